@@ -2,7 +2,7 @@ import { env } from "@services/env.service";
 import axios from "axios";
 
 export const apiClient = axios.create({
-  baseURL: env.apiUrl + "/api/",
+  baseURL: env.apiUrl + "/api/v1/",
   timeout: 10000,
   headers: {
     Accept: "application/json",
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"server/config"
+	userController "server/internal/controllers/users"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"server/internal/utils"
+	"strings"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+const (
+	MIGRATION_PATH = "cmd/migration/migrations"
+	MIGRATION_DB   = "sqlite3"
+
+	EXIT_SUCCESS = 0
+	EXIT_FAILURE = 1
+	EXIT_USAGE   = 2
+)
+
+const USAGE = `usage: adminctl <command> [flags]
+
+commands:
+  create-admin --login <login> --password <password> [--first <name>] [--last <name>]
+                 create an admin user
+  reset-password --login <login> [--password <password>]
+                 set a new password, read from stdin when --password is left out
+  promote --login <login>
+                 make an existing user an admin
+
+Run it from the server directory, after the migrations are applied. Users go
+through the same validation and hashing as the API.
+`
+
+var (
+	errHelp              = errors.New("help requested")
+	ErrPendingMigrations = errors.New("database has pending migrations, run the migration command first")
+)
+
+type options struct {
+	command   string
+	login     string
+	password  string
+	firstName string
+	lastName  string
+}
+
+// adminctl is what every command needs. The user controller is built on top
+// of db, so the rules are the ones the API applies.
+type adminctl struct {
+	db          database.DB
+	source      migrate.MigrationSource
+	invalidator *database.Invalidator
+	config      config.Config
+	stdin       io.Reader
+	stdout      io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes a single command and returns the process exit code. Results go
+// to stdout, errors to stderr; logs keep going through the logger.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	opts, err := parseArgs(args)
+	if errors.Is(err, errHelp) {
+		fmt.Fprint(stdout, USAGE)
+		return EXIT_SUCCESS
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n\n%s", err, USAGE)
+		return EXIT_USAGE
+	}
+
+	if err := execute(opts, stdin, stdout); err != nil {
+		printError(stderr, err)
+		return EXIT_FAILURE
+	}
+	return EXIT_SUCCESS
+}
+
+func parseArgs(args []string) (options, error) {
+	if len(args) == 0 {
+		return options{}, errors.New("missing command")
+	}
+	if args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		return options{}, errHelp
+	}
+
+	opts := options{command: args[0]}
+	flags := flag.NewFlagSet(opts.command, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&opts.login, "login", "", "")
+
+	switch opts.command {
+	case "create-admin":
+		flags.StringVar(&opts.password, "password", "", "")
+		flags.StringVar(&opts.firstName, "first", "", "")
+		flags.StringVar(&opts.lastName, "last", "", "")
+	case "reset-password":
+		flags.StringVar(&opts.password, "password", "", "")
+	case "promote":
+	default:
+		return opts, fmt.Errorf("unknown command %q", opts.command)
+	}
+
+	if err := flags.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return opts, errHelp
+		}
+		return opts, err
+	}
+	if flags.NArg() > 0 {
+		return opts, fmt.Errorf("%s takes no arguments, got %q", opts.command, flags.Arg(0))
+	}
+	if opts.login == "" {
+		return opts, fmt.Errorf("%s needs --login", opts.command)
+	}
+	if opts.command == "create-admin" && opts.password == "" {
+		return opts, errors.New("create-admin needs --password")
+	}
+
+	return opts, nil
+}
+
+func execute(opts options, stdin io.Reader, stdout io.Writer) error {
+	log := logger.New("adminctl").Function("execute")
+
+	cfg, err := config.InitConfig()
+	if err != nil {
+		return log.Err("failed to initialize config", err)
+	}
+
+	db, err := database.New(cfg)
+	if err != nil {
+		return log.Err("failed to create database", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Er("failed to close database", err)
+		}
+	}()
+
+	// Running servers drop their cached copy of a changed user
+	eventBus := events.New(db.Cache.Events, cfg, nil)
+	invalidator, err := database.NewInvalidator(eventBus.CacheInvalidationTopic())
+	if err != nil {
+		return log.Err("failed to create cache invalidator", err)
+	}
+
+	ctl := adminctl{
+		db:          db,
+		source:      &migrate.FileMigrationSource{Dir: MIGRATION_PATH},
+		invalidator: invalidator,
+		config:      cfg,
+		stdin:       stdin,
+		stdout:      stdout,
+	}
+	return ctl.run(context.Background(), opts)
+}
+
+func (a adminctl) run(ctx context.Context, opts options) error {
+	sqlDB, err := a.db.SQL.DB()
+	if err != nil {
+		return err
+	}
+	if err := checkMigrations(sqlDB, a.source); err != nil {
+		return err
+	}
+
+	users := userController.New(
+		nil,
+		repositories.New(a.db, a.invalidator),
+		nil,
+		nil,
+		nil,
+		nil,
+		middleware.Middleware{},
+		a.config,
+	)
+
+	switch opts.command {
+	case "create-admin":
+		user, err := users.CreateAdmin(ctx, RegisterRequest{
+			Login:     opts.login,
+			Password:  opts.password,
+			FirstName: opts.firstName,
+			LastName:  opts.lastName,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.stdout, "created admin %s (id %s)\n", user.Login, user.ID)
+
+	case "reset-password":
+		password := opts.password
+		if password == "" {
+			if password, err = readPassword(a.stdin); err != nil {
+				return err
+			}
+		}
+		user, err := users.ResetPassword(ctx, opts.login, password)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.stdout, "reset password for %s (id %s)\n", user.Login, user.ID)
+
+	case "promote":
+		user, err := users.Promote(ctx, opts.login)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.stdout, "promoted %s to admin (id %s)\n", user.Login, user.ID)
+
+	default:
+		return fmt.Errorf("unknown command %q", opts.command)
+	}
+
+	return nil
+}
+
+// checkMigrations refuses to touch a database that is behind the
+// migrations, since users would be written to a schema the code doesn't
+// expect.
+func checkMigrations(db *sql.DB, source migrate.MigrationSource) error {
+	planned, _, err := migrate.PlanMigration(db, MIGRATION_DB, source, migrate.Up, 0)
+	if err != nil {
+		return fmt.Errorf("failed to check migrations: %w", err)
+	}
+	if len(planned) > 0 {
+		ids := make([]string, len(planned))
+		for i, migration := range planned {
+			ids[i] = migration.Id
+		}
+		return fmt.Errorf("%w: %s", ErrPendingMigrations, strings.Join(ids, ", "))
+	}
+	return nil
+}
+
+// readPassword reads the new password from the first line of stdin, so it
+// stays out of the shell history.
+func readPassword(stdin io.Reader) (string, error) {
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("no password given, pass --password or write it to stdin")
+	}
+	return password, nil
+}
+
+// printError writes err, with the per-field details of a validation error.
+func printError(stderr io.Writer, err error) {
+	var validationErr *utils.ValidationError
+	if !errors.As(err, &validationErr) {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return
+	}
+
+	fmt.Fprintf(stderr, "error: %s\n", validationErr.Message)
+	for field, detail := range validationErr.Details {
+		encoded, _ := json.Marshal(detail)
+		fmt.Fprintf(stderr, "  %s: %s\n", field, encoded)
+	}
+}
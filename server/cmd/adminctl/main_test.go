@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"server/config"
+	userController "server/internal/controllers/users"
+	"server/internal/database"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"server/internal/utils"
+	"slices"
+	"strings"
+	"testing"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const strongPassword = "glacier umbrella voltage"
+
+var testConfig = config.Config{
+	Security: config.SecurityConfig{
+		Salt:             bcrypt.MinCost,
+		Pepper:           "test-pepper",
+		PepperVersion:    1,
+		MinPasswordScore: 2,
+	},
+}
+
+var testMigrations = &migrate.FileMigrationSource{Dir: "../migration/migrations"}
+
+// setupAdminctl returns a CLI over a temp sqlite database migrated the way
+// `migration up` leaves it, unless migrated is false.
+func setupAdminctl(t *testing.T, migrated bool) (adminctl, *gorm.DB, *bytes.Buffer) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "admin.db")), &gorm.Config{})
+	require.NoError(t, err)
+	if migrated {
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		// As `migration up` does, the users columns the auto-migration adds
+		// are there before the data constraints rebuild the table
+		migrations, err := testMigrations.FindMigrations()
+		require.NoError(t, err)
+		before := slices.IndexFunc(migrations, func(migration *migrate.Migration) bool {
+			return migration.Id == "0010_data_constraints.sql"
+		})
+		_, err = migrate.ExecMax(sqlDB, MIGRATION_DB, testMigrations, migrate.Up, before)
+		require.NoError(t, err)
+		require.NoError(t, db.AutoMigrate(All()...))
+		_, err = migrate.Exec(sqlDB, MIGRATION_DB, testMigrations, migrate.Up)
+		require.NoError(t, err)
+		require.NoError(t, db.AutoMigrate(All()...))
+	}
+
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+
+	var stdout bytes.Buffer
+	return adminctl{
+		db:          database.DB{SQL: db},
+		source:      testMigrations,
+		invalidator: invalidator,
+		config:      testConfig,
+		stdin:       strings.NewReader(""),
+		stdout:      &stdout,
+	}, db, &stdout
+}
+
+func findUser(t *testing.T, db *gorm.DB, login string) User {
+	t.Helper()
+
+	var user User
+	require.NoError(t, db.Where("login = ?", login).First(&user).Error)
+	return user
+}
+
+func assertPassword(t *testing.T, user User, password string) {
+	t.Helper()
+
+	previous, err := utils.VerifyPassword(password, user.Password, testConfig)
+	assert.NoError(t, err)
+	assert.False(t, previous)
+	assert.Equal(t, testConfig.PepperVersion(), user.PepperVersion)
+}
+
+func TestParseArgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		expected options
+		err      string
+	}{
+		{
+			name:     "create-admin",
+			args:     []string{"create-admin", "--login", "root", "--password", strongPassword, "--first", "Ada", "--last", "Lovelace"},
+			expected: options{command: "create-admin", login: "root", password: strongPassword, firstName: "Ada", lastName: "Lovelace"},
+		},
+		{
+			name:     "reset-password reads stdin",
+			args:     []string{"reset-password", "--login=root"},
+			expected: options{command: "reset-password", login: "root"},
+		},
+		{name: "promote", args: []string{"promote", "--login", "root"}, expected: options{command: "promote", login: "root"}},
+		{name: "no command", args: nil, err: "missing command"},
+		{name: "unknown command", args: []string{"delete", "--login", "root"}, err: `unknown command "delete"`},
+		{name: "missing login", args: []string{"promote"}, err: "promote needs --login"},
+		{name: "missing password", args: []string{"create-admin", "--login", "root"}, err: "create-admin needs --password"},
+		{name: "flag of another command", args: []string{"promote", "--login", "root", "--password", "x"}, err: "flag provided but not defined"},
+		{name: "stray argument", args: []string{"promote", "--login", "root", "extra"}, err: "takes no arguments"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := parseArgs(tc.args)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, opts)
+		})
+	}
+
+	_, err := parseArgs([]string{"--help"})
+	assert.ErrorIs(t, err, errHelp)
+}
+
+func TestCreateAdmin(t *testing.T) {
+	ctl, db, stdout := setupAdminctl(t, true)
+
+	require.NoError(t, ctl.run(context.Background(), options{
+		command:   "create-admin",
+		login:     "root",
+		password:  strongPassword,
+		firstName: "Ada",
+		lastName:  "Lovelace",
+	}))
+
+	user := findUser(t, db, "root")
+	assert.True(t, user.IsAdmin)
+	assert.Equal(t, "Ada", user.FirstName)
+	assert.Equal(t, "Lovelace", user.LastName)
+	assertPassword(t, user, strongPassword)
+	assert.Equal(t, "created admin root (id "+user.ID+")\n", stdout.String())
+
+	// Logins are unique, as they are for registrations
+	err := ctl.run(context.Background(), options{command: "create-admin", login: "root", password: strongPassword})
+	assert.ErrorIs(t, err, userController.ErrLoginTaken)
+}
+
+func TestCreateAdmin_WeakPassword(t *testing.T) {
+	ctl, db, stdout := setupAdminctl(t, true)
+
+	err := ctl.run(context.Background(), options{command: "create-admin", login: "root", password: "root1234"})
+
+	var validationErr *utils.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Contains(t, validationErr.Details, "password")
+	assert.Empty(t, stdout.String())
+
+	var count int64
+	require.NoError(t, db.Model(&User{}).Count(&count).Error)
+	assert.Zero(t, count)
+
+	var stderr bytes.Buffer
+	printError(&stderr, err)
+	assert.Contains(t, stderr.String(), "error: password is too weak\n  password: {")
+}
+
+func TestResetPassword(t *testing.T) {
+	ctl, db, stdout := setupAdminctl(t, true)
+	require.NoError(t, ctl.run(context.Background(), options{command: "create-admin", login: "root", password: strongPassword}))
+	stdout.Reset()
+
+	// The new password comes from stdin when no flag is given
+	ctl.stdin = strings.NewReader("orbit lantern meadow\n")
+	require.NoError(t, ctl.run(context.Background(), options{command: "reset-password", login: "root"}))
+
+	user := findUser(t, db, "root")
+	assertPassword(t, user, "orbit lantern meadow")
+	_, err := utils.VerifyPassword(strongPassword, user.Password, testConfig)
+	assert.Error(t, err)
+	assert.Equal(t, "reset password for root (id "+user.ID+")\n", stdout.String())
+
+	err = ctl.run(context.Background(), options{command: "reset-password", login: "root", password: "root"})
+	var validationErr *utils.ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assertPassword(t, findUser(t, db, "root"), "orbit lantern meadow")
+
+	err = ctl.run(context.Background(), options{command: "reset-password", login: "nobody", password: strongPassword})
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+
+	ctl.stdin = strings.NewReader("")
+	err = ctl.run(context.Background(), options{command: "reset-password", login: "root"})
+	assert.ErrorContains(t, err, "no password given")
+}
+
+func TestPromote(t *testing.T) {
+	ctl, db, stdout := setupAdminctl(t, true)
+	member := User{Login: "member", Password: "$2a$04$C6UzMDM.H6dfI/f/IKcEeO5w8tRGVvFL1mW3X8bHVj1PeZsl8Kz3K", PepperVersion: 1}
+	require.NoError(t, db.Create(&member).Error)
+
+	require.NoError(t, ctl.run(context.Background(), options{command: "promote", login: "member"}))
+
+	user := findUser(t, db, "member")
+	assert.True(t, user.IsAdmin)
+	assert.Equal(t, member.Password, user.Password, "promoting leaves the password alone")
+	assert.Equal(t, "promoted member to admin (id "+member.ID+")\n", stdout.String())
+
+	// Promoting an admin again is harmless
+	require.NoError(t, ctl.run(context.Background(), options{command: "promote", login: "member"}))
+	assert.True(t, findUser(t, db, "member").IsAdmin)
+
+	err := ctl.run(context.Background(), options{command: "promote", login: "nobody"})
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+}
+
+func TestRefusesPendingMigrations(t *testing.T) {
+	commands := []options{
+		{command: "create-admin", login: "root", password: strongPassword},
+		{command: "reset-password", login: "root", password: strongPassword},
+		{command: "promote", login: "root"},
+	}
+
+	for _, opts := range commands {
+		t.Run(opts.command, func(t *testing.T) {
+			ctl, db, stdout := setupAdminctl(t, false)
+
+			err := ctl.run(context.Background(), opts)
+			assert.ErrorIs(t, err, ErrPendingMigrations)
+			assert.ErrorContains(t, err, "0001_")
+			assert.Empty(t, stdout.String())
+			assert.False(t, db.Migrator().HasTable(&User{}))
+		})
+	}
+}
+
+func TestRefusesPartlyMigratedDatabase(t *testing.T) {
+	ctl, db, _ := setupAdminctl(t, true)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	_, err = migrate.ExecMax(sqlDB, MIGRATION_DB, testMigrations, migrate.Down, 1)
+	require.NoError(t, err)
+
+	migrations, err := testMigrations.FindMigrations()
+	require.NoError(t, err)
+	latest := migrations[len(migrations)-1].Id
+
+	err = ctl.run(context.Background(), options{command: "promote", login: "root"})
+	assert.ErrorIs(t, err, ErrPendingMigrations)
+	assert.ErrorContains(t, err, latest)
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"server/config"
 	"server/internal/app"
+	"server/internal/diagnostics"
 	"server/internal/logger"
 	"server/internal/server"
+	"server/internal/websockets"
 	"syscall"
 	"time"
 )
@@ -28,6 +31,15 @@ func gracefulShutdown(
 
 	log.Info("shutting down gracefully, press Ctrl+C again to force")
 
+	// Move websocket clients off gradually so they don't all reconnect to the
+	// next instance at once
+	window := app.Config.Websocket.DrainWindow
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), window+5*time.Second)
+	if err := app.Websocket.Drain(drainCtx, window, websockets.DRAIN_REASON_SHUTDOWN); err != nil {
+		log.Er("failed to drain websocket connections", err)
+	}
+	cancelDrain()
+
 	// The context is used to inform the server it has 5 seconds to finish
 	// the request it is currently handling
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -44,9 +56,55 @@ func gracefulShutdown(
 	done <- true
 }
 
+// reloadOnHangup reloads the configuration each time the process gets
+// SIGHUP. A reload that fails or is refused keeps the running config.
+func reloadOnHangup(app *app.App, log logger.Logger) {
+	log = log.Function("reloadOnHangup")
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if _, err := app.Reloader.Reload(context.Background(), "SIGHUP"); err != nil {
+			log.Warn("Config reload failed, keeping the running config", "error", err)
+		}
+	}
+}
+
+// healthCheck exits 0 when the server configured in .env answers its health
+// check, dialing the unix socket when it listens on one. It is meant for
+// container health checks.
+func healthCheck(log logger.Logger) {
+	log = log.Function("healthCheck")
+
+	cfg, err := config.InitConfig()
+	if err != nil {
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), server.HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+	if err := server.HealthCheck(ctx, server.NewListenTarget(cfg)); err != nil {
+		log.Er("Server is unhealthy", err)
+		cancel()
+		os.Exit(1)
+	}
+}
+
+// serverFailed reports a server that couldn't be set up or stopped listening
+// the way app.New reports its own failures, and exits.
+func serverFailed(cfg config.Config, err error) {
+	_ = diagnostics.WriteStartupFailure(cfg, diagnostics.NewStartupFailure(diagnostics.STAGE_SERVER, err, cfg, nil))
+	os.Exit(1)
+}
+
 func main() {
 	log := logger.New("main")
 
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		healthCheck(log)
+		return
+	}
+
 	app, err := app.New()
 	if err != nil {
 		os.Exit(1)
@@ -57,23 +115,29 @@ func main() {
 		}
 	}()
 
+	target := server.NewListenTarget(app.Config)
 	server, err := server.New(app)
 	if err != nil {
-		os.Exit(1)
+		serverFailed(app.Config, err)
 	}
 
 	// Create a done channel to signal when the shutdown is complete
 	done := make(chan bool, 1)
 
 	go func() {
-		err := server.Listen(app.Config.ServerPort)
+		err := server.Listen(target)
 		if err != nil {
-			os.Exit(1)
+			serverFailed(app.Config, err)
 		}
 	}()
 
+	// Warm the caches alongside serving rather than before, so a slow warm
+	// never holds up the first requests
+	go func() { _, _ = app.AdminController.WarmCaches(context.Background()) }()
+
 	// Run graceful shutdown in a separate goroutine
 	go gracefulShutdown(app, server, done, log)
+	go reloadOnHangup(app, log)
 
 	// Wait for the graceful shutdown to complete
 	<-done
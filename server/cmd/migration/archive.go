@@ -0,0 +1,347 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"server/internal/database"
+	"server/internal/logger"
+	"server/internal/models"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const ARCHIVE_FORMAT = "baseline-export"
+
+// SchemaMismatchError is returned when an archive was exported at a different
+// migration than the target database is on.
+type SchemaMismatchError struct {
+	Archive string
+	Current string
+}
+
+func (e *SchemaMismatchError) Error() string {
+	return fmt.Sprintf("archive schema %q does not match database schema %q", e.Archive, e.Current)
+}
+
+// archiveHeader is the first line of an archive. SchemaVersion is the last
+// migration applied to the exported database.
+type archiveHeader struct {
+	Format        string    `json:"format"`
+	SchemaVersion string    `json:"schemaVersion"`
+	ExportedAt    time.Time `json:"exportedAt"`
+	Tables        []string  `json:"tables"`
+}
+
+// archiveRecord is one row, keyed by column name.
+type archiveRecord struct {
+	Table string         `json:"table"`
+	Row   map[string]any `json:"row"`
+}
+
+// exportArchive writes every row of the model tables as gzip compressed JSON
+// lines. Rows are read raw so password hashes, soft deletes and timestamps
+// come out exactly as stored.
+func exportArchive(db *gorm.DB, schemaVersion string, out io.Writer, log logger.Logger) (int, error) {
+	log = log.Function("exportArchive")
+
+	tables, err := models.TableNames(db)
+	if err != nil {
+		return 0, err
+	}
+
+	archive := gzip.NewWriter(out)
+	encoder := json.NewEncoder(archive)
+
+	header := archiveHeader{
+		Format:        ARCHIVE_FORMAT,
+		SchemaVersion: schemaVersion,
+		ExportedAt:    time.Now().UTC(),
+		Tables:        tables,
+	}
+	if err := encoder.Encode(header); err != nil {
+		return 0, fmt.Errorf("failed to write archive header: %w", err)
+	}
+
+	total := 0
+	for _, table := range tables {
+		count, err := exportTable(db, table, encoder)
+		if err != nil {
+			return total, err
+		}
+		total += count
+		log.Info("Exported table", "table", table, "rows", count)
+	}
+
+	if err := archive.Close(); err != nil {
+		return total, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return total, nil
+}
+
+func exportTable(db *gorm.DB, table string, encoder *json.Encoder) (int, error) {
+	rows, err := db.Table(table).Order("rowid").Rows()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return count, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			if raw, ok := values[i].([]byte); ok {
+				values[i] = string(raw)
+			}
+			row[column] = values[i]
+		}
+
+		if err := encoder.Encode(archiveRecord{Table: table, Row: row}); err != nil {
+			return count, fmt.Errorf("failed to write %s row: %w", table, err)
+		}
+		count++
+	}
+
+	return count, rows.Err()
+}
+
+// importArchive loads an archive into db in one transaction. The archive has
+// to match schemaVersion. Without merge the model tables are emptied first;
+// with merge, rows replace existing ones with the same primary key.
+func importArchive(
+	db *gorm.DB,
+	schemaVersion string,
+	in io.Reader,
+	merge bool,
+	log logger.Logger,
+) (int, error) {
+	log = log.Function("importArchive")
+
+	archive, err := gzip.NewReader(in)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer archive.Close()
+
+	decoder := json.NewDecoder(archive)
+	decoder.UseNumber()
+
+	var header archiveHeader
+	if err := decoder.Decode(&header); err != nil {
+		return 0, fmt.Errorf("failed to read archive header: %w", err)
+	}
+	if header.Format != ARCHIVE_FORMAT {
+		return 0, fmt.Errorf("unknown archive format %q", header.Format)
+	}
+	if header.SchemaVersion != schemaVersion {
+		return 0, &SchemaMismatchError{Archive: header.SchemaVersion, Current: schemaVersion}
+	}
+
+	tables, err := models.TableNames(db)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if !merge {
+			// Children first, the reverse of the insert order.
+			for _, table := range slices.Backward(tables) {
+				if err := tx.Exec("DELETE FROM " + quoteIdentifier(table)).Error; err != nil {
+					return fmt.Errorf("failed to clear %s: %w", table, err)
+				}
+			}
+		}
+
+		importer := tableImporter{tx: tx, merge: merge, known: tables, log: log}
+		for {
+			var record archiveRecord
+			err := decoder.Decode(&record)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read archive record: %w", err)
+			}
+
+			if err := importer.insert(record); err != nil {
+				return err
+			}
+			total++
+		}
+		importer.finishTable()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// tableImporter inserts records table by table and logs progress whenever the
+// archive moves on to the next table.
+type tableImporter struct {
+	tx    *gorm.DB
+	merge bool
+	known []string
+	log   logger.Logger
+
+	table     string
+	rows      int
+	inserted  []string
+	datetimes map[string]bool
+	keys      []string
+}
+
+func (i *tableImporter) insert(record archiveRecord) error {
+	if record.Table != i.table {
+		if err := i.startTable(record.Table); err != nil {
+			return err
+		}
+	}
+
+	columns := make([]string, 0, len(record.Row))
+	for column := range record.Row {
+		columns = append(columns, column)
+	}
+	slices.Sort(columns)
+
+	values := make([]any, len(columns))
+	for index, column := range columns {
+		value, err := i.value(column, record.Row[column])
+		if err != nil {
+			return fmt.Errorf("invalid %s.%s: %w", i.table, column, err)
+		}
+		values[index] = value
+	}
+
+	if err := i.tx.Exec(i.insertStatement(columns), values...).Error; err != nil {
+		return fmt.Errorf("failed to insert into %s: %w", i.table, database.ExplainConstraint(i.table, i.rowKey(record), err))
+	}
+	i.rows++
+	return nil
+}
+
+// rowKey names record by its primary key, or by its place among the table's
+// records when that is missing.
+func (i *tableImporter) rowKey(record archiveRecord) string {
+	values := make([]string, 0, len(i.keys))
+	for _, key := range i.keys {
+		if value := record.Row[key]; value != nil && value != "" {
+			values = append(values, fmt.Sprint(value))
+		}
+	}
+	if len(values) < len(i.keys) || len(values) == 0 {
+		return fmt.Sprintf("#%d", i.rows+1)
+	}
+	return strings.Join(values, "/")
+}
+
+func (i *tableImporter) startTable(table string) error {
+	if !slices.Contains(i.known, table) {
+		return fmt.Errorf("archive contains unknown table %q", table)
+	}
+	if slices.Contains(i.inserted, table) {
+		return fmt.Errorf("archive rows for %q are not contiguous", table)
+	}
+	i.finishTable()
+
+	columnTypes, err := i.tx.Migrator().ColumnTypes(table)
+	if err != nil {
+		return fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+
+	i.table, i.rows = table, 0
+	i.inserted = append(i.inserted, table)
+	i.datetimes = make(map[string]bool)
+	i.keys = nil
+	for _, columnType := range columnTypes {
+		switch strings.ToLower(columnType.DatabaseTypeName()) {
+		case "datetime", "timestamp", "date":
+			i.datetimes[columnType.Name()] = true
+		}
+		if primary, ok := columnType.PrimaryKey(); ok && primary {
+			i.keys = append(i.keys, columnType.Name())
+		}
+	}
+	return nil
+}
+
+func (i *tableImporter) finishTable() {
+	if i.table != "" {
+		i.log.Info("Imported table", "table", i.table, "rows", i.rows)
+	}
+}
+
+// value turns a decoded JSON value back into what the driver originally
+// returned, so times are stored in the format GORM writes.
+func (i *tableImporter) value(column string, value any) (any, error) {
+	switch typed := value.(type) {
+	case json.Number:
+		if integer, err := typed.Int64(); err == nil {
+			return integer, nil
+		}
+		return typed.Float64()
+	case string:
+		if i.datetimes[column] {
+			return time.Parse(time.RFC3339Nano, typed)
+		}
+	}
+	return value, nil
+}
+
+func (i *tableImporter) insertStatement(columns []string) string {
+	quoted := make([]string, len(columns))
+	for index, column := range columns {
+		quoted[index] = quoteIdentifier(column)
+	}
+
+	statement := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdentifier(i.table),
+		strings.Join(quoted, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "),
+	)
+	if !i.merge || len(i.keys) == 0 {
+		return statement
+	}
+
+	keys := make([]string, len(i.keys))
+	for index, key := range i.keys {
+		keys[index] = quoteIdentifier(key)
+	}
+	updates := make([]string, 0, len(columns))
+	for _, column := range quoted {
+		updates = append(updates, column+" = excluded."+column)
+	}
+	return fmt.Sprintf(
+		"%s ON CONFLICT (%s) DO UPDATE SET %s",
+		statement,
+		strings.Join(keys, ", "),
+		strings.Join(updates, ", "),
+	)
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	. "server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// setupArchiveDB returns a database migrated with the test migrations and
+// the model tables, plus its migrator.
+func setupArchiveDB(t *testing.T) (*gorm.DB, migrator) {
+	t.Helper()
+	db, _ := setupTestDB(t)
+	m := setupTestMigrator(t, testMigrations()...)
+	require.True(t, m.upCommand(db).Success)
+	return db, m
+}
+
+func seedArchiveDB(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("peppered-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	created := time.Date(2024, 3, 1, 9, 30, 15, 123456789, time.UTC)
+	users := []User{
+		{BaseModel: BaseModel{ID: "0190a1b2-0000-7000-8000-000000000001", CreatedAt: created, UpdatedAt: created},
+			Login: "admin", FirstName: "Ada", Password: string(hash), IsAdmin: true, Version: 3},
+		{BaseModel: BaseModel{ID: "0190a1b2-0000-7000-8000-000000000002", CreatedAt: created, UpdatedAt: created},
+			Login: "gone", Password: string(hash), Version: 1},
+	}
+	require.NoError(t, db.Create(&users).Error)
+	require.NoError(t, db.Delete(&users[1]).Error)
+
+	require.NoError(t, db.Create(&[]LoginEvent{
+		{ID: "event-1", UserID: users[0].ID, CreatedAt: created.Add(time.Minute), IP: "10.0.0.1", Success: true},
+		{ID: "event-2", UserID: users[0].ID, CreatedAt: created.Add(time.Hour), IP: "10.0.0.2"},
+	}).Error)
+	require.NoError(t, db.Create(&Announcement{
+		BaseModel: BaseModel{ID: "announcement-1", CreatedAt: created, UpdatedAt: created},
+		Title:     "Maintenance",
+		Body:      "Tonight",
+		Severity:  "info",
+		ExpiresAt: created.Add(24 * time.Hour),
+		CreatedBy: users[0].ID,
+	}).Error)
+}
+
+// dumpTables reads every model table raw, in insertion order.
+func dumpTables(t *testing.T, db *gorm.DB) map[string][]map[string]any {
+	t.Helper()
+	tables, err := TableNames(db)
+	require.NoError(t, err)
+
+	dump := make(map[string][]map[string]any, len(tables))
+	for _, table := range tables {
+		var rows []map[string]any
+		require.NoError(t, db.Table(table).Order("rowid").Find(&rows).Error)
+		dump[table] = rows
+	}
+	return dump
+}
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	source, sourceMigrator := setupArchiveDB(t)
+	seedArchiveDB(t, source)
+
+	path := filepath.Join(t.TempDir(), "staging.jsonl.gz")
+	exported := exportCommand(sourceMigrator, source, path)
+	require.True(t, exported.Success, exported.Error)
+	assert.Equal(t, 5, exported.Changed)
+
+	// A fresh database gets migrated by the import itself.
+	target, _ := setupTestDB(t)
+	targetMigrator := setupTestMigrator(t, testMigrations()...)
+
+	imported := importCommand(targetMigrator, target, path, false)
+	require.True(t, imported.Success, imported.Error)
+	assert.Equal(t, 5, imported.Changed)
+
+	expected := dumpTables(t, source)
+	assert.Equal(t, expected, dumpTables(t, target))
+	require.Len(t, expected["users"], 2)
+
+	// Hashes and soft deletes survive as stored.
+	var admin User
+	require.NoError(t, target.First(&admin, "login = ?", "admin").Error)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte("peppered-password")))
+	assert.Equal(t, 3, admin.Version)
+	assert.Equal(t, time.Date(2024, 3, 1, 9, 30, 15, 123456789, time.UTC), admin.CreatedAt)
+
+	var remaining int64
+	require.NoError(t, target.Model(&User{}).Count(&remaining).Error)
+	assert.Equal(t, int64(1), remaining)
+}
+
+func TestImport_SchemaVersionMismatch(t *testing.T) {
+	source, sourceMigrator := setupArchiveDB(t)
+	seedArchiveDB(t, source)
+
+	path := filepath.Join(t.TempDir(), "staging.jsonl.gz")
+	require.True(t, exportCommand(sourceMigrator, source, path).Success)
+
+	// The target binary only knows the first two migrations.
+	target, _ := setupTestDB(t)
+	targetMigrator := setupTestMigrator(t, testMigrations()[:2]...)
+
+	result := importCommand(targetMigrator, target, path, false)
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error, `archive schema "0003_announcements"`)
+
+	var archive bytes.Buffer
+	_, err := exportArchive(source, "0003_announcements", &archive, setupTestLogger())
+	require.NoError(t, err)
+
+	_, err = importArchive(target, "0002_login_events", &archive, false, setupTestLogger())
+	var mismatch *SchemaMismatchError
+	require.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, "0003_announcements", mismatch.Archive)
+	assert.Equal(t, "0002_login_events", mismatch.Current)
+
+	var users int64
+	require.NoError(t, target.Unscoped().Model(&User{}).Count(&users).Error)
+	assert.Zero(t, users, "a rejected archive must not touch the target")
+}
+
+func TestImport_WipeOrMerge(t *testing.T) {
+	source, _ := setupArchiveDB(t)
+	seedArchiveDB(t, source)
+
+	var archive bytes.Buffer
+	_, err := exportArchive(source, "0003_announcements", &archive, setupTestLogger())
+	require.NoError(t, err)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("local-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	setupTarget := func() *gorm.DB {
+		target, _ := setupArchiveDB(t)
+		require.NoError(t, target.Create(&[]User{
+			{BaseModel: BaseModel{ID: "local-only"}, Login: "local", Password: string(hash)},
+			{BaseModel: BaseModel{ID: "0190a1b2-0000-7000-8000-000000000001"}, Login: "stale-admin", Password: string(hash)},
+		}).Error)
+		return target
+	}
+
+	wiped := setupTarget()
+	_, err = importArchive(wiped, "0003_announcements", bytes.NewReader(archive.Bytes()), false, setupTestLogger())
+	require.NoError(t, err)
+	assert.Equal(t, dumpTables(t, source), dumpTables(t, wiped))
+
+	merged := setupTarget()
+	_, err = importArchive(merged, "0003_announcements", bytes.NewReader(archive.Bytes()), true, setupTestLogger())
+	require.NoError(t, err)
+
+	var logins []string
+	require.NoError(t, merged.Unscoped().Model(&User{}).Order("login").Pluck("login", &logins).Error)
+	assert.Equal(t, []string{"admin", "gone", "local"}, logins, "merge keeps local rows and replaces matching ids")
+}
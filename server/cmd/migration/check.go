@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"gorm.io/gorm"
+)
+
+const (
+	// status --check exits with these when the database isn't up to date,
+	// so a CI gate can tell the cases apart without parsing the output
+	EXIT_PENDING           = 3
+	EXIT_DRIFT             = 4
+	EXIT_PENDING_AND_DRIFT = 5
+)
+
+// DriftReport is where the database has moved away from what this build
+// knows: migrations it recorded as applied that have no file here, and the
+// tables status reports as neither registered nor marked retired.
+type DriftReport struct {
+	Migrations []string `json:"migrations"`
+	Tables     []string `json:"tables"`
+}
+
+func (d DriftReport) count() int {
+	return len(d.Migrations) + len(d.Tables)
+}
+
+// CheckSummary is what status --check found, with the exit code it gives.
+type CheckSummary struct {
+	ExitCode     int         `json:"exitCode"`
+	PendingCount int         `json:"pendingCount"`
+	Pending      []string    `json:"pending"`
+	DriftCount   int         `json:"driftCount"`
+	Drift        DriftReport `json:"drift"`
+}
+
+func newCheckSummary(pending []string, drift DriftReport) *CheckSummary {
+	summary := &CheckSummary{
+		PendingCount: len(pending),
+		Pending:      pending,
+		DriftCount:   drift.count(),
+		Drift:        drift,
+	}
+	switch {
+	case summary.PendingCount > 0 && summary.DriftCount > 0:
+		summary.ExitCode = EXIT_PENDING_AND_DRIFT
+	case summary.PendingCount > 0:
+		summary.ExitCode = EXIT_PENDING
+	case summary.DriftCount > 0:
+		summary.ExitCode = EXIT_DRIFT
+	default:
+		summary.ExitCode = EXIT_SUCCESS
+	}
+	return summary
+}
+
+func (s CheckSummary) summary() string {
+	drift := "no drift"
+	if s.DriftCount > 0 {
+		drift = fmt.Sprintf("%d drifted (%d %s, %d %s)", s.DriftCount,
+			len(s.Drift.Migrations), plural(len(s.Drift.Migrations), "unknown migration", "unknown migrations"),
+			len(s.Drift.Tables), plural(len(s.Drift.Tables), "unknown table", "unknown tables"))
+	}
+	return fmt.Sprintf("%d pending, %s", s.PendingCount, drift)
+}
+
+// checkCommand is status for CI gates: the same report, along with the
+// pending and drifted ids and an exit code for each case. It only reads, and
+// unlike the other commands doesn't create sql-migrate's table on a
+// database nothing was applied to.
+func (m migrator) checkCommand(db *gorm.DB) CommandResult {
+	result := m.statusWithTables(db)
+	if !result.Success {
+		return result
+	}
+
+	pending := []string{}
+	for _, migration := range result.Migrations {
+		if migration.State == STATE_PENDING {
+			pending = append(pending, migration.ID)
+		}
+	}
+
+	unknown, err := m.unknownApplied()
+	if err != nil {
+		return failedResult("status", err)
+	}
+	drift := DriftReport{Migrations: unknown, Tables: []string{}}
+	for _, report := range result.Tables {
+		if report.State == TABLE_UNKNOWN {
+			drift.Tables = append(drift.Tables, report.Table)
+		}
+	}
+
+	result.Check = newCheckSummary(pending, drift)
+	return result
+}
+
+// unknownApplied lists the migrations recorded as applied that this build
+// has no file for, usually applied by a newer build or renamed since.
+func (m migrator) unknownApplied() ([]string, error) {
+	migrations, err := m.source.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+	records, err := m.appliedRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	unknown := []string{}
+	for _, record := range records {
+		if !slices.ContainsFunc(migrations, func(migration *migrate.Migration) bool { return migration.Id == record.Id }) {
+			unknown = append(unknown, record.Id)
+		}
+	}
+	slices.Sort(unknown)
+	return unknown, nil
+}
+
+// writeCheckOutput writes result as the --json document to path, for a
+// pipeline to keep as an artifact.
+func writeCheckOutput(path string, result CommandResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+
+	err = NewPrinter(file, true, false).Print(result)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"server/config"
+	"testing"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var pendingAfterSetup = []string{
+	"0007_user_foreign_keys.sql",
+	"0008_user_login_nocase.sql",
+	"0009_user_login_normalized.sql",
+	"0010_data_constraints.sql",
+	"0011_announcement_audience.sql",
+	"0012_user_terms.sql",
+	"0013_user_login_active.sql",
+	"0014_login_event_attempted_login.sql",
+}
+
+// recordUnknownMigration records id as applied, the way a newer build
+// would leave a migration this one has no file for.
+func recordUnknownMigration(t *testing.T, db *sql.DB, id string) {
+	t.Helper()
+	execAll(t, db, "INSERT INTO gorp_migrations (id, applied_at) VALUES ('"+id+"', CURRENT_TIMESTAMP)")
+}
+
+func TestCheck_ExitCodes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		upToDate bool
+		setup    func(t *testing.T, db *sql.DB)
+		expected CheckSummary
+	}{
+		{
+			name:     "up to date",
+			upToDate: true,
+			expected: CheckSummary{ExitCode: EXIT_SUCCESS, Pending: []string{}, Drift: DriftReport{Migrations: []string{}, Tables: []string{}}},
+		},
+		{
+			name: "pending",
+			expected: CheckSummary{
+				ExitCode: EXIT_PENDING, PendingCount: 8, Pending: pendingAfterSetup,
+				Drift: DriftReport{Migrations: []string{}, Tables: []string{}},
+			},
+		},
+		{
+			name:     "unknown table",
+			upToDate: true,
+			setup:    func(t *testing.T, db *sql.DB) { execAll(t, db, "CREATE TABLE mystery (id INTEGER PRIMARY KEY)") },
+			expected: CheckSummary{
+				ExitCode: EXIT_DRIFT, Pending: []string{},
+				DriftCount: 1, Drift: DriftReport{Migrations: []string{}, Tables: []string{"mystery"}},
+			},
+		},
+		{
+			name:     "unknown migration",
+			upToDate: true,
+			setup:    func(t *testing.T, db *sql.DB) { recordUnknownMigration(t, db, "0099_future.sql") },
+			expected: CheckSummary{
+				ExitCode: EXIT_DRIFT, Pending: []string{},
+				DriftCount: 1, Drift: DriftReport{Migrations: []string{"0099_future.sql"}, Tables: []string{}},
+			},
+		},
+		{
+			name: "retired tables aren't drift",
+			setup: func(t *testing.T, db *sql.DB) {
+				execAll(t, db, "CREATE TABLE zz_retired_widgets_20260301 (id INTEGER PRIMARY KEY)")
+			},
+			expected: CheckSummary{
+				ExitCode: EXIT_PENDING, PendingCount: 8, Pending: pendingAfterSetup,
+				Drift: DriftReport{Migrations: []string{}, Tables: []string{}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, db := setupForeignKeyDB(t)
+			if tc.upToDate {
+				require.True(t, m.upCommand(db).Success)
+			}
+			if tc.setup != nil {
+				tc.setup(t, m.db)
+			}
+
+			result := runCommand(options{command: "status", check: true}, m, db, config.Config{})
+
+			require.True(t, result.Success, result.Error)
+			require.NotNil(t, result.Check)
+			assert.Equal(t, tc.expected, *result.Check)
+			assert.Equal(t, tc.expected.ExitCode, result.ExitCode())
+		})
+	}
+}
+
+func TestCheck_PendingAndDriftWritesBothLists(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	execAll(t, m.db, "CREATE TABLE mystery (id INTEGER PRIMARY KEY)")
+	recordUnknownMigration(t, m.db, "0099_future.sql")
+	output := filepath.Join(t.TempDir(), "migration-check.json")
+
+	result := runCommand(options{command: "status", check: true, output: output}, m, db, config.Config{})
+	require.NoError(t, writeCheckOutput(output, result))
+
+	assert.Equal(t, EXIT_PENDING_AND_DRIFT, result.ExitCode())
+
+	content, err := os.ReadFile(output)
+	require.NoError(t, err)
+	var document CommandResult
+	require.NoError(t, json.Unmarshal(content, &document))
+	require.NotNil(t, document.Check)
+	assert.Equal(t, EXIT_PENDING_AND_DRIFT, document.Check.ExitCode)
+	assert.Equal(t, 8, document.Check.PendingCount)
+	assert.Equal(t, pendingAfterSetup, document.Check.Pending)
+	assert.Equal(t, 2, document.Check.DriftCount)
+	assert.Equal(t, []string{"0099_future.sql"}, document.Check.Drift.Migrations)
+	assert.Equal(t, []string{"mystery"}, document.Check.Drift.Tables)
+
+	human := printForTest(t, result, false, false)
+	assert.Contains(t, human, "  ✗ 0099_future.sql  applied, but there is no migration file for it\n")
+	assert.Contains(t, human, "status check failed: 8 pending, 2 drifted (1 unknown migration, 1 unknown table)\n")
+}
+
+func TestCheck_NeverWrites(t *testing.T) {
+	fileHash := func(path string) [32]byte {
+		t.Helper()
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return sha256.Sum256(content)
+	}
+
+	// A database nothing was applied to doesn't get sql-migrate's table
+	path := filepath.Join(t.TempDir(), "fresh.db")
+	fresh, err := sql.Open(MIGRATION_DB, path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = fresh.Close() })
+	execAll(t, fresh, "CREATE TABLE mystery (id INTEGER PRIMARY KEY)")
+	before := fileHash(path)
+
+	m := migrator{db: fresh, source: &migrate.FileMigrationSource{Dir: "migrations"}, log: setupTestLogger()}
+	db, _ := setupTestDB(t)
+	result := m.checkCommand(db)
+
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, EXIT_PENDING_AND_DRIFT, result.ExitCode())
+	assert.Len(t, result.Check.Pending, 14)
+	assert.Equal(t, []string{"mystery"}, result.Check.Drift.Tables)
+	assert.Equal(t, 0, countRows(t, fresh, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'gorp_migrations'"))
+	assert.Equal(t, before, fileHash(path))
+}
+
+func TestCheck_FailureExitsOne(t *testing.T) {
+	m := setupTestMigrator(t)
+	require.NoError(t, m.db.Close())
+
+	result := m.checkCommand(nil)
+
+	assert.False(t, result.Success)
+	assert.Nil(t, result.Check)
+	assert.Equal(t, EXIT_FAILURE, result.ExitCode())
+}
+
+func TestParseArgs_Check(t *testing.T) {
+	opts, err := parseArgs([]string{"status", "--check", "--output", "check.json"})
+	require.NoError(t, err)
+	assert.Equal(t, options{command: "status", steps: 1, check: true, output: "check.json"}, opts)
+
+	opts, err = parseArgs([]string{"--check", "--output=check.json", "status", "--json"})
+	require.NoError(t, err)
+	assert.Equal(t, options{command: "status", steps: 1, check: true, output: "check.json", json: true}, opts)
+
+	for _, args := range [][]string{
+		{"up", "--check"},
+		{"status", "--output", "check.json"},
+		{"status", "--check", "--output"},
+		{"status", "--check", "--output", "--json"},
+		{"status", "--check", "--output="},
+	} {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, EXIT_USAGE, run(args, &stdout, &stderr), args)
+		assert.Contains(t, stderr.String(), "usage: migration", args)
+	}
+}
+
+func TestWriteCheckOutput_Unwritable(t *testing.T) {
+	err := writeCheckOutput(filepath.Join(t.TempDir(), "missing", "check.json"), CommandResult{Command: "status"})
+	assert.ErrorContains(t, err, "failed to create")
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"server/internal/database"
+	"slices"
+	"strings"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+const (
+	// DATA_CONSTRAINTS_MIGRATION adds database.DataConstraints. Rows breaking
+	// them would fail its table rebuilds, so it doesn't run until there are
+	// none.
+	DATA_CONSTRAINTS_MIGRATION = "0010_data_constraints.sql"
+
+	// How many offending rows a violation report names
+	VIOLATION_SAMPLE = 10
+)
+
+// constrainedUserColumns are the users columns DATA_CONSTRAINTS_MIGRATION
+// copies into the rebuilt table. All but pepper_version come from the
+// auto-migration.
+var constrainedUserColumns = []string{
+	"pepper_version", "created_at", "updated_at", "first_name", "last_name",
+	"login", "password", "is_admin", "version", "deleted_at",
+}
+
+// ViolationReport counts the rows of a table that break a constraint, and
+// names the first of them by primary key.
+type ViolationReport struct {
+	Table      string   `json:"table"`
+	Constraint string   `json:"constraint"`
+	Rule       string   `json:"rule"`
+	Violations int64    `json:"violations"`
+	Rows       []string `json:"rows,omitempty"`
+}
+
+func (r ViolationReport) label() string {
+	return fmt.Sprintf("%s %s", r.Table, r.Constraint)
+}
+
+// plansDataConstraints reports whether planned applies the data constraints
+// migration.
+func plansDataConstraints(planned []*migrate.PlannedMigration) bool {
+	return slices.ContainsFunc(planned, func(migration *migrate.PlannedMigration) bool {
+		return migration.Id == DATA_CONSTRAINTS_MIGRATION
+	})
+}
+
+// tableColumns lists the columns of table, empty when it doesn't exist, and
+// its primary key in order.
+func tableColumns(db *sql.DB, table string) (columns []string, key []string, err error) {
+	rows, err := db.Query("SELECT name, pk FROM pragma_table_info(?) ORDER BY pk", table)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var pk int
+		if err := rows.Scan(&name, &pk); err != nil {
+			return nil, nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+		}
+		columns = append(columns, name)
+		if pk > 0 {
+			key = append(key, name)
+		}
+	}
+	return columns, key, rows.Err()
+}
+
+// rowKey is an expression naming a row by its primary key, or its rowid when
+// that is missing.
+func rowKey(key []string) string {
+	quoted := make([]string, len(key))
+	for index, column := range key {
+		quoted[index] = quoteIdentifier(column)
+	}
+	return fmt.Sprintf("COALESCE(%s, 'rowid ' || rowid)", strings.Join(quoted, " || '/' || "))
+}
+
+// findViolations runs every data constraint as a query. Constraints on a
+// table or column the database doesn't have yet are skipped, so it works on
+// a database at any migration.
+func findViolations(db *sql.DB) ([]ViolationReport, error) {
+	reports := make([]ViolationReport, 0, len(database.DataConstraints))
+	for _, constraint := range database.DataConstraints {
+		columns, key, err := tableColumns(db, constraint.Table)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(columns, constraint.Column) {
+			continue
+		}
+
+		report := ViolationReport{Table: constraint.Table, Constraint: constraint.Name, Rule: constraint.Rule}
+		table := quoteIdentifier(constraint.Table)
+		// Like CHECK, a condition that comes out NULL doesn't count
+		where := fmt.Sprintf("NOT (%s)", constraint.Condition)
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", table, where)).Scan(&report.Violations); err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", constraint.Name, err)
+		}
+		if report.Violations > 0 {
+			if report.Rows, err = sampleRows(db, table, rowKey(key), where); err != nil {
+				return nil, fmt.Errorf("failed to list rows breaking %s: %w", constraint.Name, err)
+			}
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func sampleRows(db *sql.DB, table string, key string, where string) ([]string, error) {
+	rows, err := db.Query(
+		fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY rowid LIMIT ?", key, table, where),
+		VIOLATION_SAMPLE,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func countViolations(reports []ViolationReport) int64 {
+	var total int64
+	for _, report := range reports {
+		total += report.Violations
+	}
+	return total
+}
+
+// verifyDataCommand reports rows breaking the data constraints, failing when
+// there are any so it can gate a deploy.
+func (m migrator) verifyDataCommand() CommandResult {
+	reports, err := findViolations(m.db)
+	if err != nil {
+		return failedResult("verify-data", err)
+	}
+
+	result := CommandResult{Command: "verify-data", Success: true, Migrations: []MigrationResult{}, Constraints: reports}
+	if violations := countViolations(reports); violations > 0 {
+		result.Success = false
+		result.Error = violationError(violations).Error()
+	}
+	return result
+}
+
+// execDataConstraints applies up to limit of planned, 0 meaning all, which
+// include the data constraints migration. The migrations before it go first,
+// so the tables it rebuilds are there for prepareDataConstraints.
+func (m migrator) execDataConstraints(planned []*migrate.PlannedMigration, limit int) (int, []ViolationReport, error) {
+	before := slices.IndexFunc(planned, func(migration *migrate.PlannedMigration) bool {
+		return migration.Id == DATA_CONSTRAINTS_MIGRATION
+	})
+
+	n := 0
+	if before > 0 {
+		applied, err := migrate.ExecMax(m.db, MIGRATION_DB, m.source, migrate.Up, before)
+		n += applied
+		if err != nil {
+			return n, nil, err
+		}
+	}
+
+	if violations, err := m.prepareDataConstraints(); err != nil {
+		return n, violations, err
+	}
+
+	if limit > 0 {
+		limit -= before
+	}
+	applied, err := migrate.ExecMax(m.db, MIGRATION_DB, m.source, migrate.Up, limit)
+	return n + applied, nil, err
+}
+
+// prepareDataConstraints runs just before the data constraints migration. It
+// refuses while rows break the constraints, returning their reports, and
+// otherwise adds the users columns the migration copies that the
+// auto-migration hasn't yet. Those are missing when the database is migrated
+// from scratch in one go, and are left for the migration to define.
+func (m migrator) prepareDataConstraints() ([]ViolationReport, error) {
+	log := m.log.Function("prepareDataConstraints")
+
+	reports, err := findViolations(m.db)
+	if err != nil {
+		return nil, err
+	}
+	if violations := countViolations(reports); violations > 0 {
+		return reports, violationError(violations)
+	}
+
+	columns, _, err := tableColumns(m.db, "users")
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range constrainedUserColumns {
+		if slices.Contains(columns, column) {
+			continue
+		}
+		if _, err := m.db.Exec("ALTER TABLE users ADD COLUMN " + quoteIdentifier(column)); err != nil {
+			return nil, log.Err("failed to add users column", err, "column", column)
+		}
+	}
+	return nil, nil
+}
+
+func violationError(violations int64) error {
+	return fmt.Errorf(
+		"%d %s the data constraints, fix or remove them and migrate again",
+		violations, plural(int(violations), "row breaks", "rows break"),
+	)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"server/internal/database"
+	"testing"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedViolations leaves a user with too short a login and a login event
+// without an id, next to rows that are fine.
+func seedViolations(t *testing.T, db *sql.DB) {
+	t.Helper()
+	insertUsers(t, db, "kept", "ab")
+	execAll(t, db,
+		"INSERT INTO user_preferences (user_id, key, value, updated_at) VALUES ('kept', 'theme', 'dark', CURRENT_TIMESTAMP)",
+		"INSERT INTO login_events (id, user_id, created_at) VALUES ('ok', 'kept', CURRENT_TIMESTAMP)",
+		"INSERT INTO login_events (id, user_id, created_at) VALUES ('', 'kept', CURRENT_TIMESTAMP)",
+	)
+}
+
+func violationsOf(reports []ViolationReport, constraint string) ViolationReport {
+	for _, report := range reports {
+		if report.Constraint == constraint {
+			return report
+		}
+	}
+	return ViolationReport{}
+}
+
+func constraintCount(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	return countRows(t, db, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = '"+table+"' AND sql LIKE '%CHECK%'")
+}
+
+func TestVerifyData_ReportsViolations(t *testing.T) {
+	m, _ := setupForeignKeyDB(t)
+	seedViolations(t, m.db)
+
+	result := m.verifyDataCommand()
+
+	assert.False(t, result.Success)
+	assert.Equal(t, EXIT_FAILURE, result.ExitCode())
+	assert.Len(t, result.Constraints, len(database.DataConstraints))
+	assert.Equal(t, ViolationReport{
+		Table: "users", Constraint: "users_login_length", Rule: "login must be at least 3 characters",
+		Violations: 1, Rows: []string{"ab"},
+	}, violationsOf(result.Constraints, "users_login_length"))
+	assert.Equal(t, []string{""}, violationsOf(result.Constraints, "login_events_id_not_empty").Rows)
+	assert.Equal(t, int64(2), countViolations(result.Constraints))
+	assert.Contains(t, result.Error, "2 rows break the data constraints")
+
+	output := printForTest(t, result, false, false)
+	assert.Contains(t, output, "✗ users users_login_length")
+	assert.Contains(t, output, "      rows: ab\n")
+	assert.Contains(t, output, "✓ users users_is_admin_boolean")
+}
+
+func TestVerifyData_Clean(t *testing.T) {
+	m, _ := setupForeignKeyDB(t)
+	insertUsers(t, m.db, "kept")
+
+	result := m.verifyDataCommand()
+
+	assert.True(t, result.Success, result.Error)
+	assert.Zero(t, countViolations(result.Constraints))
+	assert.Contains(t, printForTest(t, result, false, false), "verify-data ok: no rows break the data constraints\n")
+}
+
+func TestMigrateUp_RefusesDataViolations(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	seedViolations(t, m.db)
+
+	result := m.upCommand(db)
+
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error, "fix or remove them")
+	assert.Equal(t, int64(2), countViolations(result.Constraints))
+
+	// The migrations before it went through, it didn't
+	statuses, err := m.status()
+	require.NoError(t, err)
+	for _, status := range statuses {
+		if status.ID == DATA_CONSTRAINTS_MIGRATION {
+			assert.Equal(t, STATE_PENDING, status.State)
+		}
+	}
+	assert.Zero(t, constraintCount(t, m.db, "users"))
+
+	execAll(t, m.db,
+		"UPDATE users SET login = 'abc' WHERE id = 'ab'",
+		"DELETE FROM login_events WHERE id = ''",
+	)
+	retried := m.upCommand(db)
+	require.True(t, retried.Success, retried.Error)
+	assert.Equal(t, 1, constraintCount(t, m.db, "users"))
+}
+
+func TestDataConstraints_RefuseBadRows(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	insertUsers(t, m.db, "kept")
+	execAll(t, m.db,
+		"INSERT INTO user_preferences (user_id, key, value, updated_at) VALUES ('kept', 'theme', 'dark', CURRENT_TIMESTAMP)",
+		"INSERT INTO login_events (id, user_id, created_at) VALUES ('ok', 'kept', CURRENT_TIMESTAMP)",
+	)
+
+	require.True(t, m.upCommand(db).Success)
+
+	// The rebuild keeps the rows referencing users, and the auto-migration
+	// after it leaves the constraints alone
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM user_preferences"))
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM login_events WHERE user_id = 'kept'"))
+	for _, table := range []string{"users", "login_events", "user_preferences", "announcements", "audit_logs", "audit_archives"} {
+		assert.Equal(t, 1, constraintCount(t, m.db, table), table)
+	}
+
+	_, err := m.db.Exec("INSERT INTO users (id, login, password, created_at, updated_at) VALUES ('short', 'ab', 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)")
+	require.ErrorContains(t, err, "CHECK constraint failed: users_login_length")
+	assert.EqualError(t, database.ExplainConstraint("users", "short", err),
+		"row short violates users_login_length: login must be at least 3 characters")
+
+	_, err = m.db.Exec("INSERT INTO user_preferences (user_id, key, value, updated_at) VALUES ('kept', '', 'dark', CURRENT_TIMESTAMP)")
+	assert.ErrorContains(t, err, "CHECK constraint failed: user_preferences_key_not_empty")
+
+	_, err = m.db.Exec("INSERT INTO announcements (id, title, body, severity, expires_at) VALUES (NULL, 't', 'b', 'info', CURRENT_TIMESTAMP)")
+	assert.ErrorContains(t, err, "NOT NULL constraint failed: announcements.id")
+}
+
+func TestMigrateUp_FreshDatabaseWithConstraints(t *testing.T) {
+	sqlDB, err := sql.Open(MIGRATION_DB, database.SQLiteDSN(filepath.Join(t.TempDir(), "fresh.db")))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	m := migrator{db: sqlDB, source: &migrate.FileMigrationSource{Dir: "migrations"}, log: setupTestLogger()}
+
+	result := m.exec("up", migrate.Up, 0)
+
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, 1, constraintCount(t, m.db, "users"))
+	insertUsers(t, m.db, "kept")
+}
+
+func TestDataConstraintsMigration_Down(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	require.True(t, m.upCommand(db).Success)
+	insertUsers(t, m.db, "kept")
+	execAll(t, m.db, "INSERT INTO user_preferences (user_id, key, value, updated_at) VALUES ('kept', 'theme', 'dark', CURRENT_TIMESTAMP)")
+
+	result := m.exec("down", migrate.Down, stepsThrough(t, m, DATA_CONSTRAINTS_MIGRATION))
+
+	require.True(t, result.Success, result.Error)
+	assert.Zero(t, constraintCount(t, m.db, "users"))
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM user_preferences"))
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM pragma_foreign_key_list('user_preferences')"))
+	insertUsers(t, m.db, "ab")
+}
+
+func TestImport_ExplainsConstraintViolation(t *testing.T) {
+	source, _ := setupArchiveDB(t)
+	seedArchiveDB(t, source)
+	require.NoError(t, source.Exec("UPDATE users SET login = 'ab' WHERE login = 'gone'").Error)
+
+	var archive bytes.Buffer
+	_, err := exportArchive(source, DATA_CONSTRAINTS_MIGRATION, &archive, setupTestLogger())
+	require.NoError(t, err)
+
+	m, target := setupForeignKeyDB(t)
+	require.True(t, m.upCommand(target).Success)
+
+	_, err = importArchive(target, DATA_CONSTRAINTS_MIGRATION, &archive, false, setupTestLogger())
+	assert.EqualError(t, err, "failed to insert into users: row 0190a1b2-0000-7000-8000-000000000002 "+
+		"violates users_login_length: login must be at least 3 characters")
+
+	var violation *database.ConstraintError
+	require.True(t, errors.As(err, &violation))
+	assert.Equal(t, "users", violation.Table)
+	assert.Equal(t, "0190a1b2-0000-7000-8000-000000000002", violation.Row)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"server/config"
+	"server/internal/encryption"
+	. "server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ENCRYPT_BATCH_SIZE is how many rows encrypt-columns rewrites per
+// transaction
+const ENCRYPT_BATCH_SIZE = 500
+
+// encryptedColumn is a column of a registered model tagged
+// `gorm:"serializer:encrypted"`.
+type encryptedColumn struct {
+	table  string
+	key    string
+	column string
+}
+
+// encryptedColumns lists the encrypted columns of every registered model.
+func encryptedColumns(db *gorm.DB) ([]encryptedColumn, error) {
+	var columns []encryptedColumn
+	for _, model := range All() {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		if stmt.Schema.PrioritizedPrimaryField == nil {
+			continue
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.TagSettings["SERIALIZER"] == encryption.SERIALIZER {
+				columns = append(columns, encryptedColumn{
+					table:  stmt.Schema.Table,
+					key:    stmt.Schema.PrioritizedPrimaryField.DBName,
+					column: field.DBName,
+				})
+			}
+		}
+	}
+	return columns, nil
+}
+
+// encryptColumnsCommand encrypts the values of encrypted columns still
+// stored as plaintext, from before SECURITY_DATA_KEY was set. The server
+// reads both, so it can run while the server is up; Changed is the number
+// of values encrypted.
+func encryptColumnsCommand(db *gorm.DB, config config.Config) CommandResult {
+	key := config.DataKey()
+	if key == nil {
+		return failedResult("encrypt-columns", errors.New("SECURITY_DATA_KEY is not set"))
+	}
+
+	columns, err := encryptedColumns(db)
+	if err != nil {
+		return failedResult("encrypt-columns", err)
+	}
+
+	changed := 0
+	for _, column := range columns {
+		count, err := encryptColumn(db, key, column)
+		changed += count
+		if err != nil {
+			return failedResult("encrypt-columns", err)
+		}
+	}
+
+	return CommandResult{Command: "encrypt-columns", Success: true, Changed: changed, Migrations: []MigrationResult{}}
+}
+
+// encryptColumn rewrites a column batch by batch, until no plaintext value
+// is left. Rows are read and written through the table rather than the
+// model, so values go to the database as they are, unlike the serializer.
+func encryptColumn(db *gorm.DB, key []byte, column encryptedColumn) (int, error) {
+	changed := 0
+	for {
+		var rows []struct {
+			RowKey   string
+			RowValue string
+		}
+		err := db.Table(column.table).
+			Select(column.key+" AS row_key", column.column+" AS row_value").
+			Where(column.column+" <> '' AND "+column.column+" NOT LIKE ?", encryption.PREFIX+"%").
+			Limit(ENCRYPT_BATCH_SIZE).
+			Scan(&rows).Error
+		if err != nil || len(rows) == 0 {
+			return changed, err
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				ciphertext, err := encryption.Encrypt(key, row.RowValue)
+				if err != nil {
+					return err
+				}
+				err = tx.Table(column.table).
+					Where(column.key+" = ?", row.RowKey).
+					UpdateColumn(column.column, ciphertext).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return changed, err
+		}
+		changed += len(rows)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/base64"
+	"server/config"
+	"server/internal/database"
+	"server/internal/encryption"
+	. "server/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptColumnsCommand(t *testing.T) {
+	db, _ := setupTestDB(t)
+	require.NoError(t, autoMigrate(db, setupTestLogger()))
+	for _, login := range []string{"ada", "grace", "linus"} {
+		user := User{Login: login, Password: "$2a$04$C6UzMDM.H6dfI/f/IKcEeO5w8tRGVvFL1mW3X8bHVj1PeZsl8Kz3K"}
+		require.NoError(t, db.Create(&user).Error)
+	}
+
+	key := []byte(strings.Repeat("k", encryption.KEY_SIZE))
+	cfg := config.Config{Security: config.SecurityConfig{DataKey: base64.StdEncoding.EncodeToString(key)}}
+
+	result := encryptColumnsCommand(db, cfg)
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, 3, result.Changed)
+	assert.Equal(t, "encrypt-columns ok: 3 values encrypted\n", printForTest(t, result, false, false))
+
+	var passwords []string
+	require.NoError(t, db.Table("users").Pluck("password", &passwords).Error)
+	for _, password := range passwords {
+		assert.True(t, encryption.IsEncrypted(password))
+	}
+
+	// The server reads them back with the key
+	require.NoError(t, db.Use(database.EncryptionPlugin{Key: key}))
+	var user User
+	require.NoError(t, db.First(&user, "login = ?", "ada").Error)
+	assert.Equal(t, "$2a$04$C6UzMDM.H6dfI/f/IKcEeO5w8tRGVvFL1mW3X8bHVj1PeZsl8Kz3K", user.Password)
+
+	// Nothing left to encrypt
+	result = encryptColumnsCommand(db, cfg)
+	require.True(t, result.Success, result.Error)
+	assert.Zero(t, result.Changed)
+}
+
+func TestEncryptColumnsCommand_NoKey(t *testing.T) {
+	db, _ := setupTestDB(t)
+	require.NoError(t, autoMigrate(db, setupTestLogger()))
+
+	result := encryptColumnsCommand(db, config.Config{})
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error, "SECURITY_DATA_KEY")
+}
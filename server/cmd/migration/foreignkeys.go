@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	ON_DELETE_CASCADE  = "cascade"
+	ON_DELETE_SET_NULL = "set null"
+)
+
+// ForeignKey is a reference from a child table to its parent that the schema
+// enforces. A table that gains a reference adds it here along with its
+// migration, so verify-fk and --force-clean cover it.
+type ForeignKey struct {
+	Table        string
+	Column       string
+	Parent       string
+	ParentColumn string
+	OnDelete     string
+}
+
+var foreignKeys = []ForeignKey{
+	{Table: "user_preferences", Column: "user_id", Parent: "users", ParentColumn: "id", OnDelete: ON_DELETE_CASCADE},
+	{Table: "login_events", Column: "user_id", Parent: "users", ParentColumn: "id", OnDelete: ON_DELETE_SET_NULL},
+}
+
+// OrphanReport counts the rows of a table whose parent is gone.
+type OrphanReport struct {
+	Table      string `json:"table"`
+	Column     string `json:"column"`
+	References string `json:"references"`
+	OnDelete   string `json:"onDelete"`
+	Orphans    int64  `json:"orphans"`
+}
+
+func (r OrphanReport) label() string {
+	return fmt.Sprintf("%s.%s → %s", r.Table, r.Column, r.References)
+}
+
+// orphanCondition matches rows pointing at a parent that doesn't exist.
+// Before the constraint, rows of a set null reference without a parent were
+// stored as an empty string, which the migration turns into NULL.
+func (fk ForeignKey) orphanCondition() string {
+	column := quoteIdentifier(fk.Column)
+	condition := fmt.Sprintf(
+		"%s IS NOT NULL AND NOT EXISTS (SELECT 1 FROM %s WHERE %s.%s = %s.%s)",
+		column,
+		quoteIdentifier(fk.Parent),
+		quoteIdentifier(fk.Parent), quoteIdentifier(fk.ParentColumn),
+		quoteIdentifier(fk.Table), column,
+	)
+	if fk.OnDelete == ON_DELETE_SET_NULL {
+		condition += fmt.Sprintf(" AND %s <> ''", column)
+	}
+	return condition
+}
+
+// tablesExist reports whether both tables have been created yet.
+func (fk ForeignKey) tablesExist(db interface {
+	QueryRow(query string, args ...any) *sql.Row
+}) (bool, error) {
+	var tables int
+	if err := db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN (?, ?)",
+		fk.Table, fk.Parent,
+	).Scan(&tables); err != nil {
+		return false, fmt.Errorf("failed to look up %s: %w", fk.Table, err)
+	}
+	return tables == 2, nil
+}
+
+// findOrphans reports every foreign key whose tables exist, so it works on a
+// database at any migration.
+func findOrphans(db *sql.DB) ([]OrphanReport, error) {
+	reports := make([]OrphanReport, 0, len(foreignKeys))
+	for _, fk := range foreignKeys {
+		exists, err := fk.tablesExist(db)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+
+		report := OrphanReport{
+			Table:      fk.Table,
+			Column:     fk.Column,
+			References: fk.Parent + "." + fk.ParentColumn,
+			OnDelete:   fk.OnDelete,
+		}
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", quoteIdentifier(fk.Table), fk.orphanCondition())
+		if err := db.QueryRow(query).Scan(&report.Orphans); err != nil {
+			return nil, fmt.Errorf("failed to count orphans in %s: %w", fk.Table, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func countOrphans(reports []OrphanReport) int64 {
+	var total int64
+	for _, report := range reports {
+		total += report.Orphans
+	}
+	return total
+}
+
+// cleanOrphans does to orphaned rows what the constraint will do once their
+// parent is deleted: removes them, or clears the reference.
+func cleanOrphans(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, fk := range foreignKeys {
+		exists, err := fk.tablesExist(tx)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		statement := fmt.Sprintf("DELETE FROM %s WHERE %s", quoteIdentifier(fk.Table), fk.orphanCondition())
+		if fk.OnDelete == ON_DELETE_SET_NULL {
+			statement = fmt.Sprintf(
+				"UPDATE %s SET %s = NULL WHERE %s",
+				quoteIdentifier(fk.Table), quoteIdentifier(fk.Column), fk.orphanCondition(),
+			)
+		}
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to clean orphans in %s: %w", fk.Table, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// verifyForeignKeysCommand reports orphaned rows, failing when there are any
+// so it can gate a deploy.
+func (m migrator) verifyForeignKeysCommand() CommandResult {
+	reports, err := findOrphans(m.db)
+	if err != nil {
+		return failedResult("verify-fk", err)
+	}
+
+	result := CommandResult{Command: "verify-fk", Success: true, Migrations: []MigrationResult{}, ForeignKeys: reports}
+	if orphans := countOrphans(reports); orphans > 0 {
+		result.Success = false
+		result.Error = orphanError(orphans).Error()
+	}
+	return result
+}
+
+// checkOrphans runs ahead of migrating up. Orphans would make the migrations
+// that add constraints fail halfway through a table rebuild, so they are
+// either reported or, with --force-clean, removed first. The reports are of
+// what was removed.
+func (m migrator) checkOrphans() ([]OrphanReport, error) {
+	log := m.log.Function("checkOrphans")
+
+	reports, err := findOrphans(m.db)
+	if err != nil {
+		return nil, err
+	}
+	orphans := countOrphans(reports)
+	if orphans == 0 {
+		return nil, nil
+	}
+	if !m.forceClean {
+		return reports, orphanError(orphans)
+	}
+
+	if err := cleanOrphans(m.db); err != nil {
+		return reports, log.Err("failed to clean orphaned rows", err)
+	}
+	for _, report := range reports {
+		if report.Orphans > 0 {
+			log.Warn("Cleaned orphaned rows", "table", report.Table, "orphans", report.Orphans, "onDelete", report.OnDelete)
+		}
+	}
+	return reports, nil
+}
+
+func orphanError(orphans int64) error {
+	return fmt.Errorf(
+		"%d orphaned %s reference missing parents, clean them up or rerun with --force-clean",
+		orphans, plural(int(orphans), "row", "rows"),
+	)
+}
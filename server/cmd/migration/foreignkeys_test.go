@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"server/internal/database"
+	"testing"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const FOREIGN_KEYS_MIGRATION = "0007_user_foreign_keys.sql"
+
+// setupForeignKeyDB returns a migrator over the real migrations, applied up
+// to the one before the foreign keys and auto-migrated as a deployed
+// database would be, and a GORM handle on the same file. Both connect the
+// way the server does, with foreign keys enforced.
+func setupForeignKeyDB(t *testing.T) (migrator, *gorm.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fk.db")
+
+	sqlDB, err := sql.Open(MIGRATION_DB, database.SQLiteDSN(path))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	m := migrator{db: sqlDB, source: &migrate.FileMigrationSource{Dir: "migrations"}, log: setupTestLogger()}
+	_, err = migrate.ExecMax(sqlDB, MIGRATION_DB, m.source, migrate.Up, 6)
+	require.NoError(t, err)
+
+	db, err := gorm.Open(sqlite.Open(database.SQLiteDSN(path)), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, autoMigrate(db, m.log))
+	return m, db
+}
+
+// stepsThrough is how many migrations down takes to undo id and every
+// migration after it.
+func stepsThrough(t *testing.T, m migrator, id string) int {
+	t.Helper()
+	migrations, err := m.source.FindMigrations()
+	require.NoError(t, err)
+	for i, migration := range migrations {
+		if migration.Id == id {
+			return len(migrations) - i
+		}
+	}
+	t.Fatalf("no migration %s", id)
+	return 0
+}
+
+func execAll(t *testing.T, db *sql.DB, statements ...string) {
+	t.Helper()
+	for _, statement := range statements {
+		_, err := db.Exec(statement)
+		require.NoError(t, err, statement)
+	}
+}
+
+func insertUsers(t *testing.T, db *sql.DB, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		_, err := db.Exec(
+			"INSERT INTO users (id, login, password, created_at, updated_at) VALUES (?, ?, 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)",
+			id, id,
+		)
+		require.NoError(t, err)
+	}
+}
+
+func countRows(t *testing.T, db *sql.DB, query string) int {
+	t.Helper()
+	var count int
+	require.NoError(t, db.QueryRow(query).Scan(&count))
+	return count
+}
+
+// seedOrphans leaves one orphaned preference and one orphaned login event
+// next to rows that are fine, as deleting users before the constraint did.
+func seedOrphans(t *testing.T, db *sql.DB) {
+	t.Helper()
+	insertUsers(t, db, "kept")
+	execAll(t, db,
+		"INSERT INTO user_preferences (user_id, key, value, updated_at) VALUES ('kept', 'theme', 'dark', CURRENT_TIMESTAMP)",
+		"INSERT INTO user_preferences (user_id, key, value, updated_at) VALUES ('deleted', 'theme', 'light', CURRENT_TIMESTAMP)",
+		"INSERT INTO login_events (id, user_id, created_at) VALUES ('ok', 'kept', CURRENT_TIMESTAMP)",
+		"INSERT INTO login_events (id, user_id, created_at) VALUES ('unknown-login', '', CURRENT_TIMESTAMP)",
+		"INSERT INTO login_events (id, user_id, created_at) VALUES ('orphan', 'deleted', CURRENT_TIMESTAMP)",
+	)
+}
+
+func TestVerifyForeignKeys_ReportsOrphans(t *testing.T) {
+	m, _ := setupForeignKeyDB(t)
+	seedOrphans(t, m.db)
+
+	result := m.verifyForeignKeysCommand()
+
+	assert.False(t, result.Success)
+	assert.Equal(t, EXIT_FAILURE, result.ExitCode())
+	assert.Equal(t, []OrphanReport{
+		{Table: "user_preferences", Column: "user_id", References: "users.id", OnDelete: ON_DELETE_CASCADE, Orphans: 1},
+		{Table: "login_events", Column: "user_id", References: "users.id", OnDelete: ON_DELETE_SET_NULL, Orphans: 1},
+	}, result.ForeignKeys)
+	assert.Contains(t, result.Error, "2 orphaned rows")
+
+	assert.Equal(t, "  ✗ user_preferences.user_id → users.id  1 orphan (on delete cascade)\n"+
+		"  ✗ login_events.user_id → users.id      1 orphan (on delete set null)\n"+
+		"verify-fk failed: "+result.Error+"\n",
+		printForTest(t, result, false, false))
+}
+
+func TestVerifyForeignKeys_Clean(t *testing.T) {
+	m, _ := setupForeignKeyDB(t)
+	insertUsers(t, m.db, "kept")
+
+	result := m.verifyForeignKeysCommand()
+
+	assert.True(t, result.Success, result.Error)
+	assert.Len(t, result.ForeignKeys, 2)
+	assert.Zero(t, countOrphans(result.ForeignKeys))
+	assert.Contains(t, printForTest(t, result, false, false), "verify-fk ok: no orphaned rows\n")
+}
+
+func TestMigrateUp_RefusesOrphans(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	seedOrphans(t, m.db)
+
+	result := m.upCommand(db)
+
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error, "--force-clean")
+	assert.Equal(t, int64(2), countOrphans(result.ForeignKeys))
+
+	// Nothing was applied or removed
+	statuses, err := m.status()
+	require.NoError(t, err)
+	assert.Equal(t, STATE_PENDING, statuses[len(statuses)-1].State)
+	assert.Equal(t, 2, countRows(t, m.db, "SELECT COUNT(*) FROM user_preferences"))
+	assert.Equal(t, 3, countRows(t, m.db, "SELECT COUNT(*) FROM login_events"))
+}
+
+func TestMigrateUp_ForceClean(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	seedOrphans(t, m.db)
+	m.forceClean = true
+
+	result := m.upCommand(db)
+
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, FOREIGN_KEYS_MIGRATION, result.Migrations[0].ID)
+	assert.Equal(t, int64(2), countOrphans(result.ForeignKeys), "reports what was cleaned")
+
+	// Orphaned preferences are deleted, orphaned login events lose their user
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM user_preferences"))
+	assert.Equal(t, 3, countRows(t, m.db, "SELECT COUNT(*) FROM login_events"))
+	assert.Equal(t, 2, countRows(t, m.db, "SELECT COUNT(*) FROM login_events WHERE user_id IS NULL"))
+
+	verify := m.verifyForeignKeysCommand()
+	assert.True(t, verify.Success, verify.Error)
+}
+
+func TestForeignKeys_CascadeOnDelete(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	require.True(t, m.upCommand(db).Success)
+
+	// GORM's auto-migration runs after the SQL and must leave the
+	// constraints alone
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM pragma_foreign_key_list('user_preferences')"))
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM pragma_foreign_key_list('login_events')"))
+
+	insertUsers(t, m.db, "leaving", "staying")
+	execAll(t, m.db,
+		"INSERT INTO user_preferences (user_id, key, value, updated_at) VALUES ('leaving', 'theme', 'dark', CURRENT_TIMESTAMP)",
+		"INSERT INTO user_preferences (user_id, key, value, updated_at) VALUES ('staying', 'theme', 'dark', CURRENT_TIMESTAMP)",
+		"INSERT INTO login_events (id, user_id, created_at) VALUES ('event', 'leaving', CURRENT_TIMESTAMP)",
+	)
+
+	require.NoError(t, db.Exec("DELETE FROM users WHERE id = ?", "leaving").Error)
+
+	assert.Equal(t, 0, countRows(t, m.db, "SELECT COUNT(*) FROM user_preferences WHERE user_id = 'leaving'"))
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM user_preferences WHERE user_id = 'staying'"))
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM login_events WHERE id = 'event' AND user_id IS NULL"))
+
+	// New rows can't point at a missing user
+	_, err := m.db.Exec("INSERT INTO user_preferences (user_id, key, value, updated_at) VALUES ('leaving', 'theme', 'dark', CURRENT_TIMESTAMP)")
+	assert.ErrorContains(t, err, "FOREIGN KEY constraint failed")
+}
+
+func TestForeignKeysMigration_Down(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	require.True(t, m.upCommand(db).Success)
+	execAll(t, m.db, "INSERT INTO login_events (id, created_at) VALUES ('unknown-login', CURRENT_TIMESTAMP)")
+
+	result := m.exec("down", migrate.Down, stepsThrough(t, m, FOREIGN_KEYS_MIGRATION))
+
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, 0, countRows(t, m.db, "SELECT COUNT(*) FROM pragma_foreign_key_list('user_preferences')"))
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM login_events WHERE user_id = ''"))
+}
+
+func TestUserLoginMigration_ReplacesCaseSensitiveIndex(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	// The index as earlier versions of the User model created it
+	execAll(t, m.db,
+		"DROP INDEX idx_users_login",
+		"CREATE UNIQUE INDEX idx_users_login ON users (login)",
+	)
+	insertUsers(t, m.db, "jdoe")
+
+	require.True(t, m.upCommand(db).Success)
+
+	_, err := m.db.Exec(
+		"INSERT INTO users (id, login, password, created_at, updated_at) VALUES ('other', 'JDoe', 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)",
+	)
+	assert.ErrorContains(t, err, "UNIQUE constraint failed")
+}
+
+func TestUserLoginMigration_FreesDeletedLogins(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	insertUsers(t, m.db, "jdoe")
+
+	require.True(t, m.upCommand(db).Success)
+
+	execAll(t, m.db, "UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE login = 'jdoe'")
+	_, err := m.db.Exec(
+		"INSERT INTO users (id, login, password, created_at, updated_at) VALUES ('other', 'JDoe', 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)",
+	)
+	require.NoError(t, err)
+
+	_, err = m.db.Exec(
+		"INSERT INTO users (id, login, password, created_at, updated_at) VALUES ('third', 'jdoe', 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)",
+	)
+	assert.ErrorContains(t, err, "UNIQUE constraint failed")
+}
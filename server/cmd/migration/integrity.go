@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"server/config"
+	"server/internal/database"
+
+	"gorm.io/gorm"
+)
+
+// integrityCheckCommand runs the full check the server runs weekly, on
+// demand. Nothing is alerted; the exit code says whether it passed.
+func integrityCheckCommand(db *gorm.DB, config config.Config) CommandResult {
+	status, err := database.NewIntegrity(db, config, nil, nil).Check(context.Background(), true)
+	result := CommandResult{
+		Command:    "integrity-check",
+		Success:    err == nil,
+		Migrations: []MigrationResult{},
+		Integrity:  &status,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func integritySummary(status *database.IntegrityStatus) string {
+	summary := fmt.Sprintf("%s passed, %s with a %s WAL, %.0f%% of pages in use",
+		status.Check, byteSize(status.FileBytes), byteSize(status.WALBytes), status.PageUtilization*100)
+	if status.WALOverThreshold {
+		summary += ", WAL over the alert size"
+	}
+	return summary
+}
+
+func byteSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, suffix := float64(bytes)/unit, "KMGT"
+	index := 0
+	for value >= unit && index < len(suffix)-1 {
+		value /= unit
+		index++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, suffix[index])
+}
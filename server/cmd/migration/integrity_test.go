@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrityCheck_Passes(t *testing.T) {
+	db, dbPath := setupTestDB(t)
+	require.NoError(t, autoMigrate(db, setupTestLogger()))
+
+	result := integrityCheckCommand(db, setupTestConfig(dbPath))
+
+	require.True(t, result.Success, result.Error)
+	require.NotNil(t, result.Integrity)
+	assert.Equal(t, "integrity_check", result.Integrity.Check)
+	assert.Positive(t, result.Integrity.FileBytes)
+	assert.Regexp(t, `^integrity-check ok: integrity_check passed, \d+\.\d KiB with a 0 B WAL, \d+% of pages in use\n$`,
+		printForTest(t, result, false, false))
+}
+
+func TestParseArgs_IntegrityCheck(t *testing.T) {
+	opts, err := parseArgs([]string{"integrity-check"})
+	require.NoError(t, err)
+	assert.Equal(t, "integrity-check", opts.command)
+
+	_, err = parseArgs([]string{"integrity-check", "extra"})
+	assert.Error(t, err)
+}
+
+func TestByteSize(t *testing.T) {
+	assert.Equal(t, "512 B", byteSize(512))
+	assert.Equal(t, "1.0 KiB", byteSize(1024))
+	assert.Equal(t, "1.5 MiB", byteSize(3<<19))
+	assert.Equal(t, "2.0 GiB", byteSize(2<<30))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	. "server/internal/models"
+	"slices"
+	"strings"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// LOGIN_NORMALIZATION_MIGRATION is applied once every login is in the form
+// NormalizeLogin gives, which normalizeLogins does in Go just before it.
+const LOGIN_NORMALIZATION_MIGRATION = "0009_user_login_normalized.sql"
+
+// LoginCollision is a normalized login more than one user would end up with.
+type LoginCollision struct {
+	Login string          `json:"login"`
+	Users []CollidingUser `json:"users"`
+}
+
+// CollidingUser is one of the users behind a collision, with their login as
+// it is stored now.
+type CollidingUser struct {
+	ID    string `json:"id"`
+	Login string `json:"login"`
+}
+
+func (c LoginCollision) label() string {
+	users := make([]string, 0, len(c.Users))
+	for _, user := range c.Users {
+		users = append(users, fmt.Sprintf("%q (id %s)", user.Login, user.ID))
+	}
+	return fmt.Sprintf("%q is shared by %s", c.Login, strings.Join(users, ", "))
+}
+
+// plansLoginNormalization reports whether planned applies the login
+// normalization migration.
+func plansLoginNormalization(planned []*migrate.PlannedMigration) bool {
+	return slices.ContainsFunc(planned, func(migration *migrate.PlannedMigration) bool {
+		return migration.Id == LOGIN_NORMALIZATION_MIGRATION
+	})
+}
+
+// userLogins returns every user's stored login by id, deleted users included
+// as the unique index covers them too. It is empty on a database whose users
+// don't have logins yet.
+func userLogins(db *sql.DB) (map[string]string, error) {
+	var columns int
+	if err := db.QueryRow(
+		"SELECT COUNT(*) FROM pragma_table_info('users') WHERE name IN ('id', 'login')",
+	).Scan(&columns); err != nil {
+		return nil, fmt.Errorf("failed to look up users: %w", err)
+	}
+	if columns < 2 {
+		return map[string]string{}, nil
+	}
+
+	rows, err := db.Query("SELECT id, login FROM users")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list logins: %w", err)
+	}
+	defer rows.Close()
+
+	logins := make(map[string]string)
+	for rows.Next() {
+		var id, login string
+		if err := rows.Scan(&id, &login); err != nil {
+			return nil, fmt.Errorf("failed to read login: %w", err)
+		}
+		logins[id] = login
+	}
+	return logins, rows.Err()
+}
+
+// findLoginCollisions groups users by normalized login and returns the groups
+// with more than one user, ordered by login and then id.
+func findLoginCollisions(logins map[string]string) []LoginCollision {
+	byLogin := make(map[string][]CollidingUser)
+	for id, login := range logins {
+		normalized := NormalizeLogin(login)
+		byLogin[normalized] = append(byLogin[normalized], CollidingUser{ID: id, Login: login})
+	}
+
+	collisions := []LoginCollision{}
+	for login, users := range byLogin {
+		if len(users) < 2 {
+			continue
+		}
+		slices.SortFunc(users, func(a, b CollidingUser) int { return strings.Compare(a.ID, b.ID) })
+		collisions = append(collisions, LoginCollision{Login: login, Users: users})
+	}
+	slices.SortFunc(collisions, func(a, b LoginCollision) int { return strings.Compare(a.Login, b.Login) })
+	return collisions
+}
+
+// normalizeLogins rewrites every login into its normalized form ahead of the
+// login normalization migration. When that would give two users the same
+// login nothing is changed, and the collisions are returned for someone to
+// rename all but one user in each.
+func (m migrator) normalizeLogins() ([]LoginCollision, error) {
+	log := m.log.Function("normalizeLogins")
+
+	logins, err := userLogins(m.db)
+	if err != nil {
+		return nil, err
+	}
+	if collisions := findLoginCollisions(logins); len(collisions) > 0 {
+		return collisions, loginCollisionError(len(collisions))
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	changed := 0
+	for id, login := range logins {
+		normalized := NormalizeLogin(login)
+		if normalized == login {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE users SET login = ? WHERE id = ?", normalized, id); err != nil {
+			return nil, log.Err("failed to normalize login", err, "userID", id)
+		}
+		changed++
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, log.Err("failed to commit normalized logins", err)
+	}
+
+	if changed > 0 {
+		log.Info("Normalized logins", "users", changed)
+	}
+	return nil, nil
+}
+
+func loginCollisionError(collisions int) error {
+	return fmt.Errorf(
+		"%d %s would be shared by more than one user once normalized, rename all but one user in each and migrate again",
+		collisions, plural(collisions, "login", "logins"),
+	)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func insertLogins(t *testing.T, db *sql.DB, logins map[string]string) {
+	t.Helper()
+	for id, login := range logins {
+		_, err := db.Exec(
+			"INSERT INTO users (id, login, password, created_at, updated_at) VALUES (?, ?, 'hash', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)",
+			id, login,
+		)
+		require.NoError(t, err)
+	}
+}
+
+func storedLogin(t *testing.T, db *sql.DB, id string) string {
+	t.Helper()
+	var login string
+	require.NoError(t, db.QueryRow("SELECT login FROM users WHERE id = ?", id).Scan(&login))
+	return login
+}
+
+func TestMigrateUp_NormalizesLogins(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	insertLogins(t, m.db, map[string]string{
+		"plain":    "alice",
+		"spaced":   "  Bob ",
+		"accented": "E\u0301MILE",
+	})
+
+	result := m.upCommand(db)
+
+	require.True(t, result.Success, result.Error)
+	assert.Empty(t, result.Logins)
+	assert.Equal(t, "alice", storedLogin(t, m.db, "plain"))
+	assert.Equal(t, "bob", storedLogin(t, m.db, "spaced"))
+	assert.Equal(t, "\u00e9mile", storedLogin(t, m.db, "accented"))
+
+	statuses, err := m.status()
+	require.NoError(t, err)
+	index := slices.IndexFunc(statuses, func(status MigrationResult) bool {
+		return status.ID == LOGIN_NORMALIZATION_MIGRATION
+	})
+	require.GreaterOrEqual(t, index, 0)
+	assert.Equal(t, STATE_APPLIED, statuses[index].State)
+}
+
+func TestMigrateUp_RefusesLoginCollisions(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	insertLogins(t, m.db, map[string]string{
+		"alice-1": "alice",
+		"alice-2": "Alice ",
+		"emile-1": "\u00e9mile",
+		"emile-2": "e\u0301mile",
+		"bob":     " Bob",
+	})
+	execAll(t, m.db, "UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = 'emile-2'")
+
+	result := m.upCommand(db)
+
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error, "2 logins would be shared by more than one user")
+	assert.Equal(t, []LoginCollision{
+		{Login: "alice", Users: []CollidingUser{{ID: "alice-1", Login: "alice"}, {ID: "alice-2", Login: "Alice "}}},
+		{Login: "\u00e9mile", Users: []CollidingUser{{ID: "emile-1", Login: "\u00e9mile"}, {ID: "emile-2", Login: "e\u0301mile"}}},
+	}, result.Logins, "deleted users collide too, as the index covers them")
+
+	output := printForTest(t, result, false, false)
+	assert.Contains(t, output, `✗ "alice" is shared by "alice" (id alice-1), "Alice " (id alice-2)`)
+
+	// Nothing was applied or renamed
+	statuses, err := m.status()
+	require.NoError(t, err)
+	assert.Equal(t, STATE_PENDING, statuses[len(statuses)-1].State)
+	assert.Equal(t, " Bob", storedLogin(t, m.db, "bob"))
+
+	// Renaming all but one in each lets the migration through
+	execAll(t, m.db,
+		"UPDATE users SET login = 'alice2' WHERE id = 'alice-2'",
+		"DELETE FROM users WHERE id = 'emile-2'",
+	)
+	result = m.upCommand(db)
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, "bob", storedLogin(t, m.db, "bob"))
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
-	"path/filepath"
 	"server/cmd/migration/seed"
 	"server/config"
 	"server/internal/database"
 	"server/internal/logger"
 	. "server/internal/models"
 	"strconv"
+	"strings"
+	"time"
 
 	migrate "github.com/rubenv/sql-migrate"
 	"gorm.io/gorm"
@@ -20,55 +24,356 @@ const (
 	MIGRATION_DB   = "sqlite3"
 )
 
-var MODELS_TO_MIGRATE = []any{
-	&User{},
+const USAGE = `usage: migration [--json] [--no-color] [--merge] [--force-clean] [--purge]
+                 [--check [--output <file>]] <command>
+
+commands:
+  status         list every migration and whether it is applied, and any
+                 table that is retired or isn't registered
+  up             apply all pending migrations (default)
+  down [steps]   roll back the last steps migrations, 1 by default
+  goto <id>      migrate up or down until <id> is the last applied migration
+  seed           migrate up and seed the database
+  backup         write a verified copy of the database to DB_BACKUP_DIR
+  export <file>  write every table to a gzip JSON lines archive
+  import <file>  migrate up and load an archive, replacing all rows
+  rotate-pepper-status
+                 count users whose password isn't on the current pepper yet
+  encrypt-columns
+                 encrypt the values of encrypted columns stored before
+                 SECURITY_DATA_KEY was set
+  verify-fk      count rows whose user or other parent no longer exists
+  verify-data    list rows that break the database's CHECK constraints
+  integrity-check
+                 run sqlite's full integrity check and report the file sizes
+  drop-retired   rename retired tables to zz_retired_<table>_<date>
+  restore-retired <table>
+                 rename the latest renamed copy of a retired table back
+
+flags:
+  --json         print one JSON document instead of aligned lines
+  --no-color     disable color, also honoured through NO_COLOR
+  --merge        import keeps existing rows, replacing those with the same id
+  --force-clean  up and goto delete orphaned rows, or clear their reference,
+                 instead of refusing to migrate
+  --purge        drop-retired also drops renamed tables older than
+                 DATABASE_RETIRED_GRACE_PERIOD
+  --check        status exits 3 when migrations are pending, 4 when the
+                 database has drifted (applied migrations without a file,
+                 tables neither registered nor retired) and 5 for both,
+                 without writing to the database
+  --output <file>
+                 status --check also writes its JSON document to file
+`
+
+type options struct {
+	command string
+	target  string
+	steps   int
+	json    bool
+	noColor bool
+	merge   bool
+	// Clean up orphaned rows rather than refuse to migrate up
+	forceClean bool
+	// Drop renamed retired tables past their grace period
+	purge bool
+	// Gate on pending migrations and drift, writing the result to output
+	check  bool
+	output string
 }
 
 func main() {
-	log := logger.New("migrations")
-	log = log.Function("main")
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes a single command and returns the process exit code. Results go
+// to stdout, usage errors to stderr; logs keep going through the logger.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	opts, err := parseArgs(args)
+	if errors.Is(err, errHelp) {
+		fmt.Fprint(stdout, USAGE)
+		return EXIT_SUCCESS
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n\n%s", err, USAGE)
+		return EXIT_USAGE
+	}
+
+	printer := NewPrinter(stdout, opts.json, !opts.noColor && colorEnabled(stdout))
+	result := execute(opts)
+	if err := printer.Print(result); err != nil {
+		return EXIT_FAILURE
+	}
+	if opts.output != "" {
+		if err := writeCheckOutput(opts.output, result); err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXIT_FAILURE
+		}
+	}
+
+	return result.ExitCode()
+}
+
+var errHelp = errors.New("help requested")
+
+func parseArgs(args []string) (options, error) {
+	opts := options{command: "up", steps: 1}
+
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if output, ok := strings.CutPrefix(arg, "--output="); ok {
+			opts.output = output
+			if output == "" {
+				return opts, fmt.Errorf("--output takes a file path")
+			}
+			continue
+		}
+
+		switch arg {
+		case "--json":
+			opts.json = true
+		case "--no-color":
+			opts.noColor = true
+		case "--merge":
+			opts.merge = true
+		case "--force-clean":
+			opts.forceClean = true
+		case "--purge":
+			opts.purge = true
+		case "--check":
+			opts.check = true
+		case "--output":
+			if i+1 == len(args) || strings.HasPrefix(args[i+1], "-") {
+				return opts, fmt.Errorf("--output takes a file path")
+			}
+			i++
+			opts.output = args[i]
+		case "-h", "--help":
+			return opts, errHelp
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return opts, fmt.Errorf("unknown flag %q", arg)
+			}
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) > 0 {
+		opts.command = positional[0]
+		positional = positional[1:]
+	}
+
+	switch opts.command {
+	case "status", "up", "seed", "backup", "rotate-pepper-status", "verify-fk", "verify-data",
+		"integrity-check", "drop-retired", "encrypt-columns":
+		if len(positional) > 0 {
+			return opts, fmt.Errorf("%s takes no arguments", opts.command)
+		}
+	case "down":
+		if len(positional) > 1 {
+			return opts, fmt.Errorf("down takes at most one argument")
+		}
+		if len(positional) == 1 {
+			steps, err := strconv.Atoi(positional[0])
+			if err != nil || steps < 1 {
+				return opts, fmt.Errorf("invalid steps %q", positional[0])
+			}
+			opts.steps = steps
+		}
+	case "goto":
+		if len(positional) != 1 {
+			return opts, fmt.Errorf("goto takes exactly one migration id")
+		}
+		opts.target = positional[0]
+	case "export", "import":
+		if len(positional) != 1 {
+			return opts, fmt.Errorf("%s takes exactly one archive path", opts.command)
+		}
+		opts.target = positional[0]
+	case "restore-retired":
+		if len(positional) != 1 {
+			return opts, fmt.Errorf("restore-retired takes exactly one table name")
+		}
+		opts.target = positional[0]
+	default:
+		return opts, fmt.Errorf("unknown command %q", opts.command)
+	}
+
+	if opts.merge && opts.command != "import" {
+		return opts, fmt.Errorf("--merge only applies to import")
+	}
+	if opts.forceClean && opts.command != "up" && opts.command != "goto" {
+		return opts, fmt.Errorf("--force-clean only applies to up and goto")
+	}
+	if opts.purge && opts.command != "drop-retired" {
+		return opts, fmt.Errorf("--purge only applies to drop-retired")
+	}
+	if opts.check && opts.command != "status" {
+		return opts, fmt.Errorf("--check only applies to status")
+	}
+	if opts.output != "" && !opts.check {
+		return opts, fmt.Errorf("--output only applies to status --check")
+	}
+
+	return opts, nil
+}
+
+func execute(opts options) CommandResult {
+	log := logger.New("migrations").Function("execute")
 
 	config, err := config.InitConfig()
 	if err != nil {
-		log.Er("failed to initialize config", err)
-		os.Exit(1)
+		return failedResult(opts.command, log.Err("failed to initialize config", err))
+	}
+	// A check never writes, so the startup write probe is skipped and GORM
+	// refuses any write that would slip through
+	if opts.check {
+		config.Database.ReadOnly = true
 	}
 
 	db, err := database.New(config)
 	if err != nil {
-		log.Er("failed to create database", err)
-		os.Exit(1)
+		return failedResult(opts.command, log.Err("failed to create database", err))
 	}
 
-	// Get flags from command line
-	migrationType := "up"
-	if len(os.Args) > 1 {
-		migrationType = os.Args[1]
+	m, err := openMigrator(config, log)
+	if err != nil {
+		return failedResult(opts.command, err)
 	}
+	defer m.Close()
+
+	return runCommand(opts, m, db.SQL, config)
+}
+
+// readCommands only read the database, so they also run against a read-only
+// replica.
+var readCommands = map[string]bool{
+	"status":               true,
+	"backup":               true,
+	"export":               true,
+	"rotate-pepper-status": true,
+	"verify-fk":            true,
+	"verify-data":          true,
+	"integrity-check":      true,
+}
 
-	switch migrationType {
+func runCommand(opts options, m migrator, db *gorm.DB, config config.Config) CommandResult {
+	m.forceClean = opts.forceClean
+
+	// Migrations go through their own connection, which the server's
+	// read-only plugin doesn't cover
+	if config.Database.ReadOnly && !readCommands[opts.command] {
+		return failedResult(opts.command, fmt.Errorf(
+			"%s writes to the database, which DATABASE_READ_ONLY marks as a read-only replica", opts.command,
+		))
+	}
+
+	switch opts.command {
+	case "status":
+		if opts.check {
+			return m.checkCommand(db)
+		}
+		return m.statusWithTables(db)
 	case "up":
-		err = migrateUp(db.SQL, config, log)
+		return m.upCommand(db)
 	case "down":
-		steps := 1
-		if len(os.Args) > 2 {
-			steps, err = strconv.Atoi(os.Args[2])
-			if err != nil {
-				log.Er("failed to parse step", err)
-				os.Exit(1)
-			}
-		}
-		err = migrateDown(steps, config, log)
+		return m.exec("down", migrate.Down, opts.steps)
+	case "goto":
+		return m.gotoCommand(opts.target)
 	case "seed":
-		err = migrateSeed(db.SQL, config, log)
+		if err := migrateSeed(db, config, m.log); err != nil {
+			return failedResult("seed", err)
+		}
+		return CommandResult{Command: "seed", Success: true, Migrations: []MigrationResult{}}
+	case "backup":
+		return backupCommand(db, config)
+	case "export":
+		return exportCommand(m, db, opts.target)
+	case "import":
+		return importCommand(m, db, opts.target, opts.merge)
+	case "rotate-pepper-status":
+		return pepperStatusCommand(db, config)
+	case "encrypt-columns":
+		return encryptColumnsCommand(db, config)
+	case "verify-fk":
+		return m.verifyForeignKeysCommand()
+	case "verify-data":
+		return m.verifyDataCommand()
+	case "integrity-check":
+		return integrityCheckCommand(db, config)
+	case "drop-retired":
+		return m.dropRetired(db, Retired(), opts.purge, config.Database.RetiredGracePeriod, time.Now())
+	case "restore-retired":
+		return m.restoreRetired(db, opts.target)
+	default:
+		return failedResult(opts.command, fmt.Errorf("unknown command %q", opts.command))
+	}
+}
+
+// backupCommand takes the same backup the server schedules, on demand.
+func backupCommand(db *gorm.DB, config config.Config) CommandResult {
+	backup := database.NewBackup(db, config, nil)
+	if err := backup.Run(context.Background()); err != nil {
+		return failedResult("backup", err)
+	}
+
+	return CommandResult{
+		Command:    "backup",
+		Success:    true,
+		Migrations: []MigrationResult{},
+		File:       backup.Status().File,
+	}
+}
+
+// exportCommand writes the database to an archive stamped with its schema
+// version.
+func exportCommand(m migrator, db *gorm.DB, path string) CommandResult {
+	version, err := m.schemaVersion()
+	if err != nil {
+		return failedResult("export", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return failedResult("export", err)
 	}
 
+	rows, err := exportArchive(db, version, file, m.log)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		log.Er("failed to run migrations", err)
-		os.Exit(1)
+		return failedResult("export", err)
 	}
 
-	log.Info("Migrations complete")
+	return CommandResult{Command: "export", Success: true, Changed: rows, Migrations: []MigrationResult{}, File: path}
+}
+
+// importCommand brings the database up to date first, so an archive from the
+// current schema always lines up with the tables it is loaded into.
+func importCommand(m migrator, db *gorm.DB, path string, merge bool) CommandResult {
+	file, err := os.Open(path)
+	if err != nil {
+		return failedResult("import", err)
+	}
+	defer file.Close()
+
+	if result := m.upCommand(db); !result.Success {
+		return failedResult("import", errors.New(result.Error))
+	}
+
+	version, err := m.schemaVersion()
+	if err != nil {
+		return failedResult("import", err)
+	}
+
+	rows, err := importArchive(db, version, file, merge, m.log)
+	if err != nil {
+		return failedResult("import", err)
+	}
+
+	return CommandResult{Command: "import", Success: true, Changed: rows, Migrations: []MigrationResult{}, File: path}
 }
 
 func migrateUp(db *gorm.DB, config config.Config, log logger.Logger) error {
@@ -92,11 +397,18 @@ func migrateDown(steps int, config config.Config, log logger.Logger) error {
 	log = log.Function("migrateDown")
 	log.Info("Running migrations down")
 
-	for range steps {
-		err := runMigrations(config, log, migrate.Down)
-		if err != nil {
-			return log.Err("failed to run migrations", err)
-		}
+	if steps < 1 {
+		return nil
+	}
+
+	m, err := openMigrator(config, log)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if result := m.exec("down", migrate.Down, steps); !result.Success {
+		return log.Error("failed to run migrations", "error", result.Error)
 	}
 
 	return nil
@@ -127,7 +439,7 @@ func migrateSeed(db *gorm.DB, config config.Config, log logger.Logger) error {
 func autoMigrate(db *gorm.DB, log logger.Logger) error {
 	log = log.Function("autoMigrate")
 
-	dbTables := MODELS_TO_MIGRATE
+	dbTables := All()
 
 	log.Info("GORM auto-migrating tables", "tables", dbTables)
 	err := db.AutoMigrate(dbTables...)
@@ -145,36 +457,19 @@ func runMigrations(
 ) error {
 	log = log.Function("runMigrations")
 
-	migrations := &migrate.FileMigrationSource{
-		Dir: MIGRATION_PATH,
-	}
-
-	filename := config.DatabaseDbPath
-
-	dir := filepath.Dir(filename)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return log.Err("failed to create database directory", err)
-	}
-
-	db, err := sql.Open(MIGRATION_DB, filename)
+	m, err := openMigrator(config, log)
 	if err != nil {
-		return log.Err("failed to open database for migrations", err)
+		return err
 	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			log.Er("failed to close database", err)
-		}
-	}()
+	defer m.Close()
 
-	n, err := migrate.Exec(db, MIGRATION_DB, migrations, direction)
-	if err != nil {
-		return log.Err("failed to run migrations", err)
+	command := "up"
+	if direction == migrate.Down {
+		command = "down"
 	}
 
-	if n == 0 {
-		log.Info("No migrations to apply")
-	} else {
-		log.Info("Applied migrations", "migrationCount", n)
+	if result := m.exec(command, direction, 0); !result.Success {
+		return log.Error("failed to run migrations", "error", result.Error)
 	}
 
 	return nil
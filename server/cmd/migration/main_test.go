@@ -13,6 +13,7 @@ import (
 
 	migrate "github.com/rubenv/sql-migrate"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -34,12 +35,12 @@ func TestMigrationConstants(t *testing.T) {
 }
 
 func TestModelsToMigrate(t *testing.T) {
-	// Test MODELS_TO_MIGRATE slice
-	assert.NotNil(t, MODELS_TO_MIGRATE)
-	assert.Len(t, MODELS_TO_MIGRATE, 1) // Should have User model
+	// Test the registered models slice
+	assert.NotNil(t, All())
+	assert.Len(t, All(), 6) // Should have User, LoginEvent, Announcement, UserPreference, AuditLog and AuditArchive models
 
 	// Should contain User model
-	assert.IsType(t, &User{}, MODELS_TO_MIGRATE[0])
+	assert.IsType(t, &User{}, All()[0])
 }
 
 // Helper functions for testing
@@ -57,7 +58,7 @@ func setupTestDB(t *testing.T) (*gorm.DB, string) {
 
 func setupTestConfig(dbPath string) config.Config {
 	return config.Config{
-		DatabaseDbPath: dbPath,
+		Database: config.DatabaseConfig{Path: dbPath},
 	}
 }
 
@@ -217,7 +218,7 @@ func TestMigrateSeed_WithNilDB(t *testing.T) {
 func TestRunMigrations_DirectoryValidation(t *testing.T) {
 	// Test runMigrations with various configurations
 	cfg := config.Config{
-		DatabaseDbPath: filepath.Join(t.TempDir(), "test.db"),
+		Database: config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "test.db")},
 	}
 	log := setupTestLogger()
 
@@ -233,7 +234,7 @@ func TestRunMigrations_DirectoryValidation(t *testing.T) {
 func TestRunMigrations_EmptyDatabasePath(t *testing.T) {
 	// Test runMigrations with empty database path
 	cfg := config.Config{
-		DatabaseDbPath: "",
+		Database: config.DatabaseConfig{Path: ""},
 	}
 	log := setupTestLogger()
 
@@ -246,7 +247,7 @@ func TestRunMigrations_EmptyDatabasePath(t *testing.T) {
 func TestRunMigrations_InvalidDatabasePath(t *testing.T) {
 	// Test runMigrations with invalid database path
 	cfg := config.Config{
-		DatabaseDbPath: "/invalid/path/that/cannot/be/created/test.db",
+		Database: config.DatabaseConfig{Path: "/invalid/path/that/cannot/be/created/test.db"},
 	}
 	log := setupTestLogger()
 
@@ -329,25 +330,25 @@ func TestConstants_EdgeCases(t *testing.T) {
 }
 
 func TestModelsToMigrate_EdgeCases(t *testing.T) {
-	// Test MODELS_TO_MIGRATE edge cases
+	// Test the registered models edge cases
 
 	// Should not be empty
-	assert.NotEmpty(t, MODELS_TO_MIGRATE)
+	assert.NotEmpty(t, All())
 
 	// All elements should be pointers
-	for i, model := range MODELS_TO_MIGRATE {
+	for i, model := range All() {
 		assert.NotNil(t, model, "Model at index %d should not be nil", i)
 	}
 
 	// Should contain User model
 	foundUser := false
-	for _, model := range MODELS_TO_MIGRATE {
+	for _, model := range All() {
 		if _, ok := model.(*User); ok {
 			foundUser = true
 			break
 		}
 	}
-	assert.True(t, foundUser, "MODELS_TO_MIGRATE should contain User model")
+	assert.True(t, foundUser, "the registry should contain User model")
 }
 
 func TestDatabasePathHandling(t *testing.T) {
@@ -442,12 +443,12 @@ func TestPackageConstants_Validation(t *testing.T) {
 	// Test that package constants are properly defined
 	assert.IsType(t, "", MIGRATION_PATH)
 	assert.IsType(t, "", MIGRATION_DB)
-	assert.IsType(t, []any{}, MODELS_TO_MIGRATE)
+	assert.IsType(t, []any{}, All())
 
 	// Test constant values are reasonable
 	assert.True(t, len(MIGRATION_PATH) > 0)
 	assert.True(t, len(MIGRATION_DB) > 0)
-	assert.True(t, len(MODELS_TO_MIGRATE) > 0)
+	assert.True(t, len(All()) > 0)
 }
 
 func TestMigrationDirections(t *testing.T) {
@@ -476,8 +477,8 @@ func TestErrorHandling_PropagatesCorrectly(t *testing.T) {
 
 	// Test with various invalid configurations
 	invalidConfigs := []config.Config{
-		{DatabaseDbPath: ""},                 // Empty path
-		{DatabaseDbPath: "/invalid/path.db"}, // Invalid path
+		{Database: config.DatabaseConfig{Path: ""}},                 // Empty path
+		{Database: config.DatabaseConfig{Path: "/invalid/path.db"}}, // Invalid path
 	}
 
 	log := setupTestLogger()
@@ -522,3 +523,42 @@ func TestStringHandling_EdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestBackupCommand(t *testing.T) {
+	db, dbPath := setupTestDB(t)
+	require.NoError(t, autoMigrate(db, setupTestLogger()))
+
+	testConfig := setupTestConfig(dbPath)
+	testConfig.Database.BackupDir = filepath.Join(filepath.Dir(dbPath), "backups")
+	testConfig.Database.BackupRetention = 3
+
+	result := backupCommand(db, testConfig)
+
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, "backup", result.Command)
+	assert.FileExists(t, result.File)
+	assert.Equal(t, testConfig.Database.BackupDir, filepath.Dir(result.File))
+}
+
+func TestBackupCommand_WithoutDirectory(t *testing.T) {
+	db, dbPath := setupTestDB(t)
+
+	result := backupCommand(db, setupTestConfig(dbPath))
+
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error, "backup directory")
+}
+
+func TestRunCommand_ReadOnlyRefusesWrites(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	cfg := config.Config{Database: config.DatabaseConfig{ReadOnly: true}}
+
+	for _, command := range []string{"up", "down", "seed", "import", "drop-retired"} {
+		result := runCommand(options{command: command}, m, db, cfg)
+		assert.False(t, result.Success, command)
+		assert.Contains(t, result.Error, "read-only replica", command)
+	}
+
+	result := runCommand(options{command: "status"}, m, db, cfg)
+	assert.True(t, result.Success, result.Error)
+}
@@ -0,0 +1,15 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS login_events (
+  id TEXT PRIMARY KEY,
+  user_id TEXT,
+  created_at DATETIME NOT NULL,
+  ip TEXT,
+  user_agent TEXT,
+  client_type TEXT,
+  success BOOL NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS idx_login_events_user_created ON login_events (user_id, created_at);
+
+-- +migrate Down
+DROP INDEX IF EXISTS idx_login_events_user_created;
+DROP TABLE IF EXISTS login_events;
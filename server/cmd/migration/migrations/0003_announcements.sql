@@ -0,0 +1,16 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS announcements (
+  id TEXT PRIMARY KEY,
+  created_at DATETIME,
+  updated_at DATETIME,
+  title TEXT NOT NULL,
+  body TEXT NOT NULL,
+  severity TEXT NOT NULL,
+  expires_at DATETIME NOT NULL,
+  created_by TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_announcements_expires_at ON announcements (expires_at);
+
+-- +migrate Down
+DROP INDEX IF EXISTS idx_announcements_expires_at;
+DROP TABLE IF EXISTS announcements;
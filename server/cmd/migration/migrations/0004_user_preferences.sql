@@ -0,0 +1,11 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS user_preferences (
+  user_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS user_preferences;
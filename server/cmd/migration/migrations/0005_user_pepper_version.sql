@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE users ADD COLUMN pepper_version INTEGER NOT NULL DEFAULT 1;
+
+-- +migrate Down
+ALTER TABLE users DROP COLUMN pepper_version;
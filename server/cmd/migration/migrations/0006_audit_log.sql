@@ -0,0 +1,30 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS audit_logs (
+  id TEXT PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  action TEXT NOT NULL,
+  source TEXT NOT NULL,
+  actor_id TEXT,
+  target_id TEXT,
+  ip TEXT,
+  details TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created_at ON audit_logs (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor_id ON audit_logs (actor_id);
+
+CREATE TABLE IF NOT EXISTS audit_archives (
+  id TEXT PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  file TEXT NOT NULL UNIQUE,
+  oldest_at DATETIME NOT NULL,
+  newest_at DATETIME NOT NULL,
+  count INTEGER NOT NULL,
+  size INTEGER NOT NULL,
+  checksum TEXT NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS audit_archives;
+DROP INDEX IF EXISTS idx_audit_logs_actor_id;
+DROP INDEX IF EXISTS idx_audit_logs_created_at;
+DROP TABLE IF EXISTS audit_logs;
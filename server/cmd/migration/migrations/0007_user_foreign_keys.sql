@@ -0,0 +1,62 @@
+-- +migrate Up
+-- sqlite can't add a constraint to an existing table, so both are rebuilt.
+-- Orphaned rows make the copy fail; `migration verify-fk` lists them and
+-- `migration up --force-clean` removes them first.
+CREATE TABLE user_preferences_new (
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
+INSERT INTO user_preferences_new (user_id, key, value, updated_at)
+  SELECT user_id, key, value, updated_at FROM user_preferences;
+DROP TABLE user_preferences;
+ALTER TABLE user_preferences_new RENAME TO user_preferences;
+
+-- Login events outlive the user so login counts stay accurate; attempts for
+-- unknown logins have no user at all.
+CREATE TABLE login_events_new (
+  id TEXT PRIMARY KEY,
+  user_id TEXT REFERENCES users (id) ON DELETE SET NULL,
+  created_at DATETIME NOT NULL,
+  ip TEXT,
+  user_agent TEXT,
+  client_type TEXT,
+  success BOOL NOT NULL DEFAULT false
+);
+INSERT INTO login_events_new (id, user_id, created_at, ip, user_agent, client_type, success)
+  SELECT id, NULLIF(user_id, ''), created_at, ip, user_agent, client_type, success FROM login_events;
+DROP INDEX IF EXISTS idx_login_events_user_created;
+DROP TABLE login_events;
+ALTER TABLE login_events_new RENAME TO login_events;
+CREATE INDEX IF NOT EXISTS idx_login_events_user_created ON login_events (user_id, created_at);
+
+-- +migrate Down
+CREATE TABLE login_events_old (
+  id TEXT PRIMARY KEY,
+  user_id TEXT,
+  created_at DATETIME NOT NULL,
+  ip TEXT,
+  user_agent TEXT,
+  client_type TEXT,
+  success BOOL NOT NULL DEFAULT false
+);
+INSERT INTO login_events_old (id, user_id, created_at, ip, user_agent, client_type, success)
+  SELECT id, COALESCE(user_id, ''), created_at, ip, user_agent, client_type, success FROM login_events;
+DROP INDEX IF EXISTS idx_login_events_user_created;
+DROP TABLE login_events;
+ALTER TABLE login_events_old RENAME TO login_events;
+CREATE INDEX IF NOT EXISTS idx_login_events_user_created ON login_events (user_id, created_at);
+
+CREATE TABLE user_preferences_old (
+  user_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
+INSERT INTO user_preferences_old (user_id, key, value, updated_at)
+  SELECT user_id, key, value, updated_at FROM user_preferences;
+DROP TABLE user_preferences;
+ALTER TABLE user_preferences_old RENAME TO user_preferences;
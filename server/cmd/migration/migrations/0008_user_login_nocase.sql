@@ -0,0 +1,11 @@
+-- +migrate Up
+-- Logins are unique regardless of case. The login column and its index are
+-- created by the auto-migration that runs after these migrations, which
+-- rebuilds idx_users_login from the User model with NOCASE collation once the
+-- old case-sensitive index is gone. Logins differing only in case make that
+-- fail and have to be renamed first.
+DROP INDEX IF EXISTS idx_users_login;
+
+-- +migrate Down
+-- Nothing to undo here: the index is defined by the User model, which the
+-- auto-migration follows.
@@ -0,0 +1,9 @@
+-- +migrate Up
+-- Logins are stored trimmed, case folded and NFC normalized. SQLite can't
+-- normalize Unicode, so the migration command rewrites the users ahead of
+-- this migration (normalizeLogins), and refuses to migrate when two users
+-- would end up with the same login. Nothing is left for the SQL to do.
+
+-- +migrate Down
+-- The original spelling of each login isn't kept, so there is nothing to
+-- restore.
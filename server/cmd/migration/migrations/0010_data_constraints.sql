@@ -0,0 +1,222 @@
+-- +migrate Up
+-- CHECK constraints keep rows the application would refuse out of the
+-- database, whatever path they come in by. They are listed in
+-- database.DataConstraints, which `migration verify-data` checks ahead of
+-- this migration. sqlite can't add a constraint to an existing table, so
+-- every table is rebuilt.
+--
+-- Dropping users would delete or clear the rows referencing it, so the
+-- tables that reference it are moved aside along with it and rebuilt first,
+-- and the old copies dropped children first. Its columns are the ones the
+-- auto-migration adds, in its own words, so it doesn't rebuild the table
+-- again. A database migrated from scratch in one go doesn't have them yet,
+-- and the migration command adds them just before (prepareDataConstraints).
+ALTER TABLE login_events RENAME TO login_events_old;
+ALTER TABLE user_preferences RENAME TO user_preferences_old;
+ALTER TABLE users RENAME TO users_old;
+
+CREATE TABLE users (
+  id UUID NOT NULL PRIMARY KEY,
+  pepper_version INTEGER NOT NULL DEFAULT 1,
+  `created_at` datetime,
+  `updated_at` datetime,
+  `first_name` text,
+  `last_name` text,
+  `login` text NOT NULL,
+  `password` text NOT NULL,
+  `is_admin` numeric DEFAULT false,
+  `version` integer NOT NULL DEFAULT 1,
+  `deleted_at` datetime,
+  CONSTRAINT users_id_not_empty CHECK (id <> ''),
+  CONSTRAINT users_login_length CHECK (length(login) >= 3),
+  CONSTRAINT users_is_admin_boolean CHECK (is_admin IN (0, 1))
+);
+INSERT INTO users (id, pepper_version, created_at, updated_at, first_name, last_name, login, password, is_admin, version, deleted_at)
+  SELECT id, pepper_version, created_at, updated_at, first_name, last_name, login, password, is_admin, version, deleted_at FROM users_old;
+
+CREATE TABLE login_events (
+  id TEXT NOT NULL PRIMARY KEY,
+  user_id TEXT REFERENCES users (id) ON DELETE SET NULL,
+  created_at DATETIME NOT NULL,
+  ip TEXT,
+  user_agent TEXT,
+  client_type TEXT,
+  success BOOL NOT NULL DEFAULT false,
+  CONSTRAINT login_events_id_not_empty CHECK (id <> '')
+);
+INSERT INTO login_events (id, user_id, created_at, ip, user_agent, client_type, success)
+  SELECT id, user_id, created_at, ip, user_agent, client_type, success FROM login_events_old;
+
+CREATE TABLE user_preferences (
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (user_id, key),
+  CONSTRAINT user_preferences_user_id_not_empty CHECK (user_id <> ''),
+  CONSTRAINT user_preferences_key_not_empty CHECK (key <> '')
+);
+INSERT INTO user_preferences (user_id, key, value, updated_at)
+  SELECT user_id, key, value, updated_at FROM user_preferences_old;
+
+DROP TABLE login_events_old;
+DROP TABLE user_preferences_old;
+DROP TABLE users_old;
+CREATE INDEX `idx_users_deleted_at` ON `users`(`deleted_at`);
+CREATE UNIQUE INDEX `idx_users_login` ON `users`(`login` COLLATE NOCASE);
+CREATE INDEX idx_login_events_user_created ON login_events (user_id, created_at);
+
+CREATE TABLE announcements_new (
+  id TEXT NOT NULL PRIMARY KEY,
+  created_at DATETIME,
+  updated_at DATETIME,
+  title TEXT NOT NULL,
+  body TEXT NOT NULL,
+  severity TEXT NOT NULL,
+  expires_at DATETIME NOT NULL,
+  created_by TEXT,
+  CONSTRAINT announcements_id_not_empty CHECK (id <> '')
+);
+INSERT INTO announcements_new (id, created_at, updated_at, title, body, severity, expires_at, created_by)
+  SELECT id, created_at, updated_at, title, body, severity, expires_at, created_by FROM announcements;
+DROP INDEX IF EXISTS idx_announcements_expires_at;
+DROP TABLE announcements;
+ALTER TABLE announcements_new RENAME TO announcements;
+CREATE INDEX IF NOT EXISTS idx_announcements_expires_at ON announcements (expires_at);
+
+CREATE TABLE audit_logs_new (
+  id TEXT NOT NULL PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  action TEXT NOT NULL,
+  source TEXT NOT NULL,
+  actor_id TEXT,
+  target_id TEXT,
+  ip TEXT,
+  details TEXT,
+  CONSTRAINT audit_logs_id_not_empty CHECK (id <> '')
+);
+INSERT INTO audit_logs_new (id, created_at, action, source, actor_id, target_id, ip, details)
+  SELECT id, created_at, action, source, actor_id, target_id, ip, details FROM audit_logs;
+DROP INDEX IF EXISTS idx_audit_logs_created_at;
+DROP INDEX IF EXISTS idx_audit_logs_actor_id;
+DROP TABLE audit_logs;
+ALTER TABLE audit_logs_new RENAME TO audit_logs;
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created_at ON audit_logs (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor_id ON audit_logs (actor_id);
+
+CREATE TABLE audit_archives_new (
+  id TEXT NOT NULL PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  file TEXT NOT NULL UNIQUE,
+  oldest_at DATETIME NOT NULL,
+  newest_at DATETIME NOT NULL,
+  count INTEGER NOT NULL,
+  size INTEGER NOT NULL,
+  checksum TEXT NOT NULL,
+  CONSTRAINT audit_archives_id_not_empty CHECK (id <> '')
+);
+INSERT INTO audit_archives_new (id, created_at, file, oldest_at, newest_at, count, size, checksum)
+  SELECT id, created_at, file, oldest_at, newest_at, count, size, checksum FROM audit_archives;
+DROP TABLE audit_archives;
+ALTER TABLE audit_archives_new RENAME TO audit_archives;
+
+-- +migrate Down
+CREATE TABLE audit_archives_old (
+  id TEXT PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  file TEXT NOT NULL UNIQUE,
+  oldest_at DATETIME NOT NULL,
+  newest_at DATETIME NOT NULL,
+  count INTEGER NOT NULL,
+  size INTEGER NOT NULL,
+  checksum TEXT NOT NULL
+);
+INSERT INTO audit_archives_old (id, created_at, file, oldest_at, newest_at, count, size, checksum)
+  SELECT id, created_at, file, oldest_at, newest_at, count, size, checksum FROM audit_archives;
+DROP TABLE audit_archives;
+ALTER TABLE audit_archives_old RENAME TO audit_archives;
+
+CREATE TABLE audit_logs_old (
+  id TEXT PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  action TEXT NOT NULL,
+  source TEXT NOT NULL,
+  actor_id TEXT,
+  target_id TEXT,
+  ip TEXT,
+  details TEXT
+);
+INSERT INTO audit_logs_old (id, created_at, action, source, actor_id, target_id, ip, details)
+  SELECT id, created_at, action, source, actor_id, target_id, ip, details FROM audit_logs;
+DROP INDEX IF EXISTS idx_audit_logs_created_at;
+DROP INDEX IF EXISTS idx_audit_logs_actor_id;
+DROP TABLE audit_logs;
+ALTER TABLE audit_logs_old RENAME TO audit_logs;
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created_at ON audit_logs (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor_id ON audit_logs (actor_id);
+
+CREATE TABLE announcements_old (
+  id TEXT PRIMARY KEY,
+  created_at DATETIME,
+  updated_at DATETIME,
+  title TEXT NOT NULL,
+  body TEXT NOT NULL,
+  severity TEXT NOT NULL,
+  expires_at DATETIME NOT NULL,
+  created_by TEXT
+);
+INSERT INTO announcements_old (id, created_at, updated_at, title, body, severity, expires_at, created_by)
+  SELECT id, created_at, updated_at, title, body, severity, expires_at, created_by FROM announcements;
+DROP INDEX IF EXISTS idx_announcements_expires_at;
+DROP TABLE announcements;
+ALTER TABLE announcements_old RENAME TO announcements;
+CREATE INDEX IF NOT EXISTS idx_announcements_expires_at ON announcements (expires_at);
+
+ALTER TABLE login_events RENAME TO login_events_constrained;
+ALTER TABLE user_preferences RENAME TO user_preferences_constrained;
+ALTER TABLE users RENAME TO users_constrained;
+
+CREATE TABLE users (
+  id UUID PRIMARY KEY,
+  pepper_version INTEGER NOT NULL DEFAULT 1,
+  `created_at` datetime,
+  `updated_at` datetime,
+  `first_name` text,
+  `last_name` text,
+  `login` text NOT NULL,
+  `password` text NOT NULL,
+  `is_admin` numeric DEFAULT false,
+  `version` integer NOT NULL DEFAULT 1,
+  `deleted_at` datetime
+);
+INSERT INTO users (id, pepper_version, created_at, updated_at, first_name, last_name, login, password, is_admin, version, deleted_at)
+  SELECT id, pepper_version, created_at, updated_at, first_name, last_name, login, password, is_admin, version, deleted_at FROM users_constrained;
+
+CREATE TABLE login_events (
+  id TEXT PRIMARY KEY,
+  user_id TEXT REFERENCES users (id) ON DELETE SET NULL,
+  created_at DATETIME NOT NULL,
+  ip TEXT,
+  user_agent TEXT,
+  client_type TEXT,
+  success BOOL NOT NULL DEFAULT false
+);
+INSERT INTO login_events (id, user_id, created_at, ip, user_agent, client_type, success)
+  SELECT id, user_id, created_at, ip, user_agent, client_type, success FROM login_events_constrained;
+
+CREATE TABLE user_preferences (
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  updated_at DATETIME NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
+INSERT INTO user_preferences (user_id, key, value, updated_at)
+  SELECT user_id, key, value, updated_at FROM user_preferences_constrained;
+
+DROP TABLE login_events_constrained;
+DROP TABLE user_preferences_constrained;
+DROP TABLE users_constrained;
+CREATE INDEX `idx_users_deleted_at` ON `users`(`deleted_at`);
+CREATE UNIQUE INDEX `idx_users_login` ON `users`(`login` COLLATE NOCASE);
+CREATE INDEX idx_login_events_user_created ON login_events (user_id, created_at);
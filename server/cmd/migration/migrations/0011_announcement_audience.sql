@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE announcements ADD COLUMN audience TEXT NOT NULL DEFAULT 'all';
+ALTER TABLE announcements ADD COLUMN user_ids TEXT;
+
+-- +migrate Down
+ALTER TABLE announcements DROP COLUMN user_ids;
+ALTER TABLE announcements DROP COLUMN audience;
@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE users ADD COLUMN tos_version TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN tos_accepted_at DATETIME;
+
+-- +migrate Down
+ALTER TABLE users DROP COLUMN tos_accepted_at;
+ALTER TABLE users DROP COLUMN tos_version;
@@ -0,0 +1,11 @@
+-- +migrate Up
+-- Deleted users are only soft deleted, so their row keeps its login. The
+-- unique index covers live users alone, leaving a deleted user's login free
+-- to register again.
+DROP INDEX IF EXISTS idx_users_login;
+CREATE UNIQUE INDEX `idx_users_login` ON `users`(`login` COLLATE NOCASE) WHERE deleted_at IS NULL;
+
+-- +migrate Down
+-- Fails while a deleted user and a live one share a login.
+DROP INDEX IF EXISTS idx_users_login;
+CREATE UNIQUE INDEX `idx_users_login` ON `users`(`login` COLLATE NOCASE);
@@ -0,0 +1,8 @@
+-- +migrate Up
+-- A failed login for a login no user has keeps the login that was tried, and
+-- no user. Rows written before this stored an empty user_id.
+ALTER TABLE login_events ADD COLUMN attempted_login TEXT;
+UPDATE login_events SET user_id = NULL WHERE user_id = '';
+
+-- +migrate Down
+ALTER TABLE login_events DROP COLUMN attempted_login;
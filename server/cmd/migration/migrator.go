@@ -0,0 +1,271 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"server/config"
+	"server/internal/database"
+	"server/internal/logger"
+	"strconv"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"gorm.io/gorm"
+)
+
+// migrator runs the SQL migrations and reports the outcome per migration.
+type migrator struct {
+	db     *sql.DB
+	source migrate.MigrationSource
+	log    logger.Logger
+	// Remove orphaned rows instead of refusing to migrate up
+	forceClean bool
+}
+
+func openMigrator(config config.Config, log logger.Logger) (migrator, error) {
+	log = log.Function("openMigrator")
+
+	filename := config.Database.Path
+
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return migrator{}, log.Err("failed to create database directory", err)
+	}
+
+	db, err := sql.Open(MIGRATION_DB, database.SQLiteDSN(filename))
+	if err != nil {
+		return migrator{}, log.Err("failed to open database for migrations", err)
+	}
+
+	return migrator{
+		db:     db,
+		source: &migrate.FileMigrationSource{Dir: MIGRATION_PATH},
+		log:    log,
+	}, nil
+}
+
+func (m migrator) Close() {
+	if err := m.db.Close(); err != nil {
+		m.log.Er("failed to close database", err)
+	}
+}
+
+// status lists every known migration in order with its applied time.
+func (m migrator) status() ([]MigrationResult, error) {
+	migrations, err := m.source.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := m.appliedRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	appliedAt := make(map[string]time.Time, len(records))
+	for _, record := range records {
+		appliedAt[record.Id] = record.AppliedAt
+	}
+
+	results := make([]MigrationResult, 0, len(migrations))
+	for _, migration := range migrations {
+		result := MigrationResult{ID: migration.Id, State: STATE_PENDING}
+		if at, ok := appliedAt[migration.Id]; ok {
+			at = at.UTC()
+			result.State = STATE_APPLIED
+			result.AppliedAt = &at
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// appliedRecords reads sql-migrate's records of the applied migrations. A
+// database nothing was applied to has no records table yet, and reading it
+// leaves it that way.
+func (m migrator) appliedRecords() ([]*migrate.MigrationRecord, error) {
+	var found int
+	err := m.db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", MIGRATION_RECORDS_TABLE,
+	).Scan(&found)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the migration records: %w", err)
+	}
+	if found == 0 {
+		return nil, nil
+	}
+
+	set := migrate.MigrationSet{TableName: MIGRATION_RECORDS_TABLE, DisableCreateTable: true}
+	return set.GetMigrationRecords(m.db, MIGRATION_DB)
+}
+
+// schemaVersion is the id of the last applied migration, empty when none
+// has been applied.
+func (m migrator) schemaVersion() (string, error) {
+	statuses, err := m.status()
+	if err != nil {
+		return "", err
+	}
+
+	version := ""
+	for _, status := range statuses {
+		if status.State == STATE_APPLIED {
+			version = status.ID
+		}
+	}
+	return version, nil
+}
+
+func (m migrator) statusCommand() CommandResult {
+	migrations, err := m.status()
+	if err != nil {
+		return failedResult("status", err)
+	}
+
+	return CommandResult{Command: "status", Success: true, Migrations: migrations}
+}
+
+// exec applies at most limit migrations in direction, 0 meaning all of them,
+// and reports the state of each planned migration afterwards.
+func (m migrator) exec(command string, direction migrate.MigrationDirection, limit int) CommandResult {
+	log := m.log.Function("exec")
+
+	planned, _, err := migrate.PlanMigration(m.db, MIGRATION_DB, m.source, direction, limit)
+	if err != nil {
+		return failedResult(command, err)
+	}
+
+	var cleaned []OrphanReport
+	if direction == migrate.Up && len(planned) > 0 {
+		if cleaned, err = m.checkOrphans(); err != nil {
+			result := failedResult(command, err)
+			result.ForeignKeys = cleaned
+			return result
+		}
+	}
+	if direction == migrate.Up && plansLoginNormalization(planned) {
+		if collisions, err := m.normalizeLogins(); err != nil {
+			result := failedResult(command, err)
+			result.Logins = collisions
+			return result
+		}
+	}
+
+	var n int
+	var execErr error
+	var violations []ViolationReport
+	if direction == migrate.Up && plansDataConstraints(planned) {
+		n, violations, execErr = m.execDataConstraints(planned, limit)
+	} else {
+		n, execErr = migrate.ExecMax(m.db, MIGRATION_DB, m.source, direction, limit)
+	}
+
+	statuses, err := m.status()
+	if err != nil {
+		return failedResult(command, err)
+	}
+	byID := make(map[string]MigrationResult, len(statuses))
+	for _, status := range statuses {
+		byID[status.ID] = status
+	}
+
+	var txErr *migrate.TxError
+	errors.As(execErr, &txErr)
+
+	result := CommandResult{
+		Command:     command,
+		Success:     execErr == nil,
+		Changed:     n,
+		Migrations:  make([]MigrationResult, 0, len(planned)),
+		ForeignKeys: cleaned,
+		Constraints: violations,
+	}
+	for _, migration := range planned {
+		status := byID[migration.Id]
+		if txErr != nil && txErr.Migration.Id == migration.Id {
+			status.State = STATE_FAILED
+			status.Error = txErr.Err.Error()
+		}
+		result.Migrations = append(result.Migrations, status)
+	}
+
+	if execErr != nil {
+		result.Error = log.Err("failed to run migrations", execErr).Error()
+		return result
+	}
+
+	if n == 0 {
+		log.Info("No migrations to apply")
+	} else {
+		log.Info("Applied migrations", "migrationCount", n)
+	}
+
+	return result
+}
+
+// upCommand applies the SQL migrations and then lets GORM catch up the model
+// tables.
+func (m migrator) upCommand(db *gorm.DB) CommandResult {
+	result := m.exec("up", migrate.Up, 0)
+	if !result.Success {
+		return result
+	}
+
+	if err := autoMigrate(db, m.log); err != nil {
+		result.Success = false
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// gotoCommand moves the database up or down until target is the last applied
+// migration. The target is a migration id or its numeric prefix.
+func (m migrator) gotoCommand(target string) CommandResult {
+	statuses, err := m.status()
+	if err != nil {
+		return failedResult("goto", err)
+	}
+
+	targetIndex, applied := -1, 0
+	for i, status := range statuses {
+		if matchesMigration(status.ID, target) {
+			targetIndex = i
+		}
+		if status.State == STATE_APPLIED {
+			applied++
+		}
+	}
+	if targetIndex < 0 {
+		return failedResult("goto", fmt.Errorf("unknown migration %q", target))
+	}
+
+	switch wanted := targetIndex + 1; {
+	case wanted > applied:
+		return m.exec("goto", migrate.Up, wanted-applied)
+	case wanted < applied:
+		return m.exec("goto", migrate.Down, applied-wanted)
+	default:
+		return CommandResult{Command: "goto", Success: true, Migrations: []MigrationResult{}}
+	}
+}
+
+func matchesMigration(id string, target string) bool {
+	if id == target {
+		return true
+	}
+
+	version, err := strconv.ParseInt(target, 10, 64)
+	if err != nil {
+		return false
+	}
+	migration := migrate.Migration{Id: id}
+	if len(migration.NumberPrefixMatches()) == 0 {
+		return false
+	}
+	return migration.VersionInt() == version
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"server/internal/database"
+	"strings"
+	"time"
+)
+
+const (
+	EXIT_SUCCESS = 0
+	EXIT_FAILURE = 1
+	EXIT_USAGE   = 2
+)
+
+const (
+	STATE_APPLIED = "applied"
+	STATE_PENDING = "pending"
+	STATE_FAILED  = "failed"
+)
+
+const (
+	colorReset = "\033[0m"
+	colorGreen = "\033[32m"
+	colorRed   = "\033[31m"
+	colorGray  = "\033[90m"
+)
+
+// MigrationResult is the state of a single migration after a command ran.
+type MigrationResult struct {
+	ID        string     `json:"id"`
+	State     string     `json:"state"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// CommandResult is the single document a command produces. Migrations holds
+// every known migration for status and only the ones a command touched for
+// up, down and goto. File is the backup or archive a command wrote or read.
+// Pepper is only set by rotate-pepper-status. ForeignKeys holds the orphan
+// counts verify-fk found, or those an up command refused over or cleaned.
+// Logins holds the collisions an up command refused to normalize logins over.
+// Constraints holds the violations verify-data found, or those an up command
+// refused over. Integrity is only set by integrity-check. Tables holds the
+// tables status found retired or unregistered, or those drop-retired and
+// restore-retired changed. Check is only set by status --check.
+type CommandResult struct {
+	Command     string                    `json:"command"`
+	Success     bool                      `json:"success"`
+	Changed     int                       `json:"changed"`
+	Migrations  []MigrationResult         `json:"migrations"`
+	File        string                    `json:"file,omitempty"`
+	Pepper      *PepperStatus             `json:"pepper,omitempty"`
+	ForeignKeys []OrphanReport            `json:"foreignKeys,omitempty"`
+	Logins      []LoginCollision          `json:"logins,omitempty"`
+	Constraints []ViolationReport         `json:"constraints,omitempty"`
+	Integrity   *database.IntegrityStatus `json:"integrity,omitempty"`
+	Tables      []TableReport             `json:"tables,omitempty"`
+	Check       *CheckSummary             `json:"check,omitempty"`
+	Error       string                    `json:"error,omitempty"`
+}
+
+func (r CommandResult) ExitCode() int {
+	if !r.Success {
+		return EXIT_FAILURE
+	}
+	if r.Check != nil {
+		return r.Check.ExitCode
+	}
+	return EXIT_SUCCESS
+}
+
+func (r CommandResult) count(state string) int {
+	count := 0
+	for _, migration := range r.Migrations {
+		if migration.State == state {
+			count++
+		}
+	}
+	return count
+}
+
+func failedResult(command string, err error) CommandResult {
+	return CommandResult{
+		Command:    command,
+		Migrations: []MigrationResult{},
+		Error:      err.Error(),
+	}
+}
+
+type Printer struct {
+	out   io.Writer
+	json  bool
+	color bool
+}
+
+func NewPrinter(out io.Writer, json bool, color bool) Printer {
+	return Printer{out: out, json: json, color: color}
+}
+
+func (p Printer) Print(result CommandResult) error {
+	if p.json {
+		encoder := json.NewEncoder(p.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	width := 0
+	for _, migration := range result.Migrations {
+		width = max(width, len(migration.ID))
+	}
+
+	var output strings.Builder
+	for _, migration := range result.Migrations {
+		output.WriteString(p.line(migration, width))
+		output.WriteString("\n")
+	}
+	p.writeOrphans(&output, result.ForeignKeys)
+	for _, collision := range result.Logins {
+		fmt.Fprintf(&output, "  %s %s\n", p.paint("✗", colorRed), collision.label())
+	}
+	p.writeViolations(&output, result.Constraints)
+	if result.Integrity != nil {
+		for _, problem := range result.Integrity.Problems {
+			fmt.Fprintf(&output, "  %s %s\n", p.paint("✗", colorRed), problem)
+		}
+	}
+	p.writeTables(&output, result.Tables)
+	if result.Check != nil {
+		for _, id := range result.Check.Drift.Migrations {
+			fmt.Fprintf(&output, "  %s %s  applied, but there is no migration file for it\n", p.paint("✗", colorRed), id)
+		}
+	}
+	output.WriteString(p.summary(result))
+	output.WriteString("\n")
+
+	_, err := io.WriteString(p.out, output.String())
+	return err
+}
+
+func (p Printer) line(migration MigrationResult, width int) string {
+	symbol, color := "•", colorGray
+	switch migration.State {
+	case STATE_APPLIED:
+		symbol, color = "✓", colorGreen
+	case STATE_FAILED:
+		symbol, color = "✗", colorRed
+	}
+
+	line := fmt.Sprintf("  %s %-*s  %-7s", p.paint(symbol, color), width, migration.ID, migration.State)
+	switch {
+	case migration.Error != "":
+		line += "  " + migration.Error
+	case migration.AppliedAt != nil:
+		line += "  " + migration.AppliedAt.UTC().Format(time.RFC3339)
+	}
+
+	return strings.TrimRight(line, " ")
+}
+
+func (p Printer) writeOrphans(output *strings.Builder, reports []OrphanReport) {
+	width := 0
+	for _, report := range reports {
+		width = max(width, len([]rune(report.label())))
+	}
+
+	for _, report := range reports {
+		symbol, color := "✓", colorGreen
+		if report.Orphans > 0 {
+			symbol, color = "✗", colorRed
+		}
+		label := report.label()
+		padding := strings.Repeat(" ", width-len([]rune(label)))
+		fmt.Fprintf(output, "  %s %s%s  %d %s (on delete %s)\n",
+			p.paint(symbol, color), label, padding,
+			report.Orphans, plural(int(report.Orphans), "orphan", "orphans"), report.OnDelete)
+	}
+}
+
+func (p Printer) writeViolations(output *strings.Builder, reports []ViolationReport) {
+	width := 0
+	for _, report := range reports {
+		width = max(width, len(report.label()))
+	}
+
+	for _, report := range reports {
+		symbol, color := "✓", colorGreen
+		if report.Violations > 0 {
+			symbol, color = "✗", colorRed
+		}
+		fmt.Fprintf(output, "  %s %-*s  %d %s (%s)\n",
+			p.paint(symbol, color), width, report.label(),
+			report.Violations, plural(int(report.Violations), "violation", "violations"), report.Rule)
+		if len(report.Rows) > 0 {
+			fmt.Fprintf(output, "      rows: %s\n", strings.Join(report.Rows, ", "))
+		}
+	}
+}
+
+func (p Printer) writeTables(output *strings.Builder, reports []TableReport) {
+	width := 0
+	for _, report := range reports {
+		width = max(width, len(report.Table))
+	}
+
+	for _, report := range reports {
+		symbol, color := "•", colorGray
+		switch report.State {
+		case TABLE_UNKNOWN:
+			symbol, color = "✗", colorRed
+		case TABLE_PURGED, TABLE_RESTORED:
+			symbol, color = "✓", colorGreen
+		}
+		fmt.Fprintf(output, "  %s %-*s  %s\n", p.paint(symbol, color), width, report.Table, report.label())
+	}
+}
+
+func (p Printer) summary(result CommandResult) string {
+	if !result.Success {
+		return p.paint(fmt.Sprintf("%s failed: %s", result.Command, result.Error), colorRed)
+	}
+	if result.Check != nil {
+		if result.Check.ExitCode != EXIT_SUCCESS {
+			return p.paint("status check failed: "+result.Check.summary(), colorRed)
+		}
+		return p.paint("status check ok: "+result.Check.summary(), colorGreen)
+	}
+
+	var summary string
+	switch result.Command {
+	case "status":
+		summary = fmt.Sprintf(
+			"%d applied, %d pending",
+			result.count(STATE_APPLIED),
+			result.count(STATE_PENDING),
+		)
+	case "seed":
+		summary = "seeded"
+	case "backup":
+		summary = result.File
+	case "export":
+		summary = fmt.Sprintf("%d %s written to %s", result.Changed, plural(result.Changed, "row", "rows"), result.File)
+	case "import":
+		summary = fmt.Sprintf("%d %s loaded from %s", result.Changed, plural(result.Changed, "row", "rows"), result.File)
+	case "rotate-pepper-status":
+		summary = result.Pepper.summary()
+	case "encrypt-columns":
+		summary = fmt.Sprintf("%d %s encrypted", result.Changed, plural(result.Changed, "value", "values"))
+	case "verify-fk":
+		summary = "no orphaned rows"
+	case "verify-data":
+		summary = "no rows break the data constraints"
+	case "integrity-check":
+		summary = integritySummary(result.Integrity)
+	case "drop-retired":
+		summary = fmt.Sprintf("%d %s renamed or dropped", result.Changed, plural(result.Changed, "table", "tables"))
+	case "restore-retired":
+		summary = fmt.Sprintf("restored %s", result.Tables[0].Original)
+	default:
+		summary = fmt.Sprintf("%d %s", result.Changed, plural(result.Changed, "migration", "migrations"))
+	}
+
+	return p.paint(fmt.Sprintf("%s ok: %s", result.Command, summary), colorGreen)
+}
+
+func (p Printer) paint(text string, color string) string {
+	if !p.color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+func plural(count int, singular string, plural string) string {
+	if count == 1 {
+		return singular
+	}
+	return plural
+}
+
+// colorEnabled follows the NO_COLOR convention and only colors terminals.
+func colorEnabled(out io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestMigrator(t *testing.T, migrations ...*migrate.Migration) migrator {
+	db, err := sql.Open(MIGRATION_DB, filepath.Join(t.TempDir(), "migrations.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	return migrator{
+		db:     db,
+		source: &migrate.MemoryMigrationSource{Migrations: migrations},
+		log:    setupTestLogger(),
+	}
+}
+
+func testMigration(id string, table string) *migrate.Migration {
+	return &migrate.Migration{
+		Id:   id,
+		Up:   []string{"CREATE TABLE " + table + " (id INTEGER PRIMARY KEY)"},
+		Down: []string{"DROP TABLE " + table},
+	}
+}
+
+func testMigrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		testMigration("0001_init", "one"),
+		testMigration("0002_login_events", "two"),
+		testMigration("0003_announcements", "three"),
+	}
+}
+
+func printForTest(t *testing.T, result CommandResult, asJSON bool, color bool) string {
+	var out bytes.Buffer
+	require.NoError(t, NewPrinter(&out, asJSON, color).Print(result))
+	return out.String()
+}
+
+func TestPrinter_HumanFormat(t *testing.T) {
+	appliedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	result := CommandResult{
+		Command: "up",
+		Changed: 1,
+		Migrations: []MigrationResult{
+			{ID: "0001_init", State: STATE_APPLIED, AppliedAt: &appliedAt},
+			{ID: "0002_login_events", State: STATE_FAILED, Error: "syntax error"},
+			{ID: "0003_a", State: STATE_PENDING},
+		},
+		Error: "syntax error handling 0002_login_events",
+	}
+
+	expected := "" +
+		"  ✓ 0001_init          applied  2024-06-01T12:00:00Z\n" +
+		"  ✗ 0002_login_events  failed   syntax error\n" +
+		"  • 0003_a             pending\n" +
+		"up failed: syntax error handling 0002_login_events\n"
+	assert.Equal(t, expected, printForTest(t, result, false, false))
+}
+
+func TestPrinter_HumanSummary(t *testing.T) {
+	testCases := []struct {
+		name     string
+		result   CommandResult
+		expected string
+	}{
+		{
+			"status",
+			CommandResult{Command: "status", Success: true, Migrations: []MigrationResult{
+				{ID: "0001_init", State: STATE_PENDING},
+			}},
+			"  • 0001_init  pending\nstatus ok: 0 applied, 1 pending\n",
+		},
+		{"nothing to do", CommandResult{Command: "up", Success: true}, "up ok: 0 migrations\n"},
+		{"single", CommandResult{Command: "down", Success: true, Changed: 1}, "down ok: 1 migration\n"},
+		{
+			"backup",
+			CommandResult{Command: "backup", Success: true, File: "data/backups/backup-20240601T030000Z.db"},
+			"backup ok: data/backups/backup-20240601T030000Z.db\n",
+		},
+		{
+			"export",
+			CommandResult{Command: "export", Success: true, Changed: 1, File: "staging.jsonl.gz"},
+			"export ok: 1 row written to staging.jsonl.gz\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, printForTest(t, tc.result, false, false))
+		})
+	}
+}
+
+func TestPrinter_Color(t *testing.T) {
+	result := CommandResult{
+		Command:    "status",
+		Success:    true,
+		Migrations: []MigrationResult{{ID: "0001_init", State: STATE_PENDING}},
+	}
+
+	colored := printForTest(t, result, false, true)
+	assert.Contains(t, colored, colorGray+"•"+colorReset)
+	assert.Contains(t, colored, colorGreen+"status ok")
+
+	assert.NotContains(t, printForTest(t, result, false, false), "\033[")
+}
+
+func TestPrinter_JSONFormat(t *testing.T) {
+	appliedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	result := CommandResult{
+		Command: "status",
+		Success: true,
+		Migrations: []MigrationResult{
+			{ID: "0001_init", State: STATE_APPLIED, AppliedAt: &appliedAt},
+			{ID: "0002_login_events", State: STATE_PENDING},
+		},
+	}
+
+	expected := `{
+  "command": "status",
+  "success": true,
+  "changed": 0,
+  "migrations": [
+    {
+      "id": "0001_init",
+      "state": "applied",
+      "appliedAt": "2024-06-01T12:00:00Z"
+    },
+    {
+      "id": "0002_login_events",
+      "state": "pending"
+    }
+  ]
+}
+`
+	assert.Equal(t, expected, printForTest(t, result, true, true))
+}
+
+func TestMigrator_StatusAndExec(t *testing.T) {
+	m := setupTestMigrator(t, testMigrations()...)
+
+	status := m.statusCommand()
+	require.True(t, status.Success)
+	require.Len(t, status.Migrations, 3)
+	assert.Equal(t, 3, status.count(STATE_PENDING))
+
+	up := m.exec("up", migrate.Up, 2)
+	require.True(t, up.Success, up.Error)
+	assert.Equal(t, 2, up.Changed)
+	assert.Equal(t, STATE_APPLIED, up.Migrations[0].State)
+	assert.NotNil(t, up.Migrations[0].AppliedAt)
+
+	down := m.exec("down", migrate.Down, 1)
+	require.True(t, down.Success, down.Error)
+	require.Len(t, down.Migrations, 1)
+	assert.Equal(t, "0002_login_events", down.Migrations[0].ID)
+	assert.Equal(t, STATE_PENDING, down.Migrations[0].State)
+
+	assert.Equal(t, 1, m.statusCommand().count(STATE_APPLIED))
+}
+
+func TestMigrator_ExecFailure(t *testing.T) {
+	broken := &migrate.Migration{Id: "0002_broken", Up: []string{"CREATE BROKEN"}}
+	m := setupTestMigrator(t, testMigration("0001_init", "one"), broken, testMigration("0003_next", "three"))
+
+	result := m.exec("up", migrate.Up, 0)
+
+	assert.False(t, result.Success)
+	assert.Equal(t, EXIT_FAILURE, result.ExitCode())
+	assert.Equal(t, 1, result.Changed)
+	require.Len(t, result.Migrations, 3)
+	assert.Equal(t, STATE_APPLIED, result.Migrations[0].State)
+	assert.Equal(t, STATE_FAILED, result.Migrations[1].State)
+	assert.NotEmpty(t, result.Migrations[1].Error)
+	assert.Equal(t, STATE_PENDING, result.Migrations[2].State)
+
+	human := printForTest(t, result, false, false)
+	assert.Contains(t, human, "  ✗ 0002_broken  failed   ")
+	assert.True(t, strings.HasPrefix(strings.Split(human, "\n")[3], "up failed: "))
+
+	var document CommandResult
+	require.NoError(t, json.Unmarshal([]byte(printForTest(t, result, true, false)), &document))
+	assert.Equal(t, result.Error, document.Error)
+	assert.Equal(t, STATE_FAILED, document.Migrations[1].State)
+}
+
+func TestMigrator_Goto(t *testing.T) {
+	m := setupTestMigrator(t, testMigrations()...)
+
+	up := m.gotoCommand("0002_login_events")
+	require.True(t, up.Success, up.Error)
+	assert.Equal(t, 2, up.Changed)
+
+	down := m.gotoCommand("1")
+	require.True(t, down.Success, down.Error)
+	require.Len(t, down.Migrations, 1)
+	assert.Equal(t, "0002_login_events", down.Migrations[0].ID)
+
+	same := m.gotoCommand("0001_init")
+	assert.True(t, same.Success)
+	assert.Equal(t, 0, same.Changed)
+
+	unknown := m.gotoCommand("0009_missing")
+	assert.False(t, unknown.Success)
+	assert.Contains(t, unknown.Error, "0009_missing")
+}
+
+func TestParseArgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		expected options
+	}{
+		{"default", nil, options{command: "up", steps: 1}},
+		{"flags anywhere", []string{"down", "--json", "3", "--no-color"}, options{command: "down", steps: 3, json: true, noColor: true}},
+		{"goto", []string{"goto", "0002"}, options{command: "goto", target: "0002", steps: 1}},
+		{"backup", []string{"backup", "--json"}, options{command: "backup", steps: 1, json: true}},
+		{"export", []string{"export", "staging.jsonl.gz"}, options{command: "export", target: "staging.jsonl.gz", steps: 1}},
+		{"merge import", []string{"--merge", "import", "staging.jsonl.gz"}, options{command: "import", target: "staging.jsonl.gz", steps: 1, merge: true}},
+		{"pepper status", []string{"rotate-pepper-status", "--json"}, options{command: "rotate-pepper-status", steps: 1, json: true}},
+		{"verify foreign keys", []string{"verify-fk"}, options{command: "verify-fk", steps: 1}},
+		{"force clean", []string{"up", "--force-clean"}, options{command: "up", steps: 1, forceClean: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := parseArgs(tc.args)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, opts)
+		})
+	}
+}
+
+func TestRun_UsageErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{"unknown command", []string{"sideways"}},
+		{"unknown flag", []string{"up", "--verbose"}},
+		{"bad steps", []string{"down", "many"}},
+		{"zero steps", []string{"down", "0"}},
+		{"goto without target", []string{"goto", "--json"}},
+		{"extra argument", []string{"status", "now"}},
+		{"export without path", []string{"export"}},
+		{"merge outside import", []string{"export", "out.gz", "--merge"}},
+		{"force clean outside up", []string{"verify-fk", "--force-clean"}},
+		{"purge outside drop-retired", []string{"up", "--purge"}},
+		{"restore without table", []string{"restore-retired"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			code := run(tc.args, &stdout, &stderr)
+
+			assert.Equal(t, EXIT_USAGE, code)
+			assert.Empty(t, stdout.String())
+			assert.Contains(t, stderr.String(), "usage: migration")
+		})
+	}
+}
+
+func TestRun_Help(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	assert.Equal(t, EXIT_SUCCESS, run([]string{"--help"}, &stdout, &stderr))
+	assert.True(t, strings.HasPrefix(stdout.String(), "usage: migration"))
+}
+
+func TestCommandResult_ExitCode(t *testing.T) {
+	assert.Equal(t, EXIT_SUCCESS, CommandResult{Success: true}.ExitCode())
+	assert.Equal(t, EXIT_FAILURE, CommandResult{}.ExitCode())
+	assert.Equal(t, EXIT_FAILURE, failedResult("up", errors.New("boom")).ExitCode())
+}
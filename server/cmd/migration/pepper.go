@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"server/config"
+	. "server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// PepperStatus is how far a pepper rotation has got. Outdated users have a
+// password hashed with an older pepper and can only log in while it is still
+// set as SECURITY_PEPPER_PREVIOUS.
+type PepperStatus struct {
+	Version        int   `json:"version"`
+	Users          int64 `json:"users"`
+	Outdated       int64 `json:"outdated"`
+	PreviousPepper bool  `json:"previousPepper"`
+}
+
+func (s *PepperStatus) summary() string {
+	if s.Outdated == 0 {
+		summary := fmt.Sprintf("all %d %s on pepper version %d", s.Users, plural(int(s.Users), "user", "users"), s.Version)
+		if s.PreviousPepper {
+			summary += ", SECURITY_PEPPER_PREVIOUS can be removed"
+		}
+		return summary
+	}
+	return fmt.Sprintf("%d of %d %s not on pepper version %d yet",
+		s.Outdated, s.Users, plural(int(s.Users), "user", "users"), s.Version)
+}
+
+// pepperStatusCommand counts the users who haven't logged in since the pepper
+// was rotated, and so still have a hash only the previous pepper verifies.
+func pepperStatusCommand(db *gorm.DB, config config.Config) CommandResult {
+	status := PepperStatus{
+		Version:        config.PepperVersion(),
+		PreviousPepper: config.Security.PepperPrevious != "",
+	}
+
+	if err := db.Model(&User{}).Count(&status.Users).Error; err != nil {
+		return failedResult("rotate-pepper-status", err)
+	}
+	if err := db.Model(&User{}).Where("pepper_version <> ?", status.Version).Count(&status.Outdated).Error; err != nil {
+		return failedResult("rotate-pepper-status", err)
+	}
+
+	return CommandResult{
+		Command:    "rotate-pepper-status",
+		Success:    true,
+		Migrations: []MigrationResult{},
+		Pepper:     &status,
+	}
+}
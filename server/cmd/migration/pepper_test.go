@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"server/config"
+	. "server/internal/models"
+	"testing"
+
+	migrate "github.com/rubenv/sql-migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPepperStatusCommand(t *testing.T) {
+	db, _ := setupTestDB(t)
+	require.NoError(t, autoMigrate(db, setupTestLogger()))
+	for i, version := range []int{1, 1, 2} {
+		user := User{Login: "user" + string(rune('a'+i)), Password: "$2a$04$C6UzMDM.H6dfI/f/IKcEeO5w8tRGVvFL1mW3X8bHVj1PeZsl8Kz3K", PepperVersion: version}
+		require.NoError(t, db.Create(&user).Error)
+	}
+
+	rotating := config.Config{Security: config.SecurityConfig{Pepper: "new", PepperPrevious: "old", PepperVersion: 2}}
+	result := pepperStatusCommand(db, rotating)
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, &PepperStatus{Version: 2, Users: 3, Outdated: 2, PreviousPepper: true}, result.Pepper)
+	assert.Equal(t, "rotate-pepper-status ok: 2 of 3 users not on pepper version 2 yet\n", printForTest(t, result, false, false))
+
+	require.NoError(t, db.Exec("UPDATE users SET pepper_version = 2").Error)
+	result = pepperStatusCommand(db, rotating)
+	require.True(t, result.Success, result.Error)
+	assert.Zero(t, result.Pepper.Outdated)
+	assert.Equal(t,
+		"rotate-pepper-status ok: all 3 users on pepper version 2, SECURITY_PEPPER_PREVIOUS can be removed\n",
+		printForTest(t, result, false, false))
+}
+
+func TestPepperStatusCommand_NotMigrated(t *testing.T) {
+	db, _ := setupTestDB(t)
+
+	result := pepperStatusCommand(db, config.Config{})
+	assert.False(t, result.Success)
+	assert.NotEmpty(t, result.Error)
+}
+
+func TestPepperVersionMigration(t *testing.T) {
+	db, err := sql.Open(MIGRATION_DB, filepath.Join(t.TempDir(), "migrations.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	source := &migrate.FileMigrationSource{Dir: "migrations"}
+
+	// Up to the pepper version, as later ones need the auto-migration
+	_, err = migrate.ExecVersion(db, MIGRATION_DB, source, migrate.Up, 5)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO users (id) VALUES ('existing')")
+	require.NoError(t, err)
+
+	// Users from before versioning were hashed with the first pepper
+	var version int
+	require.NoError(t, db.QueryRow("SELECT pepper_version FROM users").Scan(&version))
+	assert.Equal(t, 1, version)
+
+	// Back to the migration before pepper versioning
+	_, err = migrate.ExecVersion(db, MIGRATION_DB, source, migrate.Down, 4)
+	require.NoError(t, err)
+	_, err = db.Query("SELECT pepper_version FROM users")
+	assert.Error(t, err)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	. "server/internal/models"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Retired tables are renamed to RETIRED_TABLE_PREFIX + name + "_" + the
+	// date they were renamed on, which purging counts the grace period from.
+	// The prefix sorts them after every other table.
+	RETIRED_TABLE_PREFIX      = "zz_retired_"
+	RETIRED_TABLE_DATE_LAYOUT = "20060102"
+
+	// sql-migrate's own table, left out of the table report
+	MIGRATION_RECORDS_TABLE = "gorp_migrations"
+)
+
+const (
+	// TABLE_RETIRED is a retired table still under its name, pending
+	// drop-retired. TABLE_RENAMED is one drop-retired has renamed, which
+	// --purge drops once its grace period is over, and TABLE_UNKNOWN is
+	// neither registered nor retired, usually a model removed without being
+	// marked retired.
+	TABLE_RETIRED = "retired"
+	TABLE_RENAMED = "renamed"
+	TABLE_UNKNOWN = "unknown"
+
+	// What drop-retired and restore-retired did to a table
+	TABLE_PURGED   = "purged"
+	TABLE_RESTORED = "restored"
+)
+
+// TableReport is a table that isn't one of the registered models'. Original
+// is the name a renamed table had before drop-retired, and Since the
+// migration its model was removed in.
+type TableReport struct {
+	Table     string     `json:"table"`
+	State     string     `json:"state"`
+	Original  string     `json:"original,omitempty"`
+	Since     string     `json:"since,omitempty"`
+	RenamedAt *time.Time `json:"renamedAt,omitempty"`
+}
+
+func (r TableReport) label() string {
+	switch r.State {
+	case TABLE_RETIRED:
+		return fmt.Sprintf("retired since %s, pending drop-retired", r.Since)
+	case TABLE_UNKNOWN:
+		return "neither registered nor marked retired"
+	case TABLE_RESTORED:
+		return fmt.Sprintf("restored to %s", r.Original)
+	case TABLE_PURGED:
+		return fmt.Sprintf("dropped, renamed from %s on %s", r.Original, r.RenamedAt.Format(time.DateOnly))
+	}
+	return fmt.Sprintf("renamed from %s on %s", r.Original, r.RenamedAt.Format(time.DateOnly))
+}
+
+// retiredTableName is the name drop-retired renames table to on day.
+func retiredTableName(table string, day time.Time) string {
+	return RETIRED_TABLE_PREFIX + table + "_" + day.UTC().Format(RETIRED_TABLE_DATE_LAYOUT)
+}
+
+// parseRetiredTableName splits a renamed table's name into the table's
+// original name and the day it was renamed on.
+func parseRetiredTableName(name string) (string, time.Time, bool) {
+	rest, ok := strings.CutPrefix(name, RETIRED_TABLE_PREFIX)
+	if !ok {
+		return "", time.Time{}, false
+	}
+	separator := len(rest) - len(RETIRED_TABLE_DATE_LAYOUT) - 1
+	if separator < 1 || rest[separator] != '_' {
+		return "", time.Time{}, false
+	}
+	day, err := time.Parse(RETIRED_TABLE_DATE_LAYOUT, rest[separator+1:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return rest[:separator], day, true
+}
+
+// classifyTables reports every table in present that isn't registered,
+// ordered by name.
+func classifyTables(present []string, registered []string, retired []RetiredTable) []TableReport {
+	reports := []TableReport{}
+	for _, table := range present {
+		if slices.Contains(registered, table) {
+			continue
+		}
+
+		report := TableReport{Table: table, State: TABLE_UNKNOWN}
+		original := table
+		if name, day, ok := parseRetiredTableName(table); ok {
+			report.State = TABLE_RENAMED
+			report.Original = name
+			report.RenamedAt = &day
+			original = name
+		}
+
+		// A renamed table is purged in the end even once it is no longer
+		// marked retired
+		index := slices.IndexFunc(retired, func(r RetiredTable) bool { return r.Table == original })
+		if index >= 0 {
+			report.Since = retired[index].Since
+			if report.State == TABLE_UNKNOWN {
+				report.State = TABLE_RETIRED
+			}
+		}
+		reports = append(reports, report)
+	}
+
+	slices.SortFunc(reports, func(a, b TableReport) int { return strings.Compare(a.Table, b.Table) })
+	return reports
+}
+
+// tables lists the database's own tables, leaving out sqlite's and
+// sql-migrate's.
+func tables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != ? ORDER BY name",
+		MIGRATION_RECORDS_TABLE,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to read table name: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// tableReports classifies the database's tables against the registry.
+func (m migrator) tableReports(db *gorm.DB, retired []RetiredTable) ([]TableReport, error) {
+	registered, err := TableNames(db)
+	if err != nil {
+		return nil, err
+	}
+
+	present, err := tables(m.db)
+	if err != nil {
+		return nil, err
+	}
+	return classifyTables(present, registered, retired), nil
+}
+
+// statusWithTables is status along with the tables that aren't registered,
+// so a model removed from the registry shows up as retired or unknown.
+func (m migrator) statusWithTables(db *gorm.DB) CommandResult {
+	result := m.statusCommand()
+	if !result.Success {
+		return result
+	}
+
+	reports, err := m.tableReports(db, Retired())
+	if err != nil {
+		return failedResult("status", err)
+	}
+	result.Tables = reports
+	return result
+}
+
+// dropRetired renames every retired table still under its name, keeping its
+// rows so restoreRetired can bring it back. With purge, renamed tables whose
+// grace period has passed by now are dropped for good. Nothing else ever
+// drops a retired table.
+func (m migrator) dropRetired(db *gorm.DB, retired []RetiredTable, purge bool, grace time.Duration, now time.Time) CommandResult {
+	log := m.log.Function("dropRetired")
+
+	reports, err := m.tableReports(db, retired)
+	if err != nil {
+		return failedResult("drop-retired", err)
+	}
+
+	changed := []TableReport{}
+	for _, report := range reports {
+		switch {
+		case report.State == TABLE_RETIRED:
+			day := now.UTC().Truncate(24 * time.Hour)
+			renamed := TableReport{
+				Table:     retiredTableName(report.Table, day),
+				State:     TABLE_RENAMED,
+				Original:  report.Table,
+				Since:     report.Since,
+				RenamedAt: &day,
+			}
+			if err := m.renameTable(report.Table, renamed.Table); err != nil {
+				result := failedResult("drop-retired", err)
+				result.Tables = changed
+				return result
+			}
+			log.Info("Renamed retired table", "table", report.Table, "to", renamed.Table)
+			changed = append(changed, renamed)
+		case purge && report.State == TABLE_RENAMED && !now.Before(report.RenamedAt.Add(grace)):
+			if _, err := m.db.Exec(fmt.Sprintf("DROP TABLE %q", report.Table)); err != nil {
+				result := failedResult("drop-retired", log.Err("failed to drop retired table", err, "table", report.Table))
+				result.Tables = changed
+				return result
+			}
+			log.Info("Dropped retired table", "table", report.Table, "original", report.Original)
+			report.State = TABLE_PURGED
+			changed = append(changed, report)
+		}
+	}
+
+	return CommandResult{
+		Command:    "drop-retired",
+		Success:    true,
+		Changed:    len(changed),
+		Migrations: []MigrationResult{},
+		Tables:     changed,
+	}
+}
+
+// restoreRetired renames the latest renamed copy of table back to its
+// name, undoing drop-retired. The table's model has to be registered or the
+// table marked retired again for it to be used or reported as expected.
+func (m migrator) restoreRetired(db *gorm.DB, table string) CommandResult {
+	reports, err := m.tableReports(db, Retired())
+	if err != nil {
+		return failedResult("restore-retired", err)
+	}
+
+	present, err := tables(m.db)
+	if err != nil {
+		return failedResult("restore-retired", err)
+	}
+	if slices.Contains(present, table) {
+		return failedResult("restore-retired", fmt.Errorf("table %s already exists", table))
+	}
+
+	var latest *TableReport
+	for i, report := range reports {
+		if report.State == TABLE_RENAMED && report.Original == table &&
+			(latest == nil || report.RenamedAt.After(*latest.RenamedAt)) {
+			latest = &reports[i]
+		}
+	}
+	if latest == nil {
+		return failedResult("restore-retired", fmt.Errorf("no renamed copy of %s to restore", table))
+	}
+
+	if err := m.renameTable(latest.Table, table); err != nil {
+		return failedResult("restore-retired", err)
+	}
+	m.log.Function("restoreRetired").Info("Restored retired table", "table", table, "from", latest.Table)
+
+	restored := *latest
+	restored.State = TABLE_RESTORED
+	return CommandResult{
+		Command:    "restore-retired",
+		Success:    true,
+		Changed:    1,
+		Migrations: []MigrationResult{},
+		Tables:     []TableReport{restored},
+	}
+}
+
+func (m migrator) renameTable(from string, to string) error {
+	if _, err := m.db.Exec(fmt.Sprintf("ALTER TABLE %q RENAME TO %q", from, to)); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", from, to, err)
+	}
+	return nil
+}
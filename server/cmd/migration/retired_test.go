@@ -0,0 +1,138 @@
+package main
+
+import (
+	. "server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var retiredWidgets = []RetiredTable{{Table: "widgets", Since: "0013_drop_widgets.sql"}}
+
+func TestClassifyTables(t *testing.T) {
+	renamedAt := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	present := []string{
+		"users",
+		"widgets",
+		"mystery",
+		"zz_retired_old_gadgets_20260301",
+		"zz_retired_widgets_notadate",
+	}
+
+	reports := classifyTables(present, []string{"users"}, append(retiredWidgets, RetiredTable{Table: "old_gadgets", Since: "0011"}))
+
+	assert.Equal(t, []TableReport{
+		{Table: "mystery", State: TABLE_UNKNOWN},
+		{Table: "widgets", State: TABLE_RETIRED, Since: "0013_drop_widgets.sql"},
+		{Table: "zz_retired_old_gadgets_20260301", State: TABLE_RENAMED, Original: "old_gadgets", Since: "0011", RenamedAt: &renamedAt},
+		{Table: "zz_retired_widgets_notadate", State: TABLE_UNKNOWN},
+	}, reports)
+}
+
+func TestStatus_ReportsUnregisteredTables(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	execAll(t, m.db, "CREATE TABLE mystery (id INTEGER PRIMARY KEY)")
+
+	result := m.statusWithTables(db)
+
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, []TableReport{{Table: "mystery", State: TABLE_UNKNOWN}}, result.Tables)
+	assert.Contains(t, printForTest(t, result, false, false), "  ✗ mystery  neither registered nor marked retired\n")
+}
+
+func TestDropRetired_RenamesAndRestores(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	execAll(t, m.db,
+		"CREATE TABLE widgets (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO widgets (name) VALUES ('sprocket')",
+	)
+	now := time.Date(2026, 10, 15, 18, 30, 0, 0, time.UTC)
+
+	result := m.dropRetired(db, retiredWidgets, false, 30*24*time.Hour, now)
+
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, 1, result.Changed)
+	require.Len(t, result.Tables, 1)
+	assert.Equal(t, "zz_retired_widgets_20261015", result.Tables[0].Table)
+	assert.Equal(t, TABLE_RENAMED, result.Tables[0].State)
+	assert.Equal(t, 1, countRows(t, m.db, "SELECT COUNT(*) FROM zz_retired_widgets_20261015"), "rows are kept")
+	assert.Equal(t, ""+
+		"  • zz_retired_widgets_20261015  renamed from widgets on 2026-10-15\n"+
+		"drop-retired ok: 1 table renamed or dropped\n",
+		printForTest(t, result, false, false))
+
+	// Running it again has nothing left to rename
+	again := m.dropRetired(db, retiredWidgets, false, 30*24*time.Hour, now)
+	require.True(t, again.Success, again.Error)
+	assert.Zero(t, again.Changed)
+
+	restored := m.restoreRetired(db, "widgets")
+	require.True(t, restored.Success, restored.Error)
+	assert.Equal(t, TABLE_RESTORED, restored.Tables[0].State)
+	assert.Equal(t, "sprocket", storedWidget(t, m))
+
+	// A table already under the name isn't overwritten
+	refused := m.restoreRetired(db, "widgets")
+	assert.False(t, refused.Success)
+	assert.Contains(t, refused.Error, "already exists")
+}
+
+func TestRestoreRetired_TakesTheLatestCopy(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	execAll(t, m.db,
+		"CREATE TABLE zz_retired_widgets_20260101 (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO zz_retired_widgets_20260101 (name) VALUES ('old')",
+		"CREATE TABLE zz_retired_widgets_20260601 (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO zz_retired_widgets_20260601 (name) VALUES ('new')",
+	)
+
+	result := m.restoreRetired(db, "widgets")
+
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, "zz_retired_widgets_20260601", result.Tables[0].Table)
+	assert.Equal(t, "new", storedWidget(t, m))
+
+	missing := m.restoreRetired(db, "gadgets")
+	assert.False(t, missing.Success)
+	assert.Contains(t, missing.Error, "no renamed copy of gadgets")
+}
+
+func TestDropRetired_PurgesAfterGracePeriod(t *testing.T) {
+	m, db := setupForeignKeyDB(t)
+	execAll(t, m.db,
+		"CREATE TABLE zz_retired_widgets_20260901 (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE zz_retired_widgets_20261001 (id INTEGER PRIMARY KEY)",
+	)
+	grace := 30 * 24 * time.Hour
+
+	// Without --purge renamed tables are never dropped
+	kept := m.dropRetired(db, retiredWidgets, false, grace, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.True(t, kept.Success, kept.Error)
+	assert.Zero(t, kept.Changed)
+
+	// One day short of the second copy's grace period only the first goes
+	result := m.dropRetired(db, retiredWidgets, true, grace, time.Date(2026, 10, 30, 0, 0, 0, 0, time.UTC))
+
+	require.True(t, result.Success, result.Error)
+	require.Len(t, result.Tables, 1)
+	assert.Equal(t, "zz_retired_widgets_20260901", result.Tables[0].Table)
+	assert.Equal(t, TABLE_PURGED, result.Tables[0].State)
+
+	present, err := tables(m.db)
+	require.NoError(t, err)
+	assert.NotContains(t, present, "zz_retired_widgets_20260901")
+	assert.Contains(t, present, "zz_retired_widgets_20261001")
+
+	result = m.dropRetired(db, retiredWidgets, true, grace, time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC))
+	require.True(t, result.Success, result.Error)
+	assert.Equal(t, 1, result.Changed)
+}
+
+func storedWidget(t *testing.T, m migrator) string {
+	t.Helper()
+	var name string
+	require.NoError(t, m.db.QueryRow("SELECT name FROM widgets").Scan(&name))
+	return name
+}
@@ -2,8 +2,10 @@ package seed
 
 import (
 	"server/config"
+	"server/internal/database"
 	"server/internal/logger"
 	. "server/internal/models"
+	"server/internal/utils"
 
 	"gorm.io/gorm"
 )
@@ -47,9 +49,17 @@ func Seed(db *gorm.DB, config config.Config, log logger.Logger) error {
 			log.Info("User already exists", "user", user)
 			continue
 		}
-		log.Info("Seeding user", "user", user)
+		hashedPassword, err := utils.HashPassword(user.Password, config)
+		if err != nil {
+			log.Er("failed to hash password", err, "login", user.Login)
+			continue
+		}
+		user.Password = hashedPassword
+		user.PepperVersion = config.PepperVersion()
+
+		log.Info("Seeding user", "login", user.Login)
 		if err := db.Create(&user).Error; err != nil {
-			log.Er("failed to create user", err, "user", user)
+			log.Er("failed to create user", database.ExplainConstraint("users", user.ID, err), "user", user)
 		}
 	}
 
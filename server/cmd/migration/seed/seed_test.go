@@ -4,6 +4,7 @@ import (
 	"server/config"
 	"server/internal/logger"
 	. "server/internal/models"
+	"server/internal/utils"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,13 +15,12 @@ import (
 
 func setupTestDB(t *testing.T) (*gorm.DB, config.Config) {
 	testConfig := config.Config{
-		SecuritySalt:   12,
-		SecurityPepper: "test-pepper",
+		Security: config.SecurityConfig{
+			Salt:   12,
+			Pepper: "test-pepper",
+		},
 	}
 
-	// Set the global config instance for the utils package
-	config.ConfigInstance = testConfig
-
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	require.NoError(t, err)
 
@@ -49,10 +49,9 @@ func TestSeed(t *testing.T) {
 					FirstName: "John",
 					LastName:  "Doe",
 					Login:     "johndoe",
-					Password:  "existing_password",
 					IsAdmin:   true,
 				}
-				db.Create(&existingUser)
+				require.NoError(t, db.Create(&existingUser).Error)
 			},
 		},
 	}
@@ -167,15 +166,18 @@ func TestSeed_UserCreationError(t *testing.T) {
 	db, config := setupTestDB(t)
 	log := logger.New("test")
 
+	hashedPassword, err := utils.HashPassword("existing_password", config)
+	require.NoError(t, err)
+
 	// Create a user with the same login to cause a constraint violation
 	existingUser := User{
 		FirstName: "Existing",
 		LastName:  "User",
 		Login:     "johndoe",
-		Password:  "existing_password",
+		Password:  hashedPassword,
 		IsAdmin:   false,
 	}
-	err := db.Create(&existingUser).Error
+	err = db.Create(&existingUser).Error
 	require.NoError(t, err)
 
 	// Seed should complete without error (it checks for existing users)
@@ -1,62 +1,1153 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"server/internal/encryption"
 	"server/internal/logger"
+	"server/internal/semver"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
+// Config is grouped into sections. A setting is read from the environment
+// variable named after its section and key, e.g. Server.Port from
+// SERVER_PORT, and from the .env file under the same name.
 type Config struct {
-	GeneralVersion       string `mapstructure:"GENERAL_VERSION"`
-	Environment          string `mapstructure:"ENVIRONMENT"`
-	ServerPort           int    `mapstructure:"SERVER_PORT"`
-	DatabaseDbPath       string `mapstructure:"DB_PATH"`
-	DatabaseCacheAddress string `mapstructure:"DB_CACHE_ADDRESS"`
-	DatabaseCachePort    int    `mapstructure:"DB_CACHE_PORT"`
-	CorsAllowOrigins     string `mapstructure:"CORS_ALLOW_ORIGINS"`
-	SecuritySalt         int    `mapstructure:"SECURITY_SALT"`
-	SecurityPepper       string `mapstructure:"SECURITY_PEPPER"`
-	SecurityJwtSecret    string `mapstructure:"SECURITY_JWT_SECRET"`
+	GeneralVersion string `mapstructure:"general_version"`
+	Environment    string `mapstructure:"environment"`
+
+	// Where a failed startup leaves its report, with secrets redacted
+	DiagnosticsPath string `mapstructure:"diagnostics_path"`
+
+	// The terms of service version users must have accepted. Registrations
+	// must send it, and bumping it holds back signed in users' writes until
+	// they accept the new version. Empty turns the check off.
+	TermsVersion string `mapstructure:"terms_version"`
+
+	// Where the web client is served, e.g. https://app.example.com. Links
+	// into the client, like /.well-known/change-password, are built on it.
+	FrontendBaseURL string `mapstructure:"frontend_base_url"`
+
+	Server      ServerConfig      `mapstructure:"server"`
+	Database    DatabaseConfig    `mapstructure:"database"`
+	Cache       CacheConfig       `mapstructure:"cache"`
+	Security    SecurityConfig    `mapstructure:"security"`
+	Session     SessionConfig     `mapstructure:"session"`
+	Websocket   WebsocketConfig   `mapstructure:"websocket"`
+	Logging     LoggingConfig     `mapstructure:"logging"`
+	Audit       AuditConfig       `mapstructure:"audit"`
+	Maintenance MaintenanceConfig `mapstructure:"maintenance"`
+	Tracing     TracingConfig     `mapstructure:"tracing"`
+	WellKnown   WellKnownConfig   `mapstructure:"well_known"`
+}
+
+type ServerConfig struct {
+	Port             int    `mapstructure:"port"`
+	CorsAllowOrigins string `mapstructure:"cors_allow_origins"`
+
+	// Listen on a unix socket instead of SERVER_PORT, e.g.
+	// unix:///var/run/app.sock. The socket is created with the octal
+	// permissions in SERVER_SOCKET_MODE.
+	Listen     string `mapstructure:"listen"`
+	SocketMode string `mapstructure:"socket_mode"`
+
+	DebugBodyCapture bool   `mapstructure:"debug_body_capture"`
+	RedactFields     string `mapstructure:"redact_fields"`
+
+	// Comma separated IPs or CIDR ranges of the proxies in front of the
+	// server. Only requests from these have their X-Forwarded-Proto and
+	// X-Forwarded-Host headers believed.
+	TrustedProxies string `mapstructure:"trusted_proxies"`
+
+	// Date (2006-01-02) the unversioned /api alias stops being served
+	LegacyApiSunset string `mapstructure:"legacy_api_sunset"`
+
+	// Answer every error as RFC 7807 application/problem+json, not only to
+	// clients that ask for it in Accept
+	ProblemJSON bool `mapstructure:"problem_json"`
+
+	// Requests each user, or each IP when signed out, may have running at
+	// once on an instance. 0 uses DEFAULT_USER_CONCURRENCY and a negative
+	// value turns the limit off.
+	UserConcurrency int `mapstructure:"user_concurrency"`
+}
+
+type DatabaseConfig struct {
+	Path string `mapstructure:"path"`
+
+	// A read-only replica serves reads from a file something else writes. It
+	// skips the startup check that the file is writable, and every write
+	// through gorm fails with database.ErrReadOnly instead.
+	ReadOnly bool `mapstructure:"read_only"`
+
+	// SQL migrations the schema is checked against on startup. Empty skips
+	// the check, for deployments that migrate some other way.
+	MigrationsDir string `mapstructure:"migrations_dir"`
+
+	// Backups are disabled when the directory is empty
+	BackupDir       string        `mapstructure:"backup_dir"`
+	BackupInterval  time.Duration `mapstructure:"backup_interval"`
+	BackupRetention int           `mapstructure:"backup_retention"`
+
+	// How many write-heavy requests each route group runs at once and how
+	// many more may wait, as comma separated group=slots:queue pairs, e.g.
+	// broadcast=2:8. Groups not listed get DEFAULT_WRITE_LIMIT_SLOTS and
+	// DEFAULT_WRITE_LIMIT_QUEUE.
+	WriteLimits string `mapstructure:"write_limits"`
+
+	// Driver picks the defaults of the connection pool settings left at 0,
+	// see DatabasePool. Only sqlite can be opened so far.
+	Driver          string        `mapstructure:"driver"`
+	MaxOpenConns    int           `mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `mapstructure:"conn_max_idle_time"`
+
+	// Caches a prepared statement per query on each connection
+	PrepareStmt bool `mapstructure:"prepare_stmt"`
+
+	// How often the sqlite file is checked with PRAGMA quick_check, with a
+	// full integrity_check once a week. 0 turns the checks off. A system
+	// alert is raised when the check fails or the write-ahead log grows
+	// past WalAlertMB, which usually wants a checkpoint.
+	IntegrityInterval time.Duration `mapstructure:"integrity_interval"`
+	WalAlertMB        int           `mapstructure:"wal_alert_mb"`
+
+	// Queries taking longer are logged at Warn, and those taking five times
+	// as long with their query plan. 0 uses DEFAULT_SLOW_QUERY_MS and a
+	// negative value turns slow query logging off.
+	SlowQueryMs int `mapstructure:"slow_query_ms"`
+
+	// How long a retired table renamed by the migration tool's drop-retired
+	// is kept before drop-retired --purge drops it
+	RetiredGracePeriod time.Duration `mapstructure:"retired_grace_period"`
+}
+
+// DatabasePool is the connection pool of the SQL database. A lifetime or idle
+// time of 0 keeps connections open for good.
+type DatabasePool struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// WriteLimit is the concurrency allowed to one group of write-heavy routes.
+type WriteLimit struct {
+	Slots int64
+	Queue int
+}
+
+type CacheConfig struct {
+	Address string `mapstructure:"address"`
+	Port    int    `mapstructure:"port"`
+}
+
+type SecurityConfig struct {
+	Salt      int    `mapstructure:"salt"`
+	Pepper    string `mapstructure:"pepper"`
+	JwtSecret string `mapstructure:"jwt_secret"`
+
+	// Tokens are signed with this issuer and audience, and tokens with any
+	// other are refused, so a service sharing the secret can't sign in here.
+	// JwtAudienceRelaxed also accepts tokens with no audience, as tokens
+	// signed before the audience was added have none; turn it off once they
+	// have expired.
+	JwtIssuer          string `mapstructure:"jwt_issuer"`
+	JwtAudience        string `mapstructure:"jwt_audience"`
+	JwtAudienceRelaxed bool   `mapstructure:"jwt_audience_relaxed"`
+
+	// Key for cookies signed with utils.WriteSignedCookie, falling back to
+	// the JWT secret when empty
+	CookieKey string `mapstructure:"cookie_key"`
+
+	MinPasswordScore int `mapstructure:"min_password_score"`
+
+	// Rotating the pepper: move the old one to SECURITY_PEPPER_PREVIOUS and
+	// bump the version. Passwords hashed with the previous pepper still verify
+	// and are rehashed on login; `migration rotate-pepper-status` reports how
+	// many are left before the previous pepper can be dropped.
+	PepperPrevious string `mapstructure:"pepper_previous"`
+	PepperVersion  int    `mapstructure:"pepper_version"`
+
+	// 32 byte base64 key encrypting sensitive columns, the password hashes,
+	// in the database file. Without it they are stored as plaintext; after
+	// setting it on an existing database, `migration encrypt-columns`
+	// encrypts the rows already there.
+	DataKey string `mapstructure:"data_key"`
+
+	// Log new users straight in; turn off when they must verify their email
+	RegistrationAutoLogin bool `mapstructure:"registration_auto_login"`
+
+	// Bot mitigation. The honeypot fakes success for forms that fill in the
+	// hidden website field; the form token makes registrations wait for a
+	// token from GET /users/form-token to be at least the minimum age.
+	// API-first clients may need either turned off.
+	HoneypotEnabled  bool          `mapstructure:"honeypot_enabled"`
+	FormTokenEnabled bool          `mapstructure:"form_token_enabled"`
+	FormTokenMinAge  time.Duration `mapstructure:"form_token_min_age"`
+
+	// Requests a minute each IP may make to the registration dry run, which
+	// reveals whether a login is taken. 0 uses DEFAULT_VALIDATE_RATE_LIMIT.
+	ValidateRateLimit int `mapstructure:"validate_rate_limit"`
+
+	// Which challenge, such as a CAPTCHA, risky logins must pass. Providers
+	// register a name with the user controller; "none" never asks for one.
+	ChallengeProvider string `mapstructure:"challenge_provider"`
+
+	// Soft limits on the users and unexpired sessions across all instances.
+	// Past them registrations and logins are refused with 507 while existing
+	// sessions carry on; admins are alerted at 80%. 0 doesn't limit.
+	MaxUsers         int `mapstructure:"max_users"`
+	MaxTotalSessions int `mapstructure:"max_total_sessions"`
+}
+
+type SessionConfig struct {
+	// SameSite mode of the session cookie: lax, strict or none. Embedded
+	// (iframe) clients need none, which also makes the cookie Secure.
+	CookieSameSite    string `mapstructure:"cookie_same_site"`
+	CookiePartitioned bool   `mapstructure:"cookie_partitioned"`
+
+	// How each X-Client-Type authenticates, as comma separated type=strategy
+	// pairs, e.g. tauri=token,admin-web=cookie. They add to or override the
+	// built in flutter=token and solid=cookie. Types not listed use
+	// UnknownClientType: a strategy, none to carry on unauthenticated, or
+	// reject to answer 400.
+	ClientTypes       string `mapstructure:"client_types"`
+	UnknownClientType string `mapstructure:"unknown_client_type"`
+
+	// The oldest X-Client-Version each client type may still use, as comma
+	// separated type=version pairs, e.g. flutter=2.3.0. Older clients are
+	// answered 426 and pointed at ClientUpgradeURL. Unlisted types aren't
+	// checked.
+	MinClientVersions string `mapstructure:"min_client_versions"`
+	ClientUpgradeURL  string `mapstructure:"client_upgrade_url"`
+
+	// How long after signing in a web session can still be extended with
+	// POST /users/session/extend
+	MaxLifetime time.Duration `mapstructure:"max_lifetime"`
+}
+
+type WebsocketConfig struct {
+	// Comma separated channels guests may subscribe to without logging in
+	PublicChannels string `mapstructure:"public_channels"`
+
+	// Draining on shutdown spreads clients' reconnects over the window and
+	// closes their connections this many at a time
+	DrainWindow    time.Duration `mapstructure:"drain_window"`
+	DrainBatchSize int           `mapstructure:"drain_batch_size"`
+
+	// What happens when a user connects again with a device ID one of their
+	// connections already has: replace closes the old one, reject the new
+	DuplicateConnections string `mapstructure:"duplicate_connections"`
+}
+
+type LoggingConfig struct {
+	ComponentLevels string `mapstructure:"component_levels"`
+}
+
+type AuditConfig struct {
+	// Audit entries older than the retention are moved to gzip files in the
+	// archive directory once a day. 0 keeps them forever.
+	RetentionDays int    `mapstructure:"retention_days"`
+	ArchiveDir    string `mapstructure:"archive_dir"`
 }
 
+type MaintenanceConfig struct {
+	// Start in maintenance mode. When off, the state an admin last set is
+	// restored from the cache.
+	Enabled bool   `mapstructure:"enabled"`
+	Message string `mapstructure:"message"`
+}
+
+type TracingConfig struct {
+	// OTLP/HTTP collector spans are sent to, e.g. http://collector:4318.
+	// Tracing is off when it is empty.
+	Endpoint string `mapstructure:"endpoint"`
+
+	// Share of new traces recorded, from 0 to 1. Traces continued from a
+	// caller's traceparent follow the caller's choice.
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+	ServiceName string  `mapstructure:"service_name"`
+}
+
+type WellKnownConfig struct {
+	// Page of the web client, under FrontendBaseURL, that
+	// /.well-known/change-password sends password managers to
+	ChangePasswordPath string `mapstructure:"change_password_path"`
+
+	// Fields of /.well-known/security.txt, which is only served once there
+	// is a contact. The contact is a mailto: or https: URI and the expiry a
+	// date (2006-01-02) or RFC 3339 time.
+	SecurityContact   string `mapstructure:"security_contact"`
+	SecurityExpires   string `mapstructure:"security_expires"`
+	SecurityPolicyURL string `mapstructure:"security_policy_url"`
+}
+
+const (
+	UNIX_LISTEN_PREFIX  = "unix://"
+	DEFAULT_SOCKET_MODE = os.FileMode(0o660)
+
+	// bcrypt cost when SECURITY_SALT is unset. Each step doubles the time a
+	// login spends hashing, so development stays cheap.
+	DEVELOPMENT_BCRYPT_COST    = 6
+	PRODUCTION_BCRYPT_COST     = 12
+	MIN_PRODUCTION_BCRYPT_COST = 10
+
+	DEFAULT_VALIDATE_RATE_LIMIT = 10
+
+	DEFAULT_USER_CONCURRENCY = 16
+
+	// Security.ChallengeProvider that never challenges a login
+	CHALLENGE_PROVIDER_NONE = "none"
+
+	DEFAULT_WAL_ALERT_MB = 64
+
+	DEFAULT_SLOW_QUERY_MS = 200
+
+	DEFAULT_WRITE_LIMIT_SLOTS = 2
+	DEFAULT_WRITE_LIMIT_QUEUE = 8
+
+	// Database.Driver values
+	DATABASE_DRIVER_SQLITE   = "sqlite"
+	DATABASE_DRIVER_POSTGRES = "postgres"
+
+	// Sessions have always been signed with this issuer, so tokens issued
+	// before it was configurable keep working
+	DEFAULT_JWT_ISSUER   = "app_api"
+	DEFAULT_JWT_AUDIENCE = "app_api"
+
+	DEFAULT_DIAGNOSTICS_PATH = "diagnostics/last_startup_failure.json"
+
+	DEFAULT_TRACING_SERVICE_NAME = "app_api"
+
+	DEFAULT_CHANGE_PASSWORD_PATH = "/profile"
+
+	// Ways a client type authenticates: the session cookie, or the token in
+	// the Authorization header. None never authenticates.
+	AUTH_STRATEGY_COOKIE = "cookie"
+	AUTH_STRATEGY_TOKEN  = "token"
+	AUTH_STRATEGY_NONE   = "none"
+
+	// Session.UnknownClientType that refuses unlisted client types
+	UNKNOWN_CLIENT_TYPE_REJECT = "reject"
+
+	DEFAULT_SESSION_MAX_LIFETIME = 30 * 24 * time.Hour
+
+	// Websocket.DuplicateConnections policies
+	DUPLICATE_CONNECTIONS_REPLACE = "replace"
+	DUPLICATE_CONNECTIONS_REJECT  = "reject"
+)
+
 var ConfigInstance Config
 
+// LegacyEnvAliases maps the environment variables whose names changed when
+// the config was split into sections to their old names. An old name is still
+// read when the new one is unset, and goes away in the next release.
+var LegacyEnvAliases = map[string]string{
+	"SERVER_CORS_ALLOW_ORIGINS": "CORS_ALLOW_ORIGINS",
+	"DATABASE_PATH":             "DB_PATH",
+	"DATABASE_BACKUP_DIR":       "DB_BACKUP_DIR",
+	"DATABASE_BACKUP_INTERVAL":  "DB_BACKUP_INTERVAL",
+	"DATABASE_BACKUP_RETENTION": "DB_BACKUP_RETENTION",
+	"CACHE_ADDRESS":             "DB_CACHE_ADDRESS",
+	"CACHE_PORT":                "DB_CACHE_PORT",
+	"LOGGING_COMPONENT_LEVELS":  "LOG_COMPONENT_LEVELS",
+}
+
+// setDefaults registers fallback values for optional settings.
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("diagnostics_path", DEFAULT_DIAGNOSTICS_PATH)
+	v.SetDefault("terms_version", "")
+	v.SetDefault("frontend_base_url", "")
+	v.SetDefault("database.read_only", false)
+	v.SetDefault("database.migrations_dir", "cmd/migration/migrations")
+	v.SetDefault("database.backup_dir", "data/backups")
+	v.SetDefault("database.backup_interval", "24h")
+	v.SetDefault("database.backup_retention", 7)
+	v.SetDefault("database.write_limits", "")
+	v.SetDefault("database.driver", DATABASE_DRIVER_SQLITE)
+	v.SetDefault("database.max_open_conns", 0)
+	v.SetDefault("database.max_idle_conns", 0)
+	v.SetDefault("database.conn_max_lifetime", "0s")
+	v.SetDefault("database.conn_max_idle_time", "0s")
+	v.SetDefault("database.prepare_stmt", true)
+	v.SetDefault("database.integrity_interval", "1h")
+	v.SetDefault("database.wal_alert_mb", DEFAULT_WAL_ALERT_MB)
+	v.SetDefault("database.slow_query_ms", DEFAULT_SLOW_QUERY_MS)
+	v.SetDefault("database.retired_grace_period", "720h")
+	v.SetDefault("audit.retention_days", 365)
+	v.SetDefault("audit.archive_dir", "data/audit")
+	v.SetDefault("security.cookie_key", "")
+	v.SetDefault("security.jwt_issuer", DEFAULT_JWT_ISSUER)
+	v.SetDefault("security.jwt_audience", DEFAULT_JWT_AUDIENCE)
+	v.SetDefault("security.jwt_audience_relaxed", false)
+	v.SetDefault("security.min_password_score", 2)
+	v.SetDefault("security.pepper_previous", "")
+	v.SetDefault("security.data_key", "")
+	v.SetDefault("security.pepper_version", 1)
+	v.SetDefault("security.registration_auto_login", true)
+	v.SetDefault("security.honeypot_enabled", true)
+	v.SetDefault("security.form_token_enabled", true)
+	v.SetDefault("security.form_token_min_age", "3s")
+	v.SetDefault("security.validate_rate_limit", DEFAULT_VALIDATE_RATE_LIMIT)
+	v.SetDefault("security.challenge_provider", CHALLENGE_PROVIDER_NONE)
+	v.SetDefault("security.max_users", 0)
+	v.SetDefault("security.max_total_sessions", 0)
+	v.SetDefault("session.cookie_same_site", "lax")
+	v.SetDefault("session.cookie_partitioned", false)
+	v.SetDefault("session.client_types", "")
+	v.SetDefault("session.unknown_client_type", AUTH_STRATEGY_NONE)
+	v.SetDefault("session.min_client_versions", "")
+	v.SetDefault("session.client_upgrade_url", "")
+	v.SetDefault("session.max_lifetime", "720h")
+	v.SetDefault("server.debug_body_capture", false)
+	v.SetDefault("server.redact_fields", "password,token,secret,authorization")
+	v.SetDefault("server.listen", "")
+	v.SetDefault("server.socket_mode", "0660")
+	v.SetDefault("server.trusted_proxies", "")
+	v.SetDefault("server.legacy_api_sunset", "2027-04-01")
+	v.SetDefault("server.problem_json", false)
+	v.SetDefault("server.user_concurrency", DEFAULT_USER_CONCURRENCY)
+	v.SetDefault("logging.component_levels", "")
+	v.SetDefault("websocket.public_channels", "")
+	v.SetDefault("websocket.drain_window", "20s")
+	v.SetDefault("websocket.drain_batch_size", 100)
+	v.SetDefault("websocket.duplicate_connections", DUPLICATE_CONNECTIONS_REPLACE)
+	v.SetDefault("maintenance.enabled", false)
+	v.SetDefault("maintenance.message", "")
+	v.SetDefault("tracing.endpoint", "")
+	v.SetDefault("tracing.sample_ratio", 1.0)
+	v.SetDefault("tracing.service_name", DEFAULT_TRACING_SERVICE_NAME)
+	v.SetDefault("well_known.change_password_path", DEFAULT_CHANGE_PASSWORD_PATH)
+	v.SetDefault("well_known.security_contact", "")
+	v.SetDefault("well_known.security_expires", "")
+	v.SetDefault("well_known.security_policy_url", "")
+}
+
+// setting is a key of Config and the environment variable it is read from.
+type setting struct {
+	key string
+	env string
+}
+
+// settings lists every key of Config. A section's keys are prefixed with the
+// section's, so Server.Port is server.port and read from SERVER_PORT.
+func settings() []setting {
+	var all []setting
+	configType := reflect.TypeOf(Config{})
+	for i := range configType.NumField() {
+		field := configType.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if field.Type.Kind() != reflect.Struct {
+			all = append(all, setting{key: name, env: strings.ToUpper(name)})
+			continue
+		}
+		for j := range field.Type.NumField() {
+			key := field.Type.Field(j).Tag.Get("mapstructure")
+			all = append(all, setting{key: name + "." + key, env: strings.ToUpper(name + "_" + key)})
+		}
+	}
+	return all
+}
+
+// Values is every setting by its key, e.g. server.port, with durations
+// written the way they are configured. Secrets are in it, so it must be
+// redacted before it is written anywhere.
+func (c Config) Values() map[string]any {
+	values := make(map[string]any)
+	add := func(key string, value reflect.Value) {
+		if duration, ok := value.Interface().(time.Duration); ok {
+			values[key] = duration.String()
+			return
+		}
+		values[key] = value.Interface()
+	}
+
+	configValue := reflect.ValueOf(c)
+	for i := range configValue.NumField() {
+		field, name := configValue.Field(i), configValue.Type().Field(i).Tag.Get("mapstructure")
+		if field.Kind() != reflect.Struct {
+			add(name, field)
+			continue
+		}
+		for j := range field.NumField() {
+			add(name+"."+field.Type().Field(j).Tag.Get("mapstructure"), field.Field(j))
+		}
+	}
+	return values
+}
+
+// bindSettings makes each setting come from its environment variable, then
+// the .env file, then its default. The legacy name, if any, is tried after
+// the current one at each step.
+func bindSettings(v *viper.Viper, envFile *viper.Viper, log logger.Logger) error {
+	for _, setting := range settings() {
+		names := []string{setting.env}
+		if legacy, ok := LegacyEnvAliases[setting.env]; ok {
+			names = append(names, legacy)
+			if _, set := os.LookupEnv(legacy); set || envFile.IsSet(legacy) {
+				log.Warn("Deprecated setting name, rename it", "name", legacy, "rename", setting.env)
+			}
+		}
+
+		if err := v.BindEnv(append([]string{setting.key}, names...)...); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if envFile.IsSet(name) {
+				v.SetDefault(setting.key, envFile.Get(name))
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// applyEnvironmentOverrides turns off settings that must never run in
+// production, whatever the environment asked for.
+func applyEnvironmentOverrides(config Config, log logger.Logger) Config {
+	if config.Environment == "production" && config.Server.DebugBodyCapture {
+		log.Warn("Debug body capture is not allowed in production, disabling")
+		config.Server.DebugBodyCapture = false
+	}
+	return config
+}
+
+// DebugBodyCaptureEnabled reports whether redacted bodies may be attached to
+// request logs. It is always false in production.
+func (c Config) DebugBodyCaptureEnabled() bool {
+	return c.Server.DebugBodyCapture && c.Environment != "production"
+}
+
+// PepperVersion is the version recorded on passwords hashed with the current
+// pepper. Versions start at 1.
+func (c Config) PepperVersion() int {
+	return max(c.Security.PepperVersion, 1)
+}
+
+// UserConcurrency is how many requests each user may have running at once,
+// or 0 when they aren't limited.
+func (c Config) UserConcurrency() int {
+	switch {
+	case c.Server.UserConcurrency < 0:
+		return 0
+	case c.Server.UserConcurrency == 0:
+		return DEFAULT_USER_CONCURRENCY
+	}
+	return c.Server.UserConcurrency
+}
+
+// ValidateRateLimit is how many registration dry runs each IP may make a
+// minute.
+func (c Config) ValidateRateLimit() int {
+	if c.Security.ValidateRateLimit > 0 {
+		return c.Security.ValidateRateLimit
+	}
+	return DEFAULT_VALIDATE_RATE_LIMIT
+}
+
+// SessionMaxLifetime bounds how long after signing in a web session can be
+// extended.
+func (c Config) SessionMaxLifetime() time.Duration {
+	if c.Session.MaxLifetime > 0 {
+		return c.Session.MaxLifetime
+	}
+	return DEFAULT_SESSION_MAX_LIFETIME
+}
+
+// JwtIssuer is the issuer tokens are signed with and must carry.
+func (c Config) JwtIssuer() string {
+	if c.Security.JwtIssuer != "" {
+		return c.Security.JwtIssuer
+	}
+	return DEFAULT_JWT_ISSUER
+}
+
+// JwtAudience is the audience tokens are signed with and must carry.
+func (c Config) JwtAudience() string {
+	if c.Security.JwtAudience != "" {
+		return c.Security.JwtAudience
+	}
+	return DEFAULT_JWT_AUDIENCE
+}
+
+// CookieSigningKey is the key signed cookies are signed with:
+// Security.CookieKey when set, otherwise the JWT secret.
+func (c Config) CookieSigningKey() string {
+	if c.Security.CookieKey != "" {
+		return c.Security.CookieKey
+	}
+	return c.Security.JwtSecret
+}
+
+// BcryptCost is the cost passwords are hashed with: Security.Salt when set,
+// otherwise the default for the environment.
+func (c Config) BcryptCost() int {
+	if c.Security.Salt > 0 {
+		return c.Security.Salt
+	}
+	if c.Environment == "production" {
+		return PRODUCTION_BCRYPT_COST
+	}
+	return DEVELOPMENT_BCRYPT_COST
+}
+
+// SlowQueryThreshold is how long a query may take before it is logged as
+// slow, or 0 when slow queries aren't logged.
+func (c Config) SlowQueryThreshold() time.Duration {
+	switch {
+	case c.Database.SlowQueryMs < 0:
+		return 0
+	case c.Database.SlowQueryMs == 0:
+		return DEFAULT_SLOW_QUERY_MS * time.Millisecond
+	}
+	return time.Duration(c.Database.SlowQueryMs) * time.Millisecond
+}
+
+// AuditRetention is how long audit entries stay in the table before they are
+// archived, or 0 when they are never purged.
+func (c Config) AuditRetention() time.Duration {
+	return time.Duration(c.Audit.RetentionDays) * 24 * time.Hour
+}
+
+// SocketPath is the unix socket to listen on, or "" to listen on Server.Port.
+func (c Config) SocketPath() string {
+	path, _ := strings.CutPrefix(c.Server.Listen, UNIX_LISTEN_PREFIX)
+	return path
+}
+
+// SocketMode is the permissions the unix socket is created with.
+func (c Config) SocketMode() os.FileMode {
+	mode, err := strconv.ParseUint(c.Server.SocketMode, 8, 32)
+	if err != nil || c.Server.SocketMode == "" {
+		return DEFAULT_SOCKET_MODE
+	}
+	return os.FileMode(mode)
+}
+
+// TrustedProxies splits Server.TrustedProxies into the list Fiber expects.
+func (c Config) TrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(c.Server.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// ClientTypes parses Session.ClientTypes into the strategy of each client
+// type listed.
+func (c Config) ClientTypes() (map[string]string, error) {
+	clientTypes := make(map[string]string)
+	for _, pair := range strings.Split(c.Session.ClientTypes, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		clientType, strategy, ok := strings.Cut(pair, "=")
+		clientType, strategy = strings.TrimSpace(clientType), strings.ToLower(strings.TrimSpace(strategy))
+		if !ok || clientType == "" {
+			return nil, fmt.Errorf("invalid client type %q: expected type=strategy", pair)
+		}
+		if strategy != AUTH_STRATEGY_COOKIE && strategy != AUTH_STRATEGY_TOKEN {
+			return nil, fmt.Errorf("invalid strategy %q for client type %q: expected cookie or token", strategy, clientType)
+		}
+		clientTypes[clientType] = strategy
+	}
+	return clientTypes, nil
+}
+
+// DataKey is the key encrypting sensitive columns, nil when none is set.
+func (c Config) DataKey() []byte {
+	if c.Security.DataKey == "" {
+		return nil
+	}
+	// Validated at startup
+	key, err := encryption.ParseKey(c.Security.DataKey)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// MinClientVersions parses Session.MinClientVersions into the oldest version
+// of each client type listed.
+func (c Config) MinClientVersions() (map[string]semver.Version, error) {
+	versions := make(map[string]semver.Version)
+	for _, pair := range strings.Split(c.Session.MinClientVersions, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		clientType, version, ok := strings.Cut(pair, "=")
+		clientType, version = strings.TrimSpace(clientType), strings.TrimSpace(version)
+		if !ok || clientType == "" {
+			return nil, fmt.Errorf("invalid minimum client version %q: expected type=version", pair)
+		}
+		parsed, err := semver.Parse(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum version %q for client type %q: %w", version, clientType, err)
+		}
+		versions[clientType] = parsed
+	}
+	return versions, nil
+}
+
+// DefaultDatabasePools are the pool settings of each driver. sqlite takes one
+// writer at a time, so more connections only queue on its lock; the one
+// connection is kept for good so its prepared statements are reused.
+var DefaultDatabasePools = map[string]DatabasePool{
+	DATABASE_DRIVER_SQLITE: {MaxOpenConns: 1, MaxIdleConns: 1},
+	DATABASE_DRIVER_POSTGRES: {
+		MaxOpenConns:    25,
+		MaxIdleConns:    10,
+		ConnMaxLifetime: 30 * time.Minute,
+		ConnMaxIdleTime: 5 * time.Minute,
+	},
+}
+
+// DatabaseDriver is Database.Driver, sqlite when it is unset.
+func (c Config) DatabaseDriver() string {
+	if c.Database.Driver == "" {
+		return DATABASE_DRIVER_SQLITE
+	}
+	return c.Database.Driver
+}
+
+// DatabasePool is the pool configured for Database.Driver, with the driver's
+// defaults in place of the settings left at 0.
+func (c Config) DatabasePool() DatabasePool {
+	database := c.Database
+	pool := DefaultDatabasePools[c.DatabaseDriver()]
+	if database.MaxOpenConns > 0 {
+		pool.MaxOpenConns = database.MaxOpenConns
+	}
+	if database.MaxIdleConns > 0 {
+		pool.MaxIdleConns = database.MaxIdleConns
+	}
+	if database.ConnMaxLifetime > 0 {
+		pool.ConnMaxLifetime = database.ConnMaxLifetime
+	}
+	if database.ConnMaxIdleTime > 0 {
+		pool.ConnMaxIdleTime = database.ConnMaxIdleTime
+	}
+	return pool
+}
+
+// WriteLimits parses Database.WriteLimits by group.
+func (c Config) WriteLimits() (map[string]WriteLimit, error) {
+	limits := make(map[string]WriteLimit)
+	for _, pair := range strings.Split(c.Database.WriteLimits, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		group, limit, ok := strings.Cut(pair, "=")
+		group = strings.TrimSpace(group)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("invalid write limit %q: expected group=slots:queue", pair)
+		}
+		slots, queue, hasQueue := strings.Cut(limit, ":")
+		parsed := WriteLimit{Queue: DEFAULT_WRITE_LIMIT_QUEUE}
+		var err error
+		if parsed.Slots, err = strconv.ParseInt(strings.TrimSpace(slots), 10, 64); err != nil || parsed.Slots < 1 {
+			return nil, fmt.Errorf("invalid slots %q for write group %q", slots, group)
+		}
+		if hasQueue {
+			if parsed.Queue, err = strconv.Atoi(strings.TrimSpace(queue)); err != nil || parsed.Queue < 0 {
+				return nil, fmt.Errorf("invalid queue %q for write group %q", queue, group)
+			}
+		}
+		limits[group] = parsed
+	}
+	return limits, nil
+}
+
+// WriteLimit is the limit for group, or the default when it isn't
+// configured.
+func (c Config) WriteLimit(group string) WriteLimit {
+	limits, _ := c.WriteLimits()
+	if limit, ok := limits[group]; ok {
+		return limit
+	}
+	return WriteLimit{Slots: DEFAULT_WRITE_LIMIT_SLOTS, Queue: DEFAULT_WRITE_LIMIT_QUEUE}
+}
+
+// UnknownClientType is what happens to client types that aren't registered:
+// a strategy, AUTH_STRATEGY_NONE or UNKNOWN_CLIENT_TYPE_REJECT.
+func (c Config) UnknownClientType() string {
+	if c.Session.UnknownClientType == "" {
+		return AUTH_STRATEGY_NONE
+	}
+	return strings.ToLower(c.Session.UnknownClientType)
+}
+
+// DuplicateConnections is the policy for a second websocket connection with
+// the same user and device ID, DUPLICATE_CONNECTIONS_REPLACE by default.
+func (c Config) DuplicateConnections() string {
+	if c.Websocket.DuplicateConnections == "" {
+		return DUPLICATE_CONNECTIONS_REPLACE
+	}
+	return strings.ToLower(c.Websocket.DuplicateConnections)
+}
+
+// LegacyAPISunset is the day the unversioned /api alias goes away, if one has
+// been announced.
+func (c Config) LegacyAPISunset() (time.Time, bool) {
+	if c.Server.LegacyApiSunset == "" {
+		return time.Time{}, false
+	}
+	sunset, err := time.Parse(time.DateOnly, c.Server.LegacyApiSunset)
+	return sunset, err == nil
+}
+
+// SecurityTxtExpires is when the security.txt contact details go stale, if
+// an expiry is configured. A date expires at its start, in UTC.
+func (c Config) SecurityTxtExpires() (time.Time, bool) {
+	expires := c.WellKnown.SecurityExpires
+	if expires == "" {
+		return time.Time{}, false
+	}
+	if parsed, err := time.Parse(time.DateOnly, expires); err == nil {
+		return parsed, true
+	}
+	parsed, err := time.Parse(time.RFC3339, expires)
+	return parsed, err == nil
+}
+
 func InitConfig() (Config, error) {
 	log := logger.New("config").Function("InitConfig")
 	log.Info("Initializing config")
 
-	viper.SetConfigFile(".env")
-	viper.SetConfigType("env")
+	config, err := read(log)
+	if err != nil {
+		return Config{}, err
+	}
+
+	log.Info("Successfully initialized config", "config", config)
+	if err := validateConfig(config, log); err != nil {
+		return config, err
+	}
+	applyLogging(config)
 
-	if err := viper.ReadInConfig(); err != nil {
+	return config, nil
+}
+
+// read loads the config from the environment, the .env file and the
+// defaults, without validating it.
+func read(log logger.Logger) (Config, error) {
+	v := viper.New()
+	setDefaults(v)
+
+	envFile := viper.New()
+	envFile.SetConfigFile(".env")
+	envFile.SetConfigType("env")
+	if err := envFile.ReadInConfig(); err != nil {
 		log.Warn("Could not find .env file", "error", err)
 	}
 
-	viper.AutomaticEnv()
+	if err := bindSettings(v, envFile, log); err != nil {
+		return Config{}, log.Err("Fatal error: could not bind config", err)
+	}
 
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	if err := v.Unmarshal(&config); err != nil {
 		return Config{}, log.Err("Fatal error: could not unmarshal config", err)
 	}
+	return applyEnvironmentOverrides(config, log), nil
+}
 
-	log.Info("Successfully initialized config", "config", config)
-	return config, validateConfig(config, log)
+// applyLogging sets the per-component log levels of a validated config.
+func applyLogging(config Config) {
+	// Already validated, so the parse can't fail here.
+	levels, _ := logger.ParseComponentLevels(config.Logging.ComponentLevels)
+	logger.SetComponentLevels(levels)
 }
 
 func GetConfig() Config {
 	return ConfigInstance
 }
 
+// SectionError is a setting that failed validation, named by the section it
+// belongs to.
+type SectionError struct {
+	Section string
+	Err     error
+}
+
+func (e *SectionError) Error() string {
+	return fmt.Sprintf("%s config: %v", e.Section, e.Err)
+}
+
+func (e *SectionError) Unwrap() error {
+	return e.Err
+}
+
+// sectionValidators check one section each, in order. Warnings are logged
+// and don't fail validation.
+var sectionValidators = []struct {
+	section  string
+	validate func(config Config, log logger.Logger) error
+}{
+	{"server", validateServer},
+	{"database", validateDatabase},
+	{"security", validateSecurity},
+	{"session", validateSession},
+	{"websocket", validateWebsocket},
+	{"logging", validateLogging},
+	{"audit", validateAudit},
+	{"tracing", validateTracing},
+	{"well_known", validateWellKnown},
+}
+
 func validateConfig(config Config, log logger.Logger) error {
-	if config.ServerPort <= 0 {
-		return log.Err(
-			"Fatal error: invalid server port",
-			fmt.Errorf("invalid port: %d", config.ServerPort),
-			"port", config.ServerPort,
-		)
+	if err := validateSections(config, log); err != nil {
+		return err
 	}
 
 	ConfigInstance = config
 	return nil
 }
+
+func validateSections(config Config, log logger.Logger) error {
+	for _, validator := range sectionValidators {
+		if err := validator.validate(config, log); err != nil {
+			return log.Err(
+				"Fatal error: invalid config",
+				&SectionError{Section: validator.section, Err: err},
+				"section", validator.section,
+			)
+		}
+	}
+	return nil
+}
+
+func validateServer(config Config, log logger.Logger) error {
+	server := config.Server
+	if server.Listen != "" {
+		if !strings.HasPrefix(server.Listen, UNIX_LISTEN_PREFIX) || config.SocketPath() == "" {
+			return fmt.Errorf("invalid listen target: expected %s<path>, got %q", UNIX_LISTEN_PREFIX, server.Listen)
+		}
+	} else if server.Port <= 0 {
+		return fmt.Errorf("invalid port: %d", server.Port)
+	}
+
+	if server.SocketMode != "" {
+		if mode, err := strconv.ParseUint(server.SocketMode, 8, 32); err != nil || mode > 0o777 {
+			return fmt.Errorf("invalid socket mode: expected octal permissions, got %q", server.SocketMode)
+		}
+	}
+
+	for _, proxy := range config.TrustedProxies() {
+		if net.ParseIP(proxy) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			return fmt.Errorf("invalid trusted proxy: not an IP or CIDR range: %q", proxy)
+		}
+	}
+
+	if server.LegacyApiSunset != "" {
+		if _, err := time.Parse(time.DateOnly, server.LegacyApiSunset); err != nil {
+			return fmt.Errorf("invalid legacy API sunset date: %w", err)
+		}
+	}
+	return nil
+}
+
+func validateDatabase(config Config, log logger.Logger) error {
+	if _, err := config.WriteLimits(); err != nil {
+		return err
+	}
+
+	database := config.Database
+	if _, ok := DefaultDatabasePools[config.DatabaseDriver()]; !ok {
+		return fmt.Errorf("invalid database driver %q: expected sqlite or postgres", database.Driver)
+	}
+	if database.MaxOpenConns < 0 || database.MaxIdleConns < 0 {
+		return fmt.Errorf("invalid database connection limits: %d open, %d idle",
+			database.MaxOpenConns, database.MaxIdleConns)
+	}
+	if database.ConnMaxLifetime < 0 || database.ConnMaxIdleTime < 0 {
+		return fmt.Errorf("invalid database connection lifetime %s or idle time %s",
+			database.ConnMaxLifetime, database.ConnMaxIdleTime)
+	}
+	if database.IntegrityInterval < 0 {
+		return fmt.Errorf("invalid database integrity interval: %s", database.IntegrityInterval)
+	}
+	if database.WalAlertMB < 0 {
+		return fmt.Errorf("invalid WAL alert size: %d MB", database.WalAlertMB)
+	}
+	if database.RetiredGracePeriod < 0 {
+		return fmt.Errorf("invalid retired table grace period: %s", database.RetiredGracePeriod)
+	}
+
+	if database.BackupDir == "" {
+		return nil
+	}
+	if database.BackupInterval <= 0 {
+		return fmt.Errorf("invalid backup interval: %s", database.BackupInterval)
+	}
+	if database.BackupRetention < 1 {
+		return fmt.Errorf("invalid backup retention: %d", database.BackupRetention)
+	}
+	return nil
+}
+
+func validateSecurity(config Config, log logger.Logger) error {
+	security := config.Security
+
+	// Tokens expire after an hour, so a longer minimum could never pass.
+	if security.FormTokenMinAge < 0 || security.FormTokenMinAge >= time.Hour {
+		return fmt.Errorf("invalid form token minimum age: %s", security.FormTokenMinAge)
+	}
+
+	if security.ValidateRateLimit < 0 {
+		return fmt.Errorf("invalid validate rate limit: %d", security.ValidateRateLimit)
+	}
+
+	if security.PepperVersion < 0 {
+		return fmt.Errorf("invalid pepper version: %d", security.PepperVersion)
+	}
+	if security.PepperPrevious != "" && security.PepperPrevious == security.Pepper {
+		return errors.New("previous pepper is the same as the current one")
+	}
+
+	if security.DataKey != "" {
+		if _, err := encryption.ParseKey(security.DataKey); err != nil {
+			return fmt.Errorf("invalid data key: %w", err)
+		}
+	}
+
+	if config.Environment == "production" && config.BcryptCost() < MIN_PRODUCTION_BCRYPT_COST {
+		log.Warn(
+			"bcrypt cost is too low for production",
+			"cost", config.BcryptCost(),
+			"minimum", MIN_PRODUCTION_BCRYPT_COST,
+		)
+	}
+	return nil
+}
+
+func validateSession(config Config, log logger.Logger) error {
+	switch strings.ToLower(config.Session.CookieSameSite) {
+	case "", "lax", "strict":
+	case "none":
+		if config.Environment != "production" {
+			log.Warn(
+				"SameSite=None session cookies are Secure and are dropped over plain HTTP",
+				"environment", config.Environment,
+			)
+		}
+	default:
+		return fmt.Errorf("invalid cookie SameSite mode: %q", config.Session.CookieSameSite)
+	}
+
+	if _, err := config.ClientTypes(); err != nil {
+		return err
+	}
+	switch config.UnknownClientType() {
+	case AUTH_STRATEGY_COOKIE, AUTH_STRATEGY_TOKEN, AUTH_STRATEGY_NONE, UNKNOWN_CLIENT_TYPE_REJECT:
+	default:
+		return fmt.Errorf("invalid unknown client type handling: %q", config.Session.UnknownClientType)
+	}
+	minVersions, err := config.MinClientVersions()
+	if err != nil {
+		return err
+	}
+	if len(minVersions) > 0 && config.Session.ClientUpgradeURL == "" {
+		log.Warn("Clients below their minimum version get no upgrade URL, set SESSION_CLIENT_UPGRADE_URL")
+	}
+	if config.Session.MaxLifetime < 0 {
+		return fmt.Errorf("invalid max lifetime %s", config.Session.MaxLifetime)
+	}
+	return nil
+}
+
+func validateWebsocket(config Config, log logger.Logger) error {
+	websocket := config.Websocket
+	if websocket.DrainWindow < 0 || websocket.DrainBatchSize < 0 {
+		return fmt.Errorf("invalid drain window %s or batch size %d", websocket.DrainWindow, websocket.DrainBatchSize)
+	}
+	switch config.DuplicateConnections() {
+	case DUPLICATE_CONNECTIONS_REPLACE, DUPLICATE_CONNECTIONS_REJECT:
+	default:
+		return fmt.Errorf("invalid duplicate connections policy: %q", websocket.DuplicateConnections)
+	}
+	return nil
+}
+
+func validateLogging(config Config, log logger.Logger) error {
+	if _, err := logger.ParseComponentLevels(config.Logging.ComponentLevels); err != nil {
+		return fmt.Errorf("invalid component levels: %w", err)
+	}
+	return nil
+}
+
+func validateAudit(config Config, log logger.Logger) error {
+	audit := config.Audit
+	if audit.RetentionDays < 0 || (audit.RetentionDays > 0 && audit.ArchiveDir == "") {
+		return fmt.Errorf("invalid retention %d days with archive dir %q", audit.RetentionDays, audit.ArchiveDir)
+	}
+	return nil
+}
+
+func validateTracing(config Config, log logger.Logger) error {
+	tracing := config.Tracing
+	if tracing.SampleRatio < 0 || tracing.SampleRatio > 1 {
+		return fmt.Errorf("invalid tracing sample ratio: %g", tracing.SampleRatio)
+	}
+	if tracing.Endpoint == "" {
+		return nil
+	}
+	endpoint, err := url.Parse(tracing.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("invalid tracing endpoint %q: expected an http(s) URL", tracing.Endpoint)
+	}
+	return nil
+}
+
+func validateWellKnown(config Config, log logger.Logger) error {
+	if config.FrontendBaseURL != "" && !absoluteHTTPURL(config.FrontendBaseURL) {
+		return fmt.Errorf("invalid frontend base URL %q: expected an absolute http(s) URL", config.FrontendBaseURL)
+	}
+	wellKnown := config.WellKnown
+	if wellKnown.ChangePasswordPath != "" && !strings.HasPrefix(wellKnown.ChangePasswordPath, "/") {
+		return fmt.Errorf("invalid change password path %q: expected it to start with /", wellKnown.ChangePasswordPath)
+	}
+
+	if wellKnown.SecurityContact == "" {
+		return nil
+	}
+	contact, err := url.Parse(wellKnown.SecurityContact)
+	if err != nil || (contact.Scheme != "mailto" && contact.Scheme != "https" && contact.Scheme != "tel") {
+		return fmt.Errorf("invalid security contact %q: expected a mailto:, https: or tel: URI", wellKnown.SecurityContact)
+	}
+	if wellKnown.SecurityExpires == "" {
+		return errors.New("security contact is set without an expiry, which security.txt requires")
+	}
+	expires, ok := config.SecurityTxtExpires()
+	if !ok {
+		return fmt.Errorf("invalid security expiry %q: expected a date or RFC 3339 time", wellKnown.SecurityExpires)
+	}
+	if expires.Before(time.Now()) {
+		log.Warn("security.txt has expired, move the expiry on", "expires", wellKnown.SecurityExpires)
+	}
+	if wellKnown.SecurityPolicyURL != "" && !absoluteHTTPURL(wellKnown.SecurityPolicyURL) {
+		return fmt.Errorf("invalid security policy URL %q: expected an absolute http(s) URL", wellKnown.SecurityPolicyURL)
+	}
+	return nil
+}
+
+// absoluteHTTPURL reports whether raw is an http or https URL with a host.
+func absoluteHTTPURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
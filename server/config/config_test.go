@@ -14,16 +14,22 @@ func TestValidateConfig_Success(t *testing.T) {
 	log := logger.New("test")
 
 	validConfig := Config{
-		GeneralVersion:       "1.0.0",
-		Environment:          "development",
-		ServerPort:           8280,
-		DatabaseDbPath:       "data/app.db",
-		DatabaseCacheAddress: "localhost",
-		DatabaseCachePort:    6379,
-		CorsAllowOrigins:     "http://localhost:3010",
-		SecuritySalt:         12,
-		SecurityPepper:       "test-pepper-value",
-		SecurityJwtSecret:    "test-jwt-secret-key",
+		GeneralVersion: "1.0.0",
+		Environment:    "development",
+		Server: ServerConfig{
+			Port:             8280,
+			CorsAllowOrigins: "http://localhost:3010",
+		},
+		Database: DatabaseConfig{Path: "data/app.db"},
+		Cache: CacheConfig{
+			Address: "localhost",
+			Port:    6379,
+		},
+		Security: SecurityConfig{
+			Salt:      12,
+			Pepper:    "test-pepper-value",
+			JwtSecret: "test-jwt-secret-key",
+		},
 	}
 
 	err := validateConfig(validConfig, log)
@@ -37,7 +43,7 @@ func TestValidateConfig_MinimalValidConfig(t *testing.T) {
 	log := logger.New("test")
 
 	minimalConfig := Config{
-		ServerPort: 1, // Minimum valid port
+		Server: ServerConfig{Port: 1}, // Minimum valid port
 	}
 
 	err := validateConfig(minimalConfig, log)
@@ -50,16 +56,22 @@ func TestValidateConfig_TypicalProductionConfig(t *testing.T) {
 	log := logger.New("test")
 
 	prodConfig := Config{
-		GeneralVersion:       "1.2.3",
-		Environment:          "production",
-		ServerPort:           80,
-		DatabaseDbPath:       "/var/lib/app/database.db",
-		DatabaseCacheAddress: "redis.example.com",
-		DatabaseCachePort:    6379,
-		CorsAllowOrigins:     "https://app.example.com,https://api.example.com",
-		SecuritySalt:         16,
-		SecurityPepper:       "xytcAjFSNIYlKE48UW1Rwub7iUspR3GVv85lWtfjNe0=",
-		SecurityJwtSecret:    "super-secret-production-jwt-key-that-is-very-long",
+		GeneralVersion: "1.2.3",
+		Environment:    "production",
+		Server: ServerConfig{
+			Port:             80,
+			CorsAllowOrigins: "https://app.example.com,https://api.example.com",
+		},
+		Database: DatabaseConfig{Path: "/var/lib/app/database.db"},
+		Cache: CacheConfig{
+			Address: "redis.example.com",
+			Port:    6379,
+		},
+		Security: SecurityConfig{
+			Salt:      16,
+			Pepper:    "xytcAjFSNIYlKE48UW1Rwub7iUspR3GVv85lWtfjNe0=",
+			JwtSecret: "super-secret-production-jwt-key-that-is-very-long",
+		},
 	}
 
 	err := validateConfig(prodConfig, log)
@@ -83,7 +95,7 @@ func TestValidateConfig_HighPortNumbers(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			config := Config{
-				ServerPort: tc.port,
+				Server: ServerConfig{Port: tc.port},
 			}
 
 			err := validateConfig(config, log)
@@ -108,7 +120,7 @@ func TestValidateConfig_DifferentEnvironments(t *testing.T) {
 		t.Run("env_"+env, func(t *testing.T) {
 			config := Config{
 				Environment: env,
-				ServerPort:  8080,
+				Server:      ServerConfig{Port: 8080},
 			}
 
 			err := validateConfig(config, log)
@@ -133,13 +145,13 @@ func TestValidateConfig_DifferentDatabasePaths(t *testing.T) {
 	for i, path := range dbPaths {
 		t.Run("db_path_"+string(rune(i)), func(t *testing.T) {
 			config := Config{
-				DatabaseDbPath: path,
-				ServerPort:     8080,
+				Database: DatabaseConfig{Path: path},
+				Server:   ServerConfig{Port: 8080},
 			}
 
 			err := validateConfig(config, log)
 			assert.NoError(t, err)
-			assert.Equal(t, path, ConfigInstance.DatabaseDbPath)
+			assert.Equal(t, path, ConfigInstance.Database.Path)
 		})
 	}
 }
@@ -158,13 +170,15 @@ func TestValidateConfig_CORSOrigins(t *testing.T) {
 	for i, cors := range corsConfigs {
 		t.Run("cors_"+string(rune(i)), func(t *testing.T) {
 			config := Config{
-				CorsAllowOrigins: cors,
-				ServerPort:       8080,
+				Server: ServerConfig{
+					CorsAllowOrigins: cors,
+					Port:             8080,
+				},
 			}
 
 			err := validateConfig(config, log)
 			assert.NoError(t, err)
-			assert.Equal(t, cors, ConfigInstance.CorsAllowOrigins)
+			assert.Equal(t, cors, ConfigInstance.Server.CorsAllowOrigins)
 		})
 	}
 }
@@ -201,17 +215,19 @@ func TestValidateConfig_SecurityConfiguration(t *testing.T) {
 	for _, sc := range securityConfigs {
 		t.Run(sc.name, func(t *testing.T) {
 			config := Config{
-				ServerPort:        8080,
-				SecuritySalt:      sc.salt,
-				SecurityPepper:    sc.pepper,
-				SecurityJwtSecret: sc.jwtSecret,
+				Server: ServerConfig{Port: 8080},
+				Security: SecurityConfig{
+					Salt:      sc.salt,
+					Pepper:    sc.pepper,
+					JwtSecret: sc.jwtSecret,
+				},
 			}
 
 			err := validateConfig(config, log)
 			assert.NoError(t, err)
-			assert.Equal(t, sc.salt, ConfigInstance.SecuritySalt)
-			assert.Equal(t, sc.pepper, ConfigInstance.SecurityPepper)
-			assert.Equal(t, sc.jwtSecret, ConfigInstance.SecurityJwtSecret)
+			assert.Equal(t, sc.salt, ConfigInstance.Security.Salt)
+			assert.Equal(t, sc.pepper, ConfigInstance.Security.Pepper)
+			assert.Equal(t, sc.jwtSecret, ConfigInstance.Security.JwtSecret)
 		})
 	}
 }
@@ -222,7 +238,7 @@ func TestGetConfig_ReturnsCurrentInstance(t *testing.T) {
 	// Set a specific config
 	testConfig := Config{
 		GeneralVersion: "test-version",
-		ServerPort:     9999,
+		Server:         ServerConfig{Port: 9999},
 		Environment:    "test",
 	}
 
@@ -234,36 +250,42 @@ func TestGetConfig_ReturnsCurrentInstance(t *testing.T) {
 
 	assert.Equal(t, testConfig, retrievedConfig)
 	assert.Equal(t, "test-version", retrievedConfig.GeneralVersion)
-	assert.Equal(t, 9999, retrievedConfig.ServerPort)
+	assert.Equal(t, 9999, retrievedConfig.Server.Port)
 	assert.Equal(t, "test", retrievedConfig.Environment)
 }
 
 func TestConfig_StructFieldTypes(t *testing.T) {
 	// Test that we can create a config with all field types
 	config := Config{
-		GeneralVersion:       "string-value",
-		Environment:          "another-string",
-		ServerPort:           12345,        // int
-		DatabaseDbPath:       "path/to/db", // string
-		DatabaseCacheAddress: "cache-addr", // string
-		DatabaseCachePort:    6379,         // int
-		CorsAllowOrigins:     "origins",    // string
-		SecuritySalt:         42,           // int
-		SecurityPepper:       "pepper-str", // string
-		SecurityJwtSecret:    "jwt-secret", // string
+		GeneralVersion: "string-value",
+		Environment:    "another-string",
+		Server: ServerConfig{
+			Port:             12345,     // int
+			CorsAllowOrigins: "origins", // string
+		},
+		Database: DatabaseConfig{Path: "path/to/db"}, // string
+		Cache: CacheConfig{
+			Address: "cache-addr", // string
+			Port:    6379,         // int
+		},
+		Security: SecurityConfig{
+			Salt:      42,           // int
+			Pepper:    "pepper-str", // string
+			JwtSecret: "jwt-secret", // string
+		},
 	}
 
 	// Verify all fields are accessible and have correct types
 	assert.IsType(t, "", config.GeneralVersion)
 	assert.IsType(t, "", config.Environment)
-	assert.IsType(t, 0, config.ServerPort)
-	assert.IsType(t, "", config.DatabaseDbPath)
-	assert.IsType(t, "", config.DatabaseCacheAddress)
-	assert.IsType(t, 0, config.DatabaseCachePort)
-	assert.IsType(t, "", config.CorsAllowOrigins)
-	assert.IsType(t, 0, config.SecuritySalt)
-	assert.IsType(t, "", config.SecurityPepper)
-	assert.IsType(t, "", config.SecurityJwtSecret)
+	assert.IsType(t, 0, config.Server.Port)
+	assert.IsType(t, "", config.Database.Path)
+	assert.IsType(t, "", config.Cache.Address)
+	assert.IsType(t, 0, config.Cache.Port)
+	assert.IsType(t, "", config.Server.CorsAllowOrigins)
+	assert.IsType(t, 0, config.Security.Salt)
+	assert.IsType(t, "", config.Security.Pepper)
+	assert.IsType(t, "", config.Security.JwtSecret)
 }
 
 func TestConfig_DefaultZeroValues(t *testing.T) {
@@ -272,14 +294,14 @@ func TestConfig_DefaultZeroValues(t *testing.T) {
 
 	assert.Equal(t, "", config.GeneralVersion)
 	assert.Equal(t, "", config.Environment)
-	assert.Equal(t, 0, config.ServerPort)
-	assert.Equal(t, "", config.DatabaseDbPath)
-	assert.Equal(t, "", config.DatabaseCacheAddress)
-	assert.Equal(t, 0, config.DatabaseCachePort)
-	assert.Equal(t, "", config.CorsAllowOrigins)
-	assert.Equal(t, 0, config.SecuritySalt)
-	assert.Equal(t, "", config.SecurityPepper)
-	assert.Equal(t, "", config.SecurityJwtSecret)
+	assert.Equal(t, 0, config.Server.Port)
+	assert.Equal(t, "", config.Database.Path)
+	assert.Equal(t, "", config.Cache.Address)
+	assert.Equal(t, 0, config.Cache.Port)
+	assert.Equal(t, "", config.Server.CorsAllowOrigins)
+	assert.Equal(t, 0, config.Security.Salt)
+	assert.Equal(t, "", config.Security.Pepper)
+	assert.Equal(t, "", config.Security.JwtSecret)
 }
 
 // Negative Test Cases
@@ -288,7 +310,7 @@ func TestValidateConfig_InvalidServerPort_Zero(t *testing.T) {
 	log := logger.New("test")
 
 	invalidConfig := Config{
-		ServerPort: 0, // Invalid: zero port
+		Server: ServerConfig{Port: 0}, // Invalid: zero port
 	}
 
 	err := validateConfig(invalidConfig, log)
@@ -310,7 +332,7 @@ func TestValidateConfig_InvalidServerPort_Negative(t *testing.T) {
 	for _, port := range negativePortConfigs {
 		t.Run(fmt.Sprintf("port_%d", port), func(t *testing.T) {
 			invalidConfig := Config{
-				ServerPort: port,
+				Server: ServerConfig{Port: port},
 			}
 
 			err := validateConfig(invalidConfig, log)
@@ -340,7 +362,7 @@ func TestValidateConfig_PortBoundaryValues(t *testing.T) {
 	for _, test := range boundaryTests {
 		t.Run(test.name, func(t *testing.T) {
 			config := Config{
-				ServerPort: test.port,
+				Server: ServerConfig{Port: test.port},
 			}
 
 			err := validateConfig(config, log)
@@ -361,7 +383,7 @@ func TestValidateConfig_GlobalStateModification(t *testing.T) {
 	originalConfig := ConfigInstance
 
 	invalidConfig := Config{
-		ServerPort: -1, // Invalid
+		Server: ServerConfig{Port: -1}, // Invalid
 	}
 
 	err := validateConfig(invalidConfig, log)
@@ -387,11 +409,11 @@ func TestValidateConfig_PartiallyInvalidConfig(t *testing.T) {
 
 	// Config with some valid fields but invalid port
 	partialConfig := Config{
-		GeneralVersion:    "1.0.0",
-		Environment:       "production",
-		ServerPort:        -8080, // Invalid
-		DatabaseDbPath:    "/valid/path/database.db",
-		SecurityJwtSecret: "valid-secret",
+		GeneralVersion: "1.0.0",
+		Environment:    "production",
+		Server:         ServerConfig{Port: -8080}, // Invalid
+		Database:       DatabaseConfig{Path: "/valid/path/database.db"},
+		Security:       SecurityConfig{JwtSecret: "valid-secret"},
 	}
 
 	err := validateConfig(partialConfig, log)
@@ -422,7 +444,7 @@ func TestValidateConfig_SpecialPortValues(t *testing.T) {
 	for _, sp := range specialPorts {
 		t.Run(sp.name, func(t *testing.T) {
 			config := Config{
-				ServerPort: sp.port,
+				Server: ServerConfig{Port: sp.port},
 			}
 
 			err := validateConfig(config, log)
@@ -438,7 +460,7 @@ func TestGetConfig_AfterFailedValidation(t *testing.T) {
 
 	// Set a known good config first
 	goodConfig := Config{
-		ServerPort:  8080,
+		Server:      ServerConfig{Port: 8080},
 		Environment: "test",
 	}
 	err := validateConfig(goodConfig, log)
@@ -450,7 +472,7 @@ func TestGetConfig_AfterFailedValidation(t *testing.T) {
 
 	// Try to validate a bad config
 	badConfig := Config{
-		ServerPort: -1,
+		Server: ServerConfig{Port: -1},
 	}
 	err = validateConfig(badConfig, log)
 	assert.Error(t, err)
@@ -470,14 +492,18 @@ func TestConfig_ExtremeStringValues(t *testing.T) {
 	veryLongString := strings.Repeat("a", 10000)
 
 	config := Config{
-		ServerPort:           8080, // Valid port
-		GeneralVersion:       veryLongString,
-		Environment:          veryLongString,
-		DatabaseDbPath:       veryLongString,
-		DatabaseCacheAddress: veryLongString,
-		CorsAllowOrigins:     veryLongString,
-		SecurityPepper:       veryLongString,
-		SecurityJwtSecret:    veryLongString,
+		Server: ServerConfig{
+			Port:             8080, // Valid port
+			CorsAllowOrigins: veryLongString,
+		},
+		GeneralVersion: veryLongString,
+		Environment:    veryLongString,
+		Database:       DatabaseConfig{Path: veryLongString},
+		Cache:          CacheConfig{Address: veryLongString},
+		Security: SecurityConfig{
+			Pepper:    veryLongString,
+			JwtSecret: veryLongString,
+		},
 	}
 
 	err := validateConfig(config, log)
@@ -491,14 +517,18 @@ func TestConfig_UnicodeAndSpecialCharacters(t *testing.T) {
 	log := logger.New("test")
 
 	unicodeConfig := Config{
-		ServerPort:           8080,
-		GeneralVersion:       "v1.0.0-测试版",
-		Environment:          "тест", // Cyrillic
-		DatabaseDbPath:       "/path/with/émojis/🚀/database.db",
-		DatabaseCacheAddress: "café.example.com",
-		CorsAllowOrigins:     "https://测试.example.com,https://тест.com",
-		SecurityPepper:       "🔒secure🔑pepper🛡️",
-		SecurityJwtSecret:    "jwt-secret-with-特殊字符",
+		Server: ServerConfig{
+			Port:             8080,
+			CorsAllowOrigins: "https://测试.example.com,https://тест.com",
+		},
+		GeneralVersion: "v1.0.0-测试版",
+		Environment:    "тест", // Cyrillic
+		Database:       DatabaseConfig{Path: "/path/with/émojis/🚀/database.db"},
+		Cache:          CacheConfig{Address: "café.example.com"},
+		Security: SecurityConfig{
+			Pepper:    "🔒secure🔑pepper🛡️",
+			JwtSecret: "jwt-secret-with-特殊字符",
+		},
 	}
 
 	err := validateConfig(unicodeConfig, log)
@@ -511,14 +541,18 @@ func TestConfig_ControlCharactersAndWhitespace(t *testing.T) {
 
 	// Test with various whitespace and control characters
 	controlCharConfig := Config{
-		ServerPort:           8080,
-		GeneralVersion:       "v1.0.0\n\t\r",
-		Environment:          " production ",
-		DatabaseDbPath:       "/path/with\nnewlines/db.sqlite",
-		DatabaseCacheAddress: "\t\tredis.example.com\t\t",
-		CorsAllowOrigins:     "http://localhost:3000\n,https://app.com\r\n",
-		SecurityPepper:       "pepper\x00with\x01control\x02chars",
-		SecurityJwtSecret:    "jwt\tsecret\nwith\rwhitespace",
+		Server: ServerConfig{
+			Port:             8080,
+			CorsAllowOrigins: "http://localhost:3000\n,https://app.com\r\n",
+		},
+		GeneralVersion: "v1.0.0\n\t\r",
+		Environment:    " production ",
+		Database:       DatabaseConfig{Path: "/path/with\nnewlines/db.sqlite"},
+		Cache:          CacheConfig{Address: "\t\tredis.example.com\t\t"},
+		Security: SecurityConfig{
+			Pepper:    "pepper\x00with\x01control\x02chars",
+			JwtSecret: "jwt\tsecret\nwith\rwhitespace",
+		},
 	}
 
 	err := validateConfig(controlCharConfig, log)
@@ -528,7 +562,7 @@ func TestConfig_ControlCharactersAndWhitespace(t *testing.T) {
 func TestConfig_NilLogger(t *testing.T) {
 	// Test validateConfig with nil logger (edge case)
 	config := Config{
-		ServerPort: -1, // Invalid port
+		Server: ServerConfig{Port: -1}, // Invalid port
 	}
 
 	// This should panic or fail gracefully
@@ -555,7 +589,7 @@ func TestConfig_ConcurrentValidation(t *testing.T) {
 	for i := 0; i < numGoroutines; i++ {
 		go func(id int) {
 			config := Config{
-				ServerPort:  8080 + id, // Different ports
+				Server:      ServerConfig{Port: 8080 + id}, // Different ports
 				Environment: fmt.Sprintf("test-%d", id),
 			}
 			err := validateConfig(config, log)
@@ -571,7 +605,7 @@ func TestConfig_ConcurrentValidation(t *testing.T) {
 
 	// Global config should be one of the valid configs
 	finalConfig := GetConfig()
-	assert.True(t, finalConfig.ServerPort >= 8080 && finalConfig.ServerPort < 8090)
+	assert.True(t, finalConfig.Server.Port >= 8080 && finalConfig.Server.Port < 8090)
 }
 
 func TestConfig_ValidationOrderDependency(t *testing.T) {
@@ -579,9 +613,9 @@ func TestConfig_ValidationOrderDependency(t *testing.T) {
 
 	// Test that validation works regardless of field order
 	configs := []Config{
-		{ServerPort: 8080, Environment: "test1"},
-		{Environment: "test2", ServerPort: 8081},
-		{DatabaseDbPath: "/path", ServerPort: 8082, Environment: "test3"},
+		{Server: ServerConfig{Port: 8080}, Environment: "test1"},
+		{Environment: "test2", Server: ServerConfig{Port: 8081}},
+		{Database: DatabaseConfig{Path: "/path"}, Server: ServerConfig{Port: 8082}, Environment: "test3"},
 	}
 
 	for i, config := range configs {
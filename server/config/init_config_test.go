@@ -1,11 +1,17 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"server/internal/logger"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -44,14 +50,14 @@ SECURITY_JWT_SECRET=test-jwt-secret`
 	assert.NoError(t, err)
 	assert.Equal(t, "1.2.3", config.GeneralVersion)
 	assert.Equal(t, "test", config.Environment)
-	assert.Equal(t, 9999, config.ServerPort)
-	assert.Equal(t, "/tmp/test.db", config.DatabaseDbPath)
-	assert.Equal(t, "localhost", config.DatabaseCacheAddress)
-	assert.Equal(t, 6379, config.DatabaseCachePort)
-	assert.Equal(t, "http://localhost:3000", config.CorsAllowOrigins)
-	assert.Equal(t, 12, config.SecuritySalt)
-	assert.Equal(t, "test-pepper", config.SecurityPepper)
-	assert.Equal(t, "test-jwt-secret", config.SecurityJwtSecret)
+	assert.Equal(t, 9999, config.Server.Port)
+	assert.Equal(t, "/tmp/test.db", config.Database.Path)
+	assert.Equal(t, "localhost", config.Cache.Address)
+	assert.Equal(t, 6379, config.Cache.Port)
+	assert.Equal(t, "http://localhost:3000", config.Server.CorsAllowOrigins)
+	assert.Equal(t, 12, config.Security.Salt)
+	assert.Equal(t, "test-pepper", config.Security.Pepper)
+	assert.Equal(t, "test-jwt-secret", config.Security.JwtSecret)
 }
 
 func TestInitConfig_WithEnvFile_MinimalValid(t *testing.T) {
@@ -75,11 +81,11 @@ func TestInitConfig_WithEnvFile_MinimalValid(t *testing.T) {
 	config, err := InitConfig()
 
 	assert.NoError(t, err)
-	assert.Equal(t, 8080, config.ServerPort)
+	assert.Equal(t, 8080, config.Server.Port)
 	// Other fields should be zero values
 	assert.Equal(t, "", config.GeneralVersion)
 	assert.Equal(t, "", config.Environment)
-	assert.Equal(t, 0, config.SecuritySalt)
+	assert.Equal(t, 0, config.Security.Salt)
 }
 
 func TestInitConfig_WithEnvFile_InvalidPort(t *testing.T) {
@@ -129,7 +135,7 @@ func TestInitConfig_NoEnvFile_WithEnvVars(t *testing.T) {
 	config, err := InitConfig()
 
 	assert.NoError(t, err)
-	assert.Equal(t, 7777, config.ServerPort)
+	assert.Equal(t, 7777, config.Server.Port)
 	// Other fields will be zero values since no .env file and no other env vars set
 }
 
@@ -162,7 +168,7 @@ ENVIRONMENT=development`
 
 	assert.NoError(t, err)
 	// Environment variables should override .env file values
-	assert.Equal(t, 9090, config.ServerPort)
+	assert.Equal(t, 9090, config.Server.Port)
 	assert.Equal(t, "production", config.Environment)
 }
 
@@ -209,7 +215,7 @@ GENERAL_VERSION=1.0.0`
 
 	// Should work since the valid lines can be parsed
 	assert.NoError(t, err)
-	assert.Equal(t, 8080, config.ServerPort)
+	assert.Equal(t, 8080, config.Server.Port)
 	assert.Equal(t, "test", config.Environment)
 	assert.Equal(t, "1.0.0", config.GeneralVersion)
 }
@@ -236,11 +242,11 @@ SECURITY_JWT_SECRET="jwt-秘密-with-émojis-🔒"`
 	config, err := InitConfig()
 
 	assert.NoError(t, err)
-	assert.Equal(t, 8080, config.ServerPort)
+	assert.Equal(t, 8080, config.Server.Port)
 	assert.Equal(t, "v1.0.0-测试版", config.GeneralVersion)
 	assert.Equal(t, "test-with-hyphens", config.Environment)
-	assert.Equal(t, "pepper-with-special-chars-safe", config.SecurityPepper)
-	assert.Equal(t, "jwt-秘密-with-émojis-🔒", config.SecurityJwtSecret)
+	assert.Equal(t, "pepper-with-special-chars-safe", config.Security.Pepper)
+	assert.Equal(t, "jwt-秘密-with-émojis-🔒", config.Security.JwtSecret)
 }
 
 func TestInitConfig_DifferentDataTypes(t *testing.T) {
@@ -272,18 +278,18 @@ SECURITY_JWT_SECRET=long-jwt-secret-value`
 	assert.NoError(t, err)
 
 	// Test int fields
-	assert.Equal(t, 65535, config.ServerPort)
-	assert.Equal(t, 6379, config.DatabaseCachePort)
-	assert.Equal(t, 16, config.SecuritySalt)
+	assert.Equal(t, 65535, config.Server.Port)
+	assert.Equal(t, 6379, config.Cache.Port)
+	assert.Equal(t, 16, config.Security.Salt)
 
 	// Test string fields
 	assert.Equal(t, "1.0.0", config.GeneralVersion)
 	assert.Equal(t, "production", config.Environment)
-	assert.Equal(t, "/absolute/path/to/database.db", config.DatabaseDbPath)
-	assert.Equal(t, "redis.example.com", config.DatabaseCacheAddress)
-	assert.Equal(t, "https://app1.com,https://app2.com", config.CorsAllowOrigins)
-	assert.Equal(t, "long-pepper-value", config.SecurityPepper)
-	assert.Equal(t, "long-jwt-secret-value", config.SecurityJwtSecret)
+	assert.Equal(t, "/absolute/path/to/database.db", config.Database.Path)
+	assert.Equal(t, "redis.example.com", config.Cache.Address)
+	assert.Equal(t, "https://app1.com,https://app2.com", config.Server.CorsAllowOrigins)
+	assert.Equal(t, "long-pepper-value", config.Security.Pepper)
+	assert.Equal(t, "long-jwt-secret-value", config.Security.JwtSecret)
 }
 
 func TestInitConfig_InvalidIntegerValues(t *testing.T) {
@@ -361,11 +367,11 @@ func TestInitConfig_LongStrings(t *testing.T) {
 	config, err := InitConfig()
 
 	assert.NoError(t, err)
-	assert.Equal(t, 8080, config.ServerPort)
+	assert.Equal(t, 8080, config.Server.Port)
 	assert.Equal(t, longString, config.GeneralVersion)
 	assert.Equal(t, longString, config.Environment)
-	assert.Equal(t, longString, config.SecurityPepper)
-	assert.Equal(t, longString, config.SecurityJwtSecret)
+	assert.Equal(t, longString, config.Security.Pepper)
+	assert.Equal(t, longString, config.Security.JwtSecret)
 }
 
 func TestInitConfig_UpdatesGlobalConfigInstance(t *testing.T) {
@@ -393,7 +399,7 @@ GENERAL_VERSION=test-version`
 
 	// Verify global ConfigInstance was updated
 	assert.Equal(t, config, ConfigInstance)
-	assert.Equal(t, 7654, ConfigInstance.ServerPort)
+	assert.Equal(t, 7654, ConfigInstance.Server.Port)
 	assert.Equal(t, "init-test", ConfigInstance.Environment)
 	assert.Equal(t, "test-version", ConfigInstance.GeneralVersion)
 
@@ -421,18 +427,535 @@ func TestInitConfig_WithComplexCORSOrigins(t *testing.T) {
 	config, err := InitConfig()
 
 	assert.NoError(t, err)
-	assert.Equal(t, corsOrigins, config.CorsAllowOrigins)
+	assert.Equal(t, corsOrigins, config.Server.CorsAllowOrigins)
 }
 
 // Helper functions
 
+func TestInitConfig_DebugBodyCapture(t *testing.T) {
+	testCases := []struct {
+		environment string
+		expected    bool
+	}{
+		{"development", true},
+		{"production", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.environment, func(t *testing.T) {
+			clearEnvVars(t)
+
+			envFile := createTempEnvFile(t, "SERVER_PORT=8080\nENVIRONMENT="+tc.environment+"\nSERVER_DEBUG_BODY_CAPTURE=true")
+			defer func() { _ = os.Remove(envFile) }()
+
+			originalDir, err := os.Getwd()
+			require.NoError(t, err)
+			defer func() { _ = os.Chdir(originalDir) }()
+			require.NoError(t, os.Chdir(filepath.Dir(envFile)))
+
+			config, err := InitConfig()
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, config.Server.DebugBodyCapture)
+			assert.Equal(t, tc.expected, config.DebugBodyCaptureEnabled())
+			assert.Equal(t, "password,token,secret,authorization", config.Server.RedactFields)
+			assert.Equal(t, 24*time.Hour, config.Database.BackupInterval)
+			assert.Equal(t, 7, config.Database.BackupRetention)
+			assert.Equal(t, DATABASE_DRIVER_SQLITE, config.Database.Driver)
+			assert.Empty(t, config.Tracing.Endpoint)
+			assert.Equal(t, 1.0, config.Tracing.SampleRatio)
+			assert.True(t, config.Database.PrepareStmt)
+			assert.Equal(t, "lax", config.Session.CookieSameSite)
+			assert.False(t, config.Session.CookiePartitioned)
+			assert.Equal(t, 30*24*time.Hour, config.SessionMaxLifetime())
+			assert.True(t, config.Security.RegistrationAutoLogin)
+			assert.True(t, config.Security.HoneypotEnabled)
+			assert.True(t, config.Security.FormTokenEnabled)
+			assert.Equal(t, 3*time.Second, config.Security.FormTokenMinAge)
+			assert.Equal(t, 20*time.Second, config.Websocket.DrainWindow)
+			assert.Equal(t, 100, config.Websocket.DrainBatchSize)
+			assert.Empty(t, config.Security.PepperPrevious)
+			assert.Equal(t, 1, config.Security.PepperVersion)
+			assert.Equal(t, 365, config.Audit.RetentionDays)
+			assert.Equal(t, "data/audit", config.Audit.ArchiveDir)
+		})
+	}
+}
+
+func TestInitConfig_LogComponentLevels(t *testing.T) {
+	testCases := []struct {
+		name   string
+		levels string
+		valid  bool
+	}{
+		{"valid", "websockets=warn,middleware=info", true},
+		{"unknown level", "websockets=loud", false},
+		{"missing level", "websockets", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clearEnvVars(t)
+			defer logger.SetComponentLevels(nil)
+
+			envFile := createTempEnvFile(t, "SERVER_PORT=8080\nLOG_COMPONENT_LEVELS="+tc.levels)
+			defer func() { _ = os.Remove(envFile) }()
+
+			originalDir, err := os.Getwd()
+			require.NoError(t, err)
+			defer func() { _ = os.Chdir(originalDir) }()
+			require.NoError(t, os.Chdir(filepath.Dir(envFile)))
+
+			config, err := InitConfig()
+			if !tc.valid {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.levels, config.Logging.ComponentLevels)
+		})
+	}
+}
+
+func TestConfig_DebugBodyCaptureEnabled_NeverInProduction(t *testing.T) {
+	config := Config{Environment: "production", Server: ServerConfig{DebugBodyCapture: true}}
+	assert.False(t, config.DebugBodyCaptureEnabled())
+}
+
+func TestConfig_LegacyAPISunset(t *testing.T) {
+	sunset, ok := Config{Server: ServerConfig{LegacyApiSunset: "2027-04-01"}}.LegacyAPISunset()
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC), sunset)
+
+	_, ok = Config{}.LegacyAPISunset()
+	assert.False(t, ok)
+
+	err := validateConfig(Config{Server: ServerConfig{Port: 8080, LegacyApiSunset: "April 2027"}}, logger.New("test"))
+	assert.Error(t, err)
+}
+
+func TestValidateConfig_DatabaseBackup(t *testing.T) {
+	log := logger.New("test")
+
+	valid := Config{
+		Server: ServerConfig{Port: 8080},
+		Database: DatabaseConfig{
+			BackupDir: "data/backups",
+			BackupInterval: 24 * time.Hour,
+			BackupRetention: 7,
+		},
+	}
+	assert.NoError(t, validateConfig(valid, log))
+
+	noInterval := valid
+	noInterval.Database.BackupInterval = 0
+	assert.Error(t, validateConfig(noInterval, log))
+
+	noRetention := valid
+	noRetention.Database.BackupRetention = 0
+	assert.Error(t, validateConfig(noRetention, log))
+
+	// Nothing else is checked once backups are turned off.
+	assert.NoError(t, validateConfig(Config{Server: ServerConfig{Port: 8080}}, log))
+}
+
+func TestValidateConfig_DatabasePool(t *testing.T) {
+	log := logger.New("test")
+	valid := Config{Server: ServerConfig{Port: 8080}}
+
+	for _, driver := range []string{"", DATABASE_DRIVER_SQLITE, DATABASE_DRIVER_POSTGRES} {
+		config := valid
+		config.Database.Driver = driver
+		assert.NoError(t, validateConfig(config, log), driver)
+	}
+
+	unknownDriver := valid
+	unknownDriver.Database.Driver = "mysql"
+	assert.Error(t, validateConfig(unknownDriver, log))
+
+	negativeOpen := valid
+	negativeOpen.Database.MaxOpenConns = -1
+	assert.Error(t, validateConfig(negativeOpen, log))
+
+	negativeLifetime := valid
+	negativeLifetime.Database.ConnMaxLifetime = -time.Second
+	assert.Error(t, validateConfig(negativeLifetime, log))
+}
+
+func TestValidateConfig_Tracing(t *testing.T) {
+	log := logger.New("test")
+	valid := Config{Server: ServerConfig{Port: 8080}}
+
+	for _, endpoint := range []string{"", "http://collector:4318", "https://collector/v1/traces"} {
+		config := valid
+		config.Tracing.Endpoint = endpoint
+		assert.NoError(t, validateConfig(config, log), endpoint)
+	}
+
+	for _, endpoint := range []string{"collector:4318", "grpc://collector:4317", "http://"} {
+		config := valid
+		config.Tracing.Endpoint = endpoint
+		assert.Error(t, validateConfig(config, log), endpoint)
+	}
+
+	for _, ratio := range []float64{-0.1, 1.5} {
+		config := valid
+		config.Tracing.SampleRatio = ratio
+		assert.Error(t, validateConfig(config, log), ratio)
+	}
+}
+
+func TestConfig_DatabasePool(t *testing.T) {
+	assert.Equal(t, DatabasePool{MaxOpenConns: 1, MaxIdleConns: 1}, Config{}.DatabasePool())
+	assert.Equal(t, DefaultDatabasePools[DATABASE_DRIVER_POSTGRES],
+		Config{Database: DatabaseConfig{Driver: DATABASE_DRIVER_POSTGRES}}.DatabasePool())
+
+	overridden := Config{Database: DatabaseConfig{
+		Driver:          DATABASE_DRIVER_POSTGRES,
+		MaxOpenConns:    50,
+		ConnMaxLifetime: time.Hour,
+	}}.DatabasePool()
+	assert.Equal(t, DatabasePool{
+		MaxOpenConns:    50,
+		MaxIdleConns:    10,
+		ConnMaxLifetime: time.Hour,
+		ConnMaxIdleTime: 5 * time.Minute,
+	}, overridden)
+}
+
+func TestValidateConfig_SessionCookieSameSite(t *testing.T) {
+	log := logger.New("test")
+
+	for _, sameSite := range []string{"", "lax", "strict", "none", "None"} {
+		assert.NoError(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Session: SessionConfig{CookieSameSite: sameSite}}, log), sameSite)
+	}
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Session: SessionConfig{CookieSameSite: "relaxed"}}, log))
+}
+
+func TestValidateConfig_SecurityFormTokenMinAge(t *testing.T) {
+	log := logger.New("test")
+
+	for _, minAge := range []time.Duration{0, 3 * time.Second, 59 * time.Minute} {
+		assert.NoError(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Security: SecurityConfig{FormTokenMinAge: minAge}}, log), minAge)
+	}
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Security: SecurityConfig{FormTokenMinAge: -time.Second}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Security: SecurityConfig{FormTokenMinAge: time.Hour}}, log))
+}
+
+func TestValidateConfig_WebsocketDrain(t *testing.T) {
+	log := logger.New("test")
+
+	assert.NoError(t, validateConfig(Config{Server: ServerConfig{Port: 8080}}, log))
+	assert.NoError(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Websocket: WebsocketConfig{DrainWindow: time.Minute, DrainBatchSize: 10}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Websocket: WebsocketConfig{DrainWindow: -time.Second}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Websocket: WebsocketConfig{DrainBatchSize: -1}}, log))
+}
+
+func TestValidateConfig_WebsocketDuplicateConnections(t *testing.T) {
+	log := logger.New("test")
+
+	assert.Equal(t, DUPLICATE_CONNECTIONS_REPLACE, Config{}.DuplicateConnections())
+	assert.NoError(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Websocket: WebsocketConfig{DuplicateConnections: "Reject"}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Websocket: WebsocketConfig{DuplicateConnections: "ignore"}}, log))
+}
+
+func TestValidateConfig_PepperRotation(t *testing.T) {
+	log := logger.New("test")
+
+	rotating := Config{Server: ServerConfig{Port: 8080}, Security: SecurityConfig{Pepper: "new", PepperPrevious: "old", PepperVersion: 2}}
+	assert.NoError(t, validateConfig(rotating, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Security: SecurityConfig{PepperVersion: -1}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Security: SecurityConfig{Pepper: "same", PepperPrevious: "same"}}, log))
+
+	assert.Equal(t, 1, Config{}.PepperVersion())
+	assert.Equal(t, 2, rotating.PepperVersion())
+}
+
+func TestValidateConfig_AuditRetention(t *testing.T) {
+	log := logger.New("test")
+
+	assert.NoError(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Audit: AuditConfig{RetentionDays: 365, ArchiveDir: "data/audit"}}, log))
+	assert.NoError(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Audit: AuditConfig{RetentionDays: 0}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Audit: AuditConfig{RetentionDays: -1, ArchiveDir: "data/audit"}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Audit: AuditConfig{RetentionDays: 30}}, log))
+
+	assert.Equal(t, 30*24*time.Hour, Config{Audit: AuditConfig{RetentionDays: 30}}.AuditRetention())
+	assert.Zero(t, Config{}.AuditRetention())
+}
+
+func TestValidateConfig_TrustedProxies(t *testing.T) {
+	log := logger.New("test")
+
+	assert.NoError(t, validateConfig(Config{Server: ServerConfig{Port: 8080, TrustedProxies: "10.0.0.1, 172.16.0.0/12,::1"}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080, TrustedProxies: "10.0.0.1,load-balancer"}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080, TrustedProxies: "10.0.0.0/33"}}, log))
+
+	assert.Equal(t, []string{"10.0.0.1", "172.16.0.0/12", "::1"}, Config{Server: ServerConfig{TrustedProxies: "10.0.0.1, 172.16.0.0/12,::1,"}}.TrustedProxies())
+	assert.Empty(t, Config{}.TrustedProxies())
+}
+
+func TestConfig_SlowQueryThreshold(t *testing.T) {
+	assert.Equal(t, 200*time.Millisecond, Config{}.SlowQueryThreshold())
+	assert.Equal(t, 50*time.Millisecond, Config{Database: DatabaseConfig{SlowQueryMs: 50}}.SlowQueryThreshold())
+	assert.Zero(t, Config{Database: DatabaseConfig{SlowQueryMs: -1}}.SlowQueryThreshold(), "negative turns it off")
+}
+
+func TestConfig_UserConcurrency(t *testing.T) {
+	assert.Equal(t, DEFAULT_USER_CONCURRENCY, Config{}.UserConcurrency())
+	assert.Equal(t, 4, Config{Server: ServerConfig{UserConcurrency: 4}}.UserConcurrency())
+	assert.Equal(t, 0, Config{Server: ServerConfig{UserConcurrency: -1}}.UserConcurrency(), "negative turns it off")
+}
+
+func TestConfig_ValidateRateLimit(t *testing.T) {
+	assert.Equal(t, DEFAULT_VALIDATE_RATE_LIMIT, Config{}.ValidateRateLimit())
+	assert.Equal(t, 3, Config{Security: SecurityConfig{ValidateRateLimit: 3}}.ValidateRateLimit())
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080}, Security: SecurityConfig{ValidateRateLimit: -1}}, logger.New("test")))
+}
+
+func TestValidateConfig_ClientTypes(t *testing.T) {
+	log := logger.New("test")
+	session := func(clientTypes, unknown string) Config {
+		return Config{Server: ServerConfig{Port: 8080}, Session: SessionConfig{ClientTypes: clientTypes, UnknownClientType: unknown}}
+	}
+
+	assert.NoError(t, validateConfig(session("", ""), log))
+	assert.NoError(t, validateConfig(session("tauri=token, admin-web=Cookie,", "reject"), log))
+	assert.NoError(t, validateConfig(session("", "token"), log))
+	assert.Error(t, validateConfig(session("tauri", ""), log))
+	assert.Error(t, validateConfig(session("=token", ""), log))
+	assert.Error(t, validateConfig(session("tauri=bearer", ""), log))
+	assert.Error(t, validateConfig(session("", "deny"), log))
+
+	clientTypes, err := session("tauri=token, admin-web=Cookie,", "").ClientTypes()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tauri": AUTH_STRATEGY_TOKEN, "admin-web": AUTH_STRATEGY_COOKIE}, clientTypes)
+	assert.Equal(t, AUTH_STRATEGY_NONE, Config{}.UnknownClientType())
+}
+
+func TestValidateConfig_MinClientVersions(t *testing.T) {
+	log := logger.New("test")
+	session := func(minVersions string) Config {
+		return Config{Server: ServerConfig{Port: 8080}, Session: SessionConfig{MinClientVersions: minVersions}}
+	}
+
+	assert.NoError(t, validateConfig(session(""), log))
+	assert.NoError(t, validateConfig(session("flutter=2.3.0, tauri=1.0.0-beta.2,"), log))
+	assert.Error(t, validateConfig(session("flutter"), log))
+	assert.Error(t, validateConfig(session("=2.3.0"), log))
+	assert.Error(t, validateConfig(session("flutter=v2.3"), log))
+
+	minVersions, err := session("flutter=2.3.0, tauri=1.0.0-beta.2").MinClientVersions()
+	require.NoError(t, err)
+	require.Len(t, minVersions, 2)
+	assert.Equal(t, "2.3.0", minVersions["flutter"].String())
+	assert.Equal(t, "1.0.0-beta.2", minVersions["tauri"].String())
+}
+
+func TestValidateConfig_DataKey(t *testing.T) {
+	log := logger.New("test")
+	security := func(dataKey string) Config {
+		return Config{Server: ServerConfig{Port: 8080}, Security: SecurityConfig{DataKey: dataKey}}
+	}
+	key := "a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s="
+
+	assert.NoError(t, validateConfig(security(""), log))
+	assert.NoError(t, validateConfig(security(key), log))
+	assert.Error(t, validateConfig(security("a2tra2tra2tra2tra2tra2tr"), log), "16 bytes")
+	assert.Error(t, validateConfig(security("not a key"), log))
+
+	assert.Len(t, security(key).DataKey(), 32)
+	assert.Nil(t, security("").DataKey())
+}
+
+func TestValidateConfig_ServerListen(t *testing.T) {
+	log := logger.New("test")
+
+	// A socket replaces the port
+	assert.NoError(t, validateConfig(Config{Server: ServerConfig{Listen: "unix:///var/run/app.sock"}}, log))
+	assert.NoError(t, validateConfig(Config{Server: ServerConfig{Listen: "unix:///var/run/app.sock", SocketMode: "0600"}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Listen: "/var/run/app.sock"}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Listen: "unix://"}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Listen: "tcp://:8080"}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080, SocketMode: "rw-rw----"}}, log))
+	assert.Error(t, validateConfig(Config{Server: ServerConfig{Port: 8080, SocketMode: "1777"}}, log))
+
+	assert.Equal(t, "/var/run/app.sock", Config{Server: ServerConfig{Listen: "unix:///var/run/app.sock"}}.SocketPath())
+	assert.Empty(t, Config{Server: ServerConfig{Port: 8080}}.SocketPath())
+	assert.Equal(t, os.FileMode(0o600), Config{Server: ServerConfig{SocketMode: "0600"}}.SocketMode())
+	assert.Equal(t, DEFAULT_SOCKET_MODE, Config{}.SocketMode())
+}
+
+func TestConfig_BcryptCost(t *testing.T) {
+	assert.Equal(t, DEVELOPMENT_BCRYPT_COST, Config{}.BcryptCost())
+	assert.Equal(t, DEVELOPMENT_BCRYPT_COST, Config{Environment: "development"}.BcryptCost())
+	assert.Equal(t, PRODUCTION_BCRYPT_COST, Config{Environment: "production"}.BcryptCost())
+
+	// An explicit cost wins in every environment
+	assert.Equal(t, 14, Config{Environment: "production", Security: SecurityConfig{Salt: 14}}.BcryptCost())
+	assert.Equal(t, 10, Config{Environment: "development", Security: SecurityConfig{Salt: 10}}.BcryptCost())
+}
+
+func TestConfig_CookieSigningKey(t *testing.T) {
+	assert.Equal(t, "cookie-key", Config{Security: SecurityConfig{CookieKey: "cookie-key", JwtSecret: "jwt"}}.CookieSigningKey())
+	assert.Equal(t, "jwt", Config{Security: SecurityConfig{JwtSecret: "jwt"}}.CookieSigningKey())
+}
+
+func TestConfig_JwtClaims(t *testing.T) {
+	assert.Equal(t, DEFAULT_JWT_ISSUER, Config{}.JwtIssuer())
+	assert.Equal(t, DEFAULT_JWT_AUDIENCE, Config{}.JwtAudience())
+
+	configured := Config{Security: SecurityConfig{JwtIssuer: "auth.example.com", JwtAudience: "api.example.com"}}
+	assert.Equal(t, "auth.example.com", configured.JwtIssuer())
+	assert.Equal(t, "api.example.com", configured.JwtAudience())
+}
+
+func TestConfig_WriteLimits(t *testing.T) {
+	cfg := Config{Database: DatabaseConfig{WriteLimits: "import=1:4, broadcast = 3"}}
+
+	limits, err := cfg.WriteLimits()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]WriteLimit{
+		"import":    {Slots: 1, Queue: 4},
+		"broadcast": {Slots: 3, Queue: DEFAULT_WRITE_LIMIT_QUEUE},
+	}, limits)
+	assert.Equal(t, WriteLimit{Slots: 1, Queue: 4}, cfg.WriteLimit("import"))
+	assert.Equal(t, WriteLimit{Slots: DEFAULT_WRITE_LIMIT_SLOTS, Queue: DEFAULT_WRITE_LIMIT_QUEUE}, cfg.WriteLimit("backup"))
+
+	for _, invalid := range []string{"import", "=2", "import=0", "import=two", "import=1:-1", "import=1:x"} {
+		_, err := Config{Database: DatabaseConfig{WriteLimits: invalid}}.WriteLimits()
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestConfig_Values(t *testing.T) {
+	values := Config{
+		Environment:     "production",
+		DiagnosticsPath: "diagnostics/report.json",
+		Server:          ServerConfig{Port: 8280},
+		Security:        SecurityConfig{JwtSecret: "jwt"},
+		Database:        DatabaseConfig{BackupInterval: 90 * time.Minute},
+	}.Values()
+
+	assert.Equal(t, "production", values["environment"])
+	assert.Equal(t, "diagnostics/report.json", values["diagnostics_path"])
+	assert.Equal(t, 8280, values["server.port"])
+	assert.Equal(t, "jwt", values["security.jwt_secret"])
+	assert.Equal(t, "1h30m0s", values["database.backup_interval"])
+	assert.NotContains(t, values, "server")
+}
+
+func TestValidateConfig_WarnsOnLowProductionBcryptCost(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config Config
+		warns  bool
+	}{
+		{name: "production default", config: Config{Server: ServerConfig{Port: 8080}, Environment: "production"}},
+		{name: "production at minimum", config: Config{Server: ServerConfig{Port: 8080}, Environment: "production", Security: SecurityConfig{Salt: 10}}},
+		{name: "production below minimum", config: Config{Server: ServerConfig{Port: 8080}, Environment: "production", Security: SecurityConfig{Salt: 8}}, warns: true},
+		{name: "development default", config: Config{Server: ServerConfig{Port: 8080}, Environment: "development"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log := logger.NewWithHandler("config", slog.NewJSONHandler(&logs, nil))
+
+			require.NoError(t, validateConfig(tc.config, log))
+			assert.Equal(t, tc.warns, strings.Contains(logs.String(), "bcrypt cost is too low"))
+		})
+	}
+}
+
+func TestInitConfig_LegacyEnvAliases(t *testing.T) {
+	clearEnvVars(t)
+
+	envFile := createTempEnvFile(t, "SERVER_PORT=8080\nDB_CACHE_PORT=6380\nCACHE_ADDRESS=valkey")
+	defer func() { _ = os.Remove(envFile) }()
+
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() { _ = os.Chdir(originalDir) }()
+	require.NoError(t, os.Chdir(filepath.Dir(envFile)))
+
+	t.Setenv("DB_PATH", "/tmp/legacy.db")
+	t.Setenv("CORS_ALLOW_ORIGINS", "http://legacy.example.com")
+	t.Setenv("SERVER_CORS_ALLOW_ORIGINS", "http://current.example.com")
+
+	config, err := InitConfig()
+
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/legacy.db", config.Database.Path, "legacy name used when the new one is unset")
+	assert.Equal(t, 6380, config.Cache.Port, "legacy name read from .env")
+	assert.Equal(t, "valkey", config.Cache.Address)
+	assert.Equal(t, "http://current.example.com", config.Server.CorsAllowOrigins, "new name wins over the legacy one")
+}
+
+func TestBindSettings_WarnsOnLegacyNames(t *testing.T) {
+	clearEnvVars(t)
+	t.Setenv("LOG_COMPONENT_LEVELS", "websockets=warn")
+
+	var logs bytes.Buffer
+	log := logger.NewWithHandler("config", slog.NewJSONHandler(&logs, nil))
+	envFile := viper.New()
+	envFile.Set("DB_BACKUP_DIR", "legacy/backups")
+	envFile.Set("DATABASE_BACKUP_RETENTION", 3)
+
+	v := viper.New()
+	setDefaults(v)
+	require.NoError(t, bindSettings(v, envFile, log))
+
+	assert.Equal(t, "websockets=warn", v.GetString("logging.component_levels"))
+	assert.Equal(t, "legacy/backups", v.GetString("database.backup_dir"))
+	assert.Equal(t, 3, v.GetInt("database.backup_retention"))
+	assert.Equal(t, "24h", v.GetString("database.backup_interval"))
+
+	output := logs.String()
+	assert.Contains(t, output, `"name":"LOG_COMPONENT_LEVELS"`)
+	assert.Contains(t, output, `"name":"DB_BACKUP_DIR"`)
+	assert.NotContains(t, output, "DB_BACKUP_RETENTION")
+}
+
+func TestValidateConfig_NamesFailingSection(t *testing.T) {
+	testCases := []struct {
+		section string
+		config  Config
+		message string
+	}{
+		{"server", Config{Server: ServerConfig{Port: -1}}, "server config: invalid port: -1"},
+		{"database", Config{Server: ServerConfig{Port: 8080}, Database: DatabaseConfig{BackupDir: "backups"}}, "database config: invalid backup interval: 0s"},
+		{"security", Config{Server: ServerConfig{Port: 8080}, Security: SecurityConfig{PepperVersion: -1}}, "security config: invalid pepper version: -1"},
+		{"session", Config{Server: ServerConfig{Port: 8080}, Session: SessionConfig{CookieSameSite: "loose"}}, `session config: invalid cookie SameSite mode: "loose"`},
+		{"websocket", Config{Server: ServerConfig{Port: 8080}, Websocket: WebsocketConfig{DrainBatchSize: -1}}, "websocket config: invalid drain window 0s or batch size -1"},
+		{"logging", Config{Server: ServerConfig{Port: 8080}, Logging: LoggingConfig{ComponentLevels: "websockets"}}, "logging config: invalid component levels"},
+		{"audit", Config{Server: ServerConfig{Port: 8080}, Audit: AuditConfig{RetentionDays: -1}}, "audit config: invalid retention -1 days"},
+	}
+
+	log := logger.New("test")
+	for _, tc := range testCases {
+		t.Run(tc.section, func(t *testing.T) {
+			err := validateConfig(tc.config, log)
+
+			require.Error(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), tc.message), err.Error())
+			var sectionErr *SectionError
+			require.True(t, errors.As(err, &sectionErr))
+			assert.Equal(t, tc.section, sectionErr.Section)
+		})
+	}
+}
+
 func clearEnvVars(t *testing.T) {
 	// Clear all config-related environment variables
 	envVars := []string{
-		"GENERAL_VERSION", "ENVIRONMENT", "SERVER_PORT", "DB_PATH",
-		"DB_CACHE_ADDRESS", "DB_CACHE_PORT", "CORS_ALLOW_ORIGINS",
+		"GENERAL_VERSION", "ENVIRONMENT", "SERVER_PORT", "DATABASE_PATH",
+		"CACHE_ADDRESS", "CACHE_PORT", "SERVER_CORS_ALLOW_ORIGINS",
 		"SECURITY_SALT", "SECURITY_PEPPER", "SECURITY_JWT_SECRET",
 	}
+	for _, legacy := range LegacyEnvAliases {
+		envVars = append(envVars, legacy)
+	}
 	for _, envVar := range envVars {
 		_ = os.Unsetenv(envVar)
 	}
@@ -453,3 +976,37 @@ func createTempDir(t *testing.T) string {
 	require.NoError(t, err)
 	return tmpDir
 }
+
+func TestValidateConfig_WellKnown(t *testing.T) {
+	log := logger.New("test")
+	valid := Config{Server: ServerConfig{Port: 8080}}
+
+	for _, base := range []string{"", "http://localhost:3010", "https://app.example.com/"} {
+		config := valid
+		config.FrontendBaseURL = base
+		assert.NoError(t, validateConfig(config, log), base)
+	}
+
+	for _, base := range []string{"app.example.com", "/app", "ftp://app.example.com", "https://"} {
+		config := valid
+		config.FrontendBaseURL = base
+		assert.Error(t, validateConfig(config, log), base)
+	}
+
+	securityTxt := valid
+	securityTxt.WellKnown = WellKnownConfig{SecurityContact: "mailto:security@example.com", SecurityExpires: "2027-04-01"}
+	assert.NoError(t, validateConfig(securityTxt, log))
+
+	invalid := []WellKnownConfig{
+		{ChangePasswordPath: "profile"},
+		{SecurityContact: "security@example.com", SecurityExpires: "2027-04-01"},
+		{SecurityContact: "mailto:security@example.com"},
+		{SecurityContact: "mailto:security@example.com", SecurityExpires: "April 2027"},
+		{SecurityContact: "mailto:security@example.com", SecurityExpires: "2027-04-01", SecurityPolicyURL: "/security"},
+	}
+	for _, wellKnown := range invalid {
+		config := valid
+		config.WellKnown = wellKnown
+		assert.Error(t, validateConfig(config, log), wellKnown)
+	}
+}
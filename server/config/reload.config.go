@@ -0,0 +1,119 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"server/internal/logger"
+	"slices"
+	"strings"
+)
+
+const (
+	// REDACTED_SETTING replaces the values of secret settings in a Change
+	REDACTED_SETTING = "[REDACTED]"
+
+	// Keys of the settings a reload may change
+	SETTING_SERVER_CORS_ALLOW_ORIGINS = "server.cors_allow_origins"
+	SETTING_LOGGING_COMPONENT_LEVELS  = "logging.component_levels"
+)
+
+var ErrNotReloadable = errors.New("settings can't be changed without a restart")
+
+// reloadableSettings are the settings a reload may change. Everything else
+// is read once while the server starts, so a changed value would not take
+// effect and the reload is refused instead.
+var reloadableSettings = []string{
+	SETTING_SERVER_CORS_ALLOW_ORIGINS,
+	SETTING_LOGGING_COMPONENT_LEVELS,
+}
+
+// Settings whose keys contain one of these are never written out in a
+// Change, only that they changed.
+var secretSettings = []string{"pepper", "secret", "password", "token", "cookie_key", "data_key"}
+
+// Change is one setting a reload changed, with secrets redacted.
+type Change struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// NotReloadableError names the settings a refused reload would have changed.
+type NotReloadableError struct {
+	Fields []string
+}
+
+func (e *NotReloadableError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrNotReloadable, strings.Join(e.Fields, ", "))
+}
+
+func (e *NotReloadableError) Unwrap() error {
+	return ErrNotReloadable
+}
+
+// Load reads and validates the config the way InitConfig does, without
+// making it the current one, so a reload can be checked before it applies.
+func Load() (Config, error) {
+	log := logger.New("config").Function("Load")
+
+	config, err := read(log)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := validateSections(config, log); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Diff lists the settings that differ between previous and next, by key,
+// e.g. server.cors_allow_origins, in key order.
+func Diff(previous Config, next Config) []Change {
+	oldValues, newValues := previous.Values(), next.Values()
+
+	var changes []Change
+	for _, setting := range settings() {
+		before, after := oldValues[setting.key], newValues[setting.key]
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		if isSecretSetting(setting.key) {
+			before, after = REDACTED_SETTING, REDACTED_SETTING
+		}
+		changes = append(changes, Change{Field: setting.key, Old: before, New: after})
+	}
+	slices.SortFunc(changes, func(a, b Change) int { return strings.Compare(a.Field, b.Field) })
+	return changes
+}
+
+// CheckReloadable returns a *NotReloadableError naming every changed
+// setting a reload can't apply, or nil when all of them can be.
+func CheckReloadable(changes []Change) error {
+	var fields []string
+	for _, change := range changes {
+		if !slices.Contains(reloadableSettings, change.Field) {
+			fields = append(fields, change.Field)
+		}
+	}
+	if len(fields) > 0 {
+		return &NotReloadableError{Fields: fields}
+	}
+	return nil
+}
+
+// Apply makes a config returned by Load the one GetConfig returns.
+// Subsystems that keep their own copy react to the config.changed event
+// instead.
+func Apply(config Config) {
+	ConfigInstance = config
+}
+
+func isSecretSetting(key string) bool {
+	for _, secret := range secretSettings {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"server/internal/logger"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	previous := Config{
+		Environment: "production",
+		Server:      ServerConfig{Port: 8280, CorsAllowOrigins: "https://app.example.com"},
+		Database:    DatabaseConfig{BackupInterval: time.Hour},
+		Logging:     LoggingConfig{ComponentLevels: "database=warn"},
+	}
+
+	assert.Empty(t, Diff(previous, previous))
+
+	next := previous
+	next.Environment = "staging"
+	next.Server.CorsAllowOrigins = "https://app.example.com,https://admin.example.com"
+	next.Database.BackupInterval = 90 * time.Minute
+	next.Logging.ComponentLevels = ""
+
+	assert.Equal(t, []Change{
+		{Field: "database.backup_interval", Old: "1h0m0s", New: "1h30m0s"},
+		{Field: "environment", Old: "production", New: "staging"},
+		{Field: "logging.component_levels", Old: "database=warn", New: ""},
+		{Field: "server.cors_allow_origins", Old: "https://app.example.com", New: "https://app.example.com,https://admin.example.com"},
+	}, Diff(previous, next))
+}
+
+func TestDiff_RedactsSecrets(t *testing.T) {
+	previous := Config{Security: SecurityConfig{
+		Pepper:    "old-pepper",
+		JwtSecret: "old-secret",
+		CookieKey: "old-key",
+		Salt:      10,
+	}}
+	next := Config{Security: SecurityConfig{
+		Pepper:         "new-pepper",
+		PepperPrevious: "old-pepper",
+		JwtSecret:      "new-secret",
+		CookieKey:      "new-key",
+		Salt:           12,
+	}}
+
+	changes := Diff(previous, next)
+	assert.Equal(t, []Change{
+		{Field: "security.cookie_key", Old: REDACTED_SETTING, New: REDACTED_SETTING},
+		{Field: "security.jwt_secret", Old: REDACTED_SETTING, New: REDACTED_SETTING},
+		{Field: "security.pepper", Old: REDACTED_SETTING, New: REDACTED_SETTING},
+		{Field: "security.pepper_previous", Old: REDACTED_SETTING, New: REDACTED_SETTING},
+		{Field: "security.salt", Old: 10, New: 12},
+	}, changes)
+	for _, change := range changes {
+		for _, secret := range []string{"old-pepper", "new-pepper", "old-secret", "new-secret", "old-key", "new-key"} {
+			assert.NotEqual(t, secret, change.Old)
+			assert.NotEqual(t, secret, change.New)
+		}
+	}
+}
+
+func TestCheckReloadable(t *testing.T) {
+	reloadable := []Change{
+		{Field: SETTING_SERVER_CORS_ALLOW_ORIGINS, Old: "a", New: "b"},
+		{Field: SETTING_LOGGING_COMPONENT_LEVELS, Old: "", New: "database=debug"},
+	}
+	assert.NoError(t, CheckReloadable(reloadable))
+	assert.NoError(t, CheckReloadable(nil))
+
+	err := CheckReloadable(append(reloadable,
+		Change{Field: "database.path", Old: "a.db", New: "b.db"},
+		Change{Field: "server.port", Old: 8280, New: 8281},
+	))
+	require.ErrorIs(t, err, ErrNotReloadable)
+	var notReloadable *NotReloadableError
+	require.True(t, errors.As(err, &notReloadable))
+	assert.Equal(t, []string{"database.path", "server.port"}, notReloadable.Fields)
+	assert.Contains(t, err.Error(), "database.path, server.port")
+}
+
+func TestLoad_DoesNotApply(t *testing.T) {
+	clearEnvVars(t)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("ENVIRONMENT=test\nSERVER_PORT=8280\nSERVER_CORS_ALLOW_ORIGINS=https://reloaded.example.com\n"), 0o600))
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.Chdir(originalDir) })
+	require.NoError(t, os.Chdir(dir))
+
+	running := Config{Environment: "test", Server: ServerConfig{Port: 8280, CorsAllowOrigins: "https://running.example.com"}}
+	require.NoError(t, validateConfig(running, logger.New("test")))
+
+	loaded, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, "https://reloaded.example.com", loaded.Server.CorsAllowOrigins)
+	assert.Equal(t, running, GetConfig(), "loading leaves the running config in place")
+
+	Apply(loaded)
+	assert.Equal(t, loaded, GetConfig())
+}
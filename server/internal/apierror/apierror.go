@@ -0,0 +1,212 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"server/config"
+	"server/internal/logger"
+	"server/internal/utils"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	PROBLEM_CONTENT_TYPE = "application/problem+json"
+
+	// TYPE_PATH is where each code's definition is served, and so the path
+	// of a problem's type URI
+	TYPE_PATH = "/api/v1/problems/"
+
+	CODE_BAD_REQUEST        = "bad_request"
+	CODE_NOT_FOUND          = "not_found"
+	CODE_METHOD_NOT_ALLOWED = "method_not_allowed"
+	CODE_PAYLOAD_TOO_LARGE  = "payload_too_large"
+	CODE_UPGRADE_REQUIRED   = "upgrade_required"
+	CODE_INTERNAL           = "internal_error"
+
+	// A list cursor that isn't one the list handed out
+	CODE_INVALID_CURSOR = "invalid_cursor"
+)
+
+// Definition is one kind of error the API answers with. Both renderings of
+// an error come from its definition, so they can't drift apart.
+type Definition struct {
+	Code   string `json:"code"`
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+}
+
+var (
+	mutex       sync.RWMutex
+	definitions = make(map[string]Definition)
+)
+
+func init() {
+	Register(Definition{Code: CODE_BAD_REQUEST, Status: fiber.StatusBadRequest, Title: "Bad request"})
+	Register(Definition{Code: CODE_NOT_FOUND, Status: fiber.StatusNotFound, Title: "Not found"})
+	Register(Definition{Code: CODE_METHOD_NOT_ALLOWED, Status: fiber.StatusMethodNotAllowed, Title: "Method not allowed"})
+	Register(Definition{Code: CODE_PAYLOAD_TOO_LARGE, Status: fiber.StatusRequestEntityTooLarge, Title: "Payload too large"})
+	Register(Definition{Code: CODE_UPGRADE_REQUIRED, Status: fiber.StatusUpgradeRequired, Title: "Upgrade required"})
+	Register(Definition{Code: CODE_INTERNAL, Status: fiber.StatusInternalServerError, Title: "Internal server error"})
+	Register(Definition{Code: CODE_INVALID_CURSOR, Status: fiber.StatusBadRequest, Title: "Invalid cursor"})
+}
+
+// Register adds a definition. Packages register the codes they answer with
+// from init. It panics on a duplicate or incomplete definition, since that is
+// a programming error.
+func Register(definition Definition) {
+	if definition.Code == "" || definition.Title == "" || http.StatusText(definition.Status) == "" {
+		panic(fmt.Sprintf("apierror: incomplete definition %+v", definition))
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := definitions[definition.Code]; ok {
+		panic(fmt.Sprintf("apierror: %q registered twice", definition.Code))
+	}
+	definitions[definition.Code] = definition
+}
+
+// Lookup returns the definition of code.
+func Lookup(code string) (Definition, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	definition, ok := definitions[code]
+	return definition, ok
+}
+
+// Definitions lists every registered definition by code.
+func Definitions() []Definition {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	registered := make([]Definition, 0, len(definitions))
+	for _, definition := range definitions {
+		registered = append(registered, definition)
+	}
+	slices.SortFunc(registered, func(a, b Definition) int {
+		return strings.Compare(a.Code, b.Code)
+	})
+	return registered
+}
+
+// APIError is an error response. Extensions are extra members of the body in
+// either rendering.
+type APIError struct {
+	Definition
+	Message    string
+	Extensions map[string]any
+}
+
+// New builds an APIError for a registered code. It panics on an unknown code.
+func New(code string, message string) *APIError {
+	definition, ok := Lookup(code)
+	if !ok {
+		panic(fmt.Sprintf("apierror: %q is not registered", code))
+	}
+	return &APIError{Definition: definition, Message: message}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// With adds an extension member to the body.
+func (e *APIError) With(key string, value any) *APIError {
+	if e.Extensions == nil {
+		e.Extensions = make(map[string]any)
+	}
+	e.Extensions[key] = value
+	return e
+}
+
+// TypeURI is where the definition of the error's code is served.
+func TypeURI(c *fiber.Ctx, code string) string {
+	return utils.ExternalURL(c).String() + TYPE_PATH + code
+}
+
+// Send writes apiErr as a problem+json document when the client prefers one
+// or the config forces it, and as the APIError JSON otherwise.
+func Send(c *fiber.Ctx, apiErr *APIError, config config.Config) error {
+	body := make(fiber.Map, len(apiErr.Extensions)+6)
+	for key, value := range apiErr.Extensions {
+		body[key] = value
+	}
+	body["code"] = apiErr.Code
+
+	c.Status(apiErr.Status)
+	if !prefersProblem(c, config) {
+		body["error"] = apiErr.Message
+		return c.JSON(body)
+	}
+
+	body["type"] = TypeURI(c, apiErr.Code)
+	body["title"] = apiErr.Title
+	body["status"] = apiErr.Status
+	body["detail"] = apiErr.Message
+	body["instance"] = c.Path()
+	if requestID := c.GetRespHeader(fiber.HeaderXRequestID); requestID != "" {
+		body["requestId"] = requestID
+	}
+	return c.JSON(body, PROBLEM_CONTENT_TYPE)
+}
+
+func prefersProblem(c *fiber.Ctx, config config.Config) bool {
+	if config.Server.ProblemJSON {
+		return true
+	}
+	// Offers are tried in order, so anything that doesn't name problem+json
+	// ahead of plain JSON keeps the APIError shape
+	return c.Accepts(fiber.MIMEApplicationJSON, PROBLEM_CONTENT_TYPE) == PROBLEM_CONTENT_TYPE
+}
+
+// Handler renders errors returned from handlers and middleware. Fiber's own
+// errors, like an unknown route, map to the definition for their status;
+// anything else is logged and answered with a bare internal error.
+func Handler(config config.Config) fiber.ErrorHandler {
+	return handler(config, logger.New("apierror"))
+}
+
+func handler(config config.Config, log logger.Logger) fiber.ErrorHandler {
+	log = log.Function("Handler")
+
+	return func(c *fiber.Ctx, err error) error {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			return Send(c, apiErr, config)
+		}
+
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			return Send(c, fromStatus(fiberErr.Code, fiberErr.Message), config)
+		}
+
+		log.Er("unhandled error", err, "path", c.Path(), "requestID", c.GetRespHeader(fiber.HeaderXRequestID))
+		return Send(c, New(CODE_INTERNAL, "Internal server error"), config)
+	}
+}
+
+// statusCodes are the generic codes for the statuses Fiber answers with on
+// its own.
+var statusCodes = map[int]string{
+	fiber.StatusBadRequest:            CODE_BAD_REQUEST,
+	fiber.StatusNotFound:              CODE_NOT_FOUND,
+	fiber.StatusMethodNotAllowed:      CODE_METHOD_NOT_ALLOWED,
+	fiber.StatusRequestEntityTooLarge: CODE_PAYLOAD_TOO_LARGE,
+	fiber.StatusUpgradeRequired:       CODE_UPGRADE_REQUIRED,
+}
+
+// fromStatus uses the generic code for status, or the internal error code
+// with status kept when there is none.
+func fromStatus(status int, message string) *APIError {
+	if code, ok := statusCodes[status]; ok {
+		return New(code, message)
+	}
+	apiErr := New(CODE_INTERNAL, message)
+	apiErr.Status = status
+	return apiErr
+}
@@ -0,0 +1,205 @@
+package apierror
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/logger"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const CODE_TEST_CONFLICT = "test_conflict"
+
+func init() {
+	Register(Definition{Code: CODE_TEST_CONFLICT, Status: fiber.StatusConflict, Title: "Test conflict"})
+}
+
+// setupErrorApp answers /fail with err through Handler, the way the server
+// does, and gives every request the ID "request-1".
+func setupErrorApp(err error, cfg config.Config) *fiber.App {
+	fiberApp := fiber.New(fiber.Config{ErrorHandler: Handler(cfg)})
+	fiberApp.Use(requestid.New(requestid.Config{Generator: func() string { return "request-1" }}))
+	fiberApp.Get("/fail", func(c *fiber.Ctx) error {
+		return err
+	})
+	return fiberApp
+}
+
+func requestError(t *testing.T, fiberApp *fiber.App, path string, accept string) (int, string, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	if accept != "" {
+		req.Header.Set(fiber.HeaderAccept, accept)
+	}
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, resp.Header.Get(fiber.HeaderContentType), body
+}
+
+func TestSend_BothRenderings(t *testing.T) {
+	testCases := []struct {
+		name       string
+		err        error
+		status     int
+		code       string
+		title      string
+		detail     string
+		extensions map[string]any
+	}{
+		{
+			name:   "registered error",
+			err:    New(CODE_TEST_CONFLICT, "Already taken"),
+			status: fiber.StatusConflict, code: CODE_TEST_CONFLICT, title: "Test conflict", detail: "Already taken",
+		},
+		{
+			name:   "with extensions",
+			err:    New(CODE_TEST_CONFLICT, "Already taken").With("version", 3),
+			status: fiber.StatusConflict, code: CODE_TEST_CONFLICT, title: "Test conflict", detail: "Already taken",
+			extensions: map[string]any{"version": float64(3)},
+		},
+		{
+			name:   "fiber error",
+			err:    fiber.ErrMethodNotAllowed,
+			status: fiber.StatusMethodNotAllowed, code: CODE_METHOD_NOT_ALLOWED, title: "Method not allowed", detail: "Method Not Allowed",
+		},
+		{
+			name:   "unexpected error",
+			err:    errors.New("disk full"),
+			status: fiber.StatusInternalServerError, code: CODE_INTERNAL, title: "Internal server error", detail: "Internal server error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fiberApp := setupErrorApp(tc.err, config.Config{})
+
+			status, contentType, body := requestError(t, fiberApp, "/fail", fiber.MIMEApplicationJSON)
+			assert.Equal(t, tc.status, status)
+			assert.Equal(t, fiber.MIMEApplicationJSON, contentType)
+			expected := map[string]any{"error": tc.detail, "code": tc.code}
+			for key, value := range tc.extensions {
+				expected[key] = value
+			}
+			assert.Equal(t, expected, body)
+
+			status, contentType, body = requestError(t, fiberApp, "/fail", PROBLEM_CONTENT_TYPE)
+			assert.Equal(t, tc.status, status)
+			assert.Equal(t, PROBLEM_CONTENT_TYPE, contentType)
+			expected = map[string]any{
+				"type":      "http://example.com" + TYPE_PATH + tc.code,
+				"title":     tc.title,
+				"status":    float64(tc.status),
+				"detail":    tc.detail,
+				"instance":  "/fail",
+				"code":      tc.code,
+				"requestId": "request-1",
+			}
+			for key, value := range tc.extensions {
+				expected[key] = value
+			}
+			assert.Equal(t, expected, body)
+		})
+	}
+}
+
+func TestSend_AcceptNegotiation(t *testing.T) {
+	testCases := []struct {
+		accept  string
+		problem bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"application/problem+json", true},
+		{"application/problem+json, application/json", true},
+		{"application/json, application/problem+json", false},
+		{"application/json;q=0.5, application/problem+json", true},
+		{"text/html", false},
+	}
+
+	fiberApp := setupErrorApp(New(CODE_TEST_CONFLICT, "Already taken"), config.Config{})
+	for _, tc := range testCases {
+		_, contentType, body := requestError(t, fiberApp, "/fail", tc.accept)
+		if tc.problem {
+			assert.Equal(t, PROBLEM_CONTENT_TYPE, contentType, tc.accept)
+			assert.Equal(t, "Already taken", body["detail"], tc.accept)
+		} else {
+			assert.Equal(t, fiber.MIMEApplicationJSON, contentType, tc.accept)
+			assert.Equal(t, "Already taken", body["error"], tc.accept)
+		}
+	}
+}
+
+func TestSend_ForcedByConfig(t *testing.T) {
+	fiberApp := setupErrorApp(New(CODE_TEST_CONFLICT, "Already taken"), config.Config{Server: config.ServerConfig{ProblemJSON: true}})
+
+	_, contentType, body := requestError(t, fiberApp, "/fail", fiber.MIMEApplicationJSON)
+
+	assert.Equal(t, PROBLEM_CONTENT_TYPE, contentType)
+	assert.Equal(t, "Test conflict", body["title"])
+}
+
+func TestHandler_UnknownRoute(t *testing.T) {
+	fiberApp := setupErrorApp(nil, config.Config{})
+
+	status, _, body := requestError(t, fiberApp, "/missing", PROBLEM_CONTENT_TYPE)
+
+	assert.Equal(t, fiber.StatusNotFound, status)
+	assert.Equal(t, CODE_NOT_FOUND, body["code"])
+	assert.Equal(t, "/missing", body["instance"])
+}
+
+func TestHandler_LogsUnexpectedErrors(t *testing.T) {
+	var logs bytes.Buffer
+	fiberApp := fiber.New(fiber.Config{
+		ErrorHandler: handler(config.Config{}, logger.NewWithHandler("test", slog.NewJSONHandler(&logs, nil))),
+	})
+	fiberApp.Use(requestid.New(requestid.Config{Generator: func() string { return "request-1" }}))
+	fiberApp.Get("/fail", func(c *fiber.Ctx) error {
+		return errors.New("disk full")
+	})
+
+	_, _, body := requestError(t, fiberApp, "/fail", "")
+
+	assert.NotContains(t, body["error"], "disk full", "internal errors stay out of the response")
+	assert.Contains(t, logs.String(), "disk full")
+	assert.Contains(t, logs.String(), "request-1")
+}
+
+func TestStatusCodesAreRegistered(t *testing.T) {
+	for status, code := range statusCodes {
+		definition, ok := Lookup(code)
+		require.True(t, ok, code)
+		assert.Equal(t, status, definition.Status, code)
+	}
+}
+
+func TestRegister_Panics(t *testing.T) {
+	assert.Panics(t, func() {
+		Register(Definition{Code: CODE_NOT_FOUND, Status: fiber.StatusNotFound, Title: "Again"})
+	})
+	assert.Panics(t, func() { Register(Definition{Code: "no_title", Status: fiber.StatusTeapot}) })
+	assert.Panics(t, func() { Register(Definition{Code: "bad_status", Status: 999, Title: "Bad"}) })
+	assert.Panics(t, func() { New("never_registered", "") })
+}
+
+func TestDefinitions_SortedByCode(t *testing.T) {
+	definitions := Definitions()
+
+	require.NotEmpty(t, definitions)
+	for i := 1; i < len(definitions); i++ {
+		assert.Less(t, definitions[i-1].Code, definitions[i].Code)
+	}
+	assert.Contains(t, definitions, Definition{Code: CODE_TEST_CONFLICT, Status: fiber.StatusConflict, Title: "Test conflict"})
+}
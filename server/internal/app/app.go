@@ -1,32 +1,72 @@
 package app
 
 import (
+	"context"
 	"server/config"
+	"server/internal/clock"
 	"server/internal/database"
+	"server/internal/diagnostics"
 	"server/internal/events"
+	"server/internal/health"
+	"server/internal/hooks"
 	"server/internal/logger"
+	"server/internal/maintenance"
+	"server/internal/metrics"
+	"server/internal/models"
 	"server/internal/repositories"
 	"server/internal/routes/middleware"
+	"server/internal/scheduler"
+	"server/internal/tracing"
 	"server/internal/websockets"
+	"time"
 
 	adminController "server/internal/controllers/admin"
 	userController "server/internal/controllers/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	TRACING_FLUSH_INTERVAL   = 5 * time.Second
+	TRACING_SHUTDOWN_TIMEOUT = 5 * time.Second
 )
 
+// RouteRegistrar is implemented by anything that mounts its own HTTP routes,
+// including whatever middleware those routes need.
+type RouteRegistrar interface {
+	RegisterRoutes(router fiber.Router)
+}
+
 type App struct {
-	Database   database.DB
-	Middleware middleware.Middleware
-	Websocket  *websockets.Manager
-	EventBus   *events.EventBus
-	Config     config.Config
+	Database    database.DB
+	Middleware  middleware.Middleware
+	Websocket   *websockets.Manager
+	EventBus    *events.EventBus
+	Scheduler   *scheduler.Scheduler
+	Backup      *database.Backup
+	Maintenance *maintenance.Mode
+	Reloader    *ConfigReloader
+	Health      *health.Registry
+	Latency     *metrics.LatencyTracker
+	Tracer      *tracing.Tracer
+	Clock       clock.Clock
+	Config      config.Config
 
 	// Repositories
-	UserRepo    repositories.UserRepository
-	SessionRepo repositories.SessionRepository
+	UserRepo         repositories.UserRepository
+	SessionRepo      repositories.SessionRepository
+	LoginEventRepo   repositories.LoginEventRepository
+	AnnouncementRepo repositories.AnnouncementRepository
+	StatsRepo        repositories.StatsRepository
+	PreferenceRepo   repositories.PreferenceRepository
+	AuditRepo        repositories.AuditRepository
 
 	// Controllers
 	UserController  *userController.UserController
 	AdminController *adminController.AdminController
+
+	// Registrars are mounted under /api/v1 and the legacy /api, in order
+	Registrars []RouteRegistrar
 }
 
 func New() (*App, error) {
@@ -34,49 +74,245 @@ func New() (*App, error) {
 
 	config, err := config.InitConfig()
 	if err != nil {
-		return &App{}, log.Err("failed to initialize config", err)
+		return startupFailed(diagnostics.STAGE_CONFIG, config, log.Err("failed to initialize config", err))
 	}
 
-	db, err := database.New(config)
+	tracer, err := startTracing(config)
 	if err != nil {
-		return &App{}, log.Err("failed to create database", err)
+		return startupFailed(diagnostics.STAGE_CONFIG, config, log.Err("failed to start tracing", err))
 	}
 
-	eventBus := events.New(db.Cache.Events, config)
+	db, err := database.NewSQL(config)
+	if err != nil {
+		return startupFailed(diagnostics.STAGE_DATABASE, config, log.Err("failed to create database", err))
+	}
+
+	if config.Database.MigrationsDir != "" {
+		pending, err := db.PendingMigrations(config.Database.MigrationsDir)
+		if err != nil {
+			return startupFailed(diagnostics.STAGE_MIGRATIONS, config, log.Err("failed to check migrations", err))
+		}
+		if len(pending) > 0 {
+			log.Warn("Database has pending migrations, run the migration command", "pending", pending)
+		}
+	}
+
+	retired := make([]string, 0, len(models.Retired()))
+	for _, table := range models.Retired() {
+		retired = append(retired, table.Table)
+	}
+	if present := db.ExistingTables(retired); len(present) > 0 {
+		log.Warn("Database has retired tables, rename them with the migration command's drop-retired", "tables", present)
+	}
+
+	if err := db.ConnectCache(config); err != nil {
+		return startupFailed(diagnostics.STAGE_CACHE, config, log.Err("failed to connect to cache", err))
+	}
+
+	clock := clock.New()
+	eventBus := events.New(db.Cache.Events, config, clock)
+
+	invalidator, err := database.NewInvalidator(eventBus.CacheInvalidationTopic())
+	if err != nil {
+		return startupFailed(diagnostics.STAGE_SERVICES, config, log.Err("failed to create cache invalidator", err))
+	}
+
+	maintenanceMode, err := maintenance.New(
+		context.Background(),
+		database.NewCacheStore(db.Cache.General),
+		eventBus.MaintenanceTopic(),
+		config,
+		clock,
+	)
+	if err != nil {
+		return startupFailed(diagnostics.STAGE_SERVICES, config, log.Err("failed to restore maintenance mode", err))
+	}
 
 	// Initialize repositories
-	userRepo := repositories.New(db)
-	sessionRepo := repositories.NewSessionRepository(db)
+	userRepo := repositories.New(db, invalidator)
+	sessionRepo := repositories.NewSessionRepository(db, clock)
+	loginEventRepo := repositories.NewLoginEventRepository(db)
+	announcementRepo := repositories.NewAnnouncementRepository(db)
+	statsRepo := repositories.NewStatsRepository(db)
+	preferenceRepo := repositories.NewPreferenceRepository(db, invalidator, clock)
+	auditRepo := repositories.NewAuditRepository(db)
 
 	// Initialize services with repositories
-	middleware := middleware.New(db, eventBus, config, userRepo, sessionRepo)
-	userController := userController.New(eventBus, userRepo, sessionRepo, config)
-	adminController := adminController.New(eventBus, userRepo, config)
+	responseCache := middleware.NewResponseCache(database.NewCacheStore(db.Cache.General), clock)
+	writeLimits := middleware.NewConcurrencyLimits(config)
+	latency := metrics.NewLatencyTracker(metrics.DEFAULT_LATENCY_ROUTES, clock)
+	middleware := middleware.New(db, eventBus, config, userRepo, sessionRepo, clock)
+	challenge, err := userController.NewChallengeProvider(config)
+	if err != nil {
+		return startupFailed(diagnostics.STAGE_SERVICES, config, log.Err("failed to create login challenge provider", err))
+	}
+	capacity := userController.NewCapacity(config, statsRepo, sessionRepo, eventBus.SystemAlertTopic(), auditRepo, clock)
+	userController := userController.New(
+		eventBus,
+		userRepo,
+		sessionRepo,
+		loginEventRepo,
+		preferenceRepo,
+		auditRepo,
+		middleware,
+		config,
+	)
+	userController.SetChallengeProvider(challenge)
+	userController.SetCapacity(capacity)
+	adminController := adminController.New(
+		eventBus,
+		userRepo,
+		loginEventRepo,
+		announcementRepo,
+		sessionRepo,
+		statsRepo,
+		auditRepo,
+		database.NewCacheStore(db.Cache.General),
+		middleware,
+		config,
+		clock,
+	)
 
-	websocket, err := websockets.New(db, eventBus, config)
+	websocket, err := websockets.New(db, eventBus, config, clock)
 	if err != nil {
-		return &App{}, log.Err("failed to create websocket manager", err)
+		return startupFailed(diagnostics.STAGE_SERVICES, config, log.Err("failed to create websocket manager", err))
+	}
+	adminController.SetWebSocketManager(websocket)
+	adminController.SetMaintenance(maintenanceMode)
+	adminController.SetResponseCache(responseCache)
+	adminController.SetWriteLimits(writeLimits)
+	adminController.SetLatency(latency)
+	adminController.SetCaches(
+		database.NewCacheStore(db.Cache.General),
+		database.NewCacheStore(db.Cache.Session),
+		database.NewCacheStore(db.Cache.User),
+	)
+	websocket.SetNotificationPreferences(preferenceRepo, invalidator)
+	websocket.SetMaintenance(maintenanceMode)
+	websocket.SetResumeStore(database.NewCacheStore(db.Cache.Session))
+	websocket.SetUserLookup(userRepo)
+	websocket.SetSessionLookup(sessionRepo)
+
+	scheduler := scheduler.New()
+	scheduler.Every("prune-login-events", 24*time.Hour, userController.PruneLoginHistory)
+	scheduler.Every("reap-sessions", 15*time.Minute, userController.ReapSessions)
+	if capacity.Limited() {
+		scheduler.Every("refresh-capacity", capacity.RefreshInterval(), capacity.Refresh)
+	}
+
+	if config.Audit.RetentionDays > 0 {
+		scheduler.Every("archive-audit-log", 24*time.Hour, adminController.ArchiveAuditLog)
+	}
+
+	var backup *database.Backup
+	if config.Database.BackupDir != "" {
+		backup = database.NewBackup(db.SQL, config, clock)
+		scheduler.Every("backup-database", config.Database.BackupInterval, backup.Run)
+	}
+
+	var integrity *database.Integrity
+	if config.Database.IntegrityInterval > 0 {
+		integrity = database.NewIntegrity(db.SQL, config, clock, eventBus.SystemAlertTopic())
+		scheduler.Every("check-database-integrity", config.Database.IntegrityInterval, integrity.Run)
+	}
+
+	reloader := NewConfigReloader(config, auditRepo, eventBus)
+	if err := reloader.Subscribe(reloader.applyLogLevels); err != nil {
+		return startupFailed(diagnostics.STAGE_SERVICES, config, log.Err("failed to subscribe to config changes", err))
+	}
+
+	// Subsystems the service can't work without are critical, the rest only
+	// degrade it
+	checks := health.New(health.DEFAULT_CHECK_TIMEOUT)
+	checks.Register(db.SQLHealth(), true)
+	checks.Register(db.CacheHealth(), true)
+	checks.Register(eventBus.Health(), false)
+	checks.Register(middleware.AuthHealth(), false)
+	checks.Register(websocket.Health(), false)
+	checks.Register(scheduler.Health(), false)
+	if backup != nil {
+		checks.Register(backup.Health(), false)
+	}
+	if integrity != nil {
+		checks.Register(integrity.Health(), false)
 	}
 
 	app := &App{
-		Database:        db,
-		Config:          config,
-		Middleware:      middleware,
-		UserRepo:        userRepo,
-		SessionRepo:     sessionRepo,
-		UserController:  userController,
-		AdminController: adminController,
-		Websocket:       websocket,
-		EventBus:        eventBus,
+		Database:         db,
+		Config:           config,
+		Middleware:       middleware,
+		UserRepo:         userRepo,
+		SessionRepo:      sessionRepo,
+		LoginEventRepo:   loginEventRepo,
+		AnnouncementRepo: announcementRepo,
+		StatsRepo:        statsRepo,
+		PreferenceRepo:   preferenceRepo,
+		AuditRepo:        auditRepo,
+		UserController:   userController,
+		AdminController:  adminController,
+		Websocket:        websocket,
+		EventBus:         eventBus,
+		Scheduler:        scheduler,
+		Backup:           backup,
+		Maintenance:      maintenanceMode,
+		Reloader:         reloader,
+		Health:           checks,
+		Latency:          latency,
+		Tracer:           tracer,
+		Clock:            clock,
+		Registrars:       []RouteRegistrar{userController, adminController},
 	}
 
 	if err := app.validate(); err != nil {
-		return &App{}, log.Err("failed to validate app", err)
+		return startupFailed(diagnostics.STAGE_VALIDATION, config, log.Err("failed to validate app", err))
 	}
 
+	// A report left by an earlier failed start no longer applies
+	_ = diagnostics.ClearStartupFailure(config)
+
+	scheduler.Start()
+
 	return app, nil
 }
 
+// startTracing installs a tracer exporting to the configured collector. With
+// no endpoint configured it does nothing and spans cost nothing.
+func startTracing(config config.Config) (*tracing.Tracer, error) {
+	if config.Tracing.Endpoint == "" {
+		return nil, nil
+	}
+
+	exporter, err := tracing.NewOTLPExporter(config.Tracing.Endpoint, config.Tracing.ServiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	log := logger.New("app").Function("startTracing")
+	tracer := tracing.NewTracer(exporter, config.Tracing.SampleRatio)
+	tracer.FlushEvery(TRACING_FLUSH_INTERVAL, func(err error) {
+		log.Warn("Failed to export spans", "error", err)
+	})
+	tracing.Install(tracer)
+
+	return tracer, nil
+}
+
+// startupFailed leaves a report of err at the diagnostics path, for when the
+// logs of a server that won't start are lost, and returns it.
+func startupFailed(stage string, config config.Config, err error) (*App, error) {
+	_ = diagnostics.WriteStartupFailure(config, diagnostics.NewStartupFailure(stage, err, config, nil))
+	return &App{}, err
+}
+
+// RegisterHook runs hook at stage of every request, after the hooks already
+// registered there; hooks.Stage lists the stages in the order a request
+// passes them. Forks add their own headers, audit sinks and the like here,
+// between New and server.New, rather than patching the server. a.Middleware
+// must come from middleware.New.
+func (a *App) RegisterHook(stage hooks.Stage, hook hooks.Hook) {
+	a.Middleware.HookRegistry().Register(stage, hook)
+}
+
 func (a *App) validate() error {
 	log := logger.New("app").Function("validate")
 	if a.Database.SQL == nil {
@@ -94,6 +330,13 @@ func (a *App) validate() error {
 		a.Middleware,
 		a.UserRepo,
 		a.SessionRepo,
+		a.LoginEventRepo,
+		a.AnnouncementRepo,
+		a.StatsRepo,
+		a.PreferenceRepo,
+		a.AuditRepo,
+		a.Maintenance,
+		a.Scheduler,
 	}
 
 	for _, check := range nilChecks {
@@ -106,6 +349,10 @@ func (a *App) validate() error {
 }
 
 func (a *App) Close() (err error) {
+	if a.Scheduler != nil {
+		a.Scheduler.Stop()
+	}
+
 	if a.EventBus != nil {
 		if closeErr := a.EventBus.Close(); closeErr != nil {
 			err = closeErr
@@ -116,5 +363,15 @@ func (a *App) Close() (err error) {
 		err = dbErr
 	}
 
+	// Spans of the work just stopped are still waiting to be exported
+	if a.Tracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), TRACING_SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if tracerErr := a.Tracer.Shutdown(ctx); tracerErr != nil {
+			err = tracerErr
+		}
+		tracing.Install(nil)
+	}
+
 	return err
 }
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"path/filepath"
 	"server/config"
 	"server/internal/database"
 	"server/internal/logger"
@@ -15,21 +16,23 @@ func TestApp_StructCreation(t *testing.T) {
 	// Test creating App struct manually
 	app := &App{
 		Config: config.Config{
-			ServerPort: 8080,
+			Server: config.ServerConfig{Port: 8080},
 		},
 	}
 
 	assert.NotNil(t, app)
-	assert.Equal(t, 8080, app.Config.ServerPort)
+	assert.Equal(t, 8080, app.Config.Server.Port)
 }
 
 func TestApp_StructWithAllFields(t *testing.T) {
 	// Test App struct with all fields populated
 	mockConfig := config.Config{
-		ServerPort:       8080,
-		GeneralVersion:   "1.0.0",
-		Environment:      "test",
-		CorsAllowOrigins: "http://localhost:3000",
+		Server: config.ServerConfig{
+			Port:             8080,
+			CorsAllowOrigins: "http://localhost:3000",
+		},
+		GeneralVersion: "1.0.0",
+		Environment:    "test",
 	}
 
 	app := &App{
@@ -81,7 +84,7 @@ func TestApp_Validate_EmptyApp(t *testing.T) {
 func TestApp_Validate_NilDatabase(t *testing.T) {
 	app := &App{
 		Database:  database.DB{SQL: nil}, // Nil SQL
-		Config:    config.Config{ServerPort: 8080},
+		Config:    config.Config{Server: config.ServerConfig{Port: 8080}},
 		Websocket: &websockets.Manager{},
 		Middleware: middleware.Middleware{
 			Config: config.Config{},
@@ -144,6 +147,7 @@ func TestNew_FunctionExists(t *testing.T) {
 	// Test that New function exists and can be called
 	// This will fail due to missing config file and dependencies,
 	// but we can verify it doesn't panic and returns proper error
+	t.Setenv("DIAGNOSTICS_PATH", filepath.Join(t.TempDir(), "last_startup_failure.json"))
 	app, err := New()
 
 	// Should return error due to missing dependencies
@@ -194,11 +198,11 @@ func TestApp_ZeroValues(t *testing.T) {
 func TestApp_PartiallyPopulated(t *testing.T) {
 	// Test app with only some fields populated
 	app := &App{
-		Config: config.Config{ServerPort: 8080},
+		Config: config.Config{Server: config.ServerConfig{Port: 8080}},
 		// Other fields remain zero/nil
 	}
 
-	assert.Equal(t, 8080, app.Config.ServerPort)
+	assert.Equal(t, 8080, app.Config.Server.Port)
 	assert.Equal(t, database.DB{}, app.Database)
 	assert.Nil(t, app.Websocket)
 	assert.Nil(t, app.UserController)
@@ -207,7 +211,7 @@ func TestApp_PartiallyPopulated(t *testing.T) {
 func TestApp_ConfigComparison(t *testing.T) {
 	// Test config comparison logic used in validation
 	emptyConfig := config.Config{}
-	nonEmptyConfig := config.Config{ServerPort: 8080}
+	nonEmptyConfig := config.Config{Server: config.ServerConfig{Port: 8080}}
 
 	app1 := &App{Config: emptyConfig}
 	app2 := &App{Config: nonEmptyConfig}
@@ -221,7 +225,7 @@ func TestApp_MiddlewareComparison(t *testing.T) {
 	// Test middleware comparison logic used in validation
 	emptyMiddleware := middleware.Middleware{}
 	nonEmptyMiddleware := middleware.Middleware{
-		Config: config.Config{ServerPort: 8080},
+		Config: config.Config{Server: config.ServerConfig{Port: 8080}},
 	}
 
 	app1 := &App{Middleware: emptyMiddleware}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"server/config"
+	"server/internal/events"
+	"server/internal/logger"
+	"server/internal/models"
+	"server/internal/repositories"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ConfigReloader applies a reloaded configuration. Each reload is diffed
+// against the running config, refused when it touches settings that only
+// take effect on a restart, and recorded in the audit log before it is
+// applied, so no change goes unrecorded. Subsystems holding their own copy
+// of a setting learn of changes from the config.changed event.
+type ConfigReloader struct {
+	load       func() (config.Config, error)
+	auditRepo  repositories.AuditRepository
+	topic      events.TypedTopic[events.ConfigChangedEvent]
+	instanceID string
+	log        logger.Logger
+
+	mutex    sync.Mutex
+	current  config.Config
+	revision int64
+}
+
+// NewConfigReloader starts from current, the config the app was built with.
+func NewConfigReloader(
+	current config.Config,
+	auditRepo repositories.AuditRepository,
+	eventBus *events.EventBus,
+) *ConfigReloader {
+	return &ConfigReloader{
+		load:       config.Load,
+		auditRepo:  auditRepo,
+		topic:      eventBus.ConfigChangedTopic(),
+		instanceID: uuid.New().String(),
+		log:        logger.New("app").File("reload"),
+		current:    current,
+	}
+}
+
+// Current is the config as of the last applied reload.
+func (r *ConfigReloader) Current() config.Config {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.current
+}
+
+// Reload reads the configuration again and applies what changed, returning
+// the changes. trigger says what asked for the reload, e.g. SIGHUP. A reload
+// changing a setting that needs a restart is refused whole with a
+// *config.NotReloadableError, and one that can't be audited isn't applied.
+func (r *ConfigReloader) Reload(ctx context.Context, trigger string) ([]config.Change, error) {
+	log := r.log.Function("Reload")
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	next, err := r.load()
+	if err != nil {
+		return nil, log.Err("failed to load config, keeping the running one", err, "trigger", trigger)
+	}
+
+	changes := config.Diff(r.current, next)
+	if len(changes) == 0 {
+		log.Info("Config reloaded, nothing changed", "trigger", trigger)
+		return nil, nil
+	}
+
+	if err := config.CheckReloadable(changes); err != nil {
+		var notReloadable *config.NotReloadableError
+		errors.As(err, &notReloadable)
+		return nil, log.Err("config reload refused, restart to change these settings", err,
+			"trigger", trigger, "fields", notReloadable.Fields)
+	}
+
+	revision := r.revision + 1
+	host, _ := os.Hostname()
+	entry := models.AuditLog{
+		Action: models.AUDIT_ACTION_CONFIG_RELOADED,
+		Source: models.AUDIT_SOURCE_CONFIG_RELOAD,
+		Details: map[string]any{
+			"trigger":  trigger,
+			"host":     host,
+			"instance": r.instanceID,
+			"revision": revision,
+			"changes":  changes,
+		},
+	}
+	if err := r.auditRepo.Create(ctx, &entry); err != nil {
+		return nil, log.Err("failed to audit config reload, not applied", err, "trigger", trigger)
+	}
+
+	config.Apply(next)
+	r.current = next
+	r.revision = revision
+	log.Info("Config reloaded", "trigger", trigger, "revision", revision, "changes", changes)
+
+	// The change is applied either way, so a failed publish is only logged
+	if err := r.topic.Publish(ctx, events.ConfigChangedEvent{
+		Revision: revision,
+		Changes:  changes,
+		Origin:   r.instanceID,
+	}); err != nil {
+		log.Er("failed to publish config change", err, "revision", revision)
+	}
+	return changes, nil
+}
+
+// Subscribe calls handler once for each reload of this instance. The bus
+// also delivers other instances' reloads, and with valkey delivers this
+// one's twice, locally and back from valkey; both are dropped.
+func (r *ConfigReloader) Subscribe(handler func(ctx context.Context, event events.ConfigChangedEvent) error) error {
+	var handled sync.Map
+	return r.topic.Subscribe(func(ctx context.Context, event events.ConfigChangedEvent) error {
+		if event.Origin != r.instanceID {
+			return nil
+		}
+		if _, seen := handled.LoadOrStore(event.Revision, struct{}{}); seen {
+			return nil
+		}
+		return handler(ctx, event)
+	})
+}
+
+// applyLogLevels sets the per-component log levels a reload changed.
+func (r *ConfigReloader) applyLogLevels(ctx context.Context, event events.ConfigChangedEvent) error {
+	if !event.Changed(config.SETTING_LOGGING_COMPONENT_LEVELS) {
+		return nil
+	}
+	levels, err := logger.ParseComponentLevels(r.Current().Logging.ComponentLevels)
+	if err != nil {
+		return err
+	}
+	logger.SetComponentLevels(levels)
+	return nil
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"server/config"
+	"server/internal/events"
+	"server/internal/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingAuditRepository keeps the entries it is given, or fails with err.
+type recordingAuditRepository struct {
+	mockAuditRepository
+	mutex   sync.Mutex
+	entries []models.AuditLog
+	err     error
+}
+
+func (r *recordingAuditRepository) Create(ctx context.Context, entry *models.AuditLog) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.entries = append(r.entries, *entry)
+	return nil
+}
+
+func (r *recordingAuditRepository) Entries() []models.AuditLog {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]models.AuditLog{}, r.entries...)
+}
+
+func setupReloadTest(t *testing.T) (*ConfigReloader, *recordingAuditRepository, *config.Config, chan events.ConfigChangedEvent) {
+	running := config.Config{
+		Environment: "test",
+		Server:      config.ServerConfig{Port: 8280, CorsAllowOrigins: "https://app.example.com"},
+		Security:    config.SecurityConfig{Pepper: "pepper"},
+	}
+	next := running
+
+	auditRepo := &recordingAuditRepository{}
+	bus := events.New(nil, running, nil)
+	reloader := NewConfigReloader(running, auditRepo, bus)
+	reloader.load = func() (config.Config, error) { return next, nil }
+
+	received := make(chan events.ConfigChangedEvent, 8)
+	require.NoError(t, reloader.Subscribe(func(ctx context.Context, event events.ConfigChangedEvent) error {
+		received <- event
+		return nil
+	}))
+	return reloader, auditRepo, &next, received
+}
+
+func TestConfigReloader_Reload(t *testing.T) {
+	reloader, auditRepo, next, received := setupReloadTest(t)
+	next.Server.CorsAllowOrigins = "https://app.example.com,https://admin.example.com"
+
+	changes, err := reloader.Reload(context.Background(), "SIGHUP")
+	require.NoError(t, err)
+	want := []config.Change{{
+		Field: config.SETTING_SERVER_CORS_ALLOW_ORIGINS,
+		Old:   "https://app.example.com",
+		New:   "https://app.example.com,https://admin.example.com",
+	}}
+	assert.Equal(t, want, changes)
+	assert.Equal(t, *next, reloader.Current())
+
+	entries := auditRepo.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, models.AUDIT_ACTION_CONFIG_RELOADED, entries[0].Action)
+	assert.Equal(t, models.AUDIT_SOURCE_CONFIG_RELOAD, entries[0].Source)
+	assert.Equal(t, "SIGHUP", entries[0].Details["trigger"])
+	assert.Equal(t, want, entries[0].Details["changes"])
+
+	select {
+	case event := <-received:
+		assert.Equal(t, int64(1), event.Revision)
+		assert.True(t, event.Changed(config.SETTING_SERVER_CORS_ALLOW_ORIGINS))
+		assert.False(t, event.Changed(config.SETTING_LOGGING_COMPONENT_LEVELS))
+	case <-time.After(time.Second):
+		t.Fatal("no config.changed event")
+	}
+
+	// Reloading again with nothing changed neither audits nor publishes
+	changes, err = reloader.Reload(context.Background(), "SIGHUP")
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Len(t, auditRepo.Entries(), 1)
+	select {
+	case event := <-received:
+		t.Fatalf("unexpected event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConfigReloader_RefusesNonReloadable(t *testing.T) {
+	reloader, auditRepo, next, received := setupReloadTest(t)
+	running := reloader.Current()
+	next.Server.CorsAllowOrigins = "https://admin.example.com"
+	next.Server.Port = 8281
+	next.Security.Pepper = "rotated"
+
+	changes, err := reloader.Reload(context.Background(), "SIGHUP")
+	require.ErrorIs(t, err, config.ErrNotReloadable)
+	var notReloadable *config.NotReloadableError
+	require.True(t, errors.As(err, &notReloadable))
+	assert.Equal(t, []string{"security.pepper", "server.port"}, notReloadable.Fields)
+	assert.NotContains(t, err.Error(), "rotated")
+	assert.Empty(t, changes)
+
+	assert.Equal(t, running, reloader.Current(), "nothing is applied, not even the reloadable change")
+	assert.Empty(t, auditRepo.Entries())
+	select {
+	case event := <-received:
+		t.Fatalf("unexpected event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConfigReloader_UnauditedIsNotApplied(t *testing.T) {
+	reloader, auditRepo, next, received := setupReloadTest(t)
+	running := reloader.Current()
+	auditRepo.err = errors.New("database is locked")
+	next.Logging.ComponentLevels = "database=debug"
+
+	_, err := reloader.Reload(context.Background(), "SIGHUP")
+	require.Error(t, err)
+	assert.Equal(t, running, reloader.Current())
+	select {
+	case event := <-received:
+		t.Fatalf("unexpected event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConfigReloader_SubscribeOncePerReload(t *testing.T) {
+	reloader, _, next, received := setupReloadTest(t)
+	ctx := context.Background()
+
+	next.Logging.ComponentLevels = "database=debug"
+	_, err := reloader.Reload(ctx, "SIGHUP")
+	require.NoError(t, err)
+
+	// With valkey an instance gets its own event back, and every other
+	// instance's reloads too
+	echo := events.ConfigChangedEvent{Revision: 1, Changes: []config.Change{}, Origin: reloader.instanceID}
+	require.NoError(t, reloader.topic.Publish(ctx, echo))
+	other := events.ConfigChangedEvent{Revision: 2, Changes: []config.Change{}, Origin: "other-instance"}
+	require.NoError(t, reloader.topic.Publish(ctx, other))
+
+	next.Logging.ComponentLevels = "database=warn"
+	_, err = reloader.Reload(ctx, "SIGHUP")
+	require.NoError(t, err)
+
+	var revisions []int64
+	timeout := time.After(time.Second)
+	for len(revisions) < 2 {
+		select {
+		case event := <-received:
+			assert.Equal(t, reloader.instanceID, event.Origin)
+			revisions = append(revisions, event.Revision)
+		case <-timeout:
+			t.Fatalf("got revisions %v, want 1 and 2", revisions)
+		}
+	}
+	assert.ElementsMatch(t, []int64{1, 2}, revisions)
+	select {
+	case event := <-received:
+		t.Fatalf("event delivered twice: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
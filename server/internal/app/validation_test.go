@@ -2,14 +2,21 @@ package app
 
 import (
 	"context"
+	"encoding/json"
+	"maps"
+	"net"
 	"os"
+	"path/filepath"
 	"server/config"
 	"server/internal/database"
+	"server/internal/diagnostics"
 	"server/internal/models"
 	"server/internal/routes/middleware"
 	"server/internal/websockets"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	userController "server/internal/controllers/users"
 	"github.com/stretchr/testify/assert"
@@ -32,13 +39,18 @@ func TestApp_Validate_CompleteValidation(t *testing.T) {
 		{
 			name: "AllValidFields",
 			app: &App{
-				Database:       createValidMockDatabase(t),
-				Config:         config.Config{ServerPort: 8080},
-				Websocket:      &websockets.Manager{},
-				UserController: (*userController.UserController)(nil),
-				Middleware:     middleware.Middleware{Config: config.Config{ServerPort: 8080}},
-				UserRepo:       &mockUserRepository{},
-				SessionRepo:    &mockSessionRepository{},
+				Database:         createValidMockDatabase(t),
+				Config:           config.Config{Server: config.ServerConfig{Port: 8080}},
+				Websocket:        &websockets.Manager{},
+				UserController:   (*userController.UserController)(nil),
+				Middleware:       middleware.Middleware{Config: config.Config{Server: config.ServerConfig{Port: 8080}}},
+				UserRepo:         &mockUserRepository{},
+				SessionRepo:      &mockSessionRepository{},
+				LoginEventRepo:   &mockLoginEventRepository{},
+				AnnouncementRepo: &mockAnnouncementRepository{},
+				StatsRepo:        &mockStatsRepository{},
+				PreferenceRepo:   &mockPreferenceRepository{},
+				AuditRepo:        &mockAuditRepository{},
 			},
 			expectError: false,
 		},
@@ -46,10 +58,10 @@ func TestApp_Validate_CompleteValidation(t *testing.T) {
 			name: "NilDatabase",
 			app: &App{
 				Database:       database.DB{SQL: nil},
-				Config:         config.Config{ServerPort: 8080},
+				Config:         config.Config{Server: config.ServerConfig{Port: 8080}},
 				Websocket:      &websockets.Manager{},
 				UserController: (*userController.UserController)(nil),
-				Middleware:     middleware.Middleware{Config: config.Config{ServerPort: 8080}},
+				Middleware:     middleware.Middleware{Config: config.Config{Server: config.ServerConfig{Port: 8080}}},
 			},
 			expectError: true,
 			errorMsg:    "database is nil",
@@ -61,7 +73,7 @@ func TestApp_Validate_CompleteValidation(t *testing.T) {
 				Config:         config.Config{}, // Empty config
 				Websocket:      &websockets.Manager{},
 				UserController: (*userController.UserController)(nil),
-				Middleware:     middleware.Middleware{Config: config.Config{ServerPort: 8080}},
+				Middleware:     middleware.Middleware{Config: config.Config{Server: config.ServerConfig{Port: 8080}}},
 			},
 			expectError: true,
 			errorMsg:    "config is nil",
@@ -70,10 +82,10 @@ func TestApp_Validate_CompleteValidation(t *testing.T) {
 			name: "NilWebsocket",
 			app: &App{
 				Database:       createValidMockDatabase(t),
-				Config:         config.Config{ServerPort: 8080},
+				Config:         config.Config{Server: config.ServerConfig{Port: 8080}},
 				Websocket:      nil,
 				UserController: (*userController.UserController)(nil),
-				Middleware:     middleware.Middleware{Config: config.Config{ServerPort: 8080}},
+				Middleware:     middleware.Middleware{Config: config.Config{Server: config.ServerConfig{Port: 8080}}},
 			},
 			expectError: true,
 			errorMsg:    "nil check failed",
@@ -82,10 +94,10 @@ func TestApp_Validate_CompleteValidation(t *testing.T) {
 			name: "NilUserController",
 			app: &App{
 				Database:       createValidMockDatabase(t),
-				Config:         config.Config{ServerPort: 8080},
+				Config:         config.Config{Server: config.ServerConfig{Port: 8080}},
 				Websocket:      &websockets.Manager{},
 				UserController: nil,
-				Middleware:     middleware.Middleware{Config: config.Config{ServerPort: 8080}},
+				Middleware:     middleware.Middleware{Config: config.Config{Server: config.ServerConfig{Port: 8080}}},
 			},
 			expectError: true,
 			errorMsg:    "nil check failed",
@@ -94,7 +106,7 @@ func TestApp_Validate_CompleteValidation(t *testing.T) {
 			name: "EmptyMiddleware",
 			app: &App{
 				Database:       createValidMockDatabase(t),
-				Config:         config.Config{ServerPort: 8080},
+				Config:         config.Config{Server: config.ServerConfig{Port: 8080}},
 				Websocket:      &websockets.Manager{},
 				UserController: (*userController.UserController)(nil),
 				Middleware:     middleware.Middleware{}, // Empty middleware
@@ -253,7 +265,7 @@ func TestApp_StructFieldAccess(t *testing.T) {
 func TestApp_ConfigComparisons(t *testing.T) {
 	// Test config comparison logic used in validation
 	emptyConfig := config.Config{}
-	validConfig := config.Config{ServerPort: 8080}
+	validConfig := config.Config{Server: config.ServerConfig{Port: 8080}}
 
 	app1 := &App{Config: emptyConfig}
 	app2 := &App{Config: validConfig}
@@ -268,7 +280,7 @@ func TestApp_MiddlewareComparisons(t *testing.T) {
 	// Test middleware comparison logic used in validation
 	emptyMiddleware := middleware.Middleware{}
 	validMiddleware := middleware.Middleware{
-		Config: config.Config{ServerPort: 8080},
+		Config: config.Config{Server: config.ServerConfig{Port: 8080}},
 	}
 
 	app1 := &App{Middleware: emptyMiddleware}
@@ -288,6 +300,9 @@ type mockUserRepository struct{}
 func (m *mockUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
 	return &models.User{}, nil
 }
+func (m *mockUserRepository) GetByIDs(ctx context.Context, ids []string) ([]models.User, error) {
+	return nil, nil
+}
 func (m *mockUserRepository) GetByLogin(ctx context.Context, login string) (*models.User, error) {
 	return &models.User{}, nil
 }
@@ -297,21 +312,83 @@ func (m *mockUserRepository) Create(ctx context.Context, user *models.User, conf
 func (m *mockUserRepository) Update(ctx context.Context, user *models.User) error {
 	return nil
 }
+func (m *mockUserRepository) UpdateProfile(ctx context.Context, user *models.User, expectedVersion int) error {
+	return nil
+}
 func (m *mockUserRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
+func (m *mockUserRepository) List(ctx context.Context, page int, sort models.UserSort, columns []string) ([]models.User, bool, error) {
+	return nil, false, nil
+}
+func (m *mockUserRepository) Stream(ctx context.Context, columns []string, each func(users []models.User) error) (int64, error) {
+	return 0, nil
+}
 
 type mockSessionRepository struct{}
 
 func (m *mockSessionRepository) Create(ctx context.Context, session *models.Session, config config.Config) error {
 	return nil
 }
+func (m *mockSessionRepository) Extend(ctx context.Context, session *models.Session, expiresAt time.Time, config config.Config) error {
+	return nil
+}
 func (m *mockSessionRepository) GetByID(ctx context.Context, id string) (*models.Session, error) {
 	return &models.Session{}, nil
 }
 func (m *mockSessionRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
+func (m *mockSessionRepository) ListByUser(ctx context.Context, userID string) ([]*models.Session, error) {
+	return nil, nil
+}
+func (m *mockSessionRepository) DeleteByUser(ctx context.Context, userID string) error {
+	return nil
+}
+
+func (m *mockSessionRepository) CountActive(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockSessionRepository) ReapExpired(ctx context.Context, expiredBefore time.Time, limit int) (int, error) {
+	return 0, nil
+}
+
+type mockLoginEventRepository struct{}
+
+func (m *mockLoginEventRepository) Create(ctx context.Context, event *models.LoginEvent) error {
+	return nil
+}
+func (m *mockLoginEventRepository) ListSuccessful(ctx context.Context, userID string, cursor string) ([]models.LoginEvent, string, error) {
+	return nil, "", nil
+}
+func (m *mockLoginEventRepository) StreamByUser(ctx context.Context, userID string, each func(events []models.LoginEvent) error) (int64, error) {
+	return 0, nil
+}
+func (m *mockLoginEventRepository) CountFailedSince(ctx context.Context, userID string, since time.Time) (int64, error) {
+	return 0, nil
+}
+func (m *mockLoginEventRepository) HasSucceededFromIP(ctx context.Context, userID string, ip string) (bool, error) {
+	return false, nil
+}
+func (m *mockLoginEventRepository) RecentlyActiveUsers(ctx context.Context, since time.Time, limit int) ([]string, error) {
+	return nil, nil
+}
+func (m *mockLoginEventRepository) AnonymizeByUser(ctx context.Context, userID string) (int64, error) {
+	return 0, nil
+}
+func (m *mockLoginEventRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	return 0, nil
+}
+
+type mockAnnouncementRepository struct{}
+
+func (m *mockAnnouncementRepository) Create(ctx context.Context, announcement *models.Announcement) error {
+	return nil
+}
+func (m *mockAnnouncementRepository) ListActive(ctx context.Context, now time.Time) ([]models.Announcement, error) {
+	return nil, nil
+}
 
 func createValidMockDatabase(t *testing.T) database.DB {
 	// Create in-memory SQLite database
@@ -336,3 +413,150 @@ func clearEnvironmentVars(t *testing.T) {
 		_ = os.Unsetenv(envVar)
 	}
 }
+
+type mockStatsRepository struct{}
+
+func (m *mockStatsRepository) CountUsers(ctx context.Context, since time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockStatsRepository) LoginsPerDay(ctx context.Context, since time.Time, days int) ([]models.DailyCount, error) {
+	return nil, nil
+}
+
+func (m *mockStatsRepository) RegistrationsPerDay(
+	ctx context.Context,
+	since time.Time,
+	days int,
+) ([]models.DailyCount, error) {
+	return nil, nil
+}
+
+type mockPreferenceRepository struct{}
+
+func (m *mockPreferenceRepository) ListByUser(ctx context.Context, userID string) ([]models.UserPreference, error) {
+	return nil, nil
+}
+
+func (m *mockPreferenceRepository) Get(ctx context.Context, userID string, key string) (*models.UserPreference, error) {
+	return nil, nil
+}
+
+func (m *mockPreferenceRepository) Set(ctx context.Context, preference *models.UserPreference) error {
+	return nil
+}
+
+func (m *mockPreferenceRepository) Delete(ctx context.Context, userID string, key string) error {
+	return nil
+}
+
+type mockAuditRepository struct{}
+
+func (m *mockAuditRepository) Create(ctx context.Context, entry *models.AuditLog) error {
+	return nil
+}
+
+func (m *mockAuditRepository) List(ctx context.Context, cursor string) ([]models.AuditLog, string, error) {
+	return nil, "", nil
+}
+
+func (m *mockAuditRepository) Stream(ctx context.Context, each func(entries []models.AuditLog) error) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockAuditRepository) ExportBefore(
+	ctx context.Context,
+	cutoff time.Time,
+	export func(entries []models.AuditLog) error,
+) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockAuditRepository) DeleteBefore(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockAuditRepository) CreateArchive(ctx context.Context, archive *models.AuditArchive) error {
+	return nil
+}
+
+func (m *mockAuditRepository) ListArchives(ctx context.Context) ([]models.AuditArchive, error) {
+	return nil, nil
+}
+
+func (m *mockAuditRepository) GetArchive(ctx context.Context, id string) (*models.AuditArchive, error) {
+	return nil, nil
+}
+
+func TestApp_New_StartupFailureReport(t *testing.T) {
+	originalDir, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() { _ = os.Chdir(originalDir) }()
+
+	// A port nothing listens on, so the cache can't be reached
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+
+	testCases := []struct {
+		name  string
+		env   func(dir string) map[string]string
+		stage string
+	}{
+		{"config", func(dir string) map[string]string {
+			return map[string]string{"SERVER_PORT": "0"}
+		}, diagnostics.STAGE_CONFIG},
+		{"database", func(dir string) map[string]string {
+			return map[string]string{"DATABASE_PATH": filepath.Join(dir, "missing", "nested", "app.db", "x")}
+		}, diagnostics.STAGE_DATABASE},
+		{"migrations", func(dir string) map[string]string {
+			return map[string]string{"DATABASE_MIGRATIONS_DIR": filepath.Join(dir, "missing")}
+		}, diagnostics.STAGE_MIGRATIONS},
+		{"cache", func(dir string) map[string]string {
+			return map[string]string{}
+		}, diagnostics.STAGE_CACHE},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.Chdir(dir))
+			defer func() { _ = os.Chdir(originalDir) }()
+			require.NoError(t, os.Mkdir(filepath.Join(dir, "migrations"), 0o755))
+
+			report := filepath.Join(dir, "diagnostics", "failure.json")
+			env := map[string]string{
+				"SERVER_PORT":             "8280",
+				"DATABASE_PATH":           filepath.Join(dir, "app.db"),
+				"DATABASE_MIGRATIONS_DIR": filepath.Join(dir, "migrations"),
+				"CACHE_ADDRESS":           "127.0.0.1",
+				"CACHE_PORT":              strconv.Itoa(closedPort),
+				"SECURITY_JWT_SECRET":     "jwt-secret-value",
+				"SECURITY_PEPPER":         "pepper-value",
+				"SECURITY_COOKIE_KEY":     "cookie-key-value",
+				"DIAGNOSTICS_PATH":        report,
+			}
+			maps.Copy(env, tc.env(dir))
+			for key, value := range env {
+				t.Setenv(key, value)
+			}
+
+			_, startErr := New()
+			require.Error(t, startErr)
+
+			written, err := os.ReadFile(report)
+			require.NoError(t, err)
+			for _, secret := range []string{"jwt-secret-value", "pepper-value", "cookie-key-value"} {
+				assert.NotContains(t, string(written), secret)
+			}
+
+			var failure diagnostics.StartupFailure
+			require.NoError(t, json.Unmarshal(written, &failure))
+			assert.Equal(t, tc.stage, failure.Stage)
+			assert.Equal(t, startErr.Error(), failure.Error)
+			assert.NotEmpty(t, failure.Chain)
+			assert.Equal(t, env["DATABASE_MIGRATIONS_DIR"], failure.Config["database.migrations_dir"])
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of the current time. Code that compares against "now"
+// takes a Clock so tests can pin it with a Fake.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+// New returns the wall clock. Times are always in UTC.
+func New() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// OrDefault returns c, or the wall clock when c is nil.
+func OrDefault(c Clock) Clock {
+	if c == nil {
+		return New()
+	}
+	return c
+}
+
+// Fake is a Clock that only moves when told to.
+type Fake struct {
+	mutex sync.RWMutex
+	now   time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now.UTC()}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.now
+}
+
+func (f *Fake) Set(now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = now.UTC()
+}
+
+func (f *Fake) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+}
@@ -0,0 +1,35 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_ReturnsUTC(t *testing.T) {
+	now := New().Now()
+	assert.Equal(t, time.UTC, now.Location())
+	assert.WithinDuration(t, time.Now(), now, time.Second)
+}
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	fake := NewFake(start)
+
+	assert.Equal(t, time.UTC, fake.Now().Location())
+	assert.True(t, fake.Now().Equal(start))
+
+	fake.Advance(90 * time.Minute)
+	assert.True(t, fake.Now().Equal(start.Add(90*time.Minute)))
+
+	later := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+	fake.Set(later)
+	assert.Equal(t, later, fake.Now())
+}
+
+func TestOrDefault(t *testing.T) {
+	fake := NewFake(time.Now())
+	assert.Same(t, fake, OrDefault(fake))
+	assert.NotNil(t, OrDefault(nil))
+}
@@ -2,63 +2,713 @@ package adminController
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
 	"server/internal/events"
 	"server/internal/logger"
+	"server/internal/maintenance"
+	"server/internal/metrics"
+	"server/internal/notifier"
 	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"server/internal/utils"
+	"server/internal/validate"
+	"server/internal/websockets"
+	"slices"
+	"strings"
 	"time"
 
 	. "server/internal/models"
-
-	"github.com/google/uuid"
 )
 
 type AdminController struct {
-	userRepo repositories.UserRepository
-	Config   config.Config
-	log      logger.Logger
-	eventBus *events.EventBus
+	userRepo         repositories.UserRepository
+	loginEventRepo   repositories.LoginEventRepository
+	announcementRepo repositories.AnnouncementRepository
+	sessionRepo      repositories.SessionRepository
+	statsRepo        repositories.StatsRepository
+	auditRepo        repositories.AuditRepository
+	statsCache       database.CacheStore
+	caches           []database.CacheStore
+	Config           config.Config
+	log              logger.Logger
+	eventBus         *events.EventBus
+	middleware       middleware.Middleware
+	wsManager        WebSocketManager
+	maintenance      *maintenance.Mode
+	responseCache    *middleware.ResponseCache
+	writeLimits      *middleware.ConcurrencyLimits
+	latency          *metrics.LatencyTracker
+	clock            clock.Clock
+}
+
+const (
+	ADMIN_STATS_CACHE_KEY = "admin_stats:%d:%d"
+	ADMIN_STATS_CACHE_TTL = 60 * time.Second
+
+	// Announcements can be served for up to the ttl past their expiry
+	ANNOUNCEMENTS_RESPONSE_CACHE     = "announcements"
+	ANNOUNCEMENTS_RESPONSE_CACHE_TTL = 30 * time.Second
+
+	// Write group of the bulk broadcast, limited by Database.WriteLimits
+	BROADCAST_WRITE_GROUP = "broadcast"
+
+	// Lists sent one JSON object per line, when asked for with Accept or
+	// ?stream=true
+	NDJSON_CONTENT_TYPE = "application/x-ndjson"
+
+	// Reason websocket clients are given when an admin logs their user out
+	FORCE_LOGOUT_REASON = "logged_out_by_admin"
+)
+
+// WebSocketManager reports how many clients are connected, how many of them
+// receive broadcasts and how a user's connections fare, and drains them
+// ahead of maintenance.
+type WebSocketManager interface {
+	notifier.WebsocketNotifier
+	AuthenticatedClientCount() int
+	AudienceClientCount(audience string, userIDs []string) int
+	ConnectionCount() int
+	UserDiagnostics(userID string) websockets.UserDiagnostics
+	Drain(ctx context.Context, window time.Duration, reason string) error
 }
 
 func New(
 	eventBus *events.EventBus,
 	userRepo repositories.UserRepository,
+	loginEventRepo repositories.LoginEventRepository,
+	announcementRepo repositories.AnnouncementRepository,
+	sessionRepo repositories.SessionRepository,
+	statsRepo repositories.StatsRepository,
+	auditRepo repositories.AuditRepository,
+	statsCache database.CacheStore,
+	middleware middleware.Middleware,
 	config config.Config,
+	clk clock.Clock,
 ) *AdminController {
 	return &AdminController{
-		userRepo: userRepo,
-		Config:   config,
-		log:      logger.New("AdminController"),
-		eventBus: eventBus,
+		userRepo:         userRepo,
+		loginEventRepo:   loginEventRepo,
+		announcementRepo: announcementRepo,
+		sessionRepo:      sessionRepo,
+		statsRepo:        statsRepo,
+		auditRepo:        auditRepo,
+		statsCache:       statsCache,
+		Config:           config,
+		log:              logger.New("AdminController"),
+		eventBus:         eventBus,
+		middleware:       middleware,
+		clock:            clock.OrDefault(clk),
+	}
+}
+
+func (c *AdminController) SetWebSocketManager(wsManager WebSocketManager) {
+	c.wsManager = wsManager
+}
+
+func (c *AdminController) SetMaintenance(mode *maintenance.Mode) {
+	c.maintenance = mode
+}
+
+// SetResponseCache caches the public announcements list. It must be called
+// before RegisterRoutes.
+func (c *AdminController) SetResponseCache(cache *middleware.ResponseCache) {
+	c.responseCache = cache
+}
+
+// SetWriteLimits limits how many write-heavy requests run at once. It must be
+// called before RegisterRoutes.
+func (c *AdminController) SetWriteLimits(limits *middleware.ConcurrencyLimits) {
+	c.writeLimits = limits
+}
+
+// SetCaches are the caches FlushCache empties, one per valkey database.
+func (c *AdminController) SetCaches(caches ...database.CacheStore) {
+	c.caches = caches
+}
+
+// SetLatency is where the request logger records request durations, served
+// by the latency endpoint.
+func (c *AdminController) SetLatency(latency *metrics.LatencyTracker) {
+	c.latency = latency
+}
+
+// Announce stores an announcement and broadcasts it to connected clients. It
+// returns the number of clients connected to this instance at send time.
+func (c *AdminController) Announce(
+	ctx context.Context,
+	user User,
+	announcementRequest AnnouncementRequest,
+) (Announcement, int, error) {
+	log := c.log.Function("Announce")
+
+	announcementRequest.Title = strings.TrimSpace(announcementRequest.Title)
+	announcementRequest.Body = strings.TrimSpace(announcementRequest.Body)
+	announcementRequest.Severity = strings.ToLower(strings.TrimSpace(announcementRequest.Severity))
+	announcementRequest.Audience = strings.ToLower(strings.TrimSpace(announcementRequest.Audience))
+	if announcementRequest.Audience == "" {
+		announcementRequest.Audience = ANNOUNCEMENT_AUDIENCE_ALL
+		if len(announcementRequest.UserIDs) > 0 {
+			announcementRequest.Audience = ANNOUNCEMENT_AUDIENCE_USERS
+		}
+	}
+	if len(announcementRequest.UserIDs) > 0 {
+		announcementRequest.UserIDs = slices.Compact(slices.Sorted(slices.Values(announcementRequest.UserIDs)))
+	}
+
+	if err := ValidateAnnouncement(announcementRequest, c.clock.Now()); err != nil {
+		return Announcement{}, 0, err
+	}
+
+	announcement := Announcement{
+		Title:     announcementRequest.Title,
+		Body:      announcementRequest.Body,
+		Severity:  announcementRequest.Severity,
+		ExpiresAt: announcementRequest.ExpiresAt,
+		CreatedBy: user.ID,
+		Audience:  announcementRequest.Audience,
+		UserIDs:   announcementRequest.UserIDs,
+	}
+	if err := c.announcementRepo.Create(ctx, &announcement); err != nil {
+		return Announcement{}, 0, log.Err("failed to store announcement", err, "userID", user.ID)
+	}
+	if err := c.responseCache.Invalidate(ctx, ANNOUNCEMENTS_RESPONSE_CACHE); err != nil {
+		log.Er("failed to invalidate cached announcements", err, "announcementID", announcement.ID)
 	}
+
+	event := events.AdminBroadcastEvent{
+		ID:        announcement.ID,
+		Title:     announcement.Title,
+		Message:   announcement.Body,
+		Severity:  announcement.Severity,
+		ExpiresAt: NewAPITimePtr(&announcement.ExpiresAt),
+		Audience:  announcement.Audience,
+		UserIDs:   announcement.UserIDs,
+		SentBy:    user.ID,
+	}
+	if err := c.eventBus.BroadcastTopic().Publish(ctx, event); err != nil {
+		log.Er("failed to publish announcement", err, "announcementID", announcement.ID)
+		return announcement, 0, nil
+	}
+
+	delivered := 0
+	if c.wsManager != nil {
+		delivered = c.wsManager.AudienceClientCount(announcement.Audience, announcement.UserIDs)
+	}
+
+	log.Info("Announcement sent", "announcementID", announcement.ID, "userID", user.ID, "delivered", delivered)
+	return announcement, delivered, nil
 }
 
-type Message struct {
-	ID        string         `json:"id"`
-	Type      string         `json:"type"`
-	Channel   string         `json:"channel,omitempty"`
-	Action    string         `json:"action,omitempty"`
-	UserID    string         `json:"userId,omitempty"`
-	Data      map[string]any `json:"data,omitempty"`
-	Timestamp time.Time      `json:"timestamp"`
+// ActiveAnnouncements returns the announcements that haven't expired yet and
+// are visible to the user, or only those for everyone when user is nil. The
+// users an announcement is for are left out.
+func (c *AdminController) ActiveAnnouncements(ctx context.Context, user *User) ([]Announcement, error) {
+	announcements, err := c.announcementRepo.ListActive(ctx, c.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	userID, isAdmin := "", false
+	if user != nil {
+		userID, isAdmin = user.ID, user.IsAdmin
+	}
+	visible := []Announcement{}
+	for _, announcement := range announcements {
+		if announcement.VisibleTo(userID, isAdmin) {
+			announcement.UserIDs = nil
+			visible = append(visible, announcement)
+		}
+	}
+	return visible, nil
 }
 
-func (c *AdminController) SendBroadcast(ctx context.Context, user User, message string) {
-	log := c.log.Function("SendBroadcast")
+// Stats returns the dashboard summary for the requested windows, from the
+// cache when a copy younger than ADMIN_STATS_CACHE_TTL exists.
+func (c *AdminController) Stats(ctx context.Context, statsRequest AdminStatsRequest) (AdminStats, error) {
+	log := c.log.Function("Stats")
+
+	if err := statsRequest.Validate(); err != nil {
+		return AdminStats{}, err
+	}
+
+	key := fmt.Sprintf(ADMIN_STATS_CACHE_KEY, statsRequest.LoginDays, statsRequest.RegistrationDays)
+
+	var stats AdminStats
+	if c.statsCache != nil {
+		err := c.statsCache.Get(ctx, key, &stats)
+		if err == nil {
+			return stats, nil
+		}
+		if !errors.Is(err, database.ErrCacheMiss) {
+			log.Warn("failed to read cached stats", "key", key, "error", err)
+		}
+	}
+
+	stats, err := c.buildStats(ctx, statsRequest)
+	if err != nil {
+		return AdminStats{}, err
+	}
+
+	if c.statsCache != nil {
+		if err := c.statsCache.Set(ctx, key, stats, ADMIN_STATS_CACHE_TTL); err != nil {
+			log.Warn("failed to cache stats", "key", key, "error", err)
+		}
+	}
+
+	return stats, nil
+}
+
+func (c *AdminController) buildStats(ctx context.Context, statsRequest AdminStatsRequest) (AdminStats, error) {
+	log := c.log.Function("buildStats")
+
+	now := c.clock.Now().UTC()
+	stats := AdminStats{GeneratedAt: NewAPITime(now)}
+
+	var err error
+	if stats.TotalUsers, err = c.statsRepo.CountUsers(ctx, time.Time{}); err != nil {
+		return AdminStats{}, log.Err("failed to count users", err)
+	}
+	if stats.NewUsersLast7Days, err = c.statsRepo.CountUsers(ctx, now.AddDate(0, 0, -7)); err != nil {
+		return AdminStats{}, log.Err("failed to count new users", err)
+	}
+	if stats.NewUsersLast30Days, err = c.statsRepo.CountUsers(ctx, now.AddDate(0, 0, -30)); err != nil {
+		return AdminStats{}, log.Err("failed to count new users", err)
+	}
+	if stats.ActiveSessions, err = c.sessionRepo.CountActive(ctx); err != nil {
+		return AdminStats{}, log.Err("failed to count active sessions", err)
+	}
+
+	// Both series end today, so they start days-1 days back.
+	loginsSince := now.AddDate(0, 0, 1-statsRequest.LoginDays)
+	if stats.LoginsPerDay, err = c.statsRepo.LoginsPerDay(ctx, loginsSince, statsRequest.LoginDays); err != nil {
+		return AdminStats{}, log.Err("failed to count logins per day", err)
+	}
+	registrationsSince := now.AddDate(0, 0, 1-statsRequest.RegistrationDays)
+	if stats.RegistrationsPerDay, err = c.statsRepo.RegistrationsPerDay(
+		ctx,
+		registrationsSince,
+		statsRequest.RegistrationDays,
+	); err != nil {
+		return AdminStats{}, log.Err("failed to count registrations per day", err)
+	}
+
+	if c.wsManager != nil {
+		stats.WebsocketConnections = c.wsManager.ConnectionCount()
+	}
+
+	return stats, nil
+}
+
+// ValidateAnnouncement checks an announcement request against now.
+func ValidateAnnouncement(announcementRequest AnnouncementRequest, now time.Time) error {
+	var expiry *validate.FieldError
+	switch {
+	case announcementRequest.ExpiresAt.IsZero():
+		expiry = validate.Fail("expiresAt", validate.CODE_REQUIRED, "is required")
+	case !announcementRequest.ExpiresAt.After(now):
+		expiry = validate.Fail("expiresAt", "in_past", "must be in the future")
+	}
+
+	errs := validate.Collect(
+		validate.Check("title", announcementRequest.Title, validate.Required(), validate.Length(0, ANNOUNCEMENT_TITLE_MAX)),
+		validate.Check("body", announcementRequest.Body, validate.Required(), validate.Length(0, ANNOUNCEMENT_BODY_MAX)),
+		validate.Check("severity", announcementRequest.Severity, validate.Required(), validate.OneOf(AnnouncementSeverities...)),
+		expiry,
+		validate.Check("audience", announcementRequest.Audience, validate.OneOf(AnnouncementAudiences...)),
+		validateAudienceUserIDs(announcementRequest),
+	)
+	if len(errs) > 0 {
+		return utils.FieldValidationError("invalid announcement", errs)
+	}
+	return nil
+}
+
+// validateAudienceUserIDs checks the users of a users audience, and that no
+// other audience lists any.
+func validateAudienceUserIDs(announcementRequest AnnouncementRequest) *validate.FieldError {
+	userIDs := announcementRequest.UserIDs
+	if announcementRequest.Audience != ANNOUNCEMENT_AUDIENCE_USERS {
+		if len(userIDs) > 0 {
+			return validate.Fail("userIds", validate.CODE_NOT_ALLOWED, "are only allowed with the users audience")
+		}
+		return nil
+	}
+
+	switch {
+	case len(userIDs) == 0:
+		return validate.Fail("userIds", validate.CODE_REQUIRED, "are required for the users audience")
+	case len(userIDs) > ANNOUNCEMENT_USER_IDS_MAX:
+		return validate.Fail("userIds", "too_many", fmt.Sprintf("must be at most %d users", ANNOUNCEMENT_USER_IDS_MAX))
+	}
+	for _, userID := range userIDs {
+		if fieldErr := validate.Check("userIds", userID, validate.Required(), validate.UUID()); fieldErr != nil {
+			fieldErr.Reason = "must all be UUIDs"
+			return fieldErr
+		}
+	}
+	return nil
+}
+
+// SetMaintenanceMode turns maintenance mode on or off on every instance. When
+// enabling with Drain, this instance's websocket clients are drained over
+// Websocket.DrainWindow in the background.
+func (c *AdminController) SetMaintenanceMode(
+	ctx context.Context,
+	user User,
+	maintenanceRequest maintenance.Request,
+) (maintenance.State, error) {
+	log := c.log.Function("SetMaintenanceMode")
+
+	maintenanceRequest.Message = strings.TrimSpace(maintenanceRequest.Message)
+	if err := ValidateMaintenance(maintenanceRequest); err != nil {
+		return maintenance.State{}, err
+	}
+
+	if c.maintenance == nil {
+		return maintenance.State{}, log.ErrMsg("maintenance mode is not configured")
+	}
 
-	event := events.Event{
-		ID:        uuid.New().String(),
-		Type:      "admin",
-		Channel:   "admin",
+	state, err := c.maintenance.Set(ctx, *maintenanceRequest.Enabled, maintenanceRequest.Message, user.ID)
+	if err != nil {
+		return state, err
+	}
+
+	if state.Enabled && maintenanceRequest.Drain && c.wsManager != nil {
+		go func() {
+			err := c.wsManager.Drain(context.Background(), c.Config.Websocket.DrainWindow, websockets.DRAIN_REASON_MAINTENANCE)
+			if err != nil {
+				log.Er("failed to drain websocket connections", err)
+			}
+		}()
+	}
+
+	return state, nil
+}
+
+// ValidateMaintenance checks a maintenance toggle request.
+func ValidateMaintenance(maintenanceRequest maintenance.Request) error {
+	var enabled *validate.FieldError
+	if maintenanceRequest.Enabled == nil {
+		enabled = validate.Fail("enabled", validate.CODE_REQUIRED, "is required")
+	}
+
+	errs := validate.Collect(
+		enabled,
+		validate.Check("message", maintenanceRequest.Message, validate.Length(0, maintenance.MESSAGE_MAX)),
+	)
+	if len(errs) > 0 {
+		return utils.FieldValidationError("invalid maintenance request", errs)
+	}
+	return nil
+}
+
+func (c *AdminController) ResetPassword(
+	ctx context.Context,
+	userID string,
+	resetRequest ResetPasswordRequest,
+) error {
+	log := c.log.Function("ResetPassword")
+
+	user, err := c.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return log.Err("failed to get user", err, "userID", userID)
+	}
+
+	if err := utils.ValidatePassword(
+		"password",
+		resetRequest.Password,
+		c.Config,
+		user.Login,
+		user.FirstName,
+		user.LastName,
+	); err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(resetRequest.Password, c.Config)
+	if err != nil {
+		return log.Err("failed to hash password", err, "userID", userID)
+	}
+
+	user.Password = hashedPassword
+	user.PepperVersion = c.Config.PepperVersion()
+	if err := c.userRepo.Update(ctx, user); err != nil {
+		return log.Err("failed to reset password", err, "userID", userID)
+	}
+
+	// Resume tokens issued under the old password stop working
+	if c.eventBus != nil {
+		if err := c.eventBus.UserLogoutTopic().Publish(ctx, events.UserLogoutEvent{UserID: user.ID}); err != nil {
+			log.Er("failed to publish user logout event", err, "userID", userID)
+		}
+	}
+
+	log.Info("Password reset by admin", "userID", userID)
+	return nil
+}
+
+// UpdateUser applies a partial update to any user, including the admin flag.
+// A stale precondition comes back as a *repositories.VersionConflictError.
+func (c *AdminController) UpdateUser(
+	ctx context.Context,
+	userID string,
+	updateRequest AdminUpdateUserRequest,
+	precondition utils.Precondition,
+) (User, error) {
+	log := c.log.Function("UpdateUser")
+
+	user, err := c.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return User{}, log.Err("failed to get user", err, "userID", userID)
+	}
+
+	expectedVersion, ok := precondition.ExpectedVersion(user.Version, user.UpdatedAt)
+	if !ok {
+		return User{}, &repositories.VersionConflictError{Current: user.Version}
+	}
+
+	before := *user
+	if err := updateRequest.Apply(user); err != nil {
+		return User{}, err
+	}
+	if updateRequest.IsAdmin != nil {
+		user.IsAdmin = *updateRequest.IsAdmin
+	}
+
+	if err := c.userRepo.UpdateProfile(ctx, user, expectedVersion); err != nil {
+		return User{}, err
+	}
+
+	// The user's open clients still show what they had
+	if fields := user.ChangedFields(before); len(fields) > 0 {
+		if err := c.eventBus.UserUpdatedTopic().Publish(ctx, events.UserUpdatedEvent{
+			UserID: userID,
+			Fields: fields,
+		}); err != nil {
+			log.Er("failed to publish user updated event", err, "userID", userID)
+		}
+	}
+
+	log.Info("User updated by admin", "userID", userID, "version", user.Version)
+	return *user, nil
+}
+
+// Impersonate starts a session as another user so support can see what they
+// see. The session lasts IMPERSONATION_EXPIRY, is never refreshed and points
+// back at the admin's own session so they can return to it.
+func (c *AdminController) Impersonate(
+	ctx context.Context,
+	admin User,
+	adminSession Session,
+	userID string,
+	ip string,
+	userAgent string,
+) (User, Session, error) {
+	log := c.log.Function("Impersonate")
+
+	if userID == admin.ID {
+		return User{}, Session{}, utils.NewValidationError("invalid impersonation request", "id", map[string]any{
+			"code":    "self",
+			"message": "admins can't impersonate themselves",
+		})
+	}
+
+	user, err := c.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return User{}, Session{}, log.Err("failed to get user", err, "userID", userID)
+	}
+
+	session := Session{
+		UserID:                user.ID,
+		DeviceName:            NormalizeDeviceName("Impersonated by " + admin.Login),
+		UserAgent:             userAgent,
+		ImpersonatedBy:        admin.ID,
+		ImpersonatorSessionID: adminSession.ID,
+	}
+	if err := c.sessionRepo.Create(ctx, &session, c.Config); err != nil {
+		return User{}, Session{}, log.Err("failed to create impersonation session", err, "userID", userID)
+	}
+
+	if err := c.eventBus.ImpersonationTopic().Publish(ctx, events.ImpersonationEvent{
+		Action:    events.IMPERSONATION_STARTED,
+		AdminID:   admin.ID,
 		UserID:    user.ID,
-		Data:      map[string]any{"message": message},
-		Timestamp: time.Now(),
+		SessionID: session.ID,
+		ExpiresAt: &session.ExpiresAt,
+		IP:        ip,
+	}); err != nil {
+		log.Er("failed to publish impersonation audit event", err, "sessionID", session.ID)
 	}
+	c.recordAudit(ctx, AuditLog{
+		Action:   AUDIT_ACTION_IMPERSONATION_STARTED,
+		Source:   AUDIT_SOURCE_API,
+		ActorID:  admin.ID,
+		TargetID: user.ID,
+		IP:       ip,
+		Details:  map[string]any{"sessionId": session.ID, "expiresAt": NewAPITime(session.ExpiresAt)},
+	})
 
-	if err := c.eventBus.Publish("broadcast", event); err != nil {
-		log.Er("failed to publish event", err, "event", event)
+	log.Info("Impersonation started", "adminID", admin.ID, "userID", user.ID, "sessionID", session.ID, "ip", ip)
+	return *user, session, nil
+}
+
+// ForceLogoutResult counts what ForceLogout ended.
+type ForceLogoutResult struct {
+	Sessions    int `json:"sessions"`
+	Connections int `json:"connections"`
+}
+
+// ForceLogout ends every session of the user and closes their websocket
+// connections on this instance. Each session's logout is published as well,
+// which revokes its resume tokens everywhere.
+func (c *AdminController) ForceLogout(
+	ctx context.Context,
+	admin User,
+	userID string,
+	ip string,
+) (ForceLogoutResult, error) {
+	log := c.log.Function("ForceLogout")
+
+	user, err := c.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return ForceLogoutResult{}, log.Err("failed to get user", err, "userID", userID)
+	}
+
+	sessions, err := c.sessionRepo.ListByUser(ctx, user.ID)
+	if err != nil {
+		return ForceLogoutResult{}, log.Err("failed to list sessions", err, "userID", user.ID)
+	}
+
+	var result ForceLogoutResult
+	for _, session := range sessions {
+		if err := c.sessionRepo.Delete(ctx, session.ID); err != nil {
+			return result, log.Err("failed to end session", err, "userID", user.ID, "sessionID", session.ID)
+		}
+		c.middleware.ForgetAuth(session.ID)
+		result.Sessions++
+
+		if err := c.eventBus.UserLogoutTopic().Publish(ctx, events.UserLogoutEvent{
+			UserID:    user.ID,
+			SessionID: session.ID,
+		}); err != nil {
+			log.Er("failed to publish user logout event", err, "userID", user.ID, "sessionID", session.ID)
+		}
+	}
+
+	if c.wsManager != nil {
+		result.Connections = c.wsManager.DisconnectUser(user.ID, FORCE_LOGOUT_REASON)
+	}
+
+	c.recordAudit(ctx, AuditLog{
+		Action:   AUDIT_ACTION_FORCE_LOGOUT,
+		Source:   AUDIT_SOURCE_API,
+		ActorID:  admin.ID,
+		TargetID: user.ID,
+		IP:       ip,
+		Details:  map[string]any{"sessions": result.Sessions, "connections": result.Connections},
+	})
+
+	log.Info("User logged out by admin",
+		"adminID", admin.ID, "userID", user.ID, "sessions", result.Sessions, "connections", result.Connections)
+	return result, nil
+}
+
+// FlushCache deletes the keys of a namespace, or every key, from each cache,
+// for when cached values went stale behind the application's back, such as
+// after editing the database by hand. Flushing sessions signs everyone out.
+// Users stay in each instance's local cache until USER_LOCAL_CACHE_EXPIRY.
+func (c *AdminController) FlushCache(
+	ctx context.Context,
+	admin User,
+	flushRequest CacheFlushRequest,
+	ip string,
+) (CacheFlushResult, error) {
+	log := c.log.Function("FlushCache")
+
+	flushRequest.Normalize()
+	if err := flushRequest.Validate(); err != nil {
+		return CacheFlushResult{}, err
+	}
+
+	result := CacheFlushResult{Namespace: flushRequest.Namespace}
+	var flushErr error
+	for _, cache := range c.caches {
+		deleted, err := cache.Flush(ctx, flushRequest.Namespace)
+		result.Deleted += deleted
+		if err != nil {
+			flushErr = err
+			break
+		}
+	}
+
+	// A flush that failed part way still deleted keys, so it is audited too
+	details := map[string]any{"namespace": result.Namespace, "deleted": result.Deleted}
+	if flushErr != nil {
+		details["error"] = flushErr.Error()
+	}
+	c.recordAudit(ctx, AuditLog{
+		Action:  AUDIT_ACTION_CACHE_FLUSHED,
+		Source:  AUDIT_SOURCE_API,
+		ActorID: admin.ID,
+		IP:      ip,
+		Details: details,
+	})
+	if flushErr != nil {
+		return result, log.Err("failed to flush cache", flushErr, "namespace", result.Namespace)
+	}
+
+	log.Info("Cache flushed", "adminID", admin.ID, "namespace", result.Namespace, "deleted", result.Deleted)
+	return result, nil
+}
+
+// recordAudit stores an audit entry. A failure is logged rather than undoing
+// the action it describes.
+func (c *AdminController) recordAudit(ctx context.Context, entry AuditLog) {
+	if c.auditRepo == nil {
 		return
 	}
+	if err := c.auditRepo.Create(ctx, &entry); err != nil {
+		c.log.Function("recordAudit").Er("failed to record audit entry", err, "action", entry.Action)
+	}
+}
+
+// UserLoginHistory returns a page of successful logins for any user, after
+// cursor when it isn't empty.
+func (c *AdminController) UserLoginHistory(
+	ctx context.Context,
+	userID string,
+	cursor string,
+) (LoginHistoryPage, error) {
+	events, next, err := c.loginEventRepo.ListSuccessful(ctx, userID, cursor)
+	if err != nil {
+		return LoginHistoryPage{}, err
+	}
 
-	log.Info("Broadcasting user login event", "message", message, "userID", user.ID)
+	return NewLoginHistoryPage(events, next), nil
+}
+
+// WebsocketDiagnostics returns what a user's clients reported of their
+// websocket connections to this instance, with the server's stats of those
+// still open.
+func (c *AdminController) WebsocketDiagnostics(
+	ctx context.Context,
+	userID string,
+) (websockets.UserDiagnostics, error) {
+	user, err := c.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return websockets.UserDiagnostics{}, err
+	}
+
+	if c.wsManager == nil {
+		return websockets.UserDiagnostics{
+			UserID:      user.ID,
+			Reports:     []websockets.DiagnosticsReport{},
+			Connections: []websockets.ConnectionStats{},
+		}, nil
+	}
+	return c.wsManager.UserDiagnostics(user.ID), nil
 }
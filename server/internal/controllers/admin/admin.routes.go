@@ -0,0 +1,553 @@
+package adminController
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"server/internal/apierror"
+	"server/internal/maintenance"
+	"server/internal/metrics"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"server/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (c *AdminController) RegisterRoutes(router fiber.Router) {
+	router.Get(
+		"/announcements",
+		c.middleware.CacheResponse(c.responseCache, ANNOUNCEMENTS_RESPONSE_CACHE, ANNOUNCEMENTS_RESPONSE_CACHE_TTL),
+		c.middleware.AuthIfPresent(),
+		c.middleware.LimitUserConcurrency(),
+		c.handleActiveAnnouncements,
+	)
+
+	admin := router.Group("/admin", c.middleware.BasicAuth(), c.middleware.LimitUserConcurrency())
+	admin.Post(
+		"/broadcast",
+		c.middleware.AdminRequired(),
+		c.middleware.LimitConcurrency(c.writeLimits, BROADCAST_WRITE_GROUP, 1),
+		c.handleBroadcast,
+	)
+	admin.Get("/stats", c.middleware.AdminRequired(), c.handleStats)
+	admin.Get("/metrics", c.middleware.AdminRequired(), c.handleMetrics)
+	admin.Get("/latency", c.middleware.AdminRequired(), c.handleLatency)
+	admin.Post("/maintenance", c.middleware.AdminRequired(), c.handleMaintenance)
+	admin.Post("/cache/flush", c.middleware.AdminRequired(), c.handleFlushCache)
+	admin.Post("/cache/warm", c.middleware.AdminRequired(), c.handleWarmCache)
+	admin.Get("/users", c.middleware.AdminRequired(), c.handleListUsers)
+	admin.Get("/users/:id", c.middleware.AdminRequired(), c.handleGetUser)
+	admin.Patch("/users/:id", c.middleware.AdminRequired(), c.handleUpdateUser)
+	admin.Post("/users/:id/password", c.middleware.AdminRequired(), c.handleResetPassword)
+	admin.Get("/users/:id/logins", c.middleware.AdminRequired(), c.handleUserLoginHistory)
+	admin.Post("/users/:id/impersonate", c.middleware.AdminRequired(), c.handleImpersonate)
+	admin.Post("/users/:id/logout", c.middleware.AdminRequired(), c.handleForceLogout)
+	admin.Get("/websocket/diagnostics/:userId", c.middleware.AdminRequired(), c.handleWebsocketDiagnostics)
+	admin.Get("/audit", c.middleware.AdminRequired(), c.handleAuditLog)
+	admin.Get("/audit/archives", c.middleware.AdminRequired(), c.handleAuditArchives)
+	admin.Get("/audit/archives/:id", c.middleware.AdminRequired(), c.handleDownloadAuditArchive)
+}
+
+func (c *AdminController) handleBroadcast(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleBroadcast")
+
+	var announcementRequest AnnouncementRequest
+	if err := ctx.BodyParser(&announcementRequest); err != nil {
+		log.Er("failed to parse announcement request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse announcement request"})
+	}
+
+	user := ctx.Locals("user").(User)
+	announcement, delivered, err := c.Announce(ctx.Context(), user, announcementRequest)
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		log.Er("failed to send announcement", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to send announcement"})
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":      "Broadcast sent",
+		"announcement": announcement,
+		"delivered":    delivered,
+	})
+}
+
+func (c *AdminController) handleStats(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleStats")
+
+	statsRequest := AdminStatsRequest{
+		LoginDays:        ctx.QueryInt("loginDays", ADMIN_STATS_LOGIN_DAYS_DEFAULT),
+		RegistrationDays: ctx.QueryInt("registrationDays", ADMIN_STATS_REGISTRATION_DAYS_DEFAULT),
+	}
+
+	stats, err := c.Stats(ctx.Context(), statsRequest)
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		log.Er("failed to build stats", err)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to get stats"})
+	}
+
+	return ctx.JSON(stats)
+}
+
+// handleMetrics serves this instance's metrics in the Prometheus text format.
+// Unlike stats they aren't shared, so each instance must be scraped.
+func (c *AdminController) handleMetrics(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleMetrics")
+
+	var text bytes.Buffer
+	if err := metrics.Default.WriteText(&text); err != nil {
+		log.Er("failed to write metrics", err)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to get metrics"})
+	}
+
+	ctx.Set(fiber.HeaderContentType, metrics.CONTENT_TYPE)
+	return ctx.Send(text.Bytes())
+}
+
+// handleLatency serves this instance's latency percentiles per route, for
+// deployments without Prometheus. Like metrics they aren't shared.
+func (c *AdminController) handleLatency(ctx *fiber.Ctx) error {
+	return ctx.JSON(fiber.Map{
+		"generatedAt": NewAPITime(c.clock.Now()),
+		"routes":      c.latency.Snapshot(),
+	})
+}
+
+func (c *AdminController) handleMaintenance(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleMaintenance")
+
+	var maintenanceRequest maintenance.Request
+	if err := ctx.BodyParser(&maintenanceRequest); err != nil {
+		log.Er("failed to parse maintenance request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse maintenance request"})
+	}
+
+	user := ctx.Locals("user").(User)
+	state, err := c.SetMaintenanceMode(ctx.Context(), user, maintenanceRequest)
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		log.Er("failed to set maintenance mode", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to set maintenance mode"})
+	}
+
+	message := "Maintenance mode disabled"
+	if state.Enabled {
+		message = "Maintenance mode enabled"
+	}
+	return ctx.JSON(fiber.Map{"message": message, "maintenance": state})
+}
+
+// handleFlushCache flushes the namespace in the body, or every namespace when
+// there is no body.
+func (c *AdminController) handleFlushCache(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleFlushCache")
+
+	var flushRequest CacheFlushRequest
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&flushRequest); err != nil {
+			log.Er("failed to parse cache flush request", err)
+			return ctx.Status(fiber.StatusBadRequest).
+				JSON(fiber.Map{"message": "failed to parse cache flush request"})
+		}
+	}
+
+	user := ctx.Locals("user").(User)
+	result, err := c.FlushCache(ctx.Context(), user, flushRequest, ctx.IP())
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		log.Er("failed to flush cache", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to flush cache", "deleted": result.Deleted})
+	}
+
+	return ctx.JSON(fiber.Map{"message": "Cache flushed", "namespace": result.Namespace, "deleted": result.Deleted})
+}
+
+func (c *AdminController) handleWarmCache(ctx *fiber.Ctx) error {
+	report, err := c.WarmCaches(ctx.Context())
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to warm caches", "report": report})
+	}
+
+	return ctx.JSON(fiber.Map{"message": "Caches warmed", "report": report})
+}
+
+func (c *AdminController) handleActiveAnnouncements(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleActiveAnnouncements")
+
+	var user *User
+	if authenticated, ok := ctx.Locals("user").(User); ok {
+		user = &authenticated
+	}
+
+	announcements, err := c.ActiveAnnouncements(ctx.Context(), user)
+	if err != nil {
+		log.Er("failed to list announcements", err)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to list announcements"})
+	}
+
+	return ctx.JSON(fiber.Map{"announcements": announcements})
+}
+
+// handleListUsers pages through users, or streams them all like the audit
+// log. Either way ?fields narrows each user to the named keys. Pages follow
+// ?sort and ?order; a stream is always newest first, so it refuses them.
+func (c *AdminController) handleListUsers(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleListUsers")
+
+	fields, err := ParseUserFields(ctx.Query("fields"))
+	if err != nil {
+		return unknownFieldResponse(ctx, err)
+	}
+	sort, err := ParseUserSort(ctx.Query("sort"), ctx.Query("order"))
+	if err != nil {
+		return unknownSortResponse(ctx, err)
+	}
+
+	if wantsStream(ctx) {
+		if sort != DefaultUserSort {
+			return unknownSortResponse(ctx, fmt.Errorf("%w: streams are always newest first", ErrUnknownSort))
+		}
+		return c.streamUsers(ctx, fields)
+	}
+
+	page := max(ctx.QueryInt("page", 1), 1)
+	users, err := c.ListUsers(ctx.Context(), page, sort, fields)
+	if err != nil {
+		log.Er("failed to list users", err, "page", page)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to list users"})
+	}
+
+	return ctx.JSON(users)
+}
+
+// streamUsers sends every user without holding them in memory, ending with a
+// line holding only a message if it fails part way, like streamAuditLog.
+func (c *AdminController) streamUsers(ctx *fiber.Ctx, fields FieldSelection) error {
+	log := c.log.Function("streamUsers")
+
+	ctx.Set(fiber.HeaderContentType, NDJSON_CONTENT_TYPE)
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		streamed, err := c.StreamUsers(context.Background(), w, fields)
+		switch {
+		case errors.Is(err, ErrStreamClosed):
+			log.Info("User stream closed early", "streamed", streamed, "error", err)
+		case err != nil:
+			log.Er("failed to stream users", err, "streamed", streamed)
+			_ = json.NewEncoder(w).Encode(fiber.Map{"message": "Failed to stream users"})
+			_ = w.Flush()
+		}
+	})
+
+	return nil
+}
+
+func (c *AdminController) handleGetUser(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleGetUser")
+
+	fields, err := ParseUserFields(ctx.Query("fields"))
+	if err != nil {
+		return unknownFieldResponse(ctx, err)
+	}
+
+	userID := ctx.Params("id")
+	user, err := c.User(ctx.Context(), userID, fields)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+	}
+	if err != nil {
+		log.Er("failed to get user", err, "userID", userID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to get user"})
+	}
+
+	return ctx.JSON(fiber.Map{"user": user})
+}
+
+// unknownFieldResponse answers a ?fields naming something a user doesn't
+// have with the names it could have used.
+func unknownFieldResponse(ctx *fiber.Ctx, err error) error {
+	return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"message":     err.Error(),
+		"validFields": UserFieldNames(),
+	})
+}
+
+func unknownSortResponse(ctx *fiber.Ctx, err error) error {
+	return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"message":    err.Error(),
+		"validSorts": UserSortFields,
+	})
+}
+
+func (c *AdminController) handleUpdateUser(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleUpdateUser")
+
+	precondition, err := utils.ParsePrecondition(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	var updateRequest AdminUpdateUserRequest
+	if err := ctx.BodyParser(&updateRequest); err != nil {
+		log.Er("failed to parse update user request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse update user request"})
+	}
+
+	userID := ctx.Params("id")
+	user, err := c.UpdateUser(ctx.Context(), userID, updateRequest, precondition)
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		var conflictErr *repositories.VersionConflictError
+		if errors.As(err, &conflictErr) {
+			return utils.PreconditionFailedResponse(ctx, conflictErr.Current)
+		}
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+		}
+		log.Er("failed to update user", err, "userID", userID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to update user"})
+	}
+
+	utils.ApplyVersionHeaders(ctx, user.Version, precondition)
+	return ctx.JSON(fiber.Map{"message": "User updated", "user": user.DTO(true)})
+}
+
+func (c *AdminController) handleResetPassword(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleResetPassword")
+
+	var resetRequest ResetPasswordRequest
+	if err := ctx.BodyParser(&resetRequest); err != nil {
+		log.Er("failed to parse reset password request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse reset password request"})
+	}
+
+	userID := ctx.Params("id")
+	if err := c.ResetPassword(ctx.Context(), userID, resetRequest); err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		log.Er("failed to reset password", err, "userID", userID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to reset password"})
+	}
+
+	return ctx.JSON(fiber.Map{"message": "Password reset"})
+}
+
+func (c *AdminController) handleUserLoginHistory(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleUserLoginHistory")
+
+	userID := ctx.Params("id")
+	history, err := c.UserLoginHistory(ctx.Context(), userID, ctx.Query("cursor"))
+	if errors.Is(err, repositories.ErrInvalidCursor) {
+		return apierror.Send(ctx, apierror.New(apierror.CODE_INVALID_CURSOR, "The cursor is not one this list handed out"), c.Config)
+	}
+	if err != nil {
+		log.Er("failed to get login history", err, "userID", userID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to get login history"})
+	}
+
+	return ctx.JSON(history)
+}
+
+func (c *AdminController) handleWebsocketDiagnostics(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleWebsocketDiagnostics")
+
+	userID := ctx.Params("userId")
+	diagnostics, err := c.WebsocketDiagnostics(ctx.Context(), userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+	}
+	if err != nil {
+		log.Er("failed to get websocket diagnostics", err, "userID", userID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to get websocket diagnostics"})
+	}
+
+	return ctx.JSON(diagnostics)
+}
+
+func (c *AdminController) handleImpersonate(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleImpersonate")
+
+	admin := ctx.Locals("user").(User)
+	adminSession, _ := ctx.Locals("session").(Session)
+	userID := ctx.Params("id")
+
+	user, session, err := c.Impersonate(
+		ctx.Context(),
+		admin,
+		adminSession,
+		userID,
+		ctx.IP(),
+		ctx.Get(fiber.HeaderUserAgent),
+	)
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+		}
+		log.Er("failed to impersonate user", err, "adminID", admin.ID, "userID", userID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to impersonate user"})
+	}
+
+	utils.ApplyCookie(ctx, utils.Cookie{
+		Name:    SESSION_COOKIE_KEY,
+		Value:   session.ID,
+		Expires: session.ExpiresAt,
+	}, c.Config)
+	utils.ApplyToken(ctx, session.Token)
+	ctx.Set(middleware.IMPERSONATING_HEADER, "true")
+
+	return ctx.JSON(fiber.Map{
+		"message": "Impersonation started",
+		"user":    user.DTO(true),
+		"session": session.Summary(),
+	})
+}
+
+func (c *AdminController) handleForceLogout(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleForceLogout")
+
+	admin := ctx.Locals("user").(User)
+	userID := ctx.Params("id")
+
+	result, err := c.ForceLogout(ctx.Context(), admin, userID, ctx.IP())
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "User not found"})
+		}
+		log.Er("failed to log user out", err, "adminID", admin.ID, "userID", userID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to log user out"})
+	}
+
+	return ctx.JSON(fiber.Map{
+		"message":     "User logged out",
+		"sessions":    result.Sessions,
+		"connections": result.Connections,
+	})
+}
+
+func (c *AdminController) handleAuditLog(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleAuditLog")
+
+	if wantsStream(ctx) {
+		return c.streamAuditLog(ctx)
+	}
+
+	auditLog, err := c.AuditLog(ctx.Context(), ctx.Query("cursor"))
+	if errors.Is(err, repositories.ErrInvalidCursor) {
+		return apierror.Send(ctx, apierror.New(apierror.CODE_INVALID_CURSOR, "The cursor is not one this list handed out"), c.Config)
+	}
+	if err != nil {
+		log.Er("failed to get audit log", err)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to get audit log"})
+	}
+
+	return ctx.JSON(auditLog)
+}
+
+// wantsStream reports whether the client asked for the whole list as JSON
+// lines, with ?stream=true or by preferring NDJSON_CONTENT_TYPE.
+func wantsStream(ctx *fiber.Ctx) bool {
+	return ctx.QueryBool("stream") ||
+		ctx.Accepts(fiber.MIMEApplicationJSON, NDJSON_CONTENT_TYPE) == NDJSON_CONTENT_TYPE
+}
+
+// streamAuditLog sends the whole audit log without holding it in memory.
+// Once the first line is out the status can't change, so an error part way
+// through ends the stream with a line holding only a message.
+func (c *AdminController) streamAuditLog(ctx *fiber.Ctx) error {
+	log := c.log.Function("streamAuditLog")
+
+	ctx.Set(fiber.HeaderContentType, NDJSON_CONTENT_TYPE)
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		streamed, err := c.StreamAuditLog(context.Background(), w)
+		switch {
+		case errors.Is(err, ErrStreamClosed):
+			log.Info("Audit log stream closed early", "streamed", streamed, "error", err)
+		case err != nil:
+			log.Er("failed to stream audit log", err, "streamed", streamed)
+			_ = json.NewEncoder(w).Encode(fiber.Map{"message": "Failed to stream audit log"})
+			_ = w.Flush()
+		}
+	})
+
+	return nil
+}
+
+func (c *AdminController) handleAuditArchives(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleAuditArchives")
+
+	archives, err := c.AuditArchives(ctx.Context())
+	if err != nil {
+		log.Er("failed to list audit archives", err)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to list audit archives"})
+	}
+
+	return ctx.JSON(fiber.Map{"archives": archives})
+}
+
+func (c *AdminController) handleDownloadAuditArchive(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleDownloadAuditArchive")
+
+	archiveID := ctx.Params("id")
+	archive, path, err := c.AuditArchiveFile(ctx.Context(), archiveID)
+	switch {
+	case errors.Is(err, repositories.ErrNotFound):
+		return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Audit archive not found"})
+	case errors.Is(err, ErrAuditArchiveMissing):
+		log.Warn("Audit archive file is missing", "archiveID", archiveID, "file", archive.File)
+		return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "Audit archive file not found"})
+	case err != nil:
+		log.Er("failed to get audit archive", err, "archiveID", archiveID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to get audit archive"})
+	}
+
+	ctx.Set(AUDIT_ARCHIVE_CHECKSUM_HEADER, archive.Checksum)
+	return ctx.Download(path, archive.File)
+}
@@ -0,0 +1,1316 @@
+package adminController
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"server/config"
+	"server/internal/clock"
+	userController "server/internal/controllers/users"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	"server/internal/maintenance"
+	"server/internal/metrics"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"server/internal/utils"
+	"server/internal/utils/testsupport"
+	"server/internal/websockets"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type MockUserRepository struct {
+	mock.Mock
+}
+
+func (m *MockUserRepository) GetByID(ctx context.Context, id string) (*User, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*User), args.Error(1)
+}
+
+func (m *MockUserRepository) GetByIDs(ctx context.Context, ids []string) ([]User, error) {
+	args := m.Called(ctx, ids)
+	users, _ := args.Get(0).([]User)
+	return users, args.Error(1)
+}
+
+func (m *MockUserRepository) GetByLogin(ctx context.Context, login string) (*User, error) {
+	args := m.Called(ctx, login)
+	return args.Get(0).(*User), args.Error(1)
+}
+
+func (m *MockUserRepository) Create(ctx context.Context, user *User, config config.Config) error {
+	args := m.Called(ctx, user, config)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) Update(ctx context.Context, user *User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) UpdateProfile(ctx context.Context, user *User, expectedVersion int) error {
+	args := m.Called(ctx, user, expectedVersion)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) List(ctx context.Context, page int, sort UserSort, columns []string) ([]User, bool, error) {
+	args := m.Called(ctx, page, sort, columns)
+	return args.Get(0).([]User), args.Bool(1), args.Error(2)
+}
+
+func (m *MockUserRepository) Stream(ctx context.Context, columns []string, each func(users []User) error) (int64, error) {
+	args := m.Called(ctx, columns, each)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type MockSessionRepository struct {
+	mock.Mock
+}
+
+func (m *MockSessionRepository) Create(ctx context.Context, session *Session, config config.Config) error {
+	args := m.Called(ctx, session, config)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) Extend(ctx context.Context, session *Session, expiresAt time.Time, config config.Config) error {
+	args := m.Called(ctx, session, expiresAt, config)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) GetByID(ctx context.Context, id string) (*Session, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*Session), args.Error(1)
+}
+
+func (m *MockSessionRepository) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) ListByUser(ctx context.Context, userID string) ([]*Session, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]*Session), args.Error(1)
+}
+
+func (m *MockSessionRepository) DeleteByUser(ctx context.Context, userID string) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) CountActive(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockSessionRepository) ReapExpired(ctx context.Context, expiredBefore time.Time, limit int) (int, error) {
+	args := m.Called(ctx, expiredBefore, limit)
+	return args.Int(0), args.Error(1)
+}
+
+type MockAnnouncementRepository struct {
+	mock.Mock
+}
+
+func (m *MockAnnouncementRepository) Create(ctx context.Context, announcement *Announcement) error {
+	args := m.Called(ctx, announcement)
+	if announcement.ID == "" {
+		announcement.ID = "announcement-1"
+	}
+	return args.Error(0)
+}
+
+func (m *MockAnnouncementRepository) ListActive(ctx context.Context, now time.Time) ([]Announcement, error) {
+	args := m.Called(ctx, now)
+	return args.Get(0).([]Announcement), args.Error(1)
+}
+
+// fakeWebSocketManager reports fixed counts. What it notifies goes to the
+// embedded mock, when there is one.
+type fakeWebSocketManager struct {
+	*testsupport.MockWebsocketNotifier
+	clients   int
+	guests    int
+	audiences map[string]int
+	drains    chan string
+	// diagnostics by user id
+	diagnostics map[string]websockets.UserDiagnostics
+}
+
+func (f fakeWebSocketManager) AuthenticatedClientCount() int {
+	return f.clients
+}
+
+func (f fakeWebSocketManager) AudienceClientCount(audience string, userIDs []string) int {
+	if count, ok := f.audiences[audience]; ok {
+		return count
+	}
+	return f.clients
+}
+
+func (f fakeWebSocketManager) ConnectionCount() int {
+	return f.clients + f.guests
+}
+
+func (f fakeWebSocketManager) UserDiagnostics(userID string) websockets.UserDiagnostics {
+	return f.diagnostics[userID]
+}
+
+func (f fakeWebSocketManager) Drain(ctx context.Context, window time.Duration, reason string) error {
+	if f.drains != nil {
+		f.drains <- reason
+	}
+	return nil
+}
+
+// adminTestNow is the time on the fake clock of the controllers under test.
+var adminTestNow = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func validAnnouncementRequest() AnnouncementRequest {
+	return AnnouncementRequest{
+		Title:     "Maintenance",
+		Body:      "Maintenance in 10 minutes",
+		Severity:  ANNOUNCEMENT_SEVERITY_WARNING,
+		ExpiresAt: adminTestNow.Add(time.Hour),
+	}
+}
+
+func testUserID(n int) string {
+	return fmt.Sprintf("0190a1b2-0000-7000-8000-%012d", n)
+}
+
+func testUserIDs(count int) []string {
+	userIDs := make([]string, count)
+	for i := range userIDs {
+		userIDs[i] = testUserID(i + 1)
+	}
+	return userIDs
+}
+
+func TestValidateAnnouncement(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	valid := AnnouncementRequest{
+		Title:     "Maintenance",
+		Body:      "Back soon",
+		Severity:  ANNOUNCEMENT_SEVERITY_INFO,
+		ExpiresAt: now.Add(time.Minute),
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(*AnnouncementRequest)
+		field  string
+		code   string
+	}{
+		{"valid", func(r *AnnouncementRequest) {}, "", ""},
+		{"missing title", func(r *AnnouncementRequest) { r.Title = "" }, "title", "required"},
+		{"long title", func(r *AnnouncementRequest) { r.Title = strings.Repeat("x", ANNOUNCEMENT_TITLE_MAX+1) }, "title", "too_long"},
+		{"missing body", func(r *AnnouncementRequest) { r.Body = "" }, "body", "required"},
+		{"long body", func(r *AnnouncementRequest) { r.Body = strings.Repeat("x", ANNOUNCEMENT_BODY_MAX+1) }, "body", "too_long"},
+		{"unknown severity", func(r *AnnouncementRequest) { r.Severity = "urgent" }, "severity", "invalid"},
+		{"missing expiry", func(r *AnnouncementRequest) { r.ExpiresAt = time.Time{} }, "expiresAt", "required"},
+		{"expires now", func(r *AnnouncementRequest) { r.ExpiresAt = now }, "expiresAt", "in_past"},
+		{"expired", func(r *AnnouncementRequest) { r.ExpiresAt = now.Add(-time.Minute) }, "expiresAt", "in_past"},
+		{"admins", func(r *AnnouncementRequest) { r.Audience = ANNOUNCEMENT_AUDIENCE_ADMINS }, "", ""},
+		{"users", func(r *AnnouncementRequest) {
+			r.Audience = ANNOUNCEMENT_AUDIENCE_USERS
+			r.UserIDs = []string{testUserID(1), testUserID(2)}
+		}, "", ""},
+		{"unknown audience", func(r *AnnouncementRequest) { r.Audience = "guests" }, "audience", "invalid"},
+		{"users without ids", func(r *AnnouncementRequest) { r.Audience = ANNOUNCEMENT_AUDIENCE_USERS }, "userIds", "required"},
+		{"users at the cap", func(r *AnnouncementRequest) {
+			r.Audience = ANNOUNCEMENT_AUDIENCE_USERS
+			r.UserIDs = testUserIDs(ANNOUNCEMENT_USER_IDS_MAX)
+		}, "", ""},
+		{"users over the cap", func(r *AnnouncementRequest) {
+			r.Audience = ANNOUNCEMENT_AUDIENCE_USERS
+			r.UserIDs = testUserIDs(ANNOUNCEMENT_USER_IDS_MAX + 1)
+		}, "userIds", "too_many"},
+		{"user id not a uuid", func(r *AnnouncementRequest) {
+			r.Audience = ANNOUNCEMENT_AUDIENCE_USERS
+			r.UserIDs = []string{testUserID(1), "admin"}
+		}, "userIds", "invalid_uuid"},
+		{"user ids for everyone", func(r *AnnouncementRequest) {
+			r.Audience = ANNOUNCEMENT_AUDIENCE_ALL
+			r.UserIDs = []string{testUserID(1)}
+		}, "userIds", "not_allowed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := valid
+			tc.modify(&request)
+
+			err := ValidateAnnouncement(request, now)
+			if tc.field == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *utils.ValidationError
+			require.True(t, errors.As(err, &validationErr))
+			detail := validationErr.Details[tc.field].(map[string]any)
+			assert.Equal(t, tc.code, detail["code"])
+		})
+	}
+}
+
+func TestAdminController_Announce_PersistsAndBroadcasts(t *testing.T) {
+	eventBus := events.New(nil, config.Config{}, nil)
+	received := make(chan events.AdminBroadcastEvent, 1)
+	require.NoError(t, eventBus.BroadcastTopic().Subscribe(
+		func(ctx context.Context, event events.AdminBroadcastEvent) error {
+			received <- event
+			return nil
+		},
+	))
+
+	mockAnnouncementRepo := &MockAnnouncementRepository{}
+	mockAnnouncementRepo.On("Create", mock.Anything, mock.MatchedBy(func(a *Announcement) bool {
+		return a.Title == "Maintenance" && a.Severity == ANNOUNCEMENT_SEVERITY_WARNING && a.CreatedBy == "admin-1"
+	})).Return(nil)
+
+	controller := New(eventBus, nil, nil, mockAnnouncementRepo, nil, nil, nil, nil, middleware.Middleware{}, config.Config{}, clock.NewFake(adminTestNow))
+	controller.SetWebSocketManager(fakeWebSocketManager{clients: 3})
+
+	request := validAnnouncementRequest()
+	request.Severity = " Warning "
+	announcement, delivered, err := controller.Announce(
+		context.Background(),
+		User{BaseModel: BaseModel{ID: "admin-1"}, IsAdmin: true},
+		request,
+	)
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, delivered)
+	assert.Equal(t, "announcement-1", announcement.ID)
+	mockAnnouncementRepo.AssertExpectations(t)
+
+	select {
+	case event := <-received:
+		assert.Equal(t, "announcement-1", event.ID)
+		assert.Equal(t, "Maintenance", event.Title)
+		assert.Equal(t, "Maintenance in 10 minutes", event.Message)
+		assert.Equal(t, ANNOUNCEMENT_SEVERITY_WARNING, event.Severity)
+		require.NotNil(t, event.ExpiresAt)
+		assert.True(t, request.ExpiresAt.Truncate(time.Millisecond).Equal(event.ExpiresAt.Time), "to the millisecond the API carries")
+	case <-time.After(time.Second):
+		t.Fatal("announcement was not published")
+	}
+}
+
+func TestAdminController_Announce_Audience(t *testing.T) {
+	testCases := []struct {
+		name      string
+		audience  string
+		userIDs   []string
+		expected  string
+		stored    []string
+		delivered int
+	}{
+		{"everyone by default", "", nil, ANNOUNCEMENT_AUDIENCE_ALL, nil, 5},
+		{"admins", " Admins ", nil, ANNOUNCEMENT_AUDIENCE_ADMINS, nil, 1},
+		{
+			"users implied by ids",
+			"",
+			[]string{testUserID(2), testUserID(1), testUserID(2)},
+			ANNOUNCEMENT_AUDIENCE_USERS,
+			[]string{testUserID(1), testUserID(2)},
+			2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			eventBus := events.New(nil, config.Config{}, nil)
+			received := make(chan events.AdminBroadcastEvent, 1)
+			require.NoError(t, eventBus.BroadcastTopic().Subscribe(
+				func(ctx context.Context, event events.AdminBroadcastEvent) error {
+					received <- event
+					return nil
+				},
+			))
+
+			mockAnnouncementRepo := &MockAnnouncementRepository{}
+			mockAnnouncementRepo.On("Create", mock.Anything, mock.MatchedBy(func(a *Announcement) bool {
+				return a.Audience == tc.expected && assert.ObjectsAreEqual(tc.stored, a.UserIDs)
+			})).Return(nil)
+
+			controller := New(eventBus, nil, nil, mockAnnouncementRepo, nil, nil, nil, nil, middleware.Middleware{}, config.Config{}, clock.NewFake(adminTestNow))
+			controller.SetWebSocketManager(fakeWebSocketManager{
+				clients:   5,
+				audiences: map[string]int{ANNOUNCEMENT_AUDIENCE_ADMINS: 1, ANNOUNCEMENT_AUDIENCE_USERS: 2},
+			})
+
+			request := validAnnouncementRequest()
+			request.Audience = tc.audience
+			request.UserIDs = tc.userIDs
+			_, delivered, err := controller.Announce(context.Background(), User{BaseModel: BaseModel{ID: "admin-1"}}, request)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.delivered, delivered)
+			mockAnnouncementRepo.AssertExpectations(t)
+
+			select {
+			case event := <-received:
+				assert.Equal(t, tc.expected, event.Audience)
+				assert.Equal(t, tc.stored, event.UserIDs)
+			case <-time.After(time.Second):
+				t.Fatal("announcement was not published")
+			}
+		})
+	}
+}
+
+func TestAdminController_Announce_WithoutWebSocketManager(t *testing.T) {
+	mockAnnouncementRepo := &MockAnnouncementRepository{}
+	mockAnnouncementRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	controller := New(events.New(nil, config.Config{}, nil), nil, nil, mockAnnouncementRepo, nil, nil, nil, nil, middleware.Middleware{}, config.Config{}, clock.NewFake(adminTestNow))
+
+	_, delivered, err := controller.Announce(context.Background(), User{}, validAnnouncementRequest())
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+}
+
+func TestAdminController_Announce_InvalidIsNotStored(t *testing.T) {
+	mockAnnouncementRepo := &MockAnnouncementRepository{}
+	controller := New(events.New(nil, config.Config{}, nil), nil, nil, mockAnnouncementRepo, nil, nil, nil, nil, middleware.Middleware{}, config.Config{}, clock.NewFake(adminTestNow))
+
+	request := validAnnouncementRequest()
+	request.Severity = "urgent"
+	_, _, err := controller.Announce(context.Background(), User{}, request)
+
+	var validationErr *utils.ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	mockAnnouncementRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestAdminController_Announce_ExpiryByClock(t *testing.T) {
+	mockAnnouncementRepo := &MockAnnouncementRepository{}
+	fake := clock.NewFake(adminTestNow)
+	controller := New(events.New(nil, config.Config{}, nil), nil, nil, mockAnnouncementRepo, nil, nil, nil, nil, middleware.Middleware{}, config.Config{}, fake)
+
+	fake.Advance(2 * time.Hour)
+	_, _, err := controller.Announce(context.Background(), User{}, validAnnouncementRequest())
+
+	var validationErr *utils.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "in_past", validationErr.Details["expiresAt"].(map[string]any)["code"])
+	mockAnnouncementRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func setupAdminRoutesTest(isAdmin bool) (*fiber.App, *MockAnnouncementRepository) {
+	testConfig := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret"}}
+	fake := clock.NewFake(adminTestNow)
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, "session-1").Return(&Session{
+		ID:        "session-1",
+		UserID:    "user-1",
+		ExpiresAt: adminTestNow.Add(time.Hour),
+		RefreshAt: adminTestNow.Add(time.Hour),
+	}, nil)
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").
+		Return(&User{BaseModel: BaseModel{ID: "user-1"}, IsAdmin: isAdmin}, nil)
+
+	mockAnnouncementRepo := &MockAnnouncementRepository{}
+
+	eventBus := events.New(nil, testConfig, nil)
+	mw := middleware.New(database.DB{}, eventBus, testConfig, mockUserRepo, mockSessionRepo, fake)
+	controller := New(eventBus, mockUserRepo, nil, mockAnnouncementRepo, nil, nil, nil, nil, mw, testConfig, fake)
+	controller.log = logger.New("test")
+	controller.SetWebSocketManager(fakeWebSocketManager{clients: 2})
+
+	mode, err := maintenance.New(context.Background(), database.NewMemoryCacheStore(), nil, testConfig, nil)
+	if err != nil {
+		panic(err)
+	}
+	controller.SetMaintenance(mode)
+	controller.SetResponseCache(middleware.NewResponseCache(database.NewMemoryCacheStore(), nil))
+
+	fiberApp := fiber.New()
+	controller.RegisterRoutes(fiberApp)
+	return fiberApp, mockAnnouncementRepo
+}
+
+func TestAdminController_HandleBroadcast(t *testing.T) {
+	encoded, err := json.Marshal(validAnnouncementRequest())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		isAdmin bool
+		body    string
+		status  int
+	}{
+		{"non-admin rejected", false, string(encoded), fiber.StatusForbidden},
+		{"admin accepted", true, string(encoded), fiber.StatusCreated},
+		{"admin invalid payload", true, `{"title":"","body":"x","severity":"info"}`, fiber.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fiberApp, mockAnnouncementRepo := setupAdminRoutesTest(tc.isAdmin)
+			mockAnnouncementRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+			req := httptest.NewRequest("POST", "/admin/broadcast", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Client-Type", "solid")
+			req.Header.Set("Cookie", SESSION_COOKIE_KEY+"=session-1")
+
+			resp, err := fiberApp.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+
+			if tc.status != fiber.StatusCreated {
+				mockAnnouncementRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+				return
+			}
+
+			var result map[string]any
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			assert.Equal(t, float64(2), result["delivered"])
+			assert.Equal(t, "Maintenance", result["announcement"].(map[string]any)["title"])
+		})
+	}
+}
+
+func TestAdminController_HandleMaintenance(t *testing.T) {
+	testCases := []struct {
+		name    string
+		isAdmin bool
+		body    string
+		status  int
+		enabled bool
+		message string
+	}{
+		{"non-admin rejected", false, `{"enabled":true}`, fiber.StatusForbidden, false, ""},
+		{"enable with message", true, `{"enabled":true,"message":" Back at noon "}`, fiber.StatusOK, true, "Back at noon"},
+		{"enable with default message", true, `{"enabled":true}`, fiber.StatusOK, true, maintenance.DEFAULT_MESSAGE},
+		{"disable", true, `{"enabled":false}`, fiber.StatusOK, false, ""},
+		{"missing enabled", true, `{"message":"x"}`, fiber.StatusUnprocessableEntity, false, ""},
+		{"message too long", true, `{"enabled":true,"message":"` + strings.Repeat("x", maintenance.MESSAGE_MAX+1) + `"}`,
+			fiber.StatusUnprocessableEntity, false, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fiberApp, _ := setupAdminRoutesTest(tc.isAdmin)
+
+			req := httptest.NewRequest("POST", "/admin/maintenance", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Client-Type", "solid")
+			req.Header.Set("Cookie", SESSION_COOKIE_KEY+"=session-1")
+
+			resp, err := fiberApp.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+			if tc.status != fiber.StatusOK {
+				return
+			}
+
+			var result struct {
+				Maintenance maintenance.State `json:"maintenance"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			assert.Equal(t, tc.enabled, result.Maintenance.Enabled)
+			assert.Equal(t, tc.message, result.Maintenance.Message)
+			assert.Equal(t, "user-1", result.Maintenance.UpdatedBy)
+		})
+	}
+}
+
+func TestAdminController_SetMaintenanceMode_Drain(t *testing.T) {
+	enabled, disabled := true, false
+	testCases := []struct {
+		name    string
+		request maintenance.Request
+		drained bool
+	}{
+		{"enable without drain", maintenance.Request{Enabled: &enabled}, false},
+		{"enable with drain", maintenance.Request{Enabled: &enabled, Drain: true}, true},
+		{"disable ignores drain", maintenance.Request{Enabled: &disabled, Drain: true}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Config{Websocket: config.WebsocketConfig{DrainWindow: 20 * time.Second}}
+			controller := New(events.New(nil, cfg, nil), nil, nil, nil, nil, nil, nil, nil, middleware.Middleware{}, cfg, nil)
+			drains := make(chan string, 1)
+			controller.SetWebSocketManager(fakeWebSocketManager{drains: drains})
+
+			mode, err := maintenance.New(context.Background(), database.NewMemoryCacheStore(), nil, cfg, nil)
+			require.NoError(t, err)
+			controller.SetMaintenance(mode)
+
+			_, err = controller.SetMaintenanceMode(context.Background(), User{BaseModel: BaseModel{ID: "admin-1"}}, tc.request)
+			require.NoError(t, err)
+
+			select {
+			case reason := <-drains:
+				assert.True(t, tc.drained, "unexpected drain")
+				assert.Equal(t, websockets.DRAIN_REASON_MAINTENANCE, reason)
+			case <-time.After(100 * time.Millisecond):
+				assert.False(t, tc.drained, "expected a drain")
+			}
+		})
+	}
+}
+
+func TestAdminController_HandleActiveAnnouncements(t *testing.T) {
+	fiberApp, mockAnnouncementRepo := setupAdminRoutesTest(false)
+	mockAnnouncementRepo.On("ListActive", mock.Anything, adminTestNow).
+		Return([]Announcement{{Title: "Maintenance"}}, nil)
+
+	resp, err := fiberApp.Test(httptest.NewRequest("GET", "/announcements", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var result map[string][]map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.Len(t, result["announcements"], 1)
+	assert.Equal(t, "Maintenance", result["announcements"][0]["title"])
+}
+
+func TestAdminController_HandleActiveAnnouncements_FiltersByAudience(t *testing.T) {
+	announcements := []Announcement{
+		{Title: "Everyone", Audience: ANNOUNCEMENT_AUDIENCE_ALL},
+		{Title: "Admins", Audience: ANNOUNCEMENT_AUDIENCE_ADMINS},
+		{Title: "Listed", Audience: ANNOUNCEMENT_AUDIENCE_USERS, UserIDs: []string{"user-1"}},
+		{Title: "Others", Audience: ANNOUNCEMENT_AUDIENCE_USERS, UserIDs: []string{"user-2"}},
+	}
+
+	testCases := []struct {
+		name     string
+		signedIn bool
+		isAdmin  bool
+		expected []string
+	}{
+		{"anonymous", false, false, []string{"Everyone"}},
+		{"listed user", true, false, []string{"Everyone", "Listed"}},
+		{"admin", true, true, []string{"Everyone", "Admins", "Listed"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fiberApp, mockAnnouncementRepo := setupAdminRoutesTest(tc.isAdmin)
+			mockAnnouncementRepo.On("ListActive", mock.Anything, adminTestNow).Return(announcements, nil)
+
+			req := httptest.NewRequest("GET", "/announcements", nil)
+			if tc.signedIn {
+				req.Header.Set("X-Client-Type", "solid")
+				req.Header.Set("Cookie", SESSION_COOKIE_KEY+"=session-1")
+			}
+			resp, err := fiberApp.Test(req)
+			require.NoError(t, err)
+			require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			var result map[string][]map[string]any
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			titles := []string{}
+			for _, announcement := range result["announcements"] {
+				titles = append(titles, announcement["title"].(string))
+				assert.NotContains(t, announcement, "userIds")
+			}
+			assert.Equal(t, tc.expected, titles)
+		})
+	}
+}
+
+func TestAdminController_HandleActiveAnnouncements_CachedUntilBroadcast(t *testing.T) {
+	fiberApp, mockAnnouncementRepo := setupAdminRoutesTest(true)
+	mockAnnouncementRepo.On("ListActive", mock.Anything, adminTestNow).
+		Return([]Announcement{{Title: "Maintenance"}}, nil).Once()
+	mockAnnouncementRepo.On("ListActive", mock.Anything, adminTestNow).
+		Return([]Announcement{{Title: "Maintenance"}, {Title: "Release"}}, nil).Once()
+	mockAnnouncementRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	listAnnouncements := func() (string, int) {
+		resp, err := fiberApp.Test(httptest.NewRequest("GET", "/announcements", nil))
+		require.NoError(t, err)
+		var result map[string][]map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return resp.Header.Get(middleware.CACHE_STATUS_HEADER), len(result["announcements"])
+	}
+
+	status, count := listAnnouncements()
+	assert.Equal(t, middleware.CACHE_STATUS_MISS, status)
+	assert.Equal(t, 1, count)
+
+	status, count = listAnnouncements()
+	assert.Equal(t, middleware.CACHE_STATUS_HIT, status)
+	assert.Equal(t, 1, count)
+
+	encoded, err := json.Marshal(validAnnouncementRequest())
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/admin/broadcast", strings.NewReader(string(encoded)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Client-Type", "solid")
+	req.Header.Set("Cookie", SESSION_COOKIE_KEY+"=session-1")
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusCreated, resp.StatusCode)
+
+	status, count = listAnnouncements()
+	assert.Equal(t, middleware.CACHE_STATUS_MISS, status)
+	assert.Equal(t, 2, count)
+	mockAnnouncementRepo.AssertExpectations(t)
+}
+
+func setupUpdateUserTest(t *testing.T) (*fiber.App, *User) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+	userRepo := repositories.New(database.DB{SQL: db}, invalidator)
+	user := &User{Login: "jdoe", FirstName: "Jane"}
+	require.NoError(t, userRepo.Create(context.Background(), user, config.Config{}))
+
+	controller := New(events.New(nil, config.Config{}, nil), userRepo, nil, nil, nil, nil, nil, nil, middleware.Middleware{}, config.Config{}, nil)
+
+	fiberApp := fiber.New()
+	fiberApp.Patch("/admin/users/:id", controller.handleUpdateUser)
+	return fiberApp, user
+}
+
+func patchUser(t *testing.T, fiberApp *fiber.App, userID string, body string, ifMatch string) *http.Response {
+	req := httptest.NewRequest("PATCH", "/admin/users/"+userID, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestAdminController_HandleUpdateUser_Preconditions(t *testing.T) {
+	testCases := []struct {
+		name    string
+		ifMatch string
+		status  int
+		warning bool
+	}{
+		{"matching version", `"1"`, fiber.StatusOK, false},
+		{"stale version", `"7"`, fiber.StatusPreconditionFailed, false},
+		{"no precondition", "", fiber.StatusOK, true},
+		{"malformed header", "soon", fiber.StatusBadRequest, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fiberApp, user := setupUpdateUserTest(t)
+
+			resp := patchUser(t, fiberApp, user.ID, `{"firstName":"Janet","isAdmin":true}`, tc.ifMatch)
+			require.Equal(t, tc.status, resp.StatusCode)
+			assert.Equal(t, tc.warning, resp.Header.Get(fiber.HeaderWarning) != "")
+
+			var result map[string]any
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+
+			switch tc.status {
+			case fiber.StatusOK:
+				updated := result["user"].(map[string]any)
+				assert.Equal(t, "Janet", updated["firstName"])
+				assert.Equal(t, true, updated["isAdmin"])
+				assert.Equal(t, float64(2), updated["version"])
+				assert.Equal(t, `"2"`, resp.Header.Get(fiber.HeaderETag))
+			case fiber.StatusPreconditionFailed:
+				assert.Equal(t, float64(1), result["version"])
+			}
+		})
+	}
+}
+
+func TestAdminController_HandleUpdateUser_UnknownUser(t *testing.T) {
+	fiberApp, _ := setupUpdateUserTest(t)
+
+	resp := patchUser(t, fiberApp, "01900000-0000-7000-8000-000000000000", `{"firstName":"Janet"}`, "")
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+}
+
+func TestAdminController_HandleUpdateUser_ConcurrentEdits(t *testing.T) {
+	fiberApp, user := setupUpdateUserTest(t)
+
+	statuses := make(chan int, 2)
+	var wg sync.WaitGroup
+	for _, name := range []string{"Alice", "Bob"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("PATCH", "/admin/users/"+user.ID, strings.NewReader(`{"firstName":"`+name+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", `"1"`)
+
+			resp, err := fiberApp.Test(req)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(t, map[int]int{fiber.StatusOK: 1, fiber.StatusPreconditionFailed: 1}, counts)
+}
+
+type memoryCacheStore struct {
+	values map[string][]byte
+	ttls   map[string]time.Duration
+}
+
+func newMemoryCacheStore() *memoryCacheStore {
+	return &memoryCacheStore{values: map[string][]byte{}, ttls: map[string]time.Duration{}}
+}
+
+func (s *memoryCacheStore) Get(ctx context.Context, key string, result any) error {
+	value, ok := s.values[key]
+	if !ok {
+		return database.ErrCacheMiss
+	}
+	return json.Unmarshal(value, result)
+}
+
+func (s *memoryCacheStore) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.values[key] = encoded
+	s.ttls[key] = ttl
+	return nil
+}
+
+func (s *memoryCacheStore) Take(ctx context.Context, key string, result any) error {
+	if err := s.Get(ctx, key, result); err != nil {
+		return err
+	}
+	delete(s.values, key)
+	delete(s.ttls, key)
+	return nil
+}
+
+func (s *memoryCacheStore) Delete(ctx context.Context, key string) error {
+	delete(s.values, key)
+	delete(s.ttls, key)
+	return nil
+}
+
+func (s *memoryCacheStore) DeleteMany(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		delete(s.values, key)
+		delete(s.ttls, key)
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) Scan(ctx context.Context, pattern string, cursor uint64) ([]string, uint64, error) {
+	return nil, 0, errors.New("not supported")
+}
+
+func (s *memoryCacheStore) Flush(ctx context.Context, namespace string) (int, error) {
+	return 0, errors.New("not supported")
+}
+
+func setupStatsTest(t *testing.T) (*AdminController, *gorm.DB, *MockSessionRepository, *memoryCacheStore) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}, &LoginEvent{}))
+
+	now := time.Date(2024, 6, 30, 15, 0, 0, 0, time.UTC)
+	for i, createdAt := range []time.Time{
+		now.AddDate(0, 0, -45),
+		now.AddDate(0, 0, -20),
+		now.AddDate(0, 0, -2),
+		now.Add(-time.Hour),
+	} {
+		require.NoError(t, db.Create(&User{
+			BaseModel: BaseModel{CreatedAt: createdAt},
+			Login:     fmt.Sprintf("user-%d", i),
+		}).Error)
+	}
+	for _, event := range []LoginEvent{
+		{UserID: "user-0", CreatedAt: now.AddDate(0, 0, -14), Success: true}, // a day before the window
+		{UserID: "user-0", CreatedAt: now.AddDate(0, 0, -13), Success: true},
+		{UserID: "user-1", CreatedAt: now.AddDate(0, 0, -1), Success: true},
+		{UserID: "user-2", CreatedAt: now.AddDate(0, 0, -1), Success: false},
+		{UserID: "user-2", CreatedAt: now.Add(-2 * time.Hour), Success: true},
+		{UserID: "user-3", CreatedAt: now.Add(-time.Hour), Success: true},
+	} {
+		require.NoError(t, db.Create(&event).Error)
+	}
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("CountActive", mock.Anything).Return(int64(5), nil)
+
+	cache := newMemoryCacheStore()
+	controller := New(
+		events.New(nil, config.Config{}, nil),
+		nil,
+		nil,
+		nil,
+		mockSessionRepo,
+		repositories.NewStatsRepository(database.DB{SQL: db}),
+		nil,
+		cache,
+		middleware.Middleware{},
+		config.Config{},
+		clock.NewFake(now),
+	)
+	controller.log = logger.New("test")
+	controller.SetWebSocketManager(fakeWebSocketManager{clients: 2, guests: 1})
+
+	return controller, db, mockSessionRepo, cache
+}
+
+func TestAdminController_Stats_Aggregates(t *testing.T) {
+	controller, _, _, _ := setupStatsTest(t)
+
+	stats, err := controller.Stats(context.Background(), AdminStatsRequest{
+		LoginDays:        ADMIN_STATS_LOGIN_DAYS_DEFAULT,
+		RegistrationDays: 3,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(4), stats.TotalUsers)
+	assert.Equal(t, int64(2), stats.NewUsersLast7Days)
+	assert.Equal(t, int64(3), stats.NewUsersLast30Days)
+	assert.Equal(t, int64(5), stats.ActiveSessions)
+	assert.Equal(t, 3, stats.WebsocketConnections)
+
+	require.Len(t, stats.LoginsPerDay, ADMIN_STATS_LOGIN_DAYS_DEFAULT)
+	assert.Equal(t, DailyCount{Day: "2024-06-17", Count: 1}, stats.LoginsPerDay[0])
+	assert.Equal(t, DailyCount{Day: "2024-06-29", Count: 1}, stats.LoginsPerDay[12])
+	assert.Equal(t, DailyCount{Day: "2024-06-30", Count: 2}, stats.LoginsPerDay[13])
+	total := int64(0)
+	for _, day := range stats.LoginsPerDay {
+		total += day.Count
+	}
+	assert.Equal(t, int64(4), total)
+
+	assert.Equal(t, []DailyCount{
+		{Day: "2024-06-28", Count: 1},
+		{Day: "2024-06-29", Count: 0},
+		{Day: "2024-06-30", Count: 1},
+	}, stats.RegistrationsPerDay)
+}
+
+func TestAdminController_Stats_CacheHit(t *testing.T) {
+	controller, db, mockSessionRepo, cache := setupStatsTest(t)
+	ctx := context.Background()
+	statsRequest := AdminStatsRequest{LoginDays: 7, RegistrationDays: 7}
+
+	first, err := controller.Stats(ctx, statsRequest)
+	require.NoError(t, err)
+	assert.Equal(t, ADMIN_STATS_CACHE_TTL, cache.ttls["admin_stats:7:7"])
+
+	require.NoError(t, db.Create(&User{Login: "late"}).Error)
+
+	second, err := controller.Stats(ctx, statsRequest)
+	require.NoError(t, err)
+	assert.Equal(t, first.TotalUsers, second.TotalUsers, "second call is served from the cache")
+	assert.True(t, first.GeneratedAt.Equal(second.GeneratedAt.Time))
+	mockSessionRepo.AssertNumberOfCalls(t, "CountActive", 1)
+
+	other, err := controller.Stats(ctx, AdminStatsRequest{LoginDays: 14, RegistrationDays: 7})
+	require.NoError(t, err)
+	assert.Equal(t, first.TotalUsers+1, other.TotalUsers, "other windows are cached separately")
+	mockSessionRepo.AssertNumberOfCalls(t, "CountActive", 2)
+}
+
+func TestAdminController_HandleStats_Bounds(t *testing.T) {
+	controller, _, _, _ := setupStatsTest(t)
+
+	fiberApp := fiber.New()
+	fiberApp.Get("/admin/stats", controller.handleStats)
+
+	testCases := []struct {
+		query  string
+		status int
+	}{
+		{"", fiber.StatusOK},
+		{"?loginDays=1&registrationDays=365", fiber.StatusOK},
+		{"?loginDays=0", fiber.StatusUnprocessableEntity},
+		{"?loginDays=91", fiber.StatusUnprocessableEntity},
+		{"?registrationDays=366", fiber.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		resp, err := fiberApp.Test(httptest.NewRequest("GET", "/admin/stats"+tc.query, nil))
+		require.NoError(t, err)
+		assert.Equal(t, tc.status, resp.StatusCode, tc.query)
+	}
+}
+
+func TestAdminController_HandleMetrics(t *testing.T) {
+	controller, _, _, _ := setupStatsTest(t)
+
+	fiberApp := fiber.New()
+	fiberApp.Get("/admin/metrics", controller.handleMetrics)
+
+	userController.LoginLatency.Observe("success", 30*time.Millisecond)
+
+	resp, err := fiberApp.Test(httptest.NewRequest("GET", "/admin/metrics", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, metrics.CONTENT_TYPE, resp.Header.Get(fiber.HeaderContentType))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "# TYPE login_duration_seconds histogram\n")
+	assert.Contains(t, string(body), `login_duration_seconds_bucket{outcome="success",le="0.05"}`)
+}
+
+func TestAdminController_HandleLatency(t *testing.T) {
+	controller, _, _, _ := setupStatsTest(t)
+	latency := metrics.NewLatencyTracker(0, nil)
+	controller.SetLatency(latency)
+
+	fiberApp := fiber.New()
+	fiberApp.Get("/admin/latency", controller.handleLatency)
+
+	latency.Observe("GET /api/v1/users/", 20*time.Millisecond)
+
+	resp, err := fiberApp.Test(httptest.NewRequest("GET", "/admin/latency", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Routes []metrics.LatencyRoute `json:"routes"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Len(t, body.Routes, 1)
+	assert.Equal(t, "GET /api/v1/users/", body.Routes[0].Route)
+	for _, window := range metrics.LatencyWindows {
+		assert.Equal(t, uint64(1), body.Routes[0].Windows[window.Name].Count, window.Name)
+	}
+}
+
+func TestAdminController_HandleImpersonate(t *testing.T) {
+	testConfig := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret"}}
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, "session-1").Return(&Session{
+		ID:        "session-1",
+		UserID:    "admin-1",
+		ExpiresAt: time.Now().Add(time.Hour),
+		RefreshAt: time.Now().Add(time.Hour),
+	}, nil)
+	mockSessionRepo.On("Create", mock.Anything, mock.MatchedBy(func(session *Session) bool {
+		return session.UserID == "user-2" &&
+			session.ImpersonatedBy == "admin-1" &&
+			session.ImpersonatorSessionID == "session-1"
+	}), mock.Anything).
+		Run(func(args mock.Arguments) {
+			session := args.Get(1).(*Session)
+			session.ID = "impersonation-1"
+			session.Token = "impersonation-jwt"
+			session.ExpiresAt = time.Now().Add(repositories.IMPERSONATION_EXPIRY)
+		}).
+		Return(nil)
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "admin-1").
+		Return(&User{BaseModel: BaseModel{ID: "admin-1"}, Login: "admin", IsAdmin: true}, nil)
+	mockUserRepo.On("GetByID", mock.Anything, "user-2").
+		Return(&User{BaseModel: BaseModel{ID: "user-2"}, Login: "jdoe"}, nil)
+	mockUserRepo.On("GetByID", mock.Anything, "missing").Return((*User)(nil), repositories.ErrNotFound)
+
+	eventBus := events.New(nil, testConfig, nil)
+	audit := make(chan events.ImpersonationEvent, 1)
+	require.NoError(t, eventBus.ImpersonationTopic().Subscribe(
+		func(ctx context.Context, event events.ImpersonationEvent) error {
+			audit <- event
+			return nil
+		},
+	))
+
+	auditRepo := setupAuditRepository(t)
+
+	mw := middleware.New(database.DB{}, eventBus, testConfig, mockUserRepo, mockSessionRepo, nil)
+	controller := New(eventBus, mockUserRepo, nil, nil, mockSessionRepo, nil, auditRepo, nil, mw, testConfig, nil)
+	fiberApp := fiber.New()
+	controller.RegisterRoutes(fiberApp)
+
+	impersonate := func(userID string) *http.Response {
+		req := httptest.NewRequest("POST", "/admin/users/"+userID+"/impersonate", nil)
+		req.Header.Set("X-Client-Type", middleware.WEB_CLIENT_TYPE)
+		req.Header.Set("Cookie", SESSION_COOKIE_KEY+"=session-1")
+		resp, err := fiberApp.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := impersonate("user-2")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get(middleware.IMPERSONATING_HEADER))
+	assert.Equal(t, "impersonation-jwt", resp.Header.Get("X-Auth-Token"))
+	require.Len(t, resp.Cookies(), 1)
+	assert.Equal(t, "impersonation-1", resp.Cookies()[0].Value)
+
+	select {
+	case event := <-audit:
+		assert.Equal(t, events.IMPERSONATION_STARTED, event.Action)
+		assert.Equal(t, "admin-1", event.AdminID)
+		assert.Equal(t, "user-2", event.UserID)
+		assert.Equal(t, "impersonation-1", event.SessionID)
+		require.NotNil(t, event.ExpiresAt)
+	case <-time.After(time.Second):
+		t.Fatal("impersonation was not audited")
+	}
+
+	assert.Equal(t, fiber.StatusUnprocessableEntity, impersonate("admin-1").StatusCode)
+	assert.Equal(t, fiber.StatusNotFound, impersonate("missing").StatusCode)
+	mockSessionRepo.AssertNumberOfCalls(t, "Create", 1)
+	assert.Empty(t, audit)
+
+	entries, _, err := auditRepo.List(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, AUDIT_ACTION_IMPERSONATION_STARTED, entries[0].Action)
+	assert.Equal(t, "admin-1", entries[0].ActorID)
+	assert.Equal(t, "user-2", entries[0].TargetID)
+	assert.Equal(t, "impersonation-1", entries[0].Details["sessionId"])
+}
+
+func TestAdminController_HandleFlushCache(t *testing.T) {
+	testConfig := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret"}}
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, "session-1").Return(&Session{
+		ID:        "session-1",
+		UserID:    "admin-1",
+		ExpiresAt: time.Now().Add(time.Hour),
+		RefreshAt: time.Now().Add(time.Hour),
+	}, nil)
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "admin-1").
+		Return(&User{BaseModel: BaseModel{ID: "admin-1"}, Login: "admin", IsAdmin: true}, nil)
+
+	general, sessions := database.NewMemoryCacheStore(), database.NewMemoryCacheStore()
+	ctx := context.Background()
+	require.NoError(t, general.Set(ctx, "admin_stats:14:30", "stats", 0))
+	require.NoError(t, sessions.Set(ctx, "session:one", "a", 0))
+	require.NoError(t, sessions.Set(ctx, "session:two", "b", 0))
+	require.NoError(t, sessions.Set(ctx, "resume:one", "c", 0))
+
+	auditRepo := setupAuditRepository(t)
+	eventBus := events.New(nil, testConfig, nil)
+	mw := middleware.New(database.DB{}, eventBus, testConfig, mockUserRepo, mockSessionRepo, nil)
+	controller := New(eventBus, mockUserRepo, nil, nil, mockSessionRepo, nil, auditRepo, nil, mw, testConfig, nil)
+	controller.SetCaches(general, sessions)
+	fiberApp := fiber.New()
+	controller.RegisterRoutes(fiberApp)
+
+	flush := func(body string) (int, map[string]any) {
+		req := httptest.NewRequest("POST", "/admin/cache/flush", strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("X-Client-Type", middleware.WEB_CLIENT_TYPE)
+		req.Header.Set("Cookie", SESSION_COOKIE_KEY+"=session-1")
+		resp, err := fiberApp.Test(req)
+		require.NoError(t, err)
+		var result map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return resp.StatusCode, result
+	}
+
+	status, result := flush(`{"namespace":"session:"}`)
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "session", result["namespace"])
+	assert.Equal(t, float64(2), result["deleted"])
+
+	// Other namespaces are left alone, in the same cache and in others
+	var value string
+	assert.ErrorIs(t, sessions.Get(ctx, "session:one", &value), database.ErrCacheMiss)
+	assert.NoError(t, sessions.Get(ctx, "resume:one", &value))
+	assert.NoError(t, general.Get(ctx, "admin_stats:14:30", &value))
+
+	status, _ = flush(`{"namespace":"session:*"}`)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+
+	status, result = flush("")
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "", result["namespace"])
+	assert.Equal(t, float64(2), result["deleted"])
+	assert.ErrorIs(t, general.Get(ctx, "admin_stats:14:30", &value), database.ErrCacheMiss)
+
+	entries, _, err := auditRepo.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, AUDIT_ACTION_CACHE_FLUSHED, entry.Action)
+		assert.Equal(t, "admin-1", entry.ActorID)
+	}
+	namespaces := []any{entries[0].Details["namespace"], entries[1].Details["namespace"]}
+	assert.ElementsMatch(t, []any{"session", ""}, namespaces)
+}
+
+func TestAdminController_ForceLogout(t *testing.T) {
+	ctx := context.Background()
+	admin := User{BaseModel: BaseModel{ID: "admin-1"}, IsAdmin: true}
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").Return(&User{BaseModel: BaseModel{ID: "user-1"}}, nil)
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("ListByUser", mock.Anything, "user-1").
+		Return([]*Session{{ID: "session-1"}, {ID: "session-2"}}, nil)
+	mockSessionRepo.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	eventBus := events.New(nil, config.Config{}, nil)
+	loggedOut := make(chan string, 2)
+	require.NoError(t, eventBus.UserLogoutTopic().Subscribe(func(ctx context.Context, event events.UserLogoutEvent) error {
+		loggedOut <- event.SessionID
+		return nil
+	}))
+
+	mockWS := &testsupport.MockWebsocketNotifier{}
+	mockWS.On("DisconnectUser", "user-1", FORCE_LOGOUT_REASON).Return(3)
+
+	controller := New(eventBus, mockUserRepo, nil, nil, mockSessionRepo, nil, nil, nil, middleware.Middleware{}, config.Config{}, nil)
+	controller.log = logger.New("test")
+	controller.SetWebSocketManager(fakeWebSocketManager{MockWebsocketNotifier: mockWS})
+
+	result, err := controller.ForceLogout(ctx, admin, "user-1", "203.0.113.7")
+	require.NoError(t, err)
+	assert.Equal(t, ForceLogoutResult{Sessions: 2, Connections: 3}, result)
+
+	mockSessionRepo.AssertCalled(t, "Delete", mock.Anything, "session-1")
+	mockSessionRepo.AssertCalled(t, "Delete", mock.Anything, "session-2")
+	mockWS.AssertExpectations(t)
+	for range 2 {
+		select {
+		case sessionID := <-loggedOut:
+			assert.Contains(t, []string{"session-1", "session-2"}, sessionID)
+		case <-time.After(time.Second):
+			t.Fatal("no user logout event")
+		}
+	}
+}
+
+func TestAdminController_ForceLogout_SessionFailure(t *testing.T) {
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").Return(&User{BaseModel: BaseModel{ID: "user-1"}}, nil)
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("ListByUser", mock.Anything, "user-1").Return([]*Session{{ID: "session-1"}}, nil)
+	mockSessionRepo.On("Delete", mock.Anything, "session-1").Return(errors.New("database is locked"))
+
+	mockWS := &testsupport.MockWebsocketNotifier{}
+
+	controller := New(events.New(nil, config.Config{}, nil), mockUserRepo, nil, nil, mockSessionRepo, nil, nil, nil, middleware.Middleware{}, config.Config{}, nil)
+	controller.log = logger.New("test")
+	controller.SetWebSocketManager(fakeWebSocketManager{MockWebsocketNotifier: mockWS})
+
+	_, err := controller.ForceLogout(context.Background(), User{BaseModel: BaseModel{ID: "admin-1"}}, "user-1", "")
+	require.Error(t, err)
+	// Connections stay up while the sessions they'd reconnect with remain
+	mockWS.AssertNotCalled(t, "DisconnectUser", mock.Anything, mock.Anything)
+}
+
+func TestAdminController_WebsocketDiagnostics(t *testing.T) {
+	ctx := context.Background()
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").Return(&User{BaseModel: BaseModel{ID: "user-1"}}, nil)
+	mockUserRepo.On("GetByID", mock.Anything, "missing").Return((*User)(nil), repositories.ErrNotFound)
+
+	rtt := 42.5
+	diagnostics := websockets.UserDiagnostics{
+		UserID: "user-1",
+		Reports: []websockets.DiagnosticsReport{{
+			Client: websockets.DiagnosticsData{LatencyMs: 180, ReconnectCount: 4, LastError: "timeout"},
+			Server: websockets.ConnectionStats{ClientID: "client-1", PingRTTMs: &rtt, DroppedMessages: 2},
+		}},
+		Connections: []websockets.ConnectionStats{{ClientID: "client-1", PingRTTMs: &rtt, DroppedMessages: 2}},
+	}
+
+	controller := New(events.New(nil, config.Config{}, nil), mockUserRepo, nil, nil, nil, nil, nil, nil, middleware.Middleware{}, config.Config{}, nil)
+	controller.log = logger.New("test")
+
+	// Without a manager there is nothing to report, which isn't an error
+	result, err := controller.WebsocketDiagnostics(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", result.UserID)
+	assert.Empty(t, result.Reports)
+	assert.NotNil(t, result.Connections)
+
+	controller.SetWebSocketManager(fakeWebSocketManager{
+		diagnostics: map[string]websockets.UserDiagnostics{"user-1": diagnostics},
+	})
+	result, err = controller.WebsocketDiagnostics(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, diagnostics, result)
+
+	_, err = controller.WebsocketDiagnostics(ctx, "missing")
+	assert.ErrorIs(t, err, repositories.ErrNotFound)
+}
+
+func TestAdminController_UpdateUser_PublishesChangedFields(t *testing.T) {
+	eventBus := events.New(nil, config.Config{}, nil)
+	received := make(chan events.UserUpdatedEvent, 1)
+	require.NoError(t, eventBus.UserUpdatedTopic().Subscribe(
+		func(ctx context.Context, event events.UserUpdatedEvent) error {
+			received <- event
+			return nil
+		},
+	))
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").
+		Return(&User{BaseModel: BaseModel{ID: "user-1"}, FirstName: "Jane", LastName: "Doe", Version: 2}, nil)
+	mockUserRepo.On("UpdateProfile", mock.Anything, mock.Anything, 0).Return(nil)
+	controller := New(eventBus, mockUserRepo, nil, nil, nil, nil, nil, nil, middleware.Middleware{}, config.Config{}, nil)
+
+	isAdmin := true
+	lastName := "Doe"
+	firstName := "Janet"
+	_, err := controller.UpdateUser(context.Background(), "user-1", AdminUpdateUserRequest{
+		UpdateProfileRequest: UpdateProfileRequest{FirstName: &firstName, LastName: &lastName},
+		IsAdmin:              &isAdmin,
+	}, utils.Precondition{})
+	require.NoError(t, err)
+
+	select {
+	case event := <-received:
+		assert.Equal(t, "user-1", event.UserID)
+		// The last name was sent unchanged
+		assert.Equal(t, []string{"firstName", "isAdmin"}, event.Fields)
+	case <-time.After(time.Second):
+		t.Fatal("no user updated event")
+	}
+}
@@ -0,0 +1,219 @@
+package adminController
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"server/internal/repositories"
+	"time"
+
+	. "server/internal/models"
+)
+
+const (
+	AUDIT_ARCHIVE_PREFIX      = "audit-"
+	AUDIT_ARCHIVE_EXTENSION   = ".jsonl.gz"
+	AUDIT_ARCHIVE_TIME_FORMAT = "20060102T150405Z"
+
+	// Set on downloads so the file can be checked against the listing
+	AUDIT_ARCHIVE_CHECKSUM_HEADER = "X-Checksum-SHA256"
+)
+
+var (
+	ErrAuditArchiveMissing = errors.New("audit archive file is missing")
+
+	// A streamed response could no longer be written, usually because the
+	// client went away
+	ErrStreamClosed = errors.New("stream closed")
+)
+
+// AuditLog returns a page of audit entries, newest first, after cursor when
+// it isn't empty.
+func (c *AdminController) AuditLog(ctx context.Context, cursor string) (AuditLogPage, error) {
+	entries, next, err := c.auditRepo.List(ctx, cursor)
+	if err != nil {
+		return AuditLogPage{}, err
+	}
+
+	return NewAuditLogPage(entries, next), nil
+}
+
+// StreamAuditLog writes every audit entry to w as JSON lines, newest first,
+// flushing after each batch the repository reads. It stops as soon as a write
+// fails, with ErrStreamClosed, and returns how many entries were written.
+func (c *AdminController) StreamAuditLog(ctx context.Context, w *bufio.Writer) (int64, error) {
+	encoder := json.NewEncoder(w)
+	return c.auditRepo.Stream(ctx, func(entries []AuditLog) error {
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return fmt.Errorf("%w: %w", ErrStreamClosed, err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("%w: %w", ErrStreamClosed, err)
+		}
+		return nil
+	})
+}
+
+func (c *AdminController) AuditArchives(ctx context.Context) ([]AuditArchive, error) {
+	return c.auditRepo.ListArchives(ctx)
+}
+
+// AuditArchiveFile returns an archive and the path of its file, or
+// ErrAuditArchiveMissing when the file has been moved off this instance.
+func (c *AdminController) AuditArchiveFile(ctx context.Context, id string) (AuditArchive, string, error) {
+	archive, err := c.auditRepo.GetArchive(ctx, id)
+	if err != nil {
+		return AuditArchive{}, "", err
+	}
+
+	path := filepath.Join(c.Config.Audit.ArchiveDir, archive.File)
+	if _, err := os.Stat(path); err != nil {
+		return *archive, "", fmt.Errorf("%w: %s", ErrAuditArchiveMissing, archive.File)
+	}
+	return *archive, path, nil
+}
+
+// ArchiveAuditLog moves audit entries older than the retention window into a
+// new archive file, then deletes them a batch at a time. The archive is on
+// disk and recorded before anything is deleted, so a failure part way leaves
+// entries in both places rather than in neither. It matches scheduler.Job.
+func (c *AdminController) ArchiveAuditLog(ctx context.Context) error {
+	log := c.log.Function("ArchiveAuditLog")
+
+	retention := c.Config.AuditRetention()
+	if retention <= 0 {
+		return nil
+	}
+	cutoff := c.clock.Now().Add(-retention)
+
+	archive, err := c.writeAuditArchive(ctx, cutoff)
+	if err != nil {
+		return log.Err("failed to write audit archive", err, "cutoff", cutoff)
+	}
+	if archive == nil {
+		log.Debug("No audit entries to archive", "cutoff", cutoff)
+		return nil
+	}
+
+	if err := c.auditRepo.CreateArchive(ctx, archive); err != nil {
+		// Nothing was purged, the next run archives the same entries again
+		if removeErr := os.Remove(filepath.Join(c.Config.Audit.ArchiveDir, archive.File)); removeErr != nil {
+			log.Er("failed to remove unrecorded audit archive", removeErr, "file", archive.File)
+		}
+		return err
+	}
+
+	var deleted int64
+	for {
+		batch, err := c.auditRepo.DeleteBefore(ctx, cutoff, repositories.AUDIT_ARCHIVE_BATCH_SIZE)
+		if err != nil {
+			return log.Err("failed to purge archived audit entries", err, "file", archive.File, "deleted", deleted)
+		}
+		deleted += batch
+		if batch < repositories.AUDIT_ARCHIVE_BATCH_SIZE {
+			break
+		}
+	}
+
+	if deleted != archive.Count {
+		log.Warn("Purged a different number of audit entries than were archived",
+			"file", archive.File, "archived", archive.Count, "deleted", deleted)
+	}
+	log.Info("Audit log archived", "file", archive.File, "archived", archive.Count, "deleted", deleted)
+	return nil
+}
+
+// writeAuditArchive writes every entry older than cutoff to a gzip JSON lines
+// file named after the oldest and newest entry in it. It returns nil when
+// there is nothing to archive.
+func (c *AdminController) writeAuditArchive(ctx context.Context, cutoff time.Time) (*AuditArchive, error) {
+	dir := c.Config.Audit.ArchiveDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit archive directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, ".audit-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit archive: %w", err)
+	}
+	written := false
+	defer func() {
+		_ = file.Close()
+		if !written {
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	checksum := sha256.New()
+	size := &countingWriter{}
+	compressed := gzip.NewWriter(io.MultiWriter(file, checksum, size))
+	encoder := json.NewEncoder(compressed)
+
+	var oldest, newest time.Time
+	count, err := c.auditRepo.ExportBefore(ctx, cutoff, func(entries []AuditLog) error {
+		for _, entry := range entries {
+			if oldest.IsZero() || entry.CreatedAt.Before(oldest) {
+				oldest = entry.CreatedAt
+			}
+			if entry.CreatedAt.After(newest) {
+				newest = entry.CreatedAt
+			}
+			if err := encoder.Encode(entry); err != nil {
+				return fmt.Errorf("failed to encode audit entry %s: %w", entry.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	if err := compressed.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish audit archive: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync audit archive: %w", err)
+	}
+
+	name := AUDIT_ARCHIVE_PREFIX + oldest.UTC().Format(AUDIT_ARCHIVE_TIME_FORMAT) +
+		"-" + newest.UTC().Format(AUDIT_ARCHIVE_TIME_FORMAT) + AUDIT_ARCHIVE_EXTENSION
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("audit archive %s already exists", name)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return nil, fmt.Errorf("failed to move audit archive into place: %w", err)
+	}
+	written = true
+
+	return &AuditArchive{
+		File:     name,
+		OldestAt: oldest,
+		NewestAt: newest,
+		Count:    count,
+		Size:     size.n,
+		Checksum: hex.EncodeToString(checksum.Sum(nil)),
+	}, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
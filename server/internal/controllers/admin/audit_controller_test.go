@@ -0,0 +1,425 @@
+package adminController
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"server/config"
+	"server/internal/apierror"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var auditTestNow = time.Date(2025, 6, 1, 3, 0, 0, 0, time.UTC)
+
+func openAuditTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "audit.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&AuditLog{}, &AuditArchive{}))
+	return db
+}
+
+func setupAuditRepository(t *testing.T) repositories.AuditRepository {
+	t.Helper()
+	return repositories.NewAuditRepository(database.DB{SQL: openAuditTestDB(t)})
+}
+
+// setupArchiveTest returns a controller keeping audit entries for 30 days and
+// a counter of the DELETE statements run against the table.
+func setupArchiveTest(t *testing.T) (*AdminController, *gorm.DB, *atomic.Int32) {
+	t.Helper()
+
+	db := openAuditTestDB(t)
+	deletes := &atomic.Int32{}
+	require.NoError(t, db.Callback().Delete().After("gorm:delete").Register("count_deletes", func(tx *gorm.DB) {
+		if tx.Statement.Table == "audit_logs" {
+			deletes.Add(1)
+		}
+	}))
+
+	cfg := config.Config{Audit: config.AuditConfig{RetentionDays: 30, ArchiveDir: filepath.Join(t.TempDir(), "audit")}}
+	controller := New(nil, nil, nil, nil, nil, nil, repositories.NewAuditRepository(database.DB{SQL: db}), nil, middleware.Middleware{}, cfg, clock.NewFake(auditTestNow))
+	controller.log = logger.New("test")
+	return controller, db, deletes
+}
+
+func seedAuditEntries(t *testing.T, db *gorm.DB, count int, oldest time.Time, actorID string) {
+	t.Helper()
+
+	entries := make([]AuditLog, count)
+	for i := range entries {
+		entries[i] = AuditLog{
+			CreatedAt: oldest.Add(time.Duration(i) * time.Minute),
+			Action:    AUDIT_ACTION_IMPERSONATION_STARTED,
+			Source:    AUDIT_SOURCE_API,
+			ActorID:   actorID,
+			TargetID:  fmt.Sprintf("user-%d", i),
+			Details:   map[string]any{"index": i},
+		}
+	}
+	require.NoError(t, db.CreateInBatches(entries, 500).Error)
+}
+
+func readAuditArchive(t *testing.T, path string) []AuditLog {
+	t.Helper()
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	require.NoError(t, err)
+
+	var entries []AuditLog
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var entry AuditLog
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestAdminController_ArchiveAuditLog(t *testing.T) {
+	controller, db, deletes := setupArchiveTest(t)
+	ctx := context.Background()
+
+	cutoff := auditTestNow.Add(-30 * 24 * time.Hour)
+	oldest := cutoff.Add(-48 * time.Hour)
+	seedAuditEntries(t, db, 2500, oldest, "old")
+	seedAuditEntries(t, db, 10, cutoff.Add(time.Minute), "recent")
+
+	require.NoError(t, controller.ArchiveAuditLog(ctx))
+
+	// 1000 + 1000 + 500, each its own statement
+	assert.Equal(t, int32(3), deletes.Load())
+
+	var remaining []AuditLog
+	require.NoError(t, db.Find(&remaining).Error)
+	assert.Len(t, remaining, 10)
+	for _, entry := range remaining {
+		assert.Equal(t, "recent", entry.ActorID)
+	}
+
+	archives, err := controller.AuditArchives(ctx)
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+	archive := archives[0]
+	assert.Equal(t, int64(2500), archive.Count)
+	assert.Equal(t, oldest, archive.OldestAt)
+	assert.Equal(t, oldest.Add(2499*time.Minute), archive.NewestAt)
+	assert.Equal(t, "audit-20250430T030000Z-20250501T203900Z.jsonl.gz", archive.File)
+
+	path := filepath.Join(controller.Config.Audit.ArchiveDir, archive.File)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(sum[:]), archive.Checksum)
+	assert.Equal(t, int64(len(content)), archive.Size)
+
+	entries := readAuditArchive(t, path)
+	require.Len(t, entries, 2500)
+	targets := map[string]bool{}
+	for _, entry := range entries {
+		assert.Equal(t, "old", entry.ActorID)
+		assert.True(t, entry.CreatedAt.Before(cutoff))
+		targets[entry.TargetID] = true
+	}
+	assert.Len(t, targets, 2500)
+
+	// Nothing left to archive, so no new file or archive
+	require.NoError(t, controller.ArchiveAuditLog(ctx))
+	archives, err = controller.AuditArchives(ctx)
+	require.NoError(t, err)
+	assert.Len(t, archives, 1)
+	files, err := os.ReadDir(controller.Config.Audit.ArchiveDir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestAdminController_ArchiveAuditLog_Disabled(t *testing.T) {
+	controller, db, deletes := setupArchiveTest(t)
+	controller.Config.Audit.RetentionDays = 0
+	seedAuditEntries(t, db, 5, auditTestNow.AddDate(-5, 0, 0), "old")
+
+	require.NoError(t, controller.ArchiveAuditLog(context.Background()))
+
+	var count int64
+	require.NoError(t, db.Model(&AuditLog{}).Count(&count).Error)
+	assert.Equal(t, int64(5), count)
+	assert.Zero(t, deletes.Load())
+	assert.NoDirExists(t, controller.Config.Audit.ArchiveDir)
+}
+
+func TestAdminController_ArchiveAuditLog_KeepsEntriesWhenArchiveFails(t *testing.T) {
+	controller, db, deletes := setupArchiveTest(t)
+	seedAuditEntries(t, db, 5, auditTestNow.AddDate(-1, 0, 0), "old")
+
+	// A file where the directory should be
+	require.NoError(t, os.WriteFile(controller.Config.Audit.ArchiveDir, nil, 0644))
+
+	assert.Error(t, controller.ArchiveAuditLog(context.Background()))
+
+	var count int64
+	require.NoError(t, db.Model(&AuditLog{}).Count(&count).Error)
+	assert.Equal(t, int64(5), count)
+	assert.Zero(t, deletes.Load())
+}
+
+// adminGet mounts controller's routes behind a signed in admin and returns a
+// function making GET requests with the given Accept header.
+func adminGet(t *testing.T, controller *AdminController) func(path string, accept string) (*http.Response, []byte) {
+	t.Helper()
+
+	testConfig := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret"}}
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, "session-1").Return(&Session{
+		ID:        "session-1",
+		UserID:    "admin-1",
+		ExpiresAt: time.Now().Add(time.Hour),
+		RefreshAt: time.Now().Add(time.Hour),
+	}, nil)
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "admin-1").
+		Return(&User{BaseModel: BaseModel{ID: "admin-1"}, IsAdmin: true}, nil)
+	controller.middleware = middleware.New(database.DB{}, events.New(nil, testConfig, nil), testConfig, mockUserRepo, mockSessionRepo, nil)
+
+	fiberApp := fiber.New()
+	controller.RegisterRoutes(fiberApp)
+	return func(path string, accept string) (*http.Response, []byte) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Client-Type", middleware.WEB_CLIENT_TYPE)
+		req.Header.Set("Cookie", SESSION_COOKIE_KEY+"=session-1")
+		if accept != "" {
+			req.Header.Set(fiber.HeaderAccept, accept)
+		}
+		resp, err := fiberApp.Test(req, -1)
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, content
+	}
+}
+
+func TestAdminController_AuditArchiveRoutes(t *testing.T) {
+	controller, db, _ := setupArchiveTest(t)
+	seedAuditEntries(t, db, 3, auditTestNow.AddDate(-1, 0, 0), "old")
+	require.NoError(t, controller.ArchiveAuditLog(context.Background()))
+
+	get := adminGet(t, controller)
+
+	listing, content := get("/admin/audit/archives", "")
+	require.Equal(t, fiber.StatusOK, listing.StatusCode)
+	var body struct {
+		Archives []AuditArchive `json:"archives"`
+	}
+	require.NoError(t, json.Unmarshal(content, &body))
+	require.Len(t, body.Archives, 1)
+	archive := body.Archives[0]
+	assert.Equal(t, int64(3), archive.Count)
+
+	download, content := get("/admin/audit/archives/"+archive.ID, "")
+	require.Equal(t, fiber.StatusOK, download.StatusCode)
+	assert.Equal(t, archive.Checksum, download.Header.Get(AUDIT_ARCHIVE_CHECKSUM_HEADER))
+	assert.Contains(t, download.Header.Get(fiber.HeaderContentDisposition), archive.File)
+	sum := sha256.Sum256(content)
+	assert.Equal(t, archive.Checksum, hex.EncodeToString(sum[:]))
+
+	missing, _ := get("/admin/audit/archives/unknown", "")
+	assert.Equal(t, fiber.StatusNotFound, missing.StatusCode)
+
+	require.NoError(t, os.Remove(filepath.Join(controller.Config.Audit.ArchiveDir, archive.File)))
+	removed, _ := get("/admin/audit/archives/"+archive.ID, "")
+	assert.Equal(t, fiber.StatusNotFound, removed.StatusCode)
+}
+
+// failingWriter accepts limit bytes and then fails, as a connection does once
+// the client has gone away.
+type failingWriter struct {
+	limit   int
+	written int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > w.limit {
+		return 0, errors.New("broken pipe")
+	}
+	w.written += len(p)
+	return len(p), nil
+}
+
+// heapWriter discards what is written, and after each write, which is a
+// flush or a full buffer, records the live heap once garbage is collected.
+type heapWriter struct {
+	lines   int
+	maxHeap uint64
+}
+
+func (w *heapWriter) Write(p []byte) (int, error) {
+	w.lines += bytes.Count(p, []byte("\n"))
+	w.sample()
+	return len(p), nil
+}
+
+func (w *heapWriter) sample() {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	w.maxHeap = max(w.maxHeap, stats.HeapAlloc)
+}
+
+func TestAdminController_StreamAuditLog_BoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("seeds a large audit table")
+	}
+	controller, db, _ := setupArchiveTest(t)
+
+	// About 20MB once encoded, more than twice the allowed growth
+	const total = 10000
+	padding := strings.Repeat("x", 2048)
+	entries := make([]AuditLog, 0, 1000)
+	for i := range total {
+		entries = append(entries, AuditLog{
+			CreatedAt: auditTestNow.Add(-time.Duration(i) * time.Second),
+			Action:    AUDIT_ACTION_IMPERSONATION_STARTED,
+			Source:    AUDIT_SOURCE_API,
+			Details:   map[string]any{"index": i, "padding": padding},
+		})
+		if len(entries) == cap(entries) {
+			require.NoError(t, db.CreateInBatches(entries, 500).Error)
+			entries = entries[:0]
+		}
+	}
+	entries = nil // not counted as live while streaming
+
+	out := &heapWriter{}
+	out.sample()
+	baseline := out.maxHeap
+	out.maxHeap = 0
+
+	streamed, err := controller.StreamAuditLog(context.Background(), bufio.NewWriterSize(out, 64*1024))
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(total), streamed)
+	assert.Equal(t, total, out.lines)
+	growth := int64(out.maxHeap) - int64(baseline)
+	assert.Less(t, growth, int64(8<<20), "the heap grew by %d bytes while streaming", growth)
+}
+
+func TestAdminController_StreamAuditLog_ClientGoesAway(t *testing.T) {
+	controller, db, _ := setupArchiveTest(t)
+	seedAuditEntries(t, db, 3*repositories.AUDIT_STREAM_BATCH_SIZE, auditTestNow.AddDate(0, 0, -1), "admin-1")
+	queries := &atomic.Int32{}
+	require.NoError(t, db.Callback().Query().After("gorm:query").Register("count_queries", func(tx *gorm.DB) {
+		if tx.Statement.Table == "audit_logs" {
+			queries.Add(1)
+		}
+	}))
+
+	// Room for part of the first batch only
+	out := &failingWriter{limit: 16 * 1024}
+	streamed, err := controller.StreamAuditLog(context.Background(), bufio.NewWriter(out))
+
+	assert.ErrorIs(t, err, ErrStreamClosed)
+	assert.Zero(t, streamed)
+	assert.Equal(t, int32(1), queries.Load(), "nothing more is read once the client is gone")
+}
+
+func TestAdminController_AuditLogRoute_Streams(t *testing.T) {
+	controller, db, _ := setupArchiveTest(t)
+	total := repositories.AUDIT_STREAM_BATCH_SIZE + 3
+	seedAuditEntries(t, db, total, auditTestNow.AddDate(0, 0, -1), "admin-1")
+	get := adminGet(t, controller)
+
+	readLines := func(content []byte) []AuditLog {
+		var entries []AuditLog
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			var entry AuditLog
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+			entries = append(entries, entry)
+		}
+		require.NoError(t, scanner.Err())
+		return entries
+	}
+
+	for _, request := range []struct{ path, accept string }{
+		{"/admin/audit", NDJSON_CONTENT_TYPE},
+		{"/admin/audit?stream=true", ""},
+	} {
+		resp, content := get(request.path, request.accept)
+		require.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Equal(t, NDJSON_CONTENT_TYPE, resp.Header.Get(fiber.HeaderContentType))
+
+		entries := readLines(content)
+		require.Len(t, entries, total)
+		assert.Equal(t, "user-"+strconv.Itoa(total-1), entries[0].TargetID, "newest first")
+		assert.Equal(t, "user-0", entries[total-1].TargetID)
+	}
+
+	// Without either, the paginated JSON is unchanged
+	resp, content := get("/admin/audit", "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var page AuditLogPage
+	require.NoError(t, json.Unmarshal(content, &page))
+	assert.Len(t, page.Entries, AUDIT_LOG_PAGE_SIZE)
+	assert.True(t, page.HasMore)
+}
+
+func TestAdminController_AuditLogRoute_Cursor(t *testing.T) {
+	controller, db, _ := setupArchiveTest(t)
+	seedAuditEntries(t, db, AUDIT_LOG_PAGE_SIZE+2, auditTestNow.AddDate(0, 0, -1), "admin-1")
+	get := adminGet(t, controller)
+
+	resp, content := get("/admin/audit", "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var first AuditLogPage
+	require.NoError(t, json.Unmarshal(content, &first))
+	require.NotEmpty(t, first.NextCursor)
+
+	resp, content = get("/admin/audit?cursor="+first.NextCursor, "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var second AuditLogPage
+	require.NoError(t, json.Unmarshal(content, &second))
+	assert.Len(t, second.Entries, 2)
+	assert.Empty(t, second.NextCursor)
+	assert.False(t, second.HasMore)
+
+	resp, content = get("/admin/audit?cursor="+first.NextCursor[:len(first.NextCursor)-4]+"AAAA", "")
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(content, &body))
+	assert.Equal(t, apierror.CODE_INVALID_CURSOR, body["code"])
+}
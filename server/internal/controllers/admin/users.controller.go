@@ -0,0 +1,61 @@
+package adminController
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	. "server/internal/models"
+)
+
+// ListUsers returns a page of users in sort order, narrowed to fields. Only
+// the columns behind fields are read.
+func (c *AdminController) ListUsers(ctx context.Context, page int, sort UserSort, fields FieldSelection) (UserListPage, error) {
+	users, hasMore, err := c.userRepo.List(ctx, page, sort, fields.Columns())
+	if err != nil {
+		return UserListPage{}, err
+	}
+
+	listed := make([]any, 0, len(users))
+	for _, user := range users {
+		rendered, err := fields.Apply(user)
+		if err != nil {
+			return UserListPage{}, err
+		}
+		listed = append(listed, rendered)
+	}
+	return UserListPage{Users: listed, Page: page, HasMore: hasMore}, nil
+}
+
+// StreamUsers writes every user to w as JSON lines, newest first and narrowed
+// to fields, the way StreamAuditLog writes the audit log.
+func (c *AdminController) StreamUsers(ctx context.Context, w *bufio.Writer, fields FieldSelection) (int64, error) {
+	encoder := json.NewEncoder(w)
+	return c.userRepo.Stream(ctx, fields.Columns(), func(users []User) error {
+		for _, user := range users {
+			rendered, err := fields.Apply(user)
+			if err != nil {
+				return err
+			}
+			if err := encoder.Encode(rendered); err != nil {
+				return fmt.Errorf("%w: %w", ErrStreamClosed, err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("%w: %w", ErrStreamClosed, err)
+		}
+		return nil
+	})
+}
+
+// User returns one user narrowed to fields. It is read through the user
+// cache, which is cheaper than a narrowed query and never holds the password
+// hash.
+func (c *AdminController) User(ctx context.Context, userID string, fields FieldSelection) (any, error) {
+	user, err := c.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return fields.Apply(*user)
+}
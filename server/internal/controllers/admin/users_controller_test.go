@@ -0,0 +1,219 @@
+package adminController
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"server/config"
+	"server/internal/database"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// userQueries records the SQL of every query against the users table.
+type userQueries struct {
+	mutex sync.Mutex
+	sql   []string
+}
+
+func (q *userQueries) last() string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.sql) == 0 {
+		return ""
+	}
+	return q.sql[len(q.sql)-1]
+}
+
+// setupUserListTest seeds count users, a minute apart, the newest last.
+func setupUserListTest(t *testing.T, count int) (*AdminController, []User, *userQueries) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	users := make([]User, count)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range users {
+		users[i] = User{
+			BaseModel: BaseModel{CreatedAt: start.Add(time.Duration(i) * time.Minute)},
+			Login:     fmt.Sprintf("user%03d", i),
+			FirstName: "First",
+			Password:  string(hash),
+		}
+	}
+	require.NoError(t, db.CreateInBatches(users, 100).Error)
+
+	queries := &userQueries{}
+	require.NoError(t, db.Callback().Query().After("gorm:query").Register("capture_user_queries", func(tx *gorm.DB) {
+		if tx.Statement.Table == "users" {
+			queries.mutex.Lock()
+			queries.sql = append(queries.sql, tx.Statement.SQL.String())
+			queries.mutex.Unlock()
+		}
+	}))
+
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+	userRepo := repositories.New(database.DB{SQL: db}, invalidator)
+	controller := New(nil, userRepo, nil, nil, nil, nil, nil, nil, middleware.Middleware{}, config.Config{}, nil)
+	return controller, users, queries
+}
+
+func decodeUserList(t *testing.T, content []byte) (users []map[string]any, page float64, hasMore bool) {
+	t.Helper()
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(content, &body))
+	for _, user := range body["users"].([]any) {
+		users = append(users, user.(map[string]any))
+	}
+	return users, body["page"].(float64), body["hasMore"].(bool)
+}
+
+func TestAdminController_HandleListUsers_Fields(t *testing.T) {
+	controller, seeded, queries := setupUserListTest(t, USER_LIST_PAGE_SIZE+5)
+	get := adminGet(t, controller)
+
+	resp, content := get("/admin/users?fields=login,firstName", "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	users, page, hasMore := decodeUserList(t, content)
+	require.Len(t, users, USER_LIST_PAGE_SIZE)
+	assert.Equal(t, float64(1), page)
+	assert.True(t, hasMore)
+	assert.Equal(t, map[string]any{"login": seeded[len(seeded)-1].Login, "firstName": "First"}, users[0],
+		"newest first, with only the selected keys")
+
+	sql := queries.last()
+	assert.Contains(t, sql, "SELECT `id`,`created_at`,`login`,`first_name` FROM `users`")
+	assert.NotContains(t, sql, "password")
+
+	// The narrowing carries on to later pages
+	resp, content = get("/admin/users?fields=id&page=2", "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	users, page, hasMore = decodeUserList(t, content)
+	require.Len(t, users, 5)
+	assert.Equal(t, float64(2), page)
+	assert.False(t, hasMore)
+	assert.Equal(t, map[string]any{"id": seeded[0].ID}, users[4])
+	assert.Contains(t, queries.last(), "SELECT `id`,`created_at` FROM `users`")
+}
+
+func TestAdminController_HandleListUsers_AllFields(t *testing.T) {
+	controller, _, queries := setupUserListTest(t, 2)
+	get := adminGet(t, controller)
+
+	resp, content := get("/admin/users", "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	users, _, _ := decodeUserList(t, content)
+	require.Len(t, users, 2)
+	assert.Contains(t, users[0], "updatedAt")
+	assert.NotContains(t, users[0], "password")
+	assert.Contains(t, queries.last(), "SELECT * FROM `users`")
+}
+
+func TestAdminController_HandleListUsers_UnknownField(t *testing.T) {
+	controller, _, _ := setupUserListTest(t, 1)
+	get := adminGet(t, controller)
+
+	for _, path := range []string{
+		"/admin/users?fields=login,password",
+		"/admin/users?fields=first_name&stream=true",
+		"/admin/users/01900000-0000-7000-8000-000000000000?fields=token",
+	} {
+		resp, content := get(path, "")
+		require.Equal(t, fiber.StatusBadRequest, resp.StatusCode, path)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(content, &body))
+		assert.Contains(t, body["message"], "unknown field", path)
+		assert.Contains(t, body["validFields"], "login", path)
+		assert.Len(t, body["validFields"], len(UserFields), path)
+	}
+}
+
+func TestAdminController_HandleListUsers_Sort(t *testing.T) {
+	controller, seeded, queries := setupUserListTest(t, 3)
+	get := adminGet(t, controller)
+
+	resp, content := get("/admin/users?sort=login&fields=login", "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	users, _, _ := decodeUserList(t, content)
+	assert.Equal(t, []map[string]any{{"login": "user000"}, {"login": "user001"}, {"login": "user002"}}, users)
+	assert.Contains(t, queries.last(), "ORDER BY `login`,`id`")
+
+	resp, content = get("/admin/users?sort=firstName&order=desc&fields=id", "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	users, _, _ = decodeUserList(t, content)
+	require.Len(t, users, 3)
+	assert.Equal(t, seeded[2].ID, users[0]["id"], "every first name ties, so the newest id leads")
+	assert.Contains(t, queries.last(), "ORDER BY `first_name` DESC,`id` DESC")
+
+	for _, path := range []string{
+		"/admin/users?sort=password",
+		"/admin/users?sort=login%3B%20DROP%20TABLE%20users",
+		"/admin/users?sort=login&order=random()",
+		"/admin/users?sort=login&stream=true",
+	} {
+		resp, content := get(path, "")
+		require.Equal(t, fiber.StatusBadRequest, resp.StatusCode, path)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(content, &body))
+		assert.Contains(t, body["message"], "unknown sort", path)
+		assert.Len(t, body["validSorts"], len(UserSortFields), path)
+	}
+}
+
+func TestAdminController_HandleListUsers_StreamFields(t *testing.T) {
+	controller, seeded, queries := setupUserListTest(t, repositories.USER_STREAM_BATCH_SIZE+3)
+	get := adminGet(t, controller)
+
+	resp, content := get("/admin/users?stream=true&fields=login", "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, NDJSON_CONTENT_TYPE, resp.Header.Get(fiber.HeaderContentType))
+
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	require.Len(t, lines, len(seeded))
+	seen := map[string]bool{}
+	for _, line := range lines {
+		var user map[string]any
+		require.NoError(t, json.Unmarshal(line, &user))
+		require.Len(t, user, 1)
+		seen[user["login"].(string)] = true
+	}
+	assert.Len(t, seen, len(seeded))
+	assert.NotContains(t, queries.last(), "password")
+}
+
+func TestAdminController_HandleGetUser_Fields(t *testing.T) {
+	controller, seeded, _ := setupUserListTest(t, 1)
+	get := adminGet(t, controller)
+
+	resp, content := get("/admin/users/"+seeded[0].ID+"?fields=login,isAdmin", "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(content, &body))
+	assert.Equal(t, map[string]any{"login": "user000", "isAdmin": false}, body["user"])
+
+	resp, content = get("/admin/users/"+seeded[0].ID, "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal(content, &body))
+	assert.Equal(t, seeded[0].ID, body["user"].(map[string]any)["id"])
+	assert.NotContains(t, body["user"], "password")
+
+	missing, _ := get("/admin/users/01900000-0000-7000-8000-000000000000", "")
+	assert.Equal(t, fiber.StatusNotFound, missing.StatusCode)
+}
@@ -0,0 +1,114 @@
+package adminController
+
+import (
+	"context"
+	"errors"
+	"server/internal/repositories"
+	"time"
+)
+
+const (
+	// The most sessions warmed in one run, taken from the users who logged
+	// in most recently
+	CACHE_WARM_SESSIONS = 1000
+
+	// How long one run may take. Warming stops where it got to once the
+	// budget is spent, as later requests fill the caches anyway.
+	CACHE_WARM_BUDGET = 10 * time.Second
+)
+
+// CacheWarmReport is what a run of WarmCaches loaded. Complete is false when
+// the budget ran out before everything selected was warmed.
+type CacheWarmReport struct {
+	Users         int   `json:"users"`
+	Sessions      int   `json:"sessions"`
+	Announcements int   `json:"announcements"`
+	DurationMs    int64 `json:"durationMs"`
+	Complete      bool  `json:"complete"`
+}
+
+// WarmCaches loads what the first requests after a restart would otherwise
+// all miss at once, within CACHE_WARM_BUDGET. It runs the active
+// announcements query, then for the users who logged in most recently loads
+// each user into the user caches and remembers their live sessions for auth.
+// Users without a live session are skipped.
+func (c *AdminController) WarmCaches(ctx context.Context) (CacheWarmReport, error) {
+	return c.warmCaches(ctx, CACHE_WARM_BUDGET)
+}
+
+func (c *AdminController) warmCaches(ctx context.Context, budget time.Duration) (report CacheWarmReport, err error) {
+	log := c.log.Function("WarmCaches")
+
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	started := c.clock.Now()
+	deadline := started.Add(budget)
+	defer func() {
+		report.DurationMs = c.clock.Now().Sub(started).Milliseconds()
+		switch {
+		case err != nil:
+			log.Er("failed to warm caches", err, "users", report.Users, "sessions", report.Sessions)
+		case !report.Complete:
+			log.Warn("Cache warming ran out of time, caches partly warmed",
+				"users", report.Users, "sessions", report.Sessions,
+				"announcements", report.Announcements, "durationMs", report.DurationMs)
+		default:
+			log.Info("Caches warmed",
+				"users", report.Users, "sessions", report.Sessions,
+				"announcements", report.Announcements, "durationMs", report.DurationMs)
+		}
+	}()
+
+	announcements, err := c.announcementRepo.ListActive(ctx, started)
+	if err != nil {
+		return report, err
+	}
+	report.Announcements = len(announcements)
+
+	userIDs, err := c.loginEventRepo.RecentlyActiveUsers(ctx, started.Add(-c.Config.SessionMaxLifetime()), CACHE_WARM_SESSIONS)
+	if err != nil {
+		return report, err
+	}
+
+	for _, userID := range userIDs {
+		if report.Sessions >= CACHE_WARM_SESSIONS {
+			break
+		}
+		if !c.clock.Now().Before(deadline) || ctx.Err() != nil {
+			return report, nil
+		}
+
+		// A lookup cut off by the budget is where warming stops, not a failure
+		sessions, err := c.sessionRepo.ListByUser(ctx, userID)
+		if ctx.Err() != nil {
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+		if len(sessions) == 0 {
+			continue
+		}
+
+		user, err := c.userRepo.GetByID(ctx, userID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+
+		for _, session := range sessions {
+			c.middleware.RememberAuth(*session, *user)
+		}
+		report.Users++
+		report.Sessions += len(sessions)
+	}
+
+	report.Complete = true
+	return report, nil
+}
@@ -0,0 +1,139 @@
+package adminController
+
+import (
+	"context"
+	"path/filepath"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var warmTestTime = time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+
+type warmTest struct {
+	controller    *AdminController
+	users         *MockUserRepository
+	sessions      *MockSessionRepository
+	announcements *MockAnnouncementRepository
+	clock         *clock.Fake
+}
+
+// setupWarmTest logs in user-1 to user-4 in turn, the last most recently, and
+// user-old before the session lifetime. user-failed only failed to log in.
+func setupWarmTest(t *testing.T) *warmTest {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "warm.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&LoginEvent{}))
+
+	for _, event := range []LoginEvent{
+		{UserID: "user-old", CreatedAt: warmTestTime.Add(-config.DEFAULT_SESSION_MAX_LIFETIME - time.Hour), Success: true},
+		{UserID: "user-1", CreatedAt: warmTestTime.Add(-4 * time.Hour), Success: true},
+		{UserID: "user-2", CreatedAt: warmTestTime.Add(-3 * time.Hour), Success: true},
+		{UserID: "user-3", CreatedAt: warmTestTime.Add(-2 * time.Hour), Success: true},
+		{UserID: "user-4", CreatedAt: warmTestTime.Add(-time.Hour), Success: true},
+		{UserID: "user-failed", CreatedAt: warmTestTime.Add(-time.Hour), Success: false},
+	} {
+		require.NoError(t, db.Create(&event).Error)
+	}
+
+	users := &MockUserRepository{}
+	sessions := &MockSessionRepository{}
+	announcements := &MockAnnouncementRepository{}
+	announcements.On("ListActive", mock.Anything, warmTestTime).Return([]Announcement{{Title: "Maintenance"}}, nil)
+
+	fake := clock.NewFake(warmTestTime)
+	controller := New(
+		events.New(nil, config.Config{}, nil),
+		users,
+		repositories.NewLoginEventRepository(database.DB{SQL: db}),
+		announcements,
+		sessions,
+		nil,
+		nil,
+		nil,
+		middleware.New(database.DB{}, &events.EventBus{}, config.Config{}, users, sessions, fake),
+		config.Config{},
+		fake,
+	)
+	controller.log = logger.New("test")
+
+	return &warmTest{controller: controller, users: users, sessions: sessions, announcements: announcements, clock: fake}
+}
+
+func (w *warmTest) userWithSessions(userID string, count int) {
+	sessions := make([]*Session, count)
+	for i := range sessions {
+		sessions[i] = &Session{ID: userID + "-session-" + string(rune('a'+i)), UserID: userID}
+	}
+	w.sessions.On("ListByUser", mock.Anything, userID).Return(sessions, nil)
+	w.users.On("GetByID", mock.Anything, userID).Return(&User{BaseModel: BaseModel{ID: userID}}, nil).Maybe()
+}
+
+func TestAdminController_WarmCaches_RecentUsersWithSessions(t *testing.T) {
+	w := setupWarmTest(t)
+	w.userWithSessions("user-1", 1)
+	w.userWithSessions("user-2", 0)
+	w.userWithSessions("user-3", 2)
+	w.userWithSessions("user-4", 1)
+
+	report, err := w.controller.WarmCaches(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, CacheWarmReport{Users: 3, Sessions: 4, Announcements: 1, Complete: true}, report)
+	w.users.AssertNotCalled(t, "GetByID", mock.Anything, "user-2") // no sessions, so never loaded
+	w.sessions.AssertNotCalled(t, "ListByUser", mock.Anything, "user-old")
+	w.sessions.AssertNotCalled(t, "ListByUser", mock.Anything, "user-failed")
+	w.users.AssertExpectations(t)
+}
+
+func TestAdminController_WarmCaches_SkipsMissingUsers(t *testing.T) {
+	w := setupWarmTest(t)
+	w.userWithSessions("user-1", 1)
+	w.userWithSessions("user-2", 1)
+	w.userWithSessions("user-3", 1)
+	w.sessions.On("ListByUser", mock.Anything, "user-4").Return([]*Session{{ID: "orphan", UserID: "user-4"}}, nil)
+	w.users.On("GetByID", mock.Anything, "user-4").Return((*User)(nil), repositories.ErrNotFound)
+
+	report, err := w.controller.WarmCaches(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Users)
+	assert.True(t, report.Complete)
+}
+
+func TestAdminController_WarmCaches_StopsAtBudget(t *testing.T) {
+	w := setupWarmTest(t)
+	for _, userID := range []string{"user-1", "user-2", "user-3", "user-4"} {
+		w.userWithSessions(userID, 1)
+	}
+	// Each session lookup takes half the budget, so two users are warmed
+	w.sessions.ExpectedCalls = nil
+	w.sessions.On("ListByUser", mock.Anything, mock.Anything).
+		Run(func(mock.Arguments) { w.clock.Advance(CACHE_WARM_BUDGET / 2) }).
+		Return([]*Session{{ID: "session", UserID: "user"}}, nil)
+
+	report, err := w.controller.WarmCaches(context.Background())
+
+	require.NoError(t, err, "running out of time isn't a failure")
+	assert.False(t, report.Complete)
+	assert.Equal(t, 2, report.Users)
+	assert.Equal(t, 2, report.Sessions)
+	assert.Equal(t, CACHE_WARM_BUDGET.Milliseconds(), report.DurationMs)
+	w.sessions.AssertNumberOfCalls(t, "ListByUser", 2)
+	w.sessions.AssertCalled(t, "ListByUser", mock.Anything, "user-4")
+	w.sessions.AssertCalled(t, "ListByUser", mock.Anything, "user-3")
+}
@@ -0,0 +1,244 @@
+package userController
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CAPACITY_USERS    = "users"
+	CAPACITY_SESSIONS = "sessions"
+
+	// Share of a limit, in percent, at which admins are alerted
+	CAPACITY_ALERT_PERCENT = 80
+
+	// How often the scheduler recounts users and sessions. A check that
+	// finds the counts older than CAPACITY_MAX_STALENESS, because the job
+	// hasn't run or failed, recounts them itself.
+	CAPACITY_REFRESH_INTERVAL = time.Minute
+	CAPACITY_MAX_STALENESS    = 5 * time.Minute
+
+	ALERT_CODE_CAPACITY = "capacity_threshold"
+)
+
+var ErrCapacityReached = errors.New("capacity reached")
+
+// CapacityError is returned when creating a user or starting a session
+// would go past Security.MaxUsers or Security.MaxTotalSessions.
+type CapacityError struct {
+	Resource string
+	Limit    int64
+}
+
+func (e *CapacityError) Error() string {
+	return fmt.Sprintf("%s capacity of %d reached", e.Resource, e.Limit)
+}
+
+func (e *CapacityError) Unwrap() error { return ErrCapacityReached }
+
+// UserCounter and SessionCounter are the counts Capacity refreshes from. The
+// stats and session repositories implement them.
+type UserCounter interface {
+	CountUsers(ctx context.Context, since time.Time) (int64, error)
+}
+
+type SessionCounter interface {
+	CountActive(ctx context.Context) (int64, error)
+}
+
+type capacityLimit struct {
+	resource string
+	limit    int64
+	count    atomic.Int64
+	alerted  atomic.Bool
+}
+
+// threshold is the count at which admins are alerted.
+func (l *capacityLimit) threshold() int64 {
+	return (l.limit*CAPACITY_ALERT_PERCENT + 99) / 100
+}
+
+// Capacity enforces soft limits on the total users and sessions. Checks read
+// counts cached in memory: the scheduler recounts them every
+// CAPACITY_REFRESH_INTERVAL and this instance's own creates are added in
+// between, so the counts miss at most what other instances created, and
+// include sessions that ended, since the last recount. A create that leaves
+// a count at CAPACITY_ALERT_PERCENT of its limit or more raises a system
+// alert and an audit entry, once until a recount finds it back under. A
+// limit of 0 doesn't limit.
+type Capacity struct {
+	log            logger.Logger
+	clock          clock.Clock
+	users          *capacityLimit
+	sessions       *capacityLimit
+	userCounter    UserCounter
+	sessionCounter SessionCounter
+	alerts         database.AlertPublisher
+	auditRepo      repositories.AuditRepository
+
+	mutex       sync.Mutex
+	refreshedAt atomic.Int64
+}
+
+// NewCapacity builds the limits from config. A nil clock uses the wall
+// clock, and a nil publisher or audit repository skips that part of an
+// alert.
+func NewCapacity(
+	config config.Config,
+	users UserCounter,
+	sessions SessionCounter,
+	alerts database.AlertPublisher,
+	auditRepo repositories.AuditRepository,
+	clk clock.Clock,
+) *Capacity {
+	return &Capacity{
+		log:            logger.New("userController").File("capacity"),
+		clock:          clock.OrDefault(clk),
+		users:          &capacityLimit{resource: CAPACITY_USERS, limit: int64(max(config.Security.MaxUsers, 0))},
+		sessions:       &capacityLimit{resource: CAPACITY_SESSIONS, limit: int64(max(config.Security.MaxTotalSessions, 0))},
+		userCounter:    users,
+		sessionCounter: sessions,
+		alerts:         alerts,
+		auditRepo:      auditRepo,
+	}
+}
+
+// Limited reports whether either limit is set.
+func (c *Capacity) Limited() bool {
+	return c.users.limit > 0 || c.sessions.limit > 0
+}
+
+// RefreshInterval is how often Refresh should run.
+func (c *Capacity) RefreshInterval() time.Duration {
+	return CAPACITY_REFRESH_INTERVAL
+}
+
+// Refresh recounts users and sessions, for the scheduler.
+func (c *Capacity) Refresh(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.refresh(ctx)
+}
+
+func (c *Capacity) refresh(ctx context.Context) error {
+	if !c.Limited() {
+		return nil
+	}
+
+	if c.users.limit > 0 {
+		count, err := c.userCounter.CountUsers(ctx, time.Time{})
+		if err != nil {
+			return err
+		}
+		c.store(c.users, count)
+	}
+	if c.sessions.limit > 0 {
+		count, err := c.sessionCounter.CountActive(ctx)
+		if err != nil {
+			return err
+		}
+		c.store(c.sessions, count)
+	}
+	c.refreshedAt.Store(c.clock.Now().UnixNano())
+	return nil
+}
+
+func (c *Capacity) store(limit *capacityLimit, count int64) {
+	limit.count.Store(count)
+	if count < limit.threshold() {
+		limit.alerted.Store(false)
+	}
+}
+
+// fresh recounts when the counts are older than CAPACITY_MAX_STALENESS. A
+// failed recount is logged and the old counts used, so an outage of the
+// store doesn't stop sign ins.
+func (c *Capacity) fresh(ctx context.Context) {
+	if c.clock.Now().Sub(time.Unix(0, c.refreshedAt.Load())) < CAPACITY_MAX_STALENESS {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.clock.Now().Sub(time.Unix(0, c.refreshedAt.Load())) < CAPACITY_MAX_STALENESS {
+		return
+	}
+	if err := c.refresh(ctx); err != nil {
+		c.log.Function("fresh").Er("failed to recount capacity", err)
+	}
+}
+
+func (c *Capacity) limit(resource string) *capacityLimit {
+	if resource == CAPACITY_SESSIONS {
+		return c.sessions
+	}
+	return c.users
+}
+
+// Check returns a CapacityError when resource is at its limit.
+func (c *Capacity) Check(ctx context.Context, resource string) error {
+	limit := c.limit(resource)
+	if limit.limit <= 0 {
+		return nil
+	}
+
+	c.fresh(ctx)
+	if limit.count.Load() >= limit.limit {
+		c.log.Function("Check").Warn("Capacity reached", "resource", resource, "limit", limit.limit)
+		return &CapacityError{Resource: resource, Limit: limit.limit}
+	}
+	return nil
+}
+
+// Added counts a created user or session, alerting when the count is at
+// CAPACITY_ALERT_PERCENT of the limit or more.
+func (c *Capacity) Added(ctx context.Context, resource string) {
+	limit := c.limit(resource)
+	if limit.limit <= 0 {
+		return
+	}
+
+	count := limit.count.Add(1)
+	if count >= limit.threshold() && limit.alerted.CompareAndSwap(false, true) {
+		c.alert(ctx, limit, count)
+	}
+}
+
+func (c *Capacity) alert(ctx context.Context, limit *capacityLimit, count int64) {
+	log := c.log.Function("alert")
+	details := map[string]any{"resource": limit.resource, "count": count, "limit": limit.limit}
+	log.Warn("Raising capacity alert", "resource", limit.resource, "count", count, "limit", limit.limit)
+
+	if c.alerts != nil {
+		if err := c.alerts.Publish(ctx, events.SystemAlertEvent{
+			Code:     ALERT_CODE_CAPACITY,
+			Severity: events.ALERT_SEVERITY_WARNING,
+			Message: fmt.Sprintf(
+				"%d of %d %s, new ones are refused at the limit", count, limit.limit, limit.resource,
+			),
+			Details: details,
+		}); err != nil {
+			log.Er("failed to publish capacity alert", err, "resource", limit.resource)
+		}
+	}
+	if c.auditRepo != nil {
+		if err := c.auditRepo.Create(ctx, &AuditLog{
+			Action:  AUDIT_ACTION_CAPACITY_ALERT,
+			Source:  AUDIT_SOURCE_SYSTEM,
+			Details: details,
+		}); err != nil {
+			log.Er("failed to record audit entry", err, "action", AUDIT_ACTION_CAPACITY_ALERT)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package userController
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/events"
+	"server/internal/logger"
+	. "server/internal/models"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCapacityCounts stands in for the stats and session repositories.
+type fakeCapacityCounts struct {
+	users    atomic.Int64
+	sessions atomic.Int64
+	recounts atomic.Int64
+}
+
+func (f *fakeCapacityCounts) CountUsers(ctx context.Context, since time.Time) (int64, error) {
+	f.recounts.Add(1)
+	return f.users.Load(), nil
+}
+
+func (f *fakeCapacityCounts) CountActive(ctx context.Context) (int64, error) {
+	return f.sessions.Load(), nil
+}
+
+type recordedAlerts struct {
+	events []events.SystemAlertEvent
+}
+
+func (r *recordedAlerts) Publish(ctx context.Context, alert events.SystemAlertEvent) error {
+	r.events = append(r.events, alert)
+	return nil
+}
+
+func setupCapacityTest(
+	t *testing.T,
+	maxUsers int,
+	maxSessions int,
+) (*Capacity, *fakeCapacityCounts, *recordedAlerts, *MockAuditRepository, *clock.Fake) {
+	t.Helper()
+	counts := &fakeCapacityCounts{}
+	alerts := &recordedAlerts{}
+	auditRepo := &MockAuditRepository{}
+	fakeClock := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	capacity := NewCapacity(
+		config.Config{Security: config.SecurityConfig{MaxUsers: maxUsers, MaxTotalSessions: maxSessions}},
+		counts,
+		counts,
+		alerts,
+		auditRepo,
+		fakeClock,
+	)
+	return capacity, counts, alerts, auditRepo, fakeClock
+}
+
+func TestCapacity_AlertsOnceAtThreshold(t *testing.T) {
+	capacity, counts, alerts, auditRepo, _ := setupCapacityTest(t, 10, 0)
+	auditRepo.On("Create", mock.Anything, mock.MatchedBy(func(entry *AuditLog) bool {
+		return entry.Action == AUDIT_ACTION_CAPACITY_ALERT && entry.Source == AUDIT_SOURCE_SYSTEM
+	})).Return(nil).Once()
+	counts.users.Store(6)
+	ctx := context.Background()
+	require.NoError(t, capacity.Refresh(ctx))
+
+	capacity.Added(ctx, CAPACITY_USERS)
+	assert.Empty(t, alerts.events, "7 of 10 is under 80%")
+
+	capacity.Added(ctx, CAPACITY_USERS)
+	capacity.Added(ctx, CAPACITY_USERS)
+	require.Len(t, alerts.events, 1, "the alert fires once however far past 80% it goes")
+	alert := alerts.events[0]
+	assert.Equal(t, ALERT_CODE_CAPACITY, alert.Code)
+	assert.Equal(t, events.ALERT_SEVERITY_WARNING, alert.Severity)
+	assert.Equal(t, CAPACITY_USERS, alert.Details["resource"])
+	assert.Equal(t, int64(8), alert.Details["count"])
+	auditRepo.AssertExpectations(t)
+
+	// A recount back under the threshold arms it again
+	counts.users.Store(5)
+	require.NoError(t, capacity.Refresh(ctx))
+	auditRepo.On("Create", mock.Anything, mock.Anything).Return(nil).Once()
+	counts.users.Store(7)
+	require.NoError(t, capacity.Refresh(ctx))
+	capacity.Added(ctx, CAPACITY_USERS)
+	assert.Len(t, alerts.events, 2)
+}
+
+func TestCapacity_HardStop(t *testing.T) {
+	capacity, counts, _, auditRepo, _ := setupCapacityTest(t, 0, 2)
+	auditRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	ctx := context.Background()
+	counts.sessions.Store(1)
+
+	require.NoError(t, capacity.Check(ctx, CAPACITY_SESSIONS))
+	capacity.Added(ctx, CAPACITY_SESSIONS)
+
+	err := capacity.Check(ctx, CAPACITY_SESSIONS)
+	var capacityErr *CapacityError
+	require.ErrorAs(t, err, &capacityErr)
+	assert.ErrorIs(t, err, ErrCapacityReached)
+	assert.Equal(t, CAPACITY_SESSIONS, capacityErr.Resource)
+	assert.Equal(t, int64(2), capacityErr.Limit)
+
+	// Users aren't limited
+	assert.NoError(t, capacity.Check(ctx, CAPACITY_USERS))
+}
+
+func TestCapacity_StalenessBound(t *testing.T) {
+	capacity, counts, _, _, fakeClock := setupCapacityTest(t, 5, 0)
+	ctx := context.Background()
+
+	// The first check counts, as nothing has been counted yet
+	require.NoError(t, capacity.Check(ctx, CAPACITY_USERS))
+	assert.Equal(t, int64(1), counts.recounts.Load())
+
+	// Users created elsewhere aren't seen until a recount
+	counts.users.Store(5)
+	fakeClock.Advance(CAPACITY_MAX_STALENESS - time.Second)
+	require.NoError(t, capacity.Check(ctx, CAPACITY_USERS))
+	assert.Equal(t, int64(1), counts.recounts.Load(), "checks within the bound use the cached count")
+
+	// Past the bound a check recounts rather than trusting the cache
+	fakeClock.Advance(time.Second)
+	assert.ErrorIs(t, capacity.Check(ctx, CAPACITY_USERS), ErrCapacityReached)
+	assert.Equal(t, int64(2), counts.recounts.Load())
+}
+
+func TestCapacity_Unlimited(t *testing.T) {
+	capacity, counts, alerts, _, _ := setupCapacityTest(t, 0, 0)
+	ctx := context.Background()
+	counts.users.Store(1_000_000)
+	counts.sessions.Store(1_000_000)
+
+	assert.False(t, capacity.Limited())
+	require.NoError(t, capacity.Refresh(ctx))
+	for range 3 {
+		assert.NoError(t, capacity.Check(ctx, CAPACITY_USERS))
+		assert.NoError(t, capacity.Check(ctx, CAPACITY_SESSIONS))
+		capacity.Added(ctx, CAPACITY_USERS)
+		capacity.Added(ctx, CAPACITY_SESSIONS)
+	}
+	assert.Zero(t, counts.recounts.Load(), "nothing is counted without a limit")
+	assert.Empty(t, alerts.events)
+}
+
+func TestUserController_Login_CapacityReached(t *testing.T) {
+	controller, _, mockSessionRepo, mockLoginEventRepo := setupLoginTest(t)
+	mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	capacity, counts, _, _, _ := setupCapacityTest(t, 0, 3)
+	counts.sessions.Store(3)
+	controller.SetCapacity(capacity)
+
+	app := fiber.New()
+	app.Post("/login", controller.handleLogin)
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"login":"jdoe","password":"correct-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, fiber.StatusInsufficientStorage, resp.StatusCode)
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, ErrorCodeCapacityReached, body["code"])
+	assert.Equal(t, CAPACITY_SESSIONS, body["resource"])
+	mockSessionRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUserController_Register_CapacityReached(t *testing.T) {
+	mockUserRepo := &MockUserRepository{}
+	controller := &UserController{
+		userRepo: mockUserRepo,
+		Config:   config.Config{Security: config.SecurityConfig{MinPasswordScore: 2}},
+		log:      logger.New("test"),
+	}
+	capacity, counts, _, _, _ := setupCapacityTest(t, 2, 0)
+	counts.users.Store(2)
+	controller.SetCapacity(capacity)
+
+	_, _, err := controller.Register(context.Background(), RegisterRequest{
+		Login:    "newuser",
+		Password: "correct horse battery staple",
+	})
+
+	var capacityErr *CapacityError
+	require.ErrorAs(t, err, &capacityErr)
+	assert.Equal(t, CAPACITY_USERS, capacityErr.Resource)
+	mockUserRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
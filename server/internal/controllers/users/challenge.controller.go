@@ -0,0 +1,185 @@
+package userController
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"server/config"
+	"server/internal/clock"
+	. "server/internal/models"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CHALLENGE_FAILURE_WINDOW is how far back failed logins are counted for
+// LoginContext.RecentFailures.
+const CHALLENGE_FAILURE_WINDOW = 15 * time.Minute
+
+var (
+	ErrChallengeRequired        = errors.New("login requires a challenge")
+	ErrChallengeFailed          = errors.New("login challenge failed")
+	ErrUnknownChallengeProvider = errors.New("unknown challenge provider")
+)
+
+// LoginContext is what a ChallengeProvider weighs a login on. UserID is empty
+// and KnownIP false when the login names no account, so guessing logins
+// looks no less risky than guessing passwords.
+type LoginContext struct {
+	Login      string
+	UserID     string
+	IP         string
+	UserAgent  string
+	ClientType string
+
+	// Failed logins for the account in the last CHALLENGE_FAILURE_WINDOW
+	RecentFailures int64
+	// Whether the account has logged in from IP before
+	KnownIP bool
+}
+
+// ChallengeProvider decides which logins must pass a challenge, such as a
+// CAPTCHA, and checks the tokens clients get for passing one.
+type ChallengeProvider interface {
+	// Evaluate reports whether the login must come with a challenge token
+	Evaluate(ctx context.Context, login LoginContext) bool
+	// Verify checks a token the client got for passing the challenge
+	Verify(ctx context.Context, token string) error
+}
+
+// ChallengeDescriptor tells a client refused with challenge_required which
+// challenge to show, such as a CAPTCHA vendor and its site key.
+type ChallengeDescriptor struct {
+	Provider string            `json:"provider"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+// ChallengeDescriber is implemented by providers whose clients need more
+// than the provider name to show the challenge.
+type ChallengeDescriber interface {
+	Describe() ChallengeDescriptor
+}
+
+// NoChallenge never asks for a challenge. It is the default provider.
+type NoChallenge struct{}
+
+func (NoChallenge) Evaluate(ctx context.Context, login LoginContext) bool { return false }
+func (NoChallenge) Verify(ctx context.Context, token string) error        { return nil }
+
+// ChallengeProviderFactory builds a provider from the config, failing when
+// the provider's own settings are missing.
+type ChallengeProviderFactory func(config config.Config) (ChallengeProvider, error)
+
+var (
+	challengeProvidersMutex sync.RWMutex
+	challengeProviders      = map[string]ChallengeProviderFactory{
+		config.CHALLENGE_PROVIDER_NONE: func(config.Config) (ChallengeProvider, error) { return NoChallenge{}, nil },
+	}
+)
+
+// RegisterChallengeProvider makes a provider selectable by name with
+// SECURITY_CHALLENGE_PROVIDER. Providers register from init. It panics on a
+// name that is already taken, since that is a programming error.
+func RegisterChallengeProvider(name string, factory ChallengeProviderFactory) {
+	challengeProvidersMutex.Lock()
+	defer challengeProvidersMutex.Unlock()
+	if _, ok := challengeProviders[name]; ok {
+		panic(fmt.Sprintf("userController: challenge provider %q registered twice", name))
+	}
+	challengeProviders[name] = factory
+}
+
+// NewChallengeProvider builds the provider the config selects. An unknown
+// name is an ErrUnknownChallengeProvider.
+func NewChallengeProvider(config config.Config) (ChallengeProvider, error) {
+	name := config.Security.ChallengeProvider
+	if name == "" {
+		return NoChallenge{}, nil
+	}
+
+	challengeProvidersMutex.RLock()
+	factory, ok := challengeProviders[name]
+	known := slices.Sorted(maps.Keys(challengeProviders))
+	challengeProvidersMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q, registered: %v", ErrUnknownChallengeProvider, name, known)
+	}
+	return factory(config)
+}
+
+// SetChallengeProvider replaces the provider logins are checked with.
+func (c *UserController) SetChallengeProvider(provider ChallengeProvider) {
+	c.challenge = provider
+}
+
+// challengeDescriptor describes the configured challenge for a refused
+// login.
+func (c *UserController) challengeDescriptor() ChallengeDescriptor {
+	if describer, ok := c.challenge.(ChallengeDescriber); ok {
+		return describer.Describe()
+	}
+	return ChallengeDescriptor{Provider: c.Config.Security.ChallengeProvider}
+}
+
+// checkChallenge asks the provider whether the login needs a challenge and,
+// when a token came with it, verifies the token. A token is verified even
+// when none was needed, so a client can't probe which logins are risky by
+// sending bad ones.
+func (c *UserController) checkChallenge(ctx context.Context, loginRequest LoginRequest, user *User) error {
+	if c.challenge == nil {
+		return nil
+	}
+	if _, ok := c.challenge.(NoChallenge); ok {
+		return nil
+	}
+
+	if loginRequest.ChallengeToken != "" {
+		if err := c.challenge.Verify(ctx, loginRequest.ChallengeToken); err != nil {
+			return fmt.Errorf("%w: %w", ErrChallengeFailed, err)
+		}
+		return nil
+	}
+
+	login := c.loginContext(ctx, loginRequest, user)
+	if c.challenge.Evaluate(ctx, login) {
+		c.log.Function("checkChallenge").Info("Login requires a challenge",
+			"userID", login.UserID, "ip", login.IP, "recentFailures", login.RecentFailures, "knownIP", login.KnownIP)
+		return ErrChallengeRequired
+	}
+	return nil
+}
+
+// loginContext gathers what the provider weighs a login on. A failed lookup
+// of the history is logged and leaves its field unset rather than failing the
+// login, so an unreadable history makes every IP look new.
+func (c *UserController) loginContext(ctx context.Context, loginRequest LoginRequest, user *User) LoginContext {
+	log := c.log.Function("loginContext")
+
+	login := LoginContext{
+		Login:      loginRequest.Login,
+		IP:         loginRequest.IP,
+		UserAgent:  loginRequest.UserAgent,
+		ClientType: loginRequest.ClientType,
+	}
+	if user == nil {
+		return login
+	}
+	login.UserID = user.ID
+
+	since := clock.OrDefault(c.clock).Now().Add(-CHALLENGE_FAILURE_WINDOW)
+	failures, err := c.loginEventRepo.CountFailedSince(ctx, user.ID, since)
+	if err != nil {
+		log.Warn("failed to count recent login failures", "userID", user.ID, "error", err)
+	}
+	login.RecentFailures = failures
+
+	if login.IP != "" {
+		known, err := c.loginEventRepo.HasSucceededFromIP(ctx, user.ID, login.IP)
+		if err != nil {
+			log.Warn("failed to look up known login IPs", "userID", user.ID, "error", err)
+		}
+		login.KnownIP = known
+	}
+	return login
+}
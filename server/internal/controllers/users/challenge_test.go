@@ -0,0 +1,195 @@
+package userController
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"server/config"
+	. "server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeChallenge requires a challenge when require says so and accepts only
+// the token "passed".
+type fakeChallenge struct {
+	require   func(login LoginContext) bool
+	evaluated []LoginContext
+	verified  []string
+}
+
+func (f *fakeChallenge) Evaluate(ctx context.Context, login LoginContext) bool {
+	f.evaluated = append(f.evaluated, login)
+	return f.require(login)
+}
+
+func (f *fakeChallenge) Verify(ctx context.Context, token string) error {
+	f.verified = append(f.verified, token)
+	if token != "passed" {
+		return errors.New("token rejected")
+	}
+	return nil
+}
+
+func (f *fakeChallenge) Describe() ChallengeDescriptor {
+	return ChallengeDescriptor{Provider: "fake", Params: map[string]string{"siteKey": "site-123"}}
+}
+
+func setupChallengeTest(t *testing.T, failures int64, knownIP bool, require func(LoginContext) bool) (*UserController, *MockLoginEventRepository, *fakeChallenge) {
+	controller, _, _, mockLoginEventRepo := setupLoginTest(t)
+	mockLoginEventRepo.On("CountFailedSince", mock.Anything, "user-1", mock.MatchedBy(func(since time.Time) bool {
+		return time.Since(since).Round(time.Minute) == CHALLENGE_FAILURE_WINDOW
+	})).Return(failures, nil)
+	mockLoginEventRepo.On("HasSucceededFromIP", mock.Anything, "user-1", mock.Anything).Return(knownIP, nil)
+
+	challenge := &fakeChallenge{require: require}
+	controller.SetChallengeProvider(challenge)
+	return controller, mockLoginEventRepo, challenge
+}
+
+func challengeLogin(login string, token string) LoginRequest {
+	return LoginRequest{
+		Login:          login,
+		Password:       "correct-password",
+		ChallengeToken: token,
+		IP:             "10.0.0.1",
+		UserAgent:      "Firefox/121.0",
+		ClientType:     "solid",
+	}
+}
+
+func TestUserController_Login_ChallengeContext(t *testing.T) {
+	controller, mockLoginEventRepo, challenge := setupChallengeTest(t, 3, true, func(LoginContext) bool { return false })
+	mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	_, _, err := controller.Login(context.Background(), challengeLogin("JDoe", ""))
+	require.NoError(t, err)
+
+	require.Len(t, challenge.evaluated, 1)
+	assert.Equal(t, LoginContext{
+		Login:          "jdoe",
+		UserID:         "user-1",
+		IP:             "10.0.0.1",
+		UserAgent:      "Firefox/121.0",
+		ClientType:     "solid",
+		RecentFailures: 3,
+		KnownIP:        true,
+	}, challenge.evaluated[0])
+	assert.Empty(t, challenge.verified)
+}
+
+func TestUserController_Login_ChallengeRequired(t *testing.T) {
+	risky := func(login LoginContext) bool { return !login.KnownIP || login.RecentFailures >= 5 }
+
+	testCases := []struct {
+		name     string
+		login    string
+		failures int64
+		knownIP  bool
+		wantErr  error
+	}{
+		{"known ip, few failures", "jdoe", 1, true, nil},
+		{"new ip", "jdoe", 0, false, ErrChallengeRequired},
+		{"many failures", "jdoe", 5, true, ErrChallengeRequired},
+		{"unknown login is challenged, not refused", "missing", 0, false, ErrChallengeRequired},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			controller, mockLoginEventRepo, _ := setupChallengeTest(t, tc.failures, tc.knownIP, risky)
+			mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+			_, session, err := controller.Login(context.Background(), challengeLogin(tc.login, ""))
+
+			if tc.wantErr == nil {
+				require.NoError(t, err)
+				assert.NotEmpty(t, session.UserID)
+				mockLoginEventRepo.AssertCalled(t, "Create", mock.Anything, mock.Anything)
+				return
+			}
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Empty(t, session.ID)
+			mockLoginEventRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestUserController_Login_ChallengeToken(t *testing.T) {
+	always := func(LoginContext) bool { return true }
+
+	t.Run("passed token skips evaluation", func(t *testing.T) {
+		controller, mockLoginEventRepo, challenge := setupChallengeTest(t, 0, false, always)
+		mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+		user, _, err := controller.Login(context.Background(), challengeLogin("jdoe", "passed"))
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", user.ID)
+		assert.Equal(t, []string{"passed"}, challenge.verified)
+		assert.Empty(t, challenge.evaluated)
+	})
+
+	t.Run("bad token fails before the password is checked", func(t *testing.T) {
+		controller, mockLoginEventRepo, challenge := setupChallengeTest(t, 0, true, func(LoginContext) bool { return false })
+		mockLoginEventRepo.On("Create", mock.Anything, mock.MatchedBy(func(event *LoginEvent) bool {
+			return event.UserID == "user-1" && !event.Success
+		})).Return(nil).Once()
+
+		request := challengeLogin("jdoe", "forged")
+		request.Password = "wrong-password"
+		_, _, err := controller.Login(context.Background(), request)
+		assert.ErrorIs(t, err, ErrChallengeFailed)
+		assert.Equal(t, []string{"forged"}, challenge.verified)
+		mockLoginEventRepo.AssertNumberOfCalls(t, "Create", 1)
+	})
+}
+
+func TestUserController_HandleLogin_Challenge(t *testing.T) {
+	controller, mockLoginEventRepo, _ := setupChallengeTest(t, 0, false, func(LoginContext) bool { return true })
+	mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	fiberApp := fiber.New()
+	fiberApp.Post("/api/v1/users/login", controller.handleLogin)
+
+	send := func(body string) (int, map[string]any) {
+		resp, err := fiberApp.Test(jsonRequest("POST", "/api/v1/users/login", body))
+		require.NoError(t, err)
+		var result map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return resp.StatusCode, result
+	}
+	challenge := map[string]any{"provider": "fake", "params": map[string]any{"siteKey": "site-123"}}
+
+	status, body := send(`{"login":"jdoe","password":"correct-password"}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+	assert.Equal(t, ErrorCodeChallengeRequired, body["code"])
+	assert.Equal(t, challenge, body["challenge"])
+
+	status, body = send(`{"login":"jdoe","password":"correct-password","challengeToken":"forged"}`)
+	assert.Equal(t, fiber.StatusForbidden, status)
+	assert.Equal(t, ErrorCodeChallengeFailed, body["code"])
+
+	status, _ = send(`{"login":"jdoe","password":"correct-password","challengeToken":"passed"}`)
+	assert.Equal(t, fiber.StatusOK, status)
+}
+
+func TestNewChallengeProvider(t *testing.T) {
+	for _, name := range []string{"", config.CHALLENGE_PROVIDER_NONE} {
+		provider, err := NewChallengeProvider(config.Config{Security: config.SecurityConfig{ChallengeProvider: name}})
+		require.NoError(t, err, name)
+		assert.Equal(t, NoChallenge{}, provider, name)
+	}
+
+	RegisterChallengeProvider("test-challenge", func(config.Config) (ChallengeProvider, error) {
+		return &fakeChallenge{}, nil
+	})
+	provider, err := NewChallengeProvider(config.Config{Security: config.SecurityConfig{ChallengeProvider: "test-challenge"}})
+	require.NoError(t, err)
+	assert.IsType(t, &fakeChallenge{}, provider)
+
+	_, err = NewChallengeProvider(config.Config{Security: config.SecurityConfig{ChallengeProvider: "recaptcha"}})
+	assert.ErrorIs(t, err, ErrUnknownChallengeProvider)
+}
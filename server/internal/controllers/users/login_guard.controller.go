@@ -0,0 +1,111 @@
+package userController
+
+import (
+	"crypto/sha256"
+	"server/internal/clock"
+	"sync"
+	"time"
+)
+
+// PASSWORD_GUARD_WINDOW is how long a failed comparison is reused for the
+// same login and password.
+const PASSWORD_GUARD_WINDOW = time.Second
+
+type passwordCheckKey [sha256.Size]byte
+
+type passwordCheck struct {
+	done     chan struct{}
+	previous bool
+	err      error
+	finished time.Time
+}
+
+// passwordGuard stops repeated logins with the same wrong password from each
+// paying for a bcrypt comparison. Concurrent checks of the same login,
+// password and hash share one comparison, and a failure is remembered for
+// PASSWORD_GUARD_WINDOW. Checks are keyed on a hash of all three, so a
+// result is never reused for a different password, and passwords are never
+// held.
+type passwordGuard struct {
+	mutex     sync.Mutex
+	checks    map[passwordCheckKey]*passwordCheck
+	lastPrune time.Time
+	clock     clock.Clock
+	compare   func(password string, hash string) (previous bool, err error)
+}
+
+func newPasswordGuard(
+	clk clock.Clock,
+	compare func(password string, hash string) (bool, error),
+) *passwordGuard {
+	return &passwordGuard{
+		checks:  make(map[passwordCheckKey]*passwordCheck),
+		clock:   clock.OrDefault(clk),
+		compare: compare,
+	}
+}
+
+// Verify compares password against hash, or waits for and returns the result
+// of an identical comparison.
+func (g *passwordGuard) Verify(login string, password string, hash string) (bool, error) {
+	key := passwordKey(login, password, hash)
+
+	g.mutex.Lock()
+	now := g.clock.Now()
+	g.prune(now)
+	if check, ok := g.checks[key]; ok && !g.expired(check, now) {
+		g.mutex.Unlock()
+		<-check.done
+		return check.previous, check.err
+	}
+	check := &passwordCheck{done: make(chan struct{})}
+	g.checks[key] = check
+	g.mutex.Unlock()
+
+	check.previous, check.err = g.compare(password, hash)
+
+	g.mutex.Lock()
+	check.finished = g.clock.Now()
+	// Only failures are worth repeating cheaply
+	if check.err == nil && g.checks[key] == check {
+		delete(g.checks, key)
+	}
+	g.mutex.Unlock()
+	close(check.done)
+
+	return check.previous, check.err
+}
+
+// prune drops the failures that have outlived the window, at most once per
+// window so a burst of distinct passwords doesn't rescan the map each time.
+// Callers hold the mutex.
+func (g *passwordGuard) prune(now time.Time) {
+	if now.Sub(g.lastPrune) < PASSWORD_GUARD_WINDOW {
+		return
+	}
+	g.lastPrune = now
+
+	for key, check := range g.checks {
+		if g.expired(check, now) {
+			delete(g.checks, key)
+		}
+	}
+}
+
+// expired reports whether a finished check is too old to reuse. Callers hold
+// the mutex.
+func (g *passwordGuard) expired(check *passwordCheck, now time.Time) bool {
+	return !check.finished.IsZero() && now.Sub(check.finished) >= PASSWORD_GUARD_WINDOW
+}
+
+func passwordKey(login string, password string, hash string) passwordCheckKey {
+	digest := sha256.New()
+	for _, part := range []string{login, password, hash} {
+		digest.Write([]byte(part))
+		// Separate the parts so ("ab", "c") and ("a", "bc") differ
+		digest.Write([]byte{0})
+	}
+	var key passwordCheckKey
+	copy(key[:], digest.Sum(nil))
+	return key
+}
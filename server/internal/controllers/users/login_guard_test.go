@@ -0,0 +1,166 @@
+package userController
+
+import (
+	"context"
+	"errors"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/metrics"
+	. "server/internal/models"
+	"server/internal/routes/middleware"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// countingCompare accepts only "correct-password" and counts its calls.
+// Calls block until release is closed, when one is given.
+func countingCompare(calls *atomic.Int32, release chan struct{}) func(string, string) (bool, error) {
+	return func(password string, hash string) (bool, error) {
+		calls.Add(1)
+		if release != nil {
+			<-release
+		}
+		if password != "correct-password" {
+			return false, bcrypt.ErrMismatchedHashAndPassword
+		}
+		return false, nil
+	}
+}
+
+func TestPasswordGuard_SharesInFlightComparison(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	guard := newPasswordGuard(clock.NewFake(time.Now()), countingCompare(&calls, release))
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = guard.Verify("jdoe", "wrong-password", "hash")
+		}()
+	}
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	for _, err := range errs {
+		assert.ErrorIs(t, err, bcrypt.ErrMismatchedHashAndPassword)
+	}
+}
+
+func TestPasswordGuard_NeverSharesAcrossInputs(t *testing.T) {
+	var calls atomic.Int32
+	guard := newPasswordGuard(clock.NewFake(time.Now()), countingCompare(&calls, nil))
+
+	_, err := guard.Verify("jdoe", "wrong-password", "hash")
+	require.ErrorIs(t, err, bcrypt.ErrMismatchedHashAndPassword)
+
+	// A remembered failure must not answer for the right password
+	_, err = guard.Verify("jdoe", "correct-password", "hash")
+	assert.NoError(t, err)
+
+	// Nor for another login, or the same login after a password change
+	_, err = guard.Verify("jsmith", "wrong-password", "hash")
+	assert.ErrorIs(t, err, bcrypt.ErrMismatchedHashAndPassword)
+	_, err = guard.Verify("jdoe", "wrong-password", "new-hash")
+	assert.ErrorIs(t, err, bcrypt.ErrMismatchedHashAndPassword)
+
+	// Parts are separated, so shifting bytes between them changes the key
+	_, err = guard.Verify("jdoe", "wrong-passwordh", "ash")
+	assert.ErrorIs(t, err, bcrypt.ErrMismatchedHashAndPassword)
+
+	assert.Equal(t, int32(5), calls.Load())
+}
+
+func TestPasswordGuard_RemembersFailuresForTheWindow(t *testing.T) {
+	var calls atomic.Int32
+	fakeClock := clock.NewFake(time.Now())
+	guard := newPasswordGuard(fakeClock, countingCompare(&calls, nil))
+
+	for range 3 {
+		_, err := guard.Verify("jdoe", "wrong-password", "hash")
+		assert.ErrorIs(t, err, bcrypt.ErrMismatchedHashAndPassword)
+		fakeClock.Advance(PASSWORD_GUARD_WINDOW / 4)
+	}
+	assert.Equal(t, int32(1), calls.Load())
+
+	fakeClock.Advance(PASSWORD_GUARD_WINDOW)
+	_, err := guard.Verify("jdoe", "wrong-password", "hash")
+	assert.ErrorIs(t, err, bcrypt.ErrMismatchedHashAndPassword)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestPasswordGuard_ForgetsSuccesses(t *testing.T) {
+	var calls atomic.Int32
+	guard := newPasswordGuard(clock.NewFake(time.Now()), countingCompare(&calls, nil))
+
+	for range 2 {
+		_, err := guard.Verify("jdoe", "correct-password", "hash")
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Empty(t, guard.checks)
+}
+
+func TestPasswordGuard_PrunesExpiredFailures(t *testing.T) {
+	var calls atomic.Int32
+	fakeClock := clock.NewFake(time.Now())
+	guard := newPasswordGuard(fakeClock, countingCompare(&calls, nil))
+
+	_, _ = guard.Verify("jdoe", "first-guess", "hash")
+	_, _ = guard.Verify("jdoe", "second-guess", "hash")
+	require.Len(t, guard.checks, 2)
+
+	fakeClock.Advance(2 * PASSWORD_GUARD_WINDOW)
+	_, _ = guard.Verify("jdoe", "third-guess", "hash")
+	assert.Len(t, guard.checks, 1)
+}
+
+func TestUserController_Login_RecordsLatencyByOutcome(t *testing.T) {
+	controller, mockUserRepo, _, mockLoginEventRepo := setupLoginTest(t)
+	mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	mockUserRepo.On("GetByLogin", mock.Anything, "broken").Return((*User)(nil), errors.New("db down"))
+	controller.loginLatency = metrics.NewHistogram("test_login_seconds", "", "outcome", metrics.DefaultLatencyBuckets)
+
+	attempts := []LoginRequest{
+		{Login: "jdoe", Password: "correct-password"},
+		{Login: "jdoe", Password: "wrong-password"},
+		{Login: "jdoe", Password: "wrong-password"},
+		{Login: "missing", Password: "whatever"},
+		{Login: "broken", Password: "whatever"},
+	}
+	for _, attempt := range attempts {
+		_, _, _ = controller.Login(context.Background(), attempt)
+	}
+
+	snapshot := controller.loginLatency.Snapshot()
+	counts := make(map[string]uint64, len(snapshot))
+	for outcome, series := range snapshot {
+		counts[outcome] = series.Count
+	}
+	assert.Equal(t, map[string]uint64{
+		"success":          1,
+		"invalid_password": 2,
+		"unknown_login":    1,
+		"error":            1,
+	}, counts)
+}
+
+func TestNew_RegistersLoginLatency(t *testing.T) {
+	controller := New(nil, nil, nil, nil, nil, nil, middleware.Middleware{}, config.Config{})
+
+	assert.Same(t, LoginLatency, controller.loginLatency)
+	assert.NotNil(t, controller.passwordGuard)
+	assert.Panics(t, func() { metrics.Register(LoginLatency) }, "already registered from init")
+}
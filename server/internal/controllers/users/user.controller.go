@@ -1,120 +1,1168 @@
 package userController
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
 	"server/config"
+	"server/internal/clock"
 	"server/internal/events"
 	"server/internal/logger"
+	"server/internal/metrics"
 	. "server/internal/models"
+	"server/internal/notifier"
 	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"server/internal/tracing"
+	"server/internal/utils"
+	"slices"
 	"time"
 
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
-type UserController struct {
-	userRepo    repositories.UserRepository
-	sessionRepo repositories.SessionRepository
-	Config      config.Config
-	log         logger.Logger
-	wsManager   WebSocketManager
-	eventBus    *events.EventBus
+var (
+	ErrLoginTaken             = errors.New("login is already taken")
+	ErrReauthenticationFailed = errors.New("password confirmation failed")
+	ErrSessionNotFound        = errors.New("session not found")
+	ErrNotImpersonating       = errors.New("session is not impersonating a user")
+	ErrSessionMaxLifetime     = errors.New("session has reached its maximum lifetime")
+	ErrTermsOutdated          = errors.New("terms of service version is not the current one")
+	ErrTooManyLookupIDs       = errors.New("too many user IDs to look up")
+)
+
+// LoginLatency is how long logins take, by outcome. Most of a successful or
+// wrong-password login is the bcrypt comparison.
+var LoginLatency = metrics.NewHistogram(
+	"login_duration_seconds",
+	"Time spent checking login credentials and starting the session.",
+	"outcome",
+	metrics.DefaultLatencyBuckets,
+)
+
+// The most users LookupUsers looks up at once.
+const USER_LOOKUP_MAX_IDS = 200
+
+// The most a registration dry run waits before answering whether a login is
+// taken.
+const LOGIN_CHECK_JITTER = 100 * time.Millisecond
+
+// How long past its expiry a session is left alone before the reaper
+// deletes it, so it doesn't race requests still finishing with it.
+const SESSION_REAP_GRACE = 10 * time.Minute
+
+// Session reaper metrics. Reaped sessions are counted per run and in total;
+// stored is every session in the cache after the last run.
+var (
+	SessionsReaped = metrics.NewCounter(
+		"sessions_reaped_total",
+		"Expired sessions deleted by the session reaper.",
+	)
+	SessionsReapedLastRun = metrics.NewGauge(
+		"sessions_reaped_last_run",
+		"Expired sessions deleted by the last session reaper run.",
+	)
+	SessionsStored = metrics.NewGauge(
+		"sessions_stored",
+		"Sessions in the cache after the last session reaper run.",
+	)
+)
+
+func init() {
+	metrics.Register(LoginLatency)
+	metrics.Register(SessionsReaped)
+	metrics.Register(SessionsReapedLastRun)
+	metrics.Register(SessionsStored)
 }
 
-type WebSocketManager interface {
-	BroadcastUserLogin(userID string, userData map[string]any)
+type UserController struct {
+	userRepo       repositories.UserRepository
+	sessionRepo    repositories.SessionRepository
+	loginEventRepo repositories.LoginEventRepository
+	preferenceRepo repositories.PreferenceRepository
+	auditRepo      repositories.AuditRepository
+	Config         config.Config
+	log            logger.Logger
+	wsManager      notifier.WebsocketNotifier
+	eventBus       *events.EventBus
+	middleware     middleware.Middleware
+	clock          clock.Clock
+	passwordGuard  *passwordGuard
+	loginLatency   *metrics.Histogram
+	validateLimit  fiber.Handler
+	challenge      ChallengeProvider
+	capacity       *Capacity
 }
 
 func New(
 	eventBus *events.EventBus,
 	userRepo repositories.UserRepository,
 	sessionRepo repositories.SessionRepository,
+	loginEventRepo repositories.LoginEventRepository,
+	preferenceRepo repositories.PreferenceRepository,
+	auditRepo repositories.AuditRepository,
+	middleware middleware.Middleware,
 	config config.Config,
 ) *UserController {
-	return &UserController{
-		userRepo:    userRepo,
-		sessionRepo: sessionRepo,
-		Config:      config,
-		log:         logger.New("userController"),
-		wsManager:   nil,
-		eventBus:    eventBus,
+	controller := &UserController{
+		userRepo:       userRepo,
+		sessionRepo:    sessionRepo,
+		loginEventRepo: loginEventRepo,
+		preferenceRepo: preferenceRepo,
+		auditRepo:      auditRepo,
+		Config:         config,
+		log:            logger.New("userController"),
+		wsManager:      nil,
+		eventBus:       eventBus,
+		middleware:     middleware,
+		clock:          clock.OrDefault(nil),
+		loginLatency:   LoginLatency,
+		challenge:      NoChallenge{},
 	}
+	controller.passwordGuard = newPasswordGuard(controller.clock, func(password string, hash string) (bool, error) {
+		return utils.VerifyPassword(password, hash, controller.Config)
+	})
+	return controller
 }
 
-func (c *UserController) SetWebSocketManager(wsManager WebSocketManager) {
+func (c *UserController) SetWebSocketManager(wsManager notifier.WebsocketNotifier) {
 	c.wsManager = wsManager
 }
 
+// SetCapacity limits the users that can register and the sessions that can
+// be started. Without it neither is limited.
+func (c *UserController) SetCapacity(capacity *Capacity) {
+	c.capacity = capacity
+}
+
 func (c *UserController) Login(
 	ctx context.Context,
 	loginRequest LoginRequest,
 ) (user User, session Session, err error) {
 	log := c.log.Function("Login")
-	userPtr, err := c.userRepo.GetByLogin(ctx, loginRequest.Login)
-	if err != nil {
+	ctx, span := tracing.Start(ctx, "UserController.Login")
+	defer func() { span.EndError(err) }()
+	started := time.Now()
+	defer func() {
+		// Being asked for a challenge is not a failed attempt, and counting
+		// it as one would keep the account asking
+		if !errors.Is(err, ErrChallengeRequired) {
+			c.recordLoginEvent(ctx, loginRequest, user.ID, err == nil)
+		}
+		if c.loginLatency != nil {
+			c.loginLatency.Observe(loginOutcome(err), time.Since(started))
+		}
+	}()
+
+	loginRequest.Login = NormalizeLogin(loginRequest.Login)
+	userPtr, lookupErr := c.userRepo.GetByLogin(ctx, loginRequest.Login)
+	if lookupErr != nil && !errors.Is(lookupErr, repositories.ErrNotFound) {
+		err = lookupErr
+		return
+	}
+
+	// Unknown logins are challenged too, so the challenge gives away nothing
+	// about which accounts exist
+	if err = c.checkChallenge(ctx, loginRequest, userPtr); err != nil {
+		if userPtr != nil {
+			user = *userPtr
+		}
+		return
+	}
+	if lookupErr != nil {
+		err = lookupErr
 		return
 	}
 	user = *userPtr
 
-	if err = c.comparePassword(loginRequest.Password, user.Password); err != nil {
+	previousPepper, err := c.verifyLoginPassword(user.Login, loginRequest.Password, user.Password)
+	if err != nil {
 		log.Warn("Login failed, password comparison failed", "userID", user.ID)
 		return
 	}
+	if previousPepper || user.PepperVersion != c.Config.PepperVersion() {
+		c.rehashPassword(ctx, &user, loginRequest.Password)
+	}
 
-	session.UserID = user.ID
-	if err = c.sessionRepo.Create(ctx, &session, c.Config); err != nil {
-		return
+	session, err = c.startSession(ctx, user, loginRequest.DeviceName, loginRequest.UserAgent)
+	return
+}
+
+// verifyLoginPassword checks a login's password through the guard, so bursts
+// of the same wrong password share one bcrypt comparison.
+func (c *UserController) verifyLoginPassword(login string, password string, hash string) (bool, error) {
+	if c.passwordGuard == nil {
+		return utils.VerifyPassword(password, hash, c.Config)
+	}
+	return c.passwordGuard.Verify(login, password, hash)
+}
+
+// loginOutcome labels a login for LoginLatency.
+func loginOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, repositories.ErrNotFound):
+		return "unknown_login"
+	case errors.Is(err, ErrChallengeRequired):
+		return "challenge_required"
+	case errors.Is(err, ErrChallengeFailed):
+		return "challenge_failed"
+	case errors.Is(err, ErrCapacityReached):
+		return "capacity_reached"
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return "invalid_password"
+	default:
+		return "error"
+	}
+}
+
+// startSession creates a session for a user who just proved who they are and
+// announces the login. It returns a CapacityError once the sessions are at
+// Security.MaxTotalSessions.
+func (c *UserController) startSession(
+	ctx context.Context,
+	user User,
+	deviceName string,
+	userAgent string,
+) (Session, error) {
+	if c.capacity != nil {
+		if err := c.capacity.Check(ctx, CAPACITY_SESSIONS); err != nil {
+			return Session{}, err
+		}
+	}
+
+	session := Session{
+		UserID:     user.ID,
+		DeviceName: NormalizeDeviceName(deviceName),
+		UserAgent:  userAgent,
+	}
+	if err := c.sessionRepo.Create(ctx, &session, c.Config); err != nil {
+		return Session{}, err
+	}
+	if c.capacity != nil {
+		c.capacity.Added(ctx, CAPACITY_SESSIONS)
 	}
 
 	// Broadcast user login event to WebSocket clients
-	if c.wsManager != nil {
+	if c.eventBus != nil || c.wsManager != nil {
 		go c.broadcastUserLogin(user)
 	}
 
-	return
+	return session, nil
 }
 
-func (c *UserController) Logout(sessionID string) (err error) {
+func (c *UserController) recordLoginEvent(
+	ctx context.Context,
+	loginRequest LoginRequest,
+	userID string,
+	success bool,
+) {
+	event := LoginEvent{
+		UserID:     userID,
+		IP:         loginRequest.IP,
+		UserAgent:  loginRequest.UserAgent,
+		ClientType: loginRequest.ClientType,
+		Success:    success,
+	}
+	if userID == "" {
+		event.AttemptedLogin = loginRequest.Login
+	}
+
+	if err := c.loginEventRepo.Create(ctx, &event); err != nil {
+		c.log.Function("recordLoginEvent").
+			Warn("failed to record login event", "userID", userID, "error", err)
+	}
+}
+
+// LoginHistory returns a page of the user's successful logins, after cursor
+// when it isn't empty.
+func (c *UserController) LoginHistory(
+	ctx context.Context,
+	userID string,
+	cursor string,
+) (LoginHistoryPage, error) {
+	events, next, err := c.loginEventRepo.ListSuccessful(ctx, userID, cursor)
+	if err != nil {
+		return LoginHistoryPage{}, err
+	}
+
+	return NewLoginHistoryPage(events, next), nil
+}
+
+// LookupUsers returns the users with ids keyed by ID, for lists that show
+// many users at once. Admins get every user's details, everyone else only
+// their public fields. IDs with no user are left out.
+func (c *UserController) LookupUsers(ctx context.Context, caller User, ids []string) (map[string]UserDTO, error) {
+	if len(ids) > USER_LOOKUP_MAX_IDS {
+		return nil, ErrTooManyLookupIDs
+	}
+
+	users, err := c.userRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]UserDTO, len(users))
+	for _, user := range users {
+		found[user.ID] = user.DTO(caller.IsAdmin)
+	}
+	return found, nil
+}
+
+// PruneLoginHistory removes login events past the retention window.
+func (c *UserController) PruneLoginHistory(ctx context.Context) error {
+	log := c.log.Function("PruneLoginHistory")
+
+	cutoff := clock.OrDefault(c.clock).Now().Add(-repositories.LOGIN_EVENT_RETENTION)
+	deleted, err := c.loginEventRepo.DeleteOlderThan(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		log.Info("Pruned login events", "deleted", deleted, "cutoff", cutoff)
+	}
+
+	return nil
+}
+
+// ReapSessions deletes sessions more than SESSION_REAP_GRACE past their
+// expiry, at most SESSION_REAP_LIMIT per run, and records the session
+// metrics.
+func (c *UserController) ReapSessions(ctx context.Context) error {
+	log := c.log.Function("ReapSessions")
+
+	cutoff := clock.OrDefault(c.clock).Now().Add(-SESSION_REAP_GRACE)
+	reaped, err := c.sessionRepo.ReapExpired(ctx, cutoff, repositories.SESSION_REAP_LIMIT)
+	SessionsReaped.Add(uint64(reaped))
+	SessionsReapedLastRun.Set(float64(reaped))
+	if err != nil {
+		return err
+	}
+	if reaped > 0 {
+		log.Info("Reaped expired sessions", "reaped", reaped, "cutoff", cutoff)
+	}
+
+	stored, err := c.sessionRepo.CountActive(ctx)
+	if err != nil {
+		return err
+	}
+	SessionsStored.Set(float64(stored))
+
+	return nil
+}
+
+// Logout ends the session and tells other components, like the websocket
+// hub, that the user logged out.
+func (c *UserController) Logout(sessionID string, userID string) (err error) {
 	ctx := context.Background()
 	if err = c.sessionRepo.Delete(ctx, sessionID); err != nil {
 		return
 	}
+
+	c.middleware.ForgetAuth(sessionID)
+	c.publishLogout(ctx, userID, sessionID)
 	return
 }
 
-// TODO: implement
-func (c *UserController) Register(user User) (err error) {
-	ctx := context.Background()
-	if err = c.userRepo.Create(ctx, &user, c.Config); err != nil {
+// publishLogout tells other components a session ended before it expired,
+// or all of the user's when sessionID is empty, so that nothing issued under
+// it, like websocket resume tokens, outlives it.
+func (c *UserController) publishLogout(ctx context.Context, userID string, sessionID string) {
+	if c.eventBus == nil {
 		return
 	}
-	return
+	if err := c.eventBus.UserLogoutTopic().Publish(ctx, events.UserLogoutEvent{
+		UserID:    userID,
+		SessionID: sessionID,
+	}); err != nil {
+		c.log.Function("publishLogout").Er("failed to publish user logout event", err, "userID", userID)
+	}
 }
 
-func (c *UserController) comparePassword(password, hashedPassword string) error {
-	password = password + c.Config.SecurityPepper
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+// ListSessions returns the user's live sessions, newest first.
+func (c *UserController) ListSessions(
+	ctx context.Context,
+	userID string,
+	currentSessionID string,
+) ([]SessionDTO, error) {
+	sessions, err := c.sessionRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	listed := make([]SessionDTO, 0, len(sessions))
+	for _, session := range sessions {
+		listed = append(listed, session.DTO(currentSessionID))
+	}
+	return listed, nil
+}
+
+// RevokeSession ends one of the user's sessions. Sessions that belong to
+// someone else report ErrSessionNotFound, like missing ones, so IDs can't be
+// probed; failing to look the session up is returned as it is.
+func (c *UserController) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	session, err := c.sessionRepo.GetByID(ctx, sessionID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return ErrSessionNotFound
+	}
+
+	if err := c.sessionRepo.Delete(ctx, sessionID); err != nil {
+		return err
+	}
+	c.middleware.ForgetAuth(sessionID)
+	c.publishLogout(ctx, userID, sessionID)
+	return nil
+}
+
+// StopImpersonation ends an impersonation session and returns the admin's own
+// session to go back to. The returned session is empty when the admin's has
+// since ended, in which case they are simply logged out.
+func (c *UserController) StopImpersonation(ctx context.Context, session Session, ip string) (Session, error) {
+	log := c.log.Function("StopImpersonation")
+
+	if !session.Impersonated() {
+		return Session{}, ErrNotImpersonating
+	}
+
+	if err := c.sessionRepo.Delete(ctx, session.ID); err != nil {
+		return Session{}, log.Err("failed to end impersonation session", err, "sessionID", session.ID)
+	}
+	c.middleware.ForgetAuth(session.ID)
+
+	if c.eventBus != nil {
+		if err := c.eventBus.ImpersonationTopic().Publish(ctx, events.ImpersonationEvent{
+			Action:    events.IMPERSONATION_STOPPED,
+			AdminID:   session.ImpersonatedBy,
+			UserID:    session.UserID,
+			SessionID: session.ID,
+			IP:        ip,
+		}); err != nil {
+			log.Er("failed to publish impersonation audit event", err, "sessionID", session.ID)
+		}
+	}
+	c.recordAudit(ctx, AuditLog{
+		Action:   AUDIT_ACTION_IMPERSONATION_STOPPED,
+		Source:   AUDIT_SOURCE_API,
+		ActorID:  session.ImpersonatedBy,
+		TargetID: session.UserID,
+		IP:       ip,
+		Details:  map[string]any{"sessionId": session.ID},
+	})
+	log.Info("Impersonation stopped", "adminID", session.ImpersonatedBy, "userID", session.UserID, "sessionID", session.ID)
+
+	adminSession, err := c.sessionRepo.GetByID(ctx, session.ImpersonatorSessionID)
+	if err != nil || adminSession.UserID != session.ImpersonatedBy ||
+		adminSession.ExpiresAt.Before(clock.OrDefault(c.clock).Now()) {
+		return Session{}, nil
+	}
+	return *adminSession, nil
+}
+
+// recordAudit stores an audit entry. A failure is logged rather than undoing
+// the action it describes.
+func (c *UserController) recordAudit(ctx context.Context, entry AuditLog) {
+	if c.auditRepo == nil {
+		return
+	}
+	if err := c.auditRepo.Create(ctx, &entry); err != nil {
+		c.log.Function("recordAudit").Er("failed to record audit entry", err, "action", entry.Action)
+	}
+}
+
+// RevokeOtherSessions ends every session of the user except currentSessionID
+// and returns how many were revoked.
+func (c *UserController) RevokeOtherSessions(
+	ctx context.Context,
+	userID string,
+	currentSessionID string,
+) (int, error) {
+	log := c.log.Function("RevokeOtherSessions")
+
+	sessions, err := c.sessionRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == currentSessionID {
+			continue
+		}
+		if err := c.sessionRepo.Delete(ctx, session.ID); err != nil {
+			return revoked, log.Err("failed to revoke session", err, "userID", userID, "sessionID", session.ID)
+		}
+		c.middleware.ForgetAuth(session.ID)
+		c.publishLogout(ctx, userID, session.ID)
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// ExtendSession pushes the session's expiry out by a full session length,
+// but no later than Session.MaxLifetime after the user logged in. Past that
+// it returns ErrSessionMaxLifetime and the user has to log in again. An
+// expiry already later than the extension is left alone.
+func (c *UserController) ExtendSession(ctx context.Context, session Session) (Session, error) {
+	log := c.log.Function("ExtendSession")
+
+	now := clock.OrDefault(c.clock).Now()
+	limit := session.LoggedInAt().Add(c.Config.SessionMaxLifetime())
+	if !now.Before(limit) {
+		return Session{}, ErrSessionMaxLifetime
+	}
+
+	expiresAt := now.Add(repositories.SESSION_EXPIRY)
+	if expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	if !expiresAt.After(session.ExpiresAt) {
+		return session, nil
+	}
+
+	if err := c.sessionRepo.Extend(ctx, &session, expiresAt, c.Config); err != nil {
+		return Session{}, log.Err("failed to extend session", err, "sessionID", session.ID)
+	}
+	return session, nil
+}
+
+// Register creates the user and, unless Security.RegistrationAutoLogin is off,
+// logs them in the way Login does. The session is empty when no login
+// happened; failing to start one doesn't undo the registration. Once the
+// users are at Security.MaxUsers it returns a CapacityError.
+func (c *UserController) Register(
+	ctx context.Context,
+	registerRequest RegisterRequest,
+) (user User, session Session, err error) {
+	log := c.log.Function("Register")
+	ctx, span := tracing.Start(ctx, "UserController.Register")
+	defer func() { span.EndError(err) }()
+
+	if c.Config.Security.FormTokenEnabled {
+		if err = utils.VerifyFormToken(registerRequest.FormToken, c.Config, c.clock); err != nil {
+			log.Warn("Registration rejected, bad form token", "ip", registerRequest.IP, "error", err)
+			return
+		}
+	}
+
+	if err = c.checkTerms(registerRequest.TosVersion); err != nil {
+		log.Warn("Registration rejected, terms not accepted", "tosVersion", registerRequest.TosVersion)
+		return
+	}
+
+	if c.capacity != nil {
+		if err = c.capacity.Check(ctx, CAPACITY_USERS); err != nil {
+			return
+		}
+	}
+
+	user, err = c.createUser(ctx, registerRequest, false)
+	if err != nil {
+		return User{}, session, err
+	}
+	if c.capacity != nil {
+		c.capacity.Added(ctx, CAPACITY_USERS)
+	}
+
+	if c.eventBus != nil {
+		if err := c.eventBus.UserRegisteredTopic().Publish(ctx, events.UserRegisteredEvent{
+			UserID:    user.ID,
+			Login:     user.Login,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+		}); err != nil {
+			log.Er("failed to publish user registered event", err, "userID", user.ID)
+		}
+	}
+
+	if !c.Config.Security.RegistrationAutoLogin {
+		return user, session, nil
+	}
+
+	session, err = c.startSession(ctx, user, registerRequest.DeviceName, registerRequest.UserAgent)
+	c.recordLoginEvent(ctx, LoginRequest{
+		IP:         registerRequest.IP,
+		UserAgent:  registerRequest.UserAgent,
+		ClientType: registerRequest.ClientType,
+	}, user.ID, err == nil)
+	if err != nil {
+		log.Er("failed to start session after registering", err, "userID", user.ID)
+		return user, Session{}, nil
+	}
+
+	return user, session, nil
+}
+
+// CreateAdmin creates an admin user, checked and hashed the way Register
+// does. It is for bootstrapping a deployment, so nobody is logged in.
+func (c *UserController) CreateAdmin(ctx context.Context, registerRequest RegisterRequest) (User, error) {
+	return c.createUser(ctx, registerRequest, true)
+}
+
+// createUser applies the registration rules and stores the user.
+func (c *UserController) createUser(ctx context.Context, registerRequest RegisterRequest, isAdmin bool) (User, error) {
+	log := c.log.Function("createUser")
+
+	registerRequest.Login = NormalizeLogin(registerRequest.Login)
+	if err := checkRegistrationLogin(registerRequest); err != nil {
+		return User{}, err
+	}
+	if err := c.checkRegistrationPassword(registerRequest); err != nil {
+		return User{}, err
+	}
+
+	_, err := c.userRepo.GetByLogin(ctx, registerRequest.Login)
+	switch {
+	case err == nil:
+		log.Warn("Registration rejected, login already exists", "login", registerRequest.Login)
+		return User{}, ErrLoginTaken
+	case !errors.Is(err, repositories.ErrNotFound):
+		return User{}, log.Err("failed to check for existing login", err, "login", registerRequest.Login)
+	}
+
+	hashedPassword, err := utils.HashPassword(registerRequest.Password, c.Config)
+	if err != nil {
+		return User{}, log.Err("failed to hash password", err, "login", registerRequest.Login)
+	}
+
+	user := User{
+		Login:         registerRequest.Login,
+		Password:      hashedPassword,
+		PepperVersion: c.Config.PepperVersion(),
+		FirstName:     registerRequest.FirstName,
+		LastName:      registerRequest.LastName,
+		IsAdmin:       isAdmin,
+	}
+	if c.Config.TermsVersion != "" && registerRequest.TosVersion == c.Config.TermsVersion {
+		acceptedAt := clock.OrDefault(c.clock).Now().UTC()
+		user.TosVersion = registerRequest.TosVersion
+		user.TosAcceptedAt = &acceptedAt
+	}
+	// The check above is only a fast path: a concurrent registration for the
+	// same login can pass it too, and then loses at the unique index.
+	err = c.userRepo.Create(ctx, &user, c.Config)
+	if errors.Is(err, repositories.ErrDuplicate) {
+		log.Warn("Registration rejected, login taken concurrently", "login", registerRequest.Login)
+		return User{}, ErrLoginTaken
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// checkTerms reports whether version is the current terms of service
+// version. Anything passes while no terms are configured.
+func (c *UserController) checkTerms(version string) error {
+	if c.Config.TermsVersion == "" || version == c.Config.TermsVersion {
+		return nil
+	}
+	return ErrTermsOutdated
+}
+
+// AcceptTerms records that the user accepted the current terms of service,
+// lifting the hold BasicAuth puts on their writes once the version is bumped.
+func (c *UserController) AcceptTerms(ctx context.Context, user User, version string) (User, error) {
+	log := c.log.Function("AcceptTerms")
+
+	if c.Config.TermsVersion == "" || version != c.Config.TermsVersion {
+		return User{}, ErrTermsOutdated
+	}
+
+	// Update saves every column, so start from the stored row rather than
+	// the cached user, which has no password hash.
+	storedUser, err := c.userRepo.GetByLogin(ctx, user.Login)
 	if err != nil {
+		return User{}, log.Err("failed to load user", err, "userID", user.ID)
+	}
+
+	before := *storedUser
+	acceptedAt := clock.OrDefault(c.clock).Now().UTC()
+	storedUser.TosVersion = version
+	storedUser.TosAcceptedAt = &acceptedAt
+	if err := c.userRepo.Update(ctx, storedUser); err != nil {
+		return User{}, log.Err("failed to record terms acceptance", err, "userID", user.ID)
+	}
+	c.publishUserUpdated(ctx, before, *storedUser)
+
+	log.Info("Terms of service accepted", "userID", user.ID, "tosVersion", version)
+	return *storedUser, nil
+}
+
+// checkRegistrationLogin applies the field rules for a new login, which
+// callers have normalized.
+func checkRegistrationLogin(registerRequest RegisterRequest) error {
+	return ValidateLogin(registerRequest.Login)
+}
+
+// checkRegistrationPassword applies the field and strength rules for a new
+// user's password.
+func (c *UserController) checkRegistrationPassword(registerRequest RegisterRequest) error {
+	if registerRequest.Password == "" {
+		return utils.NewValidationError("password is required", "password", map[string]any{
+			"code": "required",
+		})
+	}
+
+	return utils.ValidatePassword(
+		"password",
+		registerRequest.Password,
+		c.Config,
+		registerRequest.Login,
+		registerRequest.FirstName,
+		registerRequest.LastName,
+	)
+}
+
+// ValidateRegistration runs the checks Register would on every field and
+// reports each outcome, without creating anything. Whether the login is
+// taken is only answered after a random delay of up to LOGIN_CHECK_JITTER,
+// so response times don't tell the two apart.
+func (c *UserController) ValidateRegistration(
+	ctx context.Context,
+	registerRequest RegisterRequest,
+) (RegistrationValidation, error) {
+	log := c.log.Function("ValidateRegistration")
+
+	registerRequest.Login = NormalizeLogin(registerRequest.Login)
+	result := RegistrationValidation{Valid: true}
+	result.Set("login", checkRegistrationLogin(registerRequest))
+	result.Set("password", c.checkRegistrationPassword(registerRequest))
+	if !result.Fields["login"].OK {
+		return result, nil
+	}
+
+	_, err := c.userRepo.GetByLogin(ctx, registerRequest.Login)
+	jitter(ctx, LOGIN_CHECK_JITTER)
+	switch {
+	case err == nil:
+		result.Set("login", utils.NewValidationError("login is already taken", "login", map[string]any{
+			"code":    "login_taken",
+			"message": ErrLoginTaken.Error(),
+		}))
+	case !errors.Is(err, repositories.ErrNotFound):
+		return RegistrationValidation{}, log.Err("failed to check for existing login", err, "login", registerRequest.Login)
+	}
+	return result, nil
+}
+
+// jitter waits a random time up to max, or until ctx is done.
+func jitter(ctx context.Context, max time.Duration) {
+	timer := time.NewTimer(rand.N(max))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// FormToken issues a token for the registration form to send back.
+func (c *UserController) FormToken() (string, time.Time, error) {
+	return utils.GenerateFormToken(c.Config, c.clock)
+}
+
+// HoneypotTripped reports whether a form filled in the honeypot field, and
+// logs the bot if so. It is always false when the honeypot is turned off.
+func (c *UserController) HoneypotTripped(form string, website string, ip string, userAgent string) bool {
+	if !c.Config.Security.HoneypotEnabled || website == "" {
+		return false
+	}
+
+	c.log.Function("HoneypotTripped").
+		Warn("Bot submission discarded", "form", form, "reason", "honeypot", "ip", ip, "userAgent", userAgent)
+	return true
+}
+
+// decoyUser is what a bot that tripped the honeypot gets back in place of a
+// real account, so the response looks like a success.
+func (c *UserController) decoyUser(login string, firstName string, lastName string) User {
+	id, _ := uuid.NewV7()
+	now := clock.OrDefault(c.clock).Now().UTC()
+	return User{
+		BaseModel: BaseModel{ID: id.String(), CreatedAt: now, UpdatedAt: now},
+		Login:     login,
+		FirstName: firstName,
+		LastName:  lastName,
+		Version:   1,
+	}
+}
+
+// UpdateProfile applies a partial profile update. The precondition decides
+// whether the write is conditional; a stale one comes back as a
+// *repositories.VersionConflictError.
+func (c *UserController) UpdateProfile(
+	ctx context.Context,
+	user User,
+	updateRequest UpdateProfileRequest,
+	precondition utils.Precondition,
+) (User, error) {
+	log := c.log.Function("UpdateProfile")
+
+	storedUser, err := c.userRepo.GetByID(ctx, user.ID)
+	if err != nil {
+		return User{}, log.Err("failed to load user", err, "userID", user.ID)
+	}
+
+	expectedVersion, ok := precondition.ExpectedVersion(storedUser.Version, storedUser.UpdatedAt)
+	if !ok {
+		return User{}, &repositories.VersionConflictError{Current: storedUser.Version}
+	}
+
+	before := *storedUser
+	if err := updateRequest.Apply(storedUser); err != nil {
+		return User{}, err
+	}
+
+	if err := c.userRepo.UpdateProfile(ctx, storedUser, expectedVersion); err != nil {
+		return User{}, err
+	}
+	c.publishUserUpdated(ctx, before, *storedUser)
+
+	return *storedUser, nil
+}
+
+func (c *UserController) ChangePassword(
+	ctx context.Context,
+	user User,
+	changeRequest ChangePasswordRequest,
+) error {
+	log := c.log.Function("ChangePassword")
+
+	// The user in the request locals comes from the cache, which never holds
+	// the password hash, so reload it from the database.
+	storedUser, err := c.userRepo.GetByLogin(ctx, user.Login)
+	if err != nil {
+		return log.Err("failed to load user", err, "userID", user.ID)
+	}
+
+	if err := c.comparePassword(changeRequest.CurrentPassword, storedUser.Password); err != nil {
+		log.Warn("Password change rejected, current password mismatch", "userID", user.ID)
+		return utils.NewValidationError(
+			"current password is incorrect",
+			"currentPassword",
+			map[string]any{"code": "invalid"},
+		)
+	}
+
+	return c.setPassword(ctx, storedUser, "newPassword", changeRequest.NewPassword)
+}
+
+// ResetPassword sets a new password without asking for the current one, for
+// operators recovering an account. It must pass the rules ChangePassword
+// applies.
+func (c *UserController) ResetPassword(ctx context.Context, login string, password string) (User, error) {
+	storedUser, err := c.userRepo.GetByLogin(ctx, login)
+	if err != nil {
+		return User{}, err
+	}
+
+	if err := c.setPassword(ctx, storedUser, "password", password); err != nil {
+		return User{}, err
+	}
+	return *storedUser, nil
+}
+
+// Promote makes the user an admin. Promoting an admin changes nothing.
+func (c *UserController) Promote(ctx context.Context, login string) (User, error) {
+	log := c.log.Function("Promote")
+
+	storedUser, err := c.userRepo.GetByLogin(ctx, login)
+	if err != nil {
+		return User{}, err
+	}
+	if storedUser.IsAdmin {
+		return *storedUser, nil
+	}
+
+	before := *storedUser
+	storedUser.IsAdmin = true
+	if err := c.userRepo.Update(ctx, storedUser); err != nil {
+		return User{}, log.Err("failed to promote user", err, "userID", storedUser.ID)
+	}
+	c.publishUserUpdated(ctx, before, *storedUser)
+
+	log.Info("User promoted to admin", "userID", storedUser.ID)
+	return *storedUser, nil
+}
+
+// publishUserUpdated tells the user's open clients which of the fields they
+// show changed, so they fetch the user again.
+func (c *UserController) publishUserUpdated(ctx context.Context, before User, after User) {
+	fields := after.ChangedFields(before)
+	if c.eventBus == nil || len(fields) == 0 {
+		return
+	}
+	if err := c.eventBus.UserUpdatedTopic().Publish(ctx, events.UserUpdatedEvent{
+		UserID: after.ID,
+		Fields: fields,
+	}); err != nil {
+		c.log.Function("publishUserUpdated").Er("failed to publish user updated event", err, "userID", after.ID)
+	}
+}
+
+// setPassword checks password against the strength rules, reported under
+// field, then hashes and stores it with the current pepper.
+func (c *UserController) setPassword(ctx context.Context, storedUser *User, field string, password string) error {
+	log := c.log.Function("setPassword")
+
+	if err := utils.ValidatePassword(
+		field,
+		password,
+		c.Config,
+		storedUser.Login,
+		storedUser.FirstName,
+		storedUser.LastName,
+	); err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(password, c.Config)
+	if err != nil {
+		return log.Err("failed to hash password", err, "userID", storedUser.ID)
+	}
+
+	storedUser.Password = hashedPassword
+	storedUser.PepperVersion = c.Config.PepperVersion()
+	if err := c.userRepo.Update(ctx, storedUser); err != nil {
+		return log.Err("failed to update password", err, "userID", storedUser.ID)
+	}
+
+	// Anything that stands in for the old password, like a resume token,
+	// goes with it
+	c.publishLogout(ctx, storedUser.ID, "")
+	return nil
+}
+
+// Export gathers everything stored about the user but their login history,
+// which WriteExport reads as it writes the document.
+func (c *UserController) Export(ctx context.Context, user User) (UserExport, error) {
+	log := c.log.Function("Export")
+
+	sessions, err := c.sessionRepo.ListByUser(ctx, user.ID)
+	if err != nil {
+		return UserExport{}, log.Err("failed to list sessions", err, "userID", user.ID)
+	}
+
+	preferences, err := c.Preferences(ctx, user.ID)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	export := UserExport{
+		ExportedAt:  NewAPITime(clock.OrDefault(c.clock).Now()),
+		Profile:     user.DTO(true),
+		Sessions:    make([]SessionSummary, 0, len(sessions)),
+		Preferences: preferences,
+		Activity:    []map[string]any{},
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, session.Summary())
+	}
+
+	return export, nil
+}
+
+// WriteExport writes export to w as one JSON document, streaming the user's
+// login history in as its last key a batch at a time, so a long history is
+// never held in memory. It returns how many logins were written. Once
+// anything is written the document can't be taken back, so a failure part
+// way leaves it unterminated, which no JSON reader mistakes for a whole one.
+func (c *UserController) WriteExport(ctx context.Context, w *bufio.Writer, export UserExport) (int64, error) {
+	var head bytes.Buffer
+	if err := json.NewEncoder(&head).Encode(export); err != nil {
+		return 0, err
+	}
+	// Reopen the document, dropping its closing brace and newline, for the
+	// login history to follow the last key
+	head.Truncate(head.Len() - len("}\n"))
+	head.WriteString(`,"loginHistory":[`)
+	if _, err := w.Write(head.Bytes()); err != nil {
+		return 0, err
+	}
+
+	encoder := json.NewEncoder(w)
+	written := false
+	streamed, err := c.loginEventRepo.StreamByUser(ctx, export.Profile.ID, func(events []LoginEvent) error {
+		for _, event := range events {
+			if written {
+				if err := w.WriteByte(','); err != nil {
+					return err
+				}
+			}
+			if err := encoder.Encode(event); err != nil {
+				return err
+			}
+			written = true
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return streamed, err
+	}
+
+	if _, err := w.WriteString("]}\n"); err != nil {
+		return streamed, err
+	}
+	return streamed, w.Flush()
+}
+
+// Preferences returns every stored preference keyed by name, values as the
+// JSON the user saved.
+func (c *UserController) Preferences(ctx context.Context, userID string) (map[string]any, error) {
+	stored, err := c.preferenceRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	preferences := make(map[string]any, len(stored))
+	for _, preference := range stored {
+		preferences[preference.Key] = json.RawMessage(preference.Value)
+	}
+	return preferences, nil
+}
+
+// SetPreference validates value against key's schema, if it has one, and
+// stores it.
+func (c *UserController) SetPreference(ctx context.Context, userID string, key string, value []byte) error {
+	if err := ValidatePreference(key, value); err != nil {
 		return err
 	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, value); err != nil {
+		return err
+	}
+
+	return c.preferenceRepo.Set(ctx, &UserPreference{
+		UserID: userID,
+		Key:    key,
+		Value:  compact.String(),
+	})
+}
+
+func (c *UserController) DeletePreference(ctx context.Context, userID string, key string) error {
+	return c.preferenceRepo.Delete(ctx, userID, key)
+}
+
+// DeleteAccount soft deletes the user after confirming their password, revokes
+// every session and scrubs identifying data from their login history.
+func (c *UserController) DeleteAccount(
+	ctx context.Context,
+	user User,
+	deleteRequest DeleteAccountRequest,
+) error {
+	log := c.log.Function("DeleteAccount")
+
+	storedUser, err := c.userRepo.GetByLogin(ctx, user.Login)
+	if err != nil {
+		return log.Err("failed to load user", err, "userID", user.ID)
+	}
+
+	if deleteRequest.Password == "" ||
+		c.comparePassword(deleteRequest.Password, storedUser.Password) != nil {
+		log.Warn("Account deletion rejected, password confirmation failed", "userID", user.ID)
+		return ErrReauthenticationFailed
+	}
+
+	// Deleting the user clears the user ID from their login events, after
+	// which they can no longer be found to scrub. Preferences go with the user.
+	if _, err := c.loginEventRepo.AnonymizeByUser(ctx, storedUser.ID); err != nil {
+		return log.Err("failed to anonymize login history", err, "userID", user.ID)
+	}
+
+	if err := c.userRepo.Delete(ctx, storedUser.ID); err != nil {
+		return log.Err("failed to delete user", err, "userID", user.ID)
+	}
+
+	if err := c.sessionRepo.DeleteByUser(ctx, storedUser.ID); err != nil {
+		return log.Err("failed to revoke sessions", err, "userID", user.ID)
+	}
+	c.middleware.ForgetUserAuth(storedUser.ID)
+	c.publishLogout(ctx, storedUser.ID, "")
+
+	if c.eventBus != nil {
+		if err := c.eventBus.PublishUserDeleted(storedUser.ID); err != nil {
+			log.Er("failed to publish user deleted event", err, "userID", user.ID)
+		}
+	}
+
+	log.Info("User deleted their account", "userID", user.ID)
 	return nil
 }
 
-// broadcastUserLogin sends a login event to WebSocket clients
+func (c *UserController) comparePassword(password, hashedPassword string) error {
+	_, err := utils.VerifyPassword(password, hashedPassword, c.Config)
+	return err
+}
+
+// rehashPassword moves a user who just logged in onto the current pepper. A
+// failure only leaves them on the old one until their next login.
+func (c *UserController) rehashPassword(ctx context.Context, user *User, password string) {
+	log := c.log.Function("rehashPassword")
+
+	hashedPassword, err := utils.HashPassword(password, c.Config)
+	if err != nil {
+		log.Er("failed to rehash password", err, "userID", user.ID)
+		return
+	}
+
+	user.Password = hashedPassword
+	user.PepperVersion = c.Config.PepperVersion()
+	if err := c.userRepo.Update(ctx, user); err != nil {
+		log.Er("failed to store rehashed password", err, "userID", user.ID)
+		return
+	}
+	log.Info("Password rehashed with the current pepper", "userID", user.ID, "pepperVersion", user.PepperVersion)
+}
+
+// broadcastUserLogin publishes the login on the event bus, which the
+// websocket manager relays to clients. A directly attached WebsocketNotifier
+// is notified as well.
 func (c *UserController) broadcastUserLogin(user User) {
 	log := c.log.Function("broadcastUserLogin")
 
-	userData := map[string]any{
-		"userId":    user.ID,
-		"firstName": user.FirstName,
-		"lastName":  user.LastName,
-		"login":     user.Login,
-		"isAdmin":   user.IsAdmin,
-		"loginTime": time.Now().Unix(),
+	event := events.UserLoginEvent{
+		UserID:    user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Login:     user.Login,
+		IsAdmin:   user.IsAdmin,
+		LoginTime: clock.OrDefault(c.clock).Now().Unix(),
 	}
 
 	log.Info("Broadcasting user login event", "userID", user.ID, "login", user.Login)
+	if c.eventBus != nil {
+		if err := c.eventBus.UserLoginTopic().Publish(context.Background(), event); err != nil {
+			log.Er("failed to publish user login event", err, "userID", user.ID)
+		}
+	}
+
 	if c.wsManager != nil {
-		c.wsManager.BroadcastUserLogin(user.ID, userData)
+		c.wsManager.BroadcastUserLogin(user.ID, map[string]any{
+			"userId":    event.UserID,
+			"firstName": event.FirstName,
+			"lastName":  event.LastName,
+			"login":     event.Login,
+			"isAdmin":   event.IsAdmin,
+			"loginTime": event.LoginTime,
+		})
 	}
 }
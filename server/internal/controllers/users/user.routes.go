@@ -0,0 +1,654 @@
+package userController
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"server/config"
+	"server/internal/apierror"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"server/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	ErrorCodeSessionMaxLifetime = "session_max_lifetime"
+	// Token clients get a new token when their session is due a refresh,
+	// so there is nothing for them to extend
+	ErrorCodeSessionExtendCookieOnly = "session_extend_cookie_only"
+	// A registration or acceptance naming terms other than the current ones
+	ErrorCodeTermsOutdated = "tos_outdated"
+	// A risky login without a challenge token, or with one that failed
+	ErrorCodeChallengeRequired = "challenge_required"
+	ErrorCodeChallengeFailed   = "challenge_failed"
+	// Registration or login past Security.MaxUsers or MaxTotalSessions
+	ErrorCodeCapacityReached = "capacity_reached"
+	// A user lookup naming more than USER_LOOKUP_MAX_IDS users
+	ErrorCodeTooManyLookupIDs = "too_many_ids"
+)
+
+func init() {
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeSessionMaxLifetime, Status: fiber.StatusUnauthorized, Title: "Session reached its maximum lifetime",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeSessionExtendCookieOnly, Status: fiber.StatusBadRequest, Title: "Only cookie sessions can be extended",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeTermsOutdated, Status: fiber.StatusUnprocessableEntity, Title: "Terms of service version is outdated",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeChallengeRequired, Status: fiber.StatusForbidden, Title: "Login requires a challenge",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeChallengeFailed, Status: fiber.StatusForbidden, Title: "Login challenge failed",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeCapacityReached, Status: fiber.StatusInsufficientStorage, Title: "Capacity reached",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeTooManyLookupIDs, Status: fiber.StatusBadRequest, Title: "Too many user IDs",
+	})
+}
+
+func (c *UserController) RegisterRoutes(router fiber.Router) {
+	users := router.Group("/users")
+	users.Post("/login", c.handleLogin)
+	users.Post("/register", c.handleRegister)
+	// Shared by the v1 and legacy mounts so the alias doesn't double it
+	if c.validateLimit == nil {
+		c.validateLimit = c.middleware.RateLimit("users/validate", c.Config.ValidateRateLimit(), time.Minute)
+	}
+	users.Post("/validate", c.validateLimit, c.handleValidateRegistration)
+	users.Get("/form-token", c.handleFormToken)
+
+	users.Use(c.middleware.BasicAuth(), c.middleware.LimitUserConcurrency(), c.middleware.AuthNoContent())
+	users.Get("/", c.handleGetUser)
+	users.Post("/lookup", c.handleLookupUsers)
+	users.Post("/logout", c.handleLogout)
+	users.Post("/stop-impersonation", c.handleStopImpersonation)
+	users.Post("/session/extend", c.handleExtendSession)
+	users.Post("/password", c.handleChangePassword)
+	users.Get("/me/logins", c.handleLoginHistory)
+	users.Get("/me/export", c.handleExport)
+	users.Patch("/me", c.handleUpdateProfile)
+	users.Delete("/me", c.handleDeleteAccount)
+	users.Post("/me/accept-tos", c.handleAcceptTerms)
+	users.Get("/me/preferences", c.handleGetPreferences)
+	users.Put("/me/preferences/:key", c.handleSetPreference)
+	users.Delete("/me/preferences/:key", c.handleDeletePreference)
+	users.Get("/sessions", c.handleListSessions)
+	users.Delete("/sessions/:id", c.handleRevokeSession)
+	users.Post("/sessions/revoke-others", c.handleRevokeOtherSessions)
+}
+
+func (c *UserController) handleGetUser(ctx *fiber.Ctx) error {
+	user := ctx.Locals("user").(User)
+	session := ctx.Locals("session").(Session)
+	if user.ID == "" {
+		c.log.Function("handleGetUser").ErMsg("No user found in locals")
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "failed to get user"})
+	}
+
+	utils.ApplyToken(ctx, session.Token) // TODO: Why is this needed? Wouldn't the middleware do this?
+
+	return ctx.JSON(fiber.Map{"message": "User logged in", "user": user.DTO(true)})
+}
+
+func (c *UserController) handleLookupUsers(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleLookupUsers")
+
+	var lookupRequest UserLookupRequest
+	if err := ctx.BodyParser(&lookupRequest); err != nil {
+		log.Er("failed to parse user lookup request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse user lookup request"})
+	}
+
+	user := ctx.Locals("user").(User)
+	users, err := c.LookupUsers(ctx.Context(), user, lookupRequest.IDs)
+	if errors.Is(err, ErrTooManyLookupIDs) {
+		return apierror.Send(ctx, apierror.New(
+			ErrorCodeTooManyLookupIDs,
+			fmt.Sprintf("At most %d users can be looked up at once", USER_LOOKUP_MAX_IDS),
+		).With("max", USER_LOOKUP_MAX_IDS), c.Config)
+	}
+	if err != nil {
+		log.Er("failed to look up users", err, "userID", user.ID, "count", len(lookupRequest.IDs))
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to look up users"})
+	}
+
+	return ctx.JSON(fiber.Map{"users": users})
+}
+
+func (c *UserController) handleLogout(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleLogout")
+	user := ctx.Locals("user").(User)
+
+	// Token clients have no cookie; their session came from the token.
+	var sessionID string
+	// BasicAuth has already rejected malformed client headers
+	if client, _ := middleware.GetClientInfo(ctx, c.Config); client.Strategy == config.AUTH_STRATEGY_TOKEN {
+		session, _ := ctx.Locals("session").(Session)
+		sessionID = session.ID
+	} else {
+		sessionID = ctx.Cookies(SESSION_COOKIE_KEY)
+		utils.ExpireCookie(ctx, SESSION_COOKIE_KEY, c.Config)
+	}
+
+	err := c.Logout(sessionID, user.ID)
+	if err != nil {
+		log.Er("failed to logout", err)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "failed to logout"})
+
+	}
+
+	return ctx.JSON(fiber.Map{"message": "User logged out"})
+}
+
+func (c *UserController) handleLogin(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleLogin")
+
+	var loginRequest LoginRequest
+	if err := ctx.BodyParser(&loginRequest); err != nil {
+		log.Er("failed to parse login request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse login request"})
+	}
+
+	loginRequest.IP = ctx.IP()
+	loginRequest.UserAgent = ctx.Get(fiber.HeaderUserAgent)
+	loginRequest.ClientType = ctx.Get(middleware.CLIENT_TYPE_HEADER)
+
+	if c.HoneypotTripped("login", loginRequest.Website, loginRequest.IP, loginRequest.UserAgent) {
+		return ctx.JSON(fiber.Map{"message": "User logged in", "user": c.decoyUser(loginRequest.Login, "", "").DTO(true)})
+	}
+
+	user, session, err := c.Login(ctx.Context(), loginRequest)
+	if errors.Is(err, ErrChallengeRequired) {
+		return apierror.Send(ctx, apierror.New(
+			ErrorCodeChallengeRequired,
+			"Pass the challenge and send its token as challengeToken",
+		).With("challenge", c.challengeDescriptor()), c.Config)
+	}
+	if errors.Is(err, ErrChallengeFailed) {
+		log.Warn("login challenge failed", "ip", loginRequest.IP, "error", err)
+		return apierror.Send(ctx, apierror.New(
+			ErrorCodeChallengeFailed,
+			"The challenge token was not accepted",
+		).With("challenge", c.challengeDescriptor()), c.Config)
+	}
+	if errors.Is(err, ErrCapacityReached) {
+		return c.capacityReached(ctx, err)
+	}
+	if err != nil {
+		log.Er("failed to login", err)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to login"})
+	}
+
+	applySessionResponse(ctx, session, c.Config)
+
+	return ctx.JSON(fiber.Map{"message": "User logged in", "user": user.DTO(true)})
+}
+
+func (c *UserController) handleRegister(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleRegister")
+
+	var registerRequest RegisterRequest
+	if err := ctx.BodyParser(&registerRequest); err != nil {
+		log.Er("failed to parse register request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse register request"})
+	}
+
+	registerRequest.IP = ctx.IP()
+	registerRequest.UserAgent = ctx.Get(fiber.HeaderUserAgent)
+	registerRequest.ClientType = ctx.Get(middleware.CLIENT_TYPE_HEADER)
+
+	// The new user is served by GET /users/, next to this route.
+	newUser := utils.ExternalURL(ctx)
+	newUser.Path = strings.TrimSuffix(ctx.Path(), "register")
+	location := newUser.String()
+
+	if c.HoneypotTripped("register", registerRequest.Website, registerRequest.IP, registerRequest.UserAgent) {
+		decoy := c.decoyUser(registerRequest.Login, registerRequest.FirstName, registerRequest.LastName)
+		ctx.Location(location)
+		return ctx.Status(fiber.StatusCreated).
+			JSON(fiber.Map{"message": "User registered", "user": decoy.DTO(true)})
+	}
+
+	user, session, err := c.Register(ctx.Context(), registerRequest)
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		if errors.Is(err, ErrLoginTaken) {
+			return ctx.Status(fiber.StatusConflict).
+				JSON(fiber.Map{"message": "login is already taken"})
+		}
+		if errors.Is(err, ErrTermsOutdated) {
+			return c.termsOutdated(ctx)
+		}
+		if errors.Is(err, ErrCapacityReached) {
+			return c.capacityReached(ctx, err)
+		}
+		log.Er("failed to register", err)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to register"})
+	}
+
+	ctx.Location(location)
+	if session.ID != "" {
+		applySessionResponse(ctx, session, c.Config)
+	}
+
+	return ctx.Status(fiber.StatusCreated).
+		JSON(fiber.Map{"message": "User registered", "user": user.DTO(true)})
+}
+
+// handleAcceptTerms records the user's acceptance of the current terms of
+// service.
+func (c *UserController) handleAcceptTerms(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleAcceptTerms")
+	user := ctx.Locals("user").(User)
+
+	var acceptRequest AcceptTermsRequest
+	if err := ctx.BodyParser(&acceptRequest); err != nil {
+		log.Er("failed to parse accept terms request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse accept terms request"})
+	}
+
+	accepted, err := c.AcceptTerms(ctx.Context(), user, acceptRequest.TosVersion)
+	if errors.Is(err, ErrTermsOutdated) {
+		return c.termsOutdated(ctx)
+	}
+	if err != nil {
+		log.Er("failed to accept terms", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "failed to accept terms"})
+	}
+
+	return ctx.JSON(fiber.Map{"message": "Terms accepted", "user": accepted.DTO(true)})
+}
+
+// termsOutdated answers 422 with the version that has to be accepted.
+func (c *UserController) termsOutdated(ctx *fiber.Ctx) error {
+	return apierror.Send(ctx, apierror.New(
+		ErrorCodeTermsOutdated,
+		"The current terms of service must be accepted",
+	).With("tosVersion", c.Config.TermsVersion), c.Config)
+}
+
+// capacityReached answers a registration or login refused by Capacity,
+// naming which limit was hit.
+func (c *UserController) capacityReached(ctx *fiber.Ctx, err error) error {
+	var capacityErr *CapacityError
+	apiErr := apierror.New(ErrorCodeCapacityReached, "The service is at capacity, try again later")
+	if errors.As(err, &capacityErr) {
+		apiErr = apiErr.With("resource", capacityErr.Resource)
+	}
+	return apierror.Send(ctx, apiErr, c.Config)
+}
+
+// handleValidateRegistration answers how each field of a registration would
+// fare, without registering. It is rate limited harder than register since it
+// tells whether logins exist.
+func (c *UserController) handleValidateRegistration(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleValidateRegistration")
+
+	var registerRequest RegisterRequest
+	if err := ctx.BodyParser(&registerRequest); err != nil {
+		log.Er("failed to parse register request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse register request"})
+	}
+
+	result, err := c.ValidateRegistration(ctx.Context(), registerRequest)
+	if err != nil {
+		log.Er("failed to validate registration", err)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to validate registration"})
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	return ctx.JSON(result)
+}
+
+func (c *UserController) handleFormToken(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleFormToken")
+
+	token, expiresAt, err := c.FormToken()
+	if err != nil {
+		log.Er("failed to issue form token", err)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to issue form token"})
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+	return ctx.JSON(fiber.Map{
+		"formToken": token,
+		"expiresAt": NewAPITime(expiresAt),
+		"minAge":    int(c.Config.Security.FormTokenMinAge.Seconds()),
+	})
+}
+
+func (c *UserController) handleUpdateProfile(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleUpdateProfile")
+
+	precondition, err := utils.ParsePrecondition(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	var updateRequest UpdateProfileRequest
+	if err := ctx.BodyParser(&updateRequest); err != nil {
+		log.Er("failed to parse update profile request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse update profile request"})
+	}
+
+	user := ctx.Locals("user").(User)
+	updatedUser, err := c.UpdateProfile(ctx.Context(), user, updateRequest, precondition)
+	if err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		var conflictErr *repositories.VersionConflictError
+		if errors.As(err, &conflictErr) {
+			return utils.PreconditionFailedResponse(ctx, conflictErr.Current)
+		}
+		log.Er("failed to update profile", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to update profile"})
+	}
+
+	utils.ApplyVersionHeaders(ctx, updatedUser.Version, precondition)
+	return ctx.JSON(fiber.Map{"message": "Profile updated", "user": updatedUser.DTO(true)})
+}
+
+func (c *UserController) handleChangePassword(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleChangePassword")
+
+	var changeRequest ChangePasswordRequest
+	if err := ctx.BodyParser(&changeRequest); err != nil {
+		log.Er("failed to parse change password request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse change password request"})
+	}
+
+	user := ctx.Locals("user").(User)
+	if err := c.ChangePassword(ctx.Context(), user, changeRequest); err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		log.Er("failed to change password", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to change password"})
+	}
+
+	return ctx.JSON(fiber.Map{"message": "Password changed"})
+}
+
+func (c *UserController) handleLoginHistory(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleLoginHistory")
+
+	user := ctx.Locals("user").(User)
+	history, err := c.LoginHistory(ctx.Context(), user.ID, ctx.Query("cursor"))
+	if errors.Is(err, repositories.ErrInvalidCursor) {
+		return apierror.Send(ctx, apierror.New(apierror.CODE_INVALID_CURSOR, "The cursor is not one this list handed out"), c.Config)
+	}
+	if err != nil {
+		log.Er("failed to get login history", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to get login history"})
+	}
+
+	return ctx.JSON(history)
+}
+
+func (c *UserController) handleExport(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleExport")
+
+	user := ctx.Locals("user").(User)
+	export, err := c.Export(ctx.Context(), user)
+	if err != nil {
+		log.Er("failed to export user data", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to export user data"})
+	}
+
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	ctx.Attachment(fmt.Sprintf("user-export-%s.json", user.ID))
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		streamed, err := c.WriteExport(context.Background(), w, export)
+		if err != nil {
+			log.Er("failed to stream user export", err, "userID", user.ID, "logins", streamed)
+		}
+	})
+
+	return nil
+}
+
+func (c *UserController) handleDeleteAccount(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleDeleteAccount")
+
+	var deleteRequest DeleteAccountRequest
+	if err := ctx.BodyParser(&deleteRequest); err != nil {
+		log.Er("failed to parse delete account request", err)
+		return ctx.Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "failed to parse delete account request"})
+	}
+
+	user := ctx.Locals("user").(User)
+	if err := c.DeleteAccount(ctx.Context(), user, deleteRequest); err != nil {
+		if errors.Is(err, ErrReauthenticationFailed) {
+			return ctx.Status(fiber.StatusUnauthorized).
+				JSON(fiber.Map{"message": "password confirmation failed"})
+		}
+		log.Er("failed to delete account", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to delete account"})
+	}
+
+	utils.ExpireCookie(ctx, SESSION_COOKIE_KEY, c.Config)
+
+	return ctx.JSON(fiber.Map{"message": "Account deleted"})
+}
+
+func (c *UserController) handleGetPreferences(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleGetPreferences")
+
+	user := ctx.Locals("user").(User)
+	preferences, err := c.Preferences(ctx.Context(), user.ID)
+	if err != nil {
+		log.Er("failed to get preferences", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to get preferences"})
+	}
+
+	return ctx.JSON(fiber.Map{"preferences": preferences})
+}
+
+func (c *UserController) handleSetPreference(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleSetPreference")
+
+	user := ctx.Locals("user").(User)
+	key := ctx.Params("key")
+	value := ctx.Body()
+
+	if err := c.SetPreference(ctx.Context(), user.ID, key, value); err != nil {
+		var validationErr *utils.ValidationError
+		if errors.As(err, &validationErr) {
+			return utils.ValidationErrorResponse(ctx, validationErr)
+		}
+		log.Er("failed to set preference", err, "userID", user.ID, "key", key)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to set preference"})
+	}
+
+	return ctx.JSON(fiber.Map{"key": key, "value": json.RawMessage(value)})
+}
+
+func (c *UserController) handleDeletePreference(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleDeletePreference")
+
+	user := ctx.Locals("user").(User)
+	key := ctx.Params("key")
+
+	if err := c.DeletePreference(ctx.Context(), user.ID, key); err != nil {
+		log.Er("failed to delete preference", err, "userID", user.ID, "key", key)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to delete preference"})
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (c *UserController) handleListSessions(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleListSessions")
+
+	user := ctx.Locals("user").(User)
+	current := ctx.Locals("session").(Session)
+
+	sessions, err := c.ListSessions(ctx.Context(), user.ID, current.ID)
+	if err != nil {
+		log.Er("failed to list sessions", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to list sessions"})
+	}
+
+	return ctx.JSON(fiber.Map{"sessions": sessions})
+}
+
+func (c *UserController) handleRevokeSession(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleRevokeSession")
+
+	user := ctx.Locals("user").(User)
+	current := ctx.Locals("session").(Session)
+	sessionID := ctx.Params("id")
+
+	if sessionID == current.ID {
+		utils.ExpireCookie(ctx, SESSION_COOKIE_KEY, c.Config)
+		if err := c.Logout(sessionID, user.ID); err != nil {
+			log.Er("failed to logout", err)
+			return ctx.Status(fiber.StatusInternalServerError).
+				JSON(fiber.Map{"message": "failed to logout"})
+		}
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+
+	if err := c.RevokeSession(ctx.Context(), user.ID, sessionID); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return ctx.Status(fiber.StatusNotFound).
+				JSON(fiber.Map{"message": "session not found"})
+		}
+		log.Er("failed to revoke session", err, "userID", user.ID, "sessionID", sessionID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to revoke session"})
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+func (c *UserController) handleRevokeOtherSessions(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleRevokeOtherSessions")
+
+	user := ctx.Locals("user").(User)
+	current := ctx.Locals("session").(Session)
+
+	revoked, err := c.RevokeOtherSessions(ctx.Context(), user.ID, current.ID)
+	if err != nil {
+		log.Er("failed to revoke other sessions", err, "userID", user.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to revoke other sessions"})
+	}
+
+	return ctx.JSON(fiber.Map{"message": "Other sessions revoked", "revoked": revoked})
+}
+
+func (c *UserController) handleStopImpersonation(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleStopImpersonation")
+
+	session := ctx.Locals("session").(Session)
+
+	adminSession, err := c.StopImpersonation(ctx.Context(), session, ctx.IP())
+	if err != nil {
+		if errors.Is(err, ErrNotImpersonating) {
+			return ctx.Status(fiber.StatusBadRequest).
+				JSON(fiber.Map{"message": "Not impersonating a user"})
+		}
+		log.Er("failed to stop impersonation", err, "sessionID", session.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to stop impersonation"})
+	}
+
+	ctx.Response().Header.Del(middleware.IMPERSONATING_HEADER)
+	if adminSession.ID == "" {
+		utils.ExpireCookie(ctx, SESSION_COOKIE_KEY, c.Config)
+		return ctx.JSON(fiber.Map{"message": "Impersonation stopped", "restored": false})
+	}
+
+	applySessionResponse(ctx, adminSession, c.Config)
+	return ctx.JSON(fiber.Map{"message": "Impersonation stopped", "restored": true})
+}
+
+// handleExtendSession lets a web client keep an active session alive without
+// waiting for a request to land after its refresh time. The response says
+// when it now expires and when to call again.
+func (c *UserController) handleExtendSession(ctx *fiber.Ctx) error {
+	log := c.log.Function("handleExtendSession")
+
+	// BasicAuth has already rejected malformed client headers
+	if client, _ := middleware.GetClientInfo(ctx, c.Config); client.Strategy == config.AUTH_STRATEGY_TOKEN {
+		return apierror.Send(ctx, apierror.New(
+			ErrorCodeSessionExtendCookieOnly,
+			"Token clients are sent a new token in the X-Auth-Token header, and over the websocket, once their session is due a refresh",
+		), c.Config)
+	}
+
+	session := ctx.Locals("session").(Session)
+	extended, err := c.ExtendSession(ctx.Context(), session)
+	if err != nil {
+		if errors.Is(err, ErrSessionMaxLifetime) {
+			return apierror.Send(ctx, apierror.New(
+				ErrorCodeSessionMaxLifetime,
+				"Session has reached its maximum lifetime, log in again",
+			), c.Config)
+		}
+		log.Er("failed to extend session", err, "sessionID", session.ID)
+		return ctx.Status(fiber.StatusInternalServerError).
+			JSON(fiber.Map{"message": "Failed to extend session"})
+	}
+
+	applySessionResponse(ctx, extended, c.Config)
+	return ctx.JSON(fiber.Map{
+		"message":   "Session extended",
+		"expiresAt": NewAPITime(extended.ExpiresAt),
+		"refreshAt": NewAPITime(extended.RefreshAt),
+	})
+}
+
+func applySessionResponse(ctx *fiber.Ctx, session Session, config config.Config) {
+	utils.ApplyCookie(ctx, utils.Cookie{
+		Name:    SESSION_COOKIE_KEY,
+		Value:   session.ID,
+		Expires: session.ExpiresAt,
+	}, config)
+
+	utils.ApplyToken(ctx, session.Token)
+}
@@ -1,15 +1,27 @@
 package userController
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"server/config"
+	"server/internal/clock"
 	"server/internal/events"
 	"server/internal/logger"
 	. "server/internal/models"
+	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"server/internal/utils"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -23,6 +35,12 @@ func (m *MockUserRepository) GetByID(ctx context.Context, id string) (*User, err
 	return args.Get(0).(*User), args.Error(1)
 }
 
+func (m *MockUserRepository) GetByIDs(ctx context.Context, ids []string) ([]User, error) {
+	args := m.Called(ctx, ids)
+	users, _ := args.Get(0).([]User)
+	return users, args.Error(1)
+}
+
 func (m *MockUserRepository) GetByLogin(ctx context.Context, login string) (*User, error) {
 	args := m.Called(ctx, login)
 	return args.Get(0).(*User), args.Error(1)
@@ -38,11 +56,26 @@ func (m *MockUserRepository) Update(ctx context.Context, user *User) error {
 	return args.Error(0)
 }
 
+func (m *MockUserRepository) UpdateProfile(ctx context.Context, user *User, expectedVersion int) error {
+	args := m.Called(ctx, user, expectedVersion)
+	return args.Error(0)
+}
+
 func (m *MockUserRepository) Delete(ctx context.Context, id string) error {
 	args := m.Called(ctx, id)
 	return args.Error(0)
 }
 
+func (m *MockUserRepository) List(ctx context.Context, page int, sort UserSort, columns []string) ([]User, bool, error) {
+	args := m.Called(ctx, page, sort, columns)
+	return args.Get(0).([]User), args.Bool(1), args.Error(2)
+}
+
+func (m *MockUserRepository) Stream(ctx context.Context, columns []string, each func(users []User) error) (int64, error) {
+	args := m.Called(ctx, columns, each)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 type MockSessionRepository struct {
 	mock.Mock
 }
@@ -52,6 +85,11 @@ func (m *MockSessionRepository) Create(ctx context.Context, session *Session, co
 	return args.Error(0)
 }
 
+func (m *MockSessionRepository) Extend(ctx context.Context, session *Session, expiresAt time.Time, config config.Config) error {
+	args := m.Called(ctx, session, expiresAt, config)
+	return args.Error(0)
+}
+
 func (m *MockSessionRepository) GetByID(ctx context.Context, id string) (*Session, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(*Session), args.Error(1)
@@ -62,15 +100,166 @@ func (m *MockSessionRepository) Delete(ctx context.Context, id string) error {
 	return args.Error(0)
 }
 
+func (m *MockSessionRepository) ListByUser(ctx context.Context, userID string) ([]*Session, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]*Session), args.Error(1)
+}
+
+func (m *MockSessionRepository) DeleteByUser(ctx context.Context, userID string) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) CountActive(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockSessionRepository) ReapExpired(ctx context.Context, expiredBefore time.Time, limit int) (int, error) {
+	args := m.Called(ctx, expiredBefore, limit)
+	return args.Int(0), args.Error(1)
+}
+
+type MockLoginEventRepository struct {
+	mock.Mock
+}
+
+func (m *MockLoginEventRepository) Create(ctx context.Context, event *LoginEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (m *MockLoginEventRepository) ListSuccessful(
+	ctx context.Context,
+	userID string,
+	cursor string,
+) ([]LoginEvent, string, error) {
+	args := m.Called(ctx, userID, cursor)
+	return args.Get(0).([]LoginEvent), args.String(1), args.Error(2)
+}
+
+// StreamByUser hands the events the mock returns to each as one batch.
+func (m *MockLoginEventRepository) StreamByUser(
+	ctx context.Context,
+	userID string,
+	each func(events []LoginEvent) error,
+) (int64, error) {
+	args := m.Called(ctx, userID)
+	events := args.Get(0).([]LoginEvent)
+	if len(events) > 0 {
+		if err := each(events); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(events)), args.Error(1)
+}
+
+func (m *MockLoginEventRepository) CountFailedSince(ctx context.Context, userID string, since time.Time) (int64, error) {
+	args := m.Called(ctx, userID, since)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockLoginEventRepository) HasSucceededFromIP(ctx context.Context, userID string, ip string) (bool, error) {
+	args := m.Called(ctx, userID, ip)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockLoginEventRepository) RecentlyActiveUsers(ctx context.Context, since time.Time, limit int) ([]string, error) {
+	args := m.Called(ctx, since, limit)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockLoginEventRepository) AnonymizeByUser(ctx context.Context, userID string) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockLoginEventRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	args := m.Called(ctx, cutoff)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type MockPreferenceRepository struct {
+	mock.Mock
+}
+
+func (m *MockPreferenceRepository) ListByUser(ctx context.Context, userID string) ([]UserPreference, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]UserPreference), args.Error(1)
+}
+
+func (m *MockPreferenceRepository) Get(ctx context.Context, userID string, key string) (*UserPreference, error) {
+	args := m.Called(ctx, userID, key)
+	return args.Get(0).(*UserPreference), args.Error(1)
+}
+
+func (m *MockPreferenceRepository) Set(ctx context.Context, preference *UserPreference) error {
+	args := m.Called(ctx, preference)
+	return args.Error(0)
+}
+
+func (m *MockPreferenceRepository) Delete(ctx context.Context, userID string, key string) error {
+	args := m.Called(ctx, userID, key)
+	return args.Error(0)
+}
+
+type MockAuditRepository struct {
+	mock.Mock
+}
+
+func (m *MockAuditRepository) Create(ctx context.Context, entry *AuditLog) error {
+	args := m.Called(ctx, entry)
+	return args.Error(0)
+}
+
+func (m *MockAuditRepository) List(ctx context.Context, cursor string) ([]AuditLog, string, error) {
+	args := m.Called(ctx, cursor)
+	return args.Get(0).([]AuditLog), args.String(1), args.Error(2)
+}
+
+func (m *MockAuditRepository) Stream(ctx context.Context, each func(entries []AuditLog) error) (int64, error) {
+	args := m.Called(ctx, each)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockAuditRepository) ExportBefore(
+	ctx context.Context,
+	cutoff time.Time,
+	export func(entries []AuditLog) error,
+) (int64, error) {
+	args := m.Called(ctx, cutoff, export)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockAuditRepository) DeleteBefore(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	args := m.Called(ctx, cutoff, limit)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockAuditRepository) CreateArchive(ctx context.Context, archive *AuditArchive) error {
+	args := m.Called(ctx, archive)
+	return args.Error(0)
+}
+
+func (m *MockAuditRepository) ListArchives(ctx context.Context) ([]AuditArchive, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]AuditArchive), args.Error(1)
+}
+
+func (m *MockAuditRepository) GetArchive(ctx context.Context, id string) (*AuditArchive, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*AuditArchive), args.Error(1)
+}
+
 func TestUserController_New(t *testing.T) {
 	mockUserRepo := &MockUserRepository{}
 	mockSessionRepo := &MockSessionRepository{}
 	mockConfig := config.Config{
-		ServerPort: 8080,
+		Server: config.ServerConfig{Port: 8080},
 	}
 
 	eventBus := &events.EventBus{}
-	controller := New(eventBus, mockUserRepo, mockSessionRepo, mockConfig)
+	controller := New(eventBus, mockUserRepo, mockSessionRepo, nil, nil, nil, middleware.Middleware{}, mockConfig)
 
 	assert.NotNil(t, controller)
 	assert.Equal(t, mockUserRepo, controller.userRepo)
@@ -85,12 +274,12 @@ func TestUserController_StructCreation(t *testing.T) {
 	controller := &UserController{
 		userRepo:    mockUserRepo,
 		sessionRepo: mockSessionRepo,
-		Config:      config.Config{ServerPort: 8080},
+		Config:      config.Config{Server: config.ServerConfig{Port: 8080}},
 		log:         logger.New("test"),
 	}
 
 	assert.NotNil(t, controller)
-	assert.Equal(t, 8080, controller.Config.ServerPort)
+	assert.Equal(t, 8080, controller.Config.Server.Port)
 	assert.NotNil(t, controller.log)
 }
 
@@ -114,7 +303,7 @@ func TestUserController_Login_StructureTest(t *testing.T) {
 	controller := &UserController{
 		userRepo:    &MockUserRepository{},
 		sessionRepo: &MockSessionRepository{},
-		Config: config.Config{SecurityPepper: "test-pepper"},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: "test-pepper"}},
 		log:    logger.New("test"),
 	}
 
@@ -148,7 +337,7 @@ func TestUserController_Register_StructureTest(t *testing.T) {
 	controller := &UserController{
 		userRepo:    &MockUserRepository{},
 		sessionRepo: &MockSessionRepository{},
-		Config: config.Config{ServerPort: 8080},
+		Config: config.Config{Server: config.ServerConfig{Port: 8080}},
 		log:    logger.New("test"),
 	}
 
@@ -169,7 +358,7 @@ func TestUserController_Register_StructureTest(t *testing.T) {
 func TestUserController_ComparePassword_Success(t *testing.T) {
 	pepper := "test-pepper"
 	controller := &UserController{
-		Config: config.Config{SecurityPepper: pepper},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: pepper}},
 		log:    logger.New("test"),
 	}
 
@@ -188,7 +377,7 @@ func TestUserController_ComparePassword_Success(t *testing.T) {
 func TestUserController_ComparePassword_Failure(t *testing.T) {
 	pepper := "test-pepper"
 	controller := &UserController{
-		Config: config.Config{SecurityPepper: pepper},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: pepper}},
 		log:    logger.New("test"),
 	}
 
@@ -207,7 +396,7 @@ func TestUserController_ComparePassword_Failure(t *testing.T) {
 
 func TestUserController_ComparePassword_EmptyPassword(t *testing.T) {
 	controller := &UserController{
-		Config: config.Config{SecurityPepper: "test-pepper"},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: "test-pepper"}},
 		log:    logger.New("test"),
 	}
 
@@ -218,7 +407,7 @@ func TestUserController_ComparePassword_EmptyPassword(t *testing.T) {
 
 func TestUserController_ComparePassword_EmptyHash(t *testing.T) {
 	controller := &UserController{
-		Config: config.Config{SecurityPepper: "test-pepper"},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: "test-pepper"}},
 		log:    logger.New("test"),
 	}
 
@@ -230,7 +419,7 @@ func TestUserController_ComparePassword_EmptyHash(t *testing.T) {
 func TestUserController_ComparePassword_WithPepper(t *testing.T) {
 	pepper := "special-pepper-123"
 	controller := &UserController{
-		Config: config.Config{SecurityPepper: pepper},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: pepper}},
 		log:    logger.New("test"),
 	}
 
@@ -311,7 +500,7 @@ func TestUserController_EmptyConfig(t *testing.T) {
 func TestUserController_ComparePassword_SpecialCharacters(t *testing.T) {
 	pepper := "special!@#$%^&*()_+{}|:<>?[];'\"\\,./`~"
 	controller := &UserController{
-		Config: config.Config{SecurityPepper: pepper},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: pepper}},
 		log:    logger.New("test"),
 	}
 
@@ -328,7 +517,7 @@ func TestUserController_ComparePassword_SpecialCharacters(t *testing.T) {
 func TestUserController_ComparePassword_UnicodeCharacters(t *testing.T) {
 	pepper := "测试胡椒🔒"
 	controller := &UserController{
-		Config: config.Config{SecurityPepper: pepper},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: pepper}},
 		log:    logger.New("test"),
 	}
 
@@ -350,7 +539,7 @@ func TestUserController_ComparePassword_VeryLongPepper(t *testing.T) {
 	}
 
 	controller := &UserController{
-		Config: config.Config{SecurityPepper: pepper},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: pepper}},
 		log:    logger.New("test"),
 	}
 
@@ -372,7 +561,7 @@ func TestUserController_ComparePassword_VeryLongPepper(t *testing.T) {
 
 func TestUserController_ComparePassword_InvalidHashFormat(t *testing.T) {
 	controller := &UserController{
-		Config: config.Config{SecurityPepper: "test-pepper"},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: "test-pepper"}},
 		log:    logger.New("test"),
 	}
 
@@ -438,7 +627,7 @@ func TestUserController_Register_EmptyUser(t *testing.T) {
 
 func TestUserController_ComparePassword_EdgeCases(t *testing.T) {
 	controller := &UserController{
-		Config: config.Config{SecurityPepper: "test"},
+		Config: config.Config{Security: config.SecurityConfig{Pepper: "test"}},
 		log:    logger.New("test"),
 	}
 
@@ -468,3 +657,702 @@ func TestUserController_ComparePassword_EdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestUserController_Register_RejectsWeakPassword(t *testing.T) {
+	mockUserRepo := &MockUserRepository{}
+	controller := &UserController{
+		userRepo: mockUserRepo,
+		Config:   config.Config{Security: config.SecurityConfig{MinPasswordScore: 2}},
+		log:      logger.New("test"),
+	}
+
+	_, _, err := controller.Register(context.Background(), RegisterRequest{
+		Login:    "newuser",
+		Password: "password1234",
+	})
+
+	var validationErr *utils.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	detail := validationErr.Details["password"].(map[string]any)
+	assert.Equal(t, "password_too_weak", detail["code"])
+	assert.Less(t, detail["score"], 2)
+	assert.NotEmpty(t, detail["suggestion"])
+	mockUserRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUserController_Register_RejectsPasswordContainingLogin(t *testing.T) {
+	mockUserRepo := &MockUserRepository{}
+	controller := &UserController{
+		userRepo: mockUserRepo,
+		Config:   config.Config{Security: config.SecurityConfig{MinPasswordScore: 0}},
+		log:      logger.New("test"),
+	}
+
+	_, _, err := controller.Register(context.Background(), RegisterRequest{
+		Login:     "Grace",
+		Password:  "amazing-GRACE-k9!vQ",
+		FirstName: "Grace",
+	})
+
+	var validationErr *utils.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	detail := validationErr.Details["password"].(map[string]any)
+	assert.Equal(t, "password_contains_user_input", detail["code"])
+	mockUserRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUserController_ValidateRegistration(t *testing.T) {
+	const strong = "correct-Horse-battery-9!"
+	ok := FieldValidation{OK: true}
+
+	testCases := []struct {
+		name     string
+		request  RegisterRequest
+		existing bool
+		login    FieldValidation
+		password FieldValidation
+	}{
+		{"valid", RegisterRequest{Login: "newuser", Password: strong}, false, ok, ok},
+		{
+			"login missing", RegisterRequest{Password: strong}, false,
+			FieldValidation{Code: "required", Message: "login is required"}, ok,
+		},
+		{
+			"login taken", RegisterRequest{Login: "existing", Password: strong}, true,
+			FieldValidation{Code: "login_taken", Message: "login is already taken"}, ok,
+		},
+		{
+			"password missing", RegisterRequest{Login: "newuser"}, false,
+			ok, FieldValidation{Code: "required", Message: "password is required"},
+		},
+		{
+			"password weak", RegisterRequest{Login: "newuser", Password: "password1234"}, false,
+			ok, FieldValidation{Code: "password_too_weak", Message: "password is too weak"},
+		},
+		{
+			"password contains login", RegisterRequest{Login: "Grace", Password: "amazing-GRACE-k9!vQ-Grace"}, false,
+			ok, FieldValidation{Code: "password_contains_user_input", Message: "password is too weak"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUserRepo := &MockUserRepository{}
+			login := NormalizeLogin(tc.request.Login)
+			if tc.existing {
+				mockUserRepo.On("GetByLogin", mock.Anything, login).Return(&User{Login: login}, nil)
+			} else {
+				mockUserRepo.On("GetByLogin", mock.Anything, login).Return((*User)(nil), repositories.ErrNotFound)
+			}
+			controller := &UserController{
+				userRepo: mockUserRepo,
+				Config:   config.Config{Security: config.SecurityConfig{MinPasswordScore: 2}},
+				log:      logger.New("test"),
+			}
+
+			result, err := controller.ValidateRegistration(context.Background(), tc.request)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.login, result.Fields["login"])
+			assert.Equal(t, tc.password, result.Fields["password"])
+			assert.Equal(t, tc.login.OK && tc.password.OK, result.Valid)
+			mockUserRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestUserController_ValidateRegistration_StoreError(t *testing.T) {
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByLogin", mock.Anything, "newuser").Return((*User)(nil), errors.New("database is locked"))
+	controller := &UserController{userRepo: mockUserRepo, log: logger.New("test")}
+
+	_, err := controller.ValidateRegistration(context.Background(), RegisterRequest{Login: "newuser", Password: "x"})
+
+	assert.Error(t, err)
+}
+
+func TestUserController_Register_ThresholdFromConfig(t *testing.T) {
+	password := "letmein2024"
+	score := utils.EstimatePasswordStrength(password).Score
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByLogin", mock.Anything, "threshold").
+		Return((*User)(nil), repositories.ErrNotFound)
+	mockUserRepo.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	lenient := &UserController{
+		userRepo: mockUserRepo,
+		Config: config.Config{
+			Security: config.SecurityConfig{
+				MinPasswordScore: score,
+				Salt: bcrypt.MinCost,
+				Pepper: "test-pepper",
+			},
+		},
+		log:      logger.New("test"),
+	}
+	_, _, err := lenient.Register(context.Background(), RegisterRequest{Login: "threshold", Password: password})
+	assert.NoError(t, err)
+
+	strict := &UserController{
+		userRepo: mockUserRepo,
+		Config:   config.Config{Security: config.SecurityConfig{MinPasswordScore: score + 1}},
+		log:      logger.New("test"),
+	}
+	_, _, err = strict.Register(context.Background(), RegisterRequest{Login: "threshold", Password: password})
+	var validationErr *utils.ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+}
+
+func TestUserController_Register_DuplicateLogin(t *testing.T) {
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByLogin", mock.Anything, "taken").
+		Return(&User{Login: "taken"}, nil)
+
+	controller := &UserController{
+		userRepo: mockUserRepo,
+		Config:   config.Config{Security: config.SecurityConfig{MinPasswordScore: 2}},
+		log:      logger.New("test"),
+	}
+
+	_, _, err := controller.Register(context.Background(), RegisterRequest{
+		Login:    "taken",
+		Password: "glacier umbrella voltage",
+	})
+
+	assert.ErrorIs(t, err, ErrLoginTaken)
+	mockUserRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUserController_Register_Success(t *testing.T) {
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByLogin", mock.Anything, "newuser").
+		Return((*User)(nil), repositories.ErrNotFound)
+	mockUserRepo.On("Create", mock.Anything, mock.MatchedBy(func(user *User) bool {
+		return user.Login == "newuser" && user.FirstName == "New" && utils.IsPasswordHash(user.Password)
+	}), mock.Anything).Return(nil)
+
+	controller := &UserController{
+		userRepo: mockUserRepo,
+		Config: config.Config{
+			Security: config.SecurityConfig{
+				MinPasswordScore: 2,
+				Salt: bcrypt.MinCost,
+				Pepper: "test-pepper",
+			},
+		},
+		log:      logger.New("test"),
+	}
+
+	user, session, err := controller.Register(context.Background(), RegisterRequest{
+		Login:     "newuser",
+		Password:  "glacier umbrella voltage",
+		FirstName: "New",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "newuser", user.Login)
+	assert.Empty(t, session.ID, "auto-login is off in this config")
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestUserController_ChangePassword_RejectsWeakPassword(t *testing.T) {
+	pepper := "test-pepper"
+	hashed, err := bcrypt.GenerateFromPassword([]byte("glacier umbrella voltage"+pepper), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByLogin", mock.Anything, "jdoe").
+		Return(&User{Login: "jdoe", Password: string(hashed)}, nil)
+
+	controller := &UserController{
+		userRepo: mockUserRepo,
+		Config:   config.Config{Security: config.SecurityConfig{Pepper: pepper, MinPasswordScore: 2}},
+		log:      logger.New("test"),
+	}
+
+	err = controller.ChangePassword(context.Background(), User{Login: "jdoe"}, ChangePasswordRequest{
+		CurrentPassword: "glacier umbrella voltage",
+		NewPassword:     "password1234",
+	})
+
+	var validationErr *utils.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Contains(t, validationErr.Details, "newPassword")
+	mockUserRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+}
+
+func setupLoginTest(t *testing.T) (*UserController, *MockUserRepository, *MockSessionRepository, *MockLoginEventRepository) {
+	pepper := "test-pepper"
+	hashed, err := bcrypt.GenerateFromPassword([]byte("correct-password"+pepper), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByLogin", mock.Anything, "jdoe").
+		Return(&User{BaseModel: BaseModel{ID: "user-1"}, Login: "jdoe", Password: string(hashed), PepperVersion: 1}, nil)
+	mockUserRepo.On("GetByLogin", mock.Anything, "missing").
+		Return((*User)(nil), repositories.ErrNotFound)
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockLoginEventRepo := &MockLoginEventRepository{}
+
+	controller := &UserController{
+		userRepo:       mockUserRepo,
+		sessionRepo:    mockSessionRepo,
+		loginEventRepo: mockLoginEventRepo,
+		Config:         config.Config{Security: config.SecurityConfig{Pepper: pepper}},
+		log:            logger.New("test"),
+	}
+
+	return controller, mockUserRepo, mockSessionRepo, mockLoginEventRepo
+}
+
+func TestUserController_Login_RecordsLoginEvents(t *testing.T) {
+	testCases := []struct {
+		name               string
+		login              string
+		password           string
+		wantErr            bool
+		wantUserID         string
+		wantAttemptedLogin string
+		wantSuccess        bool
+	}{
+		{"success", "jdoe", "correct-password", false, "user-1", "", true},
+		{"wrong password", "jdoe", "wrong-password", true, "user-1", "", false},
+		{"unknown login", "Missing", "whatever", true, "", "missing", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			controller, _, _, mockLoginEventRepo := setupLoginTest(t)
+			mockLoginEventRepo.On("Create", mock.Anything, mock.MatchedBy(func(event *LoginEvent) bool {
+				return event.UserID == tc.wantUserID &&
+					event.AttemptedLogin == tc.wantAttemptedLogin &&
+					event.Success == tc.wantSuccess &&
+					event.IP == "10.0.0.1" &&
+					event.UserAgent == "Firefox/121.0" &&
+					event.ClientType == "solid"
+			})).Return(nil).Once()
+
+			_, _, err := controller.Login(context.Background(), LoginRequest{
+				Login:      tc.login,
+				Password:   tc.password,
+				IP:         "10.0.0.1",
+				UserAgent:  "Firefox/121.0",
+				ClientType: "solid",
+			})
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			mockLoginEventRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUserController_Login_RecordFailureDoesNotBlockLogin(t *testing.T) {
+	controller, _, _, mockLoginEventRepo := setupLoginTest(t)
+	mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(errors.New("db down"))
+
+	user, _, err := controller.Login(context.Background(), LoginRequest{
+		Login:    "jdoe",
+		Password: "correct-password",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", user.ID)
+}
+
+func TestUserController_Login_RehashesPreviousPepper(t *testing.T) {
+	previous := config.Config{Security: config.SecurityConfig{Salt: bcrypt.MinCost, Pepper: "old-pepper"}}
+	hashed, err := utils.HashPassword("correct-password", previous)
+	require.NoError(t, err)
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByLogin", mock.Anything, "jdoe").
+		Return(&User{BaseModel: BaseModel{ID: "user-1"}, Login: "jdoe", Password: hashed, PepperVersion: 1}, nil)
+	mockUserRepo.On("Update", mock.Anything, mock.Anything).Return(nil)
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockLoginEventRepo := &MockLoginEventRepository{}
+	mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	cfg := config.Config{
+		Security: config.SecurityConfig{
+			Salt: bcrypt.MinCost,
+			Pepper: "new-pepper",
+			PepperPrevious: "old-pepper",
+			PepperVersion: 2,
+		},
+	}
+	controller := &UserController{
+		userRepo:       mockUserRepo,
+		sessionRepo:    mockSessionRepo,
+		loginEventRepo: mockLoginEventRepo,
+		Config:         cfg,
+		log:            logger.New("test"),
+	}
+
+	_, _, err = controller.Login(context.Background(), LoginRequest{Login: "jdoe", Password: "wrong-password"})
+	assert.Error(t, err)
+	mockUserRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+
+	user, _, err := controller.Login(context.Background(), LoginRequest{Login: "jdoe", Password: "correct-password"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, user.PepperVersion)
+	mockUserRepo.AssertCalled(t, "Update", mock.Anything, mock.MatchedBy(func(u *User) bool {
+		previous, err := utils.VerifyPassword("correct-password", u.Password, cfg)
+		return err == nil && !previous && u.PepperVersion == 2
+	}))
+}
+
+func TestUserController_LoginHistory(t *testing.T) {
+	mockLoginEventRepo := &MockLoginEventRepository{}
+	mockLoginEventRepo.On("ListSuccessful", mock.Anything, "user-1", "cursor-1").Return([]LoginEvent{
+		{
+			ID:        "event-1",
+			UserID:    "user-1",
+			IP:        "10.0.0.1",
+			UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			Success:   true,
+		},
+	}, "cursor-2", nil)
+
+	controller := &UserController{
+		loginEventRepo: mockLoginEventRepo,
+		log:            logger.New("test"),
+	}
+
+	history, err := controller.LoginHistory(context.Background(), "user-1", "cursor-1")
+
+	require.NoError(t, err)
+	assert.Equal(t, "cursor-2", history.NextCursor)
+	assert.True(t, history.HasMore)
+	require.Len(t, history.Logins, 1)
+	assert.Equal(t, "Firefox", history.Logins[0].Device.Browser)
+	assert.Equal(t, "Linux", history.Logins[0].Device.OS)
+}
+
+func TestUserController_PruneLoginHistory(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mockLoginEventRepo := &MockLoginEventRepository{}
+	mockLoginEventRepo.On("DeleteOlderThan", mock.Anything, now.Add(-repositories.LOGIN_EVENT_RETENTION)).
+		Return(int64(3), nil)
+
+	controller := &UserController{
+		loginEventRepo: mockLoginEventRepo,
+		clock:          clock.NewFake(now),
+		log:            logger.New("test"),
+	}
+
+	assert.NoError(t, controller.PruneLoginHistory(context.Background()))
+	mockLoginEventRepo.AssertExpectations(t)
+}
+
+func TestUserController_ReapSessions(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("ReapExpired", mock.Anything, now.Add(-SESSION_REAP_GRACE), repositories.SESSION_REAP_LIMIT).
+		Return(4, nil)
+	mockSessionRepo.On("CountActive", mock.Anything).Return(int64(12), nil)
+
+	controller := &UserController{
+		sessionRepo: mockSessionRepo,
+		clock:       clock.NewFake(now),
+		log:         logger.New("test"),
+	}
+	reapedBefore := SessionsReaped.Value()
+
+	require.NoError(t, controller.ReapSessions(context.Background()))
+
+	mockSessionRepo.AssertExpectations(t)
+	assert.Equal(t, reapedBefore+4, SessionsReaped.Value())
+	assert.Equal(t, float64(4), SessionsReapedLastRun.Value())
+	assert.Equal(t, float64(12), SessionsStored.Value())
+}
+
+func TestUserController_Export_Structure(t *testing.T) {
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("ListByUser", mock.Anything, "user-1").Return([]*Session{
+		{ID: "session-1", UserID: "user-1", Token: "secret-jwt", ExpiresAt: time.Now().Add(time.Hour)},
+	}, nil)
+
+	mockLoginEventRepo := &MockLoginEventRepository{}
+	mockLoginEventRepo.On("StreamByUser", mock.Anything, "user-1").Return([]LoginEvent{
+		{ID: "event-1", UserID: "user-1", IP: "10.0.0.1", Success: true},
+		{ID: "event-2", UserID: "user-1", IP: "10.0.0.2"},
+	}, nil)
+
+	mockPreferenceRepo := &MockPreferenceRepository{}
+	mockPreferenceRepo.On("ListByUser", mock.Anything, "user-1").Return([]UserPreference{
+		{UserID: "user-1", Key: "theme", Value: `"dark"`},
+	}, nil)
+
+	controller := &UserController{
+		sessionRepo:    mockSessionRepo,
+		loginEventRepo: mockLoginEventRepo,
+		preferenceRepo: mockPreferenceRepo,
+		log:            logger.New("test"),
+	}
+
+	user := User{
+		BaseModel: BaseModel{ID: "user-1"},
+		Login:     "jdoe",
+		Password:  "$2a$10$hashedpassword",
+	}
+	export, err := controller.Export(context.Background(), user)
+	require.NoError(t, err)
+
+	var buffer bytes.Buffer
+	w := bufio.NewWriter(&buffer)
+	logins, err := controller.WriteExport(context.Background(), w, export)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), logins)
+	body := buffer.Bytes()
+
+	var document map[string]any
+	require.NoError(t, json.Unmarshal(body, &document))
+
+	for _, key := range []string{"exportedAt", "profile", "sessions", "preferences", "activity", "loginHistory"} {
+		assert.Contains(t, document, key)
+	}
+	assert.Equal(t, "jdoe", document["profile"].(map[string]any)["login"])
+	assert.Len(t, document["sessions"], 1)
+	assert.Equal(t, "session-1", document["sessions"].([]any)[0].(map[string]any)["id"])
+	require.Len(t, document["loginHistory"], 2)
+	assert.Equal(t, "event-2", document["loginHistory"].([]any)[1].(map[string]any)["id"])
+	assert.Equal(t, map[string]any{"theme": "dark"}, document["preferences"])
+
+	assert.NotContains(t, string(body), "hashedpassword")
+	assert.NotContains(t, string(body), "secret-jwt")
+	assert.NotContains(t, string(body), "\"password\"")
+	assert.NotContains(t, string(body), "\"token\"")
+}
+
+func TestUserController_WriteExport_FailureLeavesDocumentOpen(t *testing.T) {
+	mockLoginEventRepo := &MockLoginEventRepository{}
+	mockLoginEventRepo.On("StreamByUser", mock.Anything, "user-1").Return([]LoginEvent{}, errors.New("db down"))
+	controller := &UserController{loginEventRepo: mockLoginEventRepo, log: logger.New("test")}
+
+	var buffer bytes.Buffer
+	w := bufio.NewWriter(&buffer)
+	_, err := controller.WriteExport(context.Background(), w, UserExport{Profile: UserDTO{ID: "user-1"}})
+	require.Error(t, err)
+	require.NoError(t, w.Flush())
+
+	var document map[string]any
+	assert.Error(t, json.Unmarshal(buffer.Bytes(), &document), "a cut short export must not parse")
+}
+
+func setupDeleteAccountTest(t *testing.T) (*UserController, *MockUserRepository, *MockSessionRepository, *MockLoginEventRepository) {
+	controller, mockUserRepo, mockSessionRepo, mockLoginEventRepo := setupLoginTest(t)
+	mockUserRepo.On("Delete", mock.Anything, "user-1").Return(nil)
+	mockSessionRepo.On("DeleteByUser", mock.Anything, "user-1").Return(nil)
+	mockLoginEventRepo.On("AnonymizeByUser", mock.Anything, "user-1").Return(int64(2), nil)
+	return controller, mockUserRepo, mockSessionRepo, mockLoginEventRepo
+}
+
+func TestUserController_DeleteAccount_RequiresPassword(t *testing.T) {
+	for _, password := range []string{"", "wrong-password", "stale-password"} {
+		t.Run(password, func(t *testing.T) {
+			controller, mockUserRepo, mockSessionRepo, mockLoginEventRepo := setupDeleteAccountTest(t)
+
+			err := controller.DeleteAccount(
+				context.Background(),
+				User{BaseModel: BaseModel{ID: "user-1"}, Login: "jdoe"},
+				DeleteAccountRequest{Password: password},
+			)
+
+			assert.ErrorIs(t, err, ErrReauthenticationFailed)
+			mockUserRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+			mockSessionRepo.AssertNotCalled(t, "DeleteByUser", mock.Anything, mock.Anything)
+			mockLoginEventRepo.AssertNotCalled(t, "AnonymizeByUser", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestUserController_DeleteAccount_Success(t *testing.T) {
+	controller, mockUserRepo, mockSessionRepo, mockLoginEventRepo := setupDeleteAccountTest(t)
+
+	err := controller.DeleteAccount(
+		context.Background(),
+		User{BaseModel: BaseModel{ID: "user-1"}, Login: "jdoe"},
+		DeleteAccountRequest{Password: "correct-password"},
+	)
+
+	assert.NoError(t, err)
+	mockUserRepo.AssertCalled(t, "Delete", mock.Anything, "user-1")
+	mockSessionRepo.AssertCalled(t, "DeleteByUser", mock.Anything, "user-1")
+	mockLoginEventRepo.AssertCalled(t, "AnonymizeByUser", mock.Anything, "user-1")
+}
+
+func TestUserController_Login_PersistsDeviceName(t *testing.T) {
+	controller, _, mockSessionRepo, mockLoginEventRepo := setupLoginTest(t)
+	mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	_, session, err := controller.Login(context.Background(), LoginRequest{
+		Login:      "jdoe",
+		Password:   "correct-password",
+		DeviceName: "  iPhone 15  ",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "iPhone 15", session.DeviceName)
+	mockSessionRepo.AssertCalled(t, "Create", mock.Anything, mock.MatchedBy(func(s *Session) bool {
+		return s.DeviceName == "iPhone 15"
+	}), mock.Anything)
+}
+
+func TestUserController_RevokeSession_Ownership(t *testing.T) {
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, "theirs").
+		Return(&Session{ID: "theirs", UserID: "user-2"}, nil)
+	mockSessionRepo.On("GetByID", mock.Anything, "mine").
+		Return(&Session{ID: "mine", UserID: "user-1"}, nil)
+	mockSessionRepo.On("Delete", mock.Anything, "mine").Return(nil)
+
+	controller := &UserController{sessionRepo: mockSessionRepo, log: logger.New("test")}
+
+	err := controller.RevokeSession(context.Background(), "user-1", "theirs")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	mockSessionRepo.AssertNotCalled(t, "Delete", mock.Anything, "theirs")
+
+	assert.NoError(t, controller.RevokeSession(context.Background(), "user-1", "mine"))
+	mockSessionRepo.AssertCalled(t, "Delete", mock.Anything, "mine")
+}
+
+func TestUserController_RevokeOtherSessions_LeavesCurrent(t *testing.T) {
+	remaining := map[string]bool{"current": true, "phone": true, "laptop": true}
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("ListByUser", mock.Anything, "user-1").Return([]*Session{
+		{ID: "phone", UserID: "user-1"},
+		{ID: "current", UserID: "user-1"},
+		{ID: "laptop", UserID: "user-1"},
+	}, nil)
+	mockSessionRepo.On("Delete", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		delete(remaining, args.String(1))
+	}).Return(nil)
+
+	controller := &UserController{sessionRepo: mockSessionRepo, log: logger.New("test")}
+
+	revoked, err := controller.RevokeOtherSessions(context.Background(), "user-1", "current")
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, revoked)
+	assert.Equal(t, map[string]bool{"current": true}, remaining)
+}
+
+// collectLogouts gathers the logout events published on eventBus.
+func collectLogouts(t *testing.T, eventBus *events.EventBus) func() []events.UserLogoutEvent {
+	t.Helper()
+	var mutex sync.Mutex
+	var logouts []events.UserLogoutEvent
+	require.NoError(t, eventBus.UserLogoutTopic().Subscribe(
+		func(ctx context.Context, event events.UserLogoutEvent) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			logouts = append(logouts, event)
+			return nil
+		},
+	))
+	return func() []events.UserLogoutEvent {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return slices.Clone(logouts)
+	}
+}
+
+func TestUserController_EndingSessionsPublishesLogout(t *testing.T) {
+	testCases := []struct {
+		name     string
+		end      func(controller *UserController) error
+		expected []events.UserLogoutEvent
+	}{
+		{
+			"revoke session",
+			func(controller *UserController) error {
+				return controller.RevokeSession(context.Background(), "user-1", "phone")
+			},
+			[]events.UserLogoutEvent{{UserID: "user-1", SessionID: "phone"}},
+		},
+		{
+			"revoke other sessions",
+			func(controller *UserController) error {
+				_, err := controller.RevokeOtherSessions(context.Background(), "user-1", "current")
+				return err
+			},
+			[]events.UserLogoutEvent{
+				{UserID: "user-1", SessionID: "phone"},
+				{UserID: "user-1", SessionID: "laptop"},
+			},
+		},
+		{
+			"delete account",
+			func(controller *UserController) error {
+				return controller.DeleteAccount(
+					context.Background(),
+					User{BaseModel: BaseModel{ID: "user-1"}, Login: "jdoe"},
+					DeleteAccountRequest{Password: "correct-password"},
+				)
+			},
+			[]events.UserLogoutEvent{{UserID: "user-1"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			controller, _, mockSessionRepo, _ := setupDeleteAccountTest(t)
+			mockSessionRepo.On("GetByID", mock.Anything, "phone").
+				Return(&Session{ID: "phone", UserID: "user-1"}, nil)
+			mockSessionRepo.On("ListByUser", mock.Anything, "user-1").Return([]*Session{
+				{ID: "phone", UserID: "user-1"},
+				{ID: "current", UserID: "user-1"},
+				{ID: "laptop", UserID: "user-1"},
+			}, nil)
+			mockSessionRepo.On("Delete", mock.Anything, mock.Anything).Return(nil)
+			eventBus := events.New(nil, config.Config{}, nil)
+			controller.eventBus = eventBus
+			logouts := collectLogouts(t, eventBus)
+
+			require.NoError(t, tc.end(controller))
+
+			assert.Eventually(t, func() bool {
+				return len(logouts()) == len(tc.expected)
+			}, time.Second, 10*time.Millisecond)
+			assert.ElementsMatch(t, tc.expected, logouts())
+		})
+	}
+}
+
+func TestUserController_ListSessions_NewestFirstWithoutTokens(t *testing.T) {
+	now := time.Now()
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("ListByUser", mock.Anything, "user-1").Return([]*Session{
+		{ID: "old", UserID: "user-1", Token: "token-old", CreatedAt: now.Add(-2 * time.Hour)},
+		{ID: "current", UserID: "user-1", Token: "token-current", CreatedAt: now, UserAgent: "Firefox"},
+		{ID: "middle", UserID: "user-1", Token: "token-middle", CreatedAt: now.Add(-time.Hour)},
+	}, nil)
+
+	controller := &UserController{sessionRepo: mockSessionRepo, log: logger.New("test")}
+
+	sessions, err := controller.ListSessions(context.Background(), "user-1", "current")
+
+	require.NoError(t, err)
+	require.Len(t, sessions, 3)
+	assert.Equal(t, "current", sessions[0].ID)
+	assert.True(t, sessions[0].Current)
+	assert.Equal(t, "Firefox", sessions[0].UserAgent)
+	assert.Equal(t, "middle", sessions[1].ID)
+	assert.False(t, sessions[1].Current)
+	assert.Equal(t, "old", sessions[2].ID)
+
+	encoded, err := json.Marshal(sessions)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), "token-")
+}
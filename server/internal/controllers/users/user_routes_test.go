@@ -0,0 +1,1299 @@
+package userController
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"server/internal/routes/middleware"
+	"server/internal/tracing"
+	"server/internal/utils"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupUserRoutesTest() *fiber.App {
+	testConfig := config.Config{
+		Security: config.SecurityConfig{
+			Salt:      12,
+			Pepper:    "test-pepper",
+			JwtSecret: "test-jwt-secret",
+		},
+	}
+
+	eventBus := events.New(nil, testConfig, nil)
+	mw := middleware.New(database.DB{}, eventBus, testConfig, nil, nil, nil)
+	controller := New(eventBus, &MockUserRepository{}, &MockSessionRepository{}, &MockLoginEventRepository{}, &MockPreferenceRepository{}, nil, mw, testConfig)
+
+	fiberApp := fiber.New()
+	controller.RegisterRoutes(fiberApp)
+	return fiberApp
+}
+
+func TestUserController_RegisterRoutes(t *testing.T) {
+	fiberApp := setupUserRoutesTest()
+
+	routes := make([]string, 0)
+	for _, route := range fiberApp.GetRoutes(true) {
+		routes = append(routes, route.Method+" "+route.Path)
+	}
+
+	assert.Contains(t, routes, "POST /users/login")
+	assert.Contains(t, routes, "POST /users/register")
+	assert.Contains(t, routes, "GET /users/")
+	assert.Contains(t, routes, "POST /users/logout")
+	assert.Contains(t, routes, "POST /users/password")
+	assert.Contains(t, routes, "DELETE /users/sessions/:id")
+	assert.Contains(t, routes, "POST /users/sessions/revoke-others")
+	assert.Contains(t, routes, "PATCH /users/me")
+}
+
+func TestUserController_RegisterRoutes_ProtectsAuthenticatedRoutes(t *testing.T) {
+	fiberApp := setupUserRoutesTest()
+
+	req := httptest.NewRequest("GET", "/users/", nil)
+	req.Header.Set("X-Client-Type", "web")
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+}
+
+func TestUserController_HandleRegister_BadBody(t *testing.T) {
+	fiberApp := setupUserRoutesTest()
+
+	req := httptest.NewRequest("POST", "/users/register", strings.NewReader("{"))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+}
+
+func TestUserController_HandleExport_Attachment(t *testing.T) {
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("ListByUser", mock.Anything, "user-1").
+		Return([]*Session{{ID: "session-1", Token: "secret-jwt"}}, nil)
+	mockLoginEventRepo := &MockLoginEventRepository{}
+	mockLoginEventRepo.On("StreamByUser", mock.Anything, "user-1").Return([]LoginEvent{}, nil)
+	mockPreferenceRepo := &MockPreferenceRepository{}
+	mockPreferenceRepo.On("ListByUser", mock.Anything, "user-1").Return([]UserPreference{}, nil)
+
+	controller := &UserController{
+		sessionRepo:    mockSessionRepo,
+		loginEventRepo: mockLoginEventRepo,
+		preferenceRepo: mockPreferenceRepo,
+		log:            logger.New("test"),
+	}
+
+	fiberApp := fiber.New()
+	fiberApp.Get("/export", func(c *fiber.Ctx) error {
+		c.Locals("user", User{BaseModel: BaseModel{ID: "user-1"}, Login: "jdoe", Password: "hash"})
+		return c.Next()
+	}, controller.handleExport)
+
+	resp, err := fiberApp.Test(httptest.NewRequest("GET", "/export", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get(fiber.HeaderContentDisposition), "attachment")
+	assert.Contains(t, resp.Header.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var document map[string]any
+	require.NoError(t, json.Unmarshal(body, &document))
+	assert.Contains(t, document, "profile")
+	assert.NotContains(t, string(body), "secret-jwt")
+}
+
+func setupSessionRoutesTest(sessionRepo *MockSessionRepository) *fiber.App {
+	controller := &UserController{
+		sessionRepo: sessionRepo,
+		log:         logger.New("test"),
+	}
+
+	fiberApp := fiber.New()
+	fiberApp.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", User{BaseModel: BaseModel{ID: "user-1"}})
+		c.Locals("session", Session{ID: "current", UserID: "user-1"})
+		return c.Next()
+	})
+	fiberApp.Delete("/sessions/:id", controller.handleRevokeSession)
+	fiberApp.Post("/sessions/revoke-others", controller.handleRevokeOtherSessions)
+	return fiberApp
+}
+
+func TestUserController_HandleRevokeSession_CurrentSessionLogsOut(t *testing.T) {
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("Delete", mock.Anything, "current").Return(nil)
+	fiberApp := setupSessionRoutesTest(mockSessionRepo)
+
+	req := httptest.NewRequest("DELETE", "/sessions/current", nil)
+	req.Header.Set("Cookie", SESSION_COOKIE_KEY+"=current")
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	cookie := resp.Header.Get(fiber.HeaderSetCookie)
+	assert.Contains(t, cookie, SESSION_COOKIE_KEY+"=;")
+	assert.Contains(t, cookie, "expires=")
+	mockSessionRepo.AssertCalled(t, "Delete", mock.Anything, "current")
+	mockSessionRepo.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+}
+
+func TestUserController_HandleLogout_PublishesLogout(t *testing.T) {
+	eventBus := events.New(nil, config.Config{}, nil)
+	logouts := make(chan events.UserLogoutEvent, 1)
+	require.NoError(t, eventBus.UserLogoutTopic().Subscribe(
+		func(ctx context.Context, event events.UserLogoutEvent) error {
+			logouts <- event
+			return nil
+		},
+	))
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("Delete", mock.Anything, "current").Return(nil)
+	controller := &UserController{
+		sessionRepo: mockSessionRepo,
+		eventBus:    eventBus,
+		log:         logger.New("test"),
+	}
+	fiberApp := fiber.New()
+	fiberApp.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", User{BaseModel: BaseModel{ID: "user-1"}})
+		return c.Next()
+	})
+	fiberApp.Post("/users/logout", controller.handleLogout)
+
+	req := httptest.NewRequest("POST", "/users/logout", nil)
+	req.Header.Set("Cookie", SESSION_COOKIE_KEY+"=current")
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	select {
+	case event := <-logouts:
+		assert.Equal(t, events.UserLogoutEvent{UserID: "user-1", SessionID: "current"}, event)
+	case <-time.After(time.Second):
+		t.Fatal("logout was not published")
+	}
+}
+
+func TestUserController_HandleLogout_ByClientType(t *testing.T) {
+	testCases := []struct {
+		name       string
+		clientType string
+		cookie     bool
+	}{
+		{"web", middleware.WEB_CLIENT_TYPE, true},
+		{"mobile", middleware.MOBILE_CLIENT_TYPE, false},
+		{"registered token client", "tauri", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockSessionRepo := &MockSessionRepository{}
+			mockSessionRepo.On("Delete", mock.Anything, "current").Return(nil)
+			controller := &UserController{
+				sessionRepo: mockSessionRepo,
+				Config:      config.Config{Session: config.SessionConfig{ClientTypes: "tauri=token"}},
+				log:         logger.New("test"),
+			}
+			fiberApp := fiber.New()
+			fiberApp.Use(func(c *fiber.Ctx) error {
+				c.Locals("user", User{BaseModel: BaseModel{ID: "user-1"}})
+				c.Locals("session", Session{ID: "current"})
+				return c.Next()
+			})
+			fiberApp.Post("/users/logout", controller.handleLogout)
+
+			req := httptest.NewRequest("POST", "/users/logout", nil)
+			req.Header.Set(middleware.CLIENT_TYPE_HEADER, tc.clientType)
+			if tc.cookie {
+				req.Header.Set("Cookie", SESSION_COOKIE_KEY+"=current")
+			}
+			resp, err := fiberApp.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			mockSessionRepo.AssertCalled(t, "Delete", mock.Anything, "current")
+			assert.Equal(t, tc.cookie, resp.Header.Get(fiber.HeaderSetCookie) != "")
+		})
+	}
+}
+
+func TestUserController_HandleRevokeSession_Ownership(t *testing.T) {
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, "mine").
+		Return(&Session{ID: "mine", UserID: "user-1"}, nil)
+	mockSessionRepo.On("GetByID", mock.Anything, "theirs").
+		Return(&Session{ID: "theirs", UserID: "user-2"}, nil)
+	mockSessionRepo.On("GetByID", mock.Anything, "missing").
+		Return((*Session)(nil), repositories.ErrNotFound)
+	mockSessionRepo.On("GetByID", mock.Anything, "unreadable").
+		Return((*Session)(nil), errors.New("cache down"))
+	mockSessionRepo.On("Delete", mock.Anything, "mine").Return(nil)
+	fiberApp := setupSessionRoutesTest(mockSessionRepo)
+
+	testCases := []struct {
+		sessionID string
+		status    int
+	}{
+		{"mine", fiber.StatusNoContent},
+		{"theirs", fiber.StatusNotFound},
+		{"missing", fiber.StatusNotFound},
+		{"unreadable", fiber.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.sessionID, func(t *testing.T) {
+			resp, err := fiberApp.Test(httptest.NewRequest("DELETE", "/sessions/"+tc.sessionID, nil))
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get(fiber.HeaderSetCookie))
+		})
+	}
+
+	mockSessionRepo.AssertNotCalled(t, "Delete", mock.Anything, "theirs")
+}
+
+func TestUserController_HandleRevokeOtherSessions(t *testing.T) {
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("ListByUser", mock.Anything, "user-1").Return([]*Session{
+		{ID: "current", UserID: "user-1"},
+		{ID: "phone", UserID: "user-1"},
+		{ID: "tablet", UserID: "user-1"},
+	}, nil)
+	mockSessionRepo.On("Delete", mock.Anything, mock.Anything).Return(nil)
+	fiberApp := setupSessionRoutesTest(mockSessionRepo)
+
+	resp, err := fiberApp.Test(httptest.NewRequest("POST", "/sessions/revoke-others", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var result map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, float64(2), result["revoked"])
+	mockSessionRepo.AssertNotCalled(t, "Delete", mock.Anything, "current")
+}
+
+func TestUserController_HandleUpdateProfile(t *testing.T) {
+	testCases := []struct {
+		name            string
+		ifMatch         string
+		expectedVersion int
+		repoErr         error
+		status          int
+		warning         bool
+	}{
+		{"matching version", "2", 2, nil, fiber.StatusOK, false},
+		{"stale version", "1", 1, &repositories.VersionConflictError{Current: 2}, fiber.StatusPreconditionFailed, false},
+		{"no precondition", "", 0, nil, fiber.StatusOK, true},
+		{"any version", "*", 0, nil, fiber.StatusOK, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockUserRepo := &MockUserRepository{}
+			mockUserRepo.On("GetByID", mock.Anything, "user-1").
+				Return(&User{BaseModel: BaseModel{ID: "user-1"}, FirstName: "Jane", Version: 2}, nil)
+			mockUserRepo.On("UpdateProfile", mock.Anything, mock.MatchedBy(func(u *User) bool {
+				return u.FirstName == "Janet"
+			}), tc.expectedVersion).Return(tc.repoErr)
+
+			controller := &UserController{userRepo: mockUserRepo, log: logger.New("test")}
+			fiberApp := fiber.New()
+			fiberApp.Patch("/me", func(c *fiber.Ctx) error {
+				c.Locals("user", User{BaseModel: BaseModel{ID: "user-1"}})
+				return c.Next()
+			}, controller.handleUpdateProfile)
+
+			req := httptest.NewRequest("PATCH", "/me", strings.NewReader(`{"firstName":" Janet "}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+
+			resp, err := fiberApp.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+			assert.Equal(t, tc.warning, resp.Header.Get(fiber.HeaderWarning) != "")
+			mockUserRepo.AssertExpectations(t)
+
+			if tc.status == fiber.StatusPreconditionFailed {
+				var result map[string]any
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+				assert.Equal(t, float64(2), result["version"])
+			}
+		})
+	}
+}
+
+func TestUserController_HandleUpdateProfile_TooLong(t *testing.T) {
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").
+		Return(&User{BaseModel: BaseModel{ID: "user-1"}, Version: 1}, nil)
+
+	controller := &UserController{userRepo: mockUserRepo, log: logger.New("test")}
+	fiberApp := fiber.New()
+	fiberApp.Patch("/me", func(c *fiber.Ctx) error {
+		c.Locals("user", User{BaseModel: BaseModel{ID: "user-1"}})
+		return c.Next()
+	}, controller.handleUpdateProfile)
+
+	body := `{"lastName":"` + strings.Repeat("x", USER_NAME_MAX+1) + `"}`
+	req := httptest.NewRequest("PATCH", "/me", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+	mockUserRepo.AssertNotCalled(t, "UpdateProfile", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func setupRegisterRoutesTest(
+	t *testing.T,
+	autoLogin bool,
+	trustedProxies ...string,
+) (*fiber.App, *MockUserRepository, *MockSessionRepository, chan string) {
+	t.Helper()
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByLogin", mock.Anything, "taken").Return(&User{Login: "taken"}, nil)
+	mockUserRepo.On("GetByLogin", mock.Anything, "newuser").Return((*User)(nil), repositories.ErrNotFound)
+	mockUserRepo.On("Create", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { args.Get(1).(*User).ID = "user-1" }).
+		Return(nil)
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("Create", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			session := args.Get(1).(*Session)
+			session.ID = "session-1"
+			session.Token = "session-jwt"
+		}).
+		Return(nil)
+
+	mockLoginEventRepo := &MockLoginEventRepository{}
+	mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	eventBus := events.New(nil, config.Config{}, nil)
+	published := make(chan string, 4)
+	require.NoError(t, eventBus.Subscribe(events.WILDCARD_CHANNEL, func(event events.Event) error {
+		published <- event.Channel
+		return nil
+	}))
+
+	controller := &UserController{
+		userRepo:       mockUserRepo,
+		sessionRepo:    mockSessionRepo,
+		loginEventRepo: mockLoginEventRepo,
+		eventBus:       eventBus,
+		Config: config.Config{
+			Security: config.SecurityConfig{
+				MinPasswordScore:      2,
+				Salt:                  bcrypt.MinCost,
+				Pepper:                "test-pepper",
+				RegistrationAutoLogin: autoLogin,
+			},
+		},
+		log: logger.New("test"),
+	}
+
+	fiberApp := fiber.New(fiber.Config{EnableTrustedProxyCheck: true, TrustedProxies: trustedProxies})
+	fiberApp.Post("/api/v1/users/register", controller.handleRegister)
+	return fiberApp, mockUserRepo, mockSessionRepo, published
+}
+
+func TestUserController_HandleValidateRegistration(t *testing.T) {
+	testConfig := config.Config{Security: config.SecurityConfig{MinPasswordScore: 2, ValidateRateLimit: 3}}
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByLogin", mock.Anything, "taken").Return(&User{Login: "taken"}, nil)
+	mw := middleware.New(database.DB{}, nil, testConfig, nil, nil, nil)
+	controller := New(nil, mockUserRepo, &MockSessionRepository{}, &MockLoginEventRepository{}, &MockPreferenceRepository{}, nil, mw, testConfig)
+
+	fiberApp := fiber.New()
+	controller.RegisterRoutes(fiberApp.Group("/api/v1"))
+	controller.RegisterRoutes(fiberApp.Group("/api"))
+
+	send := func(path string, body string) *http.Response {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := fiberApp.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := send("/api/v1/users/validate", `{"login":"taken","password":"password1234"}`)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get(fiber.HeaderCacheControl))
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, map[string]any{
+		"valid": false,
+		"fields": map[string]any{
+			"login":    map[string]any{"ok": false, "code": "login_taken", "message": "login is already taken"},
+			"password": map[string]any{"ok": false, "code": "password_too_weak", "message": "password is too weak"},
+		},
+	}, body)
+	mockUserRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+
+	// The limit is per IP across both mounts, and leaves register alone
+	assert.Equal(t, fiber.StatusOK, send("/api/v1/users/validate", `{}`).StatusCode)
+	assert.Equal(t, fiber.StatusOK, send("/api/users/validate", `{}`).StatusCode)
+	limited := send("/api/users/validate", `{}`)
+	assert.Equal(t, fiber.StatusTooManyRequests, limited.StatusCode)
+	assert.NotEmpty(t, limited.Header.Get(fiber.HeaderRetryAfter))
+	assert.NotEqual(t, fiber.StatusTooManyRequests, send("/api/v1/users/register", `{}`).StatusCode)
+}
+
+func registerRequest(login, clientType string) *http.Request {
+	body := `{"login":"` + login + `","password":"glacier umbrella voltage","firstName":"New"}`
+	req := httptest.NewRequest("POST", "/api/v1/users/register", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Client-Type", clientType)
+	return req
+}
+
+// receiveChannels collects n published event channels.
+func receiveChannels(t *testing.T, published chan string, n int) []string {
+	t.Helper()
+	channels := make([]string, 0, n)
+	for range n {
+		select {
+		case channel := <-published:
+			channels = append(channels, channel)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d events, got %v", n, channels)
+		}
+	}
+	return channels
+}
+
+func TestUserController_HandleRegister_LogsIn(t *testing.T) {
+	for _, clientType := range []string{"web", "mobile"} {
+		t.Run(clientType, func(t *testing.T) {
+			fiberApp, _, mockSessionRepo, published := setupRegisterRoutesTest(t, true)
+
+			resp, err := fiberApp.Test(registerRequest("newuser", clientType))
+			require.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+			assert.Equal(t, "http://example.com/api/v1/users/", resp.Header.Get(fiber.HeaderLocation))
+			assert.Contains(t, resp.Header.Get(fiber.HeaderSetCookie), SESSION_COOKIE_KEY+"=session-1;")
+			assert.Equal(t, "session-jwt", resp.Header.Get("X-Auth-Token"))
+			mockSessionRepo.AssertNumberOfCalls(t, "Create", 1)
+
+			var result map[string]any
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			user := result["user"].(map[string]any)
+			assert.Equal(t, "newuser", user["login"])
+			assert.NotContains(t, user, "password")
+
+			assert.ElementsMatch(t, []string{"user.registered", "user.login"}, receiveChannels(t, published, 2))
+		})
+	}
+}
+
+func TestUserController_HandleRegister_ForwardedProto(t *testing.T) {
+	testCases := []struct {
+		name     string
+		proxies  []string
+		location string
+		secure   bool
+	}{
+		// Test requests come from 0.0.0.0
+		{"trusted proxy", []string{"0.0.0.0"}, "https://app.example.com/api/v1/users/", true},
+		{"untrusted source", []string{"10.0.0.1"}, "http://example.com/api/v1/users/", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fiberApp, _, _, _ := setupRegisterRoutesTest(t, true, tc.proxies...)
+
+			req := registerRequest("newuser", "web")
+			req.Header.Set(fiber.HeaderXForwardedProto, "https")
+			req.Header.Set(fiber.HeaderXForwardedHost, "app.example.com")
+			resp, err := fiberApp.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+			assert.Equal(t, tc.location, resp.Header.Get(fiber.HeaderLocation))
+			cookies := resp.Cookies()
+			require.Len(t, cookies, 1)
+			assert.Equal(t, tc.secure, cookies[0].Secure)
+		})
+	}
+}
+
+func TestUserController_HandleRegister_AutoLoginDisabled(t *testing.T) {
+	fiberApp, mockUserRepo, mockSessionRepo, published := setupRegisterRoutesTest(t, false)
+
+	resp, err := fiberApp.Test(registerRequest("newuser", "web"))
+	require.NoError(t, err)
+
+	assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "http://example.com/api/v1/users/", resp.Header.Get(fiber.HeaderLocation))
+	assert.Empty(t, resp.Header.Get(fiber.HeaderSetCookie))
+	assert.Empty(t, resp.Header.Get("X-Auth-Token"))
+	mockUserRepo.AssertCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+	mockSessionRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+	assert.Equal(t, []string{"user.registered"}, receiveChannels(t, published, 1))
+}
+
+func TestUserController_HandleRegister_DuplicateLoginHasNoSession(t *testing.T) {
+	fiberApp, mockUserRepo, mockSessionRepo, _ := setupRegisterRoutesTest(t, true)
+
+	resp, err := fiberApp.Test(registerRequest("taken", "mobile"))
+	require.NoError(t, err)
+
+	assert.Equal(t, fiber.StatusConflict, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(fiber.HeaderSetCookie))
+	assert.Empty(t, resp.Header.Get("X-Auth-Token"))
+	mockUserRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+	mockSessionRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUserController_HandleRegister_PasswordTooLong(t *testing.T) {
+	fiberApp, mockUserRepo, mockSessionRepo, _ := setupRegisterRoutesTest(t, true)
+
+	body := `{"login":"newuser","password":"` + strings.Repeat("a", 73) + `","firstName":"New"}`
+	req := httptest.NewRequest("POST", "/api/v1/users/register", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+	var result map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, "password_too_long", result["details"].(map[string]any)["password"].(map[string]any)["code"])
+	mockUserRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+	mockSessionRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// racingUserRepository holds every login check until all of them have run, so
+// concurrent registrations all get past the check before any creates a user.
+type racingUserRepository struct {
+	repositories.UserRepository
+	checked sync.WaitGroup
+}
+
+func (r *racingUserRepository) GetByLogin(ctx context.Context, login string) (*User, error) {
+	user, err := r.UserRepository.GetByLogin(ctx, login)
+	r.checked.Done()
+	r.checked.Wait()
+	return user, err
+}
+
+func TestUserController_HandleRegister_ConcurrentSameLogin(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(database.SQLiteDSN(filepath.Join(t.TempDir(), "users.db"))), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+
+	const attempts = 8
+	userRepo := &racingUserRepository{UserRepository: repositories.New(database.DB{SQL: db}, invalidator)}
+	userRepo.checked.Add(attempts)
+
+	controller := &UserController{
+		userRepo: userRepo,
+		Config: config.Config{
+			Security: config.SecurityConfig{
+				MinPasswordScore: 2,
+				Salt:             bcrypt.MinCost,
+				Pepper:           "test-pepper",
+			},
+		},
+		log: logger.New("test"),
+	}
+	fiberApp := fiber.New()
+	fiberApp.Post("/api/v1/users/register", controller.handleRegister)
+
+	statuses := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := fiberApp.Test(registerRequest("racer", "web"), -1)
+			if assert.NoError(t, err) {
+				statuses <- resp.StatusCode
+			}
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(t, map[int]int{fiber.StatusCreated: 1, fiber.StatusConflict: attempts - 1}, counts)
+}
+
+func TestUserController_LoginIgnoresCaseAndSpacing(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(database.SQLiteDSN(filepath.Join(t.TempDir(), "users.db"))), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockLoginEventRepo := &MockLoginEventRepository{}
+	mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	controller := &UserController{
+		userRepo:       repositories.New(database.DB{SQL: db}, invalidator),
+		sessionRepo:    mockSessionRepo,
+		loginEventRepo: mockLoginEventRepo,
+		Config: config.Config{
+			Security: config.SecurityConfig{Salt: bcrypt.MinCost, Pepper: "test-pepper"},
+		},
+		log: logger.New("test"),
+	}
+	const password = "glacier umbrella voltage"
+
+	registered, err := controller.CreateAdmin(context.Background(), RegisterRequest{Login: " Ren\u00e9e ", Password: password})
+	require.NoError(t, err)
+	assert.Equal(t, "ren\u00e9e", registered.Login)
+
+	for _, login := range []string{"ren\u00e9e", "REN\u00c9E", "  Rene\u0301e\t"} {
+		user, _, err := controller.Login(context.Background(), LoginRequest{Login: login, Password: password})
+		require.NoError(t, err, login)
+		assert.Equal(t, registered.ID, user.ID)
+	}
+
+	for _, login := range []string{"RENE\u0301E", "ren\u00e9e  "} {
+		_, err := controller.CreateAdmin(context.Background(), RegisterRequest{Login: login, Password: password})
+		assert.ErrorIs(t, err, ErrLoginTaken, login)
+	}
+	_, err = controller.CreateAdmin(context.Background(), RegisterRequest{Login: "ren\u200b\u00e9e", Password: password})
+	var validationErr *utils.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "invisible_characters", validationErr.Details["login"].(map[string]any)["code"])
+}
+
+func TestUserController_Terms(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(database.SQLiteDSN(filepath.Join(t.TempDir(), "users.db"))), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+
+	fakeClock := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	controller := &UserController{
+		userRepo: repositories.New(database.DB{SQL: db}, invalidator),
+		Config: config.Config{
+			TermsVersion: "2024-06",
+			Security:     config.SecurityConfig{MinPasswordScore: 2, Salt: bcrypt.MinCost, Pepper: "test-pepper"},
+		},
+		clock: fakeClock,
+		log:   logger.New("test"),
+	}
+	fiberApp := fiber.New()
+	fiberApp.Post("/api/v1/users/register", controller.handleRegister)
+	fiberApp.Post("/api/v1/users/me/accept-tos", func(c *fiber.Ctx) error {
+		var user User
+		require.NoError(t, db.Where("login = ?", "newuser").First(&user).Error)
+		c.Locals("user", user)
+		return c.Next()
+	}, controller.handleAcceptTerms)
+
+	send := func(path string, body string) (int, map[string]any) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Client-Type", "web")
+		resp, err := fiberApp.Test(req)
+		require.NoError(t, err)
+		var result map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return resp.StatusCode, result
+	}
+	const register = `{"login":"newuser","password":"glacier umbrella voltage","tosVersion":%q}`
+
+	for _, stale := range []string{"", "2024-01"} {
+		status, body := send("/api/v1/users/register", fmt.Sprintf(register, stale))
+		assert.Equal(t, fiber.StatusUnprocessableEntity, status, stale)
+		assert.Equal(t, ErrorCodeTermsOutdated, body["code"], stale)
+		assert.Equal(t, "2024-06", body["tosVersion"], stale)
+	}
+	var count int64
+	require.NoError(t, db.Model(&User{}).Count(&count).Error)
+	assert.Zero(t, count)
+
+	status, _ := send("/api/v1/users/register", fmt.Sprintf(register, "2024-06"))
+	require.Equal(t, fiber.StatusCreated, status)
+	var stored User
+	require.NoError(t, db.Where("login = ?", "newuser").First(&stored).Error)
+	assert.Equal(t, "2024-06", stored.TosVersion)
+	require.NotNil(t, stored.TosAcceptedAt)
+	assert.True(t, fakeClock.Now().Equal(*stored.TosAcceptedAt))
+
+	// A new version has to be accepted as it is, not an older one
+	controller.Config.TermsVersion = "2025-01"
+	fakeClock.Advance(time.Hour)
+	status, body := send("/api/v1/users/me/accept-tos", `{"tosVersion":"2024-06"}`)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+	assert.Equal(t, ErrorCodeTermsOutdated, body["code"])
+
+	status, body = send("/api/v1/users/me/accept-tos", `{"tosVersion":"2025-01"}`)
+	require.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "2025-01", body["user"].(map[string]any)["tosVersion"])
+	require.NoError(t, db.Where("login = ?", "newuser").First(&stored).Error)
+	assert.Equal(t, "2025-01", stored.TosVersion)
+	assert.True(t, fakeClock.Now().Equal(*stored.TosAcceptedAt))
+	assert.NotEmpty(t, stored.Password, "accepting must not clear the password")
+}
+
+func TestUserController_HandleLogin_Traced(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(database.SQLiteDSN(filepath.Join(t.TempDir(), "users.db"))), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+	require.NoError(t, db.Use(database.TracingPlugin{}))
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockLoginEventRepo := &MockLoginEventRepository{}
+	mockLoginEventRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+	controller := &UserController{
+		userRepo:       repositories.New(database.DB{SQL: db}, invalidator),
+		sessionRepo:    mockSessionRepo,
+		loginEventRepo: mockLoginEventRepo,
+		Config: config.Config{
+			Security: config.SecurityConfig{Salt: bcrypt.MinCost, Pepper: "test-pepper"},
+		},
+		log: logger.New("test"),
+	}
+	const password = "glacier umbrella voltage"
+	_, err = controller.CreateAdmin(context.Background(), RegisterRequest{Login: "jdoe", Password: password})
+	require.NoError(t, err)
+
+	recorder := &tracing.Recorder{}
+	tracer := tracing.NewTracer(recorder, 1)
+	tracing.Install(tracer)
+	t.Cleanup(func() { tracing.Install(nil) })
+
+	mw := middleware.New(database.DB{}, nil, config.Config{}, nil, nil, nil)
+	fiberApp := fiber.New()
+	fiberApp.Use(mw.Tracing())
+	fiberApp.Post("/api/v1/users/login", controller.handleLogin)
+
+	req := jsonRequest("POST", "/api/v1/users/login", `{"login":"jdoe","password":"`+password+`"}`)
+	req.Header.Set(tracing.TRACEPARENT_HEADER, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	require.NoError(t, tracer.Flush(context.Background()))
+
+	spans := make(map[string]tracing.SpanData)
+	for _, span := range recorder.Spans() {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID.String(), span.Name)
+		spans[span.Name] = span
+	}
+	require.Contains(t, spans, "POST /api/v1/users/login")
+	require.Contains(t, spans, "UserController.Login")
+	require.Contains(t, spans, "userRepository.GetByLogin")
+	require.Contains(t, spans, "db.query")
+	require.Contains(t, spans, "cache.set")
+
+	request := spans["POST /api/v1/users/login"]
+	assert.Equal(t, tracing.SPAN_KIND_SERVER, request.Kind)
+	assert.Equal(t, "00f067aa0ba902b7", request.ParentID.String(), "the caller's span is the parent")
+	assert.Contains(t, request.Attributes, tracing.Attribute{Key: "http.response.status_code", Value: int64(fiber.StatusOK)})
+	assert.Equal(t, request.SpanID, spans["UserController.Login"].ParentID)
+	assert.Equal(t, spans["UserController.Login"].SpanID, spans["userRepository.GetByLogin"].ParentID)
+	assert.Equal(t, spans["userRepository.GetByLogin"].SpanID, spans["db.query"].ParentID)
+	assert.Equal(t, spans["userRepository.GetByLogin"].SpanID, spans["cache.set"].ParentID)
+	assert.Contains(t, spans["db.query"].Attributes, tracing.Attribute{Key: "db.sql.table", Value: "users"})
+}
+
+func TestUserController_HandleSetPreference(t *testing.T) {
+	mockPreferenceRepo := &MockPreferenceRepository{}
+	mockPreferenceRepo.On("Set", mock.Anything, mock.MatchedBy(func(preference *UserPreference) bool {
+		return preference.UserID == "user-1" &&
+			preference.Key == "notifications" &&
+			preference.Value == `{"broadcasts":false,"mentions":true}`
+	})).Return(nil)
+
+	controller := &UserController{preferenceRepo: mockPreferenceRepo, log: logger.New("test")}
+	fiberApp := fiber.New()
+	fiberApp.Put("/me/preferences/:key", func(c *fiber.Ctx) error {
+		c.Locals("user", User{BaseModel: BaseModel{ID: "user-1"}})
+		return c.Next()
+	}, controller.handleSetPreference)
+
+	testCases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"valid", `{ "broadcasts": false, "mentions": true }`, fiber.StatusOK},
+		{"schema violation", `{"broadcasts":false}`, fiber.StatusUnprocessableEntity},
+		{"bad quiet hours", `{"broadcasts":true,"mentions":true,"quietHours":{"start":"22:00","end":"07:00","timezone":"Nowhere"}}`,
+			fiber.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/me/preferences/notifications", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := fiberApp.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+		})
+	}
+	mockPreferenceRepo.AssertNumberOfCalls(t, "Set", 1)
+}
+
+func setupBotCheckRoutesTest(
+	t *testing.T,
+	cfg config.Config,
+	clk clock.Clock,
+) (*fiber.App, *MockUserRepository) {
+	t.Helper()
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByLogin", mock.Anything, "newuser").Return((*User)(nil), repositories.ErrNotFound)
+	mockUserRepo.On("Create", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { args.Get(1).(*User).ID = "user-1" }).
+		Return(nil)
+
+	cfg.Security.MinPasswordScore = 2
+	cfg.Security.Salt = bcrypt.MinCost
+	cfg.Security.Pepper = "test-pepper"
+	cfg.Security.JwtSecret = "test-secret"
+
+	controller := &UserController{
+		userRepo: mockUserRepo,
+		eventBus: events.New(nil, config.Config{}, nil),
+		Config:   cfg,
+		log:      logger.New("test"),
+		clock:    clk,
+	}
+
+	fiberApp := fiber.New()
+	fiberApp.Post("/api/v1/users/login", controller.handleLogin)
+	fiberApp.Post("/api/v1/users/register", controller.handleRegister)
+	fiberApp.Get("/api/v1/users/form-token", controller.handleFormToken)
+	return fiberApp, mockUserRepo
+}
+
+func jsonRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestUserController_Honeypot(t *testing.T) {
+	fiberApp, mockUserRepo := setupBotCheckRoutesTest(t, config.Config{Security: config.SecurityConfig{HoneypotEnabled: true}}, nil)
+
+	resp, err := fiberApp.Test(jsonRequest("POST", "/api/v1/users/register",
+		`{"login":"newuser","password":"glacier umbrella voltage","firstName":"New","website":"http://spam.example"}`))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "http://example.com/api/v1/users/", resp.Header.Get(fiber.HeaderLocation))
+	assert.Empty(t, resp.Cookies(), "bots never get a session")
+
+	var body struct {
+		User User `json:"user"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "newuser", body.User.Login)
+	assert.NotEmpty(t, body.User.ID)
+
+	resp, err = fiberApp.Test(jsonRequest("POST", "/api/v1/users/login",
+		`{"login":"newuser","password":"glacier umbrella voltage","website":"http://spam.example"}`))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Cookies())
+
+	mockUserRepo.AssertNotCalled(t, "GetByLogin", mock.Anything, mock.Anything)
+	mockUserRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUserController_HoneypotDisabled(t *testing.T) {
+	fiberApp, mockUserRepo := setupBotCheckRoutesTest(t, config.Config{}, nil)
+
+	resp, err := fiberApp.Test(jsonRequest("POST", "/api/v1/users/register",
+		`{"login":"newuser","password":"glacier umbrella voltage","firstName":"New","website":"http://example.com"}`))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+	mockUserRepo.AssertNumberOfCalls(t, "Create", 1)
+}
+
+func TestUserController_FormToken(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC))
+	cfg := config.Config{Security: config.SecurityConfig{FormTokenEnabled: true, FormTokenMinAge: 3 * time.Second}}
+	fiberApp, mockUserRepo := setupBotCheckRoutesTest(t, cfg, fake)
+
+	resp, err := fiberApp.Test(httptest.NewRequest("GET", "/api/v1/users/form-token", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get(fiber.HeaderCacheControl))
+
+	var issued struct {
+		FormToken string `json:"formToken"`
+		MinAge    int    `json:"minAge"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&issued))
+	assert.Equal(t, 3, issued.MinAge)
+
+	register := func(token string) int {
+		resp, err := fiberApp.Test(jsonRequest("POST", "/api/v1/users/register",
+			`{"login":"newuser","password":"glacier umbrella voltage","firstName":"New","formToken":"`+token+`"}`))
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, fiber.StatusUnprocessableEntity, register(""), "missing")
+	assert.Equal(t, fiber.StatusUnprocessableEntity, register(issued.FormToken), "too quick")
+	mockUserRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+
+	fake.Advance(5 * time.Second)
+	assert.Equal(t, fiber.StatusCreated, register(issued.FormToken))
+
+	fake.Advance(2 * time.Hour)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, register(issued.FormToken), "expired")
+	mockUserRepo.AssertNumberOfCalls(t, "Create", 1)
+}
+
+func TestUserController_FormTokenDisabled(t *testing.T) {
+	fiberApp, mockUserRepo := setupBotCheckRoutesTest(t, config.Config{}, nil)
+
+	resp, err := fiberApp.Test(jsonRequest("POST", "/api/v1/users/register",
+		`{"login":"newuser","password":"glacier umbrella voltage","firstName":"New"}`))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+	mockUserRepo.AssertNumberOfCalls(t, "Create", 1)
+}
+
+func setupStopImpersonationTest(
+	t *testing.T,
+	session Session,
+	sessionRepo *MockSessionRepository,
+) (*fiber.App, chan events.ImpersonationEvent, *MockAuditRepository) {
+	t.Helper()
+
+	eventBus := events.New(nil, config.Config{}, nil)
+	audit := make(chan events.ImpersonationEvent, 1)
+	require.NoError(t, eventBus.ImpersonationTopic().Subscribe(
+		func(ctx context.Context, event events.ImpersonationEvent) error {
+			audit <- event
+			return nil
+		},
+	))
+
+	auditRepo := &MockAuditRepository{}
+	auditRepo.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	controller := &UserController{
+		sessionRepo: sessionRepo,
+		auditRepo:   auditRepo,
+		eventBus:    eventBus,
+		log:         logger.New("test"),
+	}
+
+	fiberApp := fiber.New()
+	fiberApp.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", User{BaseModel: BaseModel{ID: "user-2"}})
+		c.Locals("session", session)
+		return c.Next()
+	})
+	fiberApp.Post("/users/stop-impersonation", controller.handleStopImpersonation)
+	return fiberApp, audit, auditRepo
+}
+
+func TestUserController_HandleStopImpersonation(t *testing.T) {
+	impersonation := Session{
+		ID:                    "impersonation-1",
+		UserID:                "user-2",
+		ImpersonatedBy:        "admin-1",
+		ImpersonatorSessionID: "admin-session",
+	}
+
+	testCases := []struct {
+		name         string
+		adminSession *Session
+		restored     bool
+	}{
+		{"returns to the admin's session", &Session{ID: "admin-session", UserID: "admin-1", Token: "admin-jwt",
+			ExpiresAt: time.Now().Add(time.Hour)}, true},
+		{"admin session expired", &Session{ID: "admin-session", UserID: "admin-1",
+			ExpiresAt: time.Now().Add(-time.Minute)}, false},
+		{"admin session gone", nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockSessionRepo := &MockSessionRepository{}
+			mockSessionRepo.On("Delete", mock.Anything, "impersonation-1").Return(nil)
+			if tc.adminSession != nil {
+				mockSessionRepo.On("GetByID", mock.Anything, "admin-session").Return(tc.adminSession, nil)
+			} else {
+				mockSessionRepo.On("GetByID", mock.Anything, "admin-session").Return((*Session)(nil), errors.New("not found"))
+			}
+			fiberApp, audit, auditRepo := setupStopImpersonationTest(t, impersonation, mockSessionRepo)
+
+			resp, err := fiberApp.Test(httptest.NewRequest("POST", "/users/stop-impersonation", nil))
+			require.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			mockSessionRepo.AssertCalled(t, "Delete", mock.Anything, "impersonation-1")
+
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, tc.restored, body["restored"])
+
+			cookie := resp.Header.Get(fiber.HeaderSetCookie)
+			if tc.restored {
+				assert.Contains(t, cookie, SESSION_COOKIE_KEY+"=admin-session")
+				assert.Equal(t, "admin-jwt", resp.Header.Get("X-Auth-Token"))
+			} else {
+				assert.Contains(t, cookie, SESSION_COOKIE_KEY+"=;")
+			}
+
+			select {
+			case event := <-audit:
+				assert.Equal(t, events.IMPERSONATION_STOPPED, event.Action)
+				assert.Equal(t, "admin-1", event.AdminID)
+				assert.Equal(t, "user-2", event.UserID)
+				assert.Equal(t, "impersonation-1", event.SessionID)
+			case <-time.After(time.Second):
+				t.Fatal("stopping impersonation was not audited")
+			}
+			auditRepo.AssertCalled(t, "Create", mock.Anything, mock.MatchedBy(func(entry *AuditLog) bool {
+				return entry.Action == AUDIT_ACTION_IMPERSONATION_STOPPED &&
+					entry.ActorID == "admin-1" &&
+					entry.TargetID == "user-2" &&
+					entry.Details["sessionId"] == "impersonation-1"
+			}))
+		})
+	}
+}
+
+func TestUserController_HandleStopImpersonation_NotImpersonating(t *testing.T) {
+	mockSessionRepo := &MockSessionRepository{}
+	fiberApp, audit, auditRepo := setupStopImpersonationTest(t, Session{ID: "current", UserID: "user-2"}, mockSessionRepo)
+
+	resp, err := fiberApp.Test(httptest.NewRequest("POST", "/users/stop-impersonation", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	mockSessionRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	assert.Empty(t, audit)
+	auditRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func setupExtendSessionTest(sessionRepo *MockSessionRepository, now time.Time, session Session) *fiber.App {
+	controller := &UserController{
+		sessionRepo: sessionRepo,
+		Config:      config.Config{Session: config.SessionConfig{MaxLifetime: 30 * 24 * time.Hour}},
+		log:         logger.New("test"),
+		clock:       clock.NewFake(now),
+	}
+
+	fiberApp := fiber.New()
+	fiberApp.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", User{BaseModel: BaseModel{ID: "user-1"}})
+		c.Locals("session", session)
+		return c.Next()
+	})
+	fiberApp.Post("/users/session/extend", controller.handleExtendSession)
+	return fiberApp
+}
+
+// extendSession answers Extend the way the repository does, moving the
+// session's expiry and token.
+func extendSession(sessionRepo *MockSessionRepository, expiresAt time.Time) {
+	sessionRepo.On("Extend", mock.Anything, mock.Anything, expiresAt, mock.Anything).
+		Run(func(args mock.Arguments) {
+			session := args.Get(1).(*Session)
+			session.ExpiresAt = expiresAt
+			session.RefreshAt = expiresAt.Add(-2 * 24 * time.Hour)
+			session.Token = "extended-jwt"
+		}).
+		Return(nil)
+}
+
+func postExtend(t *testing.T, fiberApp *fiber.App, clientType string) (*http.Response, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/users/session/extend", nil)
+	req.Header.Set(middleware.CLIENT_TYPE_HEADER, clientType)
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp, body
+}
+
+func TestUserController_HandleExtendSession(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	session := Session{
+		ID:        "current",
+		UserID:    "user-1",
+		StartedAt: now.Add(-24 * time.Hour),
+		ExpiresAt: now.Add(time.Hour),
+	}
+	expiresAt := now.Add(repositories.SESSION_EXPIRY)
+	mockSessionRepo := &MockSessionRepository{}
+	extendSession(mockSessionRepo, expiresAt)
+
+	resp, body := postExtend(t, setupExtendSessionTest(mockSessionRepo, now, session), middleware.WEB_CLIENT_TYPE)
+
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, expiresAt.Format(API_TIME_FORMAT), body["expiresAt"])
+	assert.Equal(t, expiresAt.Add(-2*24*time.Hour).Format(API_TIME_FORMAT), body["refreshAt"])
+	cookie := resp.Header.Get(fiber.HeaderSetCookie)
+	assert.Contains(t, cookie, SESSION_COOKIE_KEY+"=current;")
+	assert.Contains(t, cookie, "expires="+expiresAt.Format(http.TimeFormat))
+	mockSessionRepo.AssertExpectations(t)
+}
+
+func TestUserController_HandleExtendSession_MaxLifetime(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	loggedIn := now.Add(-28 * 24 * time.Hour)
+
+	t.Run("extends no further than the max lifetime", func(t *testing.T) {
+		mockSessionRepo := &MockSessionRepository{}
+		extendSession(mockSessionRepo, loggedIn.Add(30*24*time.Hour))
+		session := Session{ID: "current", UserID: "user-1", StartedAt: loggedIn, ExpiresAt: now.Add(time.Hour)}
+
+		resp, body := postExtend(t, setupExtendSessionTest(mockSessionRepo, now, session), middleware.WEB_CLIENT_TYPE)
+
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Equal(t, loggedIn.Add(30*24*time.Hour).Format(API_TIME_FORMAT), body["expiresAt"])
+		mockSessionRepo.AssertExpectations(t)
+	})
+
+	t.Run("refuses past it", func(t *testing.T) {
+		mockSessionRepo := &MockSessionRepository{}
+		// Sessions stored before StartedAt count from when they were created
+		session := Session{ID: "current", UserID: "user-1", CreatedAt: now.Add(-31 * 24 * time.Hour), ExpiresAt: now.Add(time.Hour)}
+
+		resp, body := postExtend(t, setupExtendSessionTest(mockSessionRepo, now, session), middleware.WEB_CLIENT_TYPE)
+
+		assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, ErrorCodeSessionMaxLifetime, body["code"])
+		assert.Empty(t, resp.Header.Get(fiber.HeaderSetCookie))
+		mockSessionRepo.AssertNotCalled(t, "Extend", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestUserController_HandleExtendSession_TokenClients(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	mockSessionRepo := &MockSessionRepository{}
+	session := Session{ID: "current", UserID: "user-1", StartedAt: now, ExpiresAt: now.Add(time.Hour)}
+
+	resp, body := postExtend(t, setupExtendSessionTest(mockSessionRepo, now, session), middleware.MOBILE_CLIENT_TYPE)
+
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, ErrorCodeSessionExtendCookieOnly, body["code"])
+	assert.Contains(t, body["error"], "X-Auth-Token")
+	mockSessionRepo.AssertNotCalled(t, "Extend", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func lookupUsers(t *testing.T, caller User, ids []string) (*http.Response, map[string]any) {
+	t.Helper()
+	jane := User{
+		BaseModel: BaseModel{ID: "user-1"},
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Login:     "jdoe",
+		Password:  "hash",
+		IsAdmin:   true,
+	}
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByIDs", mock.Anything, ids).Return([]User{jane}, nil)
+	controller := &UserController{userRepo: mockUserRepo, log: logger.New("test")}
+
+	fiberApp := fiber.New()
+	fiberApp.Post("/users/lookup", func(c *fiber.Ctx) error {
+		c.Locals("user", caller)
+		return c.Next()
+	}, controller.handleLookupUsers)
+
+	body, err := json.Marshal(UserLookupRequest{IDs: ids})
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/users/lookup", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+
+	var decoded map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+	return resp, decoded
+}
+
+func TestHandleLookupUsers_FieldsByRole(t *testing.T) {
+	testCases := []struct {
+		name   string
+		caller User
+		fields []string
+	}{
+		{"regular user", User{BaseModel: BaseModel{ID: "user-2"}}, []string{"id", "firstName", "lastName"}},
+		{"admin", User{BaseModel: BaseModel{ID: "user-2"}, IsAdmin: true}, []string{
+			"id", "firstName", "lastName", "login", "isAdmin", "version",
+			"tosVersion", "tosAcceptedAt", "createdAt", "updatedAt",
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := lookupUsers(t, tc.caller, []string{"user-1", "missing"})
+			require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			users := body["users"].(map[string]any)
+			require.Len(t, users, 1, "missing IDs are left out")
+			jane := users["user-1"].(map[string]any)
+			keys := make([]string, 0, len(jane))
+			for key := range jane {
+				keys = append(keys, key)
+			}
+			assert.ElementsMatch(t, tc.fields, keys)
+			assert.Equal(t, "Jane", jane["firstName"])
+			assert.NotContains(t, jane, "password")
+		})
+	}
+}
+
+func TestHandleLookupUsers_TooManyIDs(t *testing.T) {
+	ids := make([]string, USER_LOOKUP_MAX_IDS+1)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("user-%d", i)
+	}
+
+	resp, body := lookupUsers(t, User{BaseModel: BaseModel{ID: "user-2"}}, ids)
+
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, ErrorCodeTooManyLookupIDs, body["code"])
+	assert.Equal(t, float64(USER_LOOKUP_MAX_IDS), body["max"])
+
+	// At the cap it is fine
+	resp, _ = lookupUsers(t, User{BaseModel: BaseModel{ID: "user-2"}}, ids[:USER_LOOKUP_MAX_IDS])
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+}
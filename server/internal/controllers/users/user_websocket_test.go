@@ -2,8 +2,11 @@ package userController
 
 import (
 	"server/config"
+	"server/internal/clock"
 	"server/internal/events"
 	"server/internal/models"
+	"server/internal/routes/middleware"
+	"server/internal/utils/testsupport"
 	"testing"
 	"time"
 
@@ -11,33 +14,12 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
-// MockWebSocketManager for testing
-type MockWebSocketManager struct {
-	mock.Mock
-}
-
-func (m *MockWebSocketManager) BroadcastUserLogin(userID string, userData map[string]any) {
-	m.Called(userID, userData)
-}
-
-func (m *MockWebSocketManager) AssertExpected(t *testing.T) {
-	m.AssertExpectations(t)
-}
-
-func (m *MockWebSocketManager) AssertCalled(
-	t *testing.T,
-	methodName string,
-	arguments ...interface{},
-) {
-	m.Mock.AssertCalled(t, methodName, arguments...)
-}
-
 func TestUserController_SetWebSocketManager(t *testing.T) {
 	config := config.Config{}
-	eventBus := &events.EventBus{}
-	controller := New(eventBus, nil, nil, config)
+	eventBus := events.New(nil, config, nil)
+	controller := New(eventBus, nil, nil, nil, nil, nil, middleware.Middleware{}, config)
 
-	mockWS := &MockWebSocketManager{}
+	mockWS := &testsupport.MockWebsocketNotifier{}
 	controller.SetWebSocketManager(mockWS)
 
 	assert.Equal(t, mockWS, controller.wsManager, "WebSocket manager should be set correctly")
@@ -45,10 +27,10 @@ func TestUserController_SetWebSocketManager(t *testing.T) {
 
 func TestUserController_BroadcastUserLogin(t *testing.T) {
 	config := config.Config{}
-	eventBus := &events.EventBus{}
-	controller := New(eventBus, nil, nil, config)
+	eventBus := events.New(nil, config, nil)
+	controller := New(eventBus, nil, nil, nil, nil, nil, middleware.Middleware{}, config)
 
-	mockWS := &MockWebSocketManager{}
+	mockWS := &testsupport.MockWebsocketNotifier{}
 	controller.SetWebSocketManager(mockWS)
 
 	// Create test user
@@ -77,13 +59,13 @@ func TestUserController_BroadcastUserLogin(t *testing.T) {
 	controller.broadcastUserLogin(testUser)
 
 	// Verify the call was made
-	mockWS.AssertExpected(t)
+	mockWS.AssertExpectations(t)
 }
 
 func TestUserController_BroadcastUserLogin_NilWSManager(t *testing.T) {
 	config := config.Config{}
-	eventBus := &events.EventBus{}
-	controller := New(eventBus, nil, nil, config)
+	eventBus := events.New(nil, config, nil)
+	controller := New(eventBus, nil, nil, nil, nil, nil, middleware.Middleware{}, config)
 
 	// Don't set WebSocket manager (leave as nil)
 	assert.Nil(t, controller.wsManager, "WebSocket manager should be nil initially")
@@ -107,10 +89,12 @@ func TestUserController_BroadcastUserLogin_NilWSManager(t *testing.T) {
 
 func TestUserController_BroadcastUserLogin_UserData(t *testing.T) {
 	config := config.Config{}
-	eventBus := &events.EventBus{}
-	controller := New(eventBus, nil, nil, config)
+	eventBus := events.New(nil, config, nil)
+	controller := New(eventBus, nil, nil, nil, nil, nil, middleware.Middleware{}, config)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	controller.clock = clock.NewFake(now)
 
-	mockWS := &MockWebSocketManager{}
+	mockWS := &testsupport.MockWebsocketNotifier{}
 	controller.SetWebSocketManager(mockWS)
 
 	// Create test user with various field types
@@ -146,21 +130,20 @@ func TestUserController_BroadcastUserLogin_UserData(t *testing.T) {
 	assert.Equal(t, testUser.Login, capturedUserData["login"])
 	assert.Equal(t, testUser.IsAdmin, capturedUserData["isAdmin"])
 
-	// Verify loginTime is a recent timestamp
+	// Verify loginTime is taken from the clock
 	loginTime, ok := capturedUserData["loginTime"].(int64)
 	assert.True(t, ok, "loginTime should be int64")
-	assert.True(t, loginTime > 0, "loginTime should be positive")
-	assert.True(t, time.Now().Unix()-loginTime < 5, "loginTime should be within last 5 seconds")
+	assert.Equal(t, now.Unix(), loginTime)
 
-	mockWS.AssertExpected(t)
+	mockWS.AssertExpectations(t)
 }
 
 func TestUserController_BroadcastUserLogin_EmptyUserFields(t *testing.T) {
 	config := config.Config{}
-	eventBus := &events.EventBus{}
-	controller := New(eventBus, nil, nil, config)
+	eventBus := events.New(nil, config, nil)
+	controller := New(eventBus, nil, nil, nil, nil, nil, middleware.Middleware{}, config)
 
-	mockWS := &MockWebSocketManager{}
+	mockWS := &testsupport.MockWebsocketNotifier{}
 	controller.SetWebSocketManager(mockWS)
 
 	// Create test user with empty fields
@@ -193,15 +176,15 @@ func TestUserController_BroadcastUserLogin_EmptyUserFields(t *testing.T) {
 	assert.Equal(t, "", capturedUserData["login"])
 	assert.Equal(t, false, capturedUserData["isAdmin"])
 
-	mockWS.AssertExpected(t)
+	mockWS.AssertExpectations(t)
 }
 
 func TestUserController_BroadcastUserLogin_SpecialCharacters(t *testing.T) {
 	config := config.Config{}
-	eventBus := &events.EventBus{}
-	controller := New(eventBus, nil, nil, config)
+	eventBus := events.New(nil, config, nil)
+	controller := New(eventBus, nil, nil, nil, nil, nil, middleware.Middleware{}, config)
 
-	mockWS := &MockWebSocketManager{}
+	mockWS := &testsupport.MockWebsocketNotifier{}
 	controller.SetWebSocketManager(mockWS)
 
 	// Create test user with special characters
@@ -233,6 +216,6 @@ func TestUserController_BroadcastUserLogin_SpecialCharacters(t *testing.T) {
 	assert.Equal(t, testUser.LastName, capturedUserData["lastName"])
 	assert.Equal(t, testUser.Login, capturedUserData["login"])
 
-	mockWS.AssertExpected(t)
+	mockWS.AssertExpectations(t)
 }
 
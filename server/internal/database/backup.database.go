@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/logger"
+	"server/internal/models"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BACKUP_PREFIX      = "backup-"
+	BACKUP_EXTENSION   = ".db"
+	BACKUP_TIME_FORMAT = "20060102T150405Z"
+)
+
+var ErrBackupIntegrity = errors.New("backup failed integrity check")
+
+// BackupStatus is the outcome of the most recent backup, as reported by the
+// health endpoint.
+type BackupStatus struct {
+	LastRunAt     *models.APITime `json:"lastRunAt,omitempty"`
+	LastSuccessAt *models.APITime `json:"lastSuccessAt,omitempty"`
+	DurationMs    int64           `json:"durationMs"`
+	File          string          `json:"file,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// Backup copies the sqlite database into timestamped files with VACUUM INTO,
+// checks every copy and keeps the newest retention of them.
+type Backup struct {
+	db        *gorm.DB
+	dir       string
+	retention int
+	clock     clock.Clock
+	log       logger.Logger
+	verify    func(ctx context.Context, path string) error
+
+	mutex  sync.RWMutex
+	status BackupStatus
+}
+
+// NewBackup builds a Backup writing to config.Database.BackupDir. A nil clock
+// uses the wall clock.
+func NewBackup(db *gorm.DB, config config.Config, clk clock.Clock) *Backup {
+	return &Backup{
+		db:        db,
+		dir:       config.Database.BackupDir,
+		retention: config.Database.BackupRetention,
+		clock:     clock.OrDefault(clk),
+		log:       logger.New("database").File("backup"),
+		verify:    verifyBackup,
+	}
+}
+
+// Run takes one backup. It matches scheduler.Job so it can be scheduled as is.
+func (b *Backup) Run(ctx context.Context) error {
+	log := b.log.Function("Run")
+
+	startedAt := b.clock.Now()
+	start := time.Now()
+	path, err := b.backup(ctx, startedAt)
+	duration := time.Since(start)
+
+	b.record(startedAt, duration, path, err)
+	if err != nil {
+		return log.Err("failed to back up database", err, "dir", b.dir)
+	}
+
+	log.Info("Database backed up", "path", path, "duration", duration)
+	return nil
+}
+
+// Status returns the outcome of the most recent backup.
+func (b *Backup) Status() BackupStatus {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.status
+}
+
+func (b *Backup) backup(ctx context.Context, now time.Time) (string, error) {
+	if b.dir == "" {
+		return "", errors.New("backup directory is not configured")
+	}
+
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	path := filepath.Join(b.dir, BACKUP_PREFIX+now.UTC().Format(BACKUP_TIME_FORMAT)+BACKUP_EXTENSION)
+	if err := b.db.WithContext(ctx).Exec("VACUUM INTO ?", path).Error; err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	if err := b.verify(ctx, path); err != nil {
+		if removeErr := os.Remove(path); removeErr != nil {
+			b.log.Function("backup").Er("failed to remove bad backup", removeErr, "path", path)
+		}
+		return "", err
+	}
+
+	// Only prune once the new copy is known to be good. A failed prune leaves
+	// extra files behind but doesn't make this backup any less usable.
+	if err := b.prune(); err != nil {
+		b.log.Function("backup").Er("failed to prune old backups", err, "dir", b.dir)
+	}
+
+	return path, nil
+}
+
+// prune removes all but the newest retention backups. The timestamp in the
+// file name sorts the same way as the time it was taken.
+func (b *Backup) prune() error {
+	files, err := b.List()
+	if err != nil {
+		return err
+	}
+
+	for len(files) > b.retention {
+		if err := os.Remove(files[0]); err != nil {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// List returns the paths of the existing backups, oldest first.
+func (b *Backup) List() ([]string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, BACKUP_PREFIX) || !strings.HasSuffix(name, BACKUP_EXTENSION) {
+			continue
+		}
+		files = append(files, filepath.Join(b.dir, name))
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+func (b *Backup) record(startedAt time.Time, duration time.Duration, path string, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.status.LastRunAt = models.NewAPITimePtr(&startedAt)
+	b.status.DurationMs = duration.Milliseconds()
+	if err != nil {
+		b.status.Error = err.Error()
+		return
+	}
+
+	b.status.LastSuccessAt = b.status.LastRunAt
+	b.status.File = path
+	b.status.Error = ""
+}
+
+// verifyBackup opens the copy on its own connection and runs sqlite's
+// integrity check against it.
+func verifyBackup(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("failed to check backup: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: %s", ErrBackupIntegrity, result)
+	}
+	return nil
+}
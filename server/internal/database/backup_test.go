@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"server/config"
+	"server/internal/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type backupRow struct {
+	ID   uint
+	Name string
+}
+
+func setupBackupTest(t *testing.T, retention int) (*Backup, *clock.Fake, string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "app.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&backupRow{}))
+	require.NoError(t, db.Create(&[]backupRow{{Name: "alice"}, {Name: "bob"}}).Error)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	backupDir := filepath.Join(dir, "backups")
+	fake := clock.NewFake(time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC))
+	backup := NewBackup(db, config.Config{
+		Database: config.DatabaseConfig{
+			BackupDir:       backupDir,
+			BackupRetention: retention,
+		},
+	}, fake)
+
+	return backup, fake, backupDir
+}
+
+func TestBackup_Run_CopiesData(t *testing.T) {
+	backup, _, backupDir := setupBackupTest(t, 3)
+
+	require.NoError(t, backup.Run(context.Background()))
+
+	expected := filepath.Join(backupDir, "backup-20240601T030000Z.db")
+	status := backup.Status()
+	assert.Equal(t, expected, status.File)
+	assert.Empty(t, status.Error)
+	require.NotNil(t, status.LastSuccessAt)
+	assert.Equal(t, status.LastRunAt, status.LastSuccessAt)
+
+	copied, err := gorm.Open(sqlite.Open(expected), &gorm.Config{})
+	require.NoError(t, err)
+	defer func() {
+		if sqlDB, err := copied.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	}()
+
+	var rows []backupRow
+	require.NoError(t, copied.Order("id").Find(&rows).Error)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "alice", rows[0].Name)
+	assert.Equal(t, "bob", rows[1].Name)
+}
+
+func TestBackup_Run_PrunesToRetention(t *testing.T) {
+	backup, fake, backupDir := setupBackupTest(t, 2)
+
+	// Files that only look similar are left alone.
+	unrelated := filepath.Join(backupDir, "notes.txt")
+	require.NoError(t, os.MkdirAll(backupDir, 0755))
+	require.NoError(t, os.WriteFile(unrelated, []byte("keep"), 0644))
+
+	for range 4 {
+		require.NoError(t, backup.Run(context.Background()))
+		fake.Advance(time.Hour)
+	}
+
+	files, err := backup.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(backupDir, "backup-20240601T050000Z.db"),
+		filepath.Join(backupDir, "backup-20240601T060000Z.db"),
+	}, files)
+	assert.FileExists(t, unrelated)
+}
+
+func TestBackup_Run_FailedIntegrityCheck(t *testing.T) {
+	backup, fake, backupDir := setupBackupTest(t, 1)
+
+	require.NoError(t, backup.Run(context.Background()))
+	good := backup.Status()
+
+	backup.verify = func(ctx context.Context, path string) error {
+		return fmt.Errorf("%w: page 2 is never used", ErrBackupIntegrity)
+	}
+	fake.Advance(time.Hour)
+
+	err := backup.Run(context.Background())
+	require.ErrorIs(t, err, ErrBackupIntegrity)
+
+	status := backup.Status()
+	assert.Contains(t, status.Error, "integrity check")
+	assert.Equal(t, good.LastSuccessAt, status.LastSuccessAt)
+	assert.Equal(t, good.File, status.File)
+	assert.True(t, status.LastRunAt.After(status.LastSuccessAt.Time))
+
+	// The bad copy is discarded and the last good one survives pruning.
+	files, err := backup.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(backupDir, "backup-20240601T030000Z.db")}, files)
+}
+
+func TestVerifyBackup_RejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup-corrupt.db")
+	require.NoError(t, os.WriteFile(path, []byte("definitely not a database"), 0644))
+
+	assert.Error(t, verifyBackup(context.Background(), path))
+}
@@ -20,8 +20,8 @@ func (s *DB) initializeCacheDB(config config.Config) error {
 	log := s.log.Function("initializeCacheDB")
 	log.Info("initializing cache database")
 
-	address := config.DatabaseCacheAddress
-	port := config.DatabaseCachePort
+	address := config.Cache.Address
+	port := config.Cache.Port
 	if address == "" || port == 0 {
 		return log.Errorf("failed to initialize cache database", "address or port is empty")
 	}
@@ -11,6 +11,8 @@ import (
 	"github.com/valkey-io/valkey-go"
 )
 
+const SCAN_BATCH_SIZE = 100
+
 type CacheItem[T any] struct {
 	Cache       valkey.Client
 	HashPattern *string // "hash:%s"
@@ -117,8 +119,16 @@ func (cb *CacheBuilder) Set() error {
 		return fmt.Errorf("value is required")
 	}
 
-	return cb.cache.Do(ctx, cb.cache.B().Set().Key(cb.key).Value(cb.value).Ex(cb.ttl).Build()).
-		Error()
+	// A ttl of zero or less keeps the key until it is overwritten or deleted.
+	command := cb.cache.B().Set().Key(cb.key).Value(cb.value).Build()
+	if cb.ttl > 0 {
+		command = cb.cache.B().Set().Key(cb.key).Value(cb.value).Ex(cb.ttl).Build()
+	}
+	if err := cb.cache.Do(ctx, command).Error(); err != nil {
+		return err
+	}
+	CacheSets.Add(CacheNamespace(cb.key), 1)
+	return nil
 }
 
 func (cb *CacheBuilder) Get(result any) error {
@@ -135,14 +145,44 @@ func (cb *CacheBuilder) Get(result any) error {
 
 	data, err := cb.cache.Do(ctx, cb.cache.B().Get().Key(cb.key).Build()).ToString()
 	if err != nil {
+		if valkey.IsValkeyNil(err) {
+			countRead(cb.key, false)
+		}
 		return err
 	}
+	countRead(cb.key, true)
 
 	slog.Info("data", "data", data)
 
 	return json.Unmarshal([]byte(data), result)
 }
 
+// GetDelete reads the key into result and deletes it in one command, so only
+// one caller ever gets the value.
+func (cb *CacheBuilder) GetDelete(result any) error {
+	if cb.err != nil {
+		return cb.err
+	}
+
+	if cb.cache == nil {
+		return fmt.Errorf("cache client is nil")
+	}
+
+	ctx, cancel := cb.createTimeoutContext()
+	defer cancel()
+
+	data, err := cb.cache.Do(ctx, cb.cache.B().Getdel().Key(cb.key).Build()).ToString()
+	if err != nil {
+		if valkey.IsValkeyNil(err) {
+			countRead(cb.key, false)
+		}
+		return err
+	}
+	countRead(cb.key, true)
+
+	return json.Unmarshal([]byte(data), result)
+}
+
 func (cb *CacheBuilder) Delete() error {
 	if cb.err != nil {
 		return cb.err
@@ -155,7 +195,12 @@ func (cb *CacheBuilder) Delete() error {
 	ctx, cancel := cb.createTimeoutContext()
 	defer cancel()
 
-	return cb.cache.Do(ctx, cb.cache.B().Del().Key(cb.key).Build()).Error()
+	deleted, err := cb.cache.Do(ctx, cb.cache.B().Del().Key(cb.key).Build()).AsInt64()
+	if err != nil {
+		return err
+	}
+	CacheEvictions.Add(CacheNamespace(cb.key), uint64(deleted))
+	return nil
 }
 
 // SADD
@@ -236,6 +281,41 @@ func (cb *CacheBuilder) GetSetMembers() ([]string, error) {
 	return result, nil
 }
 
+// CountKeys counts the keys matching the builder's key as a glob pattern. It
+// walks the keyspace with SCAN so it never blocks the server.
+func (cb *CacheBuilder) CountKeys() (int64, error) {
+	if cb.err != nil {
+		return 0, cb.err
+	}
+
+	if cb.cache == nil {
+		return 0, fmt.Errorf("cache client is nil")
+	}
+
+	ctx, cancel := cb.createTimeoutContext()
+	defer cancel()
+
+	var count int64
+	var cursor uint64
+	for {
+		entry, err := cb.cache.Do(ctx,
+			cb.cache.B().Scan().
+				Cursor(cursor).
+				Match(cb.key).
+				Count(SCAN_BATCH_SIZE).
+				Build()).AsScanEntry()
+		if err != nil {
+			return 0, err
+		}
+
+		count += int64(len(entry.Elements))
+		cursor = entry.Cursor
+		if cursor == 0 {
+			return count, nil
+		}
+	}
+}
+
 func (cb *CacheBuilder) createTimeoutContext() (context.Context, context.CancelFunc) {
 	if deadline, ok := cb.ctx.Deadline(); ok {
 		remaining := time.Until(deadline)
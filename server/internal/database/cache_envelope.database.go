@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"server/internal/metrics"
+	"time"
+)
+
+// CacheStale counts entries dropped because they were written in another
+// format than the reader expects.
+var CacheStale = metrics.NewLabeledCounter(
+	"cache_stale_total", "Cache entries dropped for an old or unreadable format, by key namespace.", "namespace",
+)
+
+func init() {
+	metrics.Register(CacheStale)
+}
+
+// cacheEnvelope wraps a cached value with the version of its format, so a
+// value cached before a struct changed isn't decoded into the new one.
+type cacheEnvelope struct {
+	Version int             `json:"v"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// SetVersioned stores value at key in an envelope marked with version. Each
+// cached type has a version constant, bumped whenever a change to the type
+// would decode an older value wrongly.
+func SetVersioned[T any](
+	ctx context.Context,
+	store CacheStore,
+	key string,
+	version int,
+	value T,
+	ttl time.Duration,
+) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return store.Set(ctx, key, cacheEnvelope{Version: version, Payload: payload}, ttl)
+}
+
+// GetVersioned reads a value SetVersioned stored at key with version. Any
+// other entry there, of another version, without an envelope or that doesn't
+// decode, is deleted and reported as ErrCacheMiss, so the caller loads and
+// caches the value again as it would after a miss.
+func GetVersioned[T any](ctx context.Context, store CacheStore, key string, version int) (T, error) {
+	var value T
+
+	var raw json.RawMessage
+	if err := store.Get(ctx, key, &raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			return value, err
+		}
+		return value, dropStale(ctx, store, key)
+	}
+
+	var envelope cacheEnvelope
+	if err := json.Unmarshal(raw, &envelope); err != nil ||
+		envelope.Version != version ||
+		len(envelope.Payload) == 0 {
+		return value, dropStale(ctx, store, key)
+	}
+	if err := json.Unmarshal(envelope.Payload, &value); err != nil {
+		var zero T
+		return zero, dropStale(ctx, store, key)
+	}
+	return value, nil
+}
+
+// dropStale deletes an entry GetVersioned can't use and reports it as a miss.
+// The miss is what matters to the caller, so a failure to delete is left to
+// the entry's ttl.
+func dropStale(ctx context.Context, store CacheStore, key string) error {
+	CacheStale.Add(CacheNamespace(key), 1)
+	_ = store.Delete(ctx, key)
+	return ErrCacheMiss
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type envelopeTestValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestVersioned_RoundTrip(t *testing.T) {
+	store := NewMemoryCacheStore()
+	ctx := context.Background()
+	stored := envelopeTestValue{Name: "widget", Count: 3}
+
+	require.NoError(t, SetVersioned(ctx, store, "envelope_test:a", 2, stored, time.Hour))
+
+	value, err := GetVersioned[envelopeTestValue](ctx, store, "envelope_test:a", 2)
+	require.NoError(t, err)
+	assert.Equal(t, stored, value)
+	assert.Zero(t, CacheStale.Value("envelope_test"))
+
+	_, err = GetVersioned[envelopeTestValue](ctx, store, "envelope_test:missing", 2)
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.Zero(t, CacheStale.Value("envelope_test"), "a plain miss isn't stale")
+}
+
+func TestVersioned_StaleEntriesAreMissesAndEvicted(t *testing.T) {
+	testCases := []struct {
+		name  string
+		raw   string
+		stale bool
+	}{
+		{"older version", `{"v":1,"payload":{"name":"widget","count":3}}`, true},
+		{"newer version", `{"v":3,"payload":{"name":"widget","count":3}}`, true},
+		{"no envelope", `{"name":"widget","count":3}`, true},
+		{"no payload", `{"v":2}`, true},
+		{"payload of another shape", `{"v":2,"payload":{"name":["widget"]}}`, true},
+		{"not json", `{"v":2,"payl`, true},
+		{"current version", `{"v":2,"payload":{"name":"widget","count":3}}`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &memoryCacheStore{values: make(map[string][]byte)}
+			store.values["stale_test:a"] = []byte(tc.raw)
+			staleBefore := CacheStale.Value("stale_test")
+
+			value, err := GetVersioned[envelopeTestValue](context.Background(), store, "stale_test:a", 2)
+
+			if !tc.stale {
+				require.NoError(t, err)
+				assert.Equal(t, envelopeTestValue{Name: "widget", Count: 3}, value)
+				assert.Contains(t, store.values, "stale_test:a")
+				return
+			}
+			assert.ErrorIs(t, err, ErrCacheMiss)
+			assert.Zero(t, value)
+			assert.NotContains(t, store.values, "stale_test:a", "a stale entry is deleted")
+			assert.Equal(t, staleBefore+1, CacheStale.Value("stale_test"))
+		})
+	}
+}
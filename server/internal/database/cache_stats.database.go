@@ -0,0 +1,62 @@
+package database
+
+import (
+	"server/internal/metrics"
+	"strings"
+)
+
+// CACHE_NAMESPACE_OTHER counts keys without a namespace prefix.
+const CACHE_NAMESPACE_OTHER = "other"
+
+// Cache operations by key namespace, for both the valkey and the memory
+// store. A read that removes the value, like Take, counts as a hit or miss
+// only; evictions are keys removed by Delete or Flush.
+var (
+	CacheHits = metrics.NewLabeledCounter(
+		"cache_hits_total", "Cache reads that found a value, by key namespace.", "namespace",
+	)
+	CacheMisses = metrics.NewLabeledCounter(
+		"cache_misses_total", "Cache reads that found nothing, by key namespace.", "namespace",
+	)
+	CacheSets = metrics.NewLabeledCounter(
+		"cache_sets_total", "Values written to the cache, by key namespace.", "namespace",
+	)
+	CacheEvictions = metrics.NewLabeledCounter(
+		"cache_evictions_total", "Keys deleted from the cache, by key namespace.", "namespace",
+	)
+)
+
+func init() {
+	metrics.Register(CacheHits)
+	metrics.Register(CacheMisses)
+	metrics.Register(CacheSets)
+	metrics.Register(CacheEvictions)
+}
+
+// CacheNamespace is the part of key before its first colon, such as session
+// for session:<id>.
+func CacheNamespace(key string) string {
+	namespace, _, ok := strings.Cut(key, ":")
+	if !ok || namespace == "" {
+		return CACHE_NAMESPACE_OTHER
+	}
+	return namespace
+}
+
+// CacheNamespacePattern is the glob matching every key of namespace, or every
+// key at all when it is empty.
+func CacheNamespacePattern(namespace string) string {
+	if namespace == "" {
+		return "*"
+	}
+	return namespace + ":*"
+}
+
+// countRead records a read of key as a hit or, when found is false, a miss.
+func countRead(key string, found bool) {
+	if found {
+		CacheHits.Add(CacheNamespace(key), 1)
+		return
+	}
+	CacheMisses.Add(CacheNamespace(key), 1)
+}
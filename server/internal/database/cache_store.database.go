@@ -0,0 +1,237 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+var ErrCacheMiss = errors.New("cache miss")
+
+// CacheStore is a read-through cache of JSON values. Callers depend on it
+// rather than a raw client so they can be tested without valkey.
+type CacheStore interface {
+	// Get decodes the value at key into result, or returns ErrCacheMiss.
+	Get(ctx context.Context, key string, result any) error
+	// Set stores value at key. A ttl of zero keeps it until it is replaced.
+	Set(ctx context.Context, key string, value any, ttl time.Duration) error
+	// Take is Get that also removes the value, so of several callers racing
+	// for the same key only one gets it.
+	Take(ctx context.Context, key string, result any) error
+	// Delete removes key. Deleting a key that is already gone is not an error.
+	Delete(ctx context.Context, key string) error
+	// DeleteMany removes keys in one round trip, skipping those already gone.
+	DeleteMany(ctx context.Context, keys []string) error
+	// Scan returns a page of the keys matching the glob pattern, starting at
+	// cursor, and the cursor of the next page, which is 0 after the last.
+	Scan(ctx context.Context, pattern string, cursor uint64) ([]string, uint64, error)
+	// Flush deletes every key of namespace, the prefix before the colon, or
+	// every key when namespace is empty. It returns how many it deleted.
+	Flush(ctx context.Context, namespace string) (int, error)
+}
+
+type valkeyCacheStore struct {
+	client CacheClient
+}
+
+func NewCacheStore(client CacheClient) CacheStore {
+	return tracedCacheStore{&valkeyCacheStore{client: client}}
+}
+
+func (s *valkeyCacheStore) Get(ctx context.Context, key string, result any) error {
+	err := NewCacheBuilder(s.client, key).WithContext(ctx).Get(result)
+	if valkey.IsValkeyNil(err) {
+		return ErrCacheMiss
+	}
+	return err
+}
+
+func (s *valkeyCacheStore) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return NewCacheBuilder(s.client, key).WithContext(ctx).WithSruct(value).WithTTL(ttl).Set()
+}
+
+func (s *valkeyCacheStore) Take(ctx context.Context, key string, result any) error {
+	err := NewCacheBuilder(s.client, key).WithContext(ctx).GetDelete(result)
+	if valkey.IsValkeyNil(err) {
+		return ErrCacheMiss
+	}
+	return err
+}
+
+func (s *valkeyCacheStore) Delete(ctx context.Context, key string) error {
+	return NewCacheBuilder(s.client, key).WithContext(ctx).Delete()
+}
+
+func (s *valkeyCacheStore) DeleteMany(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if s.client == nil {
+		return errors.New("cache client is nil")
+	}
+	_, err := s.deleteKeys(ctx, keys)
+	return err
+}
+
+func (s *valkeyCacheStore) Scan(ctx context.Context, pattern string, cursor uint64) ([]string, uint64, error) {
+	if s.client == nil {
+		return nil, 0, errors.New("cache client is nil")
+	}
+	entry, err := s.client.Do(ctx,
+		s.client.B().Scan().
+			Cursor(cursor).
+			Match(pattern).
+			Count(SCAN_BATCH_SIZE).
+			Build()).AsScanEntry()
+	if err != nil {
+		return nil, 0, err
+	}
+	return entry.Elements, entry.Cursor, nil
+}
+
+// Flush walks the keys with SCAN, deleting each page as it goes, so it never
+// blocks the server the way FLUSHDB or KEYS would.
+func (s *valkeyCacheStore) Flush(ctx context.Context, namespace string) (int, error) {
+	deleted := 0
+	var cursor uint64
+	for {
+		keys, next, err := s.Scan(ctx, CacheNamespacePattern(namespace), cursor)
+		if err != nil {
+			return deleted, err
+		}
+
+		count, err := s.deleteKeys(ctx, keys)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// deleteKeys sends one DEL per namespace, so each namespace's evictions are
+// exact, and returns how many keys were deleted.
+func (s *valkeyCacheStore) deleteKeys(ctx context.Context, keys []string) (int, error) {
+	byNamespace := make(map[string][]string)
+	for _, key := range keys {
+		byNamespace[CacheNamespace(key)] = append(byNamespace[CacheNamespace(key)], key)
+	}
+
+	deleted := 0
+	for keyNamespace, namespaceKeys := range byNamespace {
+		count, err := s.client.Do(ctx, s.client.B().Del().Key(namespaceKeys...).Build()).AsInt64()
+		if err != nil {
+			return deleted, err
+		}
+		CacheEvictions.Add(keyNamespace, uint64(count))
+		deleted += int(count)
+	}
+	return deleted, nil
+}
+
+type memoryCacheStore struct {
+	mutex  sync.RWMutex
+	values map[string][]byte
+}
+
+// NewMemoryCacheStore keeps values in this process and ignores ttls. It is
+// for tests, where the state has to outlive the components using it.
+func NewMemoryCacheStore() CacheStore {
+	return tracedCacheStore{&memoryCacheStore{values: make(map[string][]byte)}}
+}
+
+func (s *memoryCacheStore) Get(ctx context.Context, key string, result any) error {
+	s.mutex.RLock()
+	value, ok := s.values[key]
+	s.mutex.RUnlock()
+	countRead(key, ok)
+	if !ok {
+		return ErrCacheMiss
+	}
+	return json.Unmarshal(value, result)
+}
+
+func (s *memoryCacheStore) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values[key] = encoded
+	CacheSets.Add(CacheNamespace(key), 1)
+	return nil
+}
+
+func (s *memoryCacheStore) Take(ctx context.Context, key string, result any) error {
+	s.mutex.Lock()
+	value, ok := s.values[key]
+	delete(s.values, key)
+	s.mutex.Unlock()
+	countRead(key, ok)
+	if !ok {
+		return ErrCacheMiss
+	}
+	return json.Unmarshal(value, result)
+}
+
+func (s *memoryCacheStore) Delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		CacheEvictions.Add(CacheNamespace(key), 1)
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) DeleteMany(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := s.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan returns every match in a single page.
+func (s *memoryCacheStore) Scan(ctx context.Context, pattern string, cursor uint64) ([]string, uint64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var matched []string
+	for _, key := range slices.Sorted(maps.Keys(s.values)) {
+		if ok, _ := path.Match(pattern, key); ok {
+			matched = append(matched, key)
+		}
+	}
+	return matched, 0, nil
+}
+
+func (s *memoryCacheStore) Flush(ctx context.Context, namespace string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deleted := 0
+	for key := range s.values {
+		if namespace != "" && !strings.HasPrefix(key, namespace+":") {
+			continue
+		}
+		delete(s.values, key)
+		CacheEvictions.Add(CacheNamespace(key), 1)
+		deleted++
+	}
+	return deleted, nil
+}
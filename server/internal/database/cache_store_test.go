@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cacheCounts struct {
+	hits, misses, sets, evictions uint64
+}
+
+func countsOf(namespace string) cacheCounts {
+	return cacheCounts{
+		hits:      CacheHits.Value(namespace),
+		misses:    CacheMisses.Value(namespace),
+		sets:      CacheSets.Value(namespace),
+		evictions: CacheEvictions.Value(namespace),
+	}
+}
+
+func TestCacheNamespace(t *testing.T) {
+	assert.Equal(t, "session", CacheNamespace("session:abc"))
+	assert.Equal(t, "user_sessions", CacheNamespace("user_sessions:abc:def"))
+	assert.Equal(t, CACHE_NAMESPACE_OTHER, CacheNamespace("0190a1b2-0000-7000-8000-000000000001"))
+	assert.Equal(t, CACHE_NAMESPACE_OTHER, CacheNamespace(":abc"))
+
+	assert.Equal(t, "*", CacheNamespacePattern(""))
+	assert.Equal(t, "session:*", CacheNamespacePattern("session"))
+}
+
+// The counters are shared by the process, so each test uses namespaces of
+// its own.
+func TestMemoryCacheStore_CountsOperations(t *testing.T) {
+	store := NewMemoryCacheStore()
+	ctx := context.Background()
+	var value string
+
+	require.NoError(t, store.Set(ctx, "count_test:a", "1", 0))
+	require.NoError(t, store.Set(ctx, "count_test:a", "2", 0))
+	require.NoError(t, store.Set(ctx, "count_test:b", "3", 0))
+	require.NoError(t, store.Get(ctx, "count_test:a", &value))
+	assert.ErrorIs(t, store.Get(ctx, "count_test:missing", &value), ErrCacheMiss)
+	require.NoError(t, store.Take(ctx, "count_test:b", &value))
+	assert.ErrorIs(t, store.Take(ctx, "count_test:b", &value), ErrCacheMiss)
+	require.NoError(t, store.Delete(ctx, "count_test:a"))
+	require.NoError(t, store.Delete(ctx, "count_test:a"))
+	require.NoError(t, store.Set(ctx, "count_test_other:a", "4", 0))
+
+	assert.Equal(t, cacheCounts{hits: 2, misses: 2, sets: 3, evictions: 1}, countsOf("count_test"))
+	assert.Equal(t, cacheCounts{sets: 1}, countsOf("count_test_other"))
+}
+
+func TestMemoryCacheStore_FlushNamespace(t *testing.T) {
+	store := NewMemoryCacheStore()
+	ctx := context.Background()
+	for _, key := range []string{"flush_test:a", "flush_test:b", "flush_test_kept:a", "flush_test"} {
+		require.NoError(t, store.Set(ctx, key, "value", 0))
+	}
+
+	deleted, err := store.Flush(ctx, "flush_test")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, uint64(2), CacheEvictions.Value("flush_test"))
+
+	keys, _, err := store.Scan(ctx, "*", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"flush_test", "flush_test_kept:a"}, keys)
+
+	deleted, err = store.Flush(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, uint64(1), CacheEvictions.Value("flush_test_kept"))
+
+	keys, _, err = store.Scan(ctx, "*", 0)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
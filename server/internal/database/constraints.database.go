@@ -0,0 +1,92 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DataConstraint is a CHECK constraint the schema enforces. Condition is what
+// every row of Table meets; Column is the one it is about, so it can be
+// skipped on a database that doesn't have the column yet. A migration that
+// adds a constraint adds it here too, so verify-data covers it and a
+// violation can be explained.
+type DataConstraint struct {
+	Name      string
+	Table     string
+	Column    string
+	Condition string
+	Rule      string
+}
+
+// DataConstraints are added by 0010_data_constraints. The NOT NULL on IDs
+// is part of their condition, since a non-integer primary key in sqlite
+// allows NULL otherwise.
+var DataConstraints = []DataConstraint{
+	{Name: "users_id_not_empty", Table: "users", Column: "id", Condition: "id IS NOT NULL AND id <> ''", Rule: "id must not be empty"},
+	{Name: "users_login_length", Table: "users", Column: "login", Condition: "length(login) >= 3", Rule: "login must be at least 3 characters"},
+	{Name: "users_is_admin_boolean", Table: "users", Column: "is_admin", Condition: "is_admin IN (0, 1)", Rule: "is_admin must be 0 or 1"},
+	{Name: "login_events_id_not_empty", Table: "login_events", Column: "id", Condition: "id IS NOT NULL AND id <> ''", Rule: "id must not be empty"},
+	{Name: "announcements_id_not_empty", Table: "announcements", Column: "id", Condition: "id IS NOT NULL AND id <> ''", Rule: "id must not be empty"},
+	{Name: "user_preferences_user_id_not_empty", Table: "user_preferences", Column: "user_id", Condition: "user_id <> ''", Rule: "user_id must not be empty"},
+	{Name: "user_preferences_key_not_empty", Table: "user_preferences", Column: "key", Condition: "key <> ''", Rule: "key must not be empty"},
+	{Name: "audit_logs_id_not_empty", Table: "audit_logs", Column: "id", Condition: "id IS NOT NULL AND id <> ''", Rule: "id must not be empty"},
+	{Name: "audit_archives_id_not_empty", Table: "audit_archives", Column: "id", Condition: "id IS NOT NULL AND id <> ''", Rule: "id must not be empty"},
+}
+
+// ConstraintError is a row the schema refused, named by its table and key.
+type ConstraintError struct {
+	Table string
+	Row   string
+	// The CHECK constraint's name, or the column for NOT NULL and UNIQUE
+	Constraint string
+	Rule       string
+	Err        error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("row %s violates %s: %s", e.Row, e.Constraint, e.Rule)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// ExplainConstraint turns err into a ConstraintError when it is sqlite
+// refusing row of table over a constraint, and returns it unchanged otherwise.
+func ExplainConstraint(table string, row string, err error) error {
+	if err == nil {
+		return nil
+	}
+	explained := &ConstraintError{Table: table, Row: row, Err: err}
+
+	message := err.Error()
+	if name, ok := cutAfter(message, "CHECK constraint failed: "); ok {
+		explained.Constraint, explained.Rule = name, "check failed"
+		for _, constraint := range DataConstraints {
+			if constraint.Name == name {
+				explained.Rule = constraint.Rule
+			}
+		}
+		return explained
+	}
+	if column, ok := cutAfter(message, "NOT NULL constraint failed: "); ok {
+		explained.Constraint, explained.Rule = column, "must not be null"
+		return explained
+	}
+	if column, ok := cutAfter(message, "UNIQUE constraint failed: "); ok {
+		explained.Constraint, explained.Rule = column, "already taken by another row"
+		return explained
+	}
+	return err
+}
+
+// cutAfter returns what follows prefix in message, up to the end of the
+// line.
+func cutAfter(message string, prefix string) (string, bool) {
+	_, after, ok := strings.Cut(message, prefix)
+	if !ok {
+		return "", false
+	}
+	after, _, _ = strings.Cut(after, "\n")
+	return strings.TrimSpace(after), true
+}
@@ -2,17 +2,16 @@ package database
 
 import (
 	"context"
-	"log/slog"
 	"os"
 	"path/filepath"
 	"server/config"
 	logg "server/internal/logger"
+	"strings"
 	"time"
 
 	"github.com/valkey-io/valkey-go"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
 type CacheClient valkey.Client
@@ -30,7 +29,23 @@ type DB struct {
 	log   logg.Logger
 }
 
+// New opens the sqlite database and connects to the cache.
 func New(config config.Config) (DB, error) {
+	db, err := NewSQL(config)
+	if err != nil {
+		return DB{}, err
+	}
+
+	if err := db.ConnectCache(config); err != nil {
+		return DB{}, err
+	}
+
+	return db, nil
+}
+
+// NewSQL opens the sqlite database only. ConnectCache completes it, so the
+// schema can be checked before the cache is reached for.
+func NewSQL(config config.Config) (DB, error) {
 	log := logg.New("database").Function("New")
 
 	log.Info("Initializing database")
@@ -41,14 +56,17 @@ func New(config config.Config) (DB, error) {
 		return DB{}, log.Err("failed to initialize database", err)
 	}
 
-	err = db.initializeCacheDB(config)
-	if err != nil {
-		return DB{}, log.Err("failed to initialize cache database", err)
-	}
-
 	return *db, nil
 }
 
+// ConnectCache connects the cache clients of a database from NewSQL.
+func (s *DB) ConnectCache(config config.Config) error {
+	if err := s.initializeCacheDB(config); err != nil {
+		return s.log.Function("ConnectCache").Err("failed to initialize cache database", err)
+	}
+	return nil
+}
+
 func TXDefer(tx *gorm.DB, log logg.Logger) {
 	if tx.Error != nil {
 		log.Er("failed to commit transaction", tx.Error)
@@ -63,32 +81,50 @@ func TXDefer(tx *gorm.DB, log logg.Logger) {
 	}
 }
 
-func (s *DB) initializeDB(config config.Config) error {
-	gormLogger := logger.New(
-		slog.NewLogLogger(slog.Default().Handler(), slog.LevelInfo),
-		logger.Config{
-			SlowThreshold:             1 * time.Second,
-			LogLevel:                  logger.Info,
-			IgnoreRecordNotFoundError: true,
-			ParameterizedQueries:      false,
-			Colorful:                  false,
-		},
-	)
+// SQLiteDSN adds the settings every connection to the sqlite file at path
+// needs. sqlite leaves foreign keys off unless each connection turns them
+// on, so they go in the DSN rather than a one-off PRAGMA.
+func SQLiteDSN(path string) string {
+	// The driver only reads settings after a file name, and would create a
+	// file named after them otherwise
+	if path == "" {
+		return path
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	// Concurrent writers wait for the lock instead of failing with
+	// SQLITE_BUSY
+	return path + separator + "_foreign_keys=on&_busy_timeout=5000"
+}
 
+func (s *DB) initializeDB(config config.Config) error {
 	gormConfig := &gorm.Config{
-		Logger:                                   gormLogger,
-		PrepareStmt:                              true,
+		Logger:                                   gormLogger{log: logg.New("database").File("gorm")},
+		PrepareStmt:                              config.Database.PrepareStmt,
 		DisableForeignKeyConstraintWhenMigrating: false,
 		CreateBatchSize:                          100,
+		// Store timestamps in UTC so sqlite's string comparisons line up
+		NowFunc: func() time.Time { return time.Now().UTC() },
 	}
 
+	if driver := config.DatabaseDriver(); !driverSupported(driver) {
+		return s.log.Function("initializeDB").Error("database driver is not supported yet", "driver", driver)
+	}
 	return s.initializeSQLiteDB(gormConfig, config)
 }
 
+// driverSupported reports whether New can open a database of driver. The
+// others have their pool defaults ready in config for when they land.
+func driverSupported(driver string) bool {
+	return driver == config.DATABASE_DRIVER_SQLITE
+}
+
 func (s *DB) initializeSQLiteDB(gormConfig *gorm.Config, config config.Config) error {
 	log := s.log.Function("initializeSQLiteDB")
 
-	dbPath := config.DatabaseDbPath
+	dbPath := config.Database.Path
 	if dbPath == "" {
 		return log.Error("database path is empty", "dbPath", dbPath)
 	}
@@ -100,11 +136,28 @@ func (s *DB) initializeSQLiteDB(gormConfig *gorm.Config, config config.Config) e
 	}
 
 	log.Info("Connecting with GORM", "dbPath", dbPath)
-	db, err := gorm.Open(sqlite.Open(dbPath), gormConfig)
+	db, err := gorm.Open(sqlite.Open(SQLiteDSN(dbPath)), gormConfig)
 	if err != nil {
 		return log.Err("failed to open database with GORM", err)
 	}
 
+	if err := db.Use(TracingPlugin{}); err != nil {
+		return log.Err("failed to install the tracing plugin", err)
+	}
+	if err := db.Use(NewQueryLogPlugin(config)); err != nil {
+		return log.Err("failed to install the query log plugin", err)
+	}
+	if key := config.DataKey(); key != nil {
+		if err := db.Use(EncryptionPlugin{Key: key}); err != nil {
+			return log.Err("failed to install the encryption plugin", err)
+		}
+	}
+	if config.Database.ReadOnly {
+		if err := db.Use(ReadOnlyPlugin{}); err != nil {
+			return log.Err("failed to install the read-only plugin", err)
+		}
+	}
+
 	sqlDB, err := db.DB()
 	if err != nil {
 		return log.Err("failed to get database from GORM", err)
@@ -114,10 +167,23 @@ func (s *DB) initializeSQLiteDB(gormConfig *gorm.Config, config config.Config) e
 		return log.Err("failed to ping database through GORM", err)
 	}
 
+	if config.Database.ReadOnly {
+		log.Info("Database is a read-only replica, writes are refused", "dbPath", dbPath)
+	} else if err := checkWritable(context.Background(), sqlDB, dbPath); err != nil {
+		return log.Err("database is not writable", err)
+	}
+
 	log.Info("Successfully connected with GORM")
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetMaxOpenConns(100)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+	pool := config.DatabasePool()
+	applyPool(sqlDB, pool)
+	log.Info("Configured connection pool",
+		"maxOpenConns", pool.MaxOpenConns,
+		"maxIdleConns", pool.MaxIdleConns,
+		"connMaxLifetime", pool.ConnMaxLifetime,
+		"connMaxIdleTime", pool.ConnMaxIdleTime,
+		"prepareStmt", config.Database.PrepareStmt,
+	)
+	PoolStats.Watch(sqlDB)
 
 	s.SQL = db
 
@@ -149,6 +215,25 @@ func (s *DB) Close() (err error) {
 	return
 }
 
+type txKey struct{}
+
+// withTx makes SQLWithContext(ctx) use tx, for the repositories called
+// within a Bulk transaction.
+func withTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// InTransaction reports whether ctx was given a transaction by Bulk.
+func InTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*gorm.DB)
+	return ok
+}
+
+// SQLWithContext is the database bound to ctx, or the transaction ctx was
+// given by Bulk.
 func (s *DB) SQLWithContext(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
 	return s.SQL.WithContext(ctx)
 }
@@ -21,9 +21,11 @@ func TestNew_Success(t *testing.T) {
 
 	// Setup test config with in-memory database
 	testConfig := config.Config{
-		DatabaseDbPath:       ":memory:",
-		DatabaseCacheAddress: "localhost",
-		DatabaseCachePort:    6379,
+		Database: config.DatabaseConfig{Path: ":memory:"},
+		Cache: config.CacheConfig{
+			Address: "localhost",
+			Port:    6379,
+		},
 	}
 
 	// Test database creation (will fail at cache but succeed at SQL setup)
@@ -41,9 +43,11 @@ func TestNew_InvalidConfig(t *testing.T) {
 
 	// Test with empty database path
 	invalidConfig := config.Config{
-		DatabaseDbPath:       "",
-		DatabaseCacheAddress: "",
-		DatabaseCachePort:    0,
+		Database: config.DatabaseConfig{Path: ""},
+		Cache: config.CacheConfig{
+			Address: "",
+			Port:    0,
+		},
 	}
 
 	_, err := New(invalidConfig)
@@ -61,7 +65,7 @@ func TestInitializeSQLiteDB_Success(t *testing.T) {
 	dbPath := filepath.Join(tempDir, "test.db")
 
 	testConfig := config.Config{
-		DatabaseDbPath: dbPath,
+		Database: config.DatabaseConfig{Path: dbPath},
 	}
 
 	err := db.initializeSQLiteDB(&gorm.Config{}, testConfig)
@@ -86,7 +90,7 @@ func TestInitializeSQLiteDB_EmptyPath(t *testing.T) {
 	}
 
 	testConfig := config.Config{
-		DatabaseDbPath: "",
+		Database: config.DatabaseConfig{Path: ""},
 	}
 
 	err := db.initializeSQLiteDB(&gorm.Config{}, testConfig)
@@ -100,7 +104,7 @@ func TestInitializeSQLiteDB_InMemory(t *testing.T) {
 	}
 
 	testConfig := config.Config{
-		DatabaseDbPath: ":memory:",
+		Database: config.DatabaseConfig{Path: ":memory:"},
 	}
 
 	err := db.initializeSQLiteDB(&gorm.Config{}, testConfig)
@@ -122,7 +126,7 @@ func TestInitializeDB_ConfigurationCheck(t *testing.T) {
 	}
 
 	testConfig := config.Config{
-		DatabaseDbPath: ":memory:",
+		Database: config.DatabaseConfig{Path: ":memory:"},
 	}
 
 	err := db.initializeDB(testConfig)
@@ -145,7 +149,7 @@ func TestClose_WithSQLDB(t *testing.T) {
 	}
 
 	testConfig := config.Config{
-		DatabaseDbPath: ":memory:",
+		Database: config.DatabaseConfig{Path: ":memory:"},
 	}
 
 	err := db.initializeSQLiteDB(&gorm.Config{}, testConfig)
@@ -173,7 +177,7 @@ func TestSQLWithContext(t *testing.T) {
 	}
 
 	testConfig := config.Config{
-		DatabaseDbPath: ":memory:",
+		Database: config.DatabaseConfig{Path: ":memory:"},
 	}
 
 	err := db.initializeSQLiteDB(&gorm.Config{}, testConfig)
@@ -201,7 +205,7 @@ func TestTXDefer_Success(t *testing.T) {
 	}
 
 	testConfig := config.Config{
-		DatabaseDbPath: ":memory:",
+		Database: config.DatabaseConfig{Path: ":memory:"},
 	}
 
 	err := db.initializeSQLiteDB(&gorm.Config{}, testConfig)
@@ -240,7 +244,7 @@ func TestTXDefer_WithTransactionError(t *testing.T) {
 	}
 
 	testConfig := config.Config{
-		DatabaseDbPath: ":memory:",
+		Database: config.DatabaseConfig{Path: ":memory:"},
 	}
 
 	err := db.initializeSQLiteDB(&gorm.Config{}, testConfig)
@@ -274,8 +278,10 @@ func TestInitializeCacheDB_MissingConfig(t *testing.T) {
 
 	// Test with missing address
 	invalidConfig := config.Config{
-		DatabaseCacheAddress: "",
-		DatabaseCachePort:    6379,
+		Cache: config.CacheConfig{
+			Address: "",
+			Port:    6379,
+		},
 	}
 
 	err := db.initializeCacheDB(invalidConfig)
@@ -284,8 +290,10 @@ func TestInitializeCacheDB_MissingConfig(t *testing.T) {
 
 	// Test with missing port
 	invalidConfig2 := config.Config{
-		DatabaseCacheAddress: "localhost",
-		DatabaseCachePort:    0,
+		Cache: config.CacheConfig{
+			Address: "localhost",
+			Port:    0,
+		},
 	}
 
 	err = db.initializeCacheDB(invalidConfig2)
@@ -300,8 +308,10 @@ func TestInitializeCacheDB_ValidConfig(t *testing.T) {
 
 	// Test with valid config (will fail connection but tests logic)
 	testConfig := config.Config{
-		DatabaseCacheAddress: "localhost",
-		DatabaseCachePort:    6379,
+		Cache: config.CacheConfig{
+			Address: "localhost",
+			Port:    6379,
+		},
 	}
 
 	err := db.initializeCacheDB(testConfig)
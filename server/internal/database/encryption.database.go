@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"server/internal/encryption"
+
+	"gorm.io/gorm"
+)
+
+// EncryptionPlugin gives every gorm statement the data key, for the
+// encrypted serializer to encrypt and decrypt its columns with. Columns
+// tagged `gorm:"serializer:encrypted"` are sealed with a random nonce, so
+// they can never be queried by value.
+type EncryptionPlugin struct {
+	Key []byte
+}
+
+func (EncryptionPlugin) Name() string { return "encryption" }
+
+func (p EncryptionPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:begin_transaction").Register("encryption:create", p.withKey),
+		callbacks.Query().Before("gorm:query").Register("encryption:query", p.withKey),
+		callbacks.Update().Before("gorm:begin_transaction").Register("encryption:update", p.withKey),
+		callbacks.Delete().Before("gorm:begin_transaction").Register("encryption:delete", p.withKey),
+		callbacks.Row().Before("gorm:row").Register("encryption:row", p.withKey),
+		callbacks.Raw().Before("gorm:raw").Register("encryption:raw", p.withKey),
+	)
+}
+
+func (p EncryptionPlugin) withKey(tx *gorm.DB) {
+	ctx := tx.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tx.Statement.Context = encryption.WithKey(ctx, p.Key)
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"path/filepath"
+	"server/internal/encryption"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type encryptedAccount struct {
+	ID       string `gorm:"primaryKey"`
+	Login    string
+	Password string `gorm:"serializer:encrypted"`
+}
+
+func openEncryptionTest(t *testing.T, path string, key []byte) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	require.NoError(t, err)
+	if key != nil {
+		require.NoError(t, db.Use(EncryptionPlugin{Key: key}))
+	}
+	require.NoError(t, db.AutoMigrate(&encryptedAccount{}))
+	return db
+}
+
+func storedPassword(t *testing.T, db *gorm.DB, id string) string {
+	t.Helper()
+	var password string
+	require.NoError(t, db.Raw("SELECT password FROM encrypted_accounts WHERE id = ?", id).Scan(&password).Error)
+	return password
+}
+
+func TestEncryptionPlugin(t *testing.T) {
+	key := []byte(strings.Repeat("k", encryption.KEY_SIZE))
+	db := openEncryptionTest(t, filepath.Join(t.TempDir(), "encryption.db"), key)
+
+	require.NoError(t, db.Create(&encryptedAccount{ID: "1", Login: "ada", Password: "hash-1"}).Error)
+	assert.True(t, encryption.IsEncrypted(storedPassword(t, db, "1")))
+
+	// Found by the other columns, and decrypted on the way out
+	var account encryptedAccount
+	require.NoError(t, db.Where("login = ?", "ada").First(&account).Error)
+	assert.Equal(t, "hash-1", account.Password)
+
+	account.Password = "hash-2"
+	require.NoError(t, db.Save(&account).Error)
+	require.NoError(t, db.Model(&account).Update("login", "ada2").Error)
+	var updated encryptedAccount
+	require.NoError(t, db.First(&updated, "id = ?", "1").Error)
+	assert.Equal(t, encryptedAccount{ID: "1", Login: "ada2", Password: "hash-2"}, updated)
+	assert.True(t, encryption.IsEncrypted(storedPassword(t, db, "1")))
+}
+
+func TestEncryptionPlugin_ReadsPlaintext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encryption.db")
+	plain := openEncryptionTest(t, path, nil)
+	require.NoError(t, plain.Create(&encryptedAccount{ID: "1", Password: "hash-1"}).Error)
+	assert.Equal(t, "hash-1", storedPassword(t, plain, "1"), "no key, no encryption")
+
+	key := []byte(strings.Repeat("k", encryption.KEY_SIZE))
+	encrypted := openEncryptionTest(t, path, key)
+	var account encryptedAccount
+	require.NoError(t, encrypted.First(&account, "id = ?", "1").Error)
+	assert.Equal(t, "hash-1", account.Password)
+	require.NoError(t, encrypted.Create(&encryptedAccount{ID: "2", Password: "hash-2"}).Error)
+
+	// Encrypted values need the key
+	err := plain.First(&encryptedAccount{}, "id = ?", "2").Error
+	assert.ErrorIs(t, err, encryption.ErrNoKey)
+
+	other := openEncryptionTest(t, path, []byte(strings.Repeat("o", encryption.KEY_SIZE)))
+	err = other.First(&encryptedAccount{}, "id = ?", "2").Error
+	assert.ErrorIs(t, err, encryption.ErrDecrypt)
+}
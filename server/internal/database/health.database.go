@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"server/internal/health"
+
+	"gorm.io/gorm"
+)
+
+type sqlChecker struct {
+	db *gorm.DB
+}
+
+// SQLHealth pings the sqlite database.
+func (s DB) SQLHealth() health.Checker {
+	return sqlChecker{db: s.SQL}
+}
+
+func (c sqlChecker) Name() string { return "database" }
+
+func (c sqlChecker) Check(ctx context.Context) health.CheckResult {
+	if c.db == nil {
+		return health.Down(errors.New("database is not open"), nil)
+	}
+	sqlDB, err := c.db.DB()
+	if err != nil {
+		return health.Down(err, nil)
+	}
+
+	stats := sqlDB.Stats()
+	details := map[string]any{
+		"openConnections":    stats.OpenConnections,
+		"maxOpenConnections": stats.MaxOpenConnections,
+		"inUse":              stats.InUse,
+		"idle":               stats.Idle,
+		"waitCount":          stats.WaitCount,
+		"waitDuration":       stats.WaitDuration.String(),
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return health.Down(err, details)
+	}
+	return health.OK(details)
+}
+
+type cacheChecker struct {
+	client CacheClient
+}
+
+// CacheHealth pings the general cache; the other clients share its server.
+func (s DB) CacheHealth() health.Checker {
+	return cacheChecker{client: s.Cache.General}
+}
+
+func (c cacheChecker) Name() string { return "cache" }
+
+func (c cacheChecker) Check(ctx context.Context) health.CheckResult {
+	if c.client == nil {
+		return health.Down(errors.New("cache is not connected"), nil)
+	}
+	if err := c.client.Do(ctx, c.client.B().Ping().Build()).Error(); err != nil {
+		return health.Down(err, nil)
+	}
+	return health.OK(nil)
+}
+
+type backupChecker struct {
+	backup *Backup
+}
+
+// Health reports the last backup, down when it failed.
+func (b *Backup) Health() health.Checker {
+	return backupChecker{backup: b}
+}
+
+func (c backupChecker) Name() string { return "backup" }
+
+func (c backupChecker) Check(ctx context.Context) health.CheckResult {
+	status := c.backup.Status()
+	details := map[string]any{"backup": status}
+	if status.Error != "" {
+		return health.Down(errors.New(status.Error), details)
+	}
+	return health.OK(details)
+}
+
+type integrityChecker struct {
+	integrity *Integrity
+}
+
+// Health reports the last integrity check and the file sizes measured with
+// it: down when the check failed, degraded while the WAL is over its alert
+// size.
+func (i *Integrity) Health() health.Checker {
+	return integrityChecker{integrity: i}
+}
+
+func (c integrityChecker) Name() string { return "integrity" }
+
+func (c integrityChecker) Check(ctx context.Context) health.CheckResult {
+	status := c.integrity.Status()
+	details := map[string]any{"integrity": status}
+	if status.Error != "" {
+		return health.Down(errors.New(status.Error), details)
+	}
+	if status.WALOverThreshold {
+		return health.Degraded(errors.New("write-ahead log is over the alert size"), details)
+	}
+	return health.OK(details)
+}
@@ -0,0 +1,271 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/events"
+	"server/internal/logger"
+	"server/internal/models"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	INTEGRITY_CHECK_QUICK = "quick_check"
+	INTEGRITY_CHECK_FULL  = "integrity_check"
+
+	// The full check reads every page, holding a connection the whole time,
+	// so it runs only this often and quick_check the rest of the time
+	INTEGRITY_FULL_CHECK_INTERVAL = 7 * 24 * time.Hour
+
+	// How many problems a failed check reports, of the many it may find
+	INTEGRITY_PROBLEM_LIMIT = 10
+
+	// SystemAlertEvent codes
+	ALERT_CODE_INTEGRITY_FAILED = "sqlite_integrity_failed"
+	ALERT_CODE_WAL_SIZE         = "sqlite_wal_size"
+)
+
+var ErrIntegrity = errors.New("database failed integrity check")
+
+// IntegrityStatus is the outcome of the last integrity check and the sizes
+// measured with it, as reported by the health endpoint.
+type IntegrityStatus struct {
+	CheckedAt  *models.APITime `json:"checkedAt,omitempty"`
+	Check      string          `json:"check,omitempty"`
+	DurationMs int64           `json:"durationMs"`
+	Problems   []string        `json:"problems,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	LastFullAt *models.APITime `json:"lastFullAt,omitempty"`
+
+	FileBytes        int64 `json:"fileBytes"`
+	WALBytes         int64 `json:"walBytes"`
+	WALOverThreshold bool  `json:"walOverThreshold"`
+
+	// Utilization is the share of pages holding data rather than sitting
+	// on the freelist, which VACUUM would give back
+	PageSize        int64   `json:"pageSize"`
+	PageCount       int64   `json:"pageCount"`
+	FreePages       int64   `json:"freePages"`
+	PageUtilization float64 `json:"pageUtilization"`
+}
+
+// StatFunc returns the size of the file at path, 0 when there is none.
+type StatFunc func(path string) (int64, error)
+
+// AlertPublisher raises system alerts. The event bus's SystemAlertTopic
+// implements it.
+type AlertPublisher interface {
+	Publish(ctx context.Context, payload events.SystemAlertEvent) error
+}
+
+// Integrity checks the sqlite file for corruption and keeps an eye on its
+// size, raising a system alert when a check fails or the write-ahead log
+// grows past the configured threshold.
+type Integrity struct {
+	db           *gorm.DB
+	walThreshold int64
+	clock        clock.Clock
+	log          logger.Logger
+	stat         StatFunc
+	alerts       AlertPublisher
+
+	mutex      sync.RWMutex
+	status     IntegrityStatus
+	lastFull   time.Time
+	walAlerted bool
+}
+
+// NewIntegrity builds the checker. The first full check is due a week after
+// it is built, so a restart never starts with one. A nil clock uses the wall
+// clock, and a nil publisher only logs alerts.
+func NewIntegrity(db *gorm.DB, config config.Config, clk clock.Clock, alerts AlertPublisher) *Integrity {
+	clk = clock.OrDefault(clk)
+	return &Integrity{
+		db:           db,
+		walThreshold: int64(config.Database.WalAlertMB) << 20,
+		clock:        clk,
+		log:          logger.New("database").File("integrity"),
+		stat:         statFileSize,
+		alerts:       alerts,
+		lastFull:     clk.Now(),
+	}
+}
+
+// Run checks the database, fully when the last full check is a week old, and
+// raises the alerts it calls for. It matches scheduler.Job so it can be
+// scheduled as is.
+func (i *Integrity) Run(ctx context.Context) error {
+	log := i.log.Function("Run")
+
+	i.mutex.RLock()
+	full := i.clock.Now().Sub(i.lastFull) >= INTEGRITY_FULL_CHECK_INTERVAL
+	i.mutex.RUnlock()
+
+	status, err := i.Check(ctx, full)
+	i.record(status)
+
+	if err != nil {
+		i.alert(ctx, events.SystemAlertEvent{
+			Code:     ALERT_CODE_INTEGRITY_FAILED,
+			Severity: events.ALERT_SEVERITY_CRITICAL,
+			Message:  fmt.Sprintf("sqlite %s failed, restore from a backup", status.Check),
+			Details:  map[string]any{"check": status.Check, "problems": status.Problems, "error": status.Error},
+		})
+		return log.Err("database failed integrity check", err, "check", status.Check)
+	}
+
+	log.Info("Database integrity checked", "check", status.Check, "durationMs", status.DurationMs,
+		"fileBytes", status.FileBytes, "walBytes", status.WALBytes)
+	return nil
+}
+
+// Check runs quick_check, or integrity_check when full, and measures the
+// file. It neither records the outcome nor alerts, so it can run on demand.
+// The error wraps ErrIntegrity when the check found problems.
+func (i *Integrity) Check(ctx context.Context, full bool) (IntegrityStatus, error) {
+	check := INTEGRITY_CHECK_QUICK
+	if full {
+		check = INTEGRITY_CHECK_FULL
+	}
+
+	checkedAt := models.NewAPITime(i.clock.Now())
+	status := IntegrityStatus{CheckedAt: &checkedAt, Check: check}
+	start := time.Now()
+	problems, err := i.runCheck(ctx, check)
+	status.DurationMs = time.Since(start).Milliseconds()
+	status.Problems = problems
+	if err == nil && len(problems) > 0 {
+		err = fmt.Errorf("%w: %s", ErrIntegrity, strings.Join(problems, "; "))
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	// Sizes still help diagnose a failed check, so measure either way
+	if measureErr := i.measure(ctx, &status); measureErr != nil {
+		i.log.Function("Check").Warn("failed to measure database", "error", measureErr)
+	}
+	return status, err
+}
+
+// Status returns the outcome of the last scheduled check.
+func (i *Integrity) Status() IntegrityStatus {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.status
+}
+
+// runCheck returns the problems the check lists; it answers a single ok row
+// when there are none. A file too damaged to read fails the query instead.
+func (i *Integrity) runCheck(ctx context.Context, check string) ([]string, error) {
+	rows, err := i.db.WithContext(ctx).Raw(fmt.Sprintf("PRAGMA %s(%d)", check, INTEGRITY_PROBLEM_LIMIT)).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIntegrity, err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrIntegrity, err)
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return problems, fmt.Errorf("%w: %w", ErrIntegrity, err)
+	}
+	return problems, nil
+}
+
+// measure fills in the file and page sizes. The file is the one sqlite has
+// open, so it is right however the path was configured.
+func (i *Integrity) measure(ctx context.Context, status *IntegrityStatus) error {
+	db := i.db.WithContext(ctx)
+
+	var path string
+	if err := db.Raw("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path).Error; err != nil {
+		return err
+	}
+	if path != "" {
+		var err error
+		if status.FileBytes, err = i.stat(path); err != nil {
+			return err
+		}
+		if status.WALBytes, err = i.stat(path + "-wal"); err != nil {
+			return err
+		}
+	}
+	status.WALOverThreshold = i.walThreshold > 0 && status.WALBytes > i.walThreshold
+
+	for pragma, value := range map[string]*int64{
+		"page_size":      &status.PageSize,
+		"page_count":     &status.PageCount,
+		"freelist_count": &status.FreePages,
+	} {
+		if err := db.Raw("PRAGMA " + pragma).Scan(value).Error; err != nil {
+			return err
+		}
+	}
+	if status.PageCount > 0 {
+		status.PageUtilization = float64(status.PageCount-status.FreePages) / float64(status.PageCount)
+	}
+	return nil
+}
+
+// record keeps status for the health endpoint and alerts when the WAL first
+// goes over the threshold, again only once it has come back under.
+func (i *Integrity) record(status IntegrityStatus) {
+	i.mutex.Lock()
+	if status.Check == INTEGRITY_CHECK_FULL && status.CheckedAt != nil {
+		i.lastFull = status.CheckedAt.Time
+	}
+	if !i.lastFull.IsZero() {
+		status.LastFullAt = models.NewAPITimePtr(&i.lastFull)
+	}
+	i.status = status
+	alertWAL := status.WALOverThreshold && !i.walAlerted
+	i.walAlerted = status.WALOverThreshold
+	i.mutex.Unlock()
+
+	if alertWAL {
+		i.alert(context.Background(), events.SystemAlertEvent{
+			Code:     ALERT_CODE_WAL_SIZE,
+			Severity: events.ALERT_SEVERITY_WARNING,
+			Message:  "sqlite write-ahead log is over the alert size, run PRAGMA wal_checkpoint(TRUNCATE)",
+			Details:  map[string]any{"walBytes": status.WALBytes, "thresholdBytes": i.walThreshold},
+		})
+	}
+}
+
+func (i *Integrity) alert(ctx context.Context, alert events.SystemAlertEvent) {
+	log := i.log.Function("alert")
+	log.Warn("Raising system alert", "code", alert.Code, "message", alert.Message)
+	if i.alerts == nil {
+		return
+	}
+	if err := i.alerts.Publish(ctx, alert); err != nil {
+		log.Er("failed to publish system alert", err, "code", alert.Code)
+	}
+}
+
+func statFileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/events"
+	"server/internal/health"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type recordingAlerts struct {
+	mutex  sync.Mutex
+	alerts []events.SystemAlertEvent
+}
+
+func (r *recordingAlerts) Publish(ctx context.Context, payload events.SystemAlertEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.alerts = append(r.alerts, payload)
+	return nil
+}
+
+func (r *recordingAlerts) codes() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	codes := make([]string, 0, len(r.alerts))
+	for _, alert := range r.alerts {
+		codes = append(codes, alert.Code)
+	}
+	return codes
+}
+
+// scratchDatabase writes a sqlite file with an indexed table big enough to
+// span many pages.
+func scratchDatabase(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scratch.db")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)").Error)
+	require.NoError(t, db.Exec("CREATE INDEX items_name ON items (name)").Error)
+	require.NoError(t, db.Exec(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 2000)
+		INSERT INTO items (name) SELECT printf('item-%04d-%s', i, hex(randomblob(20))) FROM n`).Error)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+	return path
+}
+
+func openScratch(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestIntegrity_Healthy(t *testing.T) {
+	db := openScratch(t, scratchDatabase(t))
+	alerts := &recordingAlerts{}
+	integrity := NewIntegrity(db, config.Config{}, nil, alerts)
+
+	require.NoError(t, integrity.Run(context.Background()))
+
+	status := integrity.Status()
+	assert.Equal(t, INTEGRITY_CHECK_QUICK, status.Check)
+	assert.Empty(t, status.Error)
+	assert.Positive(t, status.FileBytes)
+	assert.Zero(t, status.WALBytes)
+	assert.Equal(t, status.PageCount*status.PageSize, status.FileBytes)
+	assert.InDelta(t, 1, status.PageUtilization, 0.01)
+	assert.Empty(t, alerts.codes())
+
+	result := integrity.Health().Check(context.Background())
+	assert.Equal(t, health.STATUS_OK, result.Status)
+	assert.Equal(t, status, result.Details["integrity"])
+}
+
+func TestIntegrity_CorruptedFile(t *testing.T) {
+	path := scratchDatabase(t)
+
+	// Scribble over pages past the schema, where the table and index live
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	garbage := []byte(strings.Repeat("\xde\xad\xbe\xef", 1024))
+	for _, page := range []int64{3, 5, 8, 13} {
+		_, err := file.WriteAt(garbage, page*4096+100)
+		require.NoError(t, err)
+	}
+	require.NoError(t, file.Close())
+
+	alerts := &recordingAlerts{}
+	integrity := NewIntegrity(openScratch(t, path), config.Config{}, nil, alerts)
+
+	err = integrity.Run(context.Background())
+	require.ErrorIs(t, err, ErrIntegrity)
+
+	status := integrity.Status()
+	assert.NotEmpty(t, status.Error)
+	assert.Positive(t, status.FileBytes, "sizes are still measured")
+	assert.Equal(t, []string{ALERT_CODE_INTEGRITY_FAILED}, alerts.codes())
+	assert.Equal(t, events.ALERT_SEVERITY_CRITICAL, alerts.alerts[0].Severity)
+
+	result := integrity.Health().Check(context.Background())
+	assert.Equal(t, health.STATUS_DOWN, result.Status)
+}
+
+func TestIntegrity_WALThreshold(t *testing.T) {
+	db := openScratch(t, scratchDatabase(t))
+	alerts := &recordingAlerts{}
+	integrity := NewIntegrity(db, config.Config{Database: config.DatabaseConfig{WalAlertMB: 1}}, nil, alerts)
+
+	var walBytes int64
+	integrity.stat = func(path string) (int64, error) {
+		if strings.HasSuffix(path, "-wal") {
+			return walBytes, nil
+		}
+		return 4096, nil
+	}
+	ctx := context.Background()
+
+	for _, step := range []struct {
+		walBytes int64
+		over     bool
+		alerts   int
+	}{
+		{walBytes: 1 << 20, over: false, alerts: 0},
+		{walBytes: 1<<20 + 1, over: true, alerts: 1},
+		{walBytes: 2 << 20, over: true, alerts: 1},
+		{walBytes: 0, over: false, alerts: 1},
+		{walBytes: 3 << 20, over: true, alerts: 2},
+	} {
+		walBytes = step.walBytes
+		require.NoError(t, integrity.Run(ctx))
+
+		status := integrity.Status()
+		assert.Equal(t, step.walBytes, status.WALBytes)
+		assert.Equal(t, step.over, status.WALOverThreshold, step.walBytes)
+		assert.Len(t, alerts.codes(), step.alerts, "alerts once per crossing, at %d bytes", step.walBytes)
+
+		expected := health.STATUS_OK
+		if step.over {
+			expected = health.STATUS_DEGRADED
+		}
+		assert.Equal(t, expected, integrity.Health().Check(ctx).Status)
+	}
+	assert.Equal(t, ALERT_CODE_WAL_SIZE, alerts.alerts[0].Code)
+
+	// No threshold, no alert
+	integrity.walThreshold = 0
+	require.NoError(t, integrity.Run(ctx))
+	assert.False(t, integrity.Status().WALOverThreshold)
+}
+
+func TestIntegrity_FullCheckWeekly(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	integrity := NewIntegrity(openScratch(t, scratchDatabase(t)), config.Config{}, fake, nil)
+	ctx := context.Background()
+
+	require.NoError(t, integrity.Run(ctx))
+	assert.Equal(t, INTEGRITY_CHECK_QUICK, integrity.Status().Check, "a restart doesn't start with a full check")
+
+	fake.Advance(INTEGRITY_FULL_CHECK_INTERVAL)
+	require.NoError(t, integrity.Run(ctx))
+	status := integrity.Status()
+	assert.Equal(t, INTEGRITY_CHECK_FULL, status.Check)
+	require.NotNil(t, status.LastFullAt)
+	assert.Equal(t, fake.Now(), status.LastFullAt.Time)
+
+	fake.Advance(time.Hour)
+	require.NoError(t, integrity.Run(ctx))
+	assert.Equal(t, INTEGRITY_CHECK_QUICK, integrity.Status().Check)
+}
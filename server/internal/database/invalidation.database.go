@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"server/internal/events"
+	"server/internal/logger"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// InvalidationTransport carries invalidations between instances. The event
+// bus's CacheInvalidationTopic implements it on top of valkey pub/sub.
+type InvalidationTransport interface {
+	Publish(ctx context.Context, payload events.CacheInvalidatedEvent) error
+	Subscribe(handler func(ctx context.Context, payload events.CacheInvalidatedEvent) error) error
+}
+
+// Invalidator is the single place repositories report a changed entity. It
+// evicts the local caches registered for that entity right away and tells the
+// other instances to do the same. Without a transport it only evicts locally.
+type Invalidator struct {
+	instanceID string
+	transport  InvalidationTransport
+	log        logger.Logger
+
+	mutex    sync.RWMutex
+	evictors map[string][]func(id string)
+}
+
+func NewInvalidator(transport InvalidationTransport) (*Invalidator, error) {
+	invalidator := &Invalidator{
+		instanceID: uuid.New().String(),
+		transport:  transport,
+		log:        logger.New("database").File("invalidation"),
+		evictors:   make(map[string][]func(id string)),
+	}
+
+	if transport == nil {
+		return invalidator, nil
+	}
+
+	if err := transport.Subscribe(invalidator.receive); err != nil {
+		return nil, invalidator.log.Function("NewInvalidator").
+			Err("failed to subscribe to cache invalidations", err)
+	}
+	return invalidator, nil
+}
+
+// Register adds a local cache eviction for entity.
+func (i *Invalidator) Register(entity string, evict func(id string)) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.evictors[entity] = append(i.evictors[entity], evict)
+}
+
+// Invalidate evicts entity id from this instance's caches and publishes the
+// change to the others. Callers should update the shared cache first so other
+// instances reload the new value.
+func (i *Invalidator) Invalidate(ctx context.Context, entity string, id string) error {
+	i.evict(entity, id)
+
+	if i.transport == nil {
+		return nil
+	}
+
+	err := i.transport.Publish(ctx, events.CacheInvalidatedEvent{
+		Entity: entity,
+		ID:     id,
+		Origin: i.instanceID,
+	})
+	if err != nil {
+		return i.log.Function("Invalidate").
+			Err("failed to publish cache invalidation", err, "entity", entity, "id", id)
+	}
+	return nil
+}
+
+// InvalidateMany is Invalidate for several ids of entity, published as a
+// single event.
+func (i *Invalidator) InvalidateMany(ctx context.Context, entity string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	for _, id := range ids {
+		i.evict(entity, id)
+	}
+
+	if i.transport == nil {
+		return nil
+	}
+
+	err := i.transport.Publish(ctx, events.CacheInvalidatedEvent{
+		Entity: entity,
+		IDs:    ids,
+		Origin: i.instanceID,
+	})
+	if err != nil {
+		return i.log.Function("InvalidateMany").
+			Err("failed to publish cache invalidations", err, "entity", entity, "count", len(ids))
+	}
+	return nil
+}
+
+func (i *Invalidator) receive(ctx context.Context, payload events.CacheInvalidatedEvent) error {
+	// Our own changes were evicted when they were made, and valkey echoes
+	// them back to us.
+	if payload.Origin == i.instanceID {
+		return nil
+	}
+
+	i.log.Function("receive").
+		Debug("Applying remote cache invalidation", "entity", payload.Entity, "id", payload.ID, "count", len(payload.IDs))
+	if payload.ID != "" {
+		i.evict(payload.Entity, payload.ID)
+	}
+	for _, id := range payload.IDs {
+		i.evict(payload.Entity, id)
+	}
+	return nil
+}
+
+func (i *Invalidator) evict(entity string, id string) {
+	i.mutex.RLock()
+	evictors := i.evictors[entity]
+	i.mutex.RUnlock()
+
+	for _, evict := range evictors {
+		evict(id)
+	}
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"server/internal/logger"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// A batch flushes this long after its first pending invalidation, or as
+	// soon as it holds INVALIDATION_BATCH_SIZE keys, whichever comes first
+	INVALIDATION_BATCH_INTERVAL = 50 * time.Millisecond
+	INVALIDATION_BATCH_SIZE     = 500
+)
+
+// InvalidationBatch collects the invalidations of a bulk operation, so
+// touching thousands of entities costs a DEL per cache namespace and one
+// published event per flush rather than round trips for each. Repositories
+// find it with InvalidationBatchFrom and queue their changes on it; outside a
+// batch they invalidate straight away.
+//
+// Queued entities are deleted from the shared cache, not rewritten, and are
+// reloaded when next read. Until the batch flushes, reads may still see the
+// old value.
+type InvalidationBatch struct {
+	invalidator *Invalidator
+	ctx         context.Context
+	interval    time.Duration
+	size        int
+	log         logger.Logger
+
+	mutex   sync.Mutex
+	pending map[string]pendingInvalidation
+	flushed map[string]pendingInvalidation
+	timer   *time.Timer
+
+	// Held for a whole flush, so Flush returns only once invalidations
+	// queued before it, including ones a timed flush took, have gone out
+	flushMutex sync.Mutex
+}
+
+type pendingInvalidation struct {
+	store  CacheStore
+	entity string
+	id     string
+}
+
+// NewInvalidationBatch starts a batch. Timed flushes run with ctx's values
+// but outlive its cancellation. Its owner must Flush it once done.
+func NewInvalidationBatch(ctx context.Context, invalidator *Invalidator) *InvalidationBatch {
+	return &InvalidationBatch{
+		invalidator: invalidator,
+		ctx:         context.WithoutCancel(ctx),
+		interval:    INVALIDATION_BATCH_INTERVAL,
+		size:        INVALIDATION_BATCH_SIZE,
+		log:         logger.New("database").File("invalidation_batch"),
+		pending:     make(map[string]pendingInvalidation),
+		flushed:     make(map[string]pendingInvalidation),
+	}
+}
+
+type invalidationBatchKey struct{}
+
+// WithInvalidationBatch makes repositories queue the invalidations of
+// changes made with ctx on batch.
+func WithInvalidationBatch(ctx context.Context, batch *InvalidationBatch) context.Context {
+	return context.WithValue(ctx, invalidationBatchKey{}, batch)
+}
+
+// InvalidationBatchFrom is the batch ctx's changes are queued on, or nil
+// outside a bulk operation.
+func InvalidationBatchFrom(ctx context.Context) *InvalidationBatch {
+	batch, _ := ctx.Value(invalidationBatchKey{}).(*InvalidationBatch)
+	return batch
+}
+
+// Add queues deleting key from store and invalidating entity id. Adding a
+// key that is already pending coalesces with it.
+func (b *InvalidationBatch) Add(store CacheStore, entity string, id string, key string) {
+	b.mutex.Lock()
+	b.pending[key] = pendingInvalidation{store: store, entity: entity, id: id}
+	full := len(b.pending) >= b.size
+	if !full && b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, b.flushTimed)
+	}
+	b.mutex.Unlock()
+
+	if full {
+		if err := b.Flush(b.ctx); err != nil {
+			b.log.Function("Add").Warn("failed to flush full invalidation batch", "error", err)
+		}
+	}
+}
+
+func (b *InvalidationBatch) flushTimed() {
+	if err := b.Flush(b.ctx); err != nil {
+		b.log.Function("flushTimed").Warn("failed to flush invalidation batch", "error", err)
+	}
+}
+
+// Flush deletes every pending key and invalidates their entities before it
+// returns. Local copies are evicted even when the shared cache can't be
+// reached.
+func (b *InvalidationBatch) Flush(ctx context.Context) error {
+	b.flushMutex.Lock()
+	defer b.flushMutex.Unlock()
+
+	b.mutex.Lock()
+	pending := b.pending
+	b.pending = make(map[string]pendingInvalidation)
+	maps.Copy(b.flushed, pending)
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mutex.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	keysByStore := make(map[CacheStore][]string)
+	idsByEntity := make(map[string][]string)
+	for key, invalidation := range pending {
+		keysByStore[invalidation.store] = append(keysByStore[invalidation.store], key)
+		idsByEntity[invalidation.entity] = append(idsByEntity[invalidation.entity], invalidation.id)
+	}
+
+	// The shared cache goes first so instances dropping their local copies
+	// reload the change rather than the old value
+	var errs []error
+	for store, keys := range keysByStore {
+		if err := store.DeleteMany(ctx, keys); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for entity, ids := range idsByEntity {
+		if err := b.invalidator.InvalidateMany(ctx, entity, ids); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	b.log.Function("Flush").Debug("Flushed invalidation batch", "keys", len(pending))
+	return errors.Join(errs...)
+}
+
+// flushCommitted flushes every key the batch has seen, including ones
+// flushed before the transaction committed, which a concurrent read may have
+// cached again from the rows as they were.
+func (b *InvalidationBatch) flushCommitted(ctx context.Context) error {
+	b.mutex.Lock()
+	for key, invalidation := range b.flushed {
+		if _, ok := b.pending[key]; !ok {
+			b.pending[key] = invalidation
+		}
+	}
+	b.mutex.Unlock()
+	return b.Flush(ctx)
+}
+
+// Bulk runs fn in a transaction whose context carries an invalidation batch,
+// and flushes the batch once the transaction has finished, before returning.
+// Repositories called with that context write through the transaction.
+func (s *DB) Bulk(ctx context.Context, invalidator *Invalidator, fn func(ctx context.Context) error) error {
+	batch := NewInvalidationBatch(ctx, invalidator)
+	err := s.SQL.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(WithInvalidationBatch(withTx(ctx, tx), batch))
+	})
+
+	// A rolled back transaction changed nothing, but some of its keys may
+	// have been flushed already, and deleting the rest is harmless
+	if flushErr := batch.flushCommitted(ctx); flushErr != nil {
+		logger.New("database").Function("Bulk").Warn("failed to flush invalidations after bulk transaction", "error", flushErr)
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// countingStore records the keys of each DeleteMany.
+type countingStore struct {
+	CacheStore
+	mutex   sync.Mutex
+	batches [][]string
+}
+
+func (s *countingStore) DeleteMany(ctx context.Context, keys []string) error {
+	s.mutex.Lock()
+	s.batches = append(s.batches, keys)
+	s.mutex.Unlock()
+	return s.CacheStore.DeleteMany(ctx, keys)
+}
+
+func (s *countingStore) deletes() [][]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([][]string{}, s.batches...)
+}
+
+func newTestBatch(t *testing.T, interval time.Duration) (*InvalidationBatch, *countingStore, *recordingTransport) {
+	t.Helper()
+	transport := &recordingTransport{}
+	invalidator, err := NewInvalidator(transport)
+	require.NoError(t, err)
+	store := &countingStore{CacheStore: NewMemoryCacheStore()}
+
+	batch := NewInvalidationBatch(context.Background(), invalidator)
+	batch.interval = interval
+	return batch, store, transport
+}
+
+func TestInvalidationBatch_Coalesces(t *testing.T) {
+	batch, store, transport := newTestBatch(t, time.Hour)
+	ctx := context.Background()
+
+	for i := range 1000 {
+		id := fmt.Sprint(i % 10)
+		require.NoError(t, store.Set(ctx, "user:"+id, id, 0))
+		batch.Add(store, "user", id, "user:"+id)
+	}
+	assert.Empty(t, store.deletes(), "nothing goes out before a flush")
+
+	require.NoError(t, batch.Flush(ctx))
+	deletes := store.deletes()
+	require.Len(t, deletes, 1)
+	assert.Len(t, deletes[0], 10)
+	require.Len(t, transport.published, 1)
+	assert.ElementsMatch(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, transport.published[0].IDs)
+
+	keys, _, err := store.Scan(ctx, "user:*", 0)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	require.NoError(t, batch.Flush(ctx))
+	assert.Len(t, store.deletes(), 1, "an empty flush sends nothing")
+}
+
+func TestInvalidationBatch_FlushesWhenFull(t *testing.T) {
+	batch, store, _ := newTestBatch(t, time.Hour)
+
+	for i := range INVALIDATION_BATCH_SIZE - 1 {
+		batch.Add(store, "user", fmt.Sprint(i), fmt.Sprintf("user:%d", i))
+	}
+	assert.Empty(t, store.deletes())
+
+	batch.Add(store, "user", "last", "user:last")
+	deletes := store.deletes()
+	require.Len(t, deletes, 1, "the key that fills the batch flushes it")
+	assert.Len(t, deletes[0], INVALIDATION_BATCH_SIZE)
+}
+
+func TestInvalidationBatch_FlushesAfterInterval(t *testing.T) {
+	batch, store, transport := newTestBatch(t, 10*time.Millisecond)
+	evicted := make(chan string, 1)
+	batch.invalidator.Register("user", func(id string) { evicted <- id })
+
+	batch.Add(store, "user", "42", "user:42")
+	select {
+	case id := <-evicted:
+		assert.Equal(t, "42", id)
+	case <-time.After(time.Second):
+		t.Fatal("the batch was not flushed after its interval")
+	}
+	assert.Equal(t, [][]string{{"user:42"}}, store.deletes())
+
+	// The flush that took it holds Flush until it has gone out
+	require.NoError(t, batch.Flush(context.Background()))
+	assert.Len(t, transport.published, 1)
+}
+
+func TestInvalidationBatch_FlushErrorStillEvictsLocally(t *testing.T) {
+	batch, _, _ := newTestBatch(t, time.Hour)
+	evicted := false
+	batch.invalidator.Register("user", func(id string) { evicted = true })
+
+	batch.Add(failingStore{}, "user", "42", "user:42")
+	assert.Error(t, batch.Flush(context.Background()))
+	assert.True(t, evicted)
+}
+
+type failingStore struct {
+	CacheStore
+}
+
+func (failingStore) DeleteMany(ctx context.Context, keys []string) error {
+	return errors.New("valkey down")
+}
+
+func TestDB_Bulk_FlushesAtCommit(t *testing.T) {
+	sqlDB, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Exec("CREATE TABLE items (id TEXT PRIMARY KEY)").Error)
+	db := DB{SQL: sqlDB}
+
+	invalidator, err := NewInvalidator(nil)
+	require.NoError(t, err)
+	store := NewMemoryCacheStore()
+	ctx := context.Background()
+	require.NoError(t, store.Set(ctx, "item:a", "old", 0))
+	require.NoError(t, store.Set(ctx, "item:b", "old", 0))
+
+	err = db.Bulk(ctx, invalidator, func(ctx context.Context) error {
+		batch := InvalidationBatchFrom(ctx)
+		require.NotNil(t, batch)
+		require.NoError(t, db.SQLWithContext(ctx).Exec("INSERT INTO items (id) VALUES ('a'), ('b')").Error)
+
+		batch.Add(store, "item", "a", "item:a")
+		require.NoError(t, batch.Flush(ctx))
+		// A reader caches a again from the rows as they were before commit
+		require.NoError(t, store.Set(ctx, "item:a", "old", 0))
+
+		batch.Add(store, "item", "b", "item:b")
+		return nil
+	})
+	require.NoError(t, err)
+
+	var count int64
+	require.NoError(t, sqlDB.Table("items").Count(&count).Error)
+	assert.Equal(t, int64(2), count, "the transaction committed")
+	for _, key := range []string{"item:a", "item:b"} {
+		var value string
+		assert.ErrorIs(t, store.Get(ctx, key, &value), ErrCacheMiss, key)
+	}
+	assert.Nil(t, InvalidationBatchFrom(ctx), "outside Bulk nothing is batched")
+}
+
+func TestDB_Bulk_RollsBack(t *testing.T) {
+	sqlDB, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Exec("CREATE TABLE items (id TEXT PRIMARY KEY)").Error)
+	db := DB{SQL: sqlDB}
+	invalidator, err := NewInvalidator(nil)
+	require.NoError(t, err)
+
+	failed := errors.New("row 2 is invalid")
+	err = db.Bulk(context.Background(), invalidator, func(ctx context.Context) error {
+		require.NoError(t, db.SQLWithContext(ctx).Exec("INSERT INTO items (id) VALUES ('a')").Error)
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+
+	var count int64
+	require.NoError(t, sqlDB.Table("items").Count(&count).Error)
+	assert.Zero(t, count)
+}
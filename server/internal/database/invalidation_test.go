@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"server/internal/clock"
+	"server/internal/events"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingTransport struct {
+	published  []events.CacheInvalidatedEvent
+	handler    func(ctx context.Context, payload events.CacheInvalidatedEvent) error
+	publishErr error
+}
+
+func (r *recordingTransport) Publish(ctx context.Context, payload events.CacheInvalidatedEvent) error {
+	r.published = append(r.published, payload)
+	return r.publishErr
+}
+
+func (r *recordingTransport) Subscribe(handler func(ctx context.Context, payload events.CacheInvalidatedEvent) error) error {
+	r.handler = handler
+	return nil
+}
+
+func TestInvalidator_WithoutTransport_EvictsLocally(t *testing.T) {
+	invalidator, err := NewInvalidator(nil)
+	require.NoError(t, err)
+
+	var evicted []string
+	invalidator.Register("user", func(id string) { evicted = append(evicted, id) })
+	invalidator.Register("session", func(id string) { t.Fatal("other entities must not be evicted") })
+
+	require.NoError(t, invalidator.Invalidate(context.Background(), "user", "42"))
+	assert.Equal(t, []string{"42"}, evicted)
+}
+
+func TestInvalidator_PublishesAndIgnoresOwnEcho(t *testing.T) {
+	transport := &recordingTransport{}
+	invalidator, err := NewInvalidator(transport)
+	require.NoError(t, err)
+	require.NotNil(t, transport.handler)
+
+	evictions := 0
+	invalidator.Register("user", func(id string) { evictions++ })
+
+	require.NoError(t, invalidator.Invalidate(context.Background(), "user", "42"))
+	require.Len(t, transport.published, 1)
+	assert.Equal(t, "user", transport.published[0].Entity)
+	assert.Equal(t, "42", transport.published[0].ID)
+	assert.Equal(t, 1, evictions)
+
+	// The echo of our own message is skipped, another instance's isn't.
+	require.NoError(t, transport.handler(context.Background(), transport.published[0]))
+	assert.Equal(t, 1, evictions)
+
+	remote := events.CacheInvalidatedEvent{Entity: "user", ID: "42", Origin: "other-instance"}
+	require.NoError(t, transport.handler(context.Background(), remote))
+	assert.Equal(t, 2, evictions)
+}
+
+func TestInvalidator_PublishFailureStillEvictsLocally(t *testing.T) {
+	transport := &recordingTransport{publishErr: errors.New("valkey down")}
+	invalidator, err := NewInvalidator(transport)
+	require.NoError(t, err)
+
+	evicted := false
+	invalidator.Register("user", func(id string) { evicted = true })
+
+	assert.Error(t, invalidator.Invalidate(context.Background(), "user", "42"))
+	assert.True(t, evicted)
+}
+
+func TestLocalCache_ExpiresAndDeletes(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	cache := NewLocalCache[string](time.Minute, fake)
+
+	cache.Set("a", "alpha")
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "alpha", value)
+
+	cache.Delete("a")
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+
+	cache.Set("b", "beta")
+	fake.Advance(time.Minute)
+	_, ok = cache.Get("b")
+	assert.False(t, ok, "entries expire after the ttl")
+
+	// The next write sweeps the expired entry out.
+	cache.Set("c", "gamma")
+	assert.Len(t, cache.entries, 1)
+}
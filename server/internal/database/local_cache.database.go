@@ -0,0 +1,82 @@
+package database
+
+import (
+	"server/internal/clock"
+	"sync"
+	"time"
+)
+
+type localEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+// LocalCache is a small in-process cache in front of valkey. Entries expire
+// after ttl; an Invalidator evicts them sooner when any instance changes the
+// underlying row.
+type LocalCache[T any] struct {
+	ttl       time.Duration
+	clock     clock.Clock
+	mutex     sync.RWMutex
+	entries   map[string]localEntry[T]
+	lastSweep time.Time
+}
+
+// NewLocalCache builds a LocalCache. A nil clock uses the wall clock.
+func NewLocalCache[T any](ttl time.Duration, clk clock.Clock) *LocalCache[T] {
+	return &LocalCache[T]{
+		ttl:     ttl,
+		clock:   clock.OrDefault(clk),
+		entries: make(map[string]localEntry[T]),
+	}
+}
+
+func (c *LocalCache[T]) Get(key string) (T, bool) {
+	c.mutex.RLock()
+	entry, ok := c.entries[key]
+	c.mutex.RUnlock()
+
+	if !ok || !c.clock.Now().Before(entry.expiresAt) {
+		var zero T
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (c *LocalCache[T]) Set(key string, value T) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.clock.Now()
+	c.entries[key] = localEntry[T]{value: value, expiresAt: now.Add(c.ttl)}
+
+	// Sweep expired entries at most once per ttl so entries that are never
+	// read again don't pile up.
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *LocalCache[T]) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, key)
+}
+
+// DeleteFunc deletes every entry whose value matches, for evicting by
+// something other than the key.
+func (c *LocalCache[T]) DeleteFunc(match func(value T) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, entry := range c.entries {
+		if match(entry.value) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"fmt"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+const MIGRATION_DIALECT = "sqlite3"
+
+// PendingMigrations lists the IDs of the SQL migrations in dir that haven't
+// been applied to the database. A dir that can't be read is an error, since
+// the schema can't be checked against it.
+func (s *DB) PendingMigrations(dir string) ([]string, error) {
+	sqlDB, err := s.SQL.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database from GORM: %w", err)
+	}
+
+	planned, _, err := migrate.PlanMigration(sqlDB, MIGRATION_DIALECT, &migrate.FileMigrationSource{Dir: dir}, migrate.Up, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check migrations in %q: %w", dir, err)
+	}
+
+	ids := make([]string, len(planned))
+	for i, migration := range planned {
+		ids[i] = migration.Id
+	}
+	return ids, nil
+}
+
+// ExistingTables returns those of tables the database has, in the same
+// order.
+func (s *DB) ExistingTables(tables []string) []string {
+	existing := []string{}
+	for _, table := range tables {
+		if s.SQL.Migrator().HasTable(table) {
+			existing = append(existing, table)
+		}
+	}
+	return existing
+}
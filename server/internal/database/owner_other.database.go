@@ -0,0 +1,10 @@
+//go:build !unix
+
+package database
+
+import "os"
+
+// fileOwner is unknown where files aren't owned by a uid.
+func fileOwner(info os.FileInfo) string {
+	return "unknown"
+}
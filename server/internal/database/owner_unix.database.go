@@ -0,0 +1,18 @@
+//go:build unix
+
+package database
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner is the uid:gid owning info's file.
+func fileOwner(info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "unknown"
+	}
+	return strconv.FormatUint(uint64(stat.Uid), 10) + ":" + strconv.FormatUint(uint64(stat.Gid), 10)
+}
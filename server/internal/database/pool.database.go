@@ -0,0 +1,73 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"server/config"
+	"server/internal/metrics"
+	"sync/atomic"
+)
+
+// PoolStats writes the connection pool's sql.DBStats to the metrics
+// endpoint, once New has opened the database.
+var PoolStats = &poolCollector{}
+
+func init() {
+	metrics.Register(PoolStats)
+}
+
+// applyPool sets the limits of the pool on db.
+func applyPool(db *sql.DB, pool config.DatabasePool) {
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+}
+
+type poolCollector struct {
+	db atomic.Pointer[sql.DB]
+}
+
+// Watch makes db the pool the collector reports on.
+func (p *poolCollector) Watch(db *sql.DB) {
+	p.db.Store(db)
+}
+
+func (p *poolCollector) Name() string {
+	return "db_connections"
+}
+
+// WriteText reads the stats as it is scraped, and writes nothing before a
+// pool is watched.
+func (p *poolCollector) WriteText(w io.Writer) error {
+	db := p.db.Load()
+	if db == nil {
+		return nil
+	}
+	stats := db.Stats()
+
+	families := []struct {
+		name  string
+		kind  string
+		help  string
+		value any
+	}{
+		{"db_connections_max_open", "gauge", "Connections the pool may open.", stats.MaxOpenConnections},
+		{"db_connections_open", "gauge", "Connections open, in use or idle.", stats.OpenConnections},
+		{"db_connections_in_use", "gauge", "Connections running a query or transaction.", stats.InUse},
+		{"db_connections_idle", "gauge", "Connections open and waiting for a query.", stats.Idle},
+		{"db_connections_wait_total", "counter", "Queries that waited for a free connection.", stats.WaitCount},
+		{
+			"db_connections_wait_seconds_total", "counter", "Time spent waiting for a free connection.",
+			fmt.Sprintf("%g", stats.WaitDuration.Seconds()),
+		},
+	}
+	for _, family := range families {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n",
+			family.name, family.help, family.name, family.kind, family.name, family.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"server/config"
+	"server/internal/logger"
+	"server/internal/metrics"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// appliedPool reads back the limits set on db, which sql.DB keeps unexported
+// apart from the open connection limit in Stats.
+func appliedPool(db *sql.DB) config.DatabasePool {
+	fields := reflect.ValueOf(db).Elem()
+	return config.DatabasePool{
+		MaxOpenConns:    db.Stats().MaxOpenConnections,
+		MaxIdleConns:    int(fields.FieldByName("maxIdleCount").Int()),
+		ConnMaxLifetime: time.Duration(fields.FieldByName("maxLifetime").Int()),
+		ConnMaxIdleTime: time.Duration(fields.FieldByName("maxIdleTime").Int()),
+	}
+}
+
+func openTestSQL(t *testing.T, testConfig config.Config) *sql.DB {
+	testConfig.Database.Path = filepath.Join(t.TempDir(), "test.db")
+	db := &DB{log: logger.New("test")}
+	require.NoError(t, db.initializeDB(testConfig))
+
+	sqlDB, err := db.SQL.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	return sqlDB
+}
+
+func TestInitializeDB_AppliesSQLitePool(t *testing.T) {
+	sqlDB := openTestSQL(t, config.Config{})
+
+	assert.Equal(t, config.DefaultDatabasePools[config.DATABASE_DRIVER_SQLITE], appliedPool(sqlDB))
+	assert.Equal(t, 1, appliedPool(sqlDB).MaxOpenConns)
+}
+
+func TestInitializeDB_PoolOverrides(t *testing.T) {
+	sqlDB := openTestSQL(t, config.Config{Database: config.DatabaseConfig{
+		MaxOpenConns:    4,
+		ConnMaxIdleTime: time.Minute,
+	}})
+
+	assert.Equal(t, config.DatabasePool{MaxOpenConns: 4, MaxIdleConns: 1, ConnMaxIdleTime: time.Minute}, appliedPool(sqlDB))
+}
+
+func TestApplyPool_Postgres(t *testing.T) {
+	// No postgres to connect to, but the settings land on any sql.DB
+	sqlDB := openTestSQL(t, config.Config{})
+	pool := config.Config{Database: config.DatabaseConfig{Driver: config.DATABASE_DRIVER_POSTGRES}}.DatabasePool()
+
+	applyPool(sqlDB, pool)
+
+	assert.Equal(t, config.DefaultDatabasePools[config.DATABASE_DRIVER_POSTGRES], appliedPool(sqlDB))
+}
+
+func TestInitializeDB_UnsupportedDriver(t *testing.T) {
+	db := &DB{log: logger.New("test")}
+
+	err := db.initializeDB(config.Config{Database: config.DatabaseConfig{
+		Driver: config.DATABASE_DRIVER_POSTGRES,
+		Path:   filepath.Join(t.TempDir(), "test.db"),
+	}})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not supported")
+	assert.Nil(t, db.SQL)
+}
+
+func TestPoolStats_WriteText(t *testing.T) {
+	collector := &poolCollector{}
+	var empty bytes.Buffer
+	require.NoError(t, collector.WriteText(&empty))
+	assert.Empty(t, empty.String())
+
+	sqlDB := openTestSQL(t, config.Config{})
+	collector.Watch(sqlDB)
+	for range 3 {
+		_, err := sqlDB.Exec("SELECT 1")
+		require.NoError(t, err)
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, collector.WriteText(&out))
+	text := out.String()
+	assert.Contains(t, text, "# TYPE db_connections_max_open gauge\ndb_connections_max_open 1\n")
+	assert.Contains(t, text, "db_connections_open 1\n")
+	assert.Contains(t, text, "db_connections_in_use 0\n")
+	assert.Contains(t, text, "db_connections_idle 1\n")
+	assert.Contains(t, text, "# TYPE db_connections_wait_total counter\ndb_connections_wait_total 0\n")
+	assert.Contains(t, text, "db_connections_wait_seconds_total 0\n")
+}
+
+func TestPoolStats_ServedByMetricsEndpoint(t *testing.T) {
+	sqlDB := openTestSQL(t, config.Config{})
+	_, err := sqlDB.Exec("SELECT 1")
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, metrics.Default.WriteText(&out))
+	assert.Contains(t, out.String(), "db_connections_max_open 1\n")
+	assert.Contains(t, out.String(), "db_connections_idle 1\n")
+}
+
+func TestSQLHealth_ReportsPoolStats(t *testing.T) {
+	db := &DB{log: logger.New("test")}
+	require.NoError(t, db.initializeDB(config.Config{Database: config.DatabaseConfig{
+		Path: filepath.Join(t.TempDir(), "test.db"),
+	}}))
+	t.Cleanup(func() { _ = db.Close() })
+
+	details := db.SQLHealth().Check(t.Context()).Details
+	assert.Equal(t, 1, details["maxOpenConnections"])
+	for _, key := range []string{"openConnections", "inUse", "idle", "waitCount", "waitDuration"} {
+		assert.Contains(t, details, key)
+	}
+}
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime"
+	"server/config"
+	"server/internal/clock"
+	logg "server/internal/logger"
+	"server/internal/metrics"
+	"server/internal/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	// A slow query taking this many times the threshold is logged with its
+	// query plan
+	SLOW_QUERY_EXPLAIN_FACTOR = 5
+
+	queryStartKey = "querylog:start"
+)
+
+// SlowQueries counts the queries over Database.SlowQueryMs, by table.
+var SlowQueries = metrics.NewLabeledCounter(
+	"db_slow_queries_total", "Queries slower than DATABASE_SLOW_QUERY_MS, by table.", "table",
+)
+
+func init() {
+	metrics.Register(SlowQueries)
+}
+
+// queryLogFile is this file, skipped when looking for a query's call site.
+var queryLogFile = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return file
+}()
+
+// gormLogger passes gorm's own messages to the database logger. Statements
+// are logged by QueryLogPlugin, which sees their table and connection, so
+// Trace does nothing.
+type gormLogger struct {
+	log logg.Logger
+}
+
+func (l gormLogger) LogMode(logger.LogLevel) logger.Interface { return l }
+
+func (l gormLogger) Info(ctx context.Context, msg string, data ...any) {
+	l.log.Info(fmt.Sprintf(msg, data...))
+}
+
+func (l gormLogger) Warn(ctx context.Context, msg string, data ...any) {
+	l.log.Warn(fmt.Sprintf(msg, data...))
+}
+
+func (l gormLogger) Error(ctx context.Context, msg string, data ...any) {
+	_ = l.log.Error(fmt.Sprintf(msg, data...))
+}
+
+func (l gormLogger) Trace(context.Context, time.Time, func() (string, int64), error) {}
+
+// QueryLogPlugin logs gorm statements. Outside production every statement
+// is logged at Debug, which the database component level usually filters
+// out. Statements slower than the threshold are logged at Warn with their
+// rows affected and call site, and counted in SlowQueries; those taking
+// SLOW_QUERY_EXPLAIN_FACTOR times as long also get EXPLAIN QUERY PLAN. Values
+// bound to columns named like Server.RedactFields are never written out.
+type QueryLogPlugin struct {
+	log          logg.Logger
+	clock        clock.Clock
+	threshold    time.Duration
+	debug        bool
+	redactFields []string
+}
+
+func NewQueryLogPlugin(config config.Config) QueryLogPlugin {
+	return QueryLogPlugin{
+		log:          logg.New("database").File("querylog"),
+		clock:        clock.New(),
+		threshold:    config.SlowQueryThreshold(),
+		debug:        config.Environment != "production",
+		redactFields: utils.ParseRedactedFields(config.Server.RedactFields),
+	}
+}
+
+func (QueryLogPlugin) Name() string { return "querylog" }
+
+func (p QueryLogPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("querylog:before_create", p.start),
+		callbacks.Create().After("gorm:create").Register("querylog:after_create", p.end(true)),
+		callbacks.Query().Before("gorm:query").Register("querylog:before_query", p.start),
+		callbacks.Query().After("gorm:query").Register("querylog:after_query", p.end(true)),
+		callbacks.Update().Before("gorm:update").Register("querylog:before_update", p.start),
+		callbacks.Update().After("gorm:update").Register("querylog:after_update", p.end(true)),
+		callbacks.Delete().Before("gorm:delete").Register("querylog:before_delete", p.start),
+		callbacks.Delete().After("gorm:delete").Register("querylog:after_delete", p.end(true)),
+		// Row leaves its rows open for the caller, holding the connection
+		// EXPLAIN would need, so its plan isn't captured
+		callbacks.Row().Before("gorm:row").Register("querylog:before_row", p.start),
+		callbacks.Row().After("gorm:row").Register("querylog:after_row", p.end(false)),
+		callbacks.Raw().Before("gorm:raw").Register("querylog:before_raw", p.start),
+		callbacks.Raw().After("gorm:raw").Register("querylog:after_raw", p.end(true)),
+	)
+}
+
+func (p QueryLogPlugin) start(tx *gorm.DB) {
+	tx.InstanceSet(queryStartKey, p.clock.Now())
+}
+
+func (p QueryLogPlugin) end(explainable bool) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet(queryStartKey)
+		if !ok || tx.Statement.SQL.Len() == 0 {
+			return
+		}
+		elapsed := p.clock.Now().Sub(value.(time.Time))
+		slow := p.threshold > 0 && elapsed >= p.threshold
+		if !slow && !p.debug {
+			return
+		}
+
+		table := tx.Statement.Table
+		args := []any{
+			"sql", p.statement(tx),
+			"rows", tx.RowsAffected,
+			"duration_ms", elapsed.Milliseconds(),
+			"table", table,
+			"caller", callSite(),
+		}
+		if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			args = append(args, "error", tx.Error)
+		}
+		log := p.log.Traced(tx.Statement.Context)
+		if !slow {
+			log.Debug("Query", args...)
+			return
+		}
+
+		SlowQueries.Add(table, 1)
+		if explainable && elapsed >= p.threshold*SLOW_QUERY_EXPLAIN_FACTOR {
+			plan, err := explainQueryPlan(tx)
+			if err != nil {
+				args = append(args, "plan_error", err.Error())
+			} else {
+				args = append(args, "plan", plan)
+			}
+		}
+		log.Warn("Slow query", args...)
+	}
+}
+
+// statement is the statement with its values filled in, those bound to
+// sensitive columns redacted.
+func (p QueryLogPlugin) statement(tx *gorm.DB) string {
+	sql := tx.Statement.SQL.String()
+	return tx.Dialector.Explain(sql, redactQueryVars(sql, tx.Statement.Vars, p.redactFields)...)
+}
+
+// explainQueryPlan runs EXPLAIN QUERY PLAN on the statement, over its own
+// connection so it works inside a transaction on a single connection pool.
+func explainQueryPlan(tx *gorm.DB) ([]string, error) {
+	if tx.Dialector.Name() != config.DATABASE_DRIVER_SQLITE {
+		return nil, fmt.Errorf("no query plan for %s", tx.Dialector.Name())
+	}
+
+	rows, err := tx.Statement.ConnPool.QueryContext(
+		tx.Statement.Context,
+		"EXPLAIN QUERY PLAN "+tx.Statement.SQL.String(),
+		tx.Statement.Vars...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return nil, err
+		}
+		plan = append(plan, detail)
+	}
+	return plan, rows.Err()
+}
+
+// callSite is the file and line outside gorm and this file that ran the
+// statement, usually a repository method.
+func callSite() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if frame.File != queryLogFile && !strings.Contains(frame.File, "gorm.io/") {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+var (
+	// A column compared with a placeholder, e.g. `users`.`password` = ?, or
+	// the first of an IN list
+	comparedColumn = regexp.MustCompile("(?i)([\\w`\"]+)\\s*(?:=|<>|!=|<=|>=|<|>|\\bLIKE|\\bIN\\s*\\((?:\\s*\\?\\s*,)*)\\s*$")
+	insertColumns  = regexp.MustCompile("(?is)^\\s*INSERT\\s+INTO\\s+\\S+\\s*\\(([^)]*)\\)\\s*VALUES")
+)
+
+// redactQueryVars replaces the values bound to columns named like one of
+// fields. A statement that mentions such a column but binds a value in a
+// way that can't be traced to its column has every string value replaced,
+// as it might be the sensitive one.
+func redactQueryVars(sql string, vars []any, fields []string) []any {
+	if !mentionsField(sql, fields) {
+		return vars
+	}
+
+	var inserted []string
+	valuesAt := -1
+	if match := insertColumns.FindStringSubmatchIndex(sql); match != nil {
+		for column := range strings.SplitSeq(sql[match[2]:match[3]], ",") {
+			inserted = append(inserted, unquoteColumn(column))
+		}
+		valuesAt = match[1]
+	}
+
+	redacted := make([]any, len(vars))
+	copy(redacted, vars)
+	placeholder, inValues := 0, 0
+	for i := 0; i < len(sql) && placeholder < len(vars); i++ {
+		if sql[i] != '?' {
+			continue
+		}
+
+		var column string
+		if valuesAt >= 0 && i > valuesAt && len(inserted) > 0 {
+			column = inserted[inValues%len(inserted)]
+			inValues++
+		} else if match := comparedColumn.FindStringSubmatch(sql[:i]); match != nil {
+			column = unquoteColumn(match[1])
+		}
+
+		if column == "" {
+			if isText(redacted[placeholder]) {
+				redacted[placeholder] = utils.REDACTED_VALUE
+			}
+		} else if mentionsField(column, fields) {
+			redacted[placeholder] = utils.REDACTED_VALUE
+		}
+		placeholder++
+	}
+	return redacted
+}
+
+func mentionsField(text string, fields []string) bool {
+	text = strings.ToLower(text)
+	for _, field := range fields {
+		if strings.Contains(text, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// unquoteColumn strips the quotes and table of `users`.`password`.
+func unquoteColumn(column string) string {
+	column = strings.TrimSpace(column)
+	if dot := strings.LastIndex(column, "."); dot >= 0 {
+		column = column[dot+1:]
+	}
+	return strings.Trim(column, "`\"")
+}
+
+func isText(value any) bool {
+	switch value.(type) {
+	case string, []byte, *string:
+		return true
+	}
+	return false
+}
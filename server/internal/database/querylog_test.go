@@ -0,0 +1,213 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"server/internal/clock"
+	"server/internal/logger"
+	"server/internal/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// queryLogTest runs statements through a QueryLogPlugin whose clock moves on
+// by delay while each statement runs, so any of them can be made slow.
+type queryLogTest struct {
+	db    *gorm.DB
+	out   *bytes.Buffer
+	delay time.Duration
+}
+
+func setupQueryLogTest(t *testing.T, threshold time.Duration, debug bool) *queryLogTest {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "querylog.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`CREATE TABLE accounts (
+		id INTEGER PRIMARY KEY, login TEXT, password TEXT, session_token TEXT)`).Error)
+
+	test := &queryLogTest{db: db, out: &bytes.Buffer{}}
+	frozen := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	plugin := QueryLogPlugin{
+		log:          logger.NewWithHandler("querylog", slog.NewJSONHandler(test.out, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		clock:        frozen,
+		threshold:    threshold,
+		debug:        debug,
+		redactFields: utils.ParseRedactedFields(utils.DEFAULT_REDACTED_FIELDS),
+	}
+	require.NoError(t, db.Use(plugin))
+
+	slowDown := func(tx *gorm.DB) { frozen.Advance(test.delay) }
+	callbacks := db.Callback()
+	require.NoError(t, callbacks.Query().After("gorm:query").Before("querylog:after_query").Register("test:slow_query", slowDown))
+	require.NoError(t, callbacks.Create().After("gorm:create").Before("querylog:after_create").Register("test:slow_create", slowDown))
+	return test
+}
+
+func (q *queryLogTest) entries(t *testing.T) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	for line := range strings.SplitSeq(strings.TrimSpace(q.out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	q.out.Reset()
+	return entries
+}
+
+func TestQueryLogPlugin_SlowQuery(t *testing.T) {
+	test := setupQueryLogTest(t, 200*time.Millisecond, false)
+	before := SlowQueries.Value("accounts")
+
+	// Under the threshold nothing is logged in production
+	test.delay = 100 * time.Millisecond
+	var logins []string
+	require.NoError(t, test.db.Table("accounts").Where("login = ?", "jdoe").Pluck("login", &logins).Error)
+	assert.Empty(t, test.entries(t))
+
+	test.delay = 300 * time.Millisecond
+	require.NoError(t, test.db.Table("accounts").Where("login = ?", "jdoe").Pluck("login", &logins).Error)
+
+	entries := test.entries(t)
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, "WARN", entry["level"])
+	assert.Equal(t, "Slow query", entry["msg"])
+	assert.Equal(t, "SELECT `login` FROM `accounts` WHERE login = \"jdoe\"", entry["sql"])
+	assert.Equal(t, float64(300), entry["duration_ms"])
+	assert.Equal(t, float64(0), entry["rows"])
+	assert.Equal(t, "accounts", entry["table"])
+	assert.Contains(t, entry["caller"], "querylog_test.go:")
+	assert.NotContains(t, entry, "plan", "the plan is only captured past five times the threshold")
+	assert.Equal(t, before+1, SlowQueries.Value("accounts"))
+}
+
+func TestQueryLogPlugin_ExplainsVerySlowQueries(t *testing.T) {
+	test := setupQueryLogTest(t, 200*time.Millisecond, false)
+	require.NoError(t, test.db.Exec("CREATE INDEX accounts_login ON accounts (login)").Error)
+
+	test.delay = time.Second
+	var logins []string
+	require.NoError(t, test.db.Table("accounts").Where("login = ?", "jdoe").Pluck("login", &logins).Error)
+
+	entries := test.entries(t)
+	require.Len(t, entries, 1)
+	plan, ok := entries[0]["plan"].([]any)
+	require.True(t, ok, "expected a plan, got %v", entries[0])
+	require.NotEmpty(t, plan)
+	assert.Contains(t, plan[0], "accounts_login")
+
+	// Captured inside a transaction on the one connection as well
+	sqlDB, err := test.db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, test.db.Transaction(func(tx *gorm.DB) error {
+		return tx.Table("accounts").Where("login = ?", "jdoe").Pluck("login", &logins).Error
+	}))
+	entries = test.entries(t)
+	require.Len(t, entries, 1)
+	assert.NotEmpty(t, entries[0]["plan"])
+}
+
+func TestQueryLogPlugin_RedactsSensitiveValues(t *testing.T) {
+	test := setupQueryLogTest(t, 200*time.Millisecond, false)
+	test.delay = time.Second
+
+	require.NoError(t, test.db.Table("accounts").Create(map[string]any{
+		"login":         "jdoe",
+		"password":      "$2a$12$hashhashhash",
+		"session_token": "token-value",
+	}).Error)
+	var logins []string
+	require.NoError(t, test.db.Table("accounts").
+		Where("login = ? AND `accounts`.`password` = ?", "jdoe", "$2a$12$hashhashhash").
+		Pluck("login", &logins).Error)
+
+	entries := test.entries(t)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		logged, err := json.Marshal(entry)
+		require.NoError(t, err)
+		assert.NotContains(t, string(logged), "hashhashhash")
+		assert.NotContains(t, string(logged), "token-value")
+		assert.Contains(t, entry["sql"], `"jdoe"`, "values of other columns are kept")
+		assert.Contains(t, entry["sql"], utils.REDACTED_VALUE)
+	}
+}
+
+func TestQueryLogPlugin_DebugLogsEveryQuery(t *testing.T) {
+	test := setupQueryLogTest(t, 200*time.Millisecond, true)
+
+	var logins []string
+	require.NoError(t, test.db.Table("accounts").Pluck("login", &logins).Error)
+
+	entries := test.entries(t)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "DEBUG", entries[0]["level"])
+	assert.Equal(t, "Query", entries[0]["msg"])
+}
+
+func TestRedactQueryVars(t *testing.T) {
+	fields := utils.ParseRedactedFields(utils.DEFAULT_REDACTED_FIELDS)
+
+	testCases := []struct {
+		name string
+		sql  string
+		vars []any
+		want []any
+	}{
+		{
+			name: "nothing sensitive",
+			sql:  "SELECT * FROM `users` WHERE login = ?",
+			vars: []any{"jdoe"},
+			want: []any{"jdoe"},
+		},
+		{
+			name: "compared column",
+			sql:  "UPDATE `users` SET `password`=?,`updated_at`=? WHERE `id` = ?",
+			vars: []any{"hash", 7, "user-1"},
+			want: []any{utils.REDACTED_VALUE, 7, "user-1"},
+		},
+		{
+			name: "in list",
+			sql:  "DELETE FROM `sessions` WHERE `token` IN (?,?) AND user_id = ?",
+			vars: []any{"a", "b", "user-1"},
+			want: []any{utils.REDACTED_VALUE, utils.REDACTED_VALUE, "user-1"},
+		},
+		{
+			name: "insert rows",
+			sql:  "INSERT INTO `users` (`login`,`password`) VALUES (?,?),(?,?)",
+			vars: []any{"jdoe", "hash-1", "asmith", "hash-2"},
+			want: []any{"jdoe", utils.REDACTED_VALUE, "asmith", utils.REDACTED_VALUE},
+		},
+		{
+			name: "untraceable values of a sensitive statement",
+			sql:  "SELECT password FROM `users` WHERE login LIKE ? || ?",
+			vars: []any{"jd", 3},
+			want: []any{"jd", 3},
+		},
+		{
+			name: "untraceable strings are redacted",
+			sql:  "SELECT coalesce(?, password) FROM `users`",
+			vars: []any{"fallback", 3},
+			want: []any{utils.REDACTED_VALUE, 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, redactQueryVars(tc.sql, tc.vars, fields))
+		})
+	}
+}
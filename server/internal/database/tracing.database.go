@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"server/internal/tracing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const tracingSpanKey = "tracing:span"
+
+// TracingPlugin gives every gorm statement a client span, a child of the span
+// in the statement's context.
+type TracingPlugin struct{}
+
+func (TracingPlugin) Name() string { return "tracing" }
+
+func (TracingPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("tracing:before_create", startStatementSpan("db.create")),
+		callbacks.Create().After("gorm:create").Register("tracing:after_create", endStatementSpan),
+		callbacks.Query().Before("gorm:query").Register("tracing:before_query", startStatementSpan("db.query")),
+		callbacks.Query().After("gorm:query").Register("tracing:after_query", endStatementSpan),
+		callbacks.Update().Before("gorm:update").Register("tracing:before_update", startStatementSpan("db.update")),
+		callbacks.Update().After("gorm:update").Register("tracing:after_update", endStatementSpan),
+		callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", startStatementSpan("db.delete")),
+		callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", endStatementSpan),
+		callbacks.Row().Before("gorm:row").Register("tracing:before_row", startStatementSpan("db.row")),
+		callbacks.Row().After("gorm:row").Register("tracing:after_row", endStatementSpan),
+		callbacks.Raw().Before("gorm:raw").Register("tracing:before_raw", startStatementSpan("db.raw")),
+		callbacks.Raw().After("gorm:raw").Register("tracing:after_raw", endStatementSpan),
+	)
+}
+
+func startStatementSpan(name string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		if !tracing.Enabled() {
+			return
+		}
+		_, span := tracing.StartKind(tx.Statement.Context, tracing.SPAN_KIND_CLIENT, name)
+		tx.InstanceSet(tracingSpanKey, span)
+	}
+}
+
+// endStatementSpan records the statement with its placeholders, never its
+// values.
+func endStatementSpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	span, _ := value.(*tracing.Span)
+	span.SetString("db.system", "sqlite")
+	span.SetString("db.sql.table", tx.Statement.Table)
+	span.SetString("db.statement", tx.Statement.SQL.String())
+	span.SetInt("db.rows_affected", tx.RowsAffected)
+	if !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		span.SetError(tx.Error)
+	}
+	span.End()
+}
+
+// tracedCacheStore gives every cache operation a client span.
+type tracedCacheStore struct {
+	CacheStore
+}
+
+func startCacheSpan(ctx context.Context, name string, key string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.StartKind(ctx, tracing.SPAN_KIND_CLIENT, name)
+	span.SetString("cache.namespace", CacheNamespace(key))
+	return ctx, span
+}
+
+// endCacheSpan counts a miss as an answer rather than a failure.
+func endCacheSpan(span *tracing.Span, err error) {
+	if errors.Is(err, ErrCacheMiss) {
+		span.SetBool("cache.hit", false)
+		err = nil
+	}
+	span.EndError(err)
+}
+
+func (s tracedCacheStore) Get(ctx context.Context, key string, result any) error {
+	ctx, span := startCacheSpan(ctx, "cache.get", key)
+	err := s.CacheStore.Get(ctx, key, result)
+	endCacheSpan(span, err)
+	return err
+}
+
+func (s tracedCacheStore) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	ctx, span := startCacheSpan(ctx, "cache.set", key)
+	err := s.CacheStore.Set(ctx, key, value, ttl)
+	endCacheSpan(span, err)
+	return err
+}
+
+func (s tracedCacheStore) Take(ctx context.Context, key string, result any) error {
+	ctx, span := startCacheSpan(ctx, "cache.take", key)
+	err := s.CacheStore.Take(ctx, key, result)
+	endCacheSpan(span, err)
+	return err
+}
+
+func (s tracedCacheStore) Delete(ctx context.Context, key string) error {
+	ctx, span := startCacheSpan(ctx, "cache.delete", key)
+	err := s.CacheStore.Delete(ctx, key)
+	endCacheSpan(span, err)
+	return err
+}
+
+func (s tracedCacheStore) DeleteMany(ctx context.Context, keys []string) error {
+	ctx, span := tracing.StartKind(ctx, tracing.SPAN_KIND_CLIENT, "cache.delete_many")
+	span.SetInt("cache.keys", int64(len(keys)))
+	err := s.CacheStore.DeleteMany(ctx, keys)
+	span.EndError(err)
+	return err
+}
+
+func (s tracedCacheStore) Scan(ctx context.Context, pattern string, cursor uint64) ([]string, uint64, error) {
+	ctx, span := startCacheSpan(ctx, "cache.scan", pattern)
+	keys, next, err := s.CacheStore.Scan(ctx, pattern, cursor)
+	endCacheSpan(span, err)
+	return keys, next, err
+}
+
+func (s tracedCacheStore) Flush(ctx context.Context, namespace string) (int, error) {
+	ctx, span := tracing.StartKind(ctx, tracing.SPAN_KIND_CLIENT, "cache.flush")
+	span.SetString("cache.namespace", namespace)
+	count, err := s.CacheStore.Flush(ctx, namespace)
+	span.SetInt("cache.deleted", int64(count))
+	span.EndError(err)
+	return count, err
+}
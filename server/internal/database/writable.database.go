@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrDatabaseNotWritable is a sqlite file, or its directory, the server can't
+// write to, most often a data directory mounted read-only or owned by another
+// user. New fails with a *NotWritableError matching it.
+var ErrDatabaseNotWritable = errors.New("database is not writable")
+
+// ErrReadOnly is a write refused because the database is a read-only
+// replica.
+var ErrReadOnly = errors.New("database is read-only")
+
+// NotWritableError says what an operator needs to fix the permissions of the
+// database without guesswork.
+type NotWritableError struct {
+	// Absolute path of the file, or of the directory when writing there
+	// failed
+	Path  string
+	Owner string
+	Mode  os.FileMode
+	// The user the server runs as
+	UID int
+	Err error
+}
+
+func (e *NotWritableError) Error() string {
+	return fmt.Sprintf("%s: %s (owner %s, mode %s, process uid %d): %v",
+		ErrDatabaseNotWritable, e.Path, e.Owner, e.Mode, e.UID, e.Err)
+}
+
+func (e *NotWritableError) Is(target error) bool { return target == ErrDatabaseNotWritable }
+
+func (e *NotWritableError) Unwrap() error { return e.Err }
+
+// notWritable describes path failing with err.
+func notWritable(path string, err error) error {
+	notWritableErr := &NotWritableError{Path: path, Owner: "unknown", UID: os.Getuid(), Err: err}
+	if info, statErr := os.Stat(path); statErr == nil {
+		notWritableErr.Owner = fileOwner(info)
+		notWritableErr.Mode = info.Mode()
+	}
+	return notWritableErr
+}
+
+// checkWritable fails with a *NotWritableError unless the directory of the
+// sqlite file at path takes a new file, which the journal needs, and the
+// database takes a write, rolled back straight away. Otherwise the server would start and fail every
+// write at request time.
+func checkWritable(ctx context.Context, sqlDB *sql.DB, path string) error {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(absolute)
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return notWritable(dir, err)
+	}
+	_ = probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return notWritable(dir, err)
+	}
+
+	// database/sql would run each statement on whichever connection is free
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return notWritable(absolute, err)
+	}
+	// sqlite opens a file it can't write read-only without saying so, and
+	// takes the write lock all the same, so only a write tells
+	_, writeErr := conn.ExecContext(ctx, "CREATE TABLE write_check (id INTEGER)")
+	if _, err := conn.ExecContext(ctx, "ROLLBACK"); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		return notWritable(absolute, writeErr)
+	}
+	return nil
+}
+
+// ReadOnlyPlugin fails every gorm write with ErrReadOnly before it reaches
+// the database, for read-only replicas. Raw statements that only read, like
+// the integrity checks' PRAGMAs, still run.
+type ReadOnlyPlugin struct{}
+
+func (ReadOnlyPlugin) Name() string { return "read_only" }
+
+func (ReadOnlyPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:begin_transaction").Register("read_only:create", refuseWrite),
+		callbacks.Update().Before("gorm:begin_transaction").Register("read_only:update", refuseWrite),
+		callbacks.Delete().Before("gorm:begin_transaction").Register("read_only:delete", refuseWrite),
+		callbacks.Raw().Before("gorm:raw").Register("read_only:raw", refuseRawWrite),
+	)
+}
+
+func refuseWrite(tx *gorm.DB) {
+	_ = tx.AddError(ErrReadOnly)
+}
+
+// readStatements start the raw statements a read-only database still runs
+var readStatements = []string{"SELECT", "WITH", "PRAGMA", "EXPLAIN", "VACUUM INTO"}
+
+func refuseRawWrite(tx *gorm.DB) {
+	statement := strings.ToUpper(strings.TrimSpace(tx.Statement.SQL.String()))
+	for _, prefix := range readStatements {
+		if strings.HasPrefix(statement, prefix) {
+			return
+		}
+	}
+	refuseWrite(tx)
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"server/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type replicaRow struct {
+	ID   uint
+	Name string
+}
+
+// createReplica writes a database with one row at path, as the primary a
+// replica copies would.
+func createReplica(t *testing.T, path string) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&replicaRow{}))
+	require.NoError(t, db.Create(&replicaRow{Name: "alice"}).Error)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+}
+
+func closeSQL(t *testing.T, db DB) {
+	t.Cleanup(func() {
+		if sqlDB, err := db.SQL.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+}
+
+func TestNewSQL_DirectoryNotWritable(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("root writes through directory permissions")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.db")
+	createReplica(t, path)
+	require.NoError(t, os.Chmod(dir, 0o555))
+	t.Cleanup(func() { _ = os.Chmod(dir, 0o755) })
+
+	_, err := NewSQL(config.Config{Database: config.DatabaseConfig{Path: path}})
+
+	require.ErrorIs(t, err, ErrDatabaseNotWritable)
+	var notWritableErr *NotWritableError
+	require.True(t, errors.As(err, &notWritableErr))
+	assert.Equal(t, dir, notWritableErr.Path)
+	assert.Equal(t, os.FileMode(0o555)|os.ModeDir, notWritableErr.Mode)
+	assert.Equal(t, os.Getuid(), notWritableErr.UID)
+	assert.NotEqual(t, "unknown", notWritableErr.Owner)
+	assert.Contains(t, err.Error(), dir)
+	assert.Contains(t, err.Error(), "mode dr-xr-xr-x")
+}
+
+func TestNewSQL_DirectoryNotWritable_ReadOnlySkipsCheck(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("root writes through directory permissions")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.db")
+	createReplica(t, path)
+	require.NoError(t, os.Chmod(dir, 0o555))
+	t.Cleanup(func() { _ = os.Chmod(dir, 0o755) })
+
+	db, err := NewSQL(config.Config{Database: config.DatabaseConfig{Path: path, ReadOnly: true}})
+
+	require.NoError(t, err)
+	closeSQL(t, db)
+}
+
+func TestCheckWritable_FileNotWritable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	createReplica(t, path)
+
+	// Opened read-only, the file refuses the write lock even to root
+	db, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	defer sqlDB.Close()
+
+	err = checkWritable(context.Background(), sqlDB, path)
+
+	require.ErrorIs(t, err, ErrDatabaseNotWritable)
+	var notWritableErr *NotWritableError
+	require.True(t, errors.As(err, &notWritableErr))
+	assert.Equal(t, path, notWritableErr.Path)
+	assert.Equal(t, os.Getuid(), notWritableErr.UID)
+	assert.Contains(t, err.Error(), "readonly database")
+}
+
+func TestNewSQL_Writable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+
+	db, err := NewSQL(config.Config{Database: config.DatabaseConfig{Path: path}})
+
+	require.NoError(t, err)
+	closeSQL(t, db)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), ".write-check-", "the probe file is removed")
+	}
+}
+
+func TestNewSQL_ReadOnlyRefusesWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	createReplica(t, path)
+
+	db, err := NewSQL(config.Config{Database: config.DatabaseConfig{Path: path, ReadOnly: true}})
+	require.NoError(t, err)
+	closeSQL(t, db)
+
+	var rows []replicaRow
+	require.NoError(t, db.SQL.Find(&rows).Error)
+	assert.Len(t, rows, 1)
+	var count int64
+	require.NoError(t, db.SQL.Raw("SELECT COUNT(*) FROM replica_rows").Scan(&count).Error)
+	assert.Equal(t, int64(1), count)
+	require.NoError(t, db.SQL.Exec("PRAGMA quick_check").Error)
+
+	assert.ErrorIs(t, db.SQL.Create(&replicaRow{Name: "bob"}).Error, ErrReadOnly)
+	assert.ErrorIs(t, db.SQL.Model(&rows[0]).Update("name", "carol").Error, ErrReadOnly)
+	assert.ErrorIs(t, db.SQL.Delete(&rows[0]).Error, ErrReadOnly)
+	assert.ErrorIs(t, db.SQL.Exec("DELETE FROM replica_rows").Error, ErrReadOnly)
+
+	require.NoError(t, db.SQL.Find(&rows).Error)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "alice", rows[0].Name)
+}
@@ -0,0 +1,124 @@
+package diagnostics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/logger"
+	"server/internal/utils"
+	"time"
+)
+
+// Stages of startup a failure is reported under.
+const (
+	STAGE_CONFIG     = "config"
+	STAGE_DATABASE   = "database"
+	STAGE_MIGRATIONS = "migrations"
+	STAGE_CACHE      = "cache"
+	STAGE_SERVICES   = "services"
+	STAGE_VALIDATION = "validation"
+	STAGE_SERVER     = "server"
+)
+
+// secretFields are always redacted from reports, on top of
+// Server.RedactFields, so narrowing that list for request logs never puts a
+// config secret on disk.
+var secretFields = []string{"pepper", "cookie_key", "secret", "password", "token", "data_key"}
+
+// StartupFailure is the report a failed startup leaves at
+// Config.DiagnosticsPath for whoever has to work out why the server is down.
+type StartupFailure struct {
+	Timestamp time.Time `json:"timestamp"`
+	Stage     string    `json:"stage"`
+	Error     string    `json:"error"`
+
+	// Chain is the error and each error it wraps, outermost first
+	Chain  []string       `json:"chain"`
+	Config map[string]any `json:"config"`
+}
+
+// NewStartupFailure describes err, which stopped startup at stage.
+func NewStartupFailure(stage string, err error, cfg config.Config, clk clock.Clock) StartupFailure {
+	return StartupFailure{
+		Timestamp: clock.OrDefault(clk).Now().UTC(),
+		Stage:     stage,
+		Error:     err.Error(),
+		Chain:     errorChain(err),
+		Config:    cfg.Values(),
+	}
+}
+
+// errorChain flattens err and everything it wraps, joined errors included.
+func errorChain(err error) []string {
+	var chain []string
+	pending := []error{err}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		if current == nil {
+			continue
+		}
+		chain = append(chain, current.Error())
+
+		switch wrapped := current.(type) {
+		case interface{ Unwrap() []error }:
+			pending = append(pending, wrapped.Unwrap()...)
+		default:
+			if next := errors.Unwrap(current); next != nil {
+				pending = append(pending, next)
+			}
+		}
+	}
+	return chain
+}
+
+// Path is where reports for cfg go.
+func Path(cfg config.Config) string {
+	if cfg.DiagnosticsPath == "" {
+		return config.DEFAULT_DIAGNOSTICS_PATH
+	}
+	return cfg.DiagnosticsPath
+}
+
+// WriteStartupFailure redacts the report and writes it to Path(cfg),
+// replacing the last one.
+func WriteStartupFailure(cfg config.Config, failure StartupFailure) error {
+	log := logger.New("diagnostics").Function("WriteStartupFailure")
+
+	encoded, err := json.Marshal(failure)
+	if err != nil {
+		return log.Err("failed to encode startup failure", err)
+	}
+
+	fields := utils.ParseRedactedFields(cfg.Server.RedactFields)
+	var redacted bytes.Buffer
+	if err := json.Indent(&redacted, []byte(utils.RedactJSON(encoded, append(fields, secretFields...))), "", "  "); err != nil {
+		return log.Err("failed to format startup failure", err)
+	}
+
+	path := Path(cfg)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return log.Err("failed to create diagnostics directory", err, "path", path)
+	}
+	if err := os.WriteFile(path, redacted.Bytes(), 0o600); err != nil {
+		return log.Err("failed to write startup failure", err, "path", path)
+	}
+
+	log.Info("Wrote startup failure report", "path", path, "stage", failure.Stage)
+	return nil
+}
+
+// ClearStartupFailure removes the report of an earlier failed startup, once
+// startup has succeeded.
+func ClearStartupFailure(cfg config.Config) error {
+	path := Path(cfg)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return logger.New("diagnostics").Function("ClearStartupFailure").
+			Err("failed to clear startup failure", err, "path", path)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"server/config"
+	"server/internal/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStartupFailure(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	root := errors.New("connection refused")
+	err := fmt.Errorf("failed to initialize cache: %w", errors.Join(root, errors.New("dial timeout")))
+
+	failure := NewStartupFailure(STAGE_CACHE, err, config.Config{Server: config.ServerConfig{Port: 8280}}, fake)
+
+	assert.Equal(t, fake.Now(), failure.Timestamp)
+	assert.Equal(t, STAGE_CACHE, failure.Stage)
+	assert.Equal(t, err.Error(), failure.Error)
+	assert.Equal(t, []string{
+		err.Error(),
+		"connection refused\ndial timeout",
+		"connection refused",
+		"dial timeout",
+	}, failure.Chain)
+	assert.Equal(t, 8280, failure.Config["server.port"])
+}
+
+func TestWriteStartupFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "failure.json")
+	cfg := config.Config{
+		DiagnosticsPath: path,
+		Server:          config.ServerConfig{Port: 8280, RedactFields: "authorization"},
+		Security: config.SecurityConfig{
+			JwtSecret: "jwt-secret-value",
+			Pepper:    "pepper-value",
+			CookieKey: "cookie-key-value",
+		},
+	}
+
+	failure := NewStartupFailure(STAGE_DATABASE, errors.New("disk full"), cfg, nil)
+	require.NoError(t, WriteStartupFailure(cfg, failure))
+
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	// Secrets are redacted even when Server.RedactFields leaves them out
+	for _, secret := range []string{"jwt-secret-value", "pepper-value", "cookie-key-value"} {
+		assert.NotContains(t, string(written), secret)
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	var report StartupFailure
+	require.NoError(t, json.Unmarshal(written, &report))
+	assert.Equal(t, STAGE_DATABASE, report.Stage)
+	assert.Equal(t, "disk full", report.Error)
+	assert.Equal(t, float64(8280), report.Config["server.port"])
+
+	// A later failure replaces the report
+	require.NoError(t, WriteStartupFailure(cfg, NewStartupFailure(STAGE_CACHE, errors.New("refused"), cfg, nil)))
+	written, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(written, &report))
+	assert.Equal(t, STAGE_CACHE, report.Stage)
+}
+
+func TestClearStartupFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failure.json")
+	cfg := config.Config{DiagnosticsPath: path}
+
+	// Nothing to clear is fine
+	require.NoError(t, ClearStartupFailure(cfg))
+
+	require.NoError(t, WriteStartupFailure(cfg, NewStartupFailure(STAGE_CONFIG, errors.New("bad port"), cfg, nil)))
+	require.NoError(t, ClearStartupFailure(cfg))
+	_, err := os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestPath(t *testing.T) {
+	assert.Equal(t, config.DEFAULT_DIAGNOSTICS_PATH, Path(config.Config{}))
+	assert.Equal(t, "custom.json", Path(config.Config{DiagnosticsPath: "custom.json"}))
+}
@@ -0,0 +1,176 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// KEY_SIZE is the length of a data key, for AES-256-GCM
+	KEY_SIZE = 32
+
+	// PREFIX starts every encrypted value, followed by the base64 nonce and
+	// ciphertext. Values without it are plaintext. v1 is the version of the
+	// key, so a rotation can tell values still on the old one from the rest.
+	PREFIX = "enc:v1:"
+
+	// SERIALIZER is the gorm serializer encrypting a column, as in
+	// `gorm:"serializer:encrypted"`
+	SERIALIZER = "encrypted"
+)
+
+var (
+	ErrInvalidKey = fmt.Errorf("data key must be %d bytes, base64 encoded", KEY_SIZE)
+	// ErrNoKey is an encrypted value read without a data key set
+	ErrNoKey = errors.New("value is encrypted but no data key is set")
+	// ErrDecrypt is an encrypted value the data key doesn't open, because it
+	// is another key or the value was tampered with
+	ErrDecrypt = errors.New("value can't be decrypted with the data key")
+)
+
+func init() {
+	schema.RegisterSerializer(SERIALIZER, Serializer{})
+}
+
+// ParseKey decodes a base64 data key, standard or URL encoding, padded or
+// not.
+func ParseKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	for _, encoding := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		if key, err := encoding.DecodeString(encoded); err == nil {
+			if len(key) != KEY_SIZE {
+				return nil, ErrInvalidKey
+			}
+			return key, nil
+		}
+	}
+	return nil, ErrInvalidKey
+}
+
+// IsEncrypted reports whether value was written by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, PREFIX)
+}
+
+// Encrypt seals plaintext with key under a random nonce and prefixes it with
+// PREFIX.
+func Encrypt(key []byte, plaintext string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(PREFIX))
+	return PREFIX + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value Encrypt wrote with key.
+func Decrypt(key []byte, value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, PREFIX)
+	if !ok {
+		return "", ErrDecrypt
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(PREFIX))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KEY_SIZE {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type keyContext struct{}
+
+// WithKey carries key to the serializer of the statements run with ctx.
+func WithKey(ctx context.Context, key []byte) context.Context {
+	return context.WithValue(ctx, keyContext{}, key)
+}
+
+func keyFrom(ctx context.Context) []byte {
+	if ctx == nil {
+		return nil
+	}
+	key, _ := ctx.Value(keyContext{}).([]byte)
+	return key
+}
+
+// Serializer encrypts string columns with the key the statement's context
+// carries, see WithKey, and writes them as plaintext without one. It reads
+// both, so a database can be encrypted while in use; an encrypted value read
+// without the key, or with another one, fails the query.
+type Serializer struct{}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("encrypted column %s holds %T, not text", field.DBName, dbValue)
+	}
+
+	if IsEncrypted(value) {
+		key := keyFrom(ctx)
+		if key == nil {
+			return fmt.Errorf("%s: %w", field.DBName, ErrNoKey)
+		}
+		plaintext, err := Decrypt(key, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.DBName, err)
+		}
+		value = plaintext
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue any) (any, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("encrypted column %s is %T, not a string", field.DBName, fieldValue)
+	}
+
+	key := keyFrom(ctx)
+	if key == nil || value == "" {
+		return value, nil
+	}
+	return Encrypt(key, value)
+}
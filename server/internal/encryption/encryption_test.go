@@ -0,0 +1,65 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte) []byte {
+	return []byte(strings.Repeat(string(rune(b)), KEY_SIZE))
+}
+
+func TestEncrypt_RoundTrip(t *testing.T) {
+	key := testKey('a')
+
+	encrypted, err := Encrypt(key, "$2a$10$hash")
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(encrypted))
+	assert.NotContains(t, encrypted, "hash")
+
+	again, err := Encrypt(key, "$2a$10$hash")
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted, again, "every value gets its own nonce")
+
+	plaintext, err := Decrypt(key, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "$2a$10$hash", plaintext)
+}
+
+func TestDecrypt_Fails(t *testing.T) {
+	encrypted, err := Encrypt(testKey('a'), "secret")
+	require.NoError(t, err)
+
+	_, err = Decrypt(testKey('b'), encrypted)
+	assert.ErrorIs(t, err, ErrDecrypt, "another key")
+
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	_, err = Decrypt(testKey('a'), tampered)
+	assert.ErrorIs(t, err, ErrDecrypt, "tampered")
+
+	for _, value := range []string{"secret", PREFIX, PREFIX + "!!!", PREFIX + "AAAA"} {
+		_, err = Decrypt(testKey('a'), value)
+		assert.ErrorIs(t, err, ErrDecrypt, value)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := testKey('k')
+
+	for _, encoding := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		parsed, err := ParseKey(" " + encoding.EncodeToString(key) + "\n")
+		require.NoError(t, err)
+		assert.Equal(t, key, parsed)
+	}
+
+	for _, invalid := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(key[:16])} {
+		_, err := ParseKey(invalid)
+		assert.ErrorIs(t, err, ErrInvalidKey, invalid)
+	}
+}
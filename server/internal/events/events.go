@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"server/config"
+	"server/internal/clock"
 	"server/internal/logger"
+	"server/internal/tracing"
 	"sync"
 	"time"
 
@@ -19,27 +21,37 @@ type Event struct {
 	UserID    string         `json:"userId,omitempty"`
 	Data      map[string]any `json:"data"`
 	Timestamp time.Time      `json:"timestamp"`
+
+	// The span that published the event, so its handlers' spans join the
+	// trace on every instance
+	TraceParent string `json:"traceparent,omitempty"`
 }
 
 type EventHandler func(event Event) error
 
+// WILDCARD_CHANNEL subscribers see every event notified on this instance.
+const WILDCARD_CHANNEL = "*"
+
 type EventBus struct {
 	client   valkey.Client
 	logger   logger.Logger
 	config   config.Config
+	clock    clock.Clock
 	handlers map[string][]EventHandler
 	mutex    sync.RWMutex
 	ctx      context.Context
 	cancel   context.CancelFunc
 }
 
-func New(client valkey.Client, config config.Config) *EventBus {
+// New creates the bus. A nil clock stamps events with the wall clock.
+func New(client valkey.Client, config config.Config, clk clock.Clock) *EventBus {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &EventBus{
 		client:   client,
 		logger:   logger.New("EventBus"),
 		config:   config,
+		clock:    clock.OrDefault(clk),
 		handlers: make(map[string][]EventHandler),
 		ctx:      ctx,
 		cancel:   cancel,
@@ -47,6 +59,12 @@ func New(client valkey.Client, config config.Config) *EventBus {
 }
 
 func (eb *EventBus) Publish(channel string, event Event) error {
+	return eb.publish(eb.context(), channel, event)
+}
+
+// publish sends the event to valkey and the local handlers. A bus without a
+// client, as used in tests, only notifies local handlers.
+func (eb *EventBus) publish(parent context.Context, channel string, event Event) error {
 	log := eb.logger.Function("Publish")
 
 	if event.ID == "" {
@@ -54,19 +72,28 @@ func (eb *EventBus) Publish(channel string, event Event) error {
 	}
 
 	if event.Timestamp.IsZero() {
-		event.Timestamp = time.Now()
+		event.Timestamp = clock.OrDefault(eb.clock).Now()
 	}
 
 	if event.Channel == "" {
 		event.Channel = channel
 	}
 
+	if event.TraceParent == "" {
+		event.TraceParent = tracing.Traceparent(parent)
+	}
+
 	eventData, err := json.Marshal(event)
 	if err != nil {
 		return log.Err("failed to marshal event", err, "eventID", event.ID)
 	}
 
-	ctx, cancel := context.WithTimeout(eb.ctx, 5*time.Second)
+	if eb.client == nil {
+		eb.notifyLocalHandlers(channel, event)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(parent, 5*time.Second)
 	defer cancel()
 
 	err = eb.client.Do(ctx, eb.client.B().Publish().Channel(channel).Message(string(eventData)).Build()).
@@ -99,6 +126,10 @@ func (eb *EventBus) Subscribe(channel string, handler EventHandler) error {
 
 	log.Info("Handler subscribed to channel", "channel", channel)
 
+	if channel == WILDCARD_CHANNEL {
+		return nil
+	}
+
 	// Start listening to this channel if it's the first handler
 	go eb.listenToChannel(channel)
 
@@ -109,16 +140,20 @@ func (eb *EventBus) notifyLocalHandlers(channel string, event Event) {
 	log := eb.logger.Function("notifyLocalHandlers")
 
 	eb.mutex.RLock()
-	handlers, exists := eb.handlers[channel]
+	handlers := append([]EventHandler{}, eb.handlers[channel]...)
+	if channel != WILDCARD_CHANNEL {
+		handlers = append(handlers, eb.handlers[WILDCARD_CHANNEL]...)
+	}
 	eb.mutex.RUnlock()
 
-	if !exists || len(handlers) == 0 {
+	if len(handlers) == 0 {
 		return
 	}
 
 	for i, handler := range handlers {
 		go func(h EventHandler, handlerIndex int) {
-			if err := h(event); err != nil {
+			err := eb.runHandler(h, channel, handlerIndex, event)
+			if err != nil {
 				log.Er(
 					"handler failed",
 					err,
@@ -134,10 +169,34 @@ func (eb *EventBus) notifyLocalHandlers(channel string, event Event) {
 	}
 }
 
+// runHandler runs one handler in a consumer span, a child of the span that
+// published the event. The handler sees that span as the event's
+// TraceParent.
+func (eb *EventBus) runHandler(handler EventHandler, channel string, handlerIndex int, event Event) error {
+	if !tracing.Enabled() {
+		return handler(event)
+	}
+
+	parent, _ := tracing.ParseTraceparent(event.TraceParent)
+	ctx, span := tracing.StartRemote(eb.context(), tracing.SPAN_KIND_CONSUMER, "events.handle", parent)
+	span.SetString("events.channel", channel)
+	span.SetString("events.type", event.Type)
+	span.SetInt("events.handler", int64(handlerIndex))
+	event.TraceParent = tracing.Traceparent(ctx)
+
+	err := handler(event)
+	span.EndError(err)
+	return err
+}
+
 func (eb *EventBus) listenToChannel(channel string) {
 	log := eb.logger.Function("listenToChannel")
 
-	ctx, cancel := context.WithCancel(eb.ctx)
+	if eb.client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(eb.context())
 	defer cancel()
 
 	log.Info("Starting to listen to channel", "channel", channel)
@@ -169,6 +228,13 @@ func (eb *EventBus) listenToChannel(channel string) {
 	}
 }
 
+func (eb *EventBus) context() context.Context {
+	if eb.ctx == nil {
+		return context.Background()
+	}
+	return eb.ctx
+}
+
 func (eb *EventBus) Close() error {
 	log := eb.logger.Function("Close")
 
@@ -195,6 +261,14 @@ func (eb *EventBus) PublishUserLogout(userID string) error {
 	})
 }
 
+func (eb *EventBus) PublishUserDeleted(userID string) error {
+	return eb.Publish("user.deleted", Event{
+		Type:   "user_deleted",
+		UserID: userID,
+		Data:   map[string]any{},
+	})
+}
+
 func (eb *EventBus) PublishAdminBroadcast(message string, adminUserID string) error {
 	return eb.Publish("admin.broadcast", Event{
 		Type:   "admin_broadcast",
@@ -0,0 +1,34 @@
+package events
+
+import (
+	"context"
+	"server/internal/health"
+)
+
+type busChecker struct {
+	bus *EventBus
+}
+
+// Health pings the valkey connection events are published through. A bus
+// without a client only reaches local handlers, and is healthy as such.
+func (eb *EventBus) Health() health.Checker {
+	return busChecker{bus: eb}
+}
+
+func (c busChecker) Name() string { return "events" }
+
+func (c busChecker) Check(ctx context.Context) health.CheckResult {
+	c.bus.mutex.RLock()
+	details := map[string]any{"channels": len(c.bus.handlers)}
+	c.bus.mutex.RUnlock()
+
+	client := c.bus.client
+	if client == nil {
+		details["mode"] = "local"
+		return health.OK(details)
+	}
+	if err := client.Do(ctx, client.B().Ping().Build()).Error(); err != nil {
+		return health.Down(err, details)
+	}
+	return health.OK(details)
+}
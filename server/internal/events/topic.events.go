@@ -0,0 +1,324 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"server/config"
+	"server/internal/models"
+	"server/internal/tracing"
+	"time"
+)
+
+// PayloadMismatchError is returned when an event on a typed topic can't be
+// decoded into the topic's payload type.
+type PayloadMismatchError struct {
+	Channel   string
+	EventType string
+	Expected  string
+	Err       error
+}
+
+func (e *PayloadMismatchError) Error() string {
+	return fmt.Sprintf(
+		"event %q on channel %q does not match %s: %v",
+		e.EventType,
+		e.Channel,
+		e.Expected,
+		e.Err,
+	)
+}
+
+func (e *PayloadMismatchError) Unwrap() error {
+	return e.Err
+}
+
+// UserScoped payloads fill Event.UserID when published on a typed topic.
+type UserScoped interface {
+	EventUserID() string
+}
+
+// TypedTopic publishes and subscribes a single payload type on a channel,
+// converting to and from the untyped Event on the wire.
+type TypedTopic[T any] struct {
+	bus       *EventBus
+	channel   string
+	eventType string
+}
+
+func NewTopic[T any](bus *EventBus, channel string, eventType string) TypedTopic[T] {
+	return TypedTopic[T]{bus: bus, channel: channel, eventType: eventType}
+}
+
+func (t TypedTopic[T]) Channel() string {
+	return t.channel
+}
+
+func (t TypedTopic[T]) Publish(ctx context.Context, payload T) error {
+	data, err := ToData(payload)
+	if err != nil {
+		return t.bus.logger.Function("TypedTopic.Publish").
+			Err("failed to encode payload", err, "channel", t.channel)
+	}
+
+	event := Event{
+		Type: t.eventType,
+		Data: data,
+	}
+	if scoped, ok := any(payload).(UserScoped); ok {
+		event.UserID = scoped.EventUserID()
+	}
+
+	return t.bus.publish(ctx, t.channel, event)
+}
+
+// Subscribe registers a handler that receives decoded payloads. Events that
+// don't decode are reported as a PayloadMismatchError and never reach it.
+func (t TypedTopic[T]) Subscribe(handler func(ctx context.Context, payload T) error) error {
+	return t.bus.Subscribe(t.channel, func(event Event) error {
+		if event.Type != t.eventType {
+			return t.mismatch(event, fmt.Errorf("unexpected event type %q", event.Type))
+		}
+
+		payload, err := DecodePayload[T](event)
+		if err != nil {
+			return t.mismatch(event, err)
+		}
+
+		ctx := t.bus.context()
+		if parent, ok := tracing.ParseTraceparent(event.TraceParent); ok {
+			ctx = tracing.ContextWithRemote(ctx, parent)
+		}
+		return handler(ctx, payload)
+	})
+}
+
+func (t TypedTopic[T]) mismatch(event Event, err error) error {
+	var expected T
+	return &PayloadMismatchError{
+		Channel:   t.channel,
+		EventType: event.Type,
+		Expected:  fmt.Sprintf("%T", expected),
+		Err:       err,
+	}
+}
+
+// ToData flattens a payload into the map carried by Event.Data.
+func ToData(payload any) (map[string]any, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]any
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DecodePayload reads Event.Data into T. Fields T doesn't know about are an
+// error, so a payload from a different topic isn't silently half-decoded.
+func DecodePayload[T any](event Event) (T, error) {
+	var payload T
+
+	encoded, err := json.Marshal(event.Data)
+	if err != nil {
+		return payload, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&payload); err != nil {
+		return payload, err
+	}
+
+	return payload, nil
+}
+
+type UserLoginEvent struct {
+	UserID    string `json:"userId"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Login     string `json:"login"`
+	IsAdmin   bool   `json:"isAdmin"`
+	LoginTime int64  `json:"loginTime"`
+}
+
+func (e UserLoginEvent) EventUserID() string { return e.UserID }
+
+// UserLogoutEvent is published whenever a session is ended before it
+// expires. SessionID is empty when all of the user's sessions ended, or their
+// credentials changed.
+type UserLogoutEvent struct {
+	UserID    string `json:"userId"`
+	SessionID string `json:"sessionId"`
+}
+
+func (e UserLogoutEvent) EventUserID() string { return e.UserID }
+
+// SessionRefreshedEvent carries the token a token-based session was given
+// when it was refreshed, for the user's open websockets to pick up.
+type SessionRefreshedEvent struct {
+	UserID            string    `json:"userId"`
+	SessionID         string    `json:"sessionId"`
+	PreviousSessionID string    `json:"previousSessionId"`
+	Token             string    `json:"token"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+}
+
+func (e SessionRefreshedEvent) EventUserID() string { return e.UserID }
+
+type UserRegisteredEvent struct {
+	UserID    string `json:"userId"`
+	Login     string `json:"login"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+func (e UserRegisteredEvent) EventUserID() string { return e.UserID }
+
+// UserUpdatedEvent names the fields of a user that just changed, by their
+// JSON names, never their values, so the user's open clients know to fetch
+// them again.
+type UserUpdatedEvent struct {
+	UserID string   `json:"userId"`
+	Fields []string `json:"fields"`
+}
+
+func (e UserUpdatedEvent) EventUserID() string { return e.UserID }
+
+// AdminBroadcastEvent is a system message. Announcements fill in the title,
+// severity and expiry, and may be for an audience narrower than everyone; a
+// bare message leaves them empty.
+type AdminBroadcastEvent struct {
+	ID        string          `json:"id,omitempty"`
+	Title     string          `json:"title,omitempty"`
+	Message   string          `json:"message"`
+	Severity  string          `json:"severity,omitempty"`
+	ExpiresAt *models.APITime `json:"expiresAt,omitempty"`
+	Audience  string          `json:"audience,omitempty"`
+	UserIDs   []string        `json:"userIds,omitempty"`
+	SentBy    string          `json:"sentBy"`
+}
+
+func (e AdminBroadcastEvent) EventUserID() string { return e.SentBy }
+
+// CacheInvalidatedEvent tells every instance to drop its local copy of an
+// entity, or of each of IDs when a batch of them changed. Origin is the
+// instance that made the change, which has already evicted its own copy.
+type CacheInvalidatedEvent struct {
+	Entity string   `json:"entity"`
+	ID     string   `json:"id,omitempty"`
+	IDs    []string `json:"ids,omitempty"`
+	Origin string   `json:"origin"`
+}
+
+// MaintenanceChangedEvent carries the maintenance state an admin just set.
+// Origin is the instance that made the change, which has already applied it.
+type MaintenanceChangedEvent struct {
+	Enabled   bool            `json:"enabled"`
+	Message   string          `json:"message"`
+	Since     *models.APITime `json:"since,omitempty"`
+	UpdatedBy string          `json:"updatedBy"`
+	Origin    string          `json:"origin"`
+}
+
+func (e MaintenanceChangedEvent) EventUserID() string { return e.UpdatedBy }
+
+const (
+	IMPERSONATION_STARTED = "started"
+	IMPERSONATION_STOPPED = "stopped"
+)
+
+// ImpersonationEvent is the audit record of an admin starting or stopping
+// acting as another user.
+type ImpersonationEvent struct {
+	Action    string     `json:"action"`
+	AdminID   string     `json:"adminId"`
+	UserID    string     `json:"userId"`
+	SessionID string     `json:"sessionId"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	IP        string     `json:"ip,omitempty"`
+}
+
+func (e ImpersonationEvent) EventUserID() string { return e.AdminID }
+
+const (
+	ALERT_SEVERITY_WARNING  = "warning"
+	ALERT_SEVERITY_CRITICAL = "critical"
+)
+
+// SystemAlertEvent is something operators should look at, like a failed
+// database integrity check. Code names the kind of alert.
+type SystemAlertEvent struct {
+	Code     string         `json:"code"`
+	Severity string         `json:"severity"`
+	Message  string         `json:"message"`
+	Details  map[string]any `json:"details,omitempty"`
+}
+
+// ConfigChangedEvent lists the settings a configuration reload changed.
+// Reloads are per instance, so Origin is the instance that reloaded, and
+// Revision counts its reloads so a repeated delivery can be told apart.
+type ConfigChangedEvent struct {
+	Revision int64           `json:"revision"`
+	Changes  []config.Change `json:"changes"`
+	Origin   string          `json:"origin"`
+}
+
+// Changed reports whether the reload changed setting, e.g.
+// server.cors_allow_origins.
+func (e ConfigChangedEvent) Changed(setting string) bool {
+	for _, change := range e.Changes {
+		if change.Field == setting {
+			return true
+		}
+	}
+	return false
+}
+
+func (eb *EventBus) UserLoginTopic() TypedTopic[UserLoginEvent] {
+	return NewTopic[UserLoginEvent](eb, "user.login", "user_login")
+}
+
+func (eb *EventBus) UserLogoutTopic() TypedTopic[UserLogoutEvent] {
+	return NewTopic[UserLogoutEvent](eb, "user.logout", "user_logout")
+}
+
+func (eb *EventBus) SessionRefreshedTopic() TypedTopic[SessionRefreshedEvent] {
+	return NewTopic[SessionRefreshedEvent](eb, "session.refreshed", "session_refreshed")
+}
+
+func (eb *EventBus) UserRegisteredTopic() TypedTopic[UserRegisteredEvent] {
+	return NewTopic[UserRegisteredEvent](eb, "user.registered", "user_registered")
+}
+
+func (eb *EventBus) UserUpdatedTopic() TypedTopic[UserUpdatedEvent] {
+	return NewTopic[UserUpdatedEvent](eb, "user.updated", "user_updated")
+}
+
+func (eb *EventBus) BroadcastTopic() TypedTopic[AdminBroadcastEvent] {
+	return NewTopic[AdminBroadcastEvent](eb, "broadcast", "admin")
+}
+
+func (eb *EventBus) CacheInvalidationTopic() TypedTopic[CacheInvalidatedEvent] {
+	return NewTopic[CacheInvalidatedEvent](eb, "cache.invalidate", "cache_invalidated")
+}
+
+func (eb *EventBus) MaintenanceTopic() TypedTopic[MaintenanceChangedEvent] {
+	return NewTopic[MaintenanceChangedEvent](eb, "maintenance", "maintenance_changed")
+}
+
+func (eb *EventBus) ImpersonationTopic() TypedTopic[ImpersonationEvent] {
+	return NewTopic[ImpersonationEvent](eb, "admin.impersonation", "impersonation")
+}
+
+func (eb *EventBus) SystemAlertTopic() TypedTopic[SystemAlertEvent] {
+	return NewTopic[SystemAlertEvent](eb, "system.alert", "system_alert")
+}
+
+func (eb *EventBus) ConfigChangedTopic() TypedTopic[ConfigChangedEvent] {
+	return NewTopic[ConfigChangedEvent](eb, "config.changed", "config_changed")
+}
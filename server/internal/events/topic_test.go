@@ -0,0 +1,182 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/tracing"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	var zero T
+	return zero
+}
+
+func TestTypedTopic_RoundTrip(t *testing.T) {
+	bus := New(nil, config.Config{}, nil)
+	received := make(chan UserLoginEvent, 1)
+
+	require.NoError(t, bus.UserLoginTopic().Subscribe(func(ctx context.Context, event UserLoginEvent) error {
+		received <- event
+		return nil
+	}))
+
+	sent := UserLoginEvent{
+		UserID:    "user-1",
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Login:     "jdoe",
+		IsAdmin:   true,
+		LoginTime: 1717243200,
+	}
+	require.NoError(t, bus.UserLoginTopic().Publish(context.Background(), sent))
+
+	assert.Equal(t, sent, receive(t, received))
+}
+
+func TestEventBus_TimestampFromClock(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	bus := New(nil, config.Config{}, clock.NewFake(now))
+	received := make(chan Event, 1)
+	require.NoError(t, bus.Subscribe(WILDCARD_CHANNEL, func(event Event) error {
+		received <- event
+		return nil
+	}))
+
+	require.NoError(t, bus.Publish("test", Event{Type: "test"}))
+	assert.Equal(t, now, receive(t, received).Timestamp)
+
+	stamped := now.Add(-time.Hour)
+	require.NoError(t, bus.Publish("test", Event{Type: "test", Timestamp: stamped}))
+	assert.Equal(t, stamped, receive(t, received).Timestamp, "a timestamp set by the publisher is kept")
+}
+
+func TestTypedTopic_MismatchedPayload(t *testing.T) {
+	bus := New(nil, config.Config{}, nil)
+	topic := bus.UserRegisteredTopic()
+
+	event := Event{
+		Type: "user_registered",
+		Data: map[string]any{"userId": 42, "login": "jdoe"},
+	}
+	_, err := DecodePayload[UserRegisteredEvent](event)
+	assert.Error(t, err)
+
+	called := false
+	handler := func(ctx context.Context, event UserRegisteredEvent) error {
+		called = true
+		return nil
+	}
+	require.NoError(t, topic.Subscribe(handler))
+
+	bus.mutex.RLock()
+	wrapped := bus.handlers[topic.Channel()][0]
+	bus.mutex.RUnlock()
+
+	testCases := []struct {
+		name  string
+		event Event
+	}{
+		{"wrong field type", event},
+		{"unknown field", Event{Type: "user_registered", Data: map[string]any{"message": "hi"}}},
+		{"wrong event type", Event{Type: "user_login", Data: map[string]any{"userId": "user-1"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := wrapped(tc.event)
+
+			var mismatch *PayloadMismatchError
+			require.True(t, errors.As(err, &mismatch))
+			assert.Equal(t, "user.registered", mismatch.Channel)
+			assert.Equal(t, "events.UserRegisteredEvent", mismatch.Expected)
+		})
+	}
+	assert.False(t, called, "mismatched payloads must not reach the handler")
+}
+
+func TestTypedTopic_ObservedByWildcardSubscriber(t *testing.T) {
+	bus := New(nil, config.Config{}, nil)
+	received := make(chan Event, 1)
+
+	require.NoError(t, bus.Subscribe(WILDCARD_CHANNEL, func(event Event) error {
+		received <- event
+		return nil
+	}))
+
+	require.NoError(t, bus.BroadcastTopic().Publish(context.Background(), AdminBroadcastEvent{
+		Message: "maintenance at noon",
+		SentBy:  "admin-1",
+	}))
+
+	event := receive(t, received)
+	assert.Equal(t, "broadcast", event.Channel)
+	assert.Equal(t, "admin", event.Type)
+	assert.Equal(t, "admin-1", event.UserID)
+	assert.Equal(t, "maintenance at noon", event.Data["message"])
+	assert.NotEmpty(t, event.ID)
+}
+
+func TestTypedTopic_UntypedPublisherReachesTypedSubscriber(t *testing.T) {
+	bus := New(nil, config.Config{}, nil)
+	received := make(chan AdminBroadcastEvent, 1)
+
+	require.NoError(t, bus.BroadcastTopic().Subscribe(func(ctx context.Context, event AdminBroadcastEvent) error {
+		received <- event
+		return nil
+	}))
+
+	require.NoError(t, bus.Publish("broadcast", Event{
+		Type: "admin",
+		Data: map[string]any{"message": "hello"},
+	}))
+
+	assert.Equal(t, AdminBroadcastEvent{Message: "hello"}, receive(t, received))
+}
+
+func TestTypedTopic_TracesHandlers(t *testing.T) {
+	recorder := &tracing.Recorder{}
+	tracer := tracing.NewTracer(recorder, 1)
+	tracing.Install(tracer)
+	t.Cleanup(func() { tracing.Install(nil) })
+
+	bus := New(nil, config.Config{}, nil)
+	require.NoError(t, bus.UserLoginTopic().Subscribe(func(ctx context.Context, event UserLoginEvent) error {
+		_, span := tracing.Start(ctx, "work")
+		span.End()
+		return nil
+	}))
+
+	ctx, publisher := tracing.Start(context.Background(), "publisher")
+	require.NoError(t, bus.UserLoginTopic().Publish(ctx, UserLoginEvent{UserID: "user-1"}))
+	publisher.End()
+
+	spans := make(map[string]tracing.SpanData)
+	require.Eventually(t, func() bool {
+		require.NoError(t, tracer.Flush(context.Background()))
+		for _, span := range recorder.Spans() {
+			spans[span.Name] = span
+		}
+		return len(spans) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	handle := spans["events.handle"]
+	assert.Equal(t, tracing.SPAN_KIND_CONSUMER, handle.Kind)
+	assert.Equal(t, spans["publisher"].SpanID, handle.ParentID)
+	assert.Equal(t, handle.SpanID, spans["work"].ParentID)
+	assert.Equal(t, spans["publisher"].TraceID, spans["work"].TraceID)
+	assert.Contains(t, handle.Attributes, tracing.Attribute{Key: "events.type", Value: "user_login"})
+}
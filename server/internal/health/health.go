@@ -0,0 +1,183 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	STATUS_OK       = "ok"
+	STATUS_DEGRADED = "degraded"
+	STATUS_DOWN     = "down"
+
+	// How long a checker gets before it is reported down
+	DEFAULT_CHECK_TIMEOUT = 2 * time.Second
+)
+
+// CheckResult is what a checker found. Latency is filled in by the registry
+// when the checker leaves it zero.
+type CheckResult struct {
+	Status  string         `json:"status"`
+	Latency time.Duration  `json:"-"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Checker reports the health of one subsystem. Check must return once ctx is
+// done; the registry stops waiting for it then either way.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) CheckResult
+}
+
+// OK, Degraded and Down build results for checkers. Down and Degraded record
+// err when it is not nil.
+func OK(details map[string]any) CheckResult {
+	return CheckResult{Status: STATUS_OK, Details: details}
+}
+
+func Degraded(err error, details map[string]any) CheckResult {
+	return withError(CheckResult{Status: STATUS_DEGRADED, Details: details}, err)
+}
+
+func Down(err error, details map[string]any) CheckResult {
+	return withError(CheckResult{Status: STATUS_DOWN, Details: details}, err)
+}
+
+func withError(result CheckResult, err error) CheckResult {
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Check is a checker's result in a report.
+type Check struct {
+	Name      string `json:"name"`
+	Critical  bool   `json:"critical"`
+	LatencyMs int64  `json:"latencyMs"`
+	CheckResult
+}
+
+// Report is the outcome of running every checker, ordered by name. Status is
+// down when a critical checker is down, degraded when any checker is not ok,
+// and ok otherwise.
+type Report struct {
+	Status string  `json:"status"`
+	Checks []Check `json:"checks"`
+}
+
+type registration struct {
+	checker  Checker
+	critical bool
+}
+
+// Registry holds the checkers the health endpoint runs. Subsystems register
+// theirs when the app is built.
+type Registry struct {
+	mutex    sync.RWMutex
+	checkers []registration
+	timeout  time.Duration
+}
+
+// New gives each checker timeout to answer, DEFAULT_CHECK_TIMEOUT when zero.
+func New(timeout time.Duration) *Registry {
+	if timeout <= 0 {
+		timeout = DEFAULT_CHECK_TIMEOUT
+	}
+	return &Registry{timeout: timeout}
+}
+
+// Register adds checker. A critical checker failing takes the whole service
+// down; any other only degrades it. It panics when the name is already taken,
+// since that is a programming error.
+func (r *Registry) Register(checker Checker, critical bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, existing := range r.checkers {
+		if existing.checker.Name() == checker.Name() {
+			panic(fmt.Sprintf("health: %q registered twice", checker.Name()))
+		}
+	}
+	r.checkers = append(r.checkers, registration{checker: checker, critical: critical})
+}
+
+// Run runs every checker at once, each with the registry's timeout, and
+// waits for them all.
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mutex.RLock()
+	checkers := slices.Clone(r.checkers)
+	r.mutex.RUnlock()
+
+	checks := make([]Check, len(checkers))
+	var wg sync.WaitGroup
+	for i, registered := range checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checks[i] = Check{
+				Name:     registered.checker.Name(),
+				Critical: registered.critical,
+			}
+			result := r.check(ctx, registered.checker)
+			checks[i].CheckResult = result
+			checks[i].LatencyMs = result.Latency.Milliseconds()
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(checks, func(a, b Check) int { return strings.Compare(a.Name, b.Name) })
+	return Report{Status: aggregate(checks), Checks: checks}
+}
+
+// check runs checker, reporting it down when it doesn't answer in time or
+// panics. A checker that overruns is left to finish on its own.
+func (r *Registry) check(parent context.Context, checker Checker) CheckResult {
+	ctx, cancel := context.WithTimeout(parent, r.timeout)
+	defer cancel()
+
+	started := time.Now()
+	results := make(chan CheckResult, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				results <- Down(fmt.Errorf("check panicked: %v", recovered), nil)
+			}
+		}()
+		results <- checker.Check(ctx)
+	}()
+
+	var result CheckResult
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		result = Down(fmt.Errorf("check timed out after %s", r.timeout), nil)
+	}
+
+	if result.Status == "" {
+		result.Status = STATUS_DOWN
+	}
+	if result.Latency == 0 {
+		result.Latency = time.Since(started)
+	}
+	return result
+}
+
+func aggregate(checks []Check) string {
+	status := STATUS_OK
+	for _, check := range checks {
+		if check.Status == STATUS_OK {
+			continue
+		}
+		if check.Critical && check.Status == STATUS_DOWN {
+			return STATUS_DOWN
+		}
+		status = STATUS_DEGRADED
+	}
+	return status
+}
@@ -0,0 +1,132 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeChecker struct {
+	name   string
+	result CheckResult
+	delay  time.Duration
+}
+
+func (c fakeChecker) Name() string { return c.name }
+
+func (c fakeChecker) Check(ctx context.Context) CheckResult {
+	select {
+	case <-time.After(c.delay):
+		return c.result
+	case <-ctx.Done():
+		// Ignores the deadline, as a misbehaving checker would
+		time.Sleep(c.delay)
+		return c.result
+	}
+}
+
+func TestRegistry_Aggregation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		critical CheckResult
+		optional CheckResult
+		expected string
+	}{
+		{"all ok", OK(nil), OK(nil), STATUS_OK},
+		{"optional down", OK(nil), Down(errors.New("gone"), nil), STATUS_DEGRADED},
+		{"optional degraded", OK(nil), Degraded(nil, nil), STATUS_DEGRADED},
+		{"critical degraded", Degraded(nil, nil), OK(nil), STATUS_DEGRADED},
+		{"critical down", Down(errors.New("gone"), nil), OK(nil), STATUS_DOWN},
+		{"no status", CheckResult{}, OK(nil), STATUS_DOWN},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := New(time.Second)
+			registry.Register(fakeChecker{name: "critical", result: tc.critical}, true)
+			registry.Register(fakeChecker{name: "optional", result: tc.optional}, false)
+
+			assert.Equal(t, tc.expected, registry.Run(context.Background()).Status)
+		})
+	}
+}
+
+func TestRegistry_Timeout(t *testing.T) {
+	registry := New(20 * time.Millisecond)
+	registry.Register(fakeChecker{name: "slow", result: OK(nil), delay: time.Second}, false)
+	registry.Register(fakeChecker{name: "fast", result: OK(nil)}, true)
+
+	started := time.Now()
+	report := registry.Run(context.Background())
+
+	assert.Less(t, time.Since(started), 500*time.Millisecond, "expected the slow checker not to be waited for")
+	assert.Equal(t, STATUS_DEGRADED, report.Status)
+	require.Len(t, report.Checks, 2)
+	slow := report.Checks[1]
+	assert.Equal(t, "slow", slow.Name)
+	assert.Equal(t, STATUS_DOWN, slow.Status)
+	assert.Contains(t, slow.Error, "timed out")
+	assert.GreaterOrEqual(t, slow.LatencyMs, int64(20))
+}
+
+func TestRegistry_RunsConcurrently(t *testing.T) {
+	registry := New(time.Second)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		registry.Register(fakeChecker{name: name, result: OK(nil), delay: 50 * time.Millisecond}, true)
+	}
+
+	started := time.Now()
+	report := registry.Run(context.Background())
+
+	assert.Equal(t, STATUS_OK, report.Status)
+	assert.Less(t, time.Since(started), 150*time.Millisecond)
+}
+
+type panickingChecker struct{}
+
+func (panickingChecker) Name() string { return "panics" }
+
+func (panickingChecker) Check(ctx context.Context) CheckResult { panic("boom") }
+
+func TestRegistry_Panic(t *testing.T) {
+	registry := New(time.Second)
+	registry.Register(panickingChecker{}, true)
+
+	report := registry.Run(context.Background())
+
+	assert.Equal(t, STATUS_DOWN, report.Status)
+	assert.Contains(t, report.Checks[0].Error, "boom")
+}
+
+func TestRegistry_StableJSON(t *testing.T) {
+	registry := New(time.Second)
+	registry.Register(fakeChecker{name: "websocket", result: OK(map[string]any{"connections": 2})}, false)
+	registry.Register(fakeChecker{name: "cache", result: Down(errors.New("refused"), nil)}, true)
+	registry.Register(fakeChecker{name: "database", result: OK(nil), delay: 10 * time.Millisecond}, true)
+
+	report := registry.Run(context.Background())
+	for i := range report.Checks {
+		report.Checks[i].LatencyMs = 0
+	}
+	encoded, err := json.Marshal(report)
+	require.NoError(t, err)
+
+	// Byte for byte, so responses can be diffed from one request to the next
+	assert.Equal(t, `{"status":"down","checks":[`+
+		`{"name":"cache","critical":true,"latencyMs":0,"status":"down","error":"refused"},`+
+		`{"name":"database","critical":true,"latencyMs":0,"status":"ok"},`+
+		`{"name":"websocket","critical":false,"latencyMs":0,"status":"ok","details":{"connections":2}}`+
+		`]}`, string(encoded))
+}
+
+func TestRegistry_RegisterTwicePanics(t *testing.T) {
+	registry := New(0)
+	registry.Register(fakeChecker{name: "cache"}, true)
+
+	assert.Panics(t, func() { registry.Register(fakeChecker{name: "cache"}, false) })
+}
@@ -0,0 +1,150 @@
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"server/internal/logger"
+	"server/internal/models"
+	"slices"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Stage is a point in a request where hooks run. Every request passes them
+// in this order, each at most once:
+//
+//   - PRE_ROUTE before the request is routed, on every path, websocket and
+//     /.well-known included
+//   - POST_AUTH once the auth middleware has settled who is asking, signed
+//     in or not, on routes behind it
+//   - ON_ERROR after the handler, when it returned an error or answered 400
+//     or above
+//   - PRE_RESPONSE after the handler, on every response
+type Stage string
+
+const (
+	PRE_ROUTE    Stage = "pre_route"
+	POST_AUTH    Stage = "post_auth"
+	ON_ERROR     Stage = "on_error"
+	PRE_RESPONSE Stage = "pre_response"
+)
+
+// Stages lists every stage, in the order a request passes them.
+var Stages = []Stage{PRE_ROUTE, POST_AUTH, ON_ERROR, PRE_RESPONSE}
+
+// Context is what a hook is handed. User and Session are set once the
+// request has been authenticated, so from POST_AUTH on for signed in
+// requests, and nil otherwise.
+type Context struct {
+	Stage   Stage
+	Fiber   *fiber.Ctx
+	User    *models.User
+	Session *models.Session
+	// Err is the error the handler returned, at ON_ERROR and PRE_RESPONSE.
+	// It is nil when the handler wrote its error response itself.
+	Err error
+}
+
+// Hook runs at a stage. It may set and remove headers; returning an error
+// ends the request there, answered by the error handler like an error from
+// any handler, with no later hook of the stage run. A hook can't replace the
+// response on its own: a status or body it writes is put back.
+type Hook func(ctx *Context) error
+
+// Registry holds the hooks of every stage, run in the order they were
+// registered. A nil Registry runs nothing.
+type Registry struct {
+	mutex sync.RWMutex
+	hooks map[Stage][]Hook
+	log   logger.Logger
+}
+
+func New() *Registry {
+	return &Registry{hooks: make(map[Stage][]Hook), log: logger.New("hooks")}
+}
+
+// Register adds hook to stage, after those already there. It panics on an
+// unknown stage, since that is a programming error.
+func (r *Registry) Register(stage Stage, hook Hook) {
+	if !slices.Contains(Stages, stage) {
+		panic(fmt.Sprintf("hooks: unknown stage %q", stage))
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.hooks[stage] = append(r.hooks[stage], hook)
+}
+
+func ranKey(stage Stage) string {
+	return "hooks:" + string(stage)
+}
+
+// Run runs the hooks of stage for the request, unless they already ran for
+// it, and returns the first error one returns. err is the handler's error
+// handed to ON_ERROR and PRE_RESPONSE hooks. A hook that panics is logged and
+// skipped, and the request carries on.
+func (r *Registry) Run(stage Stage, c *fiber.Ctx, err error) error {
+	if r == nil {
+		return nil
+	}
+	if ran, _ := c.Locals(ranKey(stage)).(bool); ran {
+		return nil
+	}
+	c.Locals(ranKey(stage), true)
+
+	r.mutex.RLock()
+	hooks := r.hooks[stage]
+	r.mutex.RUnlock()
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	ctx := &Context{Stage: stage, Fiber: c, Err: err}
+	if user, ok := c.Locals("user").(models.User); ok {
+		ctx.User = &user
+	}
+	if session, ok := c.Locals("session").(models.Session); ok {
+		ctx.Session = &session
+	}
+
+	for i, hook := range hooks {
+		if hookErr := r.call(ctx, i, hook); hookErr != nil {
+			return hookErr
+		}
+	}
+	return nil
+}
+
+// call runs one hook, putting back the status and body when it changed them.
+// A streamed body can't be read without consuming it, so only its status is
+// kept.
+func (r *Registry) call(ctx *Context, index int, hook Hook) (err error) {
+	log := r.log.Function("call")
+	response := ctx.Fiber.Response()
+
+	status := response.StatusCode()
+	streamed := response.IsBodyStream()
+	var body []byte
+	if !streamed {
+		body = bytes.Clone(response.Body())
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Warn("Hook panicked", "stage", ctx.Stage, "hook", index, "path", ctx.Fiber.Path(), "panic", recovered)
+			err = nil
+		}
+
+		if response.StatusCode() != status {
+			log.Warn("Hook changed the status, restored", "stage", ctx.Stage, "hook", index, "status", response.StatusCode())
+			response.SetStatusCode(status)
+		}
+		if !streamed && !response.IsBodyStream() && !bytes.Equal(response.Body(), body) {
+			log.Warn("Hook replaced the body, restored", "stage", ctx.Stage, "hook", index)
+			response.SetBodyRaw(body)
+		}
+	}()
+
+	return hook(ctx)
+}
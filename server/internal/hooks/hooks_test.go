@@ -0,0 +1,63 @@
+package hooks
+
+import (
+	"bufio"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Register_UnknownStage(t *testing.T) {
+	assert.Panics(t, func() {
+		New().Register("post_route", func(ctx *Context) error { return nil })
+	})
+}
+
+func TestRegistry_Run(t *testing.T) {
+	registry := New()
+	runs := 0
+	registry.Register(PRE_RESPONSE, func(ctx *Context) error {
+		runs++
+		return nil
+	})
+
+	var nilRegistry *Registry
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		require.NoError(t, nilRegistry.Run(PRE_RESPONSE, c, nil))
+		require.NoError(t, registry.Run(PRE_RESPONSE, c, nil))
+		require.NoError(t, registry.Run(PRE_RESPONSE, c, nil))
+		return nil
+	})
+
+	_, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 1, runs, "a stage runs once per request")
+}
+
+func TestRegistry_Run_KeepsStreamedBody(t *testing.T) {
+	registry := New()
+	registry.Register(PRE_RESPONSE, func(ctx *Context) error {
+		ctx.Fiber.Set("X-Hook", "ran")
+		return nil
+	})
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			_, _ = w.WriteString("streamed")
+		})
+		return registry.Run(PRE_RESPONSE, c, nil)
+	})
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(body), "the stream isn't read to compare it")
+	assert.Equal(t, "ran", resp.Header.Get("X-Hook"))
+}
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+var componentLevels atomic.Pointer[map[string]slog.Level]
+
+// ParseComponentLevels reads a "websockets=warn,middleware=info" list into
+// per-component minimum levels. Component names are case-insensitive.
+func ParseComponentLevels(spec string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+
+	for entry := range strings.SplitSeq(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		component, levelName, ok := strings.Cut(entry, "=")
+		component = strings.ToLower(strings.TrimSpace(component))
+		if !ok || component == "" {
+			return nil, fmt.Errorf("invalid component level %q, expected component=level", entry)
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(strings.TrimSpace(levelName))); err != nil {
+			return nil, fmt.Errorf("invalid level for component %q: %w", component, err)
+		}
+		levels[component] = level
+	}
+
+	return levels, nil
+}
+
+// SetComponentLevels replaces the per-component minimum levels. It applies to
+// loggers that already exist too. Components without an entry keep the
+// default handler's level.
+func SetComponentLevels(levels map[string]slog.Level) {
+	copied := make(map[string]slog.Level, len(levels))
+	for component, level := range levels {
+		copied[strings.ToLower(component)] = level
+	}
+	componentLevels.Store(&copied)
+}
+
+func componentLevel(component string) (slog.Level, bool) {
+	levels := componentLevels.Load()
+	if levels == nil {
+		return 0, false
+	}
+
+	level, ok := (*levels)[strings.ToLower(component)]
+	return level, ok
+}
+
+// componentHandler lets the configured component level decide what is
+// enabled, falling back to the wrapped handler.
+type componentHandler struct {
+	slog.Handler
+	component string
+}
+
+func (h componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if minimum, ok := componentLevel(h.component); ok {
+		return level >= minimum
+	}
+	return h.Handler.Enabled(ctx, level)
+}
+
+func (h componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return componentHandler{Handler: h.Handler.WithAttrs(attrs), component: h.component}
+}
+
+func (h componentHandler) WithGroup(name string) slog.Handler {
+	return componentHandler{Handler: h.Handler.WithGroup(name), component: h.component}
+}
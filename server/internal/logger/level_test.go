@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBufferedLogger(name string) (Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+	return newWithHandler(name, handler), &buf
+}
+
+func TestParseComponentLevels(t *testing.T) {
+	levels, err := ParseComponentLevels(" websockets=warn, Middleware=DEBUG ,,")
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]slog.Level{
+		"websockets": slog.LevelWarn,
+		"middleware": slog.LevelDebug,
+	}, levels)
+
+	empty, err := ParseComponentLevels("")
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	for _, spec := range []string{"websockets", "=warn", "websockets=loud"} {
+		_, err := ParseComponentLevels(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestComponentLevels_Override(t *testing.T) {
+	t.Cleanup(func() { SetComponentLevels(nil) })
+
+	websockets, websocketsOut := newBufferedLogger("websockets")
+	middleware, middlewareOut := newBufferedLogger("middleware")
+	other, otherOut := newBufferedLogger("other")
+
+	SetComponentLevels(map[string]slog.Level{
+		"WebSockets": slog.LevelWarn,
+		"middleware": slog.LevelDebug,
+	})
+
+	websockets.Function("readPump").Info("chatty")
+	websockets.Warn("important")
+	assert.NotContains(t, websocketsOut.String(), "chatty")
+	assert.Contains(t, websocketsOut.String(), "important")
+
+	middleware.Debug("details")
+	assert.Contains(t, middlewareOut.String(), "details", "an override can lower the level below the handler's")
+
+	other.Debug("hidden")
+	other.Info("shown")
+	assert.NotContains(t, otherOut.String(), "hidden")
+	assert.Contains(t, otherOut.String(), "shown")
+
+	SetComponentLevels(nil)
+	websockets.Info("back")
+	assert.Contains(t, websocketsOut.String(), "back")
+}
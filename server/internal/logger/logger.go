@@ -1,10 +1,12 @@
 package logger
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"server/internal/tracing"
 	"time"
 )
 
@@ -23,6 +25,8 @@ type Logger interface {
 	File(name string) Logger
 	Function(name string) Logger
 	Timer(msg string) func()
+	Sampled(sampler *Sampler) Logger
+	Traced(ctx context.Context) Logger
 }
 
 type SlogLogger struct {
@@ -38,8 +42,21 @@ func New(name string) Logger {
 		handler = slog.Default().Handler()
 	}
 
+	return newWithHandler(name, handler)
+}
+
+// NewWithHandler is New writing to handler, for tests that check what was
+// logged and at which level.
+func NewWithHandler(name string, handler slog.Handler) Logger {
+	return newWithHandler(name, handler)
+}
+
+// newWithHandler names the logger after its component, whose configured
+// level (see SetComponentLevels) decides what gets through to handler.
+func newWithHandler(name string, handler slog.Handler) Logger {
 	return &SlogLogger{
-		logger: slog.New(handler).With("package", name),
+		logger: slog.New(componentHandler{Handler: handler, component: name}).
+			With("package", name),
 	}
 }
 
@@ -71,6 +88,26 @@ func (l *SlogLogger) Function(name string) Logger {
 	return l.With("function", name)
 }
 
+// Sampled returns a logger that lets Debug, Info and Step through sampler.
+// A nil sampler returns the logger unchanged.
+func (l *SlogLogger) Sampled(sampler *Sampler) Logger {
+	if sampler == nil {
+		return l
+	}
+	return sampledLogger{Logger: l, sampler: sampler}
+}
+
+// Traced returns a logger that tags its lines with the trace and span of
+// ctx, so they can be found from a trace. Without a span in ctx it returns
+// the logger unchanged.
+func (l *SlogLogger) Traced(ctx context.Context) Logger {
+	traceID, spanID := tracing.TraceIDs(ctx)
+	if traceID == "" {
+		return l
+	}
+	return l.With("trace_id", traceID, "span_id", spanID)
+}
+
 func (l *SlogLogger) Timer(msg string) func() {
 	start := time.Now()
 	l.logger.Debug("Starting", "operation", msg)
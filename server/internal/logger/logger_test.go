@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"server/internal/tracing"
 	"strings"
 	"testing"
 
@@ -235,3 +236,21 @@ func (h *testHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 func (h *testHandler) WithGroup(name string) slog.Handler {
 	return h
 }
+
+func TestTraced_AddsTraceIDs(t *testing.T) {
+	tracing.Install(tracing.NewTracer(&tracing.Recorder{}, 1))
+	t.Cleanup(func() { tracing.Install(nil) })
+
+	logger, buf := newBufferedLogger("test")
+	ctx, span := tracing.Start(context.Background(), "work")
+	defer span.End()
+
+	logger.Traced(ctx).Info("traced")
+	sc := span.SpanContext()
+	assert.Contains(t, buf.String(), "trace_id="+sc.TraceID.String())
+	assert.Contains(t, buf.String(), "span_id="+sc.SpanID.String())
+
+	buf.Reset()
+	logger.Traced(context.Background()).Info("untraced")
+	assert.NotContains(t, buf.String(), "trace_id")
+}
@@ -0,0 +1,130 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"server/internal/clock"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Sampler lets at most limit entries through per interval. Share one across
+// goroutines to cap a hot path as a whole.
+type Sampler struct {
+	limit    int
+	interval time.Duration
+	clock    clock.Clock
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	count       int
+	suppressed  int
+}
+
+// NewSampler builds a Sampler. A nil clock uses the wall clock.
+func NewSampler(limit int, interval time.Duration, clk clock.Clock) *Sampler {
+	return &Sampler{
+		limit:    limit,
+		interval: interval,
+		clock:    clock.OrDefault(clk),
+	}
+}
+
+// Allow reports whether another entry fits in the current interval, and how
+// many were suppressed since the last one that did.
+func (s *Sampler) Allow() (bool, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	if s.windowStart.IsZero() || now.Sub(s.windowStart) >= s.interval {
+		s.windowStart = now
+		s.count = 0
+	}
+
+	if s.count >= s.limit {
+		s.suppressed++
+		return false, 0
+	}
+
+	s.count++
+	suppressed := s.suppressed
+	s.suppressed = 0
+	return true, suppressed
+}
+
+// sampledLogger runs Debug, Info and Step through a Sampler. Warnings and
+// errors are never dropped.
+type sampledLogger struct {
+	Logger
+	sampler *Sampler
+}
+
+func (l sampledLogger) sample(log func(msg string, args ...any), msg string, args []any) {
+	allowed, suppressed := l.sampler.Allow()
+	if !allowed {
+		return
+	}
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	log(msg, args...)
+}
+
+func (l sampledLogger) Debug(msg string, args ...any) {
+	l.sample(l.Logger.Debug, msg, args)
+}
+
+func (l sampledLogger) Info(msg string, args ...any) {
+	l.sample(l.Logger.Info, msg, args)
+}
+
+func (l sampledLogger) Step(msg string) {
+	l.sample(func(msg string, _ ...any) { l.Logger.Step(msg) }, msg, nil)
+}
+
+func (l sampledLogger) With(args ...any) Logger {
+	return sampledLogger{Logger: l.Logger.With(args...), sampler: l.sampler}
+}
+
+func (l sampledLogger) File(name string) Logger {
+	return sampledLogger{Logger: l.Logger.File(name), sampler: l.sampler}
+}
+
+func (l sampledLogger) Function(name string) Logger {
+	return sampledLogger{Logger: l.Logger.Function(name), sampler: l.sampler}
+}
+
+func (l sampledLogger) Traced(ctx context.Context) Logger {
+	return sampledLogger{Logger: l.Logger.Traced(ctx), sampler: l.sampler}
+}
+
+func (l sampledLogger) Sampled(sampler *Sampler) Logger {
+	return l.Logger.Sampled(sampler)
+}
+
+// Truncate renders a payload for logging, cut to at most maxBytes. Strings
+// are used as they are and anything else is encoded as JSON.
+func Truncate(payload any, maxBytes int) string {
+	text, ok := payload.(string)
+	if !ok {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			text = fmt.Sprintf("%v", payload)
+		} else {
+			text = string(encoded)
+		}
+	}
+
+	if len(text) <= maxBytes {
+		return text
+	}
+
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...[truncated %d bytes]", text[:cut], len(text)-cut)
+}
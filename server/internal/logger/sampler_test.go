@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"server/internal/clock"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampler_LimitsPerInterval(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	sampler := NewSampler(3, time.Second, fake)
+
+	allowed := 0
+	for range 5 {
+		if ok, _ := sampler.Allow(); ok {
+			allowed++
+		}
+	}
+	assert.Equal(t, 3, allowed)
+
+	fake.Advance(999 * time.Millisecond)
+	ok, _ := sampler.Allow()
+	assert.False(t, ok, "still inside the first interval")
+
+	fake.Advance(time.Millisecond)
+	ok, suppressed := sampler.Allow()
+	assert.True(t, ok)
+	assert.Equal(t, 3, suppressed)
+
+	ok, suppressed = sampler.Allow()
+	assert.True(t, ok)
+	assert.Equal(t, 0, suppressed)
+}
+
+func TestSampled_ReportsSuppressed(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	log, out := newBufferedLogger("websockets")
+	sampled := log.Sampled(NewSampler(1, time.Second, fake)).Function("readPump")
+
+	sampled.Info("Read message", "n", 1)
+	sampled.Info("Read message", "n", 2)
+	sampled.Info("Read message", "n", 3)
+	sampled.Warn("never sampled")
+	sampled.Warn("never sampled")
+
+	fake.Advance(time.Second)
+	sampled.Info("Read message", "n", 4)
+
+	output := out.String()
+	assert.Equal(t, 2, strings.Count(output, "Read message"))
+	assert.Equal(t, 2, strings.Count(output, "never sampled"))
+	assert.Contains(t, output, "n=4 suppressed=2")
+	assert.Contains(t, output, "function=readPump")
+}
+
+func TestSampled_NilSampler(t *testing.T) {
+	log := New("test")
+	assert.Same(t, log, log.Sampled(nil))
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", Truncate("short", 10))
+	assert.Equal(t, "0123456789...[truncated 5 bytes]", Truncate("012345678901234", 10))
+
+	// Never splits a multi-byte rune.
+	truncated := Truncate(strings.Repeat("é", 10), 5)
+	assert.True(t, utf8.ValidString(truncated))
+	assert.True(t, strings.HasPrefix(truncated, "éé..."))
+
+	payload := map[string]any{"data": strings.Repeat("x", 100)}
+	truncated = Truncate(payload, 20)
+	assert.True(t, strings.HasPrefix(truncated, `{"data":"xxxxxxxxxxx`))
+	assert.Contains(t, truncated, "[truncated 91 bytes]")
+}
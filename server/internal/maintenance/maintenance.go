@@ -0,0 +1,207 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	"server/internal/models"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	CACHE_KEY       = "maintenance:state"
+	DEFAULT_MESSAGE = "The service is undergoing maintenance, please try again shortly"
+	// RETRY_AFTER is what clients are told to wait before trying again
+	RETRY_AFTER = 5 * time.Minute
+	// UPDATED_BY_CONFIG marks a state that came from MAINTENANCE_ENABLED
+	UPDATED_BY_CONFIG = "config"
+
+	MESSAGE_MAX = 500
+)
+
+// Request is the body of POST /admin/maintenance. Drain also moves this
+// instance's websocket clients off when enabling.
+type Request struct {
+	Enabled *bool  `json:"enabled"`
+	Message string `json:"message"`
+	Drain   bool   `json:"drain"`
+}
+
+// State is the maintenance mode every instance agrees on.
+type State struct {
+	Enabled   bool            `json:"enabled"`
+	Message   string          `json:"message,omitempty"`
+	Since     *models.APITime `json:"since,omitempty"`
+	UpdatedBy string          `json:"updatedBy,omitempty"`
+}
+
+// Transport carries changes between instances. The event bus's
+// MaintenanceTopic implements it on top of valkey pub/sub.
+type Transport interface {
+	Publish(ctx context.Context, payload events.MaintenanceChangedEvent) error
+	Subscribe(handler func(ctx context.Context, payload events.MaintenanceChangedEvent) error) error
+}
+
+// Mode holds this instance's copy of the maintenance state. Changes are
+// written to the cache, so they survive restarts, and published so the other
+// instances switch at the same time.
+type Mode struct {
+	instanceID     string
+	store          database.CacheStore
+	transport      Transport
+	clock          clock.Clock
+	defaultMessage string
+	log            logger.Logger
+
+	mutex     sync.RWMutex
+	state     State
+	listeners []func(State)
+}
+
+// New restores the state. MAINTENANCE_ENABLED wins over the cache; otherwise
+// whatever was last set is picked up again. A nil transport keeps changes on
+// this instance and a nil clock uses the wall clock.
+func New(
+	ctx context.Context,
+	store database.CacheStore,
+	transport Transport,
+	config config.Config,
+	clk clock.Clock,
+) (*Mode, error) {
+	mode := &Mode{
+		instanceID:     uuid.New().String(),
+		store:          store,
+		transport:      transport,
+		clock:          clock.OrDefault(clk),
+		defaultMessage: config.Maintenance.Message,
+		log:            logger.New("maintenance"),
+	}
+	if mode.defaultMessage == "" {
+		mode.defaultMessage = DEFAULT_MESSAGE
+	}
+	log := mode.log.Function("New")
+
+	if transport != nil {
+		if err := transport.Subscribe(mode.receive); err != nil {
+			return nil, log.Err("failed to subscribe to maintenance changes", err)
+		}
+	}
+
+	if config.Maintenance.Enabled {
+		if _, err := mode.Set(ctx, true, config.Maintenance.Message, UPDATED_BY_CONFIG); err != nil {
+			return nil, err
+		}
+		return mode, nil
+	}
+
+	var stored State
+	err := store.Get(ctx, CACHE_KEY, &stored)
+	switch {
+	case errors.Is(err, database.ErrCacheMiss):
+	case err != nil:
+		log.Warn("failed to restore maintenance state, starting without it", "error", err)
+	default:
+		mode.apply(stored)
+		if stored.Enabled {
+			log.Warn("Restored maintenance mode", "since", stored.Since, "updatedBy", stored.UpdatedBy)
+		}
+	}
+
+	return mode, nil
+}
+
+func (m *Mode) State() State {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.state
+}
+
+func (m *Mode) Enabled() bool {
+	return m.State().Enabled
+}
+
+// OnChange registers a hook that runs whenever the state changes, whichever
+// instance changed it.
+func (m *Mode) OnChange(listener func(State)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// Set switches maintenance mode on or off everywhere. An empty message uses
+// the configured one. Nothing changes when the state can't be persisted.
+func (m *Mode) Set(ctx context.Context, enabled bool, message string, updatedBy string) (State, error) {
+	log := m.log.Function("Set")
+
+	state := State{Enabled: enabled, UpdatedBy: updatedBy}
+	if enabled {
+		if message == "" {
+			message = m.defaultMessage
+		}
+		since := models.NewAPITime(m.clock.Now())
+		state.Message = message
+		state.Since = &since
+	}
+
+	if err := m.store.Set(ctx, CACHE_KEY, state, 0); err != nil {
+		return m.State(), log.Err("failed to persist maintenance state", err, "enabled", enabled)
+	}
+
+	m.apply(state)
+	log.Info("Maintenance mode changed", "enabled", enabled, "updatedBy", updatedBy)
+
+	if m.transport == nil {
+		return state, nil
+	}
+
+	err := m.transport.Publish(ctx, events.MaintenanceChangedEvent{
+		Enabled:   state.Enabled,
+		Message:   state.Message,
+		Since:     state.Since,
+		UpdatedBy: state.UpdatedBy,
+		Origin:    m.instanceID,
+	})
+	if err != nil {
+		return state, log.Err("failed to publish maintenance change", err, "enabled", enabled)
+	}
+	return state, nil
+}
+
+func (m *Mode) receive(ctx context.Context, payload events.MaintenanceChangedEvent) error {
+	// Applied when it was made; valkey echoes it back to us.
+	if payload.Origin == m.instanceID {
+		return nil
+	}
+
+	m.log.Function("receive").
+		Info("Applying remote maintenance change", "enabled", payload.Enabled, "updatedBy", payload.UpdatedBy)
+	m.apply(State{
+		Enabled:   payload.Enabled,
+		Message:   payload.Message,
+		Since:     payload.Since,
+		UpdatedBy: payload.UpdatedBy,
+	})
+	return nil
+}
+
+func (m *Mode) apply(state State) {
+	m.mutex.Lock()
+	changed := state.Enabled != m.state.Enabled || state.Message != m.state.Message
+	m.state = state
+	listeners := m.listeners
+	m.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+	for _, listener := range listeners {
+		listener(state)
+	}
+}
@@ -0,0 +1,107 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingStore struct {
+	database.CacheStore
+}
+
+func (failingStore) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return errors.New("valkey unavailable")
+}
+
+func newMode(t *testing.T, store database.CacheStore, bus *events.EventBus, cfg config.Config) *Mode {
+	t.Helper()
+	var transport Transport
+	if bus != nil {
+		transport = bus.MaintenanceTopic()
+	}
+	mode, err := New(context.Background(), store, transport, cfg, clock.NewFake(time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	return mode
+}
+
+func TestMode_SetPropagatesToOtherInstances(t *testing.T) {
+	store := database.NewMemoryCacheStore()
+	bus := events.New(nil, config.Config{}, nil)
+	first := newMode(t, store, bus, config.Config{})
+	second := newMode(t, store, bus, config.Config{})
+
+	changes := make(chan State, 1)
+	second.OnChange(func(state State) { changes <- state })
+
+	state, err := first.Set(context.Background(), true, "Upgrading the database", "admin-1")
+	require.NoError(t, err)
+	assert.True(t, first.Enabled())
+	assert.Equal(t, time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC), state.Since.Time)
+
+	select {
+	case received := <-changes:
+		assert.True(t, received.Enabled)
+		assert.Equal(t, "Upgrading the database", received.Message)
+		assert.Equal(t, "admin-1", received.UpdatedBy)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the change to propagate")
+	}
+	assert.Equal(t, first.State(), second.State())
+
+	_, err = first.Set(context.Background(), false, "", "admin-1")
+	require.NoError(t, err)
+	assert.False(t, (<-changes).Enabled)
+	assert.False(t, second.Enabled())
+}
+
+func TestMode_SurvivesRestart(t *testing.T) {
+	store := database.NewMemoryCacheStore()
+
+	before := newMode(t, store, nil, config.Config{})
+	_, err := before.Set(context.Background(), true, "", "admin-1")
+	require.NoError(t, err)
+
+	// Same cache, fresh process
+	after := newMode(t, store, nil, config.Config{})
+	assert.True(t, after.Enabled())
+	assert.Equal(t, DEFAULT_MESSAGE, after.State().Message)
+	assert.Equal(t, "admin-1", after.State().UpdatedBy)
+
+	_, err = after.Set(context.Background(), false, "", "admin-1")
+	require.NoError(t, err)
+	assert.False(t, newMode(t, store, nil, config.Config{}).Enabled())
+}
+
+func TestMode_ConfigEnablesAtStartup(t *testing.T) {
+	store := database.NewMemoryCacheStore()
+	cfg := config.Config{Maintenance: config.MaintenanceConfig{Enabled: true, Message: "Back at noon"}}
+
+	mode := newMode(t, store, nil, cfg)
+	assert.True(t, mode.Enabled())
+	assert.Equal(t, "Back at noon", mode.State().Message)
+	assert.Equal(t, UPDATED_BY_CONFIG, mode.State().UpdatedBy)
+
+	// Persisted like any other change
+	assert.True(t, newMode(t, store, nil, config.Config{}).Enabled())
+}
+
+func TestMode_SetKeepsStateWhenPersistFails(t *testing.T) {
+	mode := newMode(t, failingStore{database.NewMemoryCacheStore()}, nil, config.Config{})
+
+	called := false
+	mode.OnChange(func(State) { called = true })
+
+	_, err := mode.Set(context.Background(), true, "", "admin-1")
+	assert.Error(t, err)
+	assert.False(t, mode.Enabled())
+	assert.False(t, called)
+}
@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"math"
+	"server/internal/clock"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// Routes tracked on their own, past which requests count under
+	// LATENCY_OTHER_ROUTE
+	DEFAULT_LATENCY_ROUTES = 100
+	LATENCY_OTHER_ROUTE    = "other"
+
+	// Observations are kept per minute for an hour. A route unseen for the
+	// hour is dropped, freeing its place under the cap.
+	LATENCY_SLOT      = time.Minute
+	LATENCY_RETENTION = 60
+
+	// Durations are counted into buckets growing by LATENCY_GROWTH from
+	// LATENCY_FLOOR, so a percentile is within half a bucket, about 2.5%, of
+	// the true value. Beyond a minute they all land in the last bucket.
+	LATENCY_FLOOR  = 100 * time.Microsecond
+	LATENCY_GROWTH = 1.05
+)
+
+// LatencyWindows are the rolling windows Snapshot reports for each route, by
+// name and length in minutes.
+var LatencyWindows = []struct {
+	Name    string
+	Minutes int64
+}{
+	{"5m", 5},
+	{"15m", 15},
+	{"60m", 60},
+}
+
+var latencyBuckets = int(math.Ceil(math.Log(float64(time.Minute/LATENCY_FLOOR))/math.Log(LATENCY_GROWTH))) + 1
+
+// latencyBucket is the bucket duration falls in: 0 for up to LATENCY_FLOOR,
+// then i for up to LATENCY_FLOOR * LATENCY_GROWTH^i.
+func latencyBucket(duration time.Duration) int {
+	if duration <= LATENCY_FLOOR {
+		return 0
+	}
+	bucket := int(math.Ceil(math.Log(float64(duration)/float64(LATENCY_FLOOR)) / math.Log(LATENCY_GROWTH)))
+	return min(bucket, latencyBuckets-1)
+}
+
+// latencyValue is the duration reported for bucket, the geometric middle of
+// its bounds.
+func latencyValue(bucket int) time.Duration {
+	if bucket == 0 {
+		return LATENCY_FLOOR
+	}
+	return time.Duration(float64(LATENCY_FLOOR) * math.Pow(LATENCY_GROWTH, float64(bucket)-0.5))
+}
+
+// latencySlot is one minute of a route's observations.
+type latencySlot struct {
+	minute int64
+	count  uint64
+	counts []uint32
+}
+
+type latencyRoute struct {
+	lastMinute int64
+	slots      [LATENCY_RETENTION]*latencySlot
+}
+
+// LatencyTracker keeps rolling latency percentiles per route in process, for
+// when there's no Prometheus to scrape the metrics endpoint. Memory is bounded
+// by the route cap and the hour of minutes kept per route.
+type LatencyTracker struct {
+	maxRoutes int
+	clock     clock.Clock
+	mutex     sync.Mutex
+	routes    map[string]*latencyRoute
+}
+
+// NewLatencyTracker tracks up to maxRoutes routes on their own,
+// DEFAULT_LATENCY_ROUTES when it isn't positive.
+func NewLatencyTracker(maxRoutes int, clk clock.Clock) *LatencyTracker {
+	if maxRoutes <= 0 {
+		maxRoutes = DEFAULT_LATENCY_ROUTES
+	}
+	return &LatencyTracker{
+		maxRoutes: maxRoutes,
+		clock:     clock.OrDefault(clk),
+		routes:    make(map[string]*latencyRoute),
+	}
+}
+
+func (t *LatencyTracker) minute() int64 {
+	return t.clock.Now().Unix() / int64(LATENCY_SLOT/time.Second)
+}
+
+// Observe records a request to route that took duration. A nil tracker
+// records nothing.
+func (t *LatencyTracker) Observe(route string, duration time.Duration) {
+	if t == nil {
+		return
+	}
+	minute := t.minute()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	tracked, ok := t.routes[route]
+	if !ok {
+		if len(t.routes) >= t.maxRoutes {
+			t.pruneStale(minute)
+		}
+		// other doesn't count against the cap, so it always has a place
+		if len(t.routes) >= t.maxRoutes && route != LATENCY_OTHER_ROUTE {
+			route = LATENCY_OTHER_ROUTE
+			tracked = t.routes[route]
+		}
+		if tracked == nil {
+			tracked = &latencyRoute{}
+			t.routes[route] = tracked
+		}
+	}
+
+	slot := tracked.slots[minute%LATENCY_RETENTION]
+	if slot == nil {
+		slot = &latencySlot{counts: make([]uint32, latencyBuckets)}
+		tracked.slots[minute%LATENCY_RETENTION] = slot
+	}
+	if slot.minute != minute {
+		// Left from an earlier hour
+		slot.minute = minute
+		slot.count = 0
+		clear(slot.counts)
+	}
+	slot.count++
+	slot.counts[latencyBucket(duration)]++
+	tracked.lastMinute = minute
+}
+
+// pruneStale drops routes unseen for the retention. Callers hold the mutex.
+func (t *LatencyTracker) pruneStale(minute int64) {
+	for route, tracked := range t.routes {
+		if minute-tracked.lastMinute >= LATENCY_RETENTION {
+			delete(t.routes, route)
+		}
+	}
+}
+
+// LatencyWindow is a route's requests over one of the LatencyWindows.
+type LatencyWindow struct {
+	Count uint64  `json:"count"`
+	P50Ms float64 `json:"p50Ms"`
+	P90Ms float64 `json:"p90Ms"`
+	P99Ms float64 `json:"p99Ms"`
+}
+
+type LatencyRoute struct {
+	Route   string                   `json:"route"`
+	Windows map[string]LatencyWindow `json:"windows"`
+}
+
+// Snapshot reports every route seen within the retention, by name, with
+// other last.
+func (t *LatencyTracker) Snapshot() []LatencyRoute {
+	if t == nil {
+		return []LatencyRoute{}
+	}
+	minute := t.minute()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.pruneStale(minute)
+
+	snapshot := make([]LatencyRoute, 0, len(t.routes))
+	for route, tracked := range t.routes {
+		windows := make(map[string]LatencyWindow, len(LatencyWindows))
+		for _, window := range LatencyWindows {
+			windows[window.Name] = tracked.window(minute, window.Minutes)
+		}
+		snapshot = append(snapshot, LatencyRoute{Route: route, Windows: windows})
+	}
+
+	slices.SortFunc(snapshot, func(a, b LatencyRoute) int {
+		switch {
+		case a.Route == b.Route:
+			return 0
+		case a.Route == LATENCY_OTHER_ROUTE:
+			return 1
+		case b.Route == LATENCY_OTHER_ROUTE:
+			return -1
+		case a.Route < b.Route:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return snapshot
+}
+
+// window merges the slots of the last minutes, the current one included.
+func (r *latencyRoute) window(minute int64, minutes int64) LatencyWindow {
+	var result LatencyWindow
+	counts := make([]uint64, latencyBuckets)
+	for _, slot := range r.slots {
+		if slot == nil || slot.minute > minute || minute-slot.minute >= minutes {
+			continue
+		}
+		result.Count += slot.count
+		for i, count := range slot.counts {
+			counts[i] += uint64(count)
+		}
+	}
+	if result.Count == 0 {
+		return result
+	}
+
+	result.P50Ms = percentile(counts, result.Count, 0.50)
+	result.P90Ms = percentile(counts, result.Count, 0.90)
+	result.P99Ms = percentile(counts, result.Count, 0.99)
+	return result
+}
+
+// percentile is the value in milliseconds of the bucket holding the q-th
+// observation of total, by nearest rank.
+func percentile(counts []uint64, total uint64, q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for bucket, count := range counts {
+		seen += count
+		if seen >= rank {
+			return float64(latencyValue(bucket)) / float64(time.Millisecond)
+		}
+	}
+	return float64(latencyValue(len(counts)-1)) / float64(time.Millisecond)
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"server/internal/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLatencyTracker(maxRoutes int) (*LatencyTracker, *clock.Fake) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	return NewLatencyTracker(maxRoutes, fake), fake
+}
+
+func latencyWindows(t *testing.T, tracker *LatencyTracker, route string) map[string]LatencyWindow {
+	t.Helper()
+	for _, tracked := range tracker.Snapshot() {
+		if tracked.Route == route {
+			return tracked.Windows
+		}
+	}
+	t.Fatalf("route %q not tracked", route)
+	return nil
+}
+
+func TestLatencyTracker_Percentiles(t *testing.T) {
+	tracker, _ := newTestLatencyTracker(0)
+
+	// 1ms to 1s in even steps, so the q-th percentile is q seconds
+	for i := 1; i <= 1000; i++ {
+		tracker.Observe("GET /users", time.Duration(i)*time.Millisecond)
+	}
+
+	window := latencyWindows(t, tracker, "GET /users")["5m"]
+	assert.Equal(t, uint64(1000), window.Count)
+	assert.InEpsilon(t, 500, window.P50Ms, 0.03)
+	assert.InEpsilon(t, 900, window.P90Ms, 0.03)
+	assert.InEpsilon(t, 990, window.P99Ms, 0.03)
+}
+
+func TestLatencyTracker_Extremes(t *testing.T) {
+	tracker, _ := newTestLatencyTracker(0)
+
+	tracker.Observe("fast", 0)
+	tracker.Observe("slow", time.Hour)
+
+	assert.Equal(t, float64(LATENCY_FLOOR)/float64(time.Millisecond), latencyWindows(t, tracker, "fast")["5m"].P99Ms)
+	// Past a minute durations are only known to be long
+	assert.InEpsilon(t, float64(time.Minute/time.Millisecond), latencyWindows(t, tracker, "slow")["5m"].P50Ms, 0.03)
+}
+
+func TestLatencyTracker_WindowRollover(t *testing.T) {
+	tracker, fake := newTestLatencyTracker(0)
+
+	tracker.Observe("GET /users", 10*time.Millisecond)
+	fake.Advance(4 * time.Minute)
+	tracker.Observe("GET /users", 100*time.Millisecond)
+
+	windows := latencyWindows(t, tracker, "GET /users")
+	assert.Equal(t, uint64(2), windows["5m"].Count)
+	assert.Equal(t, uint64(2), windows["60m"].Count)
+
+	// The first request leaves the 5 minute window
+	fake.Advance(time.Minute)
+	windows = latencyWindows(t, tracker, "GET /users")
+	assert.Equal(t, uint64(1), windows["5m"].Count)
+	assert.InEpsilon(t, 100, windows["5m"].P50Ms, 0.03)
+	assert.Equal(t, uint64(2), windows["15m"].Count)
+
+	// An hour after the first request its minute is reused, not added to
+	fake.Advance(55 * time.Minute)
+	tracker.Observe("GET /users", time.Second)
+	windows = latencyWindows(t, tracker, "GET /users")
+	assert.Equal(t, uint64(2), windows["60m"].Count)
+	assert.Equal(t, uint64(1), windows["5m"].Count)
+	assert.InEpsilon(t, 1000, windows["5m"].P50Ms, 0.03)
+}
+
+func TestLatencyTracker_StaleRoutesDropped(t *testing.T) {
+	tracker, fake := newTestLatencyTracker(0)
+
+	tracker.Observe("GET /users", time.Millisecond)
+	fake.Advance(59 * time.Minute)
+	require.Len(t, tracker.Snapshot(), 1)
+
+	fake.Advance(time.Minute)
+	assert.Empty(t, tracker.Snapshot())
+}
+
+func TestLatencyTracker_RouteCap(t *testing.T) {
+	tracker, fake := newTestLatencyTracker(2)
+
+	for _, route := range []string{"a", "b", "c", "d", "a"} {
+		tracker.Observe(route, time.Millisecond)
+	}
+
+	snapshot := tracker.Snapshot()
+	require.Len(t, snapshot, 3)
+	assert.Equal(t, "a", snapshot[0].Route)
+	assert.Equal(t, uint64(2), snapshot[0].Windows["60m"].Count)
+	assert.Equal(t, "b", snapshot[1].Route)
+	assert.Equal(t, LATENCY_OTHER_ROUTE, snapshot[2].Route)
+	assert.Equal(t, uint64(2), snapshot[2].Windows["60m"].Count)
+
+	// Once a route goes stale its place is free again
+	fake.Advance(30 * time.Minute)
+	tracker.Observe("a", time.Millisecond)
+	fake.Advance(30 * time.Minute)
+	tracker.Observe("e", time.Millisecond)
+
+	routes := []string{}
+	for _, tracked := range tracker.Snapshot() {
+		routes = append(routes, tracked.Route)
+	}
+	assert.Equal(t, []string{"a", "e"}, routes)
+}
+
+func TestLatencyTracker_Nil(t *testing.T) {
+	var tracker *LatencyTracker
+
+	tracker.Observe("GET /users", time.Millisecond)
+	assert.Empty(t, tracker.Snapshot())
+}
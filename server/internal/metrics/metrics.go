@@ -0,0 +1,411 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CONTENT_TYPE is the media type of the text WriteText produces.
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector is anything the registry can write in the Prometheus text
+// exposition format.
+type Collector interface {
+	Name() string
+	WriteText(w io.Writer) error
+}
+
+// Registry holds the collectors served by the metrics endpoint, in the order
+// they were registered.
+type Registry struct {
+	mutex      sync.RWMutex
+	collectors []Collector
+}
+
+// Default is the registry the admin metrics endpoint serves. Packages
+// register their collectors with it from init.
+var Default = &Registry{}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds collector to the registry. It panics when the name is
+// already taken, since that is a programming error.
+func (r *Registry) Register(collector Collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, existing := range r.collectors {
+		if existing.Name() == collector.Name() {
+			panic(fmt.Sprintf("metrics: %q registered twice", collector.Name()))
+		}
+	}
+	r.collectors = append(r.collectors, collector)
+}
+
+// WriteText writes every collector in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.RLock()
+	collectors := slices.Clone(r.collectors)
+	r.mutex.RUnlock()
+
+	for _, collector := range collectors {
+		if err := collector.WriteText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register adds collector to the default registry.
+func Register(collector Collector) {
+	Default.Register(collector)
+}
+
+// DefaultLatencyBuckets are upper bounds in seconds, from a cached lookup to
+// a bcrypt comparison at a high cost.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Histogram counts durations into buckets, split by the value of a single
+// label.
+type Histogram struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*HistogramSnapshot
+}
+
+// HistogramSnapshot is one label value's observations. Counts[i] is the
+// number that fell at or below Buckets[i]; the rest are only in Count.
+type HistogramSnapshot struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+// NewHistogram creates a histogram over buckets, which must be ascending.
+func NewHistogram(name string, help string, label string, buckets []float64) *Histogram {
+	if !slices.IsSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %q are not ascending", name))
+	}
+	return &Histogram{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: slices.Clone(buckets),
+		series:  make(map[string]*HistogramSnapshot),
+	}
+}
+
+func (h *Histogram) Name() string {
+	return h.name
+}
+
+// Observe records duration for the label value.
+func (h *Histogram) Observe(labelValue string, duration time.Duration) {
+	seconds := duration.Seconds()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	series, ok := h.series[labelValue]
+	if !ok {
+		series = &HistogramSnapshot{Buckets: h.buckets, Counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = series
+	}
+	// Counts are cumulative, as the exposition format expects
+	for i, bound := range h.buckets {
+		if seconds <= bound {
+			series.Counts[i]++
+		}
+	}
+	series.Count++
+	series.Sum += seconds
+}
+
+// Snapshot copies the observations so far, by label value.
+func (h *Histogram) Snapshot() map[string]HistogramSnapshot {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	snapshot := make(map[string]HistogramSnapshot, len(h.series))
+	for labelValue, series := range h.series {
+		copied := *series
+		copied.Counts = slices.Clone(series.Counts)
+		snapshot[labelValue] = copied
+	}
+	return snapshot
+}
+
+func (h *Histogram) WriteText(w io.Writer) error {
+	snapshot := h.Snapshot()
+	labelValues := make([]string, 0, len(snapshot))
+	for labelValue := range snapshot {
+		labelValues = append(labelValues, labelValue)
+	}
+	slices.Sort(labelValues)
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+		return err
+	}
+	for _, labelValue := range labelValues {
+		series := snapshot[labelValue]
+		label := fmt.Sprintf("%s=%s", h.label, strconv.Quote(labelValue))
+		for i, bound := range series.Buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", h.name, label, formatFloat(bound), series.Counts[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w,
+			"%s_bucket{%s,le=\"+Inf\"} %d\n%s_sum{%s} %s\n%s_count{%s} %d\n",
+			h.name, label, series.Count,
+			h.name, label, formatFloat(series.Sum),
+			h.name, label, series.Count,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gauge is a single value that can go up and down, such as the size of a
+// table.
+type Gauge struct {
+	name string
+	help string
+	bits atomic.Uint64
+}
+
+func NewGauge(name string, help string) *Gauge {
+	return &Gauge{name: name, help: help}
+}
+
+func (g *Gauge) Name() string {
+	return g.name
+}
+
+func (g *Gauge) Set(value float64) {
+	g.bits.Store(math.Float64bits(value))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n",
+		g.name, g.help, g.name, g.name, formatFloat(g.Value()))
+	return err
+}
+
+// LabeledGauge is a gauge per value of a single label, such as requests in
+// flight per route group.
+type LabeledGauge struct {
+	name   string
+	help   string
+	label  string
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func NewLabeledGauge(name string, help string, label string) *LabeledGauge {
+	return &LabeledGauge{name: name, help: help, label: label, values: make(map[string]float64)}
+}
+
+func (g *LabeledGauge) Name() string {
+	return g.name
+}
+
+func (g *LabeledGauge) Set(labelValue string, value float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.values[labelValue] = value
+}
+
+// Value is the label value's gauge, 0 if it was never set.
+func (g *LabeledGauge) Value(labelValue string) float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.values[labelValue]
+}
+
+func (g *LabeledGauge) WriteText(w io.Writer) error {
+	g.mutex.Lock()
+	values := maps.Clone(g.values)
+	g.mutex.Unlock()
+
+	labelValues := make([]string, 0, len(values))
+	for labelValue := range values {
+		labelValues = append(labelValues, labelValue)
+	}
+	slices.Sort(labelValues)
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name); err != nil {
+		return err
+	}
+	for _, labelValue := range labelValues {
+		if _, err := fmt.Fprintf(w, "%s{%s=%s} %s\n",
+			g.name, g.label, strconv.Quote(labelValue), formatFloat(values[labelValue])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counter is a total that only goes up, such as the number of rows a job has
+// deleted since the process started.
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+func NewCounter(name string, help string) *Counter {
+	return &Counter{name: name, help: help}
+}
+
+func (c *Counter) Name() string {
+	return c.name
+}
+
+func (c *Counter) Add(delta uint64) {
+	c.value.Add(delta)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+func (c *Counter) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n",
+		c.name, c.help, c.name, c.name, c.Value())
+	return err
+}
+
+// LabeledCounter is a counter per value of a single label, such as cache hits
+// per key namespace.
+type LabeledCounter struct {
+	name   string
+	help   string
+	label  string
+	mutex  sync.Mutex
+	values map[string]uint64
+}
+
+func NewLabeledCounter(name string, help string, label string) *LabeledCounter {
+	return &LabeledCounter{name: name, help: help, label: label, values: make(map[string]uint64)}
+}
+
+func (c *LabeledCounter) Name() string {
+	return c.name
+}
+
+func (c *LabeledCounter) Add(labelValue string, delta uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[labelValue] += delta
+}
+
+// Value is the label value's total, 0 if it was never added to.
+func (c *LabeledCounter) Value(labelValue string) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.values[labelValue]
+}
+
+func (c *LabeledCounter) WriteText(w io.Writer) error {
+	c.mutex.Lock()
+	values := maps.Clone(c.values)
+	c.mutex.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+	for _, labelValue := range slices.Sorted(maps.Keys(values)) {
+		if _, err := fmt.Fprintf(w, "%s{%s=%s} %d\n",
+			c.name, c.label, strconv.Quote(labelValue), values[labelValue]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MultiLabelCounter is a counter per combination of the values of several
+// labels, such as requests per client type and version. Values are given in
+// the order the labels were.
+type MultiLabelCounter struct {
+	name   string
+	help   string
+	labels []string
+	mutex  sync.Mutex
+	values map[string]uint64
+}
+
+func NewMultiLabelCounter(name string, help string, labels ...string) *MultiLabelCounter {
+	return &MultiLabelCounter{name: name, help: help, labels: labels, values: make(map[string]uint64)}
+}
+
+func (c *MultiLabelCounter) Name() string {
+	return c.name
+}
+
+// Add panics unless there is a value for every label, since that is a
+// programming error.
+func (c *MultiLabelCounter) Add(delta uint64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[key] += delta
+}
+
+// Value is the combination's total, 0 if it was never added to.
+func (c *MultiLabelCounter) Value(labelValues ...string) uint64 {
+	key := c.key(labelValues)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.values[key]
+}
+
+// key joins the values with a byte no header or label value carries.
+func (c *MultiLabelCounter) key(labelValues []string) string {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", c.name, len(c.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\x00")
+}
+
+func (c *MultiLabelCounter) WriteText(w io.Writer) error {
+	c.mutex.Lock()
+	values := maps.Clone(c.values)
+	c.mutex.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		pairs := make([]string, len(c.labels))
+		for i, labelValue := range strings.Split(key, "\x00") {
+			pairs[i] = c.labels[i] + "=" + strconv.Quote(labelValue)
+		}
+		if _, err := fmt.Fprintf(w, "%s{%s} %d\n", c.name, strings.Join(pairs, ","), values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
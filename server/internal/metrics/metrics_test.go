@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram_Observe(t *testing.T) {
+	histogram := NewHistogram("test_seconds", "Test durations.", "outcome", []float64{0.1, 1})
+
+	histogram.Observe("ok", 50*time.Millisecond)
+	histogram.Observe("ok", 500*time.Millisecond)
+	histogram.Observe("ok", 2*time.Second)
+	histogram.Observe("failed", time.Second)
+
+	snapshot := histogram.Snapshot()
+	require.Len(t, snapshot, 2)
+
+	ok := snapshot["ok"]
+	assert.Equal(t, []uint64{1, 2}, ok.Counts)
+	assert.Equal(t, uint64(3), ok.Count)
+	assert.InDelta(t, 2.55, ok.Sum, 1e-9)
+
+	// Bounds are inclusive
+	assert.Equal(t, []uint64{0, 1}, snapshot["failed"].Counts)
+}
+
+func TestHistogram_SnapshotIsACopy(t *testing.T) {
+	histogram := NewHistogram("test_seconds", "Test durations.", "outcome", []float64{1})
+	histogram.Observe("ok", time.Millisecond)
+
+	snapshot := histogram.Snapshot()
+	histogram.Observe("ok", time.Millisecond)
+
+	assert.Equal(t, []uint64{1}, snapshot["ok"].Counts)
+	assert.Equal(t, uint64(2), histogram.Snapshot()["ok"].Count)
+}
+
+func TestRegistry_WriteText(t *testing.T) {
+	registry := NewRegistry()
+	histogram := NewHistogram("test_seconds", "Test durations.", "outcome", []float64{0.25, 1})
+	registry.Register(histogram)
+
+	histogram.Observe("success", 100*time.Millisecond)
+	histogram.Observe("error", 3*time.Second)
+
+	var text strings.Builder
+	require.NoError(t, registry.WriteText(&text))
+
+	assert.Equal(t, `# HELP test_seconds Test durations.
+# TYPE test_seconds histogram
+test_seconds_bucket{outcome="error",le="0.25"} 0
+test_seconds_bucket{outcome="error",le="1"} 0
+test_seconds_bucket{outcome="error",le="+Inf"} 1
+test_seconds_sum{outcome="error"} 3
+test_seconds_count{outcome="error"} 1
+test_seconds_bucket{outcome="success",le="0.25"} 1
+test_seconds_bucket{outcome="success",le="1"} 1
+test_seconds_bucket{outcome="success",le="+Inf"} 1
+test_seconds_sum{outcome="success"} 0.1
+test_seconds_count{outcome="success"} 1
+`, text.String())
+}
+
+func TestRegistry_RejectsDuplicateNames(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(NewHistogram("test_seconds", "", "outcome", DefaultLatencyBuckets))
+
+	assert.Panics(t, func() {
+		registry.Register(NewHistogram("test_seconds", "", "outcome", DefaultLatencyBuckets))
+	})
+	assert.Panics(t, func() {
+		NewHistogram("unsorted_seconds", "", "outcome", []float64{1, 0.5})
+	})
+}
+
+func TestGaugeAndCounter_WriteText(t *testing.T) {
+	registry := NewRegistry()
+	gauge := NewGauge("test_rows", "Rows in the test table.")
+	counter := NewCounter("test_deleted_total", "Rows deleted.")
+	registry.Register(gauge)
+	registry.Register(counter)
+
+	gauge.Set(12)
+	gauge.Set(7.5)
+	counter.Add(3)
+	counter.Add(2)
+
+	var text strings.Builder
+	require.NoError(t, registry.WriteText(&text))
+
+	assert.Equal(t, `# HELP test_rows Rows in the test table.
+# TYPE test_rows gauge
+test_rows 7.5
+# HELP test_deleted_total Rows deleted.
+# TYPE test_deleted_total counter
+test_deleted_total 5
+`, text.String())
+}
+
+func TestLabeledGauge_WriteText(t *testing.T) {
+	registry := NewRegistry()
+	gauge := NewLabeledGauge("test_in_flight", "Requests in flight.", "group")
+	registry.Register(gauge)
+
+	gauge.Set("import", 3)
+	gauge.Set("broadcast", 1)
+	gauge.Set("import", 2)
+
+	assert.Equal(t, float64(2), gauge.Value("import"))
+	assert.Equal(t, float64(0), gauge.Value("backup"))
+
+	var text strings.Builder
+	require.NoError(t, registry.WriteText(&text))
+
+	assert.Equal(t, `# HELP test_in_flight Requests in flight.
+# TYPE test_in_flight gauge
+test_in_flight{group="broadcast"} 1
+test_in_flight{group="import"} 2
+`, text.String())
+}
+
+func TestLabeledCounter_WriteText(t *testing.T) {
+	registry := NewRegistry()
+	counter := NewLabeledCounter("test_hits_total", "Cache hits.", "namespace")
+	registry.Register(counter)
+
+	counter.Add("user", 2)
+	counter.Add("session", 1)
+	counter.Add("user", 3)
+
+	assert.Equal(t, uint64(5), counter.Value("user"))
+	assert.Equal(t, uint64(0), counter.Value("ratelimit"))
+
+	var text strings.Builder
+	require.NoError(t, registry.WriteText(&text))
+
+	assert.Equal(t, `# HELP test_hits_total Cache hits.
+# TYPE test_hits_total counter
+test_hits_total{namespace="session"} 1
+test_hits_total{namespace="user"} 5
+`, text.String())
+}
+
+func TestMultiLabelCounter_WriteText(t *testing.T) {
+	registry := NewRegistry()
+	counter := NewMultiLabelCounter("test_requests_total", "Requests.", "client_type", "client_version")
+	registry.Register(counter)
+
+	counter.Add(2, "solid", "1.2.0")
+	counter.Add(1, "flutter", "none")
+	counter.Add(1, "solid", "1.2.0")
+
+	assert.Equal(t, uint64(3), counter.Value("solid", "1.2.0"))
+	assert.Equal(t, uint64(0), counter.Value("solid", "1.3.0"))
+	assert.Panics(t, func() { counter.Add(1, "solid") })
+
+	var text strings.Builder
+	require.NoError(t, registry.WriteText(&text))
+
+	assert.Equal(t, `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{client_type="flutter",client_version="none"} 1
+test_requests_total{client_type="solid",client_version="1.2.0"} 3
+`, text.String())
+}
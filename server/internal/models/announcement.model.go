@@ -0,0 +1,103 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ANNOUNCEMENT_SEVERITY_INFO     = "info"
+	ANNOUNCEMENT_SEVERITY_WARNING  = "warning"
+	ANNOUNCEMENT_SEVERITY_CRITICAL = "critical"
+
+	ANNOUNCEMENT_TITLE_MAX = 120
+	ANNOUNCEMENT_BODY_MAX  = 2000
+
+	// Who sees an announcement: everyone, admins only, or the users listed
+	// in UserIDs
+	ANNOUNCEMENT_AUDIENCE_ALL    = "all"
+	ANNOUNCEMENT_AUDIENCE_ADMINS = "admins"
+	ANNOUNCEMENT_AUDIENCE_USERS  = "users"
+
+	ANNOUNCEMENT_USER_IDS_MAX = 500
+)
+
+var AnnouncementSeverities = []string{
+	ANNOUNCEMENT_SEVERITY_INFO,
+	ANNOUNCEMENT_SEVERITY_WARNING,
+	ANNOUNCEMENT_SEVERITY_CRITICAL,
+}
+
+var AnnouncementAudiences = []string{
+	ANNOUNCEMENT_AUDIENCE_ALL,
+	ANNOUNCEMENT_AUDIENCE_ADMINS,
+	ANNOUNCEMENT_AUDIENCE_USERS,
+}
+
+// Announcement is a system banner pushed to connected clients and kept until
+// it expires so clients that connect later still see it.
+type Announcement struct {
+	BaseModel
+	Title     string    `gorm:"type:text;not null"             json:"title"`
+	Body      string    `gorm:"type:text;not null"             json:"body"`
+	Severity  string    `gorm:"type:text;not null"             json:"severity"`
+	ExpiresAt time.Time `gorm:"index;not null"                 json:"expiresAt"`
+	CreatedBy string    `gorm:"type:text"                      json:"createdBy"`
+	Audience  string    `gorm:"type:text;not null;default:all" json:"audience"`
+	UserIDs   []string  `gorm:"serializer:json"                json:"userIds,omitempty"`
+}
+
+// VisibleTo reports whether the user sees the announcement. A signed out
+// visitor has no userID and sees only announcements for everyone.
+func (a Announcement) VisibleTo(userID string, isAdmin bool) bool {
+	return AudienceIncludes(a.Audience, a.UserIDs, userID, isAdmin)
+}
+
+// AudienceIncludes reports whether the user is in the audience. An empty
+// audience is everyone, as for announcements stored before audiences.
+func AudienceIncludes(audience string, userIDs []string, userID string, isAdmin bool) bool {
+	switch audience {
+	case "", ANNOUNCEMENT_AUDIENCE_ALL:
+		return true
+	case ANNOUNCEMENT_AUDIENCE_ADMINS:
+		return userID != "" && isAdmin
+	case ANNOUNCEMENT_AUDIENCE_USERS:
+		return userID != "" && slices.Contains(userIDs, userID)
+	}
+	return false
+}
+
+func (a *Announcement) BeforeSave(tx *gorm.DB) error {
+	a.ExpiresAt = a.ExpiresAt.UTC()
+	return a.BaseModel.BeforeSave(tx)
+}
+
+func (a *Announcement) AfterFind(tx *gorm.DB) error {
+	a.ExpiresAt = a.ExpiresAt.UTC()
+	return a.BaseModel.AfterFind(tx)
+}
+
+// MarshalJSON writes the times as APITime.
+func (a Announcement) MarshalJSON() ([]byte, error) {
+	type announcement Announcement
+	return json.Marshal(struct {
+		announcement
+		CreatedAt APITime `json:"createdAt"`
+		UpdatedAt APITime `json:"updatedAt"`
+		ExpiresAt APITime `json:"expiresAt"`
+	}{announcement(a), NewAPITime(a.CreatedAt), NewAPITime(a.UpdatedAt), NewAPITime(a.ExpiresAt)})
+}
+
+// AnnouncementRequest is sent to everyone unless it names an audience. A list
+// of userIds without an audience is for those users.
+type AnnouncementRequest struct {
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	Severity  string    `json:"severity"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Audience  string    `json:"audience"`
+	UserIDs   []string  `json:"userIds"`
+}
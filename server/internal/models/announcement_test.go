@@ -0,0 +1,34 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudienceIncludes(t *testing.T) {
+	listed := []string{"user-1"}
+
+	testCases := []struct {
+		name     string
+		audience string
+		userID   string
+		isAdmin  bool
+		expected bool
+	}{
+		{"all anonymous", ANNOUNCEMENT_AUDIENCE_ALL, "", false, true},
+		{"unset is all", "", "", false, true},
+		{"admins admin", ANNOUNCEMENT_AUDIENCE_ADMINS, "admin-1", true, true},
+		{"admins user", ANNOUNCEMENT_AUDIENCE_ADMINS, "user-1", false, false},
+		{"admins anonymous", ANNOUNCEMENT_AUDIENCE_ADMINS, "", true, false},
+		{"users listed", ANNOUNCEMENT_AUDIENCE_USERS, "user-1", false, true},
+		{"users other", ANNOUNCEMENT_AUDIENCE_USERS, "user-2", true, false},
+		{"users anonymous", ANNOUNCEMENT_AUDIENCE_USERS, "", false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, AudienceIncludes(tc.audience, listed, tc.userID, tc.isAdmin))
+		})
+	}
+}
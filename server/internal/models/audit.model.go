@@ -0,0 +1,120 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	AUDIT_ACTION_IMPERSONATION_STARTED = "impersonation.started"
+	AUDIT_ACTION_IMPERSONATION_STOPPED = "impersonation.stopped"
+	AUDIT_ACTION_CACHE_FLUSHED         = "cache.flushed"
+	AUDIT_ACTION_CONFIG_RELOADED       = "config.reloaded"
+	AUDIT_ACTION_FORCE_LOGOUT          = "user.force_logout"
+	AUDIT_ACTION_CAPACITY_ALERT        = "capacity.alert"
+
+	// Changes made through the HTTP API
+	AUDIT_SOURCE_API = "api"
+	// Settings changed by reloading the configuration
+	AUDIT_SOURCE_CONFIG_RELOAD = "config_reload"
+	// Raised by the service itself, such as a capacity alert
+	AUDIT_SOURCE_SYSTEM = "system"
+
+	AUDIT_LOG_PAGE_SIZE = 50
+)
+
+// AuditLog records an action someone took, for later review. Entries are
+// append only, so unlike BaseModel there is no UpdatedAt.
+type AuditLog struct {
+	ID        string         `gorm:"type:text;primaryKey"          json:"id"`
+	CreatedAt time.Time      `gorm:"autoCreateTime;index;not null" json:"createdAt"`
+	Action    string         `gorm:"type:text;not null"            json:"action"`
+	Source    string         `gorm:"type:text;not null"            json:"source"`
+	ActorID   string         `gorm:"type:text;index"               json:"actorId"`
+	TargetID  string         `gorm:"type:text"                     json:"targetId,omitempty"`
+	IP        string         `gorm:"type:text"                     json:"ip,omitempty"`
+	Details   map[string]any `gorm:"serializer:json"               json:"details,omitempty"`
+}
+
+// AuditLogPage is a page of the audit log. NextCursor fetches the page after
+// it, and is empty on the last.
+type AuditLogPage struct {
+	Entries    []AuditLog `json:"entries"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+	HasMore    bool       `json:"hasMore"`
+}
+
+// AuditArchive is a gzip JSON lines file holding audit entries that were
+// removed from the table once they passed the retention window. Checksum is
+// the SHA-256 of the file as written.
+type AuditArchive struct {
+	ID        string    `gorm:"type:text;primaryKey"      json:"id"`
+	CreatedAt time.Time `gorm:"autoCreateTime;not null"   json:"createdAt"`
+	File      string    `gorm:"type:text;not null;unique" json:"file"`
+	OldestAt  time.Time `gorm:"not null"                  json:"oldestAt"`
+	NewestAt  time.Time `gorm:"not null"                  json:"newestAt"`
+	Count     int64     `gorm:"not null"                  json:"count"`
+	Size      int64     `gorm:"not null"                  json:"size"`
+	Checksum  string    `gorm:"type:text;not null"        json:"checksum"`
+}
+
+func (a *AuditLog) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == "" {
+		uuidString, _ := uuid.NewV7()
+		a.ID = uuidString.String()
+	}
+	a.CreatedAt = a.CreatedAt.UTC()
+	return nil
+}
+
+func (a *AuditLog) AfterFind(tx *gorm.DB) error {
+	a.CreatedAt = a.CreatedAt.UTC()
+	return nil
+}
+
+func (a *AuditArchive) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == "" {
+		uuidString, _ := uuid.NewV7()
+		a.ID = uuidString.String()
+	}
+	a.OldestAt = a.OldestAt.UTC()
+	a.NewestAt = a.NewestAt.UTC()
+	return nil
+}
+
+func (a *AuditArchive) AfterFind(tx *gorm.DB) error {
+	a.CreatedAt = a.CreatedAt.UTC()
+	a.OldestAt = a.OldestAt.UTC()
+	a.NewestAt = a.NewestAt.UTC()
+	return nil
+}
+
+// MarshalJSON writes the times as APITime.
+func (a AuditLog) MarshalJSON() ([]byte, error) {
+	type auditLog AuditLog
+	return json.Marshal(struct {
+		auditLog
+		CreatedAt APITime `json:"createdAt"`
+	}{auditLog(a), NewAPITime(a.CreatedAt)})
+}
+
+// MarshalJSON writes the times as APITime.
+func (a AuditArchive) MarshalJSON() ([]byte, error) {
+	type auditArchive AuditArchive
+	return json.Marshal(struct {
+		auditArchive
+		CreatedAt APITime `json:"createdAt"`
+		OldestAt  APITime `json:"oldestAt"`
+		NewestAt  APITime `json:"newestAt"`
+	}{auditArchive(a), NewAPITime(a.CreatedAt), NewAPITime(a.OldestAt), NewAPITime(a.NewestAt)})
+}
+
+func NewAuditLogPage(entries []AuditLog, nextCursor string) AuditLogPage {
+	if entries == nil {
+		entries = []AuditLog{}
+	}
+	return AuditLogPage{Entries: entries, NextCursor: nextCursor, HasMore: nextCursor != ""}
+}
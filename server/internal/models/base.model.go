@@ -18,5 +18,14 @@ func (b *BaseModel) BeforeSave(tx *gorm.DB) error {
 		uuidString, _ := uuid.NewV7()
 		b.ID = uuidString.String()
 	}
+	b.CreatedAt = b.CreatedAt.UTC()
+	b.UpdatedAt = b.UpdatedAt.UTC()
+	return nil
+}
+
+// AfterFind hands timestamps back in UTC whatever zone sqlite parsed them in.
+func (b *BaseModel) AfterFind(tx *gorm.DB) error {
+	b.CreatedAt = b.CreatedAt.UTC()
+	b.UpdatedAt = b.UpdatedAt.UTC()
 	return nil
 }
@@ -181,9 +181,10 @@ func TestBaseModel_BeforeSave_PreservesOtherFields(t *testing.T) {
 	err := model.BeforeSave(nil)
 	assert.NoError(t, err)
 
-	// Other fields should be preserved
-	assert.Equal(t, now, model.CreatedAt)
-	assert.Equal(t, now, model.UpdatedAt)
+	// Other fields should be preserved, normalized to UTC
+	assert.True(t, now.Equal(model.CreatedAt))
+	assert.True(t, now.Equal(model.UpdatedAt))
+	assert.Equal(t, time.UTC, model.CreatedAt.Location())
 
 	// ID should be generated
 	assert.NotEmpty(t, model.ID)
@@ -435,7 +436,7 @@ func TestBaseModel_BeforeSave_WithNilGormDB(t *testing.T) {
 }
 
 func TestBaseModel_BeforeSave_WithTimeFields(t *testing.T) {
-	// Test that BeforeSave doesn't interfere with time fields
+	// Test that BeforeSave keeps the instant but stores it in UTC
 	now := time.Now()
 	pastTime := now.Add(-24 * time.Hour)
 	futureTime := now.Add(24 * time.Hour)
@@ -462,9 +463,11 @@ func TestBaseModel_BeforeSave_WithTimeFields(t *testing.T) {
 			err := model.BeforeSave(nil)
 			assert.NoError(t, err)
 
-			// Time fields should be preserved
-			assert.Equal(t, tc.createdAt, model.CreatedAt)
-			assert.Equal(t, tc.updatedAt, model.UpdatedAt)
+			// Time fields should be preserved as the same instant in UTC
+			assert.True(t, tc.createdAt.Equal(model.CreatedAt))
+			assert.True(t, tc.updatedAt.Equal(model.UpdatedAt))
+			assert.Equal(t, time.UTC, model.CreatedAt.Location())
+			assert.Equal(t, time.UTC, model.UpdatedAt.Location())
 
 			// ID should be generated
 			assert.NotEmpty(t, model.ID)
@@ -472,6 +475,33 @@ func TestBaseModel_BeforeSave_WithTimeFields(t *testing.T) {
 	}
 }
 
+func TestBaseModel_BeforeSave_ConvertsToUTC(t *testing.T) {
+	zone := time.FixedZone("UTC-7", -7*60*60)
+	local := time.Date(2024, 3, 10, 23, 30, 0, 0, zone)
+	model := BaseModel{CreatedAt: local, UpdatedAt: local}
+
+	err := model.BeforeSave(nil)
+	assert.NoError(t, err)
+
+	expected := time.Date(2024, 3, 11, 6, 30, 0, 0, time.UTC)
+	assert.Equal(t, expected, model.CreatedAt)
+	assert.Equal(t, expected, model.UpdatedAt)
+}
+
+func TestBaseModel_AfterFind_ConvertsToUTC(t *testing.T) {
+	zone := time.FixedZone("UTC+9", 9*60*60)
+	model := BaseModel{
+		CreatedAt: time.Date(2024, 1, 1, 8, 0, 0, 0, zone),
+		UpdatedAt: time.Date(2024, 1, 1, 9, 0, 0, 0, zone),
+	}
+
+	err := model.AfterFind(nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC), model.CreatedAt)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), model.UpdatedAt)
+}
+
 // Edge Case Tests
 
 func TestBaseModel_UUIDv7Properties(t *testing.T) {
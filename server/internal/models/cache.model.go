@@ -0,0 +1,43 @@
+package models
+
+import (
+	"server/internal/utils"
+	"server/internal/validate"
+	"strings"
+)
+
+const CACHE_NAMESPACE_MAX = 64
+
+// CacheFlushRequest names the namespace to flush, the prefix of its keys
+// before the colon, such as session or user. Empty flushes every namespace.
+type CacheFlushRequest struct {
+	Namespace string `json:"namespace"`
+}
+
+// Normalize trims the namespace and a trailing colon, so session: names the
+// same namespace as session.
+func (r *CacheFlushRequest) Normalize() {
+	r.Namespace = strings.TrimSuffix(strings.TrimSpace(r.Namespace), ":")
+}
+
+// Validate keeps the namespace to characters that mean nothing in a key
+// pattern, so a flush can't reach past it.
+func (r CacheFlushRequest) Validate() error {
+	namespace := validate.Check("namespace", r.Namespace, validate.Length(0, CACHE_NAMESPACE_MAX))
+	if namespace == nil && strings.ContainsFunc(r.Namespace, func(char rune) bool {
+		return !(char >= 'a' && char <= 'z' || char >= '0' && char <= '9' || char == '_')
+	}) {
+		namespace = validate.Fail("namespace", validate.CODE_INVALID, "must be lowercase letters, digits and underscores")
+	}
+
+	if errs := validate.Collect(namespace); len(errs) > 0 {
+		return utils.FieldValidationError("invalid cache flush request", errs)
+	}
+	return nil
+}
+
+// CacheFlushResult is how many keys a flush deleted across the caches.
+type CacheFlushResult struct {
+	Namespace string `json:"namespace"`
+	Deleted   int    `json:"deleted"`
+}
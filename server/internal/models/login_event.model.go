@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"server/internal/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LoginEvent records a single login attempt. Events are append only, so unlike
+// BaseModel there is no UpdatedAt, and CreatedAt is part of the history index.
+// An attempt for a login no user has is stored without a UserID, with the
+// login that was tried in AttemptedLogin.
+type LoginEvent struct {
+	ID             string    `gorm:"type:text;primaryKey"                                                    json:"id"`
+	UserID         string    `gorm:"type:text;index:idx_login_events_user_created,priority:1"                json:"userId"`
+	CreatedAt      time.Time `gorm:"autoCreateTime;index:idx_login_events_user_created,priority:2;not null" json:"createdAt"`
+	IP             string    `gorm:"type:text"                                                               json:"ip"`
+	UserAgent      string    `gorm:"type:text"                                                               json:"userAgent"`
+	ClientType     string    `gorm:"type:text"                                                               json:"clientType"`
+	Success        bool      `gorm:"type:bool;not null;default:false"                                        json:"success"`
+	AttemptedLogin string    `gorm:"type:text"                                                               json:"attemptedLogin,omitempty"`
+}
+
+// LoginHistoryEntry is the user facing view of a LoginEvent.
+type LoginHistoryEntry struct {
+	ID         string              `json:"id"`
+	CreatedAt  APITime             `json:"createdAt"`
+	IP         string              `json:"ip"`
+	ClientType string              `json:"clientType"`
+	Device     utils.DeviceSummary `json:"device"`
+}
+
+// LoginHistoryPage is a page of a user's logins. NextCursor fetches the page
+// after it, and is empty on the last.
+type LoginHistoryPage struct {
+	Logins     []LoginHistoryEntry `json:"logins"`
+	NextCursor string              `json:"nextCursor,omitempty"`
+	HasMore    bool                `json:"hasMore"`
+}
+
+func (e LoginEvent) HistoryEntry() LoginHistoryEntry {
+	return LoginHistoryEntry{
+		ID:         e.ID,
+		CreatedAt:  NewAPITime(e.CreatedAt),
+		IP:         e.IP,
+		ClientType: e.ClientType,
+		Device:     utils.ParseUserAgent(e.UserAgent),
+	}
+}
+
+// MarshalJSON writes CreatedAt as APITime.
+func (e LoginEvent) MarshalJSON() ([]byte, error) {
+	type loginEvent LoginEvent
+	return json.Marshal(struct {
+		loginEvent
+		CreatedAt APITime `json:"createdAt"`
+	}{loginEvent(e), NewAPITime(e.CreatedAt)})
+}
+
+func (e *LoginEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == "" {
+		uuidString, _ := uuid.NewV7()
+		e.ID = uuidString.String()
+	}
+	e.CreatedAt = e.CreatedAt.UTC()
+	return nil
+}
+
+func (e *LoginEvent) AfterFind(tx *gorm.DB) error {
+	e.CreatedAt = e.CreatedAt.UTC()
+	return nil
+}
+
+func NewLoginHistoryPage(events []LoginEvent, nextCursor string) LoginHistoryPage {
+	logins := make([]LoginHistoryEntry, 0, len(events))
+	for _, event := range events {
+		logins = append(logins, event.HistoryEntry())
+	}
+
+	return LoginHistoryPage{Logins: logins, NextCursor: nextCursor, HasMore: nextCursor != ""}
+}
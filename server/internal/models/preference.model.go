@@ -0,0 +1,187 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"server/internal/utils"
+	"time"
+)
+
+const (
+	// PREFERENCE_NOTIFICATIONS is reserved, its value must match
+	// NotificationPreferences.
+	PREFERENCE_NOTIFICATIONS = "notifications"
+
+	PREFERENCE_KEY_MAX   = 64
+	PREFERENCE_VALUE_MAX = 4096
+
+	QUIET_HOURS_LAYOUT = "15:04"
+)
+
+var preferenceKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// UserPreference is one JSON value a user stored under a key.
+type UserPreference struct {
+	UserID    string    `gorm:"type:text;primaryKey" json:"-"`
+	Key       string    `gorm:"type:text;primaryKey" json:"key"`
+	Value     string    `gorm:"type:text;not null"   json:"value"`
+	UpdatedAt time.Time `gorm:"not null"             json:"updatedAt"`
+}
+
+// NotificationPreferences decides which websocket messages reach a user.
+type NotificationPreferences struct {
+	Broadcasts bool        `json:"broadcasts"`
+	Mentions   bool        `json:"mentions"`
+	QuietHours *QuietHours `json:"quietHours,omitempty"`
+}
+
+// QuietHours is a daily window, in the user's timezone, during which nothing
+// is delivered. End is exclusive and may be earlier than Start to span
+// midnight.
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+// DefaultNotificationPreferences applies to users who never saved any.
+func DefaultNotificationPreferences() NotificationPreferences {
+	return NotificationPreferences{Broadcasts: true, Mentions: true}
+}
+
+// ValidatePreference checks a write to key. The reserved keys have to match
+// their schema, everything else only has to be JSON.
+func ValidatePreference(key string, value []byte) error {
+	if len(key) > PREFERENCE_KEY_MAX || !preferenceKeyPattern.MatchString(key) {
+		return utils.NewValidationError("invalid preference key", "key", map[string]any{
+			"code":    "invalid",
+			"message": fmt.Sprintf("key must be at most %d lowercase letters, digits, '.', '_' or '-'", PREFERENCE_KEY_MAX),
+		})
+	}
+
+	if len(value) > PREFERENCE_VALUE_MAX {
+		return utils.NewValidationError("invalid preference", key, map[string]any{
+			"code":    "too_long",
+			"message": fmt.Sprintf("value must be at most %d bytes", PREFERENCE_VALUE_MAX),
+		})
+	}
+
+	if !json.Valid(value) {
+		return utils.NewValidationError("invalid preference", key, map[string]any{
+			"code":    "invalid_json",
+			"message": "value must be JSON",
+		})
+	}
+
+	if key == PREFERENCE_NOTIFICATIONS {
+		_, err := ParseNotificationPreferences(value)
+		return err
+	}
+	return nil
+}
+
+// ParseNotificationPreferences decodes and validates a stored notifications
+// value. Both toggles are required and unknown fields are rejected.
+func ParseNotificationPreferences(value []byte) (NotificationPreferences, error) {
+	var raw struct {
+		Broadcasts *bool       `json:"broadcasts"`
+		Mentions   *bool       `json:"mentions"`
+		QuietHours *QuietHours `json:"quietHours"`
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&raw); err != nil {
+		return NotificationPreferences{}, notificationsError(PREFERENCE_NOTIFICATIONS, "invalid", err.Error())
+	}
+
+	switch {
+	case raw.Broadcasts == nil:
+		return NotificationPreferences{}, notificationsError("notifications.broadcasts", "required", "broadcasts is required")
+	case raw.Mentions == nil:
+		return NotificationPreferences{}, notificationsError("notifications.mentions", "required", "mentions is required")
+	}
+
+	preferences := NotificationPreferences{
+		Broadcasts: *raw.Broadcasts,
+		Mentions:   *raw.Mentions,
+		QuietHours: raw.QuietHours,
+	}
+	if preferences.QuietHours != nil {
+		if err := preferences.QuietHours.validate(); err != nil {
+			return NotificationPreferences{}, err
+		}
+	}
+	return preferences, nil
+}
+
+func (q QuietHours) validate() error {
+	if _, err := time.Parse(QUIET_HOURS_LAYOUT, q.Start); err != nil {
+		return notificationsError("notifications.quietHours.start", "invalid", "start must be HH:MM")
+	}
+	if _, err := time.Parse(QUIET_HOURS_LAYOUT, q.End); err != nil {
+		return notificationsError("notifications.quietHours.end", "invalid", "end must be HH:MM")
+	}
+	if q.Start == q.End {
+		return notificationsError("notifications.quietHours.end", "invalid", "end must differ from start")
+	}
+	if _, err := loadTimezone(q.Timezone); err != nil {
+		return notificationsError("notifications.quietHours.timezone", "invalid", "timezone must be an IANA name")
+	}
+	return nil
+}
+
+// Contains reports whether at falls inside the window on the user's clock.
+// The window must have been validated.
+func (q QuietHours) Contains(at time.Time) bool {
+	location, err := loadTimezone(q.Timezone)
+	if err != nil {
+		return false
+	}
+	start, _ := time.Parse(QUIET_HOURS_LAYOUT, q.Start)
+	end, _ := time.Parse(QUIET_HOURS_LAYOUT, q.End)
+
+	local := at.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+
+	if from < to {
+		return minute >= from && minute < to
+	}
+	// The window wraps past midnight
+	return minute >= from || minute < to
+}
+
+// AllowsBroadcast reports whether an admin broadcast may be delivered at at.
+func (p NotificationPreferences) AllowsBroadcast(at time.Time) bool {
+	return p.Broadcasts && !p.inQuietHours(at)
+}
+
+// AllowsMention reports whether a message addressed to the user may be
+// delivered at at.
+func (p NotificationPreferences) AllowsMention(at time.Time) bool {
+	return p.Mentions && !p.inQuietHours(at)
+}
+
+func (p NotificationPreferences) inQuietHours(at time.Time) bool {
+	return p.QuietHours != nil && p.QuietHours.Contains(at)
+}
+
+// loadTimezone only accepts named zones; time.LoadLocation would also take ""
+// as UTC.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, fmt.Errorf("timezone is required")
+	}
+	return time.LoadLocation(name)
+}
+
+func notificationsError(field string, code string, message string) error {
+	return utils.NewValidationError("invalid notification preferences", field, map[string]any{
+		"code":    code,
+		"message": message,
+	})
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"errors"
+	"server/internal/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePreference(t *testing.T) {
+	testCases := []struct {
+		name  string
+		key   string
+		value string
+		field string
+	}{
+		{"free form key", "theme", `"dark"`, ""},
+		{"free form object", "dashboard.layout", `{"columns":3}`, ""},
+		{"invalid json", "theme", `dark`, "theme"},
+		{"too large", "theme", `"` + strings.Repeat("x", PREFERENCE_VALUE_MAX) + `"`, "theme"},
+		{"bad key", "Theme!", `"dark"`, "key"},
+		{"key too long", strings.Repeat("k", PREFERENCE_KEY_MAX+1), `1`, "key"},
+		{"notifications", "notifications", `{"broadcasts":false,"mentions":true}`, ""},
+		{"notifications with quiet hours", "notifications",
+			`{"broadcasts":true,"mentions":true,"quietHours":{"start":"22:00","end":"07:00","timezone":"Europe/Berlin"}}`, ""},
+		{"notifications missing toggle", "notifications", `{"broadcasts":true}`, "notifications.mentions"},
+		{"notifications wrong type", "notifications", `{"broadcasts":"yes","mentions":true}`, "notifications"},
+		{"notifications unknown field", "notifications", `{"broadcasts":true,"mentions":true,"sound":true}`, "notifications"},
+		{"notifications not an object", "notifications", `[]`, "notifications"},
+		{"quiet hours bad start", "notifications",
+			`{"broadcasts":true,"mentions":true,"quietHours":{"start":"25:00","end":"07:00","timezone":"UTC"}}`,
+			"notifications.quietHours.start"},
+		{"quiet hours bad end", "notifications",
+			`{"broadcasts":true,"mentions":true,"quietHours":{"start":"22:00","end":"7pm","timezone":"UTC"}}`,
+			"notifications.quietHours.end"},
+		{"quiet hours empty window", "notifications",
+			`{"broadcasts":true,"mentions":true,"quietHours":{"start":"22:00","end":"22:00","timezone":"UTC"}}`,
+			"notifications.quietHours.end"},
+		{"quiet hours unknown zone", "notifications",
+			`{"broadcasts":true,"mentions":true,"quietHours":{"start":"22:00","end":"07:00","timezone":"Mars/Olympus"}}`,
+			"notifications.quietHours.timezone"},
+		{"quiet hours missing zone", "notifications",
+			`{"broadcasts":true,"mentions":true,"quietHours":{"start":"22:00","end":"07:00"}}`,
+			"notifications.quietHours.timezone"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidatePreference(tc.key, []byte(tc.value))
+			if tc.field == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *utils.ValidationError
+			require.True(t, errors.As(err, &validationErr), "got %v", err)
+			assert.Contains(t, validationErr.Details, tc.field)
+		})
+	}
+}
+
+func TestQuietHours_Contains(t *testing.T) {
+	utc := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 15, hour, minute, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		name     string
+		hours    QuietHours
+		at       time.Time
+		expected bool
+	}{
+		{"same day, inside", QuietHours{"09:00", "17:00", "UTC"}, utc(12, 0), true},
+		{"same day, at start", QuietHours{"09:00", "17:00", "UTC"}, utc(9, 0), true},
+		{"same day, end is exclusive", QuietHours{"09:00", "17:00", "UTC"}, utc(17, 0), false},
+		{"same day, before", QuietHours{"09:00", "17:00", "UTC"}, utc(8, 59), false},
+
+		{"wraps, late evening", QuietHours{"22:00", "07:00", "UTC"}, utc(23, 30), true},
+		{"wraps, midnight", QuietHours{"22:00", "07:00", "UTC"}, utc(0, 0), true},
+		{"wraps, early morning", QuietHours{"22:00", "07:00", "UTC"}, utc(6, 59), true},
+		{"wraps, end is exclusive", QuietHours{"22:00", "07:00", "UTC"}, utc(7, 0), false},
+		{"wraps, afternoon", QuietHours{"22:00", "07:00", "UTC"}, utc(15, 0), false},
+
+		// 21:30 UTC is 22:30 in Berlin (UTC+1 in January)
+		{"ahead of utc, inside", QuietHours{"22:00", "07:00", "Europe/Berlin"}, utc(21, 30), true},
+		{"ahead of utc, just before", QuietHours{"22:00", "07:00", "Europe/Berlin"}, utc(20, 59), false},
+		// 06:30 UTC is 07:30 in Berlin, already over
+		{"ahead of utc, after end", QuietHours{"22:00", "07:00", "Europe/Berlin"}, utc(6, 30), false},
+		// 03:00 UTC is 22:00 the previous day in New York (UTC-5)
+		{"behind utc, previous local day", QuietHours{"22:00", "07:00", "America/New_York"}, utc(3, 0), true},
+		{"behind utc, local afternoon", QuietHours{"22:00", "07:00", "America/New_York"}, utc(20, 0), false},
+		// 17:30 UTC is 23:00 in Kolkata (UTC+5:30)
+		{"half hour offset", QuietHours{"23:00", "23:30", "Asia/Kolkata"}, utc(17, 30), true},
+		{"half hour offset, end", QuietHours{"23:00", "23:30", "Asia/Kolkata"}, utc(18, 0), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.hours.Contains(tc.at))
+		})
+	}
+}
+
+func TestQuietHours_FollowsDaylightSaving(t *testing.T) {
+	hours := QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/Berlin"}
+
+	// 21:30 UTC is 22:30 in winter (UTC+1) but 23:30 in summer (UTC+2), and
+	// 20:30 UTC is only inside the window in summer.
+	assert.True(t, hours.Contains(time.Date(2024, 1, 15, 21, 30, 0, 0, time.UTC)))
+	assert.False(t, hours.Contains(time.Date(2024, 1, 15, 20, 30, 0, 0, time.UTC)))
+	assert.True(t, hours.Contains(time.Date(2024, 7, 15, 20, 30, 0, 0, time.UTC)))
+}
+
+func TestNotificationPreferences_Allows(t *testing.T) {
+	night := time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	defaults := DefaultNotificationPreferences()
+	assert.True(t, defaults.AllowsBroadcast(night))
+	assert.True(t, defaults.AllowsMention(night))
+
+	noBroadcasts := NotificationPreferences{Broadcasts: false, Mentions: true}
+	assert.False(t, noBroadcasts.AllowsBroadcast(noon))
+	assert.True(t, noBroadcasts.AllowsMention(noon))
+
+	quiet := NotificationPreferences{
+		Broadcasts: true,
+		Mentions:   true,
+		QuietHours: &QuietHours{Start: "22:00", End: "07:00", Timezone: "UTC"},
+	}
+	assert.False(t, quiet.AllowsBroadcast(night))
+	assert.False(t, quiet.AllowsMention(night))
+	assert.True(t, quiet.AllowsBroadcast(noon))
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var registry struct {
+	mutex   sync.RWMutex
+	models  []any
+	types   map[reflect.Type]bool
+	retired []RetiredTable
+}
+
+// RetiredTable is a table whose model was removed from the registry. The
+// table is left in place, since AutoMigrate never drops anything, until the
+// migration tool's drop-retired command renames it out of the way.
+type RetiredTable struct {
+	Table string `json:"table"`
+	// The schema version, the id of a migration, the model was removed in
+	Since string `json:"since"`
+}
+
+// Every table model is registered here, parents before the tables that
+// reference them. Auto-migration, export and import all follow this order.
+func init() {
+	Register(&User{})
+	Register(&LoginEvent{})
+	Register(&Announcement{})
+	Register(&UserPreference{})
+	Register(&AuditLog{})
+	Register(&AuditArchive{})
+
+	// Tables whose model has been removed go here, so they are reported as
+	// pending a drop rather than as unknown, e.g.
+	// MarkRetired("widgets", "0013_drop_widgets.sql")
+}
+
+// Register adds a table model. It panics on anything but a pointer to a
+// struct, or on a model registered twice, since either is a programming error.
+func Register(model any) {
+	modelType := reflect.TypeOf(model)
+	if modelType == nil || modelType.Kind() != reflect.Pointer || modelType.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("models: Register needs a pointer to a struct, got %T", model))
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if registry.types == nil {
+		registry.types = make(map[reflect.Type]bool)
+	}
+	if registry.types[modelType] {
+		panic(fmt.Sprintf("models: %T registered twice", model))
+	}
+	registry.types[modelType] = true
+	registry.models = append(registry.models, model)
+}
+
+// All returns the registered models in registration order.
+func All() []any {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	models := make([]any, len(registry.models))
+	copy(models, registry.models)
+	return models
+}
+
+// MarkRetired records that table's model was removed in the migration
+// sinceVersion. It panics on an empty name or a table marked twice.
+func MarkRetired(table string, sinceVersion string) {
+	if table == "" {
+		panic("models: MarkRetired needs a table name")
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	for _, retired := range registry.retired {
+		if retired.Table == table {
+			panic(fmt.Sprintf("models: table %s marked retired twice", table))
+		}
+	}
+	registry.retired = append(registry.retired, RetiredTable{Table: table, Since: sinceVersion})
+}
+
+// Retired returns the tables marked retired, in the order they were marked.
+func Retired() []RetiredTable {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	retired := make([]RetiredTable, len(registry.retired))
+	copy(retired, registry.retired)
+	return retired
+}
+
+// TableNames returns the table of every registered model, in registration
+// order, as named by db's naming strategy.
+func TableNames(db *gorm.DB) ([]string, error) {
+	models := All()
+	tables := make([]string, 0, len(models))
+	for _, model := range models {
+		statement := &gorm.Statement{DB: db}
+		if err := statement.Parse(model); err != nil {
+			return nil, fmt.Errorf("failed to parse model %T: %w", model, err)
+		}
+		tables = append(tables, statement.Schema.Table)
+	}
+	return tables, nil
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// tableModels finds the exported structs in this package's source that are
+// stored in a table: those embedding BaseModel or declaring a primary key.
+// BaseModel itself is only ever embedded.
+func tableModels(t *testing.T) []string {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	require.NoError(t, err)
+
+	var names []string
+	for _, file := range packages["models"].Files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			spec, ok := node.(*ast.TypeSpec)
+			if !ok || !spec.Name.IsExported() || spec.Name.Name == "BaseModel" {
+				return true
+			}
+			structType, ok := spec.Type.(*ast.StructType)
+			if !ok {
+				return true
+			}
+			for _, field := range structType.Fields.List {
+				embedsBase := len(field.Names) == 0 && isIdent(field.Type, "BaseModel")
+				hasPrimaryKey := field.Tag != nil && strings.Contains(field.Tag.Value, "primaryKey")
+				if embedsBase || hasPrimaryKey {
+					names = append(names, spec.Name.Name)
+					break
+				}
+			}
+			return true
+		})
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+func TestRegistry_EveryTableModelIsRegistered(t *testing.T) {
+	var registered []string
+	for _, model := range All() {
+		registered = append(registered, reflect.TypeOf(model).Elem().Name())
+	}
+	slices.Sort(registered)
+
+	found := tableModels(t)
+	require.NotEmpty(t, found)
+	for _, name := range found {
+		assert.Contains(t, registered, name, "%s is a table model but isn't registered in registry.go", name)
+	}
+}
+
+func TestRegistry_AllReturnsACopy(t *testing.T) {
+	models := All()
+	models[0] = nil
+	assert.NotNil(t, All()[0])
+}
+
+func TestRegister_RejectsInvalidModels(t *testing.T) {
+	assert.Panics(t, func() { Register(User{}) }, "not a pointer")
+	assert.Panics(t, func() { Register(&User{}) }, "already registered")
+	assert.Len(t, All(), 6)
+}
+
+func TestTableNames(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	tables, err := TableNames(db)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"users", "login_events", "announcements", "user_preferences", "audit_logs", "audit_archives"}, tables)
+}
+
+func TestMarkRetired(t *testing.T) {
+	t.Cleanup(func() { registry.retired = nil })
+
+	MarkRetired("widgets", "0013_drop_widgets.sql")
+	assert.Panics(t, func() { MarkRetired("widgets", "0014_again.sql") }, "already retired")
+	assert.Panics(t, func() { MarkRetired("", "0014_again.sql") }, "no table")
+
+	retired := Retired()
+	assert.Equal(t, []RetiredTable{{Table: "widgets", Since: "0013_drop_widgets.sql"}}, retired)
+	retired[0].Table = "changed"
+	assert.Equal(t, "widgets", Retired()[0].Table)
+}
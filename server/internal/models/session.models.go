@@ -1,21 +1,108 @@
 package models
 
 import (
+	"log/slog"
 	"server/internal/utils"
+	"strings"
 	"time"
 )
 
 const (
-	SESSION_COOKIE_KEY = "sessionID"
+	SESSION_COOKIE_KEY      = "sessionID"
+	SESSION_DEVICE_NAME_MAX = 64
 )
 
+// Session is a login session. Token is the raw JWT and a replayable
+// credential, so it is never serialized; the repository stores it separately.
 type Session struct {
 	ID        string    `gorm:"-" json:"id"`
 	UserID    string    `gorm:"-" json:"userId"`
-	Token     string    `gorm:"-" json:"token"`
+	Token     string    `gorm:"-" json:"-"`
+	CreatedAt time.Time `gorm:"-" json:"createdAt"`
 	ExpiresAt time.Time `gorm:"-" json:"expiresAt"`
 	RefreshAt time.Time `gorm:"-" json:"refreshAt"`
+	// StartedAt is when the user logged in. Refreshes carry it over, so it
+	// bounds how long the session can be extended.
+	StartedAt time.Time `gorm:"-" json:"startedAt"`
+
+	DeviceName string `gorm:"-" json:"deviceName,omitempty"`
+	UserAgent  string `gorm:"-" json:"userAgent,omitempty"`
+
+	// ImpersonatedBy is the admin acting as UserID, and ImpersonatorSessionID
+	// the admin's own session to return to when they stop.
+	ImpersonatedBy        string `gorm:"-" json:"impersonatedBy,omitempty"`
+	ImpersonatorSessionID string `gorm:"-" json:"impersonatorSessionId,omitempty"`
+}
+
+// Impersonated reports whether an admin is acting as the user.
+func (s Session) Impersonated() bool {
+	return s.ImpersonatedBy != ""
+}
+
+// LoggedInAt is StartedAt, or CreatedAt for sessions stored before it was.
+func (s Session) LoggedInAt() time.Time {
+	if s.StartedAt.IsZero() {
+		return s.CreatedAt
+	}
+	return s.StartedAt
+}
+
+// LogValue keeps the token out of logs.
+func (s Session) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", s.ID),
+		slog.String("userId", s.UserID),
+		slog.Time("expiresAt", s.ExpiresAt),
+		slog.String("impersonatedBy", s.ImpersonatedBy),
+	)
+}
+
+// SessionDTO is a session as listed to its owner. Current marks the session
+// the request was made with.
+type SessionDTO struct {
+	ID         string  `json:"id"`
+	CreatedAt  APITime `json:"createdAt"`
+	ExpiresAt  APITime `json:"expiresAt"`
+	UserAgent  string  `json:"userAgent,omitempty"`
+	DeviceName string  `json:"deviceName,omitempty"`
+	Current    bool    `json:"current"`
+}
+
+func (s Session) DTO(currentSessionID string) SessionDTO {
+	return SessionDTO{
+		ID:         s.ID,
+		CreatedAt:  NewAPITime(s.CreatedAt),
+		ExpiresAt:  NewAPITime(s.ExpiresAt),
+		UserAgent:  s.UserAgent,
+		DeviceName: s.DeviceName,
+		Current:    s.ID == currentSessionID,
+	}
 }
 
 type TokenClaims utils.TokenClaims
 
+// SessionSummary is the metadata of a session without its token.
+type SessionSummary struct {
+	ID         string  `json:"id"`
+	DeviceName string  `json:"deviceName,omitempty"`
+	ExpiresAt  APITime `json:"expiresAt"`
+	RefreshAt  APITime `json:"refreshAt"`
+}
+
+func (s Session) Summary() SessionSummary {
+	return SessionSummary{
+		ID:         s.ID,
+		DeviceName: s.DeviceName,
+		ExpiresAt:  NewAPITime(s.ExpiresAt),
+		RefreshAt:  NewAPITime(s.RefreshAt),
+	}
+}
+
+// NormalizeDeviceName trims a client supplied device name and caps its length.
+func NormalizeDeviceName(name string) string {
+	name = strings.TrimSpace(name)
+	if runes := []rune(name); len(runes) > SESSION_DEVICE_NAME_MAX {
+		name = string(runes[:SESSION_DEVICE_NAME_MAX])
+	}
+	return name
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"server/config"
+	"server/internal/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jwtPattern matches three base64url segments, the first a JSON header.
+var jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)
+
+func sessionWithToken(t *testing.T) Session {
+	expiresAt := time.Now().Add(time.Hour)
+	userID := uuid.New().String()
+	token, err := utils.GenerateJWTToken(
+		userID,
+		expiresAt,
+		config.Config{Security: config.SecurityConfig{JwtSecret: "test-secret"}},
+		nil,
+	)
+	require.NoError(t, err)
+	require.Regexp(t, jwtPattern, token)
+
+	return Session{
+		ID:         "session-1",
+		UserID:     userID,
+		Token:      token,
+		CreatedAt:  time.Now(),
+		ExpiresAt:  expiresAt,
+		DeviceName: "Laptop",
+		UserAgent:  "Mozilla/5.0",
+	}
+}
+
+func assertNoToken(t *testing.T, serialized string) {
+	t.Helper()
+	assert.NotContains(t, strings.ToLower(serialized), "token")
+	assert.NotRegexp(t, jwtPattern, serialized)
+}
+
+func TestSession_SerializationOmitsToken(t *testing.T) {
+	session := sessionWithToken(t)
+
+	encoded, err := json.Marshal(session)
+	require.NoError(t, err)
+	assertNoToken(t, string(encoded))
+
+	encoded, err = json.Marshal(session.DTO(session.ID))
+	require.NoError(t, err)
+	assertNoToken(t, string(encoded))
+
+	encoded, err = json.Marshal(session.Summary())
+	require.NoError(t, err)
+	assertNoToken(t, string(encoded))
+
+	var logged bytes.Buffer
+	slog.New(slog.NewTextHandler(&logged, nil)).Info("session", "session", session)
+	assertNoToken(t, logged.String())
+}
+
+func TestSession_DTO(t *testing.T) {
+	session := sessionWithToken(t)
+
+	dto := session.DTO("session-1")
+	assert.Equal(t, SessionDTO{
+		ID:         "session-1",
+		CreatedAt:  NewAPITime(session.CreatedAt),
+		ExpiresAt:  NewAPITime(session.ExpiresAt),
+		UserAgent:  "Mozilla/5.0",
+		DeviceName: "Laptop",
+		Current:    true,
+	}, dto)
+
+	assert.False(t, session.DTO("session-2").Current)
+}
@@ -0,0 +1,53 @@
+package models
+
+import "server/internal/utils"
+
+const (
+	ADMIN_STATS_LOGIN_DAYS_DEFAULT        = 14
+	ADMIN_STATS_LOGIN_DAYS_MAX            = 90
+	ADMIN_STATS_REGISTRATION_DAYS_DEFAULT = 30
+	ADMIN_STATS_REGISTRATION_DAYS_MAX     = 365
+)
+
+// DailyCount is one UTC day of a time series. Day is formatted as 2006-01-02.
+type DailyCount struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+// AdminStats is the admin dashboard summary. It is served from a short lived
+// cache, so GeneratedAt says how fresh it is.
+type AdminStats struct {
+	GeneratedAt          APITime      `json:"generatedAt"`
+	TotalUsers           int64        `json:"totalUsers"`
+	NewUsersLast7Days    int64        `json:"newUsersLast7Days"`
+	NewUsersLast30Days   int64        `json:"newUsersLast30Days"`
+	ActiveSessions       int64        `json:"activeSessions"`
+	WebsocketConnections int          `json:"websocketConnections"`
+	LoginsPerDay         []DailyCount `json:"loginsPerDay"`
+	RegistrationsPerDay  []DailyCount `json:"registrationsPerDay"`
+}
+
+// AdminStatsRequest sets the windows of the daily series, in days ending today.
+type AdminStatsRequest struct {
+	LoginDays        int `query:"loginDays"`
+	RegistrationDays int `query:"registrationDays"`
+}
+
+func (r AdminStatsRequest) Validate() error {
+	if err := validateStatsWindow("loginDays", r.LoginDays, ADMIN_STATS_LOGIN_DAYS_MAX); err != nil {
+		return err
+	}
+	return validateStatsWindow("registrationDays", r.RegistrationDays, ADMIN_STATS_REGISTRATION_DAYS_MAX)
+}
+
+func validateStatsWindow(field string, days int, maxDays int) error {
+	if days >= 1 && days <= maxDays {
+		return nil
+	}
+	return utils.NewValidationError(field+" is out of range", field, map[string]any{
+		"code": "out_of_range",
+		"min":  1,
+		"max":  maxDays,
+	})
+}
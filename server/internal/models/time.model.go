@@ -0,0 +1,54 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// API_TIME_FORMAT is how the API writes times: RFC 3339 in UTC with exactly
+// three fractional digits, so every timestamp has the same length and
+// clients with strict parsers read them all.
+const API_TIME_FORMAT = "2006-01-02T15:04:05.000Z"
+
+// APITime is a time as the API shows it, see API_TIME_FORMAT. Response types
+// use it in place of time.Time, whose JSON has as many fractional digits as
+// the time happens to need and keeps its offset. It reads RFC 3339 with or
+// without fractional seconds, at any offset, and holds it in UTC.
+type APITime struct {
+	time.Time
+}
+
+func NewAPITime(t time.Time) APITime {
+	return APITime{Time: t.UTC()}
+}
+
+// NewAPITimePtr is nil for nil, for optional times.
+func NewAPITimePtr(t *time.Time) *APITime {
+	if t == nil {
+		return nil
+	}
+	apiTime := NewAPITime(*t)
+	return &apiTime
+}
+
+func (t APITime) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + t.UTC().Format(API_TIME_FORMAT) + `"`), nil
+}
+
+func (t *APITime) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	// Fractional seconds are read whether or not the layout has them
+	parsed, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		return fmt.Errorf("time %q is not RFC 3339", text)
+	}
+	t.Time = parsed.UTC()
+	return nil
+}
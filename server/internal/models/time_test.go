@@ -0,0 +1,154 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// apiTimeFixture has more precision than the API shows, in a zone that
+// isn't UTC.
+var apiTimeFixture = time.Date(2024, 6, 1, 14, 34, 56, 789123456, time.FixedZone("CEST", 2*60*60))
+
+const apiTimeFixtureText = "2024-06-01T12:34:56.789Z"
+
+// apiResponseTypes are the types the API answers with that write their own
+// JSON, which the response check in the routes package leaves to this list.
+// A type added here has every time in it checked for the API format.
+var apiResponseTypes = []any{
+	UserDTO{},
+	SessionDTO{},
+	SessionSummary{},
+	Announcement{},
+	AuditLog{},
+	AuditLogPage{},
+	AuditArchive{},
+	LoginEvent{},
+	LoginHistoryEntry{},
+	UserExport{},
+}
+
+func TestAPITime_Marshal(t *testing.T) {
+	encoded, err := json.Marshal(NewAPITime(apiTimeFixture))
+	require.NoError(t, err)
+	assert.Equal(t, `"`+apiTimeFixtureText+`"`, string(encoded))
+
+	encoded, err = json.Marshal(APITime{Time: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	assert.Equal(t, `"2024-06-01T12:00:00.000Z"`, string(encoded), "whole seconds keep their milliseconds")
+
+	encoded, err = json.Marshal(struct {
+		At *APITime `json:"at"`
+	}{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"at":null}`, string(encoded))
+}
+
+func TestAPITime_Unmarshal(t *testing.T) {
+	for _, text := range []string{
+		"2024-06-01T12:34:56Z",
+		"2024-06-01T12:34:56.000Z",
+		"2024-06-01T12:34:56.000000001Z",
+		"2024-06-01T14:34:56+02:00",
+		"2024-06-01T14:34:56.5+02:00",
+	} {
+		var parsed APITime
+		require.NoError(t, json.Unmarshal([]byte(`"`+text+`"`), &parsed), text)
+		assert.Equal(t, time.UTC, parsed.Location(), text)
+		assert.Equal(t, "2024-06-01T12:34:56", parsed.Format("2006-01-02T15:04:05"), text)
+	}
+
+	var parsed APITime
+	require.NoError(t, json.Unmarshal([]byte("null"), &parsed))
+	assert.True(t, parsed.IsZero())
+
+	for _, text := range []string{`"2024-06-01"`, `"2024-06-01 12:34:56"`, `1717245296`} {
+		assert.Error(t, json.Unmarshal([]byte(text), &parsed), text)
+	}
+}
+
+// TestAPIResponseTypes_TimeFormat sets every time in each response type and
+// checks each comes out in the API format, so a time.Time field added to one
+// without going through APITime fails here.
+func TestAPIResponseTypes_TimeFormat(t *testing.T) {
+	for _, responseType := range apiResponseTypes {
+		value := reflect.New(reflect.TypeOf(responseType)).Elem()
+		set := setTimes(value)
+		name := value.Type().Name()
+		require.Positive(t, set, "%s has no times to check", name)
+
+		encoded, err := json.Marshal(value.Interface())
+		require.NoError(t, err, name)
+		var decoded any
+		require.NoError(t, json.Unmarshal(encoded, &decoded), name)
+
+		times := timesIn(decoded)
+		assert.Len(t, times, set, "%s: %s", name, encoded)
+		for _, text := range times {
+			assert.Equal(t, apiTimeFixtureText, text, "%s: %s", name, encoded)
+		}
+	}
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	apiTimeType = reflect.TypeOf(APITime{})
+)
+
+// setTimes sets every exported time in value to apiTimeFixture, making
+// structs behind pointers and a single element of slices on the way, and
+// returns how many it set.
+func setTimes(value reflect.Value) int {
+	switch {
+	case value.Type() == timeType:
+		value.Set(reflect.ValueOf(apiTimeFixture))
+		return 1
+	case value.Type() == apiTimeType:
+		value.Set(reflect.ValueOf(APITime{Time: apiTimeFixture}))
+		return 1
+	}
+
+	switch value.Kind() {
+	case reflect.Pointer:
+		value.Set(reflect.New(value.Type().Elem()))
+		return setTimes(value.Elem())
+	case reflect.Slice:
+		value.Set(reflect.MakeSlice(value.Type(), 1, 1))
+		return setTimes(value.Index(0))
+	case reflect.Struct:
+		set := 0
+		for i := range value.NumField() {
+			field := value.Type().Field(i)
+			if !field.IsExported() || field.Tag.Get("json") == "-" {
+				continue
+			}
+			set += setTimes(value.Field(i))
+		}
+		return set
+	}
+	return 0
+}
+
+// timesIn is every string in decoded JSON that reads as an RFC 3339 time.
+func timesIn(decoded any) []string {
+	var times []string
+	switch v := decoded.(type) {
+	case map[string]any:
+		for _, field := range v {
+			times = append(times, timesIn(field)...)
+		}
+	case []any:
+		for _, element := range v {
+			times = append(times, timesIn(element)...)
+		}
+	case string:
+		if _, err := time.Parse(time.RFC3339, v); err == nil {
+			times = append(times, v)
+		}
+	}
+	return times
+}
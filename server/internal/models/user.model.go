@@ -1,29 +1,299 @@
 package models
 
 import (
+	"errors"
+	// Registers the serializer of Password
+	_ "server/internal/encryption"
 	"server/internal/logger"
 	"server/internal/utils"
+	"server/internal/validate"
+	"strings"
+	"time"
+	"unicode"
 
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
 	"gorm.io/gorm"
 )
 
 type User struct {
 	BaseModel
-	FirstName string `gorm:"type:text"                      json:"firstName"`
-	LastName  string `gorm:"type:text"                      json:"lastName"`
-	Login     string `gorm:"type:text;uniqueIndex;not null" json:"login"`
-	Password  string `gorm:"type:text;not null"             json:"-"`
-	IsAdmin   bool   `gorm:"type:bool;default:false"        json:"isAdmin"`
+	FirstName string         `gorm:"type:text"                                                               json:"firstName"`
+	LastName  string         `gorm:"type:text"                                                               json:"lastName"`
+	Login     string         `gorm:"type:text;not null;uniqueIndex:,collate:NOCASE,where:deleted_at IS NULL" json:"login"`
+	Password  string         `gorm:"type:text;not null;serializer:encrypted"                                 json:"-"`
+	IsAdmin   bool           `gorm:"type:bool;default:false"                                                 json:"isAdmin"`
+	Version   int            `gorm:"not null;default:1"                                                      json:"version"`
+	DeletedAt gorm.DeletedAt `gorm:"index"                                                                   json:"-"`
+
+	// The pepper version (config.PepperVersion) Password was hashed with
+	PepperVersion int `gorm:"not null;default:1" json:"-"`
+
+	// The terms of service version (config.TermsVersion) the user last
+	// accepted, and when. Empty for users who never accepted any.
+	TosVersion    string     `gorm:"type:text;not null;default:''" json:"tosVersion"`
+	TosAcceptedAt *time.Time `                                     json:"tosAcceptedAt"`
+}
+
+// UserDTO is a user as looked up by another user. Everyone is shown the
+// public fields, and only admins the UserDetails.
+type UserDTO struct {
+	ID        string `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	*UserDetails
+}
+
+// UserDetails are the fields of a user only admins are shown, every one but
+// the password and the pepper version.
+type UserDetails struct {
+	Login         string   `json:"login"`
+	IsAdmin       bool     `json:"isAdmin"`
+	Version       int      `json:"version"`
+	TosVersion    string   `json:"tosVersion"`
+	TosAcceptedAt *APITime `json:"tosAcceptedAt"`
+	CreatedAt     APITime  `json:"createdAt"`
+	UpdatedAt     APITime  `json:"updatedAt"`
+}
+
+// DTO is the user as shown to others, with its details when full.
+func (u User) DTO(full bool) UserDTO {
+	dto := UserDTO{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName}
+	if full {
+		dto.UserDetails = &UserDetails{
+			Login:         u.Login,
+			IsAdmin:       u.IsAdmin,
+			Version:       u.Version,
+			TosVersion:    u.TosVersion,
+			TosAcceptedAt: NewAPITimePtr(u.TosAcceptedAt),
+			CreatedAt:     NewAPITime(u.CreatedAt),
+			UpdatedAt:     NewAPITime(u.UpdatedAt),
+		}
+	}
+	return dto
+}
+
+// ChangedFields names the fields the user shows itself that differ from
+// before, by their JSON names. The version, which every write bumps, isn't
+// one of them.
+func (u User) ChangedFields(before User) []string {
+	var fields []string
+	changed := func(field string, differs bool) {
+		if differs {
+			fields = append(fields, field)
+		}
+	}
+	changed("firstName", u.FirstName != before.FirstName)
+	changed("lastName", u.LastName != before.LastName)
+	changed("login", u.Login != before.Login)
+	changed("isAdmin", u.IsAdmin != before.IsAdmin)
+	changed("tosVersion", u.TosVersion != before.TosVersion)
+	changed("tosAcceptedAt", !timesEqual(u.TosAcceptedAt, before.TosAcceptedAt))
+	return fields
+}
+
+func timesEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+const (
+	USER_NAME_MAX = 100
+
+	USER_LIST_PAGE_SIZE = 50
+)
+
+// NormalizeLogin is the form a login is stored and looked up in: trimmed,
+// case folded and NFC normalized, so "Alice", "alice " and an "alice" typed
+// with decomposed accents all name the same user.
+func NormalizeLogin(login string) string {
+	login = strings.TrimSpace(login)
+	return norm.NFC.String(cases.Fold().String(login))
+}
+
+// ValidateLogin applies the field rules for a new login. Invisible format
+// characters, zero-width spaces and joiners and bidi controls among them,
+// are refused, as they would let two logins that look the same coexist.
+func ValidateLogin(login string) error {
+	if fieldErr := validate.Check("login", login, validate.Required()); fieldErr != nil {
+		return utils.FieldValidationError("login is required", validate.Errors{fieldErr})
+	}
+	for _, r := range login {
+		if unicode.Is(unicode.Cf, r) || unicode.IsControl(r) {
+			return utils.NewValidationError("invalid login", "login", map[string]any{
+				"code":    "invisible_characters",
+				"message": "login must not contain invisible characters",
+			})
+		}
+	}
+	return nil
 }
 
 type LoginRequest struct {
-	Login    string `json:"login"`
+	Login      string `json:"login"`
+	Password   string `json:"password"`
+	DeviceName string `json:"deviceName"`
+
+	// Website is a honeypot: hidden from people, filled in by bots
+	Website string `json:"website"`
+
+	// Token from passing the challenge a risky login is refused with
+	ChallengeToken string `json:"challengeToken"`
+
+	// Filled in from the request for the login history
+	IP         string `json:"-"`
+	UserAgent  string `json:"-"`
+	ClientType string `json:"-"`
+}
+
+// UserLookupRequest names the users to look up at once.
+type UserLookupRequest struct {
+	IDs []string `json:"ids"`
+}
+
+type RegisterRequest struct {
+	Login      string `json:"login"`
+	Password   string `json:"password"`
+	FirstName  string `json:"firstName"`
+	LastName   string `json:"lastName"`
+	DeviceName string `json:"deviceName"`
+	FormToken  string `json:"formToken"`
+	TosVersion string `json:"tosVersion"`
+
+	// Website is a honeypot: hidden from people, filled in by bots
+	Website string `json:"website"`
+
+	// Filled in from the request for the login history
+	IP         string `json:"-"`
+	UserAgent  string `json:"-"`
+	ClientType string `json:"-"`
+}
+
+// RegistrationValidation is the outcome of a registration dry run, field by
+// field, for a signup form to show next to each input.
+type RegistrationValidation struct {
+	Valid  bool                       `json:"valid"`
+	Fields map[string]FieldValidation `json:"fields"`
+}
+
+type FieldValidation struct {
+	OK      bool   `json:"ok"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Set records the outcome of field: ok when err is nil, otherwise the code
+// and message of the validation error.
+func (v *RegistrationValidation) Set(field string, err error) {
+	if v.Fields == nil {
+		v.Fields = make(map[string]FieldValidation)
+	}
+	if err == nil {
+		v.Fields[field] = FieldValidation{OK: true}
+		return
+	}
+
+	v.Valid = false
+	result := FieldValidation{Code: "invalid", Message: err.Error()}
+	var validationErr *utils.ValidationError
+	if errors.As(err, &validationErr) {
+		detail, _ := validationErr.Details[field].(map[string]any)
+		if code, ok := detail["code"].(string); ok {
+			result.Code = code
+		}
+		if message, ok := detail["message"].(string); ok {
+			result.Message = message
+		}
+	}
+	v.Fields[field] = result
+}
+
+type UserListPage struct {
+	Users   []any `json:"users"`
+	Page    int   `json:"page"`
+	HasMore bool  `json:"hasMore"`
+}
+
+// UpdateProfileRequest is a partial update, nil fields are left as they are.
+type UpdateProfileRequest struct {
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+}
+
+// AdminUpdateUserRequest is a profile update that can also change the admin
+// flag.
+type AdminUpdateUserRequest struct {
+	UpdateProfileRequest
+	IsAdmin *bool `json:"isAdmin"`
+}
+
+// Apply trims and validates the set fields and copies them onto user.
+func (r UpdateProfileRequest) Apply(user *User) error {
+	fields := []struct {
+		name   string
+		value  *string
+		target *string
+	}{
+		{"firstName", r.FirstName, &user.FirstName},
+		{"lastName", r.LastName, &user.LastName},
+	}
+
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+
+		value := strings.TrimSpace(*field.value)
+		if fieldErr := validate.Check(field.name, value, validate.Length(0, USER_NAME_MAX)); fieldErr != nil {
+			return utils.FieldValidationError("invalid profile", validate.Errors{fieldErr})
+		}
+		*field.target = value
+	}
+
+	return nil
+}
+
+// AcceptTermsRequest names the terms of service version being accepted,
+// which must be the current one.
+type AcceptTermsRequest struct {
+	TosVersion string `json:"tosVersion"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type ResetPasswordRequest struct {
 	Password string `json:"password"`
 }
 
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// UserExport is everything stored about a user, as handed to them on request.
+// The login history, which can be long, isn't held here but streamed as the
+// document's last key, loginHistory. It must never carry password hashes or
+// session tokens.
+type UserExport struct {
+	ExportedAt  APITime          `json:"exportedAt"`
+	Profile     UserDTO          `json:"profile"`
+	Sessions    []SessionSummary `json:"sessions"`
+	Preferences map[string]any   `json:"preferences"`
+	Activity    []map[string]any `json:"activity"`
+}
+
 func (u *User) BeforeCreate(tx *gorm.DB) error {
-	if u.Password != "" {
-		hashedPassword, err := utils.HashPassword(u.Password)
+	if u.Version == 0 {
+		u.Version = 1
+	}
+	// Callers hash with their own config first; the fallback keeps a
+	// plaintext password from ever being stored.
+	if u.Password != "" && !utils.IsPasswordHash(u.Password) {
+		hashedPassword, err := utils.HashPasswordWithGlobalConfig(u.Password)
 		if err != nil {
 			return logger.New("models").
 				File("User").
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnknownField = errors.New("unknown field")
+	ErrUnknownSort  = errors.New("unknown sort")
+)
+
+// UserField is a key of the user JSON a response can be narrowed to with
+// ?fields, and the column it is read from.
+type UserField struct {
+	Name   string
+	Column string
+}
+
+// UserFields lists every selectable field, in the order unknown names are
+// answered with. The password is never one.
+var UserFields = []UserField{
+	{Name: "id", Column: "id"},
+	{Name: "login", Column: "login"},
+	{Name: "firstName", Column: "first_name"},
+	{Name: "lastName", Column: "last_name"},
+	{Name: "isAdmin", Column: "is_admin"},
+	{Name: "version", Column: "version"},
+	{Name: "tosVersion", Column: "tos_version"},
+	{Name: "tosAcceptedAt", Column: "tos_accepted_at"},
+	{Name: "createdAt", Column: "created_at"},
+	{Name: "updatedAt", Column: "updated_at"},
+}
+
+// Lists are ordered and streamed by these, so they are read whichever fields
+// were asked for.
+var userKeyColumns = []string{"id", "created_at"}
+
+// UserFieldNames lists the names of UserFields.
+func UserFieldNames() []string {
+	names := make([]string, 0, len(UserFields))
+	for _, field := range UserFields {
+		names = append(names, field.Name)
+	}
+	return names
+}
+
+// FieldSelection is the fields a user response is narrowed to. Nil selects
+// every field.
+type FieldSelection []UserField
+
+// ParseUserFields reads a comma separated ?fields value. An empty one selects
+// every field; a name not in UserFields is an ErrUnknownField.
+func ParseUserFields(raw string) (FieldSelection, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var selection FieldSelection
+	for name := range strings.SplitSeq(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || selection.has(name) {
+			continue
+		}
+		field, ok := userField(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownField, name)
+		}
+		selection = append(selection, field)
+	}
+	return selection, nil
+}
+
+func userField(name string) (UserField, bool) {
+	for _, field := range UserFields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return UserField{}, false
+}
+
+func (s FieldSelection) has(name string) bool {
+	for _, field := range s {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Columns are the columns to read for the selection, always including the
+// ones lists are ordered by. Nil reads every column.
+func (s FieldSelection) Columns() []string {
+	if s == nil {
+		return nil
+	}
+	columns := append([]string{}, userKeyColumns...)
+	for _, field := range s {
+		if !slices.Contains(columns, field.Column) {
+			columns = append(columns, field.Column)
+		}
+	}
+	return columns
+}
+
+// Apply renders user with only the selected keys, or whole when nothing was
+// selected.
+func (s FieldSelection) Apply(user User) (any, error) {
+	if s == nil {
+		return user.DTO(true), nil
+	}
+
+	raw, err := json.Marshal(user.DTO(true))
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]json.RawMessage, len(s))
+	for _, field := range s {
+		selected[field.Name] = all[field.Name]
+	}
+	return selected, nil
+}
+
+// UserSortFields are the UserFields the user list can be sorted by.
+var UserSortFields = []string{"login", "firstName", "lastName", "isAdmin", "createdAt", "updatedAt"}
+
+// UserSort is the order the user list is read in. Users it leaves tied are
+// ordered by id, the same way.
+type UserSort struct {
+	Column string
+	Desc   bool
+}
+
+// DefaultUserSort lists the newest users first.
+var DefaultUserSort = UserSort{Column: "created_at", Desc: true}
+
+// ParseUserSort reads ?sort, a name from UserSortFields, and ?order, asc or
+// desc. Names are mapped to their column, so nothing a client sends reaches
+// the query as it is. With no sort the list is newest first, and order
+// alone turns it around; a sort alone is ascending. Anything else is an
+// ErrUnknownSort.
+func ParseUserSort(sort string, order string) (UserSort, error) {
+	sort, order = strings.TrimSpace(sort), strings.ToLower(strings.TrimSpace(order))
+
+	parsed := DefaultUserSort
+	if sort != "" {
+		field, ok := userField(sort)
+		if !ok || !slices.Contains(UserSortFields, sort) {
+			return UserSort{}, fmt.Errorf("%w: %q", ErrUnknownSort, sort)
+		}
+		parsed = UserSort{Column: field.Column}
+	}
+
+	switch order {
+	case "":
+	case "asc":
+		parsed.Desc = false
+	case "desc":
+		parsed.Desc = true
+	default:
+		return UserSort{}, fmt.Errorf("%w order: %q, use asc or desc", ErrUnknownSort, order)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUserFields(t *testing.T) {
+	selection, err := ParseUserFields("")
+	require.NoError(t, err)
+	assert.Nil(t, selection)
+	assert.Nil(t, selection.Columns())
+
+	selection, err = ParseUserFields(" login, firstName,,login ")
+	require.NoError(t, err)
+	assert.Equal(t, FieldSelection{
+		{Name: "login", Column: "login"},
+		{Name: "firstName", Column: "first_name"},
+	}, selection)
+	assert.Equal(t, []string{"id", "created_at", "login", "first_name"}, selection.Columns())
+
+	for _, raw := range []string{"password", "login,Login", "first_name"} {
+		_, err := ParseUserFields(raw)
+		assert.ErrorIs(t, err, ErrUnknownField, raw)
+	}
+}
+
+func TestParseUserSort(t *testing.T) {
+	tests := []struct {
+		sort  string
+		order string
+		want  UserSort
+	}{
+		{"", "", DefaultUserSort},
+		{"", "asc", UserSort{Column: "created_at"}},
+		{"login", "", UserSort{Column: "login"}},
+		{" firstName ", "DESC", UserSort{Column: "first_name", Desc: true}},
+		{"updatedAt", "asc", UserSort{Column: "updated_at"}},
+	}
+	for _, tt := range tests {
+		sort, err := ParseUserSort(tt.sort, tt.order)
+		require.NoError(t, err, tt.sort, tt.order)
+		assert.Equal(t, tt.want, sort, tt.sort, tt.order)
+	}
+
+	for _, raw := range [][2]string{
+		{"password", ""},
+		{"first_name", ""},
+		{"tosVersion", ""},
+		{"login; DROP TABLE users", ""},
+		{"login", "sideways"},
+		{"login", "desc, id"},
+	} {
+		_, err := ParseUserSort(raw[0], raw[1])
+		assert.ErrorIs(t, err, ErrUnknownSort, raw)
+	}
+}
+
+func TestFieldSelection_Apply(t *testing.T) {
+	createdAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	user := User{
+		BaseModel: BaseModel{ID: "user-1", CreatedAt: createdAt},
+		Login:     "jdoe",
+		FirstName: "Jane",
+		Password:  "hash",
+	}
+
+	whole, err := FieldSelection(nil).Apply(user)
+	require.NoError(t, err)
+	assert.Equal(t, user.DTO(true), whole)
+
+	selection, err := ParseUserFields("login,createdAt")
+	require.NoError(t, err)
+	rendered, err := selection.Apply(user)
+	require.NoError(t, err)
+	encoded, err := json.Marshal(rendered)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"login":"jdoe","createdAt":"2025-01-02T03:04:05.000Z"}`, string(encoded))
+}
@@ -2,11 +2,13 @@ package models
 
 import (
 	"fmt"
+	"server/internal/utils"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Test constants (moved from models to repositories)
@@ -567,3 +569,83 @@ func TestUser_ZeroValueComparison(t *testing.T) {
 	// Should no longer be equal
 	assert.NotEqual(t, user1, user2)
 }
+
+func TestNormalizeLogin(t *testing.T) {
+	testCases := []struct {
+		name     string
+		login    string
+		expected string
+	}{
+		{"already normalized", "alice", "alice"},
+		{"upper case", "ALICE", "alice"},
+		{"mixed case", "Alice", "alice"},
+		{"surrounding whitespace", "  alice \t\n", "alice"},
+		{"inner whitespace kept", "alice smith", "alice smith"},
+		{"decomposed accent composed", "e\u0301mile", "\u00e9mile"},
+		{"decomposed upper case accent", "E\u0301MILE", "\u00e9mile"},
+		{"non latin case", "ΑΛΙΚΗ", "αλικη"},
+		{"full case folding", "Straße", "strasse"},
+		{"email", " Jane.Doe@Example.COM ", "jane.doe@example.com"},
+		{"empty", "   ", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, NormalizeLogin(tc.login))
+			assert.Equal(t, tc.expected, NormalizeLogin(tc.expected), "normalizing twice changes nothing")
+		})
+	}
+}
+
+func TestValidateLogin(t *testing.T) {
+	testCases := []struct {
+		name  string
+		login string
+		code  string
+	}{
+		{"plain", "alice", ""},
+		{"accented", "\u00e9mile", ""},
+		{"empty", "", "required"},
+		{"zero-width space", "ali\u200bce", "invisible_characters"},
+		{"zero-width joiner", "alice\u200d", "invisible_characters"},
+		{"byte order mark", "\ufeffalice", "invisible_characters"},
+		{"right-to-left override", "\u202ealice", "invisible_characters"},
+		{"bidi isolate", "alice\u2066", "invisible_characters"},
+		{"control character", "ali\x00ce", "invisible_characters"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateLogin(tc.login)
+			if tc.code == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErr *utils.ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.Equal(t, tc.code, validationErr.Details["login"].(map[string]any)["code"])
+		})
+	}
+}
+
+func TestUser_ChangedFields(t *testing.T) {
+	acceptedAt := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	before := User{FirstName: "Jane", LastName: "Doe", Login: "jane", Version: 3, TosAcceptedAt: &acceptedAt}
+
+	after := before
+	after.Version = 4
+	after.Password = "new-hash"
+	after.PepperVersion = 2
+	assert.Empty(t, after.ChangedFields(before), "nothing the user is shown changed")
+
+	sameInstant := acceptedAt.In(time.FixedZone("CEST", 2*60*60))
+	after.TosAcceptedAt = &sameInstant
+	assert.Empty(t, after.ChangedFields(before))
+
+	after.LastName = "Smith"
+	after.IsAdmin = true
+	after.TosVersion = "2026-10"
+	after.TosAcceptedAt = nil
+	assert.Equal(t, []string{"lastName", "isAdmin", "tosVersion", "tosAcceptedAt"}, after.ChangedFields(before))
+}
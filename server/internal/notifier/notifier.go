@@ -0,0 +1,41 @@
+package notifier
+
+import "time"
+
+// Message is the envelope sent to websocket clients. What actually goes over
+// the wire depends on the client's negotiated protocol version.
+type Message struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	Channel   string         `json:"channel,omitempty"`
+	Action    string         `json:"action,omitempty"`
+	UserID    string         `json:"userId,omitempty"`
+	Data      map[string]any `json:"data,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+
+	// Version is declared by clients on inbound messages
+	Version int `json:"version,omitempty"`
+	// Sequence and AckID are only sent to v2 clients
+	Sequence uint64 `json:"sequence,omitempty"`
+	AckID    string `json:"ackId,omitempty"`
+	// UserSequence is the message's place in the stream of messages to its
+	// user, the same on each of their connections; v2 only
+	UserSequence uint64 `json:"userSequence,omitempty"`
+}
+
+// WebsocketNotifier pushes to the clients connected to this instance. The
+// websocket manager implements it; controllers depend on it instead, so
+// their tests can use testsupport.MockWebsocketNotifier.
+type WebsocketNotifier interface {
+	// BroadcastUserLogin tells every authenticated client a user logged in
+	BroadcastUserLogin(userID string, userData map[string]any)
+	// SendToUser sends message to each of the user's connections. It fails
+	// only when userID isn't a user ID.
+	SendToUser(userID string, message Message) error
+	// BroadcastToChannel sends message to every client subscribed to
+	// channel, skipping those that can't keep up.
+	BroadcastToChannel(channel string, message Message) (delivered, skipped int)
+	// DisconnectUser closes each of the user's connections, telling them
+	// reason first, and returns how many it closed.
+	DisconnectUser(userID string, reason string) int
+}
@@ -0,0 +1,45 @@
+package repositories
+
+import (
+	"context"
+	"server/internal/database"
+	"server/internal/logger"
+	. "server/internal/models"
+	"time"
+)
+
+type announcementRepository struct {
+	db  database.DB
+	log logger.Logger
+}
+
+func NewAnnouncementRepository(db database.DB) AnnouncementRepository {
+	return &announcementRepository{
+		db:  db,
+		log: logger.New("announcementRepository"),
+	}
+}
+
+func (r *announcementRepository) Create(ctx context.Context, announcement *Announcement) error {
+	log := r.log.Function("Create")
+
+	if err := r.db.SQLWithContext(ctx).Create(announcement).Error; err != nil {
+		return log.Err("failed to create announcement", err, "title", announcement.Title)
+	}
+
+	return nil
+}
+
+// ListActive returns announcements that haven't expired at now, newest first.
+func (r *announcementRepository) ListActive(ctx context.Context, now time.Time) ([]Announcement, error) {
+	log := r.log.Function("ListActive")
+
+	announcements := []Announcement{}
+	query := r.db.SQLWithContext(ctx).Where("expires_at > ?", now.UTC())
+	if err := orderBy(query, OrderBy{Column: "created_at", Desc: true}).
+		Find(&announcements).Error; err != nil {
+		return nil, log.Err("failed to list announcements", err)
+	}
+
+	return announcements, nil
+}
@@ -0,0 +1,61 @@
+package repositories
+
+import (
+	"context"
+	"path/filepath"
+	"server/internal/database"
+	. "server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupAnnouncementTest(t *testing.T) AnnouncementRepository {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Announcement{}))
+
+	return NewAnnouncementRepository(database.DB{SQL: db})
+}
+
+func TestAnnouncementRepository_CreateAndListActive(t *testing.T) {
+	repo := setupAnnouncementTest(t)
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	zone := time.FixedZone("UTC-5", -5*60*60)
+	for _, announcement := range []Announcement{
+		{Title: "expired", ExpiresAt: now.Add(-time.Minute)},
+		{Title: "expires now", ExpiresAt: now},
+		{Title: "later", ExpiresAt: now.Add(time.Hour)},
+		{Title: "other zone", ExpiresAt: now.Add(time.Minute).In(zone)},
+	} {
+		announcement.Body = "body"
+		announcement.Severity = ANNOUNCEMENT_SEVERITY_INFO
+		require.NoError(t, repo.Create(ctx, &announcement))
+		assert.NotEmpty(t, announcement.ID)
+	}
+
+	active, err := repo.ListActive(ctx, now)
+	require.NoError(t, err)
+
+	titles := make([]string, 0, len(active))
+	for _, announcement := range active {
+		titles = append(titles, announcement.Title)
+		assert.Equal(t, time.UTC, announcement.ExpiresAt.Location())
+	}
+	assert.ElementsMatch(t, []string{"later", "other zone"}, titles)
+
+	active, err = repo.ListActive(ctx, now.Add(30*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, active, 1)
+	assert.Equal(t, "later", active[0].Title)
+
+	active, err = repo.ListActive(ctx, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, active)
+}
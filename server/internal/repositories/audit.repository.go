@@ -0,0 +1,184 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"server/internal/database"
+	"server/internal/logger"
+	. "server/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Rows read or deleted per statement when archiving, small enough that
+	// sqlite's write lock is never held for long.
+	AUDIT_ARCHIVE_BATCH_SIZE = 1000
+
+	// Rows read per statement when streaming the audit log
+	AUDIT_STREAM_BATCH_SIZE = 500
+)
+
+type auditRepository struct {
+	db  database.DB
+	log logger.Logger
+}
+
+func NewAuditRepository(db database.DB) AuditRepository {
+	return &auditRepository{
+		db:  db,
+		log: logger.New("auditRepository"),
+	}
+}
+
+func (r *auditRepository) Create(ctx context.Context, entry *AuditLog) error {
+	log := r.log.Function("Create")
+
+	if err := r.db.SQLWithContext(ctx).Create(entry).Error; err != nil {
+		return log.Err("failed to create audit entry", err, "action", entry.Action, "actorID", entry.ActorID)
+	}
+
+	return nil
+}
+
+// List returns a page of audit entries, newest first, from the start or
+// after cursor, and the cursor of the page after it, empty on the last page.
+// A cursor that doesn't decode is ErrInvalidCursor.
+func (r *auditRepository) List(ctx context.Context, cursor string) ([]AuditLog, string, error) {
+	log := r.log.Function("List")
+
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var entries []AuditLog
+	if err := afterCursor(r.db.SQLWithContext(ctx), after).
+		Limit(AUDIT_LOG_PAGE_SIZE + 1).
+		Find(&entries).Error; err != nil {
+		return nil, "", log.Err("failed to list audit entries", err, "cursor", cursor)
+	}
+
+	entries, next := cursorPage(entries, AUDIT_LOG_PAGE_SIZE, func(entry AuditLog) Cursor {
+		return Cursor{CreatedAt: entry.CreatedAt, ID: entry.ID}
+	})
+	return entries, next, nil
+}
+
+// Stream hands every entry to each, newest first like List, one batch at a
+// time. Batches follow on from the last entry of the one before rather than
+// an offset, so each is a cheap indexed read however deep into the table it
+// is, and the batch slice is reused so memory stays at one batch. It stops at
+// the first error each returns, and returns how many entries were handed on.
+func (r *auditRepository) Stream(ctx context.Context, each func(entries []AuditLog) error) (int64, error) {
+	log := r.log.Function("Stream")
+
+	var (
+		entries  []AuditLog
+		streamed int64
+	)
+	for {
+		var after *Cursor
+		if len(entries) > 0 {
+			last := entries[len(entries)-1]
+			after = &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		}
+		query := afterCursor(r.db.SQLWithContext(ctx), after).Limit(AUDIT_STREAM_BATCH_SIZE)
+
+		entries = entries[:0]
+		if err := query.Find(&entries).Error; err != nil {
+			return streamed, log.Err("failed to stream audit entries", err, "streamed", streamed)
+		}
+		if len(entries) == 0 {
+			return streamed, nil
+		}
+
+		if err := each(entries); err != nil {
+			return streamed, err
+		}
+		streamed += int64(len(entries))
+		if len(entries) < AUDIT_STREAM_BATCH_SIZE {
+			return streamed, nil
+		}
+	}
+}
+
+// ExportBefore hands every entry created before cutoff to export, one batch
+// at a time, and returns how many there were.
+func (r *auditRepository) ExportBefore(
+	ctx context.Context,
+	cutoff time.Time,
+	export func(entries []AuditLog) error,
+) (int64, error) {
+	log := r.log.Function("ExportBefore")
+
+	var entries []AuditLog
+	result := r.db.SQLWithContext(ctx).
+		Where("created_at < ?", cutoff.UTC()).
+		FindInBatches(&entries, AUDIT_ARCHIVE_BATCH_SIZE, func(tx *gorm.DB, batch int) error {
+			return export(entries)
+		})
+	if result.Error != nil {
+		return 0, log.Err("failed to export audit entries", result.Error, "cutoff", cutoff)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// DeleteBefore removes up to limit of the oldest entries created before
+// cutoff and returns how many it removed.
+func (r *auditRepository) DeleteBefore(ctx context.Context, cutoff time.Time, limit int) (int64, error) {
+	log := r.log.Function("DeleteBefore")
+
+	db := r.db.SQLWithContext(ctx)
+	oldest := orderBy(db.Model(&AuditLog{}).Select("id").Where("created_at < ?", cutoff.UTC()),
+		OrderBy{Column: "created_at"}).
+		Limit(limit)
+
+	result := db.Where("id IN (?)", oldest).Delete(&AuditLog{})
+	if result.Error != nil {
+		return 0, log.Err("failed to delete audit entries", result.Error, "cutoff", cutoff)
+	}
+
+	return result.RowsAffected, nil
+}
+
+func (r *auditRepository) CreateArchive(ctx context.Context, archive *AuditArchive) error {
+	log := r.log.Function("CreateArchive")
+
+	if err := r.db.SQLWithContext(ctx).Create(archive).Error; err != nil {
+		return log.Err("failed to record audit archive", err, "file", archive.File)
+	}
+
+	return nil
+}
+
+// ListArchives returns every audit archive, newest first.
+func (r *auditRepository) ListArchives(ctx context.Context) ([]AuditArchive, error) {
+	log := r.log.Function("ListArchives")
+
+	archives := []AuditArchive{}
+	if err := orderBy(r.db.SQLWithContext(ctx), OrderBy{Column: "created_at", Desc: true}).
+		Find(&archives).Error; err != nil {
+		return nil, log.Err("failed to list audit archives", err)
+	}
+
+	return archives, nil
+}
+
+// GetArchive returns ErrNotFound when no archive has id.
+func (r *auditRepository) GetArchive(ctx context.Context, id string) (*AuditArchive, error) {
+	log := r.log.Function("GetArchive")
+
+	var archive AuditArchive
+	err := r.db.SQLWithContext(ctx).First(&archive, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, log.Err("failed to get audit archive", err, "id", id)
+	}
+
+	return &archive, nil
+}
@@ -0,0 +1,219 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"server/internal/database"
+	. "server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupAuditTest(t *testing.T) (AuditRepository, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&AuditLog{}, &AuditArchive{}))
+
+	return NewAuditRepository(database.DB{SQL: db}), db
+}
+
+func TestAuditRepository_ListNewestFirst(t *testing.T) {
+	repo, _ := setupAuditTest(t)
+	ctx := context.Background()
+
+	base := time.Now().Add(-time.Hour)
+	total := AUDIT_LOG_PAGE_SIZE + 3
+	for i := range total {
+		require.NoError(t, repo.Create(ctx, &AuditLog{
+			CreatedAt: base.Add(time.Duration(i) * time.Second),
+			Action:    AUDIT_ACTION_IMPERSONATION_STARTED,
+			Source:    AUDIT_SOURCE_API,
+			Details:   map[string]any{"index": i},
+		}))
+	}
+
+	first, next, err := repo.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, first, AUDIT_LOG_PAGE_SIZE)
+	assert.NotEmpty(t, next)
+	assert.Equal(t, float64(total-1), first[0].Details["index"])
+
+	second, next, err := repo.List(ctx, next)
+	require.NoError(t, err)
+	assert.Len(t, second, 3)
+	assert.Empty(t, next)
+	assert.Equal(t, float64(0), second[2].Details["index"])
+}
+
+func TestAuditRepository_ListCursorSurvivesNewEntries(t *testing.T) {
+	repo, db := setupAuditTest(t)
+	ctx := context.Background()
+
+	// Pairs of entries share a timestamp, so one straddles the page boundary
+	base := time.Now().Add(-time.Hour).UTC()
+	total := AUDIT_LOG_PAGE_SIZE + 5
+	entries := make([]AuditLog, total)
+	for i := range entries {
+		entries[i] = AuditLog{
+			CreatedAt: base.Add(time.Duration(i/2) * time.Second),
+			Action:    AUDIT_ACTION_IMPERSONATION_STARTED,
+			Source:    AUDIT_SOURCE_API,
+		}
+	}
+	require.NoError(t, db.CreateInBatches(entries, 200).Error)
+
+	first, next, err := repo.List(ctx, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, next)
+
+	// Entries written while the first page is read would shift an offset
+	for range 3 {
+		require.NoError(t, repo.Create(ctx, &AuditLog{
+			Action: AUDIT_ACTION_IMPERSONATION_STARTED,
+			Source: AUDIT_SOURCE_API,
+		}))
+	}
+
+	second, next, err := repo.List(ctx, next)
+	require.NoError(t, err)
+	assert.Empty(t, next)
+
+	seen := make(map[string]bool, total)
+	for _, entry := range append(first, second...) {
+		assert.False(t, seen[entry.ID], "entry %s listed twice", entry.ID)
+		seen[entry.ID] = true
+	}
+	for _, entry := range entries {
+		assert.True(t, seen[entry.ID], "entry %s skipped", entry.ID)
+	}
+	assert.Len(t, seen, total)
+}
+
+func TestAuditRepository_ListInvalidCursor(t *testing.T) {
+	repo, _ := setupAuditTest(t)
+
+	_, _, err := repo.List(context.Background(), "not a cursor")
+
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestAuditRepository_StreamNewestFirst(t *testing.T) {
+	repo, db := setupAuditTest(t)
+	ctx := context.Background()
+
+	// Pairs of entries share a timestamp, so some straddle a batch boundary
+	base := time.Now().Add(-time.Hour).UTC()
+	total := 2*AUDIT_STREAM_BATCH_SIZE + 7
+	entries := make([]AuditLog, total)
+	for i := range entries {
+		entries[i] = AuditLog{
+			CreatedAt: base.Add(time.Duration(i/2) * time.Second),
+			Action:    AUDIT_ACTION_IMPERSONATION_STARTED,
+			Source:    AUDIT_SOURCE_API,
+			Details:   map[string]any{"index": i},
+		}
+	}
+	require.NoError(t, db.CreateInBatches(entries, 200).Error)
+
+	var batches []int
+	seen := make(map[string]bool, total)
+	var previous *AuditLog
+	streamed, err := repo.Stream(ctx, func(batch []AuditLog) error {
+		batches = append(batches, len(batch))
+		for _, entry := range batch {
+			assert.False(t, seen[entry.ID], "entry %s streamed twice", entry.ID)
+			seen[entry.ID] = true
+			if previous != nil {
+				assert.False(t, entry.CreatedAt.After(previous.CreatedAt), "newest first")
+			}
+			previous = &entry
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(total), streamed)
+	assert.Len(t, seen, total)
+	assert.Equal(t, []int{AUDIT_STREAM_BATCH_SIZE, AUDIT_STREAM_BATCH_SIZE, 7}, batches)
+}
+
+func TestAuditRepository_StreamStopsOnError(t *testing.T) {
+	repo, db := setupAuditTest(t)
+	entries := make([]AuditLog, AUDIT_STREAM_BATCH_SIZE+1)
+	for i := range entries {
+		entries[i] = AuditLog{Action: "action", Source: AUDIT_SOURCE_API}
+	}
+	require.NoError(t, db.CreateInBatches(entries, 200).Error)
+
+	stop := errors.New("stop")
+	calls := 0
+	streamed, err := repo.Stream(context.Background(), func(batch []AuditLog) error {
+		calls++
+		return stop
+	})
+
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+	assert.Zero(t, streamed)
+}
+
+func TestAuditRepository_DeleteBeforeOldestFirst(t *testing.T) {
+	repo, db := setupAuditTest(t)
+	ctx := context.Background()
+
+	cutoff := time.Now().Add(-24 * time.Hour)
+	for i := range 5 {
+		require.NoError(t, repo.Create(ctx, &AuditLog{
+			CreatedAt: cutoff.Add(-time.Duration(5-i) * time.Hour),
+			Action:    "old",
+			Source:    AUDIT_SOURCE_API,
+			TargetID:  string(rune('a' + i)),
+		}))
+	}
+	require.NoError(t, repo.Create(ctx, &AuditLog{Action: "new", Source: AUDIT_SOURCE_API}))
+
+	deleted, err := repo.DeleteBefore(ctx, cutoff, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	var left []AuditLog
+	require.NoError(t, db.Order("created_at ASC").Find(&left).Error)
+	require.Len(t, left, 4)
+	assert.Equal(t, "c", left[0].TargetID)
+
+	deleted, err = repo.DeleteBefore(ctx, cutoff, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+
+	var count int64
+	require.NoError(t, db.Model(&AuditLog{}).Where("action = ?", "new").Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestAuditRepository_GetArchive(t *testing.T) {
+	repo, _ := setupAuditTest(t)
+	ctx := context.Background()
+
+	archive := &AuditArchive{
+		File:     "audit-a-b.jsonl.gz",
+		OldestAt: time.Now().Add(-48 * time.Hour),
+		NewestAt: time.Now().Add(-24 * time.Hour),
+		Count:    2,
+		Size:     100,
+		Checksum: "abc",
+	}
+	require.NoError(t, repo.CreateArchive(ctx, archive))
+
+	found, err := repo.GetArchive(ctx, archive.ID)
+	require.NoError(t, err)
+	assert.Equal(t, archive.File, found.File)
+
+	_, err = repo.GetArchive(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
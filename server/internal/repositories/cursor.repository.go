@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCursor is a cursor that doesn't decode to a position in a list,
+// because it was mangled, tampered with or made up.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is a position in a list ordered newest first by created_at, then
+// id. Lists paged by cursor carry on after the last row they returned rather
+// than skipping an offset, so rows added between pages neither repeat nor
+// push others out of sight.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// Encode renders the cursor for clients, who treat it as opaque.
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID))
+}
+
+// DecodeCursor reads a cursor Encode rendered. An empty one is the start of
+// the list, nil.
+func DecodeCursor(encoded string) (*Cursor, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	createdAt, id, ok := strings.Cut(string(decoded), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	// Rows paged by cursor all have UUID keys
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{CreatedAt: parsed.UTC(), ID: id}, nil
+}
+
+// afterCursor orders query newest first and, given a cursor, starts it after
+// the cursor's row.
+func afterCursor(query *gorm.DB, cursor *Cursor) *gorm.DB {
+	query = orderBy(query, OrderBy{Column: "created_at", Desc: true})
+	if cursor == nil {
+		return query
+	}
+	return query.Where(
+		"created_at < ? OR (created_at = ? AND id < ?)",
+		cursor.CreatedAt, cursor.CreatedAt, cursor.ID,
+	)
+}
+
+// cursorPage trims rows, read with a limit of size + 1, to size and returns
+// the cursor of the last row kept when there are more, empty on the last
+// page.
+func cursorPage[T any](rows []T, size int, position func(row T) Cursor) ([]T, string) {
+	if len(rows) <= size {
+		return rows, ""
+	}
+	rows = rows[:size]
+	return rows, position(rows[size-1]).Encode()
+}
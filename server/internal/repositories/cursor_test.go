@@ -0,0 +1,45 @@
+package repositories
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor_RoundTrip(t *testing.T) {
+	cursor := Cursor{CreatedAt: time.Date(2026, 10, 15, 9, 30, 0, 123456789, time.UTC), ID: uuid.NewString()}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+
+	require.NoError(t, err)
+	assert.Equal(t, cursor, *decoded)
+}
+
+func TestDecodeCursor_EmptyIsStart(t *testing.T) {
+	decoded, err := DecodeCursor("")
+
+	require.NoError(t, err)
+	assert.Nil(t, decoded)
+}
+
+func TestDecodeCursor_Invalid(t *testing.T) {
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	valid := Cursor{CreatedAt: time.Now(), ID: uuid.NewString()}.Encode()
+
+	for name, cursor := range map[string]string{
+		"not base64":   "%%%",
+		"no separator": encode("2026-10-15T09:30:00Z"),
+		"bad time":     encode("yesterday|" + uuid.NewString()),
+		"bad id":       encode("2026-10-15T09:30:00Z|1 OR 1=1"),
+		"tampered":     valid[:len(valid)-4] + "AAAA",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeCursor(cursor)
+			assert.ErrorIs(t, err, ErrInvalidCursor)
+		})
+	}
+}
@@ -2,16 +2,35 @@ package repositories
 
 import (
 	"context"
+	"errors"
 	"server/config"
+	"server/internal/database"
 	. "server/internal/models"
+	"time"
 )
 
+// ErrNotFound is returned by the user, session and audit archive
+// repositories when the record doesn't exist. Any other error means the store itself failed.
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicate is returned when a write would break a unique constraint, such
+// as creating a user with a login that is already taken.
+var ErrDuplicate = errors.New("record already exists")
+
+// ErrReadOnly is returned by every write to the database while it is a
+// read-only replica.
+var ErrReadOnly = database.ErrReadOnly
+
 type UserRepository interface {
 	GetByID(ctx context.Context, id string) (*User, error)
+	GetByIDs(ctx context.Context, ids []string) ([]User, error)
 	GetByLogin(ctx context.Context, login string) (*User, error)
 	Create(ctx context.Context, user *User, config config.Config) error
 	Update(ctx context.Context, user *User) error
+	UpdateProfile(ctx context.Context, user *User, expectedVersion int) error
 	Delete(ctx context.Context, id string) error
+	List(ctx context.Context, page int, sort UserSort, columns []string) ([]User, bool, error)
+	Stream(ctx context.Context, columns []string, each func(users []User) error) (int64, error)
 }
 
 type AdminRepository interface {
@@ -20,7 +39,51 @@ type AdminRepository interface {
 
 type SessionRepository interface {
 	Create(ctx context.Context, session *Session, config config.Config) error
+	Extend(ctx context.Context, session *Session, expiresAt time.Time, config config.Config) error
 	GetByID(ctx context.Context, id string) (*Session, error)
 	Delete(ctx context.Context, id string) error
+	ListByUser(ctx context.Context, userID string) ([]*Session, error)
+	DeleteByUser(ctx context.Context, userID string) error
+	CountActive(ctx context.Context) (int64, error)
+	ReapExpired(ctx context.Context, expiredBefore time.Time, limit int) (int, error)
+}
+
+type LoginEventRepository interface {
+	Create(ctx context.Context, event *LoginEvent) error
+	ListSuccessful(ctx context.Context, userID string, cursor string) ([]LoginEvent, string, error)
+	StreamByUser(ctx context.Context, userID string, each func(events []LoginEvent) error) (int64, error)
+	CountFailedSince(ctx context.Context, userID string, since time.Time) (int64, error)
+	HasSucceededFromIP(ctx context.Context, userID string, ip string) (bool, error)
+	RecentlyActiveUsers(ctx context.Context, since time.Time, limit int) ([]string, error)
+	AnonymizeByUser(ctx context.Context, userID string) (int64, error)
+	DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+type AuditRepository interface {
+	Create(ctx context.Context, entry *AuditLog) error
+	List(ctx context.Context, cursor string) ([]AuditLog, string, error)
+	Stream(ctx context.Context, each func(entries []AuditLog) error) (int64, error)
+	ExportBefore(ctx context.Context, cutoff time.Time, export func(entries []AuditLog) error) (int64, error)
+	DeleteBefore(ctx context.Context, cutoff time.Time, limit int) (int64, error)
+	CreateArchive(ctx context.Context, archive *AuditArchive) error
+	ListArchives(ctx context.Context) ([]AuditArchive, error)
+	GetArchive(ctx context.Context, id string) (*AuditArchive, error)
+}
+
+type StatsRepository interface {
+	CountUsers(ctx context.Context, since time.Time) (int64, error)
+	LoginsPerDay(ctx context.Context, since time.Time, days int) ([]DailyCount, error)
+	RegistrationsPerDay(ctx context.Context, since time.Time, days int) ([]DailyCount, error)
 }
 
+type AnnouncementRepository interface {
+	Create(ctx context.Context, announcement *Announcement) error
+	ListActive(ctx context.Context, now time.Time) ([]Announcement, error)
+}
+
+type PreferenceRepository interface {
+	ListByUser(ctx context.Context, userID string) ([]UserPreference, error)
+	Get(ctx context.Context, userID string, key string) (*UserPreference, error)
+	Set(ctx context.Context, preference *UserPreference) error
+	Delete(ctx context.Context, userID string, key string) error
+}
@@ -0,0 +1,193 @@
+package repositories
+
+import (
+	"context"
+	"server/internal/database"
+	"server/internal/logger"
+	. "server/internal/models"
+	"time"
+)
+
+const (
+	LOGIN_HISTORY_PAGE_SIZE       = 20
+	LOGIN_EVENT_STREAM_BATCH_SIZE = 500
+	LOGIN_EVENT_RETENTION         = 180 * 24 * time.Hour // 180 days
+)
+
+type loginEventRepository struct {
+	db  database.DB
+	log logger.Logger
+}
+
+func NewLoginEventRepository(db database.DB) LoginEventRepository {
+	return &loginEventRepository{
+		db:  db,
+		log: logger.New("loginEventRepository"),
+	}
+}
+
+func (r *loginEventRepository) Create(ctx context.Context, event *LoginEvent) error {
+	log := r.log.Function("Create")
+
+	query := r.db.SQLWithContext(ctx)
+	if event.UserID == "" {
+		// Attempts for unknown logins are stored without a user, which keeps
+		// them clear of the users foreign key
+		query = query.Omit("UserID")
+	}
+	if err := query.Create(event).Error; err != nil {
+		return log.Err("failed to create login event", err, "userID", event.UserID)
+	}
+
+	return nil
+}
+
+// ListSuccessful returns a page of successful logins for a user, newest
+// first, from the start or after cursor, and the cursor of the page after it,
+// empty on the last page. A cursor that doesn't decode is ErrInvalidCursor.
+func (r *loginEventRepository) ListSuccessful(
+	ctx context.Context,
+	userID string,
+	cursor string,
+) ([]LoginEvent, string, error) {
+	log := r.log.Function("ListSuccessful")
+
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var events []LoginEvent
+	query := r.db.SQLWithContext(ctx).Where("user_id = ? AND success = ?", userID, true)
+	if err := afterCursor(query, after).
+		Limit(LOGIN_HISTORY_PAGE_SIZE + 1).
+		Find(&events).Error; err != nil {
+		return nil, "", log.Err("failed to list login events", err, "userID", userID)
+	}
+
+	events, next := cursorPage(events, LOGIN_HISTORY_PAGE_SIZE, func(event LoginEvent) Cursor {
+		return Cursor{CreatedAt: event.CreatedAt, ID: event.ID}
+	})
+	return events, next, nil
+}
+
+// StreamByUser hands every recorded attempt for a user to each, oldest
+// first, one batch at a time, following on from the last event of the batch
+// before as the audit log stream does. It stops at the first error each
+// returns, and returns how many events were handed on.
+func (r *loginEventRepository) StreamByUser(
+	ctx context.Context,
+	userID string,
+	each func(events []LoginEvent) error,
+) (int64, error) {
+	log := r.log.Function("StreamByUser")
+
+	var (
+		events   []LoginEvent
+		streamed int64
+	)
+	for {
+		query := orderBy(r.db.SQLWithContext(ctx).Where("user_id = ?", userID), OrderBy{Column: "created_at"}).
+			Limit(LOGIN_EVENT_STREAM_BATCH_SIZE)
+		if len(events) > 0 {
+			last := events[len(events)-1]
+			query = query.Where("created_at > ? OR (created_at = ? AND id > ?)", last.CreatedAt, last.CreatedAt, last.ID)
+		}
+
+		events = events[:0]
+		if err := query.Find(&events).Error; err != nil {
+			return streamed, log.Err("failed to stream login events", err, "userID", userID, "streamed", streamed)
+		}
+		if len(events) == 0 {
+			return streamed, nil
+		}
+
+		if err := each(events); err != nil {
+			return streamed, err
+		}
+		streamed += int64(len(events))
+		if len(events) < LOGIN_EVENT_STREAM_BATCH_SIZE {
+			return streamed, nil
+		}
+	}
+}
+
+// CountFailedSince counts a user's failed logins at or after since.
+func (r *loginEventRepository) CountFailedSince(ctx context.Context, userID string, since time.Time) (int64, error) {
+	log := r.log.Function("CountFailedSince")
+
+	var failed int64
+	if err := r.db.SQLWithContext(ctx).
+		Model(&LoginEvent{}).
+		Where("user_id = ? AND success = ? AND created_at >= ?", userID, false, since).
+		Count(&failed).Error; err != nil {
+		return 0, log.Err("failed to count failed logins", err, "userID", userID)
+	}
+
+	return failed, nil
+}
+
+// RecentlyActiveUsers returns the IDs of up to limit users who logged in
+// successfully at or after since, the most recent login first.
+func (r *loginEventRepository) RecentlyActiveUsers(ctx context.Context, since time.Time, limit int) ([]string, error) {
+	log := r.log.Function("RecentlyActiveUsers")
+
+	userIDs := []string{}
+	if err := r.db.SQLWithContext(ctx).
+		Model(&LoginEvent{}).
+		Where("user_id != '' AND success = ? AND created_at >= ?", true, since).
+		Group("user_id").
+		Order("MAX(created_at) DESC").
+		// Grouped rows have no id; each user is a row of its own
+		Order("user_id DESC").
+		Limit(limit).
+		Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, log.Err("failed to list recently active users", err)
+	}
+
+	return userIDs, nil
+}
+
+// HasSucceededFromIP reports whether a user has ever logged in from ip.
+// Failed attempts don't count, so an attacker can't make their IP known.
+func (r *loginEventRepository) HasSucceededFromIP(ctx context.Context, userID string, ip string) (bool, error) {
+	log := r.log.Function("HasSucceededFromIP")
+
+	var found int64
+	if err := r.db.SQLWithContext(ctx).
+		Model(&LoginEvent{}).
+		Where("user_id = ? AND ip = ? AND success = ?", userID, ip, true).
+		Limit(1).
+		Count(&found).Error; err != nil {
+		return false, log.Err("failed to look up login IP", err, "userID", userID)
+	}
+
+	return found > 0, nil
+}
+
+// AnonymizeByUser clears the identifying columns of a user's login events. The
+// rows themselves are kept so login counts stay accurate.
+func (r *loginEventRepository) AnonymizeByUser(ctx context.Context, userID string) (int64, error) {
+	log := r.log.Function("AnonymizeByUser")
+
+	result := r.db.SQLWithContext(ctx).
+		Model(&LoginEvent{}).
+		Where("user_id = ?", userID).
+		Updates(map[string]any{"ip": "", "user_agent": ""})
+	if result.Error != nil {
+		return 0, log.Err("failed to anonymize login events", result.Error, "userID", userID)
+	}
+
+	return result.RowsAffected, nil
+}
+
+func (r *loginEventRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	log := r.log.Function("DeleteOlderThan")
+
+	result := r.db.SQLWithContext(ctx).Where("created_at < ?", cutoff).Delete(&LoginEvent{})
+	if result.Error != nil {
+		return 0, log.Err("failed to prune login events", result.Error, "cutoff", cutoff)
+	}
+
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,253 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"server/internal/database"
+	. "server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupLoginEventTest(t *testing.T) (LoginEventRepository, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&LoginEvent{}))
+
+	return NewLoginEventRepository(database.DB{SQL: db}), db
+}
+
+// streamLoginEvents collects every event StreamByUser hands on for userID.
+func streamLoginEvents(t *testing.T, repo LoginEventRepository, userID string) []LoginEvent {
+	t.Helper()
+
+	var events []LoginEvent
+	_, err := repo.StreamByUser(context.Background(), userID, func(batch []LoginEvent) error {
+		events = append(events, batch...)
+		return nil
+	})
+	require.NoError(t, err)
+	return events
+}
+
+func TestLoginEventRepository_StreamByUserOldestFirst(t *testing.T) {
+	repo, db := setupLoginEventTest(t)
+	ctx := context.Background()
+
+	// Pairs of events share a timestamp, so some straddle a batch boundary
+	base := time.Now().Add(-time.Hour).UTC()
+	total := 2*LOGIN_EVENT_STREAM_BATCH_SIZE + 7
+	events := make([]LoginEvent, total)
+	for i := range events {
+		events[i] = LoginEvent{UserID: "user-1", CreatedAt: base.Add(time.Duration(i/2) * time.Second)}
+	}
+	require.NoError(t, db.CreateInBatches(events, 200).Error)
+	require.NoError(t, db.Create(&LoginEvent{UserID: "user-2"}).Error)
+
+	var batches []int
+	seen := make(map[string]bool, total)
+	var previous *LoginEvent
+	streamed, err := repo.StreamByUser(ctx, "user-1", func(batch []LoginEvent) error {
+		batches = append(batches, len(batch))
+		for _, event := range batch {
+			assert.False(t, seen[event.ID], "event %s streamed twice", event.ID)
+			seen[event.ID] = true
+			assert.Equal(t, "user-1", event.UserID)
+			if previous != nil {
+				assert.False(t, event.CreatedAt.Before(previous.CreatedAt), "oldest first")
+			}
+			previous = &event
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(total), streamed)
+	assert.Len(t, seen, total)
+	assert.Equal(t, []int{LOGIN_EVENT_STREAM_BATCH_SIZE, LOGIN_EVENT_STREAM_BATCH_SIZE, 7}, batches)
+}
+
+func TestLoginEventRepository_ListSuccessful_Pagination(t *testing.T) {
+	repo, db := setupLoginEventTest(t)
+	ctx := context.Background()
+
+	base := time.Now().Add(-time.Hour)
+	total := LOGIN_HISTORY_PAGE_SIZE + 5
+	for i := range total {
+		require.NoError(t, db.Create(&LoginEvent{
+			UserID:    "user-1",
+			CreatedAt: base.Add(time.Duration(i) * time.Second),
+			IP:        fmt.Sprintf("10.0.0.%d", i),
+			Success:   true,
+		}).Error)
+	}
+	require.NoError(t, db.Create(&LoginEvent{UserID: "user-1", Success: false}).Error)
+	require.NoError(t, db.Create(&LoginEvent{UserID: "user-2", Success: true}).Error)
+
+	first, next, err := repo.ListSuccessful(ctx, "user-1", "")
+	require.NoError(t, err)
+	assert.Len(t, first, LOGIN_HISTORY_PAGE_SIZE)
+	assert.NotEmpty(t, next)
+	assert.Equal(t, fmt.Sprintf("10.0.0.%d", total-1), first[0].IP, "newest login first")
+
+	// A login after the first page was read doesn't shift the second
+	require.NoError(t, db.Create(&LoginEvent{UserID: "user-1", CreatedAt: time.Now(), Success: true}).Error)
+
+	second, next, err := repo.ListSuccessful(ctx, "user-1", next)
+	require.NoError(t, err)
+	assert.Len(t, second, 5)
+	assert.Empty(t, next)
+	assert.Equal(t, "10.0.0.0", second[len(second)-1].IP)
+
+	for _, event := range append(first, second...) {
+		assert.True(t, event.Success)
+		assert.Equal(t, "user-1", event.UserID)
+	}
+
+	_, _, err = repo.ListSuccessful(ctx, "user-1", "not a cursor")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestLoginEventRepository_ReadOnly(t *testing.T) {
+	repo, db := setupLoginEventTest(t)
+	ctx := context.Background()
+	require.NoError(t, repo.Create(ctx, &LoginEvent{UserID: "user-1", Success: true}))
+	require.NoError(t, db.Use(database.ReadOnlyPlugin{}))
+
+	assert.ErrorIs(t, repo.Create(ctx, &LoginEvent{UserID: "user-1", Success: true}), ErrReadOnly)
+	_, err := repo.DeleteOlderThan(ctx, time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, ErrReadOnly)
+	_, err = repo.AnonymizeByUser(ctx, "user-1")
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	events, _, err := repo.ListSuccessful(ctx, "user-1", "")
+	require.NoError(t, err)
+	assert.Len(t, events, 1, "reads still work")
+}
+
+func TestLoginEventRepository_DeleteOlderThan(t *testing.T) {
+	repo, db := setupLoginEventTest(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	require.NoError(t, db.Create(&LoginEvent{UserID: "user-1", CreatedAt: now.Add(-200 * 24 * time.Hour), Success: true}).Error)
+	require.NoError(t, db.Create(&LoginEvent{UserID: "user-1", CreatedAt: now.Add(-181 * 24 * time.Hour)}).Error)
+	require.NoError(t, db.Create(&LoginEvent{UserID: "user-1", CreatedAt: now.Add(-24 * time.Hour), Success: true}).Error)
+
+	deleted, err := repo.DeleteOlderThan(ctx, now.Add(-LOGIN_EVENT_RETENTION))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	var remaining int64
+	require.NoError(t, db.Model(&LoginEvent{}).Count(&remaining).Error)
+	assert.Equal(t, int64(1), remaining)
+}
+
+func TestLoginEventRepository_AnonymizeByUser(t *testing.T) {
+	repo, db := setupLoginEventTest(t)
+	ctx := context.Background()
+
+	for _, success := range []bool{true, false, true} {
+		require.NoError(t, db.Create(&LoginEvent{
+			UserID:    "user-1",
+			IP:        "10.0.0.1",
+			UserAgent: "Firefox/121.0",
+			Success:   success,
+		}).Error)
+	}
+	require.NoError(t, db.Create(&LoginEvent{UserID: "user-2", IP: "10.0.0.2", UserAgent: "curl/8.4.0"}).Error)
+
+	updated, err := repo.AnonymizeByUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), updated)
+
+	events := streamLoginEvents(t, repo, "user-1")
+	assert.Len(t, events, 3, "anonymizing must keep the rows")
+	for _, event := range events {
+		assert.Empty(t, event.IP)
+		assert.Empty(t, event.UserAgent)
+	}
+
+	other := streamLoginEvents(t, repo, "user-2")
+	require.Len(t, other, 1)
+	assert.Equal(t, "10.0.0.2", other[0].IP)
+}
+
+func TestLoginEventRepository_Create_UnknownLoginHasNoUser(t *testing.T) {
+	repo, db := setupLoginEventTest(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.Create(ctx, &LoginEvent{IP: "10.0.0.1", AttemptedLogin: "nobody"}))
+
+	var withoutUser int64
+	require.NoError(t, db.Model(&LoginEvent{}).Where("user_id IS NULL").Count(&withoutUser).Error)
+	assert.Equal(t, int64(1), withoutUser, "stored as NULL so it satisfies the users foreign key")
+
+	var events []LoginEvent
+	require.NoError(t, db.Find(&events).Error)
+	require.Len(t, events, 1)
+	assert.Empty(t, events[0].UserID)
+	assert.Equal(t, "nobody", events[0].AttemptedLogin)
+}
+
+func TestLoginEventRepository_ChallengeSignals(t *testing.T) {
+	repo, db := setupLoginEventTest(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	for _, event := range []LoginEvent{
+		{UserID: "user-1", CreatedAt: now.Add(-time.Hour), IP: "10.0.0.1", Success: false},
+		{UserID: "user-1", CreatedAt: now.Add(-5 * time.Minute), IP: "10.0.0.2", Success: false},
+		{UserID: "user-1", CreatedAt: now.Add(-time.Minute), IP: "10.0.0.2", Success: false},
+		{UserID: "user-1", CreatedAt: now.Add(-time.Minute), IP: "10.0.0.3", Success: true},
+		{UserID: "user-2", CreatedAt: now.Add(-time.Minute), IP: "10.0.0.4", Success: true},
+	} {
+		require.NoError(t, db.Create(&event).Error)
+	}
+
+	failed, err := repo.CountFailedSince(ctx, "user-1", now.Add(-15*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), failed, "only failures inside the window")
+
+	for ip, want := range map[string]bool{
+		"10.0.0.3": true,
+		"10.0.0.2": false, // only failed from here
+		"10.0.0.4": false, // another user's
+		"10.0.0.9": false,
+	} {
+		known, err := repo.HasSucceededFromIP(ctx, "user-1", ip)
+		require.NoError(t, err)
+		assert.Equal(t, want, known, ip)
+	}
+}
+
+func TestLoginEventRepository_RecentlyActiveUsers(t *testing.T) {
+	repo, db := setupLoginEventTest(t)
+
+	since := time.Now().Add(-24 * time.Hour)
+	for _, event := range []LoginEvent{
+		{UserID: "user-1", CreatedAt: since.Add(time.Hour), Success: true},
+		{UserID: "user-2", CreatedAt: since.Add(3 * time.Hour), Success: true},
+		{UserID: "user-1", CreatedAt: since.Add(4 * time.Hour), Success: true},
+		{UserID: "user-3", CreatedAt: since.Add(2 * time.Hour), Success: true},
+		{UserID: "user-4", CreatedAt: since.Add(5 * time.Hour), Success: false},
+		{UserID: "user-5", CreatedAt: since.Add(-time.Hour), Success: true},
+		{CreatedAt: since.Add(6 * time.Hour), Success: true},
+	} {
+		require.NoError(t, db.Create(&event).Error)
+	}
+
+	userIDs, err := repo.RecentlyActiveUsers(context.Background(), since, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user-1", "user-2", "user-3"}, userIDs, "latest login first, failed and older logins left out")
+
+	userIDs, err = repo.RecentlyActiveUsers(context.Background(), since, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user-1", "user-2"}, userIDs)
+}
@@ -0,0 +1,34 @@
+package repositories
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TIE_BREAK_COLUMN orders the rows every other column of a list leaves tied.
+// Timestamps are only as fine as the clock that wrote them, so rows created
+// together would otherwise come back in whatever order sqlite finds them,
+// and shift between pages.
+const TIE_BREAK_COLUMN = "id"
+
+// OrderBy is one column of a list's order.
+type OrderBy struct {
+	Column string
+	Desc   bool
+}
+
+// orderBy orders query by columns, then by TIE_BREAK_COLUMN in the direction
+// of the last of them. Lists go through it rather than Order so none can
+// leave out the tie-breaker. Columns are quoted as identifiers, but must
+// still never come from a request unmapped.
+func orderBy(query *gorm.DB, columns ...OrderBy) *gorm.DB {
+	tieBreak := OrderBy{Column: TIE_BREAK_COLUMN}
+	for _, column := range columns {
+		query = query.Order(clause.OrderByColumn{Column: clause.Column{Name: column.Column}, Desc: column.Desc})
+		if column.Column == TIE_BREAK_COLUMN {
+			return query
+		}
+		tieBreak.Desc = column.Desc
+	}
+	return query.Order(clause.OrderByColumn{Column: clause.Column{Name: tieBreak.Column}, Desc: tieBreak.Desc})
+}
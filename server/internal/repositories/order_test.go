@@ -0,0 +1,145 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"server/internal/database"
+	. "server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestOrderBy(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{DryRun: true})
+	require.NoError(t, err)
+
+	sql := func(columns ...OrderBy) string {
+		return orderBy(db.Model(&User{}), columns...).Find(&[]User{}).Statement.SQL.String()
+	}
+
+	assert.Contains(t, sql(OrderBy{Column: "created_at", Desc: true}), "ORDER BY `created_at` DESC,`id` DESC")
+	assert.Contains(t, sql(OrderBy{Column: "login"}), "ORDER BY `login`,`id`")
+	assert.Contains(t, sql(OrderBy{Column: "is_admin", Desc: true}, OrderBy{Column: "login"}),
+		"ORDER BY `is_admin` DESC,`login`,`id`", "ties follow the last column")
+	assert.Contains(t, sql(OrderBy{Column: "id", Desc: true}), "ORDER BY `id` DESC")
+	assert.NotContains(t, sql(OrderBy{Column: "id", Desc: true}), ",`id`")
+	assert.Contains(t, sql(OrderBy{Column: "login; DROP TABLE users"}), "`login; DROP TABLE users`",
+		"a column is only ever an identifier")
+}
+
+// sameSecondIDs are UUIDv7s of rows created in the same second, inserted out
+// of order so sqlite doesn't happen to return them sorted.
+var sameSecondIDs = []string{
+	"01900000-0000-7000-8000-000000000003",
+	"01900000-0000-7000-8000-000000000001",
+	"01900000-0000-7000-8000-000000000004",
+	"01900000-0000-7000-8000-000000000002",
+}
+
+func TestOrderBy_SameTimestampAnnouncements(t *testing.T) {
+	repo := setupAnnouncementTest(t)
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, id := range sameSecondIDs {
+		require.NoError(t, repo.Create(ctx, &Announcement{
+			BaseModel: BaseModel{ID: id, CreatedAt: now},
+			Title:     id,
+			Body:      "body",
+			Severity:  ANNOUNCEMENT_SEVERITY_INFO,
+			ExpiresAt: now.Add(time.Hour),
+		}))
+	}
+
+	for range 5 {
+		active, err := repo.ListActive(ctx, now)
+		require.NoError(t, err)
+		ids := make([]string, 0, len(active))
+		for _, announcement := range active {
+			ids = append(ids, announcement.ID)
+		}
+		assert.Equal(t, []string{sameSecondIDs[2], sameSecondIDs[0], sameSecondIDs[3], sameSecondIDs[1]}, ids)
+	}
+}
+
+func TestOrderBy_SameTimestampLoginEvents(t *testing.T) {
+	repo, db := setupLoginEventTest(t)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, id := range sameSecondIDs {
+		require.NoError(t, db.Create(&LoginEvent{ID: id, UserID: "user-1", Success: true, CreatedAt: now}).Error)
+	}
+
+	for range 5 {
+		events := streamLoginEvents(t, repo, "user-1")
+		ids := make([]string, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		assert.Equal(t, []string{sameSecondIDs[1], sameSecondIDs[3], sameSecondIDs[0], sameSecondIDs[2]}, ids,
+			"oldest first, ties by id")
+	}
+}
+
+func TestUserRepository_ListSorted(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+	repo := New(database.DB{SQL: db}, invalidator)
+
+	// Every user created in the same second and half of them sharing a name,
+	// more than a page so ties straddle the page boundary
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	count := USER_LIST_PAGE_SIZE + 10
+	for i := range count {
+		firstName := "Ada"
+		if i%2 == 1 {
+			firstName = "Grace"
+		}
+		require.NoError(t, db.Create(&User{
+			BaseModel: BaseModel{CreatedAt: now},
+			Login:     fmt.Sprintf("user%03d", (i*37)%count),
+			FirstName: firstName,
+			Password:  "$2a$04$C6UzMDM.H6dfI/f/IKcEeO5w8tRGVvFL1mW3X8bHVj1PeZsl8Kz3K",
+		}).Error)
+	}
+
+	list := func(sort UserSort) []User {
+		var users []User
+		for page := 1; ; page++ {
+			listed, hasMore, err := repo.List(context.Background(), page, sort, []string{"id", "login", "first_name"})
+			require.NoError(t, err)
+			users = append(users, listed...)
+			if !hasMore {
+				return users
+			}
+		}
+	}
+
+	for _, sort := range []UserSort{{}, {Column: "first_name"}, {Column: "first_name", Desc: true}, {Column: "login"}} {
+		users := list(sort)
+		require.Len(t, users, count, sort)
+		seen := map[string]bool{}
+		for _, user := range users {
+			seen[user.ID] = true
+		}
+		assert.Len(t, seen, count, "no user repeated or skipped across pages, %v", sort)
+		assert.Equal(t, users, list(sort), "the same order every time, %v", sort)
+	}
+
+	byLogin := list(UserSort{Column: "login"})
+	assert.Equal(t, "user000", byLogin[0].Login)
+	assert.Equal(t, fmt.Sprintf("user%03d", count-1), byLogin[count-1].Login)
+
+	byName := list(UserSort{Column: "first_name", Desc: true})
+	assert.Equal(t, "Grace", byName[0].FirstName)
+	assert.Greater(t, byName[0].ID, byName[1].ID, "ties newest id first, like the name")
+}
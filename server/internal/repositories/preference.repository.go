@@ -0,0 +1,99 @@
+package repositories
+
+import (
+	"context"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/logger"
+	. "server/internal/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// PREFERENCE_CACHE_ENTITY is invalidated with the user ID whenever one of
+// their preferences changes.
+const PREFERENCE_CACHE_ENTITY = "preferences"
+
+type preferenceRepository struct {
+	db          database.DB
+	invalidator *database.Invalidator
+	clock       clock.Clock
+	log         logger.Logger
+}
+
+// NewPreferenceRepository builds the repository. A nil clock uses the wall
+// clock.
+func NewPreferenceRepository(
+	db database.DB,
+	invalidator *database.Invalidator,
+	clk clock.Clock,
+) PreferenceRepository {
+	return &preferenceRepository{
+		db:          db,
+		invalidator: invalidator,
+		clock:       clock.OrDefault(clk),
+		log:         logger.New("preferenceRepository"),
+	}
+}
+
+// ListByUser returns the user's preferences ordered by key.
+func (r *preferenceRepository) ListByUser(ctx context.Context, userID string) ([]UserPreference, error) {
+	log := r.log.Function("ListByUser")
+
+	preferences := []UserPreference{}
+	if err := r.db.SQLWithContext(ctx).
+		Where("user_id = ?", userID).
+		// Keys are the rest of the primary key, so no two tie
+		Order("key").
+		Find(&preferences).Error; err != nil {
+		return nil, log.Err("failed to list preferences", err, "userID", userID)
+	}
+
+	return preferences, nil
+}
+
+// Get returns gorm.ErrRecordNotFound when the user never set key.
+func (r *preferenceRepository) Get(ctx context.Context, userID string, key string) (*UserPreference, error) {
+	var preference UserPreference
+	if err := r.db.SQLWithContext(ctx).
+		First(&preference, "user_id = ? AND key = ?", userID, key).Error; err != nil {
+		return nil, err
+	}
+	return &preference, nil
+}
+
+// Set creates or replaces the preference.
+func (r *preferenceRepository) Set(ctx context.Context, preference *UserPreference) error {
+	log := r.log.Function("Set")
+
+	preference.UpdatedAt = r.clock.Now().UTC()
+	if err := r.db.SQLWithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+		}).
+		Create(preference).Error; err != nil {
+		return log.Err("failed to set preference", err, "userID", preference.UserID, "key", preference.Key)
+	}
+
+	r.invalidate(ctx, preference.UserID)
+	return nil
+}
+
+func (r *preferenceRepository) Delete(ctx context.Context, userID string, key string) error {
+	log := r.log.Function("Delete")
+
+	if err := r.db.SQLWithContext(ctx).
+		Delete(&UserPreference{}, "user_id = ? AND key = ?", userID, key).Error; err != nil {
+		return log.Err("failed to delete preference", err, "userID", userID, "key", key)
+	}
+
+	r.invalidate(ctx, userID)
+	return nil
+}
+
+func (r *preferenceRepository) invalidate(ctx context.Context, userID string) {
+	if err := r.invalidator.Invalidate(ctx, PREFERENCE_CACHE_ENTITY, userID); err != nil {
+		r.log.Function("invalidate").Warn("failed to invalidate preferences", "userID", userID, "error", err)
+	}
+}
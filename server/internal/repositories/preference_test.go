@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"context"
+	"path/filepath"
+	"server/internal/clock"
+	"server/internal/database"
+	. "server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupPreferenceTest(t *testing.T) (PreferenceRepository, *clock.Fake, *[]string) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&UserPreference{}))
+
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+	evicted := &[]string{}
+	invalidator.Register(PREFERENCE_CACHE_ENTITY, func(id string) { *evicted = append(*evicted, id) })
+
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	return NewPreferenceRepository(database.DB{SQL: db}, invalidator, fake), fake, evicted
+}
+
+func TestPreferenceRepository_SetReplacesAndInvalidates(t *testing.T) {
+	repo, fake, evicted := setupPreferenceTest(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.Set(ctx, &UserPreference{UserID: "user-1", Key: "theme", Value: `"light"`}))
+	fake.Advance(time.Minute)
+	require.NoError(t, repo.Set(ctx, &UserPreference{UserID: "user-1", Key: "theme", Value: `"dark"`}))
+	require.NoError(t, repo.Set(ctx, &UserPreference{UserID: "user-1", Key: "notifications", Value: `{}`}))
+	require.NoError(t, repo.Set(ctx, &UserPreference{UserID: "user-2", Key: "theme", Value: `"light"`}))
+
+	stored, err := repo.Get(ctx, "user-1", "theme")
+	require.NoError(t, err)
+	assert.Equal(t, `"dark"`, stored.Value)
+	assert.True(t, stored.UpdatedAt.Equal(fake.Now()))
+
+	preferences, err := repo.ListByUser(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, preferences, 2)
+	assert.Equal(t, "notifications", preferences[0].Key)
+	assert.Equal(t, "theme", preferences[1].Key)
+
+	assert.Equal(t, []string{"user-1", "user-1", "user-1", "user-2"}, *evicted)
+}
+
+func TestPreferenceRepository_Delete(t *testing.T) {
+	repo, _, evicted := setupPreferenceTest(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.Set(ctx, &UserPreference{UserID: "user-1", Key: "theme", Value: `"dark"`}))
+	require.NoError(t, repo.Delete(ctx, "user-1", "theme"))
+
+	_, err := repo.Get(ctx, "user-1", "theme")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.Equal(t, []string{"user-1", "user-1"}, *evicted)
+}
@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"server/internal/database"
+	"server/internal/metrics"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// Retries after the first attempt of a write that failed with a
+	// transient error
+	RETRY_ATTEMPTS = 3
+
+	// Wait before the first retry, doubled for each one after. Up to half of
+	// it is taken off at random, so writers that collided spread out.
+	RETRY_BASE_DELAY = 25 * time.Millisecond
+)
+
+// RepositoryRetries counts the retries of writes, by operation such as
+// user.Create.
+var RepositoryRetries = metrics.NewLabeledCounter(
+	"repository_retries_total", "Writes retried after a transient database error, by operation.", "operation",
+)
+
+func init() {
+	metrics.Register(RepositoryRetries)
+}
+
+// IsTransient reports whether err is sqlite failing to get a lock in time,
+// SQLITE_BUSY or SQLITE_LOCKED, which the same statement may get past on
+// another try. Anything else, a constraint violation say, fails the same way
+// again.
+func IsTransient(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+// retryTransient runs write, and again up to RETRY_ATTEMPTS times while it
+// fails with a transient error, backing off between tries. A retry that
+// wouldn't start before ctx's deadline isn't made, and the last error is
+// returned.
+//
+// Only writes that change nothing when they fail may opt in: a single
+// statement, which gorm runs in a transaction of its own, or one safe to
+// repeat. Within a Bulk transaction nothing is retried, since a busy
+// statement there can't get its lock until the transaction ends; it is the
+// whole transaction that would have to be run again.
+func retryTransient(ctx context.Context, operation string, write func() error) error {
+	err := write()
+	if database.InTransaction(ctx) {
+		return err
+	}
+
+	delay := RETRY_BASE_DELAY
+	for range RETRY_ATTEMPTS {
+		if !IsTransient(err) {
+			return err
+		}
+
+		wait := delay - rand.N(delay/2)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		RepositoryRetries.Add(operation, 1)
+		err = write()
+		delay *= 2
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"server/config"
+	"server/internal/database"
+	. "server/internal/models"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// failingWrites makes the next failures writes of every kind fail with err
+// before they reach the database, and counts the attempts.
+type failingWrites struct {
+	err      error
+	failures int
+	attempts int
+}
+
+func (f *failingWrites) inject(tx *gorm.DB) {
+	f.attempts++
+	if f.failures > 0 {
+		f.failures--
+		tx.AddError(f.err)
+	}
+}
+
+func setupRetryTest(t *testing.T) (database.DB, *userRepository, *failingWrites) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+
+	writes := &failingWrites{}
+	require.NoError(t, db.Callback().Create().Before("gorm:create").Register("test:fail", writes.inject))
+	require.NoError(t, db.Callback().Update().Before("gorm:update").Register("test:fail", writes.inject))
+	require.NoError(t, db.Callback().Delete().Before("gorm:delete").Register("test:fail", writes.inject))
+
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+	sqlDB := database.DB{SQL: db}
+	repo := New(sqlDB, invalidator).(*userRepository)
+	repo.store = database.NewMemoryCacheStore()
+	return sqlDB, repo, writes
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, IsTransient(sqlite3.Error{Code: sqlite3.ErrBusy}))
+	assert.True(t, IsTransient(sqlite3.Error{Code: sqlite3.ErrLocked}))
+	assert.True(t, IsTransient(fmt.Errorf("saving: %w", sqlite3.Error{Code: sqlite3.ErrBusy})))
+
+	assert.False(t, IsTransient(sqlite3.Error{Code: sqlite3.ErrConstraint}))
+	assert.False(t, IsTransient(gorm.ErrRecordNotFound))
+	assert.False(t, IsTransient(errors.New("database is locked")), "only the driver's codes are trusted")
+	assert.False(t, IsTransient(nil))
+}
+
+func TestRetryTransient_RetriesUntilWritten(t *testing.T) {
+	_, repo, writes := setupRetryTest(t)
+	writes.err = sqlite3.Error{Code: sqlite3.ErrBusy}
+	writes.failures = 2
+	before := RepositoryRetries.Value("user.Create")
+
+	user := &User{Login: "jdoe", FirstName: "Jane"}
+	require.NoError(t, repo.Create(context.Background(), user, config.Config{}))
+
+	assert.Equal(t, 3, writes.attempts)
+	assert.Equal(t, uint64(2), RepositoryRetries.Value("user.Create")-before)
+	stored, err := repo.GetByLogin(context.Background(), "jdoe")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, stored.ID)
+}
+
+func TestRetryTransient_GivesUp(t *testing.T) {
+	_, repo, writes := setupRetryTest(t)
+	user := &User{Login: "jdoe", FirstName: "Jane"}
+	require.NoError(t, repo.Create(context.Background(), user, config.Config{}))
+	writes.attempts = 0
+	writes.err = sqlite3.Error{Code: sqlite3.ErrLocked}
+	writes.failures = RETRY_ATTEMPTS + 1
+	before := RepositoryRetries.Value("user.Update")
+
+	user.FirstName = "Janet"
+	err := repo.Update(context.Background(), user)
+
+	assert.True(t, IsTransient(err))
+	assert.Equal(t, RETRY_ATTEMPTS+1, writes.attempts)
+	assert.Equal(t, uint64(RETRY_ATTEMPTS), RepositoryRetries.Value("user.Update")-before)
+}
+
+func TestRetryTransient_PermanentErrorsNotRetried(t *testing.T) {
+	_, repo, writes := setupRetryTest(t)
+	writes.err = sqlite3.Error{Code: sqlite3.ErrConstraint}
+	writes.failures = 1
+
+	err := repo.Create(context.Background(), &User{Login: "jdoe"}, config.Config{})
+
+	require.Error(t, err)
+	assert.Equal(t, 1, writes.attempts)
+}
+
+func TestRetryTransient_RespectsDeadline(t *testing.T) {
+	_, repo, writes := setupRetryTest(t)
+	writes.err = sqlite3.Error{Code: sqlite3.ErrBusy}
+	writes.failures = RETRY_ATTEMPTS + 1
+
+	// Less than the shortest first wait, so no retry fits
+	ctx, cancel := context.WithTimeout(context.Background(), RETRY_BASE_DELAY/4)
+	defer cancel()
+	err := repo.Create(ctx, &User{Login: "jdoe"}, config.Config{})
+
+	assert.True(t, IsTransient(err))
+	assert.Equal(t, 1, writes.attempts)
+
+	// A canceled context stops the wait too
+	writes.attempts = 0
+	writes.failures = RETRY_ATTEMPTS + 1
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = repo.Delete(ctx, "0190a5b4-7c2e-7000-8000-000000000000")
+	require.Error(t, err)
+	assert.Equal(t, 1, writes.attempts)
+}
+
+func TestRetryTransient_NotWithinBulk(t *testing.T) {
+	sqlDB, repo, writes := setupRetryTest(t)
+	ctx := context.Background()
+	user := &User{Login: "jdoe", FirstName: "Jane"}
+	require.NoError(t, repo.Create(ctx, user, config.Config{}))
+	writes.attempts = 0
+	writes.err = sqlite3.Error{Code: sqlite3.ErrBusy}
+	writes.failures = 1
+
+	err := sqlDB.Bulk(ctx, repo.invalidator, func(ctx context.Context) error {
+		user.FirstName = "Janet"
+		return repo.UpdateProfile(ctx, user, 0)
+	})
+
+	assert.True(t, IsTransient(err), "the transaction as a whole is the caller's to retry")
+	assert.Equal(t, 1, writes.attempts)
+}
@@ -2,36 +2,66 @@ package repositories
 
 import (
 	"context"
+	"errors"
 	"server/config"
+	"server/internal/clock"
 	"server/internal/database"
 	"server/internal/logger"
 	"server/internal/models"
+	"server/internal/tracing"
 	"server/internal/utils"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 const (
-	SESSION_EXPIRY     = 7 * 24 * time.Hour // 7 days
-	SESSION_REFRESH    = 5 * 24 * time.Hour // 5 days
-	SESSION_CACHE_KEY  = "session:"
-	SESSION_ISSUER_KEY = "app_api"
+	SESSION_EXPIRY    = 7 * 24 * time.Hour // 7 days
+	SESSION_REFRESH   = 5 * 24 * time.Hour // 5 days
+	SESSION_CACHE_KEY = "session:"
+
+	// Bumped whenever a change to Session would decode a cached one wrongly
+	SESSION_CACHE_VERSION = 1
+
+	// Impersonation sessions are short and never refreshed.
+	IMPERSONATION_EXPIRY = time.Hour
+
+	USER_SESSIONS_CACHE_KEY = "user_sessions:%s"
+
+	// The most sessions one ReapExpired run deletes, so a backlog is worked
+	// off over several runs instead of in one long burst.
+	SESSION_REAP_LIMIT = 1000
 )
 
+// cachedSession is how a session is stored. Session leaves its token out of
+// JSON, so the cache record carries it alongside.
+type cachedSession struct {
+	models.Session
+	Token string `json:"token"`
+}
+
 type sessionRepository struct {
-	db  database.DB
-	log logger.Logger
+	db    database.DB
+	store database.CacheStore
+	log   logger.Logger
+	clock clock.Clock
 }
 
-func NewSessionRepository(db database.DB) SessionRepository {
+// NewSessionRepository builds the repository. A nil clock uses the wall clock.
+func NewSessionRepository(db database.DB, clk clock.Clock) SessionRepository {
 	return &sessionRepository{
-		db:  db,
-		log: logger.New("sessionRepository"),
+		db:    db,
+		store: database.NewCacheStore(db.Cache.Session),
+		log:   logger.New("sessionRepository"),
+		clock: clock.OrDefault(clk),
 	}
 }
 
 func (r *sessionRepository) Create(ctx context.Context, session *models.Session, config config.Config) error {
+	ctx, span := tracing.Start(ctx, "sessionRepository.Create")
+	defer span.End()
 	log := r.log.Function("Create")
 
 	if session.ID != "" {
@@ -44,51 +74,243 @@ func (r *sessionRepository) Create(ctx context.Context, session *models.Session,
 
 	id, _ := uuid.NewV7()
 	session.ID = id.String()
-	session.ExpiresAt = time.Now().Add(SESSION_EXPIRY)
-	session.RefreshAt = time.Now().Add(SESSION_REFRESH)
-	
-	token, err := utils.GenerateJWTToken(session.UserID, session.ExpiresAt, SESSION_ISSUER_KEY, config)
+	now := r.clock.Now()
+	session.CreatedAt = now
+	if session.StartedAt.IsZero() {
+		session.StartedAt = now
+	}
+	expiry := SESSION_EXPIRY
+	session.RefreshAt = now.Add(SESSION_REFRESH)
+	if session.Impersonated() {
+		expiry = IMPERSONATION_EXPIRY
+		session.RefreshAt = now.Add(expiry)
+	}
+	session.ExpiresAt = now.Add(expiry)
+
+	token, err := utils.GenerateSessionToken(
+		session.UserID,
+		session.ID,
+		session.ExpiresAt,
+		config,
+		r.clock,
+	)
 	if err != nil {
 		return log.Err("failed to generate JWT token", err, "userID", session.UserID)
 	}
 
 	session.Token = token
 
-	if err := database.NewCacheBuilder(r.db.Cache.Session, session.ID).
-		WithHashPattern(SESSION_CACHE_KEY).
-		WithSruct(session).
-		WithTTL(SESSION_EXPIRY).
-		Set(); err != nil {
+	if err := r.save(ctx, session, expiry); err != nil {
 		return log.Err("failed to set session in cache", err, "session", session)
 	}
 
+	if err := database.NewCacheBuilder(r.db.Cache.Session, session.UserID).
+		WithHashPattern(USER_SESSIONS_CACHE_KEY).
+		WithMember(session.ID).
+		SetSadd(); err != nil {
+		log.Warn("failed to index session for user", "sessionID", session.ID, "error", err)
+	}
+
 	return nil
 }
 
+// Extend moves the session's expiry to expiresAt, keeping its ID, with a new
+// token to match. It is due a refresh at the usual time or when it expires,
+// whichever comes first.
+func (r *sessionRepository) Extend(ctx context.Context, session *models.Session, expiresAt time.Time, config config.Config) error {
+	ctx, span := tracing.Start(ctx, "sessionRepository.Extend")
+	defer span.End()
+	log := r.log.Function("Extend")
+
+	now := r.clock.Now()
+	if !expiresAt.After(now) {
+		return log.ErrMsg("Extended expiry must be in the future")
+	}
+
+	token, err := utils.GenerateSessionToken(session.UserID, session.ID, expiresAt, config, r.clock)
+	if err != nil {
+		return log.Err("failed to generate JWT token", err, "userID", session.UserID)
+	}
+
+	extended := *session
+	extended.Token = token
+	extended.ExpiresAt = expiresAt
+	extended.RefreshAt = now.Add(SESSION_REFRESH)
+	if extended.RefreshAt.After(expiresAt) {
+		extended.RefreshAt = expiresAt
+	}
+	if err := r.save(ctx, &extended, expiresAt.Sub(now)); err != nil {
+		return log.Err("failed to extend session in cache", err, "session", session)
+	}
+
+	*session = extended
+	return nil
+}
+
+// save writes the session with its token, to expire out of the cache after
+// ttl.
+func (r *sessionRepository) save(ctx context.Context, session *models.Session, ttl time.Duration) error {
+	cached := cachedSession{Session: *session, Token: session.Token}
+	return database.SetVersioned(ctx, r.store, sessionKey(session.ID), SESSION_CACHE_VERSION, cached, ttl)
+}
+
+func sessionKey(sessionID string) string {
+	return SESSION_CACHE_KEY + sessionID
+}
+
+// GetByID returns ErrNotFound once the session has expired out of the cache.
 func (r *sessionRepository) GetByID(ctx context.Context, sessionID string) (*models.Session, error) {
+	ctx, span := tracing.Start(ctx, "sessionRepository.GetByID")
+	defer span.End()
 	log := r.log.Function("GetByID")
-	
-	var session models.Session
-	
-	err := database.NewCacheBuilder(r.db.Cache.Session, sessionID).
-		WithHashPattern(SESSION_CACHE_KEY).
-		Get(&session)
+
+	cached, err := database.GetVersioned[cachedSession](ctx, r.store, sessionKey(sessionID), SESSION_CACHE_VERSION)
+	if errors.Is(err, database.ErrCacheMiss) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, log.Err("failed to get session from cache", err, "sessionID", sessionID)
 	}
 
+	session := cached.Session
+	session.Token = cached.Token
 	return &session, nil
 }
 
 func (r *sessionRepository) Delete(ctx context.Context, sessionID string) error {
+	ctx, span := tracing.Start(ctx, "sessionRepository.Delete")
+	defer span.End()
 	log := r.log.Function("Delete")
-	
-	err := database.NewCacheBuilder(r.db.Cache.Session, sessionID).
-		WithHashPattern(SESSION_CACHE_KEY).
-		Delete()
-	if err != nil {
+
+	if session, err := r.GetByID(ctx, sessionID); err == nil {
+		r.removeFromUserIndex(session.UserID, sessionID)
+	}
+
+	if err := r.store.Delete(ctx, sessionKey(sessionID)); err != nil {
 		return log.Err("failed to delete session from cache", err, "sessionID", sessionID)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// ListByUser returns the user's live sessions, newest first and then by id
+// like the database lists, as the index set has no order of its own. Index
+// entries for sessions that have already expired out of the cache are
+// dropped along the way.
+func (r *sessionRepository) ListByUser(ctx context.Context, userID string) ([]*models.Session, error) {
+	log := r.log.Function("ListByUser")
+
+	sessionIDs, err := database.NewCacheBuilder(r.db.Cache.Session, userID).
+		WithHashPattern(USER_SESSIONS_CACHE_KEY).
+		WithContext(ctx).
+		GetSetMembers()
+	if err != nil {
+		return nil, log.Err("failed to get user sessions", err, "userID", userID)
+	}
+
+	sessions := make([]*models.Session, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		session, err := r.GetByID(ctx, sessionID)
+		if err != nil {
+			r.removeFromUserIndex(userID, sessionID)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	slices.SortFunc(sessions, func(a, b *models.Session) int {
+		if byCreated := b.CreatedAt.Compare(a.CreatedAt); byCreated != 0 {
+			return byCreated
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+	return sessions, nil
+}
+
+func (r *sessionRepository) DeleteByUser(ctx context.Context, userID string) error {
+	log := r.log.Function("DeleteByUser")
+
+	sessions, err := r.ListByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if err := r.Delete(ctx, session.ID); err != nil {
+			return log.Err("failed to revoke session", err, "userID", userID, "sessionID", session.ID)
+		}
+	}
+
+	return nil
+}
+
+// CountActive is the number of unexpired sessions across all users. Expired
+// sessions drop out of the cache on their own, so every stored one counts.
+func (r *sessionRepository) CountActive(ctx context.Context) (int64, error) {
+	log := r.log.Function("CountActive")
+
+	count, err := database.NewCacheBuilder(r.db.Cache.Session, SESSION_CACHE_KEY+"*").
+		WithContext(ctx).
+		CountKeys()
+	if err != nil {
+		return 0, log.Err("failed to count sessions", err)
+	}
+
+	return count, nil
+}
+
+// ReapExpired deletes up to limit sessions that expired before expiredBefore
+// and returns how many it deleted. Sessions normally expire out of the cache
+// on their own; this catches the ones whose key outlived them. Deleting is
+// idempotent, so instances may reap at the same time, at worst counting a
+// session twice.
+func (r *sessionRepository) ReapExpired(ctx context.Context, expiredBefore time.Time, limit int) (int, error) {
+	log := r.log.Function("ReapExpired")
+
+	reaped := 0
+	var cursor uint64
+	for {
+		keys, next, err := r.store.Scan(ctx, SESSION_CACHE_KEY+"*", cursor)
+		if err != nil {
+			return reaped, log.Err("failed to scan sessions", err)
+		}
+
+		for _, key := range keys {
+			if reaped >= limit {
+				return reaped, nil
+			}
+
+			cached, err := database.GetVersioned[cachedSession](ctx, r.store, key, SESSION_CACHE_VERSION)
+			if errors.Is(err, database.ErrCacheMiss) {
+				continue
+			}
+			if err != nil {
+				return reaped, log.Err("failed to get session", err, "key", key)
+			}
+			if !cached.ExpiresAt.Before(expiredBefore) {
+				continue
+			}
+
+			if err := r.store.Delete(ctx, key); err != nil {
+				return reaped, log.Err("failed to delete session", err, "sessionID", cached.ID)
+			}
+			r.removeFromUserIndex(cached.UserID, cached.ID)
+			reaped++
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return reaped, nil
+		}
+	}
+}
+
+func (r *sessionRepository) removeFromUserIndex(userID, sessionID string) {
+	if err := database.NewCacheBuilder(r.db.Cache.Session, userID).
+		WithHashPattern(USER_SESSIONS_CACHE_KEY).
+		WithMember(sessionID).
+		RemoveSetMember(); err != nil {
+		r.log.Function("removeFromUserIndex").
+			Warn("failed to remove session from user index", "sessionID", sessionID, "error", err)
+	}
+}
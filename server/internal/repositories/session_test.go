@@ -0,0 +1,103 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/logger"
+	"server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupReapTest stores sessions that expire an hour apart, from expired hours
+// ago to live for hours yet, and returns their cache keys in that order.
+func setupReapTest(t *testing.T, count int) (*sessionRepository, database.CacheStore, time.Time, []string) {
+	t.Helper()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := database.NewMemoryCacheStore()
+	repo := &sessionRepository{store: store, log: logger.New("test"), clock: clock.NewFake(now)}
+
+	keys := make([]string, count)
+	for i := range count {
+		session := models.Session{
+			ID:        fmt.Sprintf("session-%d", i),
+			UserID:    "user-1",
+			ExpiresAt: now.Add(time.Duration(i-count/2) * time.Hour),
+		}
+		keys[i] = SESSION_CACHE_KEY + session.ID
+		cached := cachedSession{Session: session, Token: "jwt"}
+		require.NoError(t, database.SetVersioned(context.Background(), store, keys[i], SESSION_CACHE_VERSION, cached, time.Hour))
+	}
+	// Other keys in the same cache are left alone
+	require.NoError(t, store.Set(context.Background(), "user_sessions:user-1", []string{"session-0"}, 0))
+	return repo, store, now, keys
+}
+
+func remainingKeys(t *testing.T, store database.CacheStore, keys []string) []string {
+	t.Helper()
+	var remaining []string
+	for _, key := range keys {
+		var cached cachedSession
+		if store.Get(context.Background(), key, &cached) == nil {
+			remaining = append(remaining, key)
+		}
+	}
+	return remaining
+}
+
+func TestSessionRepository_ReapExpired(t *testing.T) {
+	repo, store, now, keys := setupReapTest(t, 6)
+
+	reaped, err := repo.ReapExpired(context.Background(), now, SESSION_REAP_LIMIT)
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, reaped)
+	assert.Equal(t, keys[3:], remainingKeys(t, store, keys), "only sessions expired before the cutoff are deleted")
+	var index []string
+	assert.NoError(t, store.Get(context.Background(), "user_sessions:user-1", &index))
+
+	reaped, err = repo.ReapExpired(context.Background(), now, SESSION_REAP_LIMIT)
+	require.NoError(t, err)
+	assert.Zero(t, reaped, "reaping again finds nothing left to delete")
+}
+
+func TestSessionRepository_ReapExpired_Limit(t *testing.T) {
+	repo, store, now, keys := setupReapTest(t, 10)
+
+	reaped, err := repo.ReapExpired(context.Background(), now, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reaped)
+	assert.Len(t, remainingKeys(t, store, keys), 8)
+
+	// The rest go on the following runs
+	for _, expected := range []int{2, 1, 0} {
+		reaped, err = repo.ReapExpired(context.Background(), now, 2)
+		require.NoError(t, err)
+		assert.Equal(t, expected, reaped)
+	}
+	assert.Equal(t, keys[5:], remainingKeys(t, store, keys))
+}
+
+func TestSessionRepository_GetByID_DropsUnversionedEntry(t *testing.T) {
+	store := database.NewMemoryCacheStore()
+	repo := &sessionRepository{store: store, log: logger.New("test"), clock: clock.NewFake(time.Now())}
+	ctx := context.Background()
+
+	// As cached before sessions were versioned
+	legacy := cachedSession{Session: models.Session{ID: "legacy", UserID: "user-1"}, Token: "jwt"}
+	require.NoError(t, store.Set(ctx, sessionKey("legacy"), legacy, time.Hour))
+
+	_, err := repo.GetByID(ctx, "legacy")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Empty(t, remainingKeys(t, store, []string{sessionKey("legacy")}))
+
+	require.NoError(t, repo.save(ctx, &models.Session{ID: "current", UserID: "user-1", Token: "jwt"}, time.Hour))
+	session, err := repo.GetByID(ctx, "current")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", session.UserID)
+}
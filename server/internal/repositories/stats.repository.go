@@ -0,0 +1,104 @@
+package repositories
+
+import (
+	"context"
+	"server/internal/database"
+	"server/internal/logger"
+	. "server/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const STATS_DAY_FORMAT = "2006-01-02"
+
+type statsRepository struct {
+	db  database.DB
+	log logger.Logger
+}
+
+func NewStatsRepository(db database.DB) StatsRepository {
+	return &statsRepository{
+		db:  db,
+		log: logger.New("statsRepository"),
+	}
+}
+
+// CountUsers counts users created at or after since. A zero since counts
+// every user.
+func (r *statsRepository) CountUsers(ctx context.Context, since time.Time) (int64, error) {
+	log := r.log.Function("CountUsers")
+
+	query := r.db.SQLWithContext(ctx).Model(&User{})
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since.UTC())
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return 0, log.Err("failed to count users", err, "since", since)
+	}
+
+	return count, nil
+}
+
+// LoginsPerDay counts successful logins per UTC day, for days days starting
+// on the day of since. Days without logins are included with a zero count.
+func (r *statsRepository) LoginsPerDay(ctx context.Context, since time.Time, days int) ([]DailyCount, error) {
+	log := r.log.Function("LoginsPerDay")
+
+	query := r.db.SQLWithContext(ctx).Model(&LoginEvent{}).Where("success = ?", true)
+	counts, err := r.countPerDay(query, since, days)
+	if err != nil {
+		return nil, log.Err("failed to count logins per day", err, "since", since, "days", days)
+	}
+
+	return counts, nil
+}
+
+// RegistrationsPerDay counts users created per UTC day, the same way as
+// LoginsPerDay.
+func (r *statsRepository) RegistrationsPerDay(
+	ctx context.Context,
+	since time.Time,
+	days int,
+) ([]DailyCount, error) {
+	log := r.log.Function("RegistrationsPerDay")
+
+	query := r.db.SQLWithContext(ctx).Model(&User{})
+	counts, err := r.countPerDay(query, since, days)
+	if err != nil {
+		return nil, log.Err("failed to count registrations per day", err, "since", since, "days", days)
+	}
+
+	return counts, nil
+}
+
+// countPerDay buckets the rows of query by the UTC date of created_at.
+// Timestamps are stored in UTC, so sqlite's date() gives the UTC day.
+func (r *statsRepository) countPerDay(query *gorm.DB, since time.Time, days int) ([]DailyCount, error) {
+	start := since.UTC().Truncate(24 * time.Hour)
+	end := start.AddDate(0, 0, days)
+
+	var rows []DailyCount
+	if err := query.
+		Select("date(created_at) AS day, COUNT(*) AS count").
+		Where("created_at >= ? AND created_at < ?", start, end).
+		Group("day").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	byDay := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		byDay[row.Day] = row.Count
+	}
+
+	counts := make([]DailyCount, days)
+	for i := range days {
+		day := start.AddDate(0, 0, i).Format(STATS_DAY_FORMAT)
+		counts[i] = DailyCount{Day: day, Count: byDay[day]}
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"server/internal/database"
+	. "server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupStatsTest(t *testing.T) (StatsRepository, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}, &LoginEvent{}))
+
+	return NewStatsRepository(database.DB{SQL: db}), db
+}
+
+func TestStatsRepository_CountUsers(t *testing.T) {
+	repo, db := setupStatsTest(t)
+	ctx := context.Background()
+
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	for i, age := range []time.Duration{time.Hour, 3 * 24 * time.Hour, 10 * 24 * time.Hour, 40 * 24 * time.Hour} {
+		require.NoError(t, db.Create(&User{
+			BaseModel: BaseModel{CreatedAt: now.Add(-age)},
+			Login:     fmt.Sprintf("user-%d", i),
+		}).Error)
+	}
+
+	total, err := repo.CountUsers(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+
+	lastWeek, err := repo.CountUsers(ctx, now.AddDate(0, 0, -7))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), lastWeek)
+
+	lastMonth, err := repo.CountUsers(ctx, now.AddDate(0, 0, -30))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), lastMonth)
+}
+
+func TestStatsRepository_LoginsPerDay(t *testing.T) {
+	repo, db := setupStatsTest(t)
+
+	day := func(d, hour int) time.Time { return time.Date(2024, 6, d, hour, 30, 0, 0, time.UTC) }
+	for _, event := range []LoginEvent{
+		{UserID: "u1", CreatedAt: day(9, 23), Success: true}, // before the window
+		{UserID: "u1", CreatedAt: day(10, 0), Success: true},
+		{UserID: "u2", CreatedAt: day(10, 23), Success: true},
+		{UserID: "u1", CreatedAt: day(10, 12), Success: false},
+		{UserID: "u1", CreatedAt: day(12, 8), Success: true},
+		{UserID: "u1", CreatedAt: day(13, 1), Success: true}, // after the window
+	} {
+		require.NoError(t, db.Create(&event).Error)
+	}
+
+	counts, err := repo.LoginsPerDay(context.Background(), day(10, 15), 3)
+	require.NoError(t, err)
+	assert.Equal(t, []DailyCount{
+		{Day: "2024-06-10", Count: 2},
+		{Day: "2024-06-11", Count: 0},
+		{Day: "2024-06-12", Count: 1},
+	}, counts)
+}
+
+func TestStatsRepository_RegistrationsPerDay(t *testing.T) {
+	repo, db := setupStatsTest(t)
+
+	day := func(d, hour int) time.Time { return time.Date(2024, 6, d, hour, 0, 0, 0, time.UTC) }
+	for i, createdAt := range []time.Time{day(1, 0), day(1, 23), day(2, 5), day(4, 9)} {
+		require.NoError(t, db.Create(&User{
+			BaseModel: BaseModel{CreatedAt: createdAt},
+			Login:     fmt.Sprintf("user-%d", i),
+		}).Error)
+	}
+
+	counts, err := repo.RegistrationsPerDay(context.Background(), day(1, 12), 3)
+	require.NoError(t, err)
+	assert.Equal(t, []DailyCount{
+		{Day: "2024-06-01", Count: 2},
+		{Day: "2024-06-02", Count: 1},
+		{Day: "2024-06-03", Count: 0},
+	}, counts)
+}
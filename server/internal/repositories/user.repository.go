@@ -2,42 +2,89 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"server/config"
 	"server/internal/database"
 	"server/internal/logger"
 	. "server/internal/models"
+	"server/internal/tracing"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 const (
-	USER_CACHE_EXPIRY = 7 * 24 * time.Hour // 7 days
+	USER_CACHE_EXPIRY       = 7 * 24 * time.Hour // 7 days
+	USER_LOCAL_CACHE_EXPIRY = time.Minute
+	USER_CACHE_ENTITY       = "user"
+	USER_CACHE_KEY          = "user:%s"
+
+	// Bumped whenever a change to User would decode a cached one wrongly
+	USER_CACHE_VERSION = 2
+
+	// Rows read per statement when streaming the user list
+	USER_STREAM_BATCH_SIZE = 500
+
+	// IDs looked up per statement by GetByIDs
+	USER_LOOKUP_CHUNK_SIZE = 500
 )
 
+// VersionConflictError is returned by a conditional update when the stored
+// user moved on from the version the caller expected.
+type VersionConflictError struct {
+	Current int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict, current version is %d", e.Current)
+}
+
 type userRepository struct {
-	db  database.DB
-	log logger.Logger
+	db          database.DB
+	store       database.CacheStore
+	local       *database.LocalCache[User]
+	invalidator *database.Invalidator
+	log         logger.Logger
 }
 
-func New(db database.DB) UserRepository {
-	return &userRepository{
-		db:  db,
-		log: logger.New("userRepository"),
+// New builds the user repository. Users are read through a short lived local
+// cache, then valkey, then the database; changes go through invalidator so
+// other instances drop their local copies.
+func New(db database.DB, invalidator *database.Invalidator) UserRepository {
+	repo := &userRepository{
+		db:          db,
+		store:       database.NewCacheStore(db.Cache.User),
+		local:       database.NewLocalCache[User](USER_LOCAL_CACHE_EXPIRY, nil),
+		invalidator: invalidator,
+		log:         logger.New("userRepository"),
 	}
+	invalidator.Register(USER_CACHE_ENTITY, repo.local.Delete)
+	return repo
 }
 
+// GetByID returns ErrNotFound when no user has id.
 func (r *userRepository) GetByID(ctx context.Context, id string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "userRepository.GetByID")
+	defer span.End()
 	log := r.log.Function("GetByID")
 
+	if user, ok := r.local.Get(id); ok {
+		return &user, nil
+	}
+
 	var user User
 	if err := r.getCacheByID(ctx, id, &user); err == nil {
+		r.local.Set(id, user)
 		return &user, nil
 	}
 
 	if err := r.getDBByID(ctx, id, &user); err != nil {
 		return nil, err
 	}
+	r.local.Set(id, user)
 
 	if err := r.addUserToCache(ctx, &user); err != nil {
 		log.Warn("failed to add user to cache", "userID", id, "error", err)
@@ -46,12 +93,55 @@ func (r *userRepository) GetByID(ctx context.Context, id string) (*User, error)
 	return &user, nil
 }
 
+// GetByIDs returns the users with ids, in the order of ids, reading them
+// straight from the database USER_LOOKUP_CHUNK_SIZE at a time. IDs with no
+// user, or given twice after the first time, are left out.
+func (r *userRepository) GetByIDs(ctx context.Context, ids []string) ([]User, error) {
+	ctx, span := tracing.Start(ctx, "userRepository.GetByIDs")
+	defer span.End()
+	log := r.log.Function("GetByIDs")
+
+	// No user can have an ID that isn't a UUID
+	wanted := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		wanted = append(wanted, id)
+	}
+
+	found := make(map[string]User, len(wanted))
+	for chunk := range slices.Chunk(wanted, USER_LOOKUP_CHUNK_SIZE) {
+		var users []User
+		if err := r.db.SQLWithContext(ctx).Where("id IN ?", chunk).Find(&users).Error; err != nil {
+			return nil, log.Err("failed to get users by id", err, "count", len(wanted))
+		}
+		for _, user := range users {
+			found[user.ID] = user
+		}
+	}
+
+	users := make([]User, 0, len(found))
+	for _, id := range wanted {
+		if user, ok := found[id]; ok {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// GetByLogin returns ErrNotFound when no user has login, compared in its
+// normalized form (NormalizeLogin).
 func (r *userRepository) GetByLogin(ctx context.Context, login string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "userRepository.GetByLogin")
+	defer span.End()
 	log := r.log.Function("GetByLogin")
 
 	var user User
-	if err := r.getDBByLogin(ctx, login, &user); err != nil {
-		return nil, log.Err("failed to get user by login", err, "login", login)
+	if err := r.getDBByLogin(ctx, NormalizeLogin(login), &user); err != nil {
+		return nil, err
 	}
 
 	if err := r.addUserToCache(ctx, &user); err != nil {
@@ -66,57 +156,245 @@ func (r *userRepository) Create(
 	user *User,
 	config config.Config,
 ) error {
+	ctx, span := tracing.Start(ctx, "userRepository.Create")
+	defer span.End()
 	log := r.log.Function("Create")
 
-	if err := r.db.SQLWithContext(ctx).Create(user).Error; err != nil {
+	user.Login = NormalizeLogin(user.Login)
+	db := r.db.SQLWithContext(ctx)
+	err := retryTransient(ctx, "user.Create", func() error {
+		return db.Create(user).Error
+	})
+	if err != nil {
+		if isDuplicate(db, err) {
+			return ErrDuplicate
+		}
 		return log.Err("failed to create user", err, "user", user)
 	}
 
 	return nil
 }
 
+// isDuplicate reports whether err is a unique constraint violation, whether or
+// not db was opened with TranslateError.
+func isDuplicate(db *gorm.DB, err error) bool {
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
 func (r *userRepository) Update(ctx context.Context, user *User) error {
+	ctx, span := tracing.Start(ctx, "userRepository.Update")
+	defer span.End()
 	log := r.log.Function("Update")
 
-	if err := r.db.SQLWithContext(ctx).Save(user).Error; err != nil {
+	// The version only moves through UpdateProfile, so a stale copy can't
+	// roll it back.
+	err := retryTransient(ctx, "user.Update", func() error {
+		return r.db.SQLWithContext(ctx).Omit("version").Save(user).Error
+	})
+	if err != nil {
 		return log.Err("failed to update user", err, "user", user)
 	}
 
-	if err := r.addUserToCache(ctx, user); err != nil {
-		log.Warn("failed to update user in cache", "userID", user.ID, "error", err)
+	r.changed(ctx, user)
+
+	return nil
+}
+
+// UpdateProfile writes the editable profile fields and bumps the version in a
+// single statement. With a non-zero expectedVersion nothing is written unless
+// the stored version still matches, and a *VersionConflictError carries the
+// current one. Zero keeps last-write-wins. On success user is reloaded.
+func (r *userRepository) UpdateProfile(ctx context.Context, user *User, expectedVersion int) error {
+	ctx, span := tracing.Start(ctx, "userRepository.UpdateProfile")
+	defer span.End()
+	log := r.log.Function("UpdateProfile")
+
+	query := r.db.SQLWithContext(ctx).Model(user)
+	if expectedVersion > 0 {
+		query = query.Where("version = ?", expectedVersion)
+	}
+
+	// Bumping the version isn't idempotent, but a statement that failed
+	// bumped nothing
+	var result *gorm.DB
+	err := retryTransient(ctx, "user.UpdateProfile", func() error {
+		result = query.Session(&gorm.Session{}).Updates(map[string]any{
+			"first_name": user.FirstName,
+			"last_name":  user.LastName,
+			"is_admin":   user.IsAdmin,
+			"version":    gorm.Expr("version + 1"),
+		})
+		return result.Error
+	})
+	if err != nil {
+		return log.Err("failed to update user profile", err, "userID", user.ID)
 	}
 
+	var stored User
+	if err := r.getDBByID(ctx, user.ID, &stored); err != nil {
+		return err
+	}
+
+	r.changed(ctx, &stored)
+
+	if result.RowsAffected == 0 {
+		log.Info("Profile update rejected, version conflict",
+			"userID", user.ID, "expected", expectedVersion, "current", stored.Version)
+		return &VersionConflictError{Current: stored.Version}
+	}
+
+	*user = stored
 	return nil
 }
 
 func (r *userRepository) Delete(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "userRepository.Delete")
+	defer span.End()
 	log := r.log.Function("Delete")
 
-	if err := r.db.SQLWithContext(ctx).Delete(&User{}, "id = ?", id).Error; err != nil {
+	err := retryTransient(ctx, "user.Delete", func() error {
+		return r.db.SQLWithContext(ctx).Delete(&User{}, "id = ?", id).Error
+	})
+	if err != nil {
 		return log.Err("failed to delete user", err, "id", id)
 	}
 
-	if err := database.NewCacheBuilder(r.db.Cache.User, id).Delete(); err != nil {
+	if batch := database.InvalidationBatchFrom(ctx); batch != nil {
+		batch.Add(r.store, USER_CACHE_ENTITY, id, fmt.Sprintf(USER_CACHE_KEY, id))
+		return nil
+	}
+	if err := r.store.Delete(ctx, fmt.Sprintf(USER_CACHE_KEY, id)); err != nil {
 		log.Warn("failed to remove user from cache", "userID", id, "error", err)
 	}
+	r.invalidate(ctx, id)
 
 	return nil
 }
 
+// List returns one page of users in sort order, newest first when it is
+// the zero UserSort, and whether there are more users after it. Pages start
+// at 1. Only columns are read, or every column when nil, so callers that
+// don't need the password hash don't load it.
+func (r *userRepository) List(ctx context.Context, page int, sort UserSort, columns []string) ([]User, bool, error) {
+	ctx, span := tracing.Start(ctx, "userRepository.List")
+	defer span.End()
+	log := r.log.Function("List")
+
+	if page < 1 {
+		page = 1
+	}
+
+	if sort.Column == "" {
+		sort = DefaultUserSort
+	}
+
+	var users []User
+	if err := orderBy(selectColumns(r.db.SQLWithContext(ctx), columns), OrderBy{Column: sort.Column, Desc: sort.Desc}).
+		Offset((page - 1) * USER_LIST_PAGE_SIZE).
+		Limit(USER_LIST_PAGE_SIZE + 1).
+		Find(&users).Error; err != nil {
+		return nil, false, log.Err("failed to list users", err, "page", page)
+	}
+
+	hasMore := len(users) > USER_LIST_PAGE_SIZE
+	if hasMore {
+		users = users[:USER_LIST_PAGE_SIZE]
+	}
+
+	return users, hasMore, nil
+}
+
+// Stream hands every user to each, newest first like List, one batch at a
+// time, following on from the last user of the batch before as the audit log
+// stream does. columns must include id and created_at when set.
+func (r *userRepository) Stream(ctx context.Context, columns []string, each func(users []User) error) (int64, error) {
+	ctx, span := tracing.Start(ctx, "userRepository.Stream")
+	defer span.End()
+	log := r.log.Function("Stream")
+
+	var (
+		users    []User
+		streamed int64
+	)
+	for {
+		query := orderBy(selectColumns(r.db.SQLWithContext(ctx), columns), OrderBy{Column: "created_at", Desc: true}).
+			Limit(USER_STREAM_BATCH_SIZE)
+		if len(users) > 0 {
+			last := users[len(users)-1]
+			query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", last.CreatedAt, last.CreatedAt, last.ID)
+		}
+
+		users = users[:0]
+		if err := query.Find(&users).Error; err != nil {
+			return streamed, log.Err("failed to stream users", err, "streamed", streamed)
+		}
+		if len(users) == 0 {
+			return streamed, nil
+		}
+
+		if err := each(users); err != nil {
+			return streamed, err
+		}
+		streamed += int64(len(users))
+		if len(users) < USER_STREAM_BATCH_SIZE {
+			return streamed, nil
+		}
+	}
+}
+
+// selectColumns narrows db to columns, leaving it reading every column when
+// there are none.
+func selectColumns(db *gorm.DB, columns []string) *gorm.DB {
+	if len(columns) == 0 {
+		return db
+	}
+	return db.Select(columns)
+}
+
+// changed brings the caches up to date with user. Within a bulk operation the
+// cached copy is queued for deletion on its invalidation batch instead, and
+// reloaded when next read.
+func (r *userRepository) changed(ctx context.Context, user *User) {
+	if batch := database.InvalidationBatchFrom(ctx); batch != nil {
+		batch.Add(r.store, USER_CACHE_ENTITY, user.ID, fmt.Sprintf(USER_CACHE_KEY, user.ID))
+		return
+	}
+
+	if err := r.addUserToCache(ctx, user); err != nil {
+		r.log.Function("changed").Warn("failed to update user in cache", "userID", user.ID, "error", err)
+	}
+	r.invalidate(ctx, user.ID)
+}
+
+// invalidate runs after valkey holds the new state, so instances that drop
+// their local copy reload the change rather than the old value.
+func (r *userRepository) invalidate(ctx context.Context, userID string) {
+	if err := r.invalidator.Invalidate(ctx, USER_CACHE_ENTITY, userID); err != nil {
+		r.log.Function("invalidate").Warn("failed to invalidate user", "userID", userID, "error", err)
+	}
+}
+
+// getCacheByID returns ErrCacheMiss quietly, since a miss is expected, and
+// logs any other failure.
 func (r *userRepository) getCacheByID(ctx context.Context, userID string, user *User) error {
-	if err := database.NewCacheBuilder(r.db.Cache.User, userID).Get(user); err != nil {
+	cached, err := database.GetVersioned[User](ctx, r.store, fmt.Sprintf(USER_CACHE_KEY, userID), USER_CACHE_VERSION)
+	if errors.Is(err, database.ErrCacheMiss) {
+		return err
+	}
+	if err != nil {
 		return r.log.Function("getCacheByID").
 			Err("failed to get user from cache", err, "userID", userID)
 	}
+	*user = cached
 	return nil
 }
 
 func (r *userRepository) addUserToCache(ctx context.Context, user *User) error {
-	if err := database.NewCacheBuilder(r.db.Cache.User, user.ID).
-		WithSruct(user).
-		WithTTL(USER_CACHE_EXPIRY).
-		WithContext(ctx).
-		Set(); err != nil {
+	key := fmt.Sprintf(USER_CACHE_KEY, user.ID)
+	if err := database.SetVersioned(ctx, r.store, key, USER_CACHE_VERSION, *user, USER_CACHE_EXPIRY); err != nil {
 		return r.log.Function("addUserToCache").
 			Err("failed to add user to cache", err, "user", user)
 	}
@@ -126,12 +404,18 @@ func (r *userRepository) addUserToCache(ctx context.Context, user *User) error {
 func (r *userRepository) getDBByID(ctx context.Context, userID string, user *User) error {
 	log := r.log.Function("getDBByID")
 
+	// No user can have an ID that isn't a UUID
 	id, err := uuid.Parse(userID)
 	if err != nil {
-		return log.Err("failed to parse userID", err, "userID", userID)
+		log.Debug("User ID is not a UUID", "userID", userID)
+		return ErrNotFound
 	}
 
-	if err := r.db.SQLWithContext(ctx).First(user, "id = ?", id).Error; err != nil {
+	err = r.db.SQLWithContext(ctx).First(user, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
 		return log.Err("failed to get user by id", err, "id", userID)
 	}
 
@@ -139,7 +423,11 @@ func (r *userRepository) getDBByID(ctx context.Context, userID string, user *Use
 }
 
 func (r *userRepository) getDBByLogin(ctx context.Context, login string, user *User) error {
-	if err := r.db.SQLWithContext(ctx).First(user, "login = ?", login).Error; err != nil {
+	err := r.db.SQLWithContext(ctx).First(user, "login = ?", login).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
 		return r.log.Function("getDBByLogin").
 			Err("failed to get user by login", err, "login", login)
 	}
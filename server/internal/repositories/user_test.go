@@ -0,0 +1,341 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"server/config"
+	"server/internal/database"
+	"server/internal/events"
+	. "server/internal/models"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupUserTest(t *testing.T) (UserRepository, *User) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+	repo := New(database.DB{SQL: db}, invalidator)
+	user := &User{Login: "jdoe", FirstName: "Jane"}
+	require.NoError(t, repo.Create(context.Background(), user, config.Config{}))
+	require.Equal(t, 1, user.Version)
+
+	// Set after create so BeforeCreate doesn't need a configured pepper.
+	require.NoError(t, db.Model(user).UpdateColumn("password", "hash").Error)
+
+	return repo, user
+}
+
+func TestUserRepository_Create_DuplicateLogin(t *testing.T) {
+	repo, _ := setupUserTest(t)
+
+	for _, login := range []string{"jdoe", "JDoe"} {
+		err := repo.Create(context.Background(), &User{Login: login}, config.Config{})
+		assert.ErrorIs(t, err, ErrDuplicate, login)
+	}
+}
+
+func TestUserRepository_Create_LoginOfDeletedUser(t *testing.T) {
+	repo, user := setupUserTest(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.Delete(ctx, user.ID))
+
+	again := &User{Login: "JDoe"}
+	require.NoError(t, repo.Create(ctx, again, config.Config{}))
+	assert.NotEqual(t, user.ID, again.ID)
+}
+
+func TestUserRepository_UpdateProfile_MatchingVersion(t *testing.T) {
+	repo, user := setupUserTest(t)
+
+	user.FirstName = "Janet"
+	require.NoError(t, repo.UpdateProfile(context.Background(), user, 1))
+
+	assert.Equal(t, 2, user.Version)
+	assert.Equal(t, "Janet", user.FirstName)
+	assert.Equal(t, "hash", user.Password, "profile updates must not touch the password")
+}
+
+func TestUserRepository_UpdateProfile_StaleVersion(t *testing.T) {
+	repo, user := setupUserTest(t)
+	ctx := context.Background()
+
+	first := *user
+	first.FirstName = "First"
+	require.NoError(t, repo.UpdateProfile(ctx, &first, 1))
+
+	second := *user
+	second.FirstName = "Second"
+	err := repo.UpdateProfile(ctx, &second, 1)
+
+	var conflictErr *VersionConflictError
+	require.True(t, errors.As(err, &conflictErr))
+	assert.Equal(t, 2, conflictErr.Current)
+
+	stored, err := repo.GetByLogin(ctx, "jdoe")
+	require.NoError(t, err)
+	assert.Equal(t, "First", stored.FirstName)
+}
+
+func TestUserRepository_UpdateProfile_Unconditional(t *testing.T) {
+	repo, user := setupUserTest(t)
+	ctx := context.Background()
+
+	stale := *user
+	require.NoError(t, repo.UpdateProfile(ctx, user, 0))
+
+	stale.LastName = "Doe"
+	require.NoError(t, repo.UpdateProfile(ctx, &stale, 0))
+	assert.Equal(t, 3, stale.Version)
+	assert.Equal(t, "Doe", stale.LastName)
+}
+
+func TestUserRepository_Update_KeepsVersion(t *testing.T) {
+	repo, user := setupUserTest(t)
+	ctx := context.Background()
+
+	stale := *user
+	require.NoError(t, repo.UpdateProfile(ctx, user, 1))
+
+	stale.Password = "new-hash"
+	require.NoError(t, repo.Update(ctx, &stale))
+
+	stored, err := repo.GetByLogin(ctx, "jdoe")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stored.Version, "a stale Update must not roll the version back")
+}
+
+func TestUserRepository_UpdateProfile_ConcurrentEdits(t *testing.T) {
+	repo, user := setupUserTest(t)
+
+	const editors = 8
+	results := make(chan error, editors)
+
+	var wg sync.WaitGroup
+	for i := range editors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			edit := *user
+			edit.FirstName = string(rune('A' + i))
+			results <- repo.UpdateProfile(context.Background(), &edit, 1)
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded, conflicted := 0, 0
+	for err := range results {
+		var conflictErr *VersionConflictError
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.As(err, &conflictErr):
+			conflicted++
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	assert.Equal(t, 1, succeeded)
+	assert.Equal(t, editors-1, conflicted)
+}
+
+// fakePubSub delivers every invalidation to all subscribers, including the
+// publisher, the way valkey pub/sub does.
+type fakePubSub struct {
+	mutex    sync.Mutex
+	handlers []func(ctx context.Context, payload events.CacheInvalidatedEvent) error
+}
+
+func (f *fakePubSub) Publish(ctx context.Context, payload events.CacheInvalidatedEvent) error {
+	f.mutex.Lock()
+	handlers := append([]func(context.Context, events.CacheInvalidatedEvent) error{}, f.handlers...)
+	f.mutex.Unlock()
+
+	for _, handler := range handlers {
+		if err := handler(ctx, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakePubSub) Subscribe(handler func(ctx context.Context, payload events.CacheInvalidatedEvent) error) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.handlers = append(f.handlers, handler)
+	return nil
+}
+
+func TestUserRepository_InvalidationAcrossInstances(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+	ctx := context.Background()
+
+	pubsub := &fakePubSub{}
+	newInstance := func() UserRepository {
+		invalidator, err := database.NewInvalidator(pubsub)
+		require.NoError(t, err)
+		return New(database.DB{SQL: db}, invalidator)
+	}
+	instanceA, instanceB := newInstance(), newInstance()
+
+	user := &User{Login: "jdoe", FirstName: "Jane"}
+	require.NoError(t, instanceA.Create(ctx, user, config.Config{}))
+
+	cached, err := instanceB.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane", cached.FirstName)
+
+	// B serves its local copy until something tells it otherwise.
+	require.NoError(t, db.Model(&User{}).Where("id = ?", user.ID).UpdateColumn("last_name", "Doe").Error)
+	cached, err = instanceB.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, cached.LastName)
+
+	user.FirstName = "Janet"
+	require.NoError(t, instanceA.UpdateProfile(ctx, user, 0))
+
+	cached, err = instanceB.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Janet", cached.FirstName, "the update on A must evict B's copy")
+
+	require.NoError(t, instanceA.Delete(ctx, user.ID))
+	_, err = instanceB.GetByID(ctx, user.ID)
+	assert.Error(t, err, "a deleted user must not be served from B's cache")
+}
+
+func TestUserRepository_NotFound(t *testing.T) {
+	repo, user := setupUserTest(t)
+	ctx := context.Background()
+
+	_, err := repo.GetByID(ctx, "0190a5b4-7c2e-7000-8000-000000000000")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.GetByID(ctx, "not-a-uuid")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.GetByLogin(ctx, "nobody")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	found, err := repo.GetByLogin(ctx, user.Login)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+}
+
+func TestUserRepository_StoreErrorIsNotNotFound(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+	repo := New(database.DB{SQL: db}, invalidator)
+
+	// No users table, so every lookup fails
+	_, err = repo.GetByID(context.Background(), "0190a5b4-7c2e-7000-8000-000000000000")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNotFound)
+	_, err = repo.GetByLogin(context.Background(), "jdoe")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNotFound)
+}
+
+func TestUserRepository_BulkQueuesInvalidations(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+	ctx := context.Background()
+
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+	sqlDB := database.DB{SQL: db}
+	repo := New(sqlDB, invalidator).(*userRepository)
+	repo.store = database.NewMemoryCacheStore()
+
+	user := &User{Login: "jdoe", FirstName: "Jane"}
+	require.NoError(t, repo.Create(ctx, user, config.Config{}))
+	key := fmt.Sprintf(USER_CACHE_KEY, user.ID)
+
+	// Outside a bulk operation the cached copy is rewritten straight away
+	user.FirstName = "Janet"
+	require.NoError(t, repo.Update(ctx, user))
+	cached, err := database.GetVersioned[User](ctx, repo.store, key, USER_CACHE_VERSION)
+	require.NoError(t, err)
+	assert.Equal(t, "Janet", cached.FirstName)
+
+	_, err = repo.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Bulk(ctx, invalidator, func(ctx context.Context) error {
+		user.FirstName = "Jo"
+		require.NoError(t, repo.Update(ctx, user))
+
+		_, err := database.GetVersioned[User](ctx, repo.store, key, USER_CACHE_VERSION)
+		assert.NoError(t, err, "the cached copy stays until the batch flushes")
+		return nil
+	}))
+
+	_, err = database.GetVersioned[User](ctx, repo.store, key, USER_CACHE_VERSION)
+	assert.ErrorIs(t, err, database.ErrCacheMiss)
+	reloaded, err := repo.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Jo", reloaded.FirstName, "the local copy was evicted at commit")
+}
+
+func TestUserRepository_GetByIDs(t *testing.T) {
+	repo, jane := setupUserTest(t)
+	ctx := context.Background()
+	john := &User{Login: "jsmith", FirstName: "John"}
+	require.NoError(t, repo.Create(ctx, john, config.Config{}))
+	missing := "0190a5b4-7c2e-7000-8000-000000000000"
+
+	users, err := repo.GetByIDs(ctx, []string{john.ID, missing, jane.ID, "not-a-uuid", john.ID})
+	require.NoError(t, err)
+
+	require.Len(t, users, 2, "missing IDs are left out and repeated ones given once")
+	assert.Equal(t, john.ID, users[0].ID, "users come back in the order asked for")
+	assert.Equal(t, jane.ID, users[1].ID)
+
+	users, err = repo.GetByIDs(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func TestUserRepository_GetByIDs_Chunks(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&User{}))
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+	repo := New(database.DB{SQL: db}, invalidator)
+	ctx := context.Background()
+
+	// One user at the very end, so the last chunk has to be read too
+	ids := make([]string, 0, USER_LOOKUP_CHUNK_SIZE*2+1)
+	for i := range USER_LOOKUP_CHUNK_SIZE * 2 {
+		ids = append(ids, fmt.Sprintf("0190a5b4-7c2e-7000-8000-%012d", i))
+	}
+	user := &User{Login: "jdoe"}
+	require.NoError(t, repo.Create(ctx, user, config.Config{}))
+	ids = append(ids, user.ID)
+
+	var queries int
+	require.NoError(t, db.Callback().Query().After("gorm:query").Register("count_queries", func(*gorm.DB) {
+		queries++
+	}))
+
+	users, err := repo.GetByIDs(ctx, ids)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, user.ID, users[0].ID)
+	assert.Equal(t, 3, queries, "%d IDs are read %d at a time", len(ids), USER_LOOKUP_CHUNK_SIZE)
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"server/config"
+	"server/internal/routes/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ClientVersionRoutes mounts /client-version, which tells the client sending
+// it whether it is below the minimum version of its type, and if so the
+// minimum and where to upgrade. Outdated clients can always reach it.
+func ClientVersionRoutes(router fiber.Router, config config.Config) {
+	router.Get(middleware.CLIENT_VERSION_PATH, func(c *fiber.Ctx) error {
+		info, err := middleware.GetClientInfo(c, config)
+		if err != nil {
+			return middleware.RejectClientInfo(c, err, config)
+		}
+		return c.JSON(middleware.CheckClientVersion(config, info))
+	})
+}
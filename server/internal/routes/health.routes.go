@@ -2,16 +2,40 @@ package routes
 
 import (
 	"server/config"
+	"server/internal/health"
+	"server/internal/maintenance"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func HealthRoutes(router fiber.Router, config config.Config) {
+// HealthRoutes mounts /health, which runs every checker in checks and answers
+// 503 when a critical one is down. checks may be nil, when only the version
+// is reported. Health stays up in maintenance mode and reports it when mode
+// is set.
+func HealthRoutes(
+	router fiber.Router,
+	config config.Config,
+	checks *health.Registry,
+	mode *maintenance.Mode,
+) {
 	router.Get("/health", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{
-			"status":  "ok",
+		response := fiber.Map{
+			"status":  health.STATUS_OK,
 			"version": config.GeneralVersion,
 			"service": "app_api",
-		})
+		}
+		if checks != nil {
+			report := checks.Run(c.UserContext())
+			response["status"] = report.Status
+			response["checks"] = report.Checks
+		}
+		if mode != nil {
+			response["maintenance"] = mode.State()
+		}
+
+		if response["status"] == health.STATUS_DOWN {
+			c.Status(fiber.StatusServiceUnavailable)
+		}
+		return c.JSON(response)
 	})
 }
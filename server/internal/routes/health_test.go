@@ -1,15 +1,21 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http/httptest"
+	"path/filepath"
 	"server/config"
+	"server/internal/database"
+	"server/internal/health"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func TestHealthRoutes(t *testing.T) {
@@ -18,7 +24,7 @@ func TestHealthRoutes(t *testing.T) {
 	}
 
 	app := fiber.New()
-	HealthRoutes(app, testConfig)
+	HealthRoutes(app, testConfig, nil, nil)
 
 	req := httptest.NewRequest("GET", "/health", nil)
 	resp, err := app.Test(req)
@@ -44,7 +50,7 @@ func TestHealthRoutes_WithEmptyVersion(t *testing.T) {
 	}
 
 	app := fiber.New()
-	HealthRoutes(app, testConfig)
+	HealthRoutes(app, testConfig, nil, nil)
 
 	req := httptest.NewRequest("GET", "/health", nil)
 	resp, err := app.Test(req)
@@ -70,7 +76,7 @@ func TestHealthRoutes_ResponseStructure(t *testing.T) {
 	}
 
 	app := fiber.New()
-	HealthRoutes(app, testConfig)
+	HealthRoutes(app, testConfig, nil, nil)
 
 	req := httptest.NewRequest("GET", "/health", nil)
 	resp, err := app.Test(req)
@@ -100,7 +106,7 @@ func TestHealthRoutes_ContentType(t *testing.T) {
 	}
 
 	app := fiber.New()
-	HealthRoutes(app, testConfig)
+	HealthRoutes(app, testConfig, nil, nil)
 
 	req := httptest.NewRequest("GET", "/health", nil)
 	resp, err := app.Test(req)
@@ -116,7 +122,7 @@ func TestHealthRoutes_HTTPMethods(t *testing.T) {
 	}
 
 	app := fiber.New()
-	HealthRoutes(app, testConfig)
+	HealthRoutes(app, testConfig, nil, nil)
 
 	// Test GET method works
 	req := httptest.NewRequest("GET", "/health", nil)
@@ -149,7 +155,7 @@ func TestHealthRoutes_MultipleRequests(t *testing.T) {
 	}
 
 	app := fiber.New()
-	HealthRoutes(app, testConfig)
+	HealthRoutes(app, testConfig, nil, nil)
 
 	// Make multiple requests to ensure consistency
 	for i := 0; i < 5; i++ {
@@ -212,7 +218,7 @@ func TestHealthRoutes_ConfigVariations(t *testing.T) {
 			}
 
 			app := fiber.New()
-			HealthRoutes(app, testConfig)
+			HealthRoutes(app, testConfig, nil, nil)
 
 			req := httptest.NewRequest("GET", "/health", nil)
 			resp, err := app.Test(req)
@@ -229,3 +235,104 @@ func TestHealthRoutes_ConfigVariations(t *testing.T) {
 		})
 	}
 }
+
+func TestHealthRoutes_BackupStatus(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "app.db")), &gorm.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	testConfig := config.Config{
+		GeneralVersion: "1.0.0",
+		Database: config.DatabaseConfig{
+			BackupDir:       filepath.Join(dir, "backups"),
+			BackupRetention: 1,
+		},
+	}
+	backup := database.NewBackup(db, testConfig, nil)
+	require.NoError(t, backup.Run(context.Background()))
+
+	checks := health.New(0)
+	checks.Register(backup.Health(), false)
+
+	app := fiber.New()
+	HealthRoutes(app, testConfig, checks, nil)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/health", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var healthResponse struct {
+		Status string `json:"status"`
+		Checks []struct {
+			Name    string `json:"name"`
+			Status  string `json:"status"`
+			Details struct {
+				Backup database.BackupStatus `json:"backup"`
+			} `json:"details"`
+		} `json:"checks"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&healthResponse))
+
+	assert.Equal(t, "ok", healthResponse.Status)
+	require.Len(t, healthResponse.Checks, 1)
+	assert.Equal(t, "backup", healthResponse.Checks[0].Name)
+	assert.Equal(t, "ok", healthResponse.Checks[0].Status)
+	assert.Equal(t, backup.Status().File, healthResponse.Checks[0].Details.Backup.File)
+	assert.NotNil(t, healthResponse.Checks[0].Details.Backup.LastSuccessAt)
+	assert.Empty(t, healthResponse.Checks[0].Details.Backup.Error)
+}
+
+type stubChecker struct {
+	name   string
+	status string
+}
+
+func (c stubChecker) Name() string { return c.name }
+
+func (c stubChecker) Check(ctx context.Context) health.CheckResult {
+	return health.CheckResult{Status: c.status}
+}
+
+func TestHealthRoutes_Checks(t *testing.T) {
+	testCases := []struct {
+		name           string
+		critical       bool
+		expectedCode   int
+		expectedStatus string
+	}{
+		{"critical down", true, fiber.StatusServiceUnavailable, health.STATUS_DOWN},
+		{"non-critical down", false, fiber.StatusOK, health.STATUS_DEGRADED},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checks := health.New(0)
+			checks.Register(stubChecker{name: "database", status: health.STATUS_OK}, true)
+			checks.Register(stubChecker{name: "cache", status: health.STATUS_DOWN}, tc.critical)
+
+			app := fiber.New()
+			HealthRoutes(app, config.Config{GeneralVersion: "1.0.0"}, checks, nil)
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/health", nil))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedCode, resp.StatusCode)
+
+			var healthResponse struct {
+				Status  string         `json:"status"`
+				Version string         `json:"version"`
+				Checks  []health.Check `json:"checks"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&healthResponse))
+			assert.Equal(t, tc.expectedStatus, healthResponse.Status)
+			assert.Equal(t, "1.0.0", healthResponse.Version)
+			require.Len(t, healthResponse.Checks, 2)
+			assert.Equal(t, "cache", healthResponse.Checks[0].Name)
+			assert.Equal(t, tc.critical, healthResponse.Checks[0].Critical)
+		})
+	}
+}
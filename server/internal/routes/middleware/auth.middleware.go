@@ -2,8 +2,15 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"server/config"
+	"server/internal/apierror"
+	"server/internal/events"
 	. "server/internal/models"
+	"server/internal/repositories"
 	"server/internal/utils"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -19,8 +26,94 @@ type SessionData struct {
 const (
 	MOBILE_CLIENT_TYPE = "flutter"
 	WEB_CLIENT_TYPE    = "solid"
+
+	ErrorCodeAuthUnavailable = "auth_unavailable"
+	ErrorCodeAuthRequired    = "auth_required"
+	ErrorCodeAdminRequired   = "admin_required"
+	ErrorCodeInvalidToken    = "invalid_token"
+
+	ErrorCodeTokenInCookie     = "token_in_cookie"
+	ErrorCodeSessionIDInHeader = "session_id_in_header"
+
+	// Authenticated responses carry when the session expires and is next
+	// refreshed, and for token clients when their token expires, RFC3339 in
+	// UTC, so clients needn't guess when to sign the user out
+	SESSION_EXPIRES_AT_HEADER = "X-Session-Expires-At"
+	SESSION_REFRESH_AT_HEADER = "X-Session-Refresh-At"
+	TOKEN_EXPIRES_AT_HEADER   = "X-Token-Expires-At"
+)
+
+func init() {
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeAuthUnavailable, Status: fiber.StatusServiceUnavailable, Title: "Service temporarily unavailable",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeAuthRequired, Status: fiber.StatusUnauthorized, Title: "Authentication required",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeInvalidToken, Status: fiber.StatusUnauthorized, Title: "Invalid token",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeTokenInCookie, Status: fiber.StatusBadRequest, Title: "Token sent as the session cookie",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeSessionIDInHeader, Status: fiber.StatusBadRequest, Title: "Session ID sent as the token",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeAdminRequired, Status: fiber.StatusForbidden, Title: "Admin access required",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeImpersonationForbidden, Status: fiber.StatusForbidden, Title: "Not allowed while impersonating",
+	})
+	apierror.Register(apierror.Definition{
+		Code:   ErrorCodeTermsReacceptanceRequired,
+		Status: fiber.StatusUnavailableForLegalReasons,
+		Title:  "Terms of service must be accepted",
+	})
+}
+
+// errAuthStoreUnavailable wraps session and user lookups that failed for a
+// reason other than the record not existing.
+var errAuthStoreUnavailable = errors.New("auth store unavailable")
+
+// Clients that mix up the two strategies send the token as the session cookie
+// or the session ID as the token. Both are caught by their shape, before any
+// lookup, so the client is told what it did rather than that its session is
+// gone.
+var (
+	errCredentialMisplaced = errors.New("credential sent the wrong way")
+	errTokenInCookie       = fmt.Errorf("%w: token in session cookie", errCredentialMisplaced)
+	errSessionIDInHeader   = fmt.Errorf("%w: session ID in Authorization header", errCredentialMisplaced)
 )
 
+// looksLikeJWT reports whether value has a JWT's three dot separated
+// base64url segments. Session IDs are UUIDs, which have no dots.
+func looksLikeJWT(value string) bool {
+	segments := strings.Split(value, ".")
+	if len(segments) != 3 {
+		return false
+	}
+	for _, segment := range segments {
+		if segment == "" || strings.TrimLeft(segment, base64URLAlphabet) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+const base64URLAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// looksLikeSessionID reports whether value, less any Bearer scheme, is a bare
+// UUID in its usual form. Tokens always have dots, so none is mistaken for one.
+func looksLikeSessionID(value string) bool {
+	value = strings.TrimPrefix(value, "Bearer ")
+	if len(value) != 36 {
+		return false
+	}
+	_, err := uuid.Parse(value)
+	return err == nil
+}
+
 func (m *Middleware) getWebSessionData(c *fiber.Ctx) (Session, error) {
 	log := m.log.Function("getWebSessionData")
 
@@ -29,14 +122,17 @@ func (m *Middleware) getWebSessionData(c *fiber.Ctx) (Session, error) {
 		log.Warn("No session cookie found")
 		return Session{}, nil
 	}
+	if looksLikeJWT(sessionID) {
+		return Session{}, errTokenInCookie
+	}
 
-	sessionPtr, err := m.sessionRepo.GetByID(context.Background(), sessionID)
+	sessionPtr, err := m.lookupSession(c, sessionID)
 	if err != nil {
-		return Session{}, log.Err("failed to get session", err, "sessionID", sessionID)
+		return Session{}, err
 	}
 	session := *sessionPtr
 
-	if session.ExpiresAt.Before(time.Now()) {
+	if session.ExpiresAt.Before(m.now()) {
 		return Session{}, log.ErrMsg("Session expired")
 	}
 
@@ -49,19 +145,25 @@ func (m *Middleware) getMobileSessionData(c *fiber.Ctx) (Session, error) {
 	if token == "" {
 		return Session{}, log.ErrMsg("No token found")
 	}
+	if looksLikeSessionID(token) {
+		return Session{}, errSessionIDInHeader
+	}
 
-	claims, err := utils.ParseJWTToken(token, m.Config)
+	claims, err := utils.ParseJWTToken(token, m.Config, m.clock)
 	if err != nil {
 		return Session{}, log.Err("failed to parse token", err)
 	}
+	if claims.ExpiresAt != nil {
+		c.Locals("tokenExpiresAt", claims.ExpiresAt.Time)
+	}
 
-	sessionPtr, err := m.sessionRepo.GetByID(context.Background(), claims.Subject)
+	sessionPtr, err := m.lookupSession(c, claims.Subject)
 	if err != nil {
-		return Session{}, log.Err("failed to get session", err)
+		return Session{}, err
 	}
 	session := *sessionPtr
 
-	if session.ExpiresAt.Before(time.Now()) {
+	if session.ExpiresAt.Before(m.now()) {
 		return Session{}, log.ErrMsg("Session expired")
 	}
 
@@ -76,65 +178,266 @@ func (m *Middleware) BasicAuth() fiber.Handler {
 		var session Session
 		var err error
 
+		// A failing store says nothing about the session, so it is kept, as
+		// is one the client merely sent the wrong way
 		defer func() {
-			if err != nil {
-				utils.ExpireCookie(c, SESSION_COOKIE_KEY)
+			if err != nil && !errors.Is(err, errAuthStoreUnavailable) && !errors.Is(err, errCredentialMisplaced) {
+				utils.ExpireCookie(c, SESSION_COOKIE_KEY, m.Config)
+				if session.ID == "" {
+					return
+				}
+				m.authBudget.Forget(session.ID)
 				if err := m.sessionRepo.Delete(context.Background(), session.ID); err != nil {
 					log.Er("failed to delete session", err, "sessionID", session.ID)
 				}
 			}
 		}()
 
-		clientType := c.Get("X-Client-Type")
+		client, clientErr := GetClientInfo(c, m.Config)
+		if clientErr != nil {
+			return RejectClientInfo(c, clientErr, m.Config)
+		}
+		clientType := client.Type
 		if clientType == "" {
 			return log.ErrMsg("No user client type found")
 		}
+		if client.Rejected() {
+			log.Warn("Rejected unknown client type", "clientType", clientType)
+			return RejectClientType(c, clientType, m.Config)
+		}
+		strategy := client.Strategy
 
-		switch clientType {
-		case WEB_CLIENT_TYPE:
-			log.Info("Client type is web", "clientType", clientType)
+		switch strategy {
+		case config.AUTH_STRATEGY_COOKIE:
+			log.Info("Client authenticates with a cookie", "clientType", clientType)
 			session, err = m.getWebSessionData(c)
-			if err != nil {
-				return err
-			}
-		case MOBILE_CLIENT_TYPE:
-			log.Info("Client type is mobile", "clientType", clientType)
+		case config.AUTH_STRATEGY_TOKEN:
+			log.Info("Client authenticates with a token", "clientType", clientType)
 			session, err = m.getMobileSessionData(c)
-			if err != nil {
-				return err
-			}
+		}
+		switch {
+		case errors.Is(err, repositories.ErrNotFound):
+			log.Info("Session not found, continuing unauthenticated")
+			return m.afterAuth(c)
+		case errors.Is(err, errAuthStoreUnavailable):
+			return m.authUnavailable(c, err)
+		case errors.Is(err, errTokenInCookie):
+			log.Warn("Rejected token sent as the session cookie", "clientType", clientType, "ip", c.IP())
+			return apierror.Send(c, apierror.New(ErrorCodeTokenInCookie,
+				"The session cookie holds a token. Clients of type "+clientType+
+					" send the session ID as the cookie; send tokens in the Authorization header with a token client type"), m.Config)
+		case errors.Is(err, errSessionIDInHeader):
+			log.Warn("Rejected session ID sent as the token", "clientType", clientType, "ip", c.IP())
+			return apierror.Send(c, apierror.New(ErrorCodeSessionIDInHeader,
+				"The Authorization header holds a session ID. Clients of type "+clientType+
+					" send the token from X-Auth-Token; session IDs go in the session cookie with a cookie client type"), m.Config)
+		case errors.Is(err, utils.ErrWrongIssuer), errors.Is(err, utils.ErrWrongAudience):
+			// Signed with our secret but not by or for us, so it isn't
+			// treated as merely signed out
+			log.Warn("Rejected token from another service", "error", err, "ip", c.IP())
+			return apierror.Send(c, apierror.New(ErrorCodeInvalidToken, "Token was not issued for this service"), m.Config)
+		case err != nil:
+			return err
 		}
 
 		found := session != (Session{})
 		if !found {
-			return c.Next()
+			return m.afterAuth(c)
 		}
 
-		if session.RefreshAt.Before(time.Now()) {
-			log.Info("Refreshing session", "sessionID", session.ID)
-			if err := m.sessionRepo.Create(context.Background(), &session, m.Config); err != nil {
-				return log.Err("failed to refresh session", err, "sessionID", session.ID)
+		// A remembered session is served as it was, without a refresh, which
+		// would have to write to the cache that is failing
+		memo, remembered := c.Locals("authMemo").(authMemo)
+
+		// Impersonation sessions end when they expire, they are never refreshed.
+		refreshed := false
+		if !remembered && !session.Impersonated() && session.RefreshAt.Before(m.now()) {
+			if err := m.refreshSession(c, &session, strategy); err != nil {
+				return err
 			}
-			utils.ApplyCookie(c, utils.Cookie{
-				Name:    SESSION_COOKIE_KEY,
-				Value:   session.ID,
-				Expires: session.ExpiresAt,
-			})
-			utils.ApplyToken(c, session.Token)
+			refreshed = true
 		}
 
-		userPtr, err := m.userRepo.GetByID(context.Background(), session.UserID)
-		if err != nil {
-			return log.Err("failed to get user", err, "userID", session.UserID)
+		user := memo.User
+		source := AUTH_SOURCE_MEMO
+		if !remembered {
+			source = AUTH_SOURCE_STORE
+			var userPtr *User
+			userPtr, err = m.userRepo.GetByID(context.Background(), session.UserID)
+			m.authBudget.Record(AUTH_STORE_SQL, err)
+			if err != nil {
+				err = lookupError(err)
+				if errors.Is(err, repositories.ErrNotFound) {
+					log.Info("Session user not found, continuing unauthenticated", "userID", session.UserID)
+					return m.afterAuth(c)
+				}
+				return m.authUnavailable(c, err)
+			}
+			user = *userPtr
+
+			// A refreshed session is remembered under its new ID on the
+			// client's next request
+			if key, ok := c.Locals("authKey").(string); ok && !refreshed {
+				m.authBudget.Remember(key, session, user)
+			}
 		}
-		user := *userPtr
+
+		if session.Impersonated() {
+			c.Set(IMPERSONATING_HEADER, "true")
+			if IsImpersonationBlocked(c.Method(), c.Path()) {
+				log.Warn("Rejected request while impersonating",
+					"path", c.Path(), "userID", user.ID, "adminID", session.ImpersonatedBy)
+				return apierror.Send(c, apierror.New(
+					ErrorCodeImpersonationForbidden,
+					"Not allowed while impersonating a user",
+				), m.Config)
+			}
+		}
+
+		// An admin acting as the user can't accept terms for them, so
+		// impersonation sessions aren't held back
+		if !session.Impersonated() && m.termsHeld(c, user) {
+			log.Info("Held write until terms are accepted",
+				"path", c.Path(), "userID", user.ID, "tosVersion", user.TosVersion)
+			return apierror.Send(c, apierror.New(
+				ErrorCodeTermsReacceptanceRequired,
+				"The terms of service have changed and must be accepted again",
+			).With("tosVersion", m.Config.TermsVersion), m.Config)
+		}
+
+		applyExpiryHeaders(c, session, refreshed)
 
 		c.Locals("userID", user.ID)
 		c.Locals("user", user)
 		c.Locals("session", session)
 		c.Locals("authenticated", true)
+		c.Locals("authSource", source)
 
-		return c.Next()
+		return m.afterAuth(c)
+	}
+}
+
+// applyExpiryHeaders sets the expiry headers from session, as refreshed when
+// it just was. A token client holds the token it sent, or after a refresh
+// the one it was just handed, which expires with the session.
+func applyExpiryHeaders(c *fiber.Ctx, session Session, refreshed bool) {
+	c.Set(SESSION_EXPIRES_AT_HEADER, session.ExpiresAt.UTC().Format(time.RFC3339))
+	c.Set(SESSION_REFRESH_AT_HEADER, session.RefreshAt.UTC().Format(time.RFC3339))
+
+	tokenExpiresAt, sentToken := c.Locals("tokenExpiresAt").(time.Time)
+	if !sentToken {
+		return
+	}
+	if refreshed {
+		tokenExpiresAt = session.ExpiresAt
+	}
+	c.Set(TOKEN_EXPIRES_AT_HEADER, tokenExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// refreshSession replaces session with a new one, with a new ID, token and
+// expiry, and hands them to the client. Token clients' open websockets are
+// sent the new token through a session.refreshed event; cookie clients have
+// nothing to update.
+func (m *Middleware) refreshSession(c *fiber.Ctx, session *Session, strategy string) error {
+	log := m.log.Function("refreshSession")
+	ctx := context.Background()
+
+	previousID := session.ID
+	log.Info("Refreshing session", "sessionID", previousID)
+
+	session.ID = ""
+	if err := m.sessionRepo.Create(ctx, session, m.Config); err != nil {
+		session.ID = previousID
+		return log.Err("failed to refresh session", err, "sessionID", previousID)
+	}
+	m.authBudget.Forget(previousID)
+	if err := m.sessionRepo.Delete(ctx, previousID); err != nil {
+		log.Warn("failed to delete refreshed session", "sessionID", previousID, "error", err)
+	}
+
+	utils.ApplyCookie(c, utils.Cookie{
+		Name:    SESSION_COOKIE_KEY,
+		Value:   session.ID,
+		Expires: session.ExpiresAt,
+	}, m.Config)
+	utils.ApplyToken(c, session.Token)
+
+	if strategy != config.AUTH_STRATEGY_TOKEN || m.eventBus == nil {
+		return nil
+	}
+	if err := m.eventBus.SessionRefreshedTopic().Publish(ctx, events.SessionRefreshedEvent{
+		UserID:            session.UserID,
+		SessionID:         session.ID,
+		PreviousSessionID: previousID,
+		Token:             session.Token,
+		ExpiresAt:         session.ExpiresAt,
+	}); err != nil {
+		log.Warn("failed to publish session refresh", "sessionID", session.ID, "error", err)
+	}
+	return nil
+}
+
+// lookupError passes ErrNotFound through and marks anything else as the
+// store being unavailable.
+func lookupError(err error) error {
+	if errors.Is(err, repositories.ErrNotFound) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errAuthStoreUnavailable, err)
+}
+
+// lookupSession finds the session stored under key. While auth is degraded
+// a session looked up lately is served from memory, and while it is
+// unavailable the rest aren't looked up at all. Lookups made are counted
+// against the budget.
+func (m *Middleware) lookupSession(c *fiber.Ctx, key string) (*Session, error) {
+	if memo, ok := m.authBudget.Recall(key); ok {
+		c.Locals("authMemo", memo)
+		session := memo.Session
+		return &session, nil
+	}
+	if m.authBudget.State() == AUTH_STATE_UNAVAILABLE {
+		return nil, errAuthShed
+	}
+
+	session, err := m.sessionRepo.GetByID(context.Background(), key)
+	m.authBudget.Record(AUTH_STORE_CACHE, err)
+	if err != nil {
+		return nil, lookupError(err)
+	}
+	c.Locals("authKey", key)
+	return session, nil
+}
+
+// authUnavailable answers 503 when the session or user couldn't be looked up,
+// rather than letting an outage pass as a signed out request. Routes that
+// only authenticate when they can, under AuthIfPresent, go on signed out
+// instead, so public routes stay up.
+func (m *Middleware) authUnavailable(c *fiber.Ctx, err error) error {
+	log := m.log.Function("authUnavailable")
+	if optional, _ := c.Locals("authOptional").(bool); optional {
+		log.Warn("Auth lookup failed, continuing unauthenticated", "error", err, "requestID", requestID(c), "path", c.Path())
+		return m.afterAuth(c)
+	}
+
+	log.Er("Auth lookup failed", err, "requestID", requestID(c), "path", c.Path())
+	return apierror.Send(c, apierror.New(ErrorCodeAuthUnavailable, "Service temporarily unavailable"), m.Config)
+}
+
+// AuthIfPresent authenticates requests that carry a session cookie or an
+// Authorization header, like BasicAuth, and lets the rest through
+// unauthenticated without asking for a client type, for public routes whose
+// response depends on who asks. When the session can't be looked up the
+// request is let through unauthenticated too.
+func (m *Middleware) AuthIfPresent() fiber.Handler {
+	basicAuth := m.BasicAuth()
+	return func(c *fiber.Ctx) error {
+		if isAuthenticatedRequest(c) {
+			c.Locals("authOptional", true)
+			return basicAuth(c)
+		}
+		c.Locals("authenticated", false)
+		return m.afterAuth(c)
 	}
 }
 
@@ -143,9 +446,19 @@ func (m *Middleware) AuthRequired() fiber.Handler {
 		log := m.log.Function("AuthRequired")
 		log.Info("AuthRequired")
 		if !c.Locals("authenticated").(bool) {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Authentication required",
-			})
+			return apierror.Send(c, apierror.New(ErrorCodeAuthRequired, "Authentication required"), m.Config)
+		}
+		return c.Next()
+	}
+}
+
+func (m *Middleware) AdminRequired() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		log := m.log.Function("AdminRequired")
+		user, ok := c.Locals("user").(User)
+		if !ok || !user.IsAdmin {
+			log.Warn("Rejected non-admin request", "path", c.Path())
+			return apierror.Send(c, apierror.New(ErrorCodeAdminRequired, "Admin access required"), m.Config)
 		}
 		return c.Next()
 	}
@@ -0,0 +1,274 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/health"
+	"server/internal/logger"
+	. "server/internal/models"
+	"server/internal/repositories"
+	"sync"
+	"time"
+)
+
+const (
+	// AUTH_STATE_NORMAL looks every session and user up. AUTH_STATE_MEMO
+	// serves sessions seen in the last AUTH_MEMO_TTL from memory first, and
+	// AUTH_STATE_UNAVAILABLE serves only those, answering 503 to the rest.
+	AUTH_STATE_NORMAL      = "normal"
+	AUTH_STATE_MEMO        = "memo"
+	AUTH_STATE_UNAVAILABLE = "unavailable"
+
+	// Where an authenticated request's session and user came from, in the
+	// authSource local
+	AUTH_SOURCE_STORE = "store"
+	AUTH_SOURCE_MEMO  = "memo"
+
+	// The stores the budget tracks. Sessions are only kept in the cache, and
+	// users are read through it from SQL, so a failed user lookup means SQL
+	// failed too.
+	AUTH_STORE_CACHE = "cache"
+	AUTH_STORE_SQL   = "sql"
+
+	// Lookups are counted over the last AUTH_BUDGET_WINDOW. Once at least
+	// AUTH_BUDGET_MIN_LOOKUPS of a store's lookups have been made in it, a
+	// failure rate of AUTH_BUDGET_FAILURE_RATE or more degrades auth.
+	AUTH_BUDGET_WINDOW       = 30 * time.Second
+	AUTH_BUDGET_MIN_LOOKUPS  = 10
+	AUTH_BUDGET_FAILURE_RATE = 0.5
+
+	// How long a looked up session and its user are remembered
+	AUTH_MEMO_TTL = time.Minute
+)
+
+// errAuthShed is returned instead of looking a session up while auth is
+// unavailable.
+var errAuthShed = fmt.Errorf("%w: lookups shed while degraded", errAuthStoreUnavailable)
+
+type lookupBucket struct {
+	second   int64
+	lookups  int
+	failures int
+}
+
+// lookupWindow counts one store's lookups and failures over the last
+// AUTH_BUDGET_WINDOW in one second buckets.
+type lookupWindow struct {
+	buckets [int(AUTH_BUDGET_WINDOW / time.Second)]lookupBucket
+}
+
+func (w *lookupWindow) record(now time.Time, failed bool) {
+	second := now.Unix()
+	bucket := &w.buckets[second%int64(len(w.buckets))]
+	if bucket.second != second {
+		*bucket = lookupBucket{second: second}
+	}
+	bucket.lookups++
+	if failed {
+		bucket.failures++
+	}
+}
+
+// counts sums the buckets still inside the window.
+func (w *lookupWindow) counts(now time.Time) (lookups int, failures int) {
+	second := now.Unix()
+	for _, bucket := range w.buckets {
+		if second-bucket.second < int64(len(w.buckets)) {
+			lookups += bucket.lookups
+			failures += bucket.failures
+		}
+	}
+	return lookups, failures
+}
+
+func (w *lookupWindow) exhausted(now time.Time) bool {
+	lookups, failures := w.counts(now)
+	return lookups >= AUTH_BUDGET_MIN_LOOKUPS && float64(failures) >= AUTH_BUDGET_FAILURE_RATE*float64(lookups)
+}
+
+// authMemo is a session and its user as last looked up.
+type authMemo struct {
+	Session Session
+	User    User
+}
+
+// AuthBudget degrades authentication step by step when its stores fail,
+// rather than letting every request pile onto a store that is already
+// struggling. While the cache fails at less than AUTH_BUDGET_FAILURE_RATE,
+// lookups go on as normal. Beyond it, sessions successfully looked up in the
+// last AUTH_MEMO_TTL are served from memory, and only the rest are looked
+// up. When SQL fails beyond it as well, only remembered sessions are served
+// and the rest answered 503 without a lookup, until the failures have aged
+// out of the window. Everything is kept in this process, so each instance
+// degrades on its own.
+type AuthBudget struct {
+	log   logger.Logger
+	clock clock.Clock
+	memo  *database.LocalCache[authMemo]
+
+	mutex   sync.Mutex
+	cache   lookupWindow
+	sql     lookupWindow
+	state   string
+	changed time.Time
+}
+
+// NewAuthBudget builds an AuthBudget in the normal state. A nil clock uses
+// the wall clock.
+func NewAuthBudget(clk clock.Clock) *AuthBudget {
+	clk = clock.OrDefault(clk)
+	return &AuthBudget{
+		log:     logger.New("middleware").File("auth_budget"),
+		clock:   clk,
+		memo:    database.NewLocalCache[authMemo](AUTH_MEMO_TTL, clk),
+		state:   AUTH_STATE_NORMAL,
+		changed: clk.Now(),
+	}
+}
+
+// State is the current state, one of the AUTH_STATE_ constants. A nil
+// budget is always normal, as are its methods no-ops.
+func (b *AuthBudget) State() string {
+	if b == nil {
+		return AUTH_STATE_NORMAL
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.evaluate(b.clock.Now())
+}
+
+// Record counts a lookup against store. ErrNotFound is an answer, so only
+// other errors count as failures.
+func (b *AuthBudget) Record(store string, err error) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.clock.Now()
+	failed := err != nil && !errors.Is(err, repositories.ErrNotFound)
+	if store == AUTH_STORE_SQL {
+		b.sql.record(now, failed)
+	} else {
+		b.cache.record(now, failed)
+	}
+	b.evaluate(now)
+}
+
+// evaluate moves to the state the windows call for. Callers hold the mutex.
+func (b *AuthBudget) evaluate(now time.Time) string {
+	state := AUTH_STATE_NORMAL
+	switch {
+	case b.sql.exhausted(now):
+		state = AUTH_STATE_UNAVAILABLE
+	case b.cache.exhausted(now):
+		state = AUTH_STATE_MEMO
+	}
+	if state == b.state {
+		return state
+	}
+
+	log := b.log.Function("evaluate")
+	if state == AUTH_STATE_NORMAL {
+		log.Info("Auth recovered", "from", b.state, "degradedFor", now.Sub(b.changed))
+	} else {
+		log.Warn("Auth degraded", "from", b.state, "to", state)
+	}
+	b.state = state
+	b.changed = now
+	return state
+}
+
+// Remember keeps a successfully looked up session and its user under key.
+func (b *AuthBudget) Remember(key string, session Session, user User) {
+	if b == nil {
+		return
+	}
+	b.memo.Set(key, authMemo{Session: session, User: user})
+}
+
+// RememberAuth remembers session and its user as a lookup would, for warming
+// what auth serves from while degraded.
+func (m *Middleware) RememberAuth(session Session, user User) {
+	m.authBudget.Remember(session.ID, session, user)
+}
+
+// Forget drops what was remembered for a session that has ended, so it
+// isn't served from memory while auth is degraded.
+func (b *AuthBudget) Forget(sessionID string) {
+	if b == nil {
+		return
+	}
+	b.memo.Delete(sessionID)
+}
+
+// ForgetUser drops every session remembered for the user, for when all of
+// them end at once.
+func (b *AuthBudget) ForgetUser(userID string) {
+	if b == nil {
+		return
+	}
+	b.memo.DeleteFunc(func(memo authMemo) bool { return memo.Session.UserID == userID })
+}
+
+// ForgetAuth forgets a session that ended, see AuthBudget.Forget. Anything
+// that ends a session before it expires calls it.
+func (m *Middleware) ForgetAuth(sessionID string) {
+	m.authBudget.Forget(sessionID)
+}
+
+// ForgetUserAuth forgets every session of a user whose sessions all ended.
+func (m *Middleware) ForgetUserAuth(userID string) {
+	m.authBudget.ForgetUser(userID)
+}
+
+// Recall returns what was remembered under key, only while auth is degraded
+// so sessions revoked meanwhile stop working as soon as lookups are normal.
+func (b *AuthBudget) Recall(key string) (authMemo, bool) {
+	if b.State() == AUTH_STATE_NORMAL {
+		return authMemo{}, false
+	}
+	return b.memo.Get(key)
+}
+
+// Health reports the state: degraded while serving from memory and down
+// while shedding lookups.
+func (b *AuthBudget) Health() health.Checker {
+	return authBudgetChecker{budget: b}
+}
+
+type authBudgetChecker struct {
+	budget *AuthBudget
+}
+
+func (c authBudgetChecker) Name() string { return "auth" }
+
+func (c authBudgetChecker) Check(ctx context.Context) health.CheckResult {
+	b := c.budget
+	b.mutex.Lock()
+	now := b.clock.Now()
+	state := b.evaluate(now)
+	cacheLookups, cacheFailures := b.cache.counts(now)
+	sqlLookups, sqlFailures := b.sql.counts(now)
+	details := map[string]any{
+		"state": state,
+		"since": NewAPITime(b.changed),
+		"cache": map[string]int{"lookups": cacheLookups, "failures": cacheFailures},
+		"sql":   map[string]int{"lookups": sqlLookups, "failures": sqlFailures},
+	}
+	b.mutex.Unlock()
+
+	switch state {
+	case AUTH_STATE_MEMO:
+		return health.Degraded(errors.New("cache failing, serving remembered sessions"), details)
+	case AUTH_STATE_UNAVAILABLE:
+		return health.Down(errors.New("cache and SQL failing, only remembered sessions are served"), details)
+	}
+	return health.OK(details)
+}
@@ -0,0 +1,263 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/health"
+	"server/internal/models"
+	"server/internal/repositories"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakySessions is the session cache, failing with err when it is set.
+type flakySessions struct {
+	repositories.SessionRepository
+	clock   clock.Clock
+	err     error
+	lookups int
+}
+
+func (f *flakySessions) GetByID(ctx context.Context, id string) (*models.Session, error) {
+	f.lookups++
+	if f.err != nil {
+		return nil, f.err
+	}
+	now := f.clock.Now()
+	return &models.Session{ID: id, UserID: "user-" + id, ExpiresAt: now.Add(time.Hour), RefreshAt: now.Add(time.Hour)}, nil
+}
+
+// flakyUsers is the SQL backed user lookup, failing with err when it is set.
+type flakyUsers struct {
+	repositories.UserRepository
+	err     error
+	lookups int
+}
+
+func (f *flakyUsers) GetByID(ctx context.Context, id string) (*models.User, error) {
+	f.lookups++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &models.User{BaseModel: models.BaseModel{ID: id}}, nil
+}
+
+type budgetTest struct {
+	app      *fiber.App
+	m        Middleware
+	budget   *AuthBudget
+	sessions *flakySessions
+	users    *flakyUsers
+	clock    *clock.Fake
+}
+
+func setupBudgetTest(t *testing.T) *budgetTest {
+	t.Helper()
+	fake := clock.NewFake(lookupTestTime)
+	sessions := &flakySessions{clock: fake}
+	users := &flakyUsers{}
+	m := New(database.DB{}, &events.EventBus{}, config.Config{}, users, sessions, fake)
+
+	app := fiber.New()
+	respond := func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"authenticated": c.Locals("authenticated"), "source": c.Locals("authSource")})
+	}
+	app.Get("/public", m.AuthIfPresent(), respond)
+	app.Get("/private", m.BasicAuth(), m.AuthRequired(), respond)
+	return &budgetTest{app: app, m: m, budget: m.authBudget, sessions: sessions, users: users, clock: fake}
+}
+
+type budgetResult struct {
+	status int
+	source any
+}
+
+func (b *budgetTest) get(t *testing.T, path, sessionID string) budgetResult {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("X-Client-Type", WEB_CLIENT_TYPE)
+	req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"="+sessionID)
+	resp, err := b.app.Test(req)
+	require.NoError(t, err)
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return budgetResult{status: resp.StatusCode, source: body["source"]}
+}
+
+// failUntil sends requests for new sessions until the budget reaches state.
+func (b *budgetTest) failUntil(t *testing.T, state string) {
+	t.Helper()
+	for i := range AUTH_BUDGET_MIN_LOOKUPS {
+		assert.Equal(t, fiber.StatusServiceUnavailable, b.get(t, "/private", "failing-"+string(rune('a'+i))).status)
+	}
+	require.Equal(t, state, b.budget.State())
+}
+
+func TestAuthBudget_DegradesWithStores(t *testing.T) {
+	b := setupBudgetTest(t)
+
+	// Healthy, every request is looked up and the result remembered
+	result := b.get(t, "/private", "known")
+	assert.Equal(t, fiber.StatusOK, result.status)
+	assert.Equal(t, AUTH_SOURCE_STORE, result.source)
+	assert.Equal(t, 1, b.sessions.lookups)
+	assert.Equal(t, 1, b.users.lookups)
+
+	// The cache starts failing, which alone only degrades to the memo
+	b.sessions.err = errDatabaseLocked
+	b.failUntil(t, AUTH_STATE_MEMO)
+	sessionLookups, userLookups := b.sessions.lookups, b.users.lookups
+
+	result = b.get(t, "/private", "known")
+	assert.Equal(t, fiber.StatusOK, result.status)
+	assert.Equal(t, AUTH_SOURCE_MEMO, result.source)
+	assert.Equal(t, sessionLookups, b.sessions.lookups, "a remembered session isn't looked up")
+	assert.Equal(t, userLookups, b.users.lookups)
+
+	// A session that isn't remembered is still looked up
+	b.sessions.err = nil
+	result = b.get(t, "/private", "unknown")
+	assert.Equal(t, fiber.StatusOK, result.status)
+	assert.Equal(t, AUTH_SOURCE_STORE, result.source)
+	assert.Equal(t, sessionLookups+1, b.sessions.lookups)
+	assert.Equal(t, userLookups+1, b.users.lookups)
+
+	// SQL failing as well makes auth unavailable
+	b.users.err = errDatabaseLocked
+	b.failUntil(t, AUTH_STATE_UNAVAILABLE)
+	sessionLookups, userLookups = b.sessions.lookups, b.users.lookups
+
+	result = b.get(t, "/private", "known")
+	assert.Equal(t, fiber.StatusOK, result.status, "remembered sessions are still served")
+	assert.Equal(t, AUTH_SOURCE_MEMO, result.source)
+
+	assert.Equal(t, fiber.StatusServiceUnavailable, b.get(t, "/private", "other").status)
+	result = b.get(t, "/public", "other")
+	assert.Equal(t, fiber.StatusOK, result.status, "public routes stay up, signed out")
+	assert.Nil(t, result.source)
+	assert.Equal(t, sessionLookups, b.sessions.lookups, "nothing is looked up while unavailable")
+	assert.Equal(t, userLookups, b.users.lookups)
+
+	// Once the failures have aged out lookups resume, and the memo is no
+	// longer consulted
+	b.users.err = nil
+	b.clock.Advance(AUTH_BUDGET_WINDOW)
+	assert.Equal(t, AUTH_STATE_NORMAL, b.budget.State())
+	result = b.get(t, "/private", "known")
+	assert.Equal(t, AUTH_SOURCE_STORE, result.source)
+	assert.Equal(t, sessionLookups+1, b.sessions.lookups)
+}
+
+func TestAuthBudget_RememberAuthWarmsMemo(t *testing.T) {
+	b := setupBudgetTest(t)
+	now := b.clock.Now()
+	b.m.RememberAuth(
+		models.Session{ID: "warmed", UserID: "user-warmed", ExpiresAt: now.Add(time.Hour), RefreshAt: now.Add(time.Hour)},
+		models.User{BaseModel: models.BaseModel{ID: "user-warmed"}},
+	)
+
+	b.sessions.err = errDatabaseLocked
+	b.failUntil(t, AUTH_STATE_MEMO)
+	sessionLookups, userLookups := b.sessions.lookups, b.users.lookups
+
+	result := b.get(t, "/private", "warmed")
+	assert.Equal(t, fiber.StatusOK, result.status, "a warmed session is served without ever being looked up")
+	assert.Equal(t, AUTH_SOURCE_MEMO, result.source)
+	assert.Equal(t, sessionLookups, b.sessions.lookups)
+	assert.Equal(t, userLookups, b.users.lookups)
+}
+
+func TestAuthBudget_ForgetsEndedSessions(t *testing.T) {
+	b := setupBudgetTest(t)
+	now := b.clock.Now()
+	for _, session := range []models.Session{
+		{ID: "phone", UserID: "user-1"},
+		{ID: "laptop", UserID: "user-1"},
+		{ID: "tablet", UserID: "user-1"},
+		{ID: "elsewhere", UserID: "user-2"},
+	} {
+		session.ExpiresAt, session.RefreshAt = now.Add(time.Hour), now.Add(time.Hour)
+		b.m.RememberAuth(session, models.User{BaseModel: models.BaseModel{ID: session.UserID}})
+	}
+
+	b.sessions.err = errDatabaseLocked
+	b.failUntil(t, AUTH_STATE_MEMO)
+
+	b.m.ForgetAuth("phone")
+	assert.Equal(t, fiber.StatusServiceUnavailable, b.get(t, "/private", "phone").status,
+		"a forgotten session is looked up, in a cache that still fails")
+	assert.Equal(t, fiber.StatusOK, b.get(t, "/private", "laptop").status)
+
+	b.m.ForgetUserAuth("user-1")
+	assert.Equal(t, fiber.StatusServiceUnavailable, b.get(t, "/private", "laptop").status)
+	assert.Equal(t, fiber.StatusServiceUnavailable, b.get(t, "/private", "tablet").status)
+	assert.Equal(t, fiber.StatusOK, b.get(t, "/private", "elsewhere").status, "other users are kept")
+}
+
+func TestAuthBudget_MemoExpires(t *testing.T) {
+	b := setupBudgetTest(t)
+	require.Equal(t, fiber.StatusOK, b.get(t, "/private", "known").status)
+
+	b.sessions.err = errDatabaseLocked
+	b.failUntil(t, AUTH_STATE_MEMO)
+	b.clock.Advance(AUTH_MEMO_TTL)
+
+	// Past the memo's TTL the cache is asked again, and still fails
+	assert.Equal(t, fiber.StatusServiceUnavailable, b.get(t, "/private", "known").status)
+}
+
+func TestAuthBudget_NotFoundIsNotAFailure(t *testing.T) {
+	budget := NewAuthBudget(clock.NewFake(lookupTestTime))
+	for range AUTH_BUDGET_MIN_LOOKUPS * 2 {
+		budget.Record(AUTH_STORE_CACHE, repositories.ErrNotFound)
+	}
+	assert.Equal(t, AUTH_STATE_NORMAL, budget.State())
+}
+
+func TestAuthBudget_Transitions(t *testing.T) {
+	fake := clock.NewFake(lookupTestTime)
+	budget := NewAuthBudget(fake)
+	checker := budget.Health()
+
+	// Too few lookups to judge the rate by
+	for range AUTH_BUDGET_MIN_LOOKUPS - 1 {
+		budget.Record(AUTH_STORE_CACHE, errDatabaseLocked)
+	}
+	assert.Equal(t, AUTH_STATE_NORMAL, budget.State())
+	assert.Equal(t, health.STATUS_OK, checker.Check(context.Background()).Status)
+
+	budget.Record(AUTH_STORE_CACHE, errDatabaseLocked)
+	assert.Equal(t, AUTH_STATE_MEMO, budget.State())
+	result := checker.Check(context.Background())
+	assert.Equal(t, health.STATUS_DEGRADED, result.Status)
+	assert.Equal(t, AUTH_STATE_MEMO, result.Details["state"])
+	assert.Equal(t, map[string]int{"lookups": 10, "failures": 10}, result.Details["cache"])
+
+	// Successes bring the rate back under the threshold
+	for range AUTH_BUDGET_MIN_LOOKUPS + 1 {
+		budget.Record(AUTH_STORE_CACHE, nil)
+	}
+	assert.Equal(t, AUTH_STATE_NORMAL, budget.State())
+
+	for range AUTH_BUDGET_MIN_LOOKUPS {
+		budget.Record(AUTH_STORE_SQL, errDatabaseLocked)
+	}
+	assert.Equal(t, AUTH_STATE_UNAVAILABLE, budget.State())
+	assert.Equal(t, health.STATUS_DOWN, checker.Check(context.Background()).Status)
+
+	// Failures older than the window no longer count
+	fake.Advance(AUTH_BUDGET_WINDOW - time.Second)
+	assert.Equal(t, AUTH_STATE_UNAVAILABLE, budget.State())
+	fake.Advance(time.Second)
+	assert.Equal(t, AUTH_STATE_NORMAL, budget.State())
+}
@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	"server/internal/models"
+	"server/internal/repositories"
+	"server/internal/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errDatabaseLocked = errors.New("database is locked")
+	lookupTestTime    = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+)
+
+type lookupResult struct {
+	status        int
+	body          map[string]any
+	requestID     string
+	cookieExpired bool
+	logs          []map[string]any
+}
+
+// errorLogs returns the ERROR entries logged while handling the request.
+func (r lookupResult) errorLogs() []map[string]any {
+	var entries []map[string]any
+	for _, entry := range r.logs {
+		if entry["level"] == slog.LevelError.String() {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func setupLookupTest(
+	t *testing.T,
+	sessionErr error,
+	userErr error,
+) (*fiber.App, *MockSessionRepository, *bytes.Buffer, config.Config) {
+	t.Helper()
+
+	fake := clock.NewFake(lookupTestTime)
+	session := &models.Session{
+		ID:        "session-1",
+		UserID:    "user-1",
+		ExpiresAt: fake.Now().Add(time.Hour),
+		RefreshAt: fake.Now().Add(time.Hour),
+	}
+
+	mockSessionRepo := &MockSessionRepository{}
+	if sessionErr != nil {
+		session = nil
+	}
+	mockSessionRepo.On("GetByID", mock.Anything, mock.Anything).Return(session, sessionErr)
+	mockSessionRepo.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	mockUserRepo := &MockUserRepository{}
+	var user *models.User
+	if userErr == nil {
+		user = &models.User{BaseModel: models.BaseModel{ID: "user-1"}}
+	}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").Return(user, userErr)
+
+	cfg := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret-key-for-testing"}}
+	middleware := New(database.DB{}, &events.EventBus{}, cfg, mockUserRepo, mockSessionRepo, fake)
+	var logs bytes.Buffer
+	middleware.log = logger.NewWithHandler("middleware", slog.NewJSONHandler(&logs, nil))
+
+	app := fiber.New()
+	app.Use(requestid.New())
+	app.Use(middleware.BasicAuth())
+	app.Get("/*", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"authenticated": c.Locals("authenticated")})
+	})
+	return app, mockSessionRepo, &logs, cfg
+}
+
+// sendLookup sends a request with the session cookie for web clients or a
+// valid session token for mobile ones.
+func sendLookup(t *testing.T, app *fiber.App, logs *bytes.Buffer, cfg config.Config, clientType string) lookupResult {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "/api/v1/users/", nil)
+	req.Header.Set("X-Client-Type", clientType)
+	switch clientType {
+	case WEB_CLIENT_TYPE:
+		req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"=session-1")
+	case MOBILE_CLIENT_TYPE:
+		issuedAt := clock.NewFake(lookupTestTime)
+		token, err := utils.GenerateJWTToken(uuid.New().String(), lookupTestTime.Add(time.Hour), cfg, issuedAt)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	result := lookupResult{status: resp.StatusCode, requestID: resp.Header.Get(fiber.HeaderXRequestID)}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result.body))
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == models.SESSION_COOKIE_KEY && cookie.Value == "" {
+			result.cookieExpired = true
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		result.logs = append(result.logs, entry)
+	}
+	return result
+}
+
+func TestBasicAuth_SessionLookup(t *testing.T) {
+	testCases := []struct {
+		name       string
+		clientType string
+		sessionErr error
+		userErr    error
+		status     int
+		deleted    bool
+	}{
+		{"web session not found", WEB_CLIENT_TYPE, repositories.ErrNotFound, nil, fiber.StatusOK, false},
+		{"web session store down", WEB_CLIENT_TYPE, errDatabaseLocked, nil, fiber.StatusServiceUnavailable, false},
+		{"mobile session not found", MOBILE_CLIENT_TYPE, repositories.ErrNotFound, nil, fiber.StatusOK, false},
+		{"mobile session store down", MOBILE_CLIENT_TYPE, errDatabaseLocked, nil, fiber.StatusServiceUnavailable, false},
+		{"user not found", WEB_CLIENT_TYPE, nil, repositories.ErrNotFound, fiber.StatusOK, true},
+		{"user store down", WEB_CLIENT_TYPE, nil, errDatabaseLocked, fiber.StatusServiceUnavailable, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, mockSessionRepo, logs, cfg := setupLookupTest(t, tc.sessionErr, tc.userErr)
+			result := sendLookup(t, app, logs, cfg, tc.clientType)
+
+			assert.Equal(t, tc.status, result.status)
+			if tc.deleted {
+				mockSessionRepo.AssertCalled(t, "Delete", mock.Anything, "session-1")
+			} else {
+				mockSessionRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+			}
+
+			if tc.status == fiber.StatusOK {
+				// Signed out as if there had been no session
+				assert.Equal(t, false, result.body["authenticated"])
+				assert.Empty(t, result.errorLogs())
+				assert.True(t, result.cookieExpired)
+				return
+			}
+
+			// An outage keeps the session and says so
+			assert.Equal(t, ErrorCodeAuthUnavailable, result.body["code"])
+			assert.False(t, result.cookieExpired)
+			errorLogs := result.errorLogs()
+			require.Len(t, errorLogs, 1)
+			assert.NotEmpty(t, result.requestID)
+			assert.Equal(t, result.requestID, errorLogs[0]["requestID"])
+			assert.Contains(t, errorLogs[0]["error"], errDatabaseLocked.Error())
+		})
+	}
+}
+
+func TestBasicAuth_RejectsForeignTokens(t *testing.T) {
+	testCases := []struct {
+		name     string
+		security config.SecurityConfig
+	}{
+		{"wrong issuer", config.SecurityConfig{JwtIssuer: "other-service"}},
+		{"wrong audience", config.SecurityConfig{JwtAudience: "other-service"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, mockSessionRepo, _, cfg := setupLookupTest(t, nil, nil)
+
+			// Signed with the shared secret by a service with its own claims
+			foreign := cfg
+			foreign.Security.JwtIssuer = tc.security.JwtIssuer
+			foreign.Security.JwtAudience = tc.security.JwtAudience
+			token, err := utils.GenerateJWTToken(uuid.New().String(), lookupTestTime.Add(time.Hour), foreign, clock.NewFake(lookupTestTime))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("GET", "/api/v1/users/", nil)
+			req.Header.Set("X-Client-Type", MOBILE_CLIENT_TYPE)
+			req.Header.Set("Authorization", token)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, ErrorCodeInvalidToken, body["code"])
+			mockSessionRepo.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestBasicAuth_RejectsMisplacedCredentials(t *testing.T) {
+	cfg := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret-key-for-testing"}}
+	token, err := utils.GenerateJWTToken(uuid.New().String(), lookupTestTime.Add(time.Hour), cfg, clock.NewFake(lookupTestTime))
+	require.NoError(t, err)
+	sessionID := uuid.New().String()
+
+	testCases := []struct {
+		name       string
+		clientType string
+		cookie     string
+		header     string
+		code       string
+	}{
+		{"token in cookie", WEB_CLIENT_TYPE, token, "", ErrorCodeTokenInCookie},
+		{"session ID in header", MOBILE_CLIENT_TYPE, "", sessionID, ErrorCodeSessionIDInHeader},
+		{"session ID as a bearer token", MOBILE_CLIENT_TYPE, "", "Bearer " + sessionID, ErrorCodeSessionIDInHeader},
+		{"session ID in cookie", WEB_CLIENT_TYPE, sessionID, "", ""},
+		{"token in header", MOBILE_CLIENT_TYPE, "", token, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, mockSessionRepo, _, _ := setupLookupTest(t, nil, nil)
+
+			req := httptest.NewRequest("GET", "/api/v1/users/", nil)
+			req.Header.Set("X-Client-Type", tc.clientType)
+			if tc.cookie != "" {
+				req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"="+tc.cookie)
+			}
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+			if tc.code == "" {
+				assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+				assert.Equal(t, true, body["authenticated"])
+				mockSessionRepo.AssertCalled(t, "GetByID", mock.Anything, mock.Anything)
+				return
+			}
+
+			assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+			assert.Equal(t, tc.code, body["code"])
+			assert.Contains(t, body["error"], tc.clientType)
+			assert.Empty(t, resp.Cookies(), "the cookie is left for the client to fix")
+			mockSessionRepo.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+			mockSessionRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestCredentialShapes(t *testing.T) {
+	cfg := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret-key-for-testing"}}
+	token, err := utils.GenerateJWTToken(uuid.New().String(), lookupTestTime.Add(time.Hour), cfg, clock.NewFake(lookupTestTime))
+	require.NoError(t, err)
+	sessionID, err := uuid.NewV7()
+	require.NoError(t, err)
+
+	assert.True(t, looksLikeJWT(token))
+	for _, value := range []string{sessionID.String(), "session-1", "a.b", "a..c", "a.b.c.d", "a.b+c.d", ""} {
+		assert.False(t, looksLikeJWT(value), value)
+	}
+
+	assert.True(t, looksLikeSessionID(sessionID.String()))
+	assert.True(t, looksLikeSessionID("Bearer "+sessionID.String()))
+	for _, value := range []string{token, "Bearer " + token, "urn:uuid:" + sessionID.String(), strings.ReplaceAll(sessionID.String(), "-", ""), ""} {
+		assert.False(t, looksLikeSessionID(value), value)
+	}
+}
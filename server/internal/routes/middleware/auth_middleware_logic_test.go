@@ -10,6 +10,7 @@ import (
 	"server/internal/events"
 	"server/internal/models"
 	"server/internal/utils"
+	"server/internal/utils/testsupport"
 	"strings"
 	"testing"
 	"time"
@@ -31,6 +32,12 @@ func (m *MockUserRepository) GetByID(ctx context.Context, id string) (*models.Us
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
+func (m *MockUserRepository) GetByIDs(ctx context.Context, ids []string) ([]models.User, error) {
+	args := m.Called(ctx, ids)
+	users, _ := args.Get(0).([]models.User)
+	return users, args.Error(1)
+}
+
 func (m *MockUserRepository) GetByLogin(ctx context.Context, login string) (*models.User, error) {
 	args := m.Called(ctx, login)
 	return args.Get(0).(*models.User), args.Error(1)
@@ -46,11 +53,26 @@ func (m *MockUserRepository) Update(ctx context.Context, user *models.User) erro
 	return args.Error(0)
 }
 
+func (m *MockUserRepository) UpdateProfile(ctx context.Context, user *models.User, expectedVersion int) error {
+	args := m.Called(ctx, user, expectedVersion)
+	return args.Error(0)
+}
+
 func (m *MockUserRepository) Delete(ctx context.Context, id string) error {
 	args := m.Called(ctx, id)
 	return args.Error(0)
 }
 
+func (m *MockUserRepository) List(ctx context.Context, page int, sort models.UserSort, columns []string) ([]models.User, bool, error) {
+	args := m.Called(ctx, page, sort, columns)
+	return args.Get(0).([]models.User), args.Bool(1), args.Error(2)
+}
+
+func (m *MockUserRepository) Stream(ctx context.Context, columns []string, each func(users []models.User) error) (int64, error) {
+	args := m.Called(ctx, columns, each)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 type MockSessionRepository struct {
 	mock.Mock
 }
@@ -60,6 +82,11 @@ func (m *MockSessionRepository) Create(ctx context.Context, session *models.Sess
 	return args.Error(0)
 }
 
+func (m *MockSessionRepository) Extend(ctx context.Context, session *models.Session, expiresAt time.Time, config config.Config) error {
+	args := m.Called(ctx, session, expiresAt, config)
+	return args.Error(0)
+}
+
 func (m *MockSessionRepository) GetByID(ctx context.Context, id string) (*models.Session, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(*models.Session), args.Error(1)
@@ -70,6 +97,26 @@ func (m *MockSessionRepository) Delete(ctx context.Context, id string) error {
 	return args.Error(0)
 }
 
+func (m *MockSessionRepository) ListByUser(ctx context.Context, userID string) ([]*models.Session, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]*models.Session), args.Error(1)
+}
+
+func (m *MockSessionRepository) DeleteByUser(ctx context.Context, userID string) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepository) CountActive(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockSessionRepository) ReapExpired(ctx context.Context, expiredBefore time.Time, limit int) (int, error) {
+	args := m.Called(ctx, expiredBefore, limit)
+	return args.Int(0), args.Error(1)
+}
+
 // Pure logic tests to improve coverage without cache operations
 
 func TestMiddleware_CookieAndTokenLogic(t *testing.T) {
@@ -123,7 +170,7 @@ func TestMiddleware_CookieAndTokenLogic(t *testing.T) {
 }
 
 func TestMiddleware_SessionTimingLogic(t *testing.T) {
-	now := time.Now()
+	now := testsupport.EPOCH
 
 	// Test expiration logic patterns
 	expiredTime := now.Add(-time.Hour)
@@ -241,14 +288,14 @@ func TestMiddleware_UtilityFunctionCalls(t *testing.T) {
 
 	app.Get("/utils-test", func(c *fiber.Ctx) error {
 		// Test utility function calls (used in middleware)
-		utils.ExpireCookie(c, "test-cookie")
+		utils.ExpireCookie(c, "test-cookie", config.Config{})
 
 		cookie := utils.Cookie{
 			Name:    "test-cookie",
 			Value:   "test-value",
-			Expires: time.Now().Add(time.Hour),
+			Expires: testsupport.EPOCH.Add(time.Hour),
 		}
-		utils.ApplyCookie(c, cookie)
+		utils.ApplyCookie(c, cookie, config.Config{})
 		utils.ApplyToken(c, "test-token")
 
 		return c.JSON(fiber.Map{"status": "ok"})
@@ -261,41 +308,37 @@ func TestMiddleware_UtilityFunctionCalls(t *testing.T) {
 }
 
 func TestMiddleware_JWTTokenLogic(t *testing.T) {
-	testConfig := config.Config{
-		SecurityJwtSecret: "test-jwt-secret-for-logic-test",
-	}
+	testConfig := testsupport.WithKey(config.Config{})
+	frozen := testsupport.FreezeTime(t)
 
 	// Test token generation and parsing logic
-	userID := uuid.New().String()
-	expiresAt := time.Now().Add(time.Hour)
+	userID := uuid.New()
 
 	// Test valid token generation
-	validToken, err := utils.GenerateJWTToken(userID, expiresAt, "test-issuer", testConfig)
-	require.NoError(t, err)
+	validToken := testsupport.MintTestToken(t, userID, testsupport.TokenOptions{Clock: frozen})
 	assert.NotEmpty(t, validToken)
 
 	// Test token parsing
-	claims, err := utils.ParseJWTToken(validToken, testConfig)
+	claims, err := utils.ParseJWTToken(validToken, testConfig, frozen)
 	require.NoError(t, err)
-	assert.Equal(t, userID, claims.UserID.String())
+	assert.Equal(t, userID, claims.UserID)
 
 	// Test invalid token parsing
-	_, err = utils.ParseJWTToken("invalid-token", testConfig)
+	_, err = utils.ParseJWTToken("invalid-token", testConfig, frozen)
 	assert.Error(t, err)
 
 	// Test empty token parsing
-	_, err = utils.ParseJWTToken("", testConfig)
+	_, err = utils.ParseJWTToken("", testConfig, frozen)
 	assert.Error(t, err)
 }
 
 func TestMiddleware_ErrorHandlingPatterns(t *testing.T) {
 	// Test error handling patterns used in middleware
-	testConfig := config.Config{
-		SecurityJwtSecret: "test-jwt-secret",
-	}
+	testConfig := testsupport.WithKey(config.Config{})
+	frozen := testsupport.FreezeTime(t)
 
 	// Test error cases for token generation
-	_, err := utils.GenerateJWTToken("", time.Now().Add(-time.Hour), "test-issuer", testConfig)
+	_, err := utils.GenerateJWTToken("", frozen.Now().Add(-time.Hour), testConfig, frozen)
 	assert.Error(t, err)
 
 	// Test token structure validation
@@ -311,7 +354,7 @@ func TestMiddleware_ErrorHandlingPatterns(t *testing.T) {
 
 func TestMiddleware_TimeComparisons(t *testing.T) {
 	// Test time comparison patterns used throughout middleware
-	now := time.Now()
+	now := testsupport.EPOCH
 	past := now.Add(-time.Hour)
 	future := now.Add(time.Hour)
 
@@ -381,16 +424,14 @@ func TestMiddleware_ConstantValues(t *testing.T) {
 
 func TestMiddleware_StructInitialization(t *testing.T) {
 	// Test middleware struct initialization
-	testConfig := config.Config{
-		SecurityJwtSecret: "test-secret",
-	}
+	testConfig := testsupport.WithKey(config.Config{})
 
 	db := database.DB{}
 	// Create nil repos for this test since we're just testing constructor
 	var mockUserRepo *MockUserRepository = nil
 	var mockSessionRepo *MockSessionRepository = nil
 	eventBus := &events.EventBus{}
-	middleware := New(db, eventBus, testConfig, mockUserRepo, mockSessionRepo)
+	middleware := New(db, eventBus, testConfig, mockUserRepo, mockSessionRepo, testsupport.FreezeTime(t))
 
 	assert.Equal(t, testConfig, middleware.Config)
 	assert.Equal(t, db, middleware.DB)
@@ -10,6 +10,7 @@ import (
 	"server/internal/events"
 	"server/internal/models"
 	"server/internal/utils"
+	"server/internal/utils/testsupport"
 	"testing"
 	"time"
 
@@ -21,12 +22,13 @@ import (
 )
 
 
-func setupAuthMiddlewareTest() (Middleware, config.Config, *MockUserRepository, *MockSessionRepository) {
-	testConfig := config.Config{
-		SecuritySalt:      12,
-		SecurityPepper:    "test-pepper",
-		SecurityJwtSecret: "test-jwt-secret-key-for-testing",
-	}
+func setupAuthMiddlewareTest(t *testing.T) (Middleware, config.Config, *MockUserRepository, *MockSessionRepository) {
+	testConfig := testsupport.WithKey(config.Config{
+		Security: config.SecurityConfig{
+			Salt: 12,
+			Pepper: "test-pepper",
+		},
+	})
 	config.ConfigInstance = testConfig
 
 	// Mock database
@@ -37,13 +39,13 @@ func setupAuthMiddlewareTest() (Middleware, config.Config, *MockUserRepository,
 	mockSessionRepo := &MockSessionRepository{}
 
 	eventBus := &events.EventBus{}
-	middleware := New(mockDB, eventBus, testConfig, mockUserRepo, mockSessionRepo)
+	middleware := New(mockDB, eventBus, testConfig, mockUserRepo, mockSessionRepo, testsupport.FreezeTime(t))
 
 	return middleware, testConfig, mockUserRepo, mockSessionRepo
 }
 
 func TestMiddleware_BasicAuth_NoClientType(t *testing.T) {
-	middleware, _, _, _ := setupAuthMiddlewareTest()
+	middleware, _, _, _ := setupAuthMiddlewareTest(t)
 	app := fiber.New()
 
 	app.Get("/test", middleware.BasicAuth(), func(c *fiber.Ctx) error {
@@ -58,7 +60,7 @@ func TestMiddleware_BasicAuth_NoClientType(t *testing.T) {
 }
 
 func TestMiddleware_BasicAuth_WebClient_NoCookie(t *testing.T) {
-	middleware, _, _, mockSessionRepo := setupAuthMiddlewareTest()
+	middleware, _, _, mockSessionRepo := setupAuthMiddlewareTest(t)
 
 	// Setup mock to return empty session when no cookie
 	mockSessionRepo.On("GetByID", mock.Anything, "").Return((*models.Session)(nil), errors.New("session not found"))
@@ -88,7 +90,7 @@ func TestMiddleware_BasicAuth_WebClient_NoCookie(t *testing.T) {
 }
 
 func TestMiddleware_BasicAuth_MobileClient_NoToken(t *testing.T) {
-	middleware, _, _, mockSessionRepo := setupAuthMiddlewareTest()
+	middleware, _, _, mockSessionRepo := setupAuthMiddlewareTest(t)
 
 	// Setup mock to handle session deletion in defer when error occurs
 	mockSessionRepo.On("Delete", mock.Anything, mock.Anything).Return(nil)
@@ -108,7 +110,7 @@ func TestMiddleware_BasicAuth_MobileClient_NoToken(t *testing.T) {
 }
 
 func TestMiddleware_BasicAuth_MobileClient_InvalidToken(t *testing.T) {
-	middleware, _, _, mockSessionRepo := setupAuthMiddlewareTest()
+	middleware, _, _, mockSessionRepo := setupAuthMiddlewareTest(t)
 
 	// Setup mock to handle session deletion in defer when error occurs
 	mockSessionRepo.On("Delete", mock.Anything, mock.Anything).Return(nil)
@@ -129,7 +131,7 @@ func TestMiddleware_BasicAuth_MobileClient_InvalidToken(t *testing.T) {
 }
 
 func TestMiddleware_AuthRequired_NotAuthenticated(t *testing.T) {
-	middleware, _, _, _ := setupAuthMiddlewareTest()
+	middleware, _, _, _ := setupAuthMiddlewareTest(t)
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
@@ -157,7 +159,7 @@ func TestMiddleware_AuthRequired_NotAuthenticated(t *testing.T) {
 }
 
 func TestMiddleware_AuthRequired_Authenticated(t *testing.T) {
-	middleware, _, _, _ := setupAuthMiddlewareTest()
+	middleware, _, _, _ := setupAuthMiddlewareTest(t)
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
@@ -185,7 +187,7 @@ func TestMiddleware_AuthRequired_Authenticated(t *testing.T) {
 }
 
 func TestMiddleware_AuthNoContent_NotAuthenticated(t *testing.T) {
-	middleware, _, _, _ := setupAuthMiddlewareTest()
+	middleware, _, _, _ := setupAuthMiddlewareTest(t)
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
@@ -204,7 +206,7 @@ func TestMiddleware_AuthNoContent_NotAuthenticated(t *testing.T) {
 }
 
 func TestMiddleware_AuthNoContent_Authenticated(t *testing.T) {
-	middleware, _, _, _ := setupAuthMiddlewareTest()
+	middleware, _, _, _ := setupAuthMiddlewareTest(t)
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
@@ -223,7 +225,7 @@ func TestMiddleware_AuthNoContent_Authenticated(t *testing.T) {
 }
 
 func TestMiddleware_getWebSessionData_NoSessionCookie(t *testing.T) {
-	middleware, _, _, _ := setupAuthMiddlewareTest()
+	middleware, _, _, _ := setupAuthMiddlewareTest(t)
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
@@ -240,7 +242,7 @@ func TestMiddleware_getWebSessionData_NoSessionCookie(t *testing.T) {
 }
 
 func TestMiddleware_getMobileSessionData_NoAuthHeader(t *testing.T) {
-	middleware, _, _, _ := setupAuthMiddlewareTest()
+	middleware, _, _, _ := setupAuthMiddlewareTest(t)
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
@@ -258,7 +260,7 @@ func TestMiddleware_getMobileSessionData_NoAuthHeader(t *testing.T) {
 }
 
 func TestMiddleware_getMobileSessionData_InvalidToken(t *testing.T) {
-	middleware, _, _, _ := setupAuthMiddlewareTest()
+	middleware, _, _, _ := setupAuthMiddlewareTest(t)
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
@@ -277,21 +279,92 @@ func TestMiddleware_getMobileSessionData_InvalidToken(t *testing.T) {
 	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
 }
 
+func TestMiddleware_getWebSessionData_ExpiryBoundary(t *testing.T) {
+	_, testConfig, mockUserRepo, mockSessionRepo := setupAuthMiddlewareTest(t)
+	fake := testsupport.FreezeTime(t)
+	middleware := New(database.DB{}, &events.EventBus{}, testConfig, mockUserRepo, mockSessionRepo, fake)
+
+	session := testsupport.MintTestSession(t, uuid.New(), testsupport.SessionOptions{
+		TokenOptions: testsupport.TokenOptions{Clock: fake},
+		ID:           "boundary-session",
+	})
+	mockSessionRepo.On("GetByID", mock.Anything, "boundary-session").Return(&session, nil)
+
+	app := fiber.New()
+	app.Get("/test", func(c *fiber.Ctx) error {
+		_, err := middleware.getWebSessionData(c)
+		return c.JSON(fiber.Map{"expired": err != nil})
+	})
+
+	expired := func() bool {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"=boundary-session")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+
+		var result map[string]bool
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result["expired"]
+	}
+
+	// A session is still valid at the exact instant it expires
+	fake.Set(session.ExpiresAt)
+	assert.False(t, expired())
+
+	fake.Advance(time.Nanosecond)
+	assert.True(t, expired())
+}
+
+func TestMiddleware_getMobileSessionData_ExpiryBoundary(t *testing.T) {
+	_, testConfig, mockUserRepo, mockSessionRepo := setupAuthMiddlewareTest(t)
+	fake := testsupport.FreezeTime(t)
+	middleware := New(database.DB{}, &events.EventBus{}, testConfig, mockUserRepo, mockSessionRepo, fake)
+
+	session := testsupport.MintTestSession(t, uuid.New(), testsupport.SessionOptions{
+		TokenOptions: testsupport.TokenOptions{Clock: fake},
+	})
+	mockSessionRepo.On("GetByID", mock.Anything, session.ID).Return(&session, nil)
+
+	app := fiber.New()
+	app.Get("/test", func(c *fiber.Ctx) error {
+		_, err := middleware.getMobileSessionData(c)
+		return c.JSON(fiber.Map{"expired": err != nil})
+	})
+
+	expired := func() bool {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", session.Token)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+
+		var result map[string]bool
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result["expired"]
+	}
+
+	fake.Set(session.ExpiresAt.Add(-time.Nanosecond))
+	assert.False(t, expired())
+
+	// Unlike the session record, the token's exp claim is exclusive: it is
+	// no longer valid at the exact instant it expires
+	fake.Set(session.ExpiresAt)
+	assert.True(t, expired())
+}
+
 func TestMiddleware_JWT_TokenValidation(t *testing.T) {
-	_, testConfig, _, _ := setupAuthMiddlewareTest()
+	_, testConfig, _, _ := setupAuthMiddlewareTest(t)
 
 	// Test valid token parsing
-	userID := uuid.New().String()
-	expiresAt := time.Now().Add(time.Hour)
-	token, err := utils.GenerateJWTToken(userID, expiresAt, "test-issuer", testConfig)
-	require.NoError(t, err)
+	userID := uuid.New()
+	token := testsupport.MintTestToken(t, userID, testsupport.TokenOptions{Config: testConfig})
 	require.NotEmpty(t, token)
 
 	// Test token parsing
-	claims, err := utils.ParseJWTToken(token, testConfig)
+	claims, err := utils.ParseJWTToken(token, testConfig, testsupport.FreezeTime(t))
 	require.NoError(t, err)
 	require.NotNil(t, claims)
-	assert.Equal(t, userID, claims.UserID.String())
+	assert.Equal(t, userID, claims.UserID)
+	assert.Equal(t, testsupport.EPOCH.Add(testsupport.TOKEN_TTL), claims.ExpiresAt.UTC())
 }
 
 func TestMiddleware_Constants(t *testing.T) {
@@ -301,7 +374,7 @@ func TestMiddleware_Constants(t *testing.T) {
 
 func TestMiddleware_SessionData_Structure(t *testing.T) {
 	userID := uuid.New()
-	expiresAt := time.Now().Add(time.Hour)
+	expiresAt := testsupport.EPOCH.Add(time.Hour)
 	userAgent := "test-user-agent"
 
 	sessionData := SessionData{
@@ -317,9 +390,10 @@ func TestMiddleware_SessionData_Structure(t *testing.T) {
 
 func TestMiddleware_AuthMiddlewareNew(t *testing.T) {
 	testConfig := config.Config{
-		SecuritySalt:      12,
-		SecurityPepper:    "test-pepper",
-		SecurityJwtSecret: "test-secret",
+		Security: config.SecurityConfig{
+			Salt: 12,
+			Pepper: "test-pepper",
+		},
 	}
 
 	mockDB := database.DB{}
@@ -327,7 +401,7 @@ func TestMiddleware_AuthMiddlewareNew(t *testing.T) {
 	mockSessionRepo := &MockSessionRepository{}
 
 	eventBus := &events.EventBus{}
-	middleware := New(mockDB, eventBus, testConfig, mockUserRepo, mockSessionRepo)
+	middleware := New(mockDB, eventBus, testConfig, mockUserRepo, mockSessionRepo, testsupport.FreezeTime(t))
 
 	assert.Equal(t, mockDB, middleware.DB)
 	assert.Equal(t, testConfig, middleware.Config)
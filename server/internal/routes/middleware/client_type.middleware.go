@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"errors"
+	"maps"
+	"regexp"
+	"server/config"
+	"server/internal/apierror"
+	"server/internal/metrics"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	CLIENT_TYPE_HEADER    = "X-Client-Type"
+	CLIENT_VERSION_HEADER = "X-Client-Version"
+
+	// Request counter labels standing in for a missing header, and for the
+	// type and version of clients the registry doesn't list, whose values
+	// are up to whoever sends them
+	CLIENT_LABEL_NONE  = "none"
+	CLIENT_LABEL_OTHER = "other"
+
+	ErrorCodeUnknownClientType   = "unknown_client_type"
+	ErrorCodeMalformedClientInfo = "malformed_client_info"
+)
+
+var (
+	// A name such as solid or admin-web
+	clientTypePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+	// A dotted version such as 2.4.1, optionally with a suffix like -beta.2
+	clientVersionPattern = regexp.MustCompile(`^[0-9]{1,9}(\.[0-9]{1,9}){0,3}([-+][0-9A-Za-z.-]{1,32})?$`)
+
+	errMalformedClientType    = errors.New("malformed client type")
+	errMalformedClientVersion = errors.New("malformed client version")
+)
+
+// ClientRequests counts requests by client type and version, see ClientInfo.
+var ClientRequests = metrics.NewMultiLabelCounter(
+	"http_client_requests_total",
+	"Requests by X-Client-Type and X-Client-Version.",
+	"client_type", "client_version",
+)
+
+func init() {
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeUnknownClientType, Status: fiber.StatusBadRequest, Title: "Unknown client type",
+	})
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeMalformedClientInfo, Status: fiber.StatusBadRequest, Title: "Malformed client type or version",
+	})
+	metrics.Register(ClientRequests)
+}
+
+// ClientInfo is what a request says about the client sending it, from
+// X-Client-Type and the optional X-Client-Version.
+type ClientInfo struct {
+	Type    string
+	Version string
+	// How the client authenticates, see clientStrategy. Empty without a
+	// type, or for an unknown type Session.UnknownClientType rejects.
+	Strategy string
+	// Registered is false for types that are neither built in nor listed
+	// in Session.ClientTypes
+	Registered bool
+}
+
+// Rejected reports whether the client's type is refused by the config.
+func (i ClientInfo) Rejected() bool {
+	return i.Type != "" && i.Strategy == ""
+}
+
+// labels are the ClientRequests label values of the client.
+func (i ClientInfo) labels() (string, string) {
+	switch {
+	case i.Type == "":
+		return CLIENT_LABEL_NONE, CLIENT_LABEL_NONE
+	case !i.Registered:
+		return CLIENT_LABEL_OTHER, CLIENT_LABEL_OTHER
+	case i.Version == "":
+		return i.Type, CLIENT_LABEL_NONE
+	}
+	return i.Type, i.Version
+}
+
+// ParseClientInfo validates the client type and version headers and looks
+// the type up in the registry. Both may be empty; anything else that isn't
+// a plain name or dotted version is an error.
+func ParseClientInfo(cfg config.Config, clientType string, clientVersion string) (ClientInfo, error) {
+	if clientType != "" && !clientTypePattern.MatchString(clientType) {
+		return ClientInfo{}, errMalformedClientType
+	}
+	if clientVersion != "" && !clientVersionPattern.MatchString(clientVersion) {
+		return ClientInfo{}, errMalformedClientVersion
+	}
+
+	info := ClientInfo{Type: clientType, Version: clientVersion}
+	if clientType != "" {
+		info.Strategy, info.Registered = clientStrategy(cfg, clientType)
+	}
+	return info, nil
+}
+
+// ClientInfo parses the client headers ahead of everything reading them,
+// answering 400 to malformed ones, and counts the request in ClientRequests.
+// Unknown types are only refused by the routes that authenticate.
+func (m *Middleware) ClientInfo() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		info, err := GetClientInfo(c, m.Config)
+		if err != nil {
+			m.log.Function("ClientInfo").Warn("Rejected malformed client headers", "error", err, "ip", c.IP())
+			return RejectClientInfo(c, err, m.Config)
+		}
+
+		clientType, clientVersion := info.labels()
+		ClientRequests.Add(1, clientType, clientVersion)
+		return c.Next()
+	}
+}
+
+// GetClientInfo returns the request's ClientInfo, as stored in the
+// clientInfo local by the ClientInfo middleware. Routes mounted without it
+// have their headers parsed here, once.
+func GetClientInfo(c *fiber.Ctx, cfg config.Config) (ClientInfo, error) {
+	if info, ok := c.Locals("clientInfo").(ClientInfo); ok {
+		return info, nil
+	}
+
+	info, err := ParseClientInfo(cfg, c.Get(CLIENT_TYPE_HEADER), c.Get(CLIENT_VERSION_HEADER))
+	if err != nil {
+		return ClientInfo{}, err
+	}
+	c.Locals("clientInfo", info)
+	return info, nil
+}
+
+// RejectClientInfo answers 400 for headers GetClientInfo couldn't parse.
+func RejectClientInfo(c *fiber.Ctx, err error, cfg config.Config) error {
+	message := "X-Client-Type must be a name of letters, digits, '.', '_' and '-'"
+	if errors.Is(err, errMalformedClientVersion) {
+		message = "X-Client-Version must be a dotted version such as 2.4.1"
+	}
+	return apierror.Send(c, apierror.New(ErrorCodeMalformedClientInfo, message), cfg)
+}
+
+// clientStrategy is how clients sending clientType in X-Client-Type
+// authenticate: config.AUTH_STRATEGY_COOKIE, AUTH_STRATEGY_TOKEN or, for
+// unknown types let through, AUTH_STRATEGY_NONE. The built in mobile and web
+// types seed the registry and Session.ClientTypes is laid over them. The
+// strategy is empty when the type is unknown and Session.UnknownClientType
+// rejects it.
+func clientStrategy(cfg config.Config, clientType string) (strategy string, registered bool) {
+	clientTypes := map[string]string{
+		MOBILE_CLIENT_TYPE: config.AUTH_STRATEGY_TOKEN,
+		WEB_CLIENT_TYPE:    config.AUTH_STRATEGY_COOKIE,
+	}
+	// Validated at startup; a malformed list leaves the built in types.
+	if configured, err := cfg.ClientTypes(); err == nil {
+		maps.Copy(clientTypes, configured)
+	}
+
+	if strategy, found := clientTypes[clientType]; found {
+		return strategy, true
+	}
+	if fallback := cfg.UnknownClientType(); fallback != config.UNKNOWN_CLIENT_TYPE_REJECT {
+		return fallback, false
+	}
+	return "", false
+}
+
+// RejectClientType answers 400 for a client type the config refuses.
+func RejectClientType(c *fiber.Ctx, clientType string, cfg config.Config) error {
+	return apierror.Send(c, apierror.New(
+		ErrorCodeUnknownClientType,
+		"Unknown client type: "+clientType,
+	), cfg)
+}
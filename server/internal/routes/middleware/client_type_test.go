@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/models"
+	"server/internal/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	cookieSessionID = "cookie-session"
+	tokenSessionID  = "token-session"
+)
+
+// sendClientType sends both a session cookie and a session token, so the
+// session that authenticated the request shows which strategy was used.
+func sendClientType(t *testing.T, session config.SessionConfig, clientType string) (int, map[string]any) {
+	t.Helper()
+
+	fake := clock.NewFake(lookupTestTime)
+	cfg := config.Config{
+		Security: config.SecurityConfig{JwtSecret: "test-jwt-secret-key-for-testing"},
+		Session:  session,
+	}
+
+	// Tokens from GenerateJWTToken carry no subject, so the token lookup is
+	// for the empty ID.
+	mockSessionRepo := &MockSessionRepository{}
+	for lookup, id := range map[string]string{cookieSessionID: cookieSessionID, "": tokenSessionID} {
+		mockSessionRepo.On("GetByID", mock.Anything, lookup).Return(&models.Session{
+			ID:        id,
+			UserID:    "user-1",
+			ExpiresAt: fake.Now().Add(time.Hour),
+			RefreshAt: fake.Now().Add(time.Hour),
+		}, nil)
+	}
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").
+		Return(&models.User{BaseModel: models.BaseModel{ID: "user-1"}}, nil)
+
+	middleware := New(database.DB{}, &events.EventBus{}, cfg, mockUserRepo, mockSessionRepo, fake)
+	app := fiber.New()
+	app.Use(middleware.BasicAuth())
+	app.Get("/*", func(c *fiber.Ctx) error {
+		session, _ := c.Locals("session").(models.Session)
+		return c.JSON(fiber.Map{"authenticated": c.Locals("authenticated"), "session": session.ID})
+	})
+
+	token, err := utils.GenerateJWTToken(uuid.NewString(), fake.Now().Add(time.Hour), cfg, fake)
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "/api/v1/users/", nil)
+	req.Header.Set(CLIENT_TYPE_HEADER, clientType)
+	req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"="+cookieSessionID)
+	req.Header.Set("Authorization", token)
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func TestBasicAuth_ClientTypes(t *testing.T) {
+	registered := config.SessionConfig{ClientTypes: "tauri=token, admin-web=cookie"}
+
+	testCases := []struct {
+		name       string
+		session    config.SessionConfig
+		clientType string
+		status     int
+		want       string
+	}{
+		{"web by default", config.SessionConfig{}, WEB_CLIENT_TYPE, fiber.StatusOK, cookieSessionID},
+		{"mobile by default", config.SessionConfig{}, MOBILE_CLIENT_TYPE, fiber.StatusOK, tokenSessionID},
+		{"unknown by default", config.SessionConfig{}, "web", fiber.StatusOK, ""},
+		{"mapped to token", registered, "tauri", fiber.StatusOK, tokenSessionID},
+		{"mapped to cookie", registered, "admin-web", fiber.StatusOK, cookieSessionID},
+		{"built in kept", registered, MOBILE_CLIENT_TYPE, fiber.StatusOK, tokenSessionID},
+		{"built in overridden", config.SessionConfig{ClientTypes: "solid=token"}, WEB_CLIENT_TYPE, fiber.StatusOK, tokenSessionID},
+		{
+			"unknown falls back",
+			config.SessionConfig{ClientTypes: "tauri=token", UnknownClientType: config.AUTH_STRATEGY_COOKIE},
+			"kiosk", fiber.StatusOK, cookieSessionID,
+		},
+		{
+			"unknown rejected",
+			config.SessionConfig{ClientTypes: "tauri=token", UnknownClientType: config.UNKNOWN_CLIENT_TYPE_REJECT},
+			"kiosk", fiber.StatusBadRequest, "",
+		},
+		{
+			"mapped with reject",
+			config.SessionConfig{ClientTypes: "tauri=token", UnknownClientType: config.UNKNOWN_CLIENT_TYPE_REJECT},
+			"tauri", fiber.StatusOK, tokenSessionID,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := sendClientType(t, tc.session, tc.clientType)
+
+			assert.Equal(t, tc.status, status)
+			if tc.status != fiber.StatusOK {
+				assert.Equal(t, ErrorCodeUnknownClientType, body["code"])
+				return
+			}
+			assert.Equal(t, tc.want != "", body["authenticated"])
+			assert.Equal(t, tc.want, body["session"])
+		})
+	}
+}
+
+func TestParseClientInfo(t *testing.T) {
+	cfg := config.Config{Session: config.SessionConfig{ClientTypes: "tauri=token"}}
+	rejecting := config.Config{Session: config.SessionConfig{UnknownClientType: config.UNKNOWN_CLIENT_TYPE_REJECT}}
+
+	testCases := []struct {
+		name          string
+		cfg           config.Config
+		clientType    string
+		clientVersion string
+		want          ClientInfo
+		err           error
+	}{
+		{"nothing sent", cfg, "", "", ClientInfo{}, nil},
+		{"built in", cfg, WEB_CLIENT_TYPE, "", ClientInfo{Type: WEB_CLIENT_TYPE, Strategy: config.AUTH_STRATEGY_COOKIE, Registered: true}, nil},
+		{
+			"configured with version", cfg, "tauri", "2.4.1-beta.2",
+			ClientInfo{Type: "tauri", Version: "2.4.1-beta.2", Strategy: config.AUTH_STRATEGY_TOKEN, Registered: true}, nil,
+		},
+		{"unknown let through", cfg, "kiosk", "1", ClientInfo{Type: "kiosk", Version: "1", Strategy: config.AUTH_STRATEGY_NONE}, nil},
+		{"unknown rejected", rejecting, "kiosk", "", ClientInfo{Type: "kiosk"}, nil},
+		{"type with spaces", cfg, "solid web", "", ClientInfo{}, errMalformedClientType},
+		{"type too long", cfg, strings.Repeat("a", 65), "", ClientInfo{}, errMalformedClientType},
+		{"type with markup", cfg, "<script>", "", ClientInfo{}, errMalformedClientType},
+		{"version not dotted", cfg, WEB_CLIENT_TYPE, "latest", ClientInfo{}, errMalformedClientVersion},
+		{"version too deep", cfg, WEB_CLIENT_TYPE, "1.2.3.4.5", ClientInfo{}, errMalformedClientVersion},
+		{"version without type", cfg, "", "1.0", ClientInfo{Version: "1.0"}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := ParseClientInfo(tc.cfg, tc.clientType, tc.clientVersion)
+
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.want, info)
+		})
+	}
+
+	rejected, err := ParseClientInfo(rejecting, "kiosk", "")
+	require.NoError(t, err)
+	assert.True(t, rejected.Rejected())
+}
+
+func clientInfoApp(cfg config.Config, handlers ...fiber.Handler) *fiber.App {
+	m := New(database.DB{}, &events.EventBus{}, cfg, &MockUserRepository{}, &MockSessionRepository{}, clock.NewFake(lookupTestTime))
+	app := fiber.New()
+	app.Use(m.ClientInfo())
+	for _, handler := range handlers {
+		app.Use(handler)
+	}
+	app.Get("/*", func(c *fiber.Ctx) error {
+		info, _ := c.Locals("clientInfo").(ClientInfo)
+		return c.JSON(fiber.Map{"type": info.Type, "version": info.Version, "strategy": info.Strategy})
+	})
+	return app
+}
+
+func sendClientInfo(t *testing.T, app *fiber.App, clientType string, clientVersion string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/v1/health", nil)
+	req.Header.Set(CLIENT_TYPE_HEADER, clientType)
+	req.Header.Set(CLIENT_VERSION_HEADER, clientVersion)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func TestClientInfo_RejectsMalformedHeaders(t *testing.T) {
+	app := clientInfoApp(config.Config{})
+
+	status, body := sendClientInfo(t, app, "solid;drop", "")
+	assert.Equal(t, fiber.StatusBadRequest, status)
+	assert.Equal(t, ErrorCodeMalformedClientInfo, body["code"])
+
+	status, body = sendClientInfo(t, app, WEB_CLIENT_TYPE, "v1")
+	assert.Equal(t, fiber.StatusBadRequest, status)
+	assert.Equal(t, ErrorCodeMalformedClientInfo, body["code"])
+	assert.Contains(t, body["error"], "X-Client-Version")
+
+	// Unknown types are left to the routes that authenticate
+	rejecting := clientInfoApp(config.Config{Session: config.SessionConfig{UnknownClientType: config.UNKNOWN_CLIENT_TYPE_REJECT}})
+	status, body = sendClientInfo(t, rejecting, "kiosk", "1.0")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "kiosk", body["type"])
+}
+
+func TestClientInfo_CountsRequests(t *testing.T) {
+	app := clientInfoApp(config.Config{})
+	before := func(clientType, clientVersion string) uint64 { return ClientRequests.Value(clientType, clientVersion) }
+	web, webUnversioned := before(WEB_CLIENT_TYPE, "3.1.0"), before(WEB_CLIENT_TYPE, CLIENT_LABEL_NONE)
+	other, none := before(CLIENT_LABEL_OTHER, CLIENT_LABEL_OTHER), before(CLIENT_LABEL_NONE, CLIENT_LABEL_NONE)
+
+	sendClientInfo(t, app, WEB_CLIENT_TYPE, "3.1.0")
+	sendClientInfo(t, app, WEB_CLIENT_TYPE, "3.1.0")
+	sendClientInfo(t, app, WEB_CLIENT_TYPE, "")
+	sendClientInfo(t, app, "kiosk", "9.9.9")
+	sendClientInfo(t, app, "", "")
+	sendClientInfo(t, app, WEB_CLIENT_TYPE, "not a version")
+
+	assert.Equal(t, web+2, ClientRequests.Value(WEB_CLIENT_TYPE, "3.1.0"))
+	assert.Equal(t, webUnversioned+1, ClientRequests.Value(WEB_CLIENT_TYPE, CLIENT_LABEL_NONE))
+	assert.Equal(t, other+1, ClientRequests.Value(CLIENT_LABEL_OTHER, CLIENT_LABEL_OTHER), "unregistered types share a label")
+	assert.Equal(t, none+1, ClientRequests.Value(CLIENT_LABEL_NONE, CLIENT_LABEL_NONE))
+	assert.Zero(t, ClientRequests.Value(WEB_CLIENT_TYPE, "not a version"), "rejected requests aren't counted")
+}
+
+func TestClientInfo_BasicAuthFollowsLocals(t *testing.T) {
+	fake := clock.NewFake(lookupTestTime)
+	cfg := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret-key-for-testing"}}
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, cookieSessionID).Return(&models.Session{
+		ID:        cookieSessionID,
+		UserID:    "user-1",
+		ExpiresAt: fake.Now().Add(time.Hour),
+		RefreshAt: fake.Now().Add(time.Hour),
+	}, nil)
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").
+		Return(&models.User{BaseModel: models.BaseModel{ID: "user-1"}}, nil)
+	m := New(database.DB{}, &events.EventBus{}, cfg, mockUserRepo, mockSessionRepo, fake)
+
+	// A later handler changing the header doesn't change how BasicAuth
+	// sees the client, only what ClientInfo parsed does
+	app := fiber.New()
+	app.Use(m.ClientInfo(), func(c *fiber.Ctx) error {
+		c.Request().Header.Set(CLIENT_TYPE_HEADER, MOBILE_CLIENT_TYPE)
+		return c.Next()
+	}, m.BasicAuth())
+	app.Get("/*", func(c *fiber.Ctx) error {
+		info, _ := GetClientInfo(c, cfg)
+		return c.JSON(fiber.Map{"authenticated": c.Locals("authenticated"), "version": info.Version})
+	})
+
+	req := httptest.NewRequest("GET", "/api/v1/users/", nil)
+	req.Header.Set(CLIENT_TYPE_HEADER, WEB_CLIENT_TYPE)
+	req.Header.Set(CLIENT_VERSION_HEADER, "3.1.0")
+	req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"="+cookieSessionID)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, true, body["authenticated"], "authenticated by cookie, as a web client")
+	assert.Equal(t, "3.1.0", body["version"])
+}
+
+func TestBasicAuth_RejectsMalformedClientType(t *testing.T) {
+	status, body := sendClientType(t, config.SessionConfig{}, "solid web")
+
+	assert.Equal(t, fiber.StatusBadRequest, status)
+	assert.Equal(t, ErrorCodeMalformedClientInfo, body["code"])
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"server/config"
+	"server/internal/apierror"
+	"server/internal/semver"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	ErrorCodeClientOutdated = "client_outdated"
+
+	// CLIENT_VERSION_PATH answers what a client needs to know to upgrade,
+	// see routes.ClientVersionRoutes
+	CLIENT_VERSION_PATH = "/client-version"
+)
+
+// ClientVersionExemptRoutes answer clients below their minimum version too,
+// so they can tell whether the service is up and how to upgrade, and look up
+// the error they were answered. Paths are relative to the API prefix and
+// version; a trailing /* also matches everything below.
+var ClientVersionExemptRoutes = []string{
+	"/health",
+	CLIENT_VERSION_PATH,
+	"/problems/*",
+}
+
+func init() {
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeClientOutdated, Status: fiber.StatusUpgradeRequired, Title: "Client upgrade required",
+	})
+}
+
+// ClientUpgrade is where the client sending a request stands against the
+// minimum version of its type.
+type ClientUpgrade struct {
+	ClientType     string `json:"clientType"`
+	Version        string `json:"version"`
+	MinimumVersion string `json:"minimumVersion,omitempty"`
+	UpgradeURL     string `json:"upgradeUrl,omitempty"`
+	Required       bool   `json:"upgradeRequired"`
+}
+
+// CheckClientVersion compares info's version with the minimum configured
+// for its type. Types without a minimum never need an upgrade; a type with
+// one that sends no version does, as builds from before X-Client-Version
+// are the oldest of all.
+func CheckClientVersion(cfg config.Config, info ClientInfo) ClientUpgrade {
+	upgrade := ClientUpgrade{ClientType: info.Type, Version: info.Version}
+
+	// Validated at startup; a malformed list checks nothing.
+	minVersions, err := cfg.MinClientVersions()
+	if err != nil {
+		return upgrade
+	}
+	minimum, found := minVersions[info.Type]
+	if !found {
+		return upgrade
+	}
+
+	upgrade.MinimumVersion = minimum.String()
+	upgrade.UpgradeURL = cfg.Session.ClientUpgradeURL
+	if info.Version == "" {
+		upgrade.Required = true
+		return upgrade
+	}
+	// ClientInfo only lets through versions this parses
+	version, err := semver.Parse(info.Version)
+	upgrade.Required = err != nil || version.Less(minimum)
+	return upgrade
+}
+
+// ClientVersion answers 426 to clients older than the minimum version of
+// their type, except on ClientVersionExemptRoutes. It must run after
+// ClientInfo.
+func (m *Middleware) ClientVersion() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if IsClientVersionExempt(c.Path()) {
+			return c.Next()
+		}
+
+		info, err := GetClientInfo(c, m.Config)
+		if err != nil {
+			return RejectClientInfo(c, err, m.Config)
+		}
+		upgrade := CheckClientVersion(m.Config, info)
+		if !upgrade.Required {
+			return c.Next()
+		}
+
+		m.log.Function("ClientVersion").Info("Rejected outdated client",
+			"clientType", upgrade.ClientType,
+			"version", upgrade.Version,
+			"minimumVersion", upgrade.MinimumVersion,
+			"path", c.Path(),
+		)
+		return RejectClientVersion(c, upgrade, m.Config)
+	}
+}
+
+// RejectClientVersion answers 426 with the minimum version and where to get
+// it.
+func RejectClientVersion(c *fiber.Ctx, upgrade ClientUpgrade, cfg config.Config) error {
+	apiErr := apierror.New(ErrorCodeClientOutdated, "This version of the app is no longer supported, please upgrade").
+		With("clientType", upgrade.ClientType).
+		With("minimumVersion", upgrade.MinimumVersion)
+	if upgrade.UpgradeURL != "" {
+		apiErr = apiErr.With("upgradeUrl", upgrade.UpgradeURL)
+	}
+	return apierror.Send(c, apiErr, cfg)
+}
+
+// IsClientVersionExempt reports whether an /api path matches
+// ClientVersionExemptRoutes, on either the versioned or the legacy prefix.
+func IsClientVersionExempt(path string) bool {
+	for _, route := range ClientVersionExemptRoutes {
+		if matchesRoute(path, route) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/database"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func minVersionConfig() config.Config {
+	return config.Config{Session: config.SessionConfig{
+		MinClientVersions: "flutter=2.3.0, tauri=1.0.0-beta.2",
+		ClientUpgradeURL:  "https://example.com/download",
+	}}
+}
+
+func TestCheckClientVersion(t *testing.T) {
+	cfg := minVersionConfig()
+
+	testCases := []struct {
+		name       string
+		clientType string
+		version    string
+		required   bool
+	}{
+		{"at the minimum", MOBILE_CLIENT_TYPE, "2.3.0", false},
+		{"above the minimum", MOBILE_CLIENT_TYPE, "2.10.0", false},
+		{"short version at the minimum", MOBILE_CLIENT_TYPE, "2.3", false},
+		{"below the minimum", MOBILE_CLIENT_TYPE, "2.2.9", true},
+		{"prerelease of the minimum", MOBILE_CLIENT_TYPE, "2.3.0-rc.1", true},
+		{"build metadata ignored", MOBILE_CLIENT_TYPE, "2.3.0+ios", false},
+		{"no version", MOBILE_CLIENT_TYPE, "", true},
+		{"prerelease minimum", "tauri", "1.0.0-beta.10", false},
+		{"older prerelease", "tauri", "1.0.0-alpha", true},
+		{"release after prerelease minimum", "tauri", "1.0.0", false},
+		{"unconfigured type", WEB_CLIENT_TYPE, "0.0.1", false},
+		{"unconfigured type without version", WEB_CLIENT_TYPE, "", false},
+		{"no type", "", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			upgrade := CheckClientVersion(cfg, ClientInfo{Type: tc.clientType, Version: tc.version})
+			assert.Equal(t, tc.required, upgrade.Required)
+			assert.Equal(t, tc.clientType, upgrade.ClientType)
+			assert.Equal(t, tc.version, upgrade.Version)
+		})
+	}
+
+	upgrade := CheckClientVersion(cfg, ClientInfo{Type: MOBILE_CLIENT_TYPE, Version: "2.2.0"})
+	assert.Equal(t, "2.3.0", upgrade.MinimumVersion)
+	assert.Equal(t, "https://example.com/download", upgrade.UpgradeURL)
+
+	assert.Empty(t, CheckClientVersion(cfg, ClientInfo{Type: WEB_CLIENT_TYPE}).MinimumVersion)
+	assert.False(t, CheckClientVersion(config.Config{}, ClientInfo{Type: MOBILE_CLIENT_TYPE}).Required)
+}
+
+func sendClientVersion(t *testing.T, cfg config.Config, path string, clientType string, version string) (int, map[string]any) {
+	t.Helper()
+
+	middleware := New(database.DB{}, nil, cfg, nil, nil, nil)
+	app := fiber.New()
+	app.Use(middleware.ClientInfo(), middleware.ClientVersion())
+	app.Get("/*", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"served": true})
+	})
+
+	req := httptest.NewRequest("GET", path, nil)
+	if clientType != "" {
+		req.Header.Set(CLIENT_TYPE_HEADER, clientType)
+	}
+	if version != "" {
+		req.Header.Set(CLIENT_VERSION_HEADER, version)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func TestClientVersion_RejectsOutdatedClients(t *testing.T) {
+	status, body := sendClientVersion(t, minVersionConfig(), "/api/v1/users/me", MOBILE_CLIENT_TYPE, "2.2.9")
+
+	assert.Equal(t, fiber.StatusUpgradeRequired, status)
+	assert.Equal(t, ErrorCodeClientOutdated, body["code"])
+	assert.Equal(t, MOBILE_CLIENT_TYPE, body["clientType"])
+	assert.Equal(t, "2.3.0", body["minimumVersion"])
+	assert.Equal(t, "https://example.com/download", body["upgradeUrl"])
+
+	status, _ = sendClientVersion(t, minVersionConfig(), "/api/v1/users/me", MOBILE_CLIENT_TYPE, "")
+	assert.Equal(t, fiber.StatusUpgradeRequired, status, "a type with a minimum must send its version")
+
+	status, body = sendClientVersion(t, minVersionConfig(), "/api/v1/users/me", MOBILE_CLIENT_TYPE, "2.3.0")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, true, body["served"])
+}
+
+func TestClientVersion_UnconfiguredTypesPass(t *testing.T) {
+	for _, clientType := range []string{WEB_CLIENT_TYPE, "kiosk", ""} {
+		status, _ := sendClientVersion(t, minVersionConfig(), "/api/v1/users/me", clientType, "0.0.1")
+		assert.Equal(t, fiber.StatusOK, status, clientType)
+	}
+
+	// Nothing configured checks nothing
+	status, _ := sendClientVersion(t, config.Config{}, "/api/v1/users/me", MOBILE_CLIENT_TYPE, "")
+	assert.Equal(t, fiber.StatusOK, status)
+}
+
+func TestClientVersion_ExemptRoutes(t *testing.T) {
+	for _, path := range []string{
+		"/api/v1/health",
+		"/api/health",
+		"/api/v1" + CLIENT_VERSION_PATH,
+		"/api" + CLIENT_VERSION_PATH,
+		"/api/v1/problems/" + ErrorCodeClientOutdated,
+	} {
+		status, _ := sendClientVersion(t, minVersionConfig(), path, MOBILE_CLIENT_TYPE, "1.0.0")
+		assert.Equal(t, fiber.StatusOK, status, path)
+	}
+
+	for _, path := range []string{"/api/v1/healthz", "/api/v1/users", "/ws"} {
+		status, _ := sendClientVersion(t, minVersionConfig(), path, MOBILE_CLIENT_TYPE, "1.0.0")
+		assert.Equal(t, fiber.StatusUpgradeRequired, status, path)
+	}
+}
+
+func TestClientVersion_NoUpgradeURL(t *testing.T) {
+	cfg := minVersionConfig()
+	cfg.Session.ClientUpgradeURL = ""
+
+	status, body := sendClientVersion(t, cfg, "/api/v1/users/me", MOBILE_CLIENT_TYPE, "1.0.0")
+	assert.Equal(t, fiber.StatusUpgradeRequired, status)
+	assert.NotContains(t, body, "upgradeUrl")
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"server/config"
+	"server/internal/apierror"
+	"server/internal/metrics"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	ErrorCodeBusy = "busy"
+
+	// How long a 503 from a full queue tells the client to wait
+	CONCURRENCY_RETRY_AFTER = 5 * time.Second
+
+	// The longest a request waits in the queue. Fiber doesn't cancel a
+	// request when its client goes away, so this bounds how long an
+	// abandoned one keeps its place.
+	CONCURRENCY_QUEUE_TIMEOUT = 30 * time.Second
+)
+
+// Requests running and waiting in each write group.
+var (
+	ConcurrencyInFlight = metrics.NewLabeledGauge(
+		"write_requests_in_flight",
+		"Write-heavy requests running, by route group.",
+		"group",
+	)
+	ConcurrencyQueued = metrics.NewLabeledGauge(
+		"write_requests_queued",
+		"Write-heavy requests waiting for a slot, by route group.",
+		"group",
+	)
+)
+
+var errQueueFull = errors.New("concurrency queue is full")
+
+func init() {
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeBusy, Status: fiber.StatusServiceUnavailable, Title: "Server busy",
+	})
+	metrics.Register(ConcurrencyInFlight)
+	metrics.Register(ConcurrencyQueued)
+}
+
+// ConcurrencyLimits holds a semaphore per group of write-heavy routes, so
+// bulk work can't hold enough sqlite write locks to slow down logins. Routes
+// opt in with Middleware.LimitConcurrency; routes in the same group share
+// its slots, whichever mount they are reached through.
+type ConcurrencyLimits struct {
+	mutex  sync.Mutex
+	groups map[string]*semaphore
+	config config.Config
+}
+
+// NewConcurrencyLimits sizes each group from Database.WriteLimits.
+func NewConcurrencyLimits(config config.Config) *ConcurrencyLimits {
+	return &ConcurrencyLimits{groups: make(map[string]*semaphore), config: config}
+}
+
+func (l *ConcurrencyLimits) group(name string) *semaphore {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	group, ok := l.groups[name]
+	if !ok {
+		group = newSemaphore(name, l.config.WriteLimit(name))
+		l.groups[name] = group
+	}
+	return group
+}
+
+type semaphoreWaiter struct {
+	weight int64
+	ready  chan struct{}
+}
+
+// semaphore is a weighted semaphore with a bounded FIFO queue. Waiters are
+// served in order, so a heavy request isn't starved by lighter ones.
+type semaphore struct {
+	mutex    sync.Mutex
+	name     string
+	size     int64
+	used     int64
+	running  int
+	maxQueue int
+	waiters  list.List
+}
+
+func newSemaphore(name string, limit config.WriteLimit) *semaphore {
+	return &semaphore{name: name, size: limit.Slots, maxQueue: limit.Queue}
+}
+
+// Acquire takes weight slots, waiting in the queue until they are free or ctx
+// is done. It fails straight away with errQueueFull when the queue is full.
+// Weights above the size take every slot.
+func (s *semaphore) Acquire(ctx context.Context, weight int64) error {
+	weight = min(weight, s.size)
+
+	s.mutex.Lock()
+	if s.used+weight <= s.size && s.waiters.Len() == 0 {
+		s.used += weight
+		s.running++
+		s.report()
+		s.mutex.Unlock()
+		return nil
+	}
+	if s.waiters.Len() >= s.maxQueue {
+		s.mutex.Unlock()
+		return errQueueFull
+	}
+
+	waiter := &semaphoreWaiter{weight: weight, ready: make(chan struct{})}
+	element := s.waiters.PushBack(waiter)
+	s.report()
+	s.mutex.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		select {
+		case <-waiter.ready:
+			// Granted while being cancelled, so hand the slots back
+			s.used -= weight
+			s.running--
+		default:
+			s.waiters.Remove(element)
+		}
+		// Leaving may let the waiters behind through
+		s.grant()
+		s.report()
+		return ctx.Err()
+	}
+}
+
+// Release hands back weight slots taken with Acquire.
+func (s *semaphore) Release(weight int64) {
+	weight = min(weight, s.size)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.used -= weight
+	s.running--
+	s.grant()
+	s.report()
+}
+
+// grant lets in as many waiters from the front as fit. Callers hold the
+// mutex.
+func (s *semaphore) grant() {
+	for front := s.waiters.Front(); front != nil; front = s.waiters.Front() {
+		waiter := front.Value.(*semaphoreWaiter)
+		if s.used+waiter.weight > s.size {
+			return
+		}
+		s.used += waiter.weight
+		s.running++
+		s.waiters.Remove(front)
+		close(waiter.ready)
+	}
+}
+
+// report updates the group's gauges. Callers hold the mutex.
+func (s *semaphore) report() {
+	ConcurrencyInFlight.Set(s.name, float64(s.running))
+	ConcurrencyQueued.Set(s.name, float64(s.waiters.Len()))
+}
+
+// LimitConcurrency runs the route in group's slots, weight at a time. A
+// request that finds the queue full, or waits longer than
+// CONCURRENCY_QUEUE_TIMEOUT, is answered 503 with Retry-After. One whose
+// user context is cancelled while it waits gives up its place. A nil limits
+// doesn't limit.
+func (m *Middleware) LimitConcurrency(limits *ConcurrencyLimits, group string, weight int64) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if limits == nil {
+			return c.Next()
+		}
+		log := m.log.Function("LimitConcurrency")
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), CONCURRENCY_QUEUE_TIMEOUT)
+		defer cancel()
+
+		slots := limits.group(group)
+		if err := slots.Acquire(ctx, weight); err != nil {
+			if c.UserContext().Err() != nil {
+				log.Info("Request abandoned while queued", "group", group, "path", c.Path())
+				return c.UserContext().Err()
+			}
+			log.Warn("Write group is busy", "group", group, "path", c.Path(), "error", err)
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(CONCURRENCY_RETRY_AFTER.Seconds())))
+			return apierror.Send(c, apierror.New(ErrorCodeBusy, "Server is busy, try again later"), m.Config)
+		}
+		defer slots.Release(weight)
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/database"
+	"server/internal/events"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowWriteApp serves POST /write in group, through a handler that holds its
+// slot until release is closed or sent to.
+type slowWriteApp struct {
+	app     *fiber.App
+	started chan struct{}
+	release chan struct{}
+
+	// User contexts by X-Request-Context, to cancel queued requests with
+	contexts sync.Map
+}
+
+func newSlowWriteApp(t *testing.T, group string, limit string) *slowWriteApp {
+	t.Helper()
+
+	cfg := config.Config{Database: config.DatabaseConfig{WriteLimits: limit}}
+	middleware := New(database.DB{}, &events.EventBus{}, cfg, nil, nil, nil)
+	slow := &slowWriteApp{
+		app:     fiber.New(),
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+	slow.app.Use(func(c *fiber.Ctx) error {
+		if ctx, ok := slow.contexts.Load(c.Get("X-Request-Context")); ok {
+			c.SetUserContext(ctx.(context.Context))
+		}
+		return c.Next()
+	})
+	slow.app.Post("/write", middleware.LimitConcurrency(NewConcurrencyLimits(cfg), group, 1), func(c *fiber.Ctx) error {
+		slow.started <- struct{}{}
+		<-slow.release
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	return slow
+}
+
+// send makes a request in the background and delivers its response.
+func (s *slowWriteApp) send(t *testing.T, contextKey string) <-chan *http.Response {
+	responses := make(chan *http.Response, 1)
+	go func() {
+		req := httptest.NewRequest("POST", "/write", nil)
+		req.Header.Set("X-Request-Context", contextKey)
+		resp, err := s.app.Test(req, -1)
+		assert.NoError(t, err)
+		responses <- resp
+	}()
+	return responses
+}
+
+func (s *slowWriteApp) waitStarted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.started:
+	case <-time.After(time.Second):
+		t.Fatal("expected a request to start")
+	}
+}
+
+func waitQueued(t *testing.T, group string, queued float64) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		return ConcurrencyQueued.Value(group) == queued
+	}, time.Second, time.Millisecond)
+}
+
+func receiveResponse(t *testing.T, responses <-chan *http.Response) *http.Response {
+	t.Helper()
+	select {
+	case resp := <-responses:
+		return resp
+	case <-time.After(time.Second):
+		t.Fatal("expected a response")
+		return nil
+	}
+}
+
+func TestLimitConcurrency_CapAndQueue(t *testing.T) {
+	slow := newSlowWriteApp(t, "test-cap", "test-cap=2:1")
+
+	first, second := slow.send(t, ""), slow.send(t, "")
+	slow.waitStarted(t)
+	slow.waitStarted(t)
+	assert.Equal(t, float64(2), ConcurrencyInFlight.Value("test-cap"))
+
+	// The third waits for a slot
+	third := slow.send(t, "")
+	waitQueued(t, "test-cap", 1)
+	select {
+	case <-slow.started:
+		t.Fatal("expected the third request to wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The fourth finds the queue full
+	overflow := receiveResponse(t, slow.send(t, ""))
+	assert.Equal(t, fiber.StatusServiceUnavailable, overflow.StatusCode)
+	assert.Equal(t, "5", overflow.Header.Get(fiber.HeaderRetryAfter))
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(overflow.Body).Decode(&body))
+	assert.Equal(t, ErrorCodeBusy, body["code"])
+
+	// Finishing one lets the queued request in
+	slow.release <- struct{}{}
+	slow.waitStarted(t)
+	waitQueued(t, "test-cap", 0)
+	assert.Equal(t, float64(2), ConcurrencyInFlight.Value("test-cap"))
+
+	close(slow.release)
+	for _, responses := range []<-chan *http.Response{first, second, third} {
+		assert.Equal(t, fiber.StatusNoContent, receiveResponse(t, responses).StatusCode)
+	}
+	assert.Equal(t, float64(0), ConcurrencyInFlight.Value("test-cap"))
+}
+
+func TestLimitConcurrency_CancelledWhileQueued(t *testing.T) {
+	slow := newSlowWriteApp(t, "test-cancel", "test-cancel=1:1")
+	ctx, cancel := context.WithCancel(context.Background())
+	slow.contexts.Store("abandoned", ctx)
+
+	running := slow.send(t, "")
+	slow.waitStarted(t)
+
+	abandoned := slow.send(t, "abandoned")
+	waitQueued(t, "test-cancel", 1)
+
+	// The client going away gives up its place in the queue
+	cancel()
+	resp := receiveResponse(t, abandoned)
+	assert.NotEqual(t, fiber.StatusNoContent, resp.StatusCode)
+	waitQueued(t, "test-cancel", 0)
+
+	// So the next request queues rather than being turned away, and runs
+	next := slow.send(t, "")
+	waitQueued(t, "test-cancel", 1)
+	close(slow.release)
+	assert.Equal(t, fiber.StatusNoContent, receiveResponse(t, running).StatusCode)
+	assert.Equal(t, fiber.StatusNoContent, receiveResponse(t, next).StatusCode)
+	assert.Equal(t, float64(0), ConcurrencyInFlight.Value("test-cancel"))
+}
+
+func TestSemaphore_Weights(t *testing.T) {
+	slots := newSemaphore("test-weights", config.WriteLimit{Slots: 3, Queue: 2})
+
+	require.NoError(t, slots.Acquire(context.Background(), 2))
+
+	// A heavy request waits, and the light one behind it waits its turn
+	heavy, light := make(chan error, 1), make(chan error, 1)
+	go func() { heavy <- slots.Acquire(context.Background(), 2) }()
+	waitQueued(t, "test-weights", 1)
+	go func() { light <- slots.Acquire(context.Background(), 1) }()
+	waitQueued(t, "test-weights", 2)
+
+	// Weights above the size take every slot, and the queue is full
+	assert.ErrorIs(t, slots.Acquire(context.Background(), 10), errQueueFull)
+
+	slots.Release(2)
+	require.NoError(t, <-heavy)
+	require.NoError(t, <-light)
+	assert.Equal(t, int64(3), slots.used)
+
+	slots.Release(2)
+	slots.Release(1)
+	assert.Equal(t, int64(0), slots.used)
+	assert.Equal(t, float64(0), ConcurrencyInFlight.Value("test-weights"))
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/models"
+	"server/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// sendExpiry authenticates as clientType with a session expiring in a day
+// and due for a refresh at refreshAt, sending a token that expires in an
+// hour, and returns the response headers.
+func sendExpiry(t *testing.T, clientType string, refreshAt time.Time) http.Header {
+	t.Helper()
+
+	fake := clock.NewFake(lookupTestTime)
+	cfg := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret-key-for-testing"}}
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, mock.Anything).Return(&models.Session{
+		ID:        "session-1",
+		UserID:    "user-1",
+		ExpiresAt: fake.Now().Add(24 * time.Hour),
+		RefreshAt: refreshAt,
+	}, nil)
+	mockSessionRepo.On("Create", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			session := args.Get(1).(*models.Session)
+			session.ID = "session-2"
+			session.Token = "refreshed-token"
+			session.ExpiresAt = fake.Now().Add(7 * 24 * time.Hour)
+			session.RefreshAt = fake.Now().Add(5 * 24 * time.Hour)
+		}).
+		Return(nil)
+	mockSessionRepo.On("Delete", mock.Anything, mock.Anything).Return(nil)
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").
+		Return(&models.User{BaseModel: models.BaseModel{ID: "user-1"}}, nil)
+
+	middleware := New(database.DB{}, events.New(nil, cfg, nil), cfg, mockUserRepo, mockSessionRepo, fake)
+	app := fiber.New()
+	app.Use(middleware.BasicAuth())
+	app.Get("/*", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/api/v1/users/", nil)
+	req.Header.Set(CLIENT_TYPE_HEADER, clientType)
+	switch clientType {
+	case WEB_CLIENT_TYPE:
+		req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"=session-1")
+	case MOBILE_CLIENT_TYPE:
+		token, err := utils.GenerateJWTToken(uuid.NewString(), fake.Now().Add(time.Hour), cfg, fake)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	return resp.Header
+}
+
+func TestBasicAuth_ExpiryHeaders(t *testing.T) {
+	refreshAt := lookupTestTime.Add(12 * time.Hour)
+
+	headers := sendExpiry(t, WEB_CLIENT_TYPE, refreshAt)
+	assert.Equal(t, "2024-06-02T12:00:00Z", headers.Get(SESSION_EXPIRES_AT_HEADER))
+	assert.Equal(t, "2024-06-02T00:00:00Z", headers.Get(SESSION_REFRESH_AT_HEADER))
+	assert.Empty(t, headers.Get(TOKEN_EXPIRES_AT_HEADER), "cookie clients hold no token")
+
+	headers = sendExpiry(t, MOBILE_CLIENT_TYPE, refreshAt)
+	assert.Equal(t, "2024-06-02T12:00:00Z", headers.Get(SESSION_EXPIRES_AT_HEADER))
+	assert.Equal(t, "2024-06-02T00:00:00Z", headers.Get(SESSION_REFRESH_AT_HEADER))
+	assert.Equal(t, "2024-06-01T13:00:00Z", headers.Get(TOKEN_EXPIRES_AT_HEADER), "from the token sent")
+}
+
+func TestBasicAuth_ExpiryHeadersAfterRefresh(t *testing.T) {
+	refreshAt := lookupTestTime.Add(-time.Minute)
+
+	for _, clientType := range []string{WEB_CLIENT_TYPE, MOBILE_CLIENT_TYPE} {
+		headers := sendExpiry(t, clientType, refreshAt)
+		assert.Equal(t, "2024-06-08T12:00:00Z", headers.Get(SESSION_EXPIRES_AT_HEADER), clientType)
+		assert.Equal(t, "2024-06-06T12:00:00Z", headers.Get(SESSION_REFRESH_AT_HEADER), clientType)
+	}
+
+	headers := sendExpiry(t, MOBILE_CLIENT_TYPE, refreshAt)
+	assert.Equal(t, "refreshed-token", headers.Get("X-Auth-Token"))
+	assert.Equal(t, "2024-06-08T12:00:00Z", headers.Get(TOKEN_EXPIRES_AT_HEADER), "from the token just handed out")
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"server/internal/hooks"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HookRegistry is the registry Hooks and the auth middleware run, shared by
+// every copy of the middleware.
+func (m *Middleware) HookRegistry() *hooks.Registry {
+	return m.hooks
+}
+
+// Hooks runs the PRE_ROUTE hooks, then the rest of the chain, then the
+// ON_ERROR hooks when it failed and the PRE_RESPONSE hooks. A hook's error
+// takes the place of the handler's and goes to the error handler; the
+// PRE_RESPONSE hooks run after a failed ON_ERROR one too, so they see every
+// response. It must be mounted before any route.
+func (m *Middleware) Hooks() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := m.hooks.Run(hooks.PRE_ROUTE, c, nil)
+		if err == nil {
+			err = c.Next()
+		}
+
+		if err != nil || c.Response().StatusCode() >= fiber.StatusBadRequest {
+			if hookErr := m.hooks.Run(hooks.ON_ERROR, c, err); hookErr != nil {
+				err = hookErr
+			}
+		}
+		if hookErr := m.hooks.Run(hooks.PRE_RESPONSE, c, err); hookErr != nil {
+			err = hookErr
+		}
+		return err
+	}
+}
+
+// afterAuth runs the POST_AUTH hooks once the auth middleware has settled
+// the request, and goes on with it unless one of them fails.
+func (m *Middleware) afterAuth(c *fiber.Ctx) error {
+	if err := m.hooks.Run(hooks.POST_AUTH, c, nil); err != nil {
+		return err
+	}
+	return c.Next()
+}
@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/apierror"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/hooks"
+	"server/internal/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// hookLog records the hooks that ran, in order.
+type hookLog struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (l *hookLog) hook(name string) hooks.Hook {
+	return func(ctx *hooks.Context) error {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.calls = append(l.calls, name)
+		return nil
+	}
+}
+
+func (l *hookLog) take() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	calls := l.calls
+	l.calls = nil
+	return calls
+}
+
+// setupHooksTest serves /api behind Hooks and BasicAuth, with web clients
+// sending session-1 signed in as user-1.
+func setupHooksTest(t *testing.T) (*fiber.App, *hooks.Registry) {
+	t.Helper()
+
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, "session-1").Return(&models.Session{
+		ID:        "session-1",
+		UserID:    "user-1",
+		ExpiresAt: fake.Now().Add(time.Hour),
+		RefreshAt: fake.Now().Add(time.Hour),
+	}, nil)
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").Return(&models.User{BaseModel: models.BaseModel{ID: "user-1"}}, nil)
+
+	cfg := config.Config{}
+	middleware := New(database.DB{}, &events.EventBus{}, cfg, mockUserRepo, mockSessionRepo, fake)
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(cfg)})
+	app.Use(middleware.Hooks())
+	api := app.Group("/api", middleware.BasicAuth())
+	api.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("served")
+	})
+	api.Get("/fail", func(c *fiber.Ctx) error {
+		return errors.New("handler failed")
+	})
+	return app, middleware.HookRegistry()
+}
+
+func sendHooks(t *testing.T, app *fiber.App, path string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodGet, path, nil)
+	req.Header.Set(CLIENT_TYPE_HEADER, WEB_CLIENT_TYPE)
+	req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"=session-1")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestHooks_Order(t *testing.T) {
+	app, registry := setupHooksTest(t)
+	calls := &hookLog{}
+	for _, stage := range hooks.Stages {
+		registry.Register(stage, calls.hook(string(stage)+" 1"))
+		registry.Register(stage, calls.hook(string(stage)+" 2"))
+	}
+
+	resp, _ := sendHooks(t, app, "/api/ok")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{
+		"pre_route 1", "pre_route 2",
+		"post_auth 1", "post_auth 2",
+		"pre_response 1", "pre_response 2",
+	}, calls.take())
+
+	resp, _ = sendHooks(t, app, "/api/fail")
+	assert.Equal(t, fiber.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, []string{
+		"pre_route 1", "pre_route 2",
+		"post_auth 1", "post_auth 2",
+		"on_error 1", "on_error 2",
+		"pre_response 1", "pre_response 2",
+	}, calls.take())
+
+	// Unrouted paths never reach auth
+	resp, _ = sendHooks(t, app, "/elsewhere")
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, []string{
+		"pre_route 1", "pre_route 2",
+		"on_error 1", "on_error 2",
+		"pre_response 1", "pre_response 2",
+	}, calls.take())
+}
+
+func TestHooks_AuthAtPostAuth(t *testing.T) {
+	app, registry := setupHooksTest(t)
+
+	seen := map[hooks.Stage]*hooks.Context{}
+	for _, stage := range []hooks.Stage{hooks.PRE_ROUTE, hooks.POST_AUTH, hooks.ON_ERROR, hooks.PRE_RESPONSE} {
+		registry.Register(stage, func(ctx *hooks.Context) error {
+			seen[ctx.Stage] = ctx
+			return nil
+		})
+	}
+
+	_, _ = sendHooks(t, app, "/api/fail")
+
+	assert.Nil(t, seen[hooks.PRE_ROUTE].User, "not authenticated yet")
+	assert.Nil(t, seen[hooks.PRE_ROUTE].Session)
+	require.NotNil(t, seen[hooks.POST_AUTH].User)
+	assert.Equal(t, "user-1", seen[hooks.POST_AUTH].User.ID)
+	require.NotNil(t, seen[hooks.POST_AUTH].Session)
+	assert.Equal(t, "session-1", seen[hooks.POST_AUTH].Session.ID)
+	assert.EqualError(t, seen[hooks.ON_ERROR].Err, "handler failed")
+	assert.Equal(t, "user-1", seen[hooks.PRE_RESPONSE].User.ID)
+}
+
+func TestHooks_ShortCircuit(t *testing.T) {
+	app, registry := setupHooksTest(t)
+	calls := &hookLog{}
+	registry.Register(hooks.PRE_ROUTE, func(ctx *hooks.Context) error {
+		ctx.Fiber.Set("X-Fork", "blocked")
+		return apierror.New(apierror.CODE_BAD_REQUEST, "Blocked by a hook")
+	})
+	registry.Register(hooks.PRE_ROUTE, calls.hook("pre_route after"))
+	registry.Register(hooks.POST_AUTH, calls.hook("post_auth"))
+	registry.Register(hooks.ON_ERROR, calls.hook("on_error"))
+	registry.Register(hooks.PRE_RESPONSE, calls.hook("pre_response"))
+
+	resp, body := sendHooks(t, app, "/api/ok")
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body, "Blocked by a hook")
+	assert.NotContains(t, body, "served")
+	assert.Equal(t, "blocked", resp.Header.Get("X-Fork"), "headers set before failing are kept")
+	assert.Equal(t, []string{"on_error", "pre_response"}, calls.take(), "the rest of the stage and the handler are skipped")
+}
+
+func TestHooks_PostAuthShortCircuit(t *testing.T) {
+	app, registry := setupHooksTest(t)
+	registry.Register(hooks.POST_AUTH, func(ctx *hooks.Context) error {
+		if ctx.User == nil {
+			return nil
+		}
+		return apierror.New(apierror.CODE_BAD_REQUEST, "Not this user")
+	})
+
+	resp, body := sendHooks(t, app, "/api/ok")
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	assert.NotContains(t, body, "served")
+}
+
+func TestHooks_PanicIsolated(t *testing.T) {
+	app, registry := setupHooksTest(t)
+	calls := &hookLog{}
+	for _, stage := range hooks.Stages {
+		registry.Register(stage, func(ctx *hooks.Context) error {
+			panic("hook bug")
+		})
+		registry.Register(stage, calls.hook(string(stage)))
+	}
+
+	resp, body := sendHooks(t, app, "/api/ok")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "served", body)
+	assert.Equal(t, []string{"pre_route", "post_auth", "pre_response"}, calls.take(),
+		"the hooks after a panicking one still run")
+}
+
+func TestHooks_HeadersButNotBody(t *testing.T) {
+	app, registry := setupHooksTest(t)
+	registry.Register(hooks.PRE_RESPONSE, func(ctx *hooks.Context) error {
+		ctx.Fiber.Set("X-Fork", "seen")
+		ctx.Fiber.Status(fiber.StatusTeapot)
+		return ctx.Fiber.SendString("replaced")
+	})
+
+	resp, body := sendHooks(t, app, "/api/ok")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "served", body)
+	assert.Equal(t, "seen", resp.Header.Get("X-Fork"))
+}
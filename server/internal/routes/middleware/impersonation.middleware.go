@@ -0,0 +1,37 @@
+package middleware
+
+import "strings"
+
+const (
+	IMPERSONATING_HEADER = "X-Impersonating"
+
+	// ErrorCodeImpersonationForbidden answers actions an admin may not take
+	// while acting as someone else.
+	ErrorCodeImpersonationForbidden = "impersonation_forbidden"
+)
+
+// ImpersonationBlockedRoutes can't be used from an impersonation session,
+// written as "METHOD path" with paths relative to the API prefix and version
+// like MaintenanceExemptRoutes. A * method matches any method.
+var ImpersonationBlockedRoutes = []string{
+	"POST /users/password",
+	"DELETE /users/me",
+	"POST /users/session/extend",
+	"POST /users/me/accept-tos",
+	"* /admin/*",
+}
+
+// IsImpersonationBlocked reports whether a request matches
+// ImpersonationBlockedRoutes, on either the versioned or the legacy prefix.
+func IsImpersonationBlocked(method string, path string) bool {
+	for _, route := range ImpersonationBlockedRoutes {
+		routeMethod, routePath, _ := strings.Cut(route, " ")
+		if routeMethod != "*" && !strings.EqualFold(routeMethod, method) {
+			continue
+		}
+		if matchesRoute(path, routePath) {
+			return true
+		}
+	}
+	return false
+}
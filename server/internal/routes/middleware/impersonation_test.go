@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsImpersonationBlocked(t *testing.T) {
+	testCases := []struct {
+		method  string
+		path    string
+		blocked bool
+	}{
+		{"POST", "/api/v1/users/password", true},
+		{"POST", "/api/users/password/", true},
+		{"DELETE", "/api/v1/users/me", true},
+		{"DELETE", "/api/users/me", true},
+		{"POST", "/api/v1/users/session/extend", true},
+		{"POST", "/api/v1/users/me/accept-tos", true},
+		{"GET", "/api/v1/admin/stats", true},
+		{"POST", "/api/v1/admin/users/123/impersonate", true},
+		{"PATCH", "/api/admin/users/123", true},
+		{"GET", "/api/v1/users/", false},
+		{"PATCH", "/api/v1/users/me", false},
+		{"GET", "/api/v1/users/password", false},
+		{"POST", "/api/v1/users/stop-impersonation", false},
+		{"DELETE", "/api/v1/users/me/preferences/theme", false},
+		{"GET", "/api/v1/administrators", false},
+		// Fiber routes these to the same handlers
+		{"POST", "/api/users/PASSWORD", true},
+		{"POST", "/api/Users/Password/", true},
+		{"POST", "/API/V1/users/password", true},
+		{"delete", "/api/v1/Users/Me/", true},
+		{"POST", "/api/v1/Users/Session/Extend", true},
+		{"GET", "/api/v1/Admin/stats/", true},
+		{"GET", "/API/ADMIN", true},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.blocked, IsImpersonationBlocked(tc.method, tc.path), tc.method+" "+tc.path)
+	}
+}
+
+func setupImpersonationTest(
+	t *testing.T,
+	session *models.Session,
+	fake *clock.Fake,
+) (*fiber.App, *MockSessionRepository) {
+	t.Helper()
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").
+		Return(&models.User{BaseModel: models.BaseModel{ID: "user-1"}, IsAdmin: true}, nil)
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, session.ID).Return(session, nil)
+	mockSessionRepo.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	cfg := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret-key-for-testing"}}
+	middleware := New(database.DB{}, &events.EventBus{}, cfg, mockUserRepo, mockSessionRepo, fake)
+
+	app := fiber.New()
+	app.Use(middleware.BasicAuth())
+	app.All("/*", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"authenticated": c.Locals("authenticated")})
+	})
+	return app, mockSessionRepo
+}
+
+func sendAsSession(t *testing.T, app *fiber.App, method, path, sessionID string) (int, string, map[string]any) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("X-Client-Type", WEB_CLIENT_TYPE)
+	req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"="+sessionID)
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, resp.Header.Get(IMPERSONATING_HEADER), body
+}
+
+func TestBasicAuth_ImpersonationSession(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	session := &models.Session{
+		ID:             "impersonation-1",
+		UserID:         "user-1",
+		ExpiresAt:      fake.Now().Add(time.Hour),
+		RefreshAt:      fake.Now().Add(time.Hour),
+		ImpersonatedBy: "admin-1",
+	}
+	app, _ := setupImpersonationTest(t, session, fake)
+
+	status, header, body := sendAsSession(t, app, "GET", "/api/v1/users/", session.ID)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "true", header)
+	assert.Equal(t, true, body["authenticated"])
+
+	for _, route := range [][2]string{
+		{"POST", "/api/v1/users/password"},
+		{"DELETE", "/api/v1/users/me"},
+		{"GET", "/api/admin/stats"},
+		{"POST", "/api/users/PASSWORD"},
+		{"POST", "/api/Users/Password/"},
+		{"DELETE", "/API/v1/users/me/"},
+		{"GET", "/api/Admin/Stats"},
+	} {
+		status, header, body := sendAsSession(t, app, route[0], route[1], session.ID)
+		assert.Equal(t, fiber.StatusForbidden, status, route)
+		assert.Equal(t, "true", header, route)
+		assert.Equal(t, ErrorCodeImpersonationForbidden, body["code"], route)
+	}
+}
+
+func TestBasicAuth_RegularSessionIsNotFlagged(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	session := &models.Session{
+		ID:        "session-1",
+		UserID:    "user-1",
+		ExpiresAt: fake.Now().Add(7 * 24 * time.Hour),
+		RefreshAt: fake.Now().Add(5 * 24 * time.Hour),
+	}
+	app, _ := setupImpersonationTest(t, session, fake)
+
+	status, header, _ := sendAsSession(t, app, "POST", "/api/v1/users/password", session.ID)
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Empty(t, header)
+}
+
+func TestBasicAuth_ImpersonationSessionIsNeverRefreshed(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	session := &models.Session{
+		ID:             "impersonation-1",
+		UserID:         "user-1",
+		ExpiresAt:      fake.Now().Add(time.Hour),
+		RefreshAt:      fake.Now().Add(-time.Minute),
+		ImpersonatedBy: "admin-1",
+	}
+	app, mockSessionRepo := setupImpersonationTest(t, session, fake)
+
+	status, _, _ := sendAsSession(t, app, "GET", "/api/v1/users/", session.ID)
+	assert.Equal(t, fiber.StatusOK, status)
+	mockSessionRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+
+	// Once it expires it is rejected, there was no refresh to extend it
+	fake.Advance(time.Hour + time.Second)
+	req := httptest.NewRequest("GET", "/api/v1/users/", nil)
+	req.Header.Set("X-Client-Type", WEB_CLIENT_TYPE)
+	req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"="+session.ID)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.NotEqual(t, fiber.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(IMPERSONATING_HEADER))
+	mockSessionRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"errors"
+	"server/internal/metrics"
+	"server/internal/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const SLOW_REQUEST_THRESHOLD = time.Second
+
+// RequestLogger logs requests that fail with a 5xx or take longer than
+// SLOW_REQUEST_THRESHOLD. With debug body capture on, the redacted request
+// and response bodies are attached to the entry. Every request's duration is
+// also recorded with latency, under its method and route pattern, unless it is
+// nil.
+func (m *Middleware) RequestLogger(latency *metrics.LatencyTracker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+		duration := time.Since(start)
+		latency.Observe(latencyRoute(c), duration)
+
+		status := responseStatus(c, err)
+		if status < fiber.StatusInternalServerError && duration < SLOW_REQUEST_THRESHOLD {
+			return err
+		}
+
+		log := m.log.Function("RequestLogger").Traced(c.Context())
+		args := m.requestLogArgs(c, status, duration)
+		if status >= fiber.StatusInternalServerError {
+			_ = log.Error("Request failed", args...)
+		} else {
+			log.Warn("Slow request", args...)
+		}
+
+		return err
+	}
+}
+
+// responseStatus is the status the error handler will answer with when the
+// handler chain returned err.
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// latencyRoute names the route that handled the request by its pattern
+// rather than its path, so IDs in the path don't each become a route.
+func latencyRoute(c *fiber.Ctx) string {
+	return c.Method() + " " + c.Route().Path
+}
+
+// requestID is the ID the requestid middleware gave the request, empty when
+// it isn't installed.
+func requestID(c *fiber.Ctx) string {
+	return c.GetRespHeader(fiber.HeaderXRequestID)
+}
+
+func (m *Middleware) requestLogArgs(c *fiber.Ctx, status int, duration time.Duration) []any {
+	args := []any{
+		"method", c.Method(),
+		"path", c.Path(),
+		"status", status,
+		"duration_ms", duration.Milliseconds(),
+	}
+
+	if !m.Config.DebugBodyCaptureEnabled() {
+		return args
+	}
+
+	fields := m.redactedFields()
+	responseBody := "[streamed body]"
+	if !c.Response().IsBodyStream() {
+		responseBody = utils.RedactJSON(c.Response().Body(), fields)
+	}
+
+	return append(args,
+		"request_body", utils.RedactJSON(c.Body(), fields),
+		"response_body", responseBody,
+	)
+}
+
+func (m *Middleware) redactedFields() []string {
+	fields := utils.ParseRedactedFields(m.Config.Server.RedactFields)
+	if len(fields) == 0 {
+		return utils.ParseRedactedFields(utils.DEFAULT_REDACTED_FIELDS)
+	}
+	return fields
+}
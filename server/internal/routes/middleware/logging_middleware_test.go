@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"server/config"
+	"server/internal/logger"
+	"server/internal/metrics"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func captureRequestLogArgs(t *testing.T, cfg config.Config, body string) map[string]any {
+	middleware := Middleware{Config: cfg, log: logger.New("test")}
+	captured := map[string]any{}
+
+	app := fiber.New()
+	app.Post("/login", func(c *fiber.Ctx) error {
+		err := c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "failed",
+			"session": fiber.Map{"token": "response-token", "userId": "user-1"},
+		})
+
+		args := middleware.requestLogArgs(c, fiber.StatusInternalServerError, 2*time.Second)
+		for i := 0; i < len(args); i += 2 {
+			captured[args[i].(string)] = args[i+1]
+		}
+		return err
+	})
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	_, err := app.Test(req)
+	require.NoError(t, err)
+
+	return captured
+}
+
+func TestMiddleware_RequestLogArgs_CaptureRedactsBodies(t *testing.T) {
+	cfg := config.Config{
+		Environment: "development",
+		Server: config.ServerConfig{
+			DebugBodyCapture: true,
+			RedactFields:     "password,token,secret,authorization",
+		},
+	}
+
+	args := captureRequestLogArgs(t, cfg, `{"login":"jdoe","password":"hunter2","meta":[{"secret":"s"}]}`)
+
+	assert.Equal(t, "POST", args["method"])
+	assert.Equal(t, "/login", args["path"])
+	assert.Equal(t, fiber.StatusInternalServerError, args["status"])
+	assert.Equal(t, int64(2000), args["duration_ms"])
+
+	requestBody := args["request_body"].(string)
+	assert.Contains(t, requestBody, `"login":"jdoe"`)
+	assert.NotContains(t, requestBody, "hunter2")
+	assert.NotContains(t, requestBody, `"s"`)
+
+	responseBody := args["response_body"].(string)
+	assert.Contains(t, responseBody, `"userId":"user-1"`)
+	assert.NotContains(t, responseBody, "response-token")
+}
+
+func TestMiddleware_RequestLogArgs_CaptureOff(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{"disabled", config.Config{Environment: "development"}},
+		{"production", config.Config{Environment: "production", Server: config.ServerConfig{DebugBodyCapture: true}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := captureRequestLogArgs(t, tc.cfg, `{"password":"hunter2"}`)
+
+			assert.NotContains(t, args, "request_body")
+			assert.NotContains(t, args, "response_body")
+			assert.Equal(t, "/login", args["path"])
+		})
+	}
+}
+
+func TestMiddleware_RequestLogArgs_DefaultFields(t *testing.T) {
+	cfg := config.Config{Environment: "development", Server: config.ServerConfig{DebugBodyCapture: true}}
+
+	args := captureRequestLogArgs(t, cfg, `{"password":"hunter2"}`)
+
+	assert.NotContains(t, args["request_body"], "hunter2")
+}
+
+func TestMiddleware_RequestLogger_PassesThrough(t *testing.T) {
+	middleware := Middleware{Config: config.Config{}, log: logger.New("test")}
+
+	app := fiber.New()
+	app.Use(middleware.RequestLogger(nil))
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	app.Get("/fail", func(c *fiber.Ctx) error {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "down")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/ok", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/fail", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestMiddleware_RequestLogger_RecordsLatencyByRoute(t *testing.T) {
+	middleware := Middleware{Config: config.Config{}, log: logger.New("test")}
+	latency := metrics.NewLatencyTracker(0, nil)
+
+	app := fiber.New()
+	app.Use(middleware.RequestLogger(latency))
+	app.Get("/users/:id", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/missing"} {
+		_, err := app.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+	}
+
+	routes := map[string]uint64{}
+	for _, route := range latency.Snapshot() {
+		routes[route.Route] = route.Windows["5m"].Count
+	}
+	// Paths no route matched count under the middleware's own
+	assert.Equal(t, map[string]uint64{"GET /users/:id": 2, "GET /": 1}, routes)
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"server/internal/maintenance"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaintenanceExemptRoutes stay up in maintenance mode so operators can check
+// on the service and admins can sign in to turn it off. Paths are relative to
+// the API prefix and version; a trailing /* also matches everything below.
+var MaintenanceExemptRoutes = []string{
+	"/health",
+	"/admin/*",
+	"/users",
+	"/users/login",
+	"/users/logout",
+	"/users/stop-impersonation",
+}
+
+// Maintenance answers 503 with the maintenance message while the mode is on,
+// except for MaintenanceExemptRoutes. It must run after APIVersion. A nil mode
+// never blocks anything.
+func (m *Middleware) Maintenance(mode *maintenance.Mode) fiber.Handler {
+	retryAfter := strconv.Itoa(int(maintenance.RETRY_AFTER.Seconds()))
+
+	return func(c *fiber.Ctx) error {
+		if mode == nil || IsMaintenanceExempt(c.Path()) {
+			return c.Next()
+		}
+
+		state := mode.State()
+		if !state.Enabled {
+			return c.Next()
+		}
+
+		c.Set(fiber.HeaderRetryAfter, retryAfter)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"message":     state.Message,
+			"maintenance": true,
+		})
+	}
+}
+
+// IsMaintenanceExempt reports whether an /api path matches
+// MaintenanceExemptRoutes, on either the versioned or the legacy prefix.
+func IsMaintenanceExempt(path string) bool {
+	for _, route := range MaintenanceExemptRoutes {
+		if matchesRoute(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRoute reports whether an /api path, versioned or not, is route. A
+// route ending in /* also matches everything below it. Fiber routes ignore
+// case and a trailing slash, so the match does too; routes are written in
+// lower case.
+func matchesRoute(path string, route string) bool {
+	rest := strings.TrimPrefix(strings.ToLower(path), API_PREFIX)
+	if segment, after, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/"); isVersionSegment(segment) {
+		rest = "/" + after
+	}
+	if rest != "/" {
+		rest = strings.TrimSuffix(rest, "/")
+	}
+
+	if prefix, ok := strings.CutSuffix(route, "/*"); ok {
+		return rest == prefix || strings.HasPrefix(rest, prefix+"/")
+	}
+	return rest == route
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/database"
+	"server/internal/maintenance"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsMaintenanceExempt(t *testing.T) {
+	testCases := []struct {
+		path   string
+		exempt bool
+	}{
+		{"/api/v1/health", true},
+		{"/api/health", true},
+		{"/api/v1/health/", true},
+		{"/api/v1/admin/maintenance", true},
+		{"/api/admin/users/123/logins", true},
+		{"/api/v1/users/login", true},
+		{"/api/users/logout", true},
+		{"/api/v1/users/", true},
+		{"/api/v1/users", true},
+		{"/api/v1/users/register", false},
+		{"/api/v1/users/me/preferences", false},
+		{"/api/v1/announcements", false},
+		{"/api/v1/administrators", false},
+		{"/api/v1/healthz", false},
+		{"/api/v1", false},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.exempt, IsMaintenanceExempt(tc.path), tc.path)
+	}
+}
+
+func TestMaintenance_BlocksOnlyWhileEnabled(t *testing.T) {
+	mode, err := maintenance.New(context.Background(), database.NewMemoryCacheStore(), nil, config.Config{}, nil)
+	require.NoError(t, err)
+
+	m := New(database.DB{}, nil, config.Config{}, nil, nil, nil)
+	fiberApp := fiber.New()
+	fiberApp.Use("/api", m.Maintenance(mode))
+	fiberApp.All("/api/*", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	resp, err := fiberApp.Test(httptest.NewRequest("POST", "/api/v1/users/register", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+
+	_, err = mode.Set(context.Background(), true, "Back soon", "admin-1")
+	require.NoError(t, err)
+
+	resp, err = fiberApp.Test(httptest.NewRequest("POST", "/api/v1/users/register", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "300", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, map[string]any{"message": "Back soon", "maintenance": true}, body)
+
+	resp, err = fiberApp.Test(httptest.NewRequest("POST", "/api/v1/admin/maintenance", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+
+	_, err = mode.Set(context.Background(), false, "", "admin-1")
+	require.NoError(t, err)
+
+	resp, err = fiberApp.Test(httptest.NewRequest("POST", "/api/v1/users/register", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+}
+
+func TestMaintenance_NilModePassesThrough(t *testing.T) {
+	m := New(database.DB{}, nil, config.Config{}, nil, nil, nil)
+	fiberApp := fiber.New()
+	fiberApp.Use("/api", m.Maintenance(nil))
+	fiberApp.Get("/api/*", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	resp, err := fiberApp.Test(httptest.NewRequest("GET", "/api/v1/announcements", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+}
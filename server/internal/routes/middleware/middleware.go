@@ -2,10 +2,14 @@ package middleware
 
 import (
 	"server/config"
+	"server/internal/clock"
 	"server/internal/database"
 	"server/internal/events"
+	"server/internal/health"
+	"server/internal/hooks"
 	"server/internal/logger"
 	"server/internal/repositories"
+	"time"
 )
 
 type Middleware struct {
@@ -15,6 +19,13 @@ type Middleware struct {
 	Config      config.Config
 	log         logger.Logger
 	eventBus    *events.EventBus
+	clock       clock.Clock
+
+	// Shared by every copy, so a user's requests are counted together
+	// whichever controller serves them
+	userConcurrency *UserConcurrency
+	authBudget      *AuthBudget
+	hooks           *hooks.Registry
 }
 
 func New(
@@ -23,6 +34,7 @@ func New(
 	config config.Config,
 	userRepo repositories.UserRepository,
 	sessionRepo repositories.SessionRepository,
+	clk clock.Clock,
 ) Middleware {
 	log := logger.New("middleware")
 
@@ -33,5 +45,19 @@ func New(
 		Config:      config,
 		log:         log,
 		eventBus:    eventBus,
+		clock:       clock.OrDefault(clk),
+
+		userConcurrency: NewUserConcurrency(config.UserConcurrency()),
+		authBudget:      NewAuthBudget(clk),
+		hooks:           hooks.New(),
 	}
 }
+
+// AuthHealth reports how far authentication has degraded, see AuthBudget.
+func (m *Middleware) AuthHealth() health.Checker {
+	return m.authBudget.Health()
+}
+
+func (m *Middleware) now() time.Time {
+	return clock.OrDefault(m.clock).Now()
+}
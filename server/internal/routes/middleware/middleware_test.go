@@ -17,10 +17,10 @@ func TestMiddleware_New(t *testing.T) {
 	mockDB := database.DB{}
 	mockUserRepo := &MockUserRepository{}
 	mockSessionRepo := &MockSessionRepository{}
-	mockConfig := config.Config{ServerPort: 8080}
+	mockConfig := config.Config{Server: config.ServerConfig{Port: 8080}}
 
 	eventBus := &events.EventBus{}
-	middleware := New(mockDB, eventBus, mockConfig, mockUserRepo, mockSessionRepo)
+	middleware := New(mockDB, eventBus, mockConfig, mockUserRepo, mockSessionRepo, nil)
 
 	assert.NotNil(t, middleware)
 	assert.Equal(t, mockDB, middleware.DB)
@@ -33,11 +33,11 @@ func TestMiddleware_New(t *testing.T) {
 func TestMiddleware_StructCreation(t *testing.T) {
 	middleware := Middleware{
 		DB:     database.DB{},
-		Config: config.Config{ServerPort: 8080},
+		Config: config.Config{Server: config.ServerConfig{Port: 8080}},
 		log:    logger.New("test"),
 	}
 
-	assert.Equal(t, 8080, middleware.Config.ServerPort)
+	assert.Equal(t, 8080, middleware.Config.Server.Port)
 	assert.NotNil(t, middleware.log)
 }
 
@@ -309,18 +309,20 @@ func TestSessionData_Comparison(t *testing.T) {
 func TestMiddleware_ConfigAccess(t *testing.T) {
 	// Test accessing config fields
 	config := config.Config{
-		ServerPort:     8080,
-		SecurityPepper: "test-pepper",
-		SecuritySalt:   12,
+		Server: config.ServerConfig{Port: 8080},
+		Security: config.SecurityConfig{
+			Pepper: "test-pepper",
+			Salt: 12,
+		},
 	}
 
 	middleware := Middleware{
 		Config: config,
 	}
 
-	assert.Equal(t, 8080, middleware.Config.ServerPort)
-	assert.Equal(t, "test-pepper", middleware.Config.SecurityPepper)
-	assert.Equal(t, 12, middleware.Config.SecuritySalt)
+	assert.Equal(t, 8080, middleware.Config.Server.Port)
+	assert.Equal(t, "test-pepper", middleware.Config.Security.Pepper)
+	assert.Equal(t, 12, middleware.Config.Security.Salt)
 }
 
 func TestMiddleware_DatabaseAccess(t *testing.T) {
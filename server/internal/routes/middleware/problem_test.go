@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/apierror"
+	"server/internal/database"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminRequired_RendersBothErrorFormats(t *testing.T) {
+	m := New(database.DB{}, nil, config.Config{}, nil, nil, nil)
+	fiberApp := fiber.New()
+	fiberApp.Get("/admin", m.AdminRequired(), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	testCases := []struct {
+		accept      string
+		contentType string
+		expected    map[string]any
+	}{
+		{fiber.MIMEApplicationJSON, fiber.MIMEApplicationJSON, map[string]any{
+			"error": "Admin access required",
+			"code":  ErrorCodeAdminRequired,
+		}},
+		{apierror.PROBLEM_CONTENT_TYPE, apierror.PROBLEM_CONTENT_TYPE, map[string]any{
+			"type":     "http://example.com" + apierror.TYPE_PATH + ErrorCodeAdminRequired,
+			"title":    "Admin access required",
+			"status":   float64(fiber.StatusForbidden),
+			"detail":   "Admin access required",
+			"instance": "/admin",
+			"code":     ErrorCodeAdminRequired,
+		}},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/admin", nil)
+		req.Header.Set(fiber.HeaderAccept, tc.accept)
+		resp, err := fiberApp.Test(req)
+		require.NoError(t, err)
+
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+		assert.Equal(t, tc.contentType, resp.Header.Get(fiber.HeaderContentType))
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, tc.expected, body)
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"math"
+	"server/internal/apierror"
+	"server/internal/clock"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const ErrorCodeRateLimited = "rate_limited"
+
+func init() {
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeRateLimited, Status: fiber.StatusTooManyRequests, Title: "Too many requests",
+	})
+}
+
+type rateWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+// rateLimiter counts requests per key in fixed windows. Counts are kept in
+// this process, so every instance allows the maximum on its own.
+type rateLimiter struct {
+	mutex     sync.Mutex
+	windows   map[string]*rateWindow
+	max       int
+	window    time.Duration
+	lastPrune time.Time
+	clock     clock.Clock
+}
+
+func newRateLimiter(max int, window time.Duration, clk clock.Clock) *rateLimiter {
+	return &rateLimiter{
+		windows: make(map[string]*rateWindow),
+		max:     max,
+		window:  window,
+		clock:   clock.OrDefault(clk),
+	}
+}
+
+// Allow counts a request for key and reports whether it is within the limit,
+// and if not, how long until the window resets.
+func (l *rateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.clock.Now()
+	l.prune(now)
+	current, ok := l.windows[key]
+	if !ok || !now.Before(current.resetAt) {
+		current = &rateWindow{resetAt: now.Add(l.window)}
+		l.windows[key] = current
+	}
+	current.count++
+	if current.count > l.max {
+		return false, current.resetAt.Sub(now)
+	}
+	return true, 0
+}
+
+// prune drops finished windows, at most once per window. Callers hold the
+// mutex.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+	l.lastPrune = now
+
+	for key, current := range l.windows {
+		if !now.Before(current.resetAt) {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// RateLimit lets each client IP make max requests to the route per window and
+// answers 429 with Retry-After beyond that.
+func (m *Middleware) RateLimit(name string, max int, window time.Duration) fiber.Handler {
+	limiter := newRateLimiter(max, window, m.clock)
+
+	return func(c *fiber.Ctx) error {
+		allowed, retryAfter := limiter.Allow(c.IP())
+		if allowed {
+			return c.Next()
+		}
+
+		m.log.Function("RateLimit").Warn("Rate limit reached", "route", name, "ip", c.IP())
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		return apierror.Send(c, apierror.New(ErrorCodeRateLimited, "Too many requests, try again later"), m.Config)
+	}
+}
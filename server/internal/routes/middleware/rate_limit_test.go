@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	limiter := newRateLimiter(2, time.Minute, fake)
+
+	allowed, _ := limiter.Allow("10.0.0.1")
+	assert.True(t, allowed)
+	allowed, _ = limiter.Allow("10.0.0.1")
+	assert.True(t, allowed)
+
+	fake.Advance(20 * time.Second)
+	allowed, retryAfter := limiter.Allow("10.0.0.1")
+	assert.False(t, allowed)
+	assert.Equal(t, 40*time.Second, retryAfter)
+
+	// Keys are counted apart
+	allowed, _ = limiter.Allow("10.0.0.2")
+	assert.True(t, allowed)
+
+	fake.Advance(40 * time.Second)
+	allowed, _ = limiter.Allow("10.0.0.1")
+	assert.True(t, allowed)
+	assert.Len(t, limiter.windows, 2)
+
+	// Finished windows are pruned
+	fake.Advance(2 * time.Minute)
+	limiter.Allow("10.0.0.3")
+	assert.Len(t, limiter.windows, 1)
+}
+
+func TestMiddleware_RateLimit(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	middleware := New(database.DB{}, &events.EventBus{}, config.Config{}, nil, nil, fake)
+
+	app := fiber.New()
+	app.Post("/limited", middleware.RateLimit("limited", 1, time.Minute), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/limited", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+
+	fake.Advance(30500 * time.Millisecond)
+	resp, err = app.Test(httptest.NewRequest("POST", "/limited", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get(fiber.HeaderRetryAfter))
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, ErrorCodeRateLimited, body["code"])
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"server/internal/clock"
+	"server/internal/database"
+	. "server/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	CACHE_STATUS_HEADER = "Cache-Status"
+	CACHE_STATUS_HIT    = "hit"
+	CACHE_STATUS_MISS   = "miss"
+	CACHE_STATUS_BYPASS = "bypass"
+
+	RESPONSE_CACHE_KEY            = "response_cache:%s:%s:%s?%s:%s"
+	RESPONSE_CACHE_GENERATION_KEY = "response_cache:%s:generation"
+)
+
+// CachedResponse is a response as the cache stores it.
+type CachedResponse struct {
+	Status      int               `json:"status"`
+	ContentType string            `json:"contentType"`
+	Headers     map[string]string `json:"headers"`
+	Body        []byte            `json:"body"`
+	ExpiresAt   time.Time         `json:"expiresAt"`
+}
+
+// ResponseCache stores whole responses of public GET routes that opt in with
+// Middleware.CacheResponse. Each route caches under a name, and Invalidate
+// drops every entry under that name on all instances at once by moving the
+// name to a new generation, since the store can't list keys.
+type ResponseCache struct {
+	store database.CacheStore
+	clock clock.Clock
+}
+
+// NewResponseCache builds a ResponseCache. A nil clock uses the wall clock.
+func NewResponseCache(store database.CacheStore, clk clock.Clock) *ResponseCache {
+	return &ResponseCache{store: store, clock: clock.OrDefault(clk)}
+}
+
+// Invalidate drops every response cached under name. A nil cache has nothing
+// to drop.
+func (r *ResponseCache) Invalidate(ctx context.Context, name string) error {
+	if r == nil {
+		return nil
+	}
+	return r.store.Set(ctx, fmt.Sprintf(RESPONSE_CACHE_GENERATION_KEY, name), uuid.New().String(), 0)
+}
+
+func (r *ResponseCache) generation(ctx context.Context, name string) (string, error) {
+	var generation string
+	err := r.store.Get(ctx, fmt.Sprintf(RESPONSE_CACHE_GENERATION_KEY, name), &generation)
+	if errors.Is(err, database.ErrCacheMiss) {
+		return "", nil
+	}
+	return generation, err
+}
+
+// CacheResponse serves a GET route from cache for up to ttl, keyed on the
+// path, query and locale. Only 200 responses are stored. Requests carrying a
+// session cookie or an Authorization header always reach the handler, as the
+// response may be theirs alone. Cache-Status says which happened. A nil cache
+// never caches anything.
+func (m *Middleware) CacheResponse(cache *ResponseCache, name string, ttl time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if cache == nil {
+			return c.Next()
+		}
+		if c.Method() != fiber.MethodGet || isAuthenticatedRequest(c) {
+			c.Set(CACHE_STATUS_HEADER, CACHE_STATUS_BYPASS)
+			return c.Next()
+		}
+
+		log := m.log.Function("CacheResponse")
+		ctx := c.Context()
+
+		generation, err := cache.generation(ctx, name)
+		if err != nil {
+			log.Er("failed to get response cache generation", err, "name", name)
+			c.Set(CACHE_STATUS_HEADER, CACHE_STATUS_BYPASS)
+			return c.Next()
+		}
+		key := fmt.Sprintf(RESPONSE_CACHE_KEY, name, generation, c.Path(), c.Request().URI().QueryString(), requestLocale(c))
+
+		var cached CachedResponse
+		err = cache.store.Get(ctx, key, &cached)
+		switch {
+		case err == nil && cache.clock.Now().Before(cached.ExpiresAt):
+			return sendCachedResponse(c, cached)
+		case err != nil && !errors.Is(err, database.ErrCacheMiss):
+			log.Er("failed to get cached response", err, "key", key)
+			c.Set(CACHE_STATUS_HEADER, CACHE_STATUS_BYPASS)
+			return c.Next()
+		}
+
+		// Only headers the handler sets belong to the cached response; the
+		// ones set before it, like the request's version, are per request
+		before := make(map[string]bool)
+		c.Response().Header.VisitAll(func(key []byte, value []byte) {
+			before[string(key)] = true
+		})
+
+		c.Set(CACHE_STATUS_HEADER, CACHE_STATUS_MISS)
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		response, ok := cacheableResponse(c, before)
+		if !ok {
+			return nil
+		}
+		response.ExpiresAt = cache.clock.Now().Add(ttl)
+		if err := cache.store.Set(ctx, key, response, ttl); err != nil {
+			log.Er("failed to cache response", err, "key", key)
+		}
+		return nil
+	}
+}
+
+func isAuthenticatedRequest(c *fiber.Ctx) bool {
+	return c.Cookies(SESSION_COOKIE_KEY) != "" || c.Get(fiber.HeaderAuthorization) != ""
+}
+
+// requestLocale is the first language in Accept-Language, normalized so
+// "en-US,en;q=0.9" and "en-us" share an entry.
+func requestLocale(c *fiber.Ctx) string {
+	locale, _, _ := strings.Cut(c.Get(fiber.HeaderAcceptLanguage), ",")
+	locale, _, _ = strings.Cut(locale, ";")
+	return strings.ToLower(strings.TrimSpace(locale))
+}
+
+// cacheableResponse copies the response the handler wrote, unless it is not
+// a 200, sets a cookie or asks not to be stored.
+func cacheableResponse(c *fiber.Ctx, before map[string]bool) (CachedResponse, bool) {
+	response := c.Response()
+	if response.StatusCode() != fiber.StatusOK ||
+		len(response.Header.Peek(fiber.HeaderSetCookie)) > 0 ||
+		strings.Contains(string(response.Header.Peek(fiber.HeaderCacheControl)), "no-store") {
+		return CachedResponse{}, false
+	}
+
+	headers := make(map[string]string)
+	response.Header.VisitAll(func(key []byte, value []byte) {
+		name := string(key)
+		switch {
+		case before[name]:
+		case name == fiber.HeaderContentType, name == fiber.HeaderContentLength, name == CACHE_STATUS_HEADER:
+		default:
+			headers[name] = string(value)
+		}
+	})
+
+	return CachedResponse{
+		Status:      response.StatusCode(),
+		ContentType: string(response.Header.ContentType()),
+		Headers:     headers,
+		Body:        append([]byte(nil), response.Body()...),
+	}, true
+}
+
+func sendCachedResponse(c *fiber.Ctx, cached CachedResponse) error {
+	for name, value := range cached.Headers {
+		c.Set(name, value)
+	}
+	c.Set(fiber.HeaderContentType, cached.ContentType)
+	c.Set(CACHE_STATUS_HEADER, CACHE_STATUS_HIT)
+	return c.Status(cached.Status).Send(cached.Body)
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	. "server/internal/models"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupResponseCacheTest caches /public for a minute. The handler counts its
+// calls and answers with the count, so a cached response repeats an old one.
+func setupResponseCacheTest(t *testing.T) (*fiber.App, *ResponseCache, *clock.Fake, *int) {
+	t.Helper()
+	fakeClock := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	cache := NewResponseCache(database.NewMemoryCacheStore(), fakeClock)
+	m := New(database.DB{}, nil, config.Config{}, nil, nil, nil)
+
+	calls := 0
+	fiberApp := fiber.New()
+	fiberApp.Use(func(c *fiber.Ctx) error {
+		c.Set("X-Request-Count", "per-request")
+		return c.Next()
+	})
+	fiberApp.Get("/public", m.CacheResponse(cache, "public", time.Minute), func(c *fiber.Ctx) error {
+		calls++
+		c.Set("X-Handler", "set")
+		if c.Query("fail") != "" {
+			return c.Status(fiber.StatusInternalServerError).SendString("failed " + strconv.Itoa(calls))
+		}
+		return c.JSON(fiber.Map{"calls": calls})
+	})
+	return fiberApp, cache, fakeClock, &calls
+}
+
+// getPublic returns the Cache-Status and body of a GET of target.
+func getPublic(t *testing.T, fiberApp *fiber.App, target string, headers map[string]string) (string, string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", target, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.Header.Get(CACHE_STATUS_HEADER), string(body)
+}
+
+func TestCacheResponse_MissThenHit(t *testing.T) {
+	fiberApp, _, _, calls := setupResponseCacheTest(t)
+
+	status, body := getPublic(t, fiberApp, "/public", nil)
+	assert.Equal(t, CACHE_STATUS_MISS, status)
+	assert.Equal(t, `{"calls":1}`, body)
+
+	req := httptest.NewRequest("GET", "/public", nil)
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+	cachedBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, CACHE_STATUS_HIT, resp.Header.Get(CACHE_STATUS_HEADER))
+	assert.Equal(t, `{"calls":1}`, string(cachedBody))
+	assert.Equal(t, fiber.MIMEApplicationJSON, resp.Header.Get(fiber.HeaderContentType))
+	assert.Equal(t, "set", resp.Header.Get("X-Handler"))
+	assert.Equal(t, "per-request", resp.Header.Get("X-Request-Count"))
+	assert.Equal(t, 1, *calls)
+}
+
+func TestCacheResponse_KeysOnQueryAndLocale(t *testing.T) {
+	fiberApp, _, _, calls := setupResponseCacheTest(t)
+
+	getPublic(t, fiberApp, "/public", map[string]string{fiber.HeaderAcceptLanguage: "en-US,en;q=0.9"})
+
+	status, _ := getPublic(t, fiberApp, "/public", map[string]string{fiber.HeaderAcceptLanguage: "en-us"})
+	assert.Equal(t, CACHE_STATUS_HIT, status, "same first language")
+
+	status, _ = getPublic(t, fiberApp, "/public", map[string]string{fiber.HeaderAcceptLanguage: "fr-FR"})
+	assert.Equal(t, CACHE_STATUS_MISS, status)
+
+	status, _ = getPublic(t, fiberApp, "/public?page=2", map[string]string{fiber.HeaderAcceptLanguage: "en-US"})
+	assert.Equal(t, CACHE_STATUS_MISS, status)
+
+	assert.Equal(t, 3, *calls)
+}
+
+func TestCacheResponse_AuthenticatedBypass(t *testing.T) {
+	testCases := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"session cookie", map[string]string{"Cookie": SESSION_COOKIE_KEY + "=session-1"}},
+		{"authorization", map[string]string{fiber.HeaderAuthorization: "Bearer token"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fiberApp, _, _, calls := setupResponseCacheTest(t)
+			getPublic(t, fiberApp, "/public", nil)
+
+			status, body := getPublic(t, fiberApp, "/public", tc.headers)
+			assert.Equal(t, CACHE_STATUS_BYPASS, status)
+			assert.Equal(t, `{"calls":2}`, body)
+
+			// Nor is what they got stored for anyone else
+			status, body = getPublic(t, fiberApp, "/public", nil)
+			assert.Equal(t, CACHE_STATUS_HIT, status)
+			assert.Equal(t, `{"calls":1}`, body)
+			assert.Equal(t, 2, *calls)
+		})
+	}
+}
+
+func TestCacheResponse_ExpiresAfterTTL(t *testing.T) {
+	fiberApp, _, fakeClock, calls := setupResponseCacheTest(t)
+	getPublic(t, fiberApp, "/public", nil)
+
+	fakeClock.Advance(time.Minute - time.Second)
+	status, _ := getPublic(t, fiberApp, "/public", nil)
+	assert.Equal(t, CACHE_STATUS_HIT, status)
+
+	fakeClock.Advance(time.Second)
+	status, body := getPublic(t, fiberApp, "/public", nil)
+	assert.Equal(t, CACHE_STATUS_MISS, status)
+	assert.Equal(t, `{"calls":2}`, body)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestCacheResponse_Invalidate(t *testing.T) {
+	fiberApp, cache, _, calls := setupResponseCacheTest(t)
+	getPublic(t, fiberApp, "/public", nil)
+	getPublic(t, fiberApp, "/public?page=2", nil)
+
+	require.NoError(t, cache.Invalidate(context.Background(), "public"))
+
+	for _, target := range []string{"/public", "/public?page=2"} {
+		status, _ := getPublic(t, fiberApp, target, nil)
+		assert.Equal(t, CACHE_STATUS_MISS, status, target)
+	}
+	status, _ := getPublic(t, fiberApp, "/public", nil)
+	assert.Equal(t, CACHE_STATUS_HIT, status)
+	assert.Equal(t, 4, *calls)
+
+	assert.NoError(t, (*ResponseCache)(nil).Invalidate(context.Background(), "public"))
+}
+
+func TestCacheResponse_OnlyStoresOK(t *testing.T) {
+	fiberApp, _, _, calls := setupResponseCacheTest(t)
+
+	for range 2 {
+		status, _ := getPublic(t, fiberApp, "/public?fail=1", nil)
+		assert.Equal(t, CACHE_STATUS_MISS, status)
+	}
+	assert.Equal(t, 2, *calls)
+}
+
+func TestCacheResponse_NilCache(t *testing.T) {
+	m := New(database.DB{}, nil, config.Config{}, nil, nil, nil)
+	fiberApp := fiber.New()
+	fiberApp.Get("/public", m.CacheResponse(nil, "public", time.Minute), func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	status, body := getPublic(t, fiberApp, "/public", nil)
+	assert.Empty(t, status)
+	assert.Equal(t, "ok", body)
+}
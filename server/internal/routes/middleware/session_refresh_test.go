@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/models"
+	"server/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// sendDueForRefresh authenticates as clientType with a session due for a
+// refresh. It returns the token the response carried and the
+// session.refreshed events published.
+func sendDueForRefresh(t *testing.T, clientType string) (*MockSessionRepository, string, <-chan events.SessionRefreshedEvent) {
+	t.Helper()
+
+	fake := clock.NewFake(lookupTestTime)
+	cfg := config.Config{Security: config.SecurityConfig{JwtSecret: "test-jwt-secret-key-for-testing"}}
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, mock.Anything).Return(&models.Session{
+		ID:        "session-1",
+		UserID:    "user-1",
+		ExpiresAt: fake.Now().Add(time.Hour),
+		RefreshAt: fake.Now().Add(-time.Minute),
+	}, nil)
+	mockSessionRepo.On("Create", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			session := args.Get(1).(*models.Session)
+			session.ID = "session-2"
+			session.Token = "refreshed-token"
+			session.ExpiresAt = fake.Now().Add(7 * 24 * time.Hour)
+		}).
+		Return(nil)
+	mockSessionRepo.On("Delete", mock.Anything, mock.Anything).Return(nil)
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").
+		Return(&models.User{BaseModel: models.BaseModel{ID: "user-1"}}, nil)
+
+	eventBus := events.New(nil, cfg, nil)
+	refreshed := make(chan events.SessionRefreshedEvent, 1)
+	require.NoError(t, eventBus.SessionRefreshedTopic().Subscribe(
+		func(ctx context.Context, event events.SessionRefreshedEvent) error {
+			refreshed <- event
+			return nil
+		},
+	))
+
+	middleware := New(database.DB{}, eventBus, cfg, mockUserRepo, mockSessionRepo, fake)
+	app := fiber.New()
+	app.Use(middleware.BasicAuth())
+	app.Get("/*", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/api/v1/users/", nil)
+	req.Header.Set(CLIENT_TYPE_HEADER, clientType)
+	req.Header.Set("Cookie", models.SESSION_COOKIE_KEY+"=session-1")
+	token, err := utils.GenerateJWTToken(uuid.NewString(), fake.Now().Add(time.Hour), cfg, fake)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", token)
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	return mockSessionRepo, resp.Header.Get("X-Auth-Token"), refreshed
+}
+
+func TestBasicAuth_RefreshReplacesSession(t *testing.T) {
+	mockSessionRepo, token, _ := sendDueForRefresh(t, WEB_CLIENT_TYPE)
+
+	mockSessionRepo.AssertCalled(t, "Create", mock.Anything, mock.MatchedBy(func(session *models.Session) bool {
+		return session.ID == "session-2"
+	}), mock.Anything)
+	mockSessionRepo.AssertCalled(t, "Delete", mock.Anything, "session-1")
+	assert.Equal(t, "refreshed-token", token)
+}
+
+func TestBasicAuth_RefreshPublishesTokenForTokenClients(t *testing.T) {
+	_, token, refreshed := sendDueForRefresh(t, MOBILE_CLIENT_TYPE)
+	assert.Equal(t, "refreshed-token", token)
+
+	select {
+	case event := <-refreshed:
+		// The expiry comes back from JSON without its location
+		assert.True(t, lookupTestTime.Add(7*24*time.Hour).Equal(event.ExpiresAt), "expires with the new session")
+		event.ExpiresAt = time.Time{}
+		assert.Equal(t, events.SessionRefreshedEvent{
+			UserID:            "user-1",
+			SessionID:         "session-2",
+			PreviousSessionID: "session-1",
+			Token:             "refreshed-token",
+		}, event)
+	case <-time.After(time.Second):
+		t.Fatal("expected a session.refreshed event")
+	}
+}
+
+func TestBasicAuth_RefreshSkipsPushForCookieClients(t *testing.T) {
+	_, _, refreshed := sendDueForRefresh(t, WEB_CLIENT_TYPE)
+
+	select {
+	case event := <-refreshed:
+		t.Fatalf("expected no session.refreshed event, got %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	. "server/internal/models"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrorCodeTermsReacceptanceRequired answers writes from users who haven't
+// accepted the current terms of service.
+const ErrorCodeTermsReacceptanceRequired = "tos_reacceptance_required"
+
+// TermsExemptRoutes stay writable for users who haven't accepted the current
+// terms, so they can accept them, sign out or leave. Written as "METHOD path"
+// like ImpersonationBlockedRoutes.
+var TermsExemptRoutes = []string{
+	"POST /users/me/accept-tos",
+	"POST /users/logout",
+	"DELETE /users/me",
+}
+
+// IsTermsExempt reports whether a request matches TermsExemptRoutes, on
+// either the versioned or the legacy prefix.
+func IsTermsExempt(method string, path string) bool {
+	for _, route := range TermsExemptRoutes {
+		routeMethod, routePath, _ := strings.Cut(route, " ")
+		if routeMethod != "*" && !strings.EqualFold(routeMethod, method) {
+			continue
+		}
+		if matchesRoute(path, routePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// termsHeld reports whether the request is a write the user may not make
+// until they accept the current terms. Reads are never held.
+func (m *Middleware) termsHeld(c *fiber.Ctx, user User) bool {
+	if m.Config.TermsVersion == "" || user.TosVersion == m.Config.TermsVersion {
+		return false
+	}
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return false
+	}
+	return !IsTermsExempt(c.Method(), c.Path())
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIsTermsExempt(t *testing.T) {
+	testCases := []struct {
+		method string
+		path   string
+		exempt bool
+	}{
+		{"POST", "/api/v1/users/me/accept-tos", true},
+		{"POST", "/api/users/me/accept-tos/", true},
+		{"POST", "/api/v1/users/logout", true},
+		{"DELETE", "/api/v1/users/me", true},
+		{"PATCH", "/api/v1/users/me", false},
+		{"GET", "/api/v1/users/me/accept-tos", false},
+		{"PUT", "/api/v1/users/me/preferences/theme", false},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.exempt, IsTermsExempt(tc.method, tc.path), tc.method+" "+tc.path)
+	}
+}
+
+func setupTermsTest(t *testing.T, termsVersion string, session *models.Session, fake *clock.Fake) *fiber.App {
+	t.Helper()
+
+	mockUserRepo := &MockUserRepository{}
+	mockUserRepo.On("GetByID", mock.Anything, "user-1").
+		Return(&models.User{BaseModel: models.BaseModel{ID: "user-1"}, TosVersion: "2024-01"}, nil)
+
+	mockSessionRepo := &MockSessionRepository{}
+	mockSessionRepo.On("GetByID", mock.Anything, session.ID).Return(session, nil)
+
+	cfg := config.Config{
+		TermsVersion: termsVersion,
+		Security:     config.SecurityConfig{JwtSecret: "test-jwt-secret-key-for-testing"},
+	}
+	middleware := New(database.DB{}, &events.EventBus{}, cfg, mockUserRepo, mockSessionRepo, fake)
+
+	app := fiber.New()
+	app.Use(middleware.BasicAuth())
+	app.All("/*", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"authenticated": c.Locals("authenticated")})
+	})
+	return app
+}
+
+func TestBasicAuth_HoldsWritesUntilTermsAccepted(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	session := &models.Session{
+		ID:        "session-1",
+		UserID:    "user-1",
+		ExpiresAt: fake.Now().Add(7 * 24 * time.Hour),
+		RefreshAt: fake.Now().Add(5 * 24 * time.Hour),
+	}
+	app := setupTermsTest(t, "2024-06", session, fake)
+
+	// Reads keep working
+	for _, method := range []string{"GET", "OPTIONS"} {
+		status, _, _ := sendAsSession(t, app, method, "/api/v1/users/", session.ID)
+		assert.Equal(t, fiber.StatusOK, status, method)
+	}
+
+	for _, route := range [][2]string{
+		{"PATCH", "/api/v1/users/me"},
+		{"POST", "/api/users/password"},
+		{"PUT", "/api/v1/users/me/preferences/theme"},
+	} {
+		status, _, body := sendAsSession(t, app, route[0], route[1], session.ID)
+		assert.Equal(t, fiber.StatusUnavailableForLegalReasons, status, route)
+		assert.Equal(t, ErrorCodeTermsReacceptanceRequired, body["code"], route)
+		assert.Equal(t, "2024-06", body["tosVersion"], route)
+	}
+
+	for _, route := range [][2]string{
+		{"POST", "/api/v1/users/me/accept-tos"},
+		{"POST", "/api/v1/users/logout"},
+		{"DELETE", "/api/v1/users/me"},
+	} {
+		status, _, body := sendAsSession(t, app, route[0], route[1], session.ID)
+		assert.Equal(t, fiber.StatusOK, status, route)
+		assert.Equal(t, true, body["authenticated"], route)
+	}
+}
+
+func TestBasicAuth_TermsNotHeld(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	session := &models.Session{
+		ID:        "session-1",
+		UserID:    "user-1",
+		ExpiresAt: fake.Now().Add(7 * 24 * time.Hour),
+		RefreshAt: fake.Now().Add(5 * 24 * time.Hour),
+	}
+
+	// The user accepted the current version, or no terms are configured
+	for _, termsVersion := range []string{"2024-01", ""} {
+		app := setupTermsTest(t, termsVersion, session, fake)
+		status, _, _ := sendAsSession(t, app, "PATCH", "/api/v1/users/me", session.ID)
+		assert.Equal(t, fiber.StatusOK, status, termsVersion)
+	}
+
+	// An admin impersonating the user isn't held back either
+	impersonation := *session
+	impersonation.ID = "impersonation-1"
+	impersonation.ImpersonatedBy = "admin-1"
+	app := setupTermsTest(t, "2024-06", &impersonation, fake)
+	status, _, _ := sendAsSession(t, app, "PATCH", "/api/v1/users/me", impersonation.ID)
+	assert.Equal(t, fiber.StatusOK, status)
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"fmt"
+	"server/internal/tracing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Tracing gives each request a server span, continuing the trace of an
+// incoming traceparent header. The span is the request's user context and is
+// also stored in its locals, so the fasthttp context handlers hand on to
+// controllers carries it too.
+func (m *Middleware) Tracing() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		parent, _ := tracing.ParseTraceparent(c.Get(tracing.TRACEPARENT_HEADER))
+		ctx, span := tracing.StartRemote(c.UserContext(), tracing.SPAN_KIND_SERVER, c.Method(), parent)
+		if span == nil {
+			return c.Next()
+		}
+		c.SetUserContext(ctx)
+		c.Locals(tracing.ContextKey, span)
+
+		err := c.Next()
+
+		status := responseStatus(c, err)
+		span.SetName(latencyRoute(c))
+		span.SetString("http.request.method", c.Method())
+		span.SetString("url.path", c.Path())
+		span.SetString("http.route", c.Route().Path)
+		span.SetInt("http.response.status_code", int64(status))
+		if status >= fiber.StatusInternalServerError {
+			if err == nil {
+				err = fmt.Errorf("answered %d", status)
+			}
+			span.SetError(err)
+		}
+		span.End()
+
+		return err
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"server/internal/logger"
+	"server/internal/tracing"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_Tracing_CorrelatesLogs(t *testing.T) {
+	recorder := &tracing.Recorder{}
+	tracer := tracing.NewTracer(recorder, 1)
+	tracing.Install(tracer)
+	t.Cleanup(func() { tracing.Install(nil) })
+
+	var buf bytes.Buffer
+	middleware := Middleware{log: logger.NewWithHandler("test", slog.NewJSONHandler(&buf, nil))}
+	app := fiber.New()
+	app.Use(middleware.Tracing())
+	app.Use(middleware.RequestLogger(nil))
+	app.Get("/users/:id", func(c *fiber.Ctx) error {
+		_, span := tracing.Start(c.Context(), "handler")
+		span.End()
+		return c.SendStatus(fiber.StatusInternalServerError)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/users/42", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusInternalServerError, resp.StatusCode)
+	require.NoError(t, tracer.Flush(context.Background()))
+
+	spans := recorder.Spans()
+	require.Len(t, spans, 2)
+	handler, request := spans[0], spans[1]
+	assert.Equal(t, "GET /users/:id", request.Name)
+	assert.Equal(t, request.SpanID, handler.ParentID, "handlers find the span through the fiber context")
+	assert.Equal(t, "answered 500", request.Error)
+	assert.Contains(t, request.Attributes, tracing.Attribute{Key: "http.route", Value: "/users/:id"})
+	assert.Contains(t, request.Attributes, tracing.Attribute{Key: "url.path", Value: "/users/42"})
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "Request failed", entry["msg"])
+	assert.Equal(t, request.TraceID.String(), entry["trace_id"])
+	assert.Equal(t, request.SpanID.String(), entry["span_id"])
+}
+
+func TestMiddleware_Tracing_Disabled(t *testing.T) {
+	tracing.Install(nil)
+
+	middleware := Middleware{log: logger.New("test")}
+	app := fiber.New()
+	app.Use(middleware.Tracing())
+	app.Get("/", func(c *fiber.Ctx) error {
+		assert.Nil(t, c.Locals(tracing.ContextKey))
+		assert.Nil(t, tracing.SpanFromContext(c.UserContext()))
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+}
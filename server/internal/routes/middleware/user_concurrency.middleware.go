@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"hash/fnv"
+	"server/internal/apierror"
+	. "server/internal/models"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	ErrorCodeTooManyConcurrentRequests = "too_many_concurrent_requests"
+
+	// Shards of the in-flight counts, so users don't contend on one mutex
+	USER_CONCURRENCY_SHARDS = 32
+)
+
+func init() {
+	apierror.Register(apierror.Definition{
+		Code:   ErrorCodeTooManyConcurrentRequests,
+		Status: fiber.StatusTooManyRequests,
+		Title:  "Too many concurrent requests",
+	})
+}
+
+type userConcurrencyShard struct {
+	mutex   sync.Mutex
+	running map[string]int
+}
+
+// UserConcurrency counts the requests each user, or each IP when signed out,
+// has running, so one client's parallel requests can't starve everyone
+// else. Counts are kept in this process, so every instance allows the limit
+// on its own.
+type UserConcurrency struct {
+	limit  int
+	shards [USER_CONCURRENCY_SHARDS]userConcurrencyShard
+}
+
+// NewUserConcurrency lets each key run limit requests at once. A limit of 0
+// or less doesn't limit.
+func NewUserConcurrency(limit int) *UserConcurrency {
+	limits := &UserConcurrency{limit: limit}
+	for i := range limits.shards {
+		limits.shards[i].running = make(map[string]int)
+	}
+	return limits
+}
+
+func (u *UserConcurrency) shard(key string) *userConcurrencyShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return &u.shards[hash.Sum32()%USER_CONCURRENCY_SHARDS]
+}
+
+// Acquire takes one of key's slots, reporting false when all are in use.
+func (u *UserConcurrency) Acquire(key string) bool {
+	shard := u.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if shard.running[key] >= u.limit {
+		return false
+	}
+	shard.running[key]++
+	return true
+}
+
+// Release hands back a slot taken with Acquire. Keys with nothing running
+// are dropped, so the map only holds active clients.
+func (u *UserConcurrency) Release(key string) {
+	shard := u.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if shard.running[key] <= 1 {
+		delete(shard.running, key)
+		return
+	}
+	shard.running[key]--
+}
+
+// Running is how many requests key has running.
+func (u *UserConcurrency) Running(key string) int {
+	shard := u.shard(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	return shard.running[key]
+}
+
+// userConcurrencyKey is the signed in user's ID, or the client IP.
+func userConcurrencyKey(c *fiber.Ctx) string {
+	if user, ok := c.Locals("user").(User); ok && user.ID != "" {
+		return "user:" + user.ID
+	}
+	return "ip:" + c.IP()
+}
+
+// LimitUserConcurrency answers 429 too_many_concurrent_requests to a user
+// that already has Server.UserConcurrency requests running. It goes after
+// BasicAuth, which tells it who the user is; signed out clients are counted
+// by IP. The slot is held until the rest of the chain returns, which for
+// handlers watching the user context is also when the client goes away.
+func (m *Middleware) LimitUserConcurrency() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if m.userConcurrency == nil || m.userConcurrency.limit <= 0 {
+			return c.Next()
+		}
+
+		key := userConcurrencyKey(c)
+		if !m.userConcurrency.Acquire(key) {
+			m.log.Function("LimitUserConcurrency").
+				Warn("Too many concurrent requests", "key", key, "path", c.Path(), "limit", m.userConcurrency.limit)
+			return apierror.Send(c, apierror.New(
+				ErrorCodeTooManyConcurrentRequests,
+				"Too many requests running at once, wait for some to finish",
+			).With("limit", m.userConcurrency.limit), m.Config)
+		}
+		defer m.userConcurrency.Release(key)
+
+		return c.Next()
+	}
+}
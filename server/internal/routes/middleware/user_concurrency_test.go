@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/database"
+	"server/internal/events"
+	. "server/internal/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowUserApp serves GET /slow as the user named in X-User, or signed out
+// without it, through a handler that runs until release is closed or the
+// request's user context is cancelled.
+type slowUserApp struct {
+	app        *fiber.App
+	middleware Middleware
+	started    chan struct{}
+	release    chan struct{}
+
+	// User contexts by X-Request-Context, to cancel running requests with
+	contexts sync.Map
+}
+
+func newSlowUserApp(t *testing.T, limit int) *slowUserApp {
+	t.Helper()
+
+	cfg := config.Config{Server: config.ServerConfig{UserConcurrency: limit}}
+	slow := &slowUserApp{
+		app:        fiber.New(),
+		middleware: New(database.DB{}, &events.EventBus{}, cfg, nil, nil, nil),
+		started:    make(chan struct{}, 10),
+		release:    make(chan struct{}),
+	}
+	slow.app.Use(func(c *fiber.Ctx) error {
+		if ctx, ok := slow.contexts.Load(c.Get("X-Request-Context")); ok {
+			c.SetUserContext(ctx.(context.Context))
+		}
+		if userID := c.Get("X-User"); userID != "" {
+			c.Locals("user", User{BaseModel: BaseModel{ID: userID}})
+		}
+		return c.Next()
+	})
+	slow.app.Get("/slow", slow.middleware.LimitUserConcurrency(), func(c *fiber.Ctx) error {
+		slow.started <- struct{}{}
+		select {
+		case <-slow.release:
+		case <-c.UserContext().Done():
+			return c.UserContext().Err()
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	return slow
+}
+
+// send makes a request in the background and delivers its response.
+func (s *slowUserApp) send(t *testing.T, userID string, contextKey string) <-chan *http.Response {
+	responses := make(chan *http.Response, 1)
+	go func() {
+		req := httptest.NewRequest("GET", "/slow", nil)
+		req.Header.Set("X-User", userID)
+		req.Header.Set("X-Request-Context", contextKey)
+		resp, err := s.app.Test(req, -1)
+		assert.NoError(t, err)
+		responses <- resp
+	}()
+	return responses
+}
+
+func (s *slowUserApp) waitStarted(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.started:
+	case <-time.After(time.Second):
+		t.Fatal("expected a request to start")
+	}
+}
+
+func assertTooManyConcurrent(t *testing.T, resp *http.Response) {
+	t.Helper()
+	require.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, ErrorCodeTooManyConcurrentRequests, body["code"])
+}
+
+func TestLimitUserConcurrency_CapPerUser(t *testing.T) {
+	slow := newSlowUserApp(t, 2)
+
+	first, second := slow.send(t, "user-1", ""), slow.send(t, "user-1", "")
+	slow.waitStarted(t)
+	slow.waitStarted(t)
+	assert.Equal(t, 2, slow.middleware.userConcurrency.Running("user:user-1"))
+
+	// The user's third is turned away rather than queued
+	assertTooManyConcurrent(t, receiveResponse(t, slow.send(t, "user-1", "")))
+
+	// Another user isn't held back by the first one's requests
+	other := slow.send(t, "user-2", "")
+	slow.waitStarted(t)
+
+	close(slow.release)
+	for _, responses := range []<-chan *http.Response{first, second, other} {
+		assert.Equal(t, fiber.StatusNoContent, receiveResponse(t, responses).StatusCode)
+	}
+	assert.Zero(t, slow.middleware.userConcurrency.Running("user:user-1"))
+	assert.Zero(t, slow.middleware.userConcurrency.Running("user:user-2"))
+
+	// Finished requests hand their slots back
+	resp := receiveResponse(t, slow.send(t, "user-1", ""))
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+}
+
+func TestLimitUserConcurrency_SignedOutByIP(t *testing.T) {
+	slow := newSlowUserApp(t, 1)
+
+	running := slow.send(t, "", "")
+	slow.waitStarted(t)
+
+	// Test requests all come from the same IP
+	assertTooManyConcurrent(t, receiveResponse(t, slow.send(t, "", "")))
+
+	// A signed in user from that IP has slots of their own
+	signedIn := slow.send(t, "user-1", "")
+	slow.waitStarted(t)
+
+	close(slow.release)
+	assert.Equal(t, fiber.StatusNoContent, receiveResponse(t, running).StatusCode)
+	assert.Equal(t, fiber.StatusNoContent, receiveResponse(t, signedIn).StatusCode)
+}
+
+func TestLimitUserConcurrency_ReleasedOnCancel(t *testing.T) {
+	slow := newSlowUserApp(t, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	slow.contexts.Store("abandoned", ctx)
+
+	abandoned := slow.send(t, "user-1", "abandoned")
+	slow.waitStarted(t)
+	assertTooManyConcurrent(t, receiveResponse(t, slow.send(t, "user-1", "")))
+
+	// The client going away ends the handler, which frees the slot
+	cancel()
+	assert.NotEqual(t, fiber.StatusNoContent, receiveResponse(t, abandoned).StatusCode)
+	assert.Zero(t, slow.middleware.userConcurrency.Running("user:user-1"))
+
+	next := slow.send(t, "user-1", "")
+	slow.waitStarted(t)
+	close(slow.release)
+	assert.Equal(t, fiber.StatusNoContent, receiveResponse(t, next).StatusCode)
+}
+
+func TestLimitUserConcurrency_Disabled(t *testing.T) {
+	slow := newSlowUserApp(t, -1)
+	close(slow.release)
+
+	for range 3 {
+		assert.Equal(t, fiber.StatusNoContent, receiveResponse(t, slow.send(t, "user-1", "")).StatusCode)
+	}
+}
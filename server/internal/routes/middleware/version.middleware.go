@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	API_PREFIX            = "/api"
+	API_VERSION_V1        = "v1"
+	API_VERSION_CURRENT   = API_VERSION_V1
+	API_VERSION_LOCALS    = "apiVersion"
+	API_LEGACY_DEPRECATED = "2026-10-15"
+)
+
+// APIVersion resolves the version from the request path into the locals for
+// GetAPIVersion. Requests on the unversioned /api alias are served as the
+// current version and marked deprecated (RFC 9745) with a Sunset (RFC 8594).
+func (m *Middleware) APIVersion() fiber.Handler {
+	deprecatedAt, _ := time.Parse(time.DateOnly, API_LEGACY_DEPRECATED)
+	deprecation := "@" + strconv.FormatInt(deprecatedAt.Unix(), 10)
+
+	sunset := ""
+	if sunsetAt, ok := m.Config.LegacyAPISunset(); ok {
+		sunset = sunsetAt.UTC().Format(http.TimeFormat)
+	}
+
+	return func(c *fiber.Ctx) error {
+		version, legacy := ExtractAPIVersion(c.Path())
+		c.Locals(API_VERSION_LOCALS, version)
+
+		if legacy {
+			c.Set("Deprecation", deprecation)
+			if sunset != "" {
+				c.Set("Sunset", sunset)
+			}
+			successor := API_PREFIX + "/" + API_VERSION_CURRENT + strings.TrimPrefix(c.Path(), API_PREFIX)
+			c.Set(fiber.HeaderLink, "<"+successor+`>; rel="successor-version"`)
+		}
+
+		return c.Next()
+	}
+}
+
+// ExtractAPIVersion reads the version segment from an /api path. Paths on
+// the unversioned alias resolve to the current version and report legacy.
+func ExtractAPIVersion(path string) (version string, legacy bool) {
+	rest, ok := strings.CutPrefix(path, API_PREFIX+"/")
+	if !ok {
+		return API_VERSION_CURRENT, true
+	}
+
+	segment, _, _ := strings.Cut(rest, "/")
+	if isVersionSegment(segment) {
+		return segment, false
+	}
+	return API_VERSION_CURRENT, true
+}
+
+// GetAPIVersion is the version APIVersion resolved for the request, so
+// handlers can branch once more than one version is served.
+func GetAPIVersion(c *fiber.Ctx) string {
+	if version, ok := c.Locals(API_VERSION_LOCALS).(string); ok {
+		return version
+	}
+	return API_VERSION_CURRENT
+}
+
+func isVersionSegment(segment string) bool {
+	digits, ok := strings.CutPrefix(segment, "v")
+	if !ok || digits == "" {
+		return false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
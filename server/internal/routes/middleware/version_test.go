@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"io"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/database"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractAPIVersion(t *testing.T) {
+	testCases := []struct {
+		path    string
+		version string
+		legacy  bool
+	}{
+		{"/api/v1/users/me", "v1", false},
+		{"/api/v2/users/me", "v2", false},
+		{"/api/v1", "v1", false},
+		{"/api/users/me", API_VERSION_CURRENT, true},
+		{"/api/vip/users", API_VERSION_CURRENT, true},
+		{"/api/v/users", API_VERSION_CURRENT, true},
+		{"/api", API_VERSION_CURRENT, true},
+	}
+
+	for _, tc := range testCases {
+		version, legacy := ExtractAPIVersion(tc.path)
+		assert.Equal(t, tc.version, version, tc.path)
+		assert.Equal(t, tc.legacy, legacy, tc.path)
+	}
+}
+
+func TestAPIVersion_SetsLocalsAndDeprecation(t *testing.T) {
+	m := New(database.DB{}, nil, config.Config{}, nil, nil, nil)
+
+	fiberApp := fiber.New()
+	fiberApp.Use("/api", m.APIVersion())
+	fiberApp.Get("/api/*", func(c *fiber.Ctx) error {
+		return c.SendString(GetAPIVersion(c))
+	})
+
+	resp, err := fiberApp.Test(httptest.NewRequest("GET", "/api/v2/things", nil))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(body))
+	assert.Empty(t, resp.Header.Get("Deprecation"))
+
+	resp, err = fiberApp.Test(httptest.NewRequest("GET", "/api/things", nil))
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, API_VERSION_CURRENT, string(body))
+	assert.Equal(t, "@1792022400", resp.Header.Get("Deprecation"))
+	assert.Empty(t, resp.Header.Get("Sunset"), "no sunset without a configured date")
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"server/internal/apierror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProblemRoutes mounts /problems/:code, which describes each error code. The
+// type URI of a problem+json error points here.
+func ProblemRoutes(router fiber.Router) {
+	router.Get("/problems/:code", func(c *fiber.Ctx) error {
+		definition, ok := apierror.Lookup(c.Params("code"))
+		if !ok {
+			return apierror.New(apierror.CODE_NOT_FOUND, "Unknown error code")
+		}
+		return c.JSON(definition)
+	})
+}
@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"server/config"
+	"server/internal/apierror"
+	"server/internal/routes/middleware"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProblemRoutes_EveryCodeResolves(t *testing.T) {
+	cfg := config.Config{Server: config.ServerConfig{ProblemJSON: true}}
+	fiberApp := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(cfg)})
+	ProblemRoutes(fiberApp.Group(middleware.API_PREFIX + "/" + middleware.API_VERSION_V1))
+	fiberApp.Get("/fail/:code", func(c *fiber.Ctx) error {
+		return apierror.New(c.Params("code"), "failed")
+	})
+
+	definitions := apierror.Definitions()
+	codes := make([]string, 0, len(definitions))
+	for _, definition := range definitions {
+		codes = append(codes, definition.Code)
+	}
+	assert.Contains(t, codes, middleware.ErrorCodeAuthUnavailable, "codes registered by other packages are included")
+
+	for _, definition := range definitions {
+		resp, err := fiberApp.Test(httptest.NewRequest("GET", "/fail/"+definition.Code, nil))
+		require.NoError(t, err)
+		var problem map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+
+		typeURI, err := url.Parse(problem["type"].(string))
+		require.NoError(t, err, definition.Code)
+
+		resp, err = fiberApp.Test(httptest.NewRequest("GET", typeURI.Path, nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode, definition.Code)
+
+		var served apierror.Definition
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&served))
+		assert.Equal(t, definition, served)
+	}
+}
+
+func TestProblemRoutes_UnknownCode(t *testing.T) {
+	fiberApp := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(config.Config{})})
+	ProblemRoutes(fiberApp)
+
+	resp, err := fiberApp.Test(httptest.NewRequest("GET", "/problems/never_registered", nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+}
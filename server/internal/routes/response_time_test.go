@@ -0,0 +1,246 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// listedPackage is the part of `go list -json` the response check reads.
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	GoFiles    []string
+	Export     string
+	DepOnly    bool
+}
+
+// listPackages lists the server's packages and everything they import, with
+// the compiler's export data for each, so they can be type checked without
+// loading dependencies from source.
+func listPackages(t *testing.T) []listedPackage {
+	t.Helper()
+
+	cmd := exec.Command("go", "list", "-export", "-deps", "-json", "server/internal/...")
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	require.NoError(t, err)
+
+	var packages []listedPackage
+	decoder := json.NewDecoder(strings.NewReader(string(output)))
+	for decoder.More() {
+		var pkg listedPackage
+		require.NoError(t, decoder.Decode(&pkg))
+		packages = append(packages, pkg)
+	}
+	return packages
+}
+
+// responseTimes type checks the server's packages and follows every value
+// handed to fiber.Ctx.JSON or a json.Encoder to the times it writes, and
+// returns those that are a time.Time rather than a models.APITime. Values
+// kept in an interface can't be followed, so every value put in a
+// map[string]any is checked where it is set.
+func responseTimes(t *testing.T) []string {
+	t.Helper()
+
+	packages := listPackages(t)
+	exports := make(map[string]string, len(packages))
+	for _, pkg := range packages {
+		exports[pkg.ImportPath] = pkg.Export
+	}
+
+	fset := token.NewFileSet()
+	imports := importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+		export, ok := exports[path]
+		if !ok || export == "" {
+			return nil, fmt.Errorf("no export data for %s", path)
+		}
+		return os.Open(export)
+	})
+
+	var found []string
+	for _, pkg := range packages {
+		if pkg.DepOnly || !strings.HasPrefix(pkg.ImportPath, "server/internal/") {
+			continue
+		}
+
+		var files []*ast.File
+		for _, name := range pkg.GoFiles {
+			file, err := parser.ParseFile(fset, filepath.Join(pkg.Dir, name), nil, 0)
+			require.NoError(t, err)
+			files = append(files, file)
+		}
+		info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+		_, err := (&types.Config{Importer: imports}).Check(pkg.ImportPath, fset, files, info)
+		require.NoError(t, err, pkg.ImportPath)
+
+		for _, file := range files {
+			ast.Inspect(file, func(node ast.Node) bool {
+				walker := timeWalker{seen: make(map[types.Type]bool)}
+				// A value is walked once, from the outermost place it is
+				// written or put in a map, so its maps aren't looked in again.
+				descend := true
+				switch node := node.(type) {
+				case *ast.CallExpr:
+					if len(node.Args) > 0 && isResponseWriter(info, node) {
+						walker.walkExpr(info, node.Args[0], "")
+						descend = false
+					}
+				case *ast.CompositeLit:
+					if isAnyMap(info.TypeOf(node)) {
+						walker.walkExpr(info, node, "")
+						descend = false
+					}
+				case *ast.AssignStmt:
+					for i, lhs := range node.Lhs {
+						index, ok := lhs.(*ast.IndexExpr)
+						if ok && len(node.Rhs) == len(node.Lhs) && isAnyMap(info.TypeOf(index.X)) {
+							walker.walkExpr(info, node.Rhs[i], "["+types.ExprString(index.Index)+"]")
+						}
+					}
+				}
+				for _, path := range walker.found {
+					found = append(found, fmt.Sprintf("%s: %s", fset.Position(node.Pos()), path))
+				}
+				return descend
+			})
+		}
+	}
+	return found
+}
+
+// isResponseWriter tells whether call is fiber.Ctx.JSON or
+// json.Encoder.Encode.
+func isResponseWriter(info *types.Info, call *ast.CallExpr) bool {
+	selector, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	receiver := info.TypeOf(selector.X)
+	if receiver == nil {
+		return false
+	}
+	switch selector.Sel.Name {
+	case "JSON":
+		return receiver.String() == "*github.com/gofiber/fiber/v2.Ctx"
+	case "Encode":
+		return receiver.String() == "*encoding/json.Encoder"
+	}
+	return false
+}
+
+type timeWalker struct {
+	seen  map[types.Type]bool
+	found []string
+}
+
+func (w *timeWalker) walkExpr(info *types.Info, expr ast.Expr, path string) {
+	if literal, ok := expr.(*ast.CompositeLit); ok && isAnyMap(info.TypeOf(literal)) {
+		for _, element := range literal.Elts {
+			if pair, ok := element.(*ast.KeyValueExpr); ok {
+				w.walkExpr(info, pair.Value, path+"["+types.ExprString(pair.Key)+"]")
+			}
+		}
+		return
+	}
+	w.walk(info.TypeOf(expr), path)
+}
+
+// walk follows typ the way encoding/json writes it.
+func (w *timeWalker) walk(typ types.Type, path string) {
+	if typ == nil {
+		return
+	}
+	if named, ok := typ.(*types.Named); ok {
+		object := named.Obj()
+		if object.Pkg() != nil {
+			switch object.Pkg().Path() + "." + object.Name() {
+			case "time.Time":
+				w.found = append(w.found, fmt.Sprintf("%s is a time.Time", strings.TrimPrefix(path, ".")))
+				return
+			case "server/internal/models.APITime":
+				return
+			}
+		}
+		// A type that writes itself is checked by its own tests
+		if method, _, _ := types.LookupFieldOrMethod(typ, true, object.Pkg(), "MarshalJSON"); method != nil {
+			return
+		}
+		if w.seen[typ] {
+			return
+		}
+		w.seen[typ] = true
+	}
+
+	switch underlying := typ.Underlying().(type) {
+	case *types.Pointer:
+		w.walk(underlying.Elem(), path)
+	case *types.Slice:
+		w.walk(underlying.Elem(), path+"[]")
+	case *types.Array:
+		w.walk(underlying.Elem(), path+"[]")
+	case *types.Map:
+		w.walk(underlying.Elem(), path+"[]")
+	case *types.Struct:
+		for i := range underlying.NumFields() {
+			field := underlying.Field(i)
+			name, _, _ := strings.Cut(reflect.StructTag(underlying.Tag(i)).Get("json"), ",")
+			if name == "-" || (!field.Exported() && !field.Embedded()) {
+				continue
+			}
+			if field.Embedded() && name == "" {
+				w.walk(field.Type(), path)
+				continue
+			}
+			if name == "" {
+				name = field.Name()
+			}
+			w.walk(field.Type(), path+"."+name)
+		}
+	}
+}
+
+// isAnyMap tells whether typ is a map[string]any, fiber.Map among them. Its
+// values are checked where they are set, as once in the map they can't be
+// told apart.
+func isAnyMap(typ types.Type) bool {
+	if typ == nil {
+		return false
+	}
+	mapType, ok := typ.Underlying().(*types.Map)
+	if !ok {
+		return false
+	}
+	key, isBasic := mapType.Key().(*types.Basic)
+	value, isInterface := mapType.Elem().Underlying().(*types.Interface)
+	return isBasic && key.Kind() == types.String && isInterface && value.Empty()
+}
+
+// TestResponses_TimesUseAPITime finds the types the handlers actually answer
+// with, rather than a list kept by hand, and fails on any time in them that
+// would be written as a bare time.Time.
+func TestResponses_TimesUseAPITime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("type checks the server")
+	}
+
+	found := responseTimes(t)
+	slices.Sort(found)
+	found = slices.Compact(found)
+	require.Empty(t, found, "response times must be models.APITime:\n%s", strings.Join(found, "\n"))
+}
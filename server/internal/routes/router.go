@@ -2,31 +2,39 @@ package routes
 
 import (
 	"server/internal/app"
-	"server/internal/logger"
 	"server/internal/routes/middleware"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
 
-type Route struct {
-	middleware middleware.Middleware
-	log        logger.Logger
-	router     fiber.Router
-}
-
+// Router mounts the API under /api/v1 and again under the deprecated /api
+// alias. The websocket endpoint and /.well-known URLs aren't versioned.
 func Router(router fiber.Router, app *app.App) (err error) {
 	setupWebSocketRoute(router, app)
+	WellKnownRoutes(router, app.Config)
+
+	router.Use(middleware.API_PREFIX, app.Middleware.ClientInfo())
+	router.Use(middleware.API_PREFIX, app.Middleware.APIVersion())
+	router.Use(middleware.API_PREFIX, app.Middleware.ClientVersion())
+	router.Use(middleware.API_PREFIX, app.Middleware.Maintenance(app.Maintenance))
 
-	api := router.Group("/api")
-	HealthRoutes(api, app.Config)
-	NewUserRoute(*app, api).Register()
-	api.Use(app.Middleware.BasicAuth())
-	NewAdminRoute(*app, api).Register()
+	// v1 goes first so its paths never reach the alias's group middleware.
+	registerAPI(router.Group(middleware.API_PREFIX+"/"+middleware.API_VERSION_V1), app)
+	registerAPI(router.Group(middleware.API_PREFIX), app)
 
 	return nil
 }
 
+func registerAPI(api fiber.Router, app *app.App) {
+	HealthRoutes(api, app.Config, app.Health, app.Maintenance)
+	ClientVersionRoutes(api, app.Config)
+	ProblemRoutes(api)
+	for _, registrar := range app.Registrars {
+		registrar.RegisterRoutes(api)
+	}
+}
+
 func setupWebSocketRoute(router fiber.Router, app *app.App) {
 	router.Use("/ws", func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
@@ -34,7 +42,7 @@ func setupWebSocketRoute(router fiber.Router, app *app.App) {
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
-	})
+	}, app.Middleware.ClientInfo(), app.Middleware.ClientVersion(), websocketOrigin(app.Config))
 	router.Get("/ws", websocket.New(func(c *websocket.Conn) {
 		app.Websocket.HandleWebSocket(c)
 	}))
@@ -4,10 +4,13 @@ import (
 	"net/http/httptest"
 	"server/config"
 	"server/internal/app"
+	adminController "server/internal/controllers/admin"
+	userController "server/internal/controllers/users"
 	"server/internal/database"
 	"server/internal/events"
 	"server/internal/routes/middleware"
 	"server/internal/websockets"
+	"strings"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
@@ -18,22 +21,29 @@ import (
 
 func setupTestApp() (*fiber.App, *app.App) {
 	testConfig := config.Config{
-		SecuritySalt:      12,
-		SecurityPepper:    "test-pepper",
-		SecurityJwtSecret: "test-jwt-secret",
-		GeneralVersion:    "1.0.0",
+		Security: config.SecurityConfig{
+			Salt:      12,
+			Pepper:    "test-pepper",
+			JwtSecret: "test-jwt-secret",
+		},
+		GeneralVersion: "1.0.0",
 	}
 	config.ConfigInstance = testConfig
 
 	mockDB := database.DB{}
 	var mockWsManager *websockets.Manager = nil
 
-	eventBus := events.New(nil, testConfig)
+	eventBus := events.New(nil, testConfig, nil)
+	mw := middleware.New(mockDB, eventBus, testConfig, nil, nil, nil)
 	testApp := &app.App{
 		Config:     testConfig,
 		Database:   mockDB,
 		Websocket:  mockWsManager,
-		Middleware: middleware.New(mockDB, eventBus, testConfig, nil, nil),
+		Middleware: mw,
+		Registrars: []app.RouteRegistrar{
+			userController.New(eventBus, nil, nil, nil, nil, nil, mw, testConfig),
+			adminController.New(eventBus, nil, nil, nil, nil, nil, nil, nil, mw, testConfig, nil),
+		},
 	}
 
 	fiberApp := fiber.New()
@@ -249,3 +259,150 @@ func TestRouter_UserRouteRegistration(t *testing.T) {
 
 	assert.True(t, userLoginFound, "User login route should be registered")
 }
+
+func TestRouter_RouteSnapshot(t *testing.T) {
+	fiberApp, testApp := setupTestApp()
+
+	err := Router(fiberApp, testApp)
+	require.NoError(t, err)
+
+	// Captured from the router before controllers registered their own
+	// routes. Additions are fine; removing or renaming an entry breaks clients.
+	expected := []string{
+		"GET /ws",
+		"GET /api/health",
+		"GET /api/client-version",
+		"GET /api/problems/:code",
+		"GET /api/users/form-token",
+		"GET /api/users/",
+		"GET /api/users/me/logins",
+		"GET /api/users/me/export",
+		"GET /api/users/sessions",
+		"GET /api/users/me/preferences",
+		"GET /api/announcements",
+		"GET /api/admin/stats",
+		"GET /api/admin/metrics",
+		"GET /api/admin/latency",
+		"GET /api/admin/users",
+		"GET /api/admin/users/:id",
+		"GET /api/admin/users/:id/logins",
+		"GET /api/admin/websocket/diagnostics/:userId",
+		"GET /api/admin/audit",
+		"GET /api/admin/audit/archives",
+		"GET /api/admin/audit/archives/:id",
+		"GET /.well-known/change-password",
+		"GET /.well-known/security.txt",
+		"HEAD /ws",
+		"HEAD /api/health",
+		"HEAD /api/client-version",
+		"HEAD /api/problems/:code",
+		"HEAD /api/users/form-token",
+		"HEAD /api/users/",
+		"HEAD /api/users/me/logins",
+		"HEAD /api/users/me/export",
+		"HEAD /api/users/sessions",
+		"HEAD /api/users/me/preferences",
+		"HEAD /api/announcements",
+		"HEAD /api/admin/stats",
+		"HEAD /api/admin/metrics",
+		"HEAD /api/admin/latency",
+		"HEAD /api/admin/users",
+		"HEAD /api/admin/users/:id",
+		"HEAD /api/admin/users/:id/logins",
+		"HEAD /api/admin/websocket/diagnostics/:userId",
+		"HEAD /api/admin/audit",
+		"HEAD /api/admin/audit/archives",
+		"HEAD /api/admin/audit/archives/:id",
+		"HEAD /.well-known/change-password",
+		"HEAD /.well-known/security.txt",
+		"POST /api/users/login",
+		"POST /api/users/register",
+		"POST /api/users/validate",
+		"POST /api/users/lookup",
+		"POST /api/users/logout",
+		"POST /api/users/stop-impersonation",
+		"POST /api/users/session/extend",
+		"POST /api/users/password",
+		"DELETE /api/users/me",
+		"POST /api/users/me/accept-tos",
+		"DELETE /api/users/sessions/:id",
+		"PUT /api/users/me/preferences/:key",
+		"DELETE /api/users/me/preferences/:key",
+		"POST /api/users/sessions/revoke-others",
+		"POST /api/admin/broadcast",
+		"POST /api/admin/maintenance",
+		"POST /api/admin/cache/flush",
+		"POST /api/admin/cache/warm",
+		"POST /api/admin/users/:id/password",
+		"POST /api/admin/users/:id/impersonate",
+		"POST /api/admin/users/:id/logout",
+		"PATCH /api/users/me",
+		"PATCH /api/admin/users/:id",
+	}
+
+	// Everything under the legacy /api alias is also served under /api/v1.
+	for _, route := range expected {
+		if method, path, _ := strings.Cut(route, " "); strings.HasPrefix(path, "/api/") {
+			expected = append(expected, method+" /api/v1"+strings.TrimPrefix(path, "/api"))
+		}
+	}
+
+	routes := make([]string, 0)
+	for _, route := range fiberApp.GetRoutes(true) {
+		routes = append(routes, route.Method+" "+route.Path)
+	}
+
+	assert.ElementsMatch(t, expected, routes)
+}
+
+func TestRouter_EachRouteOncePerMount(t *testing.T) {
+	fiberApp, testApp := setupTestApp()
+	require.NoError(t, Router(fiberApp, testApp))
+
+	seen := map[string]int{}
+	legacy, versioned := 0, 0
+	for _, route := range fiberApp.GetRoutes(true) {
+		seen[route.Method+" "+route.Path]++
+		switch {
+		case strings.HasPrefix(route.Path, "/api/v1/"):
+			versioned++
+		case strings.HasPrefix(route.Path, "/api/"):
+			legacy++
+		}
+	}
+
+	for route, count := range seen {
+		assert.Equal(t, 1, count, route)
+	}
+	assert.Equal(t, legacy, versioned)
+	assert.NotContains(t, seen, "GET /api/v1/ws", "the websocket endpoint is not versioned")
+}
+
+type stubRegistrar struct {
+	path string
+}
+
+func (s stubRegistrar) RegisterRoutes(router fiber.Router) {
+	router.Get(s.path, func(c *fiber.Ctx) error {
+		return c.SendString(s.path)
+	})
+}
+
+func TestRouter_MountsRegistrarsUnderAPI(t *testing.T) {
+	fiberApp := fiber.New()
+	testApp := &app.App{
+		Registrars: []app.RouteRegistrar{
+			stubRegistrar{path: "/first"},
+			stubRegistrar{path: "/second"},
+		},
+	}
+
+	err := Router(fiberApp, testApp)
+	require.NoError(t, err)
+
+	for _, path := range []string{"/api/first", "/api/second", "/api/v1/first", "/api/v1/second"} {
+		resp, err := fiberApp.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode, path)
+	}
+}
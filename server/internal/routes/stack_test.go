@@ -0,0 +1,202 @@
+package routes_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/app"
+	"server/internal/database"
+	"server/internal/hooks"
+	"server/internal/maintenance"
+	"server/internal/routes/middleware"
+	"server/internal/server/apptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// These go through the whole server, as the api binary serves it, rather than
+// the router alone.
+
+// versionRegistrar echoes the resolved API version.
+type versionRegistrar struct{}
+
+func (versionRegistrar) RegisterRoutes(router fiber.Router) {
+	router.Get("/version", func(c *fiber.Ctx) error {
+		return c.SendString(middleware.GetAPIVersion(c))
+	})
+}
+
+func stackConfig() config.Config {
+	return config.Config{
+		GeneralVersion: "1.0.0",
+		Server: config.ServerConfig{
+			CorsAllowOrigins: "http://localhost:3000",
+			LegacyApiSunset:  "2027-04-01",
+		},
+	}
+}
+
+func TestRouter_VersionedAndLegacyPrefixes(t *testing.T) {
+	cfg := stackConfig()
+	client := apptest.Serve(t, &app.App{
+		Config:     cfg,
+		Middleware: middleware.New(database.DB{}, nil, cfg, nil, nil, nil),
+		Registrars: []app.RouteRegistrar{versionRegistrar{}},
+	})
+
+	for _, path := range []string{"/health", "/version"} {
+		versionedResp := client.Get("/api/v1" + path)
+		legacyResp := client.Get("/api" + path)
+
+		assert.Equal(t, fiber.StatusOK, versionedResp.StatusCode, path)
+		assert.Equal(t, versionedResp.StatusCode, legacyResp.StatusCode, path)
+
+		versionedBody, err := io.ReadAll(versionedResp.Body)
+		require.NoError(t, err)
+		legacyBody, err := io.ReadAll(legacyResp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, string(versionedBody), string(legacyBody), path)
+
+		assert.Empty(t, versionedResp.Header.Get("Deprecation"), path)
+		assert.Empty(t, versionedResp.Header.Get("Sunset"), path)
+
+		assert.NotEmpty(t, legacyResp.Header.Get("Deprecation"), path)
+		assert.Equal(t, "Thu, 01 Apr 2027 00:00:00 GMT", legacyResp.Header.Get("Sunset"), path)
+		assert.Equal(t, "</api/v1"+path+`>; rel="successor-version"`, legacyResp.Header.Get("Link"), path)
+	}
+}
+
+func TestRouter_MaintenanceModeKeepsHealthUp(t *testing.T) {
+	mode, err := maintenance.New(context.Background(), database.NewMemoryCacheStore(), nil, config.Config{}, nil)
+	require.NoError(t, err)
+	_, err = mode.Set(context.Background(), true, "Back soon", "admin-1")
+	require.NoError(t, err)
+
+	cfg := stackConfig()
+	client := apptest.Serve(t, &app.App{
+		Config:      cfg,
+		Middleware:  middleware.New(database.DB{}, nil, cfg, nil, nil, nil),
+		Maintenance: mode,
+		Registrars:  []app.RouteRegistrar{versionRegistrar{}},
+	})
+
+	for _, prefix := range []string{"/api", "/api/v1"} {
+		resp := client.Get(prefix + "/version")
+		assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode, prefix)
+
+		resp = client.Get(prefix + "/health")
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode, prefix)
+
+		var health struct {
+			Maintenance maintenance.State `json:"maintenance"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+		assert.True(t, health.Maintenance.Enabled, prefix)
+		assert.Equal(t, "Back soon", health.Maintenance.Message, prefix)
+	}
+}
+
+func serveMinVersion(t *testing.T) *apptest.Client {
+	cfg := stackConfig()
+	cfg.Session.MinClientVersions = "flutter=2.3.0"
+	cfg.Session.ClientUpgradeURL = "https://example.com/download"
+
+	// No websocket manager: a connection that got past the checks would
+	// panic rather than be accepted
+	client := apptest.Serve(t, &app.App{
+		Config:     cfg,
+		Middleware: middleware.New(database.DB{}, nil, cfg, nil, nil, nil),
+	})
+	client.SetHeader(middleware.CLIENT_TYPE_HEADER, middleware.MOBILE_CLIENT_TYPE)
+	return client
+}
+
+func TestRouter_WebSocketRejectsOutdatedClients(t *testing.T) {
+	client := serveMinVersion(t)
+	client.SetHeader(middleware.CLIENT_VERSION_HEADER, "2.2.0")
+
+	req := httptest.NewRequest(fiber.MethodGet, "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	resp := client.Do(req)
+
+	assert.Equal(t, fiber.StatusUpgradeRequired, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Accept"), "the connection is never upgraded")
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, middleware.ErrorCodeClientOutdated, body["code"])
+	assert.Equal(t, "2.3.0", body["minimumVersion"])
+}
+
+func TestRouter_ClientVersion(t *testing.T) {
+	client := serveMinVersion(t)
+
+	for _, tc := range []struct {
+		version  string
+		required bool
+	}{
+		{"2.2.0", true},
+		{"2.3.0", false},
+	} {
+		client.SetHeader(middleware.CLIENT_VERSION_HEADER, tc.version)
+
+		resp := client.Get("/api/v1/client-version")
+		require.Equal(t, fiber.StatusOK, resp.StatusCode, tc.version)
+		var upgrade middleware.ClientUpgrade
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&upgrade))
+		assert.Equal(t, middleware.ClientUpgrade{
+			ClientType:     middleware.MOBILE_CLIENT_TYPE,
+			Version:        tc.version,
+			MinimumVersion: "2.3.0",
+			UpgradeURL:     "https://example.com/download",
+			Required:       tc.required,
+		}, upgrade)
+
+		// Health stays reachable either way, the rest only when up to date
+		assert.Equal(t, fiber.StatusOK, client.Get("/api/v1/health").StatusCode, tc.version)
+		status := client.Get("/api/v1/announcements").StatusCode
+		if tc.required {
+			assert.Equal(t, fiber.StatusUpgradeRequired, status, tc.version)
+		} else {
+			assert.Equal(t, fiber.StatusNotFound, status, tc.version)
+		}
+	}
+}
+
+func TestRouter_Hooks(t *testing.T) {
+	cfg := stackConfig()
+	application := &app.App{
+		Config:     cfg,
+		Middleware: middleware.New(database.DB{}, nil, cfg, nil, nil, nil),
+		Registrars: []app.RouteRegistrar{versionRegistrar{}},
+	}
+	var stages []hooks.Stage
+	for _, stage := range hooks.Stages {
+		application.RegisterHook(stage, func(ctx *hooks.Context) error {
+			stages = append(stages, ctx.Stage)
+			ctx.Fiber.Set("X-Hook-"+string(ctx.Stage), "ran")
+			return nil
+		})
+	}
+	client := apptest.Serve(t, application)
+
+	resp := client.Get("/api/v1/version")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, []hooks.Stage{hooks.PRE_ROUTE, hooks.PRE_RESPONSE}, stages, "no auth on the route, so no POST_AUTH")
+	assert.Equal(t, "ran", resp.Header.Get("X-Hook-pre_route"))
+	assert.Equal(t, "ran", resp.Header.Get("X-Hook-pre_response"))
+
+	// Outside the API too
+	stages = nil
+	resp = client.Get("/nowhere")
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, []hooks.Stage{hooks.PRE_ROUTE, hooks.ON_ERROR, hooks.PRE_RESPONSE}, stages)
+	assert.Equal(t, "ran", resp.Header.Get("X-Hook-on_error"))
+}
@@ -1,13 +1,63 @@
 package routes
 
 import (
+	"server/config"
+	"server/internal/apierror"
 	"server/internal/logger"
+	"server/internal/routes/middleware"
 	"server/internal/websockets"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
 
+const ErrorCodeOriginNotAllowed = "origin_not_allowed"
+
+func init() {
+	apierror.Register(apierror.Definition{
+		Code: ErrorCodeOriginNotAllowed, Status: fiber.StatusForbidden, Title: "Origin not allowed",
+	})
+}
+
+// websocketOrigin refuses upgrades from pages on origins CORS doesn't allow,
+// which browsers would otherwise connect from with the user's cookie. Clients
+// authenticating with a token aren't such pages and may send any origin, like
+// a desktop webview's own scheme.
+func websocketOrigin(cfg config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		client, err := middleware.GetClientInfo(c, cfg)
+		if err != nil {
+			return middleware.RejectClientInfo(c, err, cfg)
+		}
+		if client.Rejected() {
+			return middleware.RejectClientType(c, client.Type, cfg)
+		}
+		if client.Strategy == config.AUTH_STRATEGY_TOKEN {
+			return c.Next()
+		}
+
+		origin := c.Get(fiber.HeaderOrigin)
+		if origin == "" || originAllowed(origin, cfg.Server.CorsAllowOrigins) {
+			return c.Next()
+		}
+		logger.New("Routes").Function("websocketOrigin").Warn("Rejected websocket origin", "origin", origin)
+		return apierror.Send(c, apierror.New(ErrorCodeOriginNotAllowed, "Origin not allowed"), cfg)
+	}
+}
+
+// originAllowed reports whether origin is one of the comma separated allowed
+// origins, or they allow any with *.
+func originAllowed(origin string, allowed string) bool {
+	for _, candidate := range strings.Split(allowed, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.EqualFold(candidate, origin) {
+			return true
+		}
+	}
+	return false
+}
+
 func WebSocketRoutes(router fiber.Router, wsManager *websockets.Manager) {
 	router.Use("/ws", func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
@@ -2,6 +2,8 @@ package routes
 
 import (
 	"net/http/httptest"
+	"server/config"
+	"server/internal/routes/middleware"
 	"server/internal/websockets"
 	"testing"
 
@@ -76,4 +78,51 @@ func TestWebSocketRoutes_MiddlewareLogic(t *testing.T) {
 	resp, err = app.Test(req)
 	assert.NoError(t, err)
 	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
-}
\ No newline at end of file
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	cfg := config.Config{
+		Server:  config.ServerConfig{CorsAllowOrigins: "http://localhost:3010, https://app.example.com"},
+		Session: config.SessionConfig{ClientTypes: "tauri=token,admin-web=cookie"},
+	}
+	rejecting := cfg
+	rejecting.Session.UnknownClientType = config.UNKNOWN_CLIENT_TYPE_REJECT
+
+	testCases := []struct {
+		name       string
+		cfg        config.Config
+		clientType string
+		origin     string
+		status     int
+	}{
+		{"allowed origin", cfg, "", "https://app.example.com", fiber.StatusOK},
+		{"no origin", cfg, "", "", fiber.StatusOK},
+		{"foreign origin", cfg, "", "https://evil.example", fiber.StatusForbidden},
+		{"web client on foreign origin", cfg, middleware.WEB_CLIENT_TYPE, "https://evil.example", fiber.StatusForbidden},
+		{"cookie client on foreign origin", cfg, "admin-web", "https://evil.example", fiber.StatusForbidden},
+		{"mobile client is exempt", cfg, middleware.MOBILE_CLIENT_TYPE, "https://evil.example", fiber.StatusOK},
+		{"token client is exempt", cfg, "tauri", "tauri://localhost", fiber.StatusOK},
+		{"unknown client checked", cfg, "kiosk", "https://evil.example", fiber.StatusForbidden},
+		{"unknown client rejected", rejecting, "kiosk", "https://app.example.com", fiber.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/ws", websocketOrigin(tc.cfg), func(c *fiber.Ctx) error {
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/ws", nil)
+			if tc.clientType != "" {
+				req.Header.Set(middleware.CLIENT_TYPE_HEADER, tc.clientType)
+			}
+			if tc.origin != "" {
+				req.Header.Set(fiber.HeaderOrigin, tc.origin)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"server/config"
+	"server/internal/apierror"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const WELL_KNOWN_PREFIX = "/.well-known"
+
+// WellKnownRoutes mounts the /.well-known URLs password managers and security
+// researchers look for. They sit at the root, outside the versioned API, and
+// answer 404 until configured.
+func WellKnownRoutes(router fiber.Router, cfg config.Config) {
+	wellKnown := router.Group(WELL_KNOWN_PREFIX)
+
+	wellKnown.Get("/change-password", func(c *fiber.Ctx) error {
+		target, ok := changePasswordURL(cfg)
+		if !ok {
+			return apierror.New(apierror.CODE_NOT_FOUND, "No change password page configured")
+		}
+		return c.Redirect(target, fiber.StatusFound)
+	})
+
+	wellKnown.Get("/security.txt", func(c *fiber.Ctx) error {
+		body, ok := securityTxt(cfg)
+		if !ok {
+			return apierror.New(apierror.CODE_NOT_FOUND, "No security contact configured")
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+		return c.SendString(body)
+	})
+}
+
+// changePasswordURL is the web client's change password page, once the
+// client's URL is known.
+func changePasswordURL(cfg config.Config) (string, bool) {
+	if cfg.FrontendBaseURL == "" {
+		return "", false
+	}
+	path := cfg.WellKnown.ChangePasswordPath
+	if path == "" {
+		path = config.DEFAULT_CHANGE_PASSWORD_PATH
+	}
+	return strings.TrimRight(cfg.FrontendBaseURL, "/") + path, true
+}
+
+// securityTxt writes the RFC 9116 fields from config. Contact and Expires are
+// required, so nothing is served without both.
+func securityTxt(cfg config.Config) (string, bool) {
+	expires, ok := cfg.SecurityTxtExpires()
+	if cfg.WellKnown.SecurityContact == "" || !ok {
+		return "", false
+	}
+
+	var body strings.Builder
+	body.WriteString("Contact: " + cfg.WellKnown.SecurityContact + "\n")
+	body.WriteString("Expires: " + expires.UTC().Format(time.RFC3339) + "\n")
+	if cfg.WellKnown.SecurityPolicyURL != "" {
+		body.WriteString("Policy: " + cfg.WellKnown.SecurityPolicyURL + "\n")
+	}
+	return body.String(), true
+}
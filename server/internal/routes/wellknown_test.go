@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"io"
+	"net/http/httptest"
+	"server/config"
+	"server/internal/apierror"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func wellKnownApp(cfg config.Config) *fiber.App {
+	fiberApp := fiber.New(fiber.Config{ErrorHandler: apierror.Handler(cfg)})
+	WellKnownRoutes(fiberApp, cfg)
+	return fiberApp
+}
+
+func TestWellKnownRoutes_ChangePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.Config
+		expected string
+	}{
+		{"default path", config.Config{FrontendBaseURL: "https://app.example.com"}, "https://app.example.com/profile"},
+		{"trailing slash", config.Config{FrontendBaseURL: "https://app.example.com/"}, "https://app.example.com/profile"},
+		{"configured path", config.Config{
+			FrontendBaseURL: "https://example.com/app",
+			WellKnown:       config.WellKnownConfig{ChangePasswordPath: "/account/password"},
+		}, "https://example.com/app/account/password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := wellKnownApp(tt.cfg).Test(httptest.NewRequest("GET", "/.well-known/change-password", nil))
+			require.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+			assert.Equal(t, tt.expected, resp.Header.Get(fiber.HeaderLocation))
+		})
+	}
+}
+
+func TestWellKnownRoutes_SecurityTxt(t *testing.T) {
+	cfg := config.Config{WellKnown: config.WellKnownConfig{
+		SecurityContact:   "mailto:security@example.com",
+		SecurityExpires:   "2027-04-01T12:30:00+02:00",
+		SecurityPolicyURL: "https://example.com/security",
+	}}
+
+	resp, err := wellKnownApp(cfg).Test(httptest.NewRequest("GET", "/.well-known/security.txt", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get(fiber.HeaderContentType))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "Contact: mailto:security@example.com\n"+
+		"Expires: 2027-04-01T10:30:00Z\n"+
+		"Policy: https://example.com/security\n", string(body))
+}
+
+func TestSecurityTxt_ExpiryFormatting(t *testing.T) {
+	tests := []struct {
+		expires  string
+		expected string
+	}{
+		{"2027-04-01", "Expires: 2027-04-01T00:00:00Z\n"},
+		{"2027-04-01T12:30:00Z", "Expires: 2027-04-01T12:30:00Z\n"},
+		{"2027-04-01T00:30:00-05:00", "Expires: 2027-04-01T05:30:00Z\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expires, func(t *testing.T) {
+			body, ok := securityTxt(config.Config{WellKnown: config.WellKnownConfig{
+				SecurityContact: "https://example.com/report",
+				SecurityExpires: tt.expires,
+			}})
+			require.True(t, ok)
+			assert.Equal(t, "Contact: https://example.com/report\n"+tt.expected, body)
+		})
+	}
+}
+
+func TestWellKnownRoutes_Unconfigured(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		path string
+	}{
+		{"change password without frontend", config.Config{}, "/.well-known/change-password"},
+		{"security.txt without contact", config.Config{}, "/.well-known/security.txt"},
+		{"security.txt without expiry", config.Config{WellKnown: config.WellKnownConfig{
+			SecurityContact: "mailto:security@example.com",
+		}}, "/.well-known/security.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := wellKnownApp(tt.cfg).Test(httptest.NewRequest("GET", tt.path, nil))
+			require.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+		})
+	}
+}
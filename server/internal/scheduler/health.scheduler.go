@@ -0,0 +1,42 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"server/internal/health"
+	"slices"
+	"strings"
+)
+
+type schedulerChecker struct {
+	scheduler *Scheduler
+}
+
+// Health reports each job's last run: down when the scheduler isn't running,
+// degraded while any job's last run failed.
+func (s *Scheduler) Health() health.Checker {
+	return schedulerChecker{scheduler: s}
+}
+
+func (c schedulerChecker) Name() string { return "scheduler" }
+
+func (c schedulerChecker) Check(ctx context.Context) health.CheckResult {
+	runs := c.scheduler.LastRuns()
+	details := map[string]any{"jobs": runs}
+	if !c.scheduler.Running() {
+		return health.Down(errors.New("scheduler is not running"), details)
+	}
+
+	var failed []string
+	for name, run := range runs {
+		if run.Error != "" {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		slices.Sort(failed)
+		return health.Degraded(fmt.Errorf("last run failed: %s", strings.Join(failed, ", ")), details)
+	}
+	return health.OK(details)
+}
@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"server/internal/logger"
+	"server/internal/models"
+	"sync"
+	"time"
+)
+
+type Job func(ctx context.Context) error
+
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	run      Job
+}
+
+// Scheduler runs background jobs on fixed intervals. Jobs run once when the
+// scheduler starts and then every interval until Stop is called.
+type Scheduler struct {
+	jobs    []scheduledJob
+	log     logger.Logger
+	mutex   sync.Mutex
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started bool
+
+	// The last run of each job by name, guarded by mutex
+	lastRuns map[string]JobRun
+}
+
+// JobRun is the outcome of a job's most recent run.
+type JobRun struct {
+	At    models.APITime `json:"at"`
+	Error string         `json:"error,omitempty"`
+}
+
+func New() *Scheduler {
+	return &Scheduler{
+		log:      logger.New("scheduler"),
+		lastRuns: make(map[string]JobRun),
+	}
+}
+
+// Every registers a job. Jobs registered after Start are started immediately.
+func (s *Scheduler) Every(name string, interval time.Duration, job Job) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scheduled := scheduledJob{name: name, interval: interval, run: job}
+	s.jobs = append(s.jobs, scheduled)
+
+	if s.started {
+		s.launch(scheduled)
+	}
+}
+
+func (s *Scheduler) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.started {
+		return
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.started = true
+
+	s.log.Function("Start").Info("Starting scheduler", "jobs", len(s.jobs))
+	for _, job := range s.jobs {
+		s.launch(job)
+	}
+}
+
+// Stop cancels all running jobs and waits for them to return.
+func (s *Scheduler) Stop() {
+	s.mutex.Lock()
+	if !s.started {
+		s.mutex.Unlock()
+		return
+	}
+	s.started = false
+	s.cancel()
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Scheduler) launch(job scheduledJob) {
+	ctx := s.ctx
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(job.interval)
+		defer ticker.Stop()
+
+		for {
+			s.runJob(ctx, job)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job scheduledJob) {
+	log := s.log.Function("runJob")
+
+	run := JobRun{At: models.NewAPITime(time.Now())}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warn("Scheduled job panicked", "job", job.name, "panic", r)
+			run.Error = fmt.Sprintf("panicked: %v", r)
+		}
+		s.mutex.Lock()
+		s.lastRuns[job.name] = run
+		s.mutex.Unlock()
+	}()
+
+	if err := job.run(ctx); err != nil {
+		log.Er("scheduled job failed", err, "job", job.name)
+		run.Error = err.Error()
+	}
+}
+
+// Running reports whether the scheduler has been started and not stopped.
+func (s *Scheduler) Running() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.started
+}
+
+// LastRuns returns the last run of every job that has run, by name.
+func (s *Scheduler) LastRuns() map[string]JobRun {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return maps.Clone(s.lastRuns)
+}
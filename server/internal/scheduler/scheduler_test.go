@@ -0,0 +1,123 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"server/internal/health"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler_RunsJobRepeatedly(t *testing.T) {
+	s := New()
+
+	var runs atomic.Int32
+	s.Every("counter", 5*time.Millisecond, func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+
+	s.Start()
+	assert.Eventually(t, func() bool { return runs.Load() >= 3 }, time.Second, time.Millisecond)
+	s.Stop()
+
+	stopped := runs.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, runs.Load(), "jobs should not run after Stop")
+}
+
+func TestScheduler_RunsOnStart(t *testing.T) {
+	s := New()
+
+	ran := make(chan struct{}, 1)
+	s.Every("once", time.Hour, func(ctx context.Context) error {
+		ran <- struct{}{}
+		return nil
+	})
+
+	s.Start()
+	defer s.Stop()
+
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("job did not run on start")
+	}
+}
+
+func TestScheduler_ErrorsAndPanicsDoNotStopJob(t *testing.T) {
+	s := New()
+
+	var runs atomic.Int32
+	s.Every("flaky", 5*time.Millisecond, func(ctx context.Context) error {
+		if runs.Add(1)%2 == 0 {
+			panic("boom")
+		}
+		return errors.New("failed")
+	})
+
+	s.Start()
+	defer s.Stop()
+
+	assert.Eventually(t, func() bool { return runs.Load() >= 4 }, time.Second, time.Millisecond)
+}
+
+func TestScheduler_EveryAfterStart(t *testing.T) {
+	s := New()
+	s.Start()
+	defer s.Stop()
+
+	var runs atomic.Int32
+	s.Every("late", 5*time.Millisecond, func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+
+	assert.Eventually(t, func() bool { return runs.Load() >= 1 }, time.Second, time.Millisecond)
+}
+
+func TestScheduler_StopCancelsContext(t *testing.T) {
+	s := New()
+
+	cancelled := make(chan struct{})
+	s.Every("blocking", time.Hour, func(ctx context.Context) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+
+	s.Start()
+	s.Stop()
+
+	select {
+	case <-cancelled:
+	default:
+		t.Fatal("Stop should cancel the job context and wait for it")
+	}
+}
+
+func TestScheduler_StopWithoutStart(t *testing.T) {
+	s := New()
+	assert.NotPanics(t, s.Stop)
+}
+
+func TestScheduler_Health(t *testing.T) {
+	s := New()
+	checker := s.Health()
+	assert.Equal(t, health.STATUS_DOWN, checker.Check(context.Background()).Status, "not started")
+
+	s.Every("fine", time.Hour, func(ctx context.Context) error { return nil })
+	s.Every("broken", time.Hour, func(ctx context.Context) error { return errors.New("disk full") })
+	s.Start()
+	defer s.Stop()
+	assert.Eventually(t, func() bool { return len(s.LastRuns()) == 2 }, time.Second, time.Millisecond)
+
+	result := checker.Check(context.Background())
+	assert.Equal(t, health.STATUS_DEGRADED, result.Status)
+	assert.Equal(t, "last run failed: broken", result.Error)
+	assert.Equal(t, "disk full", s.LastRuns()["broken"].Error)
+	assert.Empty(t, s.LastRuns()["fine"].Error)
+}
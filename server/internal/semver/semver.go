@@ -0,0 +1,131 @@
+package semver
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// MAX_PARTS is how many dotted numbers a version may have, as in 2.4.1.7
+const MAX_PARTS = 4
+
+var ErrInvalidVersion = errors.New("invalid version")
+
+// Version is a dotted version such as 2.4.1, optionally with a prerelease
+// such as -beta.2 and build metadata such as +build.7. Missing parts count as
+// 0, so 2.4 is 2.4.0.
+type Version struct {
+	Parts      [MAX_PARTS]int
+	Prerelease []string
+	raw        string
+}
+
+// Parse reads a version from one to MAX_PARTS dotted numbers, then an
+// optional prerelease after - and build metadata after +.
+func Parse(value string) (Version, error) {
+	rest, _, _ := strings.Cut(value, "+")
+	core, prerelease, hasPrerelease := strings.Cut(rest, "-")
+
+	numbers := strings.Split(core, ".")
+	if len(numbers) > MAX_PARTS {
+		return Version{}, ErrInvalidVersion
+	}
+	version := Version{raw: value}
+	for i, number := range numbers {
+		if !isNumeric(number) {
+			return Version{}, ErrInvalidVersion
+		}
+		part, err := strconv.Atoi(number)
+		if err != nil {
+			return Version{}, ErrInvalidVersion
+		}
+		version.Parts[i] = part
+	}
+
+	if hasPrerelease {
+		version.Prerelease = strings.Split(prerelease, ".")
+		for _, identifier := range version.Prerelease {
+			if identifier == "" {
+				return Version{}, ErrInvalidVersion
+			}
+		}
+	}
+	return version, nil
+}
+
+// String is the version as it was parsed.
+func (v Version) String() string {
+	return v.raw
+}
+
+// Compare returns -1 when v is older than other, 1 when it's newer and 0
+// when they're the same version. A prerelease is older than its release, and
+// prereleases are ordered by their identifiers as semver orders them. Build
+// metadata is ignored.
+func (v Version) Compare(other Version) int {
+	for i := range v.Parts {
+		if v.Parts[i] != other.Parts[i] {
+			return compareInts(v.Parts[i], other.Parts[i])
+		}
+	}
+
+	switch {
+	case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(other.Prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.Prerelease) && i < len(other.Prerelease); i++ {
+		if order := compareIdentifiers(v.Prerelease[i], other.Prerelease[i]); order != 0 {
+			return order
+		}
+	}
+	return compareInts(len(v.Prerelease), len(other.Prerelease))
+}
+
+// Less reports whether v is older than other.
+func (v Version) Less(other Version) bool {
+	return v.Compare(other) < 0
+}
+
+// compareIdentifiers orders numeric identifiers by value and before any
+// alphanumeric one, which are ordered by their bytes.
+func compareIdentifiers(a string, b string) int {
+	aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		aValue, _ := strconv.Atoi(a)
+		bValue, _ := strconv.Atoi(b)
+		return compareInts(aValue, bValue)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isNumeric(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
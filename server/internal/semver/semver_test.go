@@ -0,0 +1,72 @@
+package semver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		older string
+		newer string
+	}{
+		{"2.2.9", "2.3.0"},
+		{"2.3.0", "2.10.0"},
+		{"2.3", "2.3.1"},
+		{"2.3.0", "2.3.0.1"},
+		{"1.9.9", "2.0.0-alpha"},
+		{"2.3.0-alpha", "2.3.0"},
+		{"2.3.0-alpha", "2.3.0-alpha.1"},
+		{"2.3.0-alpha.1", "2.3.0-alpha.beta"},
+		{"2.3.0-alpha.beta", "2.3.0-beta"},
+		{"2.3.0-beta.2", "2.3.0-beta.11"},
+		{"2.3.0-rc.1", "2.3.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.older+" < "+tt.newer, func(t *testing.T) {
+			older, err := Parse(tt.older)
+			require.NoError(t, err)
+			newer, err := Parse(tt.newer)
+			require.NoError(t, err)
+
+			assert.Equal(t, -1, older.Compare(newer))
+			assert.Equal(t, 1, newer.Compare(older))
+			assert.True(t, older.Less(newer))
+			assert.False(t, newer.Less(older))
+		})
+	}
+}
+
+func TestCompare_Equal(t *testing.T) {
+	tests := [][2]string{
+		{"2.3", "2.3.0"},
+		{"2.3.0", "2.3.0.0"},
+		{"2.3.0+build.7", "2.3.0"},
+		{"2.3.0-beta.1+ios", "2.3.0-beta.1+android"},
+		{"02.3.0", "2.3.0"},
+	}
+
+	for _, tt := range tests {
+		a, err := Parse(tt[0])
+		require.NoError(t, err)
+		b, err := Parse(tt[1])
+		require.NoError(t, err)
+		assert.Equal(t, 0, a.Compare(b), tt)
+	}
+}
+
+func TestParse(t *testing.T) {
+	version, err := Parse("2.4.1-beta.2+build.7")
+	require.NoError(t, err)
+	assert.Equal(t, [MAX_PARTS]int{2, 4, 1, 0}, version.Parts)
+	assert.Equal(t, []string{"beta", "2"}, version.Prerelease)
+	assert.Equal(t, "2.4.1-beta.2+build.7", version.String())
+
+	for _, invalid := range []string{"", "v2.3.0", "2..3", "2.3.", "1.2.3.4.5", "2.3.0-", "2.3.0-beta..1", "two"} {
+		_, err := Parse(invalid)
+		assert.ErrorIs(t, err, ErrInvalidVersion, invalid)
+	}
+}
@@ -0,0 +1,148 @@
+// Package apptest serves an app in-process, through the same server.New the
+// api binary listens with, so tests exercise every middleware and route
+// without binding a port.
+package apptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"server/internal/app"
+	"server/internal/models"
+	"server/internal/routes/middleware"
+	"server/internal/server"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// REQUEST_TIMEOUT is how long a request may take before the test fails, in
+// milliseconds as fiber's Test takes it
+const REQUEST_TIMEOUT = 5000
+
+// Client issues requests to a served app. Like a browser it keeps the cookies
+// responses set and sends them back, and headers set on it go with every
+// request.
+type Client struct {
+	t        testing.TB
+	fiberApp *fiber.App
+	cookies  map[string]string
+	header   http.Header
+}
+
+// Serve builds app's server as the api binary does and returns a client for
+// it. The test fails if the server can't be built.
+func Serve(t testing.TB, app *app.App) *Client {
+	t.Helper()
+
+	appServer, err := server.New(app)
+	require.NoError(t, err)
+
+	return &Client{
+		t:        t,
+		fiberApp: appServer.App(),
+		cookies:  make(map[string]string),
+		header:   make(http.Header),
+	}
+}
+
+// App is the served handler, for inspecting its routes.
+func (c *Client) App() *fiber.App {
+	return c.fiberApp
+}
+
+// Do sends req with the client's headers and cookies, and keeps the cookies
+// the response sets. Headers already on req win.
+func (c *Client) Do(req *http.Request) *http.Response {
+	c.t.Helper()
+
+	for name, values := range c.header {
+		if req.Header.Get(name) == "" {
+			req.Header[name] = values
+		}
+	}
+	for name, value := range c.cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+
+	resp, err := c.fiberApp.Test(req, REQUEST_TIMEOUT)
+	require.NoError(c.t, err)
+
+	for _, cookie := range resp.Cookies() {
+		// Cleared cookies are sent back empty or already expired
+		if cookie.Value == "" || cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+			continue
+		}
+		c.cookies[cookie.Name] = cookie.Value
+	}
+	return resp
+}
+
+// Request sends method to path with body encoded as JSON, or no body when
+// it's nil.
+func (c *Client) Request(method, path string, body any) *http.Response {
+	c.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		require.NoError(c.t, err)
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	return c.Do(req)
+}
+
+func (c *Client) Get(path string) *http.Response {
+	c.t.Helper()
+	return c.Request(http.MethodGet, path, nil)
+}
+
+// SetHeader sends name with every request, or stops sending it when value is
+// empty.
+func (c *Client) SetHeader(name, value string) {
+	if value == "" {
+		c.header.Del(name)
+		return
+	}
+	c.header.Set(name, value)
+}
+
+// Cookie is the value of the cookie name the client holds, empty when none.
+func (c *Client) Cookie(name string) string {
+	return c.cookies[name]
+}
+
+// SetCookie sets a cookie as if a response had, or clears it when value is
+// empty.
+func (c *Client) SetCookie(name, value string) {
+	if value == "" {
+		delete(c.cookies, name)
+		return
+	}
+	c.cookies[name] = value
+}
+
+// Session signs the client in as the web app does, with the session cookie
+// for sessionID. An empty sessionID signs it out.
+func (c *Client) Session(sessionID string) {
+	c.SetHeader(middleware.CLIENT_TYPE_HEADER, middleware.WEB_CLIENT_TYPE)
+	c.SetHeader(fiber.HeaderAuthorization, "")
+	c.SetCookie(models.SESSION_COOKIE_KEY, sessionID)
+}
+
+// Token signs the client in as the mobile app does, sending token as the
+// Authorization header. An empty token signs it out.
+func (c *Client) Token(token string) {
+	c.SetHeader(middleware.CLIENT_TYPE_HEADER, middleware.MOBILE_CLIENT_TYPE)
+	c.SetHeader(fiber.HeaderAuthorization, token)
+	c.SetCookie(models.SESSION_COOKIE_KEY, "")
+}
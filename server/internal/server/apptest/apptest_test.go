@@ -0,0 +1,103 @@
+package apptest
+
+import (
+	"io"
+	"server/config"
+	"server/internal/app"
+	"server/internal/database"
+	"server/internal/models"
+	"server/internal/routes/middleware"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cookieRegistrar sets, clears and echoes cookies and the headers the
+// session helpers send.
+type cookieRegistrar struct{}
+
+func (cookieRegistrar) RegisterRoutes(router fiber.Router) {
+	router.Post("/cookie/:name/:value", func(c *fiber.Ctx) error {
+		c.Cookie(&fiber.Cookie{Name: c.Params("name"), Value: c.Params("value")})
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	router.Delete("/cookie/:name", func(c *fiber.Ctx) error {
+		c.Cookie(&fiber.Cookie{Name: c.Params("name"), Expires: time.Now().Add(-time.Hour)})
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	router.Get("/echo", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"session":       c.Cookies(models.SESSION_COOKIE_KEY),
+			"flavor":        c.Cookies("flavor"),
+			"clientType":    c.Get(middleware.CLIENT_TYPE_HEADER),
+			"authorization": c.Get(fiber.HeaderAuthorization),
+		})
+	})
+}
+
+func serveCookies(t *testing.T) *Client {
+	cfg := config.Config{
+		GeneralVersion: "1.0.0",
+		Server:         config.ServerConfig{CorsAllowOrigins: "http://localhost:3000"},
+	}
+	return Serve(t, &app.App{
+		Config:     cfg,
+		Middleware: middleware.New(database.DB{}, nil, cfg, nil, nil, nil),
+		Registrars: []app.RouteRegistrar{cookieRegistrar{}},
+	})
+}
+
+func echo(t *testing.T, client *Client) string {
+	t.Helper()
+	resp := client.Get("/api/v1/echo")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestClient_KeepsCookies(t *testing.T) {
+	client := serveCookies(t)
+
+	resp := client.Request(fiber.MethodPost, "/api/v1/cookie/flavor/oatmeal", nil)
+	require.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "oatmeal", client.Cookie("flavor"))
+	assert.Contains(t, echo(t, client), `"flavor":"oatmeal"`)
+
+	resp = client.Request(fiber.MethodDelete, "/api/v1/cookie/flavor", nil)
+	require.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, client.Cookie("flavor"))
+	assert.Contains(t, echo(t, client), `"flavor":""`)
+}
+
+func TestClient_SessionAndToken(t *testing.T) {
+	client := serveCookies(t)
+
+	client.Session("session-1")
+	body := echo(t, client)
+	assert.Contains(t, body, `"session":"session-1"`)
+	assert.Contains(t, body, `"clientType":"`+middleware.WEB_CLIENT_TYPE+`"`)
+	assert.Contains(t, body, `"authorization":""`)
+
+	client.Token("token-1")
+	body = echo(t, client)
+	assert.Contains(t, body, `"session":""`)
+	assert.Contains(t, body, `"clientType":"`+middleware.MOBILE_CLIENT_TYPE+`"`)
+	assert.Contains(t, body, `"authorization":"token-1"`)
+
+	client.Token("")
+	assert.Contains(t, echo(t, client), `"authorization":""`)
+}
+
+func TestServe_MountsTheWholeServer(t *testing.T) {
+	client := serveCookies(t)
+
+	resp := client.Get("/api/v1/health")
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	// Set by middleware server.New adds, not the router
+	assert.Equal(t, "APIServer/1.0.0", resp.Header.Get(fiber.HeaderServer))
+	assert.NotEmpty(t, resp.Header.Get(fiber.HeaderXRequestID))
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"server/config"
+	"server/internal/events"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+var ErrCORSAllowsAll = errors.New("CORS can't allow every origin along with credentials")
+
+// reloadableCORS serves the CORS middleware built from the allowed origins,
+// and rebuilds it when a config reload changes them.
+type reloadableCORS struct {
+	handler atomic.Pointer[fiber.Handler]
+}
+
+func newReloadableCORS(origins string) *reloadableCORS {
+	c := &reloadableCORS{}
+	c.set(origins)
+	return c
+}
+
+func (c *reloadableCORS) set(origins string) {
+	handler := cors.New(cors.Config{
+		AllowOrigins:     origins,
+		AllowMethods:     "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, withCredentials, X-Response-Type, Upgrade, Connection, X-Client-Type, X-Client-Version, traceparent",
+		AllowCredentials: true,
+		MaxAge:           300,
+		ExposeHeaders: "Upgrade, X-Auth-Token, X-Impersonating, X-Request-ID, Deprecation, Sunset, Link, " +
+			"X-Session-Expires-At, X-Session-Refresh-At, X-Token-Expires-At",
+	})
+	c.handler.Store(&handler)
+}
+
+func (c *reloadableCORS) Handler(ctx *fiber.Ctx) error {
+	return (*c.handler.Load())(ctx)
+}
+
+// reload is a ConfigReloader subscriber. Credentials are allowed, so the
+// middleware refuses to allow every origin; a reload asking for that keeps
+// the origins it had.
+func (c *reloadableCORS) reload(current func() config.Config) func(ctx context.Context, event events.ConfigChangedEvent) error {
+	return func(ctx context.Context, event events.ConfigChangedEvent) error {
+		if !event.Changed(config.SETTING_SERVER_CORS_ALLOW_ORIGINS) {
+			return nil
+		}
+		origins := current().Server.CorsAllowOrigins
+		if origins == "" || origins == "*" {
+			return fmt.Errorf("%w: %q", ErrCORSAllowsAll, origins)
+		}
+		c.set(origins)
+		return nil
+	}
+}
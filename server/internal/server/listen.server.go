@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"server/config"
+	"time"
+)
+
+const (
+	HEALTH_CHECK_PATH    = "/api/v1/health"
+	HEALTH_CHECK_TIMEOUT = 3 * time.Second
+
+	// How long to wait for something to answer on an existing socket
+	// before treating it as stale
+	STALE_SOCKET_TIMEOUT = time.Second
+)
+
+var ErrSocketInUse = errors.New("socket is in use by another process")
+
+// ListenTarget is where the server accepts connections: a unix socket when
+// SocketPath is set, otherwise a TCP port.
+type ListenTarget struct {
+	Port       int
+	SocketPath string
+	SocketMode os.FileMode
+}
+
+func NewListenTarget(cfg config.Config) ListenTarget {
+	return ListenTarget{
+		Port:       cfg.Server.Port,
+		SocketPath: cfg.SocketPath(),
+		SocketMode: cfg.SocketMode(),
+	}
+}
+
+// Client returns an HTTP client that reaches the server at target, whatever
+// host the request URL names.
+func (t ListenTarget) Client() *http.Client {
+	if t.SocketPath == "" {
+		return &http.Client{Timeout: HEALTH_CHECK_TIMEOUT}
+	}
+
+	var dialer net.Dialer
+	return &http.Client{
+		Timeout: HEALTH_CHECK_TIMEOUT,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", t.SocketPath)
+			},
+		},
+	}
+}
+
+// URL is the address of path on the server at target. Requests to a socket
+// still need a host, which is ignored when dialing.
+func (t ListenTarget) URL(path string) string {
+	if t.SocketPath != "" {
+		return "http://localhost" + path
+	}
+	return fmt.Sprintf("http://localhost:%d%s", t.Port, path)
+}
+
+// HealthCheck asks the server at target for its health, failing unless it
+// answers 200.
+func HealthCheck(ctx context.Context, target ListenTarget) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.URL(HEALTH_CHECK_PATH), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := target.Client().Do(req)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check failed: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// listenUnix creates the socket at path with mode permissions. A socket left
+// behind by a process that didn't shut down cleanly is replaced; one that
+// something still answers on is not. The file is removed when the listener
+// closes, which Fiber does on shutdown.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(true)
+
+	if err := os.Chmod(path, mode); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, STALE_SOCKET_TIMEOUT)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%w: %s", ErrSocketInUse, path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"server/config"
+	"server/internal/app"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	"server/internal/routes/middleware"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// socketPath returns a path short enough for a unix socket, which is limited
+// to around 100 bytes.
+func socketPath(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "sock")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "app.sock")
+}
+
+// startSocketServer serves a health route on target and waits until it
+// answers.
+func startSocketServer(t *testing.T, target ListenTarget) (*AppServer, chan error) {
+	t.Helper()
+
+	server := &AppServer{
+		FiberApp: fiber.New(fiber.Config{DisableStartupMessage: true}),
+		log:      logger.New("test"),
+	}
+	server.FiberApp.Get(HEALTH_CHECK_PATH, func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"status": "ok"})
+	})
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.Listen(target) }()
+	require.Eventually(t, func() bool {
+		return HealthCheck(context.Background(), target) == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	return server, stopped
+}
+
+func TestAppServer_Listen_UnixSocket(t *testing.T) {
+	target := ListenTarget{SocketPath: socketPath(t), SocketMode: 0o600}
+	server, stopped := startSocketServer(t, target)
+
+	info, err := os.Stat(target.SocketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket, info.Mode().Type())
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	resp, err := target.Client().Get(target.URL(HEALTH_CHECK_PATH))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	// Shutting down removes the socket
+	require.NoError(t, server.FiberApp.ShutdownWithContext(context.Background()))
+	require.NoError(t, <-stopped)
+	assert.NoFileExists(t, target.SocketPath)
+	assert.Error(t, HealthCheck(context.Background(), target))
+}
+
+func TestAppServer_Listen_ReplacesStaleSocket(t *testing.T) {
+	path := socketPath(t)
+
+	// A socket left behind by a process that crashed
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+	require.FileExists(t, path)
+
+	target := ListenTarget{SocketPath: path, SocketMode: config.DEFAULT_SOCKET_MODE}
+	server, stopped := startSocketServer(t, target)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, config.DEFAULT_SOCKET_MODE, info.Mode().Perm())
+
+	require.NoError(t, server.FiberApp.ShutdownWithContext(context.Background()))
+	require.NoError(t, <-stopped)
+	assert.NoFileExists(t, path)
+}
+
+func TestAppServer_Listen_SocketInUse(t *testing.T) {
+	target := ListenTarget{SocketPath: socketPath(t), SocketMode: 0o600}
+	running, stopped := startSocketServer(t, target)
+	defer func() {
+		require.NoError(t, running.FiberApp.ShutdownWithContext(context.Background()))
+		<-stopped
+	}()
+
+	second := &AppServer{FiberApp: fiber.New(), log: logger.New("test")}
+	err := second.Listen(target)
+	assert.ErrorIs(t, err, ErrSocketInUse)
+
+	// The running server keeps its socket
+	assert.NoError(t, HealthCheck(context.Background(), target))
+}
+
+func TestAppServer_Listen_SocketPathNotASocket(t *testing.T) {
+	path := socketPath(t)
+	require.NoError(t, os.WriteFile(path, []byte("keep me"), 0o644))
+
+	server := &AppServer{FiberApp: fiber.New(), log: logger.New("test")}
+	assert.Error(t, server.Listen(ListenTarget{SocketPath: path, SocketMode: 0o600}))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "keep me", string(content))
+}
+
+// pathRegistrar answers GET on each of its paths with the path.
+type pathRegistrar []string
+
+func (p pathRegistrar) RegisterRoutes(router fiber.Router) {
+	for _, path := range p {
+		router.Get(path, func(c *fiber.Ctx) error {
+			return c.SendString(path)
+		})
+	}
+}
+
+// The handler App returns is the one Listen serves, so a route answered
+// in-process is answered the same over a socket.
+func TestAppServer_AppServesWhatListenServes(t *testing.T) {
+	cfg := config.Config{
+		GeneralVersion: "1.0.0",
+		Server:         config.ServerConfig{CorsAllowOrigins: "http://localhost:3000"},
+	}
+	eventBus := events.New(nil, cfg, nil)
+	mw := middleware.New(database.DB{}, eventBus, cfg, nil, nil, nil)
+	server, err := New(&app.App{
+		Config:     cfg,
+		Middleware: mw,
+		Registrars: []app.RouteRegistrar{pathRegistrar{"/first", "/second"}},
+	})
+	require.NoError(t, err)
+
+	target := ListenTarget{SocketPath: socketPath(t), SocketMode: 0o600}
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.Listen(target) }()
+	defer func() {
+		require.NoError(t, server.App().ShutdownWithContext(context.Background()))
+		<-stopped
+	}()
+	require.Eventually(t, func() bool {
+		return HealthCheck(context.Background(), target) == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Routes without parameters, and a path nothing serves
+	paths := []string{"/api/v1/nothing-here"}
+	for _, route := range server.App().GetRoutes(true) {
+		if route.Method == fiber.MethodGet && !strings.ContainsAny(route.Path, ":*") {
+			paths = append(paths, route.Path)
+		}
+	}
+	require.Contains(t, paths, "/api/v1/second")
+
+	for _, path := range paths {
+		inProcess, err := server.App().Test(httptest.NewRequest(fiber.MethodGet, path, nil))
+		require.NoError(t, err, path)
+		listened, err := target.Client().Get(target.URL(path))
+		require.NoError(t, err, path)
+		_ = listened.Body.Close()
+
+		assert.Equal(t, inProcess.StatusCode, listened.StatusCode, path)
+		assert.Equal(t, inProcess.Header.Get(fiber.HeaderServer), listened.Header.Get(fiber.HeaderServer), path)
+	}
+}
+
+func TestNewListenTarget(t *testing.T) {
+	assert.Equal(t,
+		ListenTarget{Port: 8280, SocketMode: config.DEFAULT_SOCKET_MODE},
+		NewListenTarget(config.Config{Server: config.ServerConfig{Port: 8280}}))
+	assert.Equal(t,
+		ListenTarget{Port: 8280, SocketPath: "/var/run/app.sock", SocketMode: 0o600},
+		NewListenTarget(config.Config{Server: config.ServerConfig{Port: 8280, Listen: "unix:///var/run/app.sock", SocketMode: "0600"}}))
+
+	assert.Equal(t, "http://localhost:8280/api/v1/health", ListenTarget{Port: 8280}.URL(HEALTH_CHECK_PATH))
+	assert.Equal(t, "http://localhost/api/v1/health", ListenTarget{SocketPath: "/var/run/app.sock"}.URL(HEALTH_CHECK_PATH))
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"server/internal/apierror"
 	"server/internal/app"
 	"server/internal/logger"
 	"server/internal/routes"
@@ -9,9 +10,9 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
-	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/helmet"
 	fiberLogs "github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
 
 type AppServer struct {
@@ -35,11 +36,13 @@ func New(app *app.App) (*AppServer, error) {
 		StreamRequestBody:        false,
 		EnableSplittingOnParsers: true,
 		EnableTrustedProxyCheck:  true,
+		TrustedProxies:           app.Config.TrustedProxies(),
 		ReadTimeout:              30 * time.Second,
 		WriteTimeout:             30 * time.Second,
 		IdleTimeout:              120 * time.Second,
 		DisableStartupMessage:    true,
 		EnablePrintRoutes:        false,
+		ErrorHandler:             apierror.Handler(app.Config),
 	}
 
 	if app.Config.Environment == "development" {
@@ -50,18 +53,23 @@ func New(app *app.App) (*AppServer, error) {
 
 	server := fiber.New(config)
 
-	server.Use(cors.New(cors.Config{
-		AllowOrigins:     app.Config.CorsAllowOrigins,
-		AllowMethods:     "GET, POST, PUT, PATCH, DELETE, OPTIONS",
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, withCredentials, X-Response-Type, Upgrade, Connection, X-Client-Type",
-		AllowCredentials: true,
-		MaxAge:           300,
-		ExposeHeaders:    "Upgrade, X-Auth-Token",
-	}))
+	corsHandler := newReloadableCORS(app.Config.Server.CorsAllowOrigins)
+	if app.Reloader != nil {
+		if err := app.Reloader.Subscribe(corsHandler.reload(app.Reloader.Current)); err != nil {
+			return &AppServer{}, log.Err("failed to subscribe CORS to config changes", err)
+		}
+	}
+	server.Use(corsHandler.Handler)
 
+	if app.Tracer != nil {
+		server.Use(app.Middleware.Tracing())
+	}
+	server.Use(requestid.New())
 	server.Use(fiberLogs.New())
 	server.Use(compress.New())
+	server.Use(app.Middleware.RequestLogger(app.Latency))
 	server.Use(helmet.New())
+	server.Use(app.Middleware.Hooks())
 
 	fiberApp := &AppServer{
 		FiberApp: server,
@@ -75,17 +83,36 @@ func New(app *app.App) (*AppServer, error) {
 	return fiberApp, nil
 }
 
-func (s *AppServer) Listen(port int) error {
+// App is the configured handler Listen serves, every middleware and route
+// mounted. Tests and tools call it in-process, with App().Test, without
+// binding a port.
+func (s *AppServer) App() *fiber.App {
+	return s.FiberApp
+}
+
+// Listen serves on target's unix socket when it has one, otherwise on its
+// port. It blocks until the server is shut down.
+func (s *AppServer) Listen(target ListenTarget) error {
 	log := s.log.Function("Listen")
 
-	if port == 0 {
+	if target.SocketPath != "" {
+		listener, err := listenUnix(target.SocketPath, target.SocketMode)
+		if err != nil {
+			return log.Err("Fatal error: could not listen on socket", err, "socket", target.SocketPath)
+		}
+
+		log.Info("Starting server", "socket", target.SocketPath, "mode", target.SocketMode)
+		return s.FiberApp.Listener(listener)
+	}
+
+	if target.Port == 0 {
 		return log.Err(
 			"Fatal error: invalid port",
-			fmt.Errorf("invalid port: %d", port),
-			"port", port,
+			fmt.Errorf("invalid port: %d", target.Port),
+			"port", target.Port,
 		)
 	}
 
-	log.Info("Starting server", "port", port)
-	return s.FiberApp.Listen(fmt.Sprintf(":%d", port))
+	log.Info("Starting server", "port", target.Port)
+	return s.FiberApp.Listen(fmt.Sprintf(":%d", target.Port))
 }
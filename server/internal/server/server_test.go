@@ -1,14 +1,19 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"net/http/httptest"
 	"server/config"
 	"server/internal/app"
+	"server/internal/events"
 	"server/internal/logger"
 	"testing"
 	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAppServer_StructCreation(t *testing.T) {
@@ -31,7 +36,7 @@ func TestAppServer_Listen_InvalidPort(t *testing.T) {
 	}
 
 	// Test with port 0 - should fail at port validation
-	err := server.Listen(0)
+	err := server.Listen(ListenTarget{})
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "invalid port: 0")
 
@@ -77,10 +82,12 @@ func TestNew_DevelopmentConfig(t *testing.T) {
 
 	mockApp := &app.App{
 		Config: config.Config{
-			GeneralVersion:   "1.0.0",
-			Environment:      "development",
-			CorsAllowOrigins: "http://localhost:3000",
-			ServerPort:       8080,
+			GeneralVersion: "1.0.0",
+			Environment:    "development",
+			Server: config.ServerConfig{
+				CorsAllowOrigins: "http://localhost:3000",
+				Port:             8080,
+			},
 		},
 	}
 
@@ -98,10 +105,12 @@ func TestNew_ProductionConfig(t *testing.T) {
 	// Test production configuration
 	mockApp := &app.App{
 		Config: config.Config{
-			GeneralVersion:   "1.0.0",
-			Environment:      "production",
-			CorsAllowOrigins: "https://example.com",
-			ServerPort:       80,
+			GeneralVersion: "1.0.0",
+			Environment:    "production",
+			Server: config.ServerConfig{
+				CorsAllowOrigins: "https://example.com",
+				Port:             80,
+			},
 		},
 	}
 
@@ -189,7 +198,7 @@ func TestAppServer_Listen_EdgeCases(t *testing.T) {
 	invalidPorts := []int{0}
 
 	for _, port := range invalidPorts {
-		err := server.Listen(port)
+		err := server.Listen(ListenTarget{Port: port})
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "invalid port")
 	}
@@ -234,10 +243,12 @@ func TestAppServer_NilLogger(t *testing.T) {
 func TestConfig_EmptyValues(t *testing.T) {
 	// Test handling of empty configuration values
 	emptyConfig := config.Config{
-		GeneralVersion:   "",
-		Environment:      "",
-		CorsAllowOrigins: "http://localhost:3000", // Can't be empty due to CORS security
-		ServerPort:       0,
+		GeneralVersion: "",
+		Environment:    "",
+		Server: config.ServerConfig{
+			CorsAllowOrigins: "http://localhost:3000", // Can't be empty due to CORS security
+			Port:             0,
+		},
 	}
 
 	mockApp := &app.App{
@@ -256,10 +267,12 @@ func TestConfig_EmptyValues(t *testing.T) {
 func TestConfig_SpecialCharacters(t *testing.T) {
 	// Test configuration with special characters
 	specialConfig := config.Config{
-		GeneralVersion:   "1.0.0-β+测试",
-		Environment:      "test-环境",
-		CorsAllowOrigins: "https://测试.example.com,https://app-β.test",
-		ServerPort:       8080,
+		GeneralVersion: "1.0.0-β+测试",
+		Environment:    "test-环境",
+		Server: config.ServerConfig{
+			CorsAllowOrigins: "https://测试.example.com,https://app-β.test",
+			Port:             8080,
+		},
 	}
 
 	mockApp := &app.App{
@@ -332,3 +345,50 @@ func TestBufferSizes_Values(t *testing.T) {
 	assert.True(t, writeBufferSize <= 1024*1024) // Max 1MB
 	assert.True(t, bodyLimit <= 100*1024*1024)   // Max 100MB
 }
+
+func TestReloadableCORS(t *testing.T) {
+	current := config.Config{Server: config.ServerConfig{CorsAllowOrigins: "https://app.example.com"}}
+	corsHandler := newReloadableCORS(current.Server.CorsAllowOrigins)
+	fiberApp := fiber.New()
+	fiberApp.Use(corsHandler.Handler)
+	fiberApp.Get("/", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+
+	allowed := func(origin string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(fiber.HeaderOrigin, origin)
+		resp, err := fiberApp.Test(req)
+		require.NoError(t, err)
+		return resp.Header.Get(fiber.HeaderAccessControlAllowOrigin)
+	}
+	assert.Equal(t, "https://app.example.com", allowed("https://app.example.com"))
+	assert.Empty(t, allowed("https://admin.example.com"))
+
+	reload := corsHandler.reload(func() config.Config { return current })
+	changed := events.ConfigChangedEvent{Changes: []config.Change{{Field: config.SETTING_SERVER_CORS_ALLOW_ORIGINS}}}
+
+	current.Server.CorsAllowOrigins = "https://admin.example.com"
+	require.NoError(t, reload(context.Background(), changed))
+	assert.Empty(t, allowed("https://app.example.com"))
+	assert.Equal(t, "https://admin.example.com", allowed("https://admin.example.com"))
+
+	current.Server.CorsAllowOrigins = "*"
+	assert.ErrorIs(t, reload(context.Background(), changed), ErrCORSAllowsAll)
+	assert.Equal(t, "https://admin.example.com", allowed("https://admin.example.com"), "a refused reload keeps the origins")
+}
+
+func TestReloadableCORS_ExposesExpiryHeaders(t *testing.T) {
+	corsHandler := newReloadableCORS("https://app.example.com")
+	fiberApp := fiber.New()
+	fiberApp.Use(corsHandler.Handler)
+	fiberApp.Get("/", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(fiber.HeaderOrigin, "https://app.example.com")
+	resp, err := fiberApp.Test(req)
+	require.NoError(t, err)
+
+	exposed := resp.Header.Get(fiber.HeaderAccessControlExposeHeaders)
+	for _, header := range []string{"X-Auth-Token", "X-Session-Expires-At", "X-Session-Refresh-At", "X-Token-Expires-At"} {
+		assert.Contains(t, exposed, header)
+	}
+}
@@ -0,0 +1,179 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OTLP_TRACES_PATH is where an OTLP/HTTP collector takes traces.
+const OTLP_TRACES_PATH = "/v1/traces"
+
+const otlpStatusError = 2
+
+// OTLPExporter posts spans to a collector as OTLP/HTTP JSON.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+// NewOTLPExporter sends to endpoint, adding OTLP_TRACES_PATH when it has no
+// path of its own. Spans are reported as coming from serviceName.
+func NewOTLPExporter(endpoint string, serviceName string) (*OTLPExporter, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("tracing endpoint %q is not an http(s) URL", endpoint)
+	}
+	if parsed.Path == "" || parsed.Path == "/" {
+		parsed.Path = OTLP_TRACES_PATH
+	}
+
+	return &OTLPExporter{
+		endpoint:    parsed.String(),
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("trace collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP JSON encoding: IDs in hex, and 64 bit integers as strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              SpanKind        `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+func (e *OTLPExporter) request(spans []SpanData) otlpRequest {
+	encoded := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		encoded[i] = otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+		}
+		if span.ParentID.IsValid() {
+			encoded[i].ParentSpanID = span.ParentID.String()
+		}
+		if span.Error != "" {
+			encoded[i].Status = &otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes([]Attribute{
+			{Key: "service.name", Value: e.serviceName},
+		})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "server"}, Spans: encoded}},
+	}}}
+}
+
+func otlpAttributes(attributes []Attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		var value map[string]any
+		switch v := attribute.Value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+	return encoded
+}
+
+// Recorder keeps exported spans in memory, for tests.
+type Recorder struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func (r *Recorder) Export(ctx context.Context, spans []SpanData) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// Spans are the spans exported so far, in the order they ended.
+func (r *Recorder) Spans() []SpanData {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]SpanData(nil), r.spans...)
+}
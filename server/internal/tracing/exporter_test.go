@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOTLPExporter_Endpoint(t *testing.T) {
+	exporter, err := NewOTLPExporter("http://collector:4318", "app_api")
+	require.NoError(t, err)
+	assert.Equal(t, "http://collector:4318/v1/traces", exporter.endpoint)
+
+	exporter, err = NewOTLPExporter("https://collector/custom/traces", "app_api")
+	require.NoError(t, err)
+	assert.Equal(t, "https://collector/custom/traces", exporter.endpoint)
+
+	for _, endpoint := range []string{"collector:4318", "grpc://collector:4317", "http://"} {
+		_, err := NewOTLPExporter(endpoint, "app_api")
+		assert.Error(t, err, endpoint)
+	}
+}
+
+func TestOTLPExporter_Export(t *testing.T) {
+	var body map[string]any
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, OTLP_TRACES_PATH, r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		raw, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(raw, &body))
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(collector.URL, "app_api")
+	require.NoError(t, err)
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	start := time.Unix(1700000000, 0)
+	require.NoError(t, exporter.Export(context.Background(), []SpanData{{
+		Name:     "GET /api/v1/users",
+		Kind:     SPAN_KIND_SERVER,
+		TraceID:  parent.TraceID,
+		SpanID:   SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		ParentID: parent.SpanID,
+		Start:    start,
+		End:      start.Add(time.Millisecond),
+		Attributes: []Attribute{
+			{Key: "http.response.status_code", Value: int64(500)},
+			{Key: "cache.hit", Value: false},
+		},
+		Error: "answered 500",
+	}}))
+
+	resourceSpans := body["resourceSpans"].([]any)[0].(map[string]any)
+	resource := resourceSpans["resource"].(map[string]any)["attributes"].([]any)[0].(map[string]any)
+	assert.Equal(t, "service.name", resource["key"])
+	assert.Equal(t, map[string]any{"stringValue": "app_api"}, resource["value"])
+
+	span := resourceSpans["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0].(map[string]any)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span["traceId"])
+	assert.Equal(t, "0102030405060708", span["spanId"])
+	assert.Equal(t, "00f067aa0ba902b7", span["parentSpanId"])
+	assert.Equal(t, float64(SPAN_KIND_SERVER), span["kind"])
+	assert.Equal(t, "1700000000000000000", span["startTimeUnixNano"])
+	assert.Equal(t, "1700000000001000000", span["endTimeUnixNano"])
+	assert.Equal(t, []any{
+		map[string]any{"key": "http.response.status_code", "value": map[string]any{"intValue": "500"}},
+		map[string]any{"key": "cache.hit", "value": map[string]any{"boolValue": false}},
+	}, span["attributes"])
+	assert.Equal(t, map[string]any{"code": float64(otlpStatusError), "message": "answered 500"}, span["status"])
+}
+
+func TestOTLPExporter_CollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(collector.URL, "app_api")
+	require.NoError(t, err)
+	assert.Error(t, exporter.Export(context.Background(), []SpanData{{Name: "work"}}))
+}
+
+type failingExporter struct{}
+
+func (failingExporter) Export(ctx context.Context, spans []SpanData) error {
+	return errors.New("collector down")
+}
+
+func TestTracer_DropsWhenExportFails(t *testing.T) {
+	tracer := NewTracer(failingExporter{}, 1)
+	Install(tracer)
+	t.Cleanup(func() { Install(nil) })
+
+	dropped := SpansDropped.Value()
+	for range 3 {
+		_, span := Start(context.Background(), "work")
+		span.End()
+	}
+	assert.Error(t, tracer.Flush(context.Background()))
+	assert.Equal(t, dropped+3, SpansDropped.Value())
+	assert.NoError(t, tracer.Flush(context.Background()), "failed spans are not retried")
+}
+
+func TestTracer_ShutdownFlushes(t *testing.T) {
+	recorder := &Recorder{}
+	tracer := NewTracer(recorder, 1)
+	tracer.FlushEvery(time.Hour, nil)
+	Install(tracer)
+	t.Cleanup(func() { Install(nil) })
+
+	_, span := Start(context.Background(), "work")
+	span.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+	assert.Len(t, recorder.Spans(), 1)
+
+	var nilTracer *Tracer
+	assert.NoError(t, nilTracer.Shutdown(context.Background()))
+}
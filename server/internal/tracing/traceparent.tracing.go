@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+)
+
+// TRACEPARENT_HEADER carries a W3C trace context between processes.
+const TRACEPARENT_HEADER = "traceparent"
+
+const (
+	traceparentLength = len("00-") + 32 + len("-") + 16 + len("-") + 2
+	flagSampled       = 0x01
+)
+
+// ParseTraceparent reads a version 00 traceparent. Later versions are read
+// by their version 00 fields, as the spec asks.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	if len(value) < traceparentLength || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return SpanContext{}, false
+	}
+	version, ok := decodeLowerHex(value[0:2])
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(value) != traceparentLength) {
+		return SpanContext{}, false
+	}
+	if len(value) > traceparentLength && value[traceparentLength] != '-' {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	traceID, ok := decodeLowerHex(value[3:35])
+	if !ok {
+		return SpanContext{}, false
+	}
+	spanID, ok := decodeLowerHex(value[36:52])
+	if !ok {
+		return SpanContext{}, false
+	}
+	flags, ok := decodeLowerHex(value[53:55])
+	if !ok {
+		return SpanContext{}, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&flagSampled != 0
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// decodeLowerHex decodes value, refusing the upper case digits the spec
+// leaves out.
+func decodeLowerHex(value string) ([]byte, bool) {
+	for i := range len(value) {
+		if c := value[i]; c >= 'A' && c <= 'F' {
+			return nil, false
+		}
+	}
+	decoded, err := hex.DecodeString(value)
+	return decoded, err == nil
+}
+
+// Traceparent formats sc for the traceparent header, empty when it isn't
+// valid.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Traceparent is the traceparent of the current span of ctx, empty when
+// there is none.
+func Traceparent(ctx context.Context) string {
+	return SpanFromContext(ctx).SpanContext().Traceparent()
+}
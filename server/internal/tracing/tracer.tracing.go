@@ -0,0 +1,182 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"server/internal/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	// Finished spans are exported in batches of this many, or sooner by
+	// FlushEvery
+	TRACING_BATCH_SIZE = 512
+
+	// Spans finished while this many wait for an exporter that is down are
+	// dropped rather than held
+	TRACING_MAX_PENDING = 4096
+)
+
+var (
+	SpansExported = metrics.NewCounter(
+		"tracing_spans_exported_total", "Spans handed to the trace exporter.",
+	)
+	SpansDropped = metrics.NewCounter(
+		"tracing_spans_dropped_total", "Spans dropped because the exporter failed or fell behind.",
+	)
+)
+
+func init() {
+	metrics.Register(SpansExported)
+	metrics.Register(SpansDropped)
+}
+
+// Exporter sends finished spans to a collector.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Tracer samples new traces and batches their finished spans for its
+// exporter.
+type Tracer struct {
+	exporter  Exporter
+	threshold uint64
+	now       func() time.Time
+
+	mutex   sync.Mutex
+	pending []SpanData
+	flushes sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTracer samples ratio of new traces, from 0 for none to 1 for all. A
+// trace continued from another process keeps the sampling decision made
+// there.
+func NewTracer(exporter Exporter, ratio float64) *Tracer {
+	return &Tracer{
+		exporter:  exporter,
+		threshold: sampleThreshold(ratio),
+		now:       time.Now,
+	}
+}
+
+// sampleThreshold is what the low bits of a trace ID are compared against,
+// so every process sampling at the same ratio picks the same traces.
+func sampleThreshold(ratio float64) uint64 {
+	switch {
+	case ratio >= 1:
+		return math.MaxUint64
+	case ratio <= 0:
+		return 0
+	}
+	return uint64(ratio * (1 << 63))
+}
+
+func (t *Tracer) sampled(traceID TraceID) bool {
+	if t.threshold == math.MaxUint64 {
+		return true
+	}
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < t.threshold
+}
+
+func (t *Tracer) start(ctx context.Context, kind SpanKind, name string, parent SpanContext) (context.Context, *Span) {
+	span := &Span{tracer: t}
+	if parent.IsValid() {
+		span.context = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+	} else {
+		traceID := newTraceID()
+		span.context = SpanContext{TraceID: traceID, SpanID: newSpanID(), Sampled: t.sampled(traceID)}
+	}
+
+	if span.context.Sampled {
+		span.recording = true
+		span.data = SpanData{
+			Name:     name,
+			Kind:     kind,
+			TraceID:  span.context.TraceID,
+			SpanID:   span.context.SpanID,
+			ParentID: parent.SpanID,
+			Start:    t.now(),
+		}
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) record(data SpanData) {
+	t.mutex.Lock()
+	if len(t.pending) >= TRACING_MAX_PENDING {
+		t.mutex.Unlock()
+		SpansDropped.Add(1)
+		return
+	}
+	t.pending = append(t.pending, data)
+	full := len(t.pending) >= TRACING_BATCH_SIZE
+	t.mutex.Unlock()
+
+	if full {
+		go func() { _ = t.Flush(context.Background()) }()
+	}
+}
+
+// Flush exports the spans finished so far. Spans the exporter fails to take
+// are dropped.
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.flushes.Lock()
+	defer t.flushes.Unlock()
+
+	t.mutex.Lock()
+	batch := t.pending
+	t.pending = nil
+	t.mutex.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := t.exporter.Export(ctx, batch); err != nil {
+		SpansDropped.Add(uint64(len(batch)))
+		return err
+	}
+	SpansExported.Add(uint64(len(batch)))
+	return nil
+}
+
+// FlushEvery flushes in the background every interval until Shutdown,
+// reporting failed exports to onError.
+func (t *Tracer) FlushEvery(interval time.Duration, onError func(error)) {
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				if err := t.Flush(context.Background()); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Shutdown stops the background flushes and exports what is left. It is safe
+// on a nil tracer.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	if t.stop != nil {
+		close(t.stop)
+		<-t.done
+		t.stop = nil
+	}
+	return t.Flush(ctx)
+}
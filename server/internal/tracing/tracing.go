@@ -0,0 +1,265 @@
+// Package tracing records spans of the work done for a request, shaped like
+// OpenTelemetry's so any OTLP collector takes them. Nothing is recorded
+// until a Tracer is installed: Start then returns the context unchanged and a
+// nil span, whose methods do nothing, so a deployment without tracing pays
+// for a pointer load per span and nothing more.
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id TraceID) IsValid() bool  { return id != TraceID{} }
+
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+// SpanKind values are OTLP's.
+type SpanKind int
+
+const (
+	SPAN_KIND_INTERNAL SpanKind = 1
+	SPAN_KIND_SERVER   SpanKind = 2
+	SPAN_KIND_CLIENT   SpanKind = 3
+	SPAN_KIND_PRODUCER SpanKind = 4
+	SPAN_KIND_CONSUMER SpanKind = 5
+)
+
+// SpanContext identifies a span across process boundaries, as carried by a
+// traceparent header.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// SpanData is a finished span as exporters see it. Error is empty unless the
+// span failed.
+type SpanData struct {
+	Name       string
+	Kind       SpanKind
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Error      string
+}
+
+// Span is one unit of work in a trace. Spans that aren't sampled, and spans
+// standing in for a parent in another process, carry their IDs so children
+// join the trace, but record nothing. Every method is safe on a nil span.
+type Span struct {
+	tracer    *Tracer
+	context   SpanContext
+	recording bool
+
+	mutex sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type contextKey struct{}
+
+// ContextKey is the key the current span is stored under in a context. Fiber
+// handlers pass the request on as their context, which reads its values from
+// the request's locals, so the middleware stores the span there under it too.
+var ContextKey any = contextKey{}
+
+var installed atomic.Pointer[Tracer]
+
+// Install makes tracer the one Start records with. A nil tracer turns
+// tracing off again.
+func Install(tracer *Tracer) {
+	installed.Store(tracer)
+}
+
+// Enabled reports whether a tracer is installed.
+func Enabled() bool {
+	return installed.Load() != nil
+}
+
+// Start begins an internal span, a child of the span in ctx if there is one,
+// and returns a context carrying it. End it with End or EndError.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, SPAN_KIND_INTERNAL, name)
+}
+
+func StartKind(ctx context.Context, kind SpanKind, name string) (context.Context, *Span) {
+	tracer := installed.Load()
+	if tracer == nil {
+		return ctx, nil
+	}
+	return tracer.start(ctx, kind, name, SpanFromContext(ctx).SpanContext())
+}
+
+// StartRemote begins a span continuing parent from another process, or a new
+// trace when parent isn't valid.
+func StartRemote(ctx context.Context, kind SpanKind, name string, parent SpanContext) (context.Context, *Span) {
+	tracer := installed.Load()
+	if tracer == nil {
+		return ctx, nil
+	}
+	return tracer.start(ctx, kind, name, parent)
+}
+
+// SpanFromContext is the current span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(contextKey{}).(*Span)
+	return span
+}
+
+// ContextWithSpan returns ctx with span as its current span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, span)
+}
+
+// ContextWithRemote returns ctx with parent, from another process, as its
+// current span. Nothing is added while tracing is off or parent isn't valid.
+func ContextWithRemote(ctx context.Context, parent SpanContext) context.Context {
+	if !Enabled() || !parent.IsValid() {
+		return ctx
+	}
+	return ContextWithSpan(ctx, &Span{context: parent})
+}
+
+// TraceIDs are the hex trace and span IDs of the current span of ctx, empty
+// when there is none.
+func TraceIDs(ctx context.Context) (traceID string, spanID string) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return "", ""
+	}
+	return sc.TraceID.String(), sc.SpanID.String()
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// Recording reports whether the span will be exported.
+func (s *Span) Recording() bool {
+	return s != nil && s.recording
+}
+
+// SetName renames the span, for a name only known once the work is done,
+// such as the route a request matched.
+func (s *Span) SetName(name string) {
+	if !s.Recording() {
+		return
+	}
+	s.mutex.Lock()
+	s.data.Name = name
+	s.mutex.Unlock()
+}
+
+// SetString, SetInt and SetBool are typed so a nil span costs the caller no
+// conversion to any.
+func (s *Span) SetString(key string, value string) {
+	if s.Recording() {
+		s.setAttribute(key, value)
+	}
+}
+
+func (s *Span) SetInt(key string, value int64) {
+	if s.Recording() {
+		s.setAttribute(key, value)
+	}
+}
+
+func (s *Span) SetBool(key string, value bool) {
+	if s.Recording() {
+		s.setAttribute(key, value)
+	}
+}
+
+func (s *Span) setAttribute(key string, value any) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.data.Attributes {
+		if s.data.Attributes[i].Key == key {
+			s.data.Attributes[i].Value = value
+			return
+		}
+	}
+	s.data.Attributes = append(s.data.Attributes, Attribute{Key: key, Value: value})
+}
+
+// SetError marks the span failed with err. A nil err changes nothing.
+func (s *Span) SetError(err error) {
+	if err == nil || !s.Recording() {
+		return
+	}
+	s.mutex.Lock()
+	s.data.Error = err.Error()
+	s.mutex.Unlock()
+}
+
+// End finishes the span and hands it to the tracer. Ending it again does
+// nothing.
+func (s *Span) End() {
+	if !s.Recording() {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mutex.Unlock()
+
+	s.tracer.record(data)
+}
+
+// EndError is SetError and End, for a deferred call with a named error.
+func (s *Span) EndError(err error) {
+	s.SetError(err)
+	s.End()
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
@@ -0,0 +1,165 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// installRecorder installs a tracer sampling at ratio for the test, and
+// returns what it exported once flushed.
+func installRecorder(t *testing.T, ratio float64) func() []SpanData {
+	t.Helper()
+	recorder := &Recorder{}
+	tracer := NewTracer(recorder, ratio)
+	Install(tracer)
+	t.Cleanup(func() { Install(nil) })
+
+	return func() []SpanData {
+		require.NoError(t, tracer.Flush(context.Background()))
+		return recorder.Spans()
+	}
+}
+
+func TestStart_DisabledIsNoop(t *testing.T) {
+	Install(nil)
+	ctx := context.Background()
+
+	spanCtx, span := Start(ctx, "work")
+	assert.Nil(t, span)
+	assert.Equal(t, ctx, spanCtx)
+
+	failed := errors.New("failed")
+	allocs := testing.AllocsPerRun(100, func() {
+		_, span := Start(ctx, "work")
+		span.SetString("key", "value")
+		span.SetInt("count", 1)
+		span.SetError(failed)
+		span.End()
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestStart_Hierarchy(t *testing.T) {
+	spans := installRecorder(t, 1)
+
+	ctx, root := StartKind(context.Background(), SPAN_KIND_SERVER, "request")
+	childCtx, child := Start(ctx, "controller")
+	_, grandchild := StartKind(childCtx, SPAN_KIND_CLIENT, "query")
+	grandchild.SetString("db.system", "sqlite")
+	grandchild.End()
+	child.EndError(errors.New("failed"))
+	root.End()
+	root.End()
+
+	exported := spans()
+	require.Len(t, exported, 3, "ending a span twice must export it once")
+	query, controller, request := exported[0], exported[1], exported[2]
+
+	assert.Equal(t, "request", request.Name)
+	assert.Equal(t, SPAN_KIND_SERVER, request.Kind)
+	assert.False(t, request.ParentID.IsValid())
+	assert.Equal(t, request.SpanID, controller.ParentID)
+	assert.Equal(t, controller.SpanID, query.ParentID)
+	for _, span := range exported {
+		assert.Equal(t, request.TraceID, span.TraceID)
+		assert.False(t, span.End.Before(span.Start))
+	}
+	assert.Equal(t, "failed", controller.Error)
+	assert.Equal(t, []Attribute{{Key: "db.system", Value: "sqlite"}}, query.Attributes)
+}
+
+func TestStartRemote_ContinuesParent(t *testing.T) {
+	spans := installRecorder(t, 0)
+
+	parent, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	ctx, span := StartRemote(context.Background(), SPAN_KIND_SERVER, "request", parent)
+	span.End()
+
+	// The caller sampled the trace, so it is recorded despite the ratio
+	exported := spans()
+	require.Len(t, exported, 1)
+	assert.Equal(t, parent.TraceID, exported[0].TraceID)
+	assert.Equal(t, parent.SpanID, exported[0].ParentID)
+
+	traceID, spanID := TraceIDs(ctx)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, exported[0].SpanID.String(), spanID)
+}
+
+func TestTracer_Sampling(t *testing.T) {
+	spans := installRecorder(t, 0)
+
+	ctx, span := Start(context.Background(), "unsampled")
+	_, child := Start(ctx, "child")
+	assert.False(t, span.Recording())
+	assert.Equal(t, span.SpanContext().TraceID, child.SpanContext().TraceID,
+		"unsampled spans still carry the trace on")
+	assert.Equal(t, "00-"+span.SpanContext().TraceID.String()+"-"+span.SpanContext().SpanID.String()+"-00", Traceparent(ctx))
+	child.End()
+	span.End()
+	assert.Empty(t, spans())
+
+	half := NewTracer(&Recorder{}, 0.5)
+	sampled := 0
+	for range 10000 {
+		if half.sampled(newTraceID()) {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 5000, sampled, 300)
+}
+
+func TestContextWithRemote(t *testing.T) {
+	parent, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+
+	Install(nil)
+	assert.Nil(t, SpanFromContext(ContextWithRemote(context.Background(), parent)))
+
+	spans := installRecorder(t, 1)
+	ctx := ContextWithRemote(context.Background(), parent)
+	assert.False(t, SpanFromContext(ctx).Recording())
+	_, span := Start(ctx, "handler")
+	span.End()
+
+	exported := spans()
+	require.Len(t, exported, 1, "the remote parent itself is never exported")
+	assert.Equal(t, parent.SpanID, exported[0].ParentID)
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"later version with more fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"version 00 with more fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"forbidden version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", false, false},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"empty", "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.value)
+			assert.Equal(t, tt.valid, ok)
+			assert.Equal(t, tt.sampled, sc.Sampled)
+			if tt.valid && tt.value[:2] == "00" {
+				assert.Equal(t, tt.value, sc.Traceparent())
+			}
+		})
+	}
+}
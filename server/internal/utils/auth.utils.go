@@ -1,25 +1,64 @@
 package utils
 
 import (
+	"errors"
 	"server/config"
 	"server/internal/logger"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
+// BCRYPT_MAX_BYTES is the most bcrypt will read; password and pepper together
+// must fit in it.
+const BCRYPT_MAX_BYTES = 72
+
+var ErrPasswordTooLong = errors.New("password and pepper exceed bcrypt's 72 byte limit")
+
+func HashPassword(password string, config config.Config) (string, error) {
 	log := logger.New("utils").File("auth").Function("hashPassword")
-	config := config.GetConfig()
-	salt := config.SecuritySalt
-	pepper := config.SecurityPepper
-	if salt <= 0 || pepper == "" {
-		return "", log.Error("salt or pepper is empty", "salt", salt, "pepper", pepper)
+	cost := config.BcryptCost()
+	pepper := config.Security.Pepper
+	if pepper == "" {
+		return "", log.Error("pepper is empty", "pepper", pepper)
+	}
+
+	if len(password)+len(pepper) > BCRYPT_MAX_BYTES {
+		return "", log.Err("failed to hash password", ErrPasswordTooLong, "bytes", len(password)+len(pepper))
 	}
 
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password+pepper), salt)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password+pepper), cost)
 	if err != nil {
 		return "", log.Err("failed to hash password", err)
 	}
 
 	return string(bytes), nil
 }
+
+// HashPasswordWithGlobalConfig hashes with config.ConfigInstance.
+//
+// Deprecated: use HashPassword with an explicit config. This will be removed
+// in the next release.
+func HashPasswordWithGlobalConfig(password string) (string, error) {
+	return HashPassword(password, config.GetConfig())
+}
+
+// VerifyPassword checks password against hash with the current pepper and,
+// while a rotation is under way, the previous one. previous reports that only
+// the previous pepper matched, so the hash should be replaced.
+func VerifyPassword(password string, hash string, config config.Config) (previous bool, err error) {
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password+config.Security.Pepper))
+	if !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || config.Security.PepperPrevious == "" {
+		return false, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password+config.Security.PepperPrevious)) != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// IsPasswordHash reports whether value is already a bcrypt hash.
+func IsPasswordHash(value string) bool {
+	_, err := bcrypt.Cost([]byte(value))
+	return err == nil
+}
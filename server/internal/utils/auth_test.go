@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"fmt"
 	"server/config"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -9,16 +11,17 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-func setupAuthTestConfig() {
-	testConfig := config.Config{
-		SecuritySalt:   12,
-		SecurityPepper: "test-pepper-for-auth",
+func setupAuthTestConfig() config.Config {
+	return config.Config{
+		Security: config.SecurityConfig{
+			Salt:   12,
+			Pepper: "test-pepper-for-auth",
+		},
 	}
-	config.ConfigInstance = testConfig
 }
 
 func TestHashPassword(t *testing.T) {
-	setupAuthTestConfig()
+	cfg := setupAuthTestConfig()
 
 	tests := []struct {
 		name     string
@@ -49,7 +52,7 @@ func TestHashPassword(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hashedPassword, err := HashPassword(tt.password)
+			hashedPassword, err := HashPassword(tt.password, cfg)
 
 			if tt.wantErr {
 				assert.Error(t, err)
@@ -68,15 +71,15 @@ func TestHashPassword(t *testing.T) {
 }
 
 func TestHashPassword_WithDifferentPasswords(t *testing.T) {
-	setupAuthTestConfig()
+	cfg := setupAuthTestConfig()
 
 	password1 := "password1"
 	password2 := "password2"
 
-	hash1, err1 := HashPassword(password1)
+	hash1, err1 := HashPassword(password1, cfg)
 	require.NoError(t, err1)
 
-	hash2, err2 := HashPassword(password2)
+	hash2, err2 := HashPassword(password2, cfg)
 	require.NoError(t, err2)
 
 	// Different passwords should produce different hashes
@@ -84,14 +87,14 @@ func TestHashPassword_WithDifferentPasswords(t *testing.T) {
 }
 
 func TestHashPassword_SamePasswordDifferentHashes(t *testing.T) {
-	setupAuthTestConfig()
+	cfg := setupAuthTestConfig()
 
 	password := "samepassword"
 
-	hash1, err1 := HashPassword(password)
+	hash1, err1 := HashPassword(password, cfg)
 	require.NoError(t, err1)
 
-	hash2, err2 := HashPassword(password)
+	hash2, err2 := HashPassword(password, cfg)
 	require.NoError(t, err2)
 
 	// Same password should produce different hashes due to salt randomization
@@ -106,102 +109,82 @@ func TestHashPassword_SamePasswordDifferentHashes(t *testing.T) {
 }
 
 func TestHashPassword_NoConfig(t *testing.T) {
-	// Save original config
-	originalConfig := config.ConfigInstance
-
 	// Set empty config
-	config.ConfigInstance = config.Config{}
+	cfg := config.Config{}
 
-	hashedPassword, err := HashPassword("password")
+	hashedPassword, err := HashPassword("password", cfg)
 	assert.Error(t, err)
 	assert.Empty(t, hashedPassword)
-	assert.Contains(t, err.Error(), "salt or pepper is empty")
-
-	// Restore original config
-	config.ConfigInstance = originalConfig
+	assert.Contains(t, err.Error(), "pepper is empty")
 }
 
 func TestHashPassword_NoSalt(t *testing.T) {
-	// Save original config
-	originalConfig := config.ConfigInstance
-
-	// Set config with no salt
-	config.ConfigInstance = config.Config{
-		SecuritySalt:   0,
-		SecurityPepper: "test-pepper",
+	// Without a salt the environment's default cost is used
+	cfg := config.Config{
+		Environment: "development",
+		Security: config.SecurityConfig{
+			Salt:   0,
+			Pepper: "test-pepper",
+		},
 	}
 
-	hashedPassword, err := HashPassword("password")
-	assert.Error(t, err)
-	assert.Empty(t, hashedPassword)
-	assert.Contains(t, err.Error(), "salt or pepper is empty")
-
-	// Restore original config
-	config.ConfigInstance = originalConfig
+	hashedPassword, err := HashPassword("password", cfg)
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	require.NoError(t, err)
+	assert.Equal(t, config.DEVELOPMENT_BCRYPT_COST, cost)
 }
 
 func TestHashPassword_NoPepper(t *testing.T) {
-	// Save original config
-	originalConfig := config.ConfigInstance
-
 	// Set config with no pepper
-	config.ConfigInstance = config.Config{
-		SecuritySalt:   12,
-		SecurityPepper: "",
+	cfg := config.Config{
+		Security: config.SecurityConfig{
+			Salt:   12,
+			Pepper: "",
+		},
 	}
 
-	hashedPassword, err := HashPassword("password")
+	hashedPassword, err := HashPassword("password", cfg)
 	assert.Error(t, err)
 	assert.Empty(t, hashedPassword)
-	assert.Contains(t, err.Error(), "salt or pepper is empty")
-
-	// Restore original config
-	config.ConfigInstance = originalConfig
+	assert.Contains(t, err.Error(), "pepper is empty")
 }
 
 func TestHashPassword_WeakSalt(t *testing.T) {
-	// Save original config
-	originalConfig := config.ConfigInstance
-
 	// Set config with weak salt (but still valid - bcrypt accepts 4-31)
-	config.ConfigInstance = config.Config{
-		SecuritySalt:   4, // Minimum valid bcrypt cost
-		SecurityPepper: "test-pepper",
+	cfg := config.Config{
+		Security: config.SecurityConfig{
+			Salt:   4, // Minimum valid bcrypt cost
+			Pepper: "test-pepper",
+		},
 	}
 
-	hashedPassword, err := HashPassword("password")
+	hashedPassword, err := HashPassword("password", cfg)
 	assert.NoError(t, err, "bcrypt should accept cost of 4")
 	assert.NotEmpty(t, hashedPassword)
-
-	// Restore original config
-	config.ConfigInstance = originalConfig
 }
 
 func TestHashPassword_BcryptLimits(t *testing.T) {
-	// Save original config
-	originalConfig := config.ConfigInstance
-
 	// Test with bcrypt cost too high
-	config.ConfigInstance = config.Config{
-		SecuritySalt:   32, // Too high for bcrypt (max is 31)
-		SecurityPepper: "test-pepper",
+	cfg := config.Config{
+		Security: config.SecurityConfig{
+			Salt:   32, // Too high for bcrypt (max is 31)
+			Pepper: "test-pepper",
+		},
 	}
 
-	hashedPassword, err := HashPassword("password")
+	hashedPassword, err := HashPassword("password", cfg)
 	assert.Error(t, err)
 	assert.Empty(t, hashedPassword)
-
-	// Restore original config
-	config.ConfigInstance = originalConfig
 }
 
 func TestHashPassword_PepperIncludedInHash(t *testing.T) {
-	setupAuthTestConfig()
+	cfg := setupAuthTestConfig()
 
 	password := "testpassword"
 	pepper := "test-pepper-for-auth"
 
-	hashedPassword, err := HashPassword(password)
+	hashedPassword, err := HashPassword(password, cfg)
 	require.NoError(t, err)
 
 	// Verify the hash was created with password + pepper
@@ -214,7 +197,7 @@ func TestHashPassword_PepperIncludedInHash(t *testing.T) {
 }
 
 func TestHashPassword_RealisticScenarios(t *testing.T) {
-	setupAuthTestConfig()
+	cfg := setupAuthTestConfig()
 
 	// Test realistic password scenarios
 	realisticPasswords := []string{
@@ -228,7 +211,7 @@ func TestHashPassword_RealisticScenarios(t *testing.T) {
 
 	for _, password := range realisticPasswords {
 		t.Run("realistic_password_"+password, func(t *testing.T) {
-			hashedPassword, err := HashPassword(password)
+			hashedPassword, err := HashPassword(password, cfg)
 			assert.NoError(t, err)
 			assert.NotEmpty(t, hashedPassword)
 			assert.NotEqual(t, password, hashedPassword)
@@ -239,3 +222,102 @@ func TestHashPassword_RealisticScenarios(t *testing.T) {
 		})
 	}
 }
+
+func TestHashPassword_ErrPasswordTooLong(t *testing.T) {
+	cfg := config.Config{Security: config.SecurityConfig{Salt: bcrypt.MinCost, Pepper: "0123456789"}}
+
+	fits := strings.Repeat("a", BCRYPT_MAX_BYTES-len(cfg.Security.Pepper))
+	_, err := HashPassword(fits, cfg)
+	assert.NoError(t, err)
+
+	hashedPassword, err := HashPassword(fits+"a", cfg)
+	assert.ErrorIs(t, err, ErrPasswordTooLong)
+	assert.Empty(t, hashedPassword)
+}
+
+func TestHashPassword_ParallelConfigs(t *testing.T) {
+	for i := range 8 {
+		cfg := config.Config{
+			Security: config.SecurityConfig{
+				Salt:   bcrypt.MinCost,
+				Pepper: fmt.Sprintf("pepper-%d", i),
+			},
+		}
+
+		t.Run(cfg.Security.Pepper, func(t *testing.T) {
+			t.Parallel()
+
+			password := fmt.Sprintf("password-%d", i)
+			hashedPassword, err := HashPassword(password, cfg)
+			require.NoError(t, err)
+
+			assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password+cfg.Security.Pepper)))
+
+			otherPepper := fmt.Sprintf("pepper-%d", (i+1)%8)
+			assert.Error(
+				t,
+				bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password+otherPepper)),
+				"hash must only use its own config's pepper",
+			)
+		})
+	}
+}
+
+func TestHashPasswordWithGlobalConfig(t *testing.T) {
+	originalConfig := config.ConfigInstance
+	t.Cleanup(func() { config.ConfigInstance = originalConfig })
+
+	config.ConfigInstance = config.Config{Security: config.SecurityConfig{Salt: bcrypt.MinCost, Pepper: "global-pepper"}}
+
+	hashedPassword, err := HashPasswordWithGlobalConfig("password")
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte("passwordglobal-pepper")))
+}
+
+func TestIsPasswordHash(t *testing.T) {
+	hashedPassword, err := HashPassword("password", config.Config{Security: config.SecurityConfig{Salt: bcrypt.MinCost, Pepper: "pepper"}})
+	require.NoError(t, err)
+
+	assert.True(t, IsPasswordHash(hashedPassword))
+	assert.False(t, IsPasswordHash("password"))
+	assert.False(t, IsPasswordHash(""))
+}
+
+func TestVerifyPassword(t *testing.T) {
+	current := config.Config{Security: config.SecurityConfig{Salt: 4, Pepper: "current-pepper"}}
+	old := config.Config{Security: config.SecurityConfig{Salt: 4, Pepper: "previous-pepper"}}
+	rotating := current
+	rotating.Security.PepperPrevious = old.Security.Pepper
+
+	currentHash, err := HashPassword("password123", current)
+	require.NoError(t, err)
+	oldHash, err := HashPassword("password123", old)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		config   config.Config
+		previous bool
+		wantErr  error
+	}{
+		{"current pepper", currentHash, "password123", rotating, false, nil},
+		{"previous pepper", oldHash, "password123", rotating, true, nil},
+		{"previous pepper not configured", oldHash, "password123", current, false, bcrypt.ErrMismatchedHashAndPassword},
+		{"wrong password", oldHash, "wrong-password", rotating, false, bcrypt.ErrMismatchedHashAndPassword},
+		{"not a hash", "plaintext", "password123", rotating, false, bcrypt.ErrHashTooShort},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous, err := VerifyPassword(tt.password, tt.hash, tt.config)
+			assert.Equal(t, tt.previous, previous)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,9 +1,16 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"server/config"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
 )
 
 type Cookie struct {
@@ -12,19 +19,121 @@ type Cookie struct {
 	Expires time.Time
 }
 
-func ApplyCookie(c *fiber.Ctx, cookie Cookie) {
+var (
+	ErrCookieMissing  = errors.New("cookie is missing")
+	ErrCookieTampered = errors.New("cookie signature does not match")
+)
+
+// SignedCookieOptions are the attributes of a cookie written with
+// WriteSignedCookie. The rest come from config, as with ApplyCookie.
+type SignedCookieOptions struct {
+	Expires time.Time
+}
+
+// ApplyCookie sets an HttpOnly cookie with the SameSite and Partitioned
+// attributes from config. It is Secure whenever the client reached us over
+// HTTPS. SameSite=None and Partitioned cookies are only accepted by browsers
+// when they are Secure, so both force it on.
+func ApplyCookie(c *fiber.Ctx, cookie Cookie, config config.Config) {
+	sameSite := cookieSameSite(config)
+	secure := ExternalURL(c).Scheme == "https" ||
+		sameSite == fiber.CookieSameSiteNoneMode ||
+		config.Session.CookiePartitioned
 	c.Cookie(&fiber.Cookie{
 		Name:     cookie.Name,
 		Value:    cookie.Value,
 		Expires:  cookie.Expires,
 		HTTPOnly: true,
+		SameSite: sameSite,
+		Secure:   secure,
 	})
+
+	// Fiber's Cookie has no Partitioned field, so set it on the cookie
+	// fasthttp already holds for the response.
+	if config.Session.CookiePartitioned {
+		partitioned := fasthttp.AcquireCookie()
+		defer fasthttp.ReleaseCookie(partitioned)
+		partitioned.SetKey(cookie.Name)
+		if c.Response().Header.Cookie(partitioned) {
+			partitioned.SetPartitioned(true)
+			c.Response().Header.SetCookie(partitioned)
+		}
+	}
 }
 
-func ExpireCookie(c *fiber.Ctx, key string) {
+// ExpireCookie clears a cookie. It carries the same attributes as ApplyCookie,
+// otherwise browsers treat it as a different cookie and keep the original.
+func ExpireCookie(c *fiber.Ctx, key string, config config.Config) {
 	ApplyCookie(c, Cookie{
 		Name:    key,
 		Value:   "",
 		Expires: time.Now().Add(1 * time.Second),
-	})
+	}, config)
+}
+
+func cookieSameSite(config config.Config) string {
+	switch strings.ToLower(config.Session.CookieSameSite) {
+	case "strict":
+		return fiber.CookieSameSiteStrictMode
+	case "none":
+		return fiber.CookieSameSiteNoneMode
+	default:
+		return fiber.CookieSameSiteLaxMode
+	}
+}
+
+// WriteSignedCookie sets a cookie whose value can't be changed by the client
+// without ReadSignedCookie noticing. The value is base64url encoded, so it
+// may hold arbitrary bytes, and followed by an HMAC-SHA256 of the name and
+// value keyed with config.CookieSigningKey. It isn't encrypted: anyone
+// holding the cookie can read the value.
+func WriteSignedCookie(c *fiber.Ctx, name, value string, opts SignedCookieOptions, config config.Config) error {
+	key := config.CookieSigningKey()
+	if key == "" {
+		return errors.New("no cookie signing key configured")
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+	ApplyCookie(c, Cookie{
+		Name:    name,
+		Value:   encoded + "." + signCookie(key, name, encoded),
+		Expires: opts.Expires,
+	}, config)
+	return nil
+}
+
+// ReadSignedCookie returns the value of a cookie written by
+// WriteSignedCookie. It fails with ErrCookieMissing when the request doesn't
+// carry the cookie, and with ErrCookieTampered when the value or signature
+// was changed, or the cookie was signed with another key or under another
+// name.
+func ReadSignedCookie(c *fiber.Ctx, name string, config config.Config) (string, error) {
+	cookie := c.Cookies(name)
+	if cookie == "" {
+		return "", ErrCookieMissing
+	}
+
+	key := config.CookieSigningKey()
+	encoded, signature, ok := strings.Cut(cookie, ".")
+	if !ok || key == "" {
+		return "", ErrCookieTampered
+	}
+	expected := signCookie(key, name, encoded)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "", ErrCookieTampered
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrCookieTampered
+	}
+	return string(value), nil
+}
+
+// signCookie covers the name too, so a signed value can't be replayed under
+// another cookie.
+func signCookie(key, name, encoded string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(name + "=" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
 }
@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"net/http/httptest"
+	"server/config"
 	"strings"
 	"testing"
 	"time"
@@ -22,7 +23,7 @@ func TestApplyCookie_Success(t *testing.T) {
 	}
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ApplyCookie(c, testCookie)
+		ApplyCookie(c, testCookie, config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -50,7 +51,7 @@ func TestApplyCookie_WithExpiration(t *testing.T) {
 	}
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ApplyCookie(c, testCookie)
+		ApplyCookie(c, testCookie, config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -76,7 +77,7 @@ func TestApplyCookie_EmptyValue(t *testing.T) {
 	}
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ApplyCookie(c, testCookie)
+		ApplyCookie(c, testCookie, config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -95,7 +96,7 @@ func TestExpireCookie_Success(t *testing.T) {
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ExpireCookie(c, "session_token")
+		ExpireCookie(c, "session_token", config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -119,7 +120,7 @@ func TestExpireCookie_VerifyExpiration(t *testing.T) {
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ExpireCookie(c, "test_cookie")
+		ExpireCookie(c, "test_cookie", config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -151,8 +152,8 @@ func TestExpireCookie_MultipleNames(t *testing.T) {
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ExpireCookie(c, "cookie1")
-		ExpireCookie(c, "cookie2")
+		ExpireCookie(c, "cookie1", config.Config{})
+		ExpireCookie(c, "cookie2", config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -188,7 +189,7 @@ func TestApplyCookie_NilFiberContext(t *testing.T) {
 	// Instead, we test with a real context
 	app := fiber.New()
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ApplyCookie(c, testCookie)
+		ApplyCookie(c, testCookie, config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -212,7 +213,7 @@ func TestApplyCookie_VeryLongName(t *testing.T) {
 	}
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ApplyCookie(c, testCookie)
+		ApplyCookie(c, testCookie, config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -238,7 +239,7 @@ func TestApplyCookie_VeryLongValue(t *testing.T) {
 	}
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ApplyCookie(c, testCookie)
+		ApplyCookie(c, testCookie, config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -275,7 +276,7 @@ func TestApplyCookie_SpecialCharactersInName(t *testing.T) {
 			}
 
 			app.Get("/test", func(c *fiber.Ctx) error {
-				ApplyCookie(c, testCookie)
+				ApplyCookie(c, testCookie, config.Config{})
 				return c.SendString("ok")
 			})
 
@@ -315,7 +316,7 @@ func TestApplyCookie_SpecialCharactersInValue(t *testing.T) {
 			}
 
 			app.Get("/test", func(c *fiber.Ctx) error {
-				ApplyCookie(c, testCookie)
+				ApplyCookie(c, testCookie, config.Config{})
 				return c.SendString("ok")
 			})
 
@@ -341,7 +342,7 @@ func TestApplyCookie_PastExpiration(t *testing.T) {
 	}
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ApplyCookie(c, testCookie)
+		ApplyCookie(c, testCookie, config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -367,7 +368,7 @@ func TestApplyCookie_ZeroTime(t *testing.T) {
 	}
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ApplyCookie(c, testCookie)
+		ApplyCookie(c, testCookie, config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -386,7 +387,7 @@ func TestExpireCookie_EmptyName(t *testing.T) {
 	app := fiber.New()
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ExpireCookie(c, "")
+		ExpireCookie(c, "", config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -407,7 +408,7 @@ func TestExpireCookie_VeryLongName(t *testing.T) {
 	longName := strings.Repeat("x", 1000)
 
 	app.Get("/test", func(c *fiber.Ctx) error {
-		ExpireCookie(c, longName)
+		ExpireCookie(c, longName, config.Config{})
 		return c.SendString("ok")
 	})
 
@@ -436,7 +437,7 @@ func TestExpireCookie_SpecialCharacterNames(t *testing.T) {
 	for _, name := range specialNames {
 		t.Run("expire_"+name, func(t *testing.T) {
 			app.Get("/test", func(c *fiber.Ctx) error {
-				ExpireCookie(c, name)
+				ExpireCookie(c, name, config.Config{})
 				return c.SendString("ok")
 			})
 
@@ -450,3 +451,195 @@ func TestExpireCookie_SpecialCharacterNames(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyCookie_SameSiteAndPartitioned(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name        string
+		sameSite    string
+		partitioned bool
+		expected    string
+	}{
+		{"default", "", false, "session_id=abc; expires=Wed, 02 Jan 2030 03:04:05 GMT; path=/; HttpOnly; SameSite=Lax"},
+		{"lax", "lax", false, "session_id=abc; expires=Wed, 02 Jan 2030 03:04:05 GMT; path=/; HttpOnly; SameSite=Lax"},
+		{"strict", "Strict", false, "session_id=abc; expires=Wed, 02 Jan 2030 03:04:05 GMT; path=/; HttpOnly; SameSite=Strict"},
+		{"none forces secure", "none", false, "session_id=abc; expires=Wed, 02 Jan 2030 03:04:05 GMT; path=/; HttpOnly; secure; SameSite=None"},
+		{"lax partitioned", "lax", true, "session_id=abc; expires=Wed, 02 Jan 2030 03:04:05 GMT; path=/; HttpOnly; secure; SameSite=Lax; Partitioned"},
+		{"none partitioned", "none", true, "session_id=abc; expires=Wed, 02 Jan 2030 03:04:05 GMT; path=/; HttpOnly; secure; SameSite=None; Partitioned"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cookieConfig := config.Config{
+				Session: config.SessionConfig{
+					CookieSameSite:    tc.sameSite,
+					CookiePartitioned: tc.partitioned,
+				},
+			}
+
+			app := fiber.New()
+			app.Get("/test", func(c *fiber.Ctx) error {
+				ApplyCookie(c, Cookie{Name: "session_id", Value: "abc", Expires: expires}, cookieConfig)
+				return c.SendString("ok")
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/test", nil))
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+
+			assert.Equal(t, []string{tc.expected}, resp.Header["Set-Cookie"])
+		})
+	}
+}
+
+func TestExpireCookie_MirrorsAttributes(t *testing.T) {
+	testCases := []struct {
+		name        string
+		sameSite    string
+		partitioned bool
+		expected    string
+	}{
+		{"lax", "lax", false, "; path=/; HttpOnly; SameSite=Lax"},
+		{"strict", "strict", false, "; path=/; HttpOnly; SameSite=Strict"},
+		{"none", "none", false, "; path=/; HttpOnly; secure; SameSite=None"},
+		{"none partitioned", "none", true, "; path=/; HttpOnly; secure; SameSite=None; Partitioned"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cookieConfig := config.Config{
+				Session: config.SessionConfig{
+					CookieSameSite:    tc.sameSite,
+					CookiePartitioned: tc.partitioned,
+				},
+			}
+
+			app := fiber.New()
+			app.Get("/test", func(c *fiber.Ctx) error {
+				ExpireCookie(c, "session_id", cookieConfig)
+				return c.SendString("ok")
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/test", nil))
+			require.NoError(t, err)
+			defer func() { _ = resp.Body.Close() }()
+
+			// The expiry is relative to now, so only its position is fixed.
+			require.Len(t, resp.Header["Set-Cookie"], 1)
+			setCookie := resp.Header["Set-Cookie"][0]
+			assert.True(t, strings.HasPrefix(setCookie, "session_id=; expires="), setCookie)
+			assert.True(t, strings.HasSuffix(setCookie, " GMT"+tc.expected), setCookie)
+		})
+	}
+}
+
+// writeSignedCookie returns the cookie WriteSignedCookie sets for value.
+func writeSignedCookie(t *testing.T, name, value string, cookieConfig config.Config) string {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/write", func(c *fiber.Ctx) error {
+		return WriteSignedCookie(c, name, value, SignedCookieOptions{}, cookieConfig)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/write", nil))
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	cookies := resp.Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, name, cookies[0].Name)
+	return cookies[0].Value
+}
+
+// readSignedCookie sends cookie back under name and returns what
+// ReadSignedCookie makes of it.
+func readSignedCookie(t *testing.T, name, cookie string, cookieConfig config.Config) (string, error) {
+	t.Helper()
+	var value string
+	var readErr error
+	app := fiber.New()
+	app.Get("/read", func(c *fiber.Ctx) error {
+		value, readErr = ReadSignedCookie(c, name, cookieConfig)
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/read", nil)
+	if cookie != "" {
+		req.Header.Set("Cookie", name+"="+cookie)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	return value, readErr
+}
+
+func TestSignedCookie_RoundTrip(t *testing.T) {
+	cookieConfig := config.Config{Security: config.SecurityConfig{JwtSecret: "jwt-secret"}}
+
+	for _, value := range []string{"csrf-token", "", "binary\x00\xff;=, value"} {
+		cookie := writeSignedCookie(t, "csrf", value, cookieConfig)
+		assert.NotContains(t, cookie, ";")
+
+		read, err := readSignedCookie(t, "csrf", cookie, cookieConfig)
+		require.NoError(t, err)
+		assert.Equal(t, value, read)
+	}
+}
+
+func TestSignedCookie_Tampered(t *testing.T) {
+	cookieConfig := config.Config{Security: config.SecurityConfig{CookieKey: "cookie-key"}}
+	cookie := writeSignedCookie(t, "csrf", "user-1", cookieConfig)
+	encoded, signature, ok := strings.Cut(cookie, ".")
+	require.True(t, ok)
+
+	forged := writeSignedCookie(t, "csrf", "admin", config.Config{Security: config.SecurityConfig{CookieKey: "attacker"}})
+	forgedValue, _, _ := strings.Cut(forged, ".")
+	flipped := []byte(signature)
+	flipped[0] ^= 1
+
+	testCases := map[string]string{
+		"tampered value":     forgedValue + "." + signature,
+		"tampered signature": encoded + "." + string(flipped),
+		"missing signature":  encoded,
+		"unsigned":           "user-1",
+	}
+	for name, tampered := range testCases {
+		_, err := readSignedCookie(t, "csrf", tampered, cookieConfig)
+		assert.ErrorIs(t, err, ErrCookieTampered, name)
+	}
+
+	_, err := readSignedCookie(t, "other", cookie, cookieConfig)
+	assert.ErrorIs(t, err, ErrCookieTampered, "signed under another name")
+}
+
+func TestSignedCookie_Missing(t *testing.T) {
+	cookieConfig := config.Config{Security: config.SecurityConfig{JwtSecret: "jwt-secret"}}
+
+	_, err := readSignedCookie(t, "csrf", "", cookieConfig)
+	assert.ErrorIs(t, err, ErrCookieMissing)
+}
+
+func TestSignedCookie_KeysDontValidateEachOther(t *testing.T) {
+	first := config.Config{Security: config.SecurityConfig{CookieKey: "first-key", JwtSecret: "jwt-secret"}}
+	second := config.Config{Security: config.SecurityConfig{JwtSecret: "jwt-secret"}}
+
+	_, err := readSignedCookie(t, "csrf", writeSignedCookie(t, "csrf", "value", first), second)
+	assert.ErrorIs(t, err, ErrCookieTampered)
+
+	_, err = readSignedCookie(t, "csrf", writeSignedCookie(t, "csrf", "value", second), first)
+	assert.ErrorIs(t, err, ErrCookieTampered)
+}
+
+func TestWriteSignedCookie_NoKey(t *testing.T) {
+	app := fiber.New()
+	app.Get("/write", func(c *fiber.Ctx) error {
+		assert.Error(t, WriteSignedCookie(c, "csrf", "value", SignedCookieOptions{}, config.Config{}))
+		return c.SendString("ok")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/write", nil))
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.Empty(t, resp.Header["Set-Cookie"])
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"errors"
+	"server/config"
+	"server/internal/clock"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func formTokenCode(t *testing.T, err error) string {
+	t.Helper()
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr), "expected a validation error, got %v", err)
+	return validationErr.Details[FORM_TOKEN_FIELD].(map[string]any)["code"].(string)
+}
+
+func TestVerifyFormToken(t *testing.T) {
+	cfg := config.Config{Security: config.SecurityConfig{JwtSecret: "test-secret", FormTokenMinAge: 3 * time.Second}}
+	fake := clock.NewFake(time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC))
+
+	token, expiresAt, err := GenerateFormToken(cfg, fake)
+	require.NoError(t, err)
+	assert.Equal(t, fake.Now().Add(FORM_TOKEN_MAX_AGE), expiresAt)
+
+	testCases := []struct {
+		name    string
+		elapsed time.Duration
+		code    string
+	}{
+		{"submitted instantly", 0, "too_new"},
+		{"just under the minimum", 2 * time.Second, "too_new"},
+		{"at the minimum", 3 * time.Second, ""},
+		{"at the maximum", FORM_TOKEN_MAX_AGE, ""},
+		{"past the maximum", FORM_TOKEN_MAX_AGE + time.Second, "expired"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			at := clock.NewFake(fake.Now().Add(tc.elapsed))
+			err := VerifyFormToken(token, cfg, at)
+			if tc.code == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tc.code, formTokenCode(t, err))
+		})
+	}
+}
+
+func TestVerifyFormToken_RejectsForgeries(t *testing.T) {
+	cfg := config.Config{Security: config.SecurityConfig{JwtSecret: "test-secret", FormTokenMinAge: 3 * time.Second}}
+	issuedAt := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	later := clock.NewFake(issuedAt.Add(time.Minute))
+
+	token, _, err := GenerateFormToken(cfg, clock.NewFake(issuedAt))
+	require.NoError(t, err)
+
+	otherSecret, _, err := GenerateFormToken(config.Config{Security: config.SecurityConfig{JwtSecret: "other-secret"}}, clock.NewFake(issuedAt))
+	require.NoError(t, err)
+
+	sessionToken, err := GenerateJWTToken(uuid.New().String(), issuedAt.Add(time.Hour), cfg, clock.NewFake(issuedAt))
+	require.NoError(t, err)
+
+	// Backdating the payload invalidates the signature
+	parts := strings.Split(token, ".")
+	parts[1] = strings.Repeat("A", len(parts[1]))
+	tampered := strings.Join(parts, ".")
+
+	testCases := map[string]struct {
+		token string
+		code  string
+	}{
+		"missing":       {"", "required"},
+		"garbage":       {"not-a-token", "invalid"},
+		"tampered":      {tampered, "invalid"},
+		"other secret":  {otherSecret, "invalid"},
+		"session token": {sessionToken, "invalid"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.code, formTokenCode(t, VerifyFormToken(tc.token, cfg, later)))
+		})
+	}
+}
@@ -0,0 +1,455 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	PASSWORD_SCORE_MIN = 0
+	PASSWORD_SCORE_MAX = 4
+	// Inputs shorter than this are too likely to collide with ordinary
+	// password content to be worth rejecting on.
+	minUserInputLength = 3
+)
+
+var ErrPasswordContainsUserInput = errors.New("password contains login or name")
+
+// PasswordStrength is the result of EstimatePasswordStrength. Score follows the
+// zxcvbn scale: 0 (too guessable) through 4 (very unguessable).
+type PasswordStrength struct {
+	Score       int      `json:"score"`
+	Guesses     float64  `json:"-"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// Suggestion returns the most relevant improvement hint, if any.
+func (p PasswordStrength) Suggestion() string {
+	if len(p.Suggestions) == 0 {
+		return ""
+	}
+	return p.Suggestions[0]
+}
+
+type WeakPasswordError struct {
+	Strength PasswordStrength
+	MinScore int
+}
+
+func (e *WeakPasswordError) Error() string {
+	return fmt.Sprintf(
+		"password strength score %d is below the minimum of %d",
+		e.Strength.Score,
+		e.MinScore,
+	)
+}
+
+// CheckPasswordStrength rejects passwords that contain any of the user inputs
+// (login, first/last name) case-insensitively, or that score below minScore.
+func CheckPasswordStrength(password string, minScore int, userInputs ...string) error {
+	lowered := strings.ToLower(password)
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if len(input) >= minUserInputLength && strings.Contains(lowered, input) {
+			return ErrPasswordContainsUserInput
+		}
+	}
+
+	strength := EstimatePasswordStrength(password, userInputs...)
+	if strength.Score < minScore {
+		return &WeakPasswordError{Strength: strength, MinScore: minScore}
+	}
+
+	return nil
+}
+
+const (
+	suggestionCommon     = "Avoid common passwords and single dictionary words"
+	suggestionUserInput  = "Avoid using your login or name in your password"
+	suggestionSequence   = "Avoid sequences like abc or 1234"
+	suggestionRepeat     = "Avoid repeated characters like aaa"
+	suggestionKeyboard   = "Avoid keyboard patterns like qwerty"
+	suggestionYear       = "Avoid years and dates that are associated with you"
+	suggestionLonger     = "Add another word or two; uncommon words are better"
+	suggestionCharacters = "Mix in uppercase letters, numbers, and symbols"
+)
+
+// commonPasswords is ordered by frequency; the index doubles as the match rank.
+var commonPasswords = []string{
+	"password", "123456", "qwerty", "letmein", "welcome", "admin", "login",
+	"monkey", "dragon", "football", "baseball", "master", "shadow", "sunshine",
+	"princess", "iloveyou", "trustno1", "superman", "batman", "starwars",
+	"hello", "freedom", "whatever", "secret", "access", "summer", "winter",
+	"spring", "autumn", "flower", "cheese", "computer", "internet", "soccer",
+	"hockey", "killer", "jordan", "michael", "charlie", "thomas", "pepper",
+	"ginger", "orange", "banana", "purple", "yellow", "silver", "golden",
+	"mustang", "harley", "ranger", "hunter", "buster", "tigger", "cookie",
+	"passw0rd", "changeme", "default", "guest", "root", "user", "test",
+	"abc", "love", "god", "money", "pass", "temp", "demo",
+}
+
+var keyboardRows = []string{
+	"qwertyuiop", "asdfghjkl", "zxcvbnm", "1234567890",
+	"qwertzuiop", "azertyuiop",
+}
+
+type passwordMatch struct {
+	start, end int // end is exclusive
+	guesses    float64
+	suggestion string
+	priority   int
+}
+
+// EstimatePasswordStrength is a small, dependency-free approximation of
+// zxcvbn: the password is covered with the cheapest known patterns (common
+// words, user inputs, sequences, repeats, keyboard runs, years) and the
+// remaining characters are treated as brute force over the password's
+// character classes.
+func EstimatePasswordStrength(password string, userInputs ...string) PasswordStrength {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return PasswordStrength{
+			Score:       PASSWORD_SCORE_MIN,
+			Guesses:     1,
+			Suggestions: []string{suggestionLonger},
+		}
+	}
+
+	lowered := []rune(strings.ToLower(password))
+	if len(lowered) != len(runes) {
+		// A handful of runes change length when lowercased; fall back to the
+		// lowered form so match offsets stay aligned.
+		runes = lowered
+	}
+	matches := findPasswordMatches(lowered, userInputs)
+	covered := selectPasswordMatches(matches)
+
+	cardinality := bruteForceCardinality(runes)
+	guesses := 1.0
+	bruteForceRun := 0
+	flushBruteForce := func() {
+		if bruteForceRun > 0 {
+			guesses *= math.Pow(float64(cardinality), float64(bruteForceRun))
+			bruteForceRun = 0
+		}
+	}
+
+	suggestionPriority := map[string]int{}
+	next := 0
+	for i := 0; i < len(runes); {
+		if next < len(covered) && covered[next].start == i {
+			flushBruteForce()
+			match := covered[next]
+			guesses *= match.guesses * capitalizationVariations(runes[match.start:match.end])
+			if current, ok := suggestionPriority[match.suggestion]; !ok || match.priority > current {
+				suggestionPriority[match.suggestion] = match.priority
+			}
+			i = match.end
+			next++
+			continue
+		}
+		bruteForceRun++
+		i++
+	}
+	flushBruteForce()
+
+	// Every additional pattern is another decision an attacker has to get
+	// right, but it should never make a password look weaker.
+	if len(covered) > 1 {
+		guesses *= float64(len(covered))
+	}
+
+	score := scoreFromGuesses(guesses)
+	suggestions := orderSuggestions(suggestionPriority)
+	if score < PASSWORD_SCORE_MAX {
+		if len(runes) < 12 {
+			suggestions = append(suggestions, suggestionLonger)
+		}
+		if cardinality <= 26 {
+			suggestions = append(suggestions, suggestionCharacters)
+		}
+	}
+
+	return PasswordStrength{
+		Score:       score,
+		Guesses:     guesses,
+		Suggestions: suggestions,
+	}
+}
+
+func findPasswordMatches(lowered []rune, userInputs []string) []passwordMatch {
+	var matches []passwordMatch
+	text := string(lowered)
+
+	for rank, word := range commonPasswords {
+		matches = append(matches, findSubstringMatches(text, word, float64(rank+1), suggestionCommon, 4)...)
+	}
+
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if len(input) < minUserInputLength {
+			continue
+		}
+		matches = append(matches, findSubstringMatches(text, input, 1, suggestionUserInput, 5)...)
+	}
+
+	matches = append(matches, findSequenceMatches(lowered)...)
+	matches = append(matches, findRepeatMatches(lowered)...)
+	matches = append(matches, findKeyboardMatches(lowered)...)
+	matches = append(matches, findYearMatches(lowered)...)
+
+	return matches
+}
+
+// findSubstringMatches works on byte offsets of the lowered string, so it
+// converts back to rune offsets before returning.
+func findSubstringMatches(
+	text, word string,
+	guesses float64,
+	suggestion string,
+	priority int,
+) []passwordMatch {
+	var matches []passwordMatch
+	for offset := 0; offset < len(text); {
+		index := strings.Index(text[offset:], word)
+		if index < 0 {
+			break
+		}
+		byteStart := offset + index
+		start := len([]rune(text[:byteStart]))
+		matches = append(matches, passwordMatch{
+			start:      start,
+			end:        start + len([]rune(word)),
+			guesses:    guesses,
+			suggestion: suggestion,
+			priority:   priority,
+		})
+		offset = byteStart + 1
+	}
+	return matches
+}
+
+func findSequenceMatches(lowered []rune) []passwordMatch {
+	var matches []passwordMatch
+	for start := 0; start < len(lowered)-2; {
+		delta := lowered[start+1] - lowered[start]
+		if (delta != 1 && delta != -1) || !sameSequenceClass(lowered[start], lowered[start+1]) {
+			start++
+			continue
+		}
+
+		end := start + 2
+		for end < len(lowered) &&
+			lowered[end]-lowered[end-1] == delta &&
+			sameSequenceClass(lowered[end-1], lowered[end]) {
+			end++
+		}
+
+		if end-start >= 3 {
+			matches = append(matches, passwordMatch{
+				start:      start,
+				end:        end,
+				guesses:    float64(4 * (end - start)),
+				suggestion: suggestionSequence,
+				priority:   3,
+			})
+			start = end
+			continue
+		}
+		start++
+	}
+	return matches
+}
+
+func sameSequenceClass(a, b rune) bool {
+	return unicode.IsDigit(a) && unicode.IsDigit(b) || unicode.IsLetter(a) && unicode.IsLetter(b)
+}
+
+func findRepeatMatches(lowered []rune) []passwordMatch {
+	var matches []passwordMatch
+	for start := 0; start < len(lowered); {
+		end := start + 1
+		for end < len(lowered) && lowered[end] == lowered[start] {
+			end++
+		}
+		if end-start >= 3 {
+			matches = append(matches, passwordMatch{
+				start:      start,
+				end:        end,
+				guesses:    float64(10 * (end - start)),
+				suggestion: suggestionRepeat,
+				priority:   2,
+			})
+		}
+		start = end
+	}
+	return matches
+}
+
+func findKeyboardMatches(lowered []rune) []passwordMatch {
+	var matches []passwordMatch
+	text := string(lowered)
+	for _, row := range keyboardRows {
+		for length := len(row); length >= 4; length-- {
+			for offset := 0; offset+length <= len(row); offset++ {
+				run := row[offset : offset+length]
+				matches = append(matches, findSubstringMatches(text, run, float64(10*length), suggestionKeyboard, 3)...)
+			}
+		}
+	}
+	return matches
+}
+
+func findYearMatches(lowered []rune) []passwordMatch {
+	var matches []passwordMatch
+	for start := 0; start+4 <= len(lowered); start++ {
+		candidate := lowered[start : start+4]
+		if !allDigits(candidate) {
+			continue
+		}
+		prefix := string(candidate[:2])
+		if prefix == "19" || prefix == "20" {
+			matches = append(matches, passwordMatch{
+				start:      start,
+				end:        start + 4,
+				guesses:    120,
+				suggestion: suggestionYear,
+				priority:   1,
+			})
+		}
+	}
+	return matches
+}
+
+func allDigits(runes []rune) bool {
+	for _, r := range runes {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectPasswordMatches picks non-overlapping matches, preferring whichever
+// explains the most characters for the fewest guesses.
+func selectPasswordMatches(matches []passwordMatch) []passwordMatch {
+	sort.SliceStable(matches, func(i, j int) bool {
+		li, lj := matches[i].end-matches[i].start, matches[j].end-matches[j].start
+		if li != lj {
+			return li > lj
+		}
+		return matches[i].guesses < matches[j].guesses
+	})
+
+	var selected []passwordMatch
+	for _, candidate := range matches {
+		overlaps := false
+		for _, existing := range selected {
+			if candidate.start < existing.end && existing.start < candidate.end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			selected = append(selected, candidate)
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].start < selected[j].start
+	})
+
+	return selected
+}
+
+func bruteForceCardinality(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	cardinality := 0
+	if lower {
+		cardinality += 26
+	}
+	if upper {
+		cardinality += 26
+	}
+	if digit {
+		cardinality += 10
+	}
+	if symbol {
+		cardinality += 33
+	}
+	if other {
+		cardinality += 100
+	}
+	return cardinality
+}
+
+// capitalizationVariations accounts for uppercase letters inside a matched
+// pattern; a leading capital only doubles the guesses, anything else more.
+func capitalizationVariations(runes []rune) float64 {
+	upper := 0
+	for _, r := range runes {
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+
+	switch {
+	case upper == 0:
+		return 1
+	case upper == 1 && unicode.IsUpper(runes[0]):
+		return 2
+	case upper == len(runes):
+		return 2
+	default:
+		return math.Pow(2, float64(upper))
+	}
+}
+
+func scoreFromGuesses(guesses float64) int {
+	switch {
+	case guesses < 1e3:
+		return 0
+	case guesses < 1e6:
+		return 1
+	case guesses < 1e8:
+		return 2
+	case guesses < 1e10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func orderSuggestions(priorities map[string]int) []string {
+	suggestions := make([]string, 0, len(priorities))
+	for suggestion := range priorities {
+		suggestions = append(suggestions, suggestion)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		pi, pj := priorities[suggestions[i]], priorities[suggestions[j]]
+		if pi != pj {
+			return pi > pj
+		}
+		return suggestions[i] < suggestions[j]
+	})
+	return suggestions
+}
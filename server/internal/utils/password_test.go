@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"errors"
+	"server/config"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimatePasswordStrength_WeakPasswords(t *testing.T) {
+	weakPasswords := []string{
+		"",
+		"password",
+		"password1234",
+		"Password1234",
+		"123456789",
+		"qwerty123",
+		"abcdefgh",
+		"aaaaaaaaaaaa",
+		"letmein2024",
+		"summer2023",
+		"asdfghjkl",
+	}
+
+	for _, password := range weakPasswords {
+		t.Run(password, func(t *testing.T) {
+			strength := EstimatePasswordStrength(password)
+			assert.Less(t, strength.Score, 2, "expected %q to be weak", password)
+			assert.NotEmpty(t, strength.Suggestion(), "weak passwords should come with a suggestion")
+		})
+	}
+}
+
+func TestEstimatePasswordStrength_StrongPasswords(t *testing.T) {
+	strongPasswords := []string{
+		"correct-horse-battery-staple",
+		"Tr0ub4dor&3x",
+		"kx9#Lp2m!vQ7",
+		"glacier umbrella voltage",
+		"n8&Fq!zR2@wE",
+	}
+
+	for _, password := range strongPasswords {
+		t.Run(password, func(t *testing.T) {
+			strength := EstimatePasswordStrength(password)
+			assert.GreaterOrEqual(t, strength.Score, 3, "expected %q to be strong", password)
+		})
+	}
+}
+
+func TestEstimatePasswordStrength_ScoreBounds(t *testing.T) {
+	for _, password := range []string{"", "a", "password", "zQ8!vR2#kL9@mN4$pX7%"} {
+		strength := EstimatePasswordStrength(password)
+		assert.GreaterOrEqual(t, strength.Score, PASSWORD_SCORE_MIN)
+		assert.LessOrEqual(t, strength.Score, PASSWORD_SCORE_MAX)
+	}
+}
+
+func TestEstimatePasswordStrength_TopSuggestion(t *testing.T) {
+	testCases := []struct {
+		password   string
+		suggestion string
+	}{
+		{"password", suggestionCommon},
+		{"abcdefghijkl", suggestionSequence},
+		{"zzzzzzzzzzzz", suggestionRepeat},
+		{"qwertyuiop", suggestionKeyboard},
+		{"zxcvbnmzxcvbnm", suggestionKeyboard},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.password, func(t *testing.T) {
+			strength := EstimatePasswordStrength(tc.password)
+			assert.Equal(t, tc.suggestion, strength.Suggestion())
+		})
+	}
+}
+
+func TestEstimatePasswordStrength_UserInputsLowerScore(t *testing.T) {
+	password := "bobparsons8472"
+
+	withoutInputs := EstimatePasswordStrength(password)
+	withInputs := EstimatePasswordStrength(password, "bobparsons")
+
+	assert.Less(t, withInputs.Guesses, withoutInputs.Guesses)
+	assert.Equal(t, suggestionUserInput, withInputs.Suggestion())
+}
+
+func TestEstimatePasswordStrength_NonASCII(t *testing.T) {
+	assert.NotPanics(t, func() {
+		EstimatePasswordStrength("İstanbul-ЖЖЖ-密码-2024")
+	})
+}
+
+func TestCheckPasswordStrength_RejectsUserInputs(t *testing.T) {
+	testCases := []struct {
+		name       string
+		password   string
+		userInputs []string
+		wantErr    bool
+	}{
+		{"contains login", "xXalice99Xx!Q", []string{"alice"}, true},
+		{"contains login different case", "ALICE-rocks-9!Qz", []string{"alice"}, true},
+		{"login with different case", "my-Wonderland-9!Qz", []string{"WONDERLAND"}, true},
+		{"contains first name", "Hopper#Grace!91", []string{"ghopper", "Grace", "Hopper"}, true},
+		{"short input ignored", "al-Qz9!vR2#kL", []string{"al"}, false},
+		{"unrelated", "glacier umbrella voltage", []string{"alice", "Alice", "Smith"}, false},
+		{"empty inputs ignored", "glacier umbrella voltage", []string{"", "  "}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckPasswordStrength(tc.password, 0, tc.userInputs...)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, ErrPasswordContainsUserInput)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckPasswordStrength_HonorsMinScore(t *testing.T) {
+	password := "letmein2024"
+	score := EstimatePasswordStrength(password).Score
+
+	for minScore := PASSWORD_SCORE_MIN; minScore <= PASSWORD_SCORE_MAX; minScore++ {
+		err := CheckPasswordStrength(password, minScore)
+		if score >= minScore {
+			assert.NoError(t, err, "min score %d", minScore)
+			continue
+		}
+
+		var weakErr *WeakPasswordError
+		require.True(t, errors.As(err, &weakErr), "min score %d", minScore)
+		assert.Equal(t, score, weakErr.Strength.Score)
+		assert.Equal(t, minScore, weakErr.MinScore)
+	}
+}
+
+func TestValidatePassword_Details(t *testing.T) {
+	cfg := config.Config{Security: config.SecurityConfig{MinPasswordScore: 2}}
+
+	err := ValidatePassword("password", "password1234", cfg)
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+
+	detail := validationErr.Details["password"].(map[string]any)
+	assert.Equal(t, "password_too_weak", detail["code"])
+	assert.Less(t, detail["score"], 2)
+	assert.Equal(t, 2, detail["minScore"])
+	assert.NotEmpty(t, detail["suggestion"])
+
+	err = ValidatePassword("newPassword", "jdoe-is-great-91!", cfg, "jdoe")
+	require.True(t, errors.As(err, &validationErr))
+	detail = validationErr.Details["newPassword"].(map[string]any)
+	assert.Equal(t, "password_contains_user_input", detail["code"])
+
+	assert.NoError(t, ValidatePassword("password", "glacier umbrella voltage", cfg, "jdoe"))
+}
+
+func TestValidatePassword_ZeroMinScoreAllowsWeak(t *testing.T) {
+	cfg := config.Config{Security: config.SecurityConfig{MinPasswordScore: 0}}
+	assert.NoError(t, ValidatePassword("password", "password1234", cfg))
+}
+
+func TestValidatePassword_TooLong(t *testing.T) {
+	cfg := config.Config{Security: config.SecurityConfig{MinPasswordScore: 2, Pepper: strings.Repeat("p", 32)}}
+
+	err := ValidatePassword("newPassword", strings.Repeat("glacier ", 5)+"umbrella", cfg)
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	detail := validationErr.Details["newPassword"].(map[string]any)
+	assert.Equal(t, "password_too_long", detail["code"])
+
+	assert.NoError(t, ValidatePassword("newPassword", strings.Repeat("glacier ", 4)+"umbrella", cfg),
+		"password and pepper filling bcrypt exactly")
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const UNCONDITIONAL_UPDATE_WARNING = `299 - "unconditional update, send If-Match with the current version to avoid overwriting concurrent changes"`
+
+// Precondition is what the client claims about the entity it is changing,
+// taken from If-Match and If-Unmodified-Since. AnyVersion is `If-Match: *`,
+// which any existing entity satisfies.
+type Precondition struct {
+	Version         int
+	AnyVersion      bool
+	UnmodifiedSince time.Time
+}
+
+func (p Precondition) Conditional() bool {
+	return p.Version > 0 || p.AnyVersion || !p.UnmodifiedSince.IsZero()
+}
+
+// ExpectedVersion resolves the precondition against the stored entity into
+// the version a conditional write must find. It reports false when the entity
+// changed after UnmodifiedSince, and returns 0 for an unconditional write.
+// The entity was found to resolve against, so AnyVersion always holds.
+func (p Precondition) ExpectedVersion(version int, updatedAt time.Time) (int, bool) {
+	if p.Version > 0 {
+		return p.Version, true
+	}
+	if p.AnyVersion || p.UnmodifiedSince.IsZero() {
+		return 0, true
+	}
+
+	// HTTP dates carry whole seconds only.
+	if updatedAt.Truncate(time.Second).After(p.UnmodifiedSince) {
+		return 0, false
+	}
+	return version, true
+}
+
+// ParsePrecondition reads the conditional request headers. If-Match carries
+// the entity version, bare or as an ETag, or is "*", and wins over
+// If-Unmodified-Since.
+func ParsePrecondition(c *fiber.Ctx) (Precondition, error) {
+	var precondition Precondition
+
+	if ifMatch := strings.TrimSpace(c.Get(fiber.HeaderIfMatch)); ifMatch != "" {
+		if ifMatch == "*" {
+			precondition.AnyVersion = true
+			return precondition, nil
+		}
+		tag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+		version, err := strconv.Atoi(tag)
+		if err != nil || version < 1 {
+			return precondition, fmt.Errorf("invalid %s header %q", fiber.HeaderIfMatch, ifMatch)
+		}
+		precondition.Version = version
+		return precondition, nil
+	}
+
+	if since := strings.TrimSpace(c.Get(fiber.HeaderIfUnmodifiedSince)); since != "" {
+		unmodifiedSince, err := http.ParseTime(since)
+		if err != nil {
+			return precondition, fmt.Errorf("invalid %s header %q", fiber.HeaderIfUnmodifiedSince, since)
+		}
+		precondition.UnmodifiedSince = unmodifiedSince.UTC()
+	}
+
+	return precondition, nil
+}
+
+// VersionETag formats an entity version the way ParsePrecondition reads it.
+func VersionETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ApplyVersionHeaders sets the ETag of the written entity and flags writes
+// that went through without a precondition.
+func ApplyVersionHeaders(c *fiber.Ctx, version int, precondition Precondition) {
+	c.Set(fiber.HeaderETag, VersionETag(version))
+	if !precondition.Conditional() {
+		c.Set(fiber.HeaderWarning, UNCONDITIONAL_UPDATE_WARNING)
+	}
+}
+
+// PreconditionFailedResponse writes a 412 carrying the current version so the
+// client can reload and retry.
+func PreconditionFailedResponse(c *fiber.Ctx, currentVersion int) error {
+	c.Set(fiber.HeaderETag, VersionETag(currentVersion))
+	return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+		"message": "resource was modified by someone else",
+		"version": currentVersion,
+	})
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parsePreconditionForTest(t *testing.T, headers map[string]string) (Precondition, error) {
+	var precondition Precondition
+	var parseErr error
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		precondition, parseErr = ParsePrecondition(c)
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	_, err := app.Test(req)
+	require.NoError(t, err)
+
+	return precondition, parseErr
+}
+
+func TestParsePrecondition(t *testing.T) {
+	since := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		headers  map[string]string
+		expected Precondition
+	}{
+		{"absent", nil, Precondition{}},
+		{"bare version", map[string]string{"If-Match": "3"}, Precondition{Version: 3}},
+		{"etag", map[string]string{"If-Match": `"3"`}, Precondition{Version: 3}},
+		{"weak etag", map[string]string{"If-Match": `W/"3"`}, Precondition{Version: 3}},
+		{"any version", map[string]string{"If-Match": "*"}, Precondition{AnyVersion: true}},
+		{
+			"unmodified since",
+			map[string]string{"If-Unmodified-Since": "Sat, 01 Jun 2024 12:00:00 GMT"},
+			Precondition{UnmodifiedSince: since},
+		},
+		{
+			"if-match wins",
+			map[string]string{"If-Match": "4", "If-Unmodified-Since": "Sat, 01 Jun 2024 12:00:00 GMT"},
+			Precondition{Version: 4},
+		},
+		{
+			"if-match any wins",
+			map[string]string{"If-Match": "*", "If-Unmodified-Since": "Sat, 01 Jun 2024 12:00:00 GMT"},
+			Precondition{AnyVersion: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			precondition, err := parsePreconditionForTest(t, tc.headers)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, precondition)
+		})
+	}
+}
+
+func TestParsePrecondition_Invalid(t *testing.T) {
+	for _, headers := range []map[string]string{
+		{"If-Match": "abc"},
+		{"If-Match": "0"},
+		{"If-Unmodified-Since": "yesterday"},
+	} {
+		_, err := parsePreconditionForTest(t, headers)
+		assert.Error(t, err, headers)
+	}
+}
+
+func TestPrecondition_ExpectedVersion(t *testing.T) {
+	since := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	version, ok := Precondition{}.ExpectedVersion(5, since)
+	assert.True(t, ok)
+	assert.Equal(t, 0, version)
+
+	version, ok = Precondition{Version: 3}.ExpectedVersion(5, since)
+	assert.True(t, ok)
+	assert.Equal(t, 3, version)
+
+	version, ok = Precondition{AnyVersion: true}.ExpectedVersion(5, since)
+	assert.True(t, ok, "any existing version matches")
+	assert.Equal(t, 0, version)
+	assert.True(t, Precondition{AnyVersion: true}.Conditional())
+
+	version, ok = Precondition{UnmodifiedSince: since}.ExpectedVersion(5, since.Add(500*time.Millisecond))
+	assert.True(t, ok, "sub-second changes fall within the same HTTP date")
+	assert.Equal(t, 5, version)
+
+	_, ok = Precondition{UnmodifiedSince: since}.ExpectedVersion(5, since.Add(time.Second))
+	assert.False(t, ok)
+}
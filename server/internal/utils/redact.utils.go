@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	REDACTED_VALUE          = "[REDACTED]"
+	DEFAULT_REDACTED_FIELDS = "password,token,secret,authorization"
+)
+
+// ParseRedactedFields splits a comma separated field list from config.
+func ParseRedactedFields(fields string) []string {
+	var parsed []string
+	for field := range strings.SplitSeq(fields, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field != "" {
+			parsed = append(parsed, field)
+		}
+	}
+	return parsed
+}
+
+// RedactJSON returns body with the value of every sensitive key replaced,
+// at any depth. A key is sensitive when it contains one of fields, ignoring
+// case, so "newPassword" and "refresh_token" are caught by "password" and
+// "token". Bodies that are not JSON are never echoed back, only their size.
+func RedactJSON(body []byte, fields []string) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Sprintf("[non-JSON body, %d bytes]", len(body))
+	}
+
+	redacted, err := json.Marshal(RedactValue(value, fields))
+	if err != nil {
+		return fmt.Sprintf("[unencodable body, %d bytes]", len(body))
+	}
+	return string(redacted)
+}
+
+// RedactValue walks a decoded JSON value and masks sensitive keys in place.
+func RedactValue(value any, fields []string) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, nested := range typed {
+			if isRedactedField(key, fields) {
+				typed[key] = REDACTED_VALUE
+				continue
+			}
+			typed[key] = RedactValue(nested, fields)
+		}
+		return typed
+	case []any:
+		for i, nested := range typed {
+			typed[i] = RedactValue(nested, fields)
+		}
+		return typed
+	default:
+		return value
+	}
+}
+
+func isRedactedField(key string, fields []string) bool {
+	key = strings.ToLower(key)
+	for _, field := range fields {
+		if strings.Contains(key, field) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func redactForTest(t *testing.T, body string) map[string]any {
+	redacted := RedactJSON([]byte(body), ParseRedactedFields(DEFAULT_REDACTED_FIELDS))
+
+	var result map[string]any
+	require.NoError(t, json.Unmarshal([]byte(redacted), &result))
+	return result
+}
+
+func TestRedactJSON_NestedPayload(t *testing.T) {
+	body := `{
+		"login": "jdoe",
+		"password": "hunter2",
+		"profile": {
+			"firstName": "Jane",
+			"apiSecret": "s3cr3t",
+			"credentials": {"newPassword": "n3w", "currentPassword": "0ld", "hint": "pet"}
+		},
+		"devices": [
+			{"name": "laptop", "refresh_token": "rt-1"},
+			{"name": "phone", "Authorization": "Bearer abc", "tags": ["a", {"token": "t-2"}]}
+		],
+		"attempts": 3,
+		"remember": true
+	}`
+
+	result := redactForTest(t, body)
+
+	assert.Equal(t, REDACTED_VALUE, result["password"])
+	assert.Equal(t, "jdoe", result["login"])
+	assert.Equal(t, float64(3), result["attempts"])
+	assert.Equal(t, true, result["remember"])
+
+	profile := result["profile"].(map[string]any)
+	assert.Equal(t, "Jane", profile["firstName"])
+	assert.Equal(t, REDACTED_VALUE, profile["apiSecret"])
+
+	credentials := profile["credentials"].(map[string]any)
+	assert.Equal(t, REDACTED_VALUE, credentials["newPassword"])
+	assert.Equal(t, REDACTED_VALUE, credentials["currentPassword"])
+	assert.Equal(t, "pet", credentials["hint"])
+
+	devices := result["devices"].([]any)
+	laptop := devices[0].(map[string]any)
+	assert.Equal(t, "laptop", laptop["name"])
+	assert.Equal(t, REDACTED_VALUE, laptop["refresh_token"])
+
+	phone := devices[1].(map[string]any)
+	assert.Equal(t, "phone", phone["name"])
+	assert.Equal(t, REDACTED_VALUE, phone["Authorization"])
+	tags := phone["tags"].([]any)
+	assert.Equal(t, "a", tags[0])
+	assert.Equal(t, REDACTED_VALUE, tags[1].(map[string]any)["token"])
+
+	for _, secret := range []string{"hunter2", "s3cr3t", "n3w", "0ld", "rt-1", "Bearer abc", "t-2"} {
+		assert.NotContains(t, RedactJSON([]byte(body), ParseRedactedFields(DEFAULT_REDACTED_FIELDS)), secret)
+	}
+}
+
+func TestRedactJSON_SensitiveObjectReplacedWhole(t *testing.T) {
+	result := redactForTest(t, `{"token": {"access": "a", "refresh": "r"}, "id": "1"}`)
+
+	assert.Equal(t, REDACTED_VALUE, result["token"])
+	assert.Equal(t, "1", result["id"])
+}
+
+func TestRedactJSON_TopLevelArray(t *testing.T) {
+	redacted := RedactJSON([]byte(`[{"password": "p"}, {"login": "l"}]`), []string{"password"})
+
+	assert.Equal(t, `[{"password":"[REDACTED]"},{"login":"l"}]`, redacted)
+}
+
+func TestRedactJSON_NonJSONBody(t *testing.T) {
+	redacted := RedactJSON([]byte("login=jdoe&password=hunter2"), []string{"password"})
+
+	assert.NotContains(t, redacted, "hunter2")
+	assert.Equal(t, "[non-JSON body, 27 bytes]", redacted)
+}
+
+func TestRedactJSON_EmptyBody(t *testing.T) {
+	assert.Equal(t, "", RedactJSON(nil, []string{"password"}))
+}
+
+func TestParseRedactedFields(t *testing.T) {
+	fields := ParseRedactedFields(" Password, TOKEN ,,secret ")
+
+	assert.Equal(t, []string{"password", "token", "secret"}, fields)
+	assert.Empty(t, ParseRedactedFields(""))
+}
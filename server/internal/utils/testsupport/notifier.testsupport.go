@@ -0,0 +1,35 @@
+package testsupport
+
+import (
+	"server/internal/notifier"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// MockWebsocketNotifier stands in for the websocket manager in controller
+// tests. Expectations are set with On as for any testify mock; DisconnectUser
+// and BroadcastToChannel return whatever they're told to.
+type MockWebsocketNotifier struct {
+	mock.Mock
+}
+
+var _ notifier.WebsocketNotifier = (*MockWebsocketNotifier)(nil)
+
+func (m *MockWebsocketNotifier) BroadcastUserLogin(userID string, userData map[string]any) {
+	m.Called(userID, userData)
+}
+
+func (m *MockWebsocketNotifier) SendToUser(userID string, message notifier.Message) error {
+	args := m.Called(userID, message)
+	return args.Error(0)
+}
+
+func (m *MockWebsocketNotifier) BroadcastToChannel(channel string, message notifier.Message) (int, int) {
+	args := m.Called(channel, message)
+	return args.Int(0), args.Int(1)
+}
+
+func (m *MockWebsocketNotifier) DisconnectUser(userID string, reason string) int {
+	args := m.Called(userID, reason)
+	return args.Int(0)
+}
@@ -0,0 +1,122 @@
+package testsupport
+
+import (
+	"server/config"
+	"server/internal/clock"
+	"server/internal/models"
+	"server/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// JWT_SECRET signs every token minted here, see WithKey
+	JWT_SECRET = "testsupport-jwt-secret-key-for-tests-only"
+
+	// TOKEN_TTL is how long minted tokens and sessions last by default
+	TOKEN_TTL = time.Hour
+)
+
+// EPOCH is the instant FreezeTime stops the clock at. It is a whole second,
+// as JWT times are, so a minted token expires at exactly its session's
+// ExpiresAt.
+var EPOCH = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// FreezeTime returns a clock stopped at EPOCH, which only moves when the test
+// moves it. Tokens and sessions minted against it expire at exact, repeatable
+// instants.
+func FreezeTime(t testing.TB) *clock.Fake {
+	t.Helper()
+	return clock.NewFake(EPOCH)
+}
+
+// WithKey returns cfg signing and verifying with JWT_SECRET, for the code
+// under test to accept minted tokens.
+func WithKey(cfg config.Config) config.Config {
+	cfg.Security.JwtSecret = JWT_SECRET
+	return cfg
+}
+
+type TokenOptions struct {
+	// The clock the token is issued by, EPOCH when nil
+	Clock clock.Clock
+	// ExpiresAt when set, otherwise TTL from now, TOKEN_TTL when zero
+	ExpiresAt time.Time
+	TTL       time.Duration
+	// Issuer and audience come from Config, and the key too when it has
+	// one, JWT_SECRET otherwise
+	Config config.Config
+	// The session the token is for, none when empty
+	SessionID string
+}
+
+func (o TokenOptions) clock() clock.Clock {
+	if o.Clock == nil {
+		return clock.NewFake(EPOCH)
+	}
+	return o.Clock
+}
+
+func (o TokenOptions) expiresAt(now time.Time) time.Time {
+	if !o.ExpiresAt.IsZero() {
+		return o.ExpiresAt
+	}
+	if o.TTL == 0 {
+		return now.Add(TOKEN_TTL)
+	}
+	return now.Add(o.TTL)
+}
+
+// MintTestToken signs a token for userID, with JWT_SECRET unless the options
+// carry another key.
+func MintTestToken(t testing.TB, userID uuid.UUID, opts TokenOptions) string {
+	t.Helper()
+
+	cfg := opts.Config
+	if cfg.Security.JwtSecret == "" {
+		cfg = WithKey(cfg)
+	}
+	clk := opts.clock()
+	token, err := utils.GenerateSessionToken(userID.String(), opts.SessionID, opts.expiresAt(clk.Now()), cfg, clk)
+	require.NoError(t, err)
+	return token
+}
+
+type SessionOptions struct {
+	TokenOptions
+	// A random ID when empty
+	ID string
+	// ExpiresAt when zero, so the session isn't refreshed
+	RefreshAt  time.Time
+	DeviceName string
+}
+
+// MintTestSession returns a session for userID as the session repository
+// would store it, with a token that expires along with it.
+func MintTestSession(t testing.TB, userID uuid.UUID, opts SessionOptions) models.Session {
+	t.Helper()
+
+	now := opts.clock().Now()
+	opts.ExpiresAt = opts.expiresAt(now)
+	if opts.ID == "" {
+		opts.ID = uuid.NewString()
+	}
+	opts.SessionID = opts.ID
+
+	session := models.Session{
+		ID:         opts.ID,
+		UserID:     userID.String(),
+		Token:      MintTestToken(t, userID, opts.TokenOptions),
+		CreatedAt:  now,
+		ExpiresAt:  opts.ExpiresAt,
+		RefreshAt:  opts.RefreshAt,
+		DeviceName: opts.DeviceName,
+	}
+	if session.RefreshAt.IsZero() {
+		session.RefreshAt = session.ExpiresAt
+	}
+	return session
+}
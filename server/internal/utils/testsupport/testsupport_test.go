@@ -0,0 +1,46 @@
+package testsupport
+
+import (
+	"server/config"
+	"server/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMintTestSession(t *testing.T) {
+	frozen := FreezeTime(t)
+	userID := uuid.New()
+
+	session := MintTestSession(t, userID, SessionOptions{
+		TokenOptions: TokenOptions{Clock: frozen, TTL: 10 * time.Minute},
+		DeviceName:   "laptop",
+	})
+
+	assert.NotEmpty(t, session.ID)
+	assert.Equal(t, userID.String(), session.UserID)
+	assert.Equal(t, EPOCH, session.CreatedAt)
+	assert.Equal(t, EPOCH.Add(10*time.Minute), session.ExpiresAt)
+	assert.Equal(t, session.ExpiresAt, session.RefreshAt)
+	assert.Equal(t, "laptop", session.DeviceName)
+
+	claims, err := utils.ParseJWTToken(session.Token, WithKey(config.Config{}), frozen)
+	require.NoError(t, err)
+	assert.Equal(t, userID, claims.UserID)
+	assert.True(t, claims.ExpiresAt.Equal(session.ExpiresAt), "the token expires with its session")
+}
+
+func TestMintTestToken_Key(t *testing.T) {
+	userID := uuid.New()
+	other := config.Config{Security: config.SecurityConfig{JwtSecret: "another-key"}}
+
+	token := MintTestToken(t, userID, TokenOptions{Config: other})
+
+	_, err := utils.ParseJWTToken(token, WithKey(config.Config{}), FreezeTime(t))
+	assert.Error(t, err)
+	_, err = utils.ParseJWTToken(token, other, FreezeTime(t))
+	assert.NoError(t, err)
+}
@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"errors"
 	"server/config"
+	"server/internal/clock"
 	"server/internal/logger"
 	"time"
 
@@ -10,6 +12,11 @@ import (
 	"github.com/google/uuid"
 )
 
+var (
+	ErrWrongIssuer   = errors.New("token was not issued by this service")
+	ErrWrongAudience = errors.New("token is not meant for this service")
+)
+
 type TokenClaims struct {
 	UserID uuid.UUID `json:"userId"`
 	jwt.RegisteredClaims
@@ -19,16 +26,29 @@ func ApplyToken(c *fiber.Ctx, token string) {
 	c.Set("X-Auth-Token", token)
 }
 
+// GenerateJWTToken signs a token for userID with the configured issuer and
+// audience.
 func GenerateJWTToken(
 	userID string,
-	// subject string,
 	expiresAt time.Time,
-	issuer string,
 	config config.Config,
+	clk clock.Clock,
+) (string, error) {
+	return GenerateSessionToken(userID, "", expiresAt, config, clk)
+}
+
+// GenerateSessionToken signs a token like GenerateJWTToken with sessionID as
+// its subject, which is how the session is found again from the token.
+func GenerateSessionToken(
+	userID string,
+	sessionID string,
+	expiresAt time.Time,
+	config config.Config,
+	clk clock.Clock,
 ) (string, error) {
-	log := logger.New("utils").Function("GenerateJWTToken")
+	log := logger.New("utils").Function("GenerateSessionToken")
 
-	secretKey := config.SecurityJwtSecret
+	secretKey := config.Security.JwtSecret
 	if secretKey == "" {
 		return "", log.ErrMsg("JWT secret key not found in config")
 	}
@@ -38,15 +58,17 @@ func GenerateJWTToken(
 		return "", log.Err("failed to parse user id", err)
 	}
 
+	now := clock.OrDefault(clk).Now()
 	claims := TokenClaims{
 		ID,
 		jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    issuer,
-			// Subject:   subject,
-			ID: uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    config.JwtIssuer(),
+			Audience:  jwt.ClaimStrings{config.JwtAudience()},
+			Subject:   sessionID,
+			ID:        uuid.New().String(),
 		},
 	}
 
@@ -60,15 +82,24 @@ func GenerateJWTToken(
 	return tokenString, nil
 }
 
-func ParseJWTToken(tokenString string, config config.Config) (*TokenClaims, error) {
+// ParseJWTToken verifies the signature, time based claims, issuer and
+// audience of a token. The time claims are checked against clk rather than
+// the wall clock. A token from another issuer or for another audience fails
+// with ErrWrongIssuer or ErrWrongAudience.
+func ParseJWTToken(
+	tokenString string,
+	config config.Config,
+	clk clock.Clock,
+) (*TokenClaims, error) {
 	log := logger.New("utils").Function("ParseJWTToken")
-	secretKey := config.SecurityJwtSecret
+	secretKey := config.Security.JwtSecret
 
 	if secretKey == "" {
 		return nil, log.ErrMsg("JWT secret key not found in config")
 	}
 
-	token, err := jwt.ParseWithClaims(
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(
 		tokenString,
 		&TokenClaims{},
 		func(token *jwt.Token) (any, error) {
@@ -82,9 +113,31 @@ func ParseJWTToken(tokenString string, config config.Config) (*TokenClaims, erro
 		return nil, log.Err("failed to parse token", err)
 	}
 
-	if claims, ok := token.Claims.(*TokenClaims); ok && token.Valid {
+	claims, ok := token.Claims.(*TokenClaims)
+	if !ok || !token.Valid {
+		return nil, log.ErrMsg("invalid token claims")
+	}
+
+	now := clock.OrDefault(clk).Now()
+	switch {
+	case !claims.VerifyExpiresAt(now, false):
+		return nil, log.ErrMsg("token is expired")
+	case !claims.VerifyIssuedAt(now, false):
+		return nil, log.ErrMsg("token used before issued")
+	case !claims.VerifyNotBefore(now, false):
+		return nil, log.ErrMsg("token is not valid yet")
+	}
+
+	if claims.Issuer != config.JwtIssuer() {
+		return nil, log.Err("rejected token", ErrWrongIssuer, "issuer", claims.Issuer)
+	}
+	if len(claims.Audience) == 0 && config.Security.JwtAudienceRelaxed {
+		log.Warn("Accepted token without an audience")
 		return claims, nil
 	}
+	if !claims.VerifyAudience(config.JwtAudience(), true) {
+		return nil, log.Err("rejected token", ErrWrongAudience, "audience", claims.Audience)
+	}
 
-	return nil, log.ErrMsg("invalid token claims")
+	return claims, nil
 }
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"net/http/httptest"
 	"server/config"
+	"server/internal/clock"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -33,14 +35,13 @@ func TestApplyToken(t *testing.T) {
 
 func TestGenerateJWTToken_Success(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(userID, expiresAt, cfg, clock.New())
 
 	require.NoError(t, err)
 	assert.NotEmpty(t, token)
@@ -49,14 +50,13 @@ func TestGenerateJWTToken_Success(t *testing.T) {
 
 func TestGenerateJWTToken_EmptySecret(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "",
+		Security: config.SecurityConfig{JwtSecret: ""},
 	}
 
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(userID, expiresAt, cfg, clock.New())
 
 	assert.Error(t, err)
 	assert.Empty(t, token)
@@ -65,14 +65,13 @@ func TestGenerateJWTToken_EmptySecret(t *testing.T) {
 
 func TestGenerateJWTToken_InvalidUserID(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	invalidUserID := "not-a-uuid"
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(invalidUserID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(invalidUserID, expiresAt, cfg, clock.New())
 
 	assert.Error(t, err)
 	assert.Empty(t, token)
@@ -81,33 +80,33 @@ func TestGenerateJWTToken_InvalidUserID(t *testing.T) {
 
 func TestParseJWTToken_Success(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(userID, expiresAt, cfg, clock.New())
 	require.NoError(t, err)
 
-	claims, err := ParseJWTToken(token, cfg)
+	claims, err := ParseJWTToken(token, cfg, clock.New())
 
 	require.NoError(t, err)
 	assert.NotNil(t, claims)
 	assert.Equal(t, userID, claims.UserID.String())
-	assert.Equal(t, issuer, claims.Issuer)
+	assert.Equal(t, config.DEFAULT_JWT_ISSUER, claims.Issuer)
+	assert.Equal(t, []string{config.DEFAULT_JWT_AUDIENCE}, []string(claims.Audience))
 	assert.True(t, claims.ExpiresAt.After(time.Now()))
 }
 
 func TestParseJWTToken_EmptySecret(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "",
+		Security: config.SecurityConfig{JwtSecret: ""},
 	}
 
 	token := "some.jwt.token"
 
-	claims, err := ParseJWTToken(token, cfg)
+	claims, err := ParseJWTToken(token, cfg, clock.New())
 
 	assert.Error(t, err)
 	assert.Nil(t, claims)
@@ -116,12 +115,12 @@ func TestParseJWTToken_EmptySecret(t *testing.T) {
 
 func TestParseJWTToken_InvalidToken(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	invalidToken := "invalid.jwt.token"
 
-	claims, err := ParseJWTToken(invalidToken, cfg)
+	claims, err := ParseJWTToken(invalidToken, cfg, clock.New())
 
 	assert.Error(t, err)
 	assert.Nil(t, claims)
@@ -129,17 +128,16 @@ func TestParseJWTToken_InvalidToken(t *testing.T) {
 
 func TestParseJWTToken_ExpiredToken(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(-1 * time.Hour) // Expired 1 hour ago
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(userID, expiresAt, cfg, clock.New())
 	require.NoError(t, err)
 
-	claims, err := ParseJWTToken(token, cfg)
+	claims, err := ParseJWTToken(token, cfg, clock.New())
 
 	assert.Error(t, err)
 	assert.Nil(t, claims)
@@ -147,20 +145,19 @@ func TestParseJWTToken_ExpiredToken(t *testing.T) {
 
 func TestParseJWTToken_WrongSecret(t *testing.T) {
 	cfg1 := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 	cfg2 := config.Config{
-		SecurityJwtSecret: "different-secret-key",
+		Security: config.SecurityConfig{JwtSecret: "different-secret-key"},
 	}
 
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg1)
+	token, err := GenerateJWTToken(userID, expiresAt, cfg1, clock.New())
 	require.NoError(t, err)
 
-	claims, err := ParseJWTToken(token, cfg2)
+	claims, err := ParseJWTToken(token, cfg2, clock.New())
 
 	assert.Error(t, err)
 	assert.Nil(t, claims)
@@ -205,14 +202,13 @@ func TestApplyToken_SpecialCharacters(t *testing.T) {
 
 func TestGenerateJWTToken_EmptyUserID(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	emptyUserID := ""
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(emptyUserID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(emptyUserID, expiresAt, cfg, clock.New())
 
 	assert.Error(t, err)
 	assert.Empty(t, token)
@@ -221,73 +217,69 @@ func TestGenerateJWTToken_EmptyUserID(t *testing.T) {
 
 func TestGenerateJWTToken_NilUUID(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	nilUserID := "00000000-0000-0000-0000-000000000000"
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(nilUserID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(nilUserID, expiresAt, cfg, clock.New())
 
 	// This should succeed as nil UUID is still a valid UUID format
 	require.NoError(t, err)
 	assert.NotEmpty(t, token)
 
 	// Verify we can parse it back
-	claims, err := ParseJWTToken(token, cfg)
+	claims, err := ParseJWTToken(token, cfg, clock.New())
 	require.NoError(t, err)
 	assert.Equal(t, nilUserID, claims.UserID.String())
 }
 
 func TestGenerateJWTToken_PastExpiration(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(-1 * time.Hour) // Expired 1 hour ago
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(userID, expiresAt, cfg, clock.New())
 
 	// Generation should succeed even with past expiration
 	require.NoError(t, err)
 	assert.NotEmpty(t, token)
 
 	// But parsing should fail due to expiration
-	claims, err := ParseJWTToken(token, cfg)
+	claims, err := ParseJWTToken(token, cfg, clock.New())
 	assert.Error(t, err)
 	assert.Nil(t, claims)
 	assert.Contains(t, err.Error(), "expired")
 }
 
-func TestGenerateJWTToken_EmptyIssuer(t *testing.T) {
+func TestGenerateJWTToken_ConfiguredIssuer(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{
+			JwtSecret:   "test-secret-key-123",
+			JwtIssuer:   "auth.example.com",
+			JwtAudience: "api.example.com",
+		},
 	}
 
-	userID := uuid.New().String()
-	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := ""
-
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
-
+	token, err := GenerateJWTToken(uuid.New().String(), time.Now().Add(24*time.Hour), cfg, clock.New())
 	require.NoError(t, err)
-	assert.NotEmpty(t, token)
 
-	// Verify the empty issuer is preserved
-	claims, err := ParseJWTToken(token, cfg)
+	claims, err := ParseJWTToken(token, cfg, clock.New())
 	require.NoError(t, err)
-	assert.Equal(t, "", claims.Issuer)
+	assert.Equal(t, "auth.example.com", claims.Issuer)
+	assert.Equal(t, []string{"api.example.com"}, []string(claims.Audience))
 }
 
 func TestParseJWTToken_EmptyToken(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
-	claims, err := ParseJWTToken("", cfg)
+	claims, err := ParseJWTToken("", cfg, clock.New())
 
 	assert.Error(t, err)
 	assert.Nil(t, claims)
@@ -295,7 +287,7 @@ func TestParseJWTToken_EmptyToken(t *testing.T) {
 
 func TestParseJWTToken_MalformedTokenStructure(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	testCases := []struct {
@@ -312,7 +304,7 @@ func TestParseJWTToken_MalformedTokenStructure(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			claims, err := ParseJWTToken(tc.token, cfg)
+			claims, err := ParseJWTToken(tc.token, cfg, clock.New())
 			assert.Error(t, err)
 			assert.Nil(t, claims)
 		})
@@ -321,15 +313,14 @@ func TestParseJWTToken_MalformedTokenStructure(t *testing.T) {
 
 func TestParseJWTToken_ValidStructureInvalidSignature(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	// Generate a valid token first
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	validToken, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
+	validToken, err := GenerateJWTToken(userID, expiresAt, cfg, clock.New())
 	require.NoError(t, err)
 
 	// Tamper with the signature part
@@ -338,7 +329,7 @@ func TestParseJWTToken_ValidStructureInvalidSignature(t *testing.T) {
 
 	tamperedToken := parts[0] + "." + parts[1] + ".tampered_signature"
 
-	claims, err := ParseJWTToken(tamperedToken, cfg)
+	claims, err := ParseJWTToken(tamperedToken, cfg, clock.New())
 	assert.Error(t, err)
 	assert.Nil(t, claims)
 	assert.Contains(t, err.Error(), "signature is invalid")
@@ -346,7 +337,7 @@ func TestParseJWTToken_ValidStructureInvalidSignature(t *testing.T) {
 
 func TestParseJWTToken_UnsupportedSigningMethod(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	// Create a token with RS256 instead of HS256 (manual construction for testing)
@@ -358,7 +349,7 @@ func TestParseJWTToken_UnsupportedSigningMethod(t *testing.T) {
 
 	maliciousToken := header + "." + payload + "." + signature
 
-	claims, err := ParseJWTToken(maliciousToken, cfg)
+	claims, err := ParseJWTToken(maliciousToken, cfg, clock.New())
 	assert.Error(t, err)
 	assert.Nil(t, claims)
 	assert.Contains(t, err.Error(), "unexpected signing method")
@@ -368,70 +359,68 @@ func TestParseJWTToken_UnsupportedSigningMethod(t *testing.T) {
 
 func TestGenerateJWTToken_ExtremelyShortSecret(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "a", // Very short secret
+		Security: config.SecurityConfig{JwtSecret: "a"}, // Very short secret
 	}
 
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(userID, expiresAt, cfg, clock.New())
 	require.NoError(t, err)
 	assert.NotEmpty(t, token)
 
 	// Should still be parseable
-	claims, err := ParseJWTToken(token, cfg)
+	claims, err := ParseJWTToken(token, cfg, clock.New())
 	require.NoError(t, err)
 	assert.Equal(t, userID, claims.UserID.String())
 }
 
 func TestGenerateJWTToken_VeryLongSecret(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: strings.Repeat("very-long-secret-key-", 100), // Very long secret
+		Security: config.SecurityConfig{JwtSecret: strings.Repeat("very-long-secret-key-", 100)}, // Very long secret
 	}
 
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(userID, expiresAt, cfg, clock.New())
 	require.NoError(t, err)
 	assert.NotEmpty(t, token)
 
 	// Should still be parseable
-	claims, err := ParseJWTToken(token, cfg)
+	claims, err := ParseJWTToken(token, cfg, clock.New())
 	require.NoError(t, err)
 	assert.Equal(t, userID, claims.UserID.String())
 }
 
 func TestGenerateJWTToken_UnicodeInIssuer(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(24 * time.Hour)
 	issuer := "🚀 Test App 測試 ëxâmplé"
+	cfg.Security.JwtIssuer = issuer
 
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(userID, expiresAt, cfg, clock.New())
 	require.NoError(t, err)
 	assert.NotEmpty(t, token)
 
-	claims, err := ParseJWTToken(token, cfg)
+	claims, err := ParseJWTToken(token, cfg, clock.New())
 	require.NoError(t, err)
 	assert.Equal(t, issuer, claims.Issuer)
 }
 
 func TestParseJWTToken_ConcurrentAccess(t *testing.T) {
 	cfg := config.Config{
-		SecurityJwtSecret: "test-secret-key-123",
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
 	}
 
 	userID := uuid.New().String()
 	expiresAt := time.Now().Add(24 * time.Hour)
-	issuer := "test-app"
 
-	token, err := GenerateJWTToken(userID, expiresAt, issuer, cfg)
+	token, err := GenerateJWTToken(userID, expiresAt, cfg, clock.New())
 	require.NoError(t, err)
 
 	// Test concurrent parsing of the same token
@@ -440,7 +429,7 @@ func TestParseJWTToken_ConcurrentAccess(t *testing.T) {
 
 	for i := 0; i < numGoroutines; i++ {
 		go func() {
-			claims, err := ParseJWTToken(token, cfg)
+			claims, err := ParseJWTToken(token, cfg, clock.New())
 			if err != nil {
 				results <- err
 				return
@@ -459,3 +448,105 @@ func TestParseJWTToken_ConcurrentAccess(t *testing.T) {
 		assert.NoError(t, err)
 	}
 }
+
+func TestParseJWTToken_FakeClockBoundary(t *testing.T) {
+	cfg := config.Config{
+		Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"},
+	}
+	issuedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(issuedAt)
+	expiresAt := issuedAt.Add(time.Hour)
+
+	token, err := GenerateJWTToken(uuid.New().String(), expiresAt, cfg, fake)
+	require.NoError(t, err)
+
+	claims, err := ParseJWTToken(token, cfg, fake)
+	require.NoError(t, err)
+	assert.Equal(t, issuedAt.Unix(), claims.IssuedAt.Unix())
+
+	fake.Set(expiresAt.Add(-time.Second))
+	_, err = ParseJWTToken(token, cfg, fake)
+	assert.NoError(t, err)
+
+	fake.Set(expiresAt.Add(time.Second))
+	_, err = ParseJWTToken(token, cfg, fake)
+	assert.Error(t, err)
+
+	fake.Set(issuedAt.Add(-time.Minute))
+	_, err = ParseJWTToken(token, cfg, fake)
+	assert.Error(t, err, "token should not be valid before it was issued")
+}
+
+// legacyToken is signed the way tokens were before they carried an audience.
+func legacyToken(t *testing.T, issuer string, cfg config.Config, now time.Time) string {
+	t.Helper()
+	claims := TokenClaims{
+		uuid.New(),
+		jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    issuer,
+			ID:        uuid.New().String(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.Security.JwtSecret))
+	require.NoError(t, err)
+	return token
+}
+
+func TestParseJWTToken_IssuerAndAudience(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+	strict := config.Config{Security: config.SecurityConfig{JwtSecret: "test-secret-key-123"}}
+	relaxed := strict
+	relaxed.Security.JwtAudienceRelaxed = true
+
+	signedBy := func(issuer, audience string) func(t *testing.T) string {
+		return func(t *testing.T) string {
+			cfg := strict
+			cfg.Security.JwtIssuer = issuer
+			cfg.Security.JwtAudience = audience
+			token, err := GenerateJWTToken(uuid.New().String(), now.Add(time.Hour), cfg, fake)
+			require.NoError(t, err)
+			return token
+		}
+	}
+
+	testCases := []struct {
+		name  string
+		token func(t *testing.T) string
+		cfg   config.Config
+		want  error
+	}{
+		{"matching claims", signedBy("", ""), strict, nil},
+		{"wrong issuer", signedBy("other-service", ""), strict, ErrWrongIssuer},
+		{"wrong audience", signedBy("", "other-service"), strict, ErrWrongAudience},
+		{"legacy without audience", func(t *testing.T) string {
+			return legacyToken(t, config.DEFAULT_JWT_ISSUER, strict, now)
+		}, strict, ErrWrongAudience},
+		{"legacy without issuer", func(t *testing.T) string {
+			return legacyToken(t, "", strict, now)
+		}, strict, ErrWrongIssuer},
+		{"relaxed accepts legacy", func(t *testing.T) string {
+			return legacyToken(t, config.DEFAULT_JWT_ISSUER, strict, now)
+		}, relaxed, nil},
+		{"relaxed still checks issuer", func(t *testing.T) string {
+			return legacyToken(t, "other-service", strict, now)
+		}, relaxed, ErrWrongIssuer},
+		{"relaxed still checks audience", signedBy("", "other-service"), relaxed, ErrWrongAudience},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := ParseJWTToken(tc.token(t), tc.cfg, fake)
+			if tc.want != nil {
+				assert.ErrorIs(t, err, tc.want)
+				assert.Nil(t, claims)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, config.DEFAULT_JWT_ISSUER, claims.Issuer)
+		})
+	}
+}
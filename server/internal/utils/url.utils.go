@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ExternalURL is the scheme and host the client used to reach the server.
+// Behind a TLS-terminating proxy the connection itself is plain HTTP, so
+// X-Forwarded-Proto and X-Forwarded-Host are used instead, but only when the
+// request came from one of the configured trusted proxies. Anyone else could
+// set them to anything.
+func ExternalURL(c *fiber.Ctx) *url.URL {
+	external := &url.URL{Scheme: "http", Host: string(c.Request().URI().Host())}
+	if c.Context().IsTLS() {
+		external.Scheme = "https"
+	}
+
+	if !fromTrustedProxy(c) {
+		return external
+	}
+	// A proxy can't make a TLS connection look like plain HTTP
+	if proto := strings.ToLower(firstHeaderValue(c.Get(fiber.HeaderXForwardedProto))); proto == "https" {
+		external.Scheme = proto
+	}
+	if host := firstHeaderValue(c.Get(fiber.HeaderXForwardedHost)); host != "" {
+		external.Host = host
+	}
+	return external
+}
+
+// fromTrustedProxy reports whether the request came from a trusted proxy.
+// Fiber trusts every address when the check is off, so that counts as none.
+func fromTrustedProxy(c *fiber.Ctx) bool {
+	return c.App().Config().EnableTrustedProxyCheck && c.IsProxyTrusted()
+}
+
+// firstHeaderValue returns the value added by the proxy nearest the client
+// when several proxies have appended to a header.
+func firstHeaderValue(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(first)
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"io"
+	"net/http/httptest"
+	"server/config"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Requests sent with app.Test come from 0.0.0.0
+const testRemoteIP = "0.0.0.0"
+
+type externalResult struct {
+	url    string
+	cookie string
+}
+
+func requestExternalURL(t *testing.T, fiberConfig fiber.Config, headers map[string]string) externalResult {
+	t.Helper()
+
+	app := fiber.New(fiberConfig)
+	app.Get("/test", func(c *fiber.Ctx) error {
+		ApplyCookie(c, Cookie{Name: "session", Value: "abc", Expires: time.Now().Add(time.Hour)}, config.Config{})
+		return c.SendString(ExternalURL(c).String())
+	})
+
+	req := httptest.NewRequest("GET", "http://api.internal/test", nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return externalResult{url: string(body), cookie: resp.Header.Get(fiber.HeaderSetCookie)}
+}
+
+func TestExternalURL(t *testing.T) {
+	forwarded := map[string]string{
+		fiber.HeaderXForwardedProto: "https",
+		fiber.HeaderXForwardedHost:  "app.example.com",
+	}
+
+	testCases := []struct {
+		name    string
+		config  fiber.Config
+		headers map[string]string
+		url     string
+		secure  bool
+	}{
+		{
+			name:    "trusted proxy",
+			config:  fiber.Config{EnableTrustedProxyCheck: true, TrustedProxies: []string{testRemoteIP}},
+			headers: forwarded,
+			url:     "https://app.example.com",
+			secure:  true,
+		},
+		{
+			name:    "trusted proxy range",
+			config:  fiber.Config{EnableTrustedProxyCheck: true, TrustedProxies: []string{"0.0.0.0/8"}},
+			headers: forwarded,
+			url:     "https://app.example.com",
+			secure:  true,
+		},
+		{
+			name:    "trusted proxy chain uses the client side value",
+			config:  fiber.Config{EnableTrustedProxyCheck: true, TrustedProxies: []string{testRemoteIP}},
+			headers: map[string]string{fiber.HeaderXForwardedProto: "HTTPS, http", fiber.HeaderXForwardedHost: "app.example.com, lb.internal"},
+			url:     "https://app.example.com",
+			secure:  true,
+		},
+		{
+			name:   "trusted proxy without headers",
+			config: fiber.Config{EnableTrustedProxyCheck: true, TrustedProxies: []string{testRemoteIP}},
+			url:    "http://api.internal",
+		},
+		{
+			name:    "trusted proxy with unknown scheme",
+			config:  fiber.Config{EnableTrustedProxyCheck: true, TrustedProxies: []string{testRemoteIP}},
+			headers: map[string]string{fiber.HeaderXForwardedProto: "javascript"},
+			url:     "http://api.internal",
+		},
+		{
+			name:    "untrusted source",
+			config:  fiber.Config{EnableTrustedProxyCheck: true, TrustedProxies: []string{"10.0.0.1"}},
+			headers: forwarded,
+			url:     "http://api.internal",
+		},
+		{
+			name:    "no trusted proxies",
+			config:  fiber.Config{EnableTrustedProxyCheck: true},
+			headers: forwarded,
+			url:     "http://api.internal",
+		},
+		{
+			name:    "proxy check disabled",
+			config:  fiber.Config{},
+			headers: forwarded,
+			url:     "http://api.internal",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := requestExternalURL(t, tc.config, tc.headers)
+
+			assert.Equal(t, tc.url, result.url)
+			assert.Contains(t, result.cookie, "session=abc")
+			if tc.secure {
+				assert.Contains(t, result.cookie, "secure")
+			} else {
+				assert.NotContains(t, result.cookie, "secure")
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+import "strings"
+
+const (
+	DEVICE_DESKTOP = "desktop"
+	DEVICE_MOBILE  = "mobile"
+	DEVICE_TABLET  = "tablet"
+	DEVICE_UNKNOWN = "unknown"
+
+	UNKNOWN_USER_AGENT_PART = "Unknown"
+)
+
+// DeviceSummary is a coarse description of the client behind a user agent.
+type DeviceSummary struct {
+	Browser string `json:"browser"`
+	OS      string `json:"os"`
+	Device  string `json:"device"`
+}
+
+type userAgentToken struct {
+	token string
+	name  string
+}
+
+// Order matters: several browsers also advertise the tokens of the engines
+// they are built on, so the more specific ones are checked first.
+var browserTokens = []userAgentToken{
+	{"edg/", "Edge"},
+	{"edge/", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chromium/", "Chromium"},
+	{"chrome/", "Chrome"},
+	{"safari/", "Safari"},
+	{"dart/", "Dart"},
+	{"curl/", "curl"},
+}
+
+var osTokens = []userAgentToken{
+	{"windows", "Windows"},
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"cros", "ChromeOS"},
+	{"android", "Android"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+}
+
+// ParseUserAgent extracts the browser, operating system and device class from
+// a user agent string. Anything it does not recognize is reported as unknown.
+func ParseUserAgent(userAgent string) DeviceSummary {
+	ua := strings.ToLower(userAgent)
+
+	summary := DeviceSummary{
+		Browser: matchUserAgentToken(ua, browserTokens),
+		OS:      matchUserAgentToken(ua, osTokens),
+		Device:  DEVICE_UNKNOWN,
+	}
+
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		summary.Device = DEVICE_TABLET
+	case summary.OS == "Android" && !strings.Contains(ua, "mobile"):
+		summary.Device = DEVICE_TABLET
+	case strings.Contains(ua, "mobile") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod"):
+		summary.Device = DEVICE_MOBILE
+	case summary.OS == "Windows" || summary.OS == "macOS" ||
+		summary.OS == "Linux" || summary.OS == "ChromeOS":
+		summary.Device = DEVICE_DESKTOP
+	}
+
+	return summary
+}
+
+func matchUserAgentToken(ua string, tokens []userAgentToken) string {
+	for _, t := range tokens {
+		if strings.Contains(ua, t.token) {
+			return t.name
+		}
+	}
+	return UNKNOWN_USER_AGENT_PART
+}
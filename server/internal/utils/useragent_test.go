@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	testCases := []struct {
+		name      string
+		userAgent string
+		expected  DeviceSummary
+	}{
+		{
+			name:      "chrome on windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			expected:  DeviceSummary{Browser: "Chrome", OS: "Windows", Device: DEVICE_DESKTOP},
+		},
+		{
+			name:      "edge on windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			expected:  DeviceSummary{Browser: "Edge", OS: "Windows", Device: DEVICE_DESKTOP},
+		},
+		{
+			name:      "firefox on linux",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			expected:  DeviceSummary{Browser: "Firefox", OS: "Linux", Device: DEVICE_DESKTOP},
+		},
+		{
+			name:      "safari on macos",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+			expected:  DeviceSummary{Browser: "Safari", OS: "macOS", Device: DEVICE_DESKTOP},
+		},
+		{
+			name:      "safari on iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			expected:  DeviceSummary{Browser: "Safari", OS: "iOS", Device: DEVICE_MOBILE},
+		},
+		{
+			name:      "chrome on ipad",
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			expected:  DeviceSummary{Browser: "Chrome", OS: "iOS", Device: DEVICE_TABLET},
+		},
+		{
+			name:      "chrome on android phone",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			expected:  DeviceSummary{Browser: "Chrome", OS: "Android", Device: DEVICE_MOBILE},
+		},
+		{
+			name:      "samsung browser on android tablet",
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Safari/537.36",
+			expected:  DeviceSummary{Browser: "Samsung Internet", OS: "Android", Device: DEVICE_TABLET},
+		},
+		{
+			name:      "opera on chromeos",
+			userAgent: "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 OPR/106.0.0.0",
+			expected:  DeviceSummary{Browser: "Opera", OS: "ChromeOS", Device: DEVICE_DESKTOP},
+		},
+		{
+			name:      "flutter client",
+			userAgent: "Dart/3.2 (dart:io)",
+			expected:  DeviceSummary{Browser: "Dart", OS: UNKNOWN_USER_AGENT_PART, Device: DEVICE_UNKNOWN},
+		},
+		{
+			name:      "curl",
+			userAgent: "curl/8.4.0",
+			expected:  DeviceSummary{Browser: "curl", OS: UNKNOWN_USER_AGENT_PART, Device: DEVICE_UNKNOWN},
+		},
+		{
+			name:      "empty",
+			userAgent: "",
+			expected: DeviceSummary{
+				Browser: UNKNOWN_USER_AGENT_PART,
+				OS:      UNKNOWN_USER_AGENT_PART,
+				Device:  DEVICE_UNKNOWN,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ParseUserAgent(tc.userAgent))
+		})
+	}
+}
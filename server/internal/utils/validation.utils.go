@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"errors"
+	"server/config"
+	"server/internal/validate"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ValidationError carries per-field details for a 422 response.
+type ValidationError struct {
+	Message string
+	Details map[string]any
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func NewValidationError(message string, field string, detail map[string]any) *ValidationError {
+	return &ValidationError{
+		Message: message,
+		Details: map[string]any{field: detail},
+	}
+}
+
+// FieldValidationError renders fields that broke their rules as the details
+// of a 422: under each field's name, the code of the rule and a message
+// naming the field. A field is reported for the first rule it broke.
+func FieldValidationError(message string, errs validate.Errors) *ValidationError {
+	details := make(map[string]any, len(errs))
+	for _, fieldErr := range errs {
+		if _, ok := details[fieldErr.Field]; ok {
+			continue
+		}
+		details[fieldErr.Field] = map[string]any{
+			"code":    fieldErr.Code,
+			"message": fieldErr.Error(),
+		}
+	}
+	return &ValidationError{Message: message, Details: details}
+}
+
+// ValidationErrorResponse writes a 422 with the per-field details.
+func ValidationErrorResponse(c *fiber.Ctx, validationErr *ValidationError) error {
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+		"message": validationErr.Message,
+		"details": validationErr.Details,
+	})
+}
+
+// ValidatePassword applies the configured strength rules to a candidate
+// password, after checking it fits in bcrypt alongside the pepper. userInputs
+// are the login and names of the account it belongs to.
+func ValidatePassword(
+	field string,
+	password string,
+	config config.Config,
+	userInputs ...string,
+) error {
+	if len(password)+len(config.Security.Pepper) > BCRYPT_MAX_BYTES {
+		return NewValidationError("password is too long", field, map[string]any{
+			"code": "password_too_long",
+		})
+	}
+
+	err := CheckPasswordStrength(password, config.Security.MinPasswordScore, userInputs...)
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, ErrPasswordContainsUserInput) {
+		return NewValidationError("password is too weak", field, map[string]any{
+			"code":       "password_contains_user_input",
+			"suggestion": suggestionUserInput,
+		})
+	}
+
+	var weakErr *WeakPasswordError
+	if errors.As(err, &weakErr) {
+		return NewValidationError("password is too weak", field, map[string]any{
+			"code":       "password_too_weak",
+			"score":      weakErr.Strength.Score,
+			"minScore":   weakErr.MinScore,
+			"suggestion": weakErr.Strength.Suggestion(),
+		})
+	}
+
+	return err
+}
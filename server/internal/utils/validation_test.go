@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"server/internal/validate"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldValidationError(t *testing.T) {
+	validationErr := FieldValidationError("Invalid announcement", validate.Collect(
+		validate.Check("title", "", validate.Required()),
+		validate.Check("severity", "urgent", validate.OneOf("info", "warning")),
+		validate.Fail("title", "too_long", "must be at most 3 characters"),
+	))
+
+	assert.Equal(t, "Invalid announcement", validationErr.Error())
+	assert.Equal(t, map[string]any{
+		"title":    map[string]any{"code": "required", "message": "title is required"},
+		"severity": map[string]any{"code": "invalid", "message": "severity must be one of info, warning"},
+	}, validationErr.Details)
+}
+
+func TestFieldValidationError_Response(t *testing.T) {
+	app := fiber.New()
+	app.Post("/", func(c *fiber.Ctx) error {
+		return ValidationErrorResponse(c, FieldValidationError("Invalid login",
+			validate.Collect(validate.Check("login", "", validate.Required()))))
+	})
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, map[string]any{
+		"message": "Invalid login",
+		"details": map[string]any{"login": map[string]any{"code": "required", "message": "login is required"}},
+	}, payload)
+}
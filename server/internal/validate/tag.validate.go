@@ -0,0 +1,59 @@
+package validate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseTag reads the rules of a validate struct tag: comma separated
+// required, uuid, email, min=N and max=N characters, and oneof= with the
+// allowed values separated by spaces. Required comes first whatever its
+// place in the tag.
+func ParseTag(tag string) ([]Rule, error) {
+	var rules []Rule
+	required := false
+	min, max := 0, 0
+
+	for _, part := range strings.Split(tag, ",") {
+		name, argument, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "":
+		case "required":
+			required = true
+		case "uuid":
+			rules = append(rules, UUID())
+		case "email":
+			rules = append(rules, Email())
+		case "oneof":
+			values := strings.Fields(argument)
+			if len(values) == 0 {
+				return nil, fmt.Errorf("oneof needs at least one value")
+			}
+			rules = append(rules, OneOf(values...))
+		case "min", "max":
+			n, err := strconv.Atoi(argument)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %s %q", name, argument)
+			}
+			if name == "min" {
+				min = n
+			} else {
+				max = n
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+	}
+
+	if min > 0 || max > 0 {
+		if max > 0 && min > max {
+			return nil, fmt.Errorf("min %d is above max %d", min, max)
+		}
+		rules = append([]Rule{Length(min, max)}, rules...)
+	}
+	if required {
+		rules = append([]Rule{Required()}, rules...)
+	}
+	return rules, nil
+}
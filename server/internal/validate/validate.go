@@ -0,0 +1,157 @@
+// Package validate checks fields against composable rules. HTTP requests and
+// websocket messages both report what is wrong with a field as a FieldError,
+// and each renders those its own way.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Codes of the rules' FieldErrors. NOT_ALLOWED is for a field that shouldn't
+// be there at all, with Fail.
+const (
+	CODE_REQUIRED      = "required"
+	CODE_TOO_SHORT     = "too_short"
+	CODE_TOO_LONG      = "too_long"
+	CODE_INVALID_UTF8  = "invalid_utf8"
+	CODE_INVALID_UUID  = "invalid_uuid"
+	CODE_INVALID_EMAIL = "invalid_email"
+	CODE_INVALID       = "invalid"
+	CODE_NOT_ALLOWED   = "not_allowed"
+)
+
+// FieldError is a field that broke a rule. Field is the name the client sent
+// it under, and Reason completes a sentence starting with it.
+type FieldError struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// Errors are the broken fields of one request, in the order they were
+// checked.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Rule checks a value, returning what is wrong with it without the field
+// name. Every rule but Required lets an empty value through, so a field is
+// optional unless Required comes first.
+type Rule func(value string) *FieldError
+
+// Check runs rules against the field's value in order and returns the first
+// error, or nil when it passes them all.
+func Check(field string, value string, rules ...Rule) *FieldError {
+	for _, rule := range rules {
+		if fieldErr := rule(value); fieldErr != nil {
+			fieldErr.Field = field
+			return fieldErr
+		}
+	}
+	return nil
+}
+
+// Fail is a FieldError for a check no rule covers, such as one on a value
+// that isn't a string.
+func Fail(field string, code string, reason string) *FieldError {
+	return &FieldError{Field: field, Code: code, Reason: reason}
+}
+
+// Collect gathers the failed checks, dropping the ones that passed. It is
+// nil when every check passed.
+func Collect(checks ...*FieldError) Errors {
+	var errs Errors
+	for _, fieldErr := range checks {
+		if fieldErr != nil {
+			errs = append(errs, fieldErr)
+		}
+	}
+	return errs
+}
+
+func Required() Rule {
+	return func(value string) *FieldError {
+		if value == "" {
+			return &FieldError{Code: CODE_REQUIRED, Reason: "is required"}
+		}
+		return nil
+	}
+}
+
+// Length bounds the value in characters, not bytes. A max of 0 leaves it
+// unbounded. The value has to be valid UTF-8 to be counted.
+func Length(min int, max int) Rule {
+	return func(value string) *FieldError {
+		if value == "" {
+			return nil
+		}
+		if !utf8.ValidString(value) {
+			return &FieldError{Code: CODE_INVALID_UTF8, Reason: "must be valid UTF-8"}
+		}
+		length := utf8.RuneCountInString(value)
+		if length < min {
+			return &FieldError{Code: CODE_TOO_SHORT, Reason: fmt.Sprintf("must be at least %d characters", min)}
+		}
+		if max > 0 && length > max {
+			return &FieldError{Code: CODE_TOO_LONG, Reason: fmt.Sprintf("must be at most %d characters", max)}
+		}
+		return nil
+	}
+}
+
+// UUID takes the canonical hyphenated form only, not the braced or urn:
+// forms uuid.Parse also reads.
+func UUID() Rule {
+	return func(value string) *FieldError {
+		if value == "" {
+			return nil
+		}
+		if _, err := uuid.Parse(value); err != nil || len(value) != 36 {
+			return &FieldError{Code: CODE_INVALID_UUID, Reason: "must be a UUID"}
+		}
+		return nil
+	}
+}
+
+func OneOf(values ...string) Rule {
+	return func(value string) *FieldError {
+		if value == "" {
+			return nil
+		}
+		for _, allowed := range values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return &FieldError{Code: CODE_INVALID, Reason: "must be one of " + strings.Join(values, ", ")}
+	}
+}
+
+// Email takes a bare address, without a display name or angle brackets.
+func Email() Rule {
+	return func(value string) *FieldError {
+		if value == "" {
+			return nil
+		}
+		address, err := mail.ParseAddress(value)
+		if err != nil || address.Address != value || address.Name != "" {
+			return &FieldError{Code: CODE_INVALID_EMAIL, Reason: "must be an email address"}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,133 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRules(t *testing.T) {
+	testCases := []struct {
+		name   string
+		rule   Rule
+		value  string
+		code   string
+		reason string
+	}{
+		{"required", Required(), "x", "", ""},
+		{"required empty", Required(), "", CODE_REQUIRED, "is required"},
+		{"length within", Length(2, 4), "abcd", "", ""},
+		{"length counts characters", Length(0, 4), "éééé", "", ""},
+		{"length too short", Length(2, 4), "a", CODE_TOO_SHORT, "must be at least 2 characters"},
+		{"length too long", Length(2, 4), "abcde", CODE_TOO_LONG, "must be at most 4 characters"},
+		{"length unbounded", Length(0, 0), strings.Repeat("a", 10000), "", ""},
+		{"length invalid utf-8", Length(0, 4), "a\xff", CODE_INVALID_UTF8, "must be valid UTF-8"},
+		{"uuid", UUID(), "0190a1b2-0000-7000-8000-000000000001", "", ""},
+		{"uuid not one", UUID(), "admin", CODE_INVALID_UUID, "must be a UUID"},
+		{"uuid braced", UUID(), "{0190a1b2-0000-7000-8000-000000000001}", CODE_INVALID_UUID, "must be a UUID"},
+		{"uuid urn", UUID(), "urn:uuid:0190a1b2-0000-7000-8000-000000000001", CODE_INVALID_UUID, "must be a UUID"},
+		{"one of", OneOf("info", "warning"), "warning", "", ""},
+		{"one of other", OneOf("info", "warning"), "urgent", CODE_INVALID, "must be one of info, warning"},
+		{"one of is case sensitive", OneOf("info"), "Info", CODE_INVALID, "must be one of info"},
+		{"email", Email(), "ada@example.com", "", ""},
+		{"email without domain", Email(), "ada", CODE_INVALID_EMAIL, "must be an email address"},
+		{"email with name", Email(), "Ada <ada@example.com>", CODE_INVALID_EMAIL, "must be an email address"},
+		{"email with spaces", Email(), " ada@example.com", CODE_INVALID_EMAIL, "must be an email address"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErr := tc.rule(tc.value)
+			if tc.code == "" {
+				assert.Nil(t, fieldErr)
+				return
+			}
+			require.NotNil(t, fieldErr)
+			assert.Equal(t, tc.code, fieldErr.Code)
+			assert.Equal(t, tc.reason, fieldErr.Reason)
+		})
+	}
+}
+
+func TestRules_EmptyIsOptional(t *testing.T) {
+	for name, rule := range map[string]Rule{
+		"length": Length(3, 10),
+		"uuid":   UUID(),
+		"one of": OneOf("a"),
+		"email":  Email(),
+	} {
+		assert.Nil(t, rule(""), name)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	rules := []Rule{Required(), Length(0, 8), Email()}
+
+	assert.Nil(t, Check("email", "a@b.io", rules...))
+	assert.Equal(t, &FieldError{Field: "email", Code: CODE_REQUIRED, Reason: "is required"}, Check("email", "", rules...))
+	// The first rule broken is the one reported
+	assert.Equal(t, &FieldError{Field: "email", Code: CODE_TOO_LONG, Reason: "must be at most 8 characters"},
+		Check("email", "not an address", rules...))
+	assert.Nil(t, Check("anything", "goes"))
+}
+
+func TestCollect(t *testing.T) {
+	errs := Collect(
+		Check("title", "", Required()),
+		Check("body", "fine", Required()),
+		Fail("expiresAt", "in_past", "must be in the future"),
+	)
+
+	require.Len(t, errs, 2)
+	assert.Equal(t, "title", errs[0].Field)
+	assert.Equal(t, "expiresAt", errs[1].Field)
+	assert.Equal(t, "title is required; expiresAt must be in the future", errs.Error())
+
+	assert.Nil(t, Collect(Check("body", "fine", Required())))
+}
+
+func TestParseTag(t *testing.T) {
+	testCases := []struct {
+		tag     string
+		value   string
+		code    string
+		invalid bool
+	}{
+		{tag: "required", value: "", code: CODE_REQUIRED},
+		{tag: "max=3,required", value: "", code: CODE_REQUIRED},
+		{tag: "max=3", value: "abcd", code: CODE_TOO_LONG},
+		{tag: "min=2,max=3", value: "a", code: CODE_TOO_SHORT},
+		{tag: "uuid", value: "nope", code: CODE_INVALID_UUID},
+		{tag: "email", value: "nope", code: CODE_INVALID_EMAIL},
+		{tag: "oneof=web mobile", value: "desktop", code: CODE_INVALID},
+		{tag: "oneof=web mobile", value: "mobile"},
+		{tag: "required, max=8, email", value: "a@b.io"},
+		{tag: "", value: ""},
+		{tag: "unique", invalid: true},
+		{tag: "max=ten", invalid: true},
+		{tag: "max=0", invalid: true},
+		{tag: "min=4,max=3", invalid: true},
+		{tag: "oneof=", invalid: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.tag, func(t *testing.T) {
+			rules, err := ParseTag(tc.tag)
+			if tc.invalid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			fieldErr := Check("field", tc.value, rules...)
+			if tc.code == "" {
+				assert.Nil(t, fieldErr)
+				return
+			}
+			require.NotNil(t, fieldErr)
+			assert.Equal(t, tc.code, fieldErr.Code)
+		})
+	}
+}
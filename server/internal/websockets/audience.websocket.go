@@ -0,0 +1,53 @@
+package websockets
+
+import (
+	"context"
+	"server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// UserLookup finds the user a client authenticated as. repositories.UserRepository
+// implements it.
+type UserLookup interface {
+	GetByID(ctx context.Context, id string) (*models.User, error)
+}
+
+// SetUserLookup lets clients be told apart by whether they are admins, for
+// announcements meant for admins. Without it no client counts as one.
+func (m *Manager) SetUserLookup(lookup UserLookup) {
+	m.users = lookup
+}
+
+// isAdmin is looked up once as the client authenticates and kept on it, so a
+// change to the user shows from their next connection.
+func (m *Manager) isAdmin(userID uuid.UUID) bool {
+	if m.users == nil {
+		return false
+	}
+	user, err := m.users.GetByID(context.Background(), userID.String())
+	if err != nil {
+		m.log.Function("isAdmin").Warn("failed to look up user, treating as not an admin", "userID", userID, "error", err)
+		return false
+	}
+	return user.IsAdmin
+}
+
+// AudienceClientCount is the number of authenticated clients on this instance
+// in the audience, see models.AudienceIncludes.
+func (m *Manager) AudienceClientCount(audience string, userIDs []string) int {
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	count := 0
+	for _, client := range m.hub.clients {
+		if client.Status == StatusAuthenticated && client.inAudience(audience, userIDs) {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *Client) inAudience(audience string, userIDs []string) bool {
+	return models.AudienceIncludes(audience, userIDs, c.UserID.String(), c.IsAdmin)
+}
@@ -0,0 +1,140 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"server/config"
+	"server/internal/events"
+	"server/internal/logger"
+	"server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUserLookup map[string]*models.User
+
+func (f fakeUserLookup) GetByID(ctx context.Context, id string) (*models.User, error) {
+	if user, ok := f[id]; ok {
+		return user, nil
+	}
+	return nil, errors.New("not found")
+}
+
+// setupAudienceTest has an admin, a user and a pending client connected.
+func setupAudienceTest() (*Manager, map[string]*Client) {
+	clients := map[string]*Client{
+		"admin":   {ID: "admin", UserID: uuid.New(), Status: StatusAuthenticated, IsAdmin: true, send: make(chan Message, 1)},
+		"user":    {ID: "user", UserID: uuid.New(), Status: StatusAuthenticated, send: make(chan Message, 1)},
+		"pending": {ID: "pending", UserID: uuid.New(), Status: StatusPending, send: make(chan Message, 1)},
+	}
+	manager := &Manager{
+		hub:      &Hub{clients: clients},
+		log:      logger.New("test"),
+		eventBus: events.New(nil, config.Config{}, nil),
+	}
+	return manager, clients
+}
+
+func TestManager_AudienceClientCount(t *testing.T) {
+	manager, clients := setupAudienceTest()
+	userID := clients["user"].UserID.String()
+
+	assert.Equal(t, 2, manager.AudienceClientCount(models.ANNOUNCEMENT_AUDIENCE_ALL, nil))
+	assert.Equal(t, 1, manager.AudienceClientCount(models.ANNOUNCEMENT_AUDIENCE_ADMINS, nil))
+	assert.Equal(t, 1, manager.AudienceClientCount(models.ANNOUNCEMENT_AUDIENCE_USERS, []string{userID}))
+	assert.Equal(t, 0, manager.AudienceClientCount(
+		models.ANNOUNCEMENT_AUDIENCE_USERS, []string{clients["pending"].UserID.String()},
+	))
+}
+
+func TestManager_BroadcastSubscription_DeliversToAudience(t *testing.T) {
+	testCases := []struct {
+		name     string
+		audience string
+		userIDs  func(map[string]*Client) []string
+		expected []string
+	}{
+		{"everyone", models.ANNOUNCEMENT_AUDIENCE_ALL, nil, []string{"admin", "user"}},
+		{"admins", models.ANNOUNCEMENT_AUDIENCE_ADMINS, nil, []string{"admin"}},
+		{"listed users", models.ANNOUNCEMENT_AUDIENCE_USERS, func(clients map[string]*Client) []string {
+			return []string{clients["user"].UserID.String()}
+		}, []string{"user"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			manager, clients := setupAudienceTest()
+			manager.subscribeToBroadcastEvents()
+
+			var userIDs []string
+			if tc.userIDs != nil {
+				userIDs = tc.userIDs(clients)
+			}
+			require.NoError(t, manager.eventBus.BroadcastTopic().Publish(context.Background(), events.AdminBroadcastEvent{
+				ID:       "announcement-1",
+				Title:    "Maintenance",
+				Message:  "Back soon",
+				Severity: "info",
+				Audience: tc.audience,
+				UserIDs:  userIDs,
+			}))
+
+			for id, client := range clients {
+				select {
+				case message := <-client.send:
+					assert.Contains(t, tc.expected, id, "unexpected delivery")
+					assert.Equal(t, tc.audience, message.Data["audience"])
+					assert.NotContains(t, message.Data, "userIds")
+				case <-time.After(100 * time.Millisecond):
+					assert.NotContains(t, tc.expected, id, "expected a delivery")
+				}
+			}
+		})
+	}
+}
+
+func TestManager_SystemAlertSubscription_DeliversToAdmins(t *testing.T) {
+	manager, clients := setupAudienceTest()
+	manager.subscribeToSystemAlertEvents()
+
+	require.NoError(t, manager.eventBus.SystemAlertTopic().Publish(context.Background(), events.SystemAlertEvent{
+		Code:     "capacity_threshold",
+		Severity: events.ALERT_SEVERITY_WARNING,
+		Message:  "8 of 10 users",
+	}))
+
+	select {
+	case message := <-clients["admin"].send:
+		assert.Equal(t, "alert", message.Action)
+		assert.Equal(t, "capacity_threshold", message.Data["code"])
+	case <-time.After(time.Second):
+		t.Fatal("expected the admin to get the alert")
+	}
+	for _, id := range []string{"user", "pending"} {
+		select {
+		case <-clients[id].send:
+			t.Errorf("%s should not get system alerts", id)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestManager_IsAdmin(t *testing.T) {
+	adminID, userID := uuid.New(), uuid.New()
+	manager := &Manager{log: logger.New("test")}
+
+	// Without a lookup no client counts as an admin
+	assert.False(t, manager.isAdmin(adminID))
+
+	manager.SetUserLookup(fakeUserLookup{
+		adminID.String(): {IsAdmin: true},
+		userID.String():  {},
+	})
+	assert.True(t, manager.isAdmin(adminID))
+	assert.False(t, manager.isAdmin(userID))
+	assert.False(t, manager.isAdmin(uuid.New()))
+}
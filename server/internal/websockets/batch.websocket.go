@@ -0,0 +1,101 @@
+package websockets
+
+import (
+	"github.com/google/uuid"
+)
+
+// SendBatch sends each of targets a message built for them, resolving every
+// target's connections in one pass under the hub lock rather than one scan
+// per user. build is called once per target that has an authenticated
+// connection, under the hub lock, so it must not call back into the manager.
+// A connection whose send channel is full is skipped rather than retried.
+// Notification preferences aren't applied; leave out users who opted out.
+func (m *Manager) SendBatch(targets []uuid.UUID, build func(userID uuid.UUID) Message) (delivered, skipped int) {
+	log := m.log.Function("SendBatch")
+
+	wanted := make(map[uuid.UUID]bool, len(targets))
+	for _, userID := range targets {
+		wanted[userID] = true
+	}
+
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	connections := make(map[uuid.UUID][]*Client, len(wanted))
+	for _, client := range m.hub.clients {
+		if client.Status == StatusAuthenticated && wanted[client.UserID] {
+			connections[client.UserID] = append(connections[client.UserID], client)
+		}
+	}
+
+	for userID, clients := range connections {
+		message := m.streams.stamp(userID, build(userID))
+		for _, client := range clients {
+			if m.trySend(client, message) {
+				delivered++
+			} else {
+				skipped++
+			}
+		}
+	}
+
+	log.Sampled(m.broadcastLogSampler).Info(
+		"Batch sent",
+		"targets", len(wanted),
+		"connectedUsers", len(connections),
+		"delivered", delivered,
+		"skipped", skipped,
+	)
+	return delivered, skipped
+}
+
+// BroadcastToChannel sends message to every client subscribed to channel,
+// guests included.
+// A connection whose send channel is full is skipped rather than retried.
+func (m *Manager) BroadcastToChannel(channel string, message Message) (delivered, skipped int) {
+	log := m.log.Function("BroadcastToChannel")
+
+	if message.ID == "" {
+		message.ID = uuid.New().String()
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = m.now()
+	}
+	message.Channel = channel
+
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	for _, client := range m.hub.clients {
+		if client.Status == StatusClosed || !client.subscriptions[channel] {
+			continue
+		}
+		if m.trySend(client, message) {
+			delivered++
+		} else {
+			skipped++
+		}
+	}
+
+	log.Sampled(m.broadcastLogSampler).Info(
+		"Channel broadcast complete",
+		"channel", SanitizeLogString(channel),
+		"messageID", message.ID,
+		"delivered", delivered,
+		"skipped", skipped,
+	)
+	return delivered, skipped
+}
+
+// trySend queues message for client unless its send channel is full. Callers
+// hold the hub lock, which keeps the channel from being closed under them.
+func (m *Manager) trySend(client *Client, message Message) bool {
+	select {
+	case client.send <- message:
+		return true
+	default:
+		client.dropped.Add(1)
+		m.log.Function("trySend").Warn("Client send channel full, dropping message", "clientID", client.ID)
+		return false
+	}
+}
@@ -0,0 +1,189 @@
+package websockets
+
+import (
+	"fmt"
+	"server/config"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBatchManager(clients ...*Client) *Manager {
+	manager := &Manager{
+		hub:                 &Hub{clients: make(map[string]*Client)},
+		log:                 logger.New("test"),
+		broadcastLogSampler: logger.NewSampler(LOG_SAMPLE_LIMIT, LOG_SAMPLE_INTERVAL, nil),
+	}
+	for _, client := range clients {
+		client.Manager = manager
+		manager.hub.clients[client.ID] = client
+	}
+	return manager
+}
+
+func TestManager_SendBatch(t *testing.T) {
+	alice, bob, carol, absent := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	aliceWeb := authenticatedClient("alice-web", alice)
+	alicePhone := authenticatedClient("alice-phone", alice)
+	bobFull := &Client{ID: "bob", UserID: bob, Status: StatusAuthenticated, send: make(chan Message, 1)}
+	bobFull.send <- Message{ID: "queued"}
+	pending := &Client{ID: "carol-pending", UserID: carol, Status: StatusPending, send: make(chan Message, 10)}
+	bystander := authenticatedClient("bystander", uuid.New())
+	manager := newBatchManager(aliceWeb, alicePhone, bobFull, pending, bystander)
+
+	built := map[uuid.UUID]int{}
+	delivered, skipped := manager.SendBatch(
+		[]uuid.UUID{alice, bob, carol, absent, alice},
+		func(userID uuid.UUID) Message {
+			built[userID]++
+			return Message{ID: "for-" + userID.String(), Type: MessageTypeMessage}
+		},
+	)
+
+	assert.Equal(t, 2, delivered)
+	assert.Equal(t, 1, skipped, "bob's full channel is skipped")
+	// Built once per connected target, duplicates and offline users included
+	assert.Equal(t, map[uuid.UUID]int{alice: 1, bob: 1}, built)
+
+	for _, client := range []*Client{aliceWeb, alicePhone} {
+		assert.Equal(t, "for-"+alice.String(), receive(t, client).ID)
+	}
+	assert.Equal(t, "queued", receive(t, bobFull).ID)
+	assert.Empty(t, bobFull.send)
+	assert.Empty(t, pending.send, "unauthenticated clients aren't sent to")
+	assert.Empty(t, bystander.send)
+}
+
+func TestManager_SendBatch_NoTargets(t *testing.T) {
+	manager := newBatchManager(authenticatedClient("client", uuid.New()))
+
+	delivered, skipped := manager.SendBatch(nil, func(uuid.UUID) Message {
+		t.Fatal("build called without targets")
+		return Message{}
+	})
+	assert.Zero(t, delivered)
+	assert.Zero(t, skipped)
+}
+
+func TestManager_BroadcastToChannel(t *testing.T) {
+	guest := &Client{ID: "guest", Status: StatusUnauthenticated, send: make(chan Message, 10)}
+	member := authenticatedClient("member", uuid.New())
+	full := &Client{ID: "full", Status: StatusAuthenticated, UserID: uuid.New(), send: make(chan Message)}
+	closed := &Client{ID: "closed", Status: StatusClosed, send: make(chan Message, 10)}
+	other := authenticatedClient("other", uuid.New())
+	manager := newBatchManager(guest, member, full, closed, other)
+	for _, client := range []*Client{guest, member, full, closed} {
+		manager.setSubscription(client, "imports", true)
+	}
+	manager.setSubscription(other, "dashboard", true)
+
+	delivered, skipped := manager.BroadcastToChannel("imports", Message{Type: MessageTypeMessage})
+	assert.Equal(t, 2, delivered)
+	assert.Equal(t, 1, skipped)
+
+	for _, client := range []*Client{guest, member} {
+		message := receive(t, client)
+		assert.Equal(t, "imports", message.Channel)
+		assert.NotEmpty(t, message.ID)
+		assert.False(t, message.Timestamp.IsZero())
+	}
+	assert.Empty(t, closed.send)
+	assert.Empty(t, other.send)
+}
+
+func TestManager_SendBatch_ConcurrentWithUnregister(t *testing.T) {
+	cfg := config.Config{}
+	manager, err := New(database.DB{}, events.New(nil, cfg, nil), cfg, nil)
+	require.NoError(t, err)
+
+	userID := uuid.New()
+	clients := make([]*Client, 50)
+	for i := range clients {
+		clients[i] = &Client{
+			ID:      fmt.Sprintf("client-%d", i),
+			UserID:  userID,
+			Manager: manager,
+			Status:  StatusAuthenticated,
+			send:    make(chan Message, SendChannelSize),
+		}
+		manager.hub.register <- clients[i]
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for _, client := range clients {
+			manager.hub.unregister <- client
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 200 {
+			manager.SendBatch([]uuid.UUID{userID}, func(uuid.UUID) Message { return Message{Type: MessageTypeMessage} })
+		}
+	}()
+	wg.Wait()
+
+	require.Eventually(t, func() bool { return manager.ConnectionCount() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+// benchmarkHub has clientCount authenticated clients spread over users with
+// three connections each, and returns the user IDs.
+func benchmarkHub(b *testing.B, clientCount int) (*Manager, []uuid.UUID) {
+	b.Helper()
+
+	manager := newBatchManager()
+	manager.log = logger.New("bench")
+	userIDs := make([]uuid.UUID, clientCount/3)
+	for i := range userIDs {
+		userIDs[i] = uuid.New()
+	}
+	for i := range clientCount {
+		client := authenticatedClient(fmt.Sprintf("client-%d", i), userIDs[i%len(userIDs)])
+		client.Manager = manager
+		manager.hub.clients[client.ID] = client
+	}
+	return manager, userIDs
+}
+
+func emptySendChannels(manager *Manager) {
+	for _, client := range manager.hub.clients {
+		for len(client.send) > 0 {
+			<-client.send
+		}
+	}
+}
+
+func BenchmarkSendMessageToUser_PerTarget(b *testing.B) {
+	manager, userIDs := benchmarkHub(b, 3000)
+	targets := userIDs[:100]
+
+	for b.Loop() {
+		for _, userID := range targets {
+			manager.SendMessageToUser(userID, Message{Type: MessageTypeMessage})
+		}
+		b.StopTimer()
+		emptySendChannels(manager)
+		b.StartTimer()
+	}
+}
+
+func BenchmarkSendBatch(b *testing.B) {
+	manager, userIDs := benchmarkHub(b, 3000)
+	targets := userIDs[:100]
+
+	for b.Loop() {
+		manager.SendBatch(targets, func(uuid.UUID) Message { return Message{Type: MessageTypeMessage} })
+		b.StopTimer()
+		emptySendChannels(manager)
+		b.StartTimer()
+	}
+}
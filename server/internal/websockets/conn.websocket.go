@@ -0,0 +1,15 @@
+package websockets
+
+import "time"
+
+// Conn is the part of a websocket connection the manager uses. Connections
+// fiber upgrades implement it, as do the in-memory ones of package wstest.
+type Conn interface {
+	ReadJSON(v any) error
+	WriteMessage(messageType int, data []byte) error
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
@@ -0,0 +1,138 @@
+package websockets
+
+import (
+	"maps"
+	"server/config"
+	"slices"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	ErrorCodeReplaced            = "replaced"
+	ErrorCodeDuplicateConnection = "duplicate_connection"
+
+	// Longest deviceId a client may tag its connection with
+	DEVICE_ID_MAX_LENGTH = 128
+)
+
+// deviceKey identifies a tagged connection: one per user and device.
+type deviceKey struct {
+	userID   uuid.UUID
+	deviceID string
+}
+
+// claimDevice makes client the connection of its user's deviceID, applying
+// the duplicate connections policy to one already there. Under replace the
+// old connection's subscriptions move to client, and it is returned for
+// closeReplaced. Under reject ok is false and nothing changes. Untagged
+// connections always get through.
+func (m *Manager) claimDevice(client *Client, deviceID string) (replaced *Client, ok bool) {
+	if deviceID == "" {
+		return nil, true
+	}
+	key := deviceKey{userID: client.UserID, deviceID: deviceID}
+
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+
+	existing := m.hub.devices[key]
+	if existing != nil && existing != client {
+		if m.config.DuplicateConnections() == config.DUPLICATE_CONNECTIONS_REJECT {
+			return nil, false
+		}
+
+		if client.subscriptions == nil {
+			client.subscriptions = make(map[string]bool)
+		}
+		maps.Copy(client.subscriptions, existing.subscriptions)
+		// Its place is taken, so it mustn't come back through a resume either
+		existing.resumeToken = ""
+		replaced = existing
+	}
+
+	if m.hub.devices == nil {
+		m.hub.devices = make(map[deviceKey]*Client)
+	}
+	m.hub.devices[key] = client
+	client.DeviceID = deviceID
+	return replaced, true
+}
+
+// releaseDevice frees client's device for the next connection, unless it was
+// already replaced. Callers hold the hub mutex.
+func (m *Manager) releaseDevice(client *Client) {
+	if client.DeviceID == "" {
+		return
+	}
+	key := deviceKey{userID: client.UserID, deviceID: client.DeviceID}
+	if m.hub.devices[key] == client {
+		delete(m.hub.devices, key)
+	}
+}
+
+// closeReplaced tells a connection another one from its device took over,
+// and closes it.
+func (m *Manager) closeReplaced(client *Client) {
+	log := m.log.Function("closeReplaced")
+
+	message := Message{
+		ID:      uuid.New().String(),
+		Type:    MessageTypeError,
+		Channel: "system",
+		Action:  ErrorCodeReplaced,
+		Data: map[string]any{
+			"code":    ErrorCodeReplaced,
+			"message": "Another connection from this device took over",
+		},
+		Timestamp: m.now(),
+	}
+
+	m.hub.mutex.Lock()
+	if _, connected := m.hub.clients[client.ID]; connected {
+		select {
+		case client.send <- message:
+		default:
+			client.dropped.Add(1)
+			log.Warn("Client send channel full, dropping replaced message", "clientID", client.ID)
+		}
+	}
+	client.closeMessage = websocket.FormatCloseMessage(websocket.CloseNormalClosure, ErrorCodeReplaced)
+	client.setDisconnectReason(DISCONNECT_REPLACED)
+	m.hub.mutex.Unlock()
+
+	log.Info("Connection replaced", "clientID", client.ID, "userID", client.UserID, "deviceID", client.DeviceID)
+	m.hub.unregister <- client
+}
+
+// rejectDuplicate turns away a connection whose device is already connected,
+// under the reject policy.
+func (c *Client) rejectDuplicate(deviceID string) {
+	c.send <- Message{
+		ID:      uuid.New().String(),
+		Type:    MessageTypeError,
+		Channel: "system",
+		Action:  ErrorCodeDuplicateConnection,
+		Data: map[string]any{
+			"code":     ErrorCodeDuplicateConnection,
+			"message":  "This device is already connected",
+			"deviceId": deviceID,
+		},
+		Timestamp: c.Manager.now(),
+	}
+
+	c.Manager.log.Function("rejectDuplicate").
+		Info("Duplicate connection refused", "clientID", c.ID, "userID", c.UserID, "deviceID", deviceID)
+
+	c.closeMessage = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ErrorCodeDuplicateConnection)
+	c.setDisconnectReason(DISCONNECT_AUTH_FAILURE)
+	c.Manager.hub.unregister <- c
+}
+
+// subscriptionsOf lists the channels client listens on, sorted.
+func (m *Manager) subscriptionsOf(client *Client) []string {
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+	return slices.Sorted(maps.Keys(client.subscriptions))
+}
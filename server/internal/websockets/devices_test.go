@@ -0,0 +1,176 @@
+package websockets
+
+import (
+	"server/config"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/utils/testsupport"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDeviceManager(t *testing.T, policy string) *Manager {
+	t.Helper()
+	cfg := testsupport.WithKey(config.Config{
+		Websocket: config.WebsocketConfig{
+			PublicChannels:       "dashboard, status",
+			DuplicateConnections: policy,
+		},
+	})
+	manager, err := New(database.DB{}, events.New(nil, cfg, nil), cfg, testsupport.FreezeTime(t))
+	require.NoError(t, err)
+	return manager
+}
+
+// connectDevice registers a client through the hub and authenticates it as
+// userID, tagged with deviceID when it isn't empty.
+func connectDevice(t *testing.T, manager *Manager, id string, userID uuid.UUID, deviceID string) *Client {
+	t.Helper()
+	client := &Client{
+		ID:      id,
+		Status:  StatusUnauthenticated,
+		Manager: manager,
+		send:    make(chan Message, SendChannelSize),
+	}
+	connected := manager.ConnectionCount()
+	manager.hub.register <- client
+	require.Eventually(t, func() bool { return manager.ConnectionCount() == connected+1 }, time.Second, time.Millisecond)
+
+	token := testsupport.MintTestToken(t, userID, testsupport.TokenOptions{Clock: manager.clock})
+	data := map[string]any{"token": token}
+	if deviceID != "" {
+		data["deviceId"] = deviceID
+	}
+	client.routeMessage(Message{Type: MessageTypeAuthResponse, Data: data})
+	return client
+}
+
+func waitClosed(t *testing.T, client *Client) {
+	t.Helper()
+	require.Eventually(t, func() bool { return isClosed(client) }, time.Second, time.Millisecond)
+}
+
+func TestDuplicateConnections_Replace(t *testing.T) {
+	manager := newDeviceManager(t, "")
+	userID := uuid.New()
+
+	old := connectDevice(t, manager, "old", userID, "laptop")
+	require.Equal(t, MessageTypeAuthSuccess, receive(t, old).Type)
+	old.handleSubscription(Message{Type: MessageTypeSubscribe, Channel: "dashboard"})
+	require.Equal(t, MessageTypeSubscribed, receive(t, old).Type)
+
+	replacement := connectDevice(t, manager, "replacement", userID, "laptop")
+
+	success := receive(t, replacement)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+	assert.Equal(t, true, success.Data["replaced"])
+	assert.Equal(t, []string{"dashboard"}, success.Data["subscriptions"])
+
+	replaced := receive(t, old)
+	assert.Equal(t, MessageTypeError, replaced.Type)
+	assert.Equal(t, ErrorCodeReplaced, replaced.Data["code"])
+	waitClosed(t, old)
+	assert.Equal(t, fasthttpws.FormatCloseMessage(fasthttpws.CloseNormalClosure, ErrorCodeReplaced), old.closeMessage)
+	assert.Equal(t, 1, manager.ConnectionCount())
+
+	// Broadcasts to the channel reach the replacement
+	sent, err := manager.BroadcastToPublicChannel("dashboard", Message{Type: MessageTypeBroadcast})
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, MessageTypeBroadcast, receive(t, replacement).Type)
+
+	// The old connection's read pump unregistering it again leaves the
+	// replacement in place, so a third connection replaces it in turn
+	manager.unregisterClient(old)
+	third := connectDevice(t, manager, "third", userID, "laptop")
+	assert.Equal(t, true, receive(t, third).Data["replaced"])
+	waitClosed(t, replacement)
+}
+
+func TestDuplicateConnections_Reject(t *testing.T) {
+	manager := newDeviceManager(t, config.DUPLICATE_CONNECTIONS_REJECT)
+	userID := uuid.New()
+
+	first := connectDevice(t, manager, "first", userID, "laptop")
+	require.Equal(t, MessageTypeAuthSuccess, receive(t, first).Type)
+
+	duplicate := connectDevice(t, manager, "duplicate", userID, "laptop")
+
+	refusal := receive(t, duplicate)
+	assert.Equal(t, MessageTypeError, refusal.Type)
+	assert.Equal(t, ErrorCodeDuplicateConnection, refusal.Data["code"])
+	assert.Equal(t, StatusUnauthenticated, duplicate.Status)
+	waitClosed(t, duplicate)
+	assert.Equal(t, fasthttpws.FormatCloseMessage(fasthttpws.ClosePolicyViolation, ErrorCodeDuplicateConnection), duplicate.closeMessage)
+
+	// The first connection is untouched
+	assert.False(t, isClosed(first))
+	assert.Equal(t, 1, manager.AuthenticatedClientCount())
+
+	// Once it goes, the device can connect again
+	manager.hub.unregister <- first
+	waitClosed(t, first)
+	again := connectDevice(t, manager, "again", userID, "laptop")
+	assert.Equal(t, MessageTypeAuthSuccess, receive(t, again).Type)
+}
+
+func TestDuplicateConnections_UntaggedAndOtherUsersCoexist(t *testing.T) {
+	manager := newDeviceManager(t, config.DUPLICATE_CONNECTIONS_REJECT)
+	userID := uuid.New()
+
+	clients := []*Client{
+		connectDevice(t, manager, "untagged-1", userID, ""),
+		connectDevice(t, manager, "untagged-2", userID, ""),
+		connectDevice(t, manager, "tagged", userID, "laptop"),
+		connectDevice(t, manager, "other-device", userID, "phone"),
+		connectDevice(t, manager, "other-user", uuid.New(), "laptop"),
+	}
+
+	for _, client := range clients {
+		success := receive(t, client)
+		assert.Equal(t, MessageTypeAuthSuccess, success.Type, client.ID)
+		assert.Nil(t, success.Data["replaced"], client.ID)
+	}
+	assert.Equal(t, 5, manager.AuthenticatedClientCount())
+}
+
+func TestDuplicateConnections_DeviceIDTooLong(t *testing.T) {
+	manager := newDeviceManager(t, "")
+
+	client := connectDevice(t, manager, "client", uuid.New(), string(make([]byte, DEVICE_ID_MAX_LENGTH+1)))
+
+	assert.Equal(t, MessageTypeError, receive(t, client).Type)
+	assert.Equal(t, StatusUnauthenticated, client.Status)
+}
+
+func TestManager_DisconnectUser(t *testing.T) {
+	manager := newDeviceManager(t, "")
+	userID := uuid.New()
+
+	laptop := connectDevice(t, manager, "laptop", userID, "laptop")
+	require.Equal(t, MessageTypeAuthSuccess, receive(t, laptop).Type)
+	phone := connectDevice(t, manager, "phone", userID, "phone")
+	require.Equal(t, MessageTypeAuthSuccess, receive(t, phone).Type)
+	other := connectDevice(t, manager, "other", uuid.New(), "")
+	require.Equal(t, MessageTypeAuthSuccess, receive(t, other).Type)
+
+	assert.Equal(t, 2, manager.DisconnectUser(userID.String(), "logged_out"))
+	for _, client := range []*Client{laptop, phone} {
+		disconnected := receive(t, client)
+		assert.Equal(t, ErrorCodeDisconnected, disconnected.Data["code"])
+		assert.Equal(t, "logged_out", disconnected.Data["reason"])
+		waitClosed(t, client)
+		assert.Empty(t, client.resumeToken)
+		assert.Equal(t, fasthttpws.FormatCloseMessage(fasthttpws.CloseNormalClosure, "logged_out"), client.closeMessage)
+	}
+	assert.Equal(t, 1, manager.ConnectionCount())
+
+	assert.Zero(t, manager.DisconnectUser(userID.String(), "logged_out"))
+	assert.Zero(t, manager.DisconnectUser("not-a-uuid", "logged_out"))
+	assert.Error(t, manager.SendToUser("not-a-uuid", Message{}))
+}
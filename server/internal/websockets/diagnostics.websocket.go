@@ -0,0 +1,219 @@
+package websockets
+
+import (
+	"fmt"
+	"server/internal/models"
+	"server/internal/validate"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// A client reports on its connection with a message on the system
+	// channel with this action, see DiagnosticsData
+	ActionDiagnostics = "diagnostics"
+
+	// Reports kept per user, the oldest dropped first
+	DIAGNOSTICS_HISTORY = 20
+	// How long a user's reports are kept after the last one came in
+	DIAGNOSTICS_TTL = 24 * time.Hour
+	// The least time between two reports from one connection
+	DIAGNOSTICS_INTERVAL = 30 * time.Second
+	// A latency above this is a client bug rather than a slow network
+	DIAGNOSTICS_MAX_LATENCY_MS = 10 * 60 * 1000
+
+	ErrorCodeRateLimited = "rate_limited"
+)
+
+// DiagnosticsData is what a client measured of its connection: the latency
+// it sees, how often it had to reconnect and the last error it ran into.
+type DiagnosticsData struct {
+	LatencyMs      uint   `json:"latencyMs"`
+	ReconnectCount uint   `json:"reconnectCount"`
+	LastError      string `json:"lastError" validate:"max=500"`
+}
+
+// ConnectionStats is what the server measured of one connection. PingRTTMs
+// is nil until the first pong came back.
+type ConnectionStats struct {
+	ClientID        string         `json:"clientId"`
+	DeviceID        string         `json:"deviceId,omitempty"`
+	ProtocolVersion int            `json:"protocolVersion"`
+	ConnectedAt     models.APITime `json:"connectedAt"`
+	PingRTTMs       *float64       `json:"pingRttMs"`
+	DroppedMessages uint64         `json:"droppedMessages"`
+}
+
+// DiagnosticsReport is a client's report with the server's stats of the
+// connection it came over, as they were when it came in.
+type DiagnosticsReport struct {
+	ReceivedAt models.APITime  `json:"receivedAt"`
+	Client     DiagnosticsData `json:"client"`
+	Server     ConnectionStats `json:"server"`
+}
+
+// UserDiagnostics is what support looks at when a user reports lag: their
+// recent reports, newest first, and the stats of their open connections.
+type UserDiagnostics struct {
+	UserID      string              `json:"userId"`
+	Reports     []DiagnosticsReport `json:"reports"`
+	Connections []ConnectionStats   `json:"connections"`
+}
+
+type userReports struct {
+	reports []DiagnosticsReport
+	lastAt  time.Time
+}
+
+// diagnosticsHistory keeps the last DIAGNOSTICS_HISTORY reports of each user
+// in this instance's memory, for DIAGNOSTICS_TTL after their last one.
+type diagnosticsHistory struct {
+	mutex  sync.Mutex
+	byUser map[uuid.UUID]*userReports
+}
+
+func (h *diagnosticsHistory) add(userID uuid.UUID, report DiagnosticsReport, now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for id, user := range h.byUser {
+		if now.Sub(user.lastAt) >= DIAGNOSTICS_TTL {
+			delete(h.byUser, id)
+		}
+	}
+	if h.byUser == nil {
+		h.byUser = make(map[uuid.UUID]*userReports)
+	}
+	user, ok := h.byUser[userID]
+	if !ok {
+		user = &userReports{}
+		h.byUser[userID] = user
+	}
+	if len(user.reports) == DIAGNOSTICS_HISTORY {
+		user.reports = append(user.reports[:0], user.reports[1:]...)
+	}
+	user.reports = append(user.reports, report)
+	user.lastAt = now
+}
+
+// recent is the user's reports, newest first.
+func (h *diagnosticsHistory) recent(userID uuid.UUID, now time.Time) []DiagnosticsReport {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	user, ok := h.byUser[userID]
+	if !ok || now.Sub(user.lastAt) >= DIAGNOSTICS_TTL {
+		return []DiagnosticsReport{}
+	}
+	reports := slices.Clone(user.reports)
+	slices.Reverse(reports)
+	return reports
+}
+
+// stats is what the server measured of the connection so far.
+func (c *Client) stats() ConnectionStats {
+	stats := ConnectionStats{
+		ClientID:        c.ID,
+		DeviceID:        c.DeviceID,
+		ProtocolVersion: c.ProtocolVersion(),
+		ConnectedAt:     models.NewAPITime(c.connectedAt),
+		DroppedMessages: c.dropped.Load(),
+	}
+	if rtt := c.pingRTT.Load(); rtt > 0 {
+		ms := float64(rtt) / float64(time.Millisecond)
+		stats.PingRTTMs = &ms
+	}
+	return stats
+}
+
+// pingPayload stamps a ping with when it was sent, which its pong echoes
+// back for recordPong.
+func (c *Client) pingPayload() []byte {
+	return []byte(strconv.FormatInt(c.Manager.now().UnixNano(), 10))
+}
+
+// recordPong takes the round trip time from a pong to a ping of
+// pingPayload. Pongs the client sent unprompted carry anything else and are
+// ignored.
+func (c *Client) recordPong(appData string) {
+	sent, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return
+	}
+	if rtt := c.Manager.now().Sub(time.Unix(0, sent)); rtt > 0 {
+		c.pingRTT.Store(int64(rtt))
+	}
+}
+
+// handleDiagnostics keeps a client's report on its connection. A client may
+// report once every DIAGNOSTICS_INTERVAL; earlier reports are answered with
+// an error, without counting as a strike.
+func (c *Client) handleDiagnostics(data DiagnosticsData) {
+	log := c.Manager.log.Function("handleDiagnostics")
+
+	if data.LatencyMs > DIAGNOSTICS_MAX_LATENCY_MS {
+		c.strike(validate.Fail("data.latencyMs", validate.CODE_INVALID,
+			fmt.Sprintf("must be at most %d", DIAGNOSTICS_MAX_LATENCY_MS)))
+		return
+	}
+
+	now := c.Manager.now()
+	if !c.lastDiagnosticsAt.IsZero() && now.Sub(c.lastDiagnosticsAt) < DIAGNOSTICS_INTERVAL {
+		retryAfter := c.lastDiagnosticsAt.Add(DIAGNOSTICS_INTERVAL).Sub(now)
+		c.send <- Message{
+			ID:      uuid.New().String(),
+			Type:    MessageTypeError,
+			Channel: "system",
+			Action:  ErrorCodeRateLimited,
+			Data: map[string]any{
+				"code":       ErrorCodeRateLimited,
+				"action":     ActionDiagnostics,
+				"retryAfter": int(retryAfter.Round(time.Second).Seconds()),
+			},
+			Timestamp: now,
+		}
+		return
+	}
+	c.lastDiagnosticsAt = now
+
+	c.Manager.diagnostics.add(c.UserID, DiagnosticsReport{
+		ReceivedAt: models.NewAPITime(now),
+		Client:     data,
+		Server:     c.stats(),
+	}, now)
+	log.Debug("Diagnostics reported", "clientID", c.ID, "userID", c.UserID,
+		"latencyMs", data.LatencyMs, "reconnects", data.ReconnectCount)
+}
+
+// UserDiagnostics is the user's recent reports and the stats of their
+// connections to this instance. Other instances keep their own.
+func (m *Manager) UserDiagnostics(userID string) UserDiagnostics {
+	diagnostics := UserDiagnostics{
+		UserID:      userID,
+		Reports:     []DiagnosticsReport{},
+		Connections: []ConnectionStats{},
+	}
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return diagnostics
+	}
+
+	diagnostics.Reports = m.diagnostics.recent(id, m.now())
+
+	m.hub.mutex.RLock()
+	for _, client := range m.hub.clients {
+		if client.Status == StatusAuthenticated && client.UserID == id {
+			diagnostics.Connections = append(diagnostics.Connections, client.stats())
+		}
+	}
+	m.hub.mutex.RUnlock()
+
+	slices.SortFunc(diagnostics.Connections, func(a, b ConnectionStats) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt.Time)
+	})
+	return diagnostics
+}
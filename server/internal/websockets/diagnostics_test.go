@@ -0,0 +1,194 @@
+package websockets
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectDiagnosticsClient registers an authenticated client of userID.
+func connectDiagnosticsClient(manager *Manager, id string, userID uuid.UUID) *Client {
+	client := connectResumeClient(manager, id)
+	client.Status = StatusAuthenticated
+	client.UserID = userID
+	client.connectedAt = manager.now()
+	return client
+}
+
+func reportDiagnostics(client *Client, data map[string]any) {
+	client.routeMessage(Message{Type: MessageTypeMessage, Channel: "system", Action: ActionDiagnostics, Data: data})
+}
+
+func TestDecodeMessageData_Diagnostics(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     map[string]any
+		expected DiagnosticsData
+		field    string
+	}{
+		{
+			name:     "full report",
+			data:     map[string]any{"latencyMs": float64(120), "reconnectCount": float64(3), "lastError": "timeout"},
+			expected: DiagnosticsData{LatencyMs: 120, ReconnectCount: 3, LastError: "timeout"},
+		},
+		{name: "empty report", data: nil, expected: DiagnosticsData{}},
+		{name: "negative latency", data: map[string]any{"latencyMs": float64(-1)}, field: "data.latencyMs"},
+		{name: "latency not a number", data: map[string]any{"latencyMs": "fast"}, field: "data.latencyMs"},
+		{name: "error too long", data: map[string]any{"lastError": strings.Repeat("e", 501)}, field: "data.lastError"},
+		{name: "unknown field", data: map[string]any{"userAgent": "curl"}, field: "data.userAgent"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, fieldErr := DecodeMessageData(Message{
+				Type: MessageTypeMessage, Channel: "system", Action: ActionDiagnostics, Data: tc.data,
+			})
+
+			if tc.field == "" {
+				require.Nil(t, fieldErr)
+				assert.Equal(t, tc.expected, data)
+				return
+			}
+			require.NotNil(t, fieldErr)
+			assert.Equal(t, tc.field, fieldErr.Field)
+		})
+	}
+
+	// Other actions on the system channel keep the raw map
+	raw := map[string]any{"latencyMs": "fast"}
+	data, fieldErr := DecodeMessageData(Message{Type: MessageTypeMessage, Channel: "system", Action: "typing", Data: raw})
+	require.Nil(t, fieldErr)
+	assert.Equal(t, raw, data)
+}
+
+func TestHandleDiagnostics_InvalidReportStrikes(t *testing.T) {
+	manager, _, _ := newResumeManager(t, nil)
+	client := connectDiagnosticsClient(manager, "client-1", uuid.New())
+
+	reportDiagnostics(client, map[string]any{"latencyMs": float64(DIAGNOSTICS_MAX_LATENCY_MS + 1)})
+
+	reply := receive(t, client)
+	assert.Equal(t, ErrorCodeInvalidField, reply.Action)
+	assert.Equal(t, "data.latencyMs", reply.Data["field"])
+	assert.Equal(t, 1, client.strikes)
+	assert.Empty(t, manager.UserDiagnostics(client.UserID.String()).Reports)
+}
+
+func TestHandleDiagnostics_RateLimited(t *testing.T) {
+	manager, _, fake := newResumeManager(t, nil)
+	client := connectDiagnosticsClient(manager, "client-1", uuid.New())
+
+	reportDiagnostics(client, map[string]any{"latencyMs": float64(100)})
+	assert.Empty(t, client.send, "an accepted report isn't answered")
+
+	fake.Advance(DIAGNOSTICS_INTERVAL - 10*time.Second)
+	reportDiagnostics(client, map[string]any{"latencyMs": float64(200)})
+
+	reply := receive(t, client)
+	assert.Equal(t, MessageTypeError, reply.Type)
+	assert.Equal(t, ErrorCodeRateLimited, reply.Action)
+	assert.Equal(t, 10, reply.Data["retryAfter"])
+	assert.Zero(t, client.strikes, "reporting too often isn't invalid")
+
+	fake.Advance(10 * time.Second)
+	reportDiagnostics(client, map[string]any{"latencyMs": float64(300)})
+	assert.Empty(t, client.send)
+
+	reports := manager.UserDiagnostics(client.UserID.String()).Reports
+	require.Len(t, reports, 2)
+	assert.Equal(t, uint(300), reports[0].Client.LatencyMs)
+	assert.Equal(t, uint(100), reports[1].Client.LatencyMs)
+
+	// The limit is per connection
+	other := connectDiagnosticsClient(manager, "client-2", client.UserID)
+	reportDiagnostics(other, map[string]any{"latencyMs": float64(400)})
+	assert.Empty(t, other.send)
+}
+
+func TestHandleDiagnostics_KeepsLastReports(t *testing.T) {
+	manager, _, fake := newResumeManager(t, nil)
+	userID := uuid.New()
+	client := connectDiagnosticsClient(manager, "client-1", userID)
+
+	for i := range DIAGNOSTICS_HISTORY + 5 {
+		reportDiagnostics(client, map[string]any{"reconnectCount": float64(i)})
+		fake.Advance(DIAGNOSTICS_INTERVAL)
+	}
+
+	reports := manager.UserDiagnostics(userID.String()).Reports
+	require.Len(t, reports, DIAGNOSTICS_HISTORY)
+	assert.Equal(t, uint(DIAGNOSTICS_HISTORY+4), reports[0].Client.ReconnectCount, "newest first")
+	assert.Equal(t, uint(5), reports[DIAGNOSTICS_HISTORY-1].Client.ReconnectCount, "oldest dropped")
+
+	// A user's reports go once they stop coming in
+	fake.Advance(DIAGNOSTICS_TTL)
+	assert.Empty(t, manager.UserDiagnostics(userID.String()).Reports)
+}
+
+func TestManager_UserDiagnostics_MergesServerStats(t *testing.T) {
+	manager, _, fake := newResumeManager(t, nil)
+	userID := uuid.New()
+	first := connectDiagnosticsClient(manager, "client-1", userID)
+	first.DeviceID = "phone"
+	fake.Advance(time.Minute)
+	second := connectDiagnosticsClient(manager, "client-2", userID)
+	connectDiagnosticsClient(manager, "client-3", uuid.New())
+	connectResumeClient(manager, "guest")
+
+	first.dropped.Add(3)
+	first.recordPong(strconv.FormatInt(fake.Now().Add(-40*time.Millisecond).UnixNano(), 10))
+	reportDiagnostics(first, map[string]any{"latencyMs": float64(180), "reconnectCount": float64(2), "lastError": "timeout"})
+
+	diagnostics := manager.UserDiagnostics(userID.String())
+	assert.Equal(t, userID.String(), diagnostics.UserID)
+
+	require.Len(t, diagnostics.Connections, 2)
+	assert.Equal(t, "client-1", diagnostics.Connections[0].ClientID, "oldest connection first")
+	assert.Equal(t, "phone", diagnostics.Connections[0].DeviceID)
+	assert.Equal(t, uint64(3), diagnostics.Connections[0].DroppedMessages)
+	require.NotNil(t, diagnostics.Connections[0].PingRTTMs)
+	assert.InDelta(t, 40, *diagnostics.Connections[0].PingRTTMs, 0.001)
+	assert.Equal(t, second.ID, diagnostics.Connections[1].ClientID)
+	assert.Nil(t, diagnostics.Connections[1].PingRTTMs, "no pong yet")
+
+	require.Len(t, diagnostics.Reports, 1)
+	report := diagnostics.Reports[0]
+	assert.Equal(t, DiagnosticsData{LatencyMs: 180, ReconnectCount: 2, LastError: "timeout"}, report.Client)
+	assert.Equal(t, diagnostics.Connections[0], report.Server, "the report carries the stats of its connection")
+	assert.True(t, report.ReceivedAt.Equal(fake.Now()))
+
+	// Reports outlive their connection
+	manager.unregisterClient(first)
+	diagnostics = manager.UserDiagnostics(userID.String())
+	assert.Len(t, diagnostics.Connections, 1)
+	assert.Len(t, diagnostics.Reports, 1)
+
+	for _, userID := range []string{uuid.New().String(), "not-a-uuid"} {
+		diagnostics := manager.UserDiagnostics(userID)
+		assert.Empty(t, diagnostics.Reports, userID)
+		assert.NotNil(t, diagnostics.Reports, userID)
+		assert.NotNil(t, diagnostics.Connections, userID)
+	}
+}
+
+func TestClient_RecordPong(t *testing.T) {
+	manager, _, fake := newResumeManager(t, nil)
+	client := connectDiagnosticsClient(manager, "client-1", uuid.New())
+
+	payload := string(client.pingPayload())
+	fake.Advance(25 * time.Millisecond)
+	client.recordPong(payload)
+	assert.Equal(t, int64(25*time.Millisecond), client.pingRTT.Load())
+
+	// Pongs that don't answer our pings leave it be
+	for _, appData := range []string{"", "hello", fmt.Sprint(fake.Now().Add(time.Second).UnixNano())} {
+		client.recordPong(appData)
+		assert.Equal(t, int64(25*time.Millisecond), client.pingRTT.Load(), appData)
+	}
+}
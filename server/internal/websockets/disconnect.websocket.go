@@ -0,0 +1,171 @@
+package websockets
+
+import (
+	"errors"
+	"net"
+	"server/internal/metrics"
+	"sync"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+const (
+	// Why a connection ended. Closing one side makes the other fail as well,
+	// so only the first reason given for a connection counts.
+	//
+	// DISCONNECT_CLIENT_CLOSE is the client sending a close frame, and
+	// DISCONNECT_READ_ERROR the connection breaking any other way while
+	// reading. DISCONNECT_PONG_TIMEOUT is no pong within the pong timeout.
+	// DISCONNECT_WRITE_ERROR is a failed write, including clients too slow to
+	// take their messages. DISCONNECT_AUTH_FAILURE is a connection refused
+	// while authenticating, DISCONNECT_SERVER_DRAIN one closed or refused by
+	// a drain or maintenance, and DISCONNECT_REPLACED one another connection
+	// from its device took over. DISCONNECT_KICKED is the server closing it
+	// for what it sent, or an admin disconnecting its user.
+	DISCONNECT_CLIENT_CLOSE = "client_close"
+	DISCONNECT_READ_ERROR   = "read_error"
+	DISCONNECT_PONG_TIMEOUT = "pong_timeout"
+	DISCONNECT_WRITE_ERROR  = "write_error"
+	DISCONNECT_AUTH_FAILURE = "auth_failure"
+	DISCONNECT_SERVER_DRAIN = "server_drain"
+	DISCONNECT_REPLACED     = "replaced"
+	DISCONNECT_KICKED       = "kicked"
+
+	// How far back Stats and the health check count disconnects
+	DISCONNECT_WINDOW = 15 * time.Minute
+)
+
+var WebsocketDisconnects = metrics.NewLabeledCounter(
+	"websocket_disconnects_total", "Websocket connections closed, by reason.", "reason",
+)
+
+func init() {
+	metrics.Register(WebsocketDisconnects)
+}
+
+type disconnectBucket struct {
+	minute  int64
+	reasons map[string]int
+}
+
+// disconnectWindow counts disconnects by reason over the last
+// DISCONNECT_WINDOW in one minute buckets.
+type disconnectWindow struct {
+	mutex   sync.Mutex
+	buckets [int(DISCONNECT_WINDOW / time.Minute)]disconnectBucket
+}
+
+func (w *disconnectWindow) record(now time.Time, reason string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	minute := now.Unix() / 60
+	bucket := &w.buckets[minute%int64(len(w.buckets))]
+	if bucket.minute != minute || bucket.reasons == nil {
+		*bucket = disconnectBucket{minute: minute, reasons: make(map[string]int)}
+	}
+	bucket.reasons[reason]++
+}
+
+// counts sums the buckets still inside the window, nil when there are none.
+func (w *disconnectWindow) counts(now time.Time) map[string]int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	minute := now.Unix() / 60
+	var counts map[string]int
+	for _, bucket := range w.buckets {
+		if bucket.reasons == nil || minute-bucket.minute >= int64(len(w.buckets)) {
+			continue
+		}
+		if counts == nil {
+			counts = make(map[string]int)
+		}
+		for reason, count := range bucket.reasons {
+			counts[reason] += count
+		}
+	}
+	return counts
+}
+
+// setDisconnectReason records why client is being closed, unless a reason
+// was already given.
+func (c *Client) setDisconnectReason(reason string) {
+	c.disconnectReason.CompareAndSwap(nil, &reason)
+}
+
+// DisconnectReason is why client was closed, one of the DISCONNECT_
+// constants, or empty while it is open.
+func (c *Client) DisconnectReason() string {
+	if reason := c.disconnectReason.Load(); reason != nil {
+		return *reason
+	}
+	return ""
+}
+
+// readErrorReason tells why reading from a connection failed.
+func readErrorReason(err error) string {
+	var netErr net.Error
+	switch {
+	// Any close frame the client sent. A connection dropped without one is
+	// reported as an abnormal closure.
+	case websocket.IsUnexpectedCloseError(err, websocket.CloseAbnormalClosure):
+		return DISCONNECT_CLIENT_CLOSE
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return DISCONNECT_PONG_TIMEOUT
+	}
+	return DISCONNECT_READ_ERROR
+}
+
+// closeWith has the write pump send client a close frame with code and text
+// and close the connection, once what is queued has been sent. Only the
+// first close frame given is sent. A client that isn't in the hub has its
+// connection closed right away.
+func (m *Manager) closeWith(client *Client, code int, text string) {
+	m.hub.mutex.Lock()
+	_, registered := m.hub.clients[client.ID]
+	if registered && client.closeMessage == nil {
+		client.closeMessage = websocket.FormatCloseMessage(code, text)
+	}
+	m.hub.mutex.Unlock()
+
+	if !registered {
+		if client.Connection != nil {
+			_ = client.Connection.Close()
+		}
+		return
+	}
+	m.hub.unregister <- client
+}
+
+// countDisconnect counts a connection ending for reason.
+func (m *Manager) countDisconnect(reason string) {
+	WebsocketDisconnects.Add(reason, 1)
+	m.disconnects.record(m.now(), reason)
+}
+
+// recordDisconnect logs and counts client leaving the hub. Every path out
+// gives a reason first, so a client without one simply stopped reading.
+func (m *Manager) recordDisconnect(client *Client) {
+	reason := client.DisconnectReason()
+	if reason == "" {
+		client.setDisconnectReason(DISCONNECT_READ_ERROR)
+		reason = client.DisconnectReason()
+	}
+	m.countDisconnect(reason)
+
+	m.log.Function("recordDisconnect").Info(
+		"Client disconnected",
+		"clientID", client.ID,
+		"userID", client.UserID,
+		"reason", reason,
+		"duration", m.now().Sub(client.connectedAt).String(),
+	)
+}
+
+// RecentDisconnects counts the connections closed in the last
+// DISCONNECT_WINDOW by reason, nil when there were none.
+func (m *Manager) RecentDisconnects() map[string]int {
+	return m.disconnects.counts(m.now())
+}
@@ -0,0 +1,209 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"server/config"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/utils/testsupport"
+	"server/internal/websockets/wstest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDisconnectManager(t *testing.T) *Manager {
+	t.Helper()
+	cfg := testsupport.WithKey(config.Config{})
+	manager, err := New(database.DB{}, events.New(nil, cfg, nil), cfg, testsupport.FreezeTime(t))
+	require.NoError(t, err)
+	return manager
+}
+
+// authenticatePeer logs peer in as userID from deviceID, when it isn't empty.
+func authenticatePeer(t *testing.T, manager *Manager, peer *wstest.Peer, userID uuid.UUID, deviceID string) {
+	t.Helper()
+	data := map[string]any{"token": testsupport.MintTestToken(t, userID, testsupport.TokenOptions{Clock: manager.clock})}
+	if deviceID != "" {
+		data["deviceId"] = deviceID
+	}
+	peer.Send(Message{Type: MessageTypeAuthResponse, Data: data})
+	var authSuccess Message
+	peer.Receive(&authSuccess)
+	require.Equal(t, MessageTypeAuthSuccess, authSuccess.Type)
+}
+
+// expectCloseFrame waits for peer to be closed with a close frame of code and
+// text.
+func expectCloseFrame(t *testing.T, peer *wstest.Peer, code int, text string) {
+	t.Helper()
+	var closeErr *fasthttpws.CloseError
+	require.ErrorAs(t, peer.WaitClosed(time.Second), &closeErr)
+	assert.Equal(t, code, closeErr.Code)
+	assert.Equal(t, text, closeErr.Text)
+}
+
+func expectDisconnects(t *testing.T, manager *Manager, expected map[string]int) {
+	t.Helper()
+	require.Eventually(t, func() bool { return len(manager.RecentDisconnects()) == len(expected) }, time.Second, time.Millisecond)
+	assert.Equal(t, expected, manager.RecentDisconnects())
+	assert.Equal(t, expected, manager.Stats().RecentDisconnects)
+}
+
+// failingWrites fails text writes once fail is set, as a broken connection
+// would.
+type failingWrites struct {
+	*fasthttpws.Conn
+	fail atomic.Bool
+}
+
+func (f *failingWrites) WriteMessage(messageType int, data []byte) error {
+	if messageType == fasthttpws.TextMessage && f.fail.Load() {
+		return errors.New("broken pipe")
+	}
+	return f.Conn.WriteMessage(messageType, data)
+}
+
+func TestDisconnect_ClientClose(t *testing.T) {
+	manager := newDisconnectManager(t)
+	peer, done := connectPeer(t, manager)
+
+	before := WebsocketDisconnects.Value(DISCONNECT_CLIENT_CLOSE)
+	peer.SendClose(fasthttpws.CloseNormalClosure, "bye")
+
+	// The server answers with the code alone
+	expectCloseFrame(t, peer, fasthttpws.CloseNormalClosure, "")
+	waitServed(t, done)
+	expectDisconnects(t, manager, map[string]int{DISCONNECT_CLIENT_CLOSE: 1})
+	assert.Equal(t, before+1, WebsocketDisconnects.Value(DISCONNECT_CLIENT_CLOSE))
+}
+
+func TestDisconnect_ReadError(t *testing.T) {
+	manager := newDisconnectManager(t)
+	peer, done := connectPeer(t, manager)
+
+	require.NoError(t, peer.Close())
+
+	waitServed(t, done)
+	expectDisconnects(t, manager, map[string]int{DISCONNECT_READ_ERROR: 1})
+}
+
+func TestDisconnect_PongTimeout(t *testing.T) {
+	manager, peer, done := servePeer(t, time.Hour, 50*time.Millisecond)
+
+	require.Error(t, peer.WaitClosed(time.Second))
+	waitServed(t, done)
+	expectDisconnects(t, manager, map[string]int{DISCONNECT_PONG_TIMEOUT: 1})
+}
+
+func TestDisconnect_WriteError(t *testing.T) {
+	manager := newDisconnectManager(t)
+	server, peer := wstest.Pipe(t)
+	conn := &failingWrites{Conn: server}
+	done := servePipe(t, manager, conn, peer)
+
+	// Guests are answered that they must authenticate, which fails to send
+	conn.fail.Store(true)
+	peer.Send(Message{Type: MessageTypeMessage, Channel: "user"})
+
+	require.Error(t, peer.WaitClosed(time.Second))
+	waitServed(t, done)
+	expectDisconnects(t, manager, map[string]int{DISCONNECT_WRITE_ERROR: 1})
+}
+
+func TestDisconnect_AuthFailure(t *testing.T) {
+	manager := newDisconnectManager(t)
+	peer, done := connectPeer(t, manager)
+
+	peer.Send(Message{Type: MessageTypeAuthResponse, Data: map[string]any{"token": "not-a-token"}})
+	var failure Message
+	peer.Receive(&failure)
+	assert.Equal(t, MessageTypeAuthFailure, failure.Type)
+
+	expectCloseFrame(t, peer, fasthttpws.ClosePolicyViolation, "Invalid token")
+	waitServed(t, done)
+	expectDisconnects(t, manager, map[string]int{DISCONNECT_AUTH_FAILURE: 1})
+}
+
+func TestDisconnect_Kicked(t *testing.T) {
+	manager := newDisconnectManager(t)
+	userID := uuid.New()
+	invalid, invalidDone := connectPeer(t, manager)
+	admin, adminDone := connectPeer(t, manager)
+	authenticatePeer(t, manager, admin, userID, "")
+
+	// Too many invalid messages
+	for range MaxStrikes {
+		invalid.Send(Message{Type: MessageTypeAuthResponse, Data: map[string]any{}})
+		var strike Message
+		invalid.Receive(&strike)
+		require.Equal(t, ErrorCodeInvalidField, strike.Action)
+	}
+	expectCloseFrame(t, invalid, fasthttpws.ClosePolicyViolation, "Too many invalid messages")
+	waitServed(t, invalidDone)
+
+	// An admin disconnecting the user
+	assert.Equal(t, 1, manager.DisconnectUser(userID.String(), "Signed out by an admin"))
+	var disconnected Message
+	admin.Receive(&disconnected)
+	assert.Equal(t, ErrorCodeDisconnected, disconnected.Data["code"])
+	expectCloseFrame(t, admin, fasthttpws.CloseNormalClosure, "Signed out by an admin")
+	waitServed(t, adminDone)
+
+	expectDisconnects(t, manager, map[string]int{DISCONNECT_KICKED: 2})
+}
+
+func TestDisconnect_Replaced(t *testing.T) {
+	manager := newDisconnectManager(t)
+	userID := uuid.New()
+	old, oldDone := connectPeer(t, manager)
+	authenticatePeer(t, manager, old, userID, "laptop")
+
+	replacement, _ := connectPeer(t, manager)
+	authenticatePeer(t, manager, replacement, userID, "laptop")
+
+	var replaced Message
+	old.Receive(&replaced)
+	assert.Equal(t, ErrorCodeReplaced, replaced.Data["code"])
+	expectCloseFrame(t, old, fasthttpws.CloseNormalClosure, ErrorCodeReplaced)
+	waitServed(t, oldDone)
+	expectDisconnects(t, manager, map[string]int{DISCONNECT_REPLACED: 1})
+	assert.False(t, replacement.Closed())
+}
+
+func TestDisconnect_ServerDrain(t *testing.T) {
+	manager := newDisconnectManager(t)
+	peer, done := connectPeer(t, manager)
+	authenticatePeer(t, manager, peer, uuid.New(), "")
+
+	require.NoError(t, manager.Drain(context.Background(), 10*time.Millisecond, DRAIN_REASON_SHUTDOWN))
+
+	var reconnect Message
+	peer.Receive(&reconnect)
+	assert.Equal(t, MessageTypeReconnect, reconnect.Type)
+	expectCloseFrame(t, peer, fasthttpws.CloseServiceRestart, ErrorCodeDraining)
+	waitServed(t, done)
+	expectDisconnects(t, manager, map[string]int{DISCONNECT_SERVER_DRAIN: 1})
+}
+
+func TestDisconnectWindow_Expires(t *testing.T) {
+	var window disconnectWindow
+	start := time.Date(2026, 10, 15, 12, 0, 30, 0, time.UTC)
+
+	assert.Nil(t, window.counts(start))
+
+	window.record(start, DISCONNECT_CLIENT_CLOSE)
+	window.record(start.Add(5*time.Minute), DISCONNECT_CLIENT_CLOSE)
+	window.record(start.Add(5*time.Minute), DISCONNECT_PONG_TIMEOUT)
+	assert.Equal(t, map[string]int{DISCONNECT_CLIENT_CLOSE: 2, DISCONNECT_PONG_TIMEOUT: 1}, window.counts(start.Add(5*time.Minute)))
+
+	// The first minute has left the window
+	assert.Equal(t, map[string]int{DISCONNECT_CLIENT_CLOSE: 1, DISCONNECT_PONG_TIMEOUT: 1}, window.counts(start.Add(DISCONNECT_WINDOW)))
+	assert.Nil(t, window.counts(start.Add(DISCONNECT_WINDOW+5*time.Minute)))
+}
@@ -0,0 +1,229 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	ErrorCodeDraining = "draining"
+
+	DRAIN_REASON_SHUTDOWN    = "shutdown"
+	DRAIN_REASON_MAINTENANCE = "maintenance"
+
+	// Used when WEBSOCKET_DRAIN_BATCH_SIZE isn't set
+	DRAIN_BATCH_SIZE_DEFAULT = 100
+)
+
+var ErrAlreadyDraining = errors.New("websocket connections are already draining")
+
+// DrainProgress is how far a drain has got. Clients are split into Batches;
+// each batch is told to reconnect within its own slice of the window and is
+// closed when that slice ends.
+type DrainProgress struct {
+	Reason        string    `json:"reason"`
+	StartedAt     time.Time `json:"startedAt"`
+	WindowMs      int64     `json:"windowMs"`
+	Clients       int       `json:"clients"`
+	Batches       int       `json:"batches"`
+	BatchesClosed int       `json:"batchesClosed"`
+	Closed        int       `json:"closed"`
+	Rejected      int       `json:"rejected"`
+	Done          bool      `json:"done"`
+}
+
+// Drain stops accepting connections and moves the connected clients off this
+// instance over window, so they don't all reconnect to the next one at once.
+// Every client is sent a reconnect message with a delay range inside its
+// batch's slice of the window, and batches are closed one slice at a time.
+// It returns once every batch is closed; if ctx ends first the remaining
+// clients are closed straight away. New connections stay refused until
+// Resume.
+func (m *Manager) Drain(ctx context.Context, window time.Duration, reason string) error {
+	log := m.log.Function("Drain")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	clients, err := m.startDrain(window, reason, cancel)
+	if err != nil {
+		return err
+	}
+
+	batchSize := m.config.Websocket.DrainBatchSize
+	if batchSize <= 0 {
+		batchSize = DRAIN_BATCH_SIZE_DEFAULT
+	}
+	var batches [][]*Client
+	for start := 0; start < len(clients); start += batchSize {
+		batches = append(batches, clients[start:min(start+batchSize, len(clients))])
+	}
+
+	var slot time.Duration
+	if len(batches) > 0 {
+		slot = window / time.Duration(len(batches))
+	}
+	m.updateDrain(func(progress *DrainProgress) { progress.Batches = len(batches) })
+	log.Info("Draining websocket connections",
+		"reason", reason, "clients", len(clients), "batches", len(batches), "window", window)
+
+	for i, batch := range batches {
+		for _, client := range batch {
+			m.sendReconnect(client, time.Duration(i)*slot, time.Duration(i+1)*slot, reason)
+		}
+	}
+
+	for i, batch := range batches {
+		select {
+		case <-m.drainTimer(slot):
+		case <-ctx.Done():
+			if !m.Draining() {
+				log.Info("Drain stopped by resume", "batchesLeft", len(batches)-i)
+				return nil
+			}
+			log.Warn("Drain cut short, closing remaining connections", "batchesLeft", len(batches)-i)
+			for _, rest := range batches[i:] {
+				m.closeDrained(rest)
+			}
+			m.updateDrain(func(progress *DrainProgress) { progress.Done = true })
+			return ctx.Err()
+		}
+		m.closeDrained(batch)
+	}
+
+	m.updateDrain(func(progress *DrainProgress) { progress.Done = true })
+	log.Info("Websocket connections drained", "reason", reason, "clients", len(clients))
+	return nil
+}
+
+// Draining reports whether new connections are being refused.
+func (m *Manager) Draining() bool {
+	m.drainMutex.Lock()
+	defer m.drainMutex.Unlock()
+	return m.drain != nil
+}
+
+// Resume accepts connections again after a drain started for reason. A drain
+// still in progress stops, leaving the clients it hadn't closed connected.
+func (m *Manager) Resume(reason string) {
+	m.drainMutex.Lock()
+	defer m.drainMutex.Unlock()
+
+	if m.drain == nil || m.drain.Reason != reason {
+		return
+	}
+	m.drain = nil
+	m.cancelDrain()
+	m.log.Function("Resume").Info("Accepting websocket connections again", "reason", reason)
+}
+
+// DrainProgress returns the current drain, or nil when not draining.
+func (m *Manager) DrainProgress() *DrainProgress {
+	m.drainMutex.Lock()
+	defer m.drainMutex.Unlock()
+
+	if m.drain == nil {
+		return nil
+	}
+	progress := *m.drain
+	return &progress
+}
+
+// startDrain marks the manager as draining and returns the clients connected
+// at that moment, shuffled so batches don't follow connection order.
+func (m *Manager) startDrain(window time.Duration, reason string, cancel context.CancelFunc) ([]*Client, error) {
+	m.drainMutex.Lock()
+	defer m.drainMutex.Unlock()
+
+	if m.drain != nil {
+		return nil, ErrAlreadyDraining
+	}
+
+	m.hub.mutex.RLock()
+	clients := make([]*Client, 0, len(m.hub.clients))
+	for _, client := range m.hub.clients {
+		clients = append(clients, client)
+	}
+	m.hub.mutex.RUnlock()
+	rand.Shuffle(len(clients), func(i, j int) { clients[i], clients[j] = clients[j], clients[i] })
+
+	m.drain = &DrainProgress{
+		Reason:    reason,
+		StartedAt: m.now(),
+		WindowMs:  window.Milliseconds(),
+		Clients:   len(clients),
+	}
+	m.cancelDrain = cancel
+	return clients, nil
+}
+
+func (m *Manager) updateDrain(update func(progress *DrainProgress)) {
+	m.drainMutex.Lock()
+	defer m.drainMutex.Unlock()
+
+	if m.drain != nil {
+		update(m.drain)
+	}
+}
+
+// reconnectMessage tells a client to reconnect after a random delay between
+// minDelay and maxDelay.
+func (m *Manager) reconnectMessage(minDelay time.Duration, maxDelay time.Duration, reason string) Message {
+	return Message{
+		ID:      uuid.New().String(),
+		Type:    MessageTypeReconnect,
+		Channel: "system",
+		Action:  ErrorCodeDraining,
+		Data: map[string]any{
+			"reason":     reason,
+			"minDelayMs": minDelay.Milliseconds(),
+			"maxDelayMs": maxDelay.Milliseconds(),
+		},
+		Timestamp: m.now(),
+	}
+}
+
+func (m *Manager) sendReconnect(client *Client, minDelay time.Duration, maxDelay time.Duration, reason string) {
+	select {
+	case client.send <- m.reconnectMessage(minDelay, maxDelay, reason):
+	default:
+		client.dropped.Add(1)
+		m.log.Function("sendReconnect").
+			Warn("Client send channel full, dropping reconnect message", "clientID", client.ID)
+	}
+}
+
+// closeDrained closes clients with a service restart close frame.
+func (m *Manager) closeDrained(clients []*Client) {
+	closing := websocket.FormatCloseMessage(websocket.CloseServiceRestart, ErrorCodeDraining)
+	for _, client := range clients {
+		client.closeMessage = closing
+		client.setDisconnectReason(DISCONNECT_SERVER_DRAIN)
+		m.hub.unregister <- client
+	}
+
+	m.updateDrain(func(progress *DrainProgress) {
+		progress.BatchesClosed++
+		progress.Closed += len(clients)
+	})
+}
+
+// rejectForDrain turns away a new connection while draining, telling it to
+// reconnect somewhere in the drain window. It reports whether it did.
+func (m *Manager) rejectForDrain(c Conn) bool {
+	progress := m.DrainProgress()
+	if progress == nil {
+		return false
+	}
+
+	m.updateDrain(func(progress *DrainProgress) { progress.Rejected++ })
+	message := m.reconnectMessage(0, time.Duration(progress.WindowMs)*time.Millisecond, progress.Reason)
+	m.refuseConnection(c, message, websocket.CloseServiceRestart, ErrorCodeDraining)
+	m.log.Function("rejectForDrain").Info("Connection refused while draining", "reason", progress.Reason)
+	return true
+}
@@ -0,0 +1,213 @@
+package websockets
+
+import (
+	"context"
+	"fmt"
+	"server/config"
+	"server/internal/database"
+	"server/internal/events"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupDrainTest returns a manager whose drain batches only close when a
+// value is sent on the returned channel.
+func setupDrainTest(t *testing.T, batchSize int) (*Manager, chan time.Time) {
+	t.Helper()
+
+	cfg := config.Config{Websocket: config.WebsocketConfig{DrainBatchSize: batchSize}}
+	manager, err := New(database.DB{}, events.New(nil, cfg, nil), cfg, nil)
+	require.NoError(t, err)
+
+	ticks := make(chan time.Time)
+	manager.drainTimer = func(time.Duration) <-chan time.Time { return ticks }
+	return manager, ticks
+}
+
+func addFakeClients(t *testing.T, manager *Manager, count int) []*Client {
+	t.Helper()
+
+	clients := make([]*Client, count)
+	for i := range clients {
+		clients[i] = &Client{
+			ID:      fmt.Sprintf("client-%d", i),
+			Manager: manager,
+			Status:  StatusAuthenticated,
+			send:    make(chan Message, SendChannelSize),
+		}
+		manager.hub.register <- clients[i]
+	}
+	require.Eventually(t, func() bool { return manager.ConnectionCount() == count }, time.Second, 10*time.Millisecond)
+	return clients
+}
+
+// isClosed reports whether client's send channel is closed, discarding any
+// messages still queued on it.
+func isClosed(client *Client) bool {
+	for {
+		select {
+		case _, ok := <-client.send:
+			if !ok {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+func countClosed(clients []*Client) int {
+	closed := 0
+	for _, client := range clients {
+		if isClosed(client) {
+			closed++
+		}
+	}
+	return closed
+}
+
+func TestManager_DrainClosesInBatches(t *testing.T) {
+	manager, ticks := setupDrainTest(t, 2)
+	clients := addFakeClients(t, manager, 5)
+	window := 30 * time.Second
+	slot := window / 3
+
+	drained := make(chan error, 1)
+	go func() { drained <- manager.Drain(context.Background(), window, DRAIN_REASON_SHUTDOWN) }()
+
+	// Every client is told when to come back, within its batch's slot
+	perSlot := map[int64]int{}
+	for _, client := range clients {
+		var message Message
+		select {
+		case message = <-client.send:
+		case <-time.After(time.Second):
+			t.Fatalf("%s was not sent a reconnect message", client.ID)
+		}
+		assert.Equal(t, MessageTypeReconnect, message.Type)
+		assert.Equal(t, ErrorCodeDraining, message.Action)
+		assert.Equal(t, DRAIN_REASON_SHUTDOWN, message.Data["reason"])
+
+		minDelay := message.Data["minDelayMs"].(int64)
+		maxDelay := message.Data["maxDelayMs"].(int64)
+		assert.GreaterOrEqual(t, minDelay, int64(0))
+		assert.LessOrEqual(t, maxDelay, window.Milliseconds())
+		assert.Equal(t, slot.Milliseconds(), maxDelay-minDelay)
+		perSlot[minDelay]++
+	}
+	assert.Equal(t, map[int64]int{0: 2, slot.Milliseconds(): 2, 2 * slot.Milliseconds(): 1}, perSlot)
+
+	progress := manager.Stats().Drain
+	require.NotNil(t, progress)
+	assert.Equal(t, 5, progress.Clients)
+	assert.Equal(t, 3, progress.Batches)
+	assert.Equal(t, window.Milliseconds(), progress.WindowMs)
+	assert.Zero(t, countClosed(clients))
+
+	// Batches close one slot at a time
+	closed := 0
+	for batch, size := range []int{2, 2, 1} {
+		ticks <- time.Now()
+		closed += size
+		require.Eventually(t, func() bool {
+			return manager.ConnectionCount() == len(clients)-closed
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, closed, countClosed(clients))
+		progress := manager.DrainProgress()
+		assert.Equal(t, batch+1, progress.BatchesClosed)
+		assert.Equal(t, closed, progress.Closed)
+	}
+
+	require.NoError(t, <-drained)
+	for _, client := range clients {
+		assert.Equal(t, fasthttpws.FormatCloseMessage(fasthttpws.CloseServiceRestart, ErrorCodeDraining), client.closeMessage)
+	}
+	assert.True(t, manager.Stats().Drain.Done)
+	assert.True(t, manager.Draining(), "connections stay refused until Resume")
+}
+
+func TestManager_DrainRefusesRegistrations(t *testing.T) {
+	manager, ticks := setupDrainTest(t, 10)
+	addFakeClients(t, manager, 1)
+
+	drained := make(chan error, 1)
+	go func() { drained <- manager.Drain(context.Background(), time.Minute, DRAIN_REASON_SHUTDOWN) }()
+	require.Eventually(t, manager.Draining, time.Second, 10*time.Millisecond)
+
+	late := &Client{ID: "late", Manager: manager, send: make(chan Message, SendChannelSize)}
+	manager.hub.register <- late
+	require.Eventually(t, func() bool { return isClosed(late) }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, manager.ConnectionCount())
+	assert.Equal(t, 1, manager.DrainProgress().Rejected)
+
+	ticks <- time.Now()
+	require.NoError(t, <-drained)
+	require.Eventually(t, func() bool { return manager.ConnectionCount() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestManager_DrainRejectsNewConnections(t *testing.T) {
+	manager, _ := setupDrainTest(t, 10)
+	url := serveWebSocket(t, manager)
+
+	// Nothing to close, but new connections are refused from here on
+	require.NoError(t, manager.Drain(context.Background(), 20*time.Second, DRAIN_REASON_MAINTENANCE))
+	assert.ErrorIs(t, manager.Drain(context.Background(), time.Second, DRAIN_REASON_SHUTDOWN), ErrAlreadyDraining)
+
+	rejected, _, err := fasthttpws.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer rejected.Close()
+
+	refusal := readMessage(t, rejected)
+	assert.Equal(t, MessageTypeReconnect, refusal.Type)
+	assert.Equal(t, DRAIN_REASON_MAINTENANCE, refusal.Data["reason"])
+	assert.Equal(t, float64(0), refusal.Data["minDelayMs"])
+	assert.Equal(t, float64(20000), refusal.Data["maxDelayMs"])
+
+	_, _, err = rejected.ReadMessage()
+	assert.True(t, fasthttpws.IsCloseError(err, fasthttpws.CloseServiceRestart), "unexpected error: %v", err)
+	assert.Equal(t, 1, manager.DrainProgress().Rejected)
+
+	// Only the matching reason resumes
+	manager.Resume(DRAIN_REASON_SHUTDOWN)
+	assert.True(t, manager.Draining())
+	manager.Resume(DRAIN_REASON_MAINTENANCE)
+	assert.False(t, manager.Draining())
+	assert.Nil(t, manager.Stats().Drain)
+
+	accepted, _, err := fasthttpws.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer accepted.Close()
+	assert.Equal(t, MessageTypeAuthRequest, readMessage(t, accepted).Type)
+}
+
+func TestManager_ResumeStopsDrain(t *testing.T) {
+	manager, _ := setupDrainTest(t, 1)
+	clients := addFakeClients(t, manager, 3)
+
+	drained := make(chan error, 1)
+	go func() { drained <- manager.Drain(context.Background(), time.Minute, DRAIN_REASON_MAINTENANCE) }()
+	require.Eventually(t, manager.Draining, time.Second, 10*time.Millisecond)
+
+	manager.Resume(DRAIN_REASON_MAINTENANCE)
+	require.NoError(t, <-drained)
+	assert.Equal(t, 3, manager.ConnectionCount())
+	assert.Zero(t, countClosed(clients))
+}
+
+func TestManager_DrainClosesRestWhenContextEnds(t *testing.T) {
+	manager, _ := setupDrainTest(t, 1)
+	clients := addFakeClients(t, manager, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, manager.Drain(ctx, time.Minute, DRAIN_REASON_SHUTDOWN), context.Canceled)
+
+	require.Eventually(t, func() bool { return manager.ConnectionCount() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, countClosed(clients))
+	assert.Equal(t, 3, manager.DrainProgress().BatchesClosed)
+	assert.True(t, manager.DrainProgress().Done)
+}
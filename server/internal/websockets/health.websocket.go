@@ -0,0 +1,38 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"server/internal/health"
+)
+
+type hubChecker struct {
+	manager *Manager
+}
+
+// Health checks the hub's run loop answers, down when it is stuck behind a
+// message, and reports the connections and recent disconnects.
+func (m *Manager) Health() health.Checker {
+	return hubChecker{manager: m}
+}
+
+func (c hubChecker) Name() string { return "websocket" }
+
+func (c hubChecker) Check(ctx context.Context) health.CheckResult {
+	details := map[string]any{
+		"connections":   c.manager.ConnectionCount(),
+		"authenticated": c.manager.AuthenticatedClientCount(),
+	}
+	if disconnects := c.manager.RecentDisconnects(); disconnects != nil {
+		details["recentDisconnects"] = disconnects
+	}
+
+	reply := make(chan struct{})
+	select {
+	case c.manager.hub.probe <- reply:
+	case <-ctx.Done():
+		return health.Down(errors.New("hub is not answering"), details)
+	}
+	<-reply
+	return health.OK(details)
+}
@@ -0,0 +1,137 @@
+package websockets
+
+import (
+	"context"
+	"server/internal/events"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// REFRESH_HINT_WINDOW is how long a user's refresh hint waits for more
+// changes to the user, which it then names as well
+const REFRESH_HINT_WINDOW = 250 * time.Millisecond
+
+// refreshHintFields are the fields a refresh hint may name, those GET /me
+// shows. Anything else a user.updated event names is left out, so internal
+// columns never reach clients.
+var refreshHintFields = map[string]bool{
+	"firstName":     true,
+	"lastName":      true,
+	"login":         true,
+	"isAdmin":       true,
+	"tosVersion":    true,
+	"tosAcceptedAt": true,
+}
+
+// refreshHints collects the changed fields of each user until their hint is
+// sent.
+type refreshHints struct {
+	mutex   sync.Mutex
+	pending map[uuid.UUID]map[string]bool
+}
+
+func (m *Manager) subscribeToUserUpdatedEvents() {
+	log := m.log.Function("subscribeToUserUpdatedEvents")
+
+	topic := m.eventBus.UserUpdatedTopic()
+	err := topic.Subscribe(func(ctx context.Context, event events.UserUpdatedEvent) error {
+		userID, err := uuid.Parse(event.UserID)
+		if err != nil {
+			return err
+		}
+		m.QueueRefreshHint(userID, event.Fields)
+		return nil
+	})
+	if err != nil {
+		log.Er("Failed to subscribe to user updated events", err)
+	}
+}
+
+// QueueRefreshHint tells userID's authenticated clients, after
+// REFRESH_HINT_WINDOW, that fields of their user changed and /me should be
+// fetched again. Hints queued for the user in the meantime are sent with it
+// as one. Fields that aren't in refreshHintFields are dropped, and nothing is
+// sent when none are left or the user has no connection here.
+func (m *Manager) QueueRefreshHint(userID uuid.UUID, fields []string) {
+	var allowed []string
+	for _, field := range fields {
+		if refreshHintFields[field] {
+			allowed = append(allowed, field)
+		}
+	}
+	if len(allowed) == 0 || !m.hasAuthenticatedClient(userID) {
+		return
+	}
+
+	m.hints.mutex.Lock()
+	defer m.hints.mutex.Unlock()
+
+	if m.hints.pending == nil {
+		m.hints.pending = make(map[uuid.UUID]map[string]bool)
+	}
+	pending, queued := m.hints.pending[userID]
+	if !queued {
+		pending = make(map[string]bool)
+		m.hints.pending[userID] = pending
+		time.AfterFunc(m.refreshHintWindow, func() { m.sendRefreshHint(userID) })
+	}
+	for _, field := range allowed {
+		pending[field] = true
+	}
+}
+
+func (m *Manager) hasAuthenticatedClient(userID uuid.UUID) bool {
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	for _, client := range m.hub.clients {
+		if client.Status == StatusAuthenticated && client.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// sendRefreshHint sends the fields queued for userID to their authenticated
+// clients. Like a token refresh, it ignores notification preferences.
+func (m *Manager) sendRefreshHint(userID uuid.UUID) {
+	log := m.log.Function("sendRefreshHint")
+
+	m.hints.mutex.Lock()
+	pending := m.hints.pending[userID]
+	delete(m.hints.pending, userID)
+	m.hints.mutex.Unlock()
+
+	fields := make([]string, 0, len(pending))
+	for field := range pending {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	message := Message{
+		ID:        uuid.New().String(),
+		Type:      MessageTypeMessage,
+		Channel:   "user",
+		Action:    "refresh_hint",
+		Data:      map[string]any{"fields": fields},
+		Timestamp: m.now(),
+	}
+
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	sent := 0
+	for _, client := range m.hub.clients {
+		if client.Status != StatusAuthenticated || client.UserID != userID {
+			continue
+		}
+		if m.trySend(client, message) {
+			sent++
+		}
+	}
+
+	log.Info("Refresh hint sent", "userID", userID, "fields", fields, "clientCount", sent)
+}
@@ -0,0 +1,102 @@
+package websockets
+
+import (
+	"context"
+	"server/config"
+	"server/internal/events"
+	"server/internal/logger"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHintManager(window time.Duration, clients ...*Client) (*Manager, *events.EventBus) {
+	eventBus := events.New(nil, config.Config{}, nil)
+	manager := &Manager{
+		hub:               &Hub{clients: map[string]*Client{}},
+		log:               logger.New("test"),
+		eventBus:          eventBus,
+		refreshHintWindow: window,
+	}
+	for _, client := range clients {
+		client.Manager = manager
+		manager.hub.clients[client.ID] = client
+	}
+	manager.subscribeToUserUpdatedEvents()
+	return manager, eventBus
+}
+
+func expectRefreshHint(t *testing.T, client *Client, fields ...string) {
+	t.Helper()
+	message := receive(t, client)
+	assert.Equal(t, MessageTypeMessage, message.Type)
+	assert.Equal(t, "user", message.Channel)
+	assert.Equal(t, "refresh_hint", message.Action)
+	assert.Equal(t, fields, message.Data["fields"])
+}
+
+func TestRefreshHint_OnlyToUsersClients(t *testing.T) {
+	userID, otherUserID := uuid.New(), uuid.New()
+	phone := authenticatedClient("phone", userID)
+	tablet := authenticatedClient("tablet", userID)
+	other := authenticatedClient("other", otherUserID)
+	pending := &Client{ID: "pending", UserID: userID, Status: StatusUnauthenticated, send: make(chan Message, 10)}
+	_, eventBus := newHintManager(0, phone, tablet, other, pending)
+
+	require.NoError(t, eventBus.UserUpdatedTopic().Publish(context.Background(), events.UserUpdatedEvent{
+		UserID: userID.String(),
+		Fields: []string{"isAdmin", "firstName"},
+	}))
+
+	expectRefreshHint(t, phone, "firstName", "isAdmin")
+	expectRefreshHint(t, tablet, "firstName", "isAdmin")
+	assert.Never(t, func() bool { return len(other.send) > 0 || len(pending.send) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+}
+
+func TestRefreshHint_OnlyWhitelistedFields(t *testing.T) {
+	userID := uuid.New()
+	client := authenticatedClient("client", userID)
+	manager, _ := newHintManager(0, client)
+
+	manager.QueueRefreshHint(userID, []string{"password", "lastName", "pepperVersion"})
+	expectRefreshHint(t, client, "lastName")
+
+	// Nothing the client may see changed
+	manager.QueueRefreshHint(userID, []string{"password", "deletedAt"})
+	assert.Never(t, func() bool { return len(client.send) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+}
+
+func TestRefreshHint_CoalescesBursts(t *testing.T) {
+	userID := uuid.New()
+	client := authenticatedClient("client", userID)
+	manager, _ := newHintManager(50*time.Millisecond, client)
+
+	manager.QueueRefreshHint(userID, []string{"firstName"})
+	manager.QueueRefreshHint(userID, []string{"lastName", "firstName"})
+	manager.QueueRefreshHint(userID, []string{"isAdmin"})
+
+	require.Eventually(t, func() bool { return len(client.send) > 0 }, time.Second, time.Millisecond)
+	expectRefreshHint(t, client, "firstName", "isAdmin", "lastName")
+	assert.Never(t, func() bool { return len(client.send) > 0 }, 100*time.Millisecond, 5*time.Millisecond)
+
+	// A change after the hint went out gets one of its own
+	manager.QueueRefreshHint(userID, []string{"login"})
+	require.Eventually(t, func() bool { return len(client.send) > 0 }, time.Second, time.Millisecond)
+	expectRefreshHint(t, client, "login")
+}
+
+func TestRefreshHint_NoConnections(t *testing.T) {
+	userID := uuid.New()
+	other := authenticatedClient("other", uuid.New())
+	manager, _ := newHintManager(0, other)
+
+	manager.QueueRefreshHint(userID, []string{"firstName"})
+
+	manager.hints.mutex.Lock()
+	assert.Empty(t, manager.hints.pending, "nothing is queued for a user who isn't connected")
+	manager.hints.mutex.Unlock()
+	assert.Never(t, func() bool { return len(other.send) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+}
@@ -1,9 +1,11 @@
 package websockets
 
 import (
+	"server/internal/models"
 	"sync"
 	"time"
 
+	"github.com/gofiber/websocket/v2"
 	"github.com/google/uuid"
 )
 
@@ -20,6 +22,12 @@ type Hub struct {
 	unregister chan *Client
 	clients    map[string]*Client
 	mutex      sync.RWMutex
+
+	// Tagged authenticated connections by user and deviceId
+	devices map[deviceKey]*Client
+
+	// Answered by the run loop, to show it isn't stuck
+	probe chan chan struct{}
 }
 
 func (h *Hub) run(m *Manager) {
@@ -29,6 +37,9 @@ func (h *Hub) run(m *Manager) {
 			m.registerClient(client)
 
 		case client := <-h.unregister:
+			// Removed first, so senders holding the hub lock never see a
+			// closed send channel
+			m.unregisterClient(client)
 			func() {
 				defer func() {
 					if r := recover(); r != nil {
@@ -37,10 +48,12 @@ func (h *Hub) run(m *Manager) {
 				}()
 				close(client.send)
 			}()
-			m.unregisterClient(client)
 
 		case message := <-h.broadcast:
 			h.broadcastMessage(message, m)
+
+		case reply := <-h.probe:
+			close(reply)
 		}
 	}
 }
@@ -60,7 +73,13 @@ func (m *Manager) unregisterClient(client *Client) {
 	m.hub.mutex.Lock()
 	defer m.hub.mutex.Unlock()
 
+	// Only the first of the unregisters a connection ends with is recorded
+	if _, registered := m.hub.clients[client.ID]; registered {
+		m.recordDisconnect(client)
+	}
 	delete(m.hub.clients, client.ID)
+	m.releaseDevice(client)
+	m.leaveStream(client)
 
 	log.Info(
 		"Client unregistered and removed from local storage",
@@ -75,6 +94,17 @@ func (m *Manager) registerClient(client *Client) {
 	log := m.log.Function("registerClient")
 	log.Info("Registering client", "clientID", client.ID, "status", client.Status)
 
+	// Connections that got past HandleWebSocket just as a drain started
+	if m.Draining() {
+		log.Info("Refusing client while draining", "clientID", client.ID)
+		m.updateDrain(func(progress *DrainProgress) { progress.Rejected++ })
+		client.setDisconnectReason(DISCONNECT_SERVER_DRAIN)
+		m.recordDisconnect(client)
+		client.closeMessage = websocket.FormatCloseMessage(websocket.CloseServiceRestart, ErrorCodeDraining)
+		close(client.send)
+		return
+	}
+
 	m.hub.mutex.Lock()
 	defer m.hub.mutex.Unlock()
 
@@ -102,6 +132,7 @@ func (h *Hub) broadcastMessage(message Message, m *Manager) {
 		return
 	}
 
+	stamps := m.stampsFor(message)
 	sentCount := 0
 	totalClients := len(h.clients)
 
@@ -110,6 +141,7 @@ func (h *Hub) broadcastMessage(message Message, m *Manager) {
 			continue
 		}
 
+		message := stamps.For(client)
 		select {
 		case client.send <- message:
 			sentCount++
@@ -119,14 +151,16 @@ func (h *Hub) broadcastMessage(message Message, m *Manager) {
 				case c.send <- msg:
 					log.Info("Message sent after retry", "clientID", cID)
 				case <-time.After(5 * time.Second):
+					c.dropped.Add(1)
 					_ = log.Error("Client too slow, disconnecting", "clientID", cID)
+					c.setDisconnectReason(DISCONNECT_WRITE_ERROR)
 					m.hub.unregister <- c
 				}
 			}(client, clientID, message)
 		}
 	}
 
-	log.Info(
+	log.Sampled(m.broadcastLogSampler).Info(
 		"Broadcast complete",
 		"messageID",
 		message.ID,
@@ -137,6 +171,30 @@ func (h *Hub) broadcastMessage(message Message, m *Manager) {
 	)
 }
 
+// AuthenticatedClientCount is the number of clients on this instance that
+// receive broadcasts. Guests on public channels aren't counted.
+func (m *Manager) AuthenticatedClientCount() int {
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	count := 0
+	for _, client := range m.hub.clients {
+		if client.Status == StatusAuthenticated {
+			count++
+		}
+	}
+	return count
+}
+
+// ConnectionCount is the number of open connections on this instance,
+// guests and clients still authenticating included.
+func (m *Manager) ConnectionCount() int {
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	return len(m.hub.clients)
+}
+
 func (m *Manager) promoteClientToAuthenticated(client *Client) {
 	log := m.log.Function("promoteClientToAuthenticated")
 
@@ -157,15 +215,23 @@ func (m *Manager) promoteClientToAuthenticated(client *Client) {
 func (m *Manager) SendMessageToUser(userID uuid.UUID, message Message) {
 	log := m.log.Function("SendMessageToUser")
 
+	allowed := m.deliveryFilter([]uuid.UUID{userID}, models.NotificationPreferences.AllowsMention)(userID)
+
 	m.hub.mutex.RLock()
 	defer m.hub.mutex.RUnlock()
 
+	stamps := m.stampsFor(message)
 	sentCount := 0
 	totalUserConnections := 0
 
 	for clientID, client := range m.hub.clients {
 		if client.Status == StatusAuthenticated && client.UserID == userID {
 			totalUserConnections++
+			if !allowed {
+				m.suppressedMentions.Add(1)
+				continue
+			}
+			message := stamps.For(client)
 			select {
 			case client.send <- message:
 				sentCount++
@@ -175,6 +241,7 @@ func (m *Manager) SendMessageToUser(userID uuid.UUID, message Message) {
 					case c.send <- msg:
 						log.Info("Message sent after retry", "clientID", cID, "userID", uID)
 					case <-time.After(5 * time.Second):
+						c.dropped.Add(1)
 						_ = log.Error(
 							"Client too slow, disconnecting",
 							"clientID",
@@ -182,6 +249,7 @@ func (m *Manager) SendMessageToUser(userID uuid.UUID, message Message) {
 							"userID",
 							uID,
 						)
+						c.setDisconnectReason(DISCONNECT_WRITE_ERROR)
 						m.hub.unregister <- c
 					}
 				}(client, clientID, message, userID)
@@ -193,6 +261,10 @@ func (m *Manager) SendMessageToUser(userID uuid.UUID, message Message) {
 		log.Info("No connections found for user", "userID", userID)
 		return
 	}
+	if !allowed {
+		log.Info("Message suppressed by notification preferences", "userID", userID, "messageID", message.ID)
+		return
+	}
 
 	log.Info(
 		"Message sent to user connections",
@@ -0,0 +1,108 @@
+package websockets
+
+import (
+	"server/internal/maintenance"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+const ErrorCodeMaintenance = "maintenance"
+
+// SetMaintenance refuses new connections while maintenance mode is on and
+// tells every connected client, guests included, when it changes. Call it
+// before clients connect.
+func (m *Manager) SetMaintenance(mode *maintenance.Mode) {
+	m.maintenance = mode
+	mode.OnChange(m.announceMaintenance)
+}
+
+// rejectForMaintenance sends a maintenance error and closes c with
+// try-again-later if maintenance mode is on. It reports whether it did.
+func (m *Manager) rejectForMaintenance(c Conn) bool {
+	if m.maintenance == nil {
+		return false
+	}
+	state := m.maintenance.State()
+	if !state.Enabled {
+		return false
+	}
+
+	m.refuseConnection(c, Message{
+		ID:      uuid.New().String(),
+		Type:    MessageTypeError,
+		Channel: "system",
+		Action:  ErrorCodeMaintenance,
+		Data: map[string]any{
+			"code":       ErrorCodeMaintenance,
+			"message":    state.Message,
+			"retryAfter": int(maintenance.RETRY_AFTER.Seconds()),
+		},
+		Timestamp: m.now(),
+	}, websocket.CloseTryAgainLater, ErrorCodeMaintenance)
+
+	m.log.Function("rejectForMaintenance").Info("Connection refused during maintenance")
+	return true
+}
+
+// refuseConnection sends message to a connection that never joined the hub,
+// then closes it with closeCode. It counts as a server_drain disconnect.
+func (m *Manager) refuseConnection(c Conn, message Message, closeCode int, reason string) {
+	log := m.log.Function("refuseConnection")
+	m.countDisconnect(DISCONNECT_SERVER_DRAIN)
+
+	data, err := EncodeMessage(DefaultProtocolVersion, message)
+	if err == nil {
+		err = c.WriteMessage(websocket.TextMessage, data)
+	}
+	if err != nil {
+		log.Er("failed to send refusal", err, "reason", reason)
+	}
+
+	closing := websocket.FormatCloseMessage(closeCode, reason)
+	if err := c.WriteMessage(websocket.CloseMessage, closing); err != nil {
+		log.Er("failed to send close message", err)
+	}
+	if err := c.Close(); err != nil {
+		log.Er("failed to close connection", err)
+	}
+}
+
+func (m *Manager) announceMaintenance(state maintenance.State) {
+	log := m.log.Function("announceMaintenance")
+
+	if !state.Enabled {
+		m.Resume(DRAIN_REASON_MAINTENANCE)
+	}
+
+	data := map[string]any{"enabled": state.Enabled}
+	if state.Enabled {
+		data["message"] = state.Message
+		data["since"] = state.Since
+	}
+	message := Message{
+		ID:        uuid.New().String(),
+		Type:      MessageTypeBroadcast,
+		Channel:   "system",
+		Action:    ErrorCodeMaintenance,
+		Data:      data,
+		Timestamp: m.now(),
+	}
+
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	stamps := m.stampsFor(message)
+	sent := 0
+	for _, client := range m.hub.clients {
+		select {
+		case client.send <- stamps.For(client):
+			sent++
+		default:
+			client.dropped.Add(1)
+			log.Warn("Client send channel full, dropping message", "clientID", client.ID)
+		}
+	}
+
+	log.Info("Maintenance change announced", "enabled", state.Enabled, "clientCount", sent)
+}
@@ -0,0 +1,91 @@
+package websockets
+
+import (
+	"context"
+	"net"
+	"server/config"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/maintenance"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveWebSocket runs manager behind a real listener and returns its /ws URL.
+func serveWebSocket(t *testing.T, manager *Manager) string {
+	t.Helper()
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", websocket.New(manager.HandleWebSocket))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = app.Listener(listener) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	return "ws://" + listener.Addr().String() + "/ws"
+}
+
+func readMessage(t *testing.T, conn *fasthttpws.Conn) Message {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	var message Message
+	require.NoError(t, conn.ReadJSON(&message))
+	return message
+}
+
+func TestManager_MaintenanceRejectsNewConnections(t *testing.T) {
+	cfg := config.Config{}
+	manager, err := New(database.DB{}, events.New(nil, cfg, nil), cfg, nil)
+	require.NoError(t, err)
+	mode, err := maintenance.New(context.Background(), database.NewMemoryCacheStore(), nil, cfg, nil)
+	require.NoError(t, err)
+	manager.SetMaintenance(mode)
+
+	url := serveWebSocket(t, manager)
+
+	existing, _, err := fasthttpws.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer existing.Close()
+	assert.Equal(t, MessageTypeAuthRequest, readMessage(t, existing).Type)
+	require.Eventually(t, func() bool { return manager.ConnectionCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	_, err = mode.Set(context.Background(), true, "Back soon", "admin-1")
+	require.NoError(t, err)
+
+	// Connected clients are told, whether or not they logged in
+	announcement := readMessage(t, existing)
+	assert.Equal(t, MessageTypeBroadcast, announcement.Type)
+	assert.Equal(t, ErrorCodeMaintenance, announcement.Action)
+	assert.Equal(t, true, announcement.Data["enabled"])
+	assert.Equal(t, "Back soon", announcement.Data["message"])
+
+	rejected, _, err := fasthttpws.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer rejected.Close()
+
+	refusal := readMessage(t, rejected)
+	assert.Equal(t, MessageTypeError, refusal.Type)
+	assert.Equal(t, ErrorCodeMaintenance, refusal.Data["code"])
+	assert.Equal(t, "Back soon", refusal.Data["message"])
+	assert.Equal(t, float64(300), refusal.Data["retryAfter"])
+
+	_, _, err = rejected.ReadMessage()
+	assert.True(t, fasthttpws.IsCloseError(err, fasthttpws.CloseTryAgainLater), "unexpected error: %v", err)
+	assert.Equal(t, 1, manager.ConnectionCount(), "refused connections never join the hub")
+
+	_, err = mode.Set(context.Background(), false, "", "admin-1")
+	require.NoError(t, err)
+	assert.Equal(t, false, readMessage(t, existing).Data["enabled"])
+
+	accepted, _, err := fasthttpws.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer accepted.Close()
+	assert.Equal(t, MessageTypeAuthRequest, readMessage(t, accepted).Type)
+}
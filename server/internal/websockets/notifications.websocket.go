@@ -0,0 +1,130 @@
+package websockets
+
+import (
+	"context"
+	"errors"
+	"server/internal/database"
+	"server/internal/models"
+	"server/internal/repositories"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// How long a user's notification preferences are trusted before they are
+// looked up again. Writes evict them sooner through the invalidator.
+const NOTIFICATION_PREFERENCE_CACHE_EXPIRY = 30 * time.Second
+
+// PreferenceLookup reads a stored preference. repositories.PreferenceRepository
+// implements it.
+type PreferenceLookup interface {
+	Get(ctx context.Context, userID string, key string) (*models.UserPreference, error)
+}
+
+// Stats counts this instance's connections and the deliveries users opted out
+// of since it started. Drain is set while connections are being drained, and
+// RecentDisconnects counts the connections closed in the last
+// DISCONNECT_WINDOW by reason.
+type Stats struct {
+	Connections          int            `json:"connections"`
+	AuthenticatedClients int            `json:"authenticatedClients"`
+	SuppressedBroadcasts uint64         `json:"suppressedBroadcasts"`
+	SuppressedMentions   uint64         `json:"suppressedMentions"`
+	Drain                *DrainProgress `json:"drain,omitempty"`
+	RecentDisconnects    map[string]int `json:"recentDisconnects,omitempty"`
+}
+
+// SetNotificationPreferences makes broadcasts and direct messages respect the
+// recipients' notification preferences. Without it everything is delivered.
+// Call it before clients connect.
+func (m *Manager) SetNotificationPreferences(lookup PreferenceLookup, invalidator *database.Invalidator) {
+	m.preferences = lookup
+	m.notificationCache = database.NewLocalCache[models.NotificationPreferences](
+		NOTIFICATION_PREFERENCE_CACHE_EXPIRY,
+		m.clock,
+	)
+	invalidator.Register(repositories.PREFERENCE_CACHE_ENTITY, m.notificationCache.Delete)
+}
+
+func (m *Manager) Stats() Stats {
+	return Stats{
+		Connections:          m.ConnectionCount(),
+		AuthenticatedClients: m.AuthenticatedClientCount(),
+		SuppressedBroadcasts: m.suppressedBroadcasts.Load(),
+		SuppressedMentions:   m.suppressedMentions.Load(),
+		Drain:                m.DrainProgress(),
+		RecentDisconnects:    m.RecentDisconnects(),
+	}
+}
+
+// notificationPreferences returns what userID asked for, or the defaults when
+// they never set anything or the lookup fails. Failures aren't cached.
+func (m *Manager) notificationPreferences(userID uuid.UUID) models.NotificationPreferences {
+	log := m.log.Function("notificationPreferences")
+
+	id := userID.String()
+	if preferences, ok := m.notificationCache.Get(id); ok {
+		return preferences
+	}
+
+	stored, err := m.preferences.Get(context.Background(), id, models.PREFERENCE_NOTIFICATIONS)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		preferences := models.DefaultNotificationPreferences()
+		m.notificationCache.Set(id, preferences)
+		return preferences
+	case err != nil:
+		log.Warn("failed to load notification preferences, delivering", "userID", id, "error", err)
+		return models.DefaultNotificationPreferences()
+	}
+
+	preferences, err := models.ParseNotificationPreferences([]byte(stored.Value))
+	if err != nil {
+		log.Warn("stored notification preferences are invalid, using defaults", "userID", id, "error", err)
+		preferences = models.DefaultNotificationPreferences()
+	}
+	m.notificationCache.Set(id, preferences)
+	return preferences
+}
+
+// deliveryFilter resolves each user's preferences once, outside the hub lock
+// since a lookup may hit the database, and reports who may receive a message
+// right now.
+func (m *Manager) deliveryFilter(
+	userIDs []uuid.UUID,
+	allows func(models.NotificationPreferences, time.Time) bool,
+) func(uuid.UUID) bool {
+	if m.preferences == nil {
+		return func(uuid.UUID) bool { return true }
+	}
+
+	now := m.now()
+	allowed := make(map[uuid.UUID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if _, seen := allowed[userID]; !seen {
+			allowed[userID] = allows(m.notificationPreferences(userID), now)
+		}
+	}
+
+	return func(userID uuid.UUID) bool {
+		if result, ok := allowed[userID]; ok {
+			return result
+		}
+		// Connected after the snapshot
+		return allows(m.notificationPreferences(userID), now)
+	}
+}
+
+func (m *Manager) authenticatedUserIDs() []uuid.UUID {
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	userIDs := make([]uuid.UUID, 0, len(m.hub.clients))
+	for _, client := range m.hub.clients {
+		if client.Status == StatusAuthenticated {
+			userIDs = append(userIDs, client.UserID)
+		}
+	}
+	return userIDs
+}
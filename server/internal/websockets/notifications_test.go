@@ -0,0 +1,158 @@
+package websockets
+
+import (
+	"context"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/logger"
+	"server/internal/models"
+	"server/internal/repositories"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type fakePreferenceLookup struct {
+	values  map[string]string
+	lookups map[string]int
+}
+
+func (f *fakePreferenceLookup) Get(ctx context.Context, userID string, key string) (*models.UserPreference, error) {
+	f.lookups[userID]++
+	value, ok := f.values[userID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &models.UserPreference{UserID: userID, Key: key, Value: value}, nil
+}
+
+func newNotificationManager(
+	t *testing.T,
+	values map[string]string,
+	clients ...*Client,
+) (*Manager, *fakePreferenceLookup, *database.Invalidator, *clock.Fake) {
+	t.Helper()
+
+	// 23:00 UTC
+	fake := clock.NewFake(time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC))
+	manager := &Manager{
+		hub:   &Hub{clients: make(map[string]*Client)},
+		log:   logger.New("test"),
+		clock: fake,
+	}
+	for _, client := range clients {
+		client.Manager = manager
+		manager.hub.clients[client.ID] = client
+	}
+
+	invalidator, err := database.NewInvalidator(nil)
+	require.NoError(t, err)
+	lookup := &fakePreferenceLookup{values: values, lookups: map[string]int{}}
+	manager.SetNotificationPreferences(lookup, invalidator)
+
+	return manager, lookup, invalidator, fake
+}
+
+func authenticatedClient(id string, userID uuid.UUID) *Client {
+	return &Client{ID: id, UserID: userID, Status: StatusAuthenticated, send: make(chan Message, 10)}
+}
+
+func TestSendToAuthenticatedClients_RespectsPreferences(t *testing.T) {
+	defaults, muted, sleeping := uuid.New(), uuid.New(), uuid.New()
+	clients := []*Client{
+		authenticatedClient("defaults", defaults),
+		authenticatedClient("muted", muted),
+		authenticatedClient("sleeping", sleeping),
+		{ID: "guest", Status: StatusUnauthenticated, send: make(chan Message, 10)},
+	}
+
+	manager, _, _, _ := newNotificationManager(t, map[string]string{
+		muted.String(): `{"broadcasts":false,"mentions":true}`,
+		sleeping.String(): `{"broadcasts":true,"mentions":true,` +
+			`"quietHours":{"start":"22:00","end":"07:00","timezone":"UTC"}}`,
+	}, clients...)
+
+	manager.sendToAuthenticatedClients(Message{ID: "broadcast-1", Type: MessageTypeBroadcast})
+
+	assert.Equal(t, "broadcast-1", receive(t, clients[0]).ID)
+	assert.Empty(t, clients[1].send)
+	assert.Empty(t, clients[2].send)
+	assert.Empty(t, clients[3].send)
+
+	stats := manager.Stats()
+	assert.Equal(t, uint64(2), stats.SuppressedBroadcasts)
+	assert.Zero(t, stats.SuppressedMentions)
+	assert.Equal(t, 4, stats.Connections)
+	assert.Equal(t, 3, stats.AuthenticatedClients)
+}
+
+func TestSendMessageToUser_RespectsPreferences(t *testing.T) {
+	listening, muted := uuid.New(), uuid.New()
+	clients := []*Client{
+		authenticatedClient("listening", listening),
+		authenticatedClient("muted-phone", muted),
+		authenticatedClient("muted-laptop", muted),
+	}
+
+	manager, _, _, fake := newNotificationManager(t, map[string]string{
+		listening.String(): `{"broadcasts":false,"mentions":true,` +
+			`"quietHours":{"start":"08:00","end":"09:00","timezone":"UTC"}}`,
+		muted.String(): `{"broadcasts":true,"mentions":false}`,
+	}, clients...)
+
+	manager.SendMessageToUser(listening, Message{ID: "mention-1"})
+	manager.SendMessageToUser(muted, Message{ID: "mention-2"})
+
+	assert.Equal(t, "mention-1", receive(t, clients[0]).ID)
+	assert.Empty(t, clients[1].send)
+	assert.Empty(t, clients[2].send)
+	assert.Equal(t, uint64(2), manager.Stats().SuppressedMentions, "one per suppressed connection")
+
+	// Inside the listening user's quiet hours
+	fake.Set(time.Date(2024, 1, 16, 8, 30, 0, 0, time.UTC))
+	manager.SendMessageToUser(listening, Message{ID: "mention-3"})
+	assert.Empty(t, clients[0].send)
+	assert.Equal(t, uint64(3), manager.Stats().SuppressedMentions)
+	assert.Zero(t, manager.Stats().SuppressedBroadcasts)
+}
+
+func TestNotificationPreferences_CachedUntilInvalidated(t *testing.T) {
+	userID := uuid.New()
+	client := authenticatedClient("client", userID)
+	manager, lookup, invalidator, fake := newNotificationManager(t, map[string]string{}, client)
+
+	manager.sendToAuthenticatedClients(Message{ID: "one"})
+	manager.sendToAuthenticatedClients(Message{ID: "two"})
+	assert.Equal(t, 1, lookup.lookups[userID.String()], "the second broadcast is served from the cache")
+	receive(t, client)
+	receive(t, client)
+
+	// A write elsewhere evicts the cached defaults
+	lookup.values[userID.String()] = `{"broadcasts":false,"mentions":true}`
+	require.NoError(t, invalidator.Invalidate(context.Background(), repositories.PREFERENCE_CACHE_ENTITY, userID.String()))
+
+	manager.sendToAuthenticatedClients(Message{ID: "three"})
+	assert.Equal(t, 2, lookup.lookups[userID.String()])
+	assert.Empty(t, client.send)
+
+	// And cached entries expire on their own
+	fake.Advance(NOTIFICATION_PREFERENCE_CACHE_EXPIRY)
+	manager.sendToAuthenticatedClients(Message{ID: "four"})
+	assert.Equal(t, 3, lookup.lookups[userID.String()])
+}
+
+func TestNotificationPreferences_NotConfiguredDeliversEverything(t *testing.T) {
+	client := authenticatedClient("client", uuid.New())
+	manager := newPublicChannelManager(client)
+
+	manager.sendToAuthenticatedClients(Message{ID: "broadcast"})
+	manager.SendMessageToUser(client.UserID, Message{ID: "mention"})
+
+	assert.Equal(t, "broadcast", receive(t, client).ID)
+	assert.Equal(t, "mention", receive(t, client).ID)
+	assert.Equal(t, Stats{Connections: 1, AuthenticatedClients: 1}, manager.Stats())
+}
@@ -0,0 +1,76 @@
+package websockets
+
+import (
+	"server/internal/notifier"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+const ErrorCodeDisconnected = "disconnected"
+
+var _ notifier.WebsocketNotifier = (*Manager)(nil)
+
+// SendToUser is SendMessageToUser for callers holding the user ID as a
+// string.
+func (m *Manager) SendToUser(userID string, message Message) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+	m.SendMessageToUser(id, message)
+	return nil
+}
+
+// DisconnectUser closes every authenticated connection of the user on this
+// instance, after telling each one reason, e.g. because an admin logged the
+// user out. The connections lose their resume tokens, so they have to log in
+// again. It returns how many it closed.
+func (m *Manager) DisconnectUser(userID string, reason string) int {
+	log := m.log.Function("DisconnectUser")
+
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return 0
+	}
+
+	message := Message{
+		ID:      uuid.New().String(),
+		Type:    MessageTypeError,
+		Channel: "system",
+		Action:  ErrorCodeDisconnected,
+		Data: map[string]any{
+			"code":   ErrorCodeDisconnected,
+			"reason": reason,
+		},
+		Timestamp: m.now(),
+	}
+	closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+
+	m.hub.mutex.Lock()
+	var clients []*Client
+	for _, client := range m.hub.clients {
+		if client.Status != StatusAuthenticated || client.UserID != id {
+			continue
+		}
+		select {
+		case client.send <- message:
+		default:
+			client.dropped.Add(1)
+			log.Warn("Client send channel full, dropping disconnect message", "clientID", client.ID)
+		}
+		client.resumeToken = ""
+		client.closeMessage = closing
+		client.setDisconnectReason(DISCONNECT_KICKED)
+		clients = append(clients, client)
+	}
+	m.hub.mutex.Unlock()
+
+	for _, client := range clients {
+		m.hub.unregister <- client
+	}
+	if len(clients) > 0 {
+		log.Info("User disconnected", "userID", userID, "reason", reason, "connections", len(clients))
+	}
+	return len(clients)
+}
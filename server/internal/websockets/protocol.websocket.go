@@ -0,0 +1,107 @@
+package websockets
+
+import (
+	"encoding/json"
+	"fmt"
+	"server/internal/models"
+	"slices"
+)
+
+const (
+	ProtocolVersion1 = 1
+	ProtocolVersion2 = 2
+
+	// Clients built before versioning existed never send a version, so they
+	// are treated as v1.
+	DefaultProtocolVersion = ProtocolVersion1
+
+	ErrorCodeUnsupportedProtocol = "unsupported_protocol"
+)
+
+// MessageEncoder renders a Message in the wire shape of one protocol version.
+type MessageEncoder func(message Message) ([]byte, error)
+
+var messageEncoders = map[int]MessageEncoder{
+	ProtocolVersion1: encodeMessageV1,
+	ProtocolVersion2: encodeMessageV2,
+}
+
+// SupportedProtocolVersions lists the versions this server can speak, oldest
+// first.
+func SupportedProtocolVersions() []int {
+	versions := make([]int, 0, len(messageEncoders))
+	for version := range messageEncoders {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+func IsSupportedProtocolVersion(version int) bool {
+	_, ok := messageEncoders[version]
+	return ok
+}
+
+// ProtocolVersion is the version the client declared as it authenticated,
+// DefaultProtocolVersion until then.
+func (c *Client) ProtocolVersion() int {
+	if version := c.version.Load(); version != 0 {
+		return int(version)
+	}
+	return DefaultProtocolVersion
+}
+
+// EncodeMessage renders message for a client speaking the given version.
+func EncodeMessage(version int, message Message) ([]byte, error) {
+	encoder, ok := messageEncoders[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported protocol version: %d", version)
+	}
+	return encoder(message)
+}
+
+// messageV1 is the original envelope. It must not change shape; add fields
+// to a newer version instead.
+type messageV1 struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	Channel   string         `json:"channel,omitempty"`
+	Action    string         `json:"action,omitempty"`
+	UserID    string         `json:"userId,omitempty"`
+	Data      map[string]any `json:"data,omitempty"`
+	Timestamp models.APITime `json:"timestamp"`
+}
+
+type messageV2 struct {
+	messageV1
+	Version      int    `json:"version"`
+	Sequence     uint64 `json:"sequence"`
+	AckID        string `json:"ackId,omitempty"`
+	UserSequence uint64 `json:"userSequence,omitempty"`
+}
+
+func encodeMessageV1(message Message) ([]byte, error) {
+	return json.Marshal(toMessageV1(message))
+}
+
+func encodeMessageV2(message Message) ([]byte, error) {
+	return json.Marshal(messageV2{
+		messageV1:    toMessageV1(message),
+		Version:      ProtocolVersion2,
+		Sequence:     message.Sequence,
+		AckID:        message.AckID,
+		UserSequence: message.UserSequence,
+	})
+}
+
+func toMessageV1(message Message) messageV1 {
+	return messageV1{
+		ID:        message.ID,
+		Type:      message.Type,
+		Channel:   message.Channel,
+		Action:    message.Action,
+		UserID:    message.UserID,
+		Data:      message.Data,
+		Timestamp: models.NewAPITime(message.Timestamp),
+	}
+}
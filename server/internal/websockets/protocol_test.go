@@ -0,0 +1,265 @@
+package websockets
+
+import (
+	"encoding/json"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/logger"
+	"server/internal/utils/testsupport"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupProtocolTest(t *testing.T) (*Client, string) {
+	testConfig := testsupport.WithKey(config.Config{})
+	frozen := testsupport.FreezeTime(t)
+
+	token := testsupport.MintTestToken(t, uuid.New(), testsupport.TokenOptions{Clock: frozen})
+
+	client := &Client{
+		ID:      "test-client",
+		Status:  StatusUnauthenticated,
+		Manager: &Manager{hub: &Hub{clients: map[string]*Client{}}, log: logger.New("test"), config: testConfig, clock: frozen},
+		send:    make(chan Message, 10),
+	}
+
+	return client, token
+}
+
+func receiveMessage(t *testing.T, client *Client) Message {
+	select {
+	case message := <-client.send:
+		return message
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("expected a message")
+		return Message{}
+	}
+}
+
+func decodeWire(t *testing.T, data []byte) map[string]any {
+	var wire map[string]any
+	require.NoError(t, json.Unmarshal(data, &wire))
+	return wire
+}
+
+func TestSupportedProtocolVersions(t *testing.T) {
+	assert.Equal(t, []int{ProtocolVersion1, ProtocolVersion2}, SupportedProtocolVersions())
+	assert.True(t, IsSupportedProtocolVersion(ProtocolVersion1))
+	assert.True(t, IsSupportedProtocolVersion(ProtocolVersion2))
+	assert.False(t, IsSupportedProtocolVersion(0))
+	assert.False(t, IsSupportedProtocolVersion(99))
+}
+
+func TestHandleAuthResponse_NegotiatesVersion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		declared int
+		expected int
+	}{
+		{"legacy client without version", 0, ProtocolVersion1},
+		{"v1 client", ProtocolVersion1, ProtocolVersion1},
+		{"v2 client", ProtocolVersion2, ProtocolVersion2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, token := setupProtocolTest(t)
+
+			client.routeMessage(Message{
+				Type:    MessageTypeAuthResponse,
+				Version: tc.declared,
+				Data:    map[string]any{"token": token},
+			})
+
+			assert.Equal(t, StatusAuthenticated, client.Status)
+			assert.Equal(t, tc.expected, client.ProtocolVersion())
+
+			success := receiveMessage(t, client)
+			assert.Equal(t, MessageTypeAuthSuccess, success.Type)
+			assert.Equal(t, tc.expected, success.Data["version"])
+		})
+	}
+}
+
+func TestHandleAuthResponse_RejectsForeignTokens(t *testing.T) {
+	testCases := []struct {
+		name   string
+		claims config.SecurityConfig
+		reason string
+	}{
+		{"wrong issuer", config.SecurityConfig{JwtIssuer: "other-service"}, "Token was not issued for this service"},
+		{"wrong audience", config.SecurityConfig{JwtAudience: "other-service"}, "Token was not issued for this service"},
+		{"bad signature", config.SecurityConfig{JwtSecret: "some-other-secret"}, "Invalid token"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, _ := setupProtocolTest(t)
+			foreign := client.Manager.config
+			foreign.Security.JwtIssuer = tc.claims.JwtIssuer
+			foreign.Security.JwtAudience = tc.claims.JwtAudience
+			if tc.claims.JwtSecret != "" {
+				foreign.Security.JwtSecret = tc.claims.JwtSecret
+			}
+			token := testsupport.MintTestToken(t, uuid.New(), testsupport.TokenOptions{
+				Clock:  client.Manager.clock,
+				Config: foreign,
+			})
+
+			client.routeMessage(Message{Type: MessageTypeAuthResponse, Data: map[string]any{"token": token}})
+
+			assert.Equal(t, StatusUnauthenticated, client.Status)
+			failure := receiveMessage(t, client)
+			assert.Equal(t, MessageTypeAuthFailure, failure.Type)
+			assert.Equal(t, tc.reason, failure.Data["reason"])
+		})
+	}
+}
+
+func TestHandleAuthResponse_ExpiryBoundary(t *testing.T) {
+	testCases := []struct {
+		name          string
+		beforeExpiry  time.Duration
+		authenticated bool
+	}{
+		{"just before expiry", time.Nanosecond, true},
+		{"at expiry", 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, token := setupProtocolTest(t)
+			frozen := client.Manager.clock.(*clock.Fake)
+			frozen.Set(testsupport.EPOCH.Add(testsupport.TOKEN_TTL - tc.beforeExpiry))
+
+			client.routeMessage(Message{Type: MessageTypeAuthResponse, Data: map[string]any{"token": token}})
+
+			reply := receiveMessage(t, client)
+			if tc.authenticated {
+				assert.Equal(t, MessageTypeAuthSuccess, reply.Type)
+				assert.Equal(t, StatusAuthenticated, client.Status)
+				return
+			}
+			assert.Equal(t, MessageTypeAuthFailure, reply.Type)
+			assert.Equal(t, "Invalid token", reply.Data["reason"])
+			assert.Equal(t, StatusUnauthenticated, client.Status)
+		})
+	}
+}
+
+func TestHandleAuthResponse_UnsupportedVersion(t *testing.T) {
+	client, token := setupProtocolTest(t)
+
+	client.routeMessage(Message{
+		Type:    MessageTypeAuthResponse,
+		Version: 99,
+		Data:    map[string]any{"token": token},
+	})
+
+	assert.Equal(t, StatusUnauthenticated, client.Status)
+	assert.Equal(t, DefaultProtocolVersion, client.ProtocolVersion())
+
+	failure := receiveMessage(t, client)
+	assert.Equal(t, MessageTypeError, failure.Type)
+	assert.Equal(t, ErrorCodeUnsupportedProtocol, failure.Data["code"])
+	assert.Equal(t, 99, failure.Data["version"])
+	assert.Equal(t, SupportedProtocolVersions(), failure.Data["supportedVersions"])
+}
+
+func TestRouteMessage_UnsupportedVersionAfterAuth(t *testing.T) {
+	client, _ := setupProtocolTest(t)
+	client.Status = StatusAuthenticated
+
+	client.routeMessage(Message{Type: MessageTypeMessage, Channel: "user", Version: 7})
+
+	failure := receiveMessage(t, client)
+	assert.Equal(t, ErrorCodeUnsupportedProtocol, failure.Data["code"])
+}
+
+func TestEncodeMessage_WireShapes(t *testing.T) {
+	message := Message{
+		ID:        "message-1",
+		Type:      MessageTypeBroadcast,
+		Channel:   "system",
+		Action:    "broadcast",
+		Data:      map[string]any{"message": "hello"},
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Sequence:  5,
+		AckID:     "client-message-1",
+	}
+
+	v1, err := EncodeMessage(ProtocolVersion1, message)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"id":        "message-1",
+		"type":      MessageTypeBroadcast,
+		"channel":   "system",
+		"action":    "broadcast",
+		"data":      map[string]any{"message": "hello"},
+		"timestamp": "2024-01-02T03:04:05.000Z",
+	}, decodeWire(t, v1))
+
+	v2, err := EncodeMessage(ProtocolVersion2, message)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"id":        "message-1",
+		"type":      MessageTypeBroadcast,
+		"channel":   "system",
+		"action":    "broadcast",
+		"data":      map[string]any{"message": "hello"},
+		"timestamp": "2024-01-02T03:04:05.000Z",
+		"version":   float64(ProtocolVersion2),
+		"sequence":  float64(5),
+		"ackId":     "client-message-1",
+	}, decodeWire(t, v2))
+
+	_, err = EncodeMessage(99, message)
+	assert.Error(t, err)
+}
+
+func TestClientEncodeMessage_SequencesV2Only(t *testing.T) {
+	message := Message{ID: "message-1", Type: MessageTypePing}
+
+	v1Client := &Client{}
+	v1Client.version.Store(ProtocolVersion1)
+	for range 2 {
+		data, err := v1Client.encodeMessage(message)
+		require.NoError(t, err)
+		assert.NotContains(t, decodeWire(t, data), "sequence")
+	}
+
+	v2Client := &Client{}
+	v2Client.version.Store(ProtocolVersion2)
+	for expected := 1; expected <= 3; expected++ {
+		data, err := v2Client.encodeMessage(message)
+		require.NoError(t, err)
+		assert.Equal(t, float64(expected), decodeWire(t, data)["sequence"])
+	}
+}
+
+// Run with -race: the write pump reads the version while the read pump sets
+// it from auth_response.
+func TestClient_ProtocolVersionSetWhileWriting(t *testing.T) {
+	client, token := setupProtocolTest(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			_, err := client.encodeMessage(Message{ID: "message-1", Type: MessageTypePing})
+			assert.NoError(t, err)
+		}
+	}()
+	client.routeMessage(Message{
+		Type:    MessageTypeAuthResponse,
+		Version: ProtocolVersion2,
+		Data:    map[string]any{"token": token},
+	})
+	<-done
+
+	assert.Equal(t, ProtocolVersion2, client.ProtocolVersion())
+}
@@ -0,0 +1,93 @@
+package websockets
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const ErrorCodeChannelNotSubscribable = "channel_not_subscribable"
+
+var ErrNotPublicChannel = errors.New("channel is not public")
+
+// IsPublicChannel reports whether channel is in the configured allowlist of
+// channels guests may subscribe to without authenticating.
+func (m *Manager) IsPublicChannel(channel string) bool {
+	if channel == "" {
+		return false
+	}
+
+	for public := range strings.SplitSeq(m.config.Websocket.PublicChannels, ",") {
+		if strings.TrimSpace(public) == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSubscription subscribes or unsubscribes the client from a public
+// channel. Callers make sure guests only get here for public channels.
+func (c *Client) handleSubscription(message Message) {
+	log := c.Manager.log.Function("handleSubscription")
+
+	if !c.Manager.IsPublicChannel(message.Channel) {
+		log.Warn(
+			"Subscription to non-public channel rejected",
+			"clientID", c.ID,
+			"channel", SanitizeLogString(message.Channel),
+		)
+		c.send <- Message{
+			ID:        uuid.New().String(),
+			Type:      MessageTypeError,
+			Channel:   "system",
+			Action:    ErrorCodeChannelNotSubscribable,
+			Data:      map[string]any{"code": ErrorCodeChannelNotSubscribable, "channel": message.Channel},
+			Timestamp: c.Manager.now(),
+		}
+		return
+	}
+
+	subscribe := message.Type == MessageTypeSubscribe
+	c.Manager.setSubscription(c, message.Channel, subscribe)
+	c.Manager.saveResumeState(c)
+
+	replyType := MessageTypeUnsubscribed
+	if subscribe {
+		replyType = MessageTypeSubscribed
+	}
+	c.send <- Message{
+		ID:        uuid.New().String(),
+		Type:      replyType,
+		Channel:   message.Channel,
+		Timestamp: c.Manager.now(),
+	}
+
+	log.Info("Subscription updated", "clientID", c.ID, "channel", message.Channel, "subscribed", subscribe)
+}
+
+func (m *Manager) setSubscription(client *Client, channel string, subscribed bool) {
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+
+	if !subscribed {
+		delete(client.subscriptions, channel)
+		return
+	}
+	if client.subscriptions == nil {
+		client.subscriptions = make(map[string]bool)
+	}
+	client.subscriptions[channel] = true
+}
+
+// BroadcastToPublicChannel sends message to every subscriber of a public
+// channel, guests included, and returns how many clients it reached. Unlike
+// the other send paths it doesn't require authentication.
+func (m *Manager) BroadcastToPublicChannel(channel string, message Message) (int, error) {
+	if !m.IsPublicChannel(channel) {
+		return 0, ErrNotPublicChannel
+	}
+
+	sent, _ := m.BroadcastToChannel(channel, message)
+	return sent, nil
+}
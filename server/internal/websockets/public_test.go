@@ -0,0 +1,145 @@
+package websockets
+
+import (
+	"server/config"
+	"server/internal/logger"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPublicChannelManager(clients ...*Client) *Manager {
+	manager := &Manager{
+		hub:    &Hub{clients: make(map[string]*Client)},
+		log:    logger.New("test"),
+		config: config.Config{Websocket: config.WebsocketConfig{PublicChannels: "dashboard, status"}},
+	}
+	for _, client := range clients {
+		client.Manager = manager
+		manager.hub.clients[client.ID] = client
+	}
+	return manager
+}
+
+func receive(t *testing.T, client *Client) Message {
+	t.Helper()
+	select {
+	case message := <-client.send:
+		return message
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("expected a message for client %s", client.ID)
+		return Message{}
+	}
+}
+
+func TestManager_IsPublicChannel(t *testing.T) {
+	manager := newPublicChannelManager()
+
+	assert.True(t, manager.IsPublicChannel("dashboard"))
+	assert.True(t, manager.IsPublicChannel("status"))
+	assert.False(t, manager.IsPublicChannel("user"))
+	assert.False(t, manager.IsPublicChannel(""))
+
+	manager.config.Websocket.PublicChannels = ""
+	assert.False(t, manager.IsPublicChannel("dashboard"))
+}
+
+func TestRouteMessage_GuestSubscribesToPublicChannel(t *testing.T) {
+	guest := &Client{ID: "guest", Status: StatusUnauthenticated, send: make(chan Message, 10)}
+	newPublicChannelManager(guest)
+
+	guest.routeMessage(Message{Type: MessageTypeSubscribe, Channel: "dashboard"})
+
+	ack := receive(t, guest)
+	assert.Equal(t, MessageTypeSubscribed, ack.Type)
+	assert.Equal(t, "dashboard", ack.Channel)
+	assert.True(t, guest.subscriptions["dashboard"])
+	assert.Equal(t, StatusUnauthenticated, guest.Status)
+
+	guest.routeMessage(Message{Type: MessageTypeUnsubscribe, Channel: "dashboard"})
+
+	ack = receive(t, guest)
+	assert.Equal(t, MessageTypeUnsubscribed, ack.Type)
+	assert.False(t, guest.subscriptions["dashboard"])
+}
+
+func TestRouteMessage_GuestNonPublicSubscribeRejected(t *testing.T) {
+	guest := &Client{ID: "guest", Status: StatusUnauthenticated, send: make(chan Message, 10)}
+	newPublicChannelManager(guest)
+
+	guest.routeMessage(Message{Type: MessageTypeSubscribe, Channel: "admin"})
+
+	failure := receive(t, guest)
+	assert.Equal(t, MessageTypeAuthFailure, failure.Type)
+	assert.Equal(t, "authentication_required", failure.Action)
+	assert.Empty(t, guest.subscriptions)
+
+	// Anything other than a subscription still requires authentication.
+	guest.routeMessage(Message{Type: MessageTypeMessage, Channel: "dashboard"})
+	failure = receive(t, guest)
+	assert.Equal(t, MessageTypeAuthFailure, failure.Type)
+}
+
+func TestRouteMessage_AuthenticatedNonPublicSubscribeRejected(t *testing.T) {
+	client := &Client{ID: "auth", Status: StatusAuthenticated, UserID: uuid.New(), send: make(chan Message, 10)}
+	newPublicChannelManager(client)
+
+	client.routeMessage(Message{Type: MessageTypeSubscribe, Channel: "admin"})
+
+	reply := receive(t, client)
+	assert.Equal(t, MessageTypeError, reply.Type)
+	assert.Equal(t, ErrorCodeChannelNotSubscribable, reply.Action)
+	assert.Empty(t, client.subscriptions)
+}
+
+func TestManager_BroadcastToPublicChannel_FansOutToBothClasses(t *testing.T) {
+	guest := &Client{ID: "guest", Status: StatusUnauthenticated, send: make(chan Message, 10)}
+	member := &Client{ID: "member", Status: StatusAuthenticated, UserID: uuid.New(), send: make(chan Message, 10)}
+	other := &Client{ID: "other", Status: StatusAuthenticated, UserID: uuid.New(), send: make(chan Message, 10)}
+	manager := newPublicChannelManager(guest, member, other)
+
+	guest.routeMessage(Message{Type: MessageTypeSubscribe, Channel: "dashboard"})
+	member.routeMessage(Message{Type: MessageTypeSubscribe, Channel: "dashboard"})
+	receive(t, guest)
+	receive(t, member)
+
+	sent, err := manager.BroadcastToPublicChannel("dashboard", Message{
+		Type: MessageTypeMessage,
+		Data: map[string]any{"visitors": 42},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+
+	for _, client := range []*Client{guest, member} {
+		message := receive(t, client)
+		assert.Equal(t, "dashboard", message.Channel)
+		assert.Equal(t, 42, message.Data["visitors"])
+		assert.NotEmpty(t, message.ID)
+	}
+	assert.Empty(t, other.send, "clients not subscribed must not receive public broadcasts")
+
+	_, err = manager.BroadcastToPublicChannel("admin", Message{Type: MessageTypeMessage})
+	assert.ErrorIs(t, err, ErrNotPublicChannel)
+}
+
+func TestManager_GuestsExcludedFromPresenceAndUserSends(t *testing.T) {
+	guest := &Client{ID: "guest", Status: StatusUnauthenticated, send: make(chan Message, 10)}
+	member := &Client{ID: "member", Status: StatusAuthenticated, UserID: uuid.New(), send: make(chan Message, 10)}
+	manager := newPublicChannelManager(guest, member)
+
+	guest.routeMessage(Message{Type: MessageTypeSubscribe, Channel: "status"})
+	receive(t, guest)
+
+	assert.Equal(t, 1, manager.AuthenticatedClientCount())
+
+	// Guests have no user, so even a zero UUID must not reach them.
+	manager.SendMessageToUser(uuid.Nil, Message{Type: MessageTypeMessage})
+	assert.Empty(t, guest.send)
+
+	manager.SendMessageToUser(member.UserID, Message{Type: MessageTypeMessage})
+	assert.Len(t, member.send, 1)
+	assert.Empty(t, guest.send)
+}
@@ -0,0 +1,142 @@
+package websockets
+
+import (
+	"context"
+	"server/config"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/utils/testsupport"
+	"server/internal/websockets/wstest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// servePeer serves one end of a wstest pipe with a manager whose pings and
+// pong timeout are shortened to the given values, and returns the other end
+// once it has the auth request. done closes when Serve returns.
+func servePeer(t *testing.T, pingInterval, pongTimeout time.Duration) (*Manager, *wstest.Peer, chan struct{}) {
+	t.Helper()
+	cfg := testsupport.WithKey(config.Config{})
+	manager, err := New(database.DB{}, events.New(nil, cfg, nil), cfg, testsupport.FreezeTime(t))
+	require.NoError(t, err)
+	manager.pingInterval = pingInterval
+	manager.pongTimeout = pongTimeout
+
+	peer, done := connectPeer(t, manager)
+	return manager, peer, done
+}
+
+// connectPeer serves one end of a wstest pipe on manager and returns the
+// other once it has the auth request.
+func connectPeer(t *testing.T, manager *Manager) (*wstest.Peer, chan struct{}) {
+	t.Helper()
+	server, peer := wstest.Pipe(t)
+	return peer, servePipe(t, manager, server, peer)
+}
+
+// servePipe serves server on manager, returning once peer has the auth
+// request. The returned channel closes when Serve returns.
+func servePipe(t *testing.T, manager *Manager, server Conn, peer *wstest.Peer) chan struct{} {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		manager.Serve(server)
+	}()
+
+	var authRequest Message
+	peer.Receive(&authRequest)
+	require.Equal(t, MessageTypeAuthRequest, authRequest.Type)
+	return done
+}
+
+func waitServed(t *testing.T, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Serve still running")
+	}
+}
+
+func TestReadPump_ClosesSilentPeer(t *testing.T) {
+	manager, peer, done := servePeer(t, time.Hour, 50*time.Millisecond)
+
+	// Nothing arrives, not even a pong, before the read deadline
+	require.Error(t, peer.WaitClosed(time.Second))
+	waitServed(t, done)
+	assert.Zero(t, manager.ConnectionCount())
+}
+
+func TestReadPump_PongsExtendDeadline(t *testing.T) {
+	_, peer, _ := servePeer(t, 10*time.Millisecond, 100*time.Millisecond)
+
+	// Several pong timeouts pass, each pong moving the deadline on
+	peer.ExpectNoMessage(400 * time.Millisecond)
+	assert.False(t, peer.Closed())
+
+	peer.AnswerPings(false)
+	require.Error(t, peer.WaitClosed(time.Second))
+}
+
+func TestWritePump_SendsPings(t *testing.T) {
+	_, peer, _ := servePeer(t, 20*time.Millisecond, time.Hour)
+
+	for range 3 {
+		peer.WaitPing(time.Second)
+	}
+}
+
+func TestServe_Lifecycle(t *testing.T) {
+	manager, peer, done := servePeer(t, time.Hour, time.Hour)
+	require.Eventually(t, func() bool { return manager.ConnectionCount() == 1 }, time.Second, time.Millisecond)
+	assert.Zero(t, manager.AuthenticatedClientCount())
+
+	// Guests are told to authenticate first
+	peer.Send(Message{Type: MessageTypeMessage, Channel: "user"})
+	var refused Message
+	peer.Receive(&refused)
+	assert.Equal(t, MessageTypeAuthFailure, refused.Type)
+
+	userID := uuid.New()
+	token := testsupport.MintTestToken(t, userID, testsupport.TokenOptions{Clock: manager.clock})
+	peer.Send(Message{Type: MessageTypeAuthResponse, Data: map[string]any{"token": token}})
+	var authSuccess Message
+	peer.Receive(&authSuccess)
+	require.Equal(t, MessageTypeAuthSuccess, authSuccess.Type)
+	assert.Equal(t, userID.String(), authSuccess.Data["userId"])
+	assert.Equal(t, 1, manager.AuthenticatedClientCount())
+
+	require.NoError(t, manager.eventBus.BroadcastTopic().Publish(context.Background(), events.AdminBroadcastEvent{
+		ID:      "announcement-1",
+		Message: "Back soon",
+		SentBy:  "admin-1",
+	}))
+	var broadcast Message
+	peer.Receive(&broadcast)
+	assert.Equal(t, MessageTypeBroadcast, broadcast.Type)
+	assert.Equal(t, "Back soon", broadcast.Data["message"])
+	assert.NotContains(t, broadcast.Data, "sentBy")
+
+	require.NoError(t, peer.Close())
+	waitServed(t, done)
+	assert.Zero(t, manager.ConnectionCount())
+}
+
+func TestServe_UnsupportedVersionDisconnects(t *testing.T) {
+	manager, peer, done := servePeer(t, time.Hour, time.Hour)
+
+	// An unsupported version is answered, then the connection is closed
+	peer.Send(Message{Type: MessageTypeAuthResponse, Version: 99, Data: map[string]any{"token": "t"}})
+	var rejection Message
+	peer.Receive(&rejection)
+	assert.Equal(t, ErrorCodeUnsupportedProtocol, rejection.Data["code"])
+
+	require.Error(t, peer.WaitClosed(time.Second))
+	waitServed(t, done)
+	assert.Zero(t, manager.ConnectionCount())
+}
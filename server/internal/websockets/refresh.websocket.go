@@ -0,0 +1,62 @@
+package websockets
+
+import (
+	"context"
+	"server/internal/events"
+
+	"github.com/google/uuid"
+)
+
+func (m *Manager) subscribeToSessionRefreshedEvents() {
+	log := m.log.Function("subscribeToSessionRefreshedEvents")
+
+	topic := m.eventBus.SessionRefreshedTopic()
+	err := topic.Subscribe(func(ctx context.Context, event events.SessionRefreshedEvent) error {
+		userID, err := uuid.Parse(event.UserID)
+		if err != nil {
+			return err
+		}
+		m.moveSession(event.PreviousSessionID, event.SessionID, event.ExpiresAt)
+		m.SendTokenRefresh(userID, event.Token)
+		return nil
+	})
+	if err != nil {
+		log.Er("Failed to subscribe to session refreshed events", err)
+	}
+}
+
+// SendTokenRefresh hands token, from a refreshed session, to userID's
+// authenticated clients so they can store it without another HTTP call. It
+// never goes to anyone else's clients, and notification preferences don't
+// apply to it.
+func (m *Manager) SendTokenRefresh(userID uuid.UUID, token string) {
+	log := m.log.Function("SendTokenRefresh")
+
+	message := Message{
+		ID:        uuid.New().String(),
+		Type:      MessageTypeTokenRefresh,
+		Channel:   "system",
+		Action:    "token_refreshed",
+		Data:      map[string]any{"token": token},
+		Timestamp: m.now(),
+	}
+
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	sent := 0
+	for _, client := range m.hub.clients {
+		if client.Status != StatusAuthenticated || client.UserID != userID {
+			continue
+		}
+		select {
+		case client.send <- message:
+			sent++
+		default:
+			client.dropped.Add(1)
+			log.Warn("Client send channel full, dropping token refresh", "clientID", client.ID)
+		}
+	}
+
+	log.Info("Token refresh sent", "userID", userID, "clientCount", sent)
+}
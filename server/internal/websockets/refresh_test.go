@@ -0,0 +1,60 @@
+package websockets
+
+import (
+	"context"
+	"server/config"
+	"server/internal/events"
+	"server/internal/logger"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendTokenRefresh_OnlyToUsersClients(t *testing.T) {
+	userID, otherUserID := uuid.New(), uuid.New()
+	phone := authenticatedClient("phone", userID)
+	tablet := authenticatedClient("tablet", userID)
+	other := authenticatedClient("other", otherUserID)
+	// Not yet authenticated, so it can't be trusted with the user's token
+	pending := &Client{ID: "pending", UserID: userID, Status: StatusUnauthenticated, send: make(chan Message, 10)}
+
+	manager, _, _, _ := newNotificationManager(t, nil, phone, tablet, other, pending)
+	manager.SendTokenRefresh(userID, "refreshed-token")
+
+	for _, client := range []*Client{phone, tablet} {
+		message := receive(t, client)
+		assert.Equal(t, MessageTypeTokenRefresh, message.Type)
+		assert.Equal(t, "system", message.Channel)
+		assert.Equal(t, "refreshed-token", message.Data["token"])
+	}
+	assert.Empty(t, other.send)
+	assert.Empty(t, pending.send)
+}
+
+func TestSubscribeToSessionRefreshedEvents(t *testing.T) {
+	userID, otherUserID := uuid.New(), uuid.New()
+	client := authenticatedClient("client", userID)
+	other := authenticatedClient("other", otherUserID)
+
+	eventBus := events.New(nil, config.Config{}, nil)
+	manager := &Manager{hub: &Hub{clients: map[string]*Client{}}, log: logger.New("test"), eventBus: eventBus}
+	for _, c := range []*Client{client, other} {
+		c.Manager = manager
+		manager.hub.clients[c.ID] = c
+	}
+	manager.subscribeToSessionRefreshedEvents()
+
+	require.NoError(t, eventBus.SessionRefreshedTopic().Publish(context.Background(), events.SessionRefreshedEvent{
+		UserID:    userID.String(),
+		SessionID: "session-2",
+		Token:     "refreshed-token",
+	}))
+
+	message := receive(t, client)
+	assert.Equal(t, MessageTypeTokenRefresh, message.Type)
+	assert.Equal(t, "refreshed-token", message.Data["token"])
+	assert.Never(t, func() bool { return len(other.send) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+}
@@ -0,0 +1,284 @@
+package websockets
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"maps"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/models"
+	"server/internal/repositories"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// How long a resume token is good for after it was last saved. The state
+	// is saved again when the connection closes, so this is the window a
+	// dropped client has to reconnect.
+	RESUME_TOKEN_TTL           = 2 * time.Minute
+	RESUME_TOKEN_KEY           = "ws_resume:%s"
+	RESUME_REVOKED_KEY         = "ws_resume_revoked:%s"
+	RESUME_SESSION_REVOKED_KEY = "ws_resume_revoked_session:%s"
+)
+
+var ErrInvalidResumeToken = errors.New("resume token is invalid, used or expired")
+
+// ResumeState is what a resume token stands for: the identity and public
+// channel subscriptions of the connection it was issued to.
+type ResumeState struct {
+	UserID        uuid.UUID `json:"userId"`
+	ClientID      string    `json:"clientId"`
+	Subscriptions []string  `json:"subscriptions"`
+	IssuedAt      time.Time `json:"issuedAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	// The session and expiry of the JWT the connection authenticated with.
+	// A resume never outlives either.
+	SessionID      string    `json:"sessionId,omitempty"`
+	TokenExpiresAt time.Time `json:"tokenExpiresAt,omitzero"`
+}
+
+// SessionLookup finds the session a client's JWT was issued for.
+// repositories.SessionRepository implements it.
+type SessionLookup interface {
+	GetByID(ctx context.Context, id string) (*models.Session, error)
+}
+
+// SetSessionLookup has resume tokens refused once the session they were
+// issued under has ended. Without it only revocation and the JWT expiry
+// limit them.
+func (m *Manager) SetSessionLookup(lookup SessionLookup) {
+	m.sessions = lookup
+}
+
+// SetResumeStore hands authenticated clients a resume token they can
+// reconnect with instead of a JWT, and revokes a session's tokens when it is
+// logged out. Without it clients always authenticate with a JWT. Call it before
+// clients connect.
+func (m *Manager) SetResumeStore(store database.CacheStore) {
+	m.resumeStore = store
+	if m.eventBus != nil {
+		m.subscribeToUserLogoutEvents()
+	}
+}
+
+// issueResumeToken gives client a new token for its current identity and
+// subscriptions. It returns "" when resume tokens are off.
+func (m *Manager) issueResumeToken(client *Client) string {
+	if m.resumeStore == nil {
+		return ""
+	}
+
+	token := rand.Text()
+	m.hub.mutex.Lock()
+	client.resumeToken = token
+	client.resumeIssuedAt = m.now()
+	m.hub.mutex.Unlock()
+
+	m.saveResumeState(client)
+	return token
+}
+
+// saveResumeState stores the client's state under its token for another
+// RESUME_TOKEN_TTL. Clients without a token are skipped.
+func (m *Manager) saveResumeState(client *Client) {
+	if m.resumeStore == nil {
+		return
+	}
+	log := m.log.Function("saveResumeState")
+
+	m.hub.mutex.RLock()
+	token := client.resumeToken
+	state := ResumeState{
+		UserID:         client.UserID,
+		ClientID:       client.ID,
+		Subscriptions:  slices.Sorted(maps.Keys(client.subscriptions)),
+		IssuedAt:       client.resumeIssuedAt,
+		SessionID:      client.sessionID,
+		TokenExpiresAt: client.tokenExpiresAt,
+	}
+	m.hub.mutex.RUnlock()
+
+	if token == "" {
+		return
+	}
+	state.ExpiresAt = m.now().Add(RESUME_TOKEN_TTL)
+
+	err := m.resumeStore.Set(context.Background(), fmt.Sprintf(RESUME_TOKEN_KEY, token), state, RESUME_TOKEN_TTL)
+	if err != nil {
+		log.Er("failed to save resume state", err, "clientID", client.ID)
+	}
+}
+
+// takeResumeState redeems token. Each token works once: it is removed from
+// the store as it is read, and the connection it was issued to stops saving
+// it, should that still be open here. A token is refused once the JWT behind
+// it has expired or its session has ended, so resuming can't be chained past
+// either.
+func (m *Manager) takeResumeState(ctx context.Context, token string) (ResumeState, error) {
+	var state ResumeState
+	if m.resumeStore == nil {
+		return state, ErrInvalidResumeToken
+	}
+
+	err := m.resumeStore.Take(ctx, fmt.Sprintf(RESUME_TOKEN_KEY, token), &state)
+	if errors.Is(err, database.ErrCacheMiss) {
+		return state, ErrInvalidResumeToken
+	}
+	if err != nil {
+		return state, err
+	}
+	now := m.now()
+	if !now.Before(state.ExpiresAt) {
+		return state, ErrInvalidResumeToken
+	}
+	if !state.TokenExpiresAt.IsZero() && !now.Before(state.TokenExpiresAt) {
+		return state, ErrInvalidResumeToken
+	}
+
+	if err := m.checkRevoked(ctx, fmt.Sprintf(RESUME_REVOKED_KEY, state.UserID), state.IssuedAt); err != nil {
+		return state, err
+	}
+	if state.SessionID != "" {
+		if err := m.checkRevoked(ctx, fmt.Sprintf(RESUME_SESSION_REVOKED_KEY, state.SessionID), state.IssuedAt); err != nil {
+			return state, err
+		}
+		if err := m.checkSession(ctx, state); err != nil {
+			return state, err
+		}
+	}
+
+	m.hub.mutex.Lock()
+	if previous, ok := m.hub.clients[state.ClientID]; ok {
+		previous.resumeToken = ""
+	}
+	m.hub.mutex.Unlock()
+
+	return state, nil
+}
+
+// checkRevoked refuses a token issued no later than the revocation stored
+// under key.
+func (m *Manager) checkRevoked(ctx context.Context, key string, issuedAt time.Time) error {
+	var revokedAt time.Time
+	err := m.resumeStore.Get(ctx, key, &revokedAt)
+	switch {
+	case err == nil && !issuedAt.After(revokedAt):
+		return ErrInvalidResumeToken
+	case err != nil && !errors.Is(err, database.ErrCacheMiss):
+		return err
+	}
+	return nil
+}
+
+// checkSession refuses a token whose session is gone, has expired or
+// belongs to someone else.
+func (m *Manager) checkSession(ctx context.Context, state ResumeState) error {
+	if m.sessions == nil {
+		return nil
+	}
+
+	session, err := m.sessions.GetByID(ctx, state.SessionID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrInvalidResumeToken
+	}
+	if err != nil {
+		return err
+	}
+	if session.UserID != state.UserID.String() || session.ExpiresAt.Before(m.now()) {
+		return ErrInvalidResumeToken
+	}
+	return nil
+}
+
+// restoreSubscriptions subscribes client to the channels it had before it
+// reconnected, skipping any that are no longer public, and returns the ones
+// it is now subscribed to.
+func (m *Manager) restoreSubscriptions(client *Client, channels []string) []string {
+	restored := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		if m.IsPublicChannel(channel) {
+			m.setSubscription(client, channel, true)
+			restored = append(restored, channel)
+		}
+	}
+	return restored
+}
+
+// RevokeResumeTokens stops every resume token of the user from working, as on
+// logout. Connected clients on this instance drop theirs, and tokens already
+// saved for a reconnect are refused until they would have expired anyway.
+func (m *Manager) RevokeResumeTokens(ctx context.Context, userID uuid.UUID) error {
+	if m.resumeStore == nil {
+		return nil
+	}
+
+	m.hub.mutex.Lock()
+	for _, client := range m.hub.clients {
+		if client.UserID == userID {
+			client.resumeToken = ""
+		}
+	}
+	m.hub.mutex.Unlock()
+
+	return m.resumeStore.Set(ctx, fmt.Sprintf(RESUME_REVOKED_KEY, userID), m.now(), RESUME_TOKEN_TTL)
+}
+
+// RevokeSessionResumeTokens is RevokeResumeTokens for the tokens issued
+// under a single session, as when it is revoked from another device.
+func (m *Manager) RevokeSessionResumeTokens(ctx context.Context, sessionID string) error {
+	if m.resumeStore == nil {
+		return nil
+	}
+
+	m.hub.mutex.Lock()
+	for _, client := range m.hub.clients {
+		if client.sessionID == sessionID {
+			client.resumeToken = ""
+		}
+	}
+	m.hub.mutex.Unlock()
+
+	return m.resumeStore.Set(ctx, fmt.Sprintf(RESUME_SESSION_REVOKED_KEY, sessionID), m.now(), RESUME_TOKEN_TTL)
+}
+
+// moveSession carries the clients of a refreshed session over to the one
+// that replaced it, so their resume tokens stay good until the new JWT
+// expires.
+func (m *Manager) moveSession(previousID string, sessionID string, tokenExpiresAt time.Time) {
+	if previousID == "" {
+		return
+	}
+
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+	for _, client := range m.hub.clients {
+		if client.sessionID == previousID {
+			client.sessionID = sessionID
+			client.tokenExpiresAt = tokenExpiresAt
+		}
+	}
+}
+
+func (m *Manager) subscribeToUserLogoutEvents() {
+	log := m.log.Function("subscribeToUserLogoutEvents")
+
+	topic := m.eventBus.UserLogoutTopic()
+	err := topic.Subscribe(func(ctx context.Context, event events.UserLogoutEvent) error {
+		if event.SessionID != "" {
+			return m.RevokeSessionResumeTokens(ctx, event.SessionID)
+		}
+		userID, err := uuid.Parse(event.UserID)
+		if err != nil {
+			return err
+		}
+		return m.RevokeResumeTokens(ctx, userID)
+	})
+	if err != nil {
+		log.Er("Failed to subscribe to user logout events", err)
+	}
+}
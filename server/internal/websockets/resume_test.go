@@ -0,0 +1,450 @@
+package websockets
+
+import (
+	"context"
+	"fmt"
+	"server/config"
+	"server/internal/clock"
+	"server/internal/database"
+	"server/internal/events"
+	"server/internal/logger"
+	"server/internal/models"
+	"server/internal/repositories"
+	"server/internal/utils/testsupport"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newResumeManager(t *testing.T, eventBus *events.EventBus) (*Manager, database.CacheStore, *clock.Fake) {
+	t.Helper()
+	fake := testsupport.FreezeTime(t)
+	store := database.NewMemoryCacheStore()
+	manager := &Manager{
+		hub:      &Hub{clients: make(map[string]*Client)},
+		log:      logger.New("test"),
+		clock:    fake,
+		eventBus: eventBus,
+		config: testsupport.WithKey(config.Config{
+			Websocket: config.WebsocketConfig{PublicChannels: "dashboard, status"},
+		}),
+	}
+	manager.SetResumeStore(store)
+	return manager, store, fake
+}
+
+// connectResumeClient registers a new unauthenticated client, the way
+// HandleWebSocket does.
+func connectResumeClient(manager *Manager, id string) *Client {
+	client := &Client{
+		ID:      id,
+		Status:  StatusUnauthenticated,
+		Manager: manager,
+		send:    make(chan Message, 10),
+	}
+	manager.hub.clients[id] = client
+	return client
+}
+
+// disconnectResumeClient drops client, the way HandleWebSocket does when the
+// connection closes.
+func disconnectResumeClient(manager *Manager, client *Client) {
+	manager.saveResumeState(client)
+	manager.unregisterClient(client)
+}
+
+// authenticateWithJWT signs userID in on client and returns its resume token.
+func authenticateWithJWT(t *testing.T, manager *Manager, client *Client, userID uuid.UUID) string {
+	t.Helper()
+	return authenticateInSession(t, manager, client, userID, "")
+}
+
+// authenticateInSession is authenticateWithJWT with a token issued for
+// sessionID.
+func authenticateInSession(t *testing.T, manager *Manager, client *Client, userID uuid.UUID, sessionID string) string {
+	t.Helper()
+	token := testsupport.MintTestToken(t, userID, testsupport.TokenOptions{Clock: manager.clock, SessionID: sessionID})
+
+	client.routeMessage(Message{Type: MessageTypeAuthResponse, Data: map[string]any{"token": token}})
+
+	success := receive(t, client)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+	resumeToken, _ := success.Data["resumeToken"].(string)
+	require.NotEmpty(t, resumeToken)
+	return resumeToken
+}
+
+// fakeSessions is a SessionLookup over the sessions it holds.
+type fakeSessions struct {
+	mutex    sync.Mutex
+	sessions map[string]*models.Session
+}
+
+func (f *fakeSessions) GetByID(ctx context.Context, id string) (*models.Session, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	session, ok := f.sessions[id]
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+	return session, nil
+}
+
+func (f *fakeSessions) end(id string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.sessions, id)
+}
+
+func resume(client *Client, resumeToken string) {
+	client.routeMessage(Message{Type: MessageTypeAuthResponse, Data: map[string]any{"resumeToken": resumeToken}})
+}
+
+func assertResumeRefused(t *testing.T, client *Client) {
+	t.Helper()
+	failure := receive(t, client)
+	assert.Equal(t, MessageTypeAuthFailure, failure.Type)
+	assert.Equal(t, "Invalid resume token", failure.Data["reason"])
+	assert.Equal(t, StatusUnauthenticated, client.Status)
+}
+
+func TestHandleAuthResponse_ResumeRestoresSubscriptions(t *testing.T) {
+	manager, _, fakeClock := newResumeManager(t, nil)
+	userID := uuid.New()
+
+	first := connectResumeClient(manager, "first")
+	resumeToken := authenticateWithJWT(t, manager, first, userID)
+	for _, channel := range []string{"status", "dashboard"} {
+		first.routeMessage(Message{Type: MessageTypeSubscribe, Channel: channel})
+		receive(t, first)
+	}
+	fakeClock.Advance(30 * time.Minute)
+	disconnectResumeClient(manager, first)
+
+	fakeClock.Advance(RESUME_TOKEN_TTL - time.Second)
+	second := connectResumeClient(manager, "second")
+	resume(second, resumeToken)
+
+	success := receive(t, second)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+	assert.Equal(t, userID.String(), success.Data["userId"])
+	assert.Equal(t, true, success.Data["resumed"])
+	assert.Equal(t, []string{"dashboard", "status"}, success.Data["subscriptions"])
+	assert.NotEmpty(t, success.Data["resumeToken"])
+	assert.NotEqual(t, resumeToken, success.Data["resumeToken"])
+
+	assert.Equal(t, StatusAuthenticated, second.Status)
+	assert.Equal(t, userID, second.UserID)
+	assert.Equal(t, map[string]bool{"dashboard": true, "status": true}, second.subscriptions)
+}
+
+func TestHandleAuthResponse_TokenExpiresAt(t *testing.T) {
+	manager, _, _ := newResumeManager(t, nil)
+	expiresAt := manager.clock.Now().Add(2 * time.Hour)
+	token := testsupport.MintTestToken(t, uuid.New(), testsupport.TokenOptions{Clock: manager.clock, ExpiresAt: expiresAt})
+
+	first := connectResumeClient(manager, "first")
+	first.routeMessage(Message{Type: MessageTypeAuthResponse, Data: map[string]any{"token": token}})
+	success := receive(t, first)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+	assert.Equal(t, models.NewAPITime(expiresAt), success.Data["tokenExpiresAt"])
+	disconnectResumeClient(manager, first)
+
+	second := connectResumeClient(manager, "second")
+	resume(second, success.Data["resumeToken"].(string))
+	success = receive(t, second)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+	assert.Equal(t, models.NewAPITime(expiresAt), success.Data["tokenExpiresAt"], "a resume keeps the expiry of the token it stands for")
+}
+
+func TestHandleAuthResponse_ResumeSkipsChannelsNoLongerPublic(t *testing.T) {
+	manager, _, _ := newResumeManager(t, nil)
+
+	first := connectResumeClient(manager, "first")
+	resumeToken := authenticateWithJWT(t, manager, first, uuid.New())
+	first.routeMessage(Message{Type: MessageTypeSubscribe, Channel: "status"})
+	receive(t, first)
+	disconnectResumeClient(manager, first)
+
+	manager.config.Websocket.PublicChannels = "dashboard"
+	second := connectResumeClient(manager, "second")
+	resume(second, resumeToken)
+
+	success := receive(t, second)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+	assert.Equal(t, []string{}, success.Data["subscriptions"])
+	assert.Empty(t, second.subscriptions)
+}
+
+func TestHandleAuthResponse_ResumeTokenExpires(t *testing.T) {
+	manager, _, fakeClock := newResumeManager(t, nil)
+
+	first := connectResumeClient(manager, "first")
+	resumeToken := authenticateWithJWT(t, manager, first, uuid.New())
+	disconnectResumeClient(manager, first)
+
+	fakeClock.Advance(RESUME_TOKEN_TTL)
+	second := connectResumeClient(manager, "second")
+	resume(second, resumeToken)
+
+	assertResumeRefused(t, second)
+}
+
+func TestHandleAuthResponse_ResumeStopsAtTokenExpiry(t *testing.T) {
+	manager, _, fakeClock := newResumeManager(t, nil)
+
+	first := connectResumeClient(manager, "first")
+	resumeToken := authenticateWithJWT(t, manager, first, uuid.New())
+
+	// Each resume hands out a new token, but none of them outlive the JWT
+	fakeClock.Advance(testsupport.TOKEN_TTL - 2*RESUME_TOKEN_TTL)
+	disconnectResumeClient(manager, first)
+	second := connectResumeClient(manager, "second")
+	resume(second, resumeToken)
+	success := receive(t, second)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+
+	fakeClock.Advance(RESUME_TOKEN_TTL)
+	disconnectResumeClient(manager, second)
+	fakeClock.Advance(RESUME_TOKEN_TTL - time.Second)
+	third := connectResumeClient(manager, "third")
+	resume(third, success.Data["resumeToken"].(string))
+	require.Equal(t, MessageTypeAuthSuccess, receive(t, third).Type)
+
+	fakeClock.Advance(time.Second)
+	disconnectResumeClient(manager, third)
+	fourth := connectResumeClient(manager, "fourth")
+	resume(fourth, third.resumeToken)
+	assertResumeRefused(t, fourth)
+}
+
+func TestHandleAuthResponse_ResumeRefusedOnceSessionEnded(t *testing.T) {
+	manager, _, fakeClock := newResumeManager(t, nil)
+	userID := uuid.New()
+	sessions := &fakeSessions{sessions: map[string]*models.Session{
+		"session-1": {ID: "session-1", UserID: userID.String(), ExpiresAt: fakeClock.Now().Add(time.Hour)},
+		"theirs":    {ID: "theirs", UserID: uuid.NewString(), ExpiresAt: fakeClock.Now().Add(time.Hour)},
+		"expiring":  {ID: "expiring", UserID: userID.String(), ExpiresAt: fakeClock.Now().Add(time.Second)},
+	}}
+	manager.SetSessionLookup(sessions)
+
+	tokens := map[string]string{}
+	for _, sessionID := range []string{"session-1", "theirs", "expiring", "missing"} {
+		client := connectResumeClient(manager, sessionID)
+		tokens[sessionID] = authenticateInSession(t, manager, client, userID, sessionID)
+		disconnectResumeClient(manager, client)
+	}
+	fakeClock.Advance(2 * time.Second)
+
+	for _, sessionID := range []string{"theirs", "expiring", "missing"} {
+		t.Run(sessionID, func(t *testing.T) {
+			client := connectResumeClient(manager, "after-"+sessionID)
+			resume(client, tokens[sessionID])
+			assertResumeRefused(t, client)
+		})
+	}
+
+	client := connectResumeClient(manager, "live")
+	resume(client, tokens["session-1"])
+	success := receive(t, client)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+
+	sessions.end("session-1")
+	disconnectResumeClient(manager, client)
+	client = connectResumeClient(manager, "ended")
+	resume(client, success.Data["resumeToken"].(string))
+	assertResumeRefused(t, client)
+}
+
+func TestSessionRefresh_CarriesResumeTokensOver(t *testing.T) {
+	eventBus := events.New(nil, config.Config{}, nil)
+	manager, _, fakeClock := newResumeManager(t, eventBus)
+	manager.subscribeToSessionRefreshedEvents()
+	userID := uuid.New()
+	expiresAt := fakeClock.Now().Add(7 * 24 * time.Hour)
+	sessions := &fakeSessions{sessions: map[string]*models.Session{
+		"session-2": {ID: "session-2", UserID: userID.String(), ExpiresAt: expiresAt},
+	}}
+	manager.SetSessionLookup(sessions)
+
+	client := connectResumeClient(manager, "client")
+	resumeToken := authenticateInSession(t, manager, client, userID, "session-1")
+
+	require.NoError(t, eventBus.SessionRefreshedTopic().Publish(context.Background(), events.SessionRefreshedEvent{
+		UserID:            userID.String(),
+		SessionID:         "session-2",
+		PreviousSessionID: "session-1",
+		Token:             "refreshed-token",
+		ExpiresAt:         expiresAt,
+	}))
+	require.Eventually(t, func() bool {
+		manager.hub.mutex.RLock()
+		defer manager.hub.mutex.RUnlock()
+		return client.sessionID == "session-2"
+	}, time.Second, 10*time.Millisecond)
+	receive(t, client)
+
+	// Past the first JWT, which the refreshed one replaced
+	fakeClock.Advance(testsupport.TOKEN_TTL)
+	disconnectResumeClient(manager, client)
+	resumed := connectResumeClient(manager, "resumed")
+	resume(resumed, resumeToken)
+	success := receive(t, resumed)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+	assert.Equal(t, models.NewAPITime(expiresAt), success.Data["tokenExpiresAt"])
+}
+
+func TestHandleAuthResponse_ResumeTokenIsSingleUse(t *testing.T) {
+	manager, _, _ := newResumeManager(t, nil)
+
+	first := connectResumeClient(manager, "first")
+	resumeToken := authenticateWithJWT(t, manager, first, uuid.New())
+	disconnectResumeClient(manager, first)
+
+	second := connectResumeClient(manager, "second")
+	resume(second, resumeToken)
+	require.Equal(t, MessageTypeAuthSuccess, receive(t, second).Type)
+
+	third := connectResumeClient(manager, "third")
+	resume(third, resumeToken)
+	assertResumeRefused(t, third)
+}
+
+func TestHandleAuthResponse_UsedTokenNotSavedAgainByOldConnection(t *testing.T) {
+	manager, _, _ := newResumeManager(t, nil)
+
+	// The old connection is still open here when the client resumes
+	first := connectResumeClient(manager, "first")
+	resumeToken := authenticateWithJWT(t, manager, first, uuid.New())
+
+	second := connectResumeClient(manager, "second")
+	resume(second, resumeToken)
+	require.Equal(t, MessageTypeAuthSuccess, receive(t, second).Type)
+
+	disconnectResumeClient(manager, first)
+	third := connectResumeClient(manager, "third")
+	resume(third, resumeToken)
+	assertResumeRefused(t, third)
+}
+
+func TestHandleAuthResponse_ResumeFallsBackToJWT(t *testing.T) {
+	manager, _, _ := newResumeManager(t, nil)
+	userID := uuid.New()
+	token := testsupport.MintTestToken(t, userID, testsupport.TokenOptions{Clock: manager.clock})
+
+	client := connectResumeClient(manager, "client")
+	client.routeMessage(Message{
+		Type: MessageTypeAuthResponse,
+		Data: map[string]any{"resumeToken": "unknown", "token": token},
+	})
+
+	success := receive(t, client)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+	assert.Equal(t, userID, client.UserID)
+	assert.NotContains(t, success.Data, "resumed")
+}
+
+func TestHandleAuthResponse_WithoutResumeStore(t *testing.T) {
+	client, token := setupProtocolTest(t)
+
+	client.routeMessage(Message{Type: MessageTypeAuthResponse, Data: map[string]any{"token": token}})
+	success := receiveMessage(t, client)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+	assert.NotContains(t, success.Data, "resumeToken")
+
+	other, _ := setupProtocolTest(t)
+	resume(other, "anything")
+	assertResumeRefused(t, other)
+}
+
+func TestRevokeResumeTokens_OnLogout(t *testing.T) {
+	eventBus := events.New(nil, config.Config{}, nil)
+	manager, store, fakeClock := newResumeManager(t, eventBus)
+	userID, otherUserID := uuid.New(), uuid.New()
+
+	// One token saved for a reconnect, one on a connection still open, both
+	// under the session that logs out
+	dropped := connectResumeClient(manager, "dropped")
+	droppedToken := authenticateInSession(t, manager, dropped, userID, "session-1")
+	disconnectResumeClient(manager, dropped)
+	open := connectResumeClient(manager, "open")
+	openToken := authenticateInSession(t, manager, open, userID, "session-1")
+	elsewhere := connectResumeClient(manager, "elsewhere")
+	elsewhereToken := authenticateInSession(t, manager, elsewhere, userID, "session-2")
+	other := connectResumeClient(manager, "other")
+	otherToken := authenticateInSession(t, manager, other, otherUserID, "session-3")
+
+	fakeClock.Advance(time.Second)
+	require.NoError(t, eventBus.UserLogoutTopic().Publish(context.Background(), events.UserLogoutEvent{
+		UserID:    userID.String(),
+		SessionID: "session-1",
+	}))
+	require.Eventually(t, func() bool {
+		var revokedAt time.Time
+		return store.Get(context.Background(), fmt.Sprintf(RESUME_SESSION_REVOKED_KEY, "session-1"), &revokedAt) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	disconnectResumeClient(manager, open)
+	disconnectResumeClient(manager, elsewhere)
+	disconnectResumeClient(manager, other)
+	for _, resumeToken := range []string{droppedToken, openToken} {
+		client := connectResumeClient(manager, "after-"+resumeToken)
+		resume(client, resumeToken)
+		assertResumeRefused(t, client)
+	}
+
+	for name, resumeToken := range map[string]string{"other sessions": elsewhereToken, "other users": otherToken} {
+		client := connectResumeClient(manager, "again-"+resumeToken)
+		resume(client, resumeToken)
+		assert.Equal(t, MessageTypeAuthSuccess, receive(t, client).Type, name+" keep their tokens")
+	}
+}
+
+func TestRevokeResumeTokens_AllSessions(t *testing.T) {
+	eventBus := events.New(nil, config.Config{}, nil)
+	manager, store, fakeClock := newResumeManager(t, eventBus)
+	userID, otherUserID := uuid.New(), uuid.New()
+
+	dropped := connectResumeClient(manager, "dropped")
+	droppedToken := authenticateInSession(t, manager, dropped, userID, "session-1")
+	disconnectResumeClient(manager, dropped)
+	open := connectResumeClient(manager, "open")
+	openToken := authenticateInSession(t, manager, open, userID, "session-2")
+	other := connectResumeClient(manager, "other")
+	otherToken := authenticateWithJWT(t, manager, other, otherUserID)
+
+	// As when the account is deleted or its password changes
+	fakeClock.Advance(time.Second)
+	require.NoError(t, eventBus.UserLogoutTopic().Publish(context.Background(), events.UserLogoutEvent{
+		UserID: userID.String(),
+	}))
+	require.Eventually(t, func() bool {
+		var revokedAt time.Time
+		return store.Get(context.Background(), fmt.Sprintf(RESUME_REVOKED_KEY, userID), &revokedAt) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	disconnectResumeClient(manager, open)
+	disconnectResumeClient(manager, other)
+	for _, resumeToken := range []string{droppedToken, openToken} {
+		client := connectResumeClient(manager, "after-"+resumeToken)
+		resume(client, resumeToken)
+		assertResumeRefused(t, client)
+	}
+
+	client := connectResumeClient(manager, "other-again")
+	resume(client, otherToken)
+	assert.Equal(t, MessageTypeAuthSuccess, receive(t, client).Type, "other users keep their tokens")
+
+	// Logging in again after the logout gets a working token
+	fakeClock.Advance(time.Second)
+	again := connectResumeClient(manager, "again")
+	againToken := authenticateWithJWT(t, manager, again, userID)
+	disconnectResumeClient(manager, again)
+	client = connectResumeClient(manager, "resumed")
+	resume(client, againToken)
+	assert.Equal(t, MessageTypeAuthSuccess, receive(t, client).Type)
+}
@@ -0,0 +1,181 @@
+package websockets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"server/internal/validate"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AuthResponseData is what a client sends to authenticate: a JWT, or the
+// resume token of a connection it lost. At least one is required. DeviceID
+// optionally tags the connection, so a second one from the same device is
+// handled by the duplicate connections policy.
+type AuthResponseData struct {
+	Token       string `json:"token"`
+	ResumeToken string `json:"resumeToken"`
+	DeviceID    string `json:"deviceId"`
+}
+
+// SubscriptionData is empty: the channel is in the envelope, and anything in
+// Data is rejected.
+type SubscriptionData struct{}
+
+type messageSchema struct {
+	dataType reflect.Type
+	fields   []schemaField
+}
+
+// schemaField is a field of a schema with a validate tag. Only string fields
+// take rules; any other field can only be required, meaning non-zero.
+type schemaField struct {
+	index    int
+	name     string
+	required bool
+	rules    []validate.Rule
+}
+
+var schemas struct {
+	mutex  sync.RWMutex
+	byType map[string]messageSchema
+}
+
+// Inbound messages with a registered schema have their Data decoded before
+// they are routed. Anything else keeps the raw map.
+func init() {
+	RegisterMessageSchema(MessageTypeAuthResponse, AuthResponseData{})
+	RegisterMessageSchema(MessageTypeSubscribe, SubscriptionData{})
+	RegisterMessageSchema(MessageTypeUnsubscribe, SubscriptionData{})
+	RegisterActionSchema("system", ActionDiagnostics, DiagnosticsData{})
+}
+
+// actionSchemaKey is where the schema of a MessageTypeMessage with channel
+// and action is registered, apart from the message types.
+func actionSchemaKey(channel string, action string) string {
+	return MessageTypeMessage + ":" + channel + ":" + action
+}
+
+// RegisterMessageSchema declares the struct Data is decoded into for inbound
+// messages of messageType. Fields are checked against the rules in their
+// validate tag (see validate.ParseTag), in field order. It panics on anything
+// but a struct, a tag that doesn't parse, rules on a field JSON can't set or
+// that isn't a string, or a type registered twice, since each is a
+// programming error.
+func RegisterMessageSchema(messageType string, schema any) {
+	registerSchema(messageType, schema)
+}
+
+// RegisterActionSchema is RegisterMessageSchema for the MessageTypeMessage
+// messages with channel and action. Other messages of the type keep their
+// raw map.
+func RegisterActionSchema(channel string, action string, schema any) {
+	registerSchema(actionSchemaKey(channel, action), schema)
+}
+
+func registerSchema(key string, schema any) {
+	dataType := reflect.TypeOf(schema)
+	if dataType == nil || dataType.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("websockets: RegisterMessageSchema needs a struct, got %T", schema))
+	}
+
+	var fields []schemaField
+	for i := range dataType.NumField() {
+		field := dataType.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			panic(fmt.Sprintf("websockets: validated field %s of %T can't be decoded", field.Name, schema))
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		rules, err := validate.ParseTag(tag)
+		if err != nil {
+			panic(fmt.Sprintf("websockets: field %s of %T: %v", field.Name, schema, err))
+		}
+		checked := schemaField{index: i, name: "data." + name, rules: rules}
+		if field.Type.Kind() != reflect.String {
+			if tag != "required" {
+				panic(fmt.Sprintf("websockets: field %s of %T can only be required", field.Name, schema))
+			}
+			checked.required, checked.rules = true, nil
+		}
+		fields = append(fields, checked)
+	}
+
+	schemas.mutex.Lock()
+	defer schemas.mutex.Unlock()
+
+	if schemas.byType == nil {
+		schemas.byType = make(map[string]messageSchema)
+	}
+	if _, ok := schemas.byType[key]; ok {
+		panic(fmt.Sprintf("websockets: schema for %q registered twice", key))
+	}
+	schemas.byType[key] = messageSchema{dataType: dataType, fields: fields}
+}
+
+// DecodeMessageData decodes Data into the struct registered for the
+// message's type, or its channel and action, rejecting unknown fields and
+// ones that break their rules. Messages without a schema get their raw Data
+// map back.
+func DecodeMessageData(message Message) (any, *validate.FieldError) {
+	key := message.Type
+	if message.Type == MessageTypeMessage {
+		key = actionSchemaKey(message.Channel, message.Action)
+	}
+	schemas.mutex.RLock()
+	schema, ok := schemas.byType[key]
+	schemas.mutex.RUnlock()
+	if !ok {
+		return message.Data, nil
+	}
+
+	value := reflect.New(schema.dataType)
+	if len(message.Data) > 0 {
+		// Data came off the wire as JSON, so it always encodes again
+		encoded, _ := json.Marshal(message.Data)
+		decoder := json.NewDecoder(bytes.NewReader(encoded))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(value.Interface()); err != nil {
+			return nil, dataFieldError(err)
+		}
+	}
+
+	for _, field := range schema.fields {
+		fieldValue := value.Elem().Field(field.index)
+		if field.required && fieldValue.IsZero() {
+			return nil, validate.Fail(field.name, validate.CODE_REQUIRED, "is required")
+		}
+		if fieldErr := validate.Check(field.name, fieldValue.String(), field.rules...); fieldErr != nil {
+			return nil, fieldErr
+		}
+	}
+	return value.Elem().Interface(), nil
+}
+
+// dataFieldError names the field of Data a decode error is about.
+func dataFieldError(err error) *validate.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return validate.Fail("data."+typeErr.Field, validate.CODE_INVALID, "must be "+typeErr.Type.String())
+	}
+
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if unquoted, unquoteErr := strconv.Unquote(name); unquoteErr == nil {
+			name = unquoted
+		}
+		return validate.Fail("data."+name, validate.CODE_NOT_ALLOWED, "is not allowed")
+	}
+
+	return validate.Fail("data", validate.CODE_INVALID, "is malformed")
+}
@@ -0,0 +1,144 @@
+package websockets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSchemaData struct {
+	Name  string   `json:"name" validate:"required,max=10"`
+	Owner string   `json:"owner" validate:"uuid"`
+	Count int      `json:"count"`
+	Tags  []string `json:"tags"`
+}
+
+// registerTestSchema registers schema for messageType until the test ends.
+func registerTestSchema(t *testing.T, messageType string, schema any) {
+	t.Helper()
+
+	RegisterMessageSchema(messageType, schema)
+	t.Cleanup(func() {
+		schemas.mutex.Lock()
+		defer schemas.mutex.Unlock()
+		delete(schemas.byType, messageType)
+	})
+}
+
+func TestDecodeMessageData(t *testing.T) {
+	registerTestSchema(t, "test_schema", testSchemaData{})
+
+	testCases := []struct {
+		name     string
+		data     map[string]any
+		expected any
+		field    string
+		rule     string
+		reason   string
+	}{
+		{
+			name:     "decodes registered type",
+			data:     map[string]any{"name": "widget", "count": float64(3), "tags": []any{"a", "b"}},
+			expected: testSchemaData{Name: "widget", Count: 3, Tags: []string{"a", "b"}},
+		},
+		{
+			name:     "optional fields may be left out",
+			data:     map[string]any{"name": "widget"},
+			expected: testSchemaData{Name: "widget"},
+		},
+		{name: "missing required field", data: map[string]any{"count": float64(3)}, field: "data.name", rule: "required", reason: "is required"},
+		{name: "empty required field", data: map[string]any{"name": ""}, field: "data.name", rule: "required", reason: "is required"},
+		{name: "no data", data: nil, field: "data.name", rule: "required", reason: "is required"},
+		{name: "too long", data: map[string]any{"name": "a very long widget"}, field: "data.name", rule: "too_long", reason: "must be at most 10 characters"},
+		{name: "not a uuid", data: map[string]any{"name": "widget", "owner": "admin"}, field: "data.owner", rule: "invalid_uuid", reason: "must be a UUID"},
+		{name: "unknown field", data: map[string]any{"name": "widget", "admin": true}, field: "data.admin", rule: "not_allowed", reason: "is not allowed"},
+		{name: "wrong type", data: map[string]any{"name": "widget", "count": "three"}, field: "data.count", rule: "invalid", reason: "must be int"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, fieldErr := DecodeMessageData(Message{Type: "test_schema", Data: tc.data})
+
+			if tc.field == "" {
+				require.Nil(t, fieldErr)
+				assert.Equal(t, tc.expected, data)
+				return
+			}
+			require.NotNil(t, fieldErr)
+			assert.Equal(t, tc.field, fieldErr.Field)
+			assert.Equal(t, tc.rule, fieldErr.Code)
+			assert.Equal(t, tc.reason, fieldErr.Reason)
+			assert.Nil(t, data)
+		})
+	}
+}
+
+func TestDecodeMessageData_UnregisteredKeepsRawMap(t *testing.T) {
+	raw := map[string]any{"anything": "goes", "nested": map[string]any{"n": float64(1)}}
+
+	data, fieldErr := DecodeMessageData(Message{Type: MessageTypeMessage, Data: raw})
+
+	require.Nil(t, fieldErr)
+	assert.Equal(t, raw, data)
+}
+
+func TestDecodeMessageData_BuiltInSchemas(t *testing.T) {
+	data, fieldErr := DecodeMessageData(Message{Type: MessageTypeAuthResponse, Data: map[string]any{"token": "jwt"}})
+	require.Nil(t, fieldErr)
+	assert.Equal(t, AuthResponseData{Token: "jwt"}, data)
+
+	data, fieldErr = DecodeMessageData(Message{Type: MessageTypeSubscribe})
+	require.Nil(t, fieldErr)
+	assert.Equal(t, SubscriptionData{}, data)
+
+	_, fieldErr = DecodeMessageData(Message{Type: MessageTypeUnsubscribe, Data: map[string]any{"channel": "dashboard"}})
+	require.NotNil(t, fieldErr)
+	assert.Equal(t, "data.channel", fieldErr.Field)
+}
+
+func TestRegisterMessageSchema_RejectsInvalidSchemas(t *testing.T) {
+	assert.Panics(t, func() { RegisterMessageSchema("test_pointer", &testSchemaData{}) }, "not a struct")
+	assert.Panics(t, func() { RegisterMessageSchema("test_map", map[string]any{}) }, "not a struct")
+	assert.Panics(t, func() {
+		RegisterMessageSchema("test_unexported", struct {
+			name string `validate:"required"`
+		}{})
+	}, "required field is unexported")
+	assert.Panics(t, func() {
+		RegisterMessageSchema("test_skipped", struct {
+			Name string `json:"-" validate:"required"`
+		}{})
+	}, "required field is never decoded")
+	assert.Panics(t, func() {
+		RegisterMessageSchema("test_unknown_rule", struct {
+			Name string `json:"name" validate:"unique"`
+		}{})
+	}, "unknown rule")
+	assert.Panics(t, func() {
+		RegisterMessageSchema("test_rule_on_int", struct {
+			Count int `json:"count" validate:"max=3"`
+		}{})
+	}, "length rule on a number")
+	assert.Panics(t, func() { RegisterMessageSchema(MessageTypeAuthResponse, AuthResponseData{}) }, "already registered")
+
+	schemas.mutex.RLock()
+	defer schemas.mutex.RUnlock()
+	for _, messageType := range []string{"test_pointer", "test_map", "test_unexported", "test_skipped", "test_unknown_rule", "test_rule_on_int"} {
+		assert.NotContains(t, schemas.byType, messageType)
+	}
+}
+
+func TestRouteMessage_SubscriptionWithDataRejected(t *testing.T) {
+	guest := &Client{ID: "guest", Status: StatusUnauthenticated, send: make(chan Message, 10)}
+	newPublicChannelManager(guest)
+
+	guest.routeMessage(Message{Type: MessageTypeSubscribe, Channel: "dashboard", Data: map[string]any{"since": "yesterday"}})
+
+	reply := receive(t, guest)
+	assert.Equal(t, MessageTypeError, reply.Type)
+	assert.Equal(t, ErrorCodeInvalidField, reply.Data["code"])
+	assert.Equal(t, "data.since", reply.Data["field"])
+	assert.Empty(t, guest.subscriptions)
+	assert.Equal(t, 1, guest.strikes)
+}
@@ -0,0 +1,255 @@
+package websockets
+
+import (
+	"server/internal/validate"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Messages kept per user for resync
+	STREAM_BUFFER_SIZE = 256
+	// How long a user's stream outlives their last connection, so a client
+	// reconnecting within it carries on from the same sequence
+	STREAM_TTL = RESUME_TOKEN_TTL
+
+	ActionResync            = "resync"
+	ActionResyncComplete    = "resync_complete"
+	ActionResyncUnavailable = "resync_unavailable"
+)
+
+// userStream is one user's recent messages, oldest first, with the sequence
+// of the newest.
+type userStream struct {
+	sequence    uint64
+	buffer      []Message
+	connections int
+	idleSince   time.Time
+}
+
+// userStreams numbers the messages sent to each user. Every message fanned
+// out to a user's connections takes the user's next sequence, the same on
+// all of them, so a client that sees a jump knows it missed some, such as
+// when its send channel was full. The last STREAM_BUFFER_SIZE are kept for
+// a resync. Streams live in this instance's memory, for STREAM_TTL after
+// the user's last connection closes; after that the sequence starts over.
+type userStreams struct {
+	mutex  sync.Mutex
+	byUser map[uuid.UUID]*userStream
+}
+
+func newUserStreams() *userStreams {
+	return &userStreams{byUser: make(map[uuid.UUID]*userStream)}
+}
+
+// stamp gives message the user's next sequence and buffers it.
+func (s *userStreams) stamp(userID uuid.UUID, message Message) Message {
+	if s == nil || userID == uuid.Nil {
+		return message
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stream := s.stream(userID)
+	stream.sequence++
+	message.UserSequence = stream.sequence
+	if len(stream.buffer) == STREAM_BUFFER_SIZE {
+		stream.buffer = append(stream.buffer[:0], stream.buffer[1:]...)
+	}
+	stream.buffer = append(stream.buffer, message)
+	return message
+}
+
+func (s *userStreams) stream(userID uuid.UUID) *userStream {
+	stream, ok := s.byUser[userID]
+	if !ok {
+		stream = &userStream{}
+		s.byUser[userID] = stream
+	}
+	return stream
+}
+
+// latest is the sequence of the last message sent to the user, 0 when
+// there has been none.
+func (s *userStreams) latest(userID uuid.UUID) uint64 {
+	if s == nil {
+		return 0
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if stream, ok := s.byUser[userID]; ok {
+		return stream.sequence
+	}
+	return 0
+}
+
+// resyncWindow is what a resync can replay: the buffered messages from
+// sequence from on, the earliest sequence still buffered and the latest.
+type resyncWindow struct {
+	messages []Message
+	earliest uint64
+	latest   uint64
+}
+
+// since returns the window from sequence from on. When some of those
+// messages have already left the buffer it returns false, and the window
+// holds no messages.
+func (s *userStreams) since(userID uuid.UUID, from uint64) (resyncWindow, bool) {
+	window := resyncWindow{earliest: 1}
+	if s == nil {
+		return window, true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stream, ok := s.byUser[userID]
+	if !ok {
+		return window, true
+	}
+	window.latest = stream.sequence
+	window.earliest = stream.sequence + 1
+	if len(stream.buffer) > 0 {
+		window.earliest = stream.buffer[0].UserSequence
+	}
+	if from < window.earliest && from <= stream.sequence {
+		return window, false
+	}
+
+	for _, message := range stream.buffer {
+		if message.UserSequence >= from {
+			window.messages = append(window.messages, message)
+		}
+	}
+	return window, true
+}
+
+// connected and disconnected track the user's open connections, so streams
+// are only dropped once STREAM_TTL has passed with none. Streams past it are
+// dropped on either call.
+func (s *userStreams) connected(userID uuid.UUID, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune(now)
+	s.stream(userID).connections++
+}
+
+func (s *userStreams) disconnected(userID uuid.UUID, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stream, ok := s.byUser[userID]; ok {
+		stream.connections = max(stream.connections-1, 0)
+		if stream.connections == 0 {
+			stream.idleSince = now
+		}
+	}
+	s.prune(now)
+}
+
+func (s *userStreams) prune(now time.Time) {
+	for userID, stream := range s.byUser {
+		if stream.connections == 0 && now.Sub(stream.idleSince) >= STREAM_TTL {
+			delete(s.byUser, userID)
+		}
+	}
+}
+
+// streamStamps stamps one message for each user it goes to, so every
+// connection of a user gets it at the same sequence. Fan outs build one and
+// call For with each recipient.
+type streamStamps struct {
+	streams *userStreams
+	message Message
+	byUser  map[uuid.UUID]Message
+}
+
+func (m *Manager) stampsFor(message Message) *streamStamps {
+	return &streamStamps{streams: m.streams, message: message, byUser: make(map[uuid.UUID]Message)}
+}
+
+// For is the message as sent to client, stamped with its user's sequence.
+// Guests aren't in any stream and get it as it is.
+func (s *streamStamps) For(client *Client) Message {
+	if client.Status != StatusAuthenticated {
+		return s.message
+	}
+	if stamped, ok := s.byUser[client.UserID]; ok {
+		return stamped
+	}
+	stamped := s.streams.stamp(client.UserID, s.message)
+	s.byUser[client.UserID] = stamped
+	return stamped
+}
+
+// joinStream counts client's connection towards its user's stream, once it
+// has authenticated.
+func (m *Manager) joinStream(client *Client) {
+	if m.streams == nil {
+		return
+	}
+
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+	if !client.inStream {
+		client.inStream = true
+		m.streams.connected(client.UserID, m.now())
+	}
+}
+
+// leaveStream undoes joinStream. Callers hold the hub lock.
+func (m *Manager) leaveStream(client *Client) {
+	if m.streams == nil || !client.inStream {
+		return
+	}
+	client.inStream = false
+	m.streams.disconnected(client.UserID, m.now())
+}
+
+// handleResync replays the user's messages from data.from on to this
+// connection, then answers resync_complete with the latest sequence.
+// Messages sent meanwhile may arrive among the replayed ones, so clients
+// drop sequences they already have. When the buffer no longer reaches back
+// to data.from it answers resync_unavailable with the earliest sequence it
+// holds instead, and the client should refetch its state over HTTP.
+func (c *Client) handleResync(message Message) {
+	log := c.Manager.log.Function("handleResync")
+
+	value, ok := message.Data["from"].(float64)
+	if !ok || value < 0 || value != float64(uint64(value)) {
+		c.strike(validate.Fail("data.from", validate.CODE_INVALID, "must be a sequence number"))
+		return
+	}
+	from := uint64(value)
+
+	window, ok := c.Manager.streams.since(c.UserID, from)
+	action := ActionResyncComplete
+	if ok {
+		for _, replayed := range window.messages {
+			c.send <- replayed
+		}
+		log.Info("Resync replayed", "clientID", c.ID, "from", from, "replayed", len(window.messages))
+	} else {
+		action = ActionResyncUnavailable
+		log.Info("Resync unavailable", "clientID", c.ID, "from", from, "earliest", window.earliest)
+	}
+
+	c.send <- Message{
+		ID:      uuid.New().String(),
+		Type:    MessageTypeMessage,
+		Channel: "system",
+		Action:  action,
+		Data: map[string]any{
+			"from":     from,
+			"replayed": len(window.messages),
+			"earliest": window.earliest,
+			"latest":   window.latest,
+		},
+		Timestamp: c.Manager.now(),
+	}
+}
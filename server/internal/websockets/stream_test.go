@@ -0,0 +1,184 @@
+package websockets
+
+import (
+	"server/config"
+	"server/internal/clock"
+	"server/internal/logger"
+	"server/internal/models"
+	"server/internal/utils/testsupport"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStreamManager(t *testing.T) (*Manager, *clock.Fake) {
+	t.Helper()
+	fake := testsupport.FreezeTime(t)
+	manager := &Manager{
+		hub:     &Hub{clients: make(map[string]*Client)},
+		log:     logger.New("test"),
+		clock:   fake,
+		config:  testsupport.WithKey(config.Config{}),
+		streams: newUserStreams(),
+	}
+	return manager, fake
+}
+
+// connectStreamClient signs userID in on a new v2 connection and returns it
+// with its auth_success.
+func connectStreamClient(t *testing.T, manager *Manager, id string, userID uuid.UUID) (*Client, Message) {
+	t.Helper()
+	client := &Client{
+		ID:      id,
+		Status:  StatusUnauthenticated,
+		Manager: manager,
+		send:    make(chan Message, SendChannelSize),
+	}
+	manager.hub.clients[id] = client
+
+	token := testsupport.MintTestToken(t, userID, testsupport.TokenOptions{Clock: manager.clock})
+	client.routeMessage(Message{
+		Type:    MessageTypeAuthResponse,
+		Version: ProtocolVersion2,
+		Data:    map[string]any{"token": token},
+	})
+	success := receive(t, client)
+	require.Equal(t, MessageTypeAuthSuccess, success.Type)
+	return client, success
+}
+
+// sendResync asks for a resync from from, which arrives as a float64 like
+// any JSON number.
+func sendResync(client *Client, from any) {
+	client.routeMessage(Message{
+		Type:    MessageTypeMessage,
+		Channel: "system",
+		Action:  ActionResync,
+		Data:    map[string]any{"from": from},
+	})
+}
+
+func userSequences(t *testing.T, client *Client, count int) []uint64 {
+	t.Helper()
+	sequences := make([]uint64, count)
+	for i := range sequences {
+		sequences[i] = receive(t, client).UserSequence
+	}
+	return sequences
+}
+
+func TestUserStream_SharedAcrossTabs(t *testing.T) {
+	manager, _ := newStreamManager(t)
+	userID, otherID := uuid.New(), uuid.New()
+
+	first, _ := connectStreamClient(t, manager, "first", userID)
+	second, _ := connectStreamClient(t, manager, "second", userID)
+	other, _ := connectStreamClient(t, manager, "other", otherID)
+
+	manager.SendMessageToUser(userID, Message{ID: "mention-1", Type: MessageTypeMessage})
+	manager.sendToAuthenticatedClients(Message{ID: "broadcast-1", Type: MessageTypeBroadcast})
+	manager.SendMessageToUser(userID, Message{ID: "mention-2", Type: MessageTypeMessage})
+
+	assert.Equal(t, []uint64{1, 2, 3}, userSequences(t, first, 3))
+	assert.Equal(t, []uint64{1, 2, 3}, userSequences(t, second, 3), "every tab sees the same positions")
+	assert.Equal(t, []uint64{1}, userSequences(t, other, 1), "each user has a stream of their own")
+}
+
+func TestUserStream_DroppedMessageLeavesGap(t *testing.T) {
+	manager, _ := newStreamManager(t)
+	userID := uuid.New()
+	client, _ := connectStreamClient(t, manager, "slow", userID)
+	client.send = make(chan Message, 1)
+
+	manager.sendToAuthenticatedClients(Message{ID: "first", Type: MessageTypeBroadcast})
+	// The channel is full, so this one is dropped but still numbered
+	manager.sendToAuthenticatedClients(Message{ID: "dropped", Type: MessageTypeBroadcast})
+	assert.Equal(t, uint64(1), receive(t, client).UserSequence)
+
+	manager.sendToAuthenticatedClients(Message{ID: "third", Type: MessageTypeBroadcast})
+	next := receive(t, client)
+	assert.Equal(t, "third", next.ID)
+	assert.Equal(t, uint64(3), next.UserSequence, "the skipped 2 tells the client it missed a message")
+}
+
+func TestUserStream_SequenceSurvivesReconnect(t *testing.T) {
+	manager, fakeClock := newStreamManager(t)
+	userID := uuid.New()
+
+	client, success := connectStreamClient(t, manager, "first", userID)
+	assert.Equal(t, uint64(0), success.Data["sequence"])
+	manager.SendMessageToUser(userID, Message{ID: "mention-1", Type: MessageTypeMessage})
+	manager.SendMessageToUser(userID, Message{ID: "mention-2", Type: MessageTypeMessage})
+	manager.unregisterClient(client)
+
+	fakeClock.Advance(STREAM_TTL - time.Second)
+	client, success = connectStreamClient(t, manager, "second", userID)
+	assert.Equal(t, uint64(2), success.Data["sequence"], "auth_success tells where the stream is")
+	manager.SendMessageToUser(userID, Message{ID: "mention-3", Type: MessageTypeMessage})
+	assert.Equal(t, uint64(3), receive(t, client).UserSequence)
+	manager.unregisterClient(client)
+
+	// Past the TTL without a connection the stream starts over
+	fakeClock.Advance(STREAM_TTL)
+	_, success = connectStreamClient(t, manager, "third", userID)
+	assert.Equal(t, uint64(0), success.Data["sequence"])
+}
+
+func TestUserStream_Resync(t *testing.T) {
+	manager, _ := newStreamManager(t)
+	userID := uuid.New()
+	client, _ := connectStreamClient(t, manager, "client", userID)
+
+	for _, id := range []string{"one", "two", "three"} {
+		manager.sendToAudience(Message{ID: id, Type: MessageTypeBroadcast}, models.ANNOUNCEMENT_AUDIENCE_ALL, nil)
+	}
+	userSequences(t, client, 3)
+
+	sendResync(client, float64(2))
+	replayed := []Message{receive(t, client), receive(t, client)}
+	assert.Equal(t, "two", replayed[0].ID)
+	assert.Equal(t, uint64(2), replayed[0].UserSequence)
+	assert.Equal(t, "three", replayed[1].ID)
+
+	done := receive(t, client)
+	assert.Equal(t, ActionResyncComplete, done.Action)
+	assert.Equal(t, 2, done.Data["replayed"])
+	assert.Equal(t, uint64(3), done.Data["latest"])
+}
+
+func TestUserStream_ResyncUnavailable(t *testing.T) {
+	manager, _ := newStreamManager(t)
+	userID := uuid.New()
+	client, _ := connectStreamClient(t, manager, "client", userID)
+
+	for range STREAM_BUFFER_SIZE + 5 {
+		manager.SendMessageToUser(userID, Message{ID: uuid.New().String(), Type: MessageTypeMessage})
+		<-client.send
+	}
+
+	sendResync(client, float64(3))
+	reply := receive(t, client)
+	assert.Equal(t, ActionResyncUnavailable, reply.Action)
+	assert.Equal(t, uint64(6), reply.Data["earliest"], "the client refetches over HTTP instead")
+	assert.Equal(t, uint64(STREAM_BUFFER_SIZE+5), reply.Data["latest"])
+	assert.Equal(t, 0, reply.Data["replayed"])
+
+	// From the earliest still held it works, replaying the whole buffer
+	client.send = make(chan Message, STREAM_BUFFER_SIZE+1)
+	sendResync(client, float64(6))
+	assert.Equal(t, uint64(6), receive(t, client).UserSequence)
+}
+
+func TestUserStream_ResyncRejectsBadFrom(t *testing.T) {
+	manager, _ := newStreamManager(t)
+	client, _ := connectStreamClient(t, manager, "client", uuid.New())
+
+	sendResync(client, "yesterday")
+
+	reply := receive(t, client)
+	assert.Equal(t, MessageTypeError, reply.Type)
+	assert.Equal(t, 1, client.strikes)
+}
@@ -0,0 +1,129 @@
+package websockets
+
+import (
+	"server/internal/validate"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	MaxMessageIDLength = 64
+	MaxChannelLength   = 128
+	MaxActionLength    = 128
+	MaxTypeLength      = 128
+
+	// Clients are disconnected after this many invalid messages
+	MaxStrikes = 3
+
+	ErrorCodeInvalidField = "invalid_field"
+)
+
+// ValidateInbound checks the envelope strings a client sent and returns the
+// first that broke the limits. Everything is checked before the server
+// overwrites its own fields, so a bad ID is still reported even though it
+// would be replaced.
+func ValidateInbound(message Message) *validate.FieldError {
+	limits := []struct {
+		field string
+		value string
+		max   int
+	}{
+		{"id", message.ID, MaxMessageIDLength},
+		{"type", message.Type, MaxTypeLength},
+		{"channel", message.Channel, MaxChannelLength},
+		{"action", message.Action, MaxActionLength},
+		{"ackId", message.AckID, MaxMessageIDLength},
+	}
+
+	for _, limit := range limits {
+		if fieldErr := validate.Check(limit.field, limit.value, validate.Length(0, limit.max)); fieldErr != nil {
+			return fieldErr
+		}
+	}
+
+	return validate.Check("userId", message.UserID, validate.UUID())
+}
+
+// FieldErrorData renders a field that broke a rule as the Data of the
+// MessageTypeError the client is sent. Code is always ErrorCodeInvalidField;
+// rule is the code of the rule that was broken.
+func FieldErrorData(fieldErr *validate.FieldError) map[string]any {
+	return map[string]any{
+		"code":   ErrorCodeInvalidField,
+		"field":  fieldErr.Field,
+		"reason": fieldErr.Reason,
+		"rule":   fieldErr.Code,
+	}
+}
+
+// acceptMessage validates an inbound message and fills in the fields only the
+// server may set. Invalid messages are answered with an error and count as a
+// strike; ok is false when the message must be dropped.
+func (c *Client) acceptMessage(message Message) (Message, bool) {
+	if fieldErr := ValidateInbound(message); fieldErr != nil {
+		c.strike(fieldErr)
+		return Message{}, false
+	}
+
+	message.ID = uuid.New().String()
+	message.Timestamp = c.Manager.now()
+
+	// Whatever the client claims, a message is from the user it
+	// authenticated as.
+	message.UserID = ""
+	if c.Status == StatusAuthenticated {
+		message.UserID = c.UserID.String()
+	}
+
+	return message, true
+}
+
+func (c *Client) strike(fieldErr *validate.FieldError) {
+	log := c.Manager.log.Function("strike")
+
+	c.strikes++
+	data := FieldErrorData(fieldErr)
+	data["strikes"] = c.strikes
+	data["maxStrikes"] = MaxStrikes
+	c.send <- Message{
+		ID:        uuid.New().String(),
+		Type:      MessageTypeError,
+		Channel:   "system",
+		Action:    ErrorCodeInvalidField,
+		Data:      data,
+		Timestamp: c.Manager.now(),
+	}
+
+	log.Warn("Invalid message field", "clientID", c.ID, "field", fieldErr.Field, "strikes", c.strikes)
+
+	if c.strikes >= MaxStrikes {
+		log.Warn("Too many invalid messages, closing connection", "clientID", c.ID)
+		c.closeAfterFlush(DISCONNECT_KICKED, websocket.ClosePolicyViolation, "Too many invalid messages")
+	}
+}
+
+// SanitizeLogString replaces invalid UTF-8 and drops control characters so a
+// client controlled value can't forge log lines.
+func SanitizeLogString(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(value, string(utf8.RuneError)))
+}
+
+// messageForLog is a copy of m that is safe to hand to the logger.
+func messageForLog(m Message) Message {
+	m.ID = SanitizeLogString(m.ID)
+	m.Type = SanitizeLogString(m.Type)
+	m.Channel = SanitizeLogString(m.Channel)
+	m.Action = SanitizeLogString(m.Action)
+	m.UserID = SanitizeLogString(m.UserID)
+	m.AckID = SanitizeLogString(m.AckID)
+	return m
+}
@@ -0,0 +1,124 @@
+package websockets
+
+import (
+	"server/internal/validate"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateInbound(t *testing.T) {
+	testCases := []struct {
+		name    string
+		message Message
+		field   string
+	}{
+		{"valid", Message{ID: "client-1", Type: MessageTypeMessage, Channel: "user", Action: "typing"}, ""},
+		{"id at limit", Message{ID: strings.Repeat("a", MaxMessageIDLength)}, ""},
+		{"id too long", Message{ID: strings.Repeat("a", MaxMessageIDLength+1)}, "id"},
+		{"multibyte id counts characters", Message{ID: strings.Repeat("é", MaxMessageIDLength)}, ""},
+		{"channel at limit", Message{Channel: strings.Repeat("c", MaxChannelLength)}, ""},
+		{"channel too long", Message{Channel: strings.Repeat("c", MaxChannelLength+1)}, "channel"},
+		{"action too long", Message{Action: strings.Repeat("x", MaxActionLength+1)}, "action"},
+		{"type too long", Message{Type: strings.Repeat("t", MaxTypeLength+1)}, "type"},
+		{"ack id too long", Message{AckID: strings.Repeat("a", MaxMessageIDLength+1)}, "ackId"},
+		{"10KB channel", Message{Channel: strings.Repeat("c", 10000)}, "channel"},
+		{"invalid utf-8", Message{Action: "bad\xff"}, "action"},
+		{"user id uuid", Message{UserID: uuid.New().String()}, ""},
+		{"user id not a uuid", Message{UserID: "admin"}, "userId"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErr := ValidateInbound(tc.message)
+			if tc.field == "" {
+				assert.Nil(t, fieldErr)
+				return
+			}
+			require.NotNil(t, fieldErr)
+			assert.Equal(t, tc.field, fieldErr.Field)
+		})
+	}
+}
+
+func TestAcceptMessage_OverwritesServerFields(t *testing.T) {
+	userID := uuid.New()
+	spoofed := uuid.New().String()
+	sent := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		status   int
+		expected string
+	}{
+		{"authenticated client claims another user", StatusAuthenticated, userID.String()},
+		{"guest claims a user", StatusUnauthenticated, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &Client{ID: "client", Status: tc.status, send: make(chan Message, 10)}
+			if tc.status == StatusAuthenticated {
+				client.UserID = userID
+			}
+			newPublicChannelManager(client)
+
+			accepted, ok := client.acceptMessage(Message{
+				ID:        "client-chosen",
+				Type:      MessageTypeMessage,
+				UserID:    spoofed,
+				Timestamp: sent,
+			})
+
+			require.True(t, ok)
+			assert.Equal(t, tc.expected, accepted.UserID)
+			assert.NotEqual(t, "client-chosen", accepted.ID)
+			assert.NotEqual(t, sent, accepted.Timestamp)
+			assert.Empty(t, client.send)
+		})
+	}
+}
+
+func TestAcceptMessage_StrikesThenDisconnects(t *testing.T) {
+	client := &Client{ID: "client", Status: StatusAuthenticated, UserID: uuid.New(), send: make(chan Message, 10)}
+	newPublicChannelManager(client)
+
+	for strike := 1; strike <= MaxStrikes; strike++ {
+		_, ok := client.acceptMessage(Message{Channel: strings.Repeat("c", MaxChannelLength+1)})
+		assert.False(t, ok)
+
+		reply := receive(t, client)
+		assert.Equal(t, MessageTypeError, reply.Type)
+		assert.Equal(t, ErrorCodeInvalidField, reply.Action)
+		assert.Equal(t, "channel", reply.Data["field"])
+		assert.Equal(t, strike, reply.Data["strikes"])
+		assert.Equal(t, validate.CODE_TOO_LONG, reply.Data["rule"])
+	}
+	assert.Equal(t, MaxStrikes, client.strikes)
+}
+
+func TestFieldErrorData(t *testing.T) {
+	data := FieldErrorData(validate.Check("data.token", "", validate.Required()))
+
+	assert.Equal(t, map[string]any{
+		"code":   ErrorCodeInvalidField,
+		"field":  "data.token",
+		"reason": "is required",
+		"rule":   validate.CODE_REQUIRED,
+	}, data)
+}
+
+func TestSanitizeLogString(t *testing.T) {
+	assert.Equal(t, "forgedINFO admin=true", SanitizeLogString("forged\nINFO admin=true"))
+	assert.Equal(t, "tab", SanitizeLogString("t\ta\x00b"))
+	assert.Equal(t, "bad�", SanitizeLogString("bad\xff"))
+	assert.Equal(t, "测试🚀", SanitizeLogString("测试🚀"))
+
+	logged := messageForLog(Message{Channel: "a\r\nb", Action: "c\x1b[31m"})
+	assert.Equal(t, "ab", logged.Channel)
+	assert.Equal(t, "c[31m", logged.Action)
+}
@@ -1,12 +1,20 @@
 package websockets
 
 import (
-	"log/slog"
+	"context"
+	"errors"
 	"server/config"
+	"server/internal/clock"
 	"server/internal/database"
 	"server/internal/events"
 	"server/internal/logger"
+	"server/internal/maintenance"
+	"server/internal/models"
+	"server/internal/notifier"
 	"server/internal/utils"
+	"server/internal/validate"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/websocket/v2"
@@ -25,6 +33,12 @@ const (
 	MessageTypeAuthResponse = "auth_response"
 	MessageTypeAuthSuccess  = "auth_success"
 	MessageTypeAuthFailure  = "auth_failure"
+	MessageTypeSubscribe    = "subscribe"
+	MessageTypeUnsubscribe  = "unsubscribe"
+	MessageTypeSubscribed   = "subscribed"
+	MessageTypeUnsubscribed = "unsubscribed"
+	MessageTypeReconnect    = "reconnect"
+	MessageTypeTokenRefresh = "token_refresh"
 	PingInterval            = 30 * time.Second
 	PongTimeout             = 60 * time.Second
 	WriteTimeout            = 10 * time.Second
@@ -32,25 +46,59 @@ const (
 	SendChannelSize         = 64
 	// Channels
 	BROADCAST_CHANNEL = "broadcast"
+	// Hot path logging, per manager
+	LOG_SAMPLE_LIMIT      = 10
+	LOG_SAMPLE_INTERVAL   = time.Second
+	LOG_PAYLOAD_MAX_BYTES = 256
 )
 
-type Message struct {
-	ID        string         `json:"id"`
-	Type      string         `json:"type"`
-	Channel   string         `json:"channel,omitempty"`
-	Action    string         `json:"action,omitempty"`
-	UserID    string         `json:"userId,omitempty"`
-	Data      map[string]any `json:"data,omitempty"`
-	Timestamp time.Time      `json:"timestamp"`
-}
+// Message is the in-memory envelope. What actually goes over the wire depends
+// on the client's negotiated protocol version, see EncodeMessage. It lives in
+// notifier so controllers can build one without importing this package.
+type Message = notifier.Message
 
 type Client struct {
 	ID         string
 	UserID     uuid.UUID
-	Connection *websocket.Conn
+	Connection Conn
 	Manager    *Manager
 	Status     int
 	send       chan Message
+	sequence   uint64
+	// Invalid messages received, only touched by the read pump
+	strikes int
+	// Public channels the client listens on, guarded by the hub mutex
+	subscriptions map[string]bool
+	// Close frame the write pump sends once send is closed, empty by default
+	closeMessage []byte
+	// Token the client can reconnect with, guarded by the hub mutex. Empty
+	// until it authenticates or once it has been used or revoked.
+	resumeToken    string
+	resumeIssuedAt time.Time
+	// Set from auth_response when the client tags its connection, guarded
+	// by the hub mutex
+	DeviceID string
+	// Looked up as the client authenticates, see Manager.SetUserLookup
+	IsAdmin bool
+	// The session and expiry of the JWT the client authenticated with,
+	// carried over by a resume and moved along by a session refresh. Guarded
+	// by the hub mutex. The expiry is zero when the token had none.
+	sessionID      string
+	tokenExpiresAt time.Time
+	// Counted in its user's stream, guarded by the hub mutex
+	inStream bool
+	// When Serve took it on, and why it was closed, see setDisconnectReason
+	connectedAt      time.Time
+	disconnectReason atomic.Pointer[string]
+	// Messages dropped because its send channel was full, and the round trip
+	// time of the last ping, see ConnectionStats
+	dropped atomic.Uint64
+	pingRTT atomic.Int64
+	// When it last reported diagnostics, only touched by the read pump
+	lastDiagnosticsAt time.Time
+	// Set by the read pump from auth_response and read by the write pump,
+	// see ProtocolVersion
+	version atomic.Int32
 }
 
 type Manager struct {
@@ -59,9 +107,61 @@ type Manager struct {
 	config   config.Config
 	log      logger.Logger
 	eventBus *events.EventBus
+	clock    clock.Clock
+
+	readLogSampler      *logger.Sampler
+	broadcastLogSampler *logger.Sampler
+
+	// PingInterval and PongTimeout, shortened by tests
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+
+	// Set through SetNotificationPreferences
+	preferences          PreferenceLookup
+	notificationCache    *database.LocalCache[models.NotificationPreferences]
+	suppressedBroadcasts atomic.Uint64
+	suppressedMentions   atomic.Uint64
+
+	// Set through SetMaintenance
+	maintenance *maintenance.Mode
+
+	// Set through SetResumeStore
+	resumeStore database.CacheStore
+
+	// Set through SetUserLookup
+	users UserLookup
+
+	// Set through SetSessionLookup
+	sessions SessionLookup
+
+	// Sequence and recent messages of each user, see userStreams
+	streams *userStreams
+
+	// Recent disconnects by reason, see RecentDisconnects
+	disconnects disconnectWindow
+
+	// Reports clients sent on their connections, see UserDiagnostics
+	diagnostics diagnosticsHistory
+
+	// Refresh hints waiting out REFRESH_HINT_WINDOW, shortened by tests, see
+	// QueueRefreshHint
+	hints             refreshHints
+	refreshHintWindow time.Duration
+
+	// Set while draining, see Drain. drainTimer waits out a batch's slot.
+	drainMutex  sync.Mutex
+	drain       *DrainProgress
+	cancelDrain context.CancelFunc
+	drainTimer  func(d time.Duration) <-chan time.Time
 }
 
-func New(db database.DB, eventBus *events.EventBus, config config.Config) (*Manager, error) {
+// New starts the hub. A nil clock uses the wall clock.
+func New(
+	db database.DB,
+	eventBus *events.EventBus,
+	config config.Config,
+	clk clock.Clock,
+) (*Manager, error) {
 	log := logger.New("websockets")
 
 	manager := &Manager{
@@ -70,32 +170,59 @@ func New(db database.DB, eventBus *events.EventBus, config config.Config) (*Mana
 			register:   make(chan *Client),
 			unregister: make(chan *Client),
 			clients:    make(map[string]*Client),
+			devices:    make(map[deviceKey]*Client),
+			probe:      make(chan chan struct{}),
 		},
 		db:       db,
 		config:   config,
 		log:      log,
 		eventBus: eventBus,
+		clock:    clock.OrDefault(clk),
+		streams:  newUserStreams(),
+
+		drainTimer:        time.After,
+		pingInterval:      PingInterval,
+		pongTimeout:       PongTimeout,
+		refreshHintWindow: REFRESH_HINT_WINDOW,
+
+		readLogSampler:      logger.NewSampler(LOG_SAMPLE_LIMIT, LOG_SAMPLE_INTERVAL, clk),
+		broadcastLogSampler: logger.NewSampler(LOG_SAMPLE_LIMIT, LOG_SAMPLE_INTERVAL, clk),
 	}
 
 	log.Function("New").Info("Starting websocket hub")
 	go manager.hub.run(manager)
 
 	go manager.subscribeToBroadcastEvents()
+	go manager.subscribeToUserLoginEvents()
+	go manager.subscribeToSessionRefreshedEvents()
+	go manager.subscribeToUserUpdatedEvents()
+	go manager.subscribeToSystemAlertEvents()
 
 	return manager, nil
 }
 
+// HandleWebSocket serves a connection fiber upgraded.
 func (m *Manager) HandleWebSocket(c *websocket.Conn) {
-	log := m.log.Function("HandleWebSocket")
+	m.Serve(c)
+}
+
+// Serve runs c as a client of the hub until it disconnects, asking it to
+// authenticate first.
+func (m *Manager) Serve(c Conn) {
+	log := m.log.Function("Serve")
+	if m.rejectForMaintenance(c) || m.rejectForDrain(c) {
+		return
+	}
 	clientID := uuid.New().String()
 
 	client := &Client{
-		ID:         clientID,
-		UserID:     uuid.Nil,
-		Connection: c,
-		Manager:    m,
-		Status:     StatusUnauthenticated,
-		send:       make(chan Message, SendChannelSize),
+		ID:          clientID,
+		UserID:      uuid.Nil,
+		Connection:  c,
+		Manager:     m,
+		Status:      StatusUnauthenticated,
+		send:        make(chan Message, SendChannelSize),
+		connectedAt: m.now(),
 	}
 
 	authRequest := Message{
@@ -103,11 +230,13 @@ func (m *Manager) HandleWebSocket(c *websocket.Conn) {
 		Type:      MessageTypeAuthRequest,
 		Channel:   "system",
 		Action:    "authenticate",
-		Timestamp: time.Now(),
+		Data:      map[string]any{"supportedVersions": SupportedProtocolVersions()},
+		Timestamp: m.now(),
 	}
 
-	if err := c.WriteJSON(authRequest); err != nil {
+	if err := client.writeMessage(authRequest); err != nil {
 		log.Er("failed to send auth request", err)
+		m.countDisconnect(DISCONNECT_WRITE_ERROR)
 		if err := c.Close(); err != nil {
 			log.Er("failed to close connection", err)
 		}
@@ -118,6 +247,8 @@ func (m *Manager) HandleWebSocket(c *websocket.Conn) {
 	m.hub.register <- client
 	defer func() {
 		log.Info("Client disconnected in the defer", "clientID", clientID)
+		// Restarts the token's window, so it can be used to reconnect
+		m.saveResumeState(client)
 		m.hub.unregister <- client
 		if err := c.Close(); err != nil {
 			log.Er("failed to close connection", err)
@@ -128,6 +259,10 @@ func (m *Manager) HandleWebSocket(c *websocket.Conn) {
 	client.writePump()
 }
 
+func (m *Manager) now() time.Time {
+	return clock.OrDefault(m.clock).Now()
+}
+
 func (m *Manager) BroadcastMessage(message Message) {
 	log := m.log.Function("BroadcastMessage")
 	log.Info("Broadcasting message from ", "messageID", message.ID)
@@ -150,7 +285,7 @@ func (m *Manager) BroadcastUserLogin(userID string, userData map[string]any) {
 		Action:    "user_login",
 		UserID:    userID,
 		Data:      userData,
-		Timestamp: time.Now(),
+		Timestamp: m.now(),
 	}
 
 	log.Info("Broadcasting user login", "userID", userID, "messageID", message.ID)
@@ -171,11 +306,12 @@ func (c *Client) readPump() {
 	}()
 
 	c.Connection.SetReadLimit(MaxMessageSize)
-	if err := c.Connection.SetReadDeadline(time.Now().Add(PongTimeout)); err != nil {
+	if err := c.Connection.SetReadDeadline(time.Now().Add(c.Manager.pongTimeout)); err != nil {
 		log.Er("failed to set read deadline", err, "clientID", c.ID)
 	}
-	c.Connection.SetPongHandler(func(string) error {
-		if err := c.Connection.SetReadDeadline(time.Now().Add(PongTimeout)); err != nil {
+	c.Connection.SetPongHandler(func(appData string) error {
+		c.recordPong(appData)
+		if err := c.Connection.SetReadDeadline(time.Now().Add(c.Manager.pongTimeout)); err != nil {
 			log.Er("failed to set read deadline in pong handler", err, "clientID", c.ID)
 		}
 		return nil
@@ -184,8 +320,8 @@ func (c *Client) readPump() {
 	for {
 		var message Message
 		err := c.Connection.ReadJSON(&message)
-		log.Info("Read message", "clientID", c.ID, "message", message)
 		if err != nil {
+			c.setDisconnectReason(readErrorReason(err))
 			log.Er("failed to read message", err)
 			if websocket.IsUnexpectedCloseError(
 				err,
@@ -197,8 +333,16 @@ func (c *Client) readPump() {
 			break
 		}
 
-		message.ID = uuid.New().String()
-		message.Timestamp = time.Now()
+		log.Sampled(c.Manager.readLogSampler).Debug(
+			"Read message",
+			"clientID", c.ID,
+			"message", logger.Truncate(messageForLog(message), LOG_PAYLOAD_MAX_BYTES),
+		)
+
+		message, ok := c.acceptMessage(message)
+		if !ok {
+			continue
+		}
 
 		c.routeMessage(message)
 	}
@@ -207,8 +351,28 @@ func (c *Client) readPump() {
 func (c *Client) routeMessage(message Message) {
 	log := c.Manager.log.Function("routeMessage")
 
+	if message.Version != 0 && !IsSupportedProtocolVersion(message.Version) {
+		c.rejectProtocolVersion(message.Version)
+		return
+	}
+
+	// Registered types arrive decoded, everything else keeps the raw map
+	data, fieldErr := DecodeMessageData(message)
+	if fieldErr != nil {
+		c.strike(fieldErr)
+		return
+	}
+
 	if message.Type == MessageTypeAuthResponse {
-		c.handleAuthResponse(message)
+		c.handleAuthResponse(message, data.(AuthResponseData))
+		return
+	}
+
+	// Guests may only (un)subscribe to public channels, everything else
+	// falls through to the authentication check.
+	isSubscription := message.Type == MessageTypeSubscribe || message.Type == MessageTypeUnsubscribe
+	if isSubscription && (c.Status == StatusAuthenticated || c.Manager.IsPublicChannel(message.Channel)) {
+		c.handleSubscription(message)
 		return
 	}
 
@@ -218,7 +382,7 @@ func (c *Client) routeMessage(message Message) {
 			"clientID",
 			c.ID,
 			"messageType",
-			message.Type,
+			SanitizeLogString(message.Type),
 		)
 		authFailure := Message{
 			ID:        uuid.New().String(),
@@ -226,21 +390,33 @@ func (c *Client) routeMessage(message Message) {
 			Channel:   "system",
 			Action:    "authentication_required",
 			Data:      map[string]any{"reason": "Authentication required"},
-			Timestamp: time.Now(),
+			Timestamp: c.Manager.now(),
 		}
 		c.send <- authFailure
 		return
 	}
 
+	if message.Type == MessageTypeMessage && message.Channel == "system" && message.Action == ActionResync {
+		c.handleResync(message)
+		return
+	}
+
+	if message.Type == MessageTypeMessage && message.Channel == "system" && message.Action == ActionDiagnostics {
+		c.handleDiagnostics(data.(DiagnosticsData))
+		return
+	}
+
 	switch message.Channel {
 	case "system":
-		slog.Info("System message", "messageID", message.ID, "clientID", c.ID, "message", message)
+		log.Debug("System message", "messageID", message.ID, "clientID", c.ID,
+			"message", logger.Truncate(messageForLog(message), LOG_PAYLOAD_MAX_BYTES))
 	case "user":
-		slog.Info("User message", "messageID", message.ID, "clientID", c.ID, "message", message)
+		log.Debug("User message", "messageID", message.ID, "clientID", c.ID,
+			"message", logger.Truncate(messageForLog(message), LOG_PAYLOAD_MAX_BYTES))
 	}
 }
 
-func (c *Client) handleAuthResponse(message Message) {
+func (c *Client) handleAuthResponse(message Message, data AuthResponseData) {
 	log := c.Manager.log.Function("handleAuthResponse")
 
 	if c.Status != StatusUnauthenticated {
@@ -248,34 +424,114 @@ func (c *Client) handleAuthResponse(message Message) {
 		return
 	}
 
-	token, ok := message.Data["token"].(string)
-	if !ok || token == "" {
-		log.Warn("Invalid token in auth response", "clientID", c.ID)
-		c.sendAuthFailure("Invalid token format")
+	if data.Token == "" && data.ResumeToken == "" {
+		c.strike(validate.Check("data.token", data.Token, validate.Required()))
+		return
+	}
+	if fieldErr := validate.Check("data.deviceId", data.DeviceID, validate.Length(0, DEVICE_ID_MAX_LENGTH)); fieldErr != nil {
+		c.strike(fieldErr)
 		return
 	}
 
-	tokenClaims, err := utils.ParseJWTToken(token, c.Manager.config)
-	if err != nil {
-		log.Er("failed to parse token", err, "clientID", c.ID)
-		c.sendAuthFailure("Invalid token")
+	version := message.Version
+	if version == 0 {
+		version = DefaultProtocolVersion
+	}
+	if !IsSupportedProtocolVersion(version) {
+		c.rejectProtocolVersion(version)
 		return
 	}
+	c.version.Store(int32(version))
+
+	// A resume token takes the old connection's place, and a JWT sent along
+	// with it is the fallback when it no longer works
+	var resumed *ResumeState
+	if data.ResumeToken != "" {
+		state, err := c.Manager.takeResumeState(context.Background(), data.ResumeToken)
+		switch {
+		case err == nil:
+			resumed = &state
+		case data.Token == "":
+			log.Er("failed to resume", err, "clientID", c.ID)
+			c.sendAuthFailure("Invalid resume token")
+			return
+		}
+	}
 
-	c.UserID = tokenClaims.UserID
+	if resumed != nil {
+		c.Manager.hub.mutex.Lock()
+		c.UserID = resumed.UserID
+		c.sessionID = resumed.SessionID
+		c.tokenExpiresAt = resumed.TokenExpiresAt
+		c.Manager.hub.mutex.Unlock()
+	} else {
+		tokenClaims, err := utils.ParseJWTToken(data.Token, c.Manager.config, c.Manager.clock)
+		if errors.Is(err, utils.ErrWrongIssuer) || errors.Is(err, utils.ErrWrongAudience) {
+			log.Warn("Rejected token from another service", "clientID", c.ID, "error", err)
+			c.sendAuthFailure("Token was not issued for this service")
+			return
+		}
+		if err != nil {
+			log.Er("failed to parse token", err, "clientID", c.ID)
+			c.sendAuthFailure("Invalid token")
+			return
+		}
+		c.Manager.hub.mutex.Lock()
+		c.UserID = tokenClaims.UserID
+		c.sessionID = tokenClaims.Subject
+		if tokenClaims.ExpiresAt != nil {
+			c.tokenExpiresAt = tokenClaims.ExpiresAt.Time
+		}
+		c.Manager.hub.mutex.Unlock()
+	}
+
+	replaced, ok := c.Manager.claimDevice(c, data.DeviceID)
+	if !ok {
+		c.rejectDuplicate(data.DeviceID)
+		return
+	}
+	c.IsAdmin = c.Manager.isAdmin(c.UserID)
 	c.Status = StatusAuthenticated
+	c.Manager.joinStream(c)
 
-	log.Info("Client authenticated successfully", "clientID", c.ID, "userID", c.UserID)
+	log.Info("Client authenticated successfully", "clientID", c.ID, "userID", c.UserID, "resumed", resumed != nil)
 
 	c.Manager.promoteClientToAuthenticated(c)
 
+	// Where the user's stream is, so a resumed client can tell what it missed
+	authData := map[string]any{
+		"userId":   c.UserID.String(),
+		"version":  c.ProtocolVersion(),
+		"sequence": c.Manager.streams.latest(c.UserID),
+	}
+	if resumed != nil {
+		authData["resumed"] = true
+		authData["subscriptions"] = c.Manager.restoreSubscriptions(c, resumed.Subscriptions)
+	}
+	if replaced != nil {
+		// The old connection's subscriptions came along
+		authData["replaced"] = true
+		authData["subscriptions"] = c.Manager.subscriptionsOf(c)
+		go c.Manager.closeReplaced(replaced)
+	}
+	if token := c.Manager.issueResumeToken(c); token != "" {
+		authData["resumeToken"] = token
+	}
+	// The expiry X-Token-Expires-At gives on HTTP responses
+	c.Manager.hub.mutex.RLock()
+	tokenExpiresAt := c.tokenExpiresAt
+	c.Manager.hub.mutex.RUnlock()
+	if !tokenExpiresAt.IsZero() {
+		authData["tokenExpiresAt"] = models.NewAPITime(tokenExpiresAt)
+	}
+
 	authSuccess := Message{
 		ID:        uuid.New().String(),
 		Type:      MessageTypeAuthSuccess,
 		Channel:   "system",
 		Action:    "authenticated",
-		Data:      map[string]any{"userId": c.UserID.String()},
-		Timestamp: time.Now(),
+		Data:      authData,
+		Timestamp: c.Manager.now(),
 	}
 
 	c.send <- authSuccess
@@ -290,23 +546,73 @@ func (c *Client) sendAuthFailure(reason string) {
 		Channel:   "system",
 		Action:    "authentication_failed",
 		Data:      map[string]any{"reason": reason},
-		Timestamp: time.Now(),
+		Timestamp: c.Manager.now(),
 	}
 
 	c.send <- authFailure
 
 	log.Info("Auth failure sent, closing connection", "clientID", c.ID, "reason", reason)
 
+	c.closeAfterFlush(DISCONNECT_AUTH_FAILURE, websocket.ClosePolicyViolation, reason)
+}
+
+func (c *Client) rejectProtocolVersion(version int) {
+	log := c.Manager.log.Function("rejectProtocolVersion")
+
+	c.send <- Message{
+		ID:      uuid.New().String(),
+		Type:    MessageTypeError,
+		Channel: "system",
+		Action:  ErrorCodeUnsupportedProtocol,
+		Data: map[string]any{
+			"code":              ErrorCodeUnsupportedProtocol,
+			"version":           version,
+			"supportedVersions": SupportedProtocolVersions(),
+		},
+		Timestamp: c.Manager.now(),
+	}
+
+	log.Warn("Unsupported protocol version, closing connection", "clientID", c.ID, "version", version)
+
+	c.closeAfterFlush(DISCONNECT_KICKED, websocket.ClosePolicyViolation, "Unsupported protocol version")
+}
+
+// closeAfterFlush gives the write pump a moment to deliver a final message
+// before the connection is closed for reason with a close frame of code and
+// text.
+func (c *Client) closeAfterFlush(reason string, code int, text string) {
+	c.setDisconnectReason(reason)
 	go func() {
 		time.Sleep(100 * time.Millisecond)
-		_ = c.Connection.Close()
+		c.Manager.closeWith(c, code, text)
 	}()
 }
 
+func (c *Client) writeMessage(message Message) error {
+	data, err := c.encodeMessage(message)
+	if err != nil {
+		return err
+	}
+
+	return c.Connection.WriteMessage(websocket.TextMessage, data)
+}
+
+// encodeMessage renders message for the client's protocol version. v2 clients
+// get a per-connection sequence number on every message.
+func (c *Client) encodeMessage(message Message) ([]byte, error) {
+	version := c.ProtocolVersion()
+	if version >= ProtocolVersion2 {
+		c.sequence++
+		message.Sequence = c.sequence
+	}
+
+	return EncodeMessage(version, message)
+}
+
 func (c *Client) writePump() {
 	log := c.Manager.log.Function("writePump")
 
-	ticker := time.NewTicker(PingInterval)
+	ticker := time.NewTicker(c.Manager.pingInterval)
 	defer func() {
 		ticker.Stop()
 		_ = c.Connection.Close()
@@ -320,12 +626,13 @@ func (c *Client) writePump() {
 			}
 			if !ok {
 				log.Info("Channel closed", "clientID", c.ID)
-				_ = c.Connection.WriteMessage(websocket.CloseMessage, []byte{})
+				_ = c.Connection.WriteMessage(websocket.CloseMessage, c.closeMessage)
 				return
 			}
 
-			if err := c.Connection.WriteJSON(message); err != nil {
-				log.Er("WebSocket write error", err, "clientID", c.ID, "message", message)
+			if err := c.writeMessage(message); err != nil {
+				c.setDisconnectReason(DISCONNECT_WRITE_ERROR)
+				log.Er("WebSocket write error", err, "clientID", c.ID, "messageID", message.ID, "type", message.Type)
 				return
 			}
 
@@ -334,7 +641,8 @@ func (c *Client) writePump() {
 			if err := c.Connection.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
 				log.Er("failed to set write deadline for ping", err, "clientID", c.ID)
 			}
-			if err := c.Connection.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.Connection.WriteMessage(websocket.PingMessage, c.pingPayload()); err != nil {
+				c.setDisconnectReason(DISCONNECT_WRITE_ERROR)
 				return
 			}
 		}
@@ -345,25 +653,26 @@ func (m *Manager) subscribeToBroadcastEvents() {
 	log := m.log.Function("subscribeToBroadcastEvents")
 	log.Info("Starting broadcast events subscription")
 
-	err := m.eventBus.Subscribe(BROADCAST_CHANNEL, func(event events.Event) error {
-		log.Info(
-			"Received broadcast event",
-			"eventID",
-			event.ID,
-			"eventType",
-			event.Type,
-			"data",
-			event.Data,
-		)
+	topic := m.eventBus.BroadcastTopic()
+	err := topic.Subscribe(func(ctx context.Context, event events.AdminBroadcastEvent) error {
+		log.Info("Received broadcast event", "sentBy", event.SentBy)
 
-		m.sendToAuthenticatedClients(Message{
+		data, err := events.ToData(event)
+		if err != nil {
+			return err
+		}
+		// Who else an announcement went to is none of the recipient's business
+		delete(data, "sentBy")
+		delete(data, "userIds")
+
+		m.sendToAudience(Message{
 			ID:        uuid.New().String(),
 			Type:      MessageTypeBroadcast,
 			Channel:   "system",
 			Action:    "broadcast",
-			Data:      event.Data,
-			Timestamp: time.Now(),
-		})
+			Data:      data,
+			Timestamp: m.now(),
+		}, event.Audience, event.UserIDs)
 		return nil
 	})
 	if err != nil {
@@ -371,20 +680,89 @@ func (m *Manager) subscribeToBroadcastEvents() {
 	}
 }
 
+// subscribeToSystemAlertEvents passes system alerts, such as capacity
+// warnings, on to connected admins.
+func (m *Manager) subscribeToSystemAlertEvents() {
+	log := m.log.Function("subscribeToSystemAlertEvents")
+
+	topic := m.eventBus.SystemAlertTopic()
+	err := topic.Subscribe(func(ctx context.Context, event events.SystemAlertEvent) error {
+		data, err := events.ToData(event)
+		if err != nil {
+			return err
+		}
+
+		m.sendToAudience(Message{
+			ID:        uuid.New().String(),
+			Type:      MessageTypeBroadcast,
+			Channel:   "system",
+			Action:    "alert",
+			Data:      data,
+			Timestamp: m.now(),
+		}, models.ANNOUNCEMENT_AUDIENCE_ADMINS, nil)
+		return nil
+	})
+	if err != nil {
+		log.Er("Failed to subscribe to system alert events", err)
+	}
+}
+
+func (m *Manager) subscribeToUserLoginEvents() {
+	log := m.log.Function("subscribeToUserLoginEvents")
+
+	topic := m.eventBus.UserLoginTopic()
+	err := topic.Subscribe(func(ctx context.Context, event events.UserLoginEvent) error {
+		data, err := events.ToData(event)
+		if err != nil {
+			return err
+		}
+
+		m.BroadcastUserLogin(event.UserID, data)
+		return nil
+	})
+	if err != nil {
+		log.Er("Failed to subscribe to user login events", err)
+	}
+}
+
 func (m *Manager) sendToAuthenticatedClients(message Message) {
-	log := m.log.Function("sendToAuthenticatedClients")
-	
+	m.sendToAudience(message, models.ANNOUNCEMENT_AUDIENCE_ALL, nil)
+}
+
+// sendToAudience sends message to the authenticated clients in the audience,
+// see models.AudienceIncludes, that haven't opted out of broadcasts.
+func (m *Manager) sendToAudience(message Message, audience string, userIDs []string) {
+	log := m.log.Function("sendToAudience")
+
+	allowed := m.deliveryFilter(m.authenticatedUserIDs(), models.NotificationPreferences.AllowsBroadcast)
+
+	m.hub.mutex.RLock()
+	defer m.hub.mutex.RUnlock()
+
+	stamps := m.stampsFor(message)
 	sent := 0
+	suppressed := 0
 	for _, client := range m.hub.clients {
-		if client.Status == StatusAuthenticated {
+		if client.Status == StatusAuthenticated && client.inAudience(audience, userIDs) {
+			if !allowed(client.UserID) {
+				suppressed++
+				continue
+			}
 			select {
-			case client.send <- message:
+			case client.send <- stamps.For(client):
 				sent++
 			default:
+				client.dropped.Add(1)
 				log.Warn("Client send channel full, dropping message", "clientID", client.ID)
 			}
 		}
 	}
-	
-	log.Info("Message sent to authenticated clients", "messageID", message.ID, "clientCount", sent)
+	m.suppressedBroadcasts.Add(uint64(suppressed))
+
+	log.Info(
+		"Message sent to authenticated clients",
+		"messageID", message.ID,
+		"clientCount", sent,
+		"suppressed", suppressed,
+	)
 }
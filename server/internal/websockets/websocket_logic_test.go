@@ -1,9 +1,13 @@
 package websockets
 
 import (
+	"context"
 	"server/config"
+	"server/internal/events"
 	"server/internal/logger"
+	"server/internal/models"
 	"server/internal/utils"
+	"server/internal/utils/testsupport"
 	"testing"
 	"time"
 
@@ -65,32 +69,35 @@ func TestClient_StatusLogic(t *testing.T) {
 }
 
 func TestJWTTokenParsing(t *testing.T) {
-	testConfig := config.Config{
-		SecurityJwtSecret: "test-jwt-secret-very-long-key-for-testing",
-		SecurityPepper:    "test-pepper",
-		SecuritySalt:      12,
-	}
+	testConfig := testsupport.WithKey(config.Config{})
+	frozen := testsupport.FreezeTime(t)
 
 	testUserID := uuid.New()
 
 	// Test valid token generation and parsing
-	expiresAt := time.Now().Add(time.Hour)
-	token, err := utils.GenerateJWTToken(testUserID.String(), expiresAt, "test-issuer", testConfig)
-	require.NoError(t, err)
+	token := testsupport.MintTestToken(t, testUserID, testsupport.TokenOptions{Clock: frozen})
 	assert.NotEmpty(t, token)
 
 	// Test token parsing
-	claims, err := utils.ParseJWTToken(token, testConfig)
+	claims, err := utils.ParseJWTToken(token, testConfig, frozen)
 	require.NoError(t, err)
 	assert.Equal(t, testUserID, claims.UserID)
 
+	// Valid up to, but not at, the instant it expires
+	frozen.Set(claims.ExpiresAt.Add(-time.Nanosecond))
+	_, err = utils.ParseJWTToken(token, testConfig, frozen)
+	assert.NoError(t, err)
+	frozen.Set(claims.ExpiresAt.Time)
+	_, err = utils.ParseJWTToken(token, testConfig, frozen)
+	assert.Error(t, err)
+
 	// Test invalid token
-	_, err = utils.ParseJWTToken("invalid-token", testConfig)
+	_, err = utils.ParseJWTToken("invalid-token", testConfig, frozen)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "invalid number of segments")
 
 	// Test empty token
-	_, err = utils.ParseJWTToken("", testConfig)
+	_, err = utils.ParseJWTToken("", testConfig, frozen)
 	assert.Error(t, err)
 }
 
@@ -149,7 +156,7 @@ func TestMessageRouting_Logic(t *testing.T) {
 
 func TestAuthResponse_MessageHandling(t *testing.T) {
 	testConfig := config.Config{
-		SecurityJwtSecret: "test-jwt-secret-very-long-key-for-testing",
+		Security: config.SecurityConfig{JwtSecret: "test-jwt-secret-very-long-key-for-testing"},
 	}
 
 	manager := &Manager{
@@ -172,16 +179,18 @@ func TestAuthResponse_MessageHandling(t *testing.T) {
 		},
 	}
 
-	client.handleAuthResponse(invalidAuthMsg)
+	client.routeMessage(invalidAuthMsg)
 
-	// Should send auth failure
+	// Should name the bad field and stay unauthenticated
 	select {
-	case failureMsg := <-client.send:
-		assert.Equal(t, MessageTypeAuthFailure, failureMsg.Type)
-		assert.Contains(t, failureMsg.Data["reason"], "Invalid token format")
+	case errorMsg := <-client.send:
+		assert.Equal(t, MessageTypeError, errorMsg.Type)
+		assert.Equal(t, ErrorCodeInvalidField, errorMsg.Data["code"])
+		assert.Equal(t, "data.token", errorMsg.Data["field"])
 	case <-time.After(100 * time.Millisecond):
-		t.Fatal("Expected auth failure message")
+		t.Fatal("Expected invalid field message")
 	}
+	assert.Equal(t, StatusUnauthenticated, client.Status)
 
 	// Test missing token
 	emptyAuthMsg := Message{
@@ -189,16 +198,18 @@ func TestAuthResponse_MessageHandling(t *testing.T) {
 		Data: map[string]any{}, // No token
 	}
 
-	client.handleAuthResponse(emptyAuthMsg)
+	client.routeMessage(emptyAuthMsg)
 
-	// Should send auth failure
+	// Should name the missing field
 	select {
-	case failureMsg := <-client.send:
-		assert.Equal(t, MessageTypeAuthFailure, failureMsg.Type)
-		assert.Contains(t, failureMsg.Data["reason"], "Invalid token format")
+	case errorMsg := <-client.send:
+		assert.Equal(t, MessageTypeError, errorMsg.Type)
+		assert.Equal(t, "data.token", errorMsg.Data["field"])
+		assert.Equal(t, "is required", errorMsg.Data["reason"])
 	case <-time.After(100 * time.Millisecond):
-		t.Fatal("Expected auth failure message")
+		t.Fatal("Expected invalid field message")
 	}
+	assert.Equal(t, StatusUnauthenticated, client.Status)
 }
 
 func TestSendAuthFailure_Logic(t *testing.T) {
@@ -412,4 +423,49 @@ func TestClient_SendChannelBehavior(t *testing.T) {
 	default:
 		// Expected - channel is empty
 	}
-}
\ No newline at end of file
+}
+func TestManager_AuthenticatedClientCount(t *testing.T) {
+	manager := &Manager{
+		hub: &Hub{clients: map[string]*Client{
+			"a": {ID: "a", Status: StatusAuthenticated},
+			"b": {ID: "b", Status: StatusPending},
+			"c": {ID: "c", Status: StatusAuthenticated},
+		}},
+		log: logger.New("test"),
+	}
+
+	assert.Equal(t, 2, manager.AuthenticatedClientCount())
+}
+
+func TestManager_BroadcastSubscription_DeliversAnnouncement(t *testing.T) {
+	eventBus := events.New(nil, config.Config{}, nil)
+	client := &Client{ID: "a", Status: StatusAuthenticated, send: make(chan Message, 1)}
+	manager := &Manager{
+		hub:      &Hub{clients: map[string]*Client{"a": client}},
+		log:      logger.New("test"),
+		eventBus: eventBus,
+	}
+	manager.subscribeToBroadcastEvents()
+
+	expiresAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, eventBus.BroadcastTopic().Publish(context.Background(), events.AdminBroadcastEvent{
+		ID:        "announcement-1",
+		Title:     "Maintenance",
+		Message:   "Back soon",
+		Severity:  "warning",
+		ExpiresAt: models.NewAPITimePtr(&expiresAt),
+		SentBy:    "admin-1",
+	}))
+
+	select {
+	case message := <-client.send:
+		assert.Equal(t, MessageTypeBroadcast, message.Type)
+		assert.Equal(t, "Maintenance", message.Data["title"])
+		assert.Equal(t, "Back soon", message.Data["message"])
+		assert.Equal(t, "warning", message.Data["severity"])
+		assert.Equal(t, "2024-06-01T12:00:00.000Z", message.Data["expiresAt"])
+		assert.NotContains(t, message.Data, "sentBy")
+	case <-time.After(time.Second):
+		t.Fatal("announcement was not delivered")
+	}
+}
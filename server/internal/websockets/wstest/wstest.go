@@ -0,0 +1,264 @@
+// Package wstest connects websocket code under test to a peer over net.Pipe,
+// so protocol tests run real frames, pings and deadlines without a server.
+//
+// Pipe returns the server end, which a Manager serves like a connection fiber
+// upgraded, and a Peer playing the client. The peer reads in the background,
+// answering pings unless told not to, so the server end never blocks writing.
+package wstest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+const (
+	// DEFAULT_TIMEOUT bounds Receive and WaitClosed
+	DEFAULT_TIMEOUT = time.Second
+
+	// Messages the peer read that no test has received yet
+	PEER_BUFFER_SIZE = 64
+)
+
+// Peer is the client end of a Pipe.
+type Peer struct {
+	t    testing.TB
+	conn *websocket.Conn
+
+	messages    chan []byte
+	pings       chan string
+	answerPings atomic.Bool
+
+	closed   chan struct{}
+	closeErr error
+}
+
+// Pipe upgrades both ends of a net.Pipe, returning the server's connection
+// and the peer. Both are closed when the test ends. A pipe has no buffer, so
+// the peer's sends, and its answer to a close frame, only complete while the
+// server end is being read, as Manager.Serve does.
+func Pipe(t testing.TB) (*websocket.Conn, *Peer) {
+	t.Helper()
+	serverEnd, clientEnd := net.Pipe()
+
+	upgraded := make(chan *websocket.Conn, 1)
+	upgradeErr := make(chan error, 1)
+	go func() {
+		server, err := upgrade(serverEnd)
+		if err != nil {
+			upgradeErr <- err
+			return
+		}
+		upgraded <- server
+	}()
+
+	dialer := websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return clientEnd, nil
+		},
+		HandshakeTimeout: DEFAULT_TIMEOUT,
+	}
+	client, _, err := dialer.Dial("ws://wstest/ws", nil)
+	if err != nil {
+		t.Fatalf("wstest: dial: %v", err)
+	}
+
+	var server *websocket.Conn
+	select {
+	case server = <-upgraded:
+	case err := <-upgradeErr:
+		t.Fatalf("wstest: upgrade: %v", err)
+	}
+
+	peer := &Peer{
+		t:        t,
+		conn:     client,
+		messages: make(chan []byte, PEER_BUFFER_SIZE),
+		pings:    make(chan string, PEER_BUFFER_SIZE),
+		closed:   make(chan struct{}),
+	}
+	peer.answerPings.Store(true)
+	client.SetPingHandler(peer.handlePing)
+	go peer.read()
+
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return server, peer
+}
+
+// upgrade reads the handshake from conn and answers it as a server would.
+func upgrade(conn net.Conn) (*websocket.Conn, error) {
+	reader := bufio.NewReader(conn)
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, err
+	}
+	writer := &hijackWriter{
+		conn:   conn,
+		rw:     bufio.NewReadWriter(reader, bufio.NewWriter(conn)),
+		header: make(http.Header),
+	}
+	upgrader := websocket.Upgrader{}
+	return upgrader.Upgrade(writer, request, nil)
+}
+
+// hijackWriter hands the pipe over to the upgrader as a hijacked HTTP
+// connection.
+type hijackWriter struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	header http.Header
+}
+
+func (w *hijackWriter) Header() http.Header            { return w.header }
+func (w *hijackWriter) Write(data []byte) (int, error) { return w.conn.Write(data) }
+func (w *hijackWriter) WriteHeader(statusCode int)     {}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, w.rw, nil
+}
+
+func (p *Peer) read() {
+	defer close(p.closed)
+	for {
+		messageType, data, err := p.conn.ReadMessage()
+		if err != nil {
+			p.closeErr = err
+			return
+		}
+		if messageType == websocket.TextMessage {
+			p.messages <- data
+		}
+	}
+}
+
+func (p *Peer) handlePing(appData string) error {
+	select {
+	case p.pings <- appData:
+	default:
+	}
+	if !p.answerPings.Load() {
+		return nil
+	}
+	err := p.conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(DEFAULT_TIMEOUT))
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// AnswerPings sets whether the peer answers pings with pongs, as browsers
+// do. A peer that doesn't is one whose network went away.
+func (p *Peer) AnswerPings(answer bool) {
+	p.answerPings.Store(answer)
+}
+
+// Send writes v to the server as a JSON text message.
+func (p *Peer) Send(v any) {
+	p.t.Helper()
+	if err := p.conn.SetWriteDeadline(time.Now().Add(DEFAULT_TIMEOUT)); err != nil {
+		p.t.Fatalf("wstest: set write deadline: %v", err)
+	}
+	if err := p.conn.WriteJSON(v); err != nil {
+		p.t.Fatalf("wstest: send: %v", err)
+	}
+}
+
+// Receive decodes the next text message from the server into v, failing the
+// test if none arrives within DEFAULT_TIMEOUT.
+func (p *Peer) Receive(v any) {
+	p.t.Helper()
+	p.ReceiveWithin(DEFAULT_TIMEOUT, v)
+}
+
+// ReceiveWithin is Receive with its own timeout.
+func (p *Peer) ReceiveWithin(timeout time.Duration, v any) {
+	p.t.Helper()
+	var data []byte
+	select {
+	case data = <-p.messages:
+	case <-p.closed:
+		// Messages read before the close are still buffered
+		select {
+		case data = <-p.messages:
+		default:
+			p.t.Fatalf("wstest: connection closed while receiving: %v", p.closeErr)
+		}
+	case <-time.After(timeout):
+		p.t.Fatalf("wstest: no message within %s", timeout)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		p.t.Fatalf("wstest: decode %s: %v", data, err)
+	}
+}
+
+// ExpectNoMessage fails the test if the server sends a text message within
+// timeout.
+func (p *Peer) ExpectNoMessage(timeout time.Duration) {
+	p.t.Helper()
+	select {
+	case data := <-p.messages:
+		p.t.Fatalf("wstest: unexpected message %s", data)
+	case <-time.After(timeout):
+	}
+}
+
+// WaitPing waits for the server's next ping, failing the test if none
+// arrives within timeout.
+func (p *Peer) WaitPing(timeout time.Duration) {
+	p.t.Helper()
+	select {
+	case <-p.pings:
+	case <-time.After(timeout):
+		p.t.Fatalf("wstest: no ping within %s", timeout)
+	}
+}
+
+// WaitClosed waits for the server to close the connection and returns the
+// error that ended reading, a *websocket.CloseError when the server sent a
+// close frame.
+func (p *Peer) WaitClosed(timeout time.Duration) error {
+	p.t.Helper()
+	select {
+	case <-p.closed:
+		return p.closeErr
+	case <-time.After(timeout):
+		p.t.Fatalf("wstest: connection still open after %s", timeout)
+		return nil
+	}
+}
+
+// Closed reports whether the server has closed the connection.
+func (p *Peer) Closed() bool {
+	select {
+	case <-p.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// SendClose sends a close frame with code and text, as a client closing
+// cleanly does. The server answers with its own.
+func (p *Peer) SendClose(code int, text string) {
+	p.t.Helper()
+	err := p.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(DEFAULT_TIMEOUT))
+	if err != nil {
+		p.t.Fatalf("wstest: send close: %v", err)
+	}
+}
+
+// Close closes the peer's end, as a client going away would.
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
@@ -0,0 +1,77 @@
+package wstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipe_Messages(t *testing.T) {
+	server, peer := Pipe(t)
+
+	require.NoError(t, server.WriteMessage(websocket.TextMessage, []byte(`{"type":"hello"}`)))
+	var received map[string]any
+	peer.Receive(&received)
+	assert.Equal(t, "hello", received["type"])
+
+	// A pipe has no buffer, so the server reads as the peer sends
+	sent := make(chan map[string]any, 1)
+	go func() {
+		var message map[string]any
+		_ = server.ReadJSON(&message)
+		sent <- message
+	}()
+	peer.Send(map[string]any{"type": "reply"})
+	assert.Equal(t, "reply", (<-sent)["type"])
+
+	peer.ExpectNoMessage(10 * time.Millisecond)
+}
+
+func TestPipe_Pings(t *testing.T) {
+	server, peer := Pipe(t)
+	pongs := make(chan string, 1)
+	server.SetPongHandler(func(appData string) error {
+		pongs <- appData
+		return nil
+	})
+	go func() {
+		for {
+			if _, _, err := server.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	require.NoError(t, server.WriteMessage(websocket.PingMessage, []byte("1")))
+	peer.WaitPing(time.Second)
+	select {
+	case appData := <-pongs:
+		assert.Equal(t, "1", appData)
+	case <-time.After(time.Second):
+		t.Fatal("no pong")
+	}
+
+	peer.AnswerPings(false)
+	require.NoError(t, server.WriteMessage(websocket.PingMessage, []byte("2")))
+	peer.WaitPing(time.Second)
+	select {
+	case <-pongs:
+		t.Fatal("pong sent while not answering pings")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPipe_Close(t *testing.T) {
+	server, peer := Pipe(t)
+	// Reads the peer's answering close frame
+	go func() { _, _, _ = server.ReadMessage() }()
+
+	closing := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "maintenance")
+	require.NoError(t, server.WriteMessage(websocket.CloseMessage, closing))
+	err := peer.WaitClosed(time.Second)
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater))
+	assert.True(t, peer.Closed())
+}